@@ -16,6 +16,8 @@ interface DeployOptions {
   environment?: string
   environmentId?: string
   branch?: string
+  tag?: string
+  commit?: string
   wait?: boolean
   yes?: boolean
 }
@@ -111,9 +113,10 @@ export async function deploy(options: DeployOptions): Promise<void> {
     }
   }
 
-  // Get branch - use flag value, or prompt if interactive mode
+  // Get branch - use flag value, or prompt if interactive mode.
+  // A tag or commit pins the exact ref, so no branch is needed.
   let branch = options.branch
-  if (!branch) {
+  if (!branch && !options.tag && !options.commit) {
     if (options.yes) {
       branch = 'main' // Default for automation
     } else {
@@ -128,8 +131,10 @@ export async function deploy(options: DeployOptions): Promise<void> {
   newline()
   box(
     `Project: ${colors.bold(projectName)}\n` +
-      `Environment: ${colors.bold(environmentName)}\n` +
-      `Branch: ${colors.bold(branch)}`,
+      `Environment: ${colors.bold(environmentName)}` +
+      (branch ? `\nBranch: ${colors.bold(branch)}` : '') +
+      (options.tag ? `\nTag: ${colors.bold(options.tag)}` : '') +
+      (options.commit ? `\nCommit: ${colors.bold(options.commit)}` : ''),
     `${icons.rocket} Deployment Preview`
   )
   newline()
@@ -155,6 +160,8 @@ export async function deploy(options: DeployOptions): Promise<void> {
       path: { id: projectData.id },
       body: {
         branch,
+        tag: options.tag,
+        commit: options.commit,
         environment_id: environmentId,
       },
     })
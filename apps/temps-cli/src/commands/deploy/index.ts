@@ -15,6 +15,8 @@ export function registerDeployCommands(program: Command): void {
     .option('-e, --environment <env>', 'Target environment name')
     .option('--environment-id <id>', 'Target environment ID')
     .option('-b, --branch <branch>', 'Git branch to deploy')
+    .option('-t, --tag <tag>', 'Git tag to deploy (e.g. v1.2.0)')
+    .option('-c, --commit <sha>', 'Exact commit SHA to deploy')
     .option('--no-wait', 'Do not wait for deployment to complete')
     .option('-y, --yes', 'Skip confirmation prompts (for automation)')
     .action(deploy)
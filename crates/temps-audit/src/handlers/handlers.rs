@@ -9,15 +9,23 @@ use axum::{
 use std::sync::Arc;
 use temps_auth::permission_guard;
 use temps_auth::RequireAuth;
+use temps_core::pagination::{CursorPage, FilterClause, FilterOp, ListError, ListParams};
 use tracing::error;
 use utoipa::OpenApi;
 
 use super::types::{AuditLogIpInfo, AuditLogResponse, AuditLogUserInfo, ListAuditLogsQuery};
+use crate::services::AUDIT_LOG_LIST;
 
 #[derive(OpenApi)]
 #[openapi(
     paths(list_audit_logs, get_audit_log),
-    components(schemas(AuditLogResponse, ListAuditLogsQuery, AuditLogUserInfo, AuditLogIpInfo)),
+    components(schemas(
+        AuditLogResponse,
+        ListAuditLogsQuery,
+        AuditLogUserInfo,
+        AuditLogIpInfo,
+        CursorPage<AuditLogResponse>
+    )),
     info(
         title = "Audit API",
         description = "API endpoints for managing and retrieving audit logs. \
@@ -34,6 +42,10 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
 }
 
 /// List audit logs with optional filtering
+///
+/// Passing `cursor` (empty for the first page) pages by cursor and answers with a
+/// `CursorPage<AuditLogResponse>`; `offset` is ignored then. Logs sort by
+/// `audit_date` or `id` and filter by `operation_type`, `user_id` and `audit_date`.
 #[utoipa::path(
     tag = "Audit Logs",
     get,
@@ -44,10 +56,14 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
         ("from", Query, description = "Start timestamp (milliseconds since epoch)"),
         ("to", Query, description = "End timestamp (milliseconds since epoch)"),
         ("limit", Query, description = "Maximum number of logs to return"),
-        ("offset", Query, description = "Number of logs to skip")
+        ("offset", Query, description = "Number of logs to skip"),
+        ("cursor", Query, description = "Start of the page, from next_cursor of the page before; empty for the first page"),
+        ("sort", Query, description = "Field to sort by; prefix with - for descending"),
+        ("filter", Query, description = "Comma-separated field:op:value clauses that must all match")
     ),
     responses(
         (status = 200, description = "List of audit logs", body = Vec<AuditLogResponse>),
+        (status = 400, description = "Invalid cursor, sort or filter"),
         (status = 401, description = "Unauthorized"),
         (status = 500, description = "Internal server error")
     ),
@@ -57,8 +73,14 @@ async fn list_audit_logs(
     State(app_state): State<Arc<AppState>>,
     RequireAuth(auth): RequireAuth,
     Query(query): Query<ListAuditLogsQuery>,
+    Query(list): Query<ListParams>,
 ) -> Result<impl IntoResponse, temps_core::problemdetails::Problem> {
     permission_guard!(auth, AuditRead);
+    if list.uses_cursor() {
+        return list_audit_logs_page(&app_state, query, list)
+            .await
+            .map(IntoResponse::into_response);
+    }
     let from_date = query.from.map(Into::into);
     let to_date = query.to.map(Into::into);
 
@@ -76,7 +98,7 @@ async fn list_audit_logs(
     {
         Ok(logs) => {
             let responses: Vec<AuditLogResponse> = logs.into_iter().map(Into::into).collect();
-            Ok(Json(responses))
+            Ok(Json(responses).into_response())
         }
         Err(e) => {
             error!("Failed to list audit logs: {}", e);
@@ -91,6 +113,59 @@ async fn list_audit_logs(
     }
 }
 
+/// Audit logs paged by cursor; the older filter parameters apply as filter clauses
+async fn list_audit_logs_page(
+    app_state: &AppState,
+    query: ListAuditLogsQuery,
+    list: ListParams,
+) -> Result<Json<CursorPage<AuditLogResponse>>, temps_core::problemdetails::Problem> {
+    let mut request = list.resolve(&AUDIT_LOG_LIST)?;
+    let clause = |field: &str, op, value: String| FilterClause {
+        field: field.to_string(),
+        op,
+        values: vec![value],
+    };
+    if let Some(operation_type) = query.operation_type {
+        request
+            .filters
+            .push(clause("operation_type", FilterOp::Contains, operation_type));
+    }
+    if let Some(user_id) = query.user_id {
+        request
+            .filters
+            .push(clause("user_id", FilterOp::Eq, user_id.to_string()));
+    }
+    if let Some(from) = query.from {
+        let from: temps_core::UtcDateTime = from.into();
+        request
+            .filters
+            .push(clause("audit_date", FilterOp::Gte, from.to_rfc3339()));
+    }
+    if let Some(to) = query.to {
+        let to: temps_core::UtcDateTime = to.into();
+        request
+            .filters
+            .push(clause("audit_date", FilterOp::Lte, to.to_rfc3339()));
+    }
+
+    match app_state.audit_service.list_audit_logs(&request).await {
+        Ok(page) => Ok(Json(page.map(AuditLogResponse::from))),
+        Err(e) => match e.downcast::<ListError>() {
+            Ok(e) => Err(e.into()),
+            Err(e) => {
+                error!("Failed to list audit logs: {}", e);
+                Err(
+                    temps_core::error_builder::ErrorBuilder::new(StatusCode::INTERNAL_SERVER_ERROR)
+                        .type_("https://temps.sh/probs/audit-error")
+                        .title("Audit Log Error")
+                        .detail(format!("Failed to list audit logs: {}", e))
+                        .build(),
+                )
+            }
+        },
+    }
+}
+
 /// Get a specific audit log entry by ID
 #[utoipa::path(
     tag = "Audit Logs",
@@ -3,7 +3,9 @@ use chrono::Utc;
 use sea_orm::{prelude::*, ColumnTrait, EntityTrait, QueryFilter, QueryOrder, QuerySelect, Set};
 use serde::Serialize;
 use std::sync::Arc;
+use temps_core::pagination::{CursorPage, ListRequest, ListSpec, Sort};
 use temps_core::{AuditLogger, AuditOperation, UtcDateTime};
+use temps_database::queries::{list_select, page_from_models, ListField, ListFieldKind};
 use temps_database::DbConnection;
 use temps_entities::{audit_logs, ip_geolocations, users};
 use temps_geo::IpAddressService;
@@ -17,6 +19,36 @@ pub struct AuditLogWithDetails {
     pub ip_address: Option<ip_geolocations::Model>,
 }
 
+fn latest_first() -> Sort {
+    Sort::desc("audit_date")
+}
+
+/// Sorting and filtering of the audit log list
+pub const AUDIT_LOG_LIST: ListSpec = ListSpec {
+    sort_fields: &["audit_date", "id"],
+    filter_fields: &["operation_type", "user_id", "audit_date"],
+    default_sort: latest_first,
+};
+
+const AUDIT_LOG_FIELDS: [ListField<audit_logs::Column>; 4] = [
+    ListField::new(
+        "audit_date",
+        audit_logs::Column::AuditDate,
+        ListFieldKind::Timestamp,
+    ),
+    ListField::new("id", audit_logs::Column::Id, ListFieldKind::Integer),
+    ListField::new(
+        "operation_type",
+        audit_logs::Column::OperationType,
+        ListFieldKind::Text,
+    ),
+    ListField::new(
+        "user_id",
+        audit_logs::Column::UserId,
+        ListFieldKind::Integer,
+    ),
+];
+
 pub struct AuditService {
     db: Arc<DbConnection>,
     ip_service: Arc<IpAddressService>,
@@ -130,7 +162,40 @@ impl AuditService {
             .await
             .context("Failed to load filtered audit logs")?;
 
-        // Fetch related user and IP geolocation data for each log
+        self.with_details(logs).await
+    }
+
+    /// A page of audit logs; an invalid request fails with a
+    /// [`temps_core::pagination::ListError`]
+    pub async fn list_audit_logs(
+        &self,
+        request: &ListRequest,
+    ) -> anyhow::Result<CursorPage<AuditLogWithDetails>> {
+        let query = list_select(
+            audit_logs::Entity::find(),
+            request,
+            &AUDIT_LOG_FIELDS,
+            audit_logs::Column::Id,
+        )?;
+        let logs = query
+            .all(self.db.as_ref())
+            .await
+            .context("Failed to list audit logs")?;
+        let page = page_from_models(logs, request, &AUDIT_LOG_FIELDS, audit_logs::Column::Id);
+
+        let details = self.with_details(page.items).await?;
+        Ok(CursorPage {
+            items: details,
+            next_cursor: page.next_cursor,
+            total: page.total,
+        })
+    }
+
+    /// Fetch the user and IP geolocation of each log
+    async fn with_details(
+        &self,
+        logs: Vec<audit_logs::Model>,
+    ) -> anyhow::Result<Vec<AuditLogWithDetails>> {
         let mut audit_details = Vec::new();
         for log in logs {
             // Fetch related user
@@ -12,8 +12,9 @@ use super::permission_guard;
 use super::RequireAuth;
 use crate::apikey_handler_types::{
     ApiKeyListResponse, ApiKeyResponse, CreateApiKeyRequest, CreateApiKeyResponse,
-    UpdateApiKeyRequest,
+    SetApiKeyLogScopeRequest, UpdateApiKeyRequest,
 };
+use crate::log_scope::{LogScope, LogScopeGrant};
 use crate::{
     apikey_service::ApiKeyService,
     apikey_types::{get_available_permissions, AvailablePermissions},
@@ -280,6 +281,55 @@ pub async fn activate_api_key(
     }
 }
 
+/// Limit the logs an API key can read
+///
+/// A key with a log scope only reads the deployment, container and proxy logs of the
+/// projects, or environments, it grants; reads outside of it are rejected and recorded
+/// in the audit log.
+#[utoipa::path(
+    put,
+    path = "/api-keys/{id}/log-scope",
+    params(
+        ("id" = i32, Path, description = "API key ID")
+    ),
+    request_body = SetApiKeyLogScopeRequest,
+    responses(
+        (status = 200, description = "Log scope saved", body = ApiKeyResponse),
+        (status = 400, description = "Invalid log scope"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Forbidden"),
+        (status = 404, description = "Not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "API Keys",
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+pub async fn set_api_key_log_scope(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<ApiKeyState>>,
+    Path(api_key_id): Path<i32>,
+    Json(request): Json<SetApiKeyLogScopeRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ApiKeysWrite);
+    // Otherwise a scoped key could lift its own scope
+    if auth.log_scope.is_some() {
+        return Err(temps_core::error_builder::forbidden()
+            .detail("Callers with a log scope can't change log scopes")
+            .build());
+    }
+
+    match state
+        .api_key_service
+        .set_log_scope(auth.user_id(), api_key_id, request.log_scope)
+        .await
+    {
+        Ok(api_key) => Ok(Json(api_key)),
+        Err(e) => Err(e.to_problem()),
+    }
+}
+
 #[utoipa::path(
     get,
     path = "/api-keys/permissions",
@@ -308,12 +358,16 @@ pub async fn get_api_key_permissions(RequireAuth(_auth): RequireAuth) -> impl In
         delete_api_key,
         activate_api_key,
         deactivate_api_key,
+        set_api_key_log_scope,
         get_api_key_permissions,
     ),
     components(
         schemas(
             CreateApiKeyRequest,
             UpdateApiKeyRequest,
+            SetApiKeyLogScopeRequest,
+            LogScope,
+            LogScopeGrant,
             ApiKeyResponse,
             CreateApiKeyResponse,
             ApiKeyListResponse,
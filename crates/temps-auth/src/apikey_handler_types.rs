@@ -1,3 +1,4 @@
+use crate::log_scope::LogScope;
 use serde::{Deserialize, Serialize};
 use temps_core::UtcDateTime;
 use temps_entities::api_keys::Model;
@@ -11,6 +12,8 @@ pub struct ApiKeyResponse {
     pub key_prefix: String,
     pub role_type: String,
     pub permissions: Option<Vec<String>>, // Reserved for future use
+    /// Projects and environments whose logs the key can read; all of them when not set
+    pub log_scope: Option<LogScope>,
     pub is_active: bool,
     #[schema(value_type = Option<String>, format = "date-time", example = "2024-12-31T23:59:59Z")]
     pub expires_at: Option<UtcDateTime>,
@@ -30,6 +33,9 @@ impl From<Model> for ApiKeyResponse {
             permissions: model
                 .permissions
                 .and_then(|p| serde_json::from_str(&p).ok()),
+            log_scope: model
+                .log_scope
+                .and_then(|scope| serde_json::from_str(&scope).ok()),
             is_active: model.is_active,
             expires_at: model.expires_at,
             last_used_at: model.last_used_at,
@@ -80,6 +86,13 @@ pub struct UpdateApiKeyRequest {
     pub expires_at: Option<UtcDateTime>,
 }
 
+/// Request to limit the logs an API key can read
+#[derive(Debug, Serialize, Deserialize, ToSchema)]
+pub struct SetApiKeyLogScopeRequest {
+    /// Projects and environments whose logs the key can read; null to lift the limit
+    pub log_scope: Option<LogScope>,
+}
+
 impl From<UpdateApiKeyRequest> for crate::apikey_service::UpdateApiKeyRequest {
     fn from(request: UpdateApiKeyRequest) -> Self {
         Self {
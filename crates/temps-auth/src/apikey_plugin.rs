@@ -10,7 +10,7 @@ use std::future::Future;
 use std::pin::Pin;
 use std::sync::Arc;
 
-use axum::routing::{delete, get, patch, post};
+use axum::routing::{delete, get, patch, post, put};
 use axum::Router;
 use temps_core::plugin::{
     PluginContext, PluginError, PluginRoutes, ServiceRegistrationContext, TempsPlugin,
@@ -86,6 +86,10 @@ impl TempsPlugin for ApiKeyPlugin {
                 "/api-keys/{id}/deactivate",
                 post(apikey_handler::deactivate_api_key),
             )
+            .route(
+                "/api-keys/{id}/log-scope",
+                put(apikey_handler::set_api_key_log_scope),
+            )
             .route(
                 "/api-keys/permissions",
                 get(apikey_handler::get_api_key_permissions),
@@ -1,3 +1,4 @@
+use crate::log_scope::LogScope;
 use crate::permissions::{Permission, Role};
 use chrono::Utc;
 use rand::Rng;
@@ -27,6 +28,8 @@ pub struct ApiKeyResponse {
     pub key_prefix: String,
     pub role_type: String,
     pub permissions: Option<Vec<String>>, // Reserved for future use
+    /// Projects and environments whose logs the key can read; all of them when not set
+    pub log_scope: Option<LogScope>,
     pub is_active: bool,
     #[schema(value_type = Option<String>, format = "date-time", example = "2024-12-31T23:59:59Z")]
     pub expires_at: Option<UtcDateTime>,
@@ -46,6 +49,9 @@ impl From<temps_entities::api_keys::Model> for ApiKeyResponse {
             permissions: model
                 .permissions
                 .and_then(|p| serde_json::from_str(&p).ok()),
+            log_scope: model
+                .log_scope
+                .and_then(|scope| serde_json::from_str(&scope).ok()),
             is_active: model.is_active,
             expires_at: model.expires_at,
             last_used_at: model.last_used_at,
@@ -378,6 +384,7 @@ impl ApiKeyService {
             Option<Vec<Permission>>,
             String,
             i32,
+            Option<LogScope>,
         ),
         ApiKeyServiceError,
     > {
@@ -439,6 +446,13 @@ impl ApiKeyService {
             (Some(role), None)
         };
 
+        let log_scope = match &api_key_model.log_scope {
+            Some(scope_json) => Some(serde_json::from_str(scope_json).map_err(|_| {
+                ApiKeyServiceError::InternalServerError("Invalid log scope in database".to_string())
+            })?),
+            None => None,
+        };
+
         // Update last_used_at
         let mut api_key_active: ApiKeyActiveModel = api_key_model.clone().into();
         api_key_active.last_used_at = Set(Some(Utc::now()));
@@ -450,9 +464,41 @@ impl ApiKeyService {
             permissions,
             api_key_model.name,
             api_key_model.id,
+            log_scope,
         ))
     }
 
+    /// Limit the logs an API key can read, or lift the limit with None
+    pub async fn set_log_scope(
+        &self,
+        user_id: i32,
+        api_key_id: i32,
+        log_scope: Option<LogScope>,
+    ) -> Result<ApiKeyResponse, ApiKeyServiceError> {
+        if let Some(scope) = &log_scope {
+            scope
+                .validate()
+                .map_err(ApiKeyServiceError::ValidationError)?;
+        }
+
+        let api_key = ApiKeyEntity::find_by_id(api_key_id)
+            .filter(temps_entities::api_keys::Column::UserId.eq(user_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| ApiKeyServiceError::NotFound("API key not found".to_string()))?;
+
+        let mut api_key_active: ApiKeyActiveModel = api_key.into();
+        api_key_active.log_scope = Set(log_scope
+            .map(|scope| serde_json::to_string(&scope))
+            .transpose()
+            .map_err(|e| ApiKeyServiceError::InternalServerError(e.to_string()))?);
+        api_key_active.updated_at = Set(Utc::now());
+
+        let updated_api_key = api_key_active.update(self.db.as_ref()).await?;
+
+        Ok(ApiKeyResponse::from(updated_api_key))
+    }
+
     pub async fn deactivate_api_key(
         &self,
         user_id: i32,
@@ -959,7 +1005,7 @@ mod tests {
             .await
             .unwrap();
 
-        let (validated_user, role, permissions, key_name, key_id) = api_key_service
+        let (validated_user, role, permissions, key_name, key_id, log_scope) = api_key_service
             .validate_api_key(&create_response.api_key)
             .await
             .unwrap();
@@ -970,6 +1016,54 @@ mod tests {
         assert!(permissions.is_none());
         assert_eq!(key_name, "Valid Key");
         assert_eq!(key_id, create_response.id);
+        assert!(log_scope.is_none());
+    }
+
+    #[tokio::test]
+    async fn test_validate_api_key_with_log_scope() {
+        let (_db, api_key_service, user) = setup_test_env().await;
+
+        let request = CreateApiKeyRequest {
+            name: "Contractor Key".to_string(),
+            role_type: "reader".to_string(),
+            permissions: None,
+            expires_at: None,
+        };
+        let create_response = api_key_service
+            .create_api_key(user.id, request)
+            .await
+            .unwrap();
+
+        let scope = LogScope {
+            grants: vec![crate::log_scope::LogScopeGrant {
+                project_id: 7,
+                environment_id: Some(3),
+            }],
+        };
+        let response = api_key_service
+            .set_log_scope(user.id, create_response.id, Some(scope.clone()))
+            .await
+            .unwrap();
+        assert_eq!(response.log_scope, Some(scope.clone()));
+
+        let (_, _, _, _, _, log_scope) = api_key_service
+            .validate_api_key(&create_response.api_key)
+            .await
+            .unwrap();
+        assert_eq!(log_scope, Some(scope));
+
+        // An empty scope would lock the key out of every log; lifting it takes None
+        let result = api_key_service
+            .set_log_scope(
+                user.id,
+                create_response.id,
+                Some(LogScope { grants: vec![] }),
+            )
+            .await;
+        assert!(matches!(
+            result,
+            Err(ApiKeyServiceError::ValidationError(_))
+        ));
     }
 
     #[tokio::test]
@@ -988,7 +1082,7 @@ mod tests {
             .await
             .unwrap();
 
-        let (validated_user, role, permissions, _, _) = api_key_service
+        let (validated_user, role, permissions, _, _, _) = api_key_service
             .validate_api_key(&create_response.api_key)
             .await
             .unwrap();
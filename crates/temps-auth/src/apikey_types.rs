@@ -56,6 +56,12 @@ impl PermissionInfo {
             Permission::EnvironmentsWrite => "Modify environment configurations",
             Permission::EnvironmentsDelete => "Delete environments",
             Permission::EnvironmentsCreate => "Create new environments",
+            Permission::ProtectedSecretsRead => {
+                "View environment variables of protected environments such as production"
+            }
+            Permission::ProtectedSecretsWrite => {
+                "Modify environment variables of protected environments such as production"
+            }
             Permission::AnalyticsRead => "View analytics and metrics",
             Permission::AnalyticsWrite => "Modify analytics settings",
             Permission::UsersRead => "View user information",
@@ -1,3 +1,4 @@
+use crate::log_scope::LogScope;
 use anyhow::Result;
 use serde::Serialize;
 use temps_core::{AuditContext, AuditOperation};
@@ -51,6 +52,15 @@ pub struct UserRestoredAudit {
     pub name: String,
 }
 
+#[derive(Debug, Clone, Serialize)]
+pub struct UserLogScopeChangedAudit {
+    pub context: AuditContext,
+    pub target_user_id: i32,
+    pub username: String,
+    /// None when the limit was lifted
+    pub log_scope: Option<LogScope>,
+}
+
 // Role management audits
 #[derive(Debug, Clone, Serialize)]
 pub struct RoleAssignedAudit {
@@ -223,6 +233,29 @@ impl AuditOperation for UserUpdatedAudit {
     }
 }
 
+impl AuditOperation for UserLogScopeChangedAudit {
+    fn operation_type(&self) -> String {
+        "USER_LOG_SCOPE_CHANGED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
 impl AuditOperation for UserDeletedAudit {
     fn operation_type(&self) -> String {
         "USER_DELETED".to_string()
@@ -1,3 +1,4 @@
+use super::log_scope::LogScope;
 use super::permissions::{Permission, Role};
 use serde::{Deserialize, Serialize};
 use temps_entities::deployment_tokens::DeploymentTokenPermission;
@@ -72,6 +73,9 @@ pub struct AuthContext {
     pub custom_permissions: Option<Vec<Permission>>, // Some for custom permissions
     /// Deployment token permissions (separate from user permissions)
     pub deployment_token_permissions: Option<Vec<DeploymentTokenPermission>>,
+    /// Projects and environments whose logs can be read (None for all of them)
+    #[serde(default)]
+    pub log_scope: Option<LogScope>,
 }
 
 // Schema version for OpenAPI documentation
@@ -82,11 +86,13 @@ pub struct AuthContextSchema {
     pub effective_role: Role,
     pub custom_permissions: Option<Vec<Permission>>,
     pub deployment_token_permissions: Option<Vec<String>>,
+    pub log_scope: Option<LogScope>,
 }
 
 impl AuthContext {
     pub fn new_session(user: users::Model, role: Role) -> Self {
         Self {
+            log_scope: LogScope::of_user(&user),
             user: Some(user.clone()),
             source: AuthSource::Session { user },
             effective_role: role,
@@ -97,6 +103,7 @@ impl AuthContext {
 
     pub fn new_cli_token(user: users::Model, role: Role) -> Self {
         Self {
+            log_scope: LogScope::of_user(&user),
             user: Some(user.clone()),
             source: AuthSource::CliToken { user },
             effective_role: role,
@@ -113,6 +120,7 @@ impl AuthContext {
         key_id: i32,
     ) -> Self {
         Self {
+            log_scope: LogScope::of_user(&user),
             user: Some(user.clone()),
             source: AuthSource::ApiKey {
                 user,
@@ -148,9 +156,24 @@ impl AuthContext {
             effective_role: Role::Custom, // Use Custom role for deployment tokens
             custom_permissions: None,
             deployment_token_permissions: Some(permissions),
+            log_scope: None,
         }
     }
 
+    /// Limit the logs this context can read to a scope, within the one it already has
+    pub fn with_log_scope(mut self, log_scope: Option<LogScope>) -> Self {
+        self.log_scope = LogScope::narrow(self.log_scope.take(), log_scope);
+        self
+    }
+
+    /// Whether the logs of an environment of the project, or of all of its
+    /// environments when `environment_id` is None, are within the log scope
+    pub fn can_read_logs(&self, project_id: i32, environment_id: Option<i32>) -> bool {
+        self.log_scope
+            .as_ref()
+            .is_none_or(|scope| scope.allows(project_id, environment_id))
+    }
+
     pub fn has_permission(&self, permission: &Permission) -> bool {
         // For deployment tokens, check if the deployment token permission matches
         if self.is_deployment_token() {
@@ -2,13 +2,14 @@ use super::AuthState;
 use crate::audit::{
     EmailVerifiedAudit, LoginAudit, LogoutAudit, MfaDisabledAudit, MfaEnabledAudit,
     MfaVerifiedAudit, PasswordResetAudit, RoleAssignedAudit, RoleRemovedAudit, UpdatedFields,
-    UserCreatedAudit, UserDeletedAudit, UserRestoredAudit, UserUpdatedAudit,
+    UserCreatedAudit, UserDeletedAudit, UserLogScopeChangedAudit, UserRestoredAudit,
+    UserUpdatedAudit,
 };
 use crate::user_service::UserServiceError;
 use crate::{permission_guard, RequireAuth};
 use axum::extract::Path;
 use axum::http::header::SET_COOKIE;
-use axum::routing::{delete, get, patch, post};
+use axum::routing::{delete, get, patch, post, put};
 use axum::Extension;
 use axum::{
     extract::{Query, State},
@@ -32,8 +33,8 @@ use utoipa::{OpenApi, ToSchema};
 use crate::types::{
     AssignRoleRequest, AuthStatusResponse, AuthTokenResponse, CliLoginRequest, CreateUserRequest,
     DisableMfaRequest, InitAuthResponse, MfaRequiredResponse, MfaSetupResponse,
-    MfaVerificationRequest, RouteRole, RouteUser, RouteUserWithRoles, TokenRenewalRequest,
-    UpdateSelfRequest, UpdateUserRequest, UserResponse, VerifyMfaRequest,
+    MfaVerificationRequest, RouteRole, RouteUser, RouteUserWithRoles, SetUserLogScopeRequest,
+    TokenRenewalRequest, UpdateSelfRequest, UpdateUserRequest, UserResponse, VerifyMfaRequest,
 };
 use temps_core::problemdetails::{new as problem_new, Problem};
 
@@ -268,6 +269,7 @@ pub async fn verify_mfa_challenge(
         remove_role,
         update_user,
         restore_user,
+        set_user_log_scope,
         update_self,
         setup_mfa,
         verify_and_enable_mfa,
@@ -295,6 +297,7 @@ pub async fn verify_mfa_challenge(
             AssignRoleRequest,
             CreateUserRequest,
             UpdateUserRequest,
+            SetUserLogScopeRequest,
             UpdateSelfRequest,
             VerifyMfaRequest,
             MfaSetupResponse,
@@ -336,6 +339,7 @@ pub fn configure_routes() -> Router<Arc<AuthState>> {
         .route("/users/{user_id}", delete(delete_user))
         .route("/users/{user_id}", patch(update_user))
         .route("/users/{user_id}/restore", post(restore_user))
+        .route("/users/{user_id}/log-scope", put(set_user_log_scope))
         .route("/users/{user_id}/roles", post(assign_role))
         .route("/users/{user_id}/roles/{role_type}", delete(remove_role))
 }
@@ -990,13 +994,14 @@ impl From<UserServiceError> for Problem {
         remove_role,
         update_user,
         restore_user,
+        set_user_log_scope,
         update_self,
         setup_mfa,
         verify_and_enable_mfa,
         disable_mfa
     ),
     components(
-        schemas(RouteUser, RouteRole, RouteUserWithRoles, AssignRoleRequest, CreateUserRequest, UpdateUserRequest, UpdateSelfRequest, VerifyMfaRequest, MfaSetupResponse, DisableMfaRequest)
+        schemas(RouteUser, RouteRole, RouteUserWithRoles, AssignRoleRequest, CreateUserRequest, UpdateUserRequest, SetUserLogScopeRequest, UpdateSelfRequest, VerifyMfaRequest, MfaSetupResponse, DisableMfaRequest)
     ),
     tags(
         (name = "Users", description = "User management API")
@@ -1569,6 +1574,80 @@ async fn restore_user(
     Ok(Json(RouteUserWithRoles::from(restored_user)).into_response())
 }
 
+/// Limit the logs a user can read (admin only)
+///
+/// A user with a log scope, and every API key of theirs, only reads the deployment,
+/// container and proxy logs of the projects, or environments, it grants; reads outside
+/// of it are rejected and recorded in the audit log.
+#[utoipa::path(
+    tag = "Users",
+    put,
+    path = "/users/{user_id}/log-scope",
+    request_body = SetUserLogScopeRequest,
+    responses(
+        (status = 200, description = "Log scope saved", body = RouteUserWithRoles),
+        (status = 404, description = "User not found"),
+        (status = 403, description = "Forbidden - Non-admin attempt"),
+        (status = 401, description = "Unauthorized"),
+        (status = 400, description = "Invalid log scope"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("user_id" = i32, Path, description = "User ID")
+    ),
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn set_user_log_scope(
+    State(app_state): State<Arc<AuthState>>,
+    RequireAuth(auth): RequireAuth,
+    Extension(metadata): Extension<RequestMetadata>,
+    Path(user_id): Path<i32>,
+    Json(request): Json<SetUserLogScopeRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, UsersWrite);
+    // Otherwise a scoped user could lift their own scope
+    if auth.log_scope.is_some() {
+        return Err(temps_core::error_builder::forbidden()
+            .detail("Callers with a log scope can't change log scopes")
+            .build());
+    }
+
+    info!(
+        "Admin {} setting the log scope of user {}",
+        auth.user_id(),
+        user_id
+    );
+    let updated_user = app_state
+        .user_service
+        .set_log_scope(user_id, request.log_scope.clone())
+        .await?;
+
+    let audit_context = AuditContext {
+        user_id: auth.user_id(),
+        ip_address: Some(metadata.ip_address.to_string()),
+        user_agent: metadata.user_agent.as_str().to_string(),
+    };
+
+    let log_scope_audit = UserLogScopeChangedAudit {
+        context: audit_context,
+        target_user_id: user_id,
+        username: updated_user.user.name.clone(),
+        log_scope: request.log_scope,
+    };
+
+    if let Err(e) = app_state
+        .audit_service
+        .create_audit_log(&log_scope_audit)
+        .await
+    {
+        error!("Failed to create audit log: {}", e);
+    }
+
+    Ok(Json(RouteUserWithRoles::from(updated_user)).into_response())
+}
+
 #[utoipa::path(
     tag = "Users",
     post,
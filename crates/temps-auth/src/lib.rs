@@ -10,6 +10,7 @@ mod decorators;
 mod deployment_token_service;
 mod email_templates;
 pub mod handlers;
+pub mod log_scope;
 mod macros;
 mod middleware;
 mod permission_attribute;
@@ -28,6 +29,7 @@ pub use middleware::*;
 pub use permission_attribute::*;
 
 pub use context::*;
+pub use log_scope::*;
 pub use permissions::*;
 pub use state::*;
 
@@ -0,0 +1,271 @@
+//! Log scopes
+//!
+//! `logs:read` and `deployments:read` let a caller read the logs of every project. A
+//! user, or one of their API keys, can be limited to the logs of some projects, or of
+//! some of their environments, such as a contractor working on one service: its log
+//! scope. An API key of a user with a scope never reads more than the user. Log
+//! handlers check the project and environment a query reads against the caller's
+//! scope, reject the ones outside of it and record the attempt in the audit log.
+
+use serde::{Deserialize, Serialize};
+use temps_core::error_builder::forbidden;
+use temps_core::problemdetails::Problem;
+use temps_core::{AuditContext, AuditLogger, AuditOperation, RequestMetadata};
+use temps_entities::users;
+use tracing::{error, warn};
+use utoipa::ToSchema;
+
+use crate::context::AuthContext;
+
+/// Most grants a log scope can have
+pub const MAX_LOG_SCOPE_GRANTS: usize = 100;
+
+/// Projects and environments whose logs a caller can read
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct LogScope {
+    pub grants: Vec<LogScopeGrant>,
+}
+
+/// Logs of a project, or of one of its environments
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct LogScopeGrant {
+    pub project_id: i32,
+    /// Only the logs of this environment; all of the project's when not set
+    #[serde(default)]
+    pub environment_id: Option<i32>,
+}
+
+impl LogScope {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.grants.is_empty() {
+            return Err("A log scope needs at least one project".to_string());
+        }
+        if self.grants.len() > MAX_LOG_SCOPE_GRANTS {
+            return Err(format!(
+                "A log scope can have at most {} grants",
+                MAX_LOG_SCOPE_GRANTS
+            ));
+        }
+        for (i, grant) in self.grants.iter().enumerate() {
+            if self.grants[..i].contains(grant) {
+                return Err(format!(
+                    "Project {} is granted twice in the log scope",
+                    grant.project_id
+                ));
+            }
+        }
+        Ok(())
+    }
+
+    /// Log scope of a user; a scope that can't be read allows no logs at all
+    pub fn of_user(user: &users::Model) -> Option<LogScope> {
+        let scope = user.log_scope.as_ref()?;
+        Some(serde_json::from_str(scope).unwrap_or_else(|e| {
+            error!("Invalid log scope of user {}: {}", user.id, e);
+            LogScope { grants: Vec::new() }
+        }))
+    }
+
+    /// The logs both scopes cover, where None stands for every log
+    pub fn narrow(outer: Option<LogScope>, inner: Option<LogScope>) -> Option<LogScope> {
+        let (outer, inner) = match (outer, inner) {
+            (Some(outer), Some(inner)) => (outer, inner),
+            (outer, inner) => return outer.or(inner),
+        };
+
+        let mut grants: Vec<LogScopeGrant> = Vec::new();
+        for grant in &inner.grants {
+            for allowed in outer
+                .grants
+                .iter()
+                .filter(|allowed| allowed.project_id == grant.project_id)
+            {
+                let environment_id = match (grant.environment_id, allowed.environment_id) {
+                    (Some(a), Some(b)) if a != b => continue,
+                    (a, b) => a.or(b),
+                };
+                let narrowed = LogScopeGrant {
+                    project_id: grant.project_id,
+                    environment_id,
+                };
+                if !grants.contains(&narrowed) {
+                    grants.push(narrowed);
+                }
+            }
+        }
+        Some(LogScope { grants })
+    }
+
+    /// Whether the scope covers the logs of an environment of the project, or of all
+    /// of the project's environments when `environment_id` is None
+    pub fn allows(&self, project_id: i32, environment_id: Option<i32>) -> bool {
+        self.grants.iter().any(|grant| {
+            grant.project_id == project_id
+                && (grant.environment_id.is_none() || grant.environment_id == environment_id)
+        })
+    }
+}
+
+/// Attempt to read logs outside of the caller's log scope
+#[derive(Debug, Clone, Serialize)]
+pub struct LogScopeDeniedAudit {
+    pub context: AuditContext,
+    pub api_key_id: Option<i32>,
+    /// Logs read, such as `deployment_logs` or `proxy_logs`
+    pub resource: String,
+    pub project_id: Option<i32>,
+    pub environment_id: Option<i32>,
+}
+
+impl AuditOperation for LogScopeDeniedAudit {
+    fn operation_type(&self) -> String {
+        "LOG_SCOPE_DENIED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> anyhow::Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+/// Check that the caller's log scope covers the logs read
+///
+/// A project of None stands for a query across projects, which only callers without
+/// a log scope can make. Reads outside of the scope are recorded in the audit log and
+/// rejected with 403.
+pub async fn require_log_scope(
+    auth: &AuthContext,
+    metadata: Option<&RequestMetadata>,
+    audit_service: &dyn AuditLogger,
+    resource: &str,
+    project_id: Option<i32>,
+    environment_id: Option<i32>,
+) -> Result<(), Problem> {
+    let Some(scope) = &auth.log_scope else {
+        return Ok(());
+    };
+    if let Some(project_id) = project_id {
+        if scope.allows(project_id, environment_id) {
+            return Ok(());
+        }
+    }
+
+    warn!(
+        "Denied reading {} of project {:?} environment {:?} outside of the log scope of user {}",
+        resource,
+        project_id,
+        environment_id,
+        auth.user_id()
+    );
+    let audit_event = LogScopeDeniedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: metadata.map(|m| m.ip_address.clone()),
+            user_agent: metadata.map(|m| m.user_agent.clone()).unwrap_or_default(),
+        },
+        api_key_id: auth.api_key_info().map(|(_, key_id)| key_id),
+        resource: resource.to_string(),
+        project_id,
+        environment_id,
+    };
+    if let Err(e) = audit_service.create_audit_log(&audit_event).await {
+        error!("Failed to create audit log: {:?}", e);
+    }
+
+    let detail = match project_id {
+        Some(_) => "These logs are outside of your log scope".to_string(),
+        None => "Your logs access is scoped to projects; filter the logs by project".to_string(),
+    };
+    Err(forbidden()
+        .type_("https://temps.sh/probs/log-scope")
+        .title("Outside Log Scope")
+        .detail(detail)
+        .value("error_code", "LOG_SCOPE_DENIED")
+        .build())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn scope() -> LogScope {
+        LogScope {
+            grants: vec![
+                LogScopeGrant {
+                    project_id: 1,
+                    environment_id: None,
+                },
+                LogScopeGrant {
+                    project_id: 2,
+                    environment_id: Some(20),
+                },
+            ],
+        }
+    }
+
+    #[test]
+    fn test_allows_granted_projects_and_environments() {
+        let scope = scope();
+        assert!(scope.allows(1, None));
+        assert!(scope.allows(1, Some(10)));
+        assert!(scope.allows(2, Some(20)));
+        // Only one environment of project 2 is granted
+        assert!(!scope.allows(2, Some(21)));
+        assert!(!scope.allows(2, None));
+        assert!(!scope.allows(3, None));
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(scope().validate().is_ok());
+        assert!(LogScope { grants: vec![] }.validate().is_err());
+
+        let mut duplicated = scope();
+        duplicated.grants.push(LogScopeGrant {
+            project_id: 1,
+            environment_id: None,
+        });
+        assert!(duplicated.validate().is_err());
+    }
+
+    #[test]
+    fn test_narrow_keeps_what_both_scopes_cover() {
+        let grant = |project_id, environment_id| LogScopeGrant {
+            project_id,
+            environment_id,
+        };
+        assert_eq!(LogScope::narrow(None, None), None);
+        assert_eq!(LogScope::narrow(Some(scope()), None), Some(scope()));
+        assert_eq!(LogScope::narrow(None, Some(scope())), Some(scope()));
+
+        let key_scope = LogScope {
+            grants: vec![grant(1, Some(10)), grant(2, None), grant(3, None)],
+        };
+        let narrowed = LogScope::narrow(Some(scope()), Some(key_scope)).unwrap();
+        // Project 1 narrowed to the key's environment, project 2 to the user's, and
+        // project 3 isn't granted to the user at all
+        assert_eq!(
+            narrowed.grants,
+            vec![grant(1, Some(10)), grant(2, Some(20))]
+        );
+
+        let disjoint = LogScope {
+            grants: vec![grant(2, Some(21))],
+        };
+        let narrowed = LogScope::narrow(Some(scope()), Some(disjoint)).unwrap();
+        assert!(!narrowed.allows(2, Some(21)));
+        assert!(!narrowed.allows(1, None));
+    }
+}
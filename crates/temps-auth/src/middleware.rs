@@ -137,7 +137,7 @@ pub async fn extract_auth_from_request(
 
                 // Try API key first (they have a specific format: tk_...)
                 if token.starts_with("tk_") {
-                    if let Ok((user, role, permissions, key_name, key_id)) =
+                    if let Ok((user, role, permissions, key_name, key_id, log_scope)) =
                         api_key_service.validate_api_key(token).await
                     {
                         return Ok(AuthContext::new_api_key(
@@ -146,7 +146,8 @@ pub async fn extract_auth_from_request(
                             permissions,
                             key_name,
                             key_id,
-                        ));
+                        )
+                        .with_log_scope(log_scope));
                     }
                 }
 
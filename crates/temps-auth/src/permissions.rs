@@ -28,6 +28,10 @@ pub enum Permission {
     EnvironmentsDelete,
     EnvironmentsCreate,
 
+    // Secrets of protected environments (e.g. production)
+    ProtectedSecretsRead,
+    ProtectedSecretsWrite,
+
     // Analytics permissions
     AnalyticsRead,
     AnalyticsWrite,
@@ -225,6 +229,8 @@ impl fmt::Display for Permission {
             Permission::EnvironmentsWrite => "environments:write",
             Permission::EnvironmentsDelete => "environments:delete",
             Permission::EnvironmentsCreate => "environments:create",
+            Permission::ProtectedSecretsRead => "protected_secrets:read",
+            Permission::ProtectedSecretsWrite => "protected_secrets:write",
             Permission::AnalyticsRead => "analytics:read",
             Permission::AnalyticsWrite => "analytics:write",
             Permission::UsersRead => "users:read",
@@ -359,6 +365,8 @@ impl Permission {
             "environments:write" => Some(Permission::EnvironmentsWrite),
             "environments:delete" => Some(Permission::EnvironmentsDelete),
             "environments:create" => Some(Permission::EnvironmentsCreate),
+            "protected_secrets:read" => Some(Permission::ProtectedSecretsRead),
+            "protected_secrets:write" => Some(Permission::ProtectedSecretsWrite),
             "analytics:read" => Some(Permission::AnalyticsRead),
             "analytics:write" => Some(Permission::AnalyticsWrite),
             "users:read" => Some(Permission::UsersRead),
@@ -490,6 +498,8 @@ impl Permission {
             Permission::EnvironmentsWrite,
             Permission::EnvironmentsDelete,
             Permission::EnvironmentsCreate,
+            Permission::ProtectedSecretsRead,
+            Permission::ProtectedSecretsWrite,
             Permission::AnalyticsRead,
             Permission::AnalyticsWrite,
             Permission::UsersRead,
@@ -726,6 +736,8 @@ impl Role {
                 Permission::ProjectsDelete,
                 Permission::ProjectsRead,
                 Permission::ProjectsWrite,
+                Permission::ProtectedSecretsRead,
+                Permission::ProtectedSecretsWrite,
                 Permission::SessionMetricsRead,
                 Permission::SettingsRead,
                 Permission::SettingsWrite,
@@ -1110,6 +1122,26 @@ mod tests {
         assert!(!reader_permissions.contains(&Permission::EmailsSend));
     }
 
+    #[test]
+    fn test_protected_secrets_are_admin_only() {
+        assert!(Role::Admin.has_permission(&Permission::ProtectedSecretsRead));
+        assert!(Role::Admin.has_permission(&Permission::ProtectedSecretsWrite));
+        for role in [
+            Role::User,
+            Role::Reader,
+            Role::Mcp,
+            Role::ApiReader,
+            Role::Demo,
+        ] {
+            assert!(!role.has_permission(&Permission::ProtectedSecretsRead));
+            assert!(!role.has_permission(&Permission::ProtectedSecretsWrite));
+        }
+        assert_eq!(
+            Permission::from_str("protected_secrets:write"),
+            Some(Permission::ProtectedSecretsWrite)
+        );
+    }
+
     #[test]
     fn test_role_has_permission_method() {
         // Admin has email send permission
@@ -109,17 +109,20 @@ impl AuthMiddleware {
 
                     // Try API key first (they have a specific format: tk_...)
                     if token.starts_with("tk_") {
-                        if let Ok((api_user, role, permissions, key_name, key_id)) =
+                        if let Ok((api_user, role, permissions, key_name, key_id, log_scope)) =
                             self.api_key_service.validate_api_key(token).await
                         {
                             user = Some(api_user.clone());
-                            Some(crate::context::AuthContext::new_api_key(
-                                api_user,
-                                role,
-                                permissions,
-                                key_name,
-                                key_id,
-                            ))
+                            Some(
+                                crate::context::AuthContext::new_api_key(
+                                    api_user,
+                                    role,
+                                    permissions,
+                                    key_name,
+                                    key_id,
+                                )
+                                .with_log_scope(log_scope),
+                            )
                         } else {
                             None
                         }
@@ -323,6 +326,7 @@ impl AuthMiddleware {
             mfa_secret: None,
             mfa_enabled: false,
             mfa_recovery_codes: None,
+            log_scope: None,
             created_at: Utc::now(),
             updated_at: Utc::now(),
         }
@@ -1,3 +1,4 @@
+use crate::log_scope::LogScope;
 use serde::{Deserialize, Serialize};
 use utoipa::ToSchema;
 
@@ -72,6 +73,8 @@ pub struct RouteUser {
     pub image: String,
     pub mfa_enabled: bool,
     pub deleted_at: Option<i64>,
+    /// Projects and environments whose logs the user can read; all of them when not set
+    pub log_scope: Option<LogScope>,
 }
 
 #[derive(Serialize, utoipa::ToSchema)]
@@ -112,6 +115,12 @@ pub struct UpdateUserRequest {
     pub name: Option<String>,
 }
 
+#[derive(Deserialize, utoipa::ToSchema)]
+pub struct SetUserLogScopeRequest {
+    /// Projects and environments whose logs the user can read; null to lift the limit
+    pub log_scope: Option<LogScope>,
+}
+
 // Add a new route for self-modification
 #[derive(Deserialize, utoipa::ToSchema)]
 pub struct UpdateSelfRequest {
@@ -143,6 +152,7 @@ pub struct DisableMfaRequest {
 impl From<temps_entities::users::Model> for RouteUser {
     fn from(db_user: temps_entities::users::Model) -> Self {
         Self {
+            log_scope: LogScope::of_user(&db_user),
             id: db_user.id,
             name: db_user.name.clone(),
             username: db_user.name.clone(),
@@ -178,6 +188,7 @@ impl From<crate::user_service::ServiceUser> for RouteUser {
             image: service_user.image,
             mfa_enabled: service_user.mfa_enabled,
             deleted_at: service_user.deleted_at.map(|d| d.timestamp_millis()),
+            log_scope: service_user.log_scope,
         }
     }
 }
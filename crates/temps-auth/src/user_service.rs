@@ -1,3 +1,4 @@
+use crate::log_scope::LogScope;
 use base64::Engine;
 use chrono::Utc;
 use qrcode::QrCode;
@@ -95,6 +96,7 @@ pub struct ServiceUser {
     pub image: String,
     pub mfa_enabled: bool,
     pub deleted_at: Option<UtcDateTime>,
+    pub log_scope: Option<LogScope>,
     // pub created_at: UtcDateTime,
     // pub updated_at: UtcDateTime,
 }
@@ -116,6 +118,7 @@ pub struct UserWithRoles {
 impl From<temps_entities::users::Model> for ServiceUser {
     fn from(db_user: temps_entities::users::Model) -> Self {
         Self {
+            log_scope: LogScope::of_user(&db_user),
             id: db_user.id,
             name: db_user.name.clone(),
             email: db_user.email,
@@ -551,6 +554,32 @@ impl UserService {
         Ok(user_with_roles)
     }
 
+    /// Limit the logs a user, and every API key of theirs, can read, or lift the limit
+    /// with None
+    pub async fn set_log_scope(
+        &self,
+        user_id: i32,
+        log_scope: Option<LogScope>,
+    ) -> Result<UserWithRoles, UserServiceError> {
+        if let Some(scope) = &log_scope {
+            scope.validate().map_err(UserServiceError::Validation)?;
+        }
+
+        let existing_user = temps_entities::users::Entity::find_by_id(user_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| UserServiceError::NotFound(format!("User {} not found", user_id)))?;
+
+        let mut user_update: temps_entities::users::ActiveModel = existing_user.into();
+        user_update.log_scope = Set(log_scope
+            .map(|scope| serde_json::to_string(&scope))
+            .transpose()?);
+        let updated_user = user_update.update(self.db.as_ref()).await?;
+
+        info!("Updated the log scope of user {}", user_id);
+        self.get_user_with_roles(updated_user.id).await
+    }
+
     pub async fn restore_user(&self, user_id: i32) -> Result<UserWithRoles, UserServiceError> {
         // Check if user exists and is deleted
         let user = temps_entities::users::Entity::find_by_id(user_id)
@@ -18,6 +18,7 @@ fn create_mock_user() -> users::Model {
         mfa_secret: None,
         mfa_enabled: false,
         mfa_recovery_codes: None,
+        log_scope: None,
         created_at: Utc::now(),
         updated_at: Utc::now(),
     }
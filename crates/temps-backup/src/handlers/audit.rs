@@ -60,6 +60,25 @@ pub struct ExternalServiceBackupRunAudit {
     pub backup_type: String,
 }
 
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceBackupDownloadedAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    pub backup_id: i32,
+    pub decompressed: bool,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceBackupRestoredAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    pub service_type: String,
+    pub backup_id: i32,
+    pub operation_id: String,
+}
+
 // Implement AuditOperation for S3 Source audit structs
 impl AuditOperation for S3SourceCreatedAudit {
     fn operation_type(&self) -> String {
@@ -199,3 +218,49 @@ impl AuditOperation for ExternalServiceBackupRunAudit {
             .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
     }
 }
+
+impl AuditOperation for ExternalServiceBackupDownloadedAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_BACKUP_DOWNLOADED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+impl AuditOperation for ExternalServiceBackupRestoredAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_BACKUP_RESTORED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
@@ -1,16 +1,23 @@
 use crate::handlers::audit::{
-    AuditContext, BackupRunAudit, BackupScheduleStatusChangedAudit, ExternalServiceBackupRunAudit,
-    S3SourceCreatedAudit, S3SourceDeletedAudit, S3SourceUpdatedAudit,
+    AuditContext, BackupRunAudit, BackupScheduleStatusChangedAudit,
+    ExternalServiceBackupDownloadedAudit, ExternalServiceBackupRestoredAudit,
+    ExternalServiceBackupRunAudit, S3SourceCreatedAudit, S3SourceDeletedAudit,
+    S3SourceUpdatedAudit,
 };
 use crate::handlers::types::BackupAppState;
-use crate::services::BackupError;
+use crate::services::{
+    BackupError, TransferOperationKind, TransferOperationResponse, UploadMetricsSnapshot,
+};
 use axum::{
-    extract::{Extension, Path, State},
-    http::StatusCode,
-    response::IntoResponse,
+    body::Body,
+    extract::{Extension, Path, Query, State},
+    http::{header, HeaderMap, HeaderValue, StatusCode},
+    response::sse::{Event, KeepAlive, Sse},
+    response::{IntoResponse, Response},
     routing::{get, patch, post},
     Json, Router,
 };
+use futures::stream;
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 use std::sync::Arc;
@@ -19,8 +26,9 @@ use temps_auth::RequireAuth;
 use temps_core::problemdetails;
 use temps_core::problemdetails::{Problem, ProblemDetails};
 use temps_core::RequestMetadata;
+use temps_providers::externalsvc::{TransferPhase, TransferSnapshot, TransferState};
 use tracing::error;
-use utoipa::{OpenApi, ToSchema};
+use utoipa::{IntoParams, OpenApi, ToSchema};
 
 impl From<BackupError> for Problem {
     fn from(error: BackupError) -> Self {
@@ -71,6 +79,10 @@ impl From<BackupError> for Problem {
                 .with_title("Internal Server Error")
                 .with_detail(msg),
 
+            BackupError::Conflict(msg) => problemdetails::new(StatusCode::CONFLICT)
+                .with_title("Operation In Progress")
+                .with_detail(msg),
+
             _ => problemdetails::new(StatusCode::INTERNAL_SERVER_ERROR)
                 .with_title("Internal Server Error")
                 .with_detail("An unexpected error occurred"),
@@ -94,9 +106,16 @@ impl From<BackupError> for Problem {
         run_backup_for_source,
         list_source_backups,
         get_backup,
+        get_upload_metrics,
         disable_backup_schedule,
         enable_backup_schedule,
-        run_external_service_backup
+        run_external_service_backup,
+        list_external_service_backups,
+        download_external_service_backup,
+        restore_external_service_backup,
+        list_external_service_operations,
+        get_backup_operation,
+        stream_backup_operation_progress
     ),
     components(
         schemas(
@@ -111,6 +130,12 @@ impl From<BackupError> for Problem {
             ExternalServiceBackupResponse,
             SourceBackupIndexResponse,
             SourceBackupEntry,
+            UploadMetricsSnapshot,
+            TransferOperationResponse,
+            TransferOperationKind,
+            TransferSnapshot,
+            TransferPhase,
+            TransferState,
         )
     ),
     info(
@@ -191,6 +216,10 @@ pub struct RunExternalServiceBackupRequest {
     /// Type of backup to perform (e.g., "full", "incremental")
     #[schema(example = "full")]
     pub backup_type: Option<String>,
+    /// Start the backup and answer right away with the operation to follow, instead of
+    /// answering once it ends
+    #[serde(default)]
+    pub background: bool,
 }
 
 /// Response type for external service backup
@@ -500,6 +529,7 @@ pub fn configure_routes() -> Router<Arc<BackupAppState>> {
             get(list_backups_for_schedule),
         )
         .route("/backups/s3-sources/{id}/backups", get(list_source_backups))
+        .route("/backups/upload-metrics", get(get_upload_metrics))
         .route("/backups/{id}", get(get_backup))
         .route(
             "/backups/schedules/{id}/disable",
@@ -513,6 +543,30 @@ pub fn configure_routes() -> Router<Arc<BackupAppState>> {
             "/backups/external-services/{id}/run",
             post(run_external_service_backup),
         )
+        .route(
+            "/backups/external-services/{id}/backups",
+            get(list_external_service_backups),
+        )
+        .route(
+            "/backups/external-services/{id}/backups/{backup_id}/download",
+            get(download_external_service_backup),
+        )
+        .route(
+            "/backups/external-services/{id}/backups/{backup_id}/restore",
+            post(restore_external_service_backup),
+        )
+        .route(
+            "/backups/external-services/{id}/operations",
+            get(list_external_service_operations),
+        )
+        .route(
+            "/backups/operations/{operation_id}",
+            get(get_backup_operation),
+        )
+        .route(
+            "/backups/operations/{operation_id}/progress",
+            get(stream_backup_operation_progress),
+        )
 }
 
 /// List all S3 sources
@@ -957,6 +1011,31 @@ async fn get_backup(
     Ok(Json(BackupResponse::from(backup.unwrap())))
 }
 
+/// Get backup upload metrics
+///
+/// Counters of backup uploads, retries and failures since the server started, and the
+/// backups kept locally until S3 is reachable.
+#[utoipa::path(
+    tag = "Backups",
+    get,
+    path = "/backups/upload-metrics",
+    responses(
+        (status = 200, description = "Backup upload metrics", body = UploadMetricsSnapshot),
+        (status = 401, description = "Unauthorized", body = ProblemDetails)
+    ),
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn get_upload_metrics(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BackupAppState>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, BackupsRead);
+
+    Ok(Json(app_state.backup_service.upload_metrics()))
+}
+
 /// Disable a backup schedule
 #[utoipa::path(
     tag = "Backups",
@@ -1039,15 +1118,22 @@ async fn enable_backup_schedule(
 }
 
 /// Run a backup for an external service manually
+///
+/// With `background`, the backup starts and the operation is returned right away;
+/// follow it at `/backups/operations/{operation_id}/progress`. Otherwise the backup
+/// is returned once it ends, and its progress can still be followed meanwhile
+/// through the service's operations.
 #[utoipa::path(
     tag = "Backups",
     post,
     path = "/backups/external-services/{id}/run",
     request_body = RunExternalServiceBackupRequest,
     responses(
-        (status = 200, description = "Backup started successfully", body = ExternalServiceBackupResponse),
+        (status = 200, description = "Backup completed successfully", body = ExternalServiceBackupResponse),
+        (status = 202, description = "Backup started in the background", body = TransferOperationResponse),
         (status = 400, description = "Invalid request", body = ProblemDetails),
         (status = 404, description = "External service or S3 source not found", body = ProblemDetails),
+        (status = 409, description = "A backup or restore of the service is already running", body = ProblemDetails),
         (status = 500, description = "Internal server error", body = ProblemDetails)
     ),
     security(
@@ -1060,7 +1146,7 @@ async fn run_external_service_backup(
     Path(id): Path<i32>,
     Extension(metadata): Extension<RequestMetadata>,
     Json(request): Json<RunExternalServiceBackupRequest>,
-) -> Result<impl IntoResponse, Problem> {
+) -> Result<Response, Problem> {
     permission_guard!(auth, BackupsCreate);
 
     // Get the external service
@@ -1070,19 +1156,72 @@ async fn run_external_service_backup(
         .await
         .map_err(Problem::from)?;
 
-    let backup_type = request.backup_type.as_deref().unwrap_or("full");
-
-    // Run the backup
-    let backup = app_state
+    let backup_type = request
+        .backup_type
+        .clone()
+        .unwrap_or_else(|| "full".to_string());
+    let user_id = auth.user_id();
+    let operation = app_state
         .backup_service
-        .backup_external_service(&service, request.s3_source_id, backup_type, auth.user_id())
+        .begin_external_service_backup(&service)?;
+
+    // The backup runs in a task of its own, so that it goes on when the client goes away
+    let response = TransferOperationResponse::from(operation.as_ref());
+    let background = request.background;
+    let task = tokio::spawn(async move {
+        let result = app_state
+            .backup_service
+            .run_external_service_backup(
+                &operation,
+                &service,
+                request.s3_source_id,
+                &backup_type,
+                user_id,
+            )
+            .await;
+        match &result {
+            Ok(backup) => {
+                audit_external_service_backup_run(
+                    &app_state,
+                    user_id,
+                    &metadata,
+                    &service,
+                    backup,
+                    &backup_type,
+                )
+                .await
+            }
+            Err(e) if background => error!(
+                "Background backup of service {} failed: {}",
+                service.name, e
+            ),
+            Err(_) => {}
+        }
+        result
+    });
+
+    if background {
+        return Ok((StatusCode::ACCEPTED, Json(response)).into_response());
+    }
+
+    let backup = task
         .await
-        .map_err(Problem::from)?;
+        .map_err(|e| BackupError::Internal(format!("Backup task failed: {}", e)))??;
 
-    // Create audit log
+    Ok(Json(ExternalServiceBackupResponse::from(backup)).into_response())
+}
+
+async fn audit_external_service_backup_run(
+    app_state: &BackupAppState,
+    user_id: i32,
+    metadata: &RequestMetadata,
+    service: &temps_entities::external_services::Model,
+    backup: &temps_entities::external_service_backups::Model,
+    backup_type: &str,
+) {
     let audit = ExternalServiceBackupRunAudit {
         context: AuditContext {
-            user_id: auth.user_id(),
+            user_id,
             ip_address: Some(metadata.ip_address.clone()),
             user_agent: metadata.user_agent.clone(),
         },
@@ -1096,6 +1235,351 @@ async fn run_external_service_backup(
     if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
         error!("Failed to create audit log: {}", e);
     }
+}
+
+/// List the backups of an external service, latest first
+#[utoipa::path(
+    tag = "Backups",
+    get,
+    path = "/backups/external-services/{id}/backups",
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    responses(
+        (status = 200, description = "Backups of the service", body = Vec<ExternalServiceBackupResponse>),
+        (status = 401, description = "Unauthorized", body = ProblemDetails),
+        (status = 404, description = "External service not found", body = ProblemDetails),
+        (status = 500, description = "Internal server error", body = ProblemDetails)
+    ),
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn list_external_service_backups(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BackupAppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, BackupsRead);
+
+    app_state.backup_service.get_external_service(id).await?;
+    let backups = app_state
+        .backup_service
+        .list_external_service_backups(id)
+        .await?;
+    Ok(Json(
+        backups
+            .into_iter()
+            .map(ExternalServiceBackupResponse::from)
+            .collect::<Vec<_>>(),
+    ))
+}
+
+#[derive(Debug, Deserialize, IntoParams)]
+pub struct DownloadBackupQuery {
+    /// Decompress a gzip backup on the way
+    #[serde(default)]
+    pub decompress: bool,
+}
+
+/// Download a backup of an external service
+///
+/// Streams the backup from its S3 source, taking the data off-platform (unlike a
+/// restore). The stored backup is checked against the SHA-256 recorded when it was
+/// taken, sent in `X-Backup-Checksum-Sha256`; if it doesn't match, the transfer is
+/// aborted. The checksum is of the stored, compressed object, so compare it with a
+/// download that isn't decompressed. Backups taken before checksums were recorded
+/// are downloaded unverified.
+#[utoipa::path(
+    tag = "Backups",
+    get,
+    path = "/backups/external-services/{id}/backups/{backup_id}/download",
+    params(
+        ("id" = i32, Path, description = "External service ID"),
+        ("backup_id" = i32, Path, description = "External service backup ID"),
+        DownloadBackupQuery
+    ),
+    responses(
+        (status = 200, description = "Backup file", content_type = "application/octet-stream"),
+        (status = 400, description = "Backup can't be downloaded, or isn't compressed", body = ProblemDetails),
+        (status = 401, description = "Unauthorized", body = ProblemDetails),
+        (status = 403, description = "Insufficient permissions", body = ProblemDetails),
+        (status = 404, description = "Backup not found", body = ProblemDetails),
+        (status = 500, description = "Internal server error", body = ProblemDetails)
+    ),
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn download_external_service_backup(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BackupAppState>>,
+    Path((id, backup_id)): Path<(i32, i32)>,
+    Query(query): Query<DownloadBackupQuery>,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, BackupsRead);
+
+    let service = app_state.backup_service.get_external_service(id).await?;
+    let download = app_state
+        .backup_service
+        .download_external_service_backup(id, backup_id, query.decompress)
+        .await?;
+
+    let audit = ExternalServiceBackupDownloadedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id: service.id,
+        service_name: service.name,
+        backup_id,
+        decompressed: download.decompressed,
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+
+    let mut headers = HeaderMap::new();
+    headers.insert(
+        header::CONTENT_TYPE,
+        HeaderValue::from_static("application/octet-stream"),
+    );
+    if let Ok(value) = HeaderValue::from_str(&format!(
+        "attachment; filename=\"{}\"",
+        download.file_name.replace('"', "")
+    )) {
+        headers.insert(header::CONTENT_DISPOSITION, value);
+    }
+    if let Some(length) = download.content_length {
+        headers.insert(header::CONTENT_LENGTH, HeaderValue::from(length));
+    }
+    if let Some(checksum) = download
+        .checksum
+        .as_deref()
+        .and_then(|checksum| HeaderValue::from_str(checksum).ok())
+    {
+        headers.insert("x-backup-checksum-sha256", checksum);
+    }
+
+    Ok((StatusCode::OK, headers, Body::from_stream(download.body)))
+}
+
+/// Restore an external service from one of its backups
+///
+/// The restore runs in the background and replaces the service's data with the
+/// backup's. Follow it at `/backups/operations/{operation_id}/progress`.
+#[utoipa::path(
+    tag = "Backups",
+    post,
+    path = "/backups/external-services/{id}/backups/{backup_id}/restore",
+    params(
+        ("id" = i32, Path, description = "External service ID"),
+        ("backup_id" = i32, Path, description = "External service backup ID")
+    ),
+    responses(
+        (status = 202, description = "Restore started", body = TransferOperationResponse),
+        (status = 400, description = "Backup can't be restored", body = ProblemDetails),
+        (status = 401, description = "Unauthorized", body = ProblemDetails),
+        (status = 403, description = "Insufficient permissions", body = ProblemDetails),
+        (status = 404, description = "Service or backup not found", body = ProblemDetails),
+        (status = 409, description = "A backup or restore of the service is already running", body = ProblemDetails),
+        (status = 500, description = "Internal server error", body = ProblemDetails)
+    ),
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn restore_external_service_backup(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BackupAppState>>,
+    Path((id, backup_id)): Path<(i32, i32)>,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, BackupsWrite);
+
+    let service = app_state.backup_service.get_external_service(id).await?;
+    let operation = app_state
+        .backup_service
+        .begin_external_service_restore(id, backup_id)
+        .await?;
+
+    let audit = ExternalServiceBackupRestoredAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id: service.id,
+        service_name: service.name.clone(),
+        service_type: service.service_type.clone(),
+        backup_id,
+        operation_id: operation.id.clone(),
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
 
-    Ok(Json(ExternalServiceBackupResponse::from(backup)))
+    let response = TransferOperationResponse::from(operation.as_ref());
+    let backup_service = app_state.backup_service.clone();
+    tokio::spawn(async move {
+        if let Err(e) = backup_service
+            .run_external_service_restore(&operation)
+            .await
+        {
+            error!(
+                "Restore of service {} from backup {} failed: {}",
+                service.name, backup_id, e
+            );
+        }
+    });
+
+    Ok((StatusCode::ACCEPTED, Json(response)))
+}
+
+/// List the backups and restores of an external service running or ended recently,
+/// latest first
+#[utoipa::path(
+    tag = "Backups",
+    get,
+    path = "/backups/external-services/{id}/operations",
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    responses(
+        (status = 200, description = "Operations of the service", body = Vec<TransferOperationResponse>),
+        (status = 401, description = "Unauthorized", body = ProblemDetails),
+        (status = 403, description = "Insufficient permissions", body = ProblemDetails),
+        (status = 404, description = "External service not found", body = ProblemDetails)
+    ),
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn list_external_service_operations(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BackupAppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, BackupsRead);
+
+    let service = app_state.backup_service.get_external_service(id).await?;
+    let operations = app_state
+        .backup_service
+        .operations()
+        .for_service(service.id)
+        .iter()
+        .map(|operation| TransferOperationResponse::from(operation.as_ref()))
+        .collect::<Vec<_>>();
+    Ok(Json(operations))
+}
+
+/// Get a backup or restore of an external service and where it stands
+#[utoipa::path(
+    tag = "Backups",
+    get,
+    path = "/backups/operations/{operation_id}",
+    params(
+        ("operation_id" = String, Path, description = "Operation ID")
+    ),
+    responses(
+        (status = 200, description = "The operation", body = TransferOperationResponse),
+        (status = 401, description = "Unauthorized", body = ProblemDetails),
+        (status = 403, description = "Insufficient permissions", body = ProblemDetails),
+        (status = 404, description = "Operation not found, or ended long ago", body = ProblemDetails)
+    ),
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn get_backup_operation(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BackupAppState>>,
+    Path(operation_id): Path<String>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, BackupsRead);
+
+    let operation = find_operation(&app_state, &operation_id)?;
+    Ok(Json(TransferOperationResponse::from(operation.as_ref())))
+}
+
+/// Least time between two progress events of an operation
+const PROGRESS_EVENT_INTERVAL: std::time::Duration = std::time::Duration::from_millis(500);
+
+/// Keep proxies from buffering the event stream
+const SSE_STREAM_HEADERS: [(&str, &str); 1] = [("x-accel-buffering", "no")];
+
+/// Follow the progress of a backup or restore as server-sent events
+///
+/// A `progress` event carries the phase, the bytes it moved and, when its size is
+/// known, its total and ETA; they come as the operation moves, at most twice a
+/// second. The stream ends with a `completed` or a `failed` event, the latter with
+/// the error. Following an operation that already ended sends only its last event.
+#[utoipa::path(
+    tag = "Backups",
+    get,
+    path = "/backups/operations/{operation_id}/progress",
+    params(
+        ("operation_id" = String, Path, description = "Operation ID")
+    ),
+    responses(
+        (status = 200, description = "Stream of progress events", content_type = "text/event-stream", body = TransferSnapshot),
+        (status = 401, description = "Unauthorized", body = ProblemDetails),
+        (status = 403, description = "Insufficient permissions", body = ProblemDetails),
+        (status = 404, description = "Operation not found, or ended long ago", body = ProblemDetails)
+    ),
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn stream_backup_operation_progress(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BackupAppState>>,
+    Path(operation_id): Path<String>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, BackupsRead);
+
+    let operation = find_operation(&app_state, &operation_id)?;
+    let receiver = operation.progress.subscribe();
+    let stream = stream::unfold(Some((receiver, true)), |state| async move {
+        let (mut receiver, first) = state?;
+        if !first {
+            tokio::time::sleep(PROGRESS_EVENT_INTERVAL).await;
+            if receiver.changed().await.is_err() {
+                return None;
+            }
+        }
+        let snapshot = receiver.borrow_and_update().clone();
+        let event = match snapshot.state {
+            TransferState::Running => "progress",
+            TransferState::Completed => "completed",
+            TransferState::Failed => "failed",
+        };
+        let next = (!snapshot.is_finished()).then_some((receiver, false));
+        Some((Event::default().event(event).json_data(&snapshot), next))
+    });
+
+    Ok((
+        SSE_STREAM_HEADERS,
+        Sse::new(stream).keep_alive(KeepAlive::default()),
+    ))
+}
+
+fn find_operation(
+    app_state: &BackupAppState,
+    operation_id: &str,
+) -> Result<Arc<crate::services::TransferOperation>, Problem> {
+    app_state
+        .backup_service
+        .operations()
+        .get(operation_id)
+        .ok_or_else(|| {
+            problemdetails::new(StatusCode::NOT_FOUND)
+                .with_title("Operation Not Found")
+                .with_detail(format!(
+                    "No backup or restore {} is running or ended recently",
+                    operation_id
+                ))
+        })
 }
@@ -1,4 +1,9 @@
 use crate::handlers::backup_handler::{CreateBackupScheduleRequest, CreateS3SourceRequest};
+use crate::services::download::{verified_stream, BackupDownload, BackupVerifier};
+use crate::services::operations::{TransferOperation, TransferOperationKind, TransferOperations};
+use crate::services::upload::{
+    with_retries, SpillBuffer, SpilledUpload, UploadMetrics, UploadMetricsSnapshot,
+};
 use anyhow::{Context, Result};
 use aws_sdk_s3::error::ProvideErrorMetadata;
 use aws_sdk_s3::{Client as S3Client, Config};
@@ -16,7 +21,7 @@ use tempfile::NamedTempFile;
 use temps_entities::backups::Model as Backup;
 use thiserror::Error;
 use tokio::time;
-use tracing::{debug, error, info};
+use tracing::{debug, error, info, warn};
 use urlencoding;
 use uuid::Uuid;
 
@@ -70,6 +75,9 @@ pub enum BackupError {
 
     #[error("Notification error: {0}")]
     NotificationError(String),
+
+    #[error("Conflict: {0}")]
+    Conflict(String),
 }
 
 // Implementation to convert anyhow errors to BackupError
@@ -132,8 +140,14 @@ pub struct BackupService {
     notification_dispatcher: Arc<dyn NotificationService>,
     config_service: Arc<temps_config::ConfigService>,
     encryption_service: Arc<temps_core::EncryptionService>,
+    upload_metrics: UploadMetrics,
+    spill_buffer: SpillBuffer,
+    operations: Arc<TransferOperations>,
 }
 
+/// How often backups in the spill buffer are uploaded again
+const SPILL_FLUSH_INTERVAL: time::Duration = time::Duration::from_secs(5 * 60);
+
 impl BackupService {
     pub fn new(
         db: Arc<DatabaseConnection>,
@@ -142,12 +156,16 @@ impl BackupService {
         serve_config: Arc<temps_config::ConfigService>,
         encryption_service: Arc<temps_core::EncryptionService>,
     ) -> Self {
+        let spill_buffer = SpillBuffer::new(serve_config.data_dir().join("backup-spill"));
         Self {
             db,
             external_service_manager,
             notification_dispatcher,
             config_service: serve_config,
             encryption_service,
+            upload_metrics: UploadMetrics::default(),
+            spill_buffer,
+            operations: Arc::new(TransferOperations::new()),
         }
     }
 
@@ -248,9 +266,18 @@ impl BackupService {
         // Create S3 client
         let s3_client = self.create_s3_client(&s3_source).await?;
 
-        // Compress and upload the backup
-        self.upload_backup(&s3_client, &s3_source, &temp_file, &s3_location)
-            .await?;
+        // Compress and upload the backup, keeping it locally while S3 is unavailable
+        let spilled = match self
+            .upload_backup(&s3_client, &s3_source, temp_file.path(), &s3_location)
+            .await
+        {
+            Ok(()) => false,
+            Err(e) => {
+                self.spill_backup(temp_file.path(), &backup_id, &s3_source, &s3_location, e)
+                    .await?;
+                true
+            }
+        };
 
         // Create backup record
         let new_backup = temps_entities::backups::ActiveModel {
@@ -259,9 +286,16 @@ impl BackupService {
             backup_id: sea_orm::Set(backup_id.clone()),
             schedule_id: sea_orm::Set(schedule_id),
             backup_type: sea_orm::Set(backup_type.to_string()),
-            state: sea_orm::Set("completed".to_string()),
+            state: sea_orm::Set(
+                if spilled {
+                    "pending_upload"
+                } else {
+                    "completed"
+                }
+                .to_string(),
+            ),
             started_at: sea_orm::Set(chrono::Utc::now()),
-            finished_at: sea_orm::Set(Some(chrono::Utc::now())),
+            finished_at: sea_orm::Set((!spilled).then(chrono::Utc::now)),
             s3_source_id: sea_orm::Set(s3_source_id),
             s3_location: sea_orm::Set(s3_location.clone()),
             compression_type: sea_orm::Set("gzip".to_string()),
@@ -284,8 +318,10 @@ impl BackupService {
 
         let backup = new_backup.insert(self.db.as_ref()).await?;
 
-        // Backup all external services
+        // Backup all external services; the ones hosted elsewhere are backed up by
+        // whoever hosts them
         let external_services = temps_entities::external_services::Entity::find()
+            .filter(temps_entities::external_services::Column::Managed.eq(true))
             .all(self.db.as_ref())
             .await?;
 
@@ -337,6 +373,15 @@ impl BackupService {
             );
         }
 
+        // A spilled backup gets its metadata and index entry once it's uploaded
+        if spilled {
+            warn!(
+                "Backup {} is waiting in the spill buffer until S3 is reachable",
+                backup_id
+            );
+            return Ok(backup);
+        }
+
         // After successful backup upload, create and upload metadata file
         let metadata = self.generate_backup_metadata(&backup, &s3_source, &external_backups);
         let metadata_key = format!(
@@ -984,229 +1029,474 @@ impl BackupService {
         )
     }
 
+    /// Keep a backup that couldn't be uploaded in the spill buffer; fails with the
+    /// upload error when the buffer is disabled or full
+    async fn spill_backup(
+        &self,
+        file: &std::path::Path,
+        backup_id: &str,
+        s3_source: &S3Source,
+        s3_location: &str,
+        upload_error: anyhow::Error,
+    ) -> Result<(), BackupError> {
+        let max_bytes = self
+            .upload_settings()
+            .await
+            .spill_buffer_mb
+            .saturating_mul(1024 * 1024);
+        if max_bytes == 0 {
+            return Err(upload_error.into());
+        }
+
+        let upload = SpilledUpload {
+            backup_id: backup_id.to_string(),
+            s3_source_id: s3_source.id,
+            s3_location: s3_location.to_string(),
+            spilled_at: Utc::now(),
+        };
+        match self.spill_buffer.spill(file, &upload, max_bytes) {
+            Ok(()) => {
+                self.upload_metrics.record_spilled();
+                warn!(
+                    "Failed to upload backup {}, keeping it locally to retry: {}",
+                    backup_id, upload_error
+                );
+                Ok(())
+            }
+            Err(e) => {
+                error!("Failed to keep backup {} locally: {}", backup_id, e);
+                Err(upload_error.into())
+            }
+        }
+    }
+
+    /// Upload the backups kept in the spill buffer while S3 was unavailable
+    pub async fn flush_spilled_uploads(&self) -> Result<(), BackupError> {
+        for upload in self.spill_buffer.pending() {
+            let Some(backup) = temps_entities::backups::Entity::find()
+                .filter(temps_entities::backups::Column::BackupId.eq(upload.backup_id.as_str()))
+                .one(self.db.as_ref())
+                .await?
+            else {
+                warn!(
+                    "Dropping spilled upload of deleted backup {}",
+                    upload.backup_id
+                );
+                self.spill_buffer.remove(&upload.backup_id);
+                continue;
+            };
+
+            let Some(s3_source) =
+                temps_entities::s3_sources::Entity::find_by_id(upload.s3_source_id)
+                    .one(self.db.as_ref())
+                    .await?
+            else {
+                warn!(
+                    "S3 source {} of spilled backup {} was deleted",
+                    upload.s3_source_id, upload.backup_id
+                );
+                let mut failed = backup.into_active_model();
+                failed.state = sea_orm::Set("failed".to_string());
+                failed.error_message = sea_orm::Set(Some(
+                    "S3 source was deleted before the backup was uploaded".to_string(),
+                ));
+                failed.update(self.db.as_ref()).await?;
+                self.spill_buffer.remove(&upload.backup_id);
+                continue;
+            };
+
+            let s3_client = match self.create_s3_client(&s3_source).await {
+                Ok(client) => client,
+                Err(e) => {
+                    error!(
+                        "Failed to create S3 client for spilled backup {}: {}",
+                        upload.backup_id, e
+                    );
+                    continue;
+                }
+            };
+            if let Err(e) = self
+                .upload_backup(
+                    &s3_client,
+                    &s3_source,
+                    &self.spill_buffer.data_path(&upload.backup_id),
+                    &upload.s3_location,
+                )
+                .await
+            {
+                warn!(
+                    "Spilled backup {} still can't be uploaded: {}",
+                    upload.backup_id, e
+                );
+                continue;
+            }
+
+            let mut completed = backup.into_active_model();
+            completed.state = sea_orm::Set("completed".to_string());
+            completed.finished_at = sea_orm::Set(Some(Utc::now()));
+            let backup = completed.update(self.db.as_ref()).await?;
+            self.spill_buffer.remove(&upload.backup_id);
+            self.upload_metrics.record_recovered();
+            info!("Uploaded spilled backup {}", upload.backup_id);
+
+            // Metadata goes next to the backup, as the index expects
+            let metadata = self.generate_backup_metadata(&backup, &s3_source, &[]);
+            let metadata_key = upload
+                .s3_location
+                .replace("backup.postgresql.gz", "metadata.json");
+            if let Err(e) = s3_client
+                .put_object()
+                .bucket(&s3_source.bucket_name)
+                .key(&metadata_key)
+                .body(serde_json::to_vec(&metadata)?.into())
+                .content_type("application/json")
+                .send()
+                .await
+            {
+                error!(
+                    "Failed to upload metadata of spilled backup {}: {}",
+                    upload.backup_id, e
+                );
+            }
+            if let Err(e) = self
+                .update_backup_index(&s3_client, &s3_source, &backup)
+                .await
+            {
+                error!(
+                    "Failed to add spilled backup {} to the index: {}",
+                    upload.backup_id, e
+                );
+            }
+        }
+        Ok(())
+    }
+
+    /// Upload spilled backups every few minutes until cancelled
+    pub async fn start_spill_flusher(
+        &self,
+        cancellation_token: tokio_util::sync::CancellationToken,
+    ) {
+        loop {
+            tokio::select! {
+                _ = time::sleep(SPILL_FLUSH_INTERVAL) => {}
+                _ = cancellation_token.cancelled() => {
+                    info!("Backup spill flusher received cancellation signal");
+                    return;
+                }
+            }
+
+            if self.spill_buffer.pending().is_empty() {
+                continue;
+            }
+            if let Err(e) = self.flush_spilled_uploads().await {
+                error!("Failed to upload spilled backups: {}", e);
+            }
+        }
+    }
+
+    /// S3 upload settings, falling back to the defaults when settings can't be read
+    async fn upload_settings(&self) -> temps_core::S3UploadSettings {
+        match self.config_service.get_settings().await {
+            Ok(settings) => settings.s3_uploads,
+            Err(e) => {
+                warn!("Failed to read S3 upload settings, using defaults: {}", e);
+                temps_core::S3UploadSettings::default()
+            }
+        }
+    }
+
+    /// Upload counters and the state of the spill buffer
+    pub fn upload_metrics(&self) -> UploadMetricsSnapshot {
+        self.upload_metrics.snapshot(&self.spill_buffer)
+    }
+
     async fn upload_backup(
         &self,
         s3_client: &S3Client,
         s3_source: &S3Source,
-        temp_file: &NamedTempFile,
+        file: &std::path::Path,
         s3_location: &str,
     ) -> Result<()> {
         info!("Uploading backup to S3: {}", s3_location);
 
+        let settings = self.upload_settings().await;
+
         // Get file size
-        let file_size = temp_file.as_file().metadata()?.len();
+        let file_size = tokio::fs::metadata(file).await?.len();
 
         // Use multipart upload for files larger than 30MB
         const MULTIPART_THRESHOLD: u64 = 30 * 1024 * 1024; // 30MB in bytes
 
-        if file_size > MULTIPART_THRESHOLD {
-            self.upload_multipart(s3_client, s3_source, temp_file, s3_location)
+        let result = if file_size > MULTIPART_THRESHOLD {
+            self.upload_multipart(s3_client, s3_source, file, s3_location, &settings)
                 .await
         } else {
-            self.upload_single_part(s3_client, s3_source, temp_file, s3_location)
+            self.upload_single_part(s3_client, s3_source, file, s3_location, &settings)
                 .await
+        };
+
+        match &result {
+            Ok(()) => self.upload_metrics.record_completed(),
+            Err(e) => self.upload_metrics.record_failed(&e.to_string()),
         }
+        result
     }
 
     async fn upload_single_part(
         &self,
         s3_client: &S3Client,
         s3_source: &S3Source,
-        temp_file: &NamedTempFile,
+        file: &std::path::Path,
         s3_location: &str,
+        settings: &temps_core::S3UploadSettings,
     ) -> Result<()> {
-        let file_content = tokio::fs::read(temp_file.path()).await?;
-
-        match s3_client
-            .put_object()
-            .bucket(&s3_source.bucket_name)
-            .key(s3_location)
-            .body(file_content.into())
-            .content_type("application/x-gzip")
-            .send()
-            .await
-        {
-            Ok(_) => {
-                info!("Successfully uploaded backup using single-part upload");
-                Ok(())
-            }
-            Err(e) => {
-                if let Some(service_error) = e.as_service_error() {
-                    error!(
-                        "S3 service error during single-part upload: {:?} - Message: {}, Code: {:?}",
-                        service_error,
-                        service_error.message().unwrap_or("no message"),
-                        service_error.code()
-                    );
-                    Err(anyhow::anyhow!(
-                        "S3 upload failed: {} (code: {:?})",
-                        service_error.message().unwrap_or("unknown error"),
-                        service_error.code()
-                    ))
-                } else {
-                    error!("Failed to upload backup: {}", e);
-                    Err(anyhow::anyhow!("Failed to upload backup: {}", e))
+        let file_content = tokio::fs::read(file).await?;
+
+        with_retries(settings, &self.upload_metrics, "Single-part upload", || {
+            let body = file_content.clone();
+            async move {
+                match s3_client
+                    .put_object()
+                    .bucket(&s3_source.bucket_name)
+                    .key(s3_location)
+                    .body(body.into())
+                    .content_type("application/x-gzip")
+                    .send()
+                    .await
+                {
+                    Ok(_) => Ok(()),
+                    Err(e) => {
+                        if let Some(service_error) = e.as_service_error() {
+                            error!(
+                                "S3 service error during single-part upload: {:?} - Message: {}, Code: {:?}",
+                                service_error,
+                                service_error.message().unwrap_or("no message"),
+                                service_error.code()
+                            );
+                            Err(anyhow::anyhow!(
+                                "S3 upload failed: {} (code: {:?})",
+                                service_error.message().unwrap_or("unknown error"),
+                                service_error.code()
+                            ))
+                        } else {
+                            error!("Failed to upload backup: {}", e);
+                            Err(anyhow::anyhow!("Failed to upload backup: {}", e))
+                        }
+                    }
                 }
             }
-        }
+        })
+        .await?;
+
+        info!("Successfully uploaded backup using single-part upload");
+        Ok(())
     }
 
     async fn upload_multipart(
         &self,
         s3_client: &S3Client,
         s3_source: &S3Source,
-        temp_file: &NamedTempFile,
+        file: &std::path::Path,
         s3_location: &str,
+        settings: &temps_core::S3UploadSettings,
     ) -> Result<()> {
         // Create multipart upload
-        let create_multipart_resp = match s3_client
-            .create_multipart_upload()
-            .bucket(&s3_source.bucket_name)
-            .key(s3_location)
-            .content_type("application/x-gzip")
-            .send()
+        let create_multipart_resp = with_retries(
+            settings,
+            &self.upload_metrics,
+            "Creating multipart upload",
+            || async {
+                match s3_client
+                    .create_multipart_upload()
+                    .bucket(&s3_source.bucket_name)
+                    .key(s3_location)
+                    .content_type("application/x-gzip")
+                    .send()
+                    .await
+                {
+                    Ok(resp) => Ok(resp),
+                    Err(e) => {
+                        if let Some(service_error) = e.as_service_error() {
+                            error!(
+                                "S3 service error creating multipart upload: {:?} - Message: {}, Code: {:?}",
+                                service_error,
+                                service_error.message().unwrap_or("no message"),
+                                service_error.code()
+                            );
+                            return Err(anyhow::anyhow!(
+                                "Failed to create multipart upload: {} (code: {:?})",
+                                service_error.message().unwrap_or("unknown error"),
+                                service_error.code()
+                            ));
+                        }
+                        Err(anyhow::anyhow!("Failed to create multipart upload: {}", e))
+                    }
+                }
+            },
+        )
+        .await?;
+
+        let upload_id = create_multipart_resp
+            .upload_id()
+            .ok_or_else(|| anyhow::anyhow!("No upload ID received from S3"))?;
+
+        let (parts, total_size) = match self
+            .upload_parts(
+                s3_client,
+                &s3_source.bucket_name,
+                s3_location,
+                upload_id,
+                file,
+                settings,
+            )
             .await
         {
-            Ok(resp) => resp,
+            Ok(uploaded) => uploaded,
             Err(e) => {
-                if let Some(service_error) = e.as_service_error() {
-                    error!(
-                        "S3 service error creating multipart upload: {:?} - Message: {}, Code: {:?}",
-                        service_error,
-                        service_error.message().unwrap_or("no message"),
-                        service_error.code()
-                    );
-                    return Err(anyhow::anyhow!(
-                        "Failed to create multipart upload: {} (code: {:?})",
-                        service_error.message().unwrap_or("unknown error"),
-                        service_error.code()
-                    ));
-                }
-                return Err(anyhow::anyhow!("Failed to create multipart upload: {}", e));
+                self.abort_multipart_upload(
+                    s3_client,
+                    &s3_source.bucket_name,
+                    s3_location,
+                    upload_id,
+                )
+                .await;
+                return Err(e);
             }
         };
 
-        let upload_id = create_multipart_resp
-            .upload_id()
-            .ok_or_else(|| anyhow::anyhow!("No upload ID received from S3"))?;
+        // Complete multipart upload
+        with_retries(
+            settings,
+            &self.upload_metrics,
+            "Completing multipart upload",
+            || async {
+                match s3_client
+                    .complete_multipart_upload()
+                    .bucket(&s3_source.bucket_name)
+                    .key(s3_location)
+                    .upload_id(upload_id)
+                    .multipart_upload(
+                        aws_sdk_s3::types::CompletedMultipartUpload::builder()
+                            .set_parts(Some(parts.clone()))
+                            .build(),
+                    )
+                    .send()
+                    .await
+                {
+                    Ok(_) => Ok(()),
+                    Err(e) => {
+                        if let Some(service_error) = e.as_service_error() {
+                            error!(
+                                "S3 service error completing multipart upload: {:?} - Message: {}, Code: {:?}",
+                                service_error,
+                                service_error.message().unwrap_or("no message"),
+                                service_error.code()
+                            );
+                            Err(anyhow::anyhow!(
+                                "Failed to complete multipart upload: {} (code: {:?})",
+                                service_error.message().unwrap_or("unknown error"),
+                                service_error.code()
+                            ))
+                        } else {
+                            error!("Failed to complete multipart upload: {}", e);
+                            Err(anyhow::anyhow!(
+                                "Failed to complete multipart upload: {}",
+                                e
+                            ))
+                        }
+                    }
+                }
+            },
+        )
+        .await?;
 
-        let mut part_number = 1;
-        let mut parts = aws_sdk_s3::types::CompletedMultipartUpload::builder();
-        let mut total_size = 0;
+        info!(
+            "Successfully uploaded backup with size: {} bytes",
+            total_size
+        );
+        Ok(())
+    }
 
-        // Stream and upload file in chunks
-        let file = tokio::fs::File::open(temp_file.path()).await?;
+    /// Stream a file to S3 in 5MB parts, uploading up to the configured number of
+    /// parts at once; returns the parts in order and the bytes uploaded
+    async fn upload_parts(
+        &self,
+        s3_client: &S3Client,
+        bucket: &str,
+        key: &str,
+        upload_id: &str,
+        file: &std::path::Path,
+        settings: &temps_core::S3UploadSettings,
+    ) -> Result<(Vec<aws_sdk_s3::types::CompletedPart>, usize)> {
+        let file = tokio::fs::File::open(file).await?;
         let reader = tokio::io::BufReader::new(file);
         let mut stream = tokio_util::io::ReaderStream::new(reader);
 
         let chunk_size = 5 * 1024 * 1024; // 5MB chunks
         let mut buffer = Vec::with_capacity(chunk_size);
+        let mut part_number = 1;
+        let mut total_size = 0;
+        let mut parts = Vec::new();
+        let mut in_flight = futures::stream::FuturesUnordered::new();
 
-        while let Some(chunk) = stream.next().await {
-            let chunk = chunk.context("Failed to read chunk from file")?;
-            buffer.extend_from_slice(&chunk);
-
-            if buffer.len() >= chunk_size {
-                match self
-                    .upload_part(
-                        s3_client,
-                        &s3_source.bucket_name,
-                        s3_location,
-                        upload_id,
-                        part_number,
-                        buffer.clone(),
-                    )
-                    .await
-                {
-                    Ok(part) => {
-                        parts = parts.parts(part);
-                        total_size += buffer.len();
-                        part_number += 1;
-                        buffer.clear();
-                    }
-                    Err(e) => {
-                        self.abort_multipart_upload(
-                            s3_client,
-                            &s3_source.bucket_name,
-                            s3_location,
-                            upload_id,
-                        )
-                        .await;
-                        return Err(e);
+        loop {
+            match stream.next().await {
+                Some(chunk) => {
+                    let chunk = chunk.context("Failed to read chunk from file")?;
+                    buffer.extend_from_slice(&chunk);
+                    if buffer.len() < chunk_size {
+                        continue;
                     }
                 }
+                // Handle remaining data
+                None if buffer.is_empty() => break,
+                None => {}
             }
-        }
 
-        // Handle remaining data
-        if !buffer.is_empty() {
-            match self
-                .upload_part(
-                    s3_client,
-                    &s3_source.bucket_name,
-                    s3_location,
-                    upload_id,
-                    part_number,
-                    buffer.clone(),
-                )
-                .await
-            {
-                Ok(part) => {
-                    parts = parts.parts(part);
-                    total_size += buffer.len();
-                }
-                Err(e) => {
-                    self.abort_multipart_upload(
-                        s3_client,
-                        &s3_source.bucket_name,
-                        s3_location,
-                        upload_id,
-                    )
-                    .await;
-                    return Err(e);
+            let body = std::mem::replace(&mut buffer, Vec::with_capacity(chunk_size));
+            total_size += body.len();
+            in_flight.push(self.upload_part_with_retries(
+                s3_client,
+                bucket,
+                key,
+                upload_id,
+                part_number,
+                body,
+                settings,
+            ));
+            part_number += 1;
+
+            if in_flight.len() >= settings.concurrency() {
+                if let Some(part) = in_flight.next().await {
+                    parts.push(part?);
                 }
             }
         }
 
-        // Complete multipart upload
-        match s3_client
-            .complete_multipart_upload()
-            .bucket(&s3_source.bucket_name)
-            .key(s3_location)
-            .upload_id(upload_id)
-            .multipart_upload(parts.build())
-            .send()
-            .await
-        {
-            Ok(_) => {
-                info!(
-                    "Successfully uploaded backup with size: {} bytes",
-                    total_size
-                );
-                Ok(())
-            }
-            Err(e) => {
-                if let Some(service_error) = e.as_service_error() {
-                    error!(
-                        "S3 service error completing multipart upload: {:?} - Message: {}, Code: {:?}",
-                        service_error,
-                        service_error.message().unwrap_or("no message"),
-                        service_error.code()
-                    );
-                    Err(anyhow::anyhow!(
-                        "Failed to complete multipart upload: {} (code: {:?})",
-                        service_error.message().unwrap_or("unknown error"),
-                        service_error.code()
-                    ))
-                } else {
-                    error!("Failed to complete multipart upload: {}", e);
-                    Err(anyhow::anyhow!(
-                        "Failed to complete multipart upload: {}",
-                        e
-                    ))
-                }
-            }
+        while let Some(part) = in_flight.next().await {
+            parts.push(part?);
         }
+        parts.sort_by_key(|part| part.part_number());
+
+        Ok((parts, total_size))
+    }
+
+    #[allow(clippy::too_many_arguments)]
+    async fn upload_part_with_retries(
+        &self,
+        s3_client: &S3Client,
+        bucket: &str,
+        key: &str,
+        upload_id: &str,
+        part_number: i32,
+        body: Vec<u8>,
+        settings: &temps_core::S3UploadSettings,
+    ) -> Result<aws_sdk_s3::types::CompletedPart> {
+        let operation = format!("Uploading part {}", part_number);
+        with_retries(settings, &self.upload_metrics, &operation, || {
+            self.upload_part(s3_client, bucket, key, upload_id, part_number, body.clone())
+        })
+        .await
     }
 
     async fn upload_part(
@@ -1918,6 +2208,8 @@ impl BackupService {
                     "s3_location": b.s3_location,
                     "state": b.state,
                     "size_bytes": b.size_bytes,
+                    "checksum": b.checksum,
+                    "compression_type": b.compression_type,
                     "type": "full",
                     "metadata": {
                         "service_type": service.service_type,
@@ -2077,12 +2369,190 @@ impl BackupService {
             })
     }
 
+    /// Backups of an external service, latest first
+    pub async fn list_external_service_backups(
+        &self,
+        service_id: i32,
+    ) -> Result<Vec<temps_entities::external_service_backups::Model>, BackupError> {
+        Ok(temps_entities::external_service_backups::Entity::find()
+            .filter(temps_entities::external_service_backups::Column::ServiceId.eq(service_id))
+            .order_by_desc(temps_entities::external_service_backups::Column::StartedAt)
+            .all(self.db.as_ref())
+            .await?)
+    }
+
+    /// Stream a completed external service backup out of its S3 source
+    ///
+    /// The stored object is verified against the checksum recorded with the backup
+    /// as it streams (see `download`). With `decompress`, a gzip backup is
+    /// decompressed on the way.
+    pub async fn download_external_service_backup(
+        &self,
+        service_id: i32,
+        backup_id: i32,
+        decompress: bool,
+    ) -> Result<BackupDownload, BackupError> {
+        let service = self.get_external_service(service_id).await?;
+        let service_backup = self
+            .get_external_service_backup(&service, backup_id)
+            .await?;
+
+        let service_type = temps_providers::ServiceType::from_str(&service.service_type)
+            .map_err(|e| BackupError::Validation(e.to_string()))?;
+        if matches!(
+            service_type,
+            temps_providers::ServiceType::S3
+                | temps_providers::ServiceType::Rustfs
+                | temps_providers::ServiceType::Blob
+        ) {
+            return Err(BackupError::Validation(format!(
+                "Backups of {} services are copied to a bucket prefix and can't be downloaded as a file",
+                service_type
+            )));
+        }
+        if service_backup.state != "completed" || service_backup.s3_location.is_empty() {
+            return Err(BackupError::Validation(format!(
+                "Backup {} is {} and can't be downloaded",
+                backup_id, service_backup.state
+            )));
+        }
+        let compressed = service_backup.compression_type == "gzip";
+        if decompress && !compressed {
+            return Err(BackupError::Validation(format!(
+                "Backup {} isn't compressed",
+                backup_id
+            )));
+        }
+
+        let backup = temps_entities::backups::Entity::find_by_id(service_backup.backup_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| BackupError::NotFound("Backup record not found".to_string()))?;
+        let s3_source = temps_entities::s3_sources::Entity::find_by_id(backup.s3_source_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| BackupError::NotFound("S3 source not found".to_string()))?;
+        let s3_client = self
+            .create_s3_client(&s3_source)
+            .await
+            .map_err(|e| BackupError::S3(e.to_string()))?;
+
+        let location = service_backup
+            .s3_location
+            .trim_start_matches('/')
+            .to_string();
+        let response = s3_client
+            .get_object()
+            .bucket(&s3_source.bucket_name)
+            .key(&location)
+            .send()
+            .await
+            .map_err(|e| {
+                BackupError::S3(format!("Failed to download backup {}: {}", location, e))
+            })?;
+
+        let mut file_name = location
+            .rsplit('/')
+            .next()
+            .unwrap_or(location.as_str())
+            .to_string();
+        if decompress {
+            file_name = file_name.trim_end_matches(".gz").to_string();
+        } else if compressed && !file_name.ends_with(".gz") {
+            // PostgreSQL dumps are stored gzipped under a .sql key
+            file_name.push_str(".gz");
+        }
+        let content_length = if decompress {
+            None
+        } else {
+            response.content_length
+        };
+
+        info!(
+            "Downloading backup {} of service {} ({})",
+            backup_id, service.name, location
+        );
+        let verifier = BackupVerifier::new(service_backup.checksum.clone(), decompress);
+        Ok(BackupDownload {
+            file_name,
+            content_length,
+            checksum: service_backup.checksum,
+            decompressed: decompress,
+            body: verified_stream(response.body, verifier, location),
+        })
+    }
+
+    /// Backups and restores of external services running, and the ones that ended
+    /// recently
+    pub fn operations(&self) -> &TransferOperations {
+        &self.operations
+    }
+
     pub async fn backup_external_service(
         &self,
         service: &temps_entities::external_services::Model,
         s3_source_id: i32,
         backup_type: &str,
         created_by: i32,
+    ) -> Result<temps_entities::external_service_backups::Model, BackupError> {
+        let operation = self.begin_external_service_backup(service)?;
+        self.run_external_service_backup(&operation, service, s3_source_id, backup_type, created_by)
+            .await
+    }
+
+    /// Register a backup of an external service, so that it can be followed while it
+    /// runs; one service runs one backup or restore at a time
+    pub fn begin_external_service_backup(
+        &self,
+        service: &temps_entities::external_services::Model,
+    ) -> Result<Arc<TransferOperation>, BackupError> {
+        if !service.managed {
+            return Err(BackupError::Unsupported(format!(
+                "Service {} is hosted outside of Temps and can't be backed up",
+                service.name
+            )));
+        }
+        self.operations
+            .start(TransferOperationKind::Backup, service.id, None)
+    }
+
+    /// Run a backup registered with `begin_external_service_backup`, reporting its
+    /// progress on the operation until it ends
+    pub async fn run_external_service_backup(
+        &self,
+        operation: &TransferOperation,
+        service: &temps_entities::external_services::Model,
+        s3_source_id: i32,
+        backup_type: &str,
+        created_by: i32,
+    ) -> Result<temps_entities::external_service_backups::Model, BackupError> {
+        let _guard = operation.guard();
+        let result = self
+            .perform_external_service_backup(
+                operation,
+                service,
+                s3_source_id,
+                backup_type,
+                created_by,
+            )
+            .await;
+        match &result {
+            Ok(external_backup) => {
+                operation.set_backup_id(external_backup.id);
+                operation.progress.complete();
+            }
+            Err(e) => operation.progress.fail(e.to_string()),
+        }
+        result
+    }
+
+    async fn perform_external_service_backup(
+        &self,
+        operation: &TransferOperation,
+        service: &temps_entities::external_services::Model,
+        s3_source_id: i32,
+        backup_type: &str,
+        created_by: i32,
     ) -> Result<temps_entities::external_service_backups::Model, BackupError> {
         info!("Starting external service backup process");
         let service_id = service.id;
@@ -2135,6 +2605,43 @@ impl BackupService {
 
         let backup = backup.insert(self.db.as_ref()).await?;
 
+        let backup_location = match self
+            .backup_service_to_s3(operation, service, &s3_client, &s3_source, &backup)
+            .await
+        {
+            Ok(backup_location) => backup_location,
+            Err(e) => {
+                self.mark_external_backup_failed(&backup, &e.to_string())
+                    .await;
+                return Err(e);
+            }
+        };
+        info!("Backup created at location: {}", backup_location);
+        // Get the external service backup record
+        let external_backup = temps_entities::external_service_backups::Entity::find()
+            .filter(temps_entities::external_service_backups::Column::BackupId.eq(backup.id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| {
+                BackupError::NotFound("External service backup record not found".to_string())
+            })?;
+
+        info!(
+            "External service backup completed successfully: {}",
+            backup_id
+        );
+        Ok(external_backup)
+    }
+
+    /// Dump a service into its backup record's S3 source, returning where it was stored
+    async fn backup_service_to_s3(
+        &self,
+        operation: &TransferOperation,
+        service: &temps_entities::external_services::Model,
+        s3_client: &S3Client,
+        s3_source: &S3Source,
+        backup: &Backup,
+    ) -> Result<String, BackupError> {
         // Generate backup path
         let subpath = format!(
             "external_services/{}/{}/{}",
@@ -2154,39 +2661,191 @@ impl BackupService {
 
         let service_config = self
             .external_service_manager
-            .get_service_config(service_id)
+            .get_service_config(service.id)
             .await
             .map_err(|e| BackupError::ExternalService(e.to_string()))?;
 
         // Perform the backup
-        let backup_location = service_instance
+        service_instance
             .backup_to_s3(
-                &s3_client,
+                s3_client,
                 backup.clone(),
-                &s3_source,
+                s3_source,
                 &subpath,
                 &subpath_root,
                 &self.db,
                 service,
                 service_config,
+                &operation.progress,
             )
             .await
-            .map_err(|e| BackupError::ExternalService(e.to_string()))?;
-        info!("Backup created at location: {}", backup_location);
-        // Get the external service backup record
-        let external_backup = temps_entities::external_service_backups::Entity::find()
+            .map_err(|e| BackupError::ExternalService(e.to_string()))
+    }
+
+    /// Record a backup that failed partway, so that neither it nor the service's
+    /// backup stays running
+    async fn mark_external_backup_failed(&self, backup: &Backup, error: &str) {
+        use sea_orm::sea_query::Expr;
+
+        let mut backup_update: temps_entities::backups::ActiveModel = backup.clone().into();
+        backup_update.state = sea_orm::Set("failed".to_string());
+        backup_update.finished_at = sea_orm::Set(Some(Utc::now()));
+        backup_update.error_message = sea_orm::Set(Some(error.to_string()));
+        if let Err(e) = backup_update.update(self.db.as_ref()).await {
+            error!(
+                "Failed to mark backup {} as failed: {}",
+                backup.backup_id, e
+            );
+        }
+
+        if let Err(e) = temps_entities::external_service_backups::Entity::update_many()
             .filter(temps_entities::external_service_backups::Column::BackupId.eq(backup.id))
+            .filter(temps_entities::external_service_backups::Column::State.eq("running"))
+            .col_expr(
+                temps_entities::external_service_backups::Column::State,
+                Expr::value("failed"),
+            )
+            .col_expr(
+                temps_entities::external_service_backups::Column::ErrorMessage,
+                Expr::value(error),
+            )
+            .col_expr(
+                temps_entities::external_service_backups::Column::FinishedAt,
+                Expr::current_timestamp().into(),
+            )
+            .exec(self.db.as_ref())
+            .await
+        {
+            error!(
+                "Failed to mark service backups of backup {} as failed: {}",
+                backup.backup_id, e
+            );
+        }
+    }
+
+    /// Register a restore of an external service from one of its completed backups,
+    /// so that it can be followed while it runs
+    pub async fn begin_external_service_restore(
+        &self,
+        service_id: i32,
+        backup_id: i32,
+    ) -> Result<Arc<TransferOperation>, BackupError> {
+        let service = self.get_external_service(service_id).await?;
+        if !service.managed {
+            return Err(BackupError::Unsupported(format!(
+                "Service {} is hosted outside of Temps and can't be restored",
+                service.name
+            )));
+        }
+        let service_backup = self
+            .get_external_service_backup(&service, backup_id)
+            .await?;
+        if service_backup.state != "completed" || service_backup.s3_location.is_empty() {
+            return Err(BackupError::Validation(format!(
+                "Backup {} is {} and can't be restored",
+                backup_id, service_backup.state
+            )));
+        }
+        self.operations
+            .start(TransferOperationKind::Restore, service_id, Some(backup_id))
+    }
+
+    /// Run a restore registered with `begin_external_service_restore`, reporting its
+    /// progress on the operation until it ends
+    pub async fn run_external_service_restore(
+        &self,
+        operation: &TransferOperation,
+    ) -> Result<(), BackupError> {
+        let _guard = operation.guard();
+        let result = self.perform_external_service_restore(operation).await;
+        match &result {
+            Ok(()) => operation.progress.complete(),
+            Err(e) => operation.progress.fail(e.to_string()),
+        }
+        result
+    }
+
+    async fn perform_external_service_restore(
+        &self,
+        operation: &TransferOperation,
+    ) -> Result<(), BackupError> {
+        let backup_id = operation
+            .backup_id()
+            .ok_or_else(|| BackupError::Internal("Restore without a backup".to_string()))?;
+        let service = self.get_external_service(operation.service_id).await?;
+        let service_backup = self
+            .get_external_service_backup(&service, backup_id)
+            .await?;
+        let backup = temps_entities::backups::Entity::find_by_id(service_backup.backup_id)
             .one(self.db.as_ref())
             .await?
-            .ok_or_else(|| {
-                BackupError::NotFound("External service backup record not found".to_string())
-            })?;
+            .ok_or_else(|| BackupError::NotFound("Backup record not found".to_string()))?;
+        let mut s3_source = temps_entities::s3_sources::Entity::find_by_id(backup.s3_source_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| BackupError::NotFound("S3 source not found".to_string()))?;
+        let s3_client = self
+            .create_s3_client(&s3_source)
+            .await
+            .map_err(|e| BackupError::S3(e.to_string()))?;
+
+        // Services restored by copying buckets hand the credentials to their own client
+        s3_source.access_key_id = self
+            .encryption_service
+            .decrypt_string(&s3_source.access_key_id)
+            .map_err(|e| BackupError::Internal(format!("Failed to decrypt access key: {}", e)))?;
+        s3_source.secret_key = self
+            .encryption_service
+            .decrypt_string(&s3_source.secret_key)
+            .map_err(|e| BackupError::Internal(format!("Failed to decrypt secret key: {}", e)))?;
+
+        let service_type = temps_providers::ServiceType::from_str(&service.service_type)
+            .map_err(|e| BackupError::Validation(e.to_string()))?;
+        let service_instance = self
+            .external_service_manager
+            .get_service_instance(service.name.clone(), service_type);
+        let service_config = self
+            .external_service_manager
+            .get_service_config(service.id)
+            .await
+            .map_err(|e| BackupError::ExternalService(e.to_string()))?;
 
         info!(
-            "External service backup completed successfully: {}",
-            backup_id
+            "Restoring service {} from backup {} ({})",
+            service.name, backup_id, service_backup.s3_location
         );
-        Ok(external_backup)
+        service_instance
+            .restore_from_s3(
+                &s3_client,
+                service_backup.s3_location.trim_start_matches('/'),
+                &s3_source,
+                service_config,
+                &operation.progress,
+            )
+            .await
+            .map_err(|e| BackupError::ExternalService(e.to_string()))?;
+        info!(
+            "Service {} restored from backup {}",
+            service.name, backup_id
+        );
+        Ok(())
+    }
+
+    async fn get_external_service_backup(
+        &self,
+        service: &temps_entities::external_services::Model,
+        backup_id: i32,
+    ) -> Result<temps_entities::external_service_backups::Model, BackupError> {
+        temps_entities::external_service_backups::Entity::find_by_id(backup_id)
+            .filter(temps_entities::external_service_backups::Column::ServiceId.eq(service.id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| {
+                BackupError::NotFound(format!(
+                    "Backup {} of service {} not found",
+                    backup_id, service.name
+                ))
+            })
     }
 
     // Add this new validation function
@@ -1,2 +1,11 @@
 mod backup;
 pub use backup::{BackupError, BackupService};
+mod download;
+pub use download::{BackupDownload, BackupVerifier};
+mod operations;
+pub use operations::{
+    OperationGuard, TransferOperation, TransferOperationKind, TransferOperationResponse,
+    TransferOperations,
+};
+mod upload;
+pub use upload::{SpillBuffer, SpilledUpload, UploadMetrics, UploadMetricsSnapshot};
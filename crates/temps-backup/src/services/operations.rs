@@ -0,0 +1,224 @@
+//! Backup and Restore Operations
+//!
+//! Backups and restores of managed services can take long. Each one is tracked as an
+//! operation with its progress (see `TransferProgress`), so that clients can follow
+//! it while it runs instead of waiting without a word. One service runs one
+//! operation at a time. Operations that ended are kept for a while, so that a client
+//! following one sees how it ended even if it arrived late.
+
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use temps_providers::externalsvc::{TransferProgress, TransferSnapshot};
+use utoipa::ToSchema;
+use uuid::Uuid;
+
+use super::BackupError;
+
+/// How long an operation that ended can still be looked up
+const FINISHED_RETENTION: chrono::Duration = chrono::Duration::minutes(30);
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum TransferOperationKind {
+    Backup,
+    Restore,
+}
+
+/// A backup or restore of a managed service
+#[derive(Debug)]
+pub struct TransferOperation {
+    pub id: String,
+    pub kind: TransferOperationKind,
+    pub service_id: i32,
+    /// Backup of the service restored; for backups, the one taken once it is recorded
+    backup_id: Mutex<Option<i32>>,
+    pub started_at: DateTime<Utc>,
+    pub progress: TransferProgress,
+}
+
+impl TransferOperation {
+    pub fn backup_id(&self) -> Option<i32> {
+        *self.backup_id.lock().unwrap()
+    }
+
+    pub fn set_backup_id(&self, backup_id: i32) {
+        *self.backup_id.lock().unwrap() = Some(backup_id);
+    }
+
+    /// Guard failing the operation if it is dropped before the operation ends
+    pub fn guard(&self) -> OperationGuard<'_> {
+        OperationGuard { operation: self }
+    }
+
+    fn finished_before(&self, cutoff: DateTime<Utc>) -> bool {
+        self.progress
+            .snapshot()
+            .finished_at
+            .is_some_and(|finished_at| finished_at < cutoff)
+    }
+}
+
+/// Fails an operation left unfinished, when the future running it is dropped or its
+/// task panics, so that the service isn't reported busy forever
+pub struct OperationGuard<'a> {
+    operation: &'a TransferOperation,
+}
+
+impl Drop for OperationGuard<'_> {
+    fn drop(&mut self) {
+        if !self.operation.progress.snapshot().is_finished() {
+            self.operation
+                .progress
+                .fail("The operation was interrupted before it ended");
+        }
+    }
+}
+
+/// A backup or restore and where it stands
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct TransferOperationResponse {
+    pub id: String,
+    pub kind: TransferOperationKind,
+    pub service_id: i32,
+    /// External service backup restored, or taken once the backup is recorded
+    pub backup_id: Option<i32>,
+    #[schema(value_type = String, format = "date-time")]
+    pub started_at: DateTime<Utc>,
+    pub progress: TransferSnapshot,
+}
+
+impl From<&TransferOperation> for TransferOperationResponse {
+    fn from(operation: &TransferOperation) -> Self {
+        Self {
+            id: operation.id.clone(),
+            kind: operation.kind,
+            service_id: operation.service_id,
+            backup_id: operation.backup_id(),
+            started_at: operation.started_at,
+            progress: operation.progress.snapshot(),
+        }
+    }
+}
+
+/// Backups and restores running, and the ones that ended recently
+#[derive(Debug, Default)]
+pub struct TransferOperations {
+    operations: Mutex<HashMap<String, Arc<TransferOperation>>>,
+}
+
+impl TransferOperations {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Register an operation on a service, unless one is running on it already
+    pub fn start(
+        &self,
+        kind: TransferOperationKind,
+        service_id: i32,
+        backup_id: Option<i32>,
+    ) -> Result<Arc<TransferOperation>, BackupError> {
+        let mut operations = self.operations.lock().unwrap();
+        let cutoff = Utc::now() - FINISHED_RETENTION;
+        operations.retain(|_, operation| !operation.finished_before(cutoff));
+
+        if let Some(running) = operations.values().find(|operation| {
+            operation.service_id == service_id && !operation.progress.snapshot().is_finished()
+        }) {
+            return Err(BackupError::Conflict(format!(
+                "A {} of service {} is already running (operation {})",
+                match running.kind {
+                    TransferOperationKind::Backup => "backup",
+                    TransferOperationKind::Restore => "restore",
+                },
+                service_id,
+                running.id
+            )));
+        }
+
+        let operation = Arc::new(TransferOperation {
+            id: Uuid::new_v4().to_string(),
+            kind,
+            service_id,
+            backup_id: Mutex::new(backup_id),
+            started_at: Utc::now(),
+            progress: TransferProgress::new(),
+        });
+        operations.insert(operation.id.clone(), operation.clone());
+        Ok(operation)
+    }
+
+    pub fn get(&self, id: &str) -> Option<Arc<TransferOperation>> {
+        self.operations.lock().unwrap().get(id).cloned()
+    }
+
+    /// Operations of a service, latest first
+    pub fn for_service(&self, service_id: i32) -> Vec<Arc<TransferOperation>> {
+        let mut operations: Vec<_> = self
+            .operations
+            .lock()
+            .unwrap()
+            .values()
+            .filter(|operation| operation.service_id == service_id)
+            .cloned()
+            .collect();
+        operations.sort_by(|a, b| b.started_at.cmp(&a.started_at));
+        operations
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_one_operation_per_service() {
+        let operations = TransferOperations::new();
+        let backup = operations
+            .start(TransferOperationKind::Backup, 1, None)
+            .unwrap();
+        assert!(matches!(
+            operations.start(TransferOperationKind::Restore, 1, Some(7)),
+            Err(BackupError::Conflict(_))
+        ));
+        // Other services aren't held up
+        assert!(operations
+            .start(TransferOperationKind::Restore, 2, Some(7))
+            .is_ok());
+
+        backup.progress.complete();
+        let restore = operations
+            .start(TransferOperationKind::Restore, 1, Some(7))
+            .unwrap();
+        assert_eq!(operations.for_service(1).len(), 2);
+        assert_eq!(operations.for_service(1)[0].id, restore.id);
+        assert_eq!(operations.get(&backup.id).unwrap().backup_id(), None);
+    }
+
+    #[test]
+    fn test_interrupted_operation_is_failed() {
+        let operations = TransferOperations::new();
+        let backup = operations
+            .start(TransferOperationKind::Backup, 1, None)
+            .unwrap();
+        drop(backup.guard());
+        assert!(backup.progress.snapshot().is_finished());
+        assert!(operations
+            .start(TransferOperationKind::Backup, 1, None)
+            .is_ok());
+
+        // An operation that ended keeps its outcome
+        let restore = operations
+            .start(TransferOperationKind::Restore, 2, Some(7))
+            .unwrap();
+        let guard = restore.guard();
+        restore.progress.complete();
+        drop(guard);
+        assert_eq!(
+            restore.progress.snapshot().state,
+            temps_providers::externalsvc::TransferState::Completed
+        );
+    }
+}
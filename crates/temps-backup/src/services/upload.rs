@@ -0,0 +1,309 @@
+//! Retries, metrics and the local spill buffer of backup uploads
+//!
+//! S3 requests of an upload are retried with exponential backoff as configured in
+//! the `s3_uploads` settings. A backup that still can't be uploaded is copied to the
+//! spill buffer on local disk with a small manifest, and uploaded again by
+//! `BackupService::flush_spilled_uploads` once S3 is reachable.
+
+use std::future::Future;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Mutex;
+
+use chrono::Utc;
+use serde::{Deserialize, Serialize};
+use temps_core::{S3UploadSettings, UtcDateTime};
+use tracing::warn;
+use utoipa::ToSchema;
+
+/// Counters of backup uploads since the server started
+#[derive(Debug, Default)]
+pub struct UploadMetrics {
+    uploads_completed: AtomicU64,
+    uploads_failed: AtomicU64,
+    requests_retried: AtomicU64,
+    requests_failed: AtomicU64,
+    uploads_spilled: AtomicU64,
+    spilled_uploads_recovered: AtomicU64,
+    last_failure: Mutex<Option<(UtcDateTime, String)>>,
+}
+
+/// Backup upload counters and the state of the spill buffer
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct UploadMetricsSnapshot {
+    /// Backups uploaded, including ones recovered from the spill buffer
+    pub uploads_completed: u64,
+    /// Uploads that failed even after retries, including retries of spilled backups
+    pub uploads_failed: u64,
+    /// S3 requests retried after a failure
+    pub requests_retried: u64,
+    /// S3 requests that failed on every attempt
+    pub requests_failed: u64,
+    /// Backups kept in the spill buffer when S3 was unavailable
+    pub uploads_spilled: u64,
+    /// Spilled backups that were uploaded later
+    pub spilled_uploads_recovered: u64,
+    /// Backups waiting in the spill buffer
+    pub spilled_pending: u64,
+    /// Disk space the spill buffer uses, in bytes
+    pub spill_buffer_bytes: u64,
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub last_failure_at: Option<UtcDateTime>,
+    pub last_failure: Option<String>,
+}
+
+impl UploadMetrics {
+    pub fn record_completed(&self) {
+        self.uploads_completed.fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn record_failed(&self, error: &str) {
+        self.uploads_failed.fetch_add(1, Ordering::Relaxed);
+        if let Ok(mut last_failure) = self.last_failure.lock() {
+            *last_failure = Some((Utc::now(), error.to_string()));
+        }
+    }
+
+    pub fn record_spilled(&self) {
+        self.uploads_spilled.fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn record_recovered(&self) {
+        self.spilled_uploads_recovered
+            .fetch_add(1, Ordering::Relaxed);
+    }
+
+    pub fn snapshot(&self, spill: &SpillBuffer) -> UploadMetricsSnapshot {
+        let pending = spill.pending();
+        let (last_failure_at, last_failure) = self
+            .last_failure
+            .lock()
+            .ok()
+            .and_then(|last_failure| last_failure.clone())
+            .map(|(at, error)| (Some(at), Some(error)))
+            .unwrap_or_default();
+        UploadMetricsSnapshot {
+            uploads_completed: self.uploads_completed.load(Ordering::Relaxed),
+            uploads_failed: self.uploads_failed.load(Ordering::Relaxed),
+            requests_retried: self.requests_retried.load(Ordering::Relaxed),
+            requests_failed: self.requests_failed.load(Ordering::Relaxed),
+            uploads_spilled: self.uploads_spilled.load(Ordering::Relaxed),
+            spilled_uploads_recovered: self.spilled_uploads_recovered.load(Ordering::Relaxed),
+            spilled_pending: pending.len() as u64,
+            spill_buffer_bytes: spill.size_bytes(),
+            last_failure_at,
+            last_failure,
+        }
+    }
+}
+
+/// Run an S3 request, retrying failures with exponential backoff
+pub async fn with_retries<T, F, Fut>(
+    settings: &S3UploadSettings,
+    metrics: &UploadMetrics,
+    operation: &str,
+    mut request: F,
+) -> anyhow::Result<T>
+where
+    F: FnMut() -> Fut,
+    Fut: Future<Output = anyhow::Result<T>>,
+{
+    let mut retry = 0;
+    loop {
+        match request().await {
+            Ok(value) => return Ok(value),
+            Err(e) if retry < settings.max_retries => {
+                let delay = settings.retry_delay(retry);
+                warn!(
+                    "{} failed (attempt {} of {}), retrying in {:?}: {}",
+                    operation,
+                    retry + 1,
+                    settings.max_retries + 1,
+                    delay,
+                    e
+                );
+                metrics.requests_retried.fetch_add(1, Ordering::Relaxed);
+                tokio::time::sleep(delay).await;
+                retry += 1;
+            }
+            Err(e) => {
+                metrics.requests_failed.fetch_add(1, Ordering::Relaxed);
+                return Err(e);
+            }
+        }
+    }
+}
+
+/// A backup in the spill buffer, waiting to be uploaded
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SpilledUpload {
+    pub backup_id: String,
+    pub s3_source_id: i32,
+    pub s3_location: String,
+    pub spilled_at: UtcDateTime,
+}
+
+/// Backups kept on local disk until they can be uploaded
+///
+/// Each backup is stored as `<backup_id>.data` next to its `<backup_id>.json` manifest.
+#[derive(Debug, Clone)]
+pub struct SpillBuffer {
+    dir: PathBuf,
+}
+
+impl SpillBuffer {
+    pub fn new(dir: PathBuf) -> Self {
+        Self { dir }
+    }
+
+    /// Copy a backup into the buffer, unless it would grow past `max_bytes`
+    pub fn spill(&self, file: &Path, upload: &SpilledUpload, max_bytes: u64) -> Result<(), String> {
+        let size = std::fs::metadata(file)
+            .map_err(|e| format!("Failed to read backup file: {}", e))?
+            .len();
+        if self.size_bytes() + size > max_bytes {
+            return Err(format!(
+                "Spill buffer is full ({} of {} bytes used)",
+                self.size_bytes(),
+                max_bytes
+            ));
+        }
+        std::fs::create_dir_all(&self.dir)
+            .map_err(|e| format!("Failed to create spill buffer: {}", e))?;
+        std::fs::copy(file, self.data_path(&upload.backup_id))
+            .map_err(|e| format!("Failed to copy backup to spill buffer: {}", e))?;
+        let manifest = serde_json::to_vec(upload).map_err(|e| e.to_string())?;
+        std::fs::write(self.manifest_path(&upload.backup_id), manifest)
+            .map_err(|e| format!("Failed to write spill manifest: {}", e))?;
+        Ok(())
+    }
+
+    /// Backups waiting to be uploaded, oldest first
+    pub fn pending(&self) -> Vec<SpilledUpload> {
+        let Ok(entries) = std::fs::read_dir(&self.dir) else {
+            return Vec::new();
+        };
+        let mut pending: Vec<SpilledUpload> = entries
+            .filter_map(|entry| entry.ok())
+            .filter(|entry| entry.path().extension().is_some_and(|ext| ext == "json"))
+            .filter_map(|entry| std::fs::read(entry.path()).ok())
+            .filter_map(|manifest| serde_json::from_slice(&manifest).ok())
+            .filter(|upload: &SpilledUpload| self.data_path(&upload.backup_id).exists())
+            .collect();
+        pending.sort_by_key(|upload| upload.spilled_at);
+        pending
+    }
+
+    pub fn data_path(&self, backup_id: &str) -> PathBuf {
+        self.dir.join(format!("{}.data", backup_id))
+    }
+
+    fn manifest_path(&self, backup_id: &str) -> PathBuf {
+        self.dir.join(format!("{}.json", backup_id))
+    }
+
+    /// Drop an uploaded or abandoned backup from the buffer
+    pub fn remove(&self, backup_id: &str) {
+        let _ = std::fs::remove_file(self.data_path(backup_id));
+        let _ = std::fs::remove_file(self.manifest_path(backup_id));
+    }
+
+    pub fn size_bytes(&self) -> u64 {
+        std::fs::read_dir(&self.dir)
+            .map(|entries| {
+                entries
+                    .filter_map(|entry| entry.ok()?.metadata().ok())
+                    .map(|metadata| metadata.len())
+                    .sum()
+            })
+            .unwrap_or(0)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::atomic::AtomicU32;
+
+    fn fast_settings(max_retries: u32) -> S3UploadSettings {
+        S3UploadSettings {
+            max_retries,
+            retry_base_delay_ms: 1,
+            ..Default::default()
+        }
+    }
+
+    #[tokio::test]
+    async fn test_with_retries_recovers_from_transient_failures() {
+        let metrics = UploadMetrics::default();
+        let attempts = AtomicU32::new(0);
+
+        let result = with_retries(&fast_settings(3), &metrics, "upload", || async {
+            if attempts.fetch_add(1, Ordering::SeqCst) < 2 {
+                Err(anyhow::anyhow!("slow down"))
+            } else {
+                Ok(42)
+            }
+        })
+        .await;
+
+        assert_eq!(result.unwrap(), 42);
+        assert_eq!(attempts.load(Ordering::SeqCst), 3);
+        assert_eq!(metrics.requests_retried.load(Ordering::Relaxed), 2);
+        assert_eq!(metrics.requests_failed.load(Ordering::Relaxed), 0);
+    }
+
+    #[tokio::test]
+    async fn test_with_retries_gives_up() {
+        let metrics = UploadMetrics::default();
+        let attempts = AtomicU32::new(0);
+
+        let result: anyhow::Result<()> =
+            with_retries(&fast_settings(2), &metrics, "upload", || async {
+                attempts.fetch_add(1, Ordering::SeqCst);
+                Err(anyhow::anyhow!("unavailable"))
+            })
+            .await;
+
+        assert!(result.is_err());
+        assert_eq!(attempts.load(Ordering::SeqCst), 3);
+        assert_eq!(metrics.requests_failed.load(Ordering::Relaxed), 1);
+    }
+
+    #[test]
+    fn test_retry_delay_backs_off() {
+        let settings = S3UploadSettings::default();
+        assert_eq!(settings.retry_delay(0).as_millis(), 500);
+        assert_eq!(settings.retry_delay(2).as_millis(), 2000);
+        assert_eq!(settings.retry_delay(10).as_millis(), 30_000);
+    }
+
+    #[test]
+    fn test_spill_buffer_round_trip() {
+        let dir = tempfile::tempdir().unwrap();
+        let spill = SpillBuffer::new(dir.path().join("spill"));
+        let backup = tempfile::NamedTempFile::new().unwrap();
+        std::fs::write(backup.path(), vec![0u8; 100]).unwrap();
+        let upload = SpilledUpload {
+            backup_id: "b1".to_string(),
+            s3_source_id: 1,
+            s3_location: "backups/b1/backup.postgresql.gz".to_string(),
+            spilled_at: Utc::now(),
+        };
+
+        assert!(spill.pending().is_empty());
+        spill.spill(backup.path(), &upload, 1024).unwrap();
+        assert_eq!(spill.pending()[0].s3_location, upload.s3_location);
+        assert!(spill.size_bytes() >= 100);
+
+        let second = SpilledUpload {
+            backup_id: "b2".to_string(),
+            ..upload.clone()
+        };
+        assert!(spill.spill(backup.path(), &second, 150).is_err());
+
+        spill.remove("b1");
+        assert!(spill.pending().is_empty());
+        assert_eq!(spill.size_bytes(), 0);
+    }
+}
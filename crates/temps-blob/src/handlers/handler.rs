@@ -61,6 +61,7 @@ fn extract_project_id(
         blob_download,
         blob_copy,
         blob_status,
+        storage_health,
         blob_enable,
         blob_update,
         blob_disable,
@@ -74,6 +75,7 @@ fn extract_project_id(
             ListBlobsQuery,
             ListBlobsResponse,
             BlobStatusResponse,
+            StorageHealthResponse,
             EnableBlobRequest,
             EnableBlobResponse,
             UpdateBlobRequest,
@@ -103,6 +105,7 @@ pub fn configure_routes() -> Router<Arc<BlobAppState>> {
         .route("/blob/enable", post(blob_enable))
         .route("/blob/update", patch(blob_update))
         .route("/blob/disable", delete(blob_disable))
+        .route("/system/storage", get(storage_health))
 }
 
 /// Upload a blob
@@ -469,6 +472,39 @@ pub async fn blob_status(
     }
 }
 
+/// Get the health of the storage backend
+///
+/// Reports the latest health check of the managed storage and the uploads kept on
+/// local disk until it recovers. Pass `refresh=true` to check it now.
+#[utoipa::path(
+    tag = "Blob Management",
+    get,
+    path = "/system/storage",
+    params(
+        ("refresh" = Option<bool>, Query, description = "Check the storage now instead of reporting the latest check"),
+    ),
+    responses(
+        (status = 200, description = "Storage health", body = StorageHealthResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn storage_health(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<BlobAppState>>,
+    Query(query): Query<StorageHealthQuery>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemRead);
+
+    let health = if query.refresh {
+        state.health_monitor.check().await
+    } else {
+        state.health_monitor.health().await
+    };
+    Ok(Json(StorageHealthResponse::from(health)))
+}
+
 /// Enable Blob service
 #[utoipa::path(
     tag = "Blob Management",
@@ -9,7 +9,7 @@ use temps_providers::externalsvc::RustfsService;
 use temps_providers::ExternalServiceManager;
 use utoipa::ToSchema;
 
-use crate::services::{BlobInfo, BlobService, ListResult};
+use crate::services::{BlobInfo, BlobService, ListResult, StorageHealth, StorageHealthMonitor};
 
 /// Application state for blob handlers
 pub struct BlobAppState {
@@ -17,6 +17,7 @@ pub struct BlobAppState {
     pub rustfs_service: Arc<RustfsService>,
     pub external_service_manager: Arc<ExternalServiceManager>,
     pub audit_service: Arc<dyn AuditLogger>,
+    pub health_monitor: Arc<StorageHealthMonitor>,
 }
 
 /// Options for uploading a blob
@@ -238,3 +239,55 @@ pub struct UpdateBlobResponse {
     /// Current status
     pub status: BlobStatusResponse,
 }
+
+/// Query parameters for the storage health
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct StorageHealthQuery {
+    /// Check the storage now instead of reporting the latest check
+    #[serde(default)]
+    pub refresh: bool,
+}
+
+/// Health of the managed storage backend
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct StorageHealthResponse {
+    /// disabled, healthy, degraded or unavailable
+    #[schema(example = "healthy")]
+    pub state: String,
+    /// When the storage was last checked
+    pub checked_at: Option<DateTime<Utc>>,
+    /// When the storage last answered a check
+    pub last_healthy_at: Option<DateTime<Utc>>,
+    /// How long the last successful check took
+    #[schema(example = 12)]
+    pub latency_ms: Option<u64>,
+    /// Failed checks in a row
+    #[schema(example = 0)]
+    pub consecutive_failures: u32,
+    /// Error of the last failed check
+    pub last_error: Option<String>,
+    /// Uploads kept on local disk until the storage recovers
+    #[schema(example = 0)]
+    pub buffered_blobs: usize,
+    #[schema(example = 0)]
+    pub buffered_bytes: u64,
+    /// Restarts of the storage container since it became unavailable
+    #[schema(example = 0)]
+    pub heal_attempts: u32,
+}
+
+impl From<StorageHealth> for StorageHealthResponse {
+    fn from(health: StorageHealth) -> Self {
+        Self {
+            state: health.state.as_str().to_string(),
+            checked_at: health.checked_at,
+            last_healthy_at: health.last_healthy_at,
+            latency_ms: health.latency_ms,
+            consecutive_failures: health.consecutive_failures,
+            last_error: health.last_error,
+            buffered_blobs: health.buffered_blobs,
+            buffered_bytes: health.buffered_bytes,
+            heal_attempts: health.heal_attempts,
+        }
+    }
+}
@@ -2,6 +2,8 @@
 //!
 //! Provides S3-compatible blob storage with project isolation.
 //! Uses RustFS (S3-compatible storage) for high-performance object storage.
+//! The storage is health-checked in the background; uploads it can't take are kept
+//! in a local buffer and uploaded once it recovers.
 
 pub mod error;
 pub mod handlers;
@@ -15,11 +15,14 @@ use utoipa::openapi::OpenApi;
 use utoipa::OpenApi as OpenApiTrait;
 
 use crate::handlers::{configure_routes, BlobApiDoc, BlobAppState};
-use crate::services::BlobService;
+use crate::services::{BlobService, LocalBuffer, StorageHealthMonitor, DEFAULT_BUFFER_MAX_BYTES};
 
 /// RustFS service name used for the Blob plugin
 const BLOB_RUSTFS_SERVICE_NAME: &str = "temps-blob";
 
+/// Directory under the data directory where uploads wait while storage is down
+const BLOB_BUFFER_DIR_NAME: &str = "blob-buffer";
+
 /// Blob Plugin for file storage operations
 pub struct BlobPlugin;
 
@@ -58,12 +61,41 @@ impl TempsPlugin for BlobPlugin {
                 encryption_service,
             ));
 
-            // Create BlobService that uses RustfsService
-            let blob_service = Arc::new(BlobService::new(rustfs_service.clone()));
+            // Create BlobService that uses RustfsService, keeping uploads on local
+            // disk while the storage can't take them
+            let config_service = context.require_service::<temps_config::ConfigService>();
+            let buffer = LocalBuffer::new(
+                config_service.data_dir().join(BLOB_BUFFER_DIR_NAME),
+                DEFAULT_BUFFER_MAX_BYTES,
+            );
+            let blob_service =
+                Arc::new(BlobService::new(rustfs_service.clone()).with_buffer(buffer));
+
+            // Watch the storage, restart it when it stops answering and upload the
+            // buffer once it recovers
+            let mut health_monitor = StorageHealthMonitor::new(
+                blob_service.clone(),
+                rustfs_service.clone(),
+                context.require_service::<ExternalServiceManager>(),
+            );
+            if let Some(notification_service) =
+                context.get_service::<dyn temps_core::notifications::NotificationService>()
+            {
+                health_monitor = health_monitor.with_notification_service(notification_service);
+            }
+            let health_monitor = Arc::new(health_monitor);
+            tokio::spawn({
+                let health_monitor = health_monitor.clone();
+                async move {
+                    debug!("Starting storage health monitor");
+                    health_monitor.start_monitoring().await;
+                }
+            });
 
             // Register services
             context.register_service(rustfs_service);
             context.register_service(blob_service);
+            context.register_service(health_monitor);
 
             debug!("Blob plugin services registered successfully");
             Ok(())
@@ -161,6 +193,7 @@ impl TempsPlugin for BlobPlugin {
         let rustfs_service = context.require_service::<RustfsService>();
         let external_service_manager = context.require_service::<ExternalServiceManager>();
         let audit_service = context.require_service::<dyn AuditLogger>();
+        let health_monitor = context.require_service::<StorageHealthMonitor>();
 
         // Create app state
         let app_state = Arc::new(BlobAppState {
@@ -168,6 +201,7 @@ impl TempsPlugin for BlobPlugin {
             rustfs_service,
             external_service_manager,
             audit_service,
+            health_monitor,
         });
 
         // Configure routes with state
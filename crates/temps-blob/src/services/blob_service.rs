@@ -1,22 +1,27 @@
 //! Blob Service implementation with RustFS/S3 backend
 
 use std::sync::Arc;
+use std::time::{Duration, Instant};
 
 use aws_sdk_s3::primitives::ByteStream;
 use aws_sdk_s3::Client;
 use bytes::Bytes;
 use chrono::{DateTime, Utc};
-use futures::Stream;
+use futures::{Stream, StreamExt};
 use temps_providers::externalsvc::RustfsService;
 use tokio::sync::OnceCell;
-use tracing::{debug, info};
+use tracing::{debug, info, warn};
 use uuid::Uuid;
 
+use super::buffer::{BufferedBlob, LocalBuffer};
 use crate::error::BlobError;
 
 /// Default bucket name for blobs
 pub const DEFAULT_BUCKET: &str = "temps-blobs";
 
+/// How long the storage has to answer a health check
+const HEALTH_CHECK_TIMEOUT: Duration = Duration::from_secs(10);
+
 /// Options for PUT operations
 #[derive(Debug, Clone, Default)]
 pub struct PutOptions {
@@ -68,6 +73,8 @@ pub struct BlobService {
     rustfs_service: Arc<RustfsService>,
     /// Track whether we've ensured the bucket exists
     bucket_initialized: OnceCell<()>,
+    /// Holds uploads the storage can't take until it recovers
+    buffer: Option<LocalBuffer>,
 }
 
 impl BlobService {
@@ -76,9 +83,16 @@ impl BlobService {
         Self {
             rustfs_service,
             bucket_initialized: OnceCell::new(),
+            buffer: None,
         }
     }
 
+    /// Keep uploads in a local buffer while the storage can't take them
+    pub fn with_buffer(mut self, buffer: LocalBuffer) -> Self {
+        self.buffer = Some(buffer);
+        self
+    }
+
     /// Ensure the default bucket exists, creating it if necessary
     async fn ensure_bucket_exists(&self, client: &Client) -> Result<(), BlobError> {
         // Only run once per service instance
@@ -154,7 +168,50 @@ impl BlobService {
         }
     }
 
+    /// Write an object to the bucket
+    async fn upload(
+        &self,
+        client: &Client,
+        key: &str,
+        body: Bytes,
+        content_type: &str,
+    ) -> Result<(), BlobError> {
+        // Ensure bucket exists on first operation
+        self.ensure_bucket_exists(client).await?;
+
+        client
+            .put_object()
+            .bucket(DEFAULT_BUCKET)
+            .key(key)
+            .body(ByteStream::from(body))
+            .content_type(content_type)
+            .send()
+            .await
+            .map_err(|e| BlobError::UploadFailed(e.to_string()))?;
+        Ok(())
+    }
+
+    /// Latest version of a blob waiting in the local buffer
+    async fn buffered(
+        &self,
+        project_id: i32,
+        pathname: &str,
+    ) -> Result<Option<(&LocalBuffer, BufferedBlob)>, BlobError> {
+        let Some(buffer) = &self.buffer else {
+            return Ok(None);
+        };
+        let pathname = pathname.trim_start_matches('/');
+        Ok(buffer
+            .find(project_id, pathname)
+            .await?
+            .map(|blob| (buffer, blob)))
+    }
+
     /// Upload a blob
+    ///
+    /// When the storage fails to take it, the blob is kept in the local buffer (if one
+    /// is configured) and uploaded once the storage recovers. A blob with versions
+    /// still waiting in the buffer is buffered behind them, so they land in order.
     pub async fn put(
         &self,
         project_id: i32,
@@ -168,9 +225,6 @@ impl BlobService {
             .await
             .map_err(|e| BlobError::ConnectionFailed(e.to_string()))?;
 
-        // Ensure bucket exists on first operation
-        self.ensure_bucket_exists(&client).await?;
-
         // Generate final pathname with optional random suffix
         let final_pathname = if options.add_random_suffix {
             add_random_suffix(pathname)
@@ -187,15 +241,32 @@ impl BlobService {
 
         debug!("PUT {} ({} bytes, {})", key, size, content_type);
 
-        client
-            .put_object()
-            .bucket(DEFAULT_BUCKET)
-            .key(&key)
-            .body(ByteStream::from(body))
-            .content_type(&content_type)
-            .send()
-            .await
-            .map_err(|e| BlobError::UploadFailed(e.to_string()))?;
+        let buffered_pathname = final_pathname.trim_start_matches('/');
+        match self.buffered(project_id, buffered_pathname).await? {
+            Some((buffer, _)) => {
+                debug!("{} has buffered versions waiting, buffering it too", key);
+                buffer
+                    .store(project_id, buffered_pathname, &content_type, &body)
+                    .await?;
+            }
+            None => {
+                if let Err(e) = self
+                    .upload(&client, &key, body.clone(), &content_type)
+                    .await
+                {
+                    let Some(buffer) = &self.buffer else {
+                        return Err(e);
+                    };
+                    warn!(
+                        "Storage failed to take {}, keeping it in the local buffer: {}",
+                        key, e
+                    );
+                    buffer
+                        .store(project_id, buffered_pathname, &content_type, &body)
+                        .await?;
+                }
+            }
+        }
 
         Ok(BlobInfo {
             url: self.blob_url(project_id, &final_pathname),
@@ -223,6 +294,11 @@ impl BlobService {
             let key = self.object_key(project_id, &pathname);
             debug!("DELETE {}", key);
 
+            // Versions waiting in the buffer would bring the blob back once flushed
+            while let Some((buffer, blob)) = self.buffered(project_id, &pathname).await? {
+                buffer.remove(&blob).await?;
+            }
+
             match client
                 .delete_object()
                 .bucket(DEFAULT_BUCKET)
@@ -243,6 +319,16 @@ impl BlobService {
 
     /// Get blob metadata
     pub async fn head(&self, project_id: i32, pathname: &str) -> Result<BlobInfo, BlobError> {
+        if let Some((_, blob)) = self.buffered(project_id, pathname).await? {
+            return Ok(BlobInfo {
+                url: self.blob_url(project_id, &blob.pathname),
+                pathname: pathname.to_string(),
+                content_type: blob.content_type,
+                size: blob.size,
+                uploaded_at: blob.buffered_at,
+            });
+        }
+
         let client = self
             .rustfs_service
             .get_connection()
@@ -369,6 +455,13 @@ impl BlobService {
         ),
         BlobError,
     > {
+        if let Some((buffer, blob)) = self.buffered(project_id, pathname).await? {
+            let body = buffer.read(&blob).await?;
+            debug!("GET {} from the local buffer", blob.id);
+            let stream = futures::stream::once(async move { Ok::<_, std::io::Error>(body) });
+            return Ok((stream.left_stream(), blob.content_type, blob.size));
+        }
+
         let client = self
             .rustfs_service
             .get_connection()
@@ -397,7 +490,7 @@ impl BlobService {
         let stream = response.body.into_async_read();
         let reader_stream = tokio_util::io::ReaderStream::new(stream);
 
-        Ok((reader_stream, content_type, size))
+        Ok((reader_stream.right_stream(), content_type, size))
     }
 
     /// Copy a blob to a new location within the same project
@@ -452,6 +545,72 @@ impl BlobService {
             uploaded_at: Utc::now(),
         })
     }
+
+    /// Probe the storage, returning how long it took to answer
+    pub async fn check_health(&self) -> Result<Duration, BlobError> {
+        let client = self
+            .rustfs_service
+            .get_connection()
+            .await
+            .map_err(|e| BlobError::ConnectionFailed(e.to_string()))?;
+
+        let started = Instant::now();
+        tokio::time::timeout(HEALTH_CHECK_TIMEOUT, async {
+            self.ensure_bucket_exists(&client).await?;
+            client
+                .head_bucket()
+                .bucket(DEFAULT_BUCKET)
+                .send()
+                .await
+                .map_err(|e| BlobError::S3(e.to_string()))
+        })
+        .await
+        .map_err(|_| {
+            BlobError::ConnectionFailed(format!(
+                "Storage did not answer within {}s",
+                HEALTH_CHECK_TIMEOUT.as_secs()
+            ))
+        })??;
+        Ok(started.elapsed())
+    }
+
+    /// Number of blobs waiting in the local buffer and their total size in bytes
+    pub async fn buffer_stats(&self) -> Result<(usize, u64), BlobError> {
+        match &self.buffer {
+            Some(buffer) => buffer.stats().await,
+            None => Ok((0, 0)),
+        }
+    }
+
+    /// Upload the blobs waiting in the local buffer, oldest first
+    ///
+    /// Stops at the first blob the storage fails to take, leaving it and the blobs
+    /// after it for the next flush. Returns how many were uploaded.
+    pub async fn flush_buffer(&self) -> Result<usize, BlobError> {
+        let Some(buffer) = &self.buffer else {
+            return Ok(0);
+        };
+        let pending = buffer.pending().await?;
+        if pending.is_empty() {
+            return Ok(0);
+        }
+
+        let client = self
+            .rustfs_service
+            .get_connection()
+            .await
+            .map_err(|e| BlobError::ConnectionFailed(e.to_string()))?;
+        let mut flushed = 0;
+        for blob in pending {
+            let body = buffer.read(&blob).await?;
+            let key = self.object_key(blob.project_id, &blob.pathname);
+            self.upload(&client, &key, body, &blob.content_type).await?;
+            buffer.remove(&blob).await?;
+            flushed += 1;
+        }
+        info!("Uploaded {} blob(s) from the local buffer", flushed);
+        Ok(flushed)
+    }
 }
 
 /// Add a random suffix to a pathname before the extension
@@ -0,0 +1,243 @@
+//! Local fallback buffer for blob uploads
+//!
+//! When the storage backend can't take an upload, the blob is written to a directory
+//! on local disk instead of being lost, and uploaded once the storage recovers. Each
+//! blob is kept as two files: its content, and a JSON record of where it belongs that
+//! is written last, so a blob only counts as buffered once it is complete. Names
+//! start with the time the blob was buffered, which keeps uploads in order.
+
+use std::path::PathBuf;
+
+use bytes::Bytes;
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use tracing::{debug, warn};
+use uuid::Uuid;
+
+use crate::error::BlobError;
+
+/// Default limit on the size of the buffer
+pub const DEFAULT_BUFFER_MAX_BYTES: u64 = 1024 * 1024 * 1024;
+
+/// Extension of the record of a buffered blob
+const RECORD_EXTENSION: &str = "json";
+
+/// Extension of the content of a buffered blob
+const CONTENT_EXTENSION: &str = "blob";
+
+/// A blob waiting in the buffer to be uploaded
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BufferedBlob {
+    pub id: String,
+    pub project_id: i32,
+    pub pathname: String,
+    pub content_type: String,
+    pub size: i64,
+    pub buffered_at: DateTime<Utc>,
+}
+
+/// Blobs held on local disk until the storage backend takes them
+#[derive(Debug, Clone)]
+pub struct LocalBuffer {
+    dir: PathBuf,
+    max_bytes: u64,
+}
+
+impl LocalBuffer {
+    pub fn new(dir: PathBuf, max_bytes: u64) -> Self {
+        Self { dir, max_bytes }
+    }
+
+    fn record_path(&self, id: &str) -> PathBuf {
+        self.dir.join(format!("{}.{}", id, RECORD_EXTENSION))
+    }
+
+    fn content_path(&self, id: &str) -> PathBuf {
+        self.dir.join(format!("{}.{}", id, CONTENT_EXTENSION))
+    }
+
+    /// Keep a blob until it can be uploaded
+    ///
+    /// Fails when the buffer would grow past its limit, so the caller sees the upload
+    /// fail instead of the blob being dropped.
+    pub async fn store(
+        &self,
+        project_id: i32,
+        pathname: &str,
+        content_type: &str,
+        body: &Bytes,
+    ) -> Result<BufferedBlob, BlobError> {
+        let (_, buffered_bytes) = self.stats().await?;
+        if buffered_bytes + body.len() as u64 > self.max_bytes {
+            return Err(BlobError::UploadFailed(format!(
+                "Storage is unavailable and the local buffer is full ({} of {} bytes used)",
+                buffered_bytes, self.max_bytes
+            )));
+        }
+
+        tokio::fs::create_dir_all(&self.dir)
+            .await
+            .map_err(|e| buffer_error("create the buffer directory", e))?;
+
+        let now = Utc::now();
+        let blob = BufferedBlob {
+            id: format!("{:013}-{}", now.timestamp_millis(), Uuid::new_v4().simple()),
+            project_id,
+            pathname: pathname.to_string(),
+            content_type: content_type.to_string(),
+            size: body.len() as i64,
+            buffered_at: now,
+        };
+        tokio::fs::write(self.content_path(&blob.id), body)
+            .await
+            .map_err(|e| buffer_error("write the blob", e))?;
+        let record = serde_json::to_vec(&blob)
+            .map_err(|e| BlobError::Internal(format!("Failed to encode buffered blob: {}", e)))?;
+        tokio::fs::write(self.record_path(&blob.id), record)
+            .await
+            .map_err(|e| buffer_error("write the blob record", e))?;
+
+        debug!(
+            "Buffered {} for project {} ({} bytes) as {}",
+            blob.pathname, blob.project_id, blob.size, blob.id
+        );
+        Ok(blob)
+    }
+
+    /// Buffered blobs, oldest first
+    pub async fn pending(&self) -> Result<Vec<BufferedBlob>, BlobError> {
+        let mut entries = match tokio::fs::read_dir(&self.dir).await {
+            Ok(entries) => entries,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+            Err(e) => return Err(buffer_error("read the buffer directory", e)),
+        };
+
+        let mut blobs = Vec::new();
+        while let Some(entry) = entries
+            .next_entry()
+            .await
+            .map_err(|e| buffer_error("read the buffer directory", e))?
+        {
+            let path = entry.path();
+            if path.extension().and_then(|ext| ext.to_str()) != Some(RECORD_EXTENSION) {
+                continue;
+            }
+            let record = match tokio::fs::read(&path).await {
+                Ok(record) => record,
+                Err(e) => {
+                    warn!("Failed to read buffered blob record {:?}: {}", path, e);
+                    continue;
+                }
+            };
+            match serde_json::from_slice::<BufferedBlob>(&record) {
+                Ok(blob) => blobs.push(blob),
+                Err(e) => warn!("Skipping unreadable buffered blob record {:?}: {}", path, e),
+            }
+        }
+        blobs.sort_by(|a, b| a.id.cmp(&b.id));
+        Ok(blobs)
+    }
+
+    /// Latest buffered version of a blob, if it is waiting to be uploaded
+    pub async fn find(
+        &self,
+        project_id: i32,
+        pathname: &str,
+    ) -> Result<Option<BufferedBlob>, BlobError> {
+        Ok(self
+            .pending()
+            .await?
+            .into_iter()
+            .rev()
+            .find(|blob| blob.project_id == project_id && blob.pathname == pathname))
+    }
+
+    /// Content of a buffered blob
+    pub async fn read(&self, blob: &BufferedBlob) -> Result<Bytes, BlobError> {
+        tokio::fs::read(self.content_path(&blob.id))
+            .await
+            .map(Bytes::from)
+            .map_err(|e| buffer_error("read the blob", e))
+    }
+
+    /// Drop a blob from the buffer, once uploaded or deleted
+    pub async fn remove(&self, blob: &BufferedBlob) -> Result<(), BlobError> {
+        // The record goes first so a half-removed blob is no longer pending
+        for path in [self.record_path(&blob.id), self.content_path(&blob.id)] {
+            match tokio::fs::remove_file(&path).await {
+                Ok(()) => {}
+                Err(e) if e.kind() == std::io::ErrorKind::NotFound => {}
+                Err(e) => return Err(buffer_error("remove the blob", e)),
+            }
+        }
+        Ok(())
+    }
+
+    /// Number of buffered blobs and their total size in bytes
+    pub async fn stats(&self) -> Result<(usize, u64), BlobError> {
+        let blobs = self.pending().await?;
+        let bytes = blobs.iter().map(|blob| blob.size.max(0) as u64).sum();
+        Ok((blobs.len(), bytes))
+    }
+}
+
+fn buffer_error(action: &str, error: std::io::Error) -> BlobError {
+    BlobError::Internal(format!(
+        "Failed to {} in the local buffer: {}",
+        action, error
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_buffered_blobs_are_kept_in_order() {
+        let dir = tempfile::tempdir().unwrap();
+        let buffer = LocalBuffer::new(dir.path().join("buffer"), DEFAULT_BUFFER_MAX_BYTES);
+        assert!(buffer.pending().await.unwrap().is_empty());
+
+        let first = buffer
+            .store(1, "logs/a.txt", "text/plain", &Bytes::from("first"))
+            .await
+            .unwrap();
+        tokio::time::sleep(std::time::Duration::from_millis(2)).await;
+        let second = buffer
+            .store(1, "logs/a.txt", "text/plain", &Bytes::from("second"))
+            .await
+            .unwrap();
+
+        let pending = buffer.pending().await.unwrap();
+        assert_eq!(
+            pending.iter().map(|blob| &blob.id).collect::<Vec<_>>(),
+            vec![&first.id, &second.id]
+        );
+        assert_eq!(buffer.stats().await.unwrap(), (2, 11));
+
+        // The latest version of a pathname is the one read back
+        let latest = buffer.find(1, "logs/a.txt").await.unwrap().unwrap();
+        assert_eq!(buffer.read(&latest).await.unwrap(), Bytes::from("second"));
+        assert!(buffer.find(2, "logs/a.txt").await.unwrap().is_none());
+
+        buffer.remove(&first).await.unwrap();
+        buffer.remove(&second).await.unwrap();
+        assert_eq!(buffer.stats().await.unwrap(), (0, 0));
+    }
+
+    #[tokio::test]
+    async fn test_full_buffer_refuses_blobs() {
+        let dir = tempfile::tempdir().unwrap();
+        let buffer = LocalBuffer::new(dir.path().to_path_buf(), 8);
+
+        buffer
+            .store(1, "a", "text/plain", &Bytes::from("12345"))
+            .await
+            .unwrap();
+        let result = buffer
+            .store(1, "b", "text/plain", &Bytes::from("6789"))
+            .await;
+        assert!(matches!(result, Err(BlobError::UploadFailed(_))));
+        assert_eq!(buffer.stats().await.unwrap(), (1, 5));
+    }
+}
@@ -0,0 +1,365 @@
+//! Storage health monitoring
+//!
+//! Probes the managed storage backend on an interval, so a failing backend is
+//! noticed instead of uploads silently going missing. Slow answers and the first
+//! failures count as degraded; after several failures in a row the storage is
+//! unavailable, an alert is sent and the storage container is restarted. Once the
+//! storage answers again the blobs kept in the local buffer meanwhile are uploaded,
+//! and a recovery notice follows the alert.
+
+use std::sync::Arc;
+use std::time::Duration;
+
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+use temps_core::notifications::{
+    NotificationData, NotificationPriority, NotificationService, NotificationType,
+};
+use temps_providers::externalsvc::{ExternalService, RustfsService};
+use temps_providers::ExternalServiceManager;
+use tokio::sync::RwLock;
+use tracing::{debug, error, info, warn};
+
+use super::BlobService;
+
+/// How often the storage is probed
+pub const STORAGE_CHECK_INTERVAL: Duration = Duration::from_secs(30);
+
+/// Answers slower than this count as degraded
+pub const SLOW_RESPONSE_THRESHOLD: Duration = Duration::from_secs(2);
+
+/// Failures in a row after which the storage is unavailable
+pub const UNAVAILABLE_AFTER_FAILURES: u32 = 3;
+
+/// Failing checks between attempts to restart the storage container
+const HEAL_EVERY_FAILURES: u32 = 10;
+
+/// Name of the storage service the monitor watches
+const STORAGE_SERVICE_NAME: &str = "temps-blob";
+
+/// Health of the storage backend
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum StorageState {
+    /// The storage service is not enabled
+    Disabled,
+    Healthy,
+    /// Answering slowly, or failing for a short while
+    Degraded,
+    /// Failing for several checks in a row
+    Unavailable,
+}
+
+impl StorageState {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            StorageState::Disabled => "disabled",
+            StorageState::Healthy => "healthy",
+            StorageState::Degraded => "degraded",
+            StorageState::Unavailable => "unavailable",
+        }
+    }
+
+    /// State after a check, from the failures in a row and how fast the last
+    /// successful check was answered
+    pub fn classify(consecutive_failures: u32, latency: Option<Duration>) -> Self {
+        if consecutive_failures >= UNAVAILABLE_AFTER_FAILURES {
+            StorageState::Unavailable
+        } else if consecutive_failures > 0
+            || latency.is_some_and(|latency| latency > SLOW_RESPONSE_THRESHOLD)
+        {
+            StorageState::Degraded
+        } else {
+            StorageState::Healthy
+        }
+    }
+}
+
+/// Outcome of the latest storage checks
+#[derive(Debug, Clone, Serialize)]
+pub struct StorageHealth {
+    pub state: StorageState,
+    pub checked_at: Option<DateTime<Utc>>,
+    pub last_healthy_at: Option<DateTime<Utc>>,
+    pub latency_ms: Option<u64>,
+    pub consecutive_failures: u32,
+    pub last_error: Option<String>,
+    /// Blobs waiting in the local buffer for the storage to recover
+    pub buffered_blobs: usize,
+    pub buffered_bytes: u64,
+    /// Restarts of the storage container since it became unavailable
+    pub heal_attempts: u32,
+}
+
+impl Default for StorageHealth {
+    fn default() -> Self {
+        Self {
+            state: StorageState::Disabled,
+            checked_at: None,
+            last_healthy_at: None,
+            latency_ms: None,
+            consecutive_failures: 0,
+            last_error: None,
+            buffered_blobs: 0,
+            buffered_bytes: 0,
+            heal_attempts: 0,
+        }
+    }
+}
+
+/// Watches the storage backend and heals it when it stops answering
+pub struct StorageHealthMonitor {
+    blob_service: Arc<BlobService>,
+    rustfs_service: Arc<RustfsService>,
+    external_service_manager: Arc<ExternalServiceManager>,
+    notification_service: Option<Arc<dyn NotificationService>>,
+    health: RwLock<StorageHealth>,
+    /// Whether an outage alert was sent that still needs its recovery notice
+    alerted: RwLock<bool>,
+}
+
+impl StorageHealthMonitor {
+    pub fn new(
+        blob_service: Arc<BlobService>,
+        rustfs_service: Arc<RustfsService>,
+        external_service_manager: Arc<ExternalServiceManager>,
+    ) -> Self {
+        Self {
+            blob_service,
+            rustfs_service,
+            external_service_manager,
+            notification_service: None,
+            health: RwLock::new(StorageHealth::default()),
+            alerted: RwLock::new(false),
+        }
+    }
+
+    /// Also send outage alerts through the notification providers
+    pub fn with_notification_service(
+        mut self,
+        notification_service: Arc<dyn NotificationService>,
+    ) -> Self {
+        self.notification_service = Some(notification_service);
+        self
+    }
+
+    /// Health as of the latest check, with the current contents of the buffer
+    pub async fn health(&self) -> StorageHealth {
+        let mut health = self.health.read().await.clone();
+        match self.blob_service.buffer_stats().await {
+            Ok((blobs, bytes)) => {
+                health.buffered_blobs = blobs;
+                health.buffered_bytes = bytes;
+            }
+            Err(e) => warn!("Failed to read the blob buffer: {}", e),
+        }
+        health
+    }
+
+    /// Whether the storage service is enabled; a stopped service is left alone
+    async fn storage_enabled(&self) -> bool {
+        match self
+            .external_service_manager
+            .get_service_by_name(STORAGE_SERVICE_NAME)
+            .await
+        {
+            Ok(service) => service.status != "stopped",
+            Err(_) => false,
+        }
+    }
+
+    /// Probe the storage once and act on the result
+    pub async fn check(&self) -> StorageHealth {
+        let now = Utc::now();
+        if !self.storage_enabled().await {
+            *self.health.write().await = StorageHealth {
+                checked_at: Some(now),
+                ..Default::default()
+            };
+            *self.alerted.write().await = false;
+            return self.health().await;
+        }
+
+        let result = self.blob_service.check_health().await;
+        let previous = self.health.read().await.clone();
+        let mut health = previous.clone();
+        health.checked_at = Some(now);
+        match &result {
+            Ok(latency) => {
+                health.consecutive_failures = 0;
+                health.latency_ms = Some(latency.as_millis() as u64);
+                health.last_error = None;
+                health.heal_attempts = 0;
+                health.last_healthy_at = Some(now);
+            }
+            Err(e) => {
+                health.consecutive_failures += 1;
+                health.latency_ms = None;
+                health.last_error = Some(e.to_string());
+            }
+        }
+        health.state =
+            StorageState::classify(health.consecutive_failures, result.as_ref().ok().copied());
+        *self.health.write().await = health.clone();
+
+        if health.state != previous.state {
+            info!(
+                "Storage is now {:?} (was {:?})",
+                health.state, previous.state
+            );
+        }
+
+        match health.state {
+            StorageState::Unavailable => {
+                if previous.state != StorageState::Unavailable {
+                    self.alert_unavailable(&health).await;
+                }
+                if (health.consecutive_failures - UNAVAILABLE_AFTER_FAILURES) % HEAL_EVERY_FAILURES
+                    == 0
+                {
+                    self.heal().await;
+                }
+            }
+            StorageState::Healthy | StorageState::Degraded if result.is_ok() => {
+                self.flush().await;
+                if *self.alerted.read().await {
+                    self.notify_recovered(&previous).await;
+                }
+            }
+            _ => {}
+        }
+
+        self.health().await
+    }
+
+    /// Restart the storage container
+    async fn heal(&self) {
+        self.health.write().await.heal_attempts += 1;
+        warn!("Storage is unavailable, restarting its container");
+        if let Err(e) = self.rustfs_service.start().await {
+            // Starting a container that is running (but hung) fails; stop it first
+            debug!("Failed to start the storage container: {}", e);
+            if let Err(e) = self.rustfs_service.stop().await {
+                warn!("Failed to stop the storage container: {}", e);
+            }
+            if let Err(e) = self.rustfs_service.start().await {
+                error!("Failed to restart the storage container: {}", e);
+            }
+        }
+    }
+
+    /// Upload what the buffer held while the storage was failing
+    async fn flush(&self) {
+        match self.blob_service.flush_buffer().await {
+            Ok(0) => {}
+            Ok(flushed) => info!("Storage recovered, uploaded {} buffered blob(s)", flushed),
+            Err(e) => warn!("Failed to upload buffered blobs: {}", e),
+        }
+    }
+
+    async fn alert_unavailable(&self, health: &StorageHealth) {
+        error!(
+            "Storage is unavailable after {} failed checks: {}",
+            health.consecutive_failures,
+            health.last_error.as_deref().unwrap_or("unknown error")
+        );
+        let sent = self
+            .notify(
+                "Storage unavailable".to_string(),
+                format!(
+                    "The managed storage has failed {} health checks in a row: {}. \
+                    Uploads are kept on local disk until it recovers, and its \
+                    container is being restarted.",
+                    health.consecutive_failures,
+                    health.last_error.as_deref().unwrap_or("unknown error")
+                ),
+                NotificationType::Alert,
+                NotificationPriority::Critical,
+            )
+            .await;
+        *self.alerted.write().await = sent;
+    }
+
+    async fn notify_recovered(&self, previous: &StorageHealth) {
+        let outage = previous
+            .last_healthy_at
+            .map(|last_healthy_at| (Utc::now() - last_healthy_at).num_seconds().max(0))
+            .unwrap_or(0);
+        self.notify(
+            "Storage recovered".to_string(),
+            format!(
+                "The managed storage is answering again after about {} seconds. \
+                Uploads kept on local disk meanwhile have been uploaded.",
+                outage
+            ),
+            NotificationType::Info,
+            NotificationPriority::Normal,
+        )
+        .await;
+        *self.alerted.write().await = false;
+    }
+
+    /// Send a notification, returning whether it went out
+    async fn notify(
+        &self,
+        title: String,
+        message: String,
+        notification_type: NotificationType,
+        priority: NotificationPriority,
+    ) -> bool {
+        let Some(notification_service) = &self.notification_service else {
+            return false;
+        };
+        let notification = NotificationData {
+            title,
+            message,
+            notification_type,
+            priority,
+            metadata: [("service".to_string(), STORAGE_SERVICE_NAME.to_string())]
+                .into_iter()
+                .collect(),
+            bypass_throttling: true,
+            ..Default::default()
+        };
+        match notification_service.send_notification(notification).await {
+            Ok(()) => true,
+            Err(e) => {
+                error!("Failed to send storage health notification: {}", e);
+                false
+            }
+        }
+    }
+
+    /// Probe the storage on an interval, forever
+    pub async fn start_monitoring(self: Arc<Self>) {
+        info!("Starting storage health monitoring");
+        loop {
+            let health = self.check().await;
+            debug!(
+                "Storage check: {:?}, {} blob(s) buffered",
+                health.state, health.buffered_blobs
+            );
+            tokio::time::sleep(STORAGE_CHECK_INTERVAL).await;
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_classify_storage_state() {
+        let fast = Some(Duration::from_millis(40));
+        assert_eq!(StorageState::classify(0, fast), StorageState::Healthy);
+        assert_eq!(
+            StorageState::classify(0, Some(Duration::from_secs(5))),
+            StorageState::Degraded
+        );
+        assert_eq!(StorageState::classify(1, None), StorageState::Degraded);
+        assert_eq!(
+            StorageState::classify(UNAVAILABLE_AFTER_FAILURES, None),
+            StorageState::Unavailable
+        );
+    }
+}
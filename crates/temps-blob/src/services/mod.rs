@@ -1,9 +1,16 @@
 //! Blob Service implementation
 
 mod blob_service;
+mod buffer;
 mod config;
+mod health;
 
 pub use blob_service::{
     BlobInfo, BlobService, ListOptions, ListResult, PutOptions, DEFAULT_BUCKET,
 };
+pub use buffer::{BufferedBlob, LocalBuffer, DEFAULT_BUFFER_MAX_BYTES};
 pub use config::{BlobConfig, BlobInputConfig};
+pub use health::{
+    StorageHealth, StorageHealthMonitor, StorageState, SLOW_RESPONSE_THRESHOLD,
+    STORAGE_CHECK_INTERVAL, UNAVAILABLE_AFTER_FAILURES,
+};
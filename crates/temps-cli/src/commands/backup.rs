@@ -47,6 +47,11 @@ struct ExternalServiceBackup {
     size_bytes: Option<i64>,
     #[serde(rename = "type")]
     backup_type: String,
+    /// SHA-256 of the stored backup; absent for backups taken before it was recorded
+    #[serde(default)]
+    checksum: Option<String>,
+    #[serde(default)]
+    compression_type: Option<String>,
     metadata: ExternalServiceMetadata,
 }
 
@@ -70,6 +75,8 @@ enum BackupCommands {
     Restore(RestoreBackupArgs),
     /// Restore a specific external service from a backup
     RestoreService(RestoreServiceArgs),
+    /// Download a specific external service's backup to a local file
+    DownloadService(DownloadServiceArgs),
 }
 
 #[derive(Args)]
@@ -189,12 +196,60 @@ struct RestoreServiceArgs {
     force_path_style: bool,
 }
 
+#[derive(Args)]
+struct DownloadServiceArgs {
+    /// S3 access key ID
+    #[arg(long, env = "S3_ACCESS_KEY_ID")]
+    access_key_id: String,
+
+    /// S3 secret access key
+    #[arg(long, env = "S3_SECRET_ACCESS_KEY")]
+    secret_access_key: String,
+
+    /// S3 bucket name
+    #[arg(long, env = "S3_BUCKET_NAME")]
+    bucket_name: String,
+
+    /// S3 bucket path/prefix (optional)
+    #[arg(long, env = "S3_BUCKET_PATH", default_value = "backups")]
+    bucket_path: String,
+
+    /// Backup ID (UUID) from index.json
+    #[arg(long)]
+    backup_id: String,
+
+    /// Service name to download the backup of (e.g., "postgres-heex")
+    #[arg(long)]
+    service_name: String,
+
+    /// File to write the backup to (defaults to the backup's file name)
+    #[arg(long)]
+    output: Option<PathBuf>,
+
+    /// Decompress a gzip backup while downloading
+    #[arg(long)]
+    decompress: bool,
+
+    /// S3 region
+    #[arg(long, env = "S3_REGION", default_value = "us-east-1")]
+    region: String,
+
+    /// S3 endpoint URL (for MinIO/custom S3)
+    #[arg(long, env = "S3_ENDPOINT")]
+    endpoint: Option<String>,
+
+    /// Force path style (needed for MinIO)
+    #[arg(long, env = "S3_FORCE_PATH_STYLE", default_value = "true")]
+    force_path_style: bool,
+}
+
 impl BackupCommand {
     pub fn execute(self) -> anyhow::Result<()> {
         match self.command {
             BackupCommands::List(args) => Self::execute_list(args),
             BackupCommands::Restore(args) => Self::execute_restore(args),
             BackupCommands::RestoreService(args) => Self::execute_restore_service(args),
+            BackupCommands::DownloadService(args) => Self::execute_download_service(args),
         }
     }
 
@@ -864,7 +919,7 @@ impl BackupCommand {
         ext_backup: &ExternalServiceBackup,
         decrypted_config: &str,
     ) -> anyhow::Result<()> {
-        use temps_providers::externalsvc::{ServiceConfig, ServiceType};
+        use temps_providers::externalsvc::{ServiceConfig, ServiceType, TransferProgress};
 
         println!(
             "  {} {}",
@@ -919,15 +974,87 @@ impl BackupCommand {
 
         // Call the trait's restore_from_s3 method
         let backup_location = ext_backup.s3_location.trim_start_matches('/');
-        service
-            .restore_from_s3(s3_client, backup_location, &s3_source, service_config)
-            .await
-            .map_err(|e| anyhow::anyhow!("Failed to restore service: {}", e))?;
+        let progress = TransferProgress::new();
+        let progress_bar = Self::show_progress(&progress);
+        let restored = service
+            .restore_from_s3(
+                s3_client,
+                backup_location,
+                &s3_source,
+                service_config,
+                &progress,
+            )
+            .await;
+        match &restored {
+            Ok(()) => progress.complete(),
+            Err(e) => progress.fail(e.to_string()),
+        }
+        let _ = progress_bar.await;
+        restored.map_err(|e| anyhow::anyhow!("Failed to restore service: {}", e))?;
 
         println!("  {} Service restored successfully", "✓".bright_green());
         Ok(())
     }
 
+    /// Show the phase of a restore and the bytes it moved while it runs: a bar for
+    /// phases of a known size, a spinner for the others
+    fn show_progress(
+        progress: &temps_providers::externalsvc::TransferProgress,
+    ) -> tokio::task::JoinHandle<()> {
+        use indicatif::{ProgressBar, ProgressStyle};
+        use temps_providers::externalsvc::TransferPhase;
+
+        let mut receiver = progress.subscribe();
+        tokio::spawn(async move {
+            let bar_style = ProgressStyle::with_template(
+                "    {msg:<28} [{bar:30.cyan/blue}] {bytes}/{total_bytes}",
+            )
+            .unwrap()
+            .progress_chars("=> ");
+            let spinner_style =
+                ProgressStyle::with_template("    {spinner} {msg:<26} {bytes}").unwrap();
+            let bar = ProgressBar::new_spinner();
+            bar.enable_steady_tick(std::time::Duration::from_millis(120));
+            let mut shown = None;
+
+            loop {
+                let snapshot = receiver.borrow_and_update().clone();
+                if snapshot.is_finished() {
+                    break;
+                }
+                if let Some(phase) = snapshot.phase {
+                    if shown != Some((phase, snapshot.total_bytes)) {
+                        shown = Some((phase, snapshot.total_bytes));
+                        match snapshot.total_bytes {
+                            Some(total) => {
+                                bar.set_style(bar_style.clone());
+                                bar.set_length(total);
+                            }
+                            None => bar.set_style(spinner_style.clone()),
+                        }
+                    }
+                    let label = match phase {
+                        TransferPhase::Dump => "Dumping",
+                        TransferPhase::Compress => "Compressing",
+                        TransferPhase::Upload => "Uploading",
+                        TransferPhase::Download => "Downloading",
+                        TransferPhase::Decompress => "Decompressing",
+                        TransferPhase::Replay => "Replaying",
+                    };
+                    bar.set_message(match snapshot.eta_seconds {
+                        Some(eta) => format!("{} (eta {}s)", label, eta),
+                        None => label.to_string(),
+                    });
+                    bar.set_position(snapshot.bytes_transferred);
+                }
+                if receiver.changed().await.is_err() {
+                    break;
+                }
+            }
+            bar.finish_and_clear();
+        })
+    }
+
     fn execute_restore_service(args: RestoreServiceArgs) -> anyhow::Result<()> {
         info!(
             "Restoring external service from backup: {}",
@@ -1151,4 +1278,180 @@ impl BackupCommand {
 
         Ok(())
     }
+
+    /// Read the metadata.json of a backup listed in the bucket's index.json
+    async fn load_backup_metadata(
+        s3_client: &aws_sdk_s3::Client,
+        bucket_name: &str,
+        bucket_path: &str,
+        backup_id: &str,
+    ) -> anyhow::Result<BackupMetadata> {
+        let index_key = if bucket_path.is_empty() {
+            "index.json".to_string()
+        } else {
+            format!("{}/index.json", bucket_path.trim_matches('/'))
+        };
+        let index_data = s3_client
+            .get_object()
+            .bucket(bucket_name)
+            .key(&index_key)
+            .send()
+            .await
+            .map_err(|e| anyhow::anyhow!("Failed to download index.json from S3: {}", e))?
+            .body
+            .collect()
+            .await
+            .map_err(|e| anyhow::anyhow!("Failed to read index.json data: {}", e))?
+            .into_bytes();
+        let index: BackupIndex = serde_json::from_slice(&index_data)
+            .map_err(|e| anyhow::anyhow!("Failed to parse index.json: {}", e))?;
+
+        let backup = index
+            .backups
+            .iter()
+            .find(|b| b.backup_id == backup_id)
+            .ok_or_else(|| {
+                anyhow::anyhow!("Backup with ID '{}' not found in index.json", backup_id)
+            })?;
+        let metadata_key = backup
+            .metadata_location
+            .trim_start_matches('/')
+            .replace("backup.postgresql.gz", "metadata.json");
+        let metadata_data = s3_client
+            .get_object()
+            .bucket(bucket_name)
+            .key(&metadata_key)
+            .send()
+            .await
+            .map_err(|e| {
+                anyhow::anyhow!(
+                    "Failed to download metadata.json from S3 with key {}: {}",
+                    metadata_key,
+                    e
+                )
+            })?
+            .body
+            .collect()
+            .await
+            .map_err(|e| anyhow::anyhow!("Failed to read metadata.json data: {}", e))?
+            .into_bytes();
+        serde_json::from_slice(&metadata_data)
+            .map_err(|e| anyhow::anyhow!("Failed to parse metadata.json: {}", e))
+    }
+
+    fn execute_download_service(args: DownloadServiceArgs) -> anyhow::Result<()> {
+        use std::io::Write;
+
+        info!("Downloading external service backup: {}", args.service_name);
+        let rt = tokio::runtime::Runtime::new()?;
+
+        let s3_client = rt.block_on(Self::create_s3_client(
+            &args.access_key_id,
+            &args.secret_access_key,
+            &args.region,
+            args.endpoint.as_deref(),
+            args.force_path_style,
+        ))?;
+
+        println!("{}", "Reading backup metadata...".bright_white());
+        let metadata = rt.block_on(Self::load_backup_metadata(
+            &s3_client,
+            &args.bucket_name,
+            &args.bucket_path,
+            &args.backup_id,
+        ))?;
+        let ext_backup = metadata
+            .external_service_backups
+            .iter()
+            .find(|b| b.metadata.service_name == args.service_name)
+            .ok_or_else(|| {
+                anyhow::anyhow!(
+                    "Service '{}' not found in backup. Available services: {}",
+                    args.service_name,
+                    metadata
+                        .external_service_backups
+                        .iter()
+                        .map(|b| b.metadata.service_name.as_str())
+                        .collect::<Vec<_>>()
+                        .join(", ")
+                )
+            })?;
+
+        let location = ext_backup.s3_location.trim_start_matches('/').to_string();
+        let compressed = match ext_backup.compression_type.as_deref() {
+            Some(compression_type) => compression_type == "gzip",
+            // Older metadata doesn't say; PostgreSQL backups are gzipped
+            None => ext_backup.metadata.service_type == "postgres",
+        };
+        if args.decompress && !compressed {
+            return Err(anyhow::anyhow!(
+                "The backup of '{}' isn't compressed",
+                args.service_name
+            ));
+        }
+
+        let output = args.output.clone().unwrap_or_else(|| {
+            let file_name = location.rsplit('/').next().unwrap_or("backup");
+            let file_name = if args.decompress {
+                file_name.trim_end_matches(".gz").to_string()
+            } else if compressed && !file_name.ends_with(".gz") {
+                format!("{}.gz", file_name)
+            } else {
+                file_name.to_string()
+            };
+            PathBuf::from(file_name)
+        });
+
+        println!(
+            "{} {} {} {}",
+            "Downloading:".bright_white(),
+            location.bright_cyan(),
+            "→".bright_white(),
+            output.display().to_string().bright_cyan()
+        );
+        let checksum = rt.block_on(async {
+            let mut body = s3_client
+                .get_object()
+                .bucket(&args.bucket_name)
+                .key(&location)
+                .send()
+                .await
+                .map_err(|e| anyhow::anyhow!("Failed to download backup {}: {}", location, e))?
+                .body;
+
+            let mut file = std::fs::File::create(&output)?;
+            let mut verifier =
+                temps_backup::BackupVerifier::new(ext_backup.checksum.clone(), args.decompress);
+            while let Some(chunk) = body.next().await {
+                let chunk = chunk.map_err(|e| anyhow::anyhow!("Failed to read backup: {}", e))?;
+                file.write_all(&verifier.push(&chunk)?)?;
+            }
+            let (rest, checksum) = verifier.finish().inspect_err(|_| {
+                let _ = std::fs::remove_file(&output);
+            })?;
+            file.write_all(&rest)?;
+            Ok::<String, anyhow::Error>(checksum)
+        })?;
+
+        println!();
+        if ext_backup.checksum.is_some() {
+            println!(
+                "{} {}",
+                "✓ Checksum verified (SHA-256):".bright_green(),
+                checksum
+            );
+        } else {
+            println!(
+                "{} {}",
+                "⚠ Backup predates recorded checksums; SHA-256 of the stored backup:".yellow(),
+                checksum
+            );
+        }
+        println!(
+            "{} {}",
+            "✓ Backup written to".bright_green(),
+            output.display()
+        );
+        Ok(())
+    }
 }
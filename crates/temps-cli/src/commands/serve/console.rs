@@ -54,6 +54,7 @@ use temps_static_files::StaticFilesPlugin;
 use temps_status_page::StatusPagePlugin;
 use temps_vulnerability_scanner::VulnerabilityScannerPlugin;
 use temps_webhooks::WebhooksPlugin;
+use temps_wireguard::WireGuardPlugin;
 use tokio::net::TcpListener;
 use tracing::{debug, info};
 use utoipa_swagger_ui::SwaggerUi;
@@ -524,6 +525,10 @@ pub async fn start_console_api(
     // Note: Route table listener is started in serve/mod.rs to avoid duplicate listeners
     service_context.register_service(route_table.clone());
 
+    // Changes to a hostname's domains and routes take its lock, so concurrent
+    // changes to the same hostname apply one after the other
+    service_context.register_service(Arc::new(temps_core::HostnameLocks::new()));
+
     // Register plugins in dependency order:
     // 1. ConfigPlugin - provides configuration services
     debug!("Registering ConfigPlugin");
@@ -627,6 +632,11 @@ pub async fn start_console_api(
     let blob_plugin = Box::new(BlobPlugin::new());
     plugin_manager.register_plugin(blob_plugin);
 
+    // 5.3. WireGuardPlugin - provides VPN peer management (depends on database, config, encryption)
+    debug!("Registering WireGuardPlugin");
+    let wireguard_plugin = Box::new(WireGuardPlugin::new());
+    plugin_manager.register_plugin(wireguard_plugin);
+
     // 5.5. EnvironmentsPlugin - provides environment management (depends on config)
     debug!("Registering EnvironmentsPlugin");
     let environments_plugin = Box::new(EnvironmentsPlugin::new());
@@ -759,6 +769,7 @@ pub async fn start_console_api(
                     mfa_enabled: Set(false),
                     mfa_secret: Set(None),
                     mfa_recovery_codes: Set(None),
+                    log_scope: Set(None),
                     created_at: Set(now),
                     updated_at: Set(now),
                 };
@@ -787,6 +798,8 @@ pub async fn start_console_api(
         let cancellation_token = tokio_util::sync::CancellationToken::new();
         let scheduler_token = cancellation_token.clone();
         let scheduler_service = backup_service.clone();
+        let spill_token = cancellation_token.clone();
+        let spill_service = backup_service.clone();
 
         tokio::spawn(async move {
             debug!("Starting backup scheduler");
@@ -798,6 +811,11 @@ pub async fn start_console_api(
             }
         });
 
+        // Retry uploads of backups kept locally while S3 was unavailable
+        tokio::spawn(async move {
+            spill_service.start_spill_flusher(spill_token).await;
+        });
+
         debug!("Backup scheduler started in background");
         // Note: Currently no graceful shutdown mechanism for cancellation_token
         // In the future, this could be wired to a shutdown signal handler
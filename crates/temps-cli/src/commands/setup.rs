@@ -1201,36 +1201,39 @@ impl SetupCommand {
 
         // Step 3: Create or get existing domain
         print_step(3, 8, "Creating domain record");
-        let domain =
-            match rt.block_on(domain_service.create_domain(&self.wildcard_domain, "dns-01")) {
-                Ok(d) => {
+        let domain = match rt.block_on(domain_service.create_domain(
+            &self.wildcard_domain,
+            "dns-01",
+            None,
+        )) {
+            Ok(d) => {
+                print_substep(&format!(
+                    "{} Domain '{}' registered",
+                    "✓".bright_green(),
+                    self.wildcard_domain
+                ));
+                d
+            }
+            Err(e) => {
+                // Domain might already exist
+                if e.to_string().contains("already exists") {
                     print_substep(&format!(
-                        "{} Domain '{}' registered",
-                        "✓".bright_green(),
-                        self.wildcard_domain
+                        "{} Domain already exists, using existing record",
+                        "ℹ".bright_blue()
                     ));
-                    d
-                }
-                Err(e) => {
-                    // Domain might already exist
-                    if e.to_string().contains("already exists") {
-                        print_substep(&format!(
-                            "{} Domain already exists, using existing record",
-                            "ℹ".bright_blue()
-                        ));
-                        rt.block_on(async {
-                            domains::Entity::find()
-                                .filter(domains::Column::Domain.eq(&self.wildcard_domain))
-                                .one(db.as_ref())
-                                .await
-                        })?
-                        .ok_or_else(|| anyhow::anyhow!("Domain not found after creation error"))?
-                    } else {
-                        println!();
-                        return Err(anyhow::anyhow!("Failed to create domain: {}", e));
-                    }
+                    rt.block_on(async {
+                        domains::Entity::find()
+                            .filter(domains::Column::Domain.eq(&self.wildcard_domain))
+                            .one(db.as_ref())
+                            .await
+                    })?
+                    .ok_or_else(|| anyhow::anyhow!("Domain not found after creation error"))?
+                } else {
+                    println!();
+                    return Err(anyhow::anyhow!("Failed to create domain: {}", e));
                 }
-            };
+            }
+        };
         println!();
 
         // Check if domain already has a valid certificate
@@ -11,8 +11,14 @@ use std::sync::Arc;
 use temps_auth::{permission_guard, RequireAuth};
 use temps_core::error_builder::ErrorBuilder;
 use temps_core::{
-    problemdetails::Problem, AppSettings, DiskSpaceAlertSettings, LetsEncryptSettings,
-    RateLimitSettings, ScreenshotSettings, SecurityHeadersSettings,
+    problemdetails::Problem, AcmeExternalAccount, AppSettings, BuildNode, BuildNodeSettings,
+    BuildNodeTls, BuildQueueSettings, CleanupSettings, ContainerMetricsSettings, CronSettings,
+    DeployIdempotencySettings, DeployRetrySettings, DeploymentRetentionSettings,
+    DiskSpaceAlertSettings, EnvironmentDomainPattern, EnvironmentDomainSettings,
+    GarbageCollectionSettings, HealthMonitorSettings, ImageUpdateSettings, LetsEncryptSettings,
+    LogStreamSettings, RateLimitSettings, S3UploadSettings, ScreenshotSettings,
+    SecurityHeadersSettings, ServiceReadinessSettings, ServiceTunnelSettings, TrustedProxySettings,
+    WireGuardSettings,
 };
 use utoipa::{OpenApi, ToSchema};
 
@@ -46,11 +52,65 @@ pub struct AppSettingsResponse {
     pub security_headers: SecurityHeadersSettings,
     pub rate_limiting: RateLimitSettings,
 
+    // Upstream CDN/load balancer in front of the proxy
+    pub trusted_proxies: TrustedProxySettings,
+
     // Docker registry settings with masked password
     pub docker_registry: DockerRegistrySettingsMasked,
 
     // Monitoring settings
     pub disk_space_alert: DiskSpaceAlertSettings,
+
+    // Garbage collection settings
+    pub garbage_collection: GarbageCollectionSettings,
+
+    // WireGuard VPN settings
+    pub wireguard: WireGuardSettings,
+
+    // Managed service tunnel settings
+    pub service_tunnels: ServiceTunnelSettings,
+
+    // Build and deploy concurrency limits
+    pub build_queue: BuildQueueSettings,
+
+    // Pruning of old deployment records
+    pub deployment_retention: DeploymentRetentionSettings,
+
+    // When and how fast the nightly cleanup deletes
+    pub cleanup: CleanupSettings,
+
+    // Image pulls and rebuilds for base image updates
+    pub image_updates: ImageUpdateSettings,
+
+    // History of environment container metrics
+    pub container_metrics: ContainerMetricsSettings,
+
+    // Which deploy failures are retried automatically
+    pub deploy_retry: DeployRetrySettings,
+
+    // Concurrency and retries of backup uploads to S3
+    pub s3_uploads: S3UploadSettings,
+
+    // How long dependents wait for managed services to accept connections
+    pub service_readiness: ServiceReadinessSettings,
+
+    // Dedicated nodes builds run on, away from the running workloads
+    pub build_nodes: BuildNodeSettings,
+
+    // Timezone cron schedules are evaluated in
+    pub crons: CronSettings,
+
+    // How long deploy triggers are deduplicated by idempotency key
+    pub deploy_idempotency: DeployIdempotencySettings,
+
+    // Hostnames environments are assigned when they're created
+    pub environment_domains: EnvironmentDomainSettings,
+
+    // How many status page health checks run at once
+    pub health_monitor: HealthMonitorSettings,
+
+    // How many log tail streams can be open at once, and for how long
+    pub log_streams: LogStreamSettings,
 }
 
 /// DNS provider settings with masked sensitive fields
@@ -73,11 +133,19 @@ pub struct DockerRegistrySettingsMasked {
 
 impl From<AppSettings> for AppSettingsResponse {
     fn from(settings: AppSettings) -> Self {
+        // Mask the external account HMAC keys
+        let mut letsencrypt = settings.letsencrypt;
+        for account in &mut letsencrypt.external_accounts {
+            if !account.hmac_key.is_empty() {
+                account.hmac_key = "******".to_string();
+            }
+        }
+
         Self {
             external_url: settings.external_url,
             preview_domain: settings.preview_domain,
             screenshots: settings.screenshots,
-            letsencrypt: settings.letsencrypt,
+            letsencrypt,
             dns_provider: DnsProviderSettingsMasked {
                 provider: settings.dns_provider.provider,
                 // Mask the API key if it exists
@@ -88,6 +156,7 @@ impl From<AppSettings> for AppSettingsResponse {
             },
             security_headers: settings.security_headers,
             rate_limiting: settings.rate_limiting,
+            trusted_proxies: settings.trusted_proxies,
             docker_registry: DockerRegistrySettingsMasked {
                 enabled: settings.docker_registry.enabled,
                 registry_url: settings.docker_registry.registry_url,
@@ -101,6 +170,38 @@ impl From<AppSettings> for AppSettingsResponse {
                 ca_certificate: settings.docker_registry.ca_certificate,
             },
             disk_space_alert: settings.disk_space_alert,
+            garbage_collection: settings.garbage_collection,
+            wireguard: settings.wireguard,
+            service_tunnels: settings.service_tunnels,
+            build_queue: settings.build_queue,
+            deployment_retention: settings.deployment_retention,
+            cleanup: settings.cleanup,
+            image_updates: settings.image_updates,
+            container_metrics: settings.container_metrics,
+            deploy_retry: settings.deploy_retry,
+            s3_uploads: settings.s3_uploads,
+            service_readiness: settings.service_readiness,
+            build_nodes: BuildNodeSettings {
+                // Mask the client keys of the nodes
+                nodes: settings
+                    .build_nodes
+                    .nodes
+                    .into_iter()
+                    .map(|node| BuildNode {
+                        tls: node.tls.map(|tls| BuildNodeTls {
+                            client_key: "******".to_string(),
+                            ..tls
+                        }),
+                        ..node
+                    })
+                    .collect(),
+                ..settings.build_nodes
+            },
+            crons: settings.crons,
+            deploy_idempotency: settings.deploy_idempotency,
+            environment_domains: settings.environment_domains,
+            health_monitor: settings.health_monitor,
+            log_streams: settings.log_streams,
         }
     }
 }
@@ -109,10 +210,14 @@ impl From<AppSettings> for AppSettingsResponse {
 #[openapi(
     paths(get_settings, update_settings),
     components(schemas(
+        AcmeExternalAccount,
         AppSettings,
         AppSettingsResponse,
+        BuildNode,
+        BuildNodeTls,
         DnsProviderSettingsMasked,
         DockerRegistrySettingsMasked,
+        EnvironmentDomainPattern,
         SettingsUpdateResponse
     )),
     info(
@@ -190,6 +295,18 @@ async fn update_settings(
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, SettingsWrite);
 
+    let invalid_cidrs = settings.trusted_proxies.invalid_cidrs();
+    if !invalid_cidrs.is_empty() {
+        return Err(ErrorBuilder::new(StatusCode::BAD_REQUEST)
+            .type_("https://temps.sh/probs/invalid-settings")
+            .title("Invalid Settings")
+            .detail(format!(
+                "Invalid trusted proxy addresses: {}",
+                invalid_cidrs.join(", ")
+            ))
+            .build());
+    }
+
     // If sensitive fields are masked, preserve the existing values
     if let Some(ref key) = settings.dns_provider.cloudflare_api_key {
         if key == "******" {
@@ -209,6 +326,40 @@ async fn update_settings(
         }
     }
 
+    // If an ACME external account HMAC key is "******", preserve the existing value
+    if settings
+        .letsencrypt
+        .external_accounts
+        .iter()
+        .any(|account| account.hmac_key == "******")
+    {
+        match app_state.config_service.get_settings().await {
+            Ok(current_settings) => {
+                for account in &mut settings.letsencrypt.external_accounts {
+                    if account.hmac_key != "******" {
+                        continue;
+                    }
+                    account.hmac_key = current_settings
+                        .letsencrypt
+                        .external_accounts
+                        .iter()
+                        .find(|current| {
+                            current.directory_url == account.directory_url
+                                && current.key_id == account.key_id
+                        })
+                        .map(|current| current.hmac_key.clone())
+                        .unwrap_or_default();
+                }
+            }
+            Err(e) => {
+                tracing::warn!(
+                    "Could not fetch current settings to preserve ACME external account keys: {}",
+                    e
+                );
+            }
+        }
+    }
+
     // If docker registry password is "******", preserve the existing value
     if let Some(ref password) = settings.docker_registry.password {
         if password == "******" {
@@ -227,6 +378,55 @@ async fn update_settings(
         }
     }
 
+    // Build node client keys that are "******" keep the node's existing key
+    if settings.build_nodes.nodes.iter().any(|node| {
+        node.tls
+            .as_ref()
+            .is_some_and(|tls| tls.client_key == "******")
+    }) {
+        match app_state.config_service.get_settings().await {
+            Ok(current_settings) => {
+                for node in &mut settings.build_nodes.nodes {
+                    let Some(tls) = node.tls.as_mut().filter(|tls| tls.client_key == "******")
+                    else {
+                        continue;
+                    };
+                    if let Some(current_tls) = current_settings
+                        .build_nodes
+                        .nodes
+                        .iter()
+                        .find(|current| current.name == node.name)
+                        .and_then(|current| current.tls.as_ref())
+                    {
+                        tls.client_key = current_tls.client_key.clone();
+                    }
+                }
+            }
+            Err(e) => {
+                tracing::warn!(
+                    "Could not fetch current settings to preserve build node client keys: {}",
+                    e
+                );
+            }
+        }
+    }
+
+    if let Err(e) = settings
+        .build_nodes
+        .validate()
+        .and_then(|_| settings.crons.validate())
+        .and_then(|_| settings.deploy_idempotency.validate())
+        .and_then(|_| settings.environment_domains.validate())
+        .and_then(|_| settings.health_monitor.validate())
+        .and_then(|_| settings.log_streams.validate())
+    {
+        return Err(ErrorBuilder::new(StatusCode::BAD_REQUEST)
+            .type_("https://temps.sh/probs/invalid-settings")
+            .title("Invalid Settings")
+            .detail(e)
+            .build());
+    }
+
     match app_state.config_service.update_settings(settings).await {
         Ok(_) => Ok((
             StatusCode::OK,
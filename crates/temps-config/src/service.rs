@@ -64,6 +64,12 @@ pub struct ServerConfig {
     pub postgres_acquire_timeout_secs: Option<u64>,
     pub postgres_idle_timeout_secs: Option<u64>,
     pub postgres_max_lifetime_secs: Option<u64>,
+
+    // Proxy log ingestion settings (all optional with defaults)
+    pub proxy_log_batch_size: Option<usize>,
+    pub proxy_log_flush_interval_ms: Option<u64>,
+    pub proxy_log_workers: Option<usize>,
+    pub proxy_log_queue_capacity: Option<usize>,
 }
 
 impl ServerConfig {
@@ -144,6 +150,24 @@ impl ServerConfig {
                 .ok()
                 .and_then(|s| s.parse().ok())
                 .or(Some(1800)),
+
+            // Proxy log ingestion settings from env or defaults
+            proxy_log_batch_size: std::env::var("TEMPS_PROXY_LOG_BATCH_SIZE")
+                .ok()
+                .and_then(|s| s.parse().ok())
+                .or(Some(500)),
+            proxy_log_flush_interval_ms: std::env::var("TEMPS_PROXY_LOG_FLUSH_INTERVAL_MS")
+                .ok()
+                .and_then(|s| s.parse().ok())
+                .or(Some(1000)),
+            proxy_log_workers: std::env::var("TEMPS_PROXY_LOG_WORKERS")
+                .ok()
+                .and_then(|s| s.parse().ok())
+                .or(Some(2)),
+            proxy_log_queue_capacity: std::env::var("TEMPS_PROXY_LOG_QUEUE_CAPACITY")
+                .ok()
+                .and_then(|s| s.parse().ok())
+                .or(Some(20_000)),
         })
     }
 
@@ -200,6 +224,23 @@ impl ServerConfig {
     pub fn get_postgres_max_lifetime_secs(&self) -> u64 {
         self.postgres_max_lifetime_secs.unwrap_or(1800)
     }
+
+    // Proxy log ingestion getters with defaults
+    pub fn get_proxy_log_batch_size(&self) -> usize {
+        self.proxy_log_batch_size.unwrap_or(500)
+    }
+
+    pub fn get_proxy_log_flush_interval_ms(&self) -> u64 {
+        self.proxy_log_flush_interval_ms.unwrap_or(1000)
+    }
+
+    pub fn get_proxy_log_workers(&self) -> usize {
+        self.proxy_log_workers.unwrap_or(2)
+    }
+
+    pub fn get_proxy_log_queue_capacity(&self) -> usize {
+        self.proxy_log_queue_capacity.unwrap_or(20_000)
+    }
 }
 
 // Default domain for local development (resolves to 127.0.0.1)
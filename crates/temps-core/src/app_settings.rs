@@ -1,4 +1,5 @@
 use serde::{Deserialize, Serialize};
+use std::net::IpAddr;
 use utoipa::ToSchema;
 
 /// Application settings stored in the database
@@ -26,11 +27,65 @@ pub struct AppSettings {
     pub security_headers: SecurityHeadersSettings,
     pub rate_limiting: RateLimitSettings,
 
+    // Upstream CDN/load balancer in front of the proxy
+    pub trusted_proxies: TrustedProxySettings,
+
     // Docker registry settings
     pub docker_registry: DockerRegistrySettings,
 
     // System monitoring settings
     pub disk_space_alert: DiskSpaceAlertSettings,
+
+    // Orphaned resource garbage collection
+    pub garbage_collection: GarbageCollectionSettings,
+
+    // WireGuard VPN for operator access to internal services
+    pub wireguard: WireGuardSettings,
+
+    // Time-boxed tunnels to managed services for local development
+    pub service_tunnels: ServiceTunnelSettings,
+
+    // Build and deploy concurrency limits
+    pub build_queue: BuildQueueSettings,
+
+    // Pruning of old deployment records
+    pub deployment_retention: DeploymentRetentionSettings,
+
+    // When and how fast the nightly cleanup deletes
+    pub cleanup: CleanupSettings,
+
+    // Image pulls and rebuilds for base image updates
+    pub image_updates: ImageUpdateSettings,
+
+    // History of environment container metrics
+    pub container_metrics: ContainerMetricsSettings,
+
+    // Which deploy failures are retried automatically
+    pub deploy_retry: DeployRetrySettings,
+
+    // Concurrency and retries of backup uploads to S3
+    pub s3_uploads: S3UploadSettings,
+
+    // How long dependents wait for managed services to accept connections
+    pub service_readiness: ServiceReadinessSettings,
+
+    // Dedicated nodes builds run on, away from the running workloads
+    pub build_nodes: BuildNodeSettings,
+
+    // Timezone cron schedules are evaluated in
+    pub crons: CronSettings,
+
+    // How long deploy triggers are deduplicated by idempotency key
+    pub deploy_idempotency: DeployIdempotencySettings,
+
+    // Hostnames environments are assigned when they're created
+    pub environment_domains: EnvironmentDomainSettings,
+
+    // How many status page health checks run at once
+    pub health_monitor: HealthMonitorSettings,
+
+    // How many log tail streams can be open at once, and for how long
+    pub log_streams: LogStreamSettings,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
@@ -45,7 +100,24 @@ pub struct ScreenshotSettings {
 #[serde(default)]
 pub struct LetsEncryptSettings {
     pub email: Option<String>,
+    /// ACME directory for domains without their own: "production", "staging" or a
+    /// directory URL of another ACME CA
+    #[schema(example = "production")]
     pub environment: String,
+    /// External account bindings for CAs that require them, such as ZeroSSL
+    pub external_accounts: Vec<AcmeExternalAccount>,
+}
+
+/// External account binding registering ACME accounts with a CA
+#[derive(Debug, Clone, Default, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct AcmeExternalAccount {
+    /// Directory URL the binding applies to
+    #[schema(example = "https://acme.zerossl.com/v2/DV90")]
+    pub directory_url: String,
+    pub key_id: String,
+    /// Base64url-encoded HMAC key issued by the CA
+    pub hmac_key: String,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
@@ -90,6 +162,22 @@ pub struct RateLimitSettings {
     pub blacklist_ips: Vec<String>,
 }
 
+/// Upstream proxies (CDN, load balancer) whose forwarded headers the proxy honors
+///
+/// `X-Forwarded-For` and `X-Forwarded-Proto` are only read from connections coming from
+/// one of these networks; from anywhere else they are replaced, so clients can't spoof
+/// their address or scheme.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct TrustedProxySettings {
+    /// IP addresses or CIDR ranges of trusted upstream proxies
+    #[schema(example = json!(["173.245.48.0/20", "10.0.0.5"]))]
+    pub trusted_cidrs: Vec<String>,
+    /// TLS is terminated by the upstream proxy, which holds the certificates; skips
+    /// automatic HTTP-01 certificate renewal
+    pub tls_terminated_upstream: bool,
+}
+
 /// Disk space alert settings for monitoring disk usage
 #[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
 #[serde(default)]
@@ -99,6 +187,9 @@ pub struct DiskSpaceAlertSettings {
     /// Threshold percentage (0-100) at which to trigger alerts
     #[schema(minimum = 0, maximum = 100, example = 80)]
     pub threshold_percent: u32,
+    /// Critical threshold percentage (0-100); new builds are refused at or above it
+    #[schema(minimum = 0, maximum = 100, example = 95)]
+    pub critical_threshold_percent: u32,
     /// Interval in seconds between disk space checks
     #[schema(minimum = 60, example = 300)]
     pub check_interval_seconds: u64,
@@ -106,7 +197,803 @@ pub struct DiskSpaceAlertSettings {
     pub monitor_path: Option<String>,
 }
 
+/// Garbage collection settings for orphaned Docker resources owned by Temps
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct GarbageCollectionSettings {
+    /// Whether periodic garbage collection is enabled
+    pub enabled: bool,
+    /// Interval in seconds between garbage collection runs
+    #[schema(minimum = 300, example = 21600)]
+    pub interval_seconds: u64,
+    /// Number of most recent deployment images kept per environment (for rollbacks)
+    #[schema(minimum = 1, example = 3)]
+    pub retained_images: u32,
+}
+
+/// WireGuard VPN settings for operator access to internal services and managed databases
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct WireGuardSettings {
+    /// Whether the WireGuard interface is managed by Temps
+    pub enabled: bool,
+    /// Public host (IP or DNS name) peers connect to; defaults to the external URL host
+    pub endpoint_host: Option<String>,
+    /// UDP port the WireGuard interface listens on
+    #[schema(example = 51820)]
+    pub listen_port: u16,
+    /// Name of the WireGuard network interface on the host
+    #[schema(example = "wg-temps")]
+    pub interface_name: String,
+    /// Address range peers are assigned from (the first address belongs to the server)
+    #[schema(example = "10.66.0.0/24")]
+    pub address_range: String,
+    /// Subnets a peer can reach when none are specified for it
+    #[schema(example = json!(["172.16.0.0/12"]))]
+    pub default_allowed_subnets: Vec<String>,
+}
+
+/// Settings for time-boxed tunnels that expose a managed service on a local port
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct ServiceTunnelSettings {
+    /// Whether users may open tunnels to managed services
+    pub enabled: bool,
+    /// Address tunnel listeners bind to (e.g. the WireGuard server address to restrict
+    /// tunnels to VPN peers)
+    #[schema(example = "0.0.0.0")]
+    pub bind_address: String,
+    /// Host returned to clients for connecting; defaults to the external URL host
+    pub public_host: Option<String>,
+    /// Tunnel lifetime when the request does not specify one
+    #[schema(example = 60)]
+    pub default_ttl_minutes: u32,
+    /// Longest lifetime a tunnel may be opened for
+    #[schema(example = 480)]
+    pub max_ttl_minutes: u32,
+    /// Close the tunnel after this many minutes without an active connection
+    #[schema(example = 15)]
+    pub idle_timeout_minutes: u32,
+}
+
+/// Limits on how many builds and deployments run at the same time
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct BuildQueueSettings {
+    /// Maximum deployment pipelines running at once across all projects; further
+    /// deployments wait for a free slot
+    #[schema(example = 4)]
+    pub max_concurrent_builds: u32,
+    /// Environments deployed at once during a project-wide deploy, for projects that
+    /// don't set their own limit
+    #[schema(example = 2)]
+    pub default_project_deploy_concurrency: u32,
+    /// Notify when a deployment has waited this many seconds for a build slot; 0
+    /// disables the alert
+    #[schema(example = 300)]
+    pub queue_wait_alert_seconds: u32,
+    /// Notify when a build runs longer than this percentage of its environment's
+    /// average duration; 0 disables the alert
+    #[schema(example = 150)]
+    pub slow_build_alert_percent: u32,
+}
+
+/// Retention of deployment history, applied per environment by the nightly cleanup
+///
+/// A deployment is pruned once it falls outside either limit. The running deployment,
+/// the last successful one and deployments still in progress are always kept.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct DeploymentRetentionSettings {
+    /// Whether old deployments are pruned by the nightly cleanup
+    pub enabled: bool,
+    /// Most recent deployments kept per environment; no count limit when unset
+    #[schema(minimum = 1, example = 100)]
+    pub keep_last: Option<u32>,
+    /// Days deployments are kept; no age limit when unset
+    #[schema(minimum = 1, example = 90)]
+    pub max_age_days: Option<u32>,
+}
+
+/// Scheduling and pace of the nightly cleanup (images, build cache, expired
+/// artifacts and deployment retention)
+///
+/// The cleanup starts at `window_start_hour` (UTC) and stops deleting once
+/// `window_end_hour` is reached; what is left is deleted by the next run. Deployments
+/// are pruned `concurrency` at a time, with at most `max_deletes_per_second` deletes
+/// per second so the sweep doesn't saturate storage or the database.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct CleanupSettings {
+    /// Hour of day (UTC) the cleanup starts
+    #[schema(minimum = 0, maximum = 23, example = 2)]
+    pub window_start_hour: u32,
+    /// Hour of day (UTC) by which the cleanup stops; the start hour for no limit
+    #[schema(minimum = 0, maximum = 23, example = 6)]
+    pub window_end_hour: u32,
+    /// Deployments pruned at once
+    #[schema(minimum = 1, maximum = 16, example = 2)]
+    pub concurrency: usize,
+    /// Deletes per second across the cleanup; 0 for no limit
+    #[schema(example = 20)]
+    pub max_deletes_per_second: u32,
+}
+
+impl CleanupSettings {
+    /// Deployments pruned at once, within 1..=16
+    pub fn concurrency(&self) -> usize {
+        self.concurrency.clamp(1, 16)
+    }
+
+    pub fn window_start_hour(&self) -> u32 {
+        self.window_start_hour % 24
+    }
+
+    /// How long a run may delete for; None when the window has no end
+    pub fn window(&self) -> Option<std::time::Duration> {
+        let hours = (self.window_end_hour % 24 + 24 - self.window_start_hour()) % 24;
+        (hours > 0).then(|| std::time::Duration::from_secs(hours as u64 * 3600))
+    }
+
+    /// Wait between two deletes; None when deletes aren't limited
+    pub fn delete_interval(&self) -> Option<std::time::Duration> {
+        (self.max_deletes_per_second > 0)
+            .then(|| std::time::Duration::from_secs(1) / self.max_deletes_per_second)
+    }
+}
+
+/// When an image is pulled from its registry
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "kebab-case")]
+pub enum ImagePullPolicy {
+    /// Pull on every use, picking up new pushes of the same tag
+    Always,
+    /// Pull only when no copy of the image exists locally
+    #[default]
+    IfNotPresent,
+}
+
+/// Keeping deployed images up to date with their base images
+///
+/// Base images (the `FROM` images of a build) receive security fixes under the same
+/// tag, which a build only picks up when it pulls them. With `auto_rebuild`, the
+/// base images of each environment's running deployment are checked periodically and
+/// the deployment is rebuilt from the same commit when one of them has a newer digest.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct ImageUpdateSettings {
+    /// Pull policy for base images during builds
+    pub base_image_pull_policy: ImagePullPolicy,
+    /// Pull policy for prebuilt images when deploying them
+    pub deploy_image_pull_policy: ImagePullPolicy,
+    /// Rebuild running deployments whose base image has a newer digest
+    pub auto_rebuild: bool,
+    /// Hours between base image update checks
+    #[schema(minimum = 1, example = 24)]
+    pub check_interval_hours: u32,
+}
+
 const DEFAULT_LOCAL_DOMAIN: &str = "localho.st";
+/// Sampling and retention of environment resource usage
+///
+/// The CPU, memory and network usage of each environment's running containers is
+/// sampled and kept at full resolution for `raw_retention_days`; older samples
+/// survive only as hourly averages and maximums, kept for `hourly_retention_days`.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct ContainerMetricsSettings {
+    /// Whether container metrics are recorded
+    pub enabled: bool,
+    /// Seconds between samples
+    #[schema(minimum = 10, example = 30)]
+    pub collection_interval_seconds: u64,
+    /// Days samples are kept at full resolution
+    #[schema(minimum = 1, example = 7)]
+    pub raw_retention_days: u32,
+    /// Days hourly aggregates are kept
+    #[schema(minimum = 1, example = 90)]
+    pub hourly_retention_days: u32,
+}
+
+/// Classification of deploy failures for automatic retries
+///
+/// Services that enable `auto_retry` only retry failures classified as transient:
+/// network, registry and timeout errors are built in. Patterns here extend that
+/// classification; they are matched case-insensitively against the failure message,
+/// and a permanent pattern wins over a transient one.
+#[derive(Debug, Clone, Default, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct DeployRetrySettings {
+    /// Extra failure messages treated as transient
+    #[schema(example = json!(["connection reset by peer"]))]
+    pub transient_patterns: Vec<String>,
+    /// Failure messages never retried, even when they match a transient pattern
+    #[schema(example = json!(["quota exceeded"]))]
+    pub permanent_patterns: Vec<String>,
+}
+
+/// Concurrency and retries of backup uploads to S3
+///
+/// Large backups upload up to `concurrency` parts at once. A failed S3 request is
+/// retried up to `max_retries` times, waiting `retry_base_delay_ms` before the first
+/// retry and twice as long before each next one, at most 30 seconds. A backup that
+/// still can't be uploaded is kept on local disk, up to `spill_buffer_mb` in total,
+/// and uploaded again once S3 is reachable.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct S3UploadSettings {
+    /// Parts of a multipart upload sent at once
+    #[schema(minimum = 1, maximum = 16, example = 4)]
+    pub concurrency: usize,
+    /// Retries of a failed S3 request
+    #[schema(example = 3)]
+    pub max_retries: u32,
+    /// Wait before the first retry, in milliseconds
+    #[schema(example = 500)]
+    pub retry_base_delay_ms: u64,
+    /// Disk space for backups waiting to be uploaded, in megabytes; 0 disables it
+    #[schema(example = 2048)]
+    pub spill_buffer_mb: u64,
+}
+
+impl S3UploadSettings {
+    /// Parts sent at once, within 1..=16
+    pub fn concurrency(&self) -> usize {
+        self.concurrency.clamp(1, 16)
+    }
+
+    /// Wait before the given retry, counting from 0
+    pub fn retry_delay(&self, retry: u32) -> std::time::Duration {
+        const MAX_RETRY_DELAY_MS: u64 = 30_000;
+        let delay = self
+            .retry_base_delay_ms
+            .saturating_mul(1u64 << retry.min(16))
+            .min(MAX_RETRY_DELAY_MS);
+        std::time::Duration::from_millis(delay)
+    }
+}
+
+impl Default for S3UploadSettings {
+    fn default() -> Self {
+        Self {
+            concurrency: 4,
+            max_retries: 3,
+            retry_base_delay_ms: 500,
+            spill_buffer_mb: 2048,
+        }
+    }
+}
+
+/// Readiness probing of managed services started ahead of their dependents
+///
+/// A started service is probed every `interval_ms` until it accepts connections;
+/// dependents give up when it isn't ready within `timeout_seconds`.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct ServiceReadinessSettings {
+    /// Longest a service may take to start and accept connections, in seconds
+    #[schema(example = 120)]
+    pub timeout_seconds: u64,
+    /// Wait between two probes, in milliseconds
+    #[schema(example = 1000)]
+    pub interval_ms: u64,
+}
+
+impl ServiceReadinessSettings {
+    pub fn timeout(&self) -> std::time::Duration {
+        std::time::Duration::from_secs(self.timeout_seconds.max(1))
+    }
+
+    /// Wait between two probes, at least 100 milliseconds
+    pub fn interval(&self) -> std::time::Duration {
+        std::time::Duration::from_millis(self.interval_ms.max(100))
+    }
+}
+
+impl Default for ServiceReadinessSettings {
+    fn default() -> Self {
+        Self {
+            timeout_seconds: 120,
+            interval_ms: 1000,
+        }
+    }
+}
+
+/// Dedicated build nodes
+///
+/// A build node is another Docker daemon that builds run on instead of the one the
+/// workloads run on. Its images are copied back after the build, through the Docker
+/// registry when one is configured and directly otherwise. Without nodes, builds run
+/// locally.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct BuildNodeSettings {
+    pub nodes: Vec<BuildNode>,
+    /// Build locally when no eligible node answers; otherwise such builds fail
+    pub fallback_to_local: bool,
+}
+
+impl BuildNodeSettings {
+    /// Enabled nodes that have every one of the labels
+    pub fn eligible_nodes(&self, labels: &[String]) -> Vec<&BuildNode> {
+        self.nodes
+            .iter()
+            .filter(|node| node.enabled)
+            .filter(|node| labels.iter().all(|label| node.labels.contains(label)))
+            .collect()
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        let mut names = std::collections::HashSet::new();
+        for node in &self.nodes {
+            if node.name.trim().is_empty() {
+                return Err("Build nodes need a name".to_string());
+            }
+            if !names.insert(node.name.as_str()) {
+                return Err(format!("Build node '{}' is configured twice", node.name));
+            }
+            let scheme = node.docker_host.split("://").next().unwrap_or_default();
+            if !node.docker_host.contains("://") || !matches!(scheme, "tcp" | "http" | "unix") {
+                return Err(format!(
+                    "Docker host '{}' of build node '{}' must be a tcp:// or unix:// address",
+                    node.docker_host, node.name
+                ));
+            }
+            if let Some(label) = node
+                .labels
+                .iter()
+                .find(|label| label.is_empty() || label.chars().any(char::is_whitespace))
+            {
+                return Err(format!(
+                    "Label '{}' of build node '{}' must be a single word",
+                    label, node.name
+                ));
+            }
+            if let Some(tls) = &node.tls {
+                if scheme == "unix" {
+                    return Err(format!(
+                        "Build node '{}' is reached over a socket, which takes no TLS",
+                        node.name
+                    ));
+                }
+                for (name, pem) in [
+                    ("CA certificate", &tls.ca_certificate),
+                    ("client certificate", &tls.client_certificate),
+                    ("client key", &tls.client_key),
+                ] {
+                    if !pem.trim_start().starts_with("-----BEGIN") {
+                        return Err(format!(
+                            "The {} of build node '{}' must be PEM encoded",
+                            name, node.name
+                        ));
+                    }
+                }
+            }
+        }
+        Ok(())
+    }
+}
+
+impl Default for BuildNodeSettings {
+    fn default() -> Self {
+        Self {
+            nodes: Vec::new(),
+            fallback_to_local: true,
+        }
+    }
+}
+
+/// A Docker daemon builds can run on
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct BuildNode {
+    #[schema(example = "builder-1")]
+    pub name: String,
+    /// Address of the node's Docker daemon, `tcp://` or `unix://`; keep TCP daemons
+    /// on a private network such as the WireGuard one
+    #[schema(example = "tcp://10.100.0.5:2375")]
+    pub docker_host: String,
+    /// Labels services can require of the node building them
+    #[schema(example = json!(["arm64", "large"]))]
+    pub labels: Vec<String>,
+    pub enabled: bool,
+    /// Mutual TLS for a `tcp://` daemon; builds with secrets only go to nodes
+    /// reached over TLS or a socket
+    pub tls: Option<BuildNodeTls>,
+}
+
+impl BuildNode {
+    /// Whether what is sent to the node is encrypted and the node authenticated
+    pub fn secure_channel(&self) -> bool {
+        self.tls.is_some() || self.docker_host.starts_with("unix://")
+    }
+}
+
+impl Default for BuildNode {
+    fn default() -> Self {
+        Self {
+            name: String::new(),
+            docker_host: String::new(),
+            labels: Vec::new(),
+            enabled: true,
+            tls: None,
+        }
+    }
+}
+
+/// PEM-encoded credentials of a build node's TLS-protected Docker daemon
+#[derive(Debug, Clone, Default, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct BuildNodeTls {
+    /// CA the daemon's certificate is verified against
+    pub ca_certificate: String,
+    pub client_certificate: String,
+    pub client_key: String,
+}
+
+/// Evaluation of cron job schedules
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct CronSettings {
+    /// IANA timezone of the cron jobs that don't set their own
+    #[schema(example = "Europe/Madrid")]
+    pub default_timezone: String,
+}
+
+impl CronSettings {
+    pub fn validate(&self) -> Result<(), String> {
+        self.default_timezone
+            .parse::<chrono_tz::Tz>()
+            .map(|_| ())
+            .map_err(|_| {
+                format!(
+                    "Default cron timezone '{}' is not an IANA timezone, such as Europe/Madrid",
+                    self.default_timezone
+                )
+            })
+    }
+}
+
+impl Default for CronSettings {
+    fn default() -> Self {
+        Self {
+            default_timezone: "UTC".to_string(),
+        }
+    }
+}
+
+/// Deduplication of deploy triggers sent with an `Idempotency-Key` header
+///
+/// A trigger repeated with the same key within `window_minutes` of the first gets the
+/// first trigger's deploy back instead of starting another one. After the window the
+/// key can be used again.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct DeployIdempotencySettings {
+    /// How long a key deduplicates triggers, in minutes
+    #[schema(minimum = 1, maximum = 10080, example = 1440)]
+    pub window_minutes: u32,
+}
+
+impl DeployIdempotencySettings {
+    /// Longest dedup window: a week
+    pub const MAX_WINDOW_MINUTES: u32 = 7 * 24 * 60;
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.window_minutes == 0 || self.window_minutes > Self::MAX_WINDOW_MINUTES {
+            return Err(format!(
+                "Deploy idempotency window must be between 1 and {} minutes",
+                Self::MAX_WINDOW_MINUTES
+            ));
+        }
+        Ok(())
+    }
+
+    pub fn window(&self) -> chrono::Duration {
+        chrono::Duration::minutes(self.window_minutes.clamp(1, Self::MAX_WINDOW_MINUTES) as i64)
+    }
+}
+
+impl Default for DeployIdempotencySettings {
+    fn default() -> Self {
+        Self {
+            window_minutes: 24 * 60,
+        }
+    }
+}
+
+/// Scheduling of the status page's health checks
+///
+/// Each monitor is checked on its own interval. Up to `concurrency` checks run at once;
+/// checks over the limit wait, which shows as monitor lag. A monitor's checks are
+/// shifted by up to `jitter_percent` of its interval so monitors created together
+/// don't keep probing at the same instant.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct HealthMonitorSettings {
+    /// Health checks running at once
+    #[schema(minimum = 1, maximum = 1000, example = 20)]
+    pub concurrency: u32,
+    /// Largest shift of a check, as a percentage of its monitor's interval
+    #[schema(minimum = 0, maximum = 50, example = 10)]
+    pub jitter_percent: u32,
+}
+
+impl HealthMonitorSettings {
+    pub const MAX_CONCURRENCY: u32 = 1000;
+    pub const MAX_JITTER_PERCENT: u32 = 50;
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.concurrency == 0 || self.concurrency > Self::MAX_CONCURRENCY {
+            return Err(format!(
+                "Health check concurrency must be between 1 and {}",
+                Self::MAX_CONCURRENCY
+            ));
+        }
+        if self.jitter_percent > Self::MAX_JITTER_PERCENT {
+            return Err(format!(
+                "Health check jitter can be at most {}% of the interval",
+                Self::MAX_JITTER_PERCENT
+            ));
+        }
+        Ok(())
+    }
+
+    pub fn concurrency(&self) -> usize {
+        self.concurrency.clamp(1, Self::MAX_CONCURRENCY) as usize
+    }
+
+    pub fn jitter_percent(&self) -> u32 {
+        self.jitter_percent.min(Self::MAX_JITTER_PERCENT)
+    }
+}
+
+impl Default for HealthMonitorSettings {
+    fn default() -> Self {
+        Self {
+            concurrency: 20,
+            jitter_percent: 10,
+        }
+    }
+}
+
+/// Limits on live log tail streams
+///
+/// Every tail of deployment, job or container logs keeps a connection and a poller
+/// open. At most `max_streams` are open at once, `max_streams_per_user` of them for
+/// one user and `max_streams_per_project` for one project; opening more is rejected
+/// with 429. A stream that sent nothing for `idle_timeout_seconds` is closed, and so
+/// is any stream open for `max_duration_seconds`; clients reconnect to resume.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct LogStreamSettings {
+    /// Log tail streams open at once across all users
+    #[schema(minimum = 1, maximum = 10000, example = 200)]
+    pub max_streams: u32,
+    /// Log tail streams one user can have open
+    #[schema(minimum = 1, maximum = 10000, example = 10)]
+    pub max_streams_per_user: u32,
+    /// Log tail streams open at once on the logs of one project
+    #[schema(minimum = 1, maximum = 10000, example = 50)]
+    pub max_streams_per_project: u32,
+    /// Seconds without new log lines after which a stream is closed
+    #[schema(minimum = 10, maximum = 86400, example = 300)]
+    pub idle_timeout_seconds: u64,
+    /// Seconds after which a stream is closed even when logs keep coming
+    #[schema(minimum = 60, maximum = 86400, example = 1800)]
+    pub max_duration_seconds: u64,
+}
+
+impl LogStreamSettings {
+    pub const MAX_STREAMS: u32 = 10000;
+    pub const MIN_IDLE_TIMEOUT_SECONDS: u64 = 10;
+    pub const MIN_DURATION_SECONDS: u64 = 60;
+    pub const MAX_SECONDS: u64 = 86400;
+
+    pub fn validate(&self) -> Result<(), String> {
+        for (name, value) in [
+            ("Log stream limit", self.max_streams),
+            ("Log streams per user", self.max_streams_per_user),
+            ("Log streams per project", self.max_streams_per_project),
+        ] {
+            if value == 0 || value > Self::MAX_STREAMS {
+                return Err(format!(
+                    "{} must be between 1 and {}",
+                    name,
+                    Self::MAX_STREAMS
+                ));
+            }
+        }
+        if self.idle_timeout_seconds < Self::MIN_IDLE_TIMEOUT_SECONDS
+            || self.idle_timeout_seconds > Self::MAX_SECONDS
+        {
+            return Err(format!(
+                "Log stream idle timeout must be between {} and {} seconds",
+                Self::MIN_IDLE_TIMEOUT_SECONDS,
+                Self::MAX_SECONDS
+            ));
+        }
+        if self.max_duration_seconds < Self::MIN_DURATION_SECONDS
+            || self.max_duration_seconds > Self::MAX_SECONDS
+        {
+            return Err(format!(
+                "Log stream duration must be between {} and {} seconds",
+                Self::MIN_DURATION_SECONDS,
+                Self::MAX_SECONDS
+            ));
+        }
+        Ok(())
+    }
+
+    pub fn max_streams(&self) -> usize {
+        self.max_streams.clamp(1, Self::MAX_STREAMS) as usize
+    }
+
+    pub fn max_streams_per_user(&self) -> usize {
+        self.max_streams_per_user.clamp(1, Self::MAX_STREAMS) as usize
+    }
+
+    pub fn max_streams_per_project(&self) -> usize {
+        self.max_streams_per_project.clamp(1, Self::MAX_STREAMS) as usize
+    }
+
+    pub fn idle_timeout(&self) -> std::time::Duration {
+        std::time::Duration::from_secs(
+            self.idle_timeout_seconds
+                .clamp(Self::MIN_IDLE_TIMEOUT_SECONDS, Self::MAX_SECONDS),
+        )
+    }
+
+    pub fn max_duration(&self) -> std::time::Duration {
+        std::time::Duration::from_secs(
+            self.max_duration_seconds
+                .clamp(Self::MIN_DURATION_SECONDS, Self::MAX_SECONDS),
+        )
+    }
+}
+
+impl Default for LogStreamSettings {
+    fn default() -> Self {
+        Self {
+            max_streams: 200,
+            max_streams_per_user: 10,
+            max_streams_per_project: 50,
+            idle_timeout_seconds: 300,
+            max_duration_seconds: 1800,
+        }
+    }
+}
+
+/// Hostnames environments get when they're created, without setting up domains
+///
+/// Each pattern names the hostname of the environments with a slug, such as
+/// `{project}-staging.platform.example.com` for `staging`. A hostname another
+/// environment or custom domain already has gets a numbered suffix. Domains attached
+/// by hand are added next to the assigned one.
+#[derive(Debug, Clone, Default, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct EnvironmentDomainSettings {
+    pub patterns: Vec<EnvironmentDomainPattern>,
+}
+
+impl EnvironmentDomainSettings {
+    /// Pattern of the environment with the slug, falling back to the `*` one
+    pub fn pattern_for(&self, environment_slug: &str) -> Option<&EnvironmentDomainPattern> {
+        self.patterns
+            .iter()
+            .find(|pattern| pattern.environment.eq_ignore_ascii_case(environment_slug))
+            .or_else(|| {
+                self.patterns
+                    .iter()
+                    .find(|pattern| pattern.environment == EnvironmentDomainPattern::ANY)
+            })
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        let mut environments = std::collections::HashSet::new();
+        for pattern in &self.patterns {
+            let environment = pattern.environment.trim().to_lowercase();
+            if environment.is_empty() {
+                return Err("Environment domain patterns need an environment".to_string());
+            }
+            if !environments.insert(environment) {
+                return Err(format!(
+                    "Environment '{}' has more than one domain pattern",
+                    pattern.environment
+                ));
+            }
+            pattern.validate()?;
+        }
+        Ok(())
+    }
+}
+
+/// Hostname template of the environments with a slug
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(default)]
+pub struct EnvironmentDomainPattern {
+    /// Slug of the environments the pattern applies to; `*` for the environments
+    /// without a pattern of their own
+    #[schema(example = "staging")]
+    pub environment: String,
+    /// Hostname with `{project}` (or `{service}`) and `{environment}` standing for the
+    /// slugs of the project and the environment
+    #[schema(example = "{project}-{environment}.platform.example.com")]
+    pub pattern: String,
+    /// Request a Let's Encrypt certificate for the hostname once it's assigned; turn
+    /// off when a wildcard certificate covers it
+    pub provision_certificate: bool,
+}
+
+impl EnvironmentDomainPattern {
+    /// Environment of the pattern applying to every environment without one
+    pub const ANY: &'static str = "*";
+    const PLACEHOLDERS: [&'static str; 3] = ["{project}", "{service}", "{environment}"];
+
+    /// Hostname of the environment of the project; None when the slugs don't make a
+    /// valid one, such as a label longer than 63 characters
+    pub fn render(&self, project_slug: &str, environment_slug: &str) -> Option<String> {
+        let hostname = self
+            .pattern
+            .trim()
+            .replace("{project}", project_slug)
+            .replace("{service}", project_slug)
+            .replace("{environment}", environment_slug)
+            .to_lowercase();
+        is_valid_hostname(&hostname).then_some(hostname)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        let mut rest = self.pattern.clone();
+        for placeholder in Self::PLACEHOLDERS {
+            rest = rest.replace(placeholder, "");
+        }
+        if rest.contains('{') || rest.contains('}') {
+            return Err(format!(
+                "Domain pattern '{}' can only use {{project}}, {{service}} and {{environment}}",
+                self.pattern
+            ));
+        }
+        if !self.pattern.contains("{project}") && !self.pattern.contains("{service}") {
+            return Err(format!(
+                "Domain pattern '{}' needs {{project}} or {{service}} so projects get different hostnames",
+                self.pattern
+            ));
+        }
+        if self.render("project", "environment").is_none() {
+            return Err(format!(
+                "Domain pattern '{}' doesn't make a hostname, such as {{project}}-staging.example.com",
+                self.pattern
+            ));
+        }
+        Ok(())
+    }
+}
+
+impl Default for EnvironmentDomainPattern {
+    fn default() -> Self {
+        Self {
+            environment: String::new(),
+            pattern: String::new(),
+            provision_certificate: true,
+        }
+    }
+}
+
+/// Whether the name is a hostname of at least two labels of letters, digits and
+/// inner hyphens
+fn is_valid_hostname(hostname: &str) -> bool {
+    hostname.len() <= 253
+        && hostname.split('.').count() >= 2
+        && hostname.split('.').all(|label| {
+            !label.is_empty()
+                && label.len() <= 63
+                && !label.starts_with('-')
+                && !label.ends_with('-')
+                && label.chars().all(|c| c.is_ascii_alphanumeric() || c == '-')
+        })
+}
+
 impl Default for AppSettings {
     fn default() -> Self {
         Self {
@@ -118,8 +1005,26 @@ impl Default for AppSettings {
             dns_provider: DnsProviderSettings::default(),
             security_headers: SecurityHeadersSettings::default(),
             rate_limiting: RateLimitSettings::default(),
+            trusted_proxies: TrustedProxySettings::default(),
             docker_registry: DockerRegistrySettings::default(),
             disk_space_alert: DiskSpaceAlertSettings::default(),
+            garbage_collection: GarbageCollectionSettings::default(),
+            wireguard: WireGuardSettings::default(),
+            service_tunnels: ServiceTunnelSettings::default(),
+            build_queue: BuildQueueSettings::default(),
+            deployment_retention: DeploymentRetentionSettings::default(),
+            cleanup: CleanupSettings::default(),
+            image_updates: ImageUpdateSettings::default(),
+            container_metrics: ContainerMetricsSettings::default(),
+            deploy_retry: DeployRetrySettings::default(),
+            s3_uploads: S3UploadSettings::default(),
+            service_readiness: ServiceReadinessSettings::default(),
+            build_nodes: BuildNodeSettings::default(),
+            crons: CronSettings::default(),
+            deploy_idempotency: DeployIdempotencySettings::default(),
+            environment_domains: EnvironmentDomainSettings::default(),
+            health_monitor: HealthMonitorSettings::default(),
+            log_streams: LogStreamSettings::default(),
         }
     }
 }
@@ -139,6 +1044,7 @@ impl Default for LetsEncryptSettings {
         Self {
             email: None,
             environment: "production".to_string(),
+            external_accounts: Vec::new(),
         }
     }
 }
@@ -195,13 +1101,114 @@ impl Default for RateLimitSettings {
     }
 }
 
+impl Default for TrustedProxySettings {
+    fn default() -> Self {
+        Self {
+            trusted_cidrs: vec![],
+            tls_terminated_upstream: false,
+        }
+    }
+}
+
 impl Default for DiskSpaceAlertSettings {
     fn default() -> Self {
         Self {
-            enabled: true,               // Enabled by default
-            threshold_percent: 80,       // Alert at 80% usage
-            check_interval_seconds: 300, // Check every 5 minutes
-            monitor_path: None,          // Use data directory by default
+            enabled: true,                  // Enabled by default
+            threshold_percent: 80,          // Alert at 80% usage
+            critical_threshold_percent: 95, // Refuse builds at 95% usage
+            check_interval_seconds: 300,    // Check every 5 minutes
+            monitor_path: None,             // Use data directory by default
+        }
+    }
+}
+
+impl Default for GarbageCollectionSettings {
+    fn default() -> Self {
+        Self {
+            enabled: true,
+            interval_seconds: 6 * 60 * 60, // Every 6 hours
+            retained_images: 3,            // Keep the last 3 images for rollbacks
+        }
+    }
+}
+
+impl Default for WireGuardSettings {
+    fn default() -> Self {
+        Self {
+            enabled: false,
+            endpoint_host: None,
+            listen_port: 51820,
+            interface_name: "wg-temps".to_string(),
+            address_range: "10.66.0.0/24".to_string(),
+            // Docker's default bridge/user network pools
+            default_allowed_subnets: vec!["172.16.0.0/12".to_string()],
+        }
+    }
+}
+
+impl Default for ServiceTunnelSettings {
+    fn default() -> Self {
+        Self {
+            enabled: false,
+            bind_address: "0.0.0.0".to_string(),
+            public_host: None,
+            default_ttl_minutes: 60,
+            max_ttl_minutes: 480,
+            idle_timeout_minutes: 15,
+        }
+    }
+}
+
+impl Default for BuildQueueSettings {
+    fn default() -> Self {
+        Self {
+            max_concurrent_builds: 4,
+            default_project_deploy_concurrency: 2,
+            queue_wait_alert_seconds: 5 * 60, // Queued for 5 minutes
+            slow_build_alert_percent: 150,    // 1.5x the usual build time
+        }
+    }
+}
+
+impl Default for DeploymentRetentionSettings {
+    fn default() -> Self {
+        Self {
+            enabled: true,
+            keep_last: Some(100), // Keep the last 100 deployments per environment
+            max_age_days: None,
+        }
+    }
+}
+
+impl Default for CleanupSettings {
+    fn default() -> Self {
+        Self {
+            window_start_hour: 2, // 2 AM to 6 AM UTC
+            window_end_hour: 6,
+            concurrency: 2,
+            max_deletes_per_second: 20,
+        }
+    }
+}
+
+impl Default for ImageUpdateSettings {
+    fn default() -> Self {
+        Self {
+            base_image_pull_policy: ImagePullPolicy::IfNotPresent,
+            deploy_image_pull_policy: ImagePullPolicy::IfNotPresent,
+            auto_rebuild: false,
+            check_interval_hours: 24,
+        }
+    }
+}
+
+impl Default for ContainerMetricsSettings {
+    fn default() -> Self {
+        Self {
+            enabled: true,
+            collection_interval_seconds: 30,
+            raw_retention_days: 7,
+            hourly_retention_days: 90,
         }
     }
 }
@@ -257,6 +1264,32 @@ impl SecurityHeadersSettings {
     }
 }
 
+impl TrustedProxySettings {
+    /// Entries of `trusted_cidrs` that are neither an IP address nor a CIDR range
+    pub fn invalid_cidrs(&self) -> Vec<&str> {
+        self.trusted_cidrs
+            .iter()
+            .map(|entry| entry.trim())
+            .filter(|entry| !is_valid_ip_or_cidr(entry))
+            .collect()
+    }
+}
+
+fn is_valid_ip_or_cidr(value: &str) -> bool {
+    let (addr, prefix) = match value.split_once('/') {
+        Some((addr, prefix)) => (addr, Some(prefix)),
+        None => (value, None),
+    };
+    let Ok(addr) = addr.parse::<IpAddr>() else {
+        return false;
+    };
+    let max_prefix = if addr.is_ipv4() { 32 } else { 128 };
+    match prefix {
+        Some(prefix) => prefix.parse::<u8>().is_ok_and(|p| p <= max_prefix),
+        None => true,
+    }
+}
+
 impl AppSettings {
     /// Create settings from JSON value, using defaults for missing fields
     pub fn from_json(value: serde_json::Value) -> Self {
@@ -268,3 +1301,82 @@ impl AppSettings {
         serde_json::to_value(self).unwrap_or_else(|_| serde_json::json!({}))
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn pattern(environment: &str, pattern: &str) -> EnvironmentDomainPattern {
+        EnvironmentDomainPattern {
+            environment: environment.to_string(),
+            pattern: pattern.to_string(),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_environment_domain_pattern_render() {
+        let staging = pattern("staging", "{project}-{environment}.Platform.example.com");
+        assert_eq!(
+            staging.render("myapp", "staging").as_deref(),
+            Some("myapp-staging.platform.example.com")
+        );
+        assert_eq!(
+            pattern("*", "{service}.{environment}.example.com")
+                .render("api", "qa")
+                .as_deref(),
+            Some("api.qa.example.com")
+        );
+        assert_eq!(staging.render(&"a".repeat(60), "staging"), None);
+    }
+
+    #[test]
+    fn test_environment_domain_pattern_for() {
+        let settings = EnvironmentDomainSettings {
+            patterns: vec![
+                pattern("*", "{project}-{environment}.example.com"),
+                pattern("staging", "{project}.staging.example.com"),
+            ],
+        };
+        assert_eq!(
+            settings.pattern_for("Staging").unwrap().pattern,
+            "{project}.staging.example.com"
+        );
+        assert_eq!(
+            settings.pattern_for("qa").unwrap().pattern,
+            "{project}-{environment}.example.com"
+        );
+        assert!(EnvironmentDomainSettings::default()
+            .pattern_for("staging")
+            .is_none());
+    }
+
+    #[test]
+    fn test_environment_domain_settings_validation() {
+        let valid = EnvironmentDomainSettings {
+            patterns: vec![pattern("staging", "{project}-staging.example.com")],
+        };
+        assert!(valid.validate().is_ok());
+
+        for invalid in [
+            pattern("", "{project}.example.com"),
+            pattern("staging", "{project}-{branch}.example.com"),
+            pattern("staging", "staging.example.com"),
+            pattern("staging", "{project}"),
+            pattern("staging", "{project}_staging.example.com"),
+        ] {
+            let settings = EnvironmentDomainSettings {
+                patterns: vec![invalid],
+            };
+            assert!(settings.validate().is_err());
+        }
+
+        let duplicated = EnvironmentDomainSettings {
+            patterns: vec![
+                pattern("staging", "{project}-staging.example.com"),
+                pattern("STAGING", "{project}.staging.example.com"),
+            ],
+        };
+        assert!(duplicated.validate().is_err());
+    }
+}
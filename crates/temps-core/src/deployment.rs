@@ -16,3 +16,29 @@ pub trait DeploymentCanceller: Send + Sync {
         environment_id: i32,
     ) -> Result<u64, Box<dyn std::error::Error + Send + Sync>>;
 }
+
+/// Trait for redeploying an environment so it picks up changed configuration
+///
+/// Used by temps-providers, which temps-deployments depends on, to roll the apps
+/// linked to a managed service (e.g. after rotating its credentials, or when the
+/// service recovers from an outage). The ProjectLifecycleService in
+/// temps-deployments implements this trait.
+#[async_trait]
+pub trait EnvironmentRedeployer: Send + Sync {
+    /// Deploy the image of an environment's current deployment again, with the
+    /// environment's current variables, and wait for the deployment to finish.
+    /// Nothing is built from the branch
+    /// Returns the ID of the new deployment, or an error if it didn't succeed
+    async fn redeploy_environment(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<i32, Box<dyn std::error::Error + Send + Sync>>;
+
+    /// Restart the containers of an environment's current deployment
+    async fn restart_environment(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<(), Box<dyn std::error::Error + Send + Sync>>;
+}
@@ -0,0 +1,109 @@
+//! Per-hostname locks for routing changes
+//!
+//! Changes that touch a hostname (creating or editing a domain, pointing a custom
+//! route at it) take the hostname's lock first, so concurrent changes to the same
+//! hostname run one after the other and each sees what the previous one wrote.
+//! Changes to unrelated hostnames don't wait on each other. Several hostnames are
+//! always locked in the same (sorted) order, so two changes can't deadlock.
+
+use std::collections::{BTreeSet, HashMap};
+use std::sync::{Arc, Mutex};
+
+use tokio::sync::{Mutex as AsyncMutex, OwnedMutexGuard};
+
+/// Locks of the hostnames being changed
+#[derive(Debug, Default)]
+pub struct HostnameLocks {
+    locks: Mutex<HashMap<String, Arc<AsyncMutex<()>>>>,
+}
+
+/// Held locks of a set of hostnames; they are released when this is dropped
+#[derive(Debug)]
+pub struct HostnameGuard {
+    hostnames: Vec<String>,
+    _guards: Vec<OwnedMutexGuard<()>>,
+}
+
+impl HostnameGuard {
+    /// Hostnames held, lowercased and sorted
+    pub fn hostnames(&self) -> &[String] {
+        &self.hostnames
+    }
+}
+
+impl HostnameLocks {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Wait for the locks of the hostnames, which are compared case-insensitively
+    pub async fn lock<I, S>(&self, hostnames: I) -> HostnameGuard
+    where
+        I: IntoIterator<Item = S>,
+        S: AsRef<str>,
+    {
+        let hostnames: BTreeSet<String> = hostnames
+            .into_iter()
+            .map(|hostname| {
+                hostname
+                    .as_ref()
+                    .trim()
+                    .trim_end_matches('.')
+                    .to_lowercase()
+            })
+            .filter(|hostname| !hostname.is_empty())
+            .collect();
+
+        let locks: Vec<Arc<AsyncMutex<()>>> = {
+            let mut locks = self.locks.lock().unwrap();
+            // Locks no change holds or waits for anymore
+            locks.retain(|_, lock| Arc::strong_count(lock) > 1);
+            hostnames
+                .iter()
+                .map(|hostname| locks.entry(hostname.clone()).or_default().clone())
+                .collect()
+        };
+
+        let mut guards = Vec::with_capacity(locks.len());
+        for lock in locks {
+            guards.push(lock.lock_owned().await);
+        }
+        HostnameGuard {
+            hostnames: hostnames.into_iter().collect(),
+            _guards: guards,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    #[tokio::test]
+    async fn test_changes_to_a_hostname_wait_for_each_other() {
+        let locks = Arc::new(HostnameLocks::new());
+        let guard = locks.lock(["App.example.com", "www.example.com."]).await;
+        assert_eq!(guard.hostnames(), ["app.example.com", "www.example.com"]);
+
+        // The same hostname, whatever its case, waits for the held lock
+        let waiting = {
+            let locks = locks.clone();
+            tokio::spawn(async move { locks.lock(["app.EXAMPLE.com"]).await })
+        };
+        tokio::time::sleep(Duration::from_millis(20)).await;
+        assert!(!waiting.is_finished());
+
+        // Unrelated hostnames don't
+        let other = tokio::time::timeout(Duration::from_secs(1), locks.lock(["api.example.com"]))
+            .await
+            .expect("unrelated hostnames shouldn't wait");
+        drop(other);
+
+        drop(guard);
+        tokio::time::timeout(Duration::from_secs(1), waiting)
+            .await
+            .expect("the lock should be released")
+            .unwrap();
+    }
+}
@@ -1,5 +1,6 @@
 use serde::{Deserialize, Serialize};
 use std::fmt;
+use utoipa::ToSchema;
 
 #[derive(Debug, Deserialize, Serialize, Clone)]
 pub struct GitPushEventJob {
@@ -9,6 +10,23 @@ pub struct GitPushEventJob {
     pub tag: Option<String>,
     pub commit: String,
     pub project_id: i32,
+    /// Environment to deploy to; when not set it is resolved from the branch
+    /// (or, for tag pushes, from the environments' tag patterns)
+    #[serde(default)]
+    pub environment_id: Option<i32>,
+    /// Started by a person rather than by a push; such deploys go ahead of others
+    /// in the build queue
+    #[serde(default)]
+    pub manual: bool,
+    /// Release note given for a manual deploy; pushes fall back to the commit message
+    #[serde(default)]
+    pub release_note: Option<String>,
+    /// User who started a manual deploy
+    #[serde(default)]
+    pub triggered_by: Option<i32>,
+    /// Build and check the deployment without shipping it
+    #[serde(default)]
+    pub dry_run: bool,
 }
 
 #[derive(Debug, Deserialize, Serialize, Clone)]
@@ -106,6 +124,28 @@ pub struct DeploymentCreatedJob {
     pub branch: Option<String>,
 }
 
+/// How a successful deployment stands in its environment's history
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Hash, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum DeploymentSuccessKind {
+    /// The environment's first successful deployment
+    First,
+    /// The first success after the environment's last deployment failed
+    Recovery,
+    #[default]
+    Routine,
+}
+
+impl fmt::Display for DeploymentSuccessKind {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            DeploymentSuccessKind::First => write!(f, "first"),
+            DeploymentSuccessKind::Recovery => write!(f, "recovery"),
+            DeploymentSuccessKind::Routine => write!(f, "routine"),
+        }
+    }
+}
+
 /// Job for when a deployment succeeds
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct DeploymentSucceededJob {
@@ -115,6 +155,8 @@ pub struct DeploymentSucceededJob {
     pub environment_name: String,
     pub commit_sha: Option<String>,
     pub url: Option<String>,
+    #[serde(default)]
+    pub kind: DeploymentSuccessKind,
 }
 
 /// Job for when a deployment fails
@@ -146,6 +188,30 @@ pub struct DeploymentReadyJob {
     pub url: Option<String>,
 }
 
+/// Job for when a deployment has waited in the build queue longer than the alert threshold
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DeploymentQueueDelayedJob {
+    pub deployment_id: i32,
+    pub project_id: i32,
+    pub environment_id: i32,
+    pub environment_name: String,
+    /// Position among deployments waiting for a build slot, starting at 1
+    pub queue_position: u32,
+    pub waiting_seconds: u64,
+}
+
+/// Job for when a running deployment takes longer than its environment usually does
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DeploymentRunningLongJob {
+    pub deployment_id: i32,
+    pub project_id: i32,
+    pub environment_id: i32,
+    pub environment_name: String,
+    pub running_seconds: u64,
+    /// Average duration of the environment's recent successful deployments
+    pub typical_seconds: u64,
+}
+
 /// Job for when a domain is created
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct DomainCreatedJob {
@@ -203,6 +269,8 @@ pub enum Job {
     DeploymentFailed(DeploymentFailedJob),
     DeploymentCancelled(DeploymentCancelledJob),
     DeploymentReady(DeploymentReadyJob),
+    DeploymentQueueDelayed(DeploymentQueueDelayedJob),
+    DeploymentRunningLong(DeploymentRunningLongJob),
     // Domain events
     DomainCreated(DomainCreatedJob),
     DomainProvisioned(DomainProvisionedJob),
@@ -239,10 +307,12 @@ impl fmt::Display for Job {
             Job::EnvironmentDeleted(job) => write!(f, "EnvironmentDeleted(id: {}, name: {}, project: {})", job.environment_id, job.environment_name, job.project_id),
             Job::MonitorCreated(job) => write!(f, "MonitorCreated(id: {}, name: {}, env: {}, project: {})", job.monitor_id, job.monitor_name, job.environment_id, job.project_id),
             Job::DeploymentCreated(job) => write!(f, "DeploymentCreated(id: {}, env: {}, project: {})", job.deployment_id, job.environment_id, job.project_id),
-            Job::DeploymentSucceeded(job) => write!(f, "DeploymentSucceeded(id: {}, env: {}, project: {})", job.deployment_id, job.environment_id, job.project_id),
+            Job::DeploymentSucceeded(job) => write!(f, "DeploymentSucceeded(id: {}, env: {}, project: {}, kind: {})", job.deployment_id, job.environment_id, job.project_id, job.kind),
             Job::DeploymentFailed(job) => write!(f, "DeploymentFailed(id: {}, env: {}, project: {}, error: {:?})", job.deployment_id, job.environment_id, job.project_id, job.error_message),
             Job::DeploymentCancelled(job) => write!(f, "DeploymentCancelled(id: {}, env: {}, project: {})", job.deployment_id, job.environment_id, job.project_id),
             Job::DeploymentReady(job) => write!(f, "DeploymentReady(id: {}, env: {}, project: {}, url: {:?})", job.deployment_id, job.environment_id, job.project_id, job.url),
+            Job::DeploymentQueueDelayed(job) => write!(f, "DeploymentQueueDelayed(id: {}, env: {}, project: {}, position: {}, waiting: {}s)", job.deployment_id, job.environment_id, job.project_id, job.queue_position, job.waiting_seconds),
+            Job::DeploymentRunningLong(job) => write!(f, "DeploymentRunningLong(id: {}, env: {}, project: {}, running: {}s, typical: {}s)", job.deployment_id, job.environment_id, job.project_id, job.running_seconds, job.typical_seconds),
             Job::DomainCreated(job) => write!(f, "DomainCreated(id: {}, name: {}, project: {})", job.domain_id, job.domain_name, job.project_id),
             Job::DomainProvisioned(job) => write!(f, "DomainProvisioned(id: {}, name: {}, project: {})", job.domain_id, job.domain_name, job.project_id),
             Job::VulnerabilityScanCompleted(job) => write!(f, "VulnerabilityScanCompleted(id: {}, project: {}, env: {:?}, total: {}, critical: {}, high: {})", job.scan_id, job.project_id, job.environment_id, job.total_vulnerabilities, job.critical_count, job.high_count),
@@ -8,6 +8,7 @@ pub mod error_builder;
 pub mod jobs;
 pub mod notifications;
 pub mod openapi;
+pub mod pagination;
 pub mod plugin;
 pub mod problemdetails;
 pub use problemdetails::ProblemDetails;
@@ -15,6 +16,7 @@ mod app_settings;
 mod constants;
 mod cookie_crypto;
 mod encryption;
+mod hostname_locks;
 pub mod repo_config;
 mod request_metadata;
 pub mod stages;
@@ -36,13 +38,20 @@ pub use utils::*;
 // Re-export external dependencies
 pub use anyhow;
 pub use app_settings::{
-    AppSettings, DiskSpaceAlertSettings, DnsProviderSettings, DockerRegistrySettings,
-    LetsEncryptSettings, RateLimitSettings, ScreenshotSettings, SecurityHeadersSettings,
+    AcmeExternalAccount, AppSettings, BuildNode, BuildNodeSettings, BuildNodeTls,
+    BuildQueueSettings, CleanupSettings, ContainerMetricsSettings, CronSettings,
+    DeployIdempotencySettings, DeployRetrySettings, DeploymentRetentionSettings,
+    DiskSpaceAlertSettings, DnsProviderSettings, DockerRegistrySettings, EnvironmentDomainPattern,
+    EnvironmentDomainSettings, GarbageCollectionSettings, HealthMonitorSettings, ImagePullPolicy,
+    ImageUpdateSettings, LetsEncryptSettings, LogStreamSettings, RateLimitSettings,
+    S3UploadSettings, ScreenshotSettings, SecurityHeadersSettings, ServiceReadinessSettings,
+    ServiceTunnelSettings, TrustedProxySettings, WireGuardSettings,
 };
 pub use async_trait;
 pub use chrono;
 pub use cookie_crypto::{CookieCrypto, CryptoError};
 pub use encryption::EncryptionService;
+pub use hostname_locks::{HostnameGuard, HostnameLocks};
 pub use repo_config::*;
 pub use request_metadata::RequestMetadata;
 pub use serde;
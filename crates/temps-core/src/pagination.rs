@@ -0,0 +1,435 @@
+//! Cursor pagination, sorting and filtering for list endpoints
+//!
+//! List endpoints share these query parameters:
+//! - `cursor`: where the page starts, as returned in `next_cursor` by the page before.
+//!   Pass it empty to get the first page.
+//! - `limit`: items per page, 20 unless set and at most 100
+//! - `sort`: field to sort by, prefixed with `-` for descending (`-created_at`)
+//! - `filter`: comma-separated `field:op:value` clauses that must all match, such as
+//!   `state:eq:failed,created_at:gte:2026-01-01T00:00:00Z`. The operators are `eq`,
+//!   `ne`, `gt`, `gte`, `lt`, `lte`, `in` (values separated by `|`) and `contains`.
+//!
+//! and answer with the same envelope, [`CursorPage`]: `items`, `next_cursor` (unset
+//! on the last page) and `total` where counting is cheap. Endpoints that answered
+//! with their own page format before keep doing so until a `cursor` is passed.
+//!
+//! Pages are read by keyset: the cursor holds the sort value and id of the last item
+//! of a page, and the next page starts right after it, so deep pages cost the same as
+//! the first and nothing beyond the page is read.
+
+use axum::http::StatusCode;
+use base64::{engine::general_purpose::URL_SAFE_NO_PAD, Engine as _};
+use serde::{Deserialize, Serialize};
+use utoipa::{IntoParams, ToSchema};
+
+use crate::problemdetails::{self, Problem};
+
+/// Items per page unless the request sets a limit
+pub const DEFAULT_LIST_LIMIT: u64 = 20;
+
+/// Most items a page can hold
+pub const MAX_LIST_LIMIT: u64 = 100;
+
+/// Query parameters shared by list endpoints
+#[derive(Debug, Clone, Default, Deserialize, IntoParams)]
+#[into_params(parameter_in = Query)]
+pub struct ListParams {
+    /// Start of the page, from `next_cursor` of the page before; empty for the first page
+    pub cursor: Option<String>,
+    /// Items per page (default: 20, max: 100)
+    pub limit: Option<u64>,
+    /// Field to sort by; prefix with `-` for descending
+    #[param(example = "-created_at")]
+    pub sort: Option<String>,
+    /// Comma-separated `field:op:value` clauses that must all match
+    #[param(example = "state:eq:failed")]
+    pub filter: Option<String>,
+}
+
+/// A page of a list
+#[derive(Debug, Serialize, ToSchema)]
+pub struct CursorPage<T> {
+    pub items: Vec<T>,
+    /// Cursor of the next page; unset on the last page
+    pub next_cursor: Option<String>,
+    /// Items matching the filter across all pages, where counting is cheap
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub total: Option<u64>,
+}
+
+#[derive(Debug, Clone, PartialEq, thiserror::Error)]
+pub enum ListError {
+    #[error("Invalid cursor; pass the next_cursor of a page listed with the same sort")]
+    InvalidCursor,
+    #[error("Invalid sort: {0}")]
+    InvalidSort(String),
+    #[error("Invalid filter: {0}")]
+    InvalidFilter(String),
+}
+
+impl From<ListError> for Problem {
+    fn from(error: ListError) -> Self {
+        problemdetails::new(StatusCode::BAD_REQUEST)
+            .with_type("https://temps.sh/probs/invalid-list-query")
+            .with_title("Invalid List Query")
+            .with_detail(error.to_string())
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum SortOrder {
+    Asc,
+    Desc,
+}
+
+/// Field a list is sorted by
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Sort {
+    pub field: String,
+    pub order: SortOrder,
+}
+
+impl Sort {
+    pub fn asc(field: &str) -> Self {
+        Self {
+            field: field.to_string(),
+            order: SortOrder::Asc,
+        }
+    }
+
+    pub fn desc(field: &str) -> Self {
+        Self {
+            field: field.to_string(),
+            order: SortOrder::Desc,
+        }
+    }
+
+    /// Parse `field` or `-field`, which must be one of the sortable fields
+    pub fn parse(input: &str, fields: &[&str]) -> Result<Self, ListError> {
+        let (field, order) = match input.trim().strip_prefix('-') {
+            Some(field) => (field, SortOrder::Desc),
+            None => (input.trim().trim_start_matches('+'), SortOrder::Asc),
+        };
+        if !fields.contains(&field) {
+            return Err(ListError::InvalidSort(format!(
+                "can't sort by '{}'; sortable fields are {}",
+                field,
+                fields.join(", ")
+            )));
+        }
+        Ok(Self {
+            field: field.to_string(),
+            order,
+        })
+    }
+
+    /// The sort as written in the `sort` parameter
+    pub fn as_param(&self) -> String {
+        match self.order {
+            SortOrder::Asc => self.field.clone(),
+            SortOrder::Desc => format!("-{}", self.field),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum FilterOp {
+    Eq,
+    Ne,
+    Gt,
+    Gte,
+    Lt,
+    Lte,
+    In,
+    Contains,
+}
+
+impl FilterOp {
+    fn parse(op: &str) -> Option<Self> {
+        Some(match op {
+            "eq" => FilterOp::Eq,
+            "ne" => FilterOp::Ne,
+            "gt" => FilterOp::Gt,
+            "gte" => FilterOp::Gte,
+            "lt" => FilterOp::Lt,
+            "lte" => FilterOp::Lte,
+            "in" => FilterOp::In,
+            "contains" => FilterOp::Contains,
+            _ => return None,
+        })
+    }
+}
+
+/// One `field:op:value` clause of a filter
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct FilterClause {
+    pub field: String,
+    pub op: FilterOp,
+    /// The value; several for `in`
+    pub values: Vec<String>,
+}
+
+/// Parse a filter whose clauses may only use the filterable fields
+///
+/// Values may contain `:`, so timestamps need no escaping, but not `,`.
+pub fn parse_filter(input: &str, fields: &[&str]) -> Result<Vec<FilterClause>, ListError> {
+    let mut clauses = Vec::new();
+    for clause in input.split(',').map(str::trim).filter(|c| !c.is_empty()) {
+        let mut parts = clause.splitn(3, ':');
+        let (Some(field), Some(op), Some(value)) = (parts.next(), parts.next(), parts.next())
+        else {
+            return Err(ListError::InvalidFilter(format!(
+                "'{}' is not field:op:value",
+                clause
+            )));
+        };
+        if !fields.contains(&field) {
+            return Err(ListError::InvalidFilter(format!(
+                "can't filter by '{}'; filterable fields are {}",
+                field,
+                fields.join(", ")
+            )));
+        }
+        let op = FilterOp::parse(op)
+            .ok_or_else(|| ListError::InvalidFilter(format!("unknown operator '{}'", op)))?;
+        let values = match op {
+            FilterOp::In => value.split('|').map(str::to_string).collect(),
+            _ => vec![value.to_string()],
+        };
+        clauses.push(FilterClause {
+            field: field.to_string(),
+            op,
+            values,
+        });
+    }
+    Ok(clauses)
+}
+
+/// Position of the last item of a page
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Cursor {
+    /// Sort the page was listed with, as in the `sort` parameter
+    pub sort: String,
+    /// Sort value of the last item
+    pub value: serde_json::Value,
+    /// Id of the last item, which orders items with the same sort value
+    pub id: i64,
+}
+
+impl Cursor {
+    pub fn encode(&self) -> String {
+        URL_SAFE_NO_PAD.encode(serde_json::to_vec(self).unwrap_or_default())
+    }
+
+    pub fn decode(input: &str) -> Result<Self, ListError> {
+        let bytes = URL_SAFE_NO_PAD
+            .decode(input.trim())
+            .map_err(|_| ListError::InvalidCursor)?;
+        serde_json::from_slice(&bytes).map_err(|_| ListError::InvalidCursor)
+    }
+}
+
+/// What a list endpoint lets requests sort and filter by
+pub struct ListSpec {
+    pub sort_fields: &'static [&'static str],
+    pub filter_fields: &'static [&'static str],
+    /// Sort when the request doesn't set one
+    pub default_sort: fn() -> Sort,
+}
+
+/// A validated list request
+#[derive(Debug, Clone, PartialEq)]
+pub struct ListRequest {
+    /// Where the page starts; the first page when unset
+    pub cursor: Option<Cursor>,
+    pub limit: u64,
+    pub sort: Sort,
+    pub filters: Vec<FilterClause>,
+}
+
+impl ListParams {
+    /// Whether the request pages by cursor (and expects a [`CursorPage`])
+    pub fn uses_cursor(&self) -> bool {
+        self.cursor.is_some()
+    }
+
+    pub fn resolve(&self, spec: &ListSpec) -> Result<ListRequest, ListError> {
+        let sort = match self.sort.as_deref().filter(|sort| !sort.trim().is_empty()) {
+            Some(sort) => Sort::parse(sort, spec.sort_fields)?,
+            None => (spec.default_sort)(),
+        };
+        let cursor = match self.cursor.as_deref().filter(|c| !c.trim().is_empty()) {
+            Some(cursor) => {
+                let cursor = Cursor::decode(cursor)?;
+                // A cursor only points into the order it was made for
+                if cursor.sort != sort.as_param() {
+                    return Err(ListError::InvalidCursor);
+                }
+                Some(cursor)
+            }
+            None => None,
+        };
+        let filters = match self.filter.as_deref() {
+            Some(filter) => parse_filter(filter, spec.filter_fields)?,
+            None => Vec::new(),
+        };
+        Ok(ListRequest {
+            cursor,
+            limit: self
+                .limit
+                .unwrap_or(DEFAULT_LIST_LIMIT)
+                .clamp(1, MAX_LIST_LIMIT),
+            sort,
+            filters,
+        })
+    }
+}
+
+impl<T> CursorPage<T> {
+    /// Page from the rows read after the cursor, which are read one past the limit
+    /// so the extra row tells whether another page follows; `key` gives the sort
+    /// value and id of a row
+    pub fn from_rows<F>(mut rows: Vec<T>, request: &ListRequest, key: F) -> Self
+    where
+        F: Fn(&T) -> (serde_json::Value, i64),
+    {
+        let limit = request.limit as usize;
+        let next_cursor = if rows.len() > limit {
+            rows.truncate(limit);
+            rows.last().map(|last| {
+                let (value, id) = key(last);
+                Cursor {
+                    sort: request.sort.as_param(),
+                    value,
+                    id,
+                }
+                .encode()
+            })
+        } else {
+            None
+        };
+        Self {
+            items: rows,
+            next_cursor,
+            total: None,
+        }
+    }
+
+    pub fn with_total(mut self, total: u64) -> Self {
+        self.total = Some(total);
+        self
+    }
+
+    pub fn map<U, F: FnMut(T) -> U>(self, f: F) -> CursorPage<U> {
+        CursorPage {
+            items: self.items.into_iter().map(f).collect(),
+            next_cursor: self.next_cursor,
+            total: self.total,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn newest_first() -> Sort {
+        Sort::desc("created_at")
+    }
+
+    const SPEC: ListSpec = ListSpec {
+        sort_fields: &["created_at", "id"],
+        filter_fields: &["state", "created_at"],
+        default_sort: newest_first,
+    };
+
+    #[test]
+    fn test_parse_sort_and_filter() {
+        assert_eq!(
+            Sort::parse("-created_at", SPEC.sort_fields).unwrap(),
+            Sort::desc("created_at")
+        );
+        assert_eq!(
+            Sort::parse("id", SPEC.sort_fields).unwrap(),
+            Sort::asc("id")
+        );
+        assert!(matches!(
+            Sort::parse("-name", SPEC.sort_fields),
+            Err(ListError::InvalidSort(_))
+        ));
+
+        let filters = parse_filter(
+            "state:in:failed|cancelled, created_at:gte:2026-01-01T00:00:00Z",
+            SPEC.filter_fields,
+        )
+        .unwrap();
+        assert_eq!(
+            filters,
+            vec![
+                FilterClause {
+                    field: "state".to_string(),
+                    op: FilterOp::In,
+                    values: vec!["failed".to_string(), "cancelled".to_string()],
+                },
+                FilterClause {
+                    field: "created_at".to_string(),
+                    op: FilterOp::Gte,
+                    values: vec!["2026-01-01T00:00:00Z".to_string()],
+                },
+            ]
+        );
+        assert!(parse_filter("state:eq", SPEC.filter_fields).is_err());
+        assert!(parse_filter("state:like:x", SPEC.filter_fields).is_err());
+        assert!(parse_filter("branch:eq:main", SPEC.filter_fields).is_err());
+    }
+
+    #[test]
+    fn test_pages_follow_each_other() {
+        let params = ListParams {
+            cursor: Some(String::new()),
+            limit: Some(2),
+            ..Default::default()
+        };
+        assert!(params.uses_cursor());
+        let request = params.resolve(&SPEC).unwrap();
+        assert_eq!(request.cursor, None);
+        assert_eq!(request.sort, Sort::desc("created_at"));
+
+        // Three rows read for a limit of two: there is a next page
+        let key = |id: &i64| (serde_json::json!(id * 10), *id);
+        let page = CursorPage::from_rows(vec![5i64, 4, 3], &request, key);
+        assert_eq!(page.items, vec![5, 4]);
+        let next_cursor = page.next_cursor.expect("a next page");
+
+        let next = ListParams {
+            cursor: Some(next_cursor.clone()),
+            limit: Some(2),
+            ..Default::default()
+        }
+        .resolve(&SPEC)
+        .unwrap();
+        assert_eq!(
+            next.cursor,
+            Some(Cursor {
+                sort: "-created_at".to_string(),
+                value: serde_json::json!(40),
+                id: 4,
+            })
+        );
+        let last = CursorPage::from_rows(vec![3i64], &next, key);
+        assert!(last.next_cursor.is_none());
+
+        // The cursor doesn't carry over to another sort
+        let resorted = ListParams {
+            cursor: Some(next_cursor),
+            sort: Some("id".to_string()),
+            ..Default::default()
+        };
+        assert_eq!(resorted.resolve(&SPEC), Err(ListError::InvalidCursor));
+        let garbage = ListParams {
+            cursor: Some("not-a-cursor".to_string()),
+            ..Default::default()
+        };
+        assert_eq!(garbage.resolve(&SPEC), Err(ListError::InvalidCursor));
+    }
+}
@@ -37,6 +37,11 @@ pub struct CronJobConfig {
     /// Example: "0 0 * * *" (daily at midnight)
     pub schedule: String,
 
+    /// IANA timezone the schedule is in, e.g. "Europe/Madrid"; defaults to the
+    /// platform's cron timezone
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub timezone: Option<String>,
+
     /// Optional name/description for the cron job
     #[serde(skip_serializing_if = "Option::is_none")]
     pub name: Option<String>,
@@ -149,6 +154,7 @@ cron:
     name: "Daily Cleanup"
   - path: /api/cron/reports
     schedule: "0 9 * * 1"
+    timezone: Europe/Madrid
     name: "Weekly Reports"
 
 build:
@@ -181,6 +187,8 @@ health:
         assert_eq!(crons[0].name.as_deref(), Some("Daily Cleanup"));
         assert_eq!(crons[1].path, "/api/cron/reports");
         assert_eq!(crons[1].schedule, "0 9 * * 1");
+        assert_eq!(crons[0].timezone, None);
+        assert_eq!(crons[1].timezone.as_deref(), Some("Europe/Madrid"));
 
         // Verify build config
         assert!(config.has_build_config());
@@ -254,6 +262,7 @@ build:
             cron: Some(vec![CronJobConfig {
                 path: "/api/cron/test".to_string(),
                 schedule: "0 0 * * *".to_string(),
+                timezone: None,
                 name: Some("Test Cron".to_string()),
             }]),
             build: None,
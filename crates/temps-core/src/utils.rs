@@ -59,6 +59,48 @@ pub fn slugify_branch_name(branch: &str) -> String {
         .collect()
 }
 
+/// Match a git ref name (tag or branch) against a glob pattern.
+///
+/// `*` matches any sequence of characters (including none) and `?` matches exactly
+/// one character. Matching is case-sensitive, like git ref names.
+///
+/// # Examples
+///
+/// ```
+/// use temps_core::ref_matches_pattern;
+///
+/// assert!(ref_matches_pattern("v*", "v1.2.0"));
+/// assert!(ref_matches_pattern("release-?.?", "release-1.2"));
+/// assert!(!ref_matches_pattern("v*", "nightly-2024"));
+/// ```
+pub fn ref_matches_pattern(pattern: &str, name: &str) -> bool {
+    let pattern: Vec<char> = pattern.chars().collect();
+    let name: Vec<char> = name.chars().collect();
+
+    let (mut p, mut n) = (0, 0);
+    // Position of the last `*` in the pattern and the name index it was matched at
+    let mut backtrack: Option<(usize, usize)> = None;
+
+    while n < name.len() {
+        if p < pattern.len() && (pattern[p] == '?' || pattern[p] == name[n]) {
+            p += 1;
+            n += 1;
+        } else if p < pattern.len() && pattern[p] == '*' {
+            backtrack = Some((p, n));
+            p += 1;
+        } else if let Some((star, matched)) = backtrack {
+            // Let the last `*` absorb one more character and retry
+            p = star + 1;
+            n = matched + 1;
+            backtrack = Some((star, n));
+        } else {
+            return false;
+        }
+    }
+
+    pattern[p..].iter().all(|c| *c == '*')
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -95,6 +137,22 @@ mod tests {
         assert_eq!(result.len(), 63);
     }
 
+    #[test]
+    fn test_ref_matches_pattern() {
+        assert!(ref_matches_pattern("v*", "v1.0.0"));
+        assert!(ref_matches_pattern("v*", "v"));
+        assert!(ref_matches_pattern("*", "anything"));
+        assert!(ref_matches_pattern("v*.*.*", "v1.20.3"));
+        assert!(ref_matches_pattern("release/*-rc?", "release/2.0-rc1"));
+        assert!(ref_matches_pattern("v1.0.0", "v1.0.0"));
+
+        assert!(!ref_matches_pattern("v*", "1.0.0"));
+        assert!(!ref_matches_pattern("v*.*.*", "v1.2"));
+        assert!(!ref_matches_pattern("release-?", "release-10"));
+        assert!(!ref_matches_pattern("V*", "v1.0.0"));
+        assert!(!ref_matches_pattern("", "v1"));
+    }
+
     #[test]
     fn test_slugify_handles_empty() {
         assert_eq!(slugify_branch_name(""), "");
@@ -30,6 +30,10 @@ pub enum WorkflowError {
     #[error("Build was cancelled")]
     BuildCancelled,
 
+    /// The workflow ran past its timeout; `phase` names the jobs still running
+    #[error("Deploy timed out after {timeout_seconds}s during {phase}")]
+    WorkflowTimedOut { phase: String, timeout_seconds: u64 },
+
     #[error("IO error: {0}")]
     IoError(#[from] std::io::Error),
 
@@ -436,6 +440,9 @@ pub struct WorkflowConfig {
     pub max_parallel_jobs: usize,
     /// Log writer for workflow execution
     pub log_writer: Arc<dyn LogWriter>,
+    /// Time the whole workflow may take; running jobs are aborted and cleaned up
+    /// when it's exceeded
+    pub timeout: Option<std::time::Duration>,
 }
 
 impl std::fmt::Debug for WorkflowConfig {
@@ -450,6 +457,7 @@ impl std::fmt::Debug for WorkflowConfig {
             .field("continue_on_failure", &self.continue_on_failure)
             .field("max_parallel_jobs", &self.max_parallel_jobs)
             .field("log_writer", &"<LogWriter>")
+            .field("timeout", &self.timeout)
             .finish()
     }
 }
@@ -465,6 +473,7 @@ pub struct WorkflowBuilder {
     continue_on_failure: bool,
     max_parallel_jobs: usize,
     log_writer: Option<Arc<dyn LogWriter>>,
+    timeout: Option<std::time::Duration>,
 }
 
 impl WorkflowBuilder {
@@ -479,6 +488,7 @@ impl WorkflowBuilder {
             continue_on_failure: true,
             max_parallel_jobs: 1, // Sequential by default
             log_writer: None,
+            timeout: None,
         }
     }
 
@@ -593,6 +603,12 @@ impl WorkflowBuilder {
         self
     }
 
+    /// Set the time the whole workflow may take
+    pub fn with_timeout(mut self, timeout: std::time::Duration) -> Self {
+        self.timeout = Some(timeout);
+        self
+    }
+
     /// Build the workflow configuration
     pub fn build(self) -> Result<WorkflowConfig, WorkflowError> {
         let workflow_run_id = self.workflow_run_id.ok_or_else(|| {
@@ -625,6 +641,7 @@ impl WorkflowBuilder {
             continue_on_failure: self.continue_on_failure,
             max_parallel_jobs: self.max_parallel_jobs,
             log_writer,
+            timeout: self.timeout,
         })
     }
 }
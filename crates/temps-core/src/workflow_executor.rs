@@ -4,12 +4,15 @@
 
 use crate::workflow::{
     JobConfig, JobResult, JobStatus, WorkflowCancellationProvider, WorkflowConfig, WorkflowContext,
-    WorkflowError,
+    WorkflowError, WorkflowTask,
 };
 use futures::future::join_all;
 use std::collections::{HashMap, HashSet, VecDeque};
 use std::sync::Arc;
+use std::time::Duration;
 use tokio::sync::Semaphore;
+use tokio::task::JoinHandle;
+use tokio::time::Instant;
 use tracing::{debug, error, info, warn};
 
 /// Workflow executor that handles job dependencies and parallel execution
@@ -114,6 +117,11 @@ impl WorkflowExecutor {
         // Create a semaphore to limit parallel execution
         let semaphore = Arc::new(Semaphore::new(config.max_parallel_jobs));
 
+        // Jobs still running once the workflow's timeout is up are aborted
+        let deadline = config
+            .timeout
+            .map(|timeout| (Instant::now() + timeout, timeout));
+
         // Execute jobs in dependency order
         for batch in execution_order {
             // Check for cancellation
@@ -152,6 +160,7 @@ impl WorkflowExecutor {
                     &semaphore,
                     cancellation_provider.clone(),
                     config.continue_on_failure,
+                    deadline,
                 )
                 .await?;
 
@@ -428,10 +437,12 @@ impl WorkflowExecutor {
         semaphore: &Arc<Semaphore>,
         cancellation_provider: Arc<dyn WorkflowCancellationProvider>,
         continue_on_failure: bool,
+        deadline: Option<(Instant, Duration)>,
     ) -> Result<Vec<(String, JobResult)>, WorkflowError> {
         info!("▶️ Executing job batch: {:?}", batch);
 
         let mut tasks = Vec::new();
+        let mut running = Vec::new();
 
         for job_id in batch {
             if let Some(job_state) = job_states.get_mut(&job_id) {
@@ -563,11 +574,27 @@ impl WorkflowExecutor {
                 });
 
                 tasks.push(task);
+                running.push(RunningJob {
+                    job: job_state.job_config.job.clone(),
+                    execution_id: job_state.execution_id,
+                });
             }
         }
 
-        // Wait for all tasks to complete
-        let results = join_all(tasks).await;
+        // Wait for all tasks to complete, or abort them at the workflow's deadline
+        let results = match deadline {
+            Some((deadline, timeout)) => {
+                match tokio::time::timeout_at(deadline, join_all(tasks.iter_mut())).await {
+                    Ok(results) => results,
+                    Err(_) => {
+                        return Err(self
+                            .abort_timed_out_jobs(tasks, running, context, timeout)
+                            .await)
+                    }
+                }
+            }
+            None => join_all(tasks.iter_mut()).await,
+        };
         let mut job_results = Vec::new();
 
         for result in results {
@@ -587,6 +614,89 @@ impl WorkflowExecutor {
 
         Ok(job_results)
     }
+
+    /// Abort the jobs of a batch still running at the workflow's deadline
+    ///
+    /// Aborted jobs are cleaned up and marked failed, and the pending jobs of the
+    /// workflow are cancelled. Returns the error naming the jobs that timed out.
+    async fn abort_timed_out_jobs(
+        &self,
+        tasks: Vec<JoinHandle<(String, JobResult)>>,
+        running: Vec<RunningJob>,
+        context: &WorkflowContext,
+        timeout: Duration,
+    ) -> WorkflowError {
+        let mut timed_out = Vec::new();
+
+        for (task, running_job) in tasks.into_iter().zip(running) {
+            let RunningJob { job, execution_id } = running_job;
+            let finished = task.is_finished();
+            if !finished {
+                task.abort();
+            }
+            // An aborted task resolves once the job's future is dropped
+            let result = task.await;
+
+            let (status, message) = match result {
+                Ok((_, job_result)) if finished => (job_result.status, job_result.message),
+                _ => {
+                    warn!(
+                        "⏱️ Job '{}' timed out after {}s, aborting",
+                        job.job_id(),
+                        timeout.as_secs()
+                    );
+                    let _ = context
+                        .log(&format!(
+                            "⏱️ Deploy timed out after {}s while '{}' was running",
+                            timeout.as_secs(),
+                            job.name()
+                        ))
+                        .await;
+                    if let Err(e) = job.cleanup(context).await {
+                        error!("Failed to cleanup job '{}': {}", job.job_id(), e);
+                    }
+                    timed_out.push(format!("'{}'", job.name()));
+                    (
+                        JobStatus::Failure,
+                        Some(format!("Timed out after {}s", timeout.as_secs())),
+                    )
+                }
+            };
+
+            if let (Some(tracker), Some(execution_id)) = (&self.job_tracker, execution_id) {
+                if let Err(e) = tracker
+                    .update_job_status(execution_id, status, message)
+                    .await
+                {
+                    error!("Failed to update job {} status: {}", job.job_id(), e);
+                }
+            }
+        }
+
+        let error = WorkflowError::WorkflowTimedOut {
+            phase: if timed_out.is_empty() {
+                "the workflow".to_string()
+            } else {
+                timed_out.join(", ")
+            },
+            timeout_seconds: timeout.as_secs(),
+        };
+        if let Some(ref tracker) = self.job_tracker {
+            if let Err(e) = tracker
+                .cancel_pending_jobs(&context.workflow_run_id, error.to_string())
+                .await
+            {
+                error!("Failed to cancel pending jobs: {}", e);
+            }
+        }
+        error
+    }
+}
+
+/// A job spawned in the current batch
+struct RunningJob {
+    job: Arc<dyn WorkflowTask>,
+    execution_id: Option<i32>,
 }
 
 #[cfg(test)]
@@ -796,4 +906,63 @@ mod tests {
             panic!("Expected DependencyCycleDetected error");
         }
     }
+
+    /// Job that never finishes on its own
+    #[derive(Debug)]
+    struct HangingJob {
+        cleaned_up: Arc<AtomicUsize>,
+    }
+
+    #[async_trait::async_trait]
+    impl WorkflowTask for HangingJob {
+        fn job_id(&self) -> &str {
+            "health_check"
+        }
+
+        fn name(&self) -> &str {
+            "Health Check"
+        }
+
+        fn description(&self) -> &str {
+            "Waits forever"
+        }
+
+        async fn execute(&self, _context: WorkflowContext) -> Result<JobResult, WorkflowError> {
+            std::future::pending().await
+        }
+
+        async fn cleanup(&self, _context: &WorkflowContext) -> Result<(), WorkflowError> {
+            self.cleaned_up.fetch_add(1, Ordering::SeqCst);
+            Ok(())
+        }
+    }
+
+    #[tokio::test]
+    async fn test_workflow_timeout_aborts_running_jobs() {
+        let cleaned_up = Arc::new(AtomicUsize::new(0));
+        let config = WorkflowBuilder::new()
+            .with_workflow_run_id("test-workflow".to_string())
+            .with_deployment_context(1, 1, 1)
+            .with_log_writer(Arc::new(MockLogWriter))
+            .with_job(Arc::new(HangingJob {
+                cleaned_up: cleaned_up.clone(),
+            }))
+            .with_timeout(std::time::Duration::from_millis(50))
+            .build()
+            .unwrap();
+
+        let executor = WorkflowExecutor::new(None);
+        let cancellation_provider = Arc::new(TestCancellationProvider { cancelled: false });
+        let result = executor
+            .execute_workflow(config, cancellation_provider)
+            .await;
+
+        match result {
+            Err(WorkflowError::WorkflowTimedOut { phase, .. }) => {
+                assert_eq!(phase, "'Health Check'")
+            }
+            other => panic!("Expected WorkflowTimedOut, got {:?}", other.map(|_| ())),
+        }
+        assert_eq!(cleaned_up.load(Ordering::SeqCst), 1);
+    }
 }
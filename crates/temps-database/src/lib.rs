@@ -2,6 +2,7 @@
 
 pub use sea_orm;
 mod connection;
+pub mod queries;
 
 pub use connection::{establish_connection, DbConnection};
 
@@ -1,5 +1,13 @@
 //! Common database query utilities
 
+use sea_orm::sea_query::Condition;
+use sea_orm::{
+    ColumnTrait, EntityTrait, ModelTrait, Order, QueryFilter, QueryOrder, QuerySelect, Select,
+    Value,
+};
+use temps_core::chrono::{DateTime, Utc};
+use temps_core::pagination::{CursorPage, FilterOp, ListError, ListRequest, SortOrder};
+use temps_core::serde_json;
 use temps_core::PaginationParams;
 
 /// Normalize pagination parameters
@@ -9,3 +17,270 @@ pub fn normalize_pagination(params: PaginationParams) -> (u64, u64) {
 
 /// Placeholder for future query utilities
 pub struct QueryUtils;
+
+/// Type of a list field, which decides how filter and cursor values are read
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ListFieldKind {
+    Integer,
+    Text,
+    /// RFC 3339, or milliseconds since the epoch
+    Timestamp,
+    Bool,
+}
+
+/// A field lists can sort or filter by, and the column it reads
+pub struct ListField<C> {
+    pub name: &'static str,
+    pub column: C,
+    pub kind: ListFieldKind,
+}
+
+impl<C> ListField<C> {
+    pub const fn new(name: &'static str, column: C, kind: ListFieldKind) -> Self {
+        Self { name, column, kind }
+    }
+}
+
+fn find_field<'a, C>(fields: &'a [ListField<C>], name: &str) -> Option<&'a ListField<C>> {
+    fields.iter().find(|field| field.name == name)
+}
+
+fn parse_value(kind: ListFieldKind, raw: &str) -> Option<Value> {
+    match kind {
+        ListFieldKind::Integer => raw.parse::<i64>().ok().map(Value::from),
+        ListFieldKind::Text => Some(Value::from(raw.to_string())),
+        ListFieldKind::Timestamp => DateTime::parse_from_rfc3339(raw)
+            .map(|date| date.with_timezone(&Utc))
+            .ok()
+            .or_else(|| {
+                raw.parse::<i64>()
+                    .ok()
+                    .and_then(DateTime::<Utc>::from_timestamp_millis)
+            })
+            .map(Value::from),
+        ListFieldKind::Bool => raw.parse::<bool>().ok().map(Value::from),
+    }
+}
+
+fn json_to_value(kind: ListFieldKind, json: &serde_json::Value) -> Option<Value> {
+    match json {
+        serde_json::Value::String(raw) => parse_value(kind, raw),
+        serde_json::Value::Number(number) if kind == ListFieldKind::Integer => {
+            number.as_i64().map(Value::from)
+        }
+        serde_json::Value::Bool(value) if kind == ListFieldKind::Bool => Some(Value::from(*value)),
+        _ => None,
+    }
+}
+
+fn value_to_json(value: Value) -> serde_json::Value {
+    match value {
+        Value::SmallInt(Some(value)) => value.into(),
+        Value::Int(Some(value)) => value.into(),
+        Value::BigInt(Some(value)) => value.into(),
+        Value::Bool(Some(value)) => value.into(),
+        Value::String(Some(value)) => (*value).into(),
+        Value::ChronoDateTimeUtc(Some(value)) => value.to_rfc3339().into(),
+        _ => serde_json::Value::Null,
+    }
+}
+
+fn value_to_id(value: Value) -> i64 {
+    match value {
+        Value::SmallInt(Some(value)) => value.into(),
+        Value::Int(Some(value)) => value.into(),
+        Value::BigInt(Some(value)) => value,
+        _ => 0,
+    }
+}
+
+/// Apply the filters of a list request to a query, e.g. to count what it matches
+pub fn list_filter<E>(
+    mut select: Select<E>,
+    request: &ListRequest,
+    fields: &[ListField<E::Column>],
+) -> Result<Select<E>, ListError>
+where
+    E: EntityTrait,
+{
+    for clause in &request.filters {
+        let field = find_field(fields, &clause.field).ok_or_else(|| {
+            ListError::InvalidFilter(format!("can't filter by '{}'", clause.field))
+        })?;
+        let values = clause
+            .values
+            .iter()
+            .map(|raw| {
+                parse_value(field.kind, raw).ok_or_else(|| {
+                    ListError::InvalidFilter(format!("'{}' is not a valid {}", raw, field.name))
+                })
+            })
+            .collect::<Result<Vec<_>, _>>()?;
+        let column = field.column;
+        let value = values[0].clone();
+        select = select.filter(match clause.op {
+            FilterOp::Eq => column.eq(value),
+            FilterOp::Ne => column.ne(value),
+            FilterOp::Gt => column.gt(value),
+            FilterOp::Gte => column.gte(value),
+            FilterOp::Lt => column.lt(value),
+            FilterOp::Lte => column.lte(value),
+            FilterOp::In => column.is_in(values),
+            FilterOp::Contains if field.kind == ListFieldKind::Text => {
+                column.contains(&clause.values[0])
+            }
+            FilterOp::Contains => {
+                return Err(ListError::InvalidFilter(format!(
+                    "contains only applies to text, not {}",
+                    field.name
+                )))
+            }
+        });
+    }
+    Ok(select)
+}
+
+/// Apply a list request to a query: its filters, its sort (by the field, then by
+/// `id`), the start of the page and the limit
+///
+/// One row past the limit is read, for [`page_from_models`] to tell whether another
+/// page follows.
+pub fn list_select<E>(
+    select: Select<E>,
+    request: &ListRequest,
+    fields: &[ListField<E::Column>],
+    id: E::Column,
+) -> Result<Select<E>, ListError>
+where
+    E: EntityTrait,
+{
+    let mut select = list_filter(select, request, fields)?;
+    let sort = find_field(fields, &request.sort.field)
+        .ok_or_else(|| ListError::InvalidSort(format!("can't sort by '{}'", request.sort.field)))?;
+    let column = sort.column;
+    if let Some(cursor) = &request.cursor {
+        let value = json_to_value(sort.kind, &cursor.value).ok_or(ListError::InvalidCursor)?;
+        // Rows after the cursor: further along the sort, or level with it and
+        // further along by id
+        let (past_value, past_id) = match request.sort.order {
+            SortOrder::Asc => (column.gt(value.clone()), id.gt(cursor.id)),
+            SortOrder::Desc => (column.lt(value.clone()), id.lt(cursor.id)),
+        };
+        select = select.filter(
+            Condition::any()
+                .add(past_value)
+                .add(Condition::all().add(column.eq(value)).add(past_id)),
+        );
+    }
+    let order = match request.sort.order {
+        SortOrder::Asc => Order::Asc,
+        SortOrder::Desc => Order::Desc,
+    };
+    Ok(select
+        .order_by(column, order.clone())
+        .order_by(id, order)
+        .limit(request.limit + 1))
+}
+
+/// Page from the rows a [`list_select`] query read
+pub fn page_from_models<M>(
+    rows: Vec<M>,
+    request: &ListRequest,
+    fields: &[ListField<<M::Entity as EntityTrait>::Column>],
+    id: <M::Entity as EntityTrait>::Column,
+) -> CursorPage<M>
+where
+    M: ModelTrait,
+{
+    let sort = find_field(fields, &request.sort.field).map(|field| field.column);
+    CursorPage::from_rows(rows, request, |row| {
+        let value = sort
+            .map(|column| value_to_json(row.get(column)))
+            .unwrap_or(serde_json::Value::Null);
+        (value, value_to_id(row.get(id)))
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use sea_orm::{DbBackend, QueryTrait};
+    use temps_core::pagination::{Cursor, ListParams, ListSpec, Sort};
+    use temps_entities::deployments;
+
+    fn newest_first() -> Sort {
+        Sort::desc("created_at")
+    }
+
+    const SPEC: ListSpec = ListSpec {
+        sort_fields: &["created_at"],
+        filter_fields: &["state", "environment_id"],
+        default_sort: newest_first,
+    };
+
+    const FIELDS: [ListField<deployments::Column>; 3] = [
+        ListField::new(
+            "created_at",
+            deployments::Column::CreatedAt,
+            ListFieldKind::Timestamp,
+        ),
+        ListField::new("state", deployments::Column::State, ListFieldKind::Text),
+        ListField::new(
+            "environment_id",
+            deployments::Column::EnvironmentId,
+            ListFieldKind::Integer,
+        ),
+    ];
+
+    #[test]
+    fn test_list_select_reads_past_the_cursor() {
+        let cursor = Cursor {
+            sort: "-created_at".to_string(),
+            value: serde_json::json!("2026-10-01T12:00:00+00:00"),
+            id: 42,
+        };
+        let request = ListParams {
+            cursor: Some(cursor.encode()),
+            limit: Some(10),
+            filter: Some("state:in:failed|cancelled,environment_id:eq:3".to_string()),
+            ..Default::default()
+        }
+        .resolve(&SPEC)
+        .unwrap();
+
+        let sql = list_select(
+            deployments::Entity::find(),
+            &request,
+            &FIELDS,
+            deployments::Column::Id,
+        )
+        .unwrap()
+        .build(DbBackend::Postgres)
+        .to_string();
+
+        assert!(sql.contains(r#""deployments"."state" IN ('failed', 'cancelled')"#));
+        assert!(sql.contains(r#""deployments"."environment_id" = 3"#));
+        assert!(sql.contains(r#"("deployments"."created_at" < '2026-10-01 12:00:00"#));
+        assert!(sql.contains(r#"AND "deployments"."id" < 42))"#));
+        assert!(sql.ends_with(
+            r#"ORDER BY "deployments"."created_at" DESC, "deployments"."id" DESC LIMIT 11"#
+        ));
+    }
+
+    #[test]
+    fn test_list_select_rejects_values_of_the_wrong_type() {
+        let request = ListParams {
+            filter: Some("environment_id:eq:production".to_string()),
+            ..Default::default()
+        }
+        .resolve(&SPEC)
+        .unwrap();
+        let result = list_select(
+            deployments::Entity::find(),
+            &request,
+            &FIELDS,
+            deployments::Column::Id,
+        );
+        assert!(matches!(result, Err(ListError::InvalidFilter(_))));
+    }
+}
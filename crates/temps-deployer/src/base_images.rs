@@ -0,0 +1,162 @@
+//! Base images of a Dockerfile
+//!
+//! The base images of a build are the images its stages start `FROM`. Recording
+//! their registry digests at build time tells later whether a newer image has been
+//! pushed under the same tag.
+
+use std::collections::{HashMap, HashSet};
+
+/// Base images a Dockerfile builds from, in order of first use
+///
+/// Stages built from earlier stages and `scratch` are skipped, as are images named
+/// by a build argument without a default, since it isn't known which image they
+/// resolve to.
+pub fn base_images(dockerfile: &str) -> Vec<String> {
+    let mut args: HashMap<String, String> = HashMap::new();
+    let mut stages: HashSet<String> = HashSet::new();
+    let mut images: Vec<String> = Vec::new();
+    let mut seen_from = false;
+
+    for line in dockerfile.lines() {
+        let line = line.trim();
+        let mut words = line.split_whitespace();
+        let Some(instruction) = words.next() else {
+            continue;
+        };
+
+        // Only arguments declared before the first FROM apply to FROM lines
+        if instruction.eq_ignore_ascii_case("ARG") && !seen_from {
+            if let Some((name, value)) = words.next().and_then(|arg| arg.split_once('=')) {
+                args.insert(name.to_string(), value.trim_matches('"').to_string());
+            }
+            continue;
+        }
+        if !instruction.eq_ignore_ascii_case("FROM") {
+            continue;
+        }
+        seen_from = true;
+
+        let mut words = words.skip_while(|word| word.starts_with("--"));
+        let Some(image) = words.next() else {
+            continue;
+        };
+        let image = substitute_args(image, &args);
+        if let (Some(keyword), Some(alias)) = (words.next(), words.next()) {
+            if keyword.eq_ignore_ascii_case("AS") {
+                stages.insert(alias.to_lowercase());
+            }
+        }
+
+        let Some(image) = image else {
+            continue;
+        };
+        if image.eq_ignore_ascii_case("scratch")
+            || stages.contains(&image.to_lowercase())
+            || images.contains(&image)
+        {
+            continue;
+        }
+        images.push(image);
+    }
+    images
+}
+
+/// Replace `$NAME` and `${NAME}` with the build argument's default
+fn substitute_args(image: &str, args: &HashMap<String, String>) -> Option<String> {
+    // Longest names first, so `$NODE` doesn't replace part of `$NODE_VERSION`
+    let mut names: Vec<&String> = args.keys().collect();
+    names.sort_by_key(|name| std::cmp::Reverse(name.len()));
+
+    let mut result = image.to_string();
+    for name in names {
+        let value = &args[name];
+        result = result
+            .replace(&format!("${{{}}}", name), value)
+            .replace(&format!("${}", name), value);
+    }
+    (!result.contains('$')).then_some(result)
+}
+
+/// Digest of an image among its `RepoDigests` (`repository@sha256:...`)
+///
+/// Prefers the digest of the image's own repository, since an image pushed to
+/// several repositories has a digest for each.
+pub fn repo_digest(image_name: &str, repo_digests: &[String]) -> Option<String> {
+    let repository = repository(image_name);
+    let digests: Vec<(&str, &str)> = repo_digests
+        .iter()
+        .filter_map(|entry| entry.split_once('@'))
+        .collect();
+    digests
+        .iter()
+        .find(|(repo, _)| *repo == repository)
+        .or_else(|| digests.first())
+        .map(|(_, digest)| digest.to_string())
+}
+
+/// Repository of an image reference, without its tag or digest
+fn repository(image_name: &str) -> &str {
+    let name = image_name
+        .split_once('@')
+        .map_or(image_name, |(name, _)| name);
+    // A colon after the last slash starts the tag; one before it is a registry port
+    match name.rfind(':') {
+        Some(colon) if !name[colon..].contains('/') => &name[..colon],
+        _ => name,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_base_images_skips_stages_and_scratch() {
+        let dockerfile = r#"
+            # syntax=docker/dockerfile:1
+            FROM --platform=$BUILDPLATFORM node:20-alpine AS deps
+            RUN npm ci
+            FROM deps AS build
+            RUN npm run build
+            FROM nginx:1.27
+            COPY --from=build /app/dist /usr/share/nginx/html
+            FROM scratch AS empty
+            from node:20-alpine
+        "#;
+        assert_eq!(
+            base_images(dockerfile),
+            vec!["node:20-alpine", "nginx:1.27"]
+        );
+    }
+
+    #[test]
+    fn test_base_images_resolves_arg_defaults() {
+        let dockerfile =
+            "ARG NODE_VERSION=20\nARG BASE\nFROM node:${NODE_VERSION}-slim\nFROM $BASE\n";
+        assert_eq!(base_images(dockerfile), vec!["node:20-slim"]);
+    }
+
+    #[test]
+    fn test_repo_digest_prefers_own_repository() {
+        let digests = vec![
+            "mirror.example.com/node@sha256:aaa".to_string(),
+            "node@sha256:bbb".to_string(),
+        ];
+        assert_eq!(
+            repo_digest("node:20-alpine", &digests),
+            Some("sha256:bbb".to_string())
+        );
+        assert_eq!(
+            repo_digest("docker.io/library/node:20", &digests),
+            Some("sha256:aaa".to_string())
+        );
+        assert_eq!(repo_digest("temps-app:12", &[]), None);
+    }
+
+    #[test]
+    fn test_repository_keeps_registry_port() {
+        assert_eq!(repository("localhost:5000/app:1.2"), "localhost:5000/app");
+        assert_eq!(repository("localhost:5000/app"), "localhost:5000/app");
+        assert_eq!(repository("node@sha256:abc"), "node");
+    }
+}
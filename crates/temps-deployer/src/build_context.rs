@@ -0,0 +1,578 @@
+//! Build context collection
+//!
+//! The build context is every file sent to the builder with a build. Docker's API
+//! takes it as a tar archive the client packs, so the client is the one honoring
+//! `.dockerignore`: excluded files — `node_modules`, local `.env` files, build output
+//! — are never uploaded. Sources that don't come from a git checkout, such as build
+//! contexts uploaded by CI, carry files git would have left out, so their
+//! `.gitignore` can apply as well.
+//!
+//! Symlinks are sent as links, like the Docker CLI does, so a link can't pull files
+//! from outside of the context into it.
+
+use std::io;
+use std::path::Path;
+
+/// Ignore file of the build context, in Docker's syntax
+pub const DOCKERIGNORE_FILE: &str = ".dockerignore";
+/// Ignore file of the repository, in git's syntax
+pub const GITIGNORE_FILE: &str = ".gitignore";
+/// Size above which a build context is unusually large (200 MiB)
+pub const LARGE_CONTEXT_BYTES: u64 = 200 * 1024 * 1024;
+
+/// One segment of an ignore pattern
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Segment {
+    /// `**`: any number of directories, none included
+    AnyDirs,
+    /// A name, with `*`, `?` and `[...]` wildcards
+    Glob(Vec<char>),
+}
+
+/// A line of an ignore file
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct IgnoreRule {
+    segments: Vec<Segment>,
+    /// `!` patterns put back what earlier patterns excluded
+    negated: bool,
+    /// Patterns ending in `/` only match directories (gitignore)
+    dir_only: bool,
+}
+
+impl IgnoreRule {
+    /// Whether the rule matches a path, or one of the directories it is in
+    fn matches(&self, path: &[&str], is_dir: bool) -> bool {
+        (1..=path.len()).any(|len| {
+            (len < path.len() || is_dir || !self.dir_only)
+                && match_segments(&self.segments, &path[..len])
+        })
+    }
+}
+
+fn parse_segments(pattern: &str) -> Vec<Segment> {
+    pattern
+        .split('/')
+        .filter(|segment| !segment.is_empty() && *segment != ".")
+        .map(|segment| match segment {
+            "**" => Segment::AnyDirs,
+            glob => Segment::Glob(glob.chars().collect()),
+        })
+        .collect()
+}
+
+fn match_segments(pattern: &[Segment], path: &[&str]) -> bool {
+    match pattern.split_first() {
+        None => path.is_empty(),
+        Some((Segment::AnyDirs, rest)) => {
+            (0..=path.len()).any(|skipped| match_segments(rest, &path[skipped..]))
+        }
+        Some((Segment::Glob(glob), rest)) => {
+            !path.is_empty()
+                && match_glob(glob, &path[0].chars().collect::<Vec<_>>())
+                && match_segments(rest, &path[1..])
+        }
+    }
+}
+
+/// Match a name against a glob: `*` is any run of characters, `?` any one, `[a-z]`
+/// (or `[!a-z]`) one of a set, and `\` escapes the next character
+fn match_glob(pattern: &[char], name: &[char]) -> bool {
+    let (mut p, mut n) = (0, 0);
+    // Position after the last `*`, and the name position it was tried at
+    let mut backtrack: Option<(usize, usize)> = None;
+    while n < name.len() {
+        let next = match pattern.get(p) {
+            Some('*') => {
+                backtrack = Some((p + 1, n));
+                p += 1;
+                continue;
+            }
+            Some('?') => Some(p + 1),
+            Some('[') => match match_class(pattern, p, name[n]) {
+                Some((true, end)) => Some(end),
+                Some((false, _)) => None,
+                // An unclosed `[` is a plain character
+                None => (name[n] == '[').then_some(p + 1),
+            },
+            Some('\\') if p + 1 < pattern.len() => (pattern[p + 1] == name[n]).then_some(p + 2),
+            Some(&c) => (c == name[n]).then_some(p + 1),
+            None => None,
+        };
+        match (next, backtrack) {
+            (Some(next), _) => {
+                p = next;
+                n += 1;
+            }
+            (None, Some((star, tried))) => {
+                p = star;
+                n = tried + 1;
+                backtrack = Some((star, tried + 1));
+            }
+            (None, None) => return false,
+        }
+    }
+    pattern[p..].iter().all(|&c| c == '*')
+}
+
+/// Whether a character is in the `[...]` set starting at `start`, and the position
+/// after the set; None when the set isn't closed
+fn match_class(pattern: &[char], start: usize, c: char) -> Option<(bool, usize)> {
+    let mut i = start + 1;
+    let negated = matches!(pattern.get(i), Some('!') | Some('^'));
+    if negated {
+        i += 1;
+    }
+    let mut matched = false;
+    let mut first = true;
+    loop {
+        let mut low = *pattern.get(i)?;
+        if low == ']' && !first {
+            return Some((matched != negated, i + 1));
+        }
+        first = false;
+        if low == '\\' {
+            i += 1;
+            low = *pattern.get(i)?;
+        }
+        let mut high = low;
+        if pattern.get(i + 1) == Some(&'-') && pattern.get(i + 2).is_some_and(|&h| h != ']') {
+            high = pattern[i + 2];
+            i += 2;
+        }
+        if low <= c && c <= high {
+            matched = true;
+        }
+        i += 1;
+    }
+}
+
+/// Patterns of the ignore files that apply to a build context
+#[derive(Debug, Clone, Default)]
+pub struct ContextIgnore {
+    rules: Vec<IgnoreRule>,
+}
+
+impl ContextIgnore {
+    /// Patterns of a `.dockerignore`: relative to the context's root, where a pattern
+    /// excludes what it matches and everything inside
+    pub fn parse_dockerignore(content: &str) -> Self {
+        let rules = content
+            .lines()
+            .map(str::trim)
+            .filter(|line| !line.is_empty() && !line.starts_with('#'))
+            .filter_map(|line| {
+                let (negated, pattern) = match line.strip_prefix('!') {
+                    Some(pattern) => (true, pattern.trim()),
+                    None => (false, line),
+                };
+                let segments = parse_segments(pattern);
+                (!segments.is_empty()).then_some(IgnoreRule {
+                    segments,
+                    negated,
+                    dir_only: false,
+                })
+            })
+            .collect();
+        Self { rules }
+    }
+
+    /// Patterns of a `.gitignore`: a pattern without a slash matches at any depth,
+    /// and one ending in a slash only matches directories
+    pub fn parse_gitignore(content: &str) -> Self {
+        let rules = content
+            .lines()
+            .map(str::trim_end)
+            .filter(|line| !line.is_empty() && !line.starts_with('#'))
+            .filter_map(|line| {
+                let (negated, pattern) = match line.strip_prefix('!') {
+                    Some(pattern) => (true, pattern),
+                    None => (false, line.strip_prefix('\\').unwrap_or(line)),
+                };
+                let dir_only = pattern.ends_with('/');
+                let pattern = pattern.trim_end_matches('/');
+                let anchored = pattern.contains('/');
+                let mut segments = parse_segments(pattern);
+                if segments.is_empty() {
+                    return None;
+                }
+                if !anchored {
+                    segments.insert(0, Segment::AnyDirs);
+                }
+                Some(IgnoreRule {
+                    segments,
+                    negated,
+                    dir_only,
+                })
+            })
+            .collect();
+        Self { rules }
+    }
+
+    /// Ignore files of a context: its `.dockerignore`, after its `.gitignore` when that
+    /// one applies, so the build's own file has the last word
+    pub fn load(
+        context_path: &Path,
+        honor_gitignore: bool,
+    ) -> io::Result<(Self, Vec<&'static str>)> {
+        let mut ignore = Self::default();
+        let mut applied = Vec::new();
+        let files = [(GITIGNORE_FILE, honor_gitignore), (DOCKERIGNORE_FILE, true)];
+        for (file, wanted) in files {
+            if !wanted {
+                continue;
+            }
+            match std::fs::read_to_string(context_path.join(file)) {
+                Ok(content) => {
+                    let parsed = if file == GITIGNORE_FILE {
+                        Self::parse_gitignore(&content)
+                    } else {
+                        Self::parse_dockerignore(&content)
+                    };
+                    ignore.rules.extend(parsed.rules);
+                    applied.push(file);
+                }
+                Err(e) if e.kind() == io::ErrorKind::NotFound => {}
+                Err(e) => return Err(e),
+            }
+        }
+        Ok((ignore, applied))
+    }
+
+    /// Whether a path relative to the context is left out; the last matching pattern
+    /// decides
+    pub fn is_ignored(&self, path: &str, is_dir: bool) -> bool {
+        let segments: Vec<&str> = path.split('/').filter(|s| !s.is_empty()).collect();
+        self.rules
+            .iter()
+            .rev()
+            .find(|rule| rule.matches(&segments, is_dir))
+            .is_some_and(|rule| !rule.negated)
+    }
+
+    /// Whether a pattern puts paths back, so excluded directories can't be skipped whole
+    fn has_exceptions(&self) -> bool {
+        self.rules.iter().any(|rule| rule.negated)
+    }
+}
+
+/// A file or directory sent with the build
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ContextEntry {
+    /// Path relative to the context, with `/` separators
+    pub path: String,
+    /// Size in bytes; 0 for directories and symlinks
+    pub size: u64,
+    pub is_dir: bool,
+}
+
+/// Files of a build context that are sent to the builder
+#[derive(Debug, Clone, Default)]
+pub struct BuildContext {
+    /// Entries in the order they are packed
+    pub entries: Vec<ContextEntry>,
+    /// Paths the ignore files left out; an excluded directory counts once
+    pub excluded: u64,
+    /// Ignore files that applied
+    pub ignore_files: Vec<&'static str>,
+}
+
+impl BuildContext {
+    /// Walk a context, leaving out what its ignore files exclude
+    ///
+    /// The Dockerfile and the `.dockerignore` are always sent, as the builder needs
+    /// them even when they are excluded.
+    pub fn collect(
+        context_path: &Path,
+        dockerfile_path: Option<&Path>,
+        honor_gitignore: bool,
+    ) -> io::Result<Self> {
+        let (ignore, ignore_files) = ContextIgnore::load(context_path, honor_gitignore)?;
+        let dockerfile = dockerfile_path
+            .and_then(|path| path.strip_prefix(context_path).ok())
+            .map(|path| path.to_string_lossy().replace('\\', "/"));
+        let always_sent =
+            |path: &str| path == DOCKERIGNORE_FILE || dockerfile.as_deref() == Some(path);
+
+        let mut context = Self {
+            ignore_files,
+            ..Default::default()
+        };
+        let mut pending = vec![String::new()];
+        while let Some(dir) = pending.pop() {
+            let mut children: Vec<_> =
+                std::fs::read_dir(context_path.join(&dir))?.collect::<io::Result<_>>()?;
+            children.sort_by_key(|child| child.file_name());
+            // Subdirectories are walked after the files next to them, in name order
+            let mut subdirs = Vec::new();
+            for child in children {
+                let name = child.file_name().to_string_lossy().to_string();
+                let path = if dir.is_empty() {
+                    name
+                } else {
+                    format!("{}/{}", dir, name)
+                };
+                let metadata = std::fs::symlink_metadata(child.path())?;
+                let is_dir = metadata.is_dir();
+                if ignore.is_ignored(&path, is_dir) && !always_sent(&path) {
+                    context.excluded += 1;
+                    // Files inside may still be put back by a later pattern
+                    if is_dir && ignore.has_exceptions() {
+                        subdirs.push(path);
+                    }
+                    continue;
+                }
+                if is_dir {
+                    context.entries.push(ContextEntry {
+                        path: path.clone(),
+                        size: 0,
+                        is_dir: true,
+                    });
+                    subdirs.push(path);
+                } else {
+                    context.entries.push(ContextEntry {
+                        path,
+                        size: if metadata.is_file() {
+                            metadata.len()
+                        } else {
+                            0
+                        },
+                        is_dir: false,
+                    });
+                }
+            }
+            pending.extend(subdirs.into_iter().rev());
+        }
+        Ok(context)
+    }
+
+    pub fn file_count(&self) -> usize {
+        self.entries.iter().filter(|entry| !entry.is_dir).count()
+    }
+
+    pub fn total_bytes(&self) -> u64 {
+        self.entries.iter().map(|entry| entry.size).sum()
+    }
+
+    pub fn is_large(&self) -> bool {
+        self.total_bytes() > LARGE_CONTEXT_BYTES
+    }
+
+    /// Top-level directories holding the most bytes, largest first
+    pub fn largest_directories(&self, limit: usize) -> Vec<(String, u64)> {
+        let mut sizes: std::collections::BTreeMap<&str, u64> = Default::default();
+        for entry in &self.entries {
+            if let Some((top, _)) = entry.path.split_once('/') {
+                *sizes.entry(top).or_default() += entry.size;
+            }
+        }
+        let mut sizes: Vec<(String, u64)> = sizes
+            .into_iter()
+            .map(|(dir, size)| (dir.to_string(), size))
+            .collect();
+        sizes.sort_by(|a, b| b.1.cmp(&a.1));
+        sizes.truncate(limit);
+        sizes
+    }
+
+    /// One line on what is sent, for the build log
+    pub fn summary(&self) -> String {
+        let ignored = if self.ignore_files.is_empty() {
+            format!("no {} found", DOCKERIGNORE_FILE)
+        } else {
+            format!(
+                "{} excluded by {}",
+                self.excluded,
+                self.ignore_files.join(" and ")
+            )
+        };
+        format!(
+            "{} files, {} ({})",
+            self.file_count(),
+            format_size(self.total_bytes()),
+            ignored
+        )
+    }
+
+    /// Pack the entries into the tar archive the builder is sent
+    pub fn pack(&self, context_path: &Path) -> io::Result<Vec<u8>> {
+        let mut archive = tar::Builder::new(Vec::new());
+        archive.follow_symlinks(false);
+        for entry in &self.entries {
+            let source = context_path.join(&entry.path);
+            if entry.is_dir {
+                archive.append_dir(&entry.path, &source)?;
+            } else {
+                archive.append_path_with_name(&source, &entry.path)?;
+            }
+        }
+        archive.into_inner()
+    }
+}
+
+/// Size in bytes for people: `512 B`, `12.3 KB`, `1.5 GB`
+pub fn format_size(bytes: u64) -> String {
+    const UNITS: [&str; 4] = ["KB", "MB", "GB", "TB"];
+    if bytes < 1024 {
+        return format!("{} B", bytes);
+    }
+    let mut size = bytes as f64 / 1024.0;
+    let mut unit = 0;
+    while size >= 1024.0 && unit < UNITS.len() - 1 {
+        size /= 1024.0;
+        unit += 1;
+    }
+    format!("{:.1} {}", size, UNITS[unit])
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_dockerignore_patterns() {
+        let ignore = ContextIgnore::parse_dockerignore(
+            "# dependencies\nnode_modules\n**/*.log\n/dist\n.env*\n!.env.example\n*/tmp?\n",
+        );
+        assert!(ignore.is_ignored("node_modules", true));
+        assert!(ignore.is_ignored("node_modules/react/index.js", false));
+        // Anchored to the context's root
+        assert!(!ignore.is_ignored("packages/app/node_modules", true));
+        assert!(ignore.is_ignored("debug.log", false));
+        assert!(ignore.is_ignored("logs/server/out.log", false));
+        assert!(ignore.is_ignored("dist/index.html", false));
+        assert!(ignore.is_ignored(".env.local", false));
+        assert!(!ignore.is_ignored(".env.example", false));
+        assert!(ignore.is_ignored("app/tmp1", true));
+        assert!(!ignore.is_ignored("app/tmp", true));
+        assert!(!ignore.is_ignored("src/main.rs", false));
+    }
+
+    #[test]
+    fn test_gitignore_patterns() {
+        let ignore = ContextIgnore::parse_gitignore("node_modules/\n*.pyc\n/build\ndocs/*.pdf\n");
+        // Patterns without a slash match at any depth
+        assert!(ignore.is_ignored("packages/app/node_modules", true));
+        assert!(ignore.is_ignored("packages/app/node_modules/x/index.js", false));
+        assert!(ignore.is_ignored("app/__init__.pyc", false));
+        // A trailing slash only matches directories
+        assert!(!ignore.is_ignored("node_modules", false));
+        assert!(ignore.is_ignored("build/out.js", false));
+        assert!(!ignore.is_ignored("src/build", true));
+        assert!(ignore.is_ignored("docs/guide.pdf", false));
+        assert!(!ignore.is_ignored("docs/api/guide.pdf", false));
+    }
+
+    #[test]
+    fn test_glob_classes_and_escapes() {
+        let glob = |pattern: &str, name: &str| {
+            match_glob(
+                &pattern.chars().collect::<Vec<_>>(),
+                &name.chars().collect::<Vec<_>>(),
+            )
+        };
+        assert!(glob("file[0-9].txt", "file7.txt"));
+        assert!(!glob("file[!0-9].txt", "file7.txt"));
+        assert!(glob("a\\*b", "a*b"));
+        assert!(!glob("a\\*b", "axb"));
+        assert!(glob("*.tar.*", "context.tar.gz"));
+        assert!(glob("[", "["));
+    }
+
+    #[test]
+    fn test_collect_leaves_out_ignored_files() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = dir.path();
+        std::fs::create_dir_all(root.join("node_modules/react")).unwrap();
+        std::fs::create_dir_all(root.join("src")).unwrap();
+        std::fs::write(root.join("node_modules/react/index.js"), vec![0u8; 4096]).unwrap();
+        std::fs::write(root.join("src/main.js"), "console.log(1)").unwrap();
+        std::fs::write(root.join(".env"), "SECRET=1").unwrap();
+        std::fs::write(root.join(".gitignore"), "*.log\n").unwrap();
+        std::fs::write(root.join("server.log"), "log").unwrap();
+        std::fs::write(root.join("Dockerfile"), "FROM node:20").unwrap();
+        std::fs::write(
+            root.join(DOCKERIGNORE_FILE),
+            "node_modules\n.env\nDockerfile\n.dockerignore\n",
+        )
+        .unwrap();
+
+        let context = BuildContext::collect(root, Some(&root.join("Dockerfile")), false).unwrap();
+        let paths: Vec<&str> = context
+            .entries
+            .iter()
+            .map(|entry| entry.path.as_str())
+            .collect();
+        assert_eq!(
+            paths,
+            [
+                ".dockerignore",
+                ".gitignore",
+                "Dockerfile",
+                "server.log",
+                "src",
+                "src/main.js"
+            ]
+        );
+        assert_eq!(context.excluded, 2);
+        assert_eq!(context.ignore_files, [DOCKERIGNORE_FILE]);
+
+        // Uploaded sources honor their .gitignore too
+        let context = BuildContext::collect(root, Some(&root.join("Dockerfile")), true).unwrap();
+        assert!(!context
+            .entries
+            .iter()
+            .any(|entry| entry.path == "server.log"));
+        assert_eq!(context.ignore_files, [GITIGNORE_FILE, DOCKERIGNORE_FILE]);
+
+        let archive = context.pack(root).unwrap();
+        let mut archive = tar::Archive::new(archive.as_slice());
+        let packed: Vec<String> = archive
+            .entries()
+            .unwrap()
+            .map(|entry| {
+                entry
+                    .unwrap()
+                    .path()
+                    .unwrap()
+                    .to_string_lossy()
+                    .trim_end_matches('/')
+                    .to_string()
+            })
+            .collect();
+        assert!(packed.contains(&"src/main.js".to_string()));
+        assert!(!packed.iter().any(|path| path.starts_with("node_modules")));
+    }
+
+    #[test]
+    fn test_largest_directories() {
+        let file = |path: &str, size| ContextEntry {
+            path: path.to_string(),
+            size,
+            is_dir: false,
+        };
+        let context = BuildContext {
+            entries: vec![
+                file("assets/video.mp4", 300 * 1024 * 1024),
+                file("src/main.rs", 1024),
+                file("src/lib.rs", 2048),
+                file("README.md", 10),
+            ],
+            ..Default::default()
+        };
+        assert!(context.is_large());
+        assert_eq!(
+            context.largest_directories(5),
+            [
+                ("assets".to_string(), 300 * 1024 * 1024),
+                ("src".to_string(), 3072)
+            ]
+        );
+    }
+
+    #[test]
+    fn test_format_size() {
+        assert_eq!(format_size(512), "512 B");
+        assert_eq!(format_size(1536), "1.5 KB");
+        assert_eq!(format_size(200 * 1024 * 1024), "200.0 MB");
+    }
+}
@@ -0,0 +1,516 @@
+//! Builds on dedicated build nodes
+//!
+//! A build node is a Docker daemon on another machine that images are built on, so
+//! builds don't compete with the running workloads for CPU and memory. The image is
+//! built on the node and brought back to the local daemon afterwards: through the
+//! configured registry when there is one, otherwise exported from the node and
+//! loaded locally. Buildpacks builds and everything that isn't a build run on the
+//! local daemon. Daemons reached over TCP can be protected with mutual TLS; their
+//! credentials are only ever held in memory.
+
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+use std::time::Instant;
+
+use async_trait::async_trait;
+use bollard::auth::DockerCredentials;
+use bollard::query_parameters::{
+    CreateImageOptions, PushImageOptions, RemoveImageOptions, TagImageOptions,
+};
+use bollard::Docker;
+use futures::{StreamExt, TryStreamExt};
+use hyper_util::client::legacy::Client;
+use hyper_util::rt::TokioExecutor;
+use temps_core::BuildNodeTls;
+use tokio::io::AsyncWriteExt;
+use tracing::{debug, info, warn};
+
+use crate::build_phases::BuildPhaseTimings;
+use crate::docker::DockerRuntime;
+use crate::{
+    buildpacks, BuildRequest, BuildRequestWithCallback, BuildResult, BuilderError, ImageBuilder,
+    LogCallback,
+};
+
+/// Seconds to wait for a build node to answer a request
+const BUILD_NODE_TIMEOUT_SECS: u64 = 120;
+
+/// Connect to the Docker daemon of a build node, at a `tcp://`, `http://` or
+/// `unix://` address, over mutual TLS when credentials are given
+pub fn connect_build_node(
+    docker_host: &str,
+    tls: Option<&BuildNodeTls>,
+) -> Result<Docker, BuilderError> {
+    if let Some(tls) = tls {
+        return connect_with_tls(docker_host, tls);
+    }
+    let docker = match docker_host.strip_prefix("unix://") {
+        Some(path) => {
+            Docker::connect_with_socket(path, BUILD_NODE_TIMEOUT_SECS, bollard::API_DEFAULT_VERSION)
+        }
+        None => Docker::connect_with_http(
+            docker_host,
+            BUILD_NODE_TIMEOUT_SECS,
+            bollard::API_DEFAULT_VERSION,
+        ),
+    };
+    docker.map_err(|e| {
+        BuilderError::Other(format!(
+            "Failed to connect to build node at {}: {}",
+            docker_host, e
+        ))
+    })
+}
+
+/// TLS client configuration for a build node, built from its mutual TLS credentials
+/// in memory
+fn tls_client_config(tls: &BuildNodeTls) -> Result<rustls::ClientConfig, BuilderError> {
+    let invalid = |what: &str, e: &dyn std::fmt::Display| {
+        BuilderError::Other(format!("Invalid build node {}: {}", what, e))
+    };
+
+    let mut roots = rustls::RootCertStore::empty();
+    for certificate in rustls_pemfile::certs(&mut tls.ca_certificate.as_bytes()) {
+        let certificate = certificate.map_err(|e| invalid("CA certificate", &e))?;
+        roots
+            .add(certificate)
+            .map_err(|e| invalid("CA certificate", &e))?;
+    }
+    if roots.is_empty() {
+        return Err(invalid("CA certificate", &"no certificate found"));
+    }
+    let chain = rustls_pemfile::certs(&mut tls.client_certificate.as_bytes())
+        .collect::<Result<Vec<_>, _>>()
+        .map_err(|e| invalid("client certificate", &e))?;
+    let key = rustls_pemfile::private_key(&mut tls.client_key.as_bytes())
+        .map_err(|e| invalid("client key", &e))?
+        .ok_or_else(|| invalid("client key", &"no private key found"))?;
+
+    rustls::ClientConfig::builder_with_provider(Arc::new(rustls::crypto::ring::default_provider()))
+        .with_safe_default_protocol_versions()
+        .map_err(|e| invalid("TLS configuration", &e))?
+        .with_root_certificates(roots)
+        .with_client_auth_cert(chain, key)
+        .map_err(|e| invalid("client certificate", &e))
+}
+
+fn connect_with_tls(docker_host: &str, tls: &BuildNodeTls) -> Result<Docker, BuilderError> {
+    // The credentials stay in memory: the connection goes through our own TLS
+    // client instead of bollard's, which only loads them from files
+    let connector = hyper_rustls::HttpsConnectorBuilder::new()
+        .with_tls_config(tls_client_config(tls)?)
+        .https_only()
+        .enable_http1()
+        .build();
+    let client: Client<_, bollard::BodyType> =
+        Client::builder(TokioExecutor::new()).build(connector);
+    let transport = move |request: hyper::Request<bollard::BodyType>| {
+        let client = client.clone();
+        async move {
+            client
+                .request(request)
+                .await
+                .map_err(bollard::errors::Error::from)
+        }
+    };
+
+    let address = docker_host
+        .trim_start_matches("tcp://")
+        .trim_start_matches("http://")
+        .trim_start_matches("https://");
+    Docker::connect_with_custom_transport(
+        transport,
+        Some(format!("https://{}", address)),
+        BUILD_NODE_TIMEOUT_SECS,
+        bollard::API_DEFAULT_VERSION,
+    )
+    .map_err(|e| {
+        BuilderError::Other(format!(
+            "Failed to connect to build node at {} over TLS: {}",
+            docker_host, e
+        ))
+    })
+}
+
+/// Split an image name into its repository and tag
+fn split_image_name(image_name: &str) -> (&str, &str) {
+    match image_name.rsplit_once(':') {
+        // A colon before the last slash belongs to a registry port
+        Some((repo, tag)) if !tag.contains('/') => (repo, tag),
+        _ => (image_name, "latest"),
+    }
+}
+
+/// Registry images built on a build node are moved through
+#[derive(Debug, Clone)]
+pub struct RegistryTransfer {
+    /// Registry host, e.g. `registry.example.com:5000`
+    pub registry: String,
+    pub username: Option<String>,
+    pub password: Option<String>,
+}
+
+impl RegistryTransfer {
+    pub fn new(registry_url: &str) -> Self {
+        let registry = registry_url
+            .trim()
+            .trim_start_matches("https://")
+            .trim_start_matches("http://")
+            .trim_end_matches('/')
+            .to_string();
+        Self {
+            registry,
+            username: None,
+            password: None,
+        }
+    }
+
+    pub fn with_credentials(mut self, username: String, password: Option<String>) -> Self {
+        self.username = Some(username);
+        self.password = password;
+        self
+    }
+
+    /// Name of an image in the registry
+    fn image_name(&self, image_name: &str) -> String {
+        format!("{}/temps-builds/{}", self.registry, image_name)
+    }
+
+    fn credentials(&self) -> Option<DockerCredentials> {
+        self.username.as_ref().map(|username| DockerCredentials {
+            username: Some(username.clone()),
+            password: self.password.clone(),
+            serveraddress: Some(self.registry.clone()),
+            ..Default::default()
+        })
+    }
+}
+
+/// Image builder that runs builds on a build node
+pub struct NodeImageBuilder {
+    node_name: String,
+    remote: Arc<Docker>,
+    remote_runtime: DockerRuntime,
+    local: Arc<Docker>,
+    local_builder: Arc<dyn ImageBuilder>,
+    registry: Option<RegistryTransfer>,
+}
+
+impl NodeImageBuilder {
+    pub fn new(
+        node_name: String,
+        remote: Arc<Docker>,
+        local: Arc<Docker>,
+        local_builder: Arc<dyn ImageBuilder>,
+        network_name: String,
+    ) -> Self {
+        Self {
+            node_name,
+            remote_runtime: DockerRuntime::new(remote.clone(), true, network_name),
+            remote,
+            local,
+            local_builder,
+            registry: None,
+        }
+    }
+
+    /// Move built images through a registry instead of exporting them from the node
+    pub fn with_registry(mut self, registry: RegistryTransfer) -> Self {
+        self.registry = Some(registry);
+        self
+    }
+
+    pub fn node_name(&self) -> &str {
+        &self.node_name
+    }
+
+    /// Make an image built on the node available under the same name locally
+    async fn transfer(&self, result: BuildResult) -> Result<BuildResult, BuilderError> {
+        let started = Instant::now();
+        match &self.registry {
+            Some(registry) => {
+                self.transfer_through_registry(registry, &result.image_name)
+                    .await?
+            }
+            None => self.transfer_as_archive(&result.image_name).await?,
+        }
+        let push_ms = started.elapsed().as_millis() as u64;
+        info!(
+            "Moved image {} from build node {} in {}ms",
+            result.image_name, self.node_name, push_ms
+        );
+
+        let image = self
+            .local
+            .inspect_image(&result.image_name)
+            .await
+            .map_err(|e| {
+                BuilderError::Other(format!(
+                    "Image {} is missing after moving it from build node {}: {}",
+                    result.image_name, self.node_name, e
+                ))
+            })?;
+
+        // The node keeps no copy; its build cache stays for the next build
+        if let Err(e) = self
+            .remote
+            .remove_image(&result.image_name, None::<RemoveImageOptions>, None)
+            .await
+        {
+            debug!(
+                "Failed to remove image {} from build node {}: {}",
+                result.image_name, self.node_name, e
+            );
+        }
+
+        Ok(BuildResult {
+            image_id: image.id.unwrap_or(result.image_id),
+            size_bytes: image
+                .size
+                .map(|size| size as u64)
+                .unwrap_or(result.size_bytes),
+            phases: Some(BuildPhaseTimings {
+                push_ms: Some(push_ms),
+                ..result.phases.unwrap_or_default()
+            }),
+            ..result
+        })
+    }
+
+    async fn transfer_through_registry(
+        &self,
+        registry: &RegistryTransfer,
+        image_name: &str,
+    ) -> Result<(), BuilderError> {
+        let registry_image = registry.image_name(image_name);
+        let (repo, tag) = split_image_name(&registry_image);
+        self.remote
+            .tag_image(
+                image_name,
+                Some(TagImageOptions {
+                    repo: Some(repo.to_string()),
+                    tag: Some(tag.to_string()),
+                }),
+            )
+            .await
+            .map_err(|e| {
+                BuilderError::Other(format!("Failed to tag image on build node: {}", e))
+            })?;
+
+        debug!(
+            "Pushing {} from build node {}",
+            registry_image, self.node_name
+        );
+        let mut push = self.remote.push_image(
+            repo,
+            Some(PushImageOptions {
+                tag: Some(tag.to_string()),
+                ..Default::default()
+            }),
+            registry.credentials(),
+        );
+        while let Some(progress) = push.next().await {
+            let progress = progress.map_err(|e| {
+                BuilderError::Other(format!("Failed to push image from build node: {}", e))
+            })?;
+            if let Some(error) = progress.error_detail.and_then(|detail| detail.message) {
+                return Err(BuilderError::Other(format!(
+                    "Failed to push image from build node: {}",
+                    error
+                )));
+            }
+        }
+
+        debug!("Pulling {} from the registry", registry_image);
+        self.local
+            .create_image(
+                Some(CreateImageOptions {
+                    from_image: Some(registry_image.clone()),
+                    ..Default::default()
+                }),
+                None,
+                registry.credentials(),
+            )
+            .try_for_each(|_| async { Ok(()) })
+            .await
+            .map_err(|e| {
+                BuilderError::Other(format!("Failed to pull image built on build node: {}", e))
+            })?;
+
+        let (repo, tag) = split_image_name(image_name);
+        self.local
+            .tag_image(
+                &registry_image,
+                Some(TagImageOptions {
+                    repo: Some(repo.to_string()),
+                    tag: Some(tag.to_string()),
+                }),
+            )
+            .await
+            .map_err(|e| BuilderError::Other(format!("Failed to tag image: {}", e)))?;
+
+        if let Err(e) = self
+            .remote
+            .remove_image(&registry_image, None::<RemoveImageOptions>, None)
+            .await
+        {
+            debug!("Failed to remove {} from build node: {}", registry_image, e);
+        }
+        Ok(())
+    }
+
+    async fn transfer_as_archive(&self, image_name: &str) -> Result<(), BuilderError> {
+        let archive = tempfile::Builder::new()
+            .prefix("temps-build-node-")
+            .suffix(".tar")
+            .tempfile()
+            .map_err(BuilderError::IoError)?;
+
+        debug!(
+            "Exporting {} from build node {}",
+            image_name, self.node_name
+        );
+        let mut file = tokio::fs::File::create(archive.path())
+            .await
+            .map_err(BuilderError::IoError)?;
+        let mut export = self.remote.export_image(image_name);
+        while let Some(chunk) = export.next().await {
+            let chunk = chunk.map_err(|e| {
+                BuilderError::Other(format!("Failed to export image from build node: {}", e))
+            })?;
+            file.write_all(&chunk)
+                .await
+                .map_err(BuilderError::IoError)?;
+        }
+        file.flush().await.map_err(BuilderError::IoError)?;
+        drop(file);
+
+        self.local_builder
+            .import_image(archive.path().to_path_buf(), image_name)
+            .await?;
+        Ok(())
+    }
+}
+
+#[async_trait]
+impl ImageBuilder for NodeImageBuilder {
+    async fn build_image(&self, request: BuildRequest) -> Result<BuildResult, BuilderError> {
+        info!(
+            "Building image {} on build node {}",
+            request.image_name, self.node_name
+        );
+        let result = self.remote_runtime.build_image(request).await?;
+        self.transfer(result).await
+    }
+
+    async fn build_image_with_callback(
+        &self,
+        request: BuildRequestWithCallback,
+    ) -> Result<BuildResult, BuilderError> {
+        info!(
+            "Building image {} on build node {}",
+            request.request.image_name, self.node_name
+        );
+        let log_callback = request.log_callback.clone();
+        let result = self
+            .remote_runtime
+            .build_image_with_callback(request)
+            .await?;
+        if let Some(log_callback) = log_callback {
+            log_callback(format!(
+                "Moving image from build node {} to this server",
+                self.node_name
+            ))
+            .await;
+        }
+        self.transfer(result).await
+    }
+
+    async fn build_with_buildpacks(
+        &self,
+        request: buildpacks::BuildpacksRequest,
+        log_callback: Option<LogCallback>,
+    ) -> Result<BuildResult, BuilderError> {
+        warn!(
+            "Buildpacks builds run locally, not on build node {}",
+            self.node_name
+        );
+        self.local_builder
+            .build_with_buildpacks(request, log_callback)
+            .await
+    }
+
+    async fn import_image(&self, image_path: PathBuf, tag: &str) -> Result<String, BuilderError> {
+        self.local_builder.import_image(image_path, tag).await
+    }
+
+    async fn extract_from_image(
+        &self,
+        image_name: &str,
+        source_path: &str,
+        destination_path: &Path,
+    ) -> Result<(), BuilderError> {
+        self.local_builder
+            .extract_from_image(image_name, source_path, destination_path)
+            .await
+    }
+
+    async fn list_images(&self) -> Result<Vec<String>, BuilderError> {
+        self.local_builder.list_images().await
+    }
+
+    async fn remove_image(&self, image_name: &str) -> Result<(), BuilderError> {
+        self.local_builder.remove_image(image_name).await
+    }
+
+    async fn image_digest(
+        &self,
+        image_name: &str,
+        pull: bool,
+    ) -> Result<Option<String>, BuilderError> {
+        self.local_builder.image_digest(image_name, pull).await
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_split_image_name() {
+        assert_eq!(
+            split_image_name("my-app-42:latest"),
+            ("my-app-42", "latest")
+        );
+        assert_eq!(split_image_name("my-app-42"), ("my-app-42", "latest"));
+        assert_eq!(
+            split_image_name("registry.example.com:5000/temps-builds/app:v2"),
+            ("registry.example.com:5000/temps-builds/app", "v2")
+        );
+        assert_eq!(
+            split_image_name("registry.example.com:5000/app"),
+            ("registry.example.com:5000/app", "latest")
+        );
+    }
+
+    #[test]
+    fn test_registry_transfer_names_images() {
+        let registry = RegistryTransfer::new("https://registry.example.com:5000/");
+        assert_eq!(registry.registry, "registry.example.com:5000");
+        assert_eq!(
+            registry.image_name("my-app-42:latest"),
+            "registry.example.com:5000/temps-builds/my-app-42:latest"
+        );
+        assert!(registry.credentials().is_none());
+    }
+
+    #[test]
+    fn test_tls_credentials_without_certificates_are_rejected() {
+        let tls = BuildNodeTls {
+            ca_certificate: String::new(),
+            client_certificate: String::new(),
+            client_key: String::new(),
+        };
+        assert!(tls_client_config(&tls).is_err());
+    }
+}
@@ -0,0 +1,285 @@
+//! Build phase timings
+//!
+//! BuildKit reports every step of a build as a vertex with its start and end time.
+//! Grouping the steps into phases — uploading the context, pulling base images,
+//! installing dependencies, compiling, exporting the image — shows where a slow
+//! build spends its time, and the share of cached steps whether the cache helps.
+
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+
+/// Phase of a build a step belongs to
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum BuildPhase {
+    /// Sending the build context and Dockerfile to the builder
+    ContextUpload,
+    /// Resolving and pulling the images stages start `FROM`
+    BaseImage,
+    /// `RUN` steps of a package manager
+    DependencyInstall,
+    /// Every other `RUN` step
+    Compile,
+    /// Writing the image's layers
+    ImageExport,
+    /// `COPY`, `WORKDIR` and the other steps that run no command
+    Other,
+}
+
+/// Commands of package managers fetching dependencies
+const DEPENDENCY_INSTALL_COMMANDS: &[&str] = &[
+    "npm ci",
+    "npm install",
+    "npm i ",
+    "yarn install",
+    "pnpm install",
+    "pnpm i ",
+    "bun install",
+    "pip install",
+    "pip3 install",
+    "poetry install",
+    "pipenv install",
+    "uv sync",
+    "bundle install",
+    "composer install",
+    "go mod download",
+    "cargo fetch",
+    "mix deps.get",
+    "dotnet restore",
+    "apt-get install",
+    "apk add",
+];
+
+/// Phase of a BuildKit step, by its vertex name
+///
+/// Names look like `[internal] load build context`, `[deps 2/4] RUN npm ci` or
+/// `exporting to image`.
+pub fn classify_step(name: &str) -> BuildPhase {
+    let name = name.trim();
+    if let Some(internal) = name.strip_prefix("[internal]") {
+        return if internal.trim_start().starts_with("load metadata") {
+            BuildPhase::BaseImage
+        } else {
+            BuildPhase::ContextUpload
+        };
+    }
+    if name.starts_with("exporting") || name.starts_with("writing image") {
+        return BuildPhase::ImageExport;
+    }
+    if name.starts_with("resolve image config") {
+        return BuildPhase::BaseImage;
+    }
+
+    // Steps of the Dockerfile are prefixed with their stage and position
+    let instruction = match name.strip_prefix('[').and_then(|rest| rest.split_once(']')) {
+        Some((_, instruction)) => instruction.trim_start(),
+        None => name,
+    };
+    let (keyword, command) = instruction.split_once(' ').unwrap_or((instruction, ""));
+    if keyword.eq_ignore_ascii_case("FROM") {
+        return BuildPhase::BaseImage;
+    }
+    if !keyword.eq_ignore_ascii_case("RUN") {
+        return BuildPhase::Other;
+    }
+    // Trailing space so a command ending the step still matches `npm i `
+    let command = format!("{} ", command);
+    if DEPENDENCY_INSTALL_COMMANDS
+        .iter()
+        .any(|install| command.contains(install))
+    {
+        BuildPhase::DependencyInstall
+    } else {
+        BuildPhase::Compile
+    }
+}
+
+/// Time a build spent in each phase
+///
+/// Steps of different stages can run in parallel, so the phases can add up to more
+/// than the build took.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BuildPhaseTimings {
+    pub context_upload_ms: u64,
+    pub base_image_ms: u64,
+    pub dependency_install_ms: u64,
+    pub compile_ms: u64,
+    pub image_export_ms: u64,
+    pub other_ms: u64,
+    /// Moving the image from a build node to this server; None for builds on this
+    /// server
+    pub push_ms: Option<u64>,
+    /// Steps the build ran
+    pub steps: u32,
+    /// Steps taken from the build cache instead of run
+    pub cached_steps: u32,
+}
+
+impl BuildPhaseTimings {
+    pub fn add(&mut self, phase: BuildPhase, ms: u64) {
+        match phase {
+            BuildPhase::ContextUpload => self.context_upload_ms += ms,
+            BuildPhase::BaseImage => self.base_image_ms += ms,
+            BuildPhase::DependencyInstall => self.dependency_install_ms += ms,
+            BuildPhase::Compile => self.compile_ms += ms,
+            BuildPhase::ImageExport => self.image_export_ms += ms,
+            BuildPhase::Other => self.other_ms += ms,
+        }
+    }
+}
+
+#[derive(Debug, Default)]
+struct StepTiming {
+    name: String,
+    cached: bool,
+    started_ms: Option<i64>,
+    completed_ms: Option<i64>,
+}
+
+/// Collects the steps BuildKit reports during a build
+///
+/// A step is reported again each time its state changes; the latest report of each
+/// wins.
+#[derive(Debug, Default)]
+pub struct BuildPhaseRecorder {
+    steps: HashMap<String, StepTiming>,
+    /// Time spent outside of BuildKit steps, such as packing the context
+    extra: Vec<(BuildPhase, u64)>,
+}
+
+impl BuildPhaseRecorder {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Record a step report; times are Unix milliseconds
+    pub fn observe(
+        &mut self,
+        digest: &str,
+        name: &str,
+        cached: bool,
+        started_ms: Option<i64>,
+        completed_ms: Option<i64>,
+    ) {
+        let step = self.steps.entry(digest.to_string()).or_default();
+        if !name.is_empty() {
+            step.name = name.to_string();
+        }
+        step.cached |= cached;
+        step.started_ms = started_ms.or(step.started_ms);
+        step.completed_ms = completed_ms.or(step.completed_ms);
+    }
+
+    /// Record time spent in a phase outside of BuildKit
+    pub fn add(&mut self, phase: BuildPhase, ms: u64) {
+        self.extra.push((phase, ms));
+    }
+
+    /// Whether BuildKit reported any step
+    pub fn is_empty(&self) -> bool {
+        self.steps.is_empty()
+    }
+
+    pub fn finish(self) -> BuildPhaseTimings {
+        let mut timings = BuildPhaseTimings::default();
+        for step in self.steps.values() {
+            timings.steps += 1;
+            if step.cached {
+                timings.cached_steps += 1;
+            }
+            if let (Some(started), Some(completed)) = (step.started_ms, step.completed_ms) {
+                timings.add(
+                    classify_step(&step.name),
+                    (completed - started).max(0) as u64,
+                );
+            }
+        }
+        for (phase, ms) in self.extra {
+            timings.add(phase, ms);
+        }
+        timings
+    }
+}
+
+/// Unix milliseconds of a protobuf timestamp's seconds and nanoseconds
+pub fn timestamp_ms(seconds: i64, nanos: i32) -> i64 {
+    seconds * 1000 + i64::from(nanos) / 1_000_000
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_classify_step() {
+        assert_eq!(
+            classify_step("[internal] load build context"),
+            BuildPhase::ContextUpload
+        );
+        assert_eq!(
+            classify_step("[internal] load metadata for docker.io/library/node:20-alpine"),
+            BuildPhase::BaseImage
+        );
+        assert_eq!(
+            classify_step("[1/6] FROM docker.io/library/node:20-alpine@sha256:4b82"),
+            BuildPhase::BaseImage
+        );
+        assert_eq!(
+            classify_step("[deps 2/4] RUN --mount=type=cache,target=/root/.npm npm ci"),
+            BuildPhase::DependencyInstall
+        );
+        assert_eq!(
+            classify_step("[stage-0 5/9] RUN npm i"),
+            BuildPhase::DependencyInstall
+        );
+        assert_eq!(
+            classify_step("[build 4/5] RUN npm run build"),
+            BuildPhase::Compile
+        );
+        assert_eq!(classify_step("[build 3/5] COPY . ."), BuildPhase::Other);
+        assert_eq!(classify_step("exporting to image"), BuildPhase::ImageExport);
+    }
+
+    #[test]
+    fn test_recorder_sums_steps_by_phase() {
+        let mut recorder = BuildPhaseRecorder::new();
+        // Reported when started, then again when completed
+        recorder.observe("sha256:a", "[2/4] RUN npm ci", false, Some(1_000), None);
+        recorder.observe("sha256:a", "[2/4] RUN npm ci", false, None, Some(31_000));
+        recorder.observe(
+            "sha256:b",
+            "[3/4] RUN npm run build",
+            false,
+            Some(31_000),
+            Some(91_000),
+        );
+        recorder.observe("sha256:c", "[1/4] FROM node:20", true, Some(0), Some(0));
+        recorder.observe(
+            "sha256:d",
+            "exporting to image",
+            false,
+            Some(91_000),
+            Some(96_500),
+        );
+        recorder.add(BuildPhase::ContextUpload, 1_200);
+
+        assert_eq!(
+            recorder.finish(),
+            BuildPhaseTimings {
+                context_upload_ms: 1_200,
+                base_image_ms: 0,
+                dependency_install_ms: 30_000,
+                compile_ms: 60_000,
+                image_export_ms: 5_500,
+                other_ms: 0,
+                push_ms: None,
+                steps: 4,
+                cached_steps: 1,
+            }
+        );
+    }
+
+    #[test]
+    fn test_timestamp_ms() {
+        assert_eq!(timestamp_ms(1_700_000_000, 250_000_000), 1_700_000_000_250);
+    }
+}
@@ -0,0 +1,209 @@
+//! Cloud Native Buildpacks builds
+//!
+//! Builds run the `pack` CLI against the local Docker daemon, so the built image
+//! sits next to the ones built from Dockerfiles and deploys the same way. `pack`
+//! has to be installed on the host running the builds.
+
+use crate::{BuilderError, ImagePullPolicy, LogCallback};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::process::Stdio;
+use tokio::io::{AsyncBufReadExt, AsyncWriteExt, BufReader};
+use tokio::process::Command;
+use tracing::{debug, info};
+
+/// Build of a source directory with Cloud Native Buildpacks
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BuildpacksRequest {
+    pub image_name: String,
+    pub context_path: PathBuf,
+    /// Builder image providing the build and run images and the default buildpacks
+    pub builder_image: String,
+    /// Buildpacks to run, in order, instead of the ones the builder detects
+    #[serde(default)]
+    pub buildpacks: Vec<String>,
+    /// Variables the buildpacks see during the build
+    #[serde(default)]
+    pub env: HashMap<String, String>,
+    pub log_path: PathBuf,
+    /// Whether the builder and run images are pulled even when a local copy exists
+    #[serde(default)]
+    pub pull_policy: ImagePullPolicy,
+}
+
+/// Arguments of the `pack build` command for a request
+///
+/// Variables are passed by name only and handed to `pack` through its environment,
+/// so their values don't show up in the host's process list.
+pub fn pack_build_args(request: &BuildpacksRequest) -> Vec<String> {
+    let mut args = vec![
+        "build".to_string(),
+        request.image_name.clone(),
+        "--path".to_string(),
+        request.context_path.to_string_lossy().to_string(),
+        "--builder".to_string(),
+        request.builder_image.clone(),
+        "--pull-policy".to_string(),
+        match request.pull_policy {
+            ImagePullPolicy::Always => "always",
+            ImagePullPolicy::IfNotPresent => "if-not-present",
+        }
+        .to_string(),
+    ];
+    for buildpack in &request.buildpacks {
+        args.push("--buildpack".to_string());
+        args.push(buildpack.clone());
+    }
+    let mut env_names: Vec<&String> = request.env.keys().collect();
+    env_names.sort();
+    for name in env_names {
+        args.push("--env".to_string());
+        args.push(name.clone());
+    }
+    args
+}
+
+/// Run `pack build`, streaming its output to the callback and the request's log file
+pub async fn pack_build(
+    request: &BuildpacksRequest,
+    log_callback: Option<LogCallback>,
+) -> Result<(), BuilderError> {
+    if !request.context_path.is_dir() {
+        return Err(BuilderError::InvalidContext(format!(
+            "Build context {} is not a directory",
+            request.context_path.display()
+        )));
+    }
+
+    info!(
+        "Building image {} with buildpacks (builder: {})",
+        request.image_name, request.builder_image
+    );
+
+    let mut child = Command::new("pack")
+        .args(pack_build_args(request))
+        .envs(&request.env)
+        .stdin(Stdio::null())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .kill_on_drop(true)
+        .spawn()
+        .map_err(|e| {
+            if e.kind() == std::io::ErrorKind::NotFound {
+                BuilderError::BuildFailed(
+                    "The `pack` CLI is not installed on this server; install it to build \
+                     with Cloud Native Buildpacks"
+                        .to_string(),
+                )
+            } else {
+                BuilderError::IoError(e)
+            }
+        })?;
+
+    let mut log_file = tokio::fs::File::create(&request.log_path).await?;
+    let stdout = child.stdout.take().map(|s| BufReader::new(s).lines());
+    let stderr = child.stderr.take().map(|s| BufReader::new(s).lines());
+    let (Some(mut stdout), Some(mut stderr)) = (stdout, stderr) else {
+        return Err(BuilderError::Other(
+            "Failed to capture pack output".to_string(),
+        ));
+    };
+
+    // Keep the last lines to explain a failure
+    let mut tail: Vec<String> = Vec::new();
+    let (mut stdout_open, mut stderr_open) = (true, true);
+    while stdout_open || stderr_open {
+        let line = tokio::select! {
+            line = stdout.next_line(), if stdout_open => line?.or_else(|| {
+                stdout_open = false;
+                None
+            }),
+            line = stderr.next_line(), if stderr_open => line?.or_else(|| {
+                stderr_open = false;
+                None
+            }),
+        };
+        let Some(line) = line else {
+            continue;
+        };
+
+        let _ = log_file.write_all(format!("{}\n", line).as_bytes()).await;
+        if let Some(callback) = &log_callback {
+            callback(line.clone()).await;
+        }
+        if tail.len() == 5 {
+            tail.remove(0);
+        }
+        tail.push(line);
+    }
+    let _ = log_file.flush().await;
+
+    let status = child.wait().await?;
+    if !status.success() {
+        return Err(BuilderError::BuildFailed(format!(
+            "pack build exited with {}: {}",
+            status,
+            tail.join("\n")
+        )));
+    }
+
+    debug!("pack build of {} finished", request.image_name);
+    Ok(())
+}
+
+/// Whether the directory has anything for buildpacks to detect
+///
+/// Buildpacks run their own detection during the build; this only rules out an
+/// empty source directory up front.
+pub fn has_source(context_path: &Path) -> bool {
+    std::fs::read_dir(context_path).is_ok_and(|mut entries| entries.next().is_some())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_pack_build_args_keep_variable_values_out() {
+        let request = BuildpacksRequest {
+            image_name: "temps-app:12".to_string(),
+            context_path: PathBuf::from("/tmp/repo"),
+            builder_image: "paketobuildpacks/builder-jammy-base".to_string(),
+            buildpacks: vec![
+                "paketo-buildpacks/nodejs".to_string(),
+                "docker://example/extra".to_string(),
+            ],
+            env: HashMap::from([
+                ("NODE_ENV".to_string(), "production".to_string()),
+                ("API_TOKEN".to_string(), "secret".to_string()),
+            ]),
+            log_path: PathBuf::from("/tmp/build.log"),
+            pull_policy: ImagePullPolicy::Always,
+        };
+
+        let args = pack_build_args(&request);
+        assert_eq!(
+            args,
+            [
+                "build",
+                "temps-app:12",
+                "--path",
+                "/tmp/repo",
+                "--builder",
+                "paketobuildpacks/builder-jammy-base",
+                "--pull-policy",
+                "always",
+                "--buildpack",
+                "paketo-buildpacks/nodejs",
+                "--buildpack",
+                "docker://example/extra",
+                "--env",
+                "API_TOKEN",
+                "--env",
+                "NODE_ENV",
+            ]
+        );
+        assert!(!args.iter().any(|arg| arg.contains("secret")));
+    }
+}
@@ -1,9 +1,11 @@
 //! Docker implementation of ImageBuilder and ContainerDeployer traits
 
+use crate::build_context::BuildContext;
+use crate::build_phases::{timestamp_ms, BuildPhase, BuildPhaseRecorder};
 use crate::{
     BuildRequest, BuildResult, BuilderError, ContainerDeployer, ContainerInfo, ContainerRuntime,
-    ContainerStatus, DeployRequest, DeployResult, DeployerError, ImageBuilder, PortMapping,
-    Protocol, RuntimeInfo,
+    ContainerStatus, DeployRequest, DeployResult, DeployerError, ImageBuilder, ImagePullPolicy,
+    ManagedContainer, PortMapping, Protocol, RuntimeInfo,
 };
 use async_trait::async_trait;
 use bollard::{
@@ -55,6 +57,10 @@ impl DockerRuntime {
             let create_options = bollard::models::NetworkCreateRequest {
                 name: self.network_name.clone(),
                 driver: Some("bridge".to_string()),
+                labels: Some(HashMap::from([(
+                    crate::labels::LABEL_MANAGED.to_string(),
+                    "true".to_string(),
+                )])),
                 ..Default::default()
             };
 
@@ -71,19 +77,26 @@ impl DockerRuntime {
 
     async fn create_tar_context_body(
         &self,
-        context_path: PathBuf,
+        request: &BuildRequest,
     ) -> Result<http_body_util::Full<bytes::Bytes>, BuilderError> {
         use bytes::Bytes;
         use http_body_util::Full;
 
-        let mut tar_buffer = Vec::new();
-        {
-            let mut tar_builder = tar::Builder::new(&mut tar_buffer);
-            tar_builder
-                .append_dir_all(".", context_path)
-                .map_err(BuilderError::IoError)?;
-            tar_builder.finish().map_err(BuilderError::IoError)?;
-        }
+        // What the ignore files exclude is never uploaded
+        let context = BuildContext::collect(
+            &request.context_path,
+            request.dockerfile_path.as_deref(),
+            request.honor_gitignore,
+        )
+        .map_err(BuilderError::IoError)?;
+        info!(
+            "Build context of {}: {}",
+            request.image_name,
+            context.summary()
+        );
+        let tar_buffer = context
+            .pack(&request.context_path)
+            .map_err(BuilderError::IoError)?;
 
         // Create body from tar buffer as expected by Bollard
         // Return Full<Bytes> which will be converted to Either::Left automatically
@@ -122,6 +135,95 @@ impl DockerRuntime {
         }
     }
 
+    /// Platforms a build is for, the native one unless the request names any
+    ///
+    /// Several comma-separated platforms produce an image index; the daemon builds
+    /// the foreign ones under QEMU emulation, so that needs BuildKit.
+    fn build_platform(&self, platform: Option<String>) -> Result<String, BuilderError> {
+        let platform = platform.unwrap_or_else(Self::get_native_platform);
+        if platform.contains(',') && !self.use_buildkit {
+            return Err(BuilderError::BuildFailed(format!(
+                "Building for {} needs BuildKit, which is disabled",
+                platform
+            )));
+        }
+        Ok(platform)
+    }
+
+    /// Point out what multi-platform builds need when the daemon refuses one
+    fn explain_build_error(error: String, platform: &str) -> String {
+        let lowercase = error.to_lowercase();
+        if platform.contains(',')
+            && (lowercase.contains("multi-platform") || lowercase.contains("multiple platforms"))
+        {
+            format!(
+                "{} (multi-platform builds need the containerd image store enabled in the Docker daemon)",
+                error
+            )
+        } else {
+            error
+        }
+    }
+
+    /// Pull an image from its registry, waiting for the pull to finish
+    async fn pull_image(&self, image_name: &str) -> Result<(), bollard::errors::Error> {
+        self.docker
+            .create_image(
+                Some(bollard::query_parameters::CreateImageOptions {
+                    from_image: Some(image_name.to_string()),
+                    ..Default::default()
+                }),
+                None,
+                None,
+            )
+            .try_for_each(|_| async { Ok(()) })
+            .await
+    }
+
+    /// Make the image of a deployment available locally according to its pull policy
+    ///
+    /// Missing images are always pulled. With `Always`, images that came from a
+    /// registry are pulled again to pick up new pushes of their tag; images built
+    /// locally have nothing to pull and are used as they are. When the pull fails, the
+    /// local copy is used.
+    async fn ensure_image(
+        &self,
+        image_name: &str,
+        pull_policy: ImagePullPolicy,
+    ) -> Result<(), DeployerError> {
+        let from_registry = match self.docker.inspect_image(image_name).await {
+            Ok(image) => Some(
+                image
+                    .repo_digests
+                    .is_some_and(|digests| !digests.is_empty()),
+            ),
+            Err(_) => None,
+        };
+        let keep_local = match pull_policy {
+            ImagePullPolicy::IfNotPresent => from_registry.is_some(),
+            ImagePullPolicy::Always => from_registry == Some(false),
+        };
+        if keep_local {
+            return Ok(());
+        }
+
+        info!("Pulling image {}", image_name);
+        match self.pull_image(image_name).await {
+            Ok(()) => Ok(()),
+            Err(e) if from_registry.is_some() => {
+                warn!(
+                    "⚠️  Failed to pull image {}, using the local copy: {}",
+                    image_name, e
+                );
+                Ok(())
+            }
+            Err(e) => Err(DeployerError::ImageNotFound(format!(
+                "{} is not available locally and could not be pulled: {}",
+                image_name, e
+            ))),
+        }
+    }
+
     async fn concat_byte_stream<S>(s: S) -> Result<Vec<u8>, bollard::errors::Error>
     where
         S: Stream<Item = Result<bytes::Bytes, bollard::errors::Error>>,
@@ -224,9 +326,13 @@ impl ImageBuilder for DockerRuntime {
         );
 
         // Create tar archive body from build context
-        let tar_body = self
-            .create_tar_context_body(request.context_path.clone())
-            .await?;
+        let packing_started = Instant::now();
+        let tar_body = self.create_tar_context_body(&request).await?;
+        let mut phases = BuildPhaseRecorder::new();
+        phases.add(
+            BuildPhase::ContextUpload,
+            packing_started.elapsed().as_millis() as u64,
+        );
 
         // Prepare build options using Bollard
         let mut build_args = HashMap::new();
@@ -236,8 +342,8 @@ impl ImageBuilder for DockerRuntime {
 
         let (cpu_limit, memory_limit) = Self::get_resource_limits();
 
-        let mut labels = HashMap::new();
-        labels.insert("built-by".to_string(), "temps".to_string());
+        let labels = crate::labels::build_labels();
+        let platform = self.build_platform(request.platform.clone())?;
         let mut build_args = Some(build_args.clone());
         if self.use_buildkit && !request.build_args_buildkit.is_empty() {
             build_args = Some(request.build_args_buildkit.clone());
@@ -259,7 +365,8 @@ impl ImageBuilder for DockerRuntime {
                 // Legacy builder supports custom networks
                 Some(self.network_name.clone())
             },
-            platform: request.platform.unwrap_or_else(Self::get_native_platform),
+            platform: platform.clone(),
+            pull: (request.pull_policy == ImagePullPolicy::Always).then(|| "true".to_string()),
             memory: Some(((memory_limit * 1024 * 1024 * 1024) & 0x7FFFFFFF) as i32), // Convert GB to bytes
             cpuquota: Some((cpu_limit * 100000) as i32), // CPU quota in microseconds (cpu_limit * 100ms)
             cpuperiod: Some(100000),                     // CPU period in microseconds (100ms)
@@ -299,12 +406,31 @@ impl ImageBuilder for DockerRuntime {
                         let _ = log_file.write_all(stream.as_bytes()).await;
                         debug!("Build: {}", stream.trim());
                     }
+                    if let Some(bollard::models::BuildInfoAux::BuildKit(res)) = info.aux {
+                        for vertex in &res.vertexes {
+                            phases.observe(
+                                &vertex.digest,
+                                &vertex.name,
+                                vertex.cached,
+                                vertex
+                                    .started
+                                    .as_ref()
+                                    .map(|t| timestamp_ms(t.seconds, t.nanos)),
+                                vertex
+                                    .completed
+                                    .as_ref()
+                                    .map(|t| timestamp_ms(t.seconds, t.nanos)),
+                            );
+                        }
+                    }
                     if let Some(error) = info.error {
                         error!("Build error: {}", error);
                         let _ = log_file
                             .write_all(format!("ERROR: {}\n", error).as_bytes())
                             .await;
-                        return Err(BuilderError::BuildFailed(error));
+                        return Err(BuilderError::BuildFailed(Self::explain_build_error(
+                            error, &platform,
+                        )));
                     }
                 }
                 Err(e) => {
@@ -345,6 +471,7 @@ impl ImageBuilder for DockerRuntime {
             image_name: request.image_name,
             size_bytes: image.size as u64,
             build_duration_ms: build_duration,
+            phases: (!phases.is_empty()).then(|| phases.finish()),
         })
     }
 
@@ -379,9 +506,13 @@ impl ImageBuilder for DockerRuntime {
         );
 
         // Create tar archive body from build context
-        let tar_body = self
-            .create_tar_context_body(request.context_path.clone())
-            .await?;
+        let packing_started = Instant::now();
+        let tar_body = self.create_tar_context_body(&request).await?;
+        let mut phases = BuildPhaseRecorder::new();
+        phases.add(
+            BuildPhase::ContextUpload,
+            packing_started.elapsed().as_millis() as u64,
+        );
 
         // Prepare build options using Bollard
         let mut build_args = HashMap::new();
@@ -391,8 +522,8 @@ impl ImageBuilder for DockerRuntime {
 
         let (cpu_limit, memory_limit) = Self::get_resource_limits();
 
-        let mut labels = HashMap::new();
-        labels.insert("built-by".to_string(), "temps".to_string());
+        let labels = crate::labels::build_labels();
+        let platform = self.build_platform(request.platform.clone())?;
 
         let build_options = bollard::query_parameters::BuildImageOptions {
             dockerfile: request
@@ -411,7 +542,8 @@ impl ImageBuilder for DockerRuntime {
                 // Legacy builder supports custom networks
                 Some(self.network_name.clone())
             },
-            platform: request.platform.unwrap_or_else(Self::get_native_platform),
+            platform: platform.clone(),
+            pull: (request.pull_policy == ImagePullPolicy::Always).then(|| "true".to_string()),
             memory: Some(((memory_limit * 1024 * 1024 * 1024) & 0x7FFFFFFF) as i32), // Convert GB to bytes
             cpuquota: Some((cpu_limit * 100000) as i32), // CPU quota in microseconds (cpu_limit * 100ms)
             cpuperiod: Some(100000),                     // CPU period in microseconds (100ms)
@@ -468,9 +600,26 @@ impl ImageBuilder for DockerRuntime {
                             callback(error_line.clone()).await;
                         }
 
-                        return Err(BuilderError::BuildFailed(error));
+                        return Err(BuilderError::BuildFailed(Self::explain_build_error(
+                            error, &platform,
+                        )));
                     }
                     if let Some(bollard::models::BuildInfoAux::BuildKit(res)) = info.aux {
+                        for vertex in &res.vertexes {
+                            phases.observe(
+                                &vertex.digest,
+                                &vertex.name,
+                                vertex.cached,
+                                vertex
+                                    .started
+                                    .as_ref()
+                                    .map(|t| timestamp_ms(t.seconds, t.nanos)),
+                                vertex
+                                    .completed
+                                    .as_ref()
+                                    .map(|t| timestamp_ms(t.seconds, t.nanos)),
+                            );
+                        }
                         for log in res.logs {
                             // Write to file
                             let _ = log_file.write_all(&log.msg[..]).await;
@@ -526,6 +675,45 @@ impl ImageBuilder for DockerRuntime {
             image_name: request.image_name,
             size_bytes: image.size as u64,
             build_duration_ms: build_duration,
+            phases: (!phases.is_empty()).then(|| phases.finish()),
+        })
+    }
+
+    async fn build_with_buildpacks(
+        &self,
+        request: crate::buildpacks::BuildpacksRequest,
+        log_callback: Option<crate::LogCallback>,
+    ) -> Result<BuildResult, BuilderError> {
+        let start_time = Instant::now();
+
+        // pack runs the build against this daemon, so the image lands here
+        crate::buildpacks::pack_build(&request, log_callback).await?;
+
+        let build_duration = start_time.elapsed().as_millis() as u64;
+
+        let images = self
+            .docker
+            .list_images(Some(bollard::query_parameters::ListImagesOptions {
+                filters: {
+                    let mut filters = HashMap::new();
+                    filters.insert("reference".to_string(), vec![request.image_name.clone()]);
+                    Some(filters)
+                },
+                ..Default::default()
+            }))
+            .await
+            .map_err(|e| BuilderError::Other(format!("Failed to get image info: {}", e)))?;
+
+        let image = images
+            .first()
+            .ok_or_else(|| BuilderError::Other("Built image not found".to_string()))?;
+
+        Ok(BuildResult {
+            image_id: image.id.clone(),
+            image_name: request.image_name,
+            size_bytes: image.size as u64,
+            build_duration_ms: build_duration,
+            phases: None,
         })
     }
 
@@ -724,6 +912,35 @@ impl ImageBuilder for DockerRuntime {
 
         Ok(())
     }
+
+    async fn image_digest(
+        &self,
+        image_name: &str,
+        pull: bool,
+    ) -> Result<Option<String>, BuilderError> {
+        if pull {
+            self.pull_image(image_name).await.map_err(|e| {
+                BuilderError::Other(format!("Failed to pull image {}: {}", image_name, e))
+            })?;
+        }
+
+        let image = match self.docker.inspect_image(image_name).await {
+            Ok(image) => image,
+            Err(bollard::errors::Error::DockerResponseServerError {
+                status_code: 404, ..
+            }) => return Ok(None),
+            Err(e) => {
+                return Err(BuilderError::Other(format!(
+                    "Failed to inspect image {}: {}",
+                    image_name, e
+                )))
+            }
+        };
+        Ok(crate::base_images::repo_digest(
+            image_name,
+            &image.repo_digests.unwrap_or_default(),
+        ))
+    }
 }
 
 #[async_trait]
@@ -738,6 +955,8 @@ impl ContainerDeployer for DockerRuntime {
         );
 
         self.ensure_network_exists().await?;
+        self.ensure_image(&request.image_name, request.pull_policy)
+            .await?;
 
         // Check if a container with this name already exists and remove it
         match self.find_container_by_name(&request.container_name).await {
@@ -805,6 +1024,36 @@ impl ContainerDeployer for DockerRuntime {
                 .resource_limits
                 .cpu_limit
                 .map(|cores| (cores * 1_000_000_000.0) as i64),
+            log_config: request.log_config.as_ref().map(|log_config| {
+                bollard::models::HostConfigLogConfig {
+                    typ: Some(log_config.driver.clone()),
+                    config: Some(log_config.options.clone()),
+                }
+            }),
+            binds: (!request.bind_mounts.is_empty()).then(|| {
+                request
+                    .bind_mounts
+                    .iter()
+                    .map(|mount| mount.to_bind_spec())
+                    .collect()
+            }),
+            dns: (!request.dns.nameservers.is_empty()).then(|| request.dns.nameservers.clone()),
+            dns_search: (!request.dns.search_domains.is_empty())
+                .then(|| request.dns.search_domains.clone()),
+            extra_hosts: (!request.dns.extra_hosts.is_empty())
+                .then(|| request.dns.extra_hosts.clone()),
+            ulimits: (!request.ulimits.is_empty()).then(|| {
+                request
+                    .ulimits
+                    .iter()
+                    .map(|ulimit| bollard::models::ResourcesUlimits {
+                        name: Some(ulimit.name.clone()),
+                        soft: Some(ulimit.soft),
+                        hard: Some(ulimit.hard),
+                    })
+                    .collect()
+            }),
+            shm_size: request.shm_size_bytes.map(|bytes| bytes as i64),
             ..Default::default()
         };
 
@@ -821,6 +1070,15 @@ impl ContainerDeployer for DockerRuntime {
             exposed_ports: Some(exposed_ports),
             host_config: Some(host_config),
             cmd: request.command.clone(),
+            entrypoint: request.entrypoint.clone(),
+            labels: if request.labels.is_empty() {
+                None
+            } else {
+                Some(request.labels.clone())
+            },
+            // Read back by `docker stop`, so every stop of the container is graceful
+            stop_signal: request.stop_signal.clone(),
+            stop_timeout: request.stop_timeout_seconds.map(i64::from),
             ..Default::default()
         };
 
@@ -980,6 +1238,7 @@ impl ContainerDeployer for DockerRuntime {
             created_at: container.created.unwrap_or_else(chrono::Utc::now),
             ports: port_mappings,
             environment_vars: env_vars,
+            labels: config.labels.unwrap_or_default(),
         })
     }
 
@@ -1107,6 +1366,47 @@ impl ContainerDeployer for DockerRuntime {
         Ok(container_infos)
     }
 
+    async fn list_managed_containers(&self) -> Result<Vec<ManagedContainer>, DeployerError> {
+        let mut filters: HashMap<String, Vec<String>> = HashMap::new();
+        filters.insert(
+            "label".to_string(),
+            vec![format!("{}=true", crate::labels::LABEL_MANAGED)],
+        );
+
+        let containers = self
+            .docker
+            .list_containers(Some(ListContainersOptions {
+                all: true,
+                filters: Some(filters),
+                ..Default::default()
+            }))
+            .await
+            .map_err(|e| DeployerError::Other(format!("Failed to list containers: {}", e)))?;
+
+        Ok(containers
+            .into_iter()
+            .filter_map(|container| {
+                let container_id = container.id?;
+                let container_name = container
+                    .names
+                    .and_then(|names| names.into_iter().next())
+                    .unwrap_or_default()
+                    .trim_start_matches('/')
+                    .to_string();
+                let status = Self::map_container_status(
+                    &container.state.map(|s| s.to_string()).unwrap_or_default(),
+                );
+
+                Some(ManagedContainer {
+                    container_id,
+                    container_name,
+                    status,
+                    labels: container.labels.unwrap_or_default(),
+                })
+            })
+            .collect())
+    }
+
     async fn get_container_logs(&self, container_id: &str) -> Result<String, DeployerError> {
         let logs_stream = self
             .docker
@@ -1238,6 +1538,19 @@ mod docker_tests {
         );
     }
 
+    #[test]
+    fn test_multi_platform_build_error_hint() {
+        let refused = "multiple platforms feature is currently not supported for docker driver";
+        assert!(
+            DockerRuntime::explain_build_error(refused.to_string(), "linux/amd64,linux/arm64")
+                .contains("containerd image store")
+        );
+        assert_eq!(
+            DockerRuntime::explain_build_error(refused.to_string(), "linux/amd64"),
+            refused
+        );
+    }
+
     #[tokio::test]
     #[serial]
     async fn test_docker_build_with_dockerfile() {
@@ -1263,6 +1576,8 @@ CMD ["cat", "/hello.txt"]
                     build_args_buildkit: HashMap::new(),
                     platform: None,
                     log_path: temp_dir.path().join("build.log"),
+                    pull_policy: crate::ImagePullPolicy::default(),
+                    honor_gitignore: false,
                 };
 
                 let result = timeout(Duration::from_secs(60), runtime.build_image(request)).await;
@@ -1336,6 +1651,16 @@ CMD ["cat", "/hello.txt"]
                     restart_policy: RestartPolicy::Never,
                     log_path: PathBuf::from("/tmp/lifecycle-test.log"),
                     command: Some(vec!["sleep".to_string(), "30".to_string()]),
+                    entrypoint: None,
+                    labels: HashMap::new(),
+                    log_config: None,
+                    bind_mounts: Vec::new(),
+                    pull_policy: crate::ImagePullPolicy::default(),
+                    dns: crate::ContainerDns::default(),
+                    stop_signal: None,
+                    stop_timeout_seconds: None,
+                    ulimits: Vec::new(),
+                    shm_size_bytes: None,
                 };
 
                 let deploy_result = runtime.deploy_container(deploy_request).await;
@@ -0,0 +1,432 @@
+//! Resilient container runtime event watching
+//!
+//! The Docker events stream is a long-lived HTTP connection that is dropped whenever the
+//! daemon restarts. [`RuntimeEventWatcher`] wraps the stream in an outer retry loop with
+//! exponential backoff so the orchestrator keeps tracking containers across daemon
+//! restarts, and notifies a [`RuntimeEventHandler`] every time the connection is
+//! (re-)established so it can reconcile any drift that happened while disconnected.
+
+use crate::labels::LABEL_MANAGED;
+use crate::DeployerError;
+use async_trait::async_trait;
+use bollard::Docker;
+use futures::stream::BoxStream;
+use futures::StreamExt;
+use std::collections::HashMap;
+use std::sync::Arc;
+use std::time::Duration;
+use tokio_util::sync::CancellationToken;
+use tracing::{debug, info, warn};
+
+/// Container lifecycle event reported by the runtime
+#[derive(Debug, Clone, PartialEq)]
+pub enum RuntimeEvent {
+    /// A container was started
+    ContainerStarted {
+        container_id: String,
+        labels: HashMap<String, String>,
+    },
+    /// A container stopped or crashed
+    ContainerDied {
+        container_id: String,
+        labels: HashMap<String, String>,
+    },
+    /// A container was removed
+    ContainerDestroyed {
+        container_id: String,
+        labels: HashMap<String, String>,
+    },
+}
+
+/// Stream of runtime events produced by a single subscription
+pub type RuntimeEventStream = BoxStream<'static, Result<RuntimeEvent, DeployerError>>;
+
+/// Source of container runtime events (mockable for testing)
+#[async_trait]
+pub trait RuntimeEventSource: Send + Sync {
+    /// Open a new event subscription.
+    ///
+    /// Returns an error if the runtime is unreachable. The returned stream ends or yields
+    /// an error when the connection is lost.
+    async fn subscribe(&self) -> Result<RuntimeEventStream, DeployerError>;
+}
+
+/// Consumer of runtime events
+#[async_trait]
+pub trait RuntimeEventHandler: Send + Sync {
+    /// Called each time a subscription is established.
+    ///
+    /// `reconnected` is false for the very first connection and true for every
+    /// subsequent one (i.e. after the runtime was unreachable).
+    async fn on_connected(&self, reconnected: bool);
+
+    /// Called for every event received while connected
+    async fn on_event(&self, event: RuntimeEvent);
+}
+
+/// Exponential backoff used between reconnection attempts
+#[derive(Debug, Clone)]
+pub struct ReconnectBackoff {
+    initial: Duration,
+    max: Duration,
+    current: Duration,
+}
+
+impl ReconnectBackoff {
+    pub fn new(initial: Duration, max: Duration) -> Self {
+        Self {
+            initial,
+            max,
+            current: initial,
+        }
+    }
+
+    /// Return the delay to wait before the next attempt and double it for the one after
+    pub fn next_delay(&mut self) -> Duration {
+        let delay = self.current;
+        self.current = (self.current * 2).min(self.max);
+        delay
+    }
+
+    /// Reset the backoff after a successful connection
+    pub fn reset(&mut self) {
+        self.current = self.initial;
+    }
+}
+
+impl Default for ReconnectBackoff {
+    fn default() -> Self {
+        Self::new(Duration::from_secs(1), Duration::from_secs(60))
+    }
+}
+
+/// Watches runtime events and transparently reconnects when the stream is lost
+pub struct RuntimeEventWatcher {
+    source: Arc<dyn RuntimeEventSource>,
+    handler: Arc<dyn RuntimeEventHandler>,
+    backoff: ReconnectBackoff,
+}
+
+impl RuntimeEventWatcher {
+    pub fn new(source: Arc<dyn RuntimeEventSource>, handler: Arc<dyn RuntimeEventHandler>) -> Self {
+        Self {
+            source,
+            handler,
+            backoff: ReconnectBackoff::default(),
+        }
+    }
+
+    pub fn with_backoff(mut self, backoff: ReconnectBackoff) -> Self {
+        self.backoff = backoff;
+        self
+    }
+
+    /// Run the watcher until the cancellation token is triggered
+    pub async fn run(&self, cancellation_token: CancellationToken) {
+        let mut backoff = self.backoff.clone();
+        let mut has_connected = false;
+
+        loop {
+            if cancellation_token.is_cancelled() {
+                break;
+            }
+
+            match self.source.subscribe().await {
+                Ok(mut stream) => {
+                    backoff.reset();
+                    if has_connected {
+                        info!("Reconnected to container runtime event stream");
+                    } else {
+                        debug!("Connected to container runtime event stream");
+                    }
+                    self.handler.on_connected(has_connected).await;
+                    has_connected = true;
+
+                    loop {
+                        tokio::select! {
+                            _ = cancellation_token.cancelled() => {
+                                debug!("Runtime event watcher cancelled");
+                                return;
+                            }
+                            item = stream.next() => match item {
+                                Some(Ok(event)) => self.handler.on_event(event).await,
+                                Some(Err(e)) => {
+                                    warn!("Container runtime event stream error: {}", e);
+                                    break;
+                                }
+                                None => {
+                                    warn!("Container runtime event stream closed");
+                                    break;
+                                }
+                            }
+                        }
+                    }
+                }
+                Err(e) => {
+                    warn!("Failed to subscribe to container runtime events: {}", e);
+                }
+            }
+
+            let delay = backoff.next_delay();
+            debug!(
+                "Reconnecting to container runtime event stream in {:?}",
+                delay
+            );
+            tokio::select! {
+                _ = cancellation_token.cancelled() => break,
+                _ = tokio::time::sleep(delay) => {}
+            }
+        }
+    }
+}
+
+/// Docker implementation of [`RuntimeEventSource`]
+///
+/// Only events for containers carrying the `sh.temps.managed` label are reported.
+pub struct DockerEventSource {
+    docker: Arc<Docker>,
+}
+
+impl DockerEventSource {
+    pub fn new(docker: Arc<Docker>) -> Self {
+        Self { docker }
+    }
+
+    fn map_event(message: bollard::models::EventMessage) -> Option<RuntimeEvent> {
+        let action = message.action?;
+        let actor = message.actor?;
+        let container_id = actor.id?;
+        let labels = actor.attributes.unwrap_or_default();
+
+        match action.as_str() {
+            "start" => Some(RuntimeEvent::ContainerStarted {
+                container_id,
+                labels,
+            }),
+            "die" => Some(RuntimeEvent::ContainerDied {
+                container_id,
+                labels,
+            }),
+            "destroy" => Some(RuntimeEvent::ContainerDestroyed {
+                container_id,
+                labels,
+            }),
+            _ => None,
+        }
+    }
+}
+
+#[async_trait]
+impl RuntimeEventSource for DockerEventSource {
+    async fn subscribe(&self) -> Result<RuntimeEventStream, DeployerError> {
+        // The events endpoint is lazy, so ping first to detect an unreachable daemon
+        self.docker.ping().await.map_err(|e| {
+            DeployerError::NetworkError(format!("Docker daemon unreachable: {}", e))
+        })?;
+
+        let mut filters: HashMap<String, Vec<String>> = HashMap::new();
+        filters.insert("type".to_string(), vec!["container".to_string()]);
+        filters.insert("label".to_string(), vec![format!("{}=true", LABEL_MANAGED)]);
+        filters.insert(
+            "event".to_string(),
+            vec![
+                "start".to_string(),
+                "die".to_string(),
+                "destroy".to_string(),
+            ],
+        );
+
+        let options = bollard::query_parameters::EventsOptions {
+            filters: Some(filters),
+            ..Default::default()
+        };
+
+        let stream = self
+            .docker
+            .events(Some(options))
+            .filter_map(|item| async move {
+                match item {
+                    Ok(message) => Self::map_event(message).map(Ok),
+                    Err(e) => Some(Err(DeployerError::NetworkError(format!(
+                        "Docker event stream error: {}",
+                        e
+                    )))),
+                }
+            })
+            .boxed();
+
+        Ok(stream)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::VecDeque;
+    use std::sync::Mutex;
+
+    /// Scripted subscription outcome for the mock source
+    enum Subscription {
+        /// Daemon unreachable
+        Unavailable,
+        /// Daemon reachable; yields the events and then drops the connection
+        Events(Vec<RuntimeEvent>),
+    }
+
+    struct MockEventSource {
+        script: Mutex<VecDeque<Subscription>>,
+        attempts: Mutex<u32>,
+    }
+
+    impl MockEventSource {
+        fn new(script: Vec<Subscription>) -> Self {
+            Self {
+                script: Mutex::new(script.into()),
+                attempts: Mutex::new(0),
+            }
+        }
+    }
+
+    #[async_trait]
+    impl RuntimeEventSource for MockEventSource {
+        async fn subscribe(&self) -> Result<RuntimeEventStream, DeployerError> {
+            *self.attempts.lock().unwrap() += 1;
+            match self.script.lock().unwrap().pop_front() {
+                Some(Subscription::Events(events)) => {
+                    let mut items: Vec<Result<RuntimeEvent, DeployerError>> =
+                        events.into_iter().map(Ok).collect();
+                    // Simulate the daemon going away mid-stream
+                    items.push(Err(DeployerError::NetworkError(
+                        "connection reset".to_string(),
+                    )));
+                    Ok(futures::stream::iter(items).boxed())
+                }
+                Some(Subscription::Unavailable) | None => Err(DeployerError::NetworkError(
+                    "daemon unreachable".to_string(),
+                )),
+            }
+        }
+    }
+
+    #[derive(Default)]
+    struct RecordingHandler {
+        connections: Mutex<Vec<bool>>,
+        events: Mutex<Vec<RuntimeEvent>>,
+    }
+
+    #[async_trait]
+    impl RuntimeEventHandler for RecordingHandler {
+        async fn on_connected(&self, reconnected: bool) {
+            self.connections.lock().unwrap().push(reconnected);
+        }
+
+        async fn on_event(&self, event: RuntimeEvent) {
+            self.events.lock().unwrap().push(event);
+        }
+    }
+
+    fn started(id: &str) -> RuntimeEvent {
+        RuntimeEvent::ContainerStarted {
+            container_id: id.to_string(),
+            labels: HashMap::new(),
+        }
+    }
+
+    #[test]
+    fn test_backoff_doubles_and_caps() {
+        let mut backoff = ReconnectBackoff::new(Duration::from_secs(1), Duration::from_secs(5));
+
+        assert_eq!(backoff.next_delay(), Duration::from_secs(1));
+        assert_eq!(backoff.next_delay(), Duration::from_secs(2));
+        assert_eq!(backoff.next_delay(), Duration::from_secs(4));
+        assert_eq!(backoff.next_delay(), Duration::from_secs(5));
+        assert_eq!(backoff.next_delay(), Duration::from_secs(5));
+
+        backoff.reset();
+        assert_eq!(backoff.next_delay(), Duration::from_secs(1));
+    }
+
+    #[tokio::test]
+    async fn test_watcher_reconnects_after_daemon_dropout() {
+        let source = Arc::new(MockEventSource::new(vec![
+            Subscription::Events(vec![started("a")]),
+            // Daemon restarting
+            Subscription::Unavailable,
+            Subscription::Unavailable,
+            Subscription::Events(vec![started("b")]),
+        ]));
+        let handler = Arc::new(RecordingHandler::default());
+        let watcher = RuntimeEventWatcher::new(source.clone(), handler.clone()).with_backoff(
+            ReconnectBackoff::new(Duration::from_millis(10), Duration::from_millis(100)),
+        );
+
+        let token = CancellationToken::new();
+        let run_token = token.clone();
+        let task = tokio::spawn(async move { watcher.run(run_token).await });
+
+        // Wait until the watcher has reconnected after the simulated dropout
+        tokio::time::timeout(Duration::from_secs(5), async {
+            while handler.connections.lock().unwrap().len() < 2 {
+                tokio::time::sleep(Duration::from_millis(5)).await;
+            }
+        })
+        .await
+        .expect("watcher did not reconnect");
+        token.cancel();
+        task.await.unwrap();
+
+        assert_eq!(*handler.connections.lock().unwrap(), vec![false, true]);
+        assert_eq!(
+            *handler.events.lock().unwrap(),
+            vec![started("a"), started("b")]
+        );
+        assert!(*source.attempts.lock().unwrap() >= 4);
+    }
+
+    #[tokio::test]
+    async fn test_watcher_stops_when_cancelled_while_disconnected() {
+        let source = Arc::new(MockEventSource::new(vec![]));
+        let handler = Arc::new(RecordingHandler::default());
+        let watcher = RuntimeEventWatcher::new(source, handler.clone());
+
+        let token = CancellationToken::new();
+        token.cancel();
+        watcher.run(token).await;
+
+        assert!(handler.connections.lock().unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_map_docker_event() {
+        let mut attributes = HashMap::new();
+        attributes.insert(LABEL_MANAGED.to_string(), "true".to_string());
+
+        let message = bollard::models::EventMessage {
+            action: Some("die".to_string()),
+            actor: Some(bollard::models::EventActor {
+                id: Some("abc".to_string()),
+                attributes: Some(attributes.clone()),
+                ..Default::default()
+            }),
+            ..Default::default()
+        };
+
+        assert_eq!(
+            DockerEventSource::map_event(message),
+            Some(RuntimeEvent::ContainerDied {
+                container_id: "abc".to_string(),
+                labels: attributes,
+            })
+        );
+
+        let ignored = bollard::models::EventMessage {
+            action: Some("attach".to_string()),
+            actor: Some(bollard::models::EventActor {
+                id: Some("abc".to_string()),
+                ..Default::default()
+            }),
+            ..Default::default()
+        };
+        assert_eq!(DockerEventSource::map_event(ignored), None);
+    }
+}
@@ -0,0 +1,179 @@
+//! Container labels used to identify resources owned by Temps
+//!
+//! Every container created for a deployment carries a set of `sh.temps.*` labels so that
+//! the orchestrator can rediscover (adopt) it after a Docker daemon restart or a Temps
+//! process restart, without relying on container names alone. Deploy metadata (commit,
+//! branch, environment, trigger and image digest) is recorded alongside for external
+//! tooling and dashboards.
+
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+
+/// Marks a resource as managed by Temps (value is always `"true"`)
+pub const LABEL_MANAGED: &str = "sh.temps.managed";
+/// Project that owns the container
+pub const LABEL_PROJECT_ID: &str = "sh.temps.project-id";
+/// Environment that owns the container
+pub const LABEL_ENVIRONMENT_ID: &str = "sh.temps.environment-id";
+/// Deployment that created the container
+pub const LABEL_DEPLOYMENT_ID: &str = "sh.temps.deployment-id";
+/// Commit SHA the deployed image was built from
+pub const LABEL_COMMIT_SHA: &str = "sh.temps.commit-sha";
+/// Branch the deployed commit was taken from
+pub const LABEL_BRANCH: &str = "sh.temps.branch";
+/// Name of the environment that owns the container
+pub const LABEL_ENVIRONMENT: &str = "sh.temps.environment";
+/// What triggered the deployment (e.g. `git_push`, `promotion`)
+pub const LABEL_TRIGGERED_BY: &str = "sh.temps.triggered-by";
+/// ID (`sha256:…`) of the image the container runs
+pub const LABEL_IMAGE_DIGEST: &str = "sh.temps.image-digest";
+
+/// Procfile process type the container runs (`web` gets the traffic)
+pub const LABEL_PROCESS_TYPE: &str = "sh.temps.process-type";
+
+/// Prefix of all labels set by Temps
+pub const LABEL_PREFIX: &str = "sh.temps.";
+
+/// Deploy metadata recorded as labels on a deployment's containers
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct DeployMetadata {
+    pub commit_sha: Option<String>,
+    pub branch: Option<String>,
+    pub environment: Option<String>,
+    pub triggered_by: Option<String>,
+    pub image_digest: Option<String>,
+}
+
+impl DeployMetadata {
+    fn fields(&self) -> [(&'static str, &Option<String>); 5] {
+        [
+            (LABEL_COMMIT_SHA, &self.commit_sha),
+            (LABEL_BRANCH, &self.branch),
+            (LABEL_ENVIRONMENT, &self.environment),
+            (LABEL_TRIGGERED_BY, &self.triggered_by),
+            (LABEL_IMAGE_DIGEST, &self.image_digest),
+        ]
+    }
+
+    /// Add the metadata that is known to a label set
+    pub fn apply(&self, labels: &mut HashMap<String, String>) {
+        for (label, value) in self.fields() {
+            if let Some(value) = value.as_deref().filter(|v| !v.is_empty()) {
+                labels.insert(label.to_string(), value.to_string());
+            }
+        }
+    }
+
+    /// Read the metadata back from a container's labels
+    pub fn from_labels(labels: &HashMap<String, String>) -> Self {
+        let get = |label: &str| labels.get(label).cloned();
+        Self {
+            commit_sha: get(LABEL_COMMIT_SHA),
+            branch: get(LABEL_BRANCH),
+            environment: get(LABEL_ENVIRONMENT),
+            triggered_by: get(LABEL_TRIGGERED_BY),
+            image_digest: get(LABEL_IMAGE_DIGEST),
+        }
+    }
+}
+
+/// The `sh.temps.*` labels of a label set
+pub fn temps_labels(labels: &HashMap<String, String>) -> HashMap<String, String> {
+    labels
+        .iter()
+        .filter(|(key, _)| key.starts_with(LABEL_PREFIX))
+        .map(|(key, value)| (key.clone(), value.clone()))
+        .collect()
+}
+
+/// Build the standard label set for a deployment container
+pub fn deployment_labels(
+    project_id: i32,
+    environment_id: i32,
+    deployment_id: i32,
+) -> HashMap<String, String> {
+    let mut labels = HashMap::new();
+    labels.insert(LABEL_MANAGED.to_string(), "true".to_string());
+    labels.insert(LABEL_PROJECT_ID.to_string(), project_id.to_string());
+    labels.insert(LABEL_ENVIRONMENT_ID.to_string(), environment_id.to_string());
+    labels.insert(LABEL_DEPLOYMENT_ID.to_string(), deployment_id.to_string());
+    labels
+}
+
+/// Label set applied to images built by Temps
+pub fn build_labels() -> HashMap<String, String> {
+    let mut labels = HashMap::new();
+    labels.insert("built-by".to_string(), "temps".to_string());
+    labels.insert(LABEL_MANAGED.to_string(), "true".to_string());
+    labels
+}
+
+/// Extract the deployment ID from a container's labels, if present and valid
+pub fn deployment_id_from_labels(labels: &HashMap<String, String>) -> Option<i32> {
+    labels
+        .get(LABEL_DEPLOYMENT_ID)
+        .and_then(|value| value.parse::<i32>().ok())
+}
+
+/// Returns true if the labels mark the resource as managed by Temps
+pub fn is_managed(labels: &HashMap<String, String>) -> bool {
+    labels
+        .get(LABEL_MANAGED)
+        .map(|v| v == "true")
+        .unwrap_or(false)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_deployment_labels_round_trip() {
+        let labels = deployment_labels(1, 2, 42);
+
+        assert!(is_managed(&labels));
+        assert_eq!(deployment_id_from_labels(&labels), Some(42));
+        assert_eq!(labels.get(LABEL_PROJECT_ID).map(String::as_str), Some("1"));
+        assert_eq!(
+            labels.get(LABEL_ENVIRONMENT_ID).map(String::as_str),
+            Some("2")
+        );
+    }
+
+    #[test]
+    fn test_deploy_metadata_labels_round_trip() {
+        let metadata = DeployMetadata {
+            commit_sha: Some("4b825dc".to_string()),
+            branch: Some("main".to_string()),
+            environment: Some("production".to_string()),
+            triggered_by: Some("git_push".to_string()),
+            image_digest: None,
+        };
+        let mut labels = deployment_labels(1, 2, 42);
+        metadata.apply(&mut labels);
+        labels.insert(
+            "org.opencontainers.image.title".to_string(),
+            "app".to_string(),
+        );
+
+        assert_eq!(
+            labels.get(LABEL_COMMIT_SHA).map(String::as_str),
+            Some("4b825dc")
+        );
+        assert!(!labels.contains_key(LABEL_IMAGE_DIGEST));
+        assert_eq!(DeployMetadata::from_labels(&labels), metadata);
+
+        let temps = temps_labels(&labels);
+        assert_eq!(temps.len(), 8);
+        assert!(!temps.contains_key("org.opencontainers.image.title"));
+    }
+
+    #[test]
+    fn test_unmanaged_labels() {
+        let mut labels = HashMap::new();
+        labels.insert(LABEL_DEPLOYMENT_ID.to_string(), "not-a-number".to_string());
+
+        assert!(!is_managed(&labels));
+        assert_eq!(deployment_id_from_labels(&labels), None);
+    }
+}
@@ -1,7 +1,7 @@
 //! Temps Deployer - Abstract container building and deployment
 //!
 //! This crate provides a unified interface for:
-//! - Building OCI images from Dockerfiles
+//! - Building OCI images from Dockerfiles or with Cloud Native Buildpacks
 //! - Deploying containers to various runtimes
 //! - Managing container lifecycle (start, stop, pause, etc.)
 //! - Extracting files from images
@@ -14,11 +14,20 @@ use std::pin::Pin;
 use temps_core::UtcDateTime;
 use thiserror::Error;
 
+pub use temps_core::ImagePullPolicy;
+
 /// Callback function type for processing build logs in real-time
 pub type LogCallback =
     std::sync::Arc<dyn Fn(String) -> Pin<Box<dyn Future<Output = ()> + Send>> + Send + Sync>;
 
+pub mod base_images;
+pub mod build_context;
+pub mod build_nodes;
+pub mod build_phases;
+pub mod buildpacks;
 pub mod docker;
+pub mod events;
+pub mod labels;
 pub mod plugin;
 pub mod static_deployer;
 
@@ -74,8 +83,17 @@ pub struct BuildRequest {
     pub dockerfile_path: Option<PathBuf>,
     pub build_args: HashMap<String, String>,
     pub build_args_buildkit: HashMap<String, String>,
+    /// Platform to build for, or several comma-separated ones for a multi-platform
+    /// image; the host's platform when unset
     pub platform: Option<String>,
     pub log_path: PathBuf,
+    /// Whether base images are pulled even when a local copy exists
+    #[serde(default)]
+    pub pull_policy: ImagePullPolicy,
+    /// Whether the context's `.gitignore` applies on top of its `.dockerignore`, for
+    /// sources that don't come from a git checkout
+    #[serde(default)]
+    pub honor_gitignore: bool,
 }
 
 /// Build request with optional log callback for real-time log streaming
@@ -90,6 +108,9 @@ pub struct BuildResult {
     pub image_name: String,
     pub size_bytes: u64,
     pub build_duration_ms: u64,
+    /// Where the build spent its time; None when the builder doesn't report its steps
+    #[serde(default)]
+    pub phases: Option<build_phases::BuildPhaseTimings>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -103,6 +124,94 @@ pub struct DeployRequest {
     pub restart_policy: RestartPolicy,
     pub log_path: PathBuf,
     pub command: Option<Vec<String>>,
+    /// Entrypoint of the container in place of the image's (`--entrypoint`); the
+    /// command is passed to it as arguments
+    #[serde(default)]
+    pub entrypoint: Option<Vec<String>>,
+    /// Labels applied to the container (see [`labels`] for the `sh.temps.*` set)
+    #[serde(default)]
+    pub labels: HashMap<String, String>,
+    /// Log driver of the container; the daemon's default when unset
+    #[serde(default)]
+    pub log_config: Option<ContainerLogConfig>,
+    /// Host paths mounted into the container
+    #[serde(default)]
+    pub bind_mounts: Vec<BindMount>,
+    /// Whether the image is pulled even when a local copy exists
+    #[serde(default)]
+    pub pull_policy: ImagePullPolicy,
+    /// Nameservers, search domains and `/etc/hosts` entries of the container
+    #[serde(default)]
+    pub dns: ContainerDns,
+    /// Signal that stops the container (`--stop-signal`); the image's, or `SIGTERM`,
+    /// when unset
+    #[serde(default)]
+    pub stop_signal: Option<String>,
+    /// Seconds the container gets to exit after the stop signal before it is killed
+    /// (`--stop-timeout`); Docker's default when unset
+    #[serde(default)]
+    pub stop_timeout_seconds: Option<u32>,
+    /// Ulimits of the container's processes (`--ulimit`); Docker's defaults when empty
+    #[serde(default)]
+    pub ulimits: Vec<Ulimit>,
+    /// Size of the container's `/dev/shm` in bytes (`--shm-size`); 64 MB when unset
+    #[serde(default)]
+    pub shm_size_bytes: Option<u64>,
+}
+
+/// Resource limit of a container's processes; -1 is unlimited
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Ulimit {
+    pub name: String,
+    pub soft: i64,
+    pub hard: i64,
+}
+
+/// Name resolution of a container (`--dns`, `--dns-search`, `--add-host`)
+///
+/// Empty lists keep Docker's defaults: on a user-defined network the embedded
+/// resolver, which forwards to the host's nameservers.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ContainerDns {
+    #[serde(default)]
+    pub nameservers: Vec<String>,
+    #[serde(default)]
+    pub search_domains: Vec<String>,
+    /// `hostname:ip` entries
+    #[serde(default)]
+    pub extra_hosts: Vec<String>,
+}
+
+/// Host path mounted into a container
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct BindMount {
+    pub host_path: PathBuf,
+    pub container_path: PathBuf,
+    pub read_only: bool,
+}
+
+impl BindMount {
+    /// Docker's `host:container[:ro]` bind specification
+    pub fn to_bind_spec(&self) -> String {
+        let spec = format!(
+            "{}:{}",
+            self.host_path.display(),
+            self.container_path.display()
+        );
+        if self.read_only {
+            format!("{}:ro", spec)
+        } else {
+            spec
+        }
+    }
+}
+
+/// Docker log driver and its options (e.g. `max-size`, `max-file` for json-file)
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ContainerLogConfig {
+    pub driver: String,
+    #[serde(default)]
+    pub options: HashMap<String, String>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -161,6 +270,18 @@ pub struct ContainerInfo {
     pub created_at: UtcDateTime,
     pub ports: Vec<PortMapping>,
     pub environment_vars: HashMap<String, String>,
+    /// Container labels, including the `sh.temps.*` deploy metadata
+    #[serde(default)]
+    pub labels: HashMap<String, String>,
+}
+
+/// A container carrying the `sh.temps.managed` label, as observed on the runtime
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ManagedContainer {
+    pub container_id: String,
+    pub container_name: String,
+    pub status: ContainerStatus,
+    pub labels: HashMap<String, String>,
 }
 
 /// Container performance statistics (CPU, memory, network)
@@ -308,6 +429,17 @@ pub trait ImageBuilder: Send + Sync {
         request: BuildRequestWithCallback,
     ) -> Result<BuildResult, BuilderError>;
 
+    /// Build an OCI image from source with Cloud Native Buildpacks, without a Dockerfile
+    async fn build_with_buildpacks(
+        &self,
+        _request: buildpacks::BuildpacksRequest,
+        _log_callback: Option<LogCallback>,
+    ) -> Result<BuildResult, BuilderError> {
+        Err(BuilderError::Other(
+            "This image builder doesn't support Cloud Native Buildpacks".to_string(),
+        ))
+    }
+
     /// Import an image from a tar archive
     async fn import_image(&self, image_path: PathBuf, tag: &str) -> Result<String, BuilderError>;
 
@@ -324,6 +456,18 @@ pub trait ImageBuilder: Send + Sync {
 
     /// Remove an image
     async fn remove_image(&self, image_name: &str) -> Result<(), BuilderError>;
+
+    /// Registry digest (`sha256:...`) of an image, pulling it first when `pull` is set
+    ///
+    /// Returns `None` for images that don't exist locally or were never pulled from a
+    /// registry (e.g. locally built images).
+    async fn image_digest(
+        &self,
+        _image_name: &str,
+        _pull: bool,
+    ) -> Result<Option<String>, BuilderError> {
+        Ok(None)
+    }
 }
 
 /// Trait for deploying and managing containers
@@ -360,6 +504,11 @@ pub trait ContainerDeployer: Send + Sync {
     /// List running containers
     async fn list_containers(&self) -> Result<Vec<ContainerInfo>, DeployerError>;
 
+    /// List all containers (running or not) labeled as managed by Temps
+    async fn list_managed_containers(&self) -> Result<Vec<ManagedContainer>, DeployerError> {
+        Ok(Vec::new())
+    }
+
     /// Get container logs
     async fn get_container_logs(&self, container_id: &str) -> Result<String, DeployerError>;
 
@@ -458,6 +607,8 @@ mod tests {
             build_args_buildkit: build_args.clone(),
             platform: Some("linux/amd64".to_string()),
             log_path,
+            pull_policy: ImagePullPolicy::default(),
+            honor_gitignore: false,
         };
 
         assert_eq!(request.image_name, "test-image:latest");
@@ -491,6 +642,16 @@ mod tests {
             restart_policy: RestartPolicy::Always,
             log_path,
             command: Some(vec!["node".to_string(), "server.js".to_string()]),
+            entrypoint: None,
+            labels: HashMap::new(),
+            log_config: None,
+            bind_mounts: Vec::new(),
+            pull_policy: ImagePullPolicy::default(),
+            dns: ContainerDns::default(),
+            stop_signal: None,
+            stop_timeout_seconds: None,
+            ulimits: Vec::new(),
+            shm_size_bytes: None,
         };
 
         assert_eq!(request.image_name, "test-image:latest");
@@ -506,6 +667,28 @@ mod tests {
         assert_eq!(request.command.as_ref().unwrap()[1], "server.js");
     }
 
+    #[test]
+    fn test_bind_mount_spec() {
+        let mount = BindMount {
+            host_path: PathBuf::from("/usr/share/zoneinfo"),
+            container_path: PathBuf::from("/usr/share/zoneinfo"),
+            read_only: true,
+        };
+        assert_eq!(
+            mount.to_bind_spec(),
+            "/usr/share/zoneinfo:/usr/share/zoneinfo:ro"
+        );
+
+        let writable = BindMount {
+            read_only: false,
+            ..mount
+        };
+        assert_eq!(
+            writable.to_bind_spec(),
+            "/usr/share/zoneinfo:/usr/share/zoneinfo"
+        );
+    }
+
     #[test]
     fn test_resource_limits_default() {
         let limits = ResourceLimits::default();
@@ -569,6 +752,7 @@ mod tests {
                 protocol: Protocol::Tcp,
             }],
             environment_vars: env_vars,
+            labels: HashMap::new(),
         };
 
         assert_eq!(info.container_id, "abc123");
@@ -587,6 +771,7 @@ mod tests {
             image_name: "test-image:latest".to_string(),
             size_bytes: 1024 * 1024 * 100, // 100MB
             build_duration_ms: 5000,
+            phases: None,
         };
 
         assert_eq!(result.image_id, "sha256:abc123");
@@ -667,6 +852,8 @@ mod tests {
             build_args_buildkit: HashMap::new(),
             platform: None,
             log_path: PathBuf::from("/tmp/build.log"),
+            pull_policy: ImagePullPolicy::default(),
+            honor_gitignore: false,
         };
 
         // Test serialization
@@ -738,6 +925,8 @@ CMD ["echo", "Hello from container"]
             build_args_buildkit: build_args.clone(),
             platform: Some("linux/amd64".to_string()),
             log_path: temp_dir.path().join("build.log"),
+            pull_policy: ImagePullPolicy::default(),
+            honor_gitignore: false,
         };
 
         assert!(request.dockerfile_path.as_ref().unwrap().exists());
@@ -792,6 +981,16 @@ CMD ["echo", "Hello from container"]
             restart_policy: RestartPolicy::Always,
             log_path: temp_dir.path().join("deploy.log"),
             command: None, // No custom command, use default from image
+            entrypoint: None,
+            labels: HashMap::new(),
+            log_config: None,
+            bind_mounts: Vec::new(),
+            pull_policy: ImagePullPolicy::default(),
+            dns: ContainerDns::default(),
+            stop_signal: None,
+            stop_timeout_seconds: None,
+            ulimits: Vec::new(),
+            shm_size_bytes: None,
         };
 
         assert_eq!(request.environment_vars.len(), 3);
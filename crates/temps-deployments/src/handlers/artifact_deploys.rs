@@ -0,0 +1,141 @@
+//! Artifact Deployment Handlers
+//!
+//! API endpoint for CI pipelines to deploy a build artifact published at a URL.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    routing::post,
+    Json, Router,
+};
+use serde::{Deserialize, Serialize};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use temps_entities::deployments::RemoteArtifactKind;
+use tracing::info;
+use utoipa::{OpenApi, ToSchema};
+
+use crate::services::{ArtifactDeployRequest, ArtifactDeployService};
+
+/// App state for artifact deployment handlers
+pub struct ArtifactDeployAppState {
+    pub artifact_deploy_service: Arc<ArtifactDeployService>,
+}
+
+/// Request to deploy a build artifact
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct DeployArtifactRequest {
+    /// URL the artifact is downloaded from
+    #[schema(example = "https://ci.example.com/builds/812/app.tar.gz")]
+    pub url: String,
+    /// build_context (default): a tarball of the source, built like a checkout;
+    /// image: an image archive written by `docker save`
+    #[serde(default)]
+    pub kind: RemoteArtifactKind,
+    /// SHA-256 the downloaded file is verified against
+    #[serde(default)]
+    #[schema(example = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08")]
+    pub sha256: Option<String>,
+    /// `Authorization` header sent with the download; stored encrypted
+    #[serde(default)]
+    #[schema(example = "Bearer ci-token")]
+    pub authorization: Option<String>,
+}
+
+/// A deployment of a build artifact
+#[derive(Debug, Serialize, ToSchema)]
+pub struct ArtifactDeploymentResponse {
+    pub deployment_id: i32,
+    #[schema(example = "my-app-42")]
+    pub slug: String,
+    pub environment_id: i32,
+    pub kind: RemoteArtifactKind,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(deploy_artifact),
+    components(schemas(DeployArtifactRequest, ArtifactDeploymentResponse, RemoteArtifactKind)),
+    info(
+        title = "Artifact Deployments API",
+        description = "API endpoints for deploying build artifacts published by CI \
+        pipelines at a URL.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Deployments", description = "Deployment management endpoints")
+    )
+)]
+pub struct ArtifactDeploysApiDoc;
+
+pub fn configure_routes() -> Router<Arc<ArtifactDeployAppState>> {
+    Router::new().route(
+        "/projects/{project_id}/environments/{environment_id}/artifact-deployments",
+        post(deploy_artifact),
+    )
+}
+
+/// Deploy a build artifact to an environment
+///
+/// Downloads the artifact from its URL, verifies its checksum if one is given, and
+/// deploys it: a source tarball is built like a checkout of the repository, an
+/// image archive runs as it is. The deployment runs in the background.
+#[utoipa::path(
+    tag = "Deployments",
+    post,
+    path = "/projects/{project_id}/environments/{environment_id}/artifact-deployments",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment to deploy to")
+    ),
+    request_body = DeployArtifactRequest,
+    responses(
+        (status = 202, description = "Deployment started", body = ArtifactDeploymentResponse),
+        (status = 400, description = "Invalid URL or checksum"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Project or environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn deploy_artifact(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ArtifactDeployAppState>>,
+    Path((project_id, environment_id)): Path<(i32, i32)>,
+    Json(request): Json<DeployArtifactRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsCreate);
+
+    info!(
+        "Deploying artifact {} to environment {} of project {}",
+        request.url, environment_id, project_id
+    );
+    let kind = request.kind;
+    let deployment = app_state
+        .artifact_deploy_service
+        .deploy(
+            project_id,
+            environment_id,
+            ArtifactDeployRequest {
+                url: request.url,
+                kind,
+                sha256: request.sha256,
+                authorization: request.authorization,
+            },
+            auth.user_id_opt(),
+        )
+        .await?;
+    Ok((
+        StatusCode::ACCEPTED,
+        Json(ArtifactDeploymentResponse {
+            deployment_id: deployment.id,
+            slug: deployment.slug,
+            environment_id: deployment.environment_id,
+            kind,
+        }),
+    ))
+}
@@ -0,0 +1,75 @@
+use anyhow::Result;
+use serde::Serialize;
+use temps_core::{AuditContext, AuditOperation};
+
+use crate::services::BulkAction;
+
+#[derive(Debug, Clone, Serialize)]
+pub struct BulkOperationStartedAudit {
+    pub context: AuditContext,
+    pub project_id: i32,
+    pub run_id: String,
+    pub action: BulkAction,
+    pub environment_ids: Vec<i32>,
+    pub service_ids: Vec<i32>,
+    pub concurrency: usize,
+}
+
+impl AuditOperation for BulkOperationStartedAudit {
+    fn operation_type(&self) -> String {
+        "PROJECT_BULK_OPERATION_STARTED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct RuntimeConfigChangedAudit {
+    pub context: AuditContext,
+    pub project_id: i32,
+    pub environment_id: i32,
+    pub key: String,
+    /// Whether the value was removed; values themselves are left out of the audit log
+    pub removed: bool,
+    /// How the running containers picked the change up
+    pub method: String,
+    pub status: String,
+}
+
+impl AuditOperation for RuntimeConfigChangedAudit {
+    fn operation_type(&self) -> String {
+        "RUNTIME_CONFIG_CHANGED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
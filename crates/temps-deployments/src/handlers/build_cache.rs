@@ -0,0 +1,126 @@
+//! Build Cache Handlers
+//!
+//! API endpoints to report the size of a project's persistent build caches (Go
+//! module and build caches, npm/pnpm/yarn stores, ...) and to clear them.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, Query, State},
+    response::IntoResponse,
+    routing::get,
+    Json, Router,
+};
+use serde::Deserialize;
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use tracing::info;
+use utoipa::{OpenApi, ToSchema};
+
+use crate::services::{BuildCacheService, BuildCacheUsage, ClearedBuildCaches};
+
+/// App state for build cache handlers
+pub struct BuildCacheAppState {
+    pub build_cache_service: Arc<BuildCacheService>,
+}
+
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct ClearBuildCacheQuery {
+    /// Only clear the cache with this name (default: all of the project's caches)
+    pub name: Option<String>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(list_build_caches, clear_build_caches),
+    components(schemas(BuildCacheUsage, ClearedBuildCaches, ClearBuildCacheQuery)),
+    info(
+        title = "Build Cache API",
+        description = "API endpoints for inspecting and clearing the persistent package \
+        manager and compiler caches of a project's builds.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Projects", description = "Project management endpoints")
+    )
+)]
+pub struct BuildCacheApiDoc;
+
+pub fn configure_routes() -> Router<Arc<BuildCacheAppState>> {
+    Router::new().route(
+        "/projects/{project_id}/build-caches",
+        get(list_build_caches).delete(clear_build_caches),
+    )
+}
+
+/// List a project's build caches and their sizes
+#[utoipa::path(
+    tag = "Projects",
+    get,
+    path = "/projects/{project_id}/build-caches",
+    params(
+        ("project_id" = i32, Path, description = "Project ID")
+    ),
+    responses(
+        (status = 200, description = "Build caches", body = Vec<BuildCacheUsage>),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn list_build_caches(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BuildCacheAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsRead);
+
+    let caches = app_state
+        .build_cache_service
+        .list_caches(project_id)
+        .await?;
+    Ok(Json(caches))
+}
+
+/// Clear a project's build caches
+///
+/// The next build downloads and compiles dependencies from scratch. Caches that a
+/// running build is using are left alone.
+#[utoipa::path(
+    tag = "Projects",
+    delete,
+    path = "/projects/{project_id}/build-caches",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("name" = Option<String>, Query, description = "Only clear the cache with this name")
+    ),
+    responses(
+        (status = 200, description = "Caches cleared", body = ClearedBuildCaches),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Named cache not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn clear_build_caches(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BuildCacheAppState>>,
+    Path(project_id): Path<i32>,
+    Query(query): Query<ClearBuildCacheQuery>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    info!(
+        "Clearing build caches {:?} of project {} (user {})",
+        query.name,
+        project_id,
+        auth.user_id()
+    );
+    let result = app_state
+        .build_cache_service
+        .clear_caches(project_id, query.name.as_deref())
+        .await?;
+    Ok(Json(result))
+}
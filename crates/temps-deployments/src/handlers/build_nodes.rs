@@ -0,0 +1,60 @@
+//! Build Node Handlers
+//!
+//! API endpoint showing the configured build nodes and whether each one answers.
+
+use std::sync::Arc;
+
+use axum::{extract::State, response::IntoResponse, routing::get, Json, Router};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use utoipa::OpenApi;
+
+use crate::services::{BuildNodeScheduler, BuildNodeStatus, BuildNodesStatus};
+
+/// App state for build node handlers
+pub struct BuildNodesAppState {
+    pub build_node_scheduler: Arc<BuildNodeScheduler>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(get_build_nodes),
+    components(schemas(BuildNodesStatus, BuildNodeStatus)),
+    info(
+        title = "Build Nodes API",
+        description = "API endpoints for the dedicated nodes images are built on.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "System", description = "System maintenance operations")
+    )
+)]
+pub struct BuildNodesApiDoc;
+
+pub fn configure_routes() -> Router<Arc<BuildNodesAppState>> {
+    Router::new().route("/system/build-nodes", get(get_build_nodes))
+}
+
+/// Get the build nodes and their reachability
+///
+/// Pings every enabled node, so the response shows which nodes can take builds
+/// right now.
+#[utoipa::path(
+    tag = "System",
+    get,
+    path = "/system/build-nodes",
+    responses(
+        (status = 200, description = "Build nodes", body = BuildNodesStatus),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_build_nodes(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BuildNodesAppState>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemRead);
+
+    Ok(Json(app_state.build_node_scheduler.status().await))
+}
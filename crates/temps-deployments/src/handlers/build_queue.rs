@@ -0,0 +1,62 @@
+//! Build Queue Handlers
+//!
+//! API endpoint showing the deployment pipelines that are running and the ones
+//! waiting for a build slot, in the order they will start.
+
+use std::sync::Arc;
+
+use axum::{extract::State, response::IntoResponse, routing::get, Json, Router};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use temps_entities::deployment_config::BuildPriority;
+use utoipa::OpenApi;
+
+use crate::services::{BuildQueue, BuildQueueStatus, QueuedBuild};
+
+/// App state for build queue handlers
+pub struct BuildQueueAppState {
+    pub build_queue: Arc<BuildQueue>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(get_build_queue),
+    components(schemas(BuildQueueStatus, QueuedBuild, BuildPriority)),
+    info(
+        title = "Build Queue API",
+        description = "API endpoints for the global queue of deployment pipelines.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "System", description = "System maintenance operations")
+    )
+)]
+pub struct BuildQueueApiDoc;
+
+pub fn configure_routes() -> Router<Arc<BuildQueueAppState>> {
+    Router::new().route("/system/build-queue", get(get_build_queue))
+}
+
+/// Get the running and waiting deployment pipelines
+///
+/// Waiting deployments are listed in the order they will get a slot: by effective
+/// priority, then by arrival.
+#[utoipa::path(
+    tag = "System",
+    get,
+    path = "/system/build-queue",
+    responses(
+        (status = 200, description = "Build queue status", body = BuildQueueStatus),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_build_queue(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<BuildQueueAppState>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemRead);
+
+    Ok(Json(app_state.build_queue.status().await))
+}
@@ -0,0 +1,197 @@
+//! Changelog Handlers
+//!
+//! API endpoints for a project's changelog of deploys, as JSON or RSS, and for
+//! editing the release note of a deployment.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, Query, State},
+    http::{header, StatusCode},
+    response::IntoResponse,
+    routing::{get, put},
+    Json, Router,
+};
+use serde::Deserialize;
+use temps_auth::{permission_guard, RequireAuth};
+use temps_config::ConfigService;
+use temps_core::problemdetails::Problem;
+use utoipa::{IntoParams, OpenApi, ToSchema};
+
+use crate::services::{render_changelog_rss, ChangelogEntry, ChangelogOutcome, ChangelogService};
+
+/// App state for changelog handlers
+pub struct ChangelogAppState {
+    pub changelog_service: Arc<ChangelogService>,
+    pub config_service: Arc<ConfigService>,
+}
+
+#[derive(Debug, Deserialize, IntoParams)]
+pub struct ChangelogQuery {
+    /// Only list deploys of this environment
+    pub environment_id: Option<i32>,
+    /// Number of entries (default 50, at most 200)
+    pub limit: Option<u64>,
+}
+
+/// Request to set the release note of a deployment
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct UpdateReleaseNoteRequest {
+    /// New release note; empty or null clears it, so the commit message is shown
+    #[schema(example = "Faster checkout and a fix for duplicate emails")]
+    pub release_note: Option<String>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(get_changelog, get_changelog_rss, update_release_note),
+    components(schemas(ChangelogEntry, ChangelogOutcome, UpdateReleaseNoteRequest)),
+    info(
+        title = "Changelog API",
+        description = "API endpoints for a project's release history: its deploys with \
+        their release notes, who started them and how they ended.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Deployments", description = "Deployment management endpoints")
+    )
+)]
+pub struct ChangelogApiDoc;
+
+pub fn configure_routes() -> Router<Arc<ChangelogAppState>> {
+    Router::new()
+        .route("/projects/{project_id}/changelog", get(get_changelog))
+        .route(
+            "/projects/{project_id}/changelog/rss",
+            get(get_changelog_rss),
+        )
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/release-note",
+            put(update_release_note),
+        )
+}
+
+/// List a project's changelog
+///
+/// Finished deploys, newest first, with their release note — or the first line of
+/// the commit message when none was given — who started them and their outcome.
+#[utoipa::path(
+    tag = "Deployments",
+    get,
+    path = "/projects/{project_id}/changelog",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ChangelogQuery
+    ),
+    responses(
+        (status = 200, description = "Changelog entries", body = Vec<ChangelogEntry>),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_changelog(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ChangelogAppState>>,
+    Path(project_id): Path<i32>,
+    Query(query): Query<ChangelogQuery>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let entries = app_state
+        .changelog_service
+        .list_changelog(project_id, query.environment_id, query.limit)
+        .await?;
+    Ok(Json(entries))
+}
+
+/// Get a project's changelog as an RSS feed
+#[utoipa::path(
+    tag = "Deployments",
+    get,
+    path = "/projects/{project_id}/changelog/rss",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ChangelogQuery
+    ),
+    responses(
+        (status = 200, description = "RSS 2.0 feed", content_type = "application/rss+xml"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Project not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_changelog_rss(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ChangelogAppState>>,
+    Path(project_id): Path<i32>,
+    Query(query): Query<ChangelogQuery>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let project = app_state.changelog_service.get_project(project_id).await?;
+    let entries = app_state
+        .changelog_service
+        .list_changelog(project_id, query.environment_id, query.limit)
+        .await?;
+    let console_url = app_state
+        .config_service
+        .get_external_url_or_default()
+        .await
+        .map_err(|e| {
+            temps_core::error_builder::internal_server_error()
+                .detail(e.to_string())
+                .build()
+        })?;
+
+    Ok((
+        StatusCode::OK,
+        [(header::CONTENT_TYPE, "application/rss+xml; charset=utf-8")],
+        render_changelog_rss(&project, &entries, &console_url),
+    ))
+}
+
+/// Set or clear the release note of a deployment
+///
+/// Returns the deployment's changelog entry, or no content while the deployment is
+/// still in progress.
+#[utoipa::path(
+    tag = "Deployments",
+    put,
+    path = "/projects/{project_id}/deployments/{deployment_id}/release-note",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID")
+    ),
+    request_body = UpdateReleaseNoteRequest,
+    responses(
+        (status = 200, description = "Updated changelog entry", body = ChangelogEntry),
+        (status = 204, description = "Release note saved; the deployment is still in progress"),
+        (status = 400, description = "Release note too long"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Deployment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn update_release_note(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ChangelogAppState>>,
+    Path((project_id, deployment_id)): Path<(i32, i32)>,
+    Json(request): Json<UpdateReleaseNoteRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsWrite);
+
+    let deployment = app_state
+        .changelog_service
+        .set_release_note(project_id, deployment_id, request.release_note)
+        .await?;
+    match app_state.changelog_service.entry(deployment).await? {
+        Some(entry) => Ok(Json(entry).into_response()),
+        None => Ok(StatusCode::NO_CONTENT.into_response()),
+    }
+}
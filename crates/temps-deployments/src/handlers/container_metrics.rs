@@ -0,0 +1,103 @@
+//! Container Metrics History Handlers
+//!
+//! API endpoint for the recorded CPU, memory and network usage of an environment,
+//! for charts over ranges from an hour to months.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, Query, State},
+    response::IntoResponse,
+    routing::get,
+    Json, Router,
+};
+use serde::Deserialize;
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use temps_core::UtcDateTime;
+use utoipa::{IntoParams, OpenApi};
+
+use crate::services::{
+    ContainerMetricsService, EnvironmentMetricsHistory, EnvironmentMetricsPoint, MetricsSource,
+};
+
+/// App state for container metrics handlers
+pub struct ContainerMetricsAppState {
+    pub container_metrics_service: Arc<ContainerMetricsService>,
+}
+
+#[derive(Debug, Deserialize, IntoParams)]
+pub struct MetricsHistoryQuery {
+    /// Start time (ISO 8601 format, default: an hour before the end time)
+    #[param(value_type = Option<String>, example = "2025-10-23T00:00:00Z")]
+    pub start_time: Option<UtcDateTime>,
+    /// End time (ISO 8601 format, default: now)
+    #[param(value_type = Option<String>, example = "2025-10-23T23:59:59Z")]
+    pub end_time: Option<UtcDateTime>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(get_environment_metrics_history),
+    components(schemas(EnvironmentMetricsHistory, EnvironmentMetricsPoint, MetricsSource)),
+    info(
+        title = "Container Metrics API",
+        description = "API endpoints for the resource usage history of environments.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Containers", description = "Container management endpoints")
+    )
+)]
+pub struct ContainerMetricsApiDoc;
+
+pub fn configure_routes() -> Router<Arc<ContainerMetricsAppState>> {
+    Router::new().route(
+        "/projects/{project_id}/environments/{environment_id}/metrics/history",
+        get(get_environment_metrics_history),
+    )
+}
+
+/// Get the resource usage history of an environment
+///
+/// CPU, memory and network usage summed over the environment's containers, in
+/// buckets sized so the range has at most 500 points. Ranges older than the raw
+/// retention are served from hourly aggregates.
+#[utoipa::path(
+    tag = "Containers",
+    get,
+    path = "/projects/{project_id}/environments/{environment_id}/metrics/history",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment ID"),
+        MetricsHistoryQuery
+    ),
+    responses(
+        (status = 200, description = "Resource usage history", body = EnvironmentMetricsHistory),
+        (status = 400, description = "Invalid time range"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_environment_metrics_history(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ContainerMetricsAppState>>,
+    Path((project_id, environment_id)): Path<(i32, i32)>,
+    Query(query): Query<MetricsHistoryQuery>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsRead);
+
+    let end_time = query.end_time.unwrap_or_else(chrono::Utc::now);
+    let start_time = query
+        .start_time
+        .unwrap_or_else(|| end_time - chrono::Duration::hours(1));
+
+    let history = app_state
+        .container_metrics_service
+        .get_history(project_id, environment_id, start_time, end_time)
+        .await?;
+    Ok(Json(history))
+}
@@ -18,6 +18,7 @@ use tracing::info;
 use utoipa::{OpenApi, ToSchema};
 
 use crate::handlers::types::AppState;
+use crate::services::DatabaseCronConfigService;
 
 #[derive(OpenApi)]
 #[openapi(
@@ -64,7 +65,13 @@ pub struct CronInfo {
     environment_id: i32,
     path: String,
     schedule: String,
+    /// IANA timezone the schedule is evaluated in, the cron's own or the default
+    #[schema(example = "Europe/Madrid")]
+    timezone: String,
+    /// Next run in UTC
     next_run: Option<String>,
+    /// Next run in the cron's timezone, with its offset
+    next_run_local: Option<String>,
     created_at: String,
     updated_at: String,
     deleted_at: Option<String>,
@@ -98,6 +105,27 @@ fn default_per_page() -> i64 {
     20
 }
 
+impl CronInfo {
+    fn new(cron: temps_entities::crons::Model, default_timezone: chrono_tz::Tz) -> Self {
+        let timezone = DatabaseCronConfigService::cron_timezone(&cron, default_timezone);
+        Self {
+            id: cron.id,
+            project_id: cron.project_id,
+            environment_id: cron.environment_id,
+            path: cron.path,
+            schedule: cron.schedule,
+            timezone: timezone.name().to_string(),
+            next_run: cron.next_run.map(|dt| dt.to_rfc3339()),
+            next_run_local: cron
+                .next_run
+                .map(|dt| dt.with_timezone(&timezone).to_rfc3339()),
+            created_at: cron.created_at.to_rfc3339(),
+            updated_at: cron.updated_at.to_rfc3339(),
+            deleted_at: cron.deleted_at.map(|dt| dt.to_rfc3339()),
+        }
+    }
+}
+
 /// Convert database errors to Problem Details
 impl From<crate::jobs::configure_crons::CronConfigError> for Problem {
     fn from(error: crate::jobs::configure_crons::CronConfigError) -> Self {
@@ -158,19 +186,10 @@ async fn get_environment_crons(
         .get_environment_crons(project_id, env_id)
         .await?;
 
+    let default_timezone = app_state.cron_service.default_timezone().await;
     let cron_infos: Vec<CronInfo> = crons
         .into_iter()
-        .map(|cron| CronInfo {
-            id: cron.id,
-            project_id: cron.project_id,
-            environment_id: cron.environment_id,
-            path: cron.path,
-            schedule: cron.schedule,
-            next_run: cron.next_run.map(|dt| dt.to_rfc3339()),
-            created_at: cron.created_at.to_rfc3339(),
-            updated_at: cron.updated_at.to_rfc3339(),
-            deleted_at: cron.deleted_at.map(|dt| dt.to_rfc3339()),
-        })
+        .map(|cron| CronInfo::new(cron, default_timezone))
         .collect();
 
     Ok(Json(cron_infos))
@@ -209,17 +228,8 @@ async fn get_cron_by_id(
         .get_cron_by_id(project_id, env_id, cron_id)
         .await?;
 
-    let cron_info = CronInfo {
-        id: cron.id,
-        project_id: cron.project_id,
-        environment_id: cron.environment_id,
-        path: cron.path,
-        schedule: cron.schedule,
-        next_run: cron.next_run.map(|dt| dt.to_rfc3339()),
-        created_at: cron.created_at.to_rfc3339(),
-        updated_at: cron.updated_at.to_rfc3339(),
-        deleted_at: cron.deleted_at.map(|dt| dt.to_rfc3339()),
-    };
+    let default_timezone = app_state.cron_service.default_timezone().await;
+    let cron_info = CronInfo::new(cron, default_timezone);
 
     Ok(Json(cron_info))
 }
@@ -0,0 +1,94 @@
+//! Deploy Gate Handlers
+//!
+//! Public callback external systems post a deploy gate's verdict to. It is
+//! authenticated by the callback token sent with the gate's request rather than by a
+//! user session or API key.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::IntoResponse,
+    routing::post,
+    Json, Router,
+};
+use temps_core::problemdetails::{self, Problem};
+use temps_entities::deployments::{DeployGateReport, DeployGateState};
+use utoipa::OpenApi;
+
+use crate::services::{DeployGateService, DeployGateVerdict};
+
+/// App state for deploy gate handlers
+pub struct DeployGateAppState {
+    pub deploy_gate_service: Arc<DeployGateService>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(report_deploy_gate_verdict),
+    components(schemas(DeployGateVerdict, DeployGateReport, DeployGateState)),
+    info(
+        title = "Deploy Gates API",
+        description = "Callback for external systems, such as CI, to approve or reject \
+        a new deployment before it receives traffic.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Deployments", description = "Deployment management endpoints")
+    )
+)]
+pub struct DeployGateApiDoc;
+
+pub fn configure_routes() -> Router<Arc<DeployGateAppState>> {
+    Router::new().route(
+        "/webhook/deployments/{deployment_id}/gate",
+        post(report_deploy_gate_verdict),
+    )
+}
+
+/// Report the verdict of a deployment's deploy gate
+///
+/// Called by the external system with the `callback_token` it received in the gate's
+/// request, as `Authorization: Bearer <callback_token>`. A failed verdict fails the
+/// deployment; the current deployment keeps serving.
+#[utoipa::path(
+    tag = "Deployments",
+    post,
+    path = "/webhook/deployments/{deployment_id}/gate",
+    params(
+        ("deployment_id" = i32, Path, description = "Deployment ID")
+    ),
+    request_body = DeployGateVerdict,
+    responses(
+        (status = 200, description = "Verdict recorded", body = DeployGateReport),
+        (status = 400, description = "Unknown status, or the gate already has a verdict"),
+        (status = 401, description = "Missing callback token"),
+        (status = 404, description = "No deploy gate of the deployment has this token"),
+        (status = 500, description = "Internal server error")
+    )
+)]
+async fn report_deploy_gate_verdict(
+    State(app_state): State<Arc<DeployGateAppState>>,
+    Path(deployment_id): Path<i32>,
+    headers: HeaderMap,
+    Json(verdict): Json<DeployGateVerdict>,
+) -> Result<impl IntoResponse, Problem> {
+    let token = headers
+        .get("authorization")
+        .and_then(|value| value.to_str().ok())
+        .and_then(|value| value.strip_prefix("Bearer "))
+        .map(str::trim)
+        .filter(|token| !token.is_empty())
+        .ok_or_else(|| {
+            problemdetails::new(StatusCode::UNAUTHORIZED)
+                .with_title("Missing Callback Token")
+                .with_detail("Send the gate's callback_token as 'Authorization: Bearer <token>'")
+        })?;
+
+    let report = app_state
+        .deploy_gate_service
+        .record_verdict(deployment_id, token, verdict)
+        .await?;
+    Ok(Json(report))
+}
@@ -0,0 +1,177 @@
+//! Deployment Artifact Handlers
+//!
+//! API endpoints to list and download the build artifacts retained for a deployment.
+
+use std::sync::Arc;
+
+use axum::{
+    body::Body,
+    extract::{Path, State},
+    http::{header, StatusCode},
+    response::IntoResponse,
+    routing::get,
+    Json, Router,
+};
+use serde::Serialize;
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use temps_core::UtcDateTime;
+use temps_entities::deployment_artifacts;
+use utoipa::{OpenApi, ToSchema};
+
+use crate::services::DeploymentArtifactService;
+
+/// App state for deployment artifact handlers
+pub struct DeploymentArtifactAppState {
+    pub artifact_service: Arc<DeploymentArtifactService>,
+}
+
+/// A build artifact retained for a deployment
+#[derive(Debug, Serialize, ToSchema)]
+pub struct DeploymentArtifactResponse {
+    pub id: i32,
+    pub deployment_id: i32,
+    pub environment_id: i32,
+    /// What the artifact is, e.g. "static_bundle"
+    #[schema(example = "static_bundle")]
+    pub kind: String,
+    #[schema(example = "deployment-42-static.tar.gz")]
+    pub file_name: String,
+    #[schema(example = "application/gzip")]
+    pub content_type: String,
+    pub size_bytes: i64,
+    /// Hex-encoded SHA-256 of the file
+    pub sha256: String,
+    /// When the artifact is deleted by the cleanup scheduler
+    #[schema(value_type = Option<String>, format = "date-time", example = "2024-12-31T02:00:00Z")]
+    pub expires_at: Option<UtcDateTime>,
+    #[schema(value_type = String, format = "date-time", example = "2024-12-01T12:00:00Z")]
+    pub created_at: UtcDateTime,
+}
+
+impl From<deployment_artifacts::Model> for DeploymentArtifactResponse {
+    fn from(model: deployment_artifacts::Model) -> Self {
+        Self {
+            id: model.id,
+            deployment_id: model.deployment_id,
+            environment_id: model.environment_id,
+            kind: model.kind,
+            file_name: model.file_name,
+            content_type: model.content_type,
+            size_bytes: model.size_bytes,
+            sha256: model.sha256,
+            expires_at: model.expires_at,
+            created_at: model.created_at,
+        }
+    }
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(list_deployment_artifacts, download_deployment_artifact),
+    components(schemas(DeploymentArtifactResponse)),
+    info(
+        title = "Deployment Artifacts API",
+        description = "API endpoints for downloading the build artifacts retained for deployments.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Deployments", description = "Deployment management endpoints")
+    )
+)]
+pub struct DeploymentArtifactsApiDoc;
+
+pub fn configure_routes() -> Router<Arc<DeploymentArtifactAppState>> {
+    Router::new()
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/artifacts",
+            get(list_deployment_artifacts),
+        )
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/artifacts/{artifact_id}/download",
+            get(download_deployment_artifact),
+        )
+}
+
+/// List the build artifacts retained for a deployment
+///
+/// Artifacts are only kept for environments with `artifact_retention_days` set.
+#[utoipa::path(
+    tag = "Deployments",
+    get,
+    path = "/projects/{project_id}/deployments/{deployment_id}/artifacts",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID")
+    ),
+    responses(
+        (status = 200, description = "Retained artifacts", body = Vec<DeploymentArtifactResponse>),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn list_deployment_artifacts(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<DeploymentArtifactAppState>>,
+    Path((project_id, deployment_id)): Path<(i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let artifacts = app_state
+        .artifact_service
+        .list_artifacts(project_id, deployment_id)
+        .await?;
+    Ok(Json(
+        artifacts
+            .into_iter()
+            .map(DeploymentArtifactResponse::from)
+            .collect::<Vec<_>>(),
+    ))
+}
+
+/// Download a build artifact retained for a deployment
+#[utoipa::path(
+    tag = "Deployments",
+    get,
+    path = "/projects/{project_id}/deployments/{deployment_id}/artifacts/{artifact_id}/download",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID"),
+        ("artifact_id" = i32, Path, description = "Artifact ID")
+    ),
+    responses(
+        (status = 200, description = "Artifact file", content_type = "application/octet-stream"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Artifact not found or expired"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn download_deployment_artifact(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<DeploymentArtifactAppState>>,
+    Path((project_id, deployment_id, artifact_id)): Path<(i32, i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let (artifact, stream) = app_state
+        .artifact_service
+        .download_artifact(project_id, deployment_id, artifact_id)
+        .await?;
+
+    Ok((
+        StatusCode::OK,
+        [
+            (header::CONTENT_TYPE, artifact.content_type),
+            (header::CONTENT_LENGTH, artifact.size_bytes.to_string()),
+            (
+                header::CONTENT_DISPOSITION,
+                format!("attachment; filename=\"{}\"", artifact.file_name),
+            ),
+        ],
+        Body::from_stream(stream),
+    ))
+}
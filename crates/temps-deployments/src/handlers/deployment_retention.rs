@@ -0,0 +1,157 @@
+//! Deployment Retention Handlers
+//!
+//! API endpoints to preview (dry run) and manually trigger pruning of deployment
+//! history beyond the configured retention, and to check on the nightly cleanup.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Query, State},
+    response::IntoResponse,
+    routing::{get, post},
+    Json, Router,
+};
+use serde::{Deserialize, Serialize};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use tracing::info;
+use utoipa::{OpenApi, ToSchema};
+
+use crate::services::{
+    CleanupRunReport, DeploymentRetentionService, DockerCleanupService, PruneReason,
+    PrunedDeployment, RetentionReport,
+};
+
+/// App state for deployment retention handlers
+pub struct DeploymentRetentionAppState {
+    pub retention_service: Arc<DeploymentRetentionService>,
+    pub cleanup_service: Arc<DockerCleanupService>,
+}
+
+/// State of the nightly cleanup
+#[derive(Debug, Serialize, ToSchema)]
+pub struct CleanupStatusResponse {
+    /// Whether a cleanup is running right now
+    pub running: bool,
+    /// Last completed run since the server started
+    pub last_run: Option<CleanupRunReport>,
+}
+
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct RunRetentionQuery {
+    /// Only report what would be pruned (default: false)
+    #[serde(default)]
+    pub dry_run: bool,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(get_retention_report, run_retention, get_cleanup_status),
+    components(schemas(
+        RetentionReport,
+        PrunedDeployment,
+        PruneReason,
+        RunRetentionQuery,
+        CleanupStatusResponse,
+        CleanupRunReport
+    )),
+    info(
+        title = "Deployment Retention API",
+        description = "API endpoints for pruning old deployment records, their logs, \
+        artifacts and images beyond the configured retention.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "System", description = "System maintenance operations")
+    )
+)]
+pub struct DeploymentRetentionApiDoc;
+
+pub fn configure_routes() -> Router<Arc<DeploymentRetentionAppState>> {
+    Router::new()
+        .route("/system/deployment-retention", get(get_retention_report))
+        .route("/system/deployment-retention/run", post(run_retention))
+        .route("/system/cleanup", get(get_cleanup_status))
+}
+
+/// Preview the deployments that retention would prune (dry run)
+#[utoipa::path(
+    tag = "System",
+    get,
+    path = "/system/deployment-retention",
+    responses(
+        (status = 200, description = "Dry-run retention report", body = RetentionReport),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_retention_report(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<DeploymentRetentionAppState>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    let report = app_state.retention_service.run(true).await?;
+    Ok(Json(report))
+}
+
+/// Manually prune deployments beyond the configured retention
+///
+/// Applies the retention limits even when nightly pruning is disabled.
+#[utoipa::path(
+    tag = "System",
+    post,
+    path = "/system/deployment-retention/run",
+    params(
+        ("dry_run" = Option<bool>, Query, description = "Only report what would be pruned (default: false)")
+    ),
+    responses(
+        (status = 200, description = "Retention report", body = RetentionReport),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn run_retention(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<DeploymentRetentionAppState>>,
+    Query(query): Query<RunRetentionQuery>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    info!(
+        "Manual deployment retention triggered by user {} (dry_run: {})",
+        auth.user_id(),
+        query.dry_run
+    );
+
+    let report = app_state.retention_service.run(query.dry_run).await?;
+    Ok(Json(report))
+}
+
+/// Status of the nightly cleanup, with the duration and reclaimed space of its last run
+#[utoipa::path(
+    tag = "System",
+    get,
+    path = "/system/cleanup",
+    responses(
+        (status = 200, description = "Cleanup status", body = CleanupStatusResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_cleanup_status(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<DeploymentRetentionAppState>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    Ok(Json(CleanupStatusResponse {
+        running: app_state.cleanup_service.is_running(),
+        last_run: app_state.cleanup_service.last_run().await,
+    }))
+}
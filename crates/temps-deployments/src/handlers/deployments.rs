@@ -4,8 +4,8 @@ use super::types::AppState;
 use axum::Router;
 use axum::{
     extract::{
-        ws::{Message, WebSocket, WebSocketUpgrade},
-        Path, Query, State,
+        ws::{close_code, CloseFrame, Message, WebSocket, WebSocketUpgrade},
+        Extension, Path, Query, State,
     },
     http::StatusCode,
     response::IntoResponse,
@@ -15,19 +15,27 @@ use axum::{
 use futures::stream::{self, StreamExt};
 use futures::SinkExt;
 use temps_auth::permission_guard;
-use temps_auth::RequireAuth;
+use temps_auth::{require_log_scope, AuthContext, RequireAuth};
 use tracing::{debug, error, info, warn};
 use utoipa::OpenApi;
 
 use crate::handlers::types::{
     ActivityDay, ActivityGraphQuery, ActivityGraphResponse, ContainerActionResponse,
     ContainerDetailResponse, ContainerInfoResponse, ContainerListResponse, ContainerLogsQuery,
-    ContainerMetricsResponse, DeploymentJobResponse, DeploymentJobsResponse,
-    DeploymentListResponse, DeploymentResponse, DeploymentStateResponse, EnvVarResponse,
-    ResourceLimitsResponse,
+    ContainerMetricsResponse, DeployPhaseResponse, DeployStatusResponse, DeploymentJobLogsResponse,
+    DeploymentJobResponse, DeploymentJobsResponse, DeploymentListResponse, DeploymentLogsQuery,
+    DeploymentLogsResponse, DeploymentResponse, DeploymentStateResponse, EnvVarResponse,
+    JobLogContextQuery, JobLogContextResponse, JobLogEntryResponse, ResourceLimitsResponse,
 };
+use crate::services::{
+    BuildBreakdown, BuildPhaseComparison, BuildPhaseName, DeployFailureReason, DeployOutcome,
+    LogStreamClosed, LogStreamPermit,
+};
+use temps_core::error_builder::ErrorBuilder;
+use temps_core::pagination::{CursorPage, FilterClause, FilterOp, ListParams};
 use temps_core::problemdetails;
 use temps_core::problemdetails::Problem;
+use temps_core::RequestMetadata;
 
 #[derive(OpenApi)]
 #[openapi(
@@ -37,11 +45,17 @@ use temps_core::problemdetails::Problem;
         get_deployment,
         get_deployment_jobs,
         get_deployment_job_logs,
+        get_deployment_job_log_context,
         tail_deployment_job_logs,
+        get_deploy_status,
+        get_build_breakdown,
+        get_deployment_logs,
+        stream_deployment_logs,
         rollback_to_deployment,
         pause_deployment,
         resume_deployment,
         cancel_deployment,
+        cancel_connection_drain,
         teardown_deployment,
         teardown_environment,
         list_containers,
@@ -57,10 +71,25 @@ use temps_core::problemdetails::Problem;
     ),
     components(schemas(
         DeploymentListResponse,
+        CursorPage<DeploymentResponse>,
         DeploymentResponse,
         DeploymentStateResponse,
         DeploymentJobsResponse,
         DeploymentJobResponse,
+        JobLogContextQuery,
+        JobLogContextResponse,
+        JobLogEntryResponse,
+        DeployStatusResponse,
+        DeployPhaseResponse,
+        DeployOutcome,
+        DeployFailureReason,
+        BuildBreakdown,
+        BuildPhaseComparison,
+        BuildPhaseName,
+        temps_entities::deployments::BuildPhaseTimings,
+        DeploymentLogsQuery,
+        DeploymentLogsResponse,
+        DeploymentJobLogsResponse,
         ContainerLogsQuery,
         GetDeploymentsParams,
         ContainerListResponse,
@@ -72,7 +101,9 @@ use temps_core::problemdetails::Problem;
         ContainerActionResponse,
         ActivityGraphQuery,
         ActivityGraphResponse,
-        ActivityDay
+        ActivityDay,
+        temps_entities::deployments::ConnectionDrainReport,
+        temps_entities::deployments::ConnectionDrainState
     )),
     info(
         title = "Deployments API",
@@ -105,6 +136,26 @@ pub fn configure_routes() -> Router<Arc<super::types::AppState>> {
             "/projects/{project_id}/deployments/{deployment_id}/jobs/{job_id}/logs",
             get(get_deployment_job_logs),
         )
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/jobs/{job_id}/logs/context",
+            get(get_deployment_job_log_context),
+        )
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/status",
+            get(get_deploy_status),
+        )
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/build-phases",
+            get(get_build_breakdown),
+        )
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/logs",
+            get(get_deployment_logs),
+        )
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/logs/stream",
+            get(stream_deployment_logs),
+        )
         // Deployment operations
         .route(
             "/projects/{project_id}/deployments/{deployment_id}/rollback",
@@ -122,6 +173,10 @@ pub fn configure_routes() -> Router<Arc<super::types::AppState>> {
             "/projects/{project_id}/deployments/{deployment_id}/cancel",
             post(cancel_deployment),
         )
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/drain/cancel",
+            post(cancel_connection_drain),
+        )
         .route(
             "/projects/{project_id}/deployments/{deployment_id}/teardown",
             delete(teardown_deployment),
@@ -204,6 +259,9 @@ impl From<crate::services::services::DeploymentError> for Problem {
                     .with_title("Invalid Deployment State")
                     .with_detail(msg)
             }
+            DeploymentError::Conflict(msg) => problemdetails::new(StatusCode::CONFLICT)
+                .with_title("Conflict")
+                .with_detail(msg),
             DeploymentError::PipelineError(msg) => {
                 problemdetails::new(StatusCode::INTERNAL_SERVER_ERROR)
                     .with_title("Pipeline Error")
@@ -249,7 +307,12 @@ pub async fn get_last_deployment(
 
 use super::types::GetDeploymentsParams;
 
-// Update the OpenAPI documentation
+/// List a project's deployments
+///
+/// Passing `cursor` (empty for the first page) pages by cursor and answers with a
+/// `CursorPage<DeploymentResponse>`, counting `total` across pages. Deployments sort
+/// by `created_at` or `id` and filter by `state`, `environment_id`, `branch` and
+/// `created_at`.
 #[utoipa::path(
     tag = "Deployments",
     path = "/projects/{id}/deployments",
@@ -259,10 +322,12 @@ use super::types::GetDeploymentsParams;
         ("id" = i32, Path, description = "Project ID"),
         ("page" = Option<i64>, Query, description = "Page number"),
         ("per_page" = Option<i64>, Query, description = "Items per page"),
-        ("environment_id" = Option<i32>, Query, description = "Environment ID filter")
+        ("environment_id" = Option<i32>, Query, description = "Environment ID filter"),
+        ListParams
     ),
     responses(
         (status = 200, description = "List of deployments", body = DeploymentListResponse),
+        (status = 400, description = "Invalid cursor, sort or filter"),
         (status = 404, description = "Project not found")
     )
 )]
@@ -270,10 +335,27 @@ pub async fn get_project_deployments(
     State(state): State<Arc<AppState>>,
     Path(id): Path<i32>,
     Query(params): Query<GetDeploymentsParams>,
+    Query(list): Query<ListParams>,
     RequireAuth(auth): RequireAuth,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, DeploymentsRead);
 
+    if list.uses_cursor() {
+        let mut request = list.resolve(&crate::services::services::DEPLOYMENT_LIST)?;
+        if let Some(environment_id) = params.environment_id {
+            request.filters.push(FilterClause {
+                field: "environment_id".to_string(),
+                op: FilterOp::Eq,
+                values: vec![environment_id.to_string()],
+            });
+        }
+        let page = state
+            .deployment_service
+            .list_project_deployments(id, &request)
+            .await?;
+        return Ok(Json(page.map(DeploymentResponse::from_service_deployment)).into_response());
+    }
+
     let list_response = state
         .deployment_service
         .get_project_deployments(id, params.page, params.per_page, params.environment_id)
@@ -483,6 +565,40 @@ pub async fn cancel_deployment(
     Ok(Json(response).into_response())
 }
 
+/// Cancel the drain of the previous deployment's connections
+///
+/// The deploy stops waiting and stops the previous deployment right away, closing the
+/// connections it still serves.
+#[utoipa::path(
+    tag = "Deployments",
+    post,
+    path = "/projects/{project_id}/deployments/{deployment_id}/drain/cancel",
+    responses(
+        (status = 200, description = "Drain cancellation requested", body = temps_entities::deployments::ConnectionDrainReport),
+        (status = 400, description = "The deployment isn't draining connections"),
+        (status = 404, description = "Project or deployment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn cancel_connection_drain(
+    State(state): State<Arc<AppState>>,
+    Path((project_id, deployment_id)): Path<(i32, i32)>,
+    RequireAuth(auth): RequireAuth,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsWrite);
+
+    let report = state
+        .deployment_service
+        .cancel_connection_drain(project_id, deployment_id)
+        .await?;
+    Ok(Json(report))
+}
+
 /// Teardown a specific deployment
 #[utoipa::path(
     tag = "Deployments",
@@ -621,12 +737,14 @@ pub async fn list_containers(
         ("start_date" = Option<i64>, Query, description = "Start date for logs"),
         ("end_date" = Option<i64>, Query, description = "End date for logs"),
         ("tail" = Option<String>, Query, description = "Number of lines to tail (or 'all')"),
-        ("timestamps" = Option<bool>, Query, description = "Include timestamps in log output (default: false)")
+        ("timestamps" = Option<bool>, Query, description = "Include timestamps in log output (default: false)"),
+        ("coalesce" = Option<bool>, Query, description = "Collapse consecutive identical lines (default: the environment's container log settings)")
     ),
     responses(
         (status = 101, description = "WebSocket connection established for streaming container logs"),
         (status = 400, description = "Not a server-type project"),
         (status = 404, description = "Project, environment, or container not found"),
+        (status = 429, description = "Too many log streams open"),
         (status = 500, description = "Internal server error")
     ),
     security(("bearer_auth" = []))
@@ -636,9 +754,21 @@ pub async fn get_container_logs_by_id(
     Path((project_id, environment_id, container_id)): Path<(i32, i32, String)>,
     Query(query): Query<ContainerLogsQuery>,
     RequireAuth(auth): RequireAuth,
+    metadata: Option<Extension<RequestMetadata>>,
     ws: WebSocketUpgrade,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, DeploymentsRead);
+    require_log_scope(
+        &auth,
+        metadata.as_deref(),
+        state.audit_service.as_ref(),
+        "container_logs",
+        Some(project_id),
+        Some(environment_id),
+    )
+    .await?;
+
+    let permit = open_log_stream(&state, &auth, project_id).await?;
 
     debug!(
         "WebSocket request for container {} logs in environment {} of project: {}",
@@ -650,6 +780,7 @@ pub async fn get_container_logs_by_id(
         handle_container_logs_socket(
             socket,
             state,
+            permit,
             ContainerLogParams {
                 project_id,
                 environment_id,
@@ -658,6 +789,7 @@ pub async fn get_container_logs_by_id(
                 end_date: query.end_date,
                 tail: query.tail,
                 timestamps: query.timestamps,
+                coalesce: query.coalesce,
             },
         )
     }))
@@ -672,11 +804,13 @@ struct ContainerLogParams {
     end_date: Option<i64>,
     tail: Option<String>,
     timestamps: bool,
+    coalesce: Option<bool>,
 }
 
 async fn handle_container_logs_socket(
     mut socket: WebSocket,
     state: Arc<AppState>,
+    permit: LogStreamPermit,
     params: ContainerLogParams,
 ) {
     debug!(
@@ -696,6 +830,7 @@ async fn handle_container_logs_socket(
                 end_date: params.end_date,
                 tail: params.tail,
                 timestamps: params.timestamps,
+                coalesce: params.coalesce,
             },
         )
         .await
@@ -722,7 +857,15 @@ async fn handle_container_logs_socket(
     tokio::pin!(log_stream);
 
     // Stream logs to WebSocket client (raw text, not JSON)
-    while let Some(log_result) = log_stream.next().await {
+    loop {
+        let log_result = match permit.next(&mut log_stream).await {
+            Ok(Some(log_result)) => log_result,
+            Ok(None) => break,
+            Err(closed) => {
+                close_log_socket(&mut socket, closed).await;
+                break;
+            }
+        };
         match log_result {
             Ok(line) => {
                 // Send raw log line as-is
@@ -762,12 +905,14 @@ async fn handle_container_logs_socket(
         ("end_date" = Option<i64>, Query, description = "End date for logs"),
         ("tail" = Option<String>, Query, description = "Number of lines to tail (or 'all')"),
         ("container_name" = Option<String>, Query, description = "Optional container name (defaults to first/primary container)"),
-        ("timestamps" = Option<bool>, Query, description = "Include timestamps in log output (default: false)")
+        ("timestamps" = Option<bool>, Query, description = "Include timestamps in log output (default: false)"),
+        ("coalesce" = Option<bool>, Query, description = "Collapse consecutive identical lines (default: the environment's container log settings)")
     ),
     responses(
         (status = 101, description = "WebSocket connection established for streaming container logs"),
         (status = 400, description = "Not a server-type project"),
         (status = 404, description = "Project, deployment, or container not found"),
+        (status = 429, description = "Too many log streams open"),
         (status = 500, description = "Internal server error")
     ),
     security(("bearer_auth" = []))
@@ -777,9 +922,21 @@ pub async fn get_container_logs(
     Path((project_id, environment_id)): Path<(i32, i32)>,
     Query(query): Query<ContainerLogsQuery>,
     RequireAuth(auth): RequireAuth,
+    metadata: Option<Extension<RequestMetadata>>,
     ws: WebSocketUpgrade,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, DeploymentsRead);
+    require_log_scope(
+        &auth,
+        metadata.as_deref(),
+        state.audit_service.as_ref(),
+        "container_logs",
+        Some(project_id),
+        Some(environment_id),
+    )
+    .await?;
+
+    let permit = open_log_stream(&state, &auth, project_id).await?;
 
     debug!(
         "WebSocket request for container logs in environment {} of project: {}",
@@ -791,6 +948,7 @@ pub async fn get_container_logs(
         handle_filtered_container_logs_socket(
             socket,
             state,
+            permit,
             FilteredContainerLogParams {
                 project_id,
                 environment_id,
@@ -799,6 +957,7 @@ pub async fn get_container_logs(
                 tail: query.tail,
                 container_name: query.container_name,
                 timestamps: query.timestamps,
+                coalesce: query.coalesce,
             },
         )
     }))
@@ -813,11 +972,13 @@ struct FilteredContainerLogParams {
     tail: Option<String>,
     container_name: Option<String>,
     timestamps: bool,
+    coalesce: Option<bool>,
 }
 
 async fn handle_filtered_container_logs_socket(
     mut socket: WebSocket,
     state: Arc<AppState>,
+    permit: LogStreamPermit,
     params: FilteredContainerLogParams,
 ) {
     debug!(
@@ -837,6 +998,7 @@ async fn handle_filtered_container_logs_socket(
                 end_date: params.end_date,
                 tail: params.tail,
                 timestamps: params.timestamps,
+                coalesce: params.coalesce,
             },
         )
         .await
@@ -863,7 +1025,15 @@ async fn handle_filtered_container_logs_socket(
     tokio::pin!(log_stream);
 
     // Stream logs to WebSocket client
-    while let Some(log_result) = log_stream.next().await {
+    loop {
+        let log_result = match permit.next(&mut log_stream).await {
+            Ok(Some(log_result)) => log_result,
+            Ok(None) => break,
+            Err(closed) => {
+                close_log_socket(&mut socket, closed).await;
+                break;
+            }
+        };
         match log_result {
             Ok(line) => {
                 // Send raw log line as-is
@@ -929,6 +1099,76 @@ pub async fn get_deployment_jobs(
     }))
 }
 
+/// Reject reading the job logs of a deployment outside of the caller's log scope
+///
+/// Job log routes look jobs up by deployment alone, so for a caller with a log scope
+/// the deployment is checked to belong to the project and its environment to be in
+/// the scope.
+async fn require_deployment_log_scope(
+    state: &AppState,
+    auth: &AuthContext,
+    metadata: Option<&RequestMetadata>,
+    project_id: i32,
+    deployment_id: i32,
+) -> Result<(), Problem> {
+    if auth.log_scope.is_none() {
+        return Ok(());
+    }
+    let deployment = state
+        .deployment_service
+        .get_deployment(project_id, deployment_id)
+        .await?;
+    require_log_scope(
+        auth,
+        metadata,
+        state.audit_service.as_ref(),
+        "deployment_logs",
+        Some(project_id),
+        Some(deployment.environment_id),
+    )
+    .await
+}
+
+/// Take a log stream permit for the caller, or reject the stream with 429 when a
+/// limit on open log streams is reached
+async fn open_log_stream(
+    state: &AppState,
+    auth: &AuthContext,
+    project_id: i32,
+) -> Result<LogStreamPermit, Problem> {
+    state
+        .log_streams
+        .acquire(auth.user_id(), project_id)
+        .await
+        .map_err(|e| {
+            warn!(
+                "Rejected log stream of user {} on project {}: {}",
+                auth.user_id(),
+                project_id,
+                e
+            );
+            ErrorBuilder::new(StatusCode::TOO_MANY_REQUESTS)
+                .type_("https://temps.sh/probs/log-stream-limit")
+                .title("Too Many Log Streams")
+                .detail(format!("{}. Close a log stream to open another", e))
+                .value("error_code", "LOG_STREAM_LIMIT")
+                .value("limit", e.limit())
+                .build()
+        })
+}
+
+/// Close a log WebSocket the server ended, telling the client why
+async fn close_log_socket(socket: &mut WebSocket, closed: LogStreamClosed) {
+    debug!("Closing log stream: {}", closed.reason());
+    let frame = CloseFrame {
+        code: close_code::NORMAL,
+        reason: closed.to_string().into(),
+    };
+    if let Err(e) = socket.send(Message::Close(Some(frame))).await {
+        warn!("Failed to close log WebSocket: {}", e);
+    }
+}
+
 /// Get logs for a specific deployment job
 #[utoipa::path(
     get,
@@ -950,9 +1190,18 @@ pub async fn get_deployment_jobs(
 pub async fn get_deployment_job_logs(
     RequireAuth(auth): RequireAuth,
     State(state): State<Arc<AppState>>,
-    Path((_project_id, deployment_id, job_id)): Path<(i32, i32, String)>,
+    Path((project_id, deployment_id, job_id)): Path<(i32, i32, String)>,
+    metadata: Option<Extension<RequestMetadata>>,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, DeploymentsRead);
+    require_deployment_log_scope(
+        &state,
+        &auth,
+        metadata.as_deref(),
+        project_id,
+        deployment_id,
+    )
+    .await?;
 
     // Get the job to verify it exists and get its log_id
     let jobs = state
@@ -978,6 +1227,415 @@ pub async fn get_deployment_job_logs(
     Ok((StatusCode::OK, log_content))
 }
 
+/// Get a line of a deployment job's logs and the entries around it
+///
+/// Fetches a number of lines before and after the line, entries logged within a time
+/// window around it, or both; counts and windows are capped.
+#[utoipa::path(
+    get,
+    path = "/projects/{project_id}/deployments/{deployment_id}/jobs/{job_id}/logs/context",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID"),
+        ("job_id" = String, Path, description = "Job ID"),
+        ("line" = u64, Query, description = "Log line to fetch the context of"),
+        ("before" = Option<usize>, Query, description = "Lines before it (default: 10, or up to 500 within window_seconds)"),
+        ("after" = Option<usize>, Query, description = "Lines after it (default: 10, or up to 500 within window_seconds)"),
+        ("window_seconds" = Option<i64>, Query, description = "Only entries logged at most this many seconds before or after it (max: 3600)")
+    ),
+    responses(
+        (status = 200, description = "Log line and its context", body = JobLogContextResponse),
+        (status = 404, description = "Job or log line not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(
+        ("bearer_token" = [])
+    ),
+    tag = "Deployments"
+)]
+pub async fn get_deployment_job_log_context(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<AppState>>,
+    Path((project_id, deployment_id, job_id)): Path<(i32, i32, String)>,
+    Query(query): Query<JobLogContextQuery>,
+    metadata: Option<Extension<RequestMetadata>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+    require_deployment_log_scope(
+        &state,
+        &auth,
+        metadata.as_deref(),
+        project_id,
+        deployment_id,
+    )
+    .await?;
+
+    let jobs = state
+        .deployment_service
+        .get_deployment_jobs(deployment_id)
+        .await?;
+
+    let job = jobs
+        .iter()
+        .find(|j| j.job_id == job_id)
+        .ok_or_else(|| problemdetails::new(StatusCode::NOT_FOUND).with_detail("Job not found"))?;
+
+    let context =
+        temps_logs::LogContextWindow::new(query.before, query.after, query.window_seconds);
+    let entries = state
+        .log_service
+        .get_structured_log_context(&job.log_id, query.line, context)
+        .await
+        .map_err(|e| {
+            problemdetails::new(StatusCode::INTERNAL_SERVER_ERROR)
+                .with_detail(format!("Failed to read logs: {}", e))
+        })?
+        .ok_or_else(|| {
+            problemdetails::new(StatusCode::NOT_FOUND)
+                .with_detail(format!("Log line {} not found", query.line))
+        })?;
+
+    Ok(Json(JobLogContextResponse {
+        line: query.line,
+        entries: entries.into_iter().map(Into::into).collect(),
+    }))
+}
+
+/// Seconds between two reads of a deployment's logs and state while streaming them
+const DEPLOY_LOG_STREAM_INTERVAL_SECONDS: u64 = 1;
+
+/// Asks the proxies in front of the API, ours included, to send events on as they
+/// come instead of buffering them
+const SSE_STREAM_HEADERS: [(&str, &str); 1] = [("x-accel-buffering", "no")];
+
+/// Get the status of a deployment
+///
+/// Stable endpoint for CI pipelines waiting on a deployment, keyed by the deployment
+/// ID returned when it was started. Poll it until `finished` is true, then branch on
+/// `outcome`; a failed deployment comes with a machine-readable `failure_reason` and
+/// the job it failed in, whose logs tell why. Each job is a phase with its timings.
+#[utoipa::path(
+    get,
+    path = "/projects/{project_id}/deployments/{deployment_id}/status",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID")
+    ),
+    responses(
+        (status = 200, description = "Deployment status", body = DeployStatusResponse),
+        (status = 404, description = "Project or deployment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(
+        ("bearer_token" = [])
+    ),
+    tag = "Deployments"
+)]
+pub async fn get_deploy_status(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<AppState>>,
+    Path((project_id, deployment_id)): Path<(i32, i32)>,
+) -> Result<Json<DeployStatusResponse>, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let status = deploy_status(&state, project_id, deployment_id).await?;
+    Ok(Json(status))
+}
+
+async fn deploy_status(
+    state: &AppState,
+    project_id: i32,
+    deployment_id: i32,
+) -> Result<DeployStatusResponse, Problem> {
+    let deployment = state
+        .deployment_service
+        .get_deployment(project_id, deployment_id)
+        .await?;
+    let jobs = state
+        .deployment_service
+        .get_deployment_jobs(deployment_id)
+        .await?;
+    Ok(DeployStatusResponse::new(deployment, jobs))
+}
+
+/// Get where a deployment's build spent its time
+///
+/// Time spent fetching the source and in each phase of the image build — context
+/// upload, base images, dependency install, compile, image export and, for builds on
+/// a build node, the push to this server — with the share of cached steps. Each
+/// phase is compared with the average of the environment's last successful builds;
+/// averages need at least 3 of them. Image build phases are only known for BuildKit
+/// builds of a Dockerfile or Nixpacks.
+#[utoipa::path(
+    get,
+    path = "/projects/{project_id}/deployments/{deployment_id}/build-phases",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID")
+    ),
+    responses(
+        (status = 200, description = "Build phase breakdown", body = BuildBreakdown),
+        (status = 404, description = "Project or deployment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(
+        ("bearer_token" = [])
+    ),
+    tag = "Deployments"
+)]
+pub async fn get_build_breakdown(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<AppState>>,
+    Path((project_id, deployment_id)): Path<(i32, i32)>,
+) -> Result<Json<BuildBreakdown>, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let breakdown = state
+        .deployment_service
+        .get_build_breakdown(project_id, deployment_id)
+        .await?;
+    Ok(Json(breakdown))
+}
+
+/// Get the logs of a deployment
+///
+/// Structured log entries of every job of the deployment, in execution order, or of
+/// one job with `job_id`. `tail` keeps the last entries of each job, e.g. to show
+/// why a deployment failed.
+#[utoipa::path(
+    get,
+    path = "/projects/{project_id}/deployments/{deployment_id}/logs",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID"),
+        ("tail" = Option<usize>, Query, description = "Only the last this many entries of each job (default: all of them)"),
+        ("job_id" = Option<String>, Query, description = "Only the logs of this job")
+    ),
+    responses(
+        (status = 200, description = "Deployment logs", body = DeploymentLogsResponse),
+        (status = 404, description = "Project, deployment or job not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(
+        ("bearer_token" = [])
+    ),
+    tag = "Deployments"
+)]
+pub async fn get_deployment_logs(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<AppState>>,
+    Path((project_id, deployment_id)): Path<(i32, i32)>,
+    Query(query): Query<DeploymentLogsQuery>,
+    metadata: Option<Extension<RequestMetadata>>,
+) -> Result<Json<DeploymentLogsResponse>, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let deployment = state
+        .deployment_service
+        .get_deployment(project_id, deployment_id)
+        .await?;
+    require_log_scope(
+        &auth,
+        metadata.as_deref(),
+        state.audit_service.as_ref(),
+        "deployment_logs",
+        Some(project_id),
+        Some(deployment.environment_id),
+    )
+    .await?;
+    let jobs = state
+        .deployment_service
+        .get_deployment_jobs(deployment_id)
+        .await?;
+    let jobs: Vec<_> = match &query.job_id {
+        Some(job_id) => {
+            let job = jobs
+                .into_iter()
+                .find(|j| &j.job_id == job_id)
+                .ok_or_else(|| {
+                    problemdetails::new(StatusCode::NOT_FOUND).with_detail("Job not found")
+                })?;
+            vec![job]
+        }
+        None => jobs,
+    };
+
+    let mut job_logs = Vec::with_capacity(jobs.len());
+    for job in jobs {
+        let entries = state
+            .log_service
+            .get_structured_logs(&job.log_id)
+            .await
+            .map_err(|e| {
+                problemdetails::new(StatusCode::INTERNAL_SERVER_ERROR)
+                    .with_detail(format!("Failed to read logs: {}", e))
+            })?;
+        let total_entries = entries.len();
+        let skip = query
+            .tail
+            .map_or(0, |tail| total_entries.saturating_sub(tail));
+        job_logs.push(DeploymentJobLogsResponse {
+            job_id: job.job_id,
+            name: job.name,
+            status: job.status.to_string(),
+            total_entries,
+            entries: entries.into_iter().skip(skip).map(Into::into).collect(),
+        });
+    }
+
+    Ok(Json(DeploymentLogsResponse {
+        deployment_id,
+        jobs: job_logs,
+    }))
+}
+
+/// Stream the logs of a deployment until it finishes, via Server-Sent Events (SSE)
+///
+/// Sends every entry the deployment's jobs logged so far, then new entries as they
+/// are written, as `log` events: a log entry with the `job_id` it belongs to. Once
+/// the deployment finished, a last `status` event carries its status, as returned by
+/// the status endpoint, and the stream ends. A stream that sent no logs for the idle
+/// timeout of the log stream settings, or was open for their maximum duration, ends
+/// with a `closed` event carrying the `reason` instead.
+#[utoipa::path(
+    get,
+    path = "/projects/{project_id}/deployments/{deployment_id}/logs/stream",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID")
+    ),
+    responses(
+        (status = 200, description = "Log stream established (Server-Sent Events)"),
+        (status = 404, description = "Project or deployment not found"),
+        (status = 429, description = "Too many log streams open"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(
+        ("bearer_token" = [])
+    ),
+    tag = "Deployments"
+)]
+pub async fn stream_deployment_logs(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<AppState>>,
+    Path((project_id, deployment_id)): Path<(i32, i32)>,
+    metadata: Option<Extension<RequestMetadata>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    // The stream only reads this deployment's jobs, so checking its environment
+    // before streaming keeps lines of other services out of it
+    let deployment = state
+        .deployment_service
+        .get_deployment(project_id, deployment_id)
+        .await?;
+    require_log_scope(
+        &auth,
+        metadata.as_deref(),
+        state.audit_service.as_ref(),
+        "deployment_logs",
+        Some(project_id),
+        Some(deployment.environment_id),
+    )
+    .await?;
+    let permit = open_log_stream(&state, &auth, project_id).await?;
+
+    // Last line sent of each job's log; None once the final status was sent
+    let sent_lines: Option<std::collections::HashMap<String, u64>> = Some(Default::default());
+    let interval = std::time::Duration::from_secs(DEPLOY_LOG_STREAM_INTERVAL_SECONDS);
+    let sse_stream = stream::unfold(
+        (
+            tokio::time::interval(interval),
+            sent_lines,
+            permit,
+            tokio::time::Instant::now(),
+        ),
+        move |(mut ticker, sent_lines, permit, last_sent)| {
+            let state = state.clone();
+            async move {
+                let mut sent_lines = sent_lines?;
+                ticker.tick().await;
+
+                // Read the state before the logs, so nothing logged before it finished
+                // is left out
+                let status = match deploy_status(&state, project_id, deployment_id).await {
+                    Ok(status) => status,
+                    Err(e) => {
+                        error!(
+                            "Failed to get the status of deployment {}: {:?}",
+                            deployment_id, e
+                        );
+                        let event = axum::response::sse::Event::default().comment("error");
+                        return Some((vec![event], (ticker, Some(sent_lines), permit, last_sent)));
+                    }
+                };
+                let jobs = state
+                    .deployment_service
+                    .get_deployment_jobs(deployment_id)
+                    .await
+                    .unwrap_or_default();
+
+                let mut events = Vec::new();
+                for job in jobs {
+                    let entries = match state.log_service.get_structured_logs(&job.log_id).await {
+                        Ok(entries) => entries,
+                        Err(e) => {
+                            warn!("Failed to read logs of job {}: {}", job.job_id, e);
+                            continue;
+                        }
+                    };
+                    let sent = sent_lines.entry(job.job_id.clone()).or_insert(0);
+                    let after = *sent;
+                    for entry in entries.into_iter().filter(|entry| entry.line > after) {
+                        *sent = entry.line;
+                        let mut data = serde_json::json!(JobLogEntryResponse::from(entry));
+                        data["job_id"] = serde_json::json!(job.job_id);
+                        events.push(
+                            axum::response::sse::Event::default()
+                                .event("log")
+                                .json_data(data)
+                                .unwrap(),
+                        );
+                    }
+                }
+
+                if status.finished {
+                    events.push(
+                        axum::response::sse::Event::default()
+                            .event("status")
+                            .json_data(&status)
+                            .unwrap(),
+                    );
+                    return Some((events, (ticker, None, permit, last_sent)));
+                }
+
+                let last_sent = if events.is_empty() {
+                    last_sent
+                } else {
+                    tokio::time::Instant::now()
+                };
+                if let Some(closed) = permit.closed_since(last_sent) {
+                    events.push(
+                        axum::response::sse::Event::default()
+                            .event("closed")
+                            .json_data(serde_json::json!({ "reason": closed.reason() }))
+                            .unwrap(),
+                    );
+                    return Some((events, (ticker, None, permit, last_sent)));
+                }
+                Some((events, (ticker, Some(sent_lines), permit, last_sent)))
+            }
+        },
+    )
+    .flat_map(|events| stream::iter(events.into_iter().map(Ok::<_, axum::Error>)));
+
+    Ok((
+        SSE_STREAM_HEADERS,
+        axum::response::sse::Sse::new(sse_stream)
+            .keep_alive(axum::response::sse::KeepAlive::default()),
+    ))
+}
+
 /// Tail logs for a specific deployment job in real-time via WebSocket
 ///
 /// **WebSocket Streaming**: Logs are sent as raw text, one line per WebSocket message.
@@ -1002,6 +1660,7 @@ pub async fn get_deployment_job_logs(
     responses(
         (status = 101, description = "WebSocket connection established for streaming deployment job logs"),
         (status = 404, description = "Job or logs not found"),
+        (status = 429, description = "Too many log streams open"),
         (status = 500, description = "Internal server error")
     ),
     security(
@@ -1012,10 +1671,19 @@ pub async fn get_deployment_job_logs(
 pub async fn tail_deployment_job_logs(
     RequireAuth(auth): RequireAuth,
     State(state): State<Arc<AppState>>,
-    Path((_project_id, deployment_id, job_id)): Path<(i32, i32, String)>,
+    Path((project_id, deployment_id, job_id)): Path<(i32, i32, String)>,
+    metadata: Option<Extension<RequestMetadata>>,
     ws: WebSocketUpgrade,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, DeploymentsRead);
+    require_deployment_log_scope(
+        &state,
+        &auth,
+        metadata.as_deref(),
+        project_id,
+        deployment_id,
+    )
+    .await?;
 
     debug!(
         "WebSocket request for tailing logs for job {} in deployment {}",
@@ -1034,13 +1702,19 @@ pub async fn tail_deployment_job_logs(
         .ok_or_else(|| problemdetails::new(StatusCode::NOT_FOUND).with_detail("Job not found"))?;
 
     let log_id = job.log_id.clone();
+    let permit = open_log_stream(&state, &auth, project_id).await?;
 
     // Upgrade to WebSocket and handle the connection
-    Ok(ws.on_upgrade(move |socket| handle_job_log_socket(socket, state, log_id)))
+    Ok(ws.on_upgrade(move |socket| handle_job_log_socket(socket, state, permit, log_id)))
 }
 
 /// Handle WebSocket connection for job log tailing
-async fn handle_job_log_socket(mut socket: WebSocket, state: Arc<AppState>, log_id: String) {
+async fn handle_job_log_socket(
+    mut socket: WebSocket,
+    state: Arc<AppState>,
+    permit: LogStreamPermit,
+    log_id: String,
+) {
     debug!("WebSocket connection established for log_id: {}", log_id);
 
     // Get the log stream from the log service
@@ -1067,7 +1741,15 @@ async fn handle_job_log_socket(mut socket: WebSocket, state: Arc<AppState>, log_
     tokio::pin!(stream);
 
     // Stream logs to WebSocket client (raw text, not JSON)
-    while let Some(line_result) = stream.next().await {
+    loop {
+        let line_result = match permit.next(&mut stream).await {
+            Ok(Some(line_result)) => line_result,
+            Ok(None) => break,
+            Err(closed) => {
+                close_log_socket(&mut socket, closed).await;
+                break;
+            }
+        };
         match line_result {
             Ok(data) => {
                 // Send raw log line as-is
@@ -1120,7 +1802,14 @@ pub async fn get_container_detail(
         .get_container_detail(project_id, environment_id, container_id.clone())
         .await?;
 
-    // Parse environment variables and mask sensitive ones
+    // Parse environment variables and mask sensitive ones; in a protected environment
+    // every value is a secret
+    let mask_all = !auth.has_permission(&temps_auth::Permission::ProtectedSecretsRead)
+        && state
+            .deployment_service
+            .environment_has_protected_secrets(environment_id)
+            .await
+            .unwrap_or(true);
     let mut env_vars = vec![];
     if let Ok(vars) = state
         .deployment_service
@@ -1129,9 +1818,10 @@ pub async fn get_container_detail(
     {
         let sensitive_keys = ["password", "secret", "token", "key", "auth", "api_key"];
         for (key, value) in vars {
-            let is_masked = sensitive_keys
-                .iter()
-                .any(|&s| key.to_lowercase().contains(s));
+            let is_masked = mask_all
+                || sensitive_keys
+                    .iter()
+                    .any(|&s| key.to_lowercase().contains(s));
             env_vars.push(crate::handlers::types::EnvVarResponse {
                 key,
                 value: if is_masked { "***".to_string() } else { value },
@@ -1140,6 +1830,13 @@ pub async fn get_container_detail(
         }
     }
 
+    // Deploy metadata labels, read from the runtime
+    let labels = state
+        .deployment_service
+        .get_container_labels(project_id, environment_id, container_id.clone())
+        .await
+        .unwrap_or_default();
+
     let response = crate::handlers::types::ContainerDetailResponse {
         id: container.id,
         container_id: container.container_id,
@@ -1154,6 +1851,7 @@ pub async fn get_container_detail(
         host_port: container.host_port,
         environment_variables: env_vars,
         resource_limits: None, // Could be populated from deployment config if needed
+        labels,
     };
 
     Ok(Json(response).into_response())
@@ -1341,12 +2039,7 @@ pub async fn stream_container_metrics(
     Path((project_id, environment_id, container_id)): Path<(i32, i32, String)>,
     Query(params): Query<std::collections::HashMap<String, String>>,
     RequireAuth(auth): RequireAuth,
-) -> Result<
-    axum::response::sse::Sse<
-        impl futures::Stream<Item = Result<axum::response::sse::Event, axum::Error>>,
-    >,
-    Problem,
-> {
+) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, EnvironmentsRead);
 
     let interval_ms = params
@@ -1415,7 +2108,10 @@ pub async fn stream_container_metrics(
         })
     };
 
-    Ok(axum::response::sse::Sse::new(sse_stream))
+    Ok((
+        SSE_STREAM_HEADERS,
+        axum::response::sse::Sse::new(sse_stream),
+    ))
 }
 
 /// Get deployment activity graph showing daily deployment counts
@@ -1474,6 +2170,18 @@ mod tests {
     use tokio::time::{timeout, Duration};
     use tokio_tungstenite::{connect_async, tungstenite::Message as WsMessage};
 
+    struct MockAuditLogger;
+
+    #[async_trait::async_trait]
+    impl temps_core::AuditLogger for MockAuditLogger {
+        async fn create_audit_log(
+            &self,
+            _operation: &dyn temps_core::AuditOperation,
+        ) -> anyhow::Result<()> {
+            Ok(())
+        }
+    }
+
     /// Helper to create a mock AuthContext for testing
     fn create_test_auth_context() -> temps_auth::AuthContext {
         let user = temps_entities::users::Model {
@@ -1490,6 +2198,7 @@ mod tests {
             mfa_secret: None,
             mfa_enabled: false,
             mfa_recovery_codes: None,
+            log_scope: None,
             created_at: chrono::Utc::now(),
             updated_at: chrono::Utc::now(),
         };
@@ -1547,7 +2256,7 @@ mod tests {
         let deployment_service = Arc::new(crate::services::services::DeploymentService::new(
             db.clone(),
             log_service.clone(),
-            config_service,
+            config_service.clone(),
             queue_service.clone(),
             docker_log_service,
             deployer,
@@ -1565,6 +2274,8 @@ mod tests {
             log_service,
             cron_service,
             external_deployment_manager: Arc::new(crate::services::ExternalDeploymentManager::new()),
+            audit_service: Arc::new(MockAuditLogger),
+            log_streams: Arc::new(crate::services::LogStreamLimiter::new(config_service)),
         });
 
         // Create test data in database
@@ -1815,7 +2526,7 @@ mod tests {
         let deployment_service = Arc::new(crate::services::services::DeploymentService::new(
             db.clone(),
             log_service.clone(),
-            config_service,
+            config_service.clone(),
             queue_service.clone(),
             docker_log_service,
             deployer,
@@ -1833,6 +2544,8 @@ mod tests {
             log_service: log_service.clone(),
             cron_service,
             external_deployment_manager: Arc::new(crate::services::ExternalDeploymentManager::new()),
+            audit_service: Arc::new(MockAuditLogger),
+            log_streams: Arc::new(crate::services::LogStreamLimiter::new(config_service)),
         });
 
         // Create test data
@@ -2082,7 +2795,7 @@ mod tests {
         let deployment_service = Arc::new(crate::services::services::DeploymentService::new(
             db.clone(),
             log_service.clone(),
-            config_service,
+            config_service.clone(),
             queue_service.clone(),
             docker_log_service,
             deployer,
@@ -2100,6 +2813,8 @@ mod tests {
             log_service: log_service.clone(),
             cron_service,
             external_deployment_manager: Arc::new(crate::services::ExternalDeploymentManager::new()),
+            audit_service: Arc::new(MockAuditLogger),
+            log_streams: Arc::new(crate::services::LogStreamLimiter::new(config_service)),
         });
 
         // Create test data
@@ -2356,7 +3071,7 @@ mod tests {
         let deployment_service = Arc::new(crate::services::services::DeploymentService::new(
             db.clone(),
             log_service.clone(),
-            config_service,
+            config_service.clone(),
             queue_service.clone(),
             docker_log_service,
             deployer,
@@ -2374,6 +3089,8 @@ mod tests {
             log_service,
             cron_service,
             external_deployment_manager: Arc::new(crate::services::ExternalDeploymentManager::new()),
+            audit_service: Arc::new(MockAuditLogger),
+            log_streams: Arc::new(crate::services::LogStreamLimiter::new(config_service)),
         })
     }
 
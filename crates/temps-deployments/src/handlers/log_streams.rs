@@ -0,0 +1,58 @@
+//! Log Stream Handlers
+//!
+//! API endpoint showing how many live log tails are open, in total and by user and
+//! project, next to the limits on them.
+
+use std::sync::Arc;
+
+use axum::{extract::State, response::IntoResponse, routing::get, Json, Router};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use utoipa::OpenApi;
+
+use crate::services::{LogStreamLimiter, LogStreamStatus, ProjectLogStreams, UserLogStreams};
+
+/// App state for log stream handlers
+pub struct LogStreamsAppState {
+    pub log_streams: Arc<LogStreamLimiter>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(get_log_streams),
+    components(schemas(LogStreamStatus, UserLogStreams, ProjectLogStreams)),
+    info(
+        title = "Log Streams API",
+        description = "API endpoints for the live log tail streams open on the server.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "System", description = "System maintenance operations")
+    )
+)]
+pub struct LogStreamsApiDoc;
+
+pub fn configure_routes() -> Router<Arc<LogStreamsAppState>> {
+    Router::new().route("/system/log-streams", get(get_log_streams))
+}
+
+/// Get the open log tail streams and the limits on them
+#[utoipa::path(
+    tag = "System",
+    get,
+    path = "/system/log-streams",
+    responses(
+        (status = 200, description = "Log stream status", body = LogStreamStatus),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_log_streams(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<LogStreamsAppState>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemRead);
+
+    Ok(Json(app_state.log_streams.status().await))
+}
@@ -1,5 +1,21 @@
+pub mod artifact_deploys;
+pub mod audit;
+pub mod build_cache;
+pub mod build_nodes;
+pub mod build_queue;
+pub mod changelog;
+pub mod container_metrics;
 pub mod crons;
+pub mod deploy_gates;
+pub mod deployment_artifacts;
+pub mod deployment_retention;
 pub mod deployment_tokens;
 pub mod deployments;
 pub mod external_images;
+pub mod log_streams;
+pub mod project_lifecycle;
+pub mod promotions;
+pub mod resource_alerts;
+pub mod resource_gc;
+pub mod runtime_config;
 pub mod types;
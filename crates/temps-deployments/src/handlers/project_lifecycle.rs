@@ -0,0 +1,439 @@
+//! Project Lifecycle Handlers
+//!
+//! API endpoints to start, stop and restart a whole project — its linked managed
+//! services and the containers of all of its environments — in dependency order, and
+//! to redeploy all of a project's environments with bounded concurrency. Bulk
+//! operations do the same for a chosen set of services and environments, reporting
+//! how each one went.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    routing::post,
+    Json, Router,
+};
+use serde::Deserialize;
+use temps_auth::{permission_guard, AuthContext, RequireAuth};
+use temps_core::problemdetails::Problem;
+use temps_core::{AuditContext, AuditLogger, RequestMetadata};
+use tracing::{error, info};
+use utoipa::{OpenApi, ToSchema};
+
+use super::audit::BulkOperationStartedAudit;
+use crate::services::{
+    BulkAction, BulkOperationProgress, BulkOperationRequest, BulkOperationState, BulkTargetKind,
+    BulkTargetProgress, BulkTargetStatus, EnvironmentDeployProgress, EnvironmentDeployStatus,
+    ProjectDeployProgress, ProjectDeployState, ProjectLifecycleReport, ProjectLifecycleService,
+};
+
+/// App state for project lifecycle handlers
+pub struct ProjectLifecycleAppState {
+    pub project_lifecycle_service: Arc<ProjectLifecycleService>,
+    pub audit_service: Arc<dyn AuditLogger>,
+}
+
+/// A bulk operation on the containers of one environment
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct EnvironmentBulkOperationRequest {
+    pub action: BulkAction,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(
+        start_project,
+        stop_project,
+        restart_project,
+        deploy_project,
+        get_project_deploy_progress,
+        start_bulk_operation,
+        start_environment_bulk_operation,
+        get_bulk_operation
+    ),
+    components(schemas(
+        ProjectLifecycleReport,
+        ProjectDeployProgress,
+        ProjectDeployState,
+        EnvironmentDeployProgress,
+        EnvironmentDeployStatus,
+        BulkAction,
+        BulkOperationRequest,
+        EnvironmentBulkOperationRequest,
+        BulkOperationProgress,
+        BulkOperationState,
+        BulkTargetKind,
+        BulkTargetProgress,
+        BulkTargetStatus,
+        temps_entities::tags::Tags
+    )),
+    info(
+        title = "Project Lifecycle API",
+        description = "API endpoints for starting and stopping a project's services and \
+        containers in dependency order.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Projects", description = "Project management endpoints")
+    )
+)]
+pub struct ProjectLifecycleApiDoc;
+
+pub fn configure_routes() -> Router<Arc<ProjectLifecycleAppState>> {
+    Router::new()
+        .route("/projects/{project_id}/start", post(start_project))
+        .route("/projects/{project_id}/stop", post(stop_project))
+        .route("/projects/{project_id}/restart", post(restart_project))
+        .route(
+            "/projects/{project_id}/deploy-all",
+            post(deploy_project).get(get_project_deploy_progress),
+        )
+        .route(
+            "/projects/{project_id}/bulk",
+            post(start_bulk_operation).get(get_bulk_operation),
+        )
+        .route(
+            "/projects/{project_id}/environments/{environment_id}/bulk",
+            post(start_environment_bulk_operation),
+        )
+}
+
+/// Redeploys create deployments; every other action changes the project's state
+fn bulk_permission_guard(auth: &AuthContext, action: BulkAction) -> Result<(), Problem> {
+    if action == BulkAction::Redeploy {
+        permission_guard!(auth, DeploymentsCreate);
+    } else {
+        permission_guard!(auth, ProjectsWrite);
+    }
+    Ok(())
+}
+
+async fn run_bulk_operation(
+    app_state: &ProjectLifecycleAppState,
+    auth: &AuthContext,
+    metadata: &RequestMetadata,
+    project_id: i32,
+    request: BulkOperationRequest,
+) -> Result<BulkOperationProgress, Problem> {
+    bulk_permission_guard(auth, request.action)?;
+
+    info!(
+        "Bulk {} of project {} (user {})",
+        request.action.as_str(),
+        project_id,
+        auth.user_id()
+    );
+    let progress = app_state
+        .project_lifecycle_service
+        .start_bulk_operation(project_id, request)
+        .await?;
+
+    let target_ids = |kind: BulkTargetKind| {
+        progress
+            .targets
+            .iter()
+            .filter(|target| target.kind == kind)
+            .map(|target| target.id)
+            .collect()
+    };
+    let audit_event = BulkOperationStartedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        project_id,
+        run_id: progress.run_id.clone(),
+        action: progress.action,
+        environment_ids: target_ids(BulkTargetKind::Environment),
+        service_ids: target_ids(BulkTargetKind::Service),
+        concurrency: progress.concurrency,
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit_event).await {
+        error!("Failed to create audit log: {:?}", e);
+    }
+
+    Ok(progress)
+}
+
+/// Start a project's linked services in dependency order, then its containers
+#[utoipa::path(
+    tag = "Projects",
+    post,
+    path = "/projects/{project_id}/start",
+    params(
+        ("project_id" = i32, Path, description = "Project ID")
+    ),
+    responses(
+        (status = 200, description = "Project started", body = ProjectLifecycleReport),
+        (status = 400, description = "Service dependencies form a cycle"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Project not found"),
+        (status = 500, description = "A service failed to start or become healthy")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn start_project(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ProjectLifecycleAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    info!("Starting project {} (user {})", project_id, auth.user_id());
+    let report = app_state
+        .project_lifecycle_service
+        .start_project(project_id)
+        .await?;
+    Ok(Json(report))
+}
+
+/// Stop a project's containers, then its linked services in reverse dependency order
+///
+/// Services shared with other projects keep running.
+#[utoipa::path(
+    tag = "Projects",
+    post,
+    path = "/projects/{project_id}/stop",
+    params(
+        ("project_id" = i32, Path, description = "Project ID")
+    ),
+    responses(
+        (status = 200, description = "Project stopped", body = ProjectLifecycleReport),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Project not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn stop_project(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ProjectLifecycleAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    info!("Stopping project {} (user {})", project_id, auth.user_id());
+    let report = app_state
+        .project_lifecycle_service
+        .stop_project(project_id)
+        .await?;
+    Ok(Json(report))
+}
+
+/// Restart a project, stopping and starting it in dependency order
+#[utoipa::path(
+    tag = "Projects",
+    post,
+    path = "/projects/{project_id}/restart",
+    params(
+        ("project_id" = i32, Path, description = "Project ID")
+    ),
+    responses(
+        (status = 200, description = "Project restarted", body = ProjectLifecycleReport),
+        (status = 400, description = "Service dependencies form a cycle"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Project not found"),
+        (status = 500, description = "A service failed to start or become healthy")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn restart_project(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ProjectLifecycleAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    info!(
+        "Restarting project {} (user {})",
+        project_id,
+        auth.user_id()
+    );
+    let report = app_state
+        .project_lifecycle_service
+        .restart_project(project_id)
+        .await?;
+    Ok(Json(report))
+}
+
+/// Redeploy all of a project's environments
+///
+/// Linked services start first in dependency order, then environments are rebuilt
+/// and deployed a few at a time: the project's deploy concurrency, capped by the
+/// global build limit. Runs in the background; poll the progress endpoint.
+#[utoipa::path(
+    tag = "Projects",
+    post,
+    path = "/projects/{project_id}/deploy-all",
+    params(
+        ("project_id" = i32, Path, description = "Project ID")
+    ),
+    responses(
+        (status = 202, description = "Project-wide deploy started", body = ProjectDeployProgress),
+        (status = 400, description = "No environments to deploy, or a deploy is already running"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Project not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn deploy_project(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ProjectLifecycleAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsCreate);
+
+    info!(
+        "Deploying all environments of project {} (user {})",
+        project_id,
+        auth.user_id()
+    );
+    let progress = app_state
+        .project_lifecycle_service
+        .deploy_project(project_id)
+        .await?;
+    Ok((StatusCode::ACCEPTED, Json(progress)))
+}
+
+/// Get the progress of a project's latest project-wide deploy
+#[utoipa::path(
+    tag = "Projects",
+    get,
+    path = "/projects/{project_id}/deploy-all",
+    params(
+        ("project_id" = i32, Path, description = "Project ID")
+    ),
+    responses(
+        (status = 200, description = "Deploy progress", body = ProjectDeployProgress),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No project-wide deploy has been run")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_project_deploy_progress(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ProjectLifecycleAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let progress = app_state
+        .project_lifecycle_service
+        .get_deploy_progress(project_id)
+        .await?;
+    Ok(Json(progress))
+}
+
+/// Start, stop, restart or redeploy a project's services and environments
+///
+/// Runs in the background; poll the progress endpoint. Services are handled in
+/// dependency order and environments a few at a time. A failure doesn't stop the
+/// operation: whatever depends on a failed service is skipped and the rest carries on.
+#[utoipa::path(
+    tag = "Projects",
+    post,
+    path = "/projects/{project_id}/bulk",
+    request_body = BulkOperationRequest,
+    params(
+        ("project_id" = i32, Path, description = "Project ID")
+    ),
+    responses(
+        (status = 202, description = "Bulk operation started", body = BulkOperationProgress),
+        (status = 400, description = "Nothing to act on, a service isn't linked to the project, service dependencies form a cycle, or an operation is already running"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Project or environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn start_bulk_operation(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ProjectLifecycleAppState>>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Path(project_id): Path<i32>,
+    Json(request): Json<BulkOperationRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    let progress = run_bulk_operation(&app_state, &auth, &metadata, project_id, request).await?;
+    Ok((StatusCode::ACCEPTED, Json(progress)))
+}
+
+/// Start, stop, restart or redeploy the containers of one environment
+///
+/// The project's linked services are left alone. Runs in the background; poll the
+/// project's bulk operation progress endpoint.
+#[utoipa::path(
+    tag = "Projects",
+    post,
+    path = "/projects/{project_id}/environments/{environment_id}/bulk",
+    request_body = EnvironmentBulkOperationRequest,
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment ID")
+    ),
+    responses(
+        (status = 202, description = "Bulk operation started", body = BulkOperationProgress),
+        (status = 400, description = "A bulk operation or project-wide deploy is already running"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Project or environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn start_environment_bulk_operation(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ProjectLifecycleAppState>>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Path((project_id, environment_id)): Path<(i32, i32)>,
+    Json(request): Json<EnvironmentBulkOperationRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    let request = BulkOperationRequest {
+        action: request.action,
+        environment_ids: Some(vec![environment_id]),
+        service_ids: Some(Vec::new()),
+        service_tags: None,
+        concurrency: None,
+    };
+    let progress = run_bulk_operation(&app_state, &auth, &metadata, project_id, request).await?;
+    Ok((StatusCode::ACCEPTED, Json(progress)))
+}
+
+/// Get the progress, or once finished the summary, of a project's latest bulk operation
+#[utoipa::path(
+    tag = "Projects",
+    get,
+    path = "/projects/{project_id}/bulk",
+    params(
+        ("project_id" = i32, Path, description = "Project ID")
+    ),
+    responses(
+        (status = 200, description = "Bulk operation progress", body = BulkOperationProgress),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No bulk operation has been run")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_bulk_operation(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ProjectLifecycleAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsRead);
+
+    let progress = app_state
+        .project_lifecycle_service
+        .get_bulk_operation(project_id)
+        .await?;
+    Ok(Json(progress))
+}
@@ -0,0 +1,342 @@
+//! Promotion Handlers
+//!
+//! API endpoints to promote a deployment to another environment without rebuilding
+//! it, review promotions waiting on an approval gate, and follow promotion lineage.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    routing::{get, post},
+    Json, Router,
+};
+use serde::{Deserialize, Serialize};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use temps_core::UtcDateTime;
+use temps_entities::deployment_promotions;
+use tracing::info;
+use utoipa::{OpenApi, ToSchema};
+
+use crate::services::PromotionService;
+
+/// App state for promotion handlers
+pub struct PromotionAppState {
+    pub promotion_service: Arc<PromotionService>,
+}
+
+/// Request to promote a deployment
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct PromoteDeploymentRequest {
+    /// Environment to deploy the deployment's image to
+    #[schema(example = 2)]
+    pub target_environment_id: i32,
+}
+
+/// Request to approve or reject a pending promotion
+#[derive(Debug, Default, Deserialize, ToSchema)]
+pub struct ReviewPromotionRequest {
+    /// Note recorded with the decision
+    #[serde(default)]
+    #[schema(example = "Verified on staging")]
+    pub comment: Option<String>,
+}
+
+/// A promotion of a deployment to another environment
+#[derive(Debug, Serialize, ToSchema)]
+pub struct PromotionResponse {
+    pub id: i32,
+    pub project_id: i32,
+    /// Deployment whose image was promoted
+    pub source_deployment_id: i32,
+    pub source_environment_id: i32,
+    pub target_environment_id: i32,
+    /// Deployment the promotion created in the target environment
+    pub target_deployment_id: Option<i32>,
+    #[schema(example = "temps-my-app:42")]
+    pub image_name: Option<String>,
+    /// Image ID that is deployed
+    #[schema(example = "sha256:4b825dc642cb6eb9a060e54bf8d69288fbee4904")]
+    pub image_digest: Option<String>,
+    /// pending_approval, deploying or rejected
+    #[schema(example = "pending_approval")]
+    pub status: String,
+    pub requested_by: Option<i32>,
+    pub reviewed_by: Option<i32>,
+    #[schema(value_type = Option<String>, format = "date-time", example = "2024-12-01T12:30:00Z")]
+    pub reviewed_at: Option<UtcDateTime>,
+    pub review_comment: Option<String>,
+    #[schema(value_type = String, format = "date-time", example = "2024-12-01T12:00:00Z")]
+    pub created_at: UtcDateTime,
+    #[schema(value_type = String, format = "date-time", example = "2024-12-01T12:30:00Z")]
+    pub updated_at: UtcDateTime,
+}
+
+impl From<deployment_promotions::Model> for PromotionResponse {
+    fn from(model: deployment_promotions::Model) -> Self {
+        Self {
+            id: model.id,
+            project_id: model.project_id,
+            source_deployment_id: model.source_deployment_id,
+            source_environment_id: model.source_environment_id,
+            target_environment_id: model.target_environment_id,
+            target_deployment_id: model.target_deployment_id,
+            image_name: model.image_name,
+            image_digest: model.image_digest,
+            status: model.status,
+            requested_by: model.requested_by,
+            reviewed_by: model.reviewed_by,
+            reviewed_at: model.reviewed_at,
+            review_comment: model.review_comment,
+            created_at: model.created_at,
+            updated_at: model.updated_at,
+        }
+    }
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(
+        promote_deployment,
+        list_promotions,
+        get_deployment_lineage,
+        approve_promotion,
+        reject_promotion
+    ),
+    components(schemas(PromoteDeploymentRequest, ReviewPromotionRequest, PromotionResponse)),
+    info(
+        title = "Promotions API",
+        description = "API endpoints for promoting deployments between environments \
+        without rebuilding them.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Deployments", description = "Deployment management endpoints")
+    )
+)]
+pub struct PromotionsApiDoc;
+
+pub fn configure_routes() -> Router<Arc<PromotionAppState>> {
+    Router::new()
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/promote",
+            post(promote_deployment),
+        )
+        .route(
+            "/projects/{project_id}/deployments/{deployment_id}/promotions",
+            get(get_deployment_lineage),
+        )
+        .route("/projects/{project_id}/promotions", get(list_promotions))
+        .route(
+            "/projects/{project_id}/promotions/{promotion_id}/approve",
+            post(approve_promotion),
+        )
+        .route(
+            "/projects/{project_id}/promotions/{promotion_id}/reject",
+            post(reject_promotion),
+        )
+}
+
+/// Promote a deployment to another environment
+///
+/// Deploys the exact image the deployment runs — pinned by image ID — to the target
+/// environment without rebuilding it. The promoted deployment uses the target
+/// environment's variables and settings, plus any variables the target carries over
+/// on promotion. If the target requires approval, the promotion waits for review.
+#[utoipa::path(
+    tag = "Deployments",
+    post,
+    path = "/projects/{project_id}/deployments/{deployment_id}/promote",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment to promote")
+    ),
+    request_body = PromoteDeploymentRequest,
+    responses(
+        (status = 201, description = "Promotion deploying or waiting for approval", body = PromotionResponse),
+        (status = 400, description = "Deployment can't be promoted to that environment"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Deployment or environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn promote_deployment(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<PromotionAppState>>,
+    Path((project_id, deployment_id)): Path<(i32, i32)>,
+    Json(request): Json<PromoteDeploymentRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsCreate);
+
+    info!(
+        "Promoting deployment {} of project {} to environment {} (user {})",
+        deployment_id,
+        project_id,
+        request.target_environment_id,
+        auth.user_id()
+    );
+    let promotion = app_state
+        .promotion_service
+        .promote(
+            project_id,
+            deployment_id,
+            request.target_environment_id,
+            auth.user_id(),
+        )
+        .await?;
+    Ok((
+        StatusCode::CREATED,
+        Json(PromotionResponse::from(promotion)),
+    ))
+}
+
+/// List a project's promotions, newest first
+#[utoipa::path(
+    tag = "Deployments",
+    get,
+    path = "/projects/{project_id}/promotions",
+    params(
+        ("project_id" = i32, Path, description = "Project ID")
+    ),
+    responses(
+        (status = 200, description = "Promotions", body = Vec<PromotionResponse>),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn list_promotions(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<PromotionAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let promotions = app_state
+        .promotion_service
+        .list_promotions(project_id)
+        .await?;
+    Ok(Json(
+        promotions
+            .into_iter()
+            .map(PromotionResponse::from)
+            .collect::<Vec<_>>(),
+    ))
+}
+
+/// Get the promotion lineage of a deployment
+///
+/// The promotions the deployment was promoted by, and the ones promoting it further.
+#[utoipa::path(
+    tag = "Deployments",
+    get,
+    path = "/projects/{project_id}/deployments/{deployment_id}/promotions",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("deployment_id" = i32, Path, description = "Deployment ID")
+    ),
+    responses(
+        (status = 200, description = "Promotions involving the deployment", body = Vec<PromotionResponse>),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_deployment_lineage(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<PromotionAppState>>,
+    Path((project_id, deployment_id)): Path<(i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsRead);
+
+    let promotions = app_state
+        .promotion_service
+        .deployment_lineage(project_id, deployment_id)
+        .await?;
+    Ok(Json(
+        promotions
+            .into_iter()
+            .map(PromotionResponse::from)
+            .collect::<Vec<_>>(),
+    ))
+}
+
+/// Approve a pending promotion and deploy it
+///
+/// The requester of a promotion cannot approve it.
+#[utoipa::path(
+    tag = "Deployments",
+    post,
+    path = "/projects/{project_id}/promotions/{promotion_id}/approve",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("promotion_id" = i32, Path, description = "Promotion ID")
+    ),
+    request_body = ReviewPromotionRequest,
+    responses(
+        (status = 200, description = "Promotion approved and deploying", body = PromotionResponse),
+        (status = 400, description = "Promotion was requested by the reviewer"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Promotion not found"),
+        (status = 409, description = "Promotion is not pending (anymore)"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn approve_promotion(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<PromotionAppState>>,
+    Path((project_id, promotion_id)): Path<(i32, i32)>,
+    Json(request): Json<ReviewPromotionRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsWrite);
+
+    let promotion = app_state
+        .promotion_service
+        .approve(project_id, promotion_id, auth.user_id(), request.comment)
+        .await?;
+    Ok(Json(PromotionResponse::from(promotion)))
+}
+
+/// Reject a pending promotion
+#[utoipa::path(
+    tag = "Deployments",
+    post,
+    path = "/projects/{project_id}/promotions/{promotion_id}/reject",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("promotion_id" = i32, Path, description = "Promotion ID")
+    ),
+    request_body = ReviewPromotionRequest,
+    responses(
+        (status = 200, description = "Promotion rejected", body = PromotionResponse),
+        (status = 400, description = "Promotion was requested by the reviewer"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Promotion not found"),
+        (status = 409, description = "Promotion is not pending (anymore)"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn reject_promotion(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<PromotionAppState>>,
+    Path((project_id, promotion_id)): Path<(i32, i32)>,
+    Json(request): Json<ReviewPromotionRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DeploymentsWrite);
+
+    let promotion = app_state
+        .promotion_service
+        .reject(project_id, promotion_id, auth.user_id(), request.comment)
+        .await?;
+    Ok(Json(PromotionResponse::from(promotion)))
+}
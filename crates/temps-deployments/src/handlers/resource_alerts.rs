@@ -0,0 +1,197 @@
+//! Resource Alert Handlers
+//!
+//! API endpoints to manage an environment's CPU and memory alert rules.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    routing::{get, put},
+    Json, Router,
+};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use utoipa::OpenApi;
+
+use crate::services::{ResourceAlertRuleRequest, ResourceAlertRuleResponse, ResourceAlertService};
+
+/// App state for resource alert handlers
+pub struct ResourceAlertAppState {
+    pub resource_alert_service: Arc<ResourceAlertService>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(
+        list_resource_alert_rules,
+        create_resource_alert_rule,
+        update_resource_alert_rule,
+        delete_resource_alert_rule
+    ),
+    components(schemas(ResourceAlertRuleRequest, ResourceAlertRuleResponse)),
+    info(
+        title = "Resource Alerts API",
+        description = "API endpoints for alerting on the CPU and memory usage of environments.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Environments", description = "Environment management endpoints")
+    )
+)]
+pub struct ResourceAlertApiDoc;
+
+pub fn configure_routes() -> Router<Arc<ResourceAlertAppState>> {
+    Router::new()
+        .route(
+            "/projects/{project_id}/environments/{environment_id}/resource-alerts",
+            get(list_resource_alert_rules).post(create_resource_alert_rule),
+        )
+        .route(
+            "/projects/{project_id}/environments/{environment_id}/resource-alerts/{rule_id}",
+            put(update_resource_alert_rule).delete(delete_resource_alert_rule),
+        )
+}
+
+/// List an environment's resource alert rules
+#[utoipa::path(
+    tag = "Environments",
+    get,
+    path = "/projects/{project_id}/environments/{environment_id}/resource-alerts",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment ID")
+    ),
+    responses(
+        (status = 200, description = "Alert rules", body = Vec<ResourceAlertRuleResponse>),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn list_resource_alert_rules(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ResourceAlertAppState>>,
+    Path((project_id, environment_id)): Path<(i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsRead);
+
+    let rules = app_state
+        .resource_alert_service
+        .list_rules(project_id, environment_id)
+        .await?;
+    Ok(Json(rules))
+}
+
+/// Create a resource alert rule
+///
+/// The rule fires once the metric has stayed above the threshold for the rule's
+/// duration, opening an incident and sending a notification, and resolves once it
+/// has stayed below the resolve threshold for as long.
+#[utoipa::path(
+    tag = "Environments",
+    post,
+    path = "/projects/{project_id}/environments/{environment_id}/resource-alerts",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment ID")
+    ),
+    request_body = ResourceAlertRuleRequest,
+    responses(
+        (status = 201, description = "Alert rule created", body = ResourceAlertRuleResponse),
+        (status = 400, description = "Invalid rule"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn create_resource_alert_rule(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ResourceAlertAppState>>,
+    Path((project_id, environment_id)): Path<(i32, i32)>,
+    Json(request): Json<ResourceAlertRuleRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsWrite);
+
+    let rule = app_state
+        .resource_alert_service
+        .create_rule(project_id, environment_id, request, auth.user_id())
+        .await?;
+    Ok((StatusCode::CREATED, Json(rule)))
+}
+
+/// Replace a resource alert rule
+///
+/// Disabling a firing rule resolves its incident.
+#[utoipa::path(
+    tag = "Environments",
+    put,
+    path = "/projects/{project_id}/environments/{environment_id}/resource-alerts/{rule_id}",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment ID"),
+        ("rule_id" = i32, Path, description = "Alert rule ID")
+    ),
+    request_body = ResourceAlertRuleRequest,
+    responses(
+        (status = 200, description = "Alert rule updated", body = ResourceAlertRuleResponse),
+        (status = 400, description = "Invalid rule"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Alert rule not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn update_resource_alert_rule(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ResourceAlertAppState>>,
+    Path((project_id, environment_id, rule_id)): Path<(i32, i32, i32)>,
+    Json(request): Json<ResourceAlertRuleRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsWrite);
+
+    let rule = app_state
+        .resource_alert_service
+        .update_rule(project_id, environment_id, rule_id, request)
+        .await?;
+    Ok(Json(rule))
+}
+
+/// Delete a resource alert rule, resolving its incident if it is firing
+#[utoipa::path(
+    tag = "Environments",
+    delete,
+    path = "/projects/{project_id}/environments/{environment_id}/resource-alerts/{rule_id}",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment ID"),
+        ("rule_id" = i32, Path, description = "Alert rule ID")
+    ),
+    responses(
+        (status = 204, description = "Alert rule deleted"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Alert rule not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn delete_resource_alert_rule(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ResourceAlertAppState>>,
+    Path((project_id, environment_id, rule_id)): Path<(i32, i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsWrite);
+
+    app_state
+        .resource_alert_service
+        .delete_rule(project_id, environment_id, rule_id)
+        .await?;
+    Ok(StatusCode::NO_CONTENT)
+}
@@ -0,0 +1,145 @@
+//! Resource Garbage Collection Handlers
+//!
+//! API endpoints to preview (dry run) and manually trigger garbage collection of
+//! orphaned Docker resources owned by Temps, and to inspect current disk usage.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Query, State},
+    response::IntoResponse,
+    routing::{get, post},
+    Json, Router,
+};
+use serde::Deserialize;
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use tracing::info;
+use utoipa::{OpenApi, ToSchema};
+
+use crate::services::{
+    DiskSpaceGuard, DiskUsageBreakdown, DiskUsageReport, GcItem, GcReport, GcResourceKind,
+    ResourceGcService,
+};
+
+/// App state for resource GC handlers
+pub struct ResourceGcAppState {
+    pub resource_gc_service: Arc<ResourceGcService>,
+    pub disk_space_guard: Arc<DiskSpaceGuard>,
+}
+
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct RunGcQuery {
+    /// Only report what would be removed (default: false)
+    #[serde(default)]
+    pub dry_run: bool,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(get_gc_report, run_gc, get_disk_usage),
+    components(schemas(
+        GcReport,
+        GcItem,
+        GcResourceKind,
+        RunGcQuery,
+        DiskUsageReport,
+        DiskUsageBreakdown
+    )),
+    info(
+        title = "Resource Garbage Collection API",
+        description = "API endpoints for inspecting and reclaiming disk space used by \
+        orphaned containers, images and networks owned by Temps.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "System", description = "System maintenance operations")
+    )
+)]
+pub struct ResourceGcApiDoc;
+
+pub fn configure_routes() -> Router<Arc<ResourceGcAppState>> {
+    Router::new()
+        .route("/system/gc", get(get_gc_report))
+        .route("/system/gc/run", post(run_gc))
+        .route("/system/disk-usage", get(get_disk_usage))
+}
+
+/// Preview orphaned resources that garbage collection would remove (dry run)
+#[utoipa::path(
+    tag = "System",
+    get,
+    path = "/system/gc",
+    responses(
+        (status = 200, description = "Dry-run garbage collection report", body = GcReport),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_gc_report(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ResourceGcAppState>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    let report = app_state.resource_gc_service.run(true).await?;
+    Ok(Json(report))
+}
+
+/// Manually trigger garbage collection of orphaned resources
+#[utoipa::path(
+    tag = "System",
+    post,
+    path = "/system/gc/run",
+    params(
+        ("dry_run" = Option<bool>, Query, description = "Only report what would be removed (default: false)")
+    ),
+    responses(
+        (status = 200, description = "Garbage collection report", body = GcReport),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn run_gc(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ResourceGcAppState>>,
+    Query(query): Query<RunGcQuery>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    info!(
+        "Manual garbage collection triggered by user {} (dry_run: {})",
+        auth.user_id(),
+        query.dry_run
+    );
+
+    let report = app_state.resource_gc_service.run(query.dry_run).await?;
+    Ok(Json(report))
+}
+
+/// Get current disk usage of the data disk, broken down by images, volumes, logs and backups
+#[utoipa::path(
+    tag = "System",
+    get,
+    path = "/system/disk-usage",
+    responses(
+        (status = 200, description = "Current disk usage", body = DiskUsageReport),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_disk_usage(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<ResourceGcAppState>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemRead);
+
+    let report = app_state.disk_space_guard.usage().await?;
+    Ok(Json(report))
+}
@@ -0,0 +1,292 @@
+//! Runtime Config Handlers
+//!
+//! API endpoints to change an environment's non-secret runtime config values, such as
+//! feature flags, without a redeploy, and to see how each change was applied.
+
+use std::collections::BTreeMap;
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, State},
+    response::IntoResponse,
+    routing::{get, put},
+    Json, Router,
+};
+use serde::{Deserialize, Serialize};
+use temps_auth::{permission_guard, AuthContext, RequireAuth};
+use temps_core::problemdetails::Problem;
+use temps_core::{AuditContext, AuditLogger, RequestMetadata, UtcDateTime};
+use temps_entities::runtime_config_changes;
+use tracing::{error, info};
+use utoipa::{OpenApi, ToSchema};
+
+use super::audit::RuntimeConfigChangedAudit;
+use crate::services::RuntimeConfigService;
+
+/// App state for runtime config handlers
+pub struct RuntimeConfigAppState {
+    pub runtime_config_service: Arc<RuntimeConfigService>,
+    pub audit_service: Arc<dyn AuditLogger>,
+}
+
+/// Request to set a runtime config value
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct SetRuntimeConfigValueRequest {
+    #[schema(example = "on")]
+    pub value: String,
+}
+
+/// A change to a runtime config value
+#[derive(Debug, Serialize, ToSchema)]
+pub struct RuntimeConfigChangeResponse {
+    pub id: i32,
+    pub environment_id: i32,
+    #[schema(example = "FEATURE_CHECKOUT")]
+    pub key: String,
+    /// New value; null when the value was removed
+    #[schema(example = "on")]
+    pub value: Option<String>,
+    /// applied, or failed when the containers couldn't be reloaded or restarted
+    #[schema(example = "applied")]
+    pub status: String,
+    /// reload, restart, or deferred when no running container had the config file
+    /// mounted yet
+    #[schema(example = "reload")]
+    pub method: String,
+    pub error: Option<String>,
+    pub changed_by: i32,
+    #[schema(value_type = String, format = "date-time", example = "2024-12-01T12:00:00Z")]
+    pub created_at: UtcDateTime,
+}
+
+impl From<runtime_config_changes::Model> for RuntimeConfigChangeResponse {
+    fn from(model: runtime_config_changes::Model) -> Self {
+        Self {
+            id: model.id,
+            environment_id: model.environment_id,
+            key: model.key,
+            value: model.value,
+            status: model.status,
+            method: model.method,
+            error: model.error,
+            changed_by: model.changed_by,
+            created_at: model.created_at,
+        }
+    }
+}
+
+/// An environment's runtime config
+#[derive(Debug, Serialize, ToSchema)]
+pub struct RuntimeConfigResponse {
+    /// Values by key, as the containers read them from /etc/temps/runtime-config.json
+    #[schema(example = json!({"FEATURE_CHECKOUT": "on", "LOG_LEVEL": "info"}))]
+    pub values: BTreeMap<String, String>,
+    /// Recent changes, latest first
+    pub changes: Vec<RuntimeConfigChangeResponse>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(get_runtime_config, set_runtime_config_value, delete_runtime_config_value),
+    components(schemas(
+        SetRuntimeConfigValueRequest,
+        RuntimeConfigChangeResponse,
+        RuntimeConfigResponse
+    )),
+    info(
+        title = "Runtime Config API",
+        description = "API endpoints for changing an environment's runtime config values \
+        without a redeploy.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "Deployments", description = "Deployment management endpoints")
+    )
+)]
+pub struct RuntimeConfigApiDoc;
+
+pub fn configure_routes() -> Router<Arc<RuntimeConfigAppState>> {
+    Router::new()
+        .route(
+            "/projects/{project_id}/environments/{environment_id}/runtime-config",
+            get(get_runtime_config),
+        )
+        .route(
+            "/projects/{project_id}/environments/{environment_id}/runtime-config/{key}",
+            put(set_runtime_config_value).delete(delete_runtime_config_value),
+        )
+}
+
+/// Get an environment's runtime config values and recent changes
+#[utoipa::path(
+    tag = "Deployments",
+    get,
+    path = "/projects/{project_id}/environments/{environment_id}/runtime-config",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment ID")
+    ),
+    responses(
+        (status = 200, description = "Runtime config", body = RuntimeConfigResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_runtime_config(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<RuntimeConfigAppState>>,
+    Path((project_id, environment_id)): Path<(i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsRead);
+
+    let values = app_state
+        .runtime_config_service
+        .list_values(project_id, environment_id)
+        .await?;
+    let changes = app_state
+        .runtime_config_service
+        .list_changes(project_id, environment_id)
+        .await?;
+    Ok(Json(RuntimeConfigResponse {
+        values,
+        changes: changes
+            .into_iter()
+            .map(RuntimeConfigChangeResponse::from)
+            .collect(),
+    }))
+}
+
+/// Set a runtime config value
+///
+/// The running containers get the new value without a rebuild: per the environment's
+/// `configReload` setting they are signalled to reload, watch the file themselves, or
+/// are restarted in place. A reload that fails falls back to a restart. Containers
+/// deployed before runtime config get the value with the next deploy. Values are not
+/// secret; use environment variables for secrets.
+#[utoipa::path(
+    tag = "Deployments",
+    put,
+    path = "/projects/{project_id}/environments/{environment_id}/runtime-config/{key}",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment ID"),
+        ("key" = String, Path, description = "Runtime config key")
+    ),
+    request_body = SetRuntimeConfigValueRequest,
+    responses(
+        (status = 200, description = "Value saved; the change says how it was applied", body = RuntimeConfigChangeResponse),
+        (status = 400, description = "Invalid key or value"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn set_runtime_config_value(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<RuntimeConfigAppState>>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Path((project_id, environment_id, key)): Path<(i32, i32, String)>,
+    Json(request): Json<SetRuntimeConfigValueRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsWrite);
+
+    let change = change_runtime_config_value(
+        &app_state,
+        &auth,
+        &metadata,
+        project_id,
+        environment_id,
+        key,
+        Some(request.value),
+    )
+    .await?;
+    Ok(Json(change))
+}
+
+/// Remove a runtime config value
+///
+/// Applied to the running containers like a new value.
+#[utoipa::path(
+    tag = "Deployments",
+    delete,
+    path = "/projects/{project_id}/environments/{environment_id}/runtime-config/{key}",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("environment_id" = i32, Path, description = "Environment ID"),
+        ("key" = String, Path, description = "Runtime config key")
+    ),
+    responses(
+        (status = 200, description = "Value removed; the change says how it was applied", body = RuntimeConfigChangeResponse),
+        (status = 400, description = "Invalid key"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Environment or value not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn delete_runtime_config_value(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<RuntimeConfigAppState>>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Path((project_id, environment_id, key)): Path<(i32, i32, String)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsWrite);
+
+    let change = change_runtime_config_value(
+        &app_state,
+        &auth,
+        &metadata,
+        project_id,
+        environment_id,
+        key,
+        None,
+    )
+    .await?;
+    Ok(Json(change))
+}
+
+async fn change_runtime_config_value(
+    app_state: &RuntimeConfigAppState,
+    auth: &AuthContext,
+    metadata: &RequestMetadata,
+    project_id: i32,
+    environment_id: i32,
+    key: String,
+    value: Option<String>,
+) -> Result<RuntimeConfigChangeResponse, Problem> {
+    info!(
+        "Changing runtime config '{}' of environment {} (user {})",
+        key,
+        environment_id,
+        auth.user_id()
+    );
+    let change = app_state
+        .runtime_config_service
+        .set_value(project_id, environment_id, &key, value, auth.user_id())
+        .await?;
+
+    let audit_event = RuntimeConfigChangedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        project_id,
+        environment_id,
+        key: change.key.clone(),
+        removed: change.value.is_none(),
+        method: change.method.clone(),
+        status: change.status.clone(),
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit_event).await {
+        error!("Failed to create audit log: {:?}", e);
+    }
+
+    Ok(RuntimeConfigChangeResponse::from(change))
+}
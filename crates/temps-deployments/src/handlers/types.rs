@@ -1,7 +1,7 @@
 use std::sync::Arc;
 
 use crate::services::database_cron_service::DatabaseCronConfigService;
-use crate::services::ExternalDeploymentManager;
+use crate::services::{ExternalDeploymentManager, LogStreamLimiter};
 use crate::DeploymentService;
 
 pub struct AppState {
@@ -9,10 +9,14 @@ pub struct AppState {
     pub log_service: Arc<temps_logs::LogService>,
     pub cron_service: Arc<DatabaseCronConfigService>,
     pub external_deployment_manager: Arc<ExternalDeploymentManager>,
+    pub audit_service: Arc<dyn temps_core::AuditLogger>,
+    pub log_streams: Arc<LogStreamLimiter>,
 }
 
 use crate::services::types::Deployment;
+use crate::services::{deploy_failure, DeployFailureReason, DeployOutcome};
 use serde::{Deserialize, Serialize};
+use temps_core::UtcDateTime;
 use utoipa::ToSchema;
 
 #[derive(Deserialize, ToSchema)]
@@ -360,6 +364,8 @@ pub struct ContainerLogsQuery {
     /// Include timestamps in log output (default: false)
     #[serde(default = "default_timestamps")]
     pub timestamps: bool,
+    /// Collapse consecutive identical lines (default: the environment's container log settings)
+    pub coalesce: Option<bool>,
 }
 
 fn default_timestamps() -> bool {
@@ -371,6 +377,182 @@ pub struct JobLogsQuery {
     pub lines: Option<usize>,
 }
 
+/// Which entries around a job log line to fetch
+#[derive(Deserialize, ToSchema)]
+pub struct JobLogContextQuery {
+    /// Line to fetch the context of
+    pub line: u64,
+    /// Lines before it (default: 10, or up to 500 within `window_seconds`)
+    pub before: Option<usize>,
+    /// Lines after it (default: 10, or up to 500 within `window_seconds`)
+    pub after: Option<usize>,
+    /// Only entries logged at most this many seconds before or after it (max: 3600)
+    pub window_seconds: Option<i64>,
+}
+
+#[derive(Serialize, Deserialize, ToSchema)]
+pub struct JobLogEntryResponse {
+    pub line: u64,
+    /// info, success, warning or error
+    #[schema(example = "error")]
+    pub level: String,
+    pub message: String,
+    pub timestamp: i64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub metadata: Option<serde_json::Value>,
+}
+
+impl From<temps_logs::LogEntry> for JobLogEntryResponse {
+    fn from(entry: temps_logs::LogEntry) -> Self {
+        let level = match entry.level {
+            temps_logs::LogLevel::Info => "info",
+            temps_logs::LogLevel::Success => "success",
+            temps_logs::LogLevel::Warning => "warning",
+            temps_logs::LogLevel::Error => "error",
+        };
+        Self {
+            line: entry.line,
+            level: level.to_string(),
+            message: entry.message,
+            timestamp: entry.timestamp.timestamp_millis(),
+            metadata: entry.metadata,
+        }
+    }
+}
+
+/// A job log line and the entries around it, in log order
+#[derive(Serialize, Deserialize, ToSchema)]
+pub struct JobLogContextResponse {
+    pub line: u64,
+    pub entries: Vec<JobLogEntryResponse>,
+}
+
+/// One job of a deployment and how long it took
+#[derive(Serialize, ToSchema)]
+pub struct DeployPhaseResponse {
+    #[schema(example = "build_image")]
+    pub job_id: String,
+    pub name: String,
+    #[schema(example = "BuildImageJob")]
+    pub job_type: String,
+    /// pending, waiting, running, success, failure, cancelled or skipped
+    #[schema(example = "success")]
+    pub status: String,
+    pub started_at: Option<i64>,
+    pub finished_at: Option<i64>,
+    /// Time the job ran; up to now while it runs
+    pub duration_ms: Option<i64>,
+    pub error_message: Option<String>,
+}
+
+impl DeployPhaseResponse {
+    pub fn from_job(job: temps_entities::deployment_jobs::Model, now: UtcDateTime) -> Self {
+        Self {
+            duration_ms: duration_ms(job.started_at, job.finished_at, now),
+            job_id: job.job_id,
+            name: job.name,
+            job_type: job.job_type,
+            status: job.status.to_string(),
+            started_at: job.started_at.map(|t| t.timestamp_millis()),
+            finished_at: job.finished_at.map(|t| t.timestamp_millis()),
+            error_message: job.error_message,
+        }
+    }
+}
+
+/// Milliseconds from the start to the end, or to now when not ended yet
+fn duration_ms(
+    started_at: Option<UtcDateTime>,
+    finished_at: Option<UtcDateTime>,
+    now: UtcDateTime,
+) -> Option<i64> {
+    started_at.map(|started_at| (finished_at.unwrap_or(now) - started_at).num_milliseconds())
+}
+
+/// Status of a deployment for clients waiting on it, such as CI pipelines
+///
+/// Poll it until `finished` is true; `outcome` and `failure_reason` are stable codes
+/// to branch on, `state` is the deployment's own, finer-grained state.
+#[derive(Serialize, ToSchema)]
+pub struct DeployStatusResponse {
+    pub deployment_id: i32,
+    pub project_id: i32,
+    pub environment_id: i32,
+    #[schema(example = "failed")]
+    pub state: String,
+    pub outcome: DeployOutcome,
+    /// Whether the outcome is final
+    pub finished: bool,
+    /// Why the deployment failed or was cancelled
+    pub failure_reason: Option<DeployFailureReason>,
+    pub failure_message: Option<String>,
+    /// Job the deployment failed in, whose logs tell why
+    pub failed_job_id: Option<String>,
+    pub url: String,
+    pub created_at: i64,
+    pub started_at: Option<i64>,
+    pub finished_at: Option<i64>,
+    /// Time the deployment ran; up to now while it runs
+    pub duration_ms: Option<i64>,
+    /// Jobs in execution order
+    pub phases: Vec<DeployPhaseResponse>,
+}
+
+impl DeployStatusResponse {
+    pub fn new(deployment: Deployment, jobs: Vec<temps_entities::deployment_jobs::Model>) -> Self {
+        let now = chrono::Utc::now();
+        let outcome = DeployOutcome::from_state(&deployment.status);
+        let failure = deploy_failure(outcome, deployment.cancelled_reason.as_deref(), &jobs);
+        Self {
+            deployment_id: deployment.id,
+            project_id: deployment.project_id,
+            environment_id: deployment.environment_id,
+            state: deployment.status,
+            outcome,
+            finished: outcome.is_finished(),
+            failure_reason: failure.as_ref().map(|failure| failure.reason),
+            failure_message: failure.as_ref().and_then(|failure| failure.message.clone()),
+            failed_job_id: failure.and_then(|failure| failure.job_id),
+            url: deployment.url,
+            created_at: deployment.created_at.timestamp_millis(),
+            started_at: deployment.started_at.map(|t| t.timestamp_millis()),
+            finished_at: deployment.finished_at.map(|t| t.timestamp_millis()),
+            duration_ms: duration_ms(deployment.started_at, deployment.finished_at, now),
+            phases: jobs
+                .into_iter()
+                .map(|job| DeployPhaseResponse::from_job(job, now))
+                .collect(),
+        }
+    }
+}
+
+/// Which logs of a deployment to fetch
+#[derive(Deserialize, ToSchema)]
+pub struct DeploymentLogsQuery {
+    /// Only the last this many entries of each job (default: all of them)
+    pub tail: Option<usize>,
+    /// Only the logs of this job
+    pub job_id: Option<String>,
+}
+
+/// Log entries of one job of a deployment
+#[derive(Serialize, ToSchema)]
+pub struct DeploymentJobLogsResponse {
+    pub job_id: String,
+    pub name: String,
+    pub status: String,
+    /// Entries the job logged, of which `entries` may be the tail
+    pub total_entries: usize,
+    pub entries: Vec<JobLogEntryResponse>,
+}
+
+/// Logs of a deployment's jobs, in execution order
+#[derive(Serialize, ToSchema)]
+pub struct DeploymentLogsResponse {
+    pub deployment_id: i32,
+    pub jobs: Vec<DeploymentJobLogsResponse>,
+}
+
 #[derive(Serialize, Deserialize, ToSchema)]
 pub struct Pipeline {
     pub id: i32,
@@ -546,6 +728,9 @@ pub struct ContainerInfoResponse {
     pub status: String,
     #[schema(example = "2025-10-12T12:15:47.609192Z")]
     pub created_at: String,
+    /// `sh.temps.*` labels: deployment, environment, commit, branch, trigger and image
+    #[schema(example = json!({"sh.temps.deployment-id": "42", "sh.temps.commit-sha": "4b825dc"}))]
+    pub labels: std::collections::HashMap<String, String>,
 }
 
 impl From<temps_deployer::ContainerInfo> for ContainerInfoResponse {
@@ -556,6 +741,7 @@ impl From<temps_deployer::ContainerInfo> for ContainerInfoResponse {
             image_name: info.image_name,
             status: info.status.to_string(),
             created_at: info.created_at.to_rfc3339(),
+            labels: temps_deployer::labels::temps_labels(&info.labels),
         }
     }
 }
@@ -591,6 +777,9 @@ pub struct ContainerDetailResponse {
     /// Resource limits
     #[schema(nullable = true)]
     pub resource_limits: Option<ResourceLimitsResponse>,
+    /// `sh.temps.*` labels: deployment, environment, commit, branch, trigger and image
+    #[schema(example = json!({"sh.temps.deployment-id": "42", "sh.temps.commit-sha": "4b825dc"}))]
+    pub labels: std::collections::HashMap<String, String>,
 }
 
 /// Environment variable with masked sensitive values
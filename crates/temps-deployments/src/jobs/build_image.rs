@@ -11,11 +11,23 @@ use std::sync::Arc;
 use temps_core::{
     JobResult, WorkflowCancellationProvider, WorkflowContext, WorkflowError, WorkflowTask,
 };
-use temps_deployer::{BuildRequest, ImageBuilder};
+use temps_deployer::build_context::{format_size, BuildContext};
+use temps_deployer::{BuildRequest, BuildRequestWithCallback, ImageBuilder, ImagePullPolicy};
+use temps_entities::deployment_config::{
+    normalize_repo_path, BuildCacheConfig, BuilderConfig, BuilderKind, SharedBuildConfig,
+    SHARED_BUILD_IMAGE_ARG,
+};
+use temps_entities::deployments::{BaseImageDigest, BuildPhaseTimings};
 use temps_logs::{LogLevel, LogService};
 use temps_presets;
 use tokio::time::{sleep, Duration};
 
+use super::builders::{self, SourceBuild};
+use super::shared_stage::{self, SharedStageLocks};
+
+/// Most files a dry run lists of the build context
+const MAX_LISTED_CONTEXT_FILES: usize = 1000;
+
 /// Typed output from DownloadRepoJob
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct RepositoryOutput {
@@ -69,6 +81,8 @@ pub struct ImageOutput {
     pub size_bytes: u64,
     pub build_context: PathBuf,
     pub dockerfile_path: PathBuf,
+    /// Where the build spent its time, when the builder reports its steps
+    pub phases: Option<BuildPhaseTimings>,
 }
 
 impl ImageOutput {
@@ -110,10 +124,52 @@ impl ImageOutput {
             size_bytes,
             build_context: PathBuf::from(build_context_str),
             dockerfile_path: PathBuf::from(dockerfile_path_str),
+            phases: context
+                .get_output(build_job_id, "build_phases")
+                .ok()
+                .flatten(),
         })
     }
 }
 
+fn build_phase_timings(
+    phases: temps_deployer::build_phases::BuildPhaseTimings,
+) -> BuildPhaseTimings {
+    BuildPhaseTimings {
+        context_upload_ms: phases.context_upload_ms,
+        base_image_ms: phases.base_image_ms,
+        dependency_install_ms: phases.dependency_install_ms,
+        compile_ms: phases.compile_ms,
+        image_export_ms: phases.image_export_ms,
+        other_ms: phases.other_ms,
+        push_ms: phases.push_ms,
+        steps: phases.steps,
+        cached_steps: phases.cached_steps,
+    }
+}
+
+/// One line summary of the phases that took any time, for the build log
+fn describe_phases(phases: &BuildPhaseTimings) -> String {
+    let mut parts: Vec<String> = [
+        ("context upload", Some(phases.context_upload_ms)),
+        ("base images", Some(phases.base_image_ms)),
+        ("dependency install", Some(phases.dependency_install_ms)),
+        ("compile", Some(phases.compile_ms)),
+        ("image export", Some(phases.image_export_ms)),
+        ("other steps", Some(phases.other_ms)),
+        ("push from build node", phases.push_ms),
+    ]
+    .into_iter()
+    .filter_map(|(name, ms)| ms.filter(|ms| *ms > 0).map(|ms| (name, ms)))
+    .map(|(name, ms)| format!("{} {:.1}s", name, ms as f64 / 1000.0))
+    .collect();
+    parts.push(format!(
+        "{} of {} steps cached",
+        phases.cached_steps, phases.steps
+    ));
+    parts.join(", ")
+}
+
 /// Configuration for building images
 #[derive(Debug, Clone)]
 pub struct BuildConfig {
@@ -123,6 +179,10 @@ pub struct BuildConfig {
     pub build_args_buildkit: Vec<(String, String)>,
     pub target_platform: Option<String>,
     pub cache_from: Vec<String>,
+    /// Whether base images are pulled even when a local copy exists
+    pub pull_policy: ImagePullPolicy,
+    /// Build args whose values are masked in the build log (build-only variables)
+    pub masked_build_args: Vec<String>,
 }
 
 impl Default for BuildConfig {
@@ -134,10 +194,32 @@ impl Default for BuildConfig {
             build_args_buildkit: Vec::new(),
             target_platform: None,
             cache_from: Vec::new(),
+            pull_policy: ImagePullPolicy::IfNotPresent,
+            masked_build_args: Vec::new(),
         }
     }
 }
 
+/// Placeholder for masked values in build logs
+const MASKED_VALUE: &str = "********";
+
+/// Replace every occurrence of the secret values in a log line
+fn mask_secrets(line: &str, secrets: &[String]) -> String {
+    secrets
+        .iter()
+        .filter(|secret| !secret.is_empty())
+        .fold(line.to_string(), |line, secret| {
+            line.replace(secret.as_str(), MASKED_VALUE)
+        })
+}
+
+/// Pre-build check that refuses to start a build when the host lacks capacity
+#[async_trait]
+pub trait BuildCapacityCheck: Send + Sync {
+    /// Returns `Err` with a user-facing message when the build must not start
+    async fn ensure_build_capacity(&self) -> Result<(), String>;
+}
+
 /// Job for building container images from source code
 pub struct BuildImageJob {
     job_id: String,
@@ -148,6 +230,18 @@ pub struct BuildImageJob {
     log_id: Option<String>,
     log_service: Option<Arc<LogService>>,
     preset: Option<String>, // Preset slug to generate Dockerfile if missing
+    capacity_check: Option<Arc<dyn BuildCapacityCheck>>,
+    build_cache: Option<BuildCacheConfig>,
+    builder: Option<BuilderConfig>,
+    /// Build command run in place of the one the preset or Nixpacks detects
+    build_command: Option<String>,
+    /// Build node the image is built on; the local daemon when unset
+    build_node: Option<String>,
+    /// List every file of the build context in the log, for dry runs
+    list_context: bool,
+    /// Build stage shared with other services of the repository
+    shared_build: Option<SharedBuildConfig>,
+    shared_stage_locks: Option<Arc<SharedStageLocks>>,
 }
 
 impl std::fmt::Debug for BuildImageJob {
@@ -178,6 +272,14 @@ impl BuildImageJob {
             log_id: None,
             log_service: None,
             preset: None,
+            capacity_check: None,
+            build_cache: None,
+            builder: None,
+            build_command: None,
+            build_node: None,
+            list_context: false,
+            shared_build: None,
+            shared_stage_locks: None,
         }
     }
 
@@ -216,6 +318,152 @@ impl BuildImageJob {
         self
     }
 
+    pub fn with_capacity_check(mut self, capacity_check: Arc<dyn BuildCapacityCheck>) -> Self {
+        self.capacity_check = Some(capacity_check);
+        self
+    }
+
+    pub fn with_build_cache(mut self, build_cache: BuildCacheConfig) -> Self {
+        self.build_cache = Some(build_cache);
+        self
+    }
+
+    /// Builder of the image; the Dockerfile builder when unset
+    pub fn with_builder(mut self, builder: BuilderConfig) -> Self {
+        self.builder = Some(builder);
+        self
+    }
+
+    pub fn with_build_command(mut self, build_command: String) -> Self {
+        self.build_command = Some(build_command);
+        self
+    }
+
+    pub fn with_build_node(mut self, build_node: String) -> Self {
+        self.build_node = Some(build_node);
+        self
+    }
+
+    pub fn with_list_context(mut self, list_context: bool) -> Self {
+        self.list_context = list_context;
+        self
+    }
+
+    /// Build stage shared with other services, built or reused before the image;
+    /// builds holding the same locks build each stage once
+    pub fn with_shared_build(
+        mut self,
+        shared_build: SharedBuildConfig,
+        shared_stage_locks: Arc<SharedStageLocks>,
+    ) -> Self {
+        self.shared_build = Some(shared_build);
+        self.shared_stage_locks = Some(shared_stage_locks);
+        self
+    }
+
+    /// Cache mounts for a generated Dockerfile: the caches of the languages detected
+    /// in the build context (unless turned off) plus the project's additional ones
+    fn cache_mounts(
+        &self,
+        build_context_dir: &Path,
+    ) -> Vec<temps_presets::build_cache::CacheMount> {
+        let config = self.build_cache.clone().unwrap_or_default();
+        let mut mounts = if config.language_caches() {
+            temps_presets::build_cache::detect_language_caches(build_context_dir)
+        } else {
+            Vec::new()
+        };
+        for extra in &config.extra_caches {
+            if mounts.iter().any(|m| m.name == extra.name) {
+                continue;
+            }
+            mounts.push(temps_presets::build_cache::CacheMount::new(
+                &extra.name,
+                &extra.target,
+                extra.env_var.as_deref(),
+            ));
+        }
+        mounts
+    }
+
+    /// Log the size of what will be sent to the builder, the directories making up
+    /// most of it when it's unusually large, and every file of it for dry runs
+    async fn report_build_context(
+        &self,
+        context: &WorkflowContext,
+        build_context: &Path,
+        dockerfile_path: &Path,
+        honor_gitignore: bool,
+    ) -> Result<(), WorkflowError> {
+        let context_path = build_context.to_path_buf();
+        let dockerfile = dockerfile_path.to_path_buf();
+        let collected = tokio::task::spawn_blocking(move || {
+            BuildContext::collect(&context_path, Some(&dockerfile), honor_gitignore)
+        })
+        .await
+        .map_err(|e| WorkflowError::Other(format!("Build context walk panicked: {}", e)))?;
+        let build_context = match collected {
+            Ok(build_context) => build_context,
+            Err(e) => {
+                // The builder walks the context again and reports what's wrong with it
+                self.log(
+                    context,
+                    format!("⚠️ warning: couldn't measure the build context: {}", e),
+                )
+                .await?;
+                return Ok(());
+            }
+        };
+
+        self.log(
+            context,
+            format!("📦 Build context: {}", build_context.summary()),
+        )
+        .await?;
+
+        if build_context.is_large() {
+            let largest = build_context
+                .largest_directories(5)
+                .into_iter()
+                .map(|(dir, bytes)| format!("{} ({})", dir, format_size(bytes)))
+                .collect::<Vec<_>>()
+                .join(", ");
+            self.log(
+                context,
+                format!(
+                    "⚠️ warning: the build context is unusually large; largest directories: {}. Add a .dockerignore to leave out what the image doesn't need",
+                    largest
+                ),
+            )
+            .await?;
+        }
+
+        if self.list_context {
+            self.log(context, "Files that would be uploaded:".to_string())
+                .await?;
+            let files: Vec<_> = build_context
+                .entries
+                .iter()
+                .filter(|entry| !entry.is_dir)
+                .collect();
+            for entry in files.iter().take(MAX_LISTED_CONTEXT_FILES) {
+                self.log(
+                    context,
+                    format!("  {} ({})", entry.path, format_size(entry.size)),
+                )
+                .await?;
+            }
+            if files.len() > MAX_LISTED_CONTEXT_FILES {
+                self.log(
+                    context,
+                    format!("  … and {} more", files.len() - MAX_LISTED_CONTEXT_FILES),
+                )
+                .await?;
+            }
+        }
+        Ok(())
+    }
+
     /// Write log message to both job-specific log file and context log writer
     async fn log(&self, context: &WorkflowContext, message: String) -> Result<(), WorkflowError> {
         // Detect log level from message content/emojis
@@ -330,21 +578,30 @@ impl BuildImageJob {
         context: &WorkflowContext,
         build_context_dir: &PathBuf,
         dockerfile_path: &PathBuf,
+        preset_override: Option<&str>,
     ) -> Result<std::collections::HashMap<String, String>, WorkflowError> {
         // If Dockerfile exists, we're done (no preset build args)
         if dockerfile_path.exists() {
+            if self.build_command.is_some() {
+                self.log(
+                    context,
+                    "⚠️ The repository's Dockerfile builds the image, the build command override is not used"
+                        .to_string(),
+                )
+                .await?;
+            }
             return Ok(std::collections::HashMap::new());
         }
 
         // Determine preset: either use provided slug or auto-detect
-        let preset_slug = if let Some(slug) = &self.preset {
+        let preset_slug = if let Some(slug) = preset_override.or(self.preset.as_deref()) {
             // Use provided preset
             self.log(
                 context,
                 format!("Dockerfile not found, generating from preset: {}", slug),
             )
             .await?;
-            slug.clone()
+            slug.to_string()
         } else {
             // Auto-detect preset from project files
             self.log(
@@ -441,8 +698,9 @@ impl BuildImageJob {
             .dockerfile(temps_presets::DockerfileConfig {
                 root_local_path: build_context_dir,
                 local_path: build_context_dir,
-                install_command: None,         // auto-detect
-                build_command: None,           // auto-detect
+                install_command: None, // auto-detect
+                // auto-detect unless overridden
+                build_command: self.build_command.as_deref(),
                 output_dir: None,              // auto-detect
                 build_vars: Some(&build_vars), // ARG directives for env vars
                 project_slug: &project_slug,
@@ -450,9 +708,30 @@ impl BuildImageJob {
             })
             .await;
 
+        // Keep package manager and compiler caches between builds of the project
+        let cache_mounts = self.cache_mounts(build_context_dir);
+        let content = temps_presets::build_cache::apply_cache_mounts(
+            &dockerfile_with_args.content,
+            context.project_id,
+            &cache_mounts,
+        );
+        if !cache_mounts.is_empty() {
+            self.log(
+                context,
+                format!(
+                    "Mounting build caches: {}",
+                    cache_mounts
+                        .iter()
+                        .map(|m| m.name.as_str())
+                        .collect::<Vec<_>>()
+                        .join(", ")
+                ),
+            )
+            .await?;
+        }
+
         // Write the Dockerfile
-        fs::write(dockerfile_path, &dockerfile_with_args.content)
-            .map_err(WorkflowError::IoError)?;
+        fs::write(dockerfile_path, &content).map_err(WorkflowError::IoError)?;
 
         self.log(
             context,
@@ -474,6 +753,165 @@ impl BuildImageJob {
         Ok(dockerfile_with_args.build_args)
     }
 
+    /// Digests of the base images a build used, for telling later whether newer ones
+    /// have been published
+    ///
+    /// Images whose digest can't be told (e.g. built locally) are left out; failing
+    /// to record them doesn't fail the build.
+    async fn base_image_digests(&self, dockerfile_path: &Path) -> Vec<BaseImageDigest> {
+        let dockerfile = match fs::read_to_string(dockerfile_path) {
+            Ok(dockerfile) => dockerfile,
+            Err(e) => {
+                tracing::warn!(
+                    "Failed to read {} for its base images: {}",
+                    dockerfile_path.display(),
+                    e
+                );
+                return Vec::new();
+            }
+        };
+
+        let mut digests = Vec::new();
+        for image in temps_deployer::base_images::base_images(&dockerfile) {
+            match self.image_builder.image_digest(&image, false).await {
+                Ok(Some(digest)) => digests.push(BaseImageDigest { image, digest }),
+                Ok(None) => tracing::debug!("Base image {} has no registry digest", image),
+                Err(e) => tracing::warn!("Failed to get digest of base image {}: {}", image, e),
+            }
+        }
+        digests
+    }
+
+    /// Callback streaming build output to the job's logs, with secrets masked
+    fn log_callback(&self, secrets: Arc<Vec<String>>) -> Option<temps_deployer::LogCallback> {
+        let (Some(log_svc), Some(log_id_str)) = (self.log_service.clone(), self.log_id.clone())
+        else {
+            return None;
+        };
+        Some(std::sync::Arc::new(move |line: String| {
+            let log_svc_clone = log_svc.clone();
+            let log_id_clone = log_id_str.clone();
+            let line = mask_secrets(&line, &secrets);
+            Box::pin(async move {
+                // Detect log level from Docker build output
+                let level = Self::detect_log_level(&line);
+                let _ = log_svc_clone
+                    .append_structured_log(&log_id_clone, level, line)
+                    .await;
+            })
+        }))
+    }
+
+    /// Image of the shared build stage for the checkout's shared code, built unless a
+    /// build of any service of the stage already did
+    ///
+    /// The stage is built with a context of only its files and without the service's
+    /// build args.
+    async fn ensure_shared_stage(
+        &self,
+        context: &WorkflowContext,
+        repo_output: &RepositoryOutput,
+        shared_build: &SharedBuildConfig,
+        log_callback: Option<temps_deployer::LogCallback>,
+    ) -> Result<String, WorkflowError> {
+        let platform = self.build_config.target_platform.clone();
+        if platform.as_deref().is_some_and(|p| p.contains(',')) {
+            return Err(WorkflowError::JobExecutionFailed(
+                "A shared build stage can't be used for multi-platform images".to_string(),
+            ));
+        }
+
+        let repo_dir = &repo_output.repo_dir;
+        let files =
+            shared_stage::stage_files(repo_dir, shared_build).map_err(WorkflowError::IoError)?;
+        let key = shared_stage::stage_key(repo_dir, &files, platform.as_deref())
+            .map_err(WorkflowError::IoError)?;
+        let image = shared_stage::stage_image(
+            &repo_output.repo_owner,
+            &repo_output.repo_name,
+            &shared_build.name,
+            &key,
+        );
+
+        // Services deployed together wait here for the first one to build the stage;
+        // each build node has images of its own
+        let lock_key = match &self.build_node {
+            Some(build_node) => format!("{}@{}", image, build_node),
+            None => image.clone(),
+        };
+        let _guard = match &self.shared_stage_locks {
+            Some(locks) => Some(locks.lock(&lock_key).await),
+            None => None,
+        };
+        let images = self.image_builder.list_images().await.map_err(|e| {
+            WorkflowError::JobExecutionFailed(format!("Failed to list images: {}", e))
+        })?;
+        if images.contains(&image) {
+            self.log(
+                context,
+                format!(
+                    "Reusing shared build stage '{}': {}",
+                    shared_build.name, image
+                ),
+            )
+            .await?;
+            return Ok(image);
+        }
+
+        self.log(
+            context,
+            format!(
+                "Building shared build stage '{}' from {} files: {}",
+                shared_build.name,
+                files.len(),
+                image
+            ),
+        )
+        .await?;
+        let context_dir = std::env::temp_dir().join(format!("shared_stage_{}", self.job_id));
+        if context_dir.exists() {
+            fs::remove_dir_all(&context_dir).map_err(WorkflowError::IoError)?;
+        }
+        shared_stage::copy_stage_context(repo_dir, &files, &context_dir)
+            .map_err(WorkflowError::IoError)?;
+
+        let request = BuildRequest {
+            image_name: image.clone(),
+            context_path: context_dir.clone(),
+            dockerfile_path: Some(context_dir.join(normalize_repo_path(&shared_build.dockerfile))),
+            build_args: HashMap::new(),
+            build_args_buildkit: HashMap::new(),
+            platform,
+            log_path: std::env::temp_dir().join(format!("build_{}_shared.log", self.job_id)),
+            pull_policy: self.build_config.pull_policy,
+            honor_gitignore: false,
+        };
+        let result = self
+            .image_builder
+            .build_image_with_callback(BuildRequestWithCallback {
+                request,
+                log_callback,
+            })
+            .await;
+        let _ = fs::remove_dir_all(&context_dir);
+        let result = result.map_err(|e| {
+            WorkflowError::JobExecutionFailed(format!(
+                "Failed to build shared build stage '{}': {}",
+                shared_build.name, e
+            ))
+        })?;
+
+        self.log(
+            context,
+            format!(
+                "Shared build stage built in {} ms",
+                result.build_duration_ms
+            ),
+        )
+        .await?;
+        Ok(image)
+    }
+
     /// Build the container image with real-time logging
     async fn build_image(
         &self,
@@ -493,24 +931,63 @@ impl BuildImageJob {
             repo_output.repo_dir.clone()
         };
 
+        let source_builder = builders::source_builder(self.builder.as_ref());
+        let kind = source_builder.kind();
+        self.log(context, format!("Builder: {}", source_builder.name()))
+            .await?;
+        if let Some(build_node) = &self.build_node {
+            self.log(context, format!("Building on build node {}", build_node))
+                .await?;
+        }
+        if let Some(build_command) = &self.build_command {
+            if kind == BuilderKind::Buildpacks {
+                return Err(WorkflowError::JobExecutionFailed(
+                    "The buildpacks builder can't run a custom build command; use the Dockerfile or Nixpacks builder"
+                        .to_string(),
+                ));
+            }
+            self.log(
+                context,
+                format!("Using build command override: {}", build_command),
+            )
+            .await?;
+        }
+
         // Determine dockerfile path relative to build context
-        let dockerfile_path = if let Some(ref dockerfile) = self.build_config.dockerfile_path {
+        let configured_dockerfile = if let Some(ref dockerfile) = self.build_config.dockerfile_path
+        {
             build_context.join(dockerfile)
         } else {
             build_context.join("Dockerfile")
         };
-
-        self.log(
-            context,
-            format!("Using Dockerfile: {}", dockerfile_path.display()),
-        )
-        .await?;
+        let dockerfile_path =
+            builders::dockerfile_path(kind, &build_context, &configured_dockerfile);
 
         // Ensure Dockerfile exists (generate from preset if needed)
         // This returns build args from the preset
-        let preset_build_args = self
-            .ensure_dockerfile(context, &build_context, &dockerfile_path)
-            .await?;
+        let preset_build_args = match kind {
+            BuilderKind::Dockerfile => {
+                self.log(
+                    context,
+                    format!("Using Dockerfile: {}", dockerfile_path.display()),
+                )
+                .await?;
+                self.ensure_dockerfile(context, &build_context, &dockerfile_path, None)
+                    .await?
+            }
+            BuilderKind::Nixpacks => {
+                // Always generated fresh, even when the repository has a Dockerfile
+                if dockerfile_path.exists() {
+                    fs::remove_file(&dockerfile_path).map_err(WorkflowError::IoError)?;
+                }
+                if let Some(dir) = dockerfile_path.parent() {
+                    fs::create_dir_all(dir).map_err(WorkflowError::IoError)?;
+                }
+                self.ensure_dockerfile(context, &build_context, &dockerfile_path, Some("nixpacks"))
+                    .await?
+            }
+            BuilderKind::Buildpacks => HashMap::new(),
+        };
 
         // Merge preset build args with user-provided build args
         // User-provided args take precedence
@@ -534,6 +1011,14 @@ impl BuildImageJob {
         )
         .await?;
 
+        // Uploads without a repository have no .git to keep their .gitignore'd
+        // files out, so the .gitignore is honored as well
+        let honor_gitignore = repo_output.repo_owner == super::fetch_artifact::ARTIFACT_OWNER;
+        if kind != BuilderKind::Buildpacks {
+            self.report_build_context(context, &build_context, &dockerfile_path, honor_gitignore)
+                .await?;
+        }
+
         // Create a temporary log file for the build
         let log_path = std::env::temp_dir().join(format!("build_{}.log", self.job_id));
 
@@ -551,47 +1036,70 @@ impl BuildImageJob {
             build_args_buildkit.insert(key.clone(), value.clone());
         }
 
-        let build_request = BuildRequest {
+        // Longest first, so a secret containing another is masked whole
+        let mut secrets: Vec<String> = self
+            .build_config
+            .build_args
+            .iter()
+            .filter(|(key, _)| self.build_config.masked_build_args.contains(key))
+            .map(|(_, value)| value.clone())
+            .collect();
+        secrets.sort_by_key(|secret| std::cmp::Reverse(secret.len()));
+        let secrets = Arc::new(secrets);
+
+        // Create log callback to stream Docker build output to job logs with structured logging
+        let log_callback = self.log_callback(secrets.clone());
+
+        // The shared stage is only on this host, so it must not be pulled from a registry
+        let mut pull_policy = self.build_config.pull_policy;
+        if let Some(shared_build) = &self.shared_build {
+            if kind != BuilderKind::Dockerfile {
+                return Err(WorkflowError::JobExecutionFailed(
+                    "A shared build stage can only be used with the Dockerfile builder".to_string(),
+                ));
+            }
+            let stage_image = self
+                .ensure_shared_stage(context, repo_output, shared_build, log_callback.clone())
+                .await?;
+            build_args.insert(SHARED_BUILD_IMAGE_ARG.to_string(), stage_image);
+            pull_policy = ImagePullPolicy::IfNotPresent;
+        }
+
+        let source_build = SourceBuild {
             image_name: self.image_tag.clone(),
             context_path: build_context.clone(),
-            dockerfile_path: Some(dockerfile_path.clone()),
+            dockerfile_path: dockerfile_path.clone(),
             build_args,
             build_args_buildkit,
             platform: self.build_config.target_platform.clone(),
             log_path: log_path.clone(),
-        };
-
-        // Create log callback to stream Docker build output to job logs with structured logging
-        let log_service = self.log_service.clone();
-        let log_id = self.log_id.clone();
-        let log_callback: Option<temps_deployer::LogCallback> =
-            if let (Some(log_svc), Some(log_id_str)) = (log_service, log_id) {
-                Some(std::sync::Arc::new(move |line: String| {
-                    let log_svc_clone = log_svc.clone();
-                    let log_id_clone = log_id_str.clone();
-                    Box::pin(async move {
-                        // Detect log level from Docker build output
-                        let level = Self::detect_log_level(&line);
-                        let _ = log_svc_clone
-                            .append_structured_log(&log_id_clone, level, line)
-                            .await;
-                    })
-                }))
-            } else {
-                None
-            };
-
-        let build_request_with_callback = temps_deployer::BuildRequestWithCallback {
-            request: build_request,
+            pull_policy,
+            honor_gitignore,
             log_callback,
         };
+        if let Some(platforms) = &self.build_config.target_platform {
+            self.log(
+                context,
+                format!("Building for {}", platforms.replace(',', ", ")),
+            )
+            .await?;
+        }
+        source_builder.detect(&source_build).map_err(|reason| {
+            WorkflowError::JobExecutionFailed(format!(
+                "{} can't build this source: {}",
+                source_builder.name(),
+                reason
+            ))
+        })?;
 
-        let build_result = self
-            .image_builder
-            .build_image_with_callback(build_request_with_callback)
+        let build_result = source_builder
+            .build(self.image_builder.as_ref(), source_build)
             .await
             .map_err(|e| {
-                WorkflowError::JobExecutionFailed(format!("Failed to build image: {}", e))
+                WorkflowError::JobExecutionFailed(format!(
+                    "Failed to build image: {}",
+                    mask_secrets(&e.to_string(), &secrets)
+                ))
             })?;
 
         self.log(
@@ -615,6 +1123,33 @@ impl BuildImageJob {
             format!("Build time: {} ms", build_result.build_duration_ms),
         )
         .await?;
+        // Foreign platforms are built under emulation, which is what makes these slow
+        let platform_count = self
+            .build_config
+            .target_platform
+            .as_ref()
+            .map_or(1, |platforms| platforms.split(',').count());
+        if platform_count > 1 {
+            self.log(
+                context,
+                format!(
+                    "Built {} platforms, {} ms per platform on average; platforms other than the \
+                     host's run under emulation and take longer",
+                    platform_count,
+                    build_result.build_duration_ms / platform_count as u64
+                ),
+            )
+            .await?;
+        }
+
+        let phases = build_result.phases.map(build_phase_timings);
+        if let Some(phases) = &phases {
+            self.log(
+                context,
+                format!("Build phases: {}", describe_phases(phases)),
+            )
+            .await?;
+        }
 
         Ok(ImageOutput {
             image_tag: build_result.image_name,
@@ -622,6 +1157,7 @@ impl BuildImageJob {
             size_bytes: build_result.size_bytes,
             build_context,
             dockerfile_path,
+            phases,
         })
     }
 }
@@ -648,8 +1184,25 @@ impl WorkflowTask for BuildImageJob {
         // Get typed output from the download job
         let repo_output = RepositoryOutput::from_context(&context, &self.download_job_id)?;
 
+        // Refuse to start the build if the host is out of capacity (e.g. disk space)
+        if let Some(capacity_check) = &self.capacity_check {
+            if let Err(message) = capacity_check.ensure_build_capacity().await {
+                self.log(&context, format!("❌ Build refused: {}", message))
+                    .await?;
+                return Err(WorkflowError::JobExecutionFailed(format!(
+                    "Build refused: {}",
+                    message
+                )));
+            }
+        }
+
         // Build the image (logs written in real-time)
         let image_output = self.build_image(&repo_output, &context).await?;
+        // Buildpacks builds have no Dockerfile naming their base images
+        let base_images = match self.builder.as_ref().map(|builder| builder.kind) {
+            Some(BuilderKind::Buildpacks) => Vec::new(),
+            _ => self.base_image_digests(&image_output.dockerfile_path).await,
+        };
 
         // Set typed job outputs
         context.set_output(&self.job_id, "image_tag", &image_output.image_tag)?;
@@ -665,6 +1218,14 @@ impl WorkflowTask for BuildImageJob {
             "dockerfile_path",
             image_output.dockerfile_path.to_string_lossy().to_string(),
         )?;
+        context.set_output(&self.job_id, "base_images", &base_images)?;
+        if let Some(phases) = &image_output.phases {
+            context.set_output(&self.job_id, "build_phases", phases)?;
+        }
+        if let Some(platforms) = &self.build_config.target_platform {
+            let platforms: Vec<&str> = platforms.split(',').collect();
+            context.set_output(&self.job_id, "build_platforms", &platforms)?;
+        }
 
         // Set artifacts
         context.set_artifact(
@@ -778,6 +1339,13 @@ pub struct BuildImageJobBuilder {
     log_id: Option<String>,
     log_service: Option<Arc<LogService>>,
     preset: Option<String>,
+    capacity_check: Option<Arc<dyn BuildCapacityCheck>>,
+    build_cache: Option<BuildCacheConfig>,
+    builder: Option<BuilderConfig>,
+    build_command: Option<String>,
+    build_node: Option<String>,
+    list_context: bool,
+    shared_build: Option<(SharedBuildConfig, Arc<SharedStageLocks>)>,
 }
 
 impl BuildImageJobBuilder {
@@ -790,6 +1358,13 @@ impl BuildImageJobBuilder {
             log_id: None,
             log_service: None,
             preset: None,
+            capacity_check: None,
+            build_cache: None,
+            builder: None,
+            build_command: None,
+            build_node: None,
+            list_context: false,
+            shared_build: None,
         }
     }
 
@@ -838,6 +1413,16 @@ impl BuildImageJobBuilder {
         self
     }
 
+    pub fn pull_policy(mut self, pull_policy: ImagePullPolicy) -> Self {
+        self.build_config.pull_policy = pull_policy;
+        self
+    }
+
+    pub fn masked_build_args(mut self, masked_build_args: Vec<String>) -> Self {
+        self.build_config.masked_build_args = masked_build_args;
+        self
+    }
+
     pub fn log_id(mut self, log_id: String) -> Self {
         self.log_id = Some(log_id);
         self
@@ -853,6 +1438,45 @@ impl BuildImageJobBuilder {
         self
     }
 
+    pub fn capacity_check(mut self, capacity_check: Arc<dyn BuildCapacityCheck>) -> Self {
+        self.capacity_check = Some(capacity_check);
+        self
+    }
+
+    pub fn build_cache(mut self, build_cache: BuildCacheConfig) -> Self {
+        self.build_cache = Some(build_cache);
+        self
+    }
+
+    pub fn builder(mut self, builder: BuilderConfig) -> Self {
+        self.builder = Some(builder);
+        self
+    }
+
+    pub fn build_command(mut self, build_command: String) -> Self {
+        self.build_command = Some(build_command);
+        self
+    }
+
+    pub fn build_node(mut self, build_node: String) -> Self {
+        self.build_node = Some(build_node);
+        self
+    }
+
+    pub fn list_context(mut self, list_context: bool) -> Self {
+        self.list_context = list_context;
+        self
+    }
+
+    pub fn shared_build(
+        mut self,
+        shared_build: SharedBuildConfig,
+        shared_stage_locks: Arc<SharedStageLocks>,
+    ) -> Self {
+        self.shared_build = Some((shared_build, shared_stage_locks));
+        self
+    }
+
     pub fn build(
         self,
         image_builder: Arc<dyn ImageBuilder>,
@@ -877,6 +1501,25 @@ impl BuildImageJobBuilder {
         if let Some(preset) = self.preset {
             job = job.with_preset(preset);
         }
+        if let Some(capacity_check) = self.capacity_check {
+            job = job.with_capacity_check(capacity_check);
+        }
+        if let Some(build_cache) = self.build_cache {
+            job = job.with_build_cache(build_cache);
+        }
+        if let Some(builder) = self.builder {
+            job = job.with_builder(builder);
+        }
+        if let Some(build_command) = self.build_command {
+            job = job.with_build_command(build_command);
+        }
+        if let Some(build_node) = self.build_node {
+            job = job.with_build_node(build_node);
+        }
+        job = job.with_list_context(self.list_context);
+        if let Some((shared_build, shared_stage_locks)) = self.shared_build {
+            job = job.with_shared_build(shared_build, shared_stage_locks);
+        }
 
         Ok(job)
     }
@@ -909,6 +1552,7 @@ mod tests {
                 image_name: request.image_name,
                 size_bytes: 104857600, // 100MB
                 build_duration_ms: 5000,
+                phases: None,
             })
         }
 
@@ -965,6 +1609,63 @@ mod tests {
         assert_eq!(job.depends_on(), vec!["download_repo".to_string()]);
     }
 
+    #[test]
+    fn test_mask_secrets() {
+        let secrets = vec!["npm_abc123".to_string(), String::new()];
+        assert_eq!(
+            mask_secrets("//registry.npmjs.org/:_authToken=npm_abc123", &secrets),
+            "//registry.npmjs.org/:_authToken=********"
+        );
+        assert_eq!(
+            mask_secrets("Step 3/7 : RUN npm ci", &secrets),
+            "Step 3/7 : RUN npm ci"
+        );
+    }
+
+    #[test]
+    fn test_describe_phases_skips_empty_phases() {
+        let phases = BuildPhaseTimings {
+            dependency_install_ms: 30_000,
+            compile_ms: 61_500,
+            steps: 9,
+            cached_steps: 4,
+            ..Default::default()
+        };
+        assert_eq!(
+            describe_phases(&phases),
+            "dependency install 30.0s, compile 61.5s, 4 of 9 steps cached"
+        );
+    }
+
+    #[test]
+    fn test_cache_mounts_combine_detected_and_extra_caches() {
+        let dir = tempfile::TempDir::new().unwrap();
+        fs::write(dir.path().join("package.json"), "{}").unwrap();
+        let job = BuildImageJob::new(
+            "build_image".to_string(),
+            "download_repo".to_string(),
+            "myapp:latest".to_string(),
+            Arc::new(MockImageBuilder),
+        );
+        let names = |job: &BuildImageJob| -> Vec<String> {
+            job.cache_mounts(dir.path())
+                .into_iter()
+                .map(|m| m.name)
+                .collect()
+        };
+        assert_eq!(names(&job), vec!["npm"]);
+
+        let job = job.with_build_cache(BuildCacheConfig {
+            language_caches: Some(false),
+            extra_caches: vec![temps_entities::deployment_config::BuildCacheMount {
+                name: "turbo".to_string(),
+                target: "/app/.turbo".to_string(),
+                env_var: None,
+            }],
+        });
+        assert_eq!(names(&job), vec!["turbo"]);
+    }
+
     #[test]
     fn test_repository_output_from_context() {
         let mut context = crate::test_utils::create_test_context("test".to_string(), 1, 1, 1);
@@ -989,4 +1690,46 @@ mod tests {
         assert_eq!(repo_output.repo_owner, "user");
         assert_eq!(repo_output.repo_name, "project");
     }
+
+    struct FullDisk;
+
+    #[async_trait]
+    impl BuildCapacityCheck for FullDisk {
+        async fn ensure_build_capacity(&self) -> Result<(), String> {
+            Err("Disk usage on / is 97.0%".to_string())
+        }
+    }
+
+    #[tokio::test]
+    async fn test_build_refused_when_capacity_check_fails() {
+        let image_builder: Arc<dyn ImageBuilder> = Arc::new(MockImageBuilder);
+
+        let job = BuildImageJobBuilder::new()
+            .download_job_id("download_repo".to_string())
+            .image_tag("myapp:latest".to_string())
+            .capacity_check(Arc::new(FullDisk))
+            .build(image_builder)
+            .unwrap();
+
+        let mut context = crate::test_utils::create_test_context("test".to_string(), 1, 1, 1);
+        context
+            .set_output("download_repo", "repo_dir", "/tmp/repo")
+            .unwrap();
+        context
+            .set_output("download_repo", "checkout_ref", "main")
+            .unwrap();
+        context
+            .set_output("download_repo", "repo_owner", "user")
+            .unwrap();
+        context
+            .set_output("download_repo", "repo_name", "project")
+            .unwrap();
+
+        match job.execute(context).await {
+            Err(WorkflowError::JobExecutionFailed(message)) => {
+                assert!(message.contains("Disk usage on / is 97.0%"));
+            }
+            other => panic!("expected build to be refused, got {:?}", other.map(|_| ())),
+        }
+    }
 }
@@ -0,0 +1,298 @@
+//! Builders that turn a service's source into its container image
+//!
+//! The build job picks one from the service's `builder` setting. Each one checks
+//! whether it can build the source it was given and builds it through the
+//! [`ImageBuilder`]; whichever built the image, the rest of the deploy is the same.
+//!
+//! - [`DockerfileBuilder`]: the repository's Dockerfile, or one generated from the
+//!   project's preset (the default)
+//! - [`DockerfileBuilder::nixpacks`]: a Dockerfile generated by Nixpacks
+//! - [`BuildpacksBuilder`]: Cloud Native Buildpacks through `pack`
+
+use async_trait::async_trait;
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use temps_deployer::buildpacks::BuildpacksRequest;
+use temps_deployer::{
+    BuildRequest, BuildRequestWithCallback, BuildResult, BuilderError, ImageBuilder,
+    ImagePullPolicy, LogCallback,
+};
+use temps_entities::deployment_config::{BuilderConfig, BuilderKind};
+
+/// Dockerfile Nixpacks writes, relative to the build context
+pub const NIXPACKS_DOCKERFILE: &str = ".nixpacks/Dockerfile";
+
+/// One build of a service's source
+pub struct SourceBuild {
+    pub image_name: String,
+    pub context_path: PathBuf,
+    /// Dockerfile to build, for the builders that build one
+    pub dockerfile_path: PathBuf,
+    pub build_args: HashMap<String, String>,
+    pub build_args_buildkit: HashMap<String, String>,
+    pub platform: Option<String>,
+    pub log_path: PathBuf,
+    pub pull_policy: ImagePullPolicy,
+    /// Whether the source's `.gitignore` applies to the build context as well
+    pub honor_gitignore: bool,
+    pub log_callback: Option<LogCallback>,
+}
+
+/// Turns a build context into a container image
+#[async_trait]
+pub trait SourceBuilder: Send + Sync {
+    fn kind(&self) -> BuilderKind;
+
+    /// Name shown in the build log
+    fn name(&self) -> String;
+
+    /// Whether the builder can build the source, or why not
+    fn detect(&self, build: &SourceBuild) -> Result<(), String>;
+
+    /// Build the source into an image named after the request
+    async fn build(
+        &self,
+        image_builder: &dyn ImageBuilder,
+        build: SourceBuild,
+    ) -> Result<BuildResult, BuilderError>;
+}
+
+/// Builds a Dockerfile: the repository's own, or one generated before the build
+pub struct DockerfileBuilder {
+    kind: BuilderKind,
+}
+
+impl DockerfileBuilder {
+    pub fn new() -> Self {
+        Self {
+            kind: BuilderKind::Dockerfile,
+        }
+    }
+
+    /// Builds the Dockerfile Nixpacks generated for the source
+    pub fn nixpacks() -> Self {
+        Self {
+            kind: BuilderKind::Nixpacks,
+        }
+    }
+}
+
+impl Default for DockerfileBuilder {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[async_trait]
+impl SourceBuilder for DockerfileBuilder {
+    fn kind(&self) -> BuilderKind {
+        self.kind
+    }
+
+    fn name(&self) -> String {
+        match self.kind {
+            BuilderKind::Nixpacks => "Nixpacks".to_string(),
+            _ => "Dockerfile".to_string(),
+        }
+    }
+
+    fn detect(&self, build: &SourceBuild) -> Result<(), String> {
+        if build.dockerfile_path.is_file() {
+            Ok(())
+        } else {
+            Err(format!(
+                "No Dockerfile at {}",
+                build.dockerfile_path.display()
+            ))
+        }
+    }
+
+    async fn build(
+        &self,
+        image_builder: &dyn ImageBuilder,
+        build: SourceBuild,
+    ) -> Result<BuildResult, BuilderError> {
+        image_builder
+            .build_image_with_callback(BuildRequestWithCallback {
+                request: BuildRequest {
+                    image_name: build.image_name,
+                    context_path: build.context_path,
+                    dockerfile_path: Some(build.dockerfile_path),
+                    build_args: build.build_args,
+                    build_args_buildkit: build.build_args_buildkit,
+                    platform: build.platform,
+                    log_path: build.log_path,
+                    pull_policy: build.pull_policy,
+                    honor_gitignore: build.honor_gitignore,
+                },
+                log_callback: build.log_callback,
+            })
+            .await
+    }
+}
+
+/// Builds the source with Cloud Native Buildpacks
+pub struct BuildpacksBuilder {
+    builder_image: String,
+    buildpacks: Vec<String>,
+}
+
+impl BuildpacksBuilder {
+    pub fn new(builder_image: String, buildpacks: Vec<String>) -> Self {
+        Self {
+            builder_image,
+            buildpacks,
+        }
+    }
+}
+
+#[async_trait]
+impl SourceBuilder for BuildpacksBuilder {
+    fn kind(&self) -> BuilderKind {
+        BuilderKind::Buildpacks
+    }
+
+    fn name(&self) -> String {
+        format!("Cloud Native Buildpacks ({})", self.builder_image)
+    }
+
+    fn detect(&self, build: &SourceBuild) -> Result<(), String> {
+        // `pack` builds for the host only
+        if build
+            .platform
+            .as_deref()
+            .is_some_and(|platform| platform.contains(','))
+        {
+            return Err("Buildpacks can't build multi-platform images".to_string());
+        }
+        if temps_deployer::buildpacks::has_source(&build.context_path) {
+            Ok(())
+        } else {
+            Err(format!(
+                "Build context {} is empty",
+                build.context_path.display()
+            ))
+        }
+    }
+
+    async fn build(
+        &self,
+        image_builder: &dyn ImageBuilder,
+        build: SourceBuild,
+    ) -> Result<BuildResult, BuilderError> {
+        // Buildpacks read build-time configuration from the environment
+        let mut env = build.build_args;
+        env.extend(build.build_args_buildkit);
+        env.retain(|_, value| !value.is_empty());
+
+        image_builder
+            .build_with_buildpacks(
+                BuildpacksRequest {
+                    image_name: build.image_name,
+                    context_path: build.context_path,
+                    builder_image: self.builder_image.clone(),
+                    buildpacks: self.buildpacks.clone(),
+                    env,
+                    log_path: build.log_path,
+                    pull_policy: build.pull_policy,
+                },
+                build.log_callback,
+            )
+            .await
+    }
+}
+
+/// The builder a service's `builder` setting picks
+pub fn source_builder(config: Option<&BuilderConfig>) -> Box<dyn SourceBuilder> {
+    let Some(config) = config else {
+        return Box::new(DockerfileBuilder::new());
+    };
+    match config.kind {
+        BuilderKind::Dockerfile => Box::new(DockerfileBuilder::new()),
+        BuilderKind::Nixpacks => Box::new(DockerfileBuilder::nixpacks()),
+        BuilderKind::Buildpacks => Box::new(BuildpacksBuilder::new(
+            config.builder_image().to_string(),
+            config.buildpacks.clone(),
+        )),
+    }
+}
+
+/// Dockerfile a builder builds, given the configured one
+pub fn dockerfile_path(kind: BuilderKind, build_context: &Path, configured: &Path) -> PathBuf {
+    match kind {
+        BuilderKind::Nixpacks => build_context.join(NIXPACKS_DOCKERFILE),
+        _ => configured.to_path_buf(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn source_build(context_path: &Path, dockerfile_path: PathBuf) -> SourceBuild {
+        SourceBuild {
+            image_name: "temps-app:1".to_string(),
+            context_path: context_path.to_path_buf(),
+            dockerfile_path,
+            build_args: HashMap::new(),
+            build_args_buildkit: HashMap::new(),
+            platform: None,
+            log_path: context_path.join("build.log"),
+            pull_policy: ImagePullPolicy::IfNotPresent,
+            honor_gitignore: false,
+            log_callback: None,
+        }
+    }
+
+    #[test]
+    fn test_builder_follows_config() {
+        assert_eq!(source_builder(None).kind(), BuilderKind::Dockerfile);
+
+        let config = BuilderConfig {
+            kind: BuilderKind::Buildpacks,
+            builder_image: Some("heroku/builder:24".to_string()),
+            buildpacks: vec![],
+            node_labels: vec![],
+        };
+        let builder = source_builder(Some(&config));
+        assert_eq!(builder.kind(), BuilderKind::Buildpacks);
+        assert_eq!(
+            builder.name(),
+            "Cloud Native Buildpacks (heroku/builder:24)"
+        );
+
+        let context = Path::new("/repo");
+        let configured = Path::new("/repo/Dockerfile");
+        assert_eq!(
+            dockerfile_path(BuilderKind::Nixpacks, context, configured),
+            PathBuf::from("/repo/.nixpacks/Dockerfile")
+        );
+        assert_eq!(
+            dockerfile_path(BuilderKind::Dockerfile, context, configured),
+            configured
+        );
+    }
+
+    #[test]
+    fn test_detect() {
+        let dir = TempDir::new().unwrap();
+        let dockerfile = dir.path().join("Dockerfile");
+        let build = source_build(dir.path(), dockerfile.clone());
+
+        let buildpacks = BuildpacksBuilder::new("builder".to_string(), vec![]);
+        assert!(DockerfileBuilder::new().detect(&build).is_err());
+        assert!(buildpacks.detect(&build).is_err());
+
+        std::fs::write(&dockerfile, "FROM alpine").unwrap();
+        assert!(DockerfileBuilder::new().detect(&build).is_ok());
+        assert!(buildpacks.detect(&build).is_ok());
+
+        let multi_platform = SourceBuild {
+            platform: Some("linux/amd64,linux/arm64".to_string()),
+            ..source_build(dir.path(), dockerfile.clone())
+        };
+        assert!(DockerfileBuilder::new().detect(&multi_platform).is_ok());
+        assert!(buildpacks.detect(&multi_platform).is_err());
+    }
+}
@@ -31,6 +31,8 @@ pub trait CronConfigService: Send + Sync {
 pub struct CronConfig {
     pub path: String,
     pub schedule: String,
+    /// IANA timezone of the schedule; None for the platform's default
+    pub timezone: Option<String>,
 }
 
 /// Errors that can occur during cron configuration
@@ -225,6 +227,7 @@ impl ConfigureCronsJob {
             .map(|job| CronConfig {
                 path: job.path.clone(),
                 schedule: job.schedule.clone(),
+                timezone: job.timezone.clone(),
             })
             .collect();
 
@@ -547,6 +550,7 @@ cron:
             .map(|job| CronConfig {
                 path: job.path.clone(),
                 schedule: job.schedule.clone(),
+                timezone: job.timezone.clone(),
             })
             .collect();
 
@@ -565,6 +569,7 @@ cron:
         let configs = vec![CronConfig {
             path: "/test".to_string(),
             schedule: "* * * * *".to_string(),
+            timezone: None,
         }];
 
         // Should succeed without doing anything
@@ -579,10 +584,12 @@ cron:
             CronConfig {
                 path: "/api/cron/task1".to_string(),
                 schedule: "0 0 * * *".to_string(),
+                timezone: None,
             },
             CronConfig {
                 path: "/api/cron/task2".to_string(),
                 schedule: "0 12 * * *".to_string(),
+                timezone: None,
             },
         ];
 
@@ -601,6 +608,7 @@ cron:
         let configs = vec![CronConfig {
             path: "/api/cron/task".to_string(),
             schedule: "0 0 * * *".to_string(),
+            timezone: None,
         }];
 
         let result = service.configure_crons(1, 1, configs).await;
@@ -694,6 +702,7 @@ cron: []
             .map(|job| CronConfig {
                 path: job.path.clone(),
                 schedule: job.schedule.clone(),
+                timezone: job.timezone.clone(),
             })
             .collect();
 
@@ -0,0 +1,637 @@
+//! Deploy Gate Job
+//!
+//! Asks an external system, typically CI, whether a new deployment may receive traffic.
+//! Once the new containers are healthy, the deploy context is POSTed to the gate's URL;
+//! the job then waits for the verdict, either posted back to the callback URL sent with
+//! the request or read by polling a status URL. The verdict is saved on the deployment.
+//! A negative verdict, or none before the timeout, fails the deployment and removes the
+//! new containers, leaving the current deployment serving.
+
+use async_trait::async_trait;
+use hmac::{Hmac, Mac};
+use rand::Rng;
+use sea_orm::{ActiveModelTrait, EntityTrait, Set};
+use serde::Deserialize;
+use sha2::{Digest, Sha256};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+use temps_core::{
+    JobResult, WorkflowCancellationProvider, WorkflowContext, WorkflowError, WorkflowTask,
+};
+use temps_database::DbConnection;
+use temps_entities::deployment_config::{DeployGateConfig, DeployGateMode};
+use temps_entities::deployments::{self, DeployGateReport, DeployGateState};
+use temps_entities::{environments, projects};
+use temps_logs::{LogLevel, LogService};
+use tokio::time::sleep;
+use tracing::{info, warn};
+
+type HmacSha256 = Hmac<Sha256>;
+
+/// Seconds between checks of the deployment for a callback's verdict
+const CALLBACK_CHECK_INTERVAL_SECONDS: u64 = 3;
+/// Seconds the request to the gate's URL and each status check may take
+const GATE_REQUEST_TIMEOUT_SECONDS: u64 = 30;
+
+/// Hash under which a callback token is saved on the deployment
+pub fn hash_callback_token(token: &str) -> String {
+    let mut hasher = Sha256::new();
+    hasher.update(token.as_bytes());
+    format!("{:x}", hasher.finalize())
+}
+
+/// Token the external system presents when posting its verdict
+fn generate_callback_token() -> String {
+    const CHARSET: &[u8] = b"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789";
+    let mut rng = rand::thread_rng();
+
+    let random_part: String = (0..40)
+        .map(|_| CHARSET[rng.gen_range(0..CHARSET.len())] as char)
+        .collect();
+    format!("dg_{}", random_part)
+}
+
+/// `X-Webhook-Signature` of a request body, as Temps webhooks sign theirs
+fn sign_body(secret: &str, timestamp: &str, body: &str) -> String {
+    let mut mac =
+        HmacSha256::new_from_slice(secret.as_bytes()).expect("HMAC can take key of any size");
+    mac.update(format!("{}.{}", timestamp, body).as_bytes());
+    format!("sha256={}", hex::encode(mac.finalize().into_bytes()))
+}
+
+/// What the external system answered to the request or to a status check
+#[derive(Debug, Default, PartialEq, Eq, Deserialize)]
+struct GateResponse {
+    #[serde(default)]
+    status: Option<String>,
+    #[serde(default)]
+    message: Option<String>,
+    #[serde(default)]
+    details_url: Option<String>,
+    #[serde(default)]
+    status_url: Option<String>,
+}
+
+impl GateResponse {
+    /// Parse a response body; bodies that aren't a JSON object carry no information
+    fn parse(body: &str) -> Self {
+        serde_json::from_str(body).unwrap_or_default()
+    }
+
+    fn state(&self) -> Option<DeployGateState> {
+        self.status
+            .as_deref()
+            .and_then(DeployGateState::from_status)
+    }
+}
+
+/// Job that waits for an external verdict on a new deployment before its cutover
+pub struct DeployGateJob {
+    job_id: String,
+    deployment_id: i32,
+    dependencies: Vec<String>,
+    deploy_job_id: Option<String>,
+    callback_url: String,
+    config: DeployGateConfig,
+    db: Arc<DbConnection>,
+    container_deployer: Arc<dyn temps_deployer::ContainerDeployer>,
+    log_id: Option<String>,
+    log_service: Option<Arc<LogService>>,
+}
+
+impl std::fmt::Debug for DeployGateJob {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("DeployGateJob")
+            .field("job_id", &self.job_id)
+            .field("deployment_id", &self.deployment_id)
+            .field("deploy_job_id", &self.deploy_job_id)
+            .field("url", &self.config.url)
+            .field("mode", &self.config.mode)
+            .finish()
+    }
+}
+
+impl DeployGateJob {
+    #[allow(clippy::too_many_arguments)]
+    pub fn new(
+        job_id: String,
+        deployment_id: i32,
+        dependencies: Vec<String>,
+        deploy_job_id: Option<String>,
+        callback_url: String,
+        config: DeployGateConfig,
+        db: Arc<DbConnection>,
+        container_deployer: Arc<dyn temps_deployer::ContainerDeployer>,
+    ) -> Self {
+        Self {
+            job_id,
+            deployment_id,
+            dependencies,
+            deploy_job_id,
+            callback_url,
+            config,
+            db,
+            container_deployer,
+            log_id: None,
+            log_service: None,
+        }
+    }
+
+    pub fn with_log_id(mut self, log_id: String) -> Self {
+        self.log_id = Some(log_id);
+        self
+    }
+
+    pub fn with_log_service(mut self, log_service: Arc<LogService>) -> Self {
+        self.log_service = Some(log_service);
+        self
+    }
+
+    /// Write log message to job-specific log file
+    async fn log(&self, message: String) -> Result<(), WorkflowError> {
+        let level = Self::detect_log_level(&message);
+
+        if let (Some(log_id), Some(log_service)) = (&self.log_id, &self.log_service) {
+            log_service
+                .append_structured_log(log_id, level, message)
+                .await
+                .map_err(|e| {
+                    WorkflowError::JobExecutionFailed(format!("Failed to write log: {}", e))
+                })?;
+        }
+
+        Ok(())
+    }
+
+    fn detect_log_level(message: &str) -> LogLevel {
+        if message.contains("✅") {
+            LogLevel::Success
+        } else if message.contains("❌") {
+            LogLevel::Error
+        } else if message.contains("⚠️") {
+            LogLevel::Warning
+        } else {
+            LogLevel::Info
+        }
+    }
+
+    async fn find_deployment(&self) -> Result<deployments::Model, WorkflowError> {
+        deployments::Entity::find_by_id(self.deployment_id)
+            .one(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to find deployment: {}", e))
+            })?
+            .ok_or_else(|| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Deployment {} not found",
+                    self.deployment_id
+                ))
+            })
+    }
+
+    /// Save the gate's report on the deployment
+    async fn save_report(&self, report: &DeployGateReport) -> Result<(), WorkflowError> {
+        let deployment = self.find_deployment().await?;
+
+        let mut metadata = deployment.metadata.clone().unwrap_or_default();
+        metadata.deploy_gate = Some(report.clone());
+        let mut active_deployment: deployments::ActiveModel = deployment.into();
+        active_deployment.metadata = Set(Some(metadata));
+        active_deployment
+            .update(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Failed to save deploy gate result: {}",
+                    e
+                ))
+            })?;
+        Ok(())
+    }
+
+    /// Deploy context sent to the gate's URL
+    async fn payload(
+        &self,
+        context: &WorkflowContext,
+        callback_token: &str,
+    ) -> Result<serde_json::Value, WorkflowError> {
+        let deployment = self.find_deployment().await?;
+        let project = projects::Entity::find_by_id(deployment.project_id)
+            .one(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to find project: {}", e))
+            })?;
+        let environment = environments::Entity::find_by_id(deployment.environment_id)
+            .one(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to find environment: {}", e))
+            })?;
+        let endpoints: Vec<String> = match &self.deploy_job_id {
+            Some(deploy_job_id) => context
+                .get_output(deploy_job_id, "endpoints")?
+                .unwrap_or_default(),
+            None => Vec::new(),
+        };
+
+        Ok(serde_json::json!({
+            "event": "deployment.gate",
+            "deployment_id": deployment.id,
+            "deployment_slug": deployment.slug,
+            "project_id": deployment.project_id,
+            "project_slug": project.map(|project| project.slug),
+            "environment_id": deployment.environment_id,
+            "environment_slug": environment.map(|environment| environment.slug),
+            "branch": deployment.branch_ref,
+            "tag": deployment.tag_ref,
+            "commit_sha": deployment.commit_sha,
+            "commit_message": deployment.commit_message,
+            "image": deployment.image_name,
+            "endpoints": endpoints,
+            "mode": self.config.mode,
+            "callback_url": self.callback_url,
+            "callback_token": callback_token,
+            "timeout_seconds": self.config.timeout_seconds(),
+        }))
+    }
+
+    /// POST the deploy context to the gate's URL
+    async fn request_gate(
+        &self,
+        client: &reqwest::Client,
+        payload: &serde_json::Value,
+    ) -> Result<GateResponse, String> {
+        let body = payload.to_string();
+        let mut request = client
+            .post(&self.config.url)
+            .header("Content-Type", "application/json");
+        for (name, value) in &self.config.headers {
+            request = request.header(name, value);
+        }
+        if let Some(secret) = &self.config.secret {
+            let timestamp = chrono::Utc::now().timestamp().to_string();
+            request = request
+                .header("X-Webhook-Signature", sign_body(secret, &timestamp, &body))
+                .header("X-Webhook-Timestamp", timestamp);
+        }
+
+        let response = request
+            .body(body)
+            .send()
+            .await
+            .map_err(|e| format!("Request to {} failed: {}", self.config.url, e))?;
+        let status = response.status();
+        let body = response.text().await.unwrap_or_default();
+        if !status.is_success() {
+            return Err(format!(
+                "{} answered with status {}",
+                self.config.url,
+                status.as_u16()
+            ));
+        }
+        Ok(GateResponse::parse(&body))
+    }
+
+    /// Poll the status URL until it reports a verdict or the deadline passes
+    async fn poll_verdict(
+        &self,
+        client: &reqwest::Client,
+        status_url: &str,
+        deadline: Instant,
+    ) -> Result<GateResponse, WorkflowError> {
+        let interval = Duration::from_secs(self.config.poll_interval_seconds() as u64);
+        while Instant::now() < deadline {
+            let mut request = client.get(status_url);
+            for (name, value) in &self.config.headers {
+                request = request.header(name, value);
+            }
+
+            // Errors may be transient, so polling continues until the deadline
+            match request.send().await {
+                Ok(response) if response.status().is_success() => {
+                    let response = GateResponse::parse(&response.text().await.unwrap_or_default());
+                    match response.state() {
+                        Some(DeployGateState::Pending) => {}
+                        Some(_) => return Ok(response),
+                        None => {
+                            self.log(format!(
+                                "⚠️  Status URL reported an unknown status {:?}",
+                                response.status
+                            ))
+                            .await?
+                        }
+                    }
+                }
+                Ok(response) => {
+                    self.log(format!(
+                        "⚠️  Status URL answered with status {}",
+                        response.status().as_u16()
+                    ))
+                    .await?
+                }
+                Err(e) => self.log(format!("⚠️  Status check failed: {}", e)).await?,
+            }
+            sleep(interval.min(deadline.saturating_duration_since(Instant::now()))).await;
+        }
+        Ok(GateResponse::default())
+    }
+
+    /// Wait until a callback saves a verdict on the deployment or the deadline passes
+    async fn wait_for_callback(
+        &self,
+        deadline: Instant,
+    ) -> Result<Option<DeployGateReport>, WorkflowError> {
+        while Instant::now() < deadline {
+            let report = self
+                .find_deployment()
+                .await?
+                .metadata
+                .and_then(|metadata| metadata.deploy_gate);
+            if let Some(report) = report.filter(|report| report.state != DeployGateState::Pending) {
+                return Ok(Some(report));
+            }
+            sleep(
+                Duration::from_secs(CALLBACK_CHECK_INTERVAL_SECONDS)
+                    .min(deadline.saturating_duration_since(Instant::now())),
+            )
+            .await;
+        }
+        Ok(None)
+    }
+
+    /// Save a verdict and fail the job unless it passed
+    async fn finish(
+        &self,
+        mut context: WorkflowContext,
+        mut report: DeployGateReport,
+    ) -> Result<JobResult, WorkflowError> {
+        report.completed_at.get_or_insert_with(chrono::Utc::now);
+        self.save_report(&report).await?;
+
+        let passed = report.state == DeployGateState::Passed;
+        context.set_output(&self.job_id, "passed", passed)?;
+
+        let message = report.message.clone().unwrap_or_default();
+        let details = report
+            .details_url
+            .as_ref()
+            .map(|url| format!(" ({})", url))
+            .unwrap_or_default();
+        if !passed {
+            self.log(format!(
+                "❌ Deploy gate failed{}: {}; the new version will not receive traffic",
+                details, message
+            ))
+            .await?;
+            return Err(WorkflowError::JobExecutionFailed(format!(
+                "Deploy gate failed: {}",
+                message
+            )));
+        }
+
+        info!("Deploy gate of deployment {} passed", self.deployment_id);
+        self.log(format!("✅ Deploy gate passed{} {}", details, message))
+            .await?;
+        Ok(JobResult::success(context))
+    }
+}
+
+#[async_trait]
+impl WorkflowTask for DeployGateJob {
+    fn job_id(&self) -> &str {
+        &self.job_id
+    }
+
+    fn name(&self) -> &str {
+        "Wait for Deploy Gate"
+    }
+
+    fn description(&self) -> &str {
+        "Waits for an external system to approve the new version before it receives traffic"
+    }
+
+    fn depends_on(&self) -> Vec<String> {
+        self.dependencies.clone()
+    }
+
+    async fn execute(&self, context: WorkflowContext) -> Result<JobResult, WorkflowError> {
+        let started = Instant::now();
+        let deadline = started + Duration::from_secs(self.config.timeout_seconds() as u64);
+        let callback_token = generate_callback_token();
+        let mut report = DeployGateReport {
+            state: DeployGateState::Pending,
+            url: self.config.url.clone(),
+            message: None,
+            details_url: None,
+            callback_token_hash: (self.config.mode == DeployGateMode::Callback)
+                .then(|| hash_callback_token(&callback_token)),
+            started_at: chrono::Utc::now(),
+            completed_at: None,
+        };
+        // Saved before the request, so a callback that arrives right away finds it
+        self.save_report(&report).await?;
+
+        let client = reqwest::Client::builder()
+            .timeout(Duration::from_secs(GATE_REQUEST_TIMEOUT_SECONDS))
+            .build()
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to create HTTP client: {}", e))
+            })?;
+
+        self.log(format!(
+            "Sending the deploy context to {} and waiting up to {}s for its verdict",
+            self.config.url,
+            self.config.timeout_seconds()
+        ))
+        .await?;
+        let payload = self.payload(&context, &callback_token).await?;
+        let response = match self.request_gate(&client, &payload).await {
+            Ok(response) => response,
+            Err(error) => {
+                report.state = DeployGateState::Failed;
+                report.message = Some(error);
+                return self.finish(context, report).await;
+            }
+        };
+        report.details_url = response.details_url.clone();
+        if let Some(details_url) = &report.details_url {
+            self.log(format!("External run: {}", details_url)).await?;
+        }
+
+        // The external system may decide right away
+        if let Some(state @ (DeployGateState::Passed | DeployGateState::Failed)) = response.state()
+        {
+            report.state = state;
+            report.message = response.message;
+            return self.finish(context, report).await;
+        }
+
+        let verdict = match self.config.mode {
+            DeployGateMode::Callback => {
+                self.log("Waiting for the callback".to_string()).await?;
+                self.wait_for_callback(deadline)
+                    .await?
+                    .map(|verdict| DeployGateReport {
+                        details_url: verdict.details_url.or(report.details_url.clone()),
+                        ..verdict
+                    })
+            }
+            DeployGateMode::Poll => {
+                let Some(status_url) = response
+                    .status_url
+                    .clone()
+                    .or_else(|| self.config.status_url.clone())
+                else {
+                    report.state = DeployGateState::Failed;
+                    report.message = Some(
+                        "No status URL to poll: none is configured and the gate's response \
+                         has no status_url"
+                            .to_string(),
+                    );
+                    return self.finish(context, report).await;
+                };
+                self.log(format!("Polling {}", status_url)).await?;
+                let polled = self.poll_verdict(&client, &status_url, deadline).await?;
+                polled.state().map(|state| DeployGateReport {
+                    state,
+                    message: polled.message,
+                    details_url: polled.details_url.or(report.details_url.clone()),
+                    ..report.clone()
+                })
+            }
+        };
+
+        let report = verdict.unwrap_or_else(|| DeployGateReport {
+            state: DeployGateState::Failed,
+            message: Some(format!(
+                "No verdict within {}s",
+                self.config.timeout_seconds()
+            )),
+            ..report
+        });
+        self.finish(context, report).await
+    }
+
+    /// Stop waiting as soon as the deployment is cancelled instead of at the timeout
+    async fn execute_with_cancellation(
+        &self,
+        context: WorkflowContext,
+        cancellation_provider: &dyn WorkflowCancellationProvider,
+    ) -> Result<JobResult, WorkflowError> {
+        let workflow_run_id = context.workflow_run_id.clone();
+        if cancellation_provider.is_cancelled(&workflow_run_id).await? {
+            return Err(WorkflowError::WorkflowCancelled);
+        }
+
+        let cancellation_check = async {
+            loop {
+                sleep(Duration::from_secs(2)).await;
+                if let Ok(true) = cancellation_provider.is_cancelled(&workflow_run_id).await {
+                    return;
+                }
+            }
+        };
+
+        tokio::select! {
+            result = self.execute(context) => result,
+            _ = cancellation_check => {
+                self.log("⚠️  Deployment cancelled while waiting for the deploy gate".to_string())
+                    .await
+                    .ok();
+                Err(WorkflowError::WorkflowCancelled)
+            }
+        }
+    }
+
+    async fn validate_prerequisites(
+        &self,
+        _context: &WorkflowContext,
+    ) -> Result<(), WorkflowError> {
+        self.config
+            .validate()
+            .map_err(WorkflowError::JobValidationFailed)
+    }
+
+    /// Remove the new containers, which never received traffic
+    async fn cleanup(&self, context: &WorkflowContext) -> Result<(), WorkflowError> {
+        let Some(deploy_job_id) = &self.deploy_job_id else {
+            return Ok(());
+        };
+        let container_ids: Vec<String> = context
+            .get_output(deploy_job_id, "container_ids")?
+            .unwrap_or_default();
+
+        for container_id in &container_ids {
+            if let Err(e) = self.container_deployer.stop_container(container_id).await {
+                warn!("Failed to stop container {}: {}", container_id, e);
+            }
+            match self.container_deployer.remove_container(container_id).await {
+                Ok(_) => {
+                    self.log(format!("🧹 Removed container {}", container_id))
+                        .await
+                        .ok();
+                }
+                Err(e) => {
+                    self.log(format!(
+                        "⚠️  Failed to remove container {}: {}",
+                        container_id, e
+                    ))
+                    .await
+                    .ok();
+                }
+            }
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_gate_response_parsing() {
+        let response = GateResponse::parse(
+            r#"{"status": "in_progress", "details_url": "https://ci.example.com/runs/7",
+               "status_url": "https://ci.example.com/api/runs/7"}"#,
+        );
+        assert_eq!(response.state(), Some(DeployGateState::Pending));
+        assert_eq!(
+            response.status_url.as_deref(),
+            Some("https://ci.example.com/api/runs/7")
+        );
+
+        let response = GateResponse::parse(r#"{"status": "FAILURE", "message": "e2e failed"}"#);
+        assert_eq!(response.state(), Some(DeployGateState::Failed));
+        assert_eq!(
+            GateResponse::parse(r#"{"status": "success"}"#).state(),
+            Some(DeployGateState::Passed)
+        );
+
+        // Anything but a JSON object, e.g. "OK", carries no verdict
+        assert_eq!(GateResponse::parse("OK"), GateResponse::default());
+        assert_eq!(GateResponse::parse(r#"{"status": "maybe"}"#).state(), None);
+    }
+
+    #[test]
+    fn test_callback_token_and_signature() {
+        let token = generate_callback_token();
+        assert!(token.starts_with("dg_"));
+        assert_eq!(token.len(), 43);
+        assert_ne!(token, generate_callback_token());
+        assert_eq!(hash_callback_token(&token).len(), 64);
+
+        let signature = sign_body("secret", "1700000000", r#"{"deployment_id":1}"#);
+        assert!(signature.starts_with("sha256="));
+        assert_eq!(
+            signature,
+            sign_body("secret", "1700000000", r#"{"deployment_id":1}"#)
+        );
+        assert_ne!(
+            signature,
+            sign_body("secret", "1700000001", r#"{"deployment_id":1}"#)
+        );
+    }
+}
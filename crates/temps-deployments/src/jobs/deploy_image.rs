@@ -5,7 +5,7 @@
 use async_trait::async_trait;
 use futures::StreamExt;
 use serde::{Deserialize, Serialize};
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 use std::path::PathBuf;
 use std::sync::{Arc, Mutex};
 use temps_core::{JobResult, WorkflowContext, WorkflowError, WorkflowTask};
@@ -13,8 +13,14 @@ use temps_deployer::{
     ContainerDeployer, ContainerStatus as DeployerContainerStatus, DeployRequest, PortMapping,
     Protocol, ResourceLimits, RestartPolicy,
 };
+use temps_entities::deployment_config::{
+    ExecHealthCheckConfig, ProcessTypeConfig, StartupProbeConfig, WEB_PROCESS_TYPE,
+};
+use temps_entities::deployments::ProcessType;
 use temps_logs::{LogLevel, LogService};
 
+use crate::utils::procfile::{parse_procfile, plan_processes, ProcessPlan, PROCFILE_NAME};
+
 /// Typed output from BuildImageJob
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct BuildImageOutput {
@@ -78,6 +84,16 @@ pub struct DeploymentOutput {
     pub container_ids: Vec<String>,
     /// List of all allocated host ports (one per replica)
     pub host_ports: Vec<u16>,
+    /// Internal base URL of each replica, e.g. `http://app-1:3000`
+    pub endpoints: Vec<String>,
+}
+
+/// Container of a process type that gets no traffic, like a worker
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
+pub struct WorkerContainer {
+    pub process_type: String,
+    pub container_id: String,
+    pub container_name: String,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
@@ -164,6 +180,37 @@ pub struct DeployImageJob {
     log_stream_task: Arc<Mutex<Option<tokio::task::JoinHandle<()>>>>,
     /// Optional: directly provided image tag (for external/pre-built images, bypasses BuildImageJob lookup)
     external_image_tag: Option<String>,
+    /// Deploy metadata recorded as `sh.temps.*` labels on the containers
+    deploy_metadata: temps_deployer::labels::DeployMetadata,
+    /// Log driver of the containers; the Docker daemon's default when unset
+    log_config: Option<temps_deployer::ContainerLogConfig>,
+    /// Host paths mounted into the containers
+    bind_mounts: Vec<temps_deployer::BindMount>,
+    /// Whether the image is pulled again even when a local copy exists
+    pull_policy: temps_deployer::ImagePullPolicy,
+    /// Nameservers, search domains and `/etc/hosts` entries of the containers
+    dns: temps_deployer::ContainerDns,
+    /// Signal that stops the containers and the seconds they get before the kill
+    stop_signal: Option<String>,
+    stop_timeout_seconds: Option<u32>,
+    /// Replicas and commands of the process types, by name
+    processes: BTreeMap<String, ProcessTypeConfig>,
+    /// Process types to run in place of the build's Procfile, as promotions do
+    procfile: Option<Vec<ProcessType>>,
+    /// Command of the web process in place of the image's or the Procfile's
+    start_command: Option<String>,
+    /// Entrypoint of the web containers in place of the image's
+    entrypoint: Option<Vec<String>>,
+    /// Arguments of the web containers in place of the image's command, unwrapped by
+    /// a shell
+    args: Option<Vec<String>>,
+    ulimits: Vec<temps_deployer::Ulimit>,
+    shm_size_bytes: Option<u64>,
+    /// Probe the web containers must pass before their health checks begin
+    startup_probe: Option<StartupProbeConfig>,
+    /// Command whose exit code is the web containers' health, in place of the HTTP
+    /// health check
+    exec_health_check: Option<ExecHealthCheckConfig>,
 }
 
 impl std::fmt::Debug for DeployImageJob {
@@ -196,6 +243,22 @@ impl DeployImageJob {
             container_ids: Arc::new(Mutex::new(Vec::new())),
             log_stream_task: Arc::new(Mutex::new(None)),
             external_image_tag: None,
+            deploy_metadata: temps_deployer::labels::DeployMetadata::default(),
+            log_config: None,
+            bind_mounts: Vec::new(),
+            pull_policy: temps_deployer::ImagePullPolicy::default(),
+            dns: temps_deployer::ContainerDns::default(),
+            stop_signal: None,
+            stop_timeout_seconds: None,
+            processes: BTreeMap::new(),
+            procfile: None,
+            start_command: None,
+            entrypoint: None,
+            args: None,
+            ulimits: Vec::new(),
+            shm_size_bytes: None,
+            startup_probe: None,
+            exec_health_check: None,
         }
     }
 
@@ -239,6 +302,134 @@ impl DeployImageJob {
         self
     }
 
+    pub fn with_deploy_metadata(
+        mut self,
+        deploy_metadata: temps_deployer::labels::DeployMetadata,
+    ) -> Self {
+        self.deploy_metadata = deploy_metadata;
+        self
+    }
+
+    pub fn with_log_config(mut self, log_config: temps_deployer::ContainerLogConfig) -> Self {
+        self.log_config = Some(log_config);
+        self
+    }
+
+    pub fn with_bind_mount(mut self, bind_mount: temps_deployer::BindMount) -> Self {
+        self.bind_mounts.push(bind_mount);
+        self
+    }
+
+    pub fn with_pull_policy(mut self, pull_policy: temps_deployer::ImagePullPolicy) -> Self {
+        self.pull_policy = pull_policy;
+        self
+    }
+
+    pub fn with_dns(mut self, dns: temps_deployer::ContainerDns) -> Self {
+        self.dns = dns;
+        self
+    }
+
+    pub fn with_stop_signal(
+        mut self,
+        stop_signal: Option<String>,
+        stop_timeout_seconds: Option<u32>,
+    ) -> Self {
+        self.stop_signal = stop_signal;
+        self.stop_timeout_seconds = stop_timeout_seconds;
+        self
+    }
+
+    pub fn with_processes(mut self, processes: BTreeMap<String, ProcessTypeConfig>) -> Self {
+        self.processes = processes;
+        self
+    }
+
+    /// Run these process types instead of reading the Procfile of the build
+    pub fn with_procfile(mut self, procfile: Vec<ProcessType>) -> Self {
+        self.procfile = Some(procfile);
+        self
+    }
+
+    pub fn with_start_command(mut self, start_command: String) -> Self {
+        self.start_command = Some(start_command);
+        self
+    }
+
+    pub fn with_entrypoint(mut self, entrypoint: Vec<String>) -> Self {
+        self.entrypoint = Some(entrypoint);
+        self
+    }
+
+    /// Arguments of the web containers, which replace the command of the web process
+    pub fn with_args(mut self, args: Vec<String>) -> Self {
+        self.args = Some(args);
+        self
+    }
+
+    /// Ulimits and `/dev/shm` size of the containers
+    pub fn with_container_limits(
+        mut self,
+        ulimits: Vec<temps_deployer::Ulimit>,
+        shm_size_bytes: Option<u64>,
+    ) -> Self {
+        self.ulimits = ulimits;
+        self.shm_size_bytes = shm_size_bytes;
+        self
+    }
+
+    pub fn with_startup_probe(mut self, startup_probe: StartupProbeConfig) -> Self {
+        self.startup_probe = Some(startup_probe);
+        self
+    }
+
+    pub fn with_exec_health_check(mut self, exec_health_check: ExecHealthCheckConfig) -> Self {
+        self.exec_health_check = Some(exec_health_check);
+        self
+    }
+
+    /// Configured process types, with the start command override as web's command
+    fn configured_processes(&self) -> BTreeMap<String, ProcessTypeConfig> {
+        let mut processes = self.processes.clone();
+        if let Some(start_command) = &self.start_command {
+            processes
+                .entry(WEB_PROCESS_TYPE.to_string())
+                .or_default()
+                .command = Some(start_command.clone());
+        }
+        processes
+    }
+
+    /// Container labels: the standard identification set plus the deploy metadata
+    ///
+    /// The image digest is the ID of the built image, or the external image itself
+    /// when it is referenced by ID (as promotions do).
+    fn container_labels(
+        &self,
+        context: &WorkflowContext,
+        image_output: &BuildImageOutput,
+        process_type: &str,
+    ) -> HashMap<String, String> {
+        let mut labels = temps_deployer::labels::deployment_labels(
+            context.project_id,
+            context.environment_id,
+            context.deployment_id,
+        );
+        labels.insert(
+            temps_deployer::labels::LABEL_PROCESS_TYPE.to_string(),
+            process_type.to_string(),
+        );
+        let mut metadata = self.deploy_metadata.clone();
+        if metadata.image_digest.is_none() {
+            metadata.image_digest = [&image_output.image_id, &image_output.image_tag]
+                .into_iter()
+                .find(|id| id.starts_with("sha256:"))
+                .cloned();
+        }
+        metadata.apply(&mut labels);
+        labels
+    }
+
     /// Write log message to job-specific log file
     /// Write log message to both job-specific log file and context log writer
     async fn log(&self, context: &WorkflowContext, message: String) -> Result<(), WorkflowError> {
@@ -365,6 +556,228 @@ impl DeployImageJob {
         &self.target
     }
 
+    /// Process types of the app: the ones given to the job, or those of the Procfile
+    /// at the root of the build context
+    async fn load_process_types(
+        &self,
+        image_output: &BuildImageOutput,
+        context: &WorkflowContext,
+    ) -> Result<Vec<ProcessType>, WorkflowError> {
+        if let Some(procfile) = &self.procfile {
+            return Ok(procfile.clone());
+        }
+        if self.external_image_tag.is_some() {
+            return Ok(Vec::new());
+        }
+
+        let procfile_path = image_output.build_context.join(PROCFILE_NAME);
+        let contents = match tokio::fs::read_to_string(&procfile_path).await {
+            Ok(contents) => contents,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+            Err(e) => {
+                return Err(WorkflowError::JobExecutionFailed(format!(
+                    "Failed to read the Procfile: {}",
+                    e
+                )))
+            }
+        };
+        let process_types = parse_procfile(&contents)
+            .map_err(|e| WorkflowError::JobExecutionFailed(format!("Invalid Procfile: {}", e)))?;
+        self.log(
+            context,
+            format!(
+                "Found a Procfile with process types: {}",
+                process_types
+                    .iter()
+                    .map(|process| process.name.as_str())
+                    .collect::<Vec<_>>()
+                    .join(", ")
+            ),
+        )
+        .await?;
+        Ok(process_types)
+    }
+
+    /// Request for a container of the deployment
+    fn deploy_request(
+        &self,
+        image_output: &BuildImageOutput,
+        context: &WorkflowContext,
+        container_name: String,
+        port_mappings: Vec<PortMapping>,
+        process: &ProcessPlan,
+    ) -> DeployRequest {
+        let resource_limits = ResourceLimits {
+            cpu_limit: self
+                .config
+                .resources
+                .cpu_limit
+                .as_ref()
+                .and_then(|s| s.parse::<f64>().ok()),
+            memory_limit_mb: self
+                .config
+                .resources
+                .memory_limit
+                .as_ref()
+                .and_then(|s| s.trim_end_matches("Mi").parse::<u64>().ok()),
+            disk_limit_mb: None,
+        };
+
+        DeployRequest {
+            image_name: image_output.image_tag.clone(),
+            container_name,
+            // PORT and HOST already included from workflow planner
+            environment_vars: self.config.environment_variables.clone(),
+            port_mappings,
+            network_name: None,
+            resource_limits,
+            restart_policy: RestartPolicy::Always,
+            log_path: std::env::temp_dir().join(format!("deploy_{}.log", self.job_id)),
+            command: match &self.args {
+                Some(args) if process.is_web() => Some(args.clone()),
+                _ => process.container_command(),
+            },
+            entrypoint: if process.is_web() {
+                self.entrypoint.clone()
+            } else {
+                None
+            },
+            labels: self.container_labels(context, image_output, &process.name),
+            log_config: self.log_config.clone(),
+            bind_mounts: self.bind_mounts.clone(),
+            pull_policy: self.pull_policy,
+            dns: self.dns.clone(),
+            stop_signal: self.stop_signal.clone(),
+            stop_timeout_seconds: self.stop_timeout_seconds,
+            ulimits: self.ulimits.clone(),
+            shm_size_bytes: self.shm_size_bytes,
+        }
+    }
+
+    /// Start the containers of the process types that get no traffic
+    ///
+    /// They have no port to check, so a container is ready once it is running.
+    async fn deploy_workers(
+        &self,
+        image_output: &BuildImageOutput,
+        context: &WorkflowContext,
+        processes: &[ProcessPlan],
+    ) -> Result<Vec<WorkerContainer>, WorkflowError> {
+        let mut workers = Vec::new();
+        for process in processes {
+            if process.replicas == 0 {
+                self.log(
+                    context,
+                    format!(
+                        "Process type {} is scaled to 0, not starting it",
+                        process.name
+                    ),
+                )
+                .await?;
+                continue;
+            }
+            self.log(
+                context,
+                format!(
+                    "🚀 Starting {} replica(s) of process type {}: {}",
+                    process.replicas,
+                    process.name,
+                    process.command.as_deref().unwrap_or_default()
+                ),
+            )
+            .await?;
+
+            for replica_index in 0..process.replicas {
+                let container_name = if process.replicas > 1 {
+                    format!(
+                        "{}-{}-{}",
+                        self.config.service_name,
+                        process.name,
+                        replica_index + 1
+                    )
+                } else {
+                    format!("{}-{}", self.config.service_name, process.name)
+                };
+                let request =
+                    self.deploy_request(image_output, context, container_name, Vec::new(), process);
+                let deploy_result = self
+                    .container_deployer
+                    .deploy_container(request)
+                    .await
+                    .map_err(|e| {
+                        WorkflowError::JobExecutionFailed(format!(
+                            "Failed to deploy {} container: {}",
+                            process.name, e
+                        ))
+                    })?;
+                self.container_ids
+                    .lock()
+                    .unwrap()
+                    .push(deploy_result.container_id.clone());
+
+                self.wait_for_worker(context, &process.name, &deploy_result.container_id)
+                    .await?;
+                self.log(
+                    context,
+                    format!(
+                        "✅ {} replica {}/{} is running",
+                        process.name,
+                        replica_index + 1,
+                        process.replicas
+                    ),
+                )
+                .await?;
+                workers.push(WorkerContainer {
+                    process_type: process.name.clone(),
+                    container_id: deploy_result.container_id,
+                    container_name: deploy_result.container_name,
+                });
+            }
+        }
+        Ok(workers)
+    }
+
+    /// Wait until a worker container runs, failing when it exits instead
+    async fn wait_for_worker(
+        &self,
+        context: &WorkflowContext,
+        process_type: &str,
+        container_id: &str,
+    ) -> Result<(), WorkflowError> {
+        let max_wait_time = std::time::Duration::from_secs(120);
+        let start_time = std::time::Instant::now();
+        loop {
+            if let Ok(info) = self
+                .container_deployer
+                .get_container_info(container_id)
+                .await
+            {
+                match info.status {
+                    DeployerContainerStatus::Running => return Ok(()),
+                    DeployerContainerStatus::Exited | DeployerContainerStatus::Dead => {
+                        self.log(
+                            context,
+                            format!("❌ Container of process type {} exited", process_type),
+                        )
+                        .await?;
+                        return Err(WorkflowError::JobExecutionFailed(format!(
+                            "Container of process type {} exited on start - check container logs for details",
+                            process_type
+                        )));
+                    }
+                    _ => {}
+                }
+            }
+            if start_time.elapsed() > max_wait_time {
+                return Err(WorkflowError::JobExecutionFailed(format!(
+                    "Container of process type {} took too long to start",
+                    process_type
+                )));
+            }
+            tokio::time::sleep(std::time::Duration::from_secs(2)).await;
+        }
+    }
+
     /// Remove all containers if they exist (called on timeout/failure/cancellation)
     async fn cleanup_container(&self, context: &WorkflowContext) -> Result<(), WorkflowError> {
         // First, abort the background log streaming task if running
@@ -427,6 +840,7 @@ impl DeployImageJob {
         &self,
         image_output: &BuildImageOutput,
         context: &WorkflowContext,
+        web: &ProcessPlan,
     ) -> Result<DeploymentOutput, WorkflowError> {
         self.log(
             context,
@@ -458,6 +872,7 @@ impl DeployImageJob {
         // Deploy multiple replicas
         let mut all_container_ids = Vec::new();
         let mut all_host_ports = Vec::new();
+        let mut all_endpoints = Vec::new();
         let mut deployment_error: Option<WorkflowError> = None;
 
         for replica_index in 0..self.config.replicas {
@@ -472,12 +887,13 @@ impl DeployImageJob {
             .await?;
 
             match self
-                .deploy_single_replica(image_output, context, replica_index)
+                .deploy_single_replica(image_output, context, web, replica_index)
                 .await
             {
-                Ok((container_id, host_port)) => {
+                Ok((container_id, host_port, endpoint)) => {
                     all_container_ids.push(container_id);
                     all_host_ports.push(host_port);
+                    all_endpoints.push(endpoint);
                 }
                 Err(e) => {
                     self.log(
@@ -531,6 +947,7 @@ impl DeployImageJob {
             resources: self.config.resources.clone(),
             container_ids: all_container_ids,
             host_ports: all_host_ports,
+            endpoints: all_endpoints,
         })
     }
 
@@ -539,14 +956,13 @@ impl DeployImageJob {
         &self,
         image_output: &BuildImageOutput,
         context: &WorkflowContext,
+        web: &ProcessPlan,
         replica_index: u32,
-    ) -> Result<(String, u16), WorkflowError> {
+    ) -> Result<(String, u16, String), WorkflowError> {
         // Prepare deployment request using temps-deployer types
         self.log(context, "Deploying container image...".to_string())
             .await?;
 
-        let log_path = std::env::temp_dir().join(format!("deploy_{}.log", self.job_id));
-
         // Determine the actual container port to expose
         // Priority: Image EXPOSE directive > configured port (from environment/project/default)
         let container_port = self
@@ -570,29 +986,11 @@ impl DeployImageJob {
             protocol: Protocol::Tcp,
         }];
 
-        let resource_limits = ResourceLimits {
-            cpu_limit: self
-                .config
-                .resources
-                .cpu_limit
-                .as_ref()
-                .and_then(|s| s.parse::<f64>().ok()),
-            memory_limit_mb: self
-                .config
-                .resources
-                .memory_limit
-                .as_ref()
-                .and_then(|s| s.trim_end_matches("Mi").parse::<u64>().ok()),
-            disk_limit_mb: None,
-        };
-
-        // Use environment variables from config (PORT and HOST already included from workflow planner)
-        let environment_vars = self.config.environment_variables.clone();
-
         tracing::debug!(
             "🌍 Deploying container with {} environment variables: {}",
-            environment_vars.len(),
-            environment_vars
+            self.config.environment_variables.len(),
+            self.config
+                .environment_variables
                 .keys()
                 .cloned()
                 .collect::<Vec<_>>()
@@ -606,17 +1004,8 @@ impl DeployImageJob {
             self.config.service_name.clone()
         };
 
-        let deploy_request = DeployRequest {
-            image_name: image_output.image_tag.clone(),
-            container_name,
-            environment_vars,
-            port_mappings,
-            network_name: None,
-            resource_limits,
-            restart_policy: RestartPolicy::Always,
-            log_path,
-            command: None,
-        };
+        let deploy_request =
+            self.deploy_request(image_output, context, container_name, port_mappings, web);
 
         let deploy_result = self
             .container_deployer
@@ -836,142 +1225,186 @@ impl DeployImageJob {
             deploy_result.host_port,
             self.config.health_check_path.as_deref(),
         );
-        self.log(context, format!("Health check URL: {}", health_check_url))
-            .await?;
 
-        let client = reqwest::Client::builder()
-            .timeout(std::time::Duration::from_secs(5))
-            .build()
-            .map_err(|e| {
-                WorkflowError::JobExecutionFailed(format!("Failed to create HTTP client: {}", e))
-            })?;
-
-        let mut consecutive_successes = 0;
-        let required_successes = 2; // Require 2 consecutive successful connections
-        let mut first_error_time: Option<std::time::Instant> = None;
-        let max_error_duration = std::time::Duration::from_secs(60); // Only retry errors for 60 seconds
+        // Slow booters get the startup probe's budget to come up; the health checks,
+        // and their timeouts, only start once it passes
+        let start_time = match &self.startup_probe {
+            Some(probe) => {
+                let startup_url = temps_core::DeploymentMode::build_container_url(
+                    &deploy_result.container_name,
+                    deploy_result.container_port,
+                    deploy_result.host_port,
+                    probe
+                        .path
+                        .as_deref()
+                        .or(self.config.health_check_path.as_deref()),
+                );
+                self.wait_for_startup(context, &deploy_result.container_id, &startup_url, probe)
+                    .await?;
+                std::time::Instant::now()
+            }
+            None => start_time,
+        };
 
-        loop {
-            // Check for overall timeout (5 minutes)
-            if start_time.elapsed() > max_wait_time {
-                self.log(
-                    context,
-                    "⏱️  Application readiness timeout - connectivity checks failed".to_string(),
-                )
+        if let Some(check) = &self.exec_health_check {
+            self.wait_for_exec_health(
+                context,
+                &deploy_result.container_id,
+                check,
+                start_time,
+                max_wait_time,
+            )
+            .await?;
+        } else {
+            self.log(context, format!("Health check URL: {}", health_check_url))
                 .await?;
-                // Clean up container on connectivity timeout
-                self.cleanup_container(context).await?;
-                return Err(WorkflowError::JobExecutionFailed(
-                    "Application timeout - connectivity checks did not pass in time".to_string(),
-                ));
-            }
 
-            // Check for error timeout (60 seconds of consecutive 4xx/5xx errors)
-            if let Some(error_start) = first_error_time {
-                if error_start.elapsed() > max_error_duration {
+            let client = reqwest::Client::builder()
+                .timeout(std::time::Duration::from_secs(5))
+                .build()
+                .map_err(|e| {
+                    WorkflowError::JobExecutionFailed(format!(
+                        "Failed to create HTTP client: {}",
+                        e
+                    ))
+                })?;
+
+            let mut consecutive_successes = 0;
+            let required_successes = 2; // Require 2 consecutive successful connections
+            let mut first_error_time: Option<std::time::Instant> = None;
+            let max_error_duration = std::time::Duration::from_secs(60); // Only retry errors for 60 seconds
+
+            loop {
+                // Check for overall timeout (5 minutes)
+                if start_time.elapsed() > max_wait_time {
                     self.log(
                         context,
-                        "⏱️  Application health check failed - server returning errors for too long".to_string(),
+                        "⏱️  Application readiness timeout - connectivity checks failed"
+                            .to_string(),
                     )
                     .await?;
-                    // Clean up container on health check failure
+                    // Clean up container on connectivity timeout
                     self.cleanup_container(context).await?;
                     return Err(WorkflowError::JobExecutionFailed(
-                        "Application health check failed - server returned error status codes for 60 seconds".to_string(),
+                        "Application timeout - connectivity checks did not pass in time"
+                            .to_string(),
                     ));
                 }
-            }
 
-            // Check if container is still running (it may have crashed)
-            // This prevents waiting 5 minutes for a container that already exited
-            if let Ok(container_info) = self
-                .container_deployer
-                .get_container_info(&deploy_result.container_id)
-                .await
-            {
-                match container_info.status {
-                    DeployerContainerStatus::Exited | DeployerContainerStatus::Dead => {
+                // Check for error timeout (60 seconds of consecutive 4xx/5xx errors)
+                if let Some(error_start) = first_error_time {
+                    if error_start.elapsed() > max_error_duration {
                         self.log(
                             context,
-                            "❌ Container crashed during startup - application failed to start"
-                                .to_string(),
+                            "⏱️  Application health check failed - server returning errors for too long".to_string(),
                         )
                         .await?;
-                        // Clean up crashed container
+                        // Clean up container on health check failure
                         self.cleanup_container(context).await?;
                         return Err(WorkflowError::JobExecutionFailed(
-                            "Container crashed during startup - check container logs for details"
-                                .to_string(),
+                            "Application health check failed - server returned error status codes for 60 seconds".to_string(),
                         ));
                     }
-                    _ => {
-                        // Container is still running, continue with connectivity checks
-                    }
                 }
-            }
 
-            match client.get(&health_check_url).send().await {
-                Ok(response) => {
-                    let status = response.status();
-
-                    // Only 2xx and 3xx are considered healthy
-                    if status.is_success() || status.is_redirection() {
-                        consecutive_successes += 1;
-                        first_error_time = None; // Reset error timer on success
-
-                        let message = format!(
-                            "✅ Health check passed - server healthy with status {} ({}/{})",
-                            status, consecutive_successes, required_successes
-                        );
-                        if let (Some(ref log_id), Some(ref log_service)) =
-                            (&self.log_id, &self.log_service)
-                        {
-                            log_service
-                                .append_structured_log(log_id, LogLevel::Success, message.clone())
-                                .await
-                                .map_err(|e| {
-                                    WorkflowError::Other(format!("Failed to write log: {}", e))
-                                })?;
+                // Check if container is still running (it may have crashed)
+                // This prevents waiting 5 minutes for a container that already exited
+                if let Ok(container_info) = self
+                    .container_deployer
+                    .get_container_info(&deploy_result.container_id)
+                    .await
+                {
+                    match container_info.status {
+                        DeployerContainerStatus::Exited | DeployerContainerStatus::Dead => {
+                            self.log(
+                                context,
+                                "❌ Container crashed during startup - application failed to start"
+                                    .to_string(),
+                            )
+                            .await?;
+                            // Clean up crashed container
+                            self.cleanup_container(context).await?;
+                            return Err(WorkflowError::JobExecutionFailed(
+                                "Container crashed during startup - check container logs for details"
+                                    .to_string(),
+                            ));
                         }
-                        context.log(&message).await?;
+                        _ => {
+                            // Container is still running, continue with connectivity checks
+                        }
+                    }
+                }
+
+                match client.get(&health_check_url).send().await {
+                    Ok(response) => {
+                        let status = response.status();
+
+                        // Only 2xx and 3xx are considered healthy
+                        if status.is_success() || status.is_redirection() {
+                            consecutive_successes += 1;
+                            first_error_time = None; // Reset error timer on success
+
+                            let message = format!(
+                                "✅ Health check passed - server healthy with status {} ({}/{})",
+                                status, consecutive_successes, required_successes
+                            );
+                            if let (Some(ref log_id), Some(ref log_service)) =
+                                (&self.log_id, &self.log_service)
+                            {
+                                log_service
+                                    .append_structured_log(
+                                        log_id,
+                                        LogLevel::Success,
+                                        message.clone(),
+                                    )
+                                    .await
+                                    .map_err(|e| {
+                                        WorkflowError::Other(format!("Failed to write log: {}", e))
+                                    })?;
+                            }
+                            context.log(&message).await?;
 
-                        if consecutive_successes >= required_successes {
-                            self.log(context, "✅ Application is ready and healthy!".to_string())
+                            if consecutive_successes >= required_successes {
+                                self.log(
+                                    context,
+                                    "✅ Application is ready and healthy!".to_string(),
+                                )
                                 .await?;
-                            break;
-                        }
-                        tokio::time::sleep(std::time::Duration::from_secs(2)).await;
-                    } else {
-                        // 4xx, 5xx = application error
-                        consecutive_successes = 0;
-
-                        // Start error timer if this is the first error
-                        if first_error_time.is_none() {
-                            first_error_time = Some(std::time::Instant::now());
-                        }
+                                break;
+                            }
+                            tokio::time::sleep(std::time::Duration::from_secs(2)).await;
+                        } else {
+                            // 4xx, 5xx = application error
+                            consecutive_successes = 0;
+
+                            // Start error timer if this is the first error
+                            if first_error_time.is_none() {
+                                first_error_time = Some(std::time::Instant::now());
+                            }
 
-                        let elapsed = first_error_time.unwrap().elapsed().as_secs();
+                            let elapsed = first_error_time.unwrap().elapsed().as_secs();
+                            self.log(
+                                context,
+                                format!(
+                                    "❌ Health check failed - server returned error status {} (not healthy), retrying... ({}/60s)",
+                                    status, elapsed
+                                ),
+                            )
+                            .await?;
+                            tokio::time::sleep(std::time::Duration::from_secs(5)).await;
+                        }
+                    }
+                    Err(e) => {
+                        consecutive_successes = 0; // Reset counter on connection error
+                        first_error_time = None; // Reset error timer - connection errors are expected during startup
                         self.log(
                             context,
-                            format!(
-                                "❌ Health check failed - server returned error status {} (not healthy), retrying... ({}/60s)",
-                                status, elapsed
-                            ),
+                            format!("⏳ Connectivity check failed ({}), retrying...", e),
                         )
                         .await?;
                         tokio::time::sleep(std::time::Duration::from_secs(5)).await;
                     }
                 }
-                Err(e) => {
-                    consecutive_successes = 0; // Reset counter on connection error
-                    first_error_time = None; // Reset error timer - connection errors are expected during startup
-                    self.log(
-                        context,
-                        format!("⏳ Connectivity check failed ({}), retrying...", e),
-                    )
-                    .await?;
-                    tokio::time::sleep(std::time::Duration::from_secs(5)).await;
-                }
             }
         }
 
@@ -987,8 +1420,259 @@ impl DeployImageJob {
         )
         .await?;
 
-        // Return container ID and host port
-        Ok((deploy_result.container_id, deploy_result.host_port))
+        // Return container ID, host port and internal URL
+        Ok((
+            deploy_result.container_id,
+            deploy_result.host_port,
+            endpoint_url,
+        ))
+    }
+
+    /// Probe a new container until it answers with a 2xx or 3xx, allowing the probe's
+    /// number of failed attempts before the deploy fails
+    async fn wait_for_startup(
+        &self,
+        context: &WorkflowContext,
+        container_id: &str,
+        url: &str,
+        probe: &StartupProbeConfig,
+    ) -> Result<(), WorkflowError> {
+        let period = std::time::Duration::from_secs(probe.period_seconds() as u64);
+        self.log(
+            context,
+            format!(
+                "🚦 Startup probe: {} every {}s, up to {} attempts ({}s)",
+                url,
+                probe.period_seconds(),
+                probe.failure_threshold(),
+                probe.startup_budget().as_secs()
+            ),
+        )
+        .await?;
+
+        let client = reqwest::Client::builder()
+            .timeout(std::time::Duration::from_secs(
+                probe.timeout_seconds() as u64
+            ))
+            .build()
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to create HTTP client: {}", e))
+            })?;
+
+        let probe_start = std::time::Instant::now();
+        let mut failures = 0;
+        loop {
+            // A container that exited won't finish booting
+            if let Ok(container_info) = self
+                .container_deployer
+                .get_container_info(container_id)
+                .await
+            {
+                if matches!(
+                    container_info.status,
+                    DeployerContainerStatus::Exited | DeployerContainerStatus::Dead
+                ) {
+                    self.log(
+                        context,
+                        "❌ Container crashed during startup - application failed to start"
+                            .to_string(),
+                    )
+                    .await?;
+                    self.cleanup_container(context).await?;
+                    return Err(WorkflowError::JobExecutionFailed(
+                        "Container crashed during startup - check container logs for details"
+                            .to_string(),
+                    ));
+                }
+            }
+
+            let attempt_start = std::time::Instant::now();
+            let failure = match client.get(url).send().await {
+                Ok(response)
+                    if response.status().is_success() || response.status().is_redirection() =>
+                {
+                    self.log(
+                        context,
+                        format!(
+                            "✅ Startup probe passed after {}s with status {}",
+                            probe_start.elapsed().as_secs(),
+                            response.status()
+                        ),
+                    )
+                    .await?;
+                    return Ok(());
+                }
+                Ok(response) => format!("status {}", response.status()),
+                Err(e) => e.to_string(),
+            };
+
+            failures += 1;
+            if failures >= probe.failure_threshold() {
+                self.log(
+                    context,
+                    format!(
+                        "❌ Startup probe failed {} times ({}), giving up",
+                        failures, failure
+                    ),
+                )
+                .await?;
+                self.cleanup_container(context).await?;
+                return Err(WorkflowError::JobExecutionFailed(format!(
+                    "Application didn't start - startup probe failed {} times in {}s",
+                    failures,
+                    probe_start.elapsed().as_secs()
+                )));
+            }
+            self.log(
+                context,
+                format!(
+                    "⏳ Application still starting ({}), startup probe {}/{}",
+                    failure,
+                    failures,
+                    probe.failure_threshold()
+                ),
+            )
+            .await?;
+            tokio::time::sleep(period.saturating_sub(attempt_start.elapsed())).await;
+        }
+    }
+
+    /// Run the exec health check in a new container until it passes the check's number
+    /// of times in a row; after the start period, its number of failed runs in a row
+    /// fails the deploy
+    async fn wait_for_exec_health(
+        &self,
+        context: &WorkflowContext,
+        container_id: &str,
+        check: &ExecHealthCheckConfig,
+        start_time: std::time::Instant,
+        max_wait_time: std::time::Duration,
+    ) -> Result<(), WorkflowError> {
+        let interval = std::time::Duration::from_secs(check.interval_seconds() as u64);
+        let start_period = std::time::Duration::from_secs(check.start_period_seconds() as u64);
+        self.log(
+            context,
+            format!(
+                "🩺 Exec health check: `{}` every {}s, {} passing runs in a row make it healthy",
+                check.command.join(" "),
+                check.interval_seconds(),
+                check.success_threshold()
+            ),
+        )
+        .await?;
+
+        let docker = bollard::Docker::connect_with_local_defaults().map_err(|e| {
+            WorkflowError::JobExecutionFailed(format!(
+                "Failed to connect to Docker for the exec health check: {}",
+                e
+            ))
+        })?;
+
+        let mut successes = 0;
+        let mut failures = 0;
+        loop {
+            // Flapping checks never reach either threshold
+            if start_time.elapsed() > max_wait_time {
+                self.log(
+                    context,
+                    "⏱️  Application readiness timeout - exec health check didn't pass in time"
+                        .to_string(),
+                )
+                .await?;
+                self.cleanup_container(context).await?;
+                return Err(WorkflowError::JobExecutionFailed(
+                    "Application timeout - exec health check did not pass in time".to_string(),
+                ));
+            }
+
+            // A container that exited won't become healthy
+            if let Ok(container_info) = self
+                .container_deployer
+                .get_container_info(container_id)
+                .await
+            {
+                if matches!(
+                    container_info.status,
+                    DeployerContainerStatus::Exited | DeployerContainerStatus::Dead
+                ) {
+                    self.log(
+                        context,
+                        "❌ Container crashed during startup - application failed to start"
+                            .to_string(),
+                    )
+                    .await?;
+                    self.cleanup_container(context).await?;
+                    return Err(WorkflowError::JobExecutionFailed(
+                        "Container crashed during startup - check container logs for details"
+                            .to_string(),
+                    ));
+                }
+            }
+
+            let attempt_start = std::time::Instant::now();
+            match run_exec_health_check(&docker, container_id, check).await {
+                Ok(()) => {
+                    successes += 1;
+                    failures = 0;
+                    self.log(
+                        context,
+                        format!(
+                            "✅ Exec health check passed ({}/{})",
+                            successes,
+                            check.success_threshold()
+                        ),
+                    )
+                    .await?;
+                    if successes >= check.success_threshold() {
+                        self.log(context, "✅ Application is ready and healthy!".to_string())
+                            .await?;
+                        return Ok(());
+                    }
+                }
+                Err(failure) if start_time.elapsed() < start_period => {
+                    successes = 0;
+                    self.log(
+                        context,
+                        format!(
+                            "⏳ Exec health check failed ({}), still in the {}s start period",
+                            failure,
+                            check.start_period_seconds()
+                        ),
+                    )
+                    .await?;
+                }
+                Err(failure) => {
+                    successes = 0;
+                    failures += 1;
+                    if failures >= check.failure_threshold() {
+                        self.log(
+                            context,
+                            format!(
+                                "❌ Exec health check failed {} times in a row ({}), giving up",
+                                failures, failure
+                            ),
+                        )
+                        .await?;
+                        self.cleanup_container(context).await?;
+                        return Err(WorkflowError::JobExecutionFailed(format!(
+                            "Application health check failed - exec health check failed {} times in a row",
+                            failures
+                        )));
+                    }
+                    self.log(
+                        context,
+                        format!(
+                            "❌ Exec health check failed ({}), retrying... ({}/{})",
+                            failure,
+                            failures,
+                            check.failure_threshold()
+                        ),
+                    )
+                    .await?;
+                }
+            }
+            tokio::time::sleep(interval.saturating_sub(attempt_start.elapsed())).await;
+        }
     }
 
     async fn validate_deployment_config(
@@ -1060,8 +1744,46 @@ impl WorkflowTask for DeployImageJob {
             BuildImageOutput::from_context(&context, &self.build_job_id)?
         };
 
+        // web gets the traffic; the other process types of the Procfile run beside it
+        let process_types = self.load_process_types(&image_output, &context).await?;
+        let processes = plan_processes(
+            &process_types,
+            self.config.replicas,
+            &self.configured_processes(),
+        );
+        let (web, workers) = processes
+            .split_first()
+            .expect("planned processes always include web");
+        if let Some(start_command) = &self.start_command {
+            self.log(
+                &context,
+                format!("Using start command override: {}", start_command),
+            )
+            .await?;
+        } else if let Some(args) = &self.args {
+            self.log(&context, format!("Using arguments override: {:?}", args))
+                .await?;
+        } else if let Some(command) = &web.command {
+            self.log(&context, format!("Running the web process: {}", command))
+                .await?;
+        }
+        if let Some(entrypoint) = &self.entrypoint {
+            self.log(
+                &context,
+                format!("Using entrypoint override: {:?}", entrypoint),
+            )
+            .await?;
+        }
+
         // Deploy the image (logs written in real-time)
-        let deployment_output = self.deploy_image(&image_output, &context).await?;
+        let deployment_output = self.deploy_image(&image_output, &context, web).await?;
+        let worker_containers = match self.deploy_workers(&image_output, &context, workers).await {
+            Ok(worker_containers) => worker_containers,
+            Err(e) => {
+                self.cleanup_container(&context).await?;
+                return Err(e);
+            }
+        };
 
         // Set typed job outputs
         context.set_output(&self.job_id, "status", &deployment_output.status)?;
@@ -1072,6 +1794,9 @@ impl WorkflowTask for DeployImageJob {
             &deployment_output.container_ids,
         )?;
         context.set_output(&self.job_id, "host_ports", &deployment_output.host_ports)?;
+        context.set_output(&self.job_id, "endpoints", &deployment_output.endpoints)?;
+        context.set_output(&self.job_id, "worker_containers", &worker_containers)?;
+        context.set_output(&self.job_id, "process_types", &process_types)?;
 
         // For backward compatibility, also set singular fields using the first container
         if !deployment_output.container_ids.is_empty() {
@@ -1243,6 +1968,73 @@ impl Default for DeployImageJobBuilder {
     }
 }
 
+/// Longest command output quoted when an exec health check fails
+const EXEC_HEALTH_CHECK_OUTPUT_CHARS: usize = 200;
+
+/// Run an exec health check once in a container; the reason it failed otherwise
+async fn run_exec_health_check(
+    docker: &bollard::Docker,
+    container_id: &str,
+    check: &ExecHealthCheckConfig,
+) -> Result<(), String> {
+    let run = async {
+        let exec = docker
+            .create_exec(
+                container_id,
+                bollard::exec::CreateExecOptions {
+                    cmd: Some(check.command.clone()),
+                    attach_stdout: Some(true),
+                    attach_stderr: Some(true),
+                    ..Default::default()
+                },
+            )
+            .await
+            .map_err(|e| format!("failed to create exec: {}", e))?;
+
+        // The exit code is only known once the output is drained
+        let mut output_text = String::new();
+        if let bollard::exec::StartExecResults::Attached { mut output, .. } = docker
+            .start_exec(&exec.id, None)
+            .await
+            .map_err(|e| format!("failed to start exec: {}", e))?
+        {
+            while let Some(Ok(chunk)) = output.next().await {
+                output_text.push_str(&String::from_utf8_lossy(&chunk.into_bytes()));
+            }
+        }
+
+        let exit_code = docker
+            .inspect_exec(&exec.id)
+            .await
+            .map_err(|e| format!("failed to inspect exec: {}", e))?
+            .exit_code;
+        match exit_code {
+            Some(0) => Ok(()),
+            Some(code) => {
+                let output = output_text.trim();
+                if output.is_empty() {
+                    Err(format!("exit code {}", code))
+                } else {
+                    Err(format!(
+                        "exit code {}: {}",
+                        code,
+                        output
+                            .chars()
+                            .take(EXEC_HEALTH_CHECK_OUTPUT_CHARS)
+                            .collect::<String>()
+                    ))
+                }
+            }
+            None => Err("command didn't report an exit code".to_string()),
+        }
+    };
+
+    let timeout = std::time::Duration::from_secs(check.timeout_seconds() as u64);
+    tokio::time::timeout(timeout, run)
+        .await
+        .unwrap_or_else(|_| Err(format!("timed out after {}s", check.timeout_seconds())))
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -1336,6 +2128,7 @@ mod tests {
                 created_at: chrono::Utc::now(),
                 ports: vec![],
                 environment_vars: HashMap::new(),
+                labels: HashMap::new(),
             })
         }
 
@@ -1444,6 +2237,261 @@ mod tests {
         // This is tested implicitly through the container deployment flow
     }
 
+    /// Answers the first `failures` requests with 503, as an app still booting, and
+    /// the rest with 200
+    async fn booting_app(failures: usize) -> String {
+        use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let address = listener.local_addr().unwrap();
+        tokio::spawn(async move {
+            let mut served = 0;
+            while let Ok((mut stream, _)) = listener.accept().await {
+                let mut request = [0u8; 1024];
+                let _ = stream.read(&mut request).await;
+                let status = if served < failures {
+                    "503 Service Unavailable"
+                } else {
+                    "200 OK"
+                };
+                served += 1;
+                let response = format!(
+                    "HTTP/1.1 {}\r\ncontent-length: 0\r\nconnection: close\r\n\r\n",
+                    status
+                );
+                let _ = stream.write_all(response.as_bytes()).await;
+            }
+        });
+        format!("http://{}/", address)
+    }
+
+    #[tokio::test]
+    async fn test_startup_probe_allows_its_failure_threshold() {
+        let container_deployer: Arc<dyn ContainerDeployer> =
+            Arc::new(TrackingMockContainerDeployer::new());
+        let job = DeployImageJobBuilder::new()
+            .build_job_id("build_image".to_string())
+            .target(DeploymentTarget::Docker {
+                registry_url: "local".to_string(),
+                network: None,
+            })
+            .service_name("myapp".to_string())
+            .build(container_deployer)
+            .unwrap();
+        let context = crate::test_utils::create_test_context("test".to_string(), 7, 1, 2);
+        let probe = |failure_threshold| StartupProbeConfig {
+            path: None,
+            period_seconds: Some(1),
+            timeout_seconds: Some(1),
+            failure_threshold: Some(failure_threshold),
+        };
+
+        // Two failed attempts while booting fit in a threshold of three
+        let url = booting_app(2).await;
+        job.wait_for_startup(&context, "container_myapp", &url, &probe(3))
+            .await
+            .expect("the app should start within the threshold");
+
+        let url = booting_app(2).await;
+        assert!(job
+            .wait_for_startup(&context, "container_myapp", &url, &probe(2))
+            .await
+            .is_err());
+    }
+
+    #[test]
+    fn test_container_labels_carry_deploy_metadata() {
+        let container_deployer: Arc<dyn ContainerDeployer> =
+            Arc::new(TrackingMockContainerDeployer::new());
+        let job = DeployImageJobBuilder::new()
+            .build_job_id("build_image".to_string())
+            .target(DeploymentTarget::Docker {
+                registry_url: "local".to_string(),
+                network: None,
+            })
+            .service_name("myapp".to_string())
+            .build(container_deployer)
+            .unwrap()
+            .with_deploy_metadata(temps_deployer::labels::DeployMetadata {
+                commit_sha: Some("4b825dc".to_string()),
+                branch: Some("main".to_string()),
+                environment: Some("production".to_string()),
+                triggered_by: Some("git_push".to_string()),
+                image_digest: None,
+            });
+        let context = crate::test_utils::create_test_context("test".to_string(), 7, 1, 2);
+        let image_output = BuildImageOutput {
+            image_tag: "myapp:latest".to_string(),
+            image_id: "sha256:abc123".to_string(),
+            size_bytes: 0,
+            build_context: PathBuf::from("."),
+            dockerfile_path: PathBuf::from("Dockerfile"),
+        };
+
+        let labels = job.container_labels(&context, &image_output, "web");
+        let label = |key: &str| labels.get(key).map(String::as_str);
+        assert_eq!(
+            label(temps_deployer::labels::LABEL_DEPLOYMENT_ID),
+            Some("7")
+        );
+        assert_eq!(
+            label(temps_deployer::labels::LABEL_PROCESS_TYPE),
+            Some("web")
+        );
+        assert_eq!(
+            label(temps_deployer::labels::LABEL_COMMIT_SHA),
+            Some("4b825dc")
+        );
+        assert_eq!(label(temps_deployer::labels::LABEL_BRANCH), Some("main"));
+        assert_eq!(
+            label(temps_deployer::labels::LABEL_IMAGE_DIGEST),
+            Some("sha256:abc123")
+        );
+    }
+
+    #[tokio::test]
+    async fn test_workers_run_each_process_type() {
+        let mock_deployer = Arc::new(TrackingMockContainerDeployer::new());
+        let container_deployer: Arc<dyn ContainerDeployer> = mock_deployer.clone();
+        let job = DeployImageJobBuilder::new()
+            .job_id("deploy_container".to_string())
+            .build_job_id("build_image".to_string())
+            .target(DeploymentTarget::Docker {
+                registry_url: "local".to_string(),
+                network: None,
+            })
+            .service_name("myapp".to_string())
+            .build(container_deployer)
+            .unwrap();
+        let context = crate::test_utils::create_test_context("test".to_string(), 7, 1, 2);
+        let image_output = BuildImageOutput {
+            image_tag: "myapp:latest".to_string(),
+            image_id: "sha256:abc123".to_string(),
+            size_bytes: 0,
+            build_context: PathBuf::from("."),
+            dockerfile_path: PathBuf::from("Dockerfile"),
+        };
+        let workers = [
+            ProcessPlan {
+                name: "worker".to_string(),
+                command: Some("bundle exec sidekiq".to_string()),
+                replicas: 2,
+            },
+            ProcessPlan {
+                name: "clock".to_string(),
+                command: Some("bin/clock".to_string()),
+                replicas: 0,
+            },
+        ];
+
+        let containers = job
+            .deploy_workers(&image_output, &context, &workers)
+            .await
+            .unwrap();
+        let names: Vec<&str> = containers
+            .iter()
+            .map(|container| container.container_name.as_str())
+            .collect();
+        assert_eq!(names, vec!["myapp-worker-1", "myapp-worker-2"]);
+        assert!(containers
+            .iter()
+            .all(|container| container.process_type == "worker"));
+        assert_eq!(mock_deployer.deployed_containers.lock().unwrap().len(), 2);
+    }
+
+    #[test]
+    fn test_start_command_overrides_web() {
+        let container_deployer: Arc<dyn ContainerDeployer> =
+            Arc::new(TrackingMockContainerDeployer::new());
+        let job = DeployImageJobBuilder::new()
+            .job_id("deploy_container".to_string())
+            .build_job_id("build_image".to_string())
+            .target(DeploymentTarget::Docker {
+                registry_url: "local".to_string(),
+                network: None,
+            })
+            .build(container_deployer)
+            .unwrap()
+            .with_start_command("node dist/server.js".to_string());
+        let procfile = vec![
+            ProcessType {
+                name: "web".to_string(),
+                command: "npm start".to_string(),
+            },
+            ProcessType {
+                name: "worker".to_string(),
+                command: "node worker.js".to_string(),
+            },
+        ];
+
+        let plans = plan_processes(&procfile, 1, &job.configured_processes());
+        assert_eq!(plans[0].command.as_deref(), Some("node dist/server.js"));
+        assert_eq!(plans[1].command.as_deref(), Some("node worker.js"));
+    }
+
+    #[test]
+    fn test_entrypoint_and_args_override_web_containers() {
+        let container_deployer: Arc<dyn ContainerDeployer> =
+            Arc::new(TrackingMockContainerDeployer::new());
+        let job = DeployImageJobBuilder::new()
+            .job_id("deploy_container".to_string())
+            .build_job_id("build_image".to_string())
+            .target(DeploymentTarget::Docker {
+                registry_url: "local".to_string(),
+                network: None,
+            })
+            .service_name("myapp".to_string())
+            .build(container_deployer)
+            .unwrap()
+            .with_entrypoint(vec!["/usr/bin/tini".to_string(), "--".to_string()])
+            .with_args(vec!["/app/server".to_string(), "--port=3000".to_string()]);
+        let context = crate::test_utils::create_test_context("test".to_string(), 7, 1, 2);
+        let image_output = BuildImageOutput {
+            image_tag: "myapp:latest".to_string(),
+            image_id: "sha256:abc123".to_string(),
+            size_bytes: 0,
+            build_context: PathBuf::from("."),
+            dockerfile_path: PathBuf::from("Dockerfile"),
+        };
+        let web = ProcessPlan {
+            name: "web".to_string(),
+            command: Some("npm start".to_string()),
+            replicas: 1,
+        };
+        let worker = ProcessPlan {
+            name: "worker".to_string(),
+            command: Some("node worker.js".to_string()),
+            replicas: 1,
+        };
+
+        let request = job.deploy_request(
+            &image_output,
+            &context,
+            "myapp".to_string(),
+            Vec::new(),
+            &web,
+        );
+        assert_eq!(
+            request.entrypoint,
+            Some(vec!["/usr/bin/tini".to_string(), "--".to_string()])
+        );
+        assert_eq!(
+            request.command,
+            Some(vec!["/app/server".to_string(), "--port=3000".to_string()])
+        );
+
+        // Workers keep the image's entrypoint and run their own command
+        let request = job.deploy_request(
+            &image_output,
+            &context,
+            "myapp-worker".to_string(),
+            Vec::new(),
+            &worker,
+        );
+        assert_eq!(request.entrypoint, None);
+        assert_eq!(request.command, worker.container_command());
+    }
+
     #[test]
     fn test_image_output_from_context() {
         let mut context = crate::test_utils::create_test_context("test".to_string(), 1, 1, 1);
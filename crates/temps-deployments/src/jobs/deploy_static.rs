@@ -10,6 +10,8 @@ use temps_deployer::static_deployer::{StaticDeployRequest, StaticDeployer};
 use temps_deployer::ImageBuilder;
 use temps_logs::{LogLevel, LogService};
 
+use crate::services::DeploymentArtifactService;
+
 /// Typed output from DeployStaticJob
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct StaticDeploymentOutput {
@@ -42,6 +44,8 @@ pub struct DeployStaticJob {
     /// Optional log service
     log_id: Option<String>,
     log_service: Option<Arc<LogService>>,
+    /// Retains the extracted bundle as a downloadable artifact, when configured
+    artifact_service: Option<Arc<DeploymentArtifactService>>,
 }
 
 impl std::fmt::Debug for DeployStaticJob {
@@ -82,6 +86,7 @@ impl DeployStaticJob {
             image_builder,
             log_id: None,
             log_service: None,
+            artifact_service: None,
         }
     }
 
@@ -95,6 +100,14 @@ impl DeployStaticJob {
         self
     }
 
+    pub fn with_artifact_service(
+        mut self,
+        artifact_service: Arc<DeploymentArtifactService>,
+    ) -> Self {
+        self.artifact_service = Some(artifact_service);
+        self
+    }
+
     /// Write log message to job-specific log file and context
     async fn log(&self, context: &WorkflowContext, message: String) -> Result<(), WorkflowError> {
         let level = Self::detect_log_level(&message);
@@ -242,6 +255,41 @@ impl WorkflowTask for DeployStaticJob {
         self.log(&context, format!("📍 Deployed to: {}", result.storage_path))
             .await?;
 
+        // Keep the bundle as a downloadable artifact; the deployment succeeds without it
+        if let Some(artifact_service) = &self.artifact_service {
+            match artifact_service
+                .retain_static_bundle(
+                    context.deployment_id,
+                    context.project_id,
+                    context.environment_id,
+                    &temp_dir,
+                )
+                .await
+            {
+                Ok(Some(artifact)) => {
+                    self.log(
+                        &context,
+                        format!(
+                            "📦 Retained static bundle as artifact {} ({} bytes)",
+                            artifact.file_name, artifact.size_bytes
+                        ),
+                    )
+                    .await?;
+                }
+                Ok(None) => {}
+                Err(e) => {
+                    self.log(
+                        &context,
+                        format!(
+                            "⚠️  Warning: Failed to retain static bundle artifact: {}",
+                            e
+                        ),
+                    )
+                    .await?;
+                }
+            }
+        }
+
         // Clean up temporary directory
         if let Err(e) = tokio::fs::remove_dir_all(&temp_dir).await {
             self.log(
@@ -366,6 +366,29 @@ impl DownloadRepoJob {
             )
         })?;
 
+        // Repositories fetched with a deploy key are cloned over SSH; archives would be
+        // downloaded with the connection's token
+        let uses_deploy_key = self
+            .git_provider_manager
+            .uses_deploy_key(connection_id, &self.repo_owner, &self.repo_name)
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Failed to look up the repository's deploy key: {}",
+                    e
+                ))
+            })?;
+        if uses_deploy_key {
+            self.log(
+                context,
+                "🔑 Fetching repository over SSH with its deploy key".to_string(),
+            )
+            .await?;
+            self.clone_repository(context, connection_id, &repo_dir, &checkout_ref)
+                .await?;
+            return self.validate_repository(context, repo_dir).await;
+        }
+
         // Try download archive first (faster)
         let archive_path = temp_dir.join("source.tar.gz");
         match self
@@ -435,36 +458,54 @@ impl DownloadRepoJob {
                 )
                 .await?;
 
-                // Fall back to git clone - directory must be empty for trait method
-                // Remove directory (and any contents) before cloning
-                std::fs::remove_dir_all(&repo_dir).map_err(|e| {
-                    WorkflowError::JobExecutionFailed(format!(
-                        "Failed to remove directory for clone: {}",
-                        e
-                    ))
-                })?;
-
-                self.git_provider_manager
-                    .clone_repository(
-                        connection_id,
-                        &self.repo_owner,
-                        &self.repo_name,
-                        &repo_dir,
-                        Some(&checkout_ref),
-                    )
-                    .await
-                    .map_err(|e| {
-                        WorkflowError::JobExecutionFailed(format!(
-                            "Failed to clone repository: {}",
-                            e
-                        ))
-                    })?;
-
-                self.log(context, "Successfully cloned repository".to_string())
+                self.clone_repository(context, connection_id, &repo_dir, &checkout_ref)
                     .await?;
             }
         }
 
+        self.validate_repository(context, repo_dir).await
+    }
+
+    /// Clone a private repository through the git provider manager
+    async fn clone_repository(
+        &self,
+        context: &WorkflowContext,
+        connection_id: i32,
+        repo_dir: &std::path::Path,
+        checkout_ref: &str,
+    ) -> Result<(), WorkflowError> {
+        // Directory must be empty for trait method
+        // Remove directory (and any contents) before cloning
+        std::fs::remove_dir_all(repo_dir).map_err(|e| {
+            WorkflowError::JobExecutionFailed(format!(
+                "Failed to remove directory for clone: {}",
+                e
+            ))
+        })?;
+
+        self.git_provider_manager
+            .clone_repository(
+                connection_id,
+                &self.repo_owner,
+                &self.repo_name,
+                repo_dir,
+                Some(checkout_ref),
+            )
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to clone repository: {}", e))
+            })?;
+
+        self.log(context, "Successfully cloned repository".to_string())
+            .await
+    }
+
+    /// Check that a repository was downloaded
+    async fn validate_repository(
+        &self,
+        context: &WorkflowContext,
+        repo_dir: PathBuf,
+    ) -> Result<PathBuf, WorkflowError> {
         // Validate repository was downloaded
         if !repo_dir.exists() || std::fs::read_dir(&repo_dir)?.next().is_none() {
             return Err(WorkflowError::JobExecutionFailed(
@@ -0,0 +1,397 @@
+//! Drain Connections Job
+//!
+//! Runs after the cutover of a rolling deploy. New requests already go to the new
+//! deployment, but the previous one may still be serving connections it accepted
+//! before (long polls, WebSockets, streams). The job waits until those finish, the
+//! environment's `connection_drain_seconds` pass, or a user cancels the drain, and then
+//! stops the previous deployment's containers. Its progress, including the connections
+//! still open, is saved on the deployment so the deploy view can show it.
+
+use async_trait::async_trait;
+use sea_orm::{ActiveModelTrait, EntityTrait, Set};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+use temps_core::{JobResult, WorkflowContext, WorkflowError, WorkflowTask};
+use temps_database::DbConnection;
+use temps_entities::deployments::{self, ConnectionDrainReport, ConnectionDrainState};
+use temps_logs::{LogLevel, LogService};
+use temps_routes::ConnectionTracker;
+use tokio::time::sleep;
+use tracing::info;
+
+use super::mark_deployment_complete::{find_previous_deployments, teardown_deployments, JobLog};
+
+/// Seconds between checks of the open connections and of a cancel request
+const DRAIN_CHECK_INTERVAL_SECONDS: u64 = 2;
+
+/// How a drain ends after a check, or None to keep waiting
+///
+/// Connections are only known to be gone when the proxy shares its counts; without
+/// them the drain waits for the whole timeout.
+pub fn drain_outcome(
+    in_flight: Option<usize>,
+    elapsed: Duration,
+    timeout: Duration,
+    cancel_requested: bool,
+) -> Option<ConnectionDrainState> {
+    if cancel_requested {
+        Some(ConnectionDrainState::Cancelled)
+    } else if in_flight == Some(0) {
+        Some(ConnectionDrainState::Drained)
+    } else if elapsed >= timeout {
+        Some(ConnectionDrainState::TimedOut)
+    } else {
+        None
+    }
+}
+
+/// Job that drains the previous deployment's connections and then stops it
+pub struct DrainConnectionsJob {
+    job_id: String,
+    deployment_id: i32,
+    timeout_seconds: u32,
+    db: Arc<DbConnection>,
+    container_deployer: Arc<dyn temps_deployer::ContainerDeployer>,
+    connections: Option<Arc<ConnectionTracker>>,
+    log_id: Option<String>,
+    log_service: Option<Arc<LogService>>,
+}
+
+impl std::fmt::Debug for DrainConnectionsJob {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("DrainConnectionsJob")
+            .field("job_id", &self.job_id)
+            .field("deployment_id", &self.deployment_id)
+            .field("timeout_seconds", &self.timeout_seconds)
+            .finish()
+    }
+}
+
+impl DrainConnectionsJob {
+    pub fn new(
+        job_id: String,
+        deployment_id: i32,
+        timeout_seconds: u32,
+        db: Arc<DbConnection>,
+        container_deployer: Arc<dyn temps_deployer::ContainerDeployer>,
+    ) -> Self {
+        Self {
+            job_id,
+            deployment_id,
+            timeout_seconds,
+            db,
+            container_deployer,
+            connections: None,
+            log_id: None,
+            log_service: None,
+        }
+    }
+
+    /// Counts of the connections the proxy has open, when it runs in this process
+    pub fn with_connections(mut self, connections: Arc<ConnectionTracker>) -> Self {
+        self.connections = Some(connections);
+        self
+    }
+
+    pub fn with_log_id(mut self, log_id: String) -> Self {
+        self.log_id = Some(log_id);
+        self
+    }
+
+    pub fn with_log_service(mut self, log_service: Arc<LogService>) -> Self {
+        self.log_service = Some(log_service);
+        self
+    }
+
+    /// Write log message to job-specific log file
+    async fn log(&self, message: String) -> Result<(), WorkflowError> {
+        let level = Self::detect_log_level(&message);
+
+        if let (Some(log_id), Some(log_service)) = (&self.log_id, &self.log_service) {
+            log_service
+                .append_structured_log(log_id, level, message)
+                .await
+                .map_err(|e| {
+                    WorkflowError::JobExecutionFailed(format!("Failed to write log: {}", e))
+                })?;
+        }
+
+        Ok(())
+    }
+
+    fn detect_log_level(message: &str) -> LogLevel {
+        if message.contains("✅") {
+            LogLevel::Success
+        } else if message.contains("⚠️") || message.contains("⏳") {
+            LogLevel::Warning
+        } else {
+            LogLevel::Info
+        }
+    }
+
+    async fn find_deployment(&self) -> Result<deployments::Model, WorkflowError> {
+        deployments::Entity::find_by_id(self.deployment_id)
+            .one(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to find deployment: {}", e))
+            })?
+            .ok_or_else(|| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Deployment {} not found",
+                    self.deployment_id
+                ))
+            })
+    }
+
+    /// Save the drain's progress on the deployment, keeping a cancel request a user
+    /// saved meanwhile
+    async fn save_report(
+        &self,
+        report: &ConnectionDrainReport,
+    ) -> Result<ConnectionDrainReport, WorkflowError> {
+        let deployment = self.find_deployment().await?;
+
+        let mut metadata = deployment.metadata.clone().unwrap_or_default();
+        let mut report = report.clone();
+        report.cancel_requested |= metadata
+            .connection_drain
+            .as_ref()
+            .is_some_and(|saved| saved.cancel_requested);
+        metadata.connection_drain = Some(report.clone());
+
+        let mut active_deployment: deployments::ActiveModel = deployment.into();
+        active_deployment.metadata = Set(Some(metadata));
+        active_deployment
+            .update(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Failed to save connection drain progress: {}",
+                    e
+                ))
+            })?;
+        Ok(report)
+    }
+
+    fn in_flight(&self, deployment_ids: &[i32]) -> Option<usize> {
+        self.connections
+            .as_ref()
+            .map(|connections| connections.in_flight_total(deployment_ids))
+    }
+
+    /// Wait for the previous deployments' connections, returning the final report
+    async fn drain(
+        &self,
+        mut report: ConnectionDrainReport,
+    ) -> Result<ConnectionDrainReport, WorkflowError> {
+        let started = Instant::now();
+        let timeout = Duration::from_secs(self.timeout_seconds as u64);
+        let interval = Duration::from_secs(DRAIN_CHECK_INTERVAL_SECONDS);
+
+        loop {
+            sleep(interval.min(timeout.saturating_sub(started.elapsed()))).await;
+
+            let in_flight = self.in_flight(&report.deployment_ids);
+            if in_flight != report.in_flight_connections {
+                if let Some(count) = in_flight.filter(|count| *count > 0) {
+                    self.log(format!("{} connection(s) still open", count))
+                        .await?;
+                }
+                report.in_flight_connections = in_flight;
+            }
+            // Saving also picks up a cancel request
+            report = self.save_report(&report).await?;
+
+            if let Some(state) = drain_outcome(
+                in_flight,
+                started.elapsed(),
+                timeout,
+                report.cancel_requested,
+            ) {
+                report.state = state;
+                report.completed_at = Some(chrono::Utc::now());
+                return self.save_report(&report).await;
+            }
+        }
+    }
+}
+
+#[async_trait]
+impl WorkflowTask for DrainConnectionsJob {
+    fn job_id(&self) -> &str {
+        &self.job_id
+    }
+
+    fn name(&self) -> &str {
+        "Drain Connections"
+    }
+
+    fn description(&self) -> &str {
+        "Lets the previous deployment finish its open connections, then stops it"
+    }
+
+    fn depends_on(&self) -> Vec<String> {
+        // Dependencies are set by the workflow planner
+        vec![]
+    }
+
+    async fn execute(&self, mut context: WorkflowContext) -> Result<JobResult, WorkflowError> {
+        let deployment = self.find_deployment().await?;
+        let previous_deployments = find_previous_deployments(
+            self.db.as_ref(),
+            deployment.environment_id,
+            self.deployment_id,
+        )
+        .await
+        .map_err(|e| {
+            WorkflowError::JobExecutionFailed(format!(
+                "Failed to fetch previous deployments: {}",
+                e
+            ))
+        })?;
+
+        if previous_deployments.is_empty() {
+            self.log("No previous deployments to drain".to_string())
+                .await?;
+            return Ok(JobResult::success(context));
+        }
+
+        let deployment_ids: Vec<i32> = previous_deployments.iter().map(|d| d.id).collect();
+        let in_flight = self.in_flight(&deployment_ids);
+        let report = self
+            .save_report(&ConnectionDrainReport {
+                state: ConnectionDrainState::Draining,
+                timeout_seconds: self.timeout_seconds,
+                deployment_ids: deployment_ids.clone(),
+                in_flight_connections: in_flight,
+                cancel_requested: false,
+                started_at: chrono::Utc::now(),
+                completed_at: None,
+            })
+            .await?;
+
+        let ids = deployment_ids
+            .iter()
+            .map(|id| id.to_string())
+            .collect::<Vec<_>>()
+            .join(", ");
+        match in_flight {
+            Some(count) => {
+                self.log(format!(
+                    "⏳ Draining {} open connection(s) from deployment(s) {} for up to {}s",
+                    count, ids, self.timeout_seconds
+                ))
+                .await?
+            }
+            None => {
+                self.log(format!(
+                    "⏳ Draining open connections from deployment(s) {} for {}s; the proxy \
+                     doesn't report its connections to this server",
+                    ids, self.timeout_seconds
+                ))
+                .await?
+            }
+        }
+
+        let report = self.drain(report).await?;
+        match report.state {
+            ConnectionDrainState::Drained => {
+                self.log("✅ All connections finished".to_string()).await?
+            }
+            ConnectionDrainState::TimedOut => match report.in_flight_connections {
+                Some(count) => {
+                    self.log(format!(
+                        "⚠️  Drain timeout reached with {} connection(s) still open; they \
+                         will be closed",
+                        count
+                    ))
+                    .await?
+                }
+                None => self.log("Drain timeout reached".to_string()).await?,
+            },
+            ConnectionDrainState::Cancelled => {
+                self.log("⚠️  Drain cancelled; stopping the previous deployment(s) now".to_string())
+                    .await?
+            }
+            ConnectionDrainState::Draining => {}
+        }
+        info!(
+            "Connection drain of deployment(s) {} before deployment {} ended: {:?}",
+            ids, self.deployment_id, report.state
+        );
+
+        teardown_deployments(
+            self.db.as_ref(),
+            self.container_deployer.as_ref(),
+            previous_deployments,
+            JobLog::new(self.log_id.as_deref(), self.log_service.as_deref()),
+        )
+        .await;
+
+        context.set_output(&self.job_id, "drain_state", report.state)?;
+        if let Some(count) = report.in_flight_connections {
+            context.set_output(&self.job_id, "in_flight_connections", count)?;
+        }
+        Ok(JobResult::success(context))
+    }
+
+    async fn validate_prerequisites(
+        &self,
+        _context: &WorkflowContext,
+    ) -> Result<(), WorkflowError> {
+        if self.deployment_id <= 0 {
+            return Err(WorkflowError::JobValidationFailed(
+                "deployment_id must be positive".to_string(),
+            ));
+        }
+        Ok(())
+    }
+
+    async fn cleanup(&self, _context: &WorkflowContext) -> Result<(), WorkflowError> {
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const TIMEOUT: Duration = Duration::from_secs(60);
+
+    #[test]
+    fn drain_ends_once_connections_finish() {
+        assert_eq!(
+            drain_outcome(Some(3), Duration::from_secs(10), TIMEOUT, false),
+            None
+        );
+        assert_eq!(
+            drain_outcome(Some(0), Duration::from_secs(10), TIMEOUT, false),
+            Some(ConnectionDrainState::Drained)
+        );
+    }
+
+    #[test]
+    fn drain_without_counts_waits_for_timeout() {
+        assert_eq!(
+            drain_outcome(None, Duration::from_secs(59), TIMEOUT, false),
+            None
+        );
+        assert_eq!(
+            drain_outcome(None, TIMEOUT, TIMEOUT, false),
+            Some(ConnectionDrainState::TimedOut)
+        );
+        assert_eq!(
+            drain_outcome(Some(2), TIMEOUT, TIMEOUT, false),
+            Some(ConnectionDrainState::TimedOut)
+        );
+    }
+
+    #[test]
+    fn cancel_ends_drain_right_away() {
+        assert_eq!(
+            drain_outcome(Some(5), Duration::from_secs(1), TIMEOUT, true),
+            Some(ConnectionDrainState::Cancelled)
+        );
+    }
+}
@@ -0,0 +1,476 @@
+//! Fetch Artifact Job
+//!
+//! First job of a deployment of a CI build: downloads the artifact from its URL and
+//! checks its SHA-256 when one was given. A build context is unpacked with the same
+//! outputs as the repository download, so the image is built as from a checkout; an
+//! image archive is loaded into Docker under the deployment's image tag.
+
+use async_trait::async_trait;
+use futures::StreamExt;
+use sha2::{Digest, Sha256};
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+use std::time::Duration;
+use temps_core::{JobResult, WorkflowContext, WorkflowError, WorkflowTask};
+use temps_deployer::ImageBuilder;
+use temps_entities::deployments::RemoteArtifactKind;
+use temps_logs::{LogLevel, LogService};
+use tokio::io::AsyncWriteExt;
+use tracing::info;
+
+/// Largest artifact downloaded (10 GiB)
+pub const MAX_ARTIFACT_SIZE_BYTES: u64 = 10 * 1024 * 1024 * 1024;
+/// Largest build context unpacked from an artifact (20 GiB)
+pub const MAX_UNPACKED_SIZE_BYTES: u64 = 20 * 1024 * 1024 * 1024;
+/// How long the download may take
+const DOWNLOAD_TIMEOUT: Duration = Duration::from_secs(30 * 60);
+/// Owner recorded for build contexts that don't come from a repository
+pub const ARTIFACT_OWNER: &str = "artifact";
+
+/// Check a file's SHA-256 against the expected one, if any
+///
+/// The expected digest may be written with a `sha256:` prefix, in either case.
+pub fn verify_checksum(expected: Option<&str>, actual: &str) -> Result<(), String> {
+    let Some(expected) = expected else {
+        return Ok(());
+    };
+    let expected = expected.trim();
+    let expected = expected.strip_prefix("sha256:").unwrap_or(expected);
+    if expected.eq_ignore_ascii_case(actual) {
+        Ok(())
+    } else {
+        Err(format!(
+            "Checksum mismatch: expected sha256 {}, downloaded {}",
+            expected.to_lowercase(),
+            actual
+        ))
+    }
+}
+
+/// Reader failing once more than `limit` bytes were read through it
+struct LimitedReader<R> {
+    inner: R,
+    read: u64,
+    limit: u64,
+}
+
+impl<R: std::io::Read> std::io::Read for LimitedReader<R> {
+    fn read(&mut self, buf: &mut [u8]) -> std::io::Result<usize> {
+        let read = self.inner.read(buf)?;
+        self.read += read as u64;
+        if self.read > self.limit {
+            return Err(std::io::Error::other(format!(
+                "The archive unpacks to more than {} bytes",
+                self.limit
+            )));
+        }
+        Ok(read)
+    }
+}
+
+/// Unpack a tar archive, gzipped or not, into `destination`
+///
+/// Entries that would land outside of `destination` are refused by the tar crate.
+/// Unpacking stops with an error once the tar stream goes past `limit` bytes, so a
+/// small gzipped archive can't fill the disk.
+pub fn unpack_archive(archive: &Path, destination: &Path, limit: u64) -> std::io::Result<()> {
+    use std::io::{Read, Seek};
+
+    let mut file = std::fs::File::open(archive)?;
+    let mut magic = [0u8; 2];
+    let gzipped = file.read(&mut magic)? == 2 && magic == [0x1f, 0x8b];
+    file.rewind()?;
+
+    std::fs::create_dir_all(destination)?;
+    let reader: Box<dyn Read> = if gzipped {
+        Box::new(flate2::read::GzDecoder::new(file))
+    } else {
+        Box::new(file)
+    };
+    tar::Archive::new(LimitedReader {
+        inner: reader,
+        read: 0,
+        limit,
+    })
+    .unpack(destination)
+}
+
+/// Root of an unpacked build context
+///
+/// CI systems often put everything under one top-level directory (`app-1.2.0/`);
+/// that directory is then the root.
+pub fn source_root(unpacked: &Path) -> std::io::Result<PathBuf> {
+    let entries: Vec<_> = std::fs::read_dir(unpacked)?.collect::<Result<_, _>>()?;
+    match entries.as_slice() {
+        [entry] if entry.file_type()?.is_dir() => Ok(entry.path()),
+        _ => Ok(unpacked.to_path_buf()),
+    }
+}
+
+/// Job that downloads a deployment's artifact
+pub struct FetchArtifactJob {
+    job_id: String,
+    url: String,
+    kind: RemoteArtifactKind,
+    sha256: Option<String>,
+    /// `Authorization` header of the download, decrypted
+    authorization: Option<String>,
+    project_slug: String,
+    /// Tag an image artifact is loaded under
+    image_tag: Option<String>,
+    image_builder: Arc<dyn ImageBuilder>,
+    log_id: Option<String>,
+    log_service: Option<Arc<LogService>>,
+}
+
+impl std::fmt::Debug for FetchArtifactJob {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("FetchArtifactJob")
+            .field("job_id", &self.job_id)
+            .field("url", &self.url)
+            .field("kind", &self.kind)
+            .field("sha256", &self.sha256)
+            .field("image_tag", &self.image_tag)
+            .finish()
+    }
+}
+
+impl FetchArtifactJob {
+    pub fn new(
+        job_id: String,
+        url: String,
+        kind: RemoteArtifactKind,
+        project_slug: String,
+        image_builder: Arc<dyn ImageBuilder>,
+    ) -> Self {
+        Self {
+            job_id,
+            url,
+            kind,
+            sha256: None,
+            authorization: None,
+            project_slug,
+            image_tag: None,
+            image_builder,
+            log_id: None,
+            log_service: None,
+        }
+    }
+
+    pub fn with_sha256(mut self, sha256: String) -> Self {
+        self.sha256 = Some(sha256);
+        self
+    }
+
+    pub fn with_authorization(mut self, authorization: String) -> Self {
+        self.authorization = Some(authorization);
+        self
+    }
+
+    pub fn with_image_tag(mut self, image_tag: String) -> Self {
+        self.image_tag = Some(image_tag);
+        self
+    }
+
+    pub fn with_log_id(mut self, log_id: String) -> Self {
+        self.log_id = Some(log_id);
+        self
+    }
+
+    pub fn with_log_service(mut self, log_service: Arc<LogService>) -> Self {
+        self.log_service = Some(log_service);
+        self
+    }
+
+    /// Write log message to job-specific log file
+    async fn log(&self, message: String) -> Result<(), WorkflowError> {
+        let level = Self::detect_log_level(&message);
+
+        if let (Some(log_id), Some(log_service)) = (&self.log_id, &self.log_service) {
+            log_service
+                .append_structured_log(log_id, level, message)
+                .await
+                .map_err(|e| {
+                    WorkflowError::JobExecutionFailed(format!("Failed to write log: {}", e))
+                })?;
+        }
+
+        Ok(())
+    }
+
+    fn detect_log_level(message: &str) -> LogLevel {
+        if message.contains("✅") {
+            LogLevel::Success
+        } else if message.contains("❌") {
+            LogLevel::Error
+        } else if message.contains("⚠️") {
+            LogLevel::Warning
+        } else {
+            LogLevel::Info
+        }
+    }
+
+    fn create_work_dir(&self) -> Result<PathBuf, WorkflowError> {
+        let work_dir = PathBuf::from("/tmp/temps-deployments")
+            .join(format!("artifact-{}", uuid::Uuid::new_v4()));
+        std::fs::create_dir_all(&work_dir).map_err(WorkflowError::IoError)?;
+        Ok(work_dir)
+    }
+
+    /// Stream the artifact to `target`; returns its size and hex SHA-256
+    async fn download(&self, target: &Path) -> Result<(u64, String), WorkflowError> {
+        let client = reqwest::Client::builder()
+            .timeout(DOWNLOAD_TIMEOUT)
+            .build()
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to create HTTP client: {}", e))
+            })?;
+        let mut request = client.get(&self.url);
+        if let Some(authorization) = &self.authorization {
+            request = request.header(reqwest::header::AUTHORIZATION, authorization);
+        }
+        let response = request.send().await.map_err(|e| {
+            WorkflowError::JobExecutionFailed(format!("Failed to download artifact: {}", e))
+        })?;
+        if !response.status().is_success() {
+            return Err(WorkflowError::JobExecutionFailed(format!(
+                "Artifact download failed with status {}",
+                response.status()
+            )));
+        }
+        if response
+            .content_length()
+            .is_some_and(|length| length > MAX_ARTIFACT_SIZE_BYTES)
+        {
+            return Err(WorkflowError::JobExecutionFailed(format!(
+                "Artifact is larger than the {} GiB limit",
+                MAX_ARTIFACT_SIZE_BYTES / (1024 * 1024 * 1024)
+            )));
+        }
+
+        let mut file = tokio::fs::File::create(target)
+            .await
+            .map_err(WorkflowError::IoError)?;
+        let mut hasher = Sha256::new();
+        let mut size: u64 = 0;
+        let mut stream = response.bytes_stream();
+        while let Some(chunk) = stream.next().await {
+            let chunk = chunk.map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to download artifact: {}", e))
+            })?;
+            size += chunk.len() as u64;
+            if size > MAX_ARTIFACT_SIZE_BYTES {
+                return Err(WorkflowError::JobExecutionFailed(format!(
+                    "Artifact is larger than the {} GiB limit",
+                    MAX_ARTIFACT_SIZE_BYTES / (1024 * 1024 * 1024)
+                )));
+            }
+            hasher.update(&chunk);
+            file.write_all(&chunk)
+                .await
+                .map_err(WorkflowError::IoError)?;
+        }
+        file.flush().await.map_err(WorkflowError::IoError)?;
+
+        Ok((size, hex::encode(hasher.finalize())))
+    }
+
+    /// Unpack a build context, with the outputs of a repository download
+    async fn unpack_build_context(
+        &self,
+        context: &mut WorkflowContext,
+        archive: PathBuf,
+        work_dir: &Path,
+        sha256: &str,
+    ) -> Result<(), WorkflowError> {
+        let unpacked = work_dir.join("source");
+        let destination = unpacked.clone();
+        tokio::task::spawn_blocking(move || {
+            unpack_archive(&archive, &destination, MAX_UNPACKED_SIZE_BYTES)
+        })
+        .await
+        .map_err(|e| WorkflowError::Other(format!("Unpack task failed: {}", e)))?
+        .map_err(|e| {
+            WorkflowError::JobExecutionFailed(format!(
+                "Failed to unpack the artifact as a tar archive: {}",
+                e
+            ))
+        })?;
+        let repo_dir = source_root(&unpacked).map_err(WorkflowError::IoError)?;
+        self.log(format!(
+            "Unpacked the build context to {}",
+            repo_dir.display()
+        ))
+        .await?;
+
+        context.set_output(
+            &self.job_id,
+            "repo_dir",
+            repo_dir.to_string_lossy().to_string(),
+        )?;
+        context.set_output(&self.job_id, "checkout_ref", sha256)?;
+        context.set_output(&self.job_id, "repo_owner", ARTIFACT_OWNER)?;
+        context.set_output(&self.job_id, "repo_name", &self.project_slug)?;
+        context.set_artifact(&self.job_id, "source_code", repo_dir);
+        Ok(())
+    }
+
+    /// Load an image archive under the deployment's image tag
+    async fn load_image(
+        &self,
+        context: &mut WorkflowContext,
+        archive: PathBuf,
+    ) -> Result<(), WorkflowError> {
+        let image_tag = self.image_tag.clone().ok_or_else(|| {
+            WorkflowError::JobValidationFailed("image_tag is required for images".to_string())
+        })?;
+        let image_id = self
+            .image_builder
+            .import_image(archive, &image_tag)
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to load the image: {}", e))
+            })?;
+        self.log(format!("Loaded image {} as {}", image_id, image_tag))
+            .await?;
+
+        context.set_output(&self.job_id, "image_tag", &image_tag)?;
+        context.set_output(&self.job_id, "image_id", image_id)?;
+        Ok(())
+    }
+}
+
+#[async_trait]
+impl WorkflowTask for FetchArtifactJob {
+    fn job_id(&self) -> &str {
+        &self.job_id
+    }
+
+    fn name(&self) -> &str {
+        "Fetch Artifact"
+    }
+
+    fn description(&self) -> &str {
+        "Downloads the build artifact the deployment was started with"
+    }
+
+    async fn execute(&self, mut context: WorkflowContext) -> Result<JobResult, WorkflowError> {
+        let work_dir = self.create_work_dir()?;
+        context.work_dir = Some(work_dir.clone());
+        let archive = work_dir.join("artifact.tar");
+
+        self.log(format!("Downloading artifact from {}", self.url))
+            .await?;
+        let (size, sha256) = self.download(&archive).await?;
+        self.log(format!("Downloaded {} bytes (sha256 {})", size, sha256))
+            .await?;
+
+        if let Err(e) = verify_checksum(self.sha256.as_deref(), &sha256) {
+            self.log(format!("❌ {}", e)).await?;
+            return Err(WorkflowError::JobExecutionFailed(e));
+        }
+        if self.sha256.is_some() {
+            self.log("✅ Checksum verified".to_string()).await?;
+        }
+        context.set_output(&self.job_id, "sha256", &sha256)?;
+
+        match self.kind {
+            RemoteArtifactKind::BuildContext => {
+                self.unpack_build_context(&mut context, archive.clone(), &work_dir, &sha256)
+                    .await?;
+            }
+            RemoteArtifactKind::Image => {
+                self.load_image(&mut context, archive.clone()).await?;
+            }
+        }
+        // The unpacked files or the loaded image are all that is needed from here
+        tokio::fs::remove_file(&archive).await.ok();
+
+        info!("Fetched artifact {} ({} bytes)", self.url, size);
+        Ok(JobResult::success(context))
+    }
+
+    async fn validate_prerequisites(
+        &self,
+        _context: &WorkflowContext,
+    ) -> Result<(), WorkflowError> {
+        if self.url.is_empty() {
+            return Err(WorkflowError::JobValidationFailed(
+                "url cannot be empty".to_string(),
+            ));
+        }
+        if self.kind == RemoteArtifactKind::Image && self.image_tag.is_none() {
+            return Err(WorkflowError::JobValidationFailed(
+                "image_tag must be provided for image artifacts".to_string(),
+            ));
+        }
+        Ok(())
+    }
+
+    async fn cleanup(&self, context: &WorkflowContext) -> Result<(), WorkflowError> {
+        // Clean up the downloaded and unpacked files
+        if let Some(ref work_dir) = context.work_dir {
+            if work_dir.exists() {
+                std::fs::remove_dir_all(work_dir).map_err(WorkflowError::IoError)?;
+            }
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_verify_checksum() {
+        let digest = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08";
+        assert!(verify_checksum(None, digest).is_ok());
+        assert!(verify_checksum(Some(digest), digest).is_ok());
+        assert!(
+            verify_checksum(Some(&format!("sha256:{}", digest.to_uppercase())), digest).is_ok()
+        );
+        assert!(verify_checksum(Some(&digest.replace('9', "8")), digest).is_err());
+    }
+
+    /// Gzipped tar archive with one file of `contents`
+    fn gzipped_archive(archive: &Path, path: &str, contents: &[u8]) {
+        let encoder = flate2::write::GzEncoder::new(
+            std::fs::File::create(archive).unwrap(),
+            flate2::Compression::default(),
+        );
+        let mut builder = tar::Builder::new(encoder);
+        let mut header = tar::Header::new_gnu();
+        header.set_size(contents.len() as u64);
+        header.set_mode(0o644);
+        header.set_cksum();
+        builder.append_data(&mut header, path, contents).unwrap();
+        builder.into_inner().unwrap().finish().unwrap();
+    }
+
+    #[test]
+    fn test_unpacks_gzipped_build_context_under_its_top_directory() {
+        let dir = tempfile::tempdir().unwrap();
+        let archive = dir.path().join("app.tar.gz");
+        gzipped_archive(&archive, "app-1.2.0/Procfile", b"web: node server.js\n");
+
+        let unpacked = dir.path().join("source");
+        unpack_archive(&archive, &unpacked, MAX_UNPACKED_SIZE_BYTES).unwrap();
+        let root = source_root(&unpacked).unwrap();
+        assert_eq!(root, unpacked.join("app-1.2.0"));
+        assert!(root.join("Procfile").exists());
+    }
+
+    #[test]
+    fn test_unpacking_stops_past_the_limit() {
+        let dir = tempfile::tempdir().unwrap();
+        let archive = dir.path().join("bomb.tar.gz");
+        // Compresses to a few KiB
+        gzipped_archive(&archive, "zeros", &vec![0u8; 4 * 1024 * 1024]);
+        assert!(std::fs::metadata(&archive).unwrap().len() < 1024 * 1024);
+
+        let unpacked = dir.path().join("source");
+        assert!(unpack_archive(&archive, &unpacked, 1024 * 1024).is_err());
+        assert!(unpack_archive(&archive, &dir.path().join("other"), 8 * 1024 * 1024).is_ok());
+    }
+}
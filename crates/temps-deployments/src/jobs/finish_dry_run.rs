@@ -0,0 +1,275 @@
+//! Finish Dry Run Job
+//!
+//! Last job of a dry run, in place of the cutover. Once the image built and the new
+//! containers passed their checks, it removes those containers and marks the
+//! deployment built: the environment's routing and its current deployment are never
+//! touched. A dry run that fails on the way is cleaned up by the failing job and
+//! marked failed like any deployment.
+
+use async_trait::async_trait;
+use sea_orm::{ActiveModelTrait, EntityTrait, Set};
+use std::sync::Arc;
+use temps_core::{JobResult, WorkflowContext, WorkflowError, WorkflowTask};
+use temps_database::DbConnection;
+use temps_entities::deployments;
+use temps_logs::{LogLevel, LogService};
+use tracing::{info, warn};
+
+use super::WorkerContainer;
+
+/// Containers a deploy job started: the web replicas and the workers
+pub fn deployed_container_ids(
+    context: &WorkflowContext,
+    deploy_job_id: &str,
+) -> Result<Vec<String>, WorkflowError> {
+    let mut container_ids: Vec<String> = context
+        .get_output(deploy_job_id, "container_ids")?
+        .unwrap_or_default();
+    let workers: Vec<WorkerContainer> = context
+        .get_output(deploy_job_id, "worker_containers")?
+        .unwrap_or_default();
+    container_ids.extend(workers.into_iter().map(|worker| worker.container_id));
+    Ok(container_ids)
+}
+
+/// Job that removes the containers of a dry run and records its outcome
+pub struct FinishDryRunJob {
+    job_id: String,
+    deployment_id: i32,
+    /// Job that started the containers; None when the dry run only builds
+    deploy_job_id: Option<String>,
+    db: Arc<DbConnection>,
+    container_deployer: Arc<dyn temps_deployer::ContainerDeployer>,
+    log_id: Option<String>,
+    log_service: Option<Arc<LogService>>,
+}
+
+impl std::fmt::Debug for FinishDryRunJob {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("FinishDryRunJob")
+            .field("job_id", &self.job_id)
+            .field("deployment_id", &self.deployment_id)
+            .field("deploy_job_id", &self.deploy_job_id)
+            .finish()
+    }
+}
+
+impl FinishDryRunJob {
+    pub fn new(
+        job_id: String,
+        deployment_id: i32,
+        deploy_job_id: Option<String>,
+        db: Arc<DbConnection>,
+        container_deployer: Arc<dyn temps_deployer::ContainerDeployer>,
+    ) -> Self {
+        Self {
+            job_id,
+            deployment_id,
+            deploy_job_id,
+            db,
+            container_deployer,
+            log_id: None,
+            log_service: None,
+        }
+    }
+
+    pub fn with_log_id(mut self, log_id: String) -> Self {
+        self.log_id = Some(log_id);
+        self
+    }
+
+    pub fn with_log_service(mut self, log_service: Arc<LogService>) -> Self {
+        self.log_service = Some(log_service);
+        self
+    }
+
+    /// Write log message to job-specific log file
+    async fn log(&self, message: String) -> Result<(), WorkflowError> {
+        let level = Self::detect_log_level(&message);
+
+        if let (Some(log_id), Some(log_service)) = (&self.log_id, &self.log_service) {
+            log_service
+                .append_structured_log(log_id, level, message)
+                .await
+                .map_err(|e| {
+                    WorkflowError::JobExecutionFailed(format!("Failed to write log: {}", e))
+                })?;
+        }
+
+        Ok(())
+    }
+
+    fn detect_log_level(message: &str) -> LogLevel {
+        if message.contains("✅") {
+            LogLevel::Success
+        } else if message.contains("❌") {
+            LogLevel::Error
+        } else if message.contains("⚠️") {
+            LogLevel::Warning
+        } else {
+            LogLevel::Info
+        }
+    }
+
+    fn container_ids(&self, context: &WorkflowContext) -> Result<Vec<String>, WorkflowError> {
+        match &self.deploy_job_id {
+            Some(deploy_job_id) => deployed_container_ids(context, deploy_job_id),
+            None => Ok(Vec::new()),
+        }
+    }
+
+    /// Stop and remove the containers; returns how many could not be removed
+    async fn remove_containers(&self, container_ids: &[String]) -> usize {
+        let mut failed = 0;
+        for container_id in container_ids {
+            if let Err(e) = self.container_deployer.stop_container(container_id).await {
+                warn!("Failed to stop container {}: {}", container_id, e);
+            }
+            match self.container_deployer.remove_container(container_id).await {
+                Ok(_) => {
+                    self.log(format!("🧹 Removed container {}", container_id))
+                        .await
+                        .ok();
+                }
+                Err(e) => {
+                    failed += 1;
+                    self.log(format!(
+                        "⚠️  Failed to remove container {}: {}",
+                        container_id, e
+                    ))
+                    .await
+                    .ok();
+                }
+            }
+        }
+        failed
+    }
+
+    /// Mark the deployment built; it stays out of the environment's routing
+    async fn mark_built(&self) -> Result<(), WorkflowError> {
+        let deployment = deployments::Entity::find_by_id(self.deployment_id)
+            .one(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to find deployment: {}", e))
+            })?
+            .ok_or_else(|| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Deployment {} not found",
+                    self.deployment_id
+                ))
+            })?;
+
+        let now = chrono::Utc::now();
+        let mut active_deployment: deployments::ActiveModel = deployment.into();
+        active_deployment.state = Set("built".to_string());
+        active_deployment.finished_at = Set(Some(now));
+        active_deployment.updated_at = Set(now);
+        active_deployment
+            .update(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Failed to update deployment {}: {}",
+                    self.deployment_id, e
+                ))
+            })?;
+        Ok(())
+    }
+}
+
+#[async_trait]
+impl WorkflowTask for FinishDryRunJob {
+    fn job_id(&self) -> &str {
+        &self.job_id
+    }
+
+    fn name(&self) -> &str {
+        "Finish Dry Run"
+    }
+
+    fn description(&self) -> &str {
+        "Removes the containers of the dry run; nothing is shipped"
+    }
+
+    fn depends_on(&self) -> Vec<String> {
+        // Dependencies are set by the workflow planner
+        vec![]
+    }
+
+    async fn execute(&self, mut context: WorkflowContext) -> Result<JobResult, WorkflowError> {
+        let container_ids = self.container_ids(&context)?;
+        if !container_ids.is_empty() {
+            self.log(format!(
+                "Removing the {} container(s) of the dry run",
+                container_ids.len()
+            ))
+            .await?;
+        }
+        let failed = self.remove_containers(&container_ids).await;
+
+        self.mark_built().await?;
+        context.set_output(&self.job_id, "passed", true)?;
+
+        info!(
+            "Dry run of deployment {} passed, {} container(s) removed",
+            self.deployment_id,
+            container_ids.len() - failed
+        );
+        self.log(
+            "✅ Dry run passed: the build and checks succeeded, nothing was shipped".to_string(),
+        )
+        .await?;
+        Ok(JobResult::success(context))
+    }
+
+    async fn validate_prerequisites(
+        &self,
+        _context: &WorkflowContext,
+    ) -> Result<(), WorkflowError> {
+        Ok(())
+    }
+
+    /// Remove the containers if the job itself failed before it did
+    async fn cleanup(&self, context: &WorkflowContext) -> Result<(), WorkflowError> {
+        let container_ids = self.container_ids(context)?;
+        self.remove_containers(&container_ids).await;
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_deployed_container_ids_include_workers() {
+        let mut context = crate::test_utils::create_test_context("test".to_string(), 1, 1, 1);
+        context
+            .set_output(
+                "deploy_container",
+                "container_ids",
+                vec!["web-1".to_string(), "web-2".to_string()],
+            )
+            .unwrap();
+        context
+            .set_output(
+                "deploy_container",
+                "worker_containers",
+                vec![WorkerContainer {
+                    process_type: "worker".to_string(),
+                    container_id: "worker-1".to_string(),
+                    container_name: "app-worker".to_string(),
+                }],
+            )
+            .unwrap();
+
+        assert_eq!(
+            deployed_container_ids(&context, "deploy_container").unwrap(),
+            vec!["web-1", "web-2", "worker-1"]
+        );
+        assert!(deployed_container_ids(&context, "build_image")
+            .unwrap()
+            .is_empty());
+    }
+}
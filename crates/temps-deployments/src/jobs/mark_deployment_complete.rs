@@ -13,7 +13,7 @@ use temps_core::{
     Job, JobQueue, JobResult, UtcDateTime, WorkflowContext, WorkflowError, WorkflowTask,
 };
 use temps_database::DbConnection;
-use temps_entities::{deployment_containers, deployments, environments};
+use temps_entities::{deployment_containers, deployments, environments, projects};
 use temps_logs::{LogLevel, LogService};
 use tracing::{debug, info};
 
@@ -33,6 +33,8 @@ pub struct MarkDeploymentCompleteJob {
     log_service: Option<Arc<LogService>>,
     container_deployer: Arc<dyn temps_deployer::ContainerDeployer>,
     queue: Arc<dyn JobQueue>,
+    /// Whether this job stops the previous deployments; false when a drain job does
+    teardown_previous: bool,
 }
 
 impl std::fmt::Debug for MarkDeploymentCompleteJob {
@@ -60,9 +62,16 @@ impl MarkDeploymentCompleteJob {
             log_service: None,
             container_deployer,
             queue,
+            teardown_previous: true,
         }
     }
 
+    /// Leave the previous deployments running for a drain job to stop
+    pub fn without_teardown(mut self) -> Self {
+        self.teardown_previous = false;
+        self
+    }
+
     pub fn with_log_id(mut self, log_id: String) -> Self {
         self.log_id = Some(log_id);
         self
@@ -144,12 +153,61 @@ impl MarkDeploymentCompleteJob {
         // Update deployment with workflow outputs
         let mut active_deployment: deployments::ActiveModel = deployment.clone().into();
 
-        // Extract image info from build job output
-        if let Ok(Some(image_tag)) = context.get_output::<String>("build_image", "image_tag") {
+        // Extract image info from build job output, or from the image artifact the
+        // deployment was fetched from
+        let image_tag = context
+            .get_output::<String>("build_image", "image_tag")
+            .ok()
+            .flatten()
+            .or_else(|| {
+                context
+                    .get_output::<String>("fetch_artifact", "image_tag")
+                    .ok()
+                    .flatten()
+            });
+        if let Some(image_tag) = image_tag {
             debug!("Setting deployment image_name to: {}", image_tag);
             active_deployment.image_name = Set(Some(image_tag));
         }
 
+        // Record the base images of the build for the base image update check, the
+        // Procfile's process types, which promotions of the deployment run as well, and
+        // where the build spent its time
+        let base_images = context
+            .get_output::<Vec<temps_entities::deployments::BaseImageDigest>>(
+                "build_image",
+                "base_images",
+            )
+            .ok()
+            .flatten();
+        let process_types = context
+            .get_output::<Vec<temps_entities::deployments::ProcessType>>(
+                "deploy_container",
+                "process_types",
+            )
+            .ok()
+            .flatten();
+        let build_phases = context
+            .get_output::<temps_entities::deployments::BuildPhaseTimings>(
+                "build_image",
+                "build_phases",
+            )
+            .ok()
+            .flatten();
+        if base_images.is_some() || process_types.is_some() || build_phases.is_some() {
+            let mut metadata = deployment.metadata.clone().unwrap_or_default();
+            if let Some(base_images) = base_images {
+                metadata.base_images = base_images;
+            }
+            if build_phases.is_some() {
+                metadata.build_phases = build_phases;
+            }
+            if let Some(process_types) = process_types {
+                metadata.process_types = process_types;
+            }
+            active_deployment.metadata = Set(Some(metadata));
+        }
+
         // Extract static_dir_location from deploy_static job output
         if let Ok(Some(static_dir)) =
             context.get_output::<String>("deploy_static", "static_dir_location")
@@ -267,6 +325,53 @@ impl MarkDeploymentCompleteJob {
             }
         }
 
+        // Containers of the other process types are recorded without a port, so the
+        // proxy never routes to them
+        let worker_containers = context
+            .get_output::<Vec<crate::jobs::WorkerContainer>>(
+                "deploy_container",
+                "worker_containers",
+            )
+            .ok()
+            .flatten()
+            .unwrap_or_default();
+        for worker in worker_containers {
+            let now = chrono::Utc::now();
+            let deployment_container = deployment_containers::ActiveModel {
+                deployment_id: Set(self.deployment_id),
+                container_id: Set(worker.container_id.clone()),
+                container_name: Set(worker.container_name),
+                container_port: Set(0),
+                host_port: Set(None),
+                image_name: Set(match &active_deployment.image_name {
+                    sea_orm::ActiveValue::Set(v) => v.clone(),
+                    sea_orm::ActiveValue::Unchanged(v) => v.clone(),
+                    _ => None,
+                }),
+                status: Set(Some("running".to_string())),
+                created_at: Set(now),
+                deployed_at: Set(now),
+                ready_at: Set(Some(now)),
+                deleted_at: Set(None),
+                process_type: Set(worker.process_type.clone()),
+                ..Default::default()
+            };
+            deployment_container
+                .insert(self.db.as_ref())
+                .await
+                .map_err(|e| {
+                    WorkflowError::JobExecutionFailed(format!(
+                        "Failed to create deployment_container: {}",
+                        e
+                    ))
+                })?;
+            self.log(format!(
+                "Container {} registered (process type {})",
+                worker.container_id, worker.process_type
+            ))
+            .await?;
+        }
+
         // Update deployment status to completed
         active_deployment.state = Set("completed".to_string());
         let now = chrono::Utc::now();
@@ -333,6 +438,21 @@ impl MarkDeploymentCompleteJob {
             None
         };
 
+        let kind = match crate::services::classify_success(
+            self.db.as_ref(),
+            environment_id,
+            self.deployment_id,
+        )
+        .await
+        {
+            Ok(kind) => kind,
+            Err(e) => {
+                self.log(format!("Failed to classify deployment success: {}", e))
+                    .await?;
+                temps_core::DeploymentSuccessKind::Routine
+            }
+        };
+
         let event = Job::DeploymentSucceeded(temps_core::DeploymentSucceededJob {
             deployment_id: self.deployment_id,
             project_id: deployment.project_id,
@@ -340,6 +460,7 @@ impl MarkDeploymentCompleteJob {
             environment_name: active_environment.name.as_ref().clone(),
             commit_sha: deployment.commit_sha.clone(),
             url,
+            kind,
         });
 
         if let Err(e) = self.queue.send(event).await {
@@ -352,8 +473,16 @@ impl MarkDeploymentCompleteJob {
             );
         }
 
-        // Cancel and teardown all previous deployments for this environment
-        self.cancel_previous_deployments(environment_id).await;
+        // Cancel and teardown all previous deployments for this environment, unless
+        // the drain step does once their connections are done
+        if self.teardown_previous {
+            self.cancel_previous_deployments(environment_id).await;
+        } else {
+            self.log(
+                "Previous deployments keep serving open connections while they drain".to_string(),
+            )
+            .await?;
+        }
 
         Ok(MarkCompleteOutput {
             completed_at: now,
@@ -361,142 +490,208 @@ impl MarkDeploymentCompleteJob {
         })
     }
 
+    /// How long previous deployments keep serving open connections before teardown
+    async fn connection_drain_period(&self, environment_id: i32) -> Option<std::time::Duration> {
+        let environment = environments::Entity::find_by_id(environment_id)
+            .one(self.db.as_ref())
+            .await
+            .ok()??;
+        let project_config = projects::Entity::find_by_id(environment.project_id)
+            .one(self.db.as_ref())
+            .await
+            .ok()
+            .flatten()
+            .and_then(|project| project.deployment_config)
+            .unwrap_or_default();
+        let seconds = environment
+            .get_effective_deployment_config(&project_config)
+            .connection_drain_seconds?;
+        (seconds > 0).then(|| std::time::Duration::from_secs(seconds as u64))
+    }
+
     /// Teardown all running/pending deployments for the same environment
     /// This ensures only one active deployment per environment
     /// Note: Deployment state is NOT changed - the is_current flag indicates which deployment is active
     async fn cancel_previous_deployments(&self, environment_id: i32) {
-        use sea_orm::Set;
+        let log = JobLog::new(self.log_id.as_deref(), self.log_service.as_deref());
 
-        self.log("Checking for previous deployments to teardown...".to_string())
-            .await
-            .ok();
-
-        // Find all running or pending deployments for this environment (excluding the new one)
-        // Note: "failed" deployments are intentionally excluded to preserve error history
-        let previous_deployments = match deployments::Entity::find()
-            .filter(deployments::Column::EnvironmentId.eq(environment_id))
-            .filter(deployments::Column::Id.ne(self.deployment_id))
-            .filter(deployments::Column::State.is_in(vec![
-                "pending",
-                "running",
-                "built",
-                "completed",
-            ]))
-            .all(self.db.as_ref())
-            .await
-        {
-            Ok(deps) => deps,
-            Err(e) => {
-                self.log(format!("Failed to fetch previous deployments: {}", e))
-                    .await
-                    .ok();
-                return;
-            }
-        };
+        log.write("Checking for previous deployments to teardown...".to_string())
+            .await;
 
-        if previous_deployments.is_empty() {
-            self.log("No previous deployments to teardown".to_string())
+        let previous_deployments =
+            match find_previous_deployments(self.db.as_ref(), environment_id, self.deployment_id)
                 .await
-                .ok();
+            {
+                Ok(deps) => deps,
+                Err(e) => {
+                    log.write(format!("Failed to fetch previous deployments: {}", e))
+                        .await;
+                    return;
+                }
+            };
+
+        if previous_deployments.is_empty() {
+            log.write("No previous deployments to teardown".to_string())
+                .await;
             return;
         }
 
-        self.log(format!(
+        log.write(format!(
             "Found {} previous deployment(s) to teardown",
             previous_deployments.len()
         ))
-        .await
-        .ok();
-
-        for deployment in previous_deployments {
-            let deployment_id = deployment.id;
-            self.log(format!(
-                "Tearing down deployment {} (state: {})",
-                deployment_id, deployment.state
+        .await;
+
+        // New connections already go to this deployment; give the ones still open on the
+        // previous deployment (WebSockets, streams) time to finish or reconnect
+        if let Some(drain) = self.connection_drain_period(environment_id).await {
+            log.write(format!(
+                "Waiting {}s for open connections to drain from previous deployments",
+                drain.as_secs()
             ))
-            .await
-            .ok();
+            .await;
+            tokio::time::sleep(drain).await;
+        }
 
-            // Stop all containers for this deployment
-            let containers = match deployment_containers::Entity::find()
-                .filter(deployment_containers::Column::DeploymentId.eq(deployment_id))
-                .filter(deployment_containers::Column::DeletedAt.is_null())
-                .all(self.db.as_ref())
+        teardown_deployments(
+            self.db.as_ref(),
+            self.container_deployer.as_ref(),
+            previous_deployments,
+            log,
+        )
+        .await;
+    }
+}
+
+/// Job log that teardown progress is written to; write errors are ignored
+#[derive(Clone, Copy)]
+pub(crate) struct JobLog<'a> {
+    log_id: Option<&'a str>,
+    log_service: Option<&'a LogService>,
+}
+
+impl<'a> JobLog<'a> {
+    pub(crate) fn new(log_id: Option<&'a str>, log_service: Option<&'a LogService>) -> Self {
+        Self {
+            log_id,
+            log_service,
+        }
+    }
+
+    pub(crate) async fn write(&self, message: String) {
+        if let (Some(log_id), Some(log_service)) = (self.log_id, self.log_service) {
+            let level = MarkDeploymentCompleteJob::detect_log_level(&message);
+            log_service
+                .append_structured_log(log_id, level, message)
                 .await
-            {
-                Ok(containers) => containers,
-                Err(e) => {
-                    self.log(format!(
-                        "Failed to fetch containers for deployment {}: {}",
-                        deployment_id, e
-                    ))
-                    .await
-                    .ok();
-                    continue;
-                }
-            };
+                .ok();
+        }
+    }
+}
 
-            for container in containers {
-                let container_id = container.container_id.clone();
-
-                // Stop container first
-                match self.container_deployer.stop_container(&container_id).await {
-                    Ok(_) => {
-                        self.log(format!("Stopped container {}", container_id))
-                            .await
-                            .ok();
-                    }
-                    Err(e) => {
-                        self.log(format!("Failed to stop container {}: {}", container_id, e))
-                            .await
-                            .ok();
-                    }
-                }
+/// Running or pending deployments of an environment other than `deployment_id`
+///
+/// "failed" deployments are intentionally excluded to preserve error history, and dry
+/// runs, which never serve traffic, remove their own containers.
+pub(crate) async fn find_previous_deployments(
+    db: &DbConnection,
+    environment_id: i32,
+    deployment_id: i32,
+) -> Result<Vec<deployments::Model>, sea_orm::DbErr> {
+    let previous_deployments = deployments::Entity::find()
+        .filter(deployments::Column::EnvironmentId.eq(environment_id))
+        .filter(deployments::Column::Id.ne(deployment_id))
+        .filter(deployments::Column::State.is_in(vec!["pending", "running", "built", "completed"]))
+        .all(db)
+        .await?;
+    Ok(previous_deployments
+        .into_iter()
+        .filter(|deployment| !deployment.is_dry_run())
+        .collect())
+}
 
-                // Remove container from Docker
-                match self
-                    .container_deployer
-                    .remove_container(&container_id)
-                    .await
-                {
-                    Ok(_) => {
-                        self.log(format!("Removed container {}", container_id))
-                            .await
-                            .ok();
-                    }
-                    Err(e) => {
-                        self.log(format!(
-                            "Failed to remove container {}: {}",
-                            container_id, e
-                        ))
-                        .await
-                        .ok();
-                    }
+/// Stop and remove the containers of deployments no longer serving traffic
+pub(crate) async fn teardown_deployments(
+    db: &DbConnection,
+    container_deployer: &dyn temps_deployer::ContainerDeployer,
+    previous_deployments: Vec<deployments::Model>,
+    log: JobLog<'_>,
+) {
+    for deployment in previous_deployments {
+        let deployment_id = deployment.id;
+        log.write(format!(
+            "Tearing down deployment {} (state: {})",
+            deployment_id, deployment.state
+        ))
+        .await;
+
+        // Stop all containers for this deployment
+        let containers = match deployment_containers::Entity::find()
+            .filter(deployment_containers::Column::DeploymentId.eq(deployment_id))
+            .filter(deployment_containers::Column::DeletedAt.is_null())
+            .all(db)
+            .await
+        {
+            Ok(containers) => containers,
+            Err(e) => {
+                log.write(format!(
+                    "Failed to fetch containers for deployment {}: {}",
+                    deployment_id, e
+                ))
+                .await;
+                continue;
+            }
+        };
+
+        for container in containers {
+            let container_id = container.container_id.clone();
+
+            // Stop container first
+            match container_deployer.stop_container(&container_id).await {
+                Ok(_) => {
+                    log.write(format!("Stopped container {}", container_id))
+                        .await;
+                }
+                Err(e) => {
+                    log.write(format!("Failed to stop container {}: {}", container_id, e))
+                        .await;
                 }
+            }
 
-                // Mark container as deleted in database
-                let mut active_container: deployment_containers::ActiveModel = container.into();
-                active_container.deleted_at = Set(Some(chrono::Utc::now()));
-                active_container.status = Set(Some("removed".to_string()));
-                if let Err(e) = active_container.update(self.db.as_ref()).await {
-                    self.log(format!("Failed to update container status: {}", e))
-                        .await
-                        .ok();
+            // Remove container from Docker
+            match container_deployer.remove_container(&container_id).await {
+                Ok(_) => {
+                    log.write(format!("Removed container {}", container_id))
+                        .await;
+                }
+                Err(e) => {
+                    log.write(format!(
+                        "Failed to remove container {}: {}",
+                        container_id, e
+                    ))
+                    .await;
                 }
             }
 
-            self.log(format!(
-                "Torn down deployment {} - containers stopped and removed",
-                deployment_id
-            ))
-            .await
-            .ok();
+            // Mark container as deleted in database
+            let mut active_container: deployment_containers::ActiveModel = container.into();
+            active_container.deleted_at = Set(Some(chrono::Utc::now()));
+            active_container.status = Set(Some("removed".to_string()));
+            if let Err(e) = active_container.update(db).await {
+                log.write(format!("Failed to update container status: {}", e))
+                    .await;
+            }
         }
 
-        self.log("All previous deployments torn down successfully".to_string())
-            .await
-            .ok();
+        log.write(format!(
+            "Torn down deployment {} - containers stopped and removed",
+            deployment_id
+        ))
+        .await;
     }
+
+    log.write("All previous deployments torn down successfully".to_string())
+        .await;
 }
 
 #[async_trait]
@@ -574,6 +769,7 @@ pub struct MarkDeploymentCompleteJobBuilder {
     log_service: Option<Arc<LogService>>,
     container_deployer: Option<Arc<dyn temps_deployer::ContainerDeployer>>,
     queue: Option<Arc<dyn JobQueue>>,
+    teardown_previous: bool,
 }
 
 impl MarkDeploymentCompleteJobBuilder {
@@ -586,6 +782,7 @@ impl MarkDeploymentCompleteJobBuilder {
             log_service: None,
             container_deployer: None,
             queue: None,
+            teardown_previous: true,
         }
     }
 
@@ -627,6 +824,11 @@ impl MarkDeploymentCompleteJobBuilder {
         self
     }
 
+    pub fn teardown_previous(mut self, teardown_previous: bool) -> Self {
+        self.teardown_previous = teardown_previous;
+        self
+    }
+
     pub fn build(self) -> Result<MarkDeploymentCompleteJob, WorkflowError> {
         let job_id = self
             .job_id
@@ -653,6 +855,9 @@ impl MarkDeploymentCompleteJobBuilder {
         if let Some(log_service) = self.log_service {
             job = job.with_log_service(log_service);
         }
+        if !self.teardown_previous {
+            job = job.without_teardown();
+        }
 
         Ok(job)
     }
@@ -3,20 +3,34 @@
 //! This module provides ready-to-use job implementations for common deployment tasks.
 
 pub mod build_image;
+pub mod builders;
 pub mod configure_crons;
+pub mod deploy_gate;
 pub mod deploy_image;
 pub mod deploy_static;
 pub mod download_repo;
+pub mod drain_connections;
+pub mod fetch_artifact;
+pub mod finish_dry_run;
 pub mod mark_deployment_complete;
 pub mod pipeline_validation;
+pub mod purge_cdn;
 pub mod scan_vulnerabilities;
+pub mod shared_stage;
+pub mod smoke_tests;
 pub mod take_screenshot;
 
 pub use build_image::*;
 pub use configure_crons::*;
+pub use deploy_gate::*;
 pub use deploy_image::*;
 pub use deploy_static::*;
 pub use download_repo::*;
+pub use drain_connections::*;
+pub use fetch_artifact::*;
+pub use finish_dry_run::*;
 pub use mark_deployment_complete::*;
+pub use purge_cdn::*;
 pub use scan_vulnerabilities::*;
+pub use smoke_tests::*;
 pub use take_screenshot::*;
@@ -30,6 +30,7 @@ impl ImageBuilder for MockImageBuilder {
             image_name: request.image_name,
             size_bytes: 104857600,
             build_duration_ms: 5000,
+            phases: None,
         })
     }
 
@@ -112,6 +113,7 @@ impl ContainerDeployer for MockContainerDeployer {
             created_at: chrono::Utc::now(),
             ports: vec![],
             environment_vars: HashMap::new(),
+            labels: HashMap::new(),
         })
     }
 
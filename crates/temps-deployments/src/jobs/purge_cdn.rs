@@ -0,0 +1,457 @@
+//! Purge CDN Job
+//!
+//! Runs after a deployment received traffic when the environment has a CDN in front
+//! of it. The CDN is asked to drop what it cached of the previous version — all of
+//! it, the objects with the configured cache tags, or the configured URLs — with the
+//! environment's API token. The outcome is saved on the deployment; a purge that
+//! fails is logged as a warning and doesn't fail the deployment.
+
+use async_trait::async_trait;
+use sea_orm::{ActiveModelTrait, EntityTrait, Set};
+use std::sync::Arc;
+use std::time::Duration;
+use temps_core::{JobResult, WorkflowContext, WorkflowError, WorkflowTask};
+use temps_database::DbConnection;
+use temps_entities::deployment_config::{CdnProvider, CdnPurgeConfig, CdnPurgeScope};
+use temps_entities::deployments::{self, CdnPurgeReport};
+use temps_logs::{LogLevel, LogService};
+use tracing::{info, warn};
+
+/// Seconds before a single purge request to the CDN's API gives up
+const PURGE_REQUEST_TIMEOUT_SECONDS: u64 = 30;
+
+/// Most tags or URLs Cloudflare takes in one purge request
+const CLOUDFLARE_BATCH_SIZE: usize = 30;
+
+/// Most surrogate keys Fastly takes in one purge request
+const FASTLY_KEY_BATCH_SIZE: usize = 256;
+
+const CLOUDFLARE_API_URL: &str = "https://api.cloudflare.com/client/v4";
+const FASTLY_API_URL: &str = "https://api.fastly.com";
+
+/// A call to the CDN's API, without its credentials
+#[derive(Debug, Clone, PartialEq)]
+pub struct PurgeRequest {
+    pub url: String,
+    pub headers: Vec<(&'static str, String)>,
+    pub body: Option<serde_json::Value>,
+}
+
+/// The API calls that purge what a config selects, in order
+///
+/// Tags and URLs are split in batches the provider accepts; Fastly purges URLs one
+/// at a time.
+pub fn purge_requests(config: &CdnPurgeConfig) -> Vec<PurgeRequest> {
+    match config.provider {
+        CdnProvider::Cloudflare => {
+            let url = format!(
+                "{}/zones/{}/purge_cache",
+                CLOUDFLARE_API_URL,
+                config.zone_id.as_deref().unwrap_or_default()
+            );
+            let bodies = match config.scope {
+                CdnPurgeScope::All => vec![serde_json::json!({ "purge_everything": true })],
+                CdnPurgeScope::Tags => config
+                    .tags
+                    .chunks(CLOUDFLARE_BATCH_SIZE)
+                    .map(|tags| serde_json::json!({ "tags": tags }))
+                    .collect(),
+                CdnPurgeScope::Urls => config
+                    .urls
+                    .chunks(CLOUDFLARE_BATCH_SIZE)
+                    .map(|urls| serde_json::json!({ "files": urls }))
+                    .collect(),
+            };
+            bodies
+                .into_iter()
+                .map(|body| PurgeRequest {
+                    url: url.clone(),
+                    headers: vec![("Content-Type", "application/json".to_string())],
+                    body: Some(body),
+                })
+                .collect()
+        }
+        CdnProvider::Fastly => {
+            let service_id = config.service_id.as_deref().unwrap_or_default();
+            match config.scope {
+                CdnPurgeScope::All => vec![PurgeRequest {
+                    url: format!("{}/service/{}/purge_all", FASTLY_API_URL, service_id),
+                    headers: Vec::new(),
+                    body: None,
+                }],
+                CdnPurgeScope::Tags => config
+                    .tags
+                    .chunks(FASTLY_KEY_BATCH_SIZE)
+                    .map(|keys| PurgeRequest {
+                        url: format!("{}/service/{}/purge", FASTLY_API_URL, service_id),
+                        headers: vec![("Surrogate-Key", keys.join(" "))],
+                        body: None,
+                    })
+                    .collect(),
+                CdnPurgeScope::Urls => config
+                    .urls
+                    .iter()
+                    .map(|url| {
+                        let cached_url = url
+                            .trim_start_matches("https://")
+                            .trim_start_matches("http://");
+                        PurgeRequest {
+                            url: format!("{}/purge/{}", FASTLY_API_URL, cached_url),
+                            headers: Vec::new(),
+                            body: None,
+                        }
+                    })
+                    .collect(),
+            }
+        }
+    }
+}
+
+/// Header carrying the API token
+fn auth_header(config: &CdnPurgeConfig) -> (&'static str, String) {
+    match config.provider {
+        CdnProvider::Cloudflare => ("Authorization", format!("Bearer {}", config.api_token)),
+        CdnProvider::Fastly => ("Fastly-Key", config.api_token.clone()),
+    }
+}
+
+/// Error Cloudflare gave for a rejected request, from its `errors` list
+fn cloudflare_error(body: &str) -> Option<String> {
+    let body: serde_json::Value = serde_json::from_str(body).ok()?;
+    let messages: Vec<&str> = body
+        .get("errors")?
+        .as_array()?
+        .iter()
+        .filter_map(|error| error.get("message").and_then(|m| m.as_str()))
+        .collect();
+    (!messages.is_empty()).then(|| messages.join("; "))
+}
+
+/// Job that purges the CDN's cache after a deployment
+pub struct PurgeCdnJob {
+    job_id: String,
+    deployment_id: i32,
+    config: CdnPurgeConfig,
+    db: Arc<DbConnection>,
+    log_id: Option<String>,
+    log_service: Option<Arc<LogService>>,
+}
+
+impl std::fmt::Debug for PurgeCdnJob {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        // The config holds the API token
+        f.debug_struct("PurgeCdnJob")
+            .field("job_id", &self.job_id)
+            .field("deployment_id", &self.deployment_id)
+            .field("provider", &self.config.provider)
+            .field("scope", &self.config.scope)
+            .finish()
+    }
+}
+
+impl PurgeCdnJob {
+    pub fn new(
+        job_id: String,
+        deployment_id: i32,
+        config: CdnPurgeConfig,
+        db: Arc<DbConnection>,
+    ) -> Self {
+        Self {
+            job_id,
+            deployment_id,
+            config,
+            db,
+            log_id: None,
+            log_service: None,
+        }
+    }
+
+    pub fn with_log_id(mut self, log_id: String) -> Self {
+        self.log_id = Some(log_id);
+        self
+    }
+
+    pub fn with_log_service(mut self, log_service: Arc<LogService>) -> Self {
+        self.log_service = Some(log_service);
+        self
+    }
+
+    /// Write log message to job-specific log file
+    async fn log(&self, message: String) -> Result<(), WorkflowError> {
+        let level = Self::detect_log_level(&message);
+
+        if let (Some(log_id), Some(log_service)) = (&self.log_id, &self.log_service) {
+            log_service
+                .append_structured_log(log_id, level, message)
+                .await
+                .map_err(|e| {
+                    WorkflowError::JobExecutionFailed(format!("Failed to write log: {}", e))
+                })?;
+        }
+
+        Ok(())
+    }
+
+    fn detect_log_level(message: &str) -> LogLevel {
+        if message.contains("✅") {
+            LogLevel::Success
+        } else if message.contains("⚠️") {
+            LogLevel::Warning
+        } else {
+            LogLevel::Info
+        }
+    }
+
+    async fn save_report(&self, report: &CdnPurgeReport) -> Result<(), WorkflowError> {
+        let deployment = deployments::Entity::find_by_id(self.deployment_id)
+            .one(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to find deployment: {}", e))
+            })?
+            .ok_or_else(|| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Deployment {} not found",
+                    self.deployment_id
+                ))
+            })?;
+
+        let mut metadata = deployment.metadata.clone().unwrap_or_default();
+        metadata.cdn_purge = Some(report.clone());
+
+        let mut active_deployment: deployments::ActiveModel = deployment.into();
+        active_deployment.metadata = Set(Some(metadata));
+        active_deployment
+            .update(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to save CDN purge result: {}", e))
+            })?;
+        Ok(())
+    }
+
+    /// Send every purge request, stopping at the first one the CDN rejects
+    async fn purge(&self) -> Result<usize, String> {
+        let client = reqwest::Client::builder()
+            .timeout(Duration::from_secs(PURGE_REQUEST_TIMEOUT_SECONDS))
+            .build()
+            .map_err(|e| format!("Failed to create HTTP client: {}", e))?;
+        let (auth_name, auth_value) = auth_header(&self.config);
+
+        let requests = purge_requests(&self.config);
+        for request in &requests {
+            let mut builder = client
+                .post(&request.url)
+                .header(auth_name, &auth_value)
+                .header("Accept", "application/json");
+            for (name, value) in &request.headers {
+                builder = builder.header(*name, value);
+            }
+            if let Some(body) = &request.body {
+                builder = builder.body(body.to_string());
+            }
+
+            let response = builder
+                .send()
+                .await
+                .map_err(|e| format!("Request to {} failed: {}", self.config.provider, e))?;
+            let status = response.status();
+            if !status.is_success() {
+                let body = response.text().await.unwrap_or_default();
+                let reason = match self.config.provider {
+                    CdnProvider::Cloudflare => cloudflare_error(&body),
+                    CdnProvider::Fastly => None,
+                };
+                return Err(match reason {
+                    Some(reason) => format!(
+                        "{} answered with status {}: {}",
+                        self.config.provider,
+                        status.as_u16(),
+                        reason
+                    ),
+                    None => format!(
+                        "{} answered with status {}",
+                        self.config.provider,
+                        status.as_u16()
+                    ),
+                });
+            }
+        }
+        Ok(requests.len())
+    }
+}
+
+#[async_trait]
+impl WorkflowTask for PurgeCdnJob {
+    fn job_id(&self) -> &str {
+        &self.job_id
+    }
+
+    fn name(&self) -> &str {
+        "Purge CDN Cache"
+    }
+
+    fn description(&self) -> &str {
+        "Purges the CDN's cache of the environment after the deployment"
+    }
+
+    fn depends_on(&self) -> Vec<String> {
+        // Dependencies are set by the workflow planner
+        vec![]
+    }
+
+    async fn execute(&self, mut context: WorkflowContext) -> Result<JobResult, WorkflowError> {
+        let what = match self.config.scope {
+            CdnPurgeScope::All => "everything".to_string(),
+            CdnPurgeScope::Tags => format!("{} cache tag(s)", self.config.tags.len()),
+            CdnPurgeScope::Urls => format!("{} URL(s)", self.config.urls.len()),
+        };
+        self.log(format!(
+            "Purging {} from the {} cache",
+            what, self.config.provider
+        ))
+        .await?;
+
+        let started_at = chrono::Utc::now();
+        let result = self.purge().await;
+        let report = CdnPurgeReport {
+            provider: self.config.provider,
+            scope: self.config.scope,
+            succeeded: result.is_ok(),
+            message: result.as_ref().err().cloned(),
+            started_at,
+            completed_at: chrono::Utc::now(),
+        };
+        self.save_report(&report).await?;
+
+        match result {
+            Ok(requests) => {
+                info!(
+                    "Purged {} cache of deployment {} with {} request(s)",
+                    self.config.provider, self.deployment_id, requests
+                );
+                self.log(format!("✅ {} cache purged", self.config.provider))
+                    .await?;
+            }
+            Err(reason) => {
+                // Stale cache entries expire on their own; the deployment stands
+                warn!(
+                    "CDN purge of deployment {} failed: {}",
+                    self.deployment_id, reason
+                );
+                self.log(format!(
+                    "⚠️  CDN purge failed: {}; cached content may be stale until it expires",
+                    reason
+                ))
+                .await?;
+            }
+        }
+
+        context.set_output(&self.job_id, "purged", report.succeeded)?;
+        Ok(JobResult::success(context))
+    }
+
+    async fn validate_prerequisites(
+        &self,
+        _context: &WorkflowContext,
+    ) -> Result<(), WorkflowError> {
+        if self.deployment_id <= 0 {
+            return Err(WorkflowError::JobValidationFailed(
+                "deployment_id must be positive".to_string(),
+            ));
+        }
+        self.config
+            .validate()
+            .map_err(WorkflowError::JobValidationFailed)
+    }
+
+    async fn cleanup(&self, _context: &WorkflowContext) -> Result<(), WorkflowError> {
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn cloudflare(scope: CdnPurgeScope) -> CdnPurgeConfig {
+        CdnPurgeConfig {
+            provider: CdnProvider::Cloudflare,
+            api_token: "token".to_string(),
+            zone_id: Some("zone123".to_string()),
+            scope,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn cloudflare_purges_everything_in_one_request() {
+        let requests = purge_requests(&cloudflare(CdnPurgeScope::All));
+        assert_eq!(requests.len(), 1);
+        assert_eq!(
+            requests[0].url,
+            "https://api.cloudflare.com/client/v4/zones/zone123/purge_cache"
+        );
+        assert_eq!(
+            requests[0].body,
+            Some(serde_json::json!({ "purge_everything": true }))
+        );
+    }
+
+    #[test]
+    fn cloudflare_batches_urls() {
+        let config = CdnPurgeConfig {
+            urls: (0..45)
+                .map(|i| format!("https://example.com/{}.js", i))
+                .collect(),
+            ..cloudflare(CdnPurgeScope::Urls)
+        };
+        let requests = purge_requests(&config);
+        assert_eq!(requests.len(), 2);
+        let files = requests[1].body.as_ref().unwrap()["files"]
+            .as_array()
+            .unwrap();
+        assert_eq!(files.len(), 15);
+        assert_eq!(files[0], "https://example.com/30.js");
+    }
+
+    #[test]
+    fn fastly_purges_tags_by_surrogate_key() {
+        let config = CdnPurgeConfig {
+            provider: CdnProvider::Fastly,
+            api_token: "token".to_string(),
+            service_id: Some("svc1".to_string()),
+            scope: CdnPurgeScope::Tags,
+            tags: vec!["assets".to_string(), "html".to_string()],
+            ..Default::default()
+        };
+        let requests = purge_requests(&config);
+        assert_eq!(requests.len(), 1);
+        assert_eq!(requests[0].url, "https://api.fastly.com/service/svc1/purge");
+        assert_eq!(
+            requests[0].headers,
+            vec![("Surrogate-Key", "assets html".to_string())]
+        );
+
+        let config = CdnPurgeConfig {
+            scope: CdnPurgeScope::Urls,
+            urls: vec!["https://example.com/index.html".to_string()],
+            ..config
+        };
+        assert_eq!(
+            purge_requests(&config)[0].url,
+            "https://api.fastly.com/purge/example.com/index.html"
+        );
+    }
+
+    #[test]
+    fn cloudflare_errors_are_read_from_the_body() {
+        assert_eq!(
+            cloudflare_error(
+                r#"{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}"#
+            ),
+            Some("Authentication error".to_string())
+        );
+        assert_eq!(cloudflare_error("<html>"), None);
+    }
+}
@@ -0,0 +1,220 @@
+//! Shared Build Stages
+//!
+//! Monorepo services deployed as projects of the same repository can share a build
+//! stage. The stage is built from its own Dockerfile with a context of only the files
+//! under its paths, and tagged with a key hashed from those files. Every service of
+//! the stage building a commit with the same shared code finds the image under that
+//! tag and reuses it, layers and all; a change to the shared code changes the key and
+//! the next build of any of its services builds the stage anew. Builds of the same
+//! stage wait for each other, so that services deployed together build it once.
+
+use sha2::{Digest, Sha256};
+use std::collections::HashMap;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+use temps_entities::deployment_config::{normalize_repo_path, SharedBuildConfig};
+use tokio::sync::OwnedMutexGuard;
+
+/// Hex digits of the stage key kept in the image tag
+const KEY_TAG_LENGTH: usize = 16;
+
+/// Builds of shared stages in progress, by image and build node
+#[derive(Debug, Default)]
+pub struct SharedStageLocks {
+    locks: Mutex<HashMap<String, Arc<tokio::sync::Mutex<()>>>>,
+}
+
+impl SharedStageLocks {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Wait until no other build works on the stage image under the key, and keep
+    /// others waiting until the guard is dropped
+    pub async fn lock(&self, key: &str) -> OwnedMutexGuard<()> {
+        let lock = {
+            let mut locks = self.locks.lock().unwrap();
+            // Locks nobody holds or waits on any longer
+            locks.retain(|_, lock| Arc::strong_count(lock) > 1);
+            locks.entry(key.to_string()).or_default().clone()
+        };
+        lock.lock_owned().await
+    }
+}
+
+/// Files of the stage relative to the repository root, sorted: its Dockerfile and
+/// every file under its paths
+///
+/// Symbolic links aren't followed and `.git` directories are left out. Paths missing
+/// from the checkout are skipped, a missing Dockerfile isn't.
+pub fn stage_files(repo_dir: &Path, config: &SharedBuildConfig) -> io::Result<Vec<PathBuf>> {
+    let dockerfile = PathBuf::from(normalize_repo_path(&config.dockerfile));
+    if !repo_dir.join(&dockerfile).is_file() {
+        return Err(io::Error::new(
+            io::ErrorKind::NotFound,
+            format!(
+                "Shared build Dockerfile {} not found in the repository",
+                dockerfile.display()
+            ),
+        ));
+    }
+
+    let mut files = vec![dockerfile];
+    for path in &config.paths {
+        collect_files(repo_dir, Path::new(normalize_repo_path(path)), &mut files)?;
+    }
+    files.sort();
+    files.dedup();
+    Ok(files)
+}
+
+fn collect_files(repo_dir: &Path, relative: &Path, files: &mut Vec<PathBuf>) -> io::Result<()> {
+    let metadata = match fs::symlink_metadata(repo_dir.join(relative)) {
+        Ok(metadata) => metadata,
+        Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(()),
+        Err(e) => return Err(e),
+    };
+    if metadata.is_file() {
+        files.push(relative.to_path_buf());
+    } else if metadata.is_dir() {
+        for entry in fs::read_dir(repo_dir.join(relative))? {
+            let entry = entry?;
+            if entry.file_name() == ".git" {
+                continue;
+            }
+            collect_files(repo_dir, &relative.join(entry.file_name()), files)?;
+        }
+    }
+    Ok(())
+}
+
+/// Key of the stage's content: its files' paths and contents, and the platform it is
+/// built for
+pub fn stage_key(repo_dir: &Path, files: &[PathBuf], platform: Option<&str>) -> io::Result<String> {
+    let mut hasher = Sha256::new();
+    hasher.update(platform.unwrap_or_default().as_bytes());
+    for file in files {
+        let contents = fs::read(repo_dir.join(file))?;
+        hasher.update([0]);
+        hasher.update(file.to_string_lossy().as_bytes());
+        hasher.update([0]);
+        hasher.update((contents.len() as u64).to_be_bytes());
+        hasher.update(&contents);
+    }
+    Ok(hex::encode(hasher.finalize()))
+}
+
+/// Image of a stage of a repository for a key
+pub fn stage_image(repo_owner: &str, repo_name: &str, stage: &str, key: &str) -> String {
+    let repository = format!("temps-shared-{}-{}-{}", repo_owner, repo_name, stage)
+        .to_lowercase()
+        .chars()
+        .map(|c| {
+            if c.is_ascii_alphanumeric() || c == '-' {
+                c
+            } else {
+                '-'
+            }
+        })
+        .collect::<String>();
+    format!("{}:{}", repository, &key[..KEY_TAG_LENGTH.min(key.len())])
+}
+
+/// Copy the stage's files into a fresh build context
+pub fn copy_stage_context(
+    repo_dir: &Path,
+    files: &[PathBuf],
+    context_dir: &Path,
+) -> io::Result<()> {
+    for file in files {
+        let target = context_dir.join(file);
+        if let Some(parent) = target.parent() {
+            fs::create_dir_all(parent)?;
+        }
+        fs::copy(repo_dir.join(file), target)?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn stage() -> SharedBuildConfig {
+        SharedBuildConfig {
+            name: "workspace".to_string(),
+            dockerfile: "docker/shared.Dockerfile".to_string(),
+            paths: vec!["packages/common".to_string(), "Cargo.lock".to_string()],
+        }
+    }
+
+    fn write(dir: &Path, file: &str, contents: &str) {
+        let path = dir.join(file);
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(path, contents).unwrap();
+    }
+
+    #[test]
+    fn test_stage_files_and_key() {
+        let repo = tempfile::tempdir().unwrap();
+        write(repo.path(), "docker/shared.Dockerfile", "FROM rust:1.80");
+        write(
+            repo.path(),
+            "packages/common/src/lib.rs",
+            "pub fn common() {}",
+        );
+        write(repo.path(), "Cargo.lock", "# lock");
+        write(repo.path(), "apps/api/src/main.rs", "fn main() {}");
+
+        let files = stage_files(repo.path(), &stage()).unwrap();
+        assert_eq!(
+            files,
+            vec![
+                PathBuf::from("Cargo.lock"),
+                PathBuf::from("docker/shared.Dockerfile"),
+                PathBuf::from("packages/common/src/lib.rs"),
+            ]
+        );
+
+        let key = stage_key(repo.path(), &files, None).unwrap();
+        // A service's own code doesn't change the stage
+        write(repo.path(), "apps/api/src/main.rs", "fn main() { todo!() }");
+        assert_eq!(stage_key(repo.path(), &files, None).unwrap(), key);
+        assert_ne!(
+            stage_key(repo.path(), &files, Some("linux/arm64")).unwrap(),
+            key
+        );
+        write(
+            repo.path(),
+            "packages/common/src/lib.rs",
+            "pub fn shared() {}",
+        );
+        assert_ne!(stage_key(repo.path(), &files, None).unwrap(), key);
+
+        let context = tempfile::tempdir().unwrap();
+        copy_stage_context(repo.path(), &files, context.path()).unwrap();
+        assert!(context.path().join("packages/common/src/lib.rs").is_file());
+        assert!(!context.path().join("apps").exists());
+    }
+
+    #[test]
+    fn test_stage_files_need_the_dockerfile() {
+        let repo = tempfile::tempdir().unwrap();
+        write(
+            repo.path(),
+            "packages/common/src/lib.rs",
+            "pub fn common() {}",
+        );
+        assert!(stage_files(repo.path(), &stage()).is_err());
+    }
+
+    #[test]
+    fn test_stage_image() {
+        assert_eq!(
+            stage_image("Acme", "mono.repo", "workspace", "0123456789abcdef0123"),
+            "temps-shared-acme-mono-repo-workspace:0123456789abcdef"
+        );
+    }
+}
@@ -0,0 +1,326 @@
+//! Smoke Test Job
+//!
+//! Runs the service's smoke tests against every new container once it is healthy and
+//! before the deployment is marked complete, so a version that starts but doesn't work
+//! never receives traffic. The results are saved on the deployment whether the checks
+//! pass or fail. A failed check fails the deployment and removes the new containers,
+//! leaving the current deployment serving.
+
+use async_trait::async_trait;
+use sea_orm::{ActiveModelTrait, EntityTrait, Set};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+use temps_core::{JobResult, WorkflowContext, WorkflowError, WorkflowTask};
+use temps_database::DbConnection;
+use temps_entities::deployment_config::{SmokeTest, SmokeTestsConfig};
+use temps_entities::deployments::{self, SmokeTestReport, SmokeTestResult};
+use temps_logs::{LogLevel, LogService};
+use tracing::{info, warn};
+
+/// Job that runs smoke tests against the containers of a new deployment
+pub struct SmokeTestJob {
+    job_id: String,
+    deployment_id: i32,
+    deploy_job_id: String,
+    config: SmokeTestsConfig,
+    db: Arc<DbConnection>,
+    container_deployer: Arc<dyn temps_deployer::ContainerDeployer>,
+    log_id: Option<String>,
+    log_service: Option<Arc<LogService>>,
+}
+
+impl std::fmt::Debug for SmokeTestJob {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("SmokeTestJob")
+            .field("job_id", &self.job_id)
+            .field("deployment_id", &self.deployment_id)
+            .field("deploy_job_id", &self.deploy_job_id)
+            .field("checks", &self.config.checks.len())
+            .finish()
+    }
+}
+
+impl SmokeTestJob {
+    pub fn new(
+        job_id: String,
+        deployment_id: i32,
+        deploy_job_id: String,
+        config: SmokeTestsConfig,
+        db: Arc<DbConnection>,
+        container_deployer: Arc<dyn temps_deployer::ContainerDeployer>,
+    ) -> Self {
+        Self {
+            job_id,
+            deployment_id,
+            deploy_job_id,
+            config,
+            db,
+            container_deployer,
+            log_id: None,
+            log_service: None,
+        }
+    }
+
+    pub fn with_log_id(mut self, log_id: String) -> Self {
+        self.log_id = Some(log_id);
+        self
+    }
+
+    pub fn with_log_service(mut self, log_service: Arc<LogService>) -> Self {
+        self.log_service = Some(log_service);
+        self
+    }
+
+    /// Write log message to job-specific log file
+    async fn log(&self, message: String) -> Result<(), WorkflowError> {
+        let level = Self::detect_log_level(&message);
+
+        if let (Some(log_id), Some(log_service)) = (&self.log_id, &self.log_service) {
+            log_service
+                .append_structured_log(log_id, level, message)
+                .await
+                .map_err(|e| {
+                    WorkflowError::JobExecutionFailed(format!("Failed to write log: {}", e))
+                })?;
+        }
+
+        Ok(())
+    }
+
+    fn detect_log_level(message: &str) -> LogLevel {
+        if message.contains("✅") {
+            LogLevel::Success
+        } else if message.contains("❌") {
+            LogLevel::Error
+        } else if message.contains("⚠️") {
+            LogLevel::Warning
+        } else {
+            LogLevel::Info
+        }
+    }
+
+    /// Send one smoke test to a container
+    async fn run_check(
+        &self,
+        client: &reqwest::Client,
+        endpoint: &str,
+        check: &SmokeTest,
+    ) -> SmokeTestResult {
+        let method = check.method();
+        let url = format!("{}{}", endpoint.trim_end_matches('/'), check.path);
+        let started = Instant::now();
+
+        let mut request = client.request(
+            reqwest::Method::from_bytes(method.as_bytes()).unwrap_or(reqwest::Method::GET),
+            &url,
+        );
+        for (name, value) in &check.headers {
+            request = request.header(name, value);
+        }
+        if let Some(body) = &check.body {
+            request = request.body(body.clone());
+        }
+
+        let (status, error) = match request.send().await {
+            Ok(response) => {
+                let status = response.status().as_u16();
+                match response.text().await {
+                    Ok(body) => (Some(status), check.check_response(status, &body)),
+                    Err(e) => (
+                        Some(status),
+                        Some(format!("Failed to read response body: {}", e)),
+                    ),
+                }
+            }
+            Err(e) if e.is_timeout() => (
+                None,
+                Some(format!(
+                    "No response within {}s",
+                    self.config.timeout_seconds()
+                )),
+            ),
+            Err(e) => (None, Some(format!("Request failed: {}", e))),
+        };
+
+        SmokeTestResult {
+            name: check.name.clone(),
+            method,
+            url,
+            status,
+            passed: error.is_none(),
+            error,
+            duration_ms: started.elapsed().as_millis() as i64,
+        }
+    }
+
+    /// Save the report on the deployment
+    async fn save_report(&self, report: &SmokeTestReport) -> Result<(), WorkflowError> {
+        let deployment = deployments::Entity::find_by_id(self.deployment_id)
+            .one(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to find deployment: {}", e))
+            })?
+            .ok_or_else(|| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Deployment {} not found",
+                    self.deployment_id
+                ))
+            })?;
+
+        let mut metadata = deployment.metadata.clone().unwrap_or_default();
+        metadata.smoke_tests = Some(report.clone());
+        let mut active_deployment: deployments::ActiveModel = deployment.into();
+        active_deployment.metadata = Set(Some(metadata));
+        active_deployment
+            .update(self.db.as_ref())
+            .await
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!(
+                    "Failed to save smoke test results: {}",
+                    e
+                ))
+            })?;
+        Ok(())
+    }
+}
+
+#[async_trait]
+impl WorkflowTask for SmokeTestJob {
+    fn job_id(&self) -> &str {
+        &self.job_id
+    }
+
+    fn name(&self) -> &str {
+        "Run Smoke Tests"
+    }
+
+    fn description(&self) -> &str {
+        "Runs HTTP checks against the new containers before they receive traffic"
+    }
+
+    fn depends_on(&self) -> Vec<String> {
+        vec![self.deploy_job_id.clone()]
+    }
+
+    async fn execute(&self, mut context: WorkflowContext) -> Result<JobResult, WorkflowError> {
+        let endpoints: Vec<String> = context
+            .get_output(&self.deploy_job_id, "endpoints")?
+            .unwrap_or_default();
+        if endpoints.is_empty() {
+            return Err(WorkflowError::JobExecutionFailed(format!(
+                "Job {} reported no containers to test",
+                self.deploy_job_id
+            )));
+        }
+
+        self.log(format!(
+            "Running {} smoke test(s) against {} container(s)",
+            self.config.checks.len(),
+            endpoints.len()
+        ))
+        .await?;
+
+        let client = reqwest::Client::builder()
+            .timeout(Duration::from_secs(self.config.timeout_seconds() as u64))
+            .redirect(reqwest::redirect::Policy::none())
+            .build()
+            .map_err(|e| {
+                WorkflowError::JobExecutionFailed(format!("Failed to create HTTP client: {}", e))
+            })?;
+
+        let mut results = Vec::new();
+        for endpoint in &endpoints {
+            for check in &self.config.checks {
+                let result = self.run_check(&client, endpoint, check).await;
+                match &result.error {
+                    None => {
+                        self.log(format!(
+                            "✅ {} {} {} ({}ms)",
+                            result.name, result.method, result.url, result.duration_ms
+                        ))
+                        .await?
+                    }
+                    Some(error) => {
+                        self.log(format!(
+                            "❌ {} {} {}: {}",
+                            result.name, result.method, result.url, error
+                        ))
+                        .await?
+                    }
+                }
+                results.push(result);
+            }
+        }
+
+        let report = SmokeTestReport {
+            passed: results.iter().all(|result| result.passed),
+            results,
+        };
+        self.save_report(&report).await?;
+        context.set_output(&self.job_id, "passed", report.passed)?;
+
+        if !report.passed {
+            let failed = report
+                .results
+                .iter()
+                .filter(|result| !result.passed)
+                .count();
+            self.log(format!(
+                "❌ {} of {} smoke test(s) failed; the new version will not receive traffic",
+                failed,
+                report.results.len()
+            ))
+            .await?;
+            return Err(WorkflowError::JobExecutionFailed(format!(
+                "{} of {} smoke test(s) failed",
+                failed,
+                report.results.len()
+            )));
+        }
+
+        info!(
+            "Smoke tests of deployment {} passed ({} checks)",
+            self.deployment_id,
+            report.results.len()
+        );
+        self.log("✅ All smoke tests passed".to_string()).await?;
+        Ok(JobResult::success(context))
+    }
+
+    async fn validate_prerequisites(
+        &self,
+        _context: &WorkflowContext,
+    ) -> Result<(), WorkflowError> {
+        self.config
+            .validate()
+            .map_err(WorkflowError::JobValidationFailed)
+    }
+
+    /// Remove the new containers, workers included, which never received traffic
+    async fn cleanup(&self, context: &WorkflowContext) -> Result<(), WorkflowError> {
+        let container_ids = super::deployed_container_ids(context, &self.deploy_job_id)?;
+
+        for container_id in &container_ids {
+            if let Err(e) = self.container_deployer.stop_container(container_id).await {
+                warn!("Failed to stop container {}: {}", container_id, e);
+            }
+            match self.container_deployer.remove_container(container_id).await {
+                Ok(_) => {
+                    self.log(format!("🧹 Removed container {}", container_id))
+                        .await
+                        .ok();
+                }
+                Err(e) => {
+                    self.log(format!(
+                        "⚠️  Failed to remove container {}: {}",
+                        container_id, e
+                    ))
+                    .await
+                    .ok();
+                }
+            }
+        }
+        Ok(())
+    }
+}
@@ -64,6 +64,25 @@ impl TempsPlugin for DeploymentsPlugin {
                 deployment_service.clone() as Arc<dyn temps_core::DeploymentCanceller>;
             context.register_service(deployment_canceller);
 
+            // Global limit on concurrently running deployment pipelines
+            let build_queue = Arc::new(crate::services::BuildQueue::new(config_service.clone()));
+            context.register_service(build_queue.clone());
+
+            // Limits on open log tail streams
+            context.register_service(Arc::new(crate::services::LogStreamLimiter::new(
+                config_service.clone(),
+            )));
+
+            // Alerts for deployments stuck in the build queue or building slowly
+            let mut build_alerts =
+                crate::services::BuildAlertService::new(db.clone(), queue_service.clone());
+            if let Some(notification_service) =
+                context.get_service::<dyn temps_core::notifications::NotificationService>()
+            {
+                build_alerts = build_alerts.with_notification_service(notification_service);
+            }
+            let build_alerts = Arc::new(build_alerts);
+
             // Cancel any running deployments from previous server instance
             let cancel_service = deployment_service.clone();
             tokio::spawn(async move {
@@ -76,10 +95,10 @@ impl TempsPlugin for DeploymentsPlugin {
             });
 
             // Create DatabaseCronConfigService to manage cron jobs
-            let database_cron_service = Arc::new(crate::services::DatabaseCronConfigService::new(
-                db.clone(),
-                queue_service.clone(),
-            ));
+            let database_cron_service = Arc::new(
+                crate::services::DatabaseCronConfigService::new(db.clone(), queue_service.clone())
+                    .with_config_service(config_service.clone()),
+            );
             let cron_service =
                 database_cron_service.clone() as Arc<dyn crate::jobs::CronConfigService>;
 
@@ -93,10 +112,44 @@ impl TempsPlugin for DeploymentsPlugin {
                 scheduler_service.start_cron_scheduler().await;
             });
 
-            // Start Docker cleanup scheduler in background (nightly cleanup at 2 AM UTC)
-            let docker_cleanup = Arc::new(crate::services::DockerCleanupService::new(Arc::new(
-                crate::services::DefaultDockerClient,
-            )));
+            // Deployment artifacts retained in blob storage (e.g. static bundles)
+            let artifact_service = Arc::new(crate::services::DeploymentArtifactService::new(
+                db.clone(),
+                context.require_service::<temps_blob::BlobService>(),
+            ));
+            context.register_service(artifact_service.clone());
+
+            // Runtime operations shared by garbage collection and deployment retention
+            let gc_runtime: Arc<dyn crate::services::GcRuntime> = Arc::new(
+                crate::services::DockerGcRuntime::new(context.require_service::<bollard::Docker>()),
+            );
+
+            // Prunes deployment history beyond its retention
+            let retention_service = Arc::new(crate::services::DeploymentRetentionService::new(
+                db.clone(),
+                config_service.clone(),
+                log_service.clone(),
+                artifact_service.clone(),
+                gc_runtime.clone(),
+            ));
+            context.register_service(retention_service.clone());
+
+            // Release history of projects, with the release notes of their deploys
+            let changelog_service = Arc::new(crate::services::ChangelogService::new(db.clone()));
+            context.register_service(changelog_service);
+
+            // Start Docker cleanup scheduler in background (nightly cleanup in the
+            // configured window), which also expires retained artifacts and prunes old
+            // deployments
+            let docker_cleanup = Arc::new(
+                crate::services::DockerCleanupService::new(Arc::new(
+                    crate::services::DefaultDockerClient,
+                ))
+                .with_artifact_service(artifact_service.clone())
+                .with_retention_service(retention_service)
+                .with_config_service(config_service.clone()),
+            );
+            context.register_service(docker_cleanup.clone());
             tokio::spawn({
                 let cleanup_service = docker_cleanup.clone();
                 async move {
@@ -105,6 +158,55 @@ impl TempsPlugin for DeploymentsPlugin {
                 }
             });
 
+            // Start orphaned resource garbage collection in background
+            let resource_gc_service = Arc::new(crate::services::ResourceGcService::new(
+                db.clone(),
+                gc_runtime.clone(),
+                config_service.clone(),
+            ));
+            context.register_service(resource_gc_service.clone());
+            tokio::spawn({
+                let resource_gc_service = resource_gc_service.clone();
+                async move {
+                    tracing::debug!("Starting orphaned resource GC scheduler");
+                    resource_gc_service.start_gc_scheduler().await;
+                }
+            });
+
+            // Refuse new builds when disk space is critical (after trying GC first)
+            let disk_space_guard = Arc::new(crate::services::DiskSpaceGuard::new(
+                db.clone(),
+                config_service.clone(),
+                resource_gc_service,
+                gc_runtime,
+            ));
+            context.register_service(disk_space_guard.clone());
+
+            // Alerts on sustained CPU and memory usage, evaluated on each sample
+            let mut resource_alerts = crate::services::ResourceAlertService::new(db.clone());
+            if let Some(notification_service) =
+                context.get_service::<dyn temps_core::notifications::NotificationService>()
+            {
+                resource_alerts = resource_alerts.with_notification_service(notification_service);
+            }
+            let resource_alerts = Arc::new(resource_alerts);
+            context.register_service(resource_alerts.clone());
+
+            // Record the resource usage of each environment's containers over time
+            let container_metrics_service = Arc::new(
+                crate::services::ContainerMetricsService::new(
+                    db.clone(),
+                    deployer.clone(),
+                    config_service.clone(),
+                )
+                .with_alerts(resource_alerts),
+            );
+            context.register_service(container_metrics_service.clone());
+            tokio::spawn(async move {
+                tracing::debug!("Starting container metrics collector");
+                container_metrics_service.start_scheduler().await;
+            });
+
             // Get screenshot service (required)
             let screenshot_service =
                 context.require_service::<temps_screenshots::ScreenshotService>();
@@ -113,19 +215,40 @@ impl TempsPlugin for DeploymentsPlugin {
             let static_deployer =
                 context.require_service::<dyn temps_deployer::static_deployer::StaticDeployer>();
 
+            // Builds run on the configured build nodes, or locally without any
+            let build_node_scheduler = Arc::new(crate::services::BuildNodeScheduler::new(
+                config_service.clone(),
+                context.require_service::<bollard::Docker>(),
+                image_builder.clone(),
+            ));
+            context.register_service(build_node_scheduler.clone());
+
             // Create WorkflowExecutionService
-            let workflow_execution_service = Arc::new(WorkflowExecutionService::new(
+            let mut workflow_execution_service = WorkflowExecutionService::new(
                 db.clone(),
                 queue_service.clone(),
                 git_provider,
-                image_builder,
-                deployer,
+                image_builder.clone(),
+                deployer.clone(),
                 static_deployer,
                 log_service.clone(),
                 cron_service,
                 config_service.clone(),
                 screenshot_service,
-            ));
+            )
+            .with_build_capacity_check(disk_space_guard)
+            .with_build_queue(build_queue.clone())
+            .with_build_alerts(build_alerts)
+            .with_artifact_service(artifact_service)
+            .with_encryption_service(context.require_service::<temps_core::EncryptionService>())
+            .with_build_node_scheduler(build_node_scheduler);
+            // The route table is shared with the proxy when it runs in this process, and
+            // counts the connections it has open to each deployment
+            if let Some(route_table) = context.get_service::<temps_routes::CachedPeerTable>() {
+                workflow_execution_service =
+                    workflow_execution_service.with_connection_tracker(route_table.connections());
+            }
+            let workflow_execution_service = Arc::new(workflow_execution_service);
 
             // Get ExternalServiceManager for accessing external service env vars
             let external_service_manager =
@@ -145,8 +268,105 @@ impl TempsPlugin for DeploymentsPlugin {
                 external_service_manager.clone(),
                 config_service.clone(),
                 dsn_service,
+                encryption_service.clone(),
+            ));
+
+            // Promote deployments between environments without rebuilding them
+            let promotion_service = Arc::new(crate::services::PromotionService::new(
+                db.clone(),
+                queue_service.clone(),
+                context.require_service::<bollard::Docker>(),
+                workflow_planner.clone(),
+                workflow_execution_service.clone(),
+            ));
+            context.register_service(promotion_service.clone());
+
+            // Watch Docker events and reconcile container state whenever the daemon
+            // (re)connects, so a daemon restart does not leave deployments untracked.
+            // Environments whose containers are gone are redeployed
+            let docker = context.require_service::<bollard::Docker>();
+            let container_reconciler = Arc::new(
+                crate::services::ContainerReconciler::new(db.clone(), deployer)
+                    .with_promotion_service(promotion_service.clone()),
+            );
+            tokio::spawn(async move {
+                tracing::debug!("Starting container runtime event watcher");
+                let watcher = temps_deployer::events::RuntimeEventWatcher::new(
+                    Arc::new(temps_deployer::events::DockerEventSource::new(docker)),
+                    container_reconciler,
+                );
+                watcher
+                    .run(tokio_util::sync::CancellationToken::new())
+                    .await;
+            });
+
+            // Start/stop/deploy whole projects with their services in dependency order
+            let project_lifecycle_service =
+                Arc::new(crate::services::ProjectLifecycleService::new(
+                    db.clone(),
+                    deployment_service.clone(),
+                    context.require_service::<temps_providers::ServiceDependencyManager>(),
+                    build_queue.clone(),
+                    promotion_service,
+                ));
+            context.register_service(project_lifecycle_service.clone());
+
+            // Also register as EnvironmentRedeployer trait for temps-providers
+            let environment_redeployer =
+                project_lifecycle_service as Arc<dyn temps_core::EnvironmentRedeployer>;
+            context.register_service(environment_redeployer);
+
+            // Change runtime config values without a redeploy
+            let runtime_config_service = Arc::new(crate::services::RuntimeConfigService::new(
+                db.clone(),
+                context.require_service::<bollard::Docker>(),
+                config_service.clone(),
+            ));
+            context.register_service(runtime_config_service);
+
+            // Deploy build artifacts CI pipelines publish at a URL
+            let artifact_deploy_service = Arc::new(crate::services::ArtifactDeployService::new(
+                db.clone(),
+                queue_service.clone(),
                 encryption_service,
+                workflow_planner.clone(),
+                workflow_execution_service.clone(),
             ));
+            context.register_service(artifact_deploy_service);
+
+            // Report and clear the persistent build caches of projects
+            let build_cache_service = Arc::new(crate::services::BuildCacheService::new(Arc::new(
+                crate::services::DockerBuildCacheRuntime::new(
+                    context.require_service::<bollard::Docker>(),
+                ),
+            )));
+            context.register_service(build_cache_service);
+
+            // Record verdicts external systems post back for deploy gates
+            let deploy_gate_service = Arc::new(crate::services::DeployGateService::new(db.clone()));
+            context.register_service(deploy_gate_service);
+
+            // Rebuild running deployments when their base images get updates
+            let mut base_image_updates = crate::services::BaseImageUpdateService::new(
+                db.clone(),
+                config_service.clone(),
+                queue_service.clone(),
+                image_builder,
+                workflow_planner.clone(),
+                workflow_execution_service.clone(),
+            );
+            if let Some(notification_service) =
+                context.get_service::<dyn temps_core::notifications::NotificationService>()
+            {
+                base_image_updates =
+                    base_image_updates.with_notification_service(notification_service);
+            }
+            let base_image_updates = Arc::new(base_image_updates);
+            context.register_service(base_image_updates.clone());
+            tokio::spawn(async move {
+                tracing::debug!("Starting base image update scheduler");
+                base_image_updates.start_scheduler().await;
+            });
 
             let mut job_processor = JobProcessorService::with_external_service_manager(
                 db,
@@ -182,6 +402,10 @@ impl TempsPlugin for DeploymentsPlugin {
             .get_service::<crate::services::DatabaseCronConfigService>()
             .expect("DatabaseCronConfigService must be registered before configuring routes");
 
+        let log_streams = context
+            .get_service::<crate::services::LogStreamLimiter>()
+            .expect("LogStreamLimiter must be registered before configuring routes");
+
         // Create external deployment manager for handling external images and operations
         let external_deployment_manager =
             Arc::new(crate::services::ExternalDeploymentManager::new());
@@ -191,16 +415,170 @@ impl TempsPlugin for DeploymentsPlugin {
             log_service,
             cron_service,
             external_deployment_manager,
+            audit_service: context.require_service::<dyn temps_core::AuditLogger>(),
+            log_streams: log_streams.clone(),
         });
 
         let deployments_routes = handlers::deployments::configure_routes();
         let cron_routes = handlers::crons::configure_routes();
         let external_images_routes = handlers::external_images::configure_routes();
 
+        let resource_gc_service = context
+            .get_service::<crate::services::ResourceGcService>()
+            .expect("ResourceGcService must be registered before configuring routes");
+        let disk_space_guard = context
+            .get_service::<crate::services::DiskSpaceGuard>()
+            .expect("DiskSpaceGuard must be registered before configuring routes");
+        let resource_gc_routes = handlers::resource_gc::configure_routes().with_state(Arc::new(
+            handlers::resource_gc::ResourceGcAppState {
+                resource_gc_service,
+                disk_space_guard,
+            },
+        ));
+
+        let project_lifecycle_service = context
+            .get_service::<crate::services::ProjectLifecycleService>()
+            .expect("ProjectLifecycleService must be registered before configuring routes");
+        let project_lifecycle_routes = handlers::project_lifecycle::configure_routes().with_state(
+            Arc::new(handlers::project_lifecycle::ProjectLifecycleAppState {
+                project_lifecycle_service,
+                audit_service: context.require_service::<dyn temps_core::AuditLogger>(),
+            }),
+        );
+
+        let artifact_service = context
+            .get_service::<crate::services::DeploymentArtifactService>()
+            .expect("DeploymentArtifactService must be registered before configuring routes");
+        let artifact_routes =
+            handlers::deployment_artifacts::configure_routes().with_state(Arc::new(
+                handlers::deployment_artifacts::DeploymentArtifactAppState { artifact_service },
+            ));
+
+        let retention_service = context
+            .get_service::<crate::services::DeploymentRetentionService>()
+            .expect("DeploymentRetentionService must be registered before configuring routes");
+        let cleanup_service = context
+            .get_service::<crate::services::DockerCleanupService>()
+            .expect("DockerCleanupService must be registered before configuring routes");
+        let retention_routes =
+            handlers::deployment_retention::configure_routes().with_state(Arc::new(
+                handlers::deployment_retention::DeploymentRetentionAppState {
+                    retention_service,
+                    cleanup_service,
+                },
+            ));
+
+        let changelog_service = context
+            .get_service::<crate::services::ChangelogService>()
+            .expect("ChangelogService must be registered before configuring routes");
+        let changelog_routes = handlers::changelog::configure_routes().with_state(Arc::new(
+            handlers::changelog::ChangelogAppState {
+                changelog_service,
+                config_service: context.require_service::<temps_config::ConfigService>(),
+            },
+        ));
+
+        let promotion_service = context
+            .get_service::<crate::services::PromotionService>()
+            .expect("PromotionService must be registered before configuring routes");
+        let promotion_routes = handlers::promotions::configure_routes().with_state(Arc::new(
+            handlers::promotions::PromotionAppState { promotion_service },
+        ));
+
+        let runtime_config_service = context
+            .get_service::<crate::services::RuntimeConfigService>()
+            .expect("RuntimeConfigService must be registered before configuring routes");
+        let runtime_config_routes = handlers::runtime_config::configure_routes().with_state(
+            Arc::new(handlers::runtime_config::RuntimeConfigAppState {
+                runtime_config_service,
+                audit_service: context.require_service::<dyn temps_core::AuditLogger>(),
+            }),
+        );
+
+        let artifact_deploy_service = context
+            .get_service::<crate::services::ArtifactDeployService>()
+            .expect("ArtifactDeployService must be registered before configuring routes");
+        let artifact_deploy_routes = handlers::artifact_deploys::configure_routes().with_state(
+            Arc::new(handlers::artifact_deploys::ArtifactDeployAppState {
+                artifact_deploy_service,
+            }),
+        );
+
+        let build_cache_service = context
+            .get_service::<crate::services::BuildCacheService>()
+            .expect("BuildCacheService must be registered before configuring routes");
+        let build_cache_routes = handlers::build_cache::configure_routes().with_state(Arc::new(
+            handlers::build_cache::BuildCacheAppState {
+                build_cache_service,
+            },
+        ));
+
+        let deploy_gate_service = context
+            .get_service::<crate::services::DeployGateService>()
+            .expect("DeployGateService must be registered before configuring routes");
+        let deploy_gate_routes = handlers::deploy_gates::configure_routes().with_state(Arc::new(
+            handlers::deploy_gates::DeployGateAppState {
+                deploy_gate_service,
+            },
+        ));
+
+        let build_queue = context
+            .get_service::<crate::services::BuildQueue>()
+            .expect("BuildQueue must be registered before configuring routes");
+        let build_queue_routes = handlers::build_queue::configure_routes().with_state(Arc::new(
+            handlers::build_queue::BuildQueueAppState { build_queue },
+        ));
+
+        let log_stream_routes = handlers::log_streams::configure_routes().with_state(Arc::new(
+            handlers::log_streams::LogStreamsAppState { log_streams },
+        ));
+
+        let build_node_scheduler = context
+            .get_service::<crate::services::BuildNodeScheduler>()
+            .expect("BuildNodeScheduler must be registered before configuring routes");
+        let build_node_routes = handlers::build_nodes::configure_routes().with_state(Arc::new(
+            handlers::build_nodes::BuildNodesAppState {
+                build_node_scheduler,
+            },
+        ));
+
+        let container_metrics_service = context
+            .get_service::<crate::services::ContainerMetricsService>()
+            .expect("ContainerMetricsService must be registered before configuring routes");
+        let container_metrics_routes = handlers::container_metrics::configure_routes().with_state(
+            Arc::new(handlers::container_metrics::ContainerMetricsAppState {
+                container_metrics_service,
+            }),
+        );
+
+        let resource_alert_service = context
+            .get_service::<crate::services::ResourceAlertService>()
+            .expect("ResourceAlertService must be registered before configuring routes");
+        let resource_alert_routes = handlers::resource_alerts::configure_routes().with_state(
+            Arc::new(handlers::resource_alerts::ResourceAlertAppState {
+                resource_alert_service,
+            }),
+        );
+
         let routes = deployments_routes
             .merge(cron_routes)
             .merge(external_images_routes)
-            .with_state(app_state);
+            .with_state(app_state)
+            .merge(resource_gc_routes)
+            .merge(project_lifecycle_routes)
+            .merge(artifact_routes)
+            .merge(retention_routes)
+            .merge(changelog_routes)
+            .merge(promotion_routes)
+            .merge(runtime_config_routes)
+            .merge(artifact_deploy_routes)
+            .merge(build_cache_routes)
+            .merge(deploy_gate_routes)
+            .merge(build_queue_routes)
+            .merge(log_stream_routes)
+            .merge(build_node_routes)
+            .merge(container_metrics_routes)
+            .merge(resource_alert_routes);
 
         Some(PluginRoutes { router: routes })
     }
@@ -211,10 +589,57 @@ impl TempsPlugin for DeploymentsPlugin {
         let cron_schema = <handlers::crons::CronApiDoc as UtoimaOpenApi>::openapi();
         let external_images_schema =
             <handlers::external_images::ExternalImagesApiDoc as UtoimaOpenApi>::openapi();
+        let resource_gc_schema =
+            <handlers::resource_gc::ResourceGcApiDoc as UtoimaOpenApi>::openapi();
+        let project_lifecycle_schema =
+            <handlers::project_lifecycle::ProjectLifecycleApiDoc as UtoimaOpenApi>::openapi();
+        let artifacts_schema =
+            <handlers::deployment_artifacts::DeploymentArtifactsApiDoc as UtoimaOpenApi>::openapi();
+        let retention_schema =
+            <handlers::deployment_retention::DeploymentRetentionApiDoc as UtoimaOpenApi>::openapi();
+        let changelog_schema = <handlers::changelog::ChangelogApiDoc as UtoimaOpenApi>::openapi();
+        let promotions_schema =
+            <handlers::promotions::PromotionsApiDoc as UtoimaOpenApi>::openapi();
+        let runtime_config_schema =
+            <handlers::runtime_config::RuntimeConfigApiDoc as UtoimaOpenApi>::openapi();
+        let artifact_deploys_schema =
+            <handlers::artifact_deploys::ArtifactDeploysApiDoc as UtoimaOpenApi>::openapi();
+        let build_cache_schema =
+            <handlers::build_cache::BuildCacheApiDoc as UtoimaOpenApi>::openapi();
+        let deploy_gate_schema =
+            <handlers::deploy_gates::DeployGateApiDoc as UtoimaOpenApi>::openapi();
+        let build_queue_schema =
+            <handlers::build_queue::BuildQueueApiDoc as UtoimaOpenApi>::openapi();
+        let log_streams_schema =
+            <handlers::log_streams::LogStreamsApiDoc as UtoimaOpenApi>::openapi();
+        let build_nodes_schema =
+            <handlers::build_nodes::BuildNodesApiDoc as UtoimaOpenApi>::openapi();
+        let container_metrics_schema =
+            <handlers::container_metrics::ContainerMetricsApiDoc as UtoimaOpenApi>::openapi();
+        let resource_alert_schema =
+            <handlers::resource_alerts::ResourceAlertApiDoc as UtoimaOpenApi>::openapi();
 
         Some(temps_core::openapi::merge_openapi_schemas(
             deployments_schema,
-            vec![cron_schema, external_images_schema],
+            vec![
+                cron_schema,
+                external_images_schema,
+                resource_gc_schema,
+                project_lifecycle_schema,
+                artifacts_schema,
+                retention_schema,
+                changelog_schema,
+                promotions_schema,
+                runtime_config_schema,
+                artifact_deploys_schema,
+                build_cache_schema,
+                deploy_gate_schema,
+                build_queue_schema,
+                log_streams_schema,
+                build_nodes_schema,
+                container_metrics_schema,
+                resource_alert_schema,
+            ],
         ))
     }
 }
@@ -0,0 +1,293 @@
+//! Artifact Deployments
+//!
+//! Deploys a build artifact a CI system published at a URL, for pipelines that
+//! neither push to a connected repository nor to a registry. The artifact is either
+//! a tarball of the app's source, built like a checkout of the repository, or an
+//! image archive written by `docker save`, which runs as it is. The downloaded file
+//! can be verified against a SHA-256, and an `Authorization` header can be sent with
+//! the download; the header is stored encrypted.
+
+use std::collections::HashMap;
+use std::sync::Arc;
+
+use chrono::Utc;
+use sea_orm::{ActiveModelTrait, ColumnTrait, EntityTrait, PaginatorTrait, QueryFilter, Set};
+use temps_core::{EncryptionService, Job, JobQueue};
+use temps_database::DbConnection;
+use temps_entities::deployment_config::DeploymentConfigSnapshot;
+use temps_entities::deployments::{self, DeploymentMetadata, RemoteArtifact, RemoteArtifactKind};
+use temps_entities::{environments, projects};
+use tracing::{error, info};
+
+use super::{DeploymentError, WorkflowExecutionService, WorkflowPlanner};
+
+/// Trigger recorded in the context of deployments of an artifact
+pub const ARTIFACT_TRIGGER: &str = "artifact";
+
+/// An artifact to deploy
+#[derive(Debug, Clone)]
+pub struct ArtifactDeployRequest {
+    pub url: String,
+    pub kind: RemoteArtifactKind,
+    /// Hex SHA-256 the downloaded file must have, optionally prefixed with `sha256:`
+    pub sha256: Option<String>,
+    /// `Authorization` header sent with the download, e.g. `Bearer <token>`
+    pub authorization: Option<String>,
+}
+
+impl ArtifactDeployRequest {
+    pub fn validate(&self) -> Result<(), DeploymentError> {
+        let url = url::Url::parse(&self.url)
+            .map_err(|e| DeploymentError::InvalidInput(format!("Invalid artifact URL: {}", e)))?;
+        if !matches!(url.scheme(), "http" | "https") {
+            return Err(DeploymentError::InvalidInput(
+                "Artifacts can only be downloaded over http or https".to_string(),
+            ));
+        }
+        if let Some(sha256) = &self.sha256 {
+            let digest = sha256.trim();
+            let digest = digest.strip_prefix("sha256:").unwrap_or(digest);
+            if digest.len() != 64 || !digest.chars().all(|c| c.is_ascii_hexdigit()) {
+                return Err(DeploymentError::InvalidInput(
+                    "The checksum must be a SHA-256 of 64 hexadecimal characters".to_string(),
+                ));
+            }
+        }
+        if self
+            .authorization
+            .as_ref()
+            .is_some_and(|authorization| authorization.trim().is_empty())
+        {
+            return Err(DeploymentError::InvalidInput(
+                "The Authorization header can't be empty".to_string(),
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// Creates and runs deployments of build artifacts
+pub struct ArtifactDeployService {
+    db: Arc<DbConnection>,
+    queue: Arc<dyn JobQueue>,
+    encryption_service: Arc<EncryptionService>,
+    workflow_planner: Arc<WorkflowPlanner>,
+    workflow_executor: Arc<WorkflowExecutionService>,
+}
+
+impl ArtifactDeployService {
+    pub fn new(
+        db: Arc<DbConnection>,
+        queue: Arc<dyn JobQueue>,
+        encryption_service: Arc<EncryptionService>,
+        workflow_planner: Arc<WorkflowPlanner>,
+        workflow_executor: Arc<WorkflowExecutionService>,
+    ) -> Self {
+        Self {
+            db,
+            queue,
+            encryption_service,
+            workflow_planner,
+            workflow_executor,
+        }
+    }
+
+    /// Deploy an artifact to an environment
+    ///
+    /// Returns the deployment once it is planned; its workflow runs in the background.
+    pub async fn deploy(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        request: ArtifactDeployRequest,
+        triggered_by: Option<i32>,
+    ) -> Result<deployments::Model, DeploymentError> {
+        request.validate()?;
+
+        let project = projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Project not found".to_string()))?;
+        let environment = environments::Entity::find_by_id(environment_id)
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .filter(environments::Column::DeletedAt.is_null())
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Environment not found".to_string()))?;
+
+        let encrypted_authorization = request
+            .authorization
+            .as_deref()
+            .map(|authorization| self.encryption_service.encrypt_string(authorization.trim()))
+            .transpose()
+            .map_err(|e| {
+                DeploymentError::Other(format!("Failed to encrypt the Authorization header: {}", e))
+            })?;
+
+        let deployment_number = deployments::Entity::find()
+            .filter(deployments::Column::ProjectId.eq(project.id))
+            .count(self.db.as_ref())
+            .await?
+            + 1;
+
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let deployment_config_snapshot = DeploymentConfigSnapshot::from_config(
+            &environment.get_effective_deployment_config(&project_config),
+            HashMap::new(),
+        );
+
+        let metadata = DeploymentMetadata {
+            remote_artifact: Some(RemoteArtifact {
+                url: request.url.clone(),
+                kind: request.kind,
+                sha256: request.sha256.clone(),
+                encrypted_authorization,
+            }),
+            triggered_by_user_id: triggered_by,
+            ..Default::default()
+        };
+
+        let now = Utc::now();
+        let deployment = deployments::ActiveModel {
+            project_id: Set(project.id),
+            environment_id: Set(environment.id),
+            slug: Set(format!("{}-{}", project.slug, deployment_number)),
+            state: Set("pending".to_string()),
+            metadata: Set(Some(metadata)),
+            context_vars: Set(Some(serde_json::json!({
+                "trigger": ARTIFACT_TRIGGER,
+                "source": "api",
+                "artifact_url": request.url
+            }))),
+            deployment_config: Set(Some(deployment_config_snapshot)),
+            created_at: Set(now),
+            updated_at: Set(now),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        info!(
+            "Deploying artifact {} to environment {} as deployment {}",
+            request.url, environment.name, deployment.id
+        );
+
+        let created_event = Job::DeploymentCreated(temps_core::DeploymentCreatedJob {
+            deployment_id: deployment.id,
+            project_id: project.id,
+            environment_id: environment.id,
+            environment_name: environment.name.clone(),
+            branch: None,
+            commit_sha: None,
+        });
+        if let Err(e) = self.queue.send(created_event).await {
+            error!("Failed to send DeploymentCreated event: {}", e);
+        }
+
+        if let Err(e) = self
+            .workflow_planner
+            .create_deployment_jobs(deployment.id)
+            .await
+        {
+            let reason = format!("Failed to plan artifact deployment: {}", e);
+            self.set_state(deployment.id, "failed", Some(reason.clone()))
+                .await;
+            return Err(DeploymentError::PipelineError(reason));
+        }
+
+        self.set_state(deployment.id, "running", None).await;
+        let workflow_executor = self.workflow_executor.clone();
+        let db = self.db.clone();
+        let deployment_id = deployment.id;
+        tokio::spawn(async move {
+            if let Err(e) = workflow_executor
+                .execute_deployment_workflow(deployment_id)
+                .await
+            {
+                error!(
+                    "Workflow execution failed for artifact deployment {}: {}",
+                    deployment_id, e
+                );
+                let result = deployments::ActiveModel {
+                    id: Set(deployment_id),
+                    state: Set("failed".to_string()),
+                    cancelled_reason: Set(Some(e.to_string())),
+                    finished_at: Set(Some(Utc::now())),
+                    updated_at: Set(Utc::now()),
+                    ..Default::default()
+                }
+                .update(db.as_ref())
+                .await;
+                if let Err(db_error) = result {
+                    error!("Failed to update deployment status: {}", db_error);
+                }
+            }
+        });
+
+        Ok(deployment)
+    }
+
+    async fn set_state(&self, deployment_id: i32, state: &str, reason: Option<String>) {
+        let now = Utc::now();
+        let mut active = deployments::ActiveModel {
+            id: Set(deployment_id),
+            state: Set(state.to_string()),
+            updated_at: Set(now),
+            ..Default::default()
+        };
+        if state == "running" {
+            active.started_at = Set(Some(now));
+        } else {
+            active.cancelled_reason = Set(reason);
+            active.finished_at = Set(Some(now));
+        }
+        if let Err(e) = active.update(self.db.as_ref()).await {
+            error!(
+                "Failed to mark deployment {} as {}: {}",
+                deployment_id, state, e
+            );
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn request(url: &str, sha256: Option<&str>) -> ArtifactDeployRequest {
+        ArtifactDeployRequest {
+            url: url.to_string(),
+            kind: RemoteArtifactKind::BuildContext,
+            sha256: sha256.map(str::to_string),
+            authorization: None,
+        }
+    }
+
+    #[test]
+    fn test_validates_url_and_checksum() {
+        let digest = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08";
+        assert!(request("https://ci.example.com/app.tar.gz", None)
+            .validate()
+            .is_ok());
+        assert!(request(
+            "https://ci.example.com/app.tar.gz",
+            Some(&format!("sha256:{}", digest))
+        )
+        .validate()
+        .is_ok());
+
+        assert!(request("ftp://ci.example.com/app.tar.gz", None)
+            .validate()
+            .is_err());
+        assert!(request("not a url", None).validate().is_err());
+        assert!(request("https://ci.example.com/app.tar.gz", Some("abc123"))
+            .validate()
+            .is_err());
+
+        let empty_authorization = ArtifactDeployRequest {
+            authorization: Some(" ".to_string()),
+            ..request("https://ci.example.com/app.tar.gz", None)
+        };
+        assert!(empty_authorization.validate().is_err());
+    }
+}
@@ -0,0 +1,538 @@
+//! Base Image Updates
+//!
+//! Base images get security fixes pushed under the same tag, but a deployment only
+//! picks them up when it is built again. When `image_updates.auto_rebuild` is on, the
+//! base images recorded for each environment's running deployment are pulled
+//! periodically, and a deployment whose base image now has a newer digest is rebuilt
+//! from the same commit. The rebuild is marked as triggered by a base image update
+//! (`trigger: base_image_update`, with the updated images in its metadata) so it can
+//! be told apart from a deployment of a code change.
+
+use std::collections::HashMap;
+use std::sync::Arc;
+use std::time::Duration;
+
+use chrono::Utc;
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, EntityTrait, PaginatorTrait, QueryFilter, QueryOrder, Set,
+};
+use temps_core::notifications::{
+    NotificationData, NotificationPriority, NotificationService, NotificationType,
+};
+use temps_core::{Job, JobQueue};
+use temps_database::DbConnection;
+use temps_deployer::ImageBuilder;
+use temps_entities::deployment_config::DeploymentConfigSnapshot;
+use temps_entities::deployments::{self, BaseImageDigest, BaseImageUpdate, DeploymentMetadata};
+use temps_entities::{environments, projects};
+use tokio::time::sleep;
+use tracing::{debug, error, info, warn};
+
+use super::{DeploymentError, WorkflowExecutionService, WorkflowPlanner};
+
+/// Deployment states of a deployment that finished building
+const BUILT_STATES: [&str; 2] = ["completed", "deployed"];
+
+/// Deployment states of a deployment still in progress
+const IN_PROGRESS_STATES: [&str; 2] = ["pending", "running"];
+
+/// Trigger recorded in the context of rebuilds for a base image update
+pub const BASE_IMAGE_UPDATE_TRIGGER: &str = "base_image_update";
+
+/// Base images whose current digest differs from the one a build used
+///
+/// Images whose current digest isn't known (e.g. the registry couldn't be reached)
+/// don't count as updated.
+pub fn find_updates(
+    recorded: &[BaseImageDigest],
+    current: &HashMap<String, Option<String>>,
+) -> Vec<BaseImageUpdate> {
+    recorded
+        .iter()
+        .filter_map(|base| {
+            let digest = current.get(&base.image)?.as_ref()?;
+            (*digest != base.digest).then(|| BaseImageUpdate {
+                image: base.image.clone(),
+                previous_digest: base.digest.clone(),
+                digest: digest.clone(),
+            })
+        })
+        .collect()
+}
+
+/// Rebuilds deployments whose base images have been updated
+pub struct BaseImageUpdateService {
+    db: Arc<DbConnection>,
+    config_service: Arc<temps_config::ConfigService>,
+    queue: Arc<dyn JobQueue>,
+    image_builder: Arc<dyn ImageBuilder>,
+    workflow_planner: Arc<WorkflowPlanner>,
+    workflow_executor: Arc<WorkflowExecutionService>,
+    notification_service: Option<Arc<dyn NotificationService>>,
+}
+
+impl BaseImageUpdateService {
+    pub fn new(
+        db: Arc<DbConnection>,
+        config_service: Arc<temps_config::ConfigService>,
+        queue: Arc<dyn JobQueue>,
+        image_builder: Arc<dyn ImageBuilder>,
+        workflow_planner: Arc<WorkflowPlanner>,
+        workflow_executor: Arc<WorkflowExecutionService>,
+    ) -> Self {
+        Self {
+            db,
+            config_service,
+            queue,
+            image_builder,
+            workflow_planner,
+            workflow_executor,
+            notification_service: None,
+        }
+    }
+
+    /// Also announce rebuilds through the notification providers
+    pub fn with_notification_service(
+        mut self,
+        notification_service: Arc<dyn NotificationService>,
+    ) -> Self {
+        self.notification_service = Some(notification_service);
+        self
+    }
+
+    /// Check the running deployments of all environments and rebuild those with
+    /// updated base images
+    ///
+    /// Returns the IDs of the rebuild deployments that were started.
+    pub async fn check_for_updates(&self) -> Result<Vec<i32>, DeploymentError> {
+        let environments = environments::Entity::find()
+            .filter(environments::Column::DeletedAt.is_null())
+            .filter(environments::Column::CurrentDeploymentId.is_not_null())
+            .all(self.db.as_ref())
+            .await?;
+
+        // Each base image is pulled once per check, however many deployments use it
+        let mut digests: HashMap<String, Option<String>> = HashMap::new();
+        let mut rebuilds = Vec::new();
+
+        for environment in environments {
+            let Some(deployment) = self.rebuild_candidate(&environment).await? else {
+                continue;
+            };
+            let recorded = deployment
+                .metadata
+                .as_ref()
+                .map(|metadata| metadata.base_images.clone())
+                .unwrap_or_default();
+
+            for base in &recorded {
+                if !digests.contains_key(&base.image) {
+                    let digest = self.current_digest(&base.image).await;
+                    digests.insert(base.image.clone(), digest);
+                }
+            }
+
+            let updates = find_updates(&recorded, &digests);
+            if updates.is_empty() {
+                debug!(
+                    "Base images of deployment {} ({}) are up to date",
+                    deployment.id, environment.name
+                );
+                continue;
+            }
+            if self.rebuild_failed(environment.id, &updates).await? {
+                warn!(
+                    "Not rebuilding deployment {} ({}) again, the last rebuild for the same \
+                    base image updates failed",
+                    deployment.id, environment.name
+                );
+                continue;
+            }
+
+            match self.rebuild(&environment, &deployment, updates).await {
+                Ok(rebuild_id) => rebuilds.push(rebuild_id),
+                Err(e) => error!(
+                    "Failed to rebuild deployment {} for base image updates: {}",
+                    deployment.id, e
+                ),
+            }
+        }
+
+        Ok(rebuilds)
+    }
+
+    /// The environment's running deployment, if it can be rebuilt for base image updates
+    ///
+    /// Deployments without recorded base images (static sites, external images) and
+    /// promoted deployments are skipped; a promoted one is updated by rebuilding in
+    /// its source environment and promoting again. Environments with a deployment in
+    /// progress are left alone, since that deployment picks up new base images anyway.
+    async fn rebuild_candidate(
+        &self,
+        environment: &environments::Model,
+    ) -> Result<Option<deployments::Model>, DeploymentError> {
+        let Some(deployment_id) = environment.current_deployment_id else {
+            return Ok(None);
+        };
+        let Some(deployment) = deployments::Entity::find_by_id(deployment_id)
+            .one(self.db.as_ref())
+            .await?
+        else {
+            return Ok(None);
+        };
+
+        if !BUILT_STATES.contains(&deployment.state.as_str()) {
+            return Ok(None);
+        }
+        let rebuildable = deployment
+            .metadata
+            .as_ref()
+            .is_some_and(|metadata| !metadata.base_images.is_empty() && !metadata.reuses_image());
+        if !rebuildable {
+            return Ok(None);
+        }
+
+        let in_progress = deployments::Entity::find()
+            .filter(deployments::Column::EnvironmentId.eq(environment.id))
+            .filter(deployments::Column::State.is_in(IN_PROGRESS_STATES))
+            .count(self.db.as_ref())
+            .await?;
+        if in_progress > 0 {
+            debug!(
+                "Environment {} has a deployment in progress, not checking base images",
+                environment.name
+            );
+            return Ok(None);
+        }
+
+        Ok(Some(deployment))
+    }
+
+    /// Whether the environment's latest deployment is a failed rebuild for the same
+    /// updates, which would most likely fail again
+    async fn rebuild_failed(
+        &self,
+        environment_id: i32,
+        updates: &[BaseImageUpdate],
+    ) -> Result<bool, DeploymentError> {
+        let latest = deployments::Entity::find()
+            .filter(deployments::Column::EnvironmentId.eq(environment_id))
+            .order_by_desc(deployments::Column::Id)
+            .one(self.db.as_ref())
+            .await?;
+        Ok(latest.is_some_and(|latest| {
+            latest.state == "failed"
+                && latest
+                    .metadata
+                    .is_some_and(|metadata| metadata.base_image_updates == updates)
+        }))
+    }
+
+    /// Digest of the image currently published under its tag
+    async fn current_digest(&self, image: &str) -> Option<String> {
+        match self.image_builder.image_digest(image, true).await {
+            Ok(digest) => digest,
+            Err(e) => {
+                warn!("Failed to check base image {} for updates: {}", image, e);
+                None
+            }
+        }
+    }
+
+    /// Build the deployment's commit again and deploy it
+    async fn rebuild(
+        &self,
+        environment: &environments::Model,
+        source: &deployments::Model,
+        updates: Vec<BaseImageUpdate>,
+    ) -> Result<i32, DeploymentError> {
+        let project = projects::Entity::find_by_id(source.project_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Project not found".to_string()))?;
+
+        let deployment_number = deployments::Entity::find()
+            .filter(deployments::Column::ProjectId.eq(project.id))
+            .count(self.db.as_ref())
+            .await?
+            + 1;
+        let slug = if environment.is_preview {
+            let branch = source
+                .branch_ref
+                .as_deref()
+                .unwrap_or("unknown")
+                .replace(['/', '_', '.'], "-")
+                .to_lowercase();
+            format!("{}-{}-{}", project.slug, branch, deployment_number)
+        } else {
+            format!("{}-{}", project.slug, deployment_number)
+        };
+
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let deployment_config_snapshot = DeploymentConfigSnapshot::from_config(
+            &environment.get_effective_deployment_config(&project_config),
+            HashMap::new(),
+        );
+
+        // The git information carries over; build results come from the new build
+        let source_metadata = source.metadata.clone().unwrap_or_default();
+        let metadata = DeploymentMetadata {
+            git_push_event: source_metadata.git_push_event,
+            labels: source_metadata.labels,
+            base_image_updates: updates.clone(),
+            ..Default::default()
+        };
+
+        let now = Utc::now();
+        let deployment = deployments::ActiveModel {
+            project_id: Set(project.id),
+            environment_id: Set(environment.id),
+            slug: Set(slug),
+            state: Set("pending".to_string()),
+            metadata: Set(Some(metadata)),
+            branch_ref: Set(source.branch_ref.clone()),
+            tag_ref: Set(source.tag_ref.clone()),
+            commit_sha: Set(source.commit_sha.clone()),
+            commit_message: Set(source.commit_message.clone()),
+            commit_author: Set(source.commit_author.clone()),
+            commit_json: Set(source.commit_json.clone()),
+            context_vars: Set(Some(serde_json::json!({
+                "trigger": BASE_IMAGE_UPDATE_TRIGGER,
+                "source": "scheduler",
+                "rebuilt_from": source.id
+            }))),
+            deployment_config: Set(Some(deployment_config_snapshot)),
+            created_at: Set(now),
+            updated_at: Set(now),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        info!(
+            "Rebuilding deployment {} of {} as deployment {} for updated base images: {}",
+            source.id,
+            environment.name,
+            deployment.id,
+            updates
+                .iter()
+                .map(|update| update.image.as_str())
+                .collect::<Vec<_>>()
+                .join(", ")
+        );
+
+        let created_event = Job::DeploymentCreated(temps_core::DeploymentCreatedJob {
+            deployment_id: deployment.id,
+            project_id: project.id,
+            environment_id: environment.id,
+            environment_name: environment.name.clone(),
+            branch: deployment.branch_ref.clone(),
+            commit_sha: deployment.commit_sha.clone(),
+        });
+        if let Err(e) = self.queue.send(created_event).await {
+            error!("Failed to send DeploymentCreated event: {}", e);
+        }
+        self.notify(&project, environment, &deployment, &updates)
+            .await;
+
+        if let Err(e) = self
+            .workflow_planner
+            .create_deployment_jobs(deployment.id)
+            .await
+        {
+            let reason = format!("Failed to plan base image rebuild: {}", e);
+            self.set_state(deployment.id, "failed", Some(reason.clone()))
+                .await;
+            return Err(DeploymentError::PipelineError(reason));
+        }
+
+        self.set_state(deployment.id, "running", None).await;
+        let workflow_executor = self.workflow_executor.clone();
+        let db = self.db.clone();
+        let deployment_id = deployment.id;
+        tokio::spawn(async move {
+            if let Err(e) = workflow_executor
+                .execute_deployment_workflow(deployment_id)
+                .await
+            {
+                error!(
+                    "Workflow execution failed for base image rebuild {}: {}",
+                    deployment_id, e
+                );
+                let result = deployments::ActiveModel {
+                    id: Set(deployment_id),
+                    state: Set("failed".to_string()),
+                    cancelled_reason: Set(Some(e.to_string())),
+                    finished_at: Set(Some(Utc::now())),
+                    updated_at: Set(Utc::now()),
+                    ..Default::default()
+                }
+                .update(db.as_ref())
+                .await;
+                if let Err(db_error) = result {
+                    error!("Failed to update deployment status: {}", db_error);
+                }
+            }
+        });
+
+        Ok(deployment.id)
+    }
+
+    async fn set_state(&self, deployment_id: i32, state: &str, reason: Option<String>) {
+        let now = Utc::now();
+        let mut active = deployments::ActiveModel {
+            id: Set(deployment_id),
+            state: Set(state.to_string()),
+            updated_at: Set(now),
+            ..Default::default()
+        };
+        if state == "running" {
+            active.started_at = Set(Some(now));
+        } else {
+            active.cancelled_reason = Set(reason);
+            active.finished_at = Set(Some(now));
+        }
+        if let Err(e) = active.update(self.db.as_ref()).await {
+            error!(
+                "Failed to mark deployment {} as {}: {}",
+                deployment_id, state, e
+            );
+        }
+    }
+
+    async fn notify(
+        &self,
+        project: &projects::Model,
+        environment: &environments::Model,
+        deployment: &deployments::Model,
+        updates: &[BaseImageUpdate],
+    ) {
+        let Some(notification_service) = &self.notification_service else {
+            return;
+        };
+
+        let images = updates
+            .iter()
+            .map(|update| update.image.as_str())
+            .collect::<Vec<_>>()
+            .join(", ");
+        let notification = NotificationData {
+            id: temps_core::uuid::Uuid::new_v4().to_string(),
+            title: format!("Rebuilding {} for base image updates", environment.name),
+            message: format!(
+                "New versions of {} were published, so {} ({}) is being rebuilt from the \
+                same commit as deployment {}. The code is unchanged.",
+                images, project.name, environment.name, deployment.slug
+            ),
+            notification_type: NotificationType::Info,
+            priority: NotificationPriority::Low,
+            severity: None,
+            timestamp: Utc::now(),
+            metadata: [
+                ("deployment_id".to_string(), deployment.id.to_string()),
+                ("project_id".to_string(), project.id.to_string()),
+                ("environment_id".to_string(), environment.id.to_string()),
+                ("environment".to_string(), environment.name.clone()),
+                ("trigger".to_string(), BASE_IMAGE_UPDATE_TRIGGER.to_string()),
+                ("base_images".to_string(), images),
+            ]
+            .into_iter()
+            .collect(),
+            bypass_throttling: false,
+        };
+        if let Err(e) = notification_service.send_notification(notification).await {
+            error!(
+                "Failed to send base image rebuild notification for deployment {}: {}",
+                deployment.id, e
+            );
+        }
+    }
+
+    /// Run the check periodically while `image_updates.auto_rebuild` is on
+    pub async fn start_scheduler(&self) {
+        info!("Base image update scheduler started");
+
+        loop {
+            let settings = match self.config_service.get_settings().await {
+                Ok(settings) => settings.image_updates,
+                Err(e) => {
+                    warn!("Failed to load image update settings: {}", e);
+                    temps_core::ImageUpdateSettings::default()
+                }
+            };
+
+            let interval_hours = u64::from(settings.check_interval_hours.max(1));
+            sleep(Duration::from_secs(interval_hours * 3600)).await;
+
+            if !settings.auto_rebuild {
+                debug!("Base image rebuilds disabled, skipping check");
+                continue;
+            }
+
+            match self.check_for_updates().await {
+                Ok(rebuilds) if rebuilds.is_empty() => {
+                    info!("✅ No deployments with base image updates")
+                }
+                Ok(rebuilds) => info!("Started {} rebuilds for base image updates", rebuilds.len()),
+                Err(e) => error!("❌ Base image update check failed: {}", e),
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn base(image: &str, digest: &str) -> BaseImageDigest {
+        BaseImageDigest {
+            image: image.to_string(),
+            digest: digest.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_find_updates() {
+        let recorded = vec![
+            base("node:20-alpine", "sha256:old"),
+            base("nginx:1.27", "sha256:same"),
+            base("redis:7", "sha256:unknown"),
+        ];
+        let current: HashMap<String, Option<String>> = [
+            ("node:20-alpine".to_string(), Some("sha256:new".to_string())),
+            ("nginx:1.27".to_string(), Some("sha256:same".to_string())),
+            ("redis:7".to_string(), None),
+        ]
+        .into_iter()
+        .collect();
+
+        assert_eq!(
+            find_updates(&recorded, &current),
+            vec![BaseImageUpdate {
+                image: "node:20-alpine".to_string(),
+                previous_digest: "sha256:old".to_string(),
+                digest: "sha256:new".to_string(),
+            }]
+        );
+    }
+
+    #[test]
+    fn test_unchecked_images_are_not_updates() {
+        let recorded = vec![base("node:20-alpine", "sha256:old")];
+        assert!(find_updates(&recorded, &HashMap::new()).is_empty());
+    }
+
+    #[test]
+    fn test_base_image_rebuilds_are_reported() {
+        let rebuild = DeploymentMetadata {
+            base_image_updates: vec![BaseImageUpdate {
+                image: "node:20-alpine".to_string(),
+                previous_digest: "sha256:old".to_string(),
+                digest: "sha256:new".to_string(),
+            }],
+            ..Default::default()
+        };
+        assert!(rebuild.is_base_image_rebuild());
+        assert!(!DeploymentMetadata::default().is_base_image_rebuild());
+    }
+}
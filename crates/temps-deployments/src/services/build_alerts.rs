@@ -0,0 +1,399 @@
+//! Build Alerts
+//!
+//! Tells people when a deployment is stuck waiting for a build slot, or when a
+//! running build is taking much longer than its environment's builds usually do, so
+//! a slow queue can be told apart from a slow build. Each alert is published on the
+//! job queue (and from there to webhooks) and, when configured, sent as a
+//! notification.
+
+use chrono::Utc;
+use sea_orm::{ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, QueryOrder, QuerySelect};
+use std::sync::Arc;
+use std::time::Duration;
+use temps_core::notifications::{
+    NotificationData, NotificationPriority, NotificationService, NotificationType,
+};
+use temps_core::{DeploymentQueueDelayedJob, DeploymentRunningLongJob, Job, JobQueue};
+use temps_entities::deployments;
+use tokio::task::JoinHandle;
+use tracing::{debug, error, info, warn};
+
+use super::{load_build_samples, slowest_phase, BuildPhaseName, DeploymentError};
+
+/// Deployment states of a successful deployment
+const SUCCESSFUL_STATES: &[&str] = &["completed", "deployed"];
+
+/// Recent successful deployments averaged for the typical build time
+const HISTORY_SIZE: usize = 10;
+
+/// Successful deployments needed before a build can be called slow
+pub(crate) const MIN_HISTORY: usize = 3;
+
+/// An environment's recent successful deployments that built their image, latest
+/// first
+///
+/// Rollbacks and promotions are left out since they skip the build and would make
+/// every real build look slow.
+pub async fn recent_builds(
+    db: &DatabaseConnection,
+    environment_id: i32,
+    exclude_deployment_id: i32,
+) -> Result<Vec<deployments::Model>, DeploymentError> {
+    let recent = deployments::Entity::find()
+        .filter(deployments::Column::EnvironmentId.eq(environment_id))
+        .filter(deployments::Column::Id.ne(exclude_deployment_id))
+        .filter(deployments::Column::State.is_in(SUCCESSFUL_STATES.iter().copied()))
+        .filter(deployments::Column::StartedAt.is_not_null())
+        .filter(deployments::Column::FinishedAt.is_not_null())
+        .order_by_desc(deployments::Column::Id)
+        .limit((HISTORY_SIZE * 2) as u64)
+        .all(db)
+        .await?;
+
+    Ok(recent
+        .into_iter()
+        .filter(|deployment| {
+            deployment
+                .metadata
+                .as_ref()
+                .is_none_or(|metadata| !metadata.is_rollback && !metadata.reuses_image())
+        })
+        .take(HISTORY_SIZE)
+        .collect())
+}
+
+/// Typical duration of a build: the average of recent durations, None while there
+/// are too few to judge by
+pub fn typical_duration(durations: &[Duration]) -> Option<Duration> {
+    if durations.len() < MIN_HISTORY {
+        return None;
+    }
+    let total: Duration = durations.iter().sum();
+    Some(total / durations.len() as u32)
+}
+
+/// Running time after which a build with this typical duration counts as slow
+pub fn slow_build_threshold(typical: Duration, percent: u32) -> Duration {
+    typical * percent.max(100) / 100
+}
+
+/// Publishes queue wait and slow build alerts
+pub struct BuildAlertService {
+    db: Arc<DatabaseConnection>,
+    queue: Arc<dyn JobQueue>,
+    notification_service: Option<Arc<dyn NotificationService>>,
+}
+
+impl BuildAlertService {
+    pub fn new(db: Arc<DatabaseConnection>, queue: Arc<dyn JobQueue>) -> Self {
+        Self {
+            db,
+            queue,
+            notification_service: None,
+        }
+    }
+
+    /// Also send alerts through the notification providers
+    pub fn with_notification_service(
+        mut self,
+        notification_service: Arc<dyn NotificationService>,
+    ) -> Self {
+        self.notification_service = Some(notification_service);
+        self
+    }
+
+    /// Typical build time of an environment, from its recent successful deployments
+    pub async fn typical_build_duration(
+        &self,
+        environment_id: i32,
+        exclude_deployment_id: i32,
+    ) -> Result<Option<Duration>, DeploymentError> {
+        let durations: Vec<Duration> =
+            recent_builds(self.db.as_ref(), environment_id, exclude_deployment_id)
+                .await?
+                .iter()
+                .filter_map(|deployment| {
+                    let elapsed = deployment.finished_at? - deployment.started_at?;
+                    elapsed.to_std().ok()
+                })
+                .collect();
+        Ok(typical_duration(&durations))
+    }
+
+    /// Phase an environment's recent builds spent the most time in, on average
+    pub async fn typical_slowest_phase(
+        &self,
+        environment_id: i32,
+        exclude_deployment_id: i32,
+    ) -> Result<Option<(BuildPhaseName, u64)>, DeploymentError> {
+        let recent = recent_builds(self.db.as_ref(), environment_id, exclude_deployment_id).await?;
+        let samples = load_build_samples(self.db.as_ref(), &recent).await?;
+        Ok(slowest_phase(&samples))
+    }
+
+    /// Report a deployment that has waited for a build slot longer than the threshold
+    pub async fn queue_delayed(
+        &self,
+        deployment: &deployments::Model,
+        environment_name: &str,
+        queue_position: usize,
+        waiting: Duration,
+    ) {
+        info!(
+            "Deployment {} has waited {}s for a build slot (position {} in queue)",
+            deployment.id,
+            waiting.as_secs(),
+            queue_position
+        );
+
+        let job = DeploymentQueueDelayedJob {
+            deployment_id: deployment.id,
+            project_id: deployment.project_id,
+            environment_id: deployment.environment_id,
+            environment_name: environment_name.to_string(),
+            queue_position: queue_position as u32,
+            waiting_seconds: waiting.as_secs(),
+        };
+        if let Err(e) = self.queue.send(Job::DeploymentQueueDelayed(job)).await {
+            error!("Failed to send DeploymentQueueDelayed event: {}", e);
+        }
+
+        self.notify(
+            format!("Deployment queued: {}", environment_name),
+            format!(
+                "Deployment {} to {} has been waiting {} for a build slot and is number \
+                {} in the queue. It starts once running builds finish; raise the build \
+                concurrency limit in Settings to run more builds at once.",
+                deployment.slug,
+                environment_name,
+                format_duration(waiting),
+                queue_position
+            ),
+            deployment,
+            environment_name,
+            [
+                ("queue_position".to_string(), queue_position.to_string()),
+                ("waiting_seconds".to_string(), waiting.as_secs().to_string()),
+            ],
+        )
+        .await;
+    }
+
+    /// Report a build that has been running longer than its environment usually takes
+    pub async fn running_long(
+        &self,
+        deployment: &deployments::Model,
+        environment_name: &str,
+        running: Duration,
+        typical: Duration,
+        slowest_phase: Option<(BuildPhaseName, u64)>,
+    ) {
+        info!(
+            "Deployment {} has been running {}s, typically {}s",
+            deployment.id,
+            running.as_secs(),
+            typical.as_secs()
+        );
+
+        let job = DeploymentRunningLongJob {
+            deployment_id: deployment.id,
+            project_id: deployment.project_id,
+            environment_id: deployment.environment_id,
+            environment_name: environment_name.to_string(),
+            running_seconds: running.as_secs(),
+            typical_seconds: typical.as_secs(),
+        };
+        if let Err(e) = self.queue.send(Job::DeploymentRunningLong(job)).await {
+            error!("Failed to send DeploymentRunningLong event: {}", e);
+        }
+
+        // Where builds usually spend their time is where to look first
+        let usual_phase = slowest_phase
+            .map(|(phase, ms)| {
+                format!(
+                    ", most of it in {} ({})",
+                    phase.label(),
+                    format_duration(Duration::from_millis(ms))
+                )
+            })
+            .unwrap_or_default();
+        self.notify(
+            format!("Deployment taking longer than usual: {}", environment_name),
+            format!(
+                "Deployment {} to {} has been running for {}. Deployments of this \
+                environment usually take {}{}. Check its logs to see whether a step is stuck.",
+                deployment.slug,
+                environment_name,
+                format_duration(running),
+                format_duration(typical),
+                usual_phase
+            ),
+            deployment,
+            environment_name,
+            [
+                ("running_seconds".to_string(), running.as_secs().to_string()),
+                ("typical_seconds".to_string(), typical.as_secs().to_string()),
+            ],
+        )
+        .await;
+    }
+
+    /// Report the deployment once it runs past the slow build threshold; the watch stops
+    /// when the returned handle is dropped
+    pub fn watch_build(
+        self: &Arc<Self>,
+        deployment: deployments::Model,
+        environment_name: String,
+        alert_percent: u32,
+    ) -> SlowBuildWatch {
+        let alerts = self.clone();
+        let handle = tokio::spawn(async move {
+            let typical = match alerts
+                .typical_build_duration(deployment.environment_id, deployment.id)
+                .await
+            {
+                Ok(Some(typical)) => typical,
+                Ok(None) => {
+                    debug!(
+                        "Not enough history to judge build time of deployment {}",
+                        deployment.id
+                    );
+                    return;
+                }
+                Err(e) => {
+                    warn!(
+                        "Failed to load build history for deployment {}: {}",
+                        deployment.id, e
+                    );
+                    return;
+                }
+            };
+
+            let slowest_phase = alerts
+                .typical_slowest_phase(deployment.environment_id, deployment.id)
+                .await
+                .unwrap_or_else(|e| {
+                    warn!(
+                        "Failed to load build phases for deployment {}: {}",
+                        deployment.id, e
+                    );
+                    None
+                });
+
+            let threshold = slow_build_threshold(typical, alert_percent);
+            tokio::time::sleep(threshold).await;
+            alerts
+                .running_long(
+                    &deployment,
+                    &environment_name,
+                    threshold,
+                    typical,
+                    slowest_phase,
+                )
+                .await;
+        });
+        SlowBuildWatch { handle }
+    }
+
+    async fn notify(
+        &self,
+        title: String,
+        message: String,
+        deployment: &deployments::Model,
+        environment_name: &str,
+        details: [(String, String); 2],
+    ) {
+        let Some(notification_service) = &self.notification_service else {
+            return;
+        };
+
+        let mut metadata: std::collections::HashMap<String, String> = [
+            ("deployment_id".to_string(), deployment.id.to_string()),
+            ("project_id".to_string(), deployment.project_id.to_string()),
+            (
+                "environment_id".to_string(),
+                deployment.environment_id.to_string(),
+            ),
+            ("environment".to_string(), environment_name.to_string()),
+        ]
+        .into_iter()
+        .collect();
+        metadata.extend(details);
+
+        let notification = NotificationData {
+            id: temps_core::uuid::Uuid::new_v4().to_string(),
+            title,
+            message,
+            notification_type: NotificationType::Warning,
+            priority: NotificationPriority::Normal,
+            severity: Some("warning".to_string()),
+            timestamp: Utc::now(),
+            metadata,
+            bypass_throttling: false,
+        };
+        if let Err(e) = notification_service.send_notification(notification).await {
+            error!(
+                "Failed to send build alert for deployment {}: {}",
+                deployment.id, e
+            );
+        }
+    }
+}
+
+/// Pending slow build alert, cancelled when dropped
+pub struct SlowBuildWatch {
+    handle: JoinHandle<()>,
+}
+
+impl Drop for SlowBuildWatch {
+    fn drop(&mut self) {
+        self.handle.abort();
+    }
+}
+
+fn format_duration(duration: Duration) -> String {
+    let seconds = duration.as_secs();
+    if seconds < 60 {
+        format!("{}s", seconds)
+    } else if seconds < 3600 {
+        format!("{}m {}s", seconds / 60, seconds % 60)
+    } else {
+        format!("{}h {}m", seconds / 3600, (seconds % 3600) / 60)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn minutes(values: &[u64]) -> Vec<Duration> {
+        values.iter().map(|m| Duration::from_secs(m * 60)).collect()
+    }
+
+    #[test]
+    fn test_typical_duration_needs_history() {
+        assert_eq!(typical_duration(&minutes(&[4, 6])), None);
+        assert_eq!(
+            typical_duration(&minutes(&[4, 6, 5])),
+            Some(Duration::from_secs(5 * 60))
+        );
+    }
+
+    #[test]
+    fn test_slow_build_threshold() {
+        let typical = Duration::from_secs(10 * 60);
+        assert_eq!(
+            slow_build_threshold(typical, 150),
+            Duration::from_secs(15 * 60)
+        );
+        // Never alert before the typical time has passed
+        assert_eq!(slow_build_threshold(typical, 50), typical);
+    }
+
+    #[test]
+    fn test_format_duration() {
+        assert_eq!(format_duration(Duration::from_secs(42)), "42s");
+        assert_eq!(format_duration(Duration::from_secs(330)), "5m 30s");
+        assert_eq!(format_duration(Duration::from_secs(7260)), "2h 1m");
+    }
+}
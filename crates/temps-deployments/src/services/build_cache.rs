@@ -0,0 +1,328 @@
+//! Build Caches
+//!
+//! Reports the size of a project's persistent build caches (the BuildKit cache
+//! mounts set up by `temps_presets::build_cache`) and clears them. BuildKit keeps a
+//! record per cache mount, described as `cached mount <target> ... with id "<id>"`;
+//! records are matched to a project by the project prefix of that ID.
+
+use std::collections::BTreeMap;
+use std::collections::HashMap;
+use std::sync::Arc;
+
+use serde::Serialize;
+use temps_presets::build_cache::cache_id_prefix;
+use tracing::{info, warn};
+use utoipa::ToSchema;
+
+use super::DeploymentError;
+
+/// A BuildKit build cache record
+#[derive(Debug, Clone)]
+pub struct CacheRecord {
+    pub id: String,
+    pub description: String,
+    pub size_bytes: u64,
+    pub in_use: bool,
+}
+
+/// Container runtime operations on the build cache (mockable for testing)
+#[async_trait::async_trait]
+pub trait BuildCacheRuntime: Send + Sync {
+    /// All build cache records of the builder
+    async fn list_cache_records(&self) -> Result<Vec<CacheRecord>, String>;
+
+    /// Remove a build cache record, returning the bytes reclaimed
+    async fn remove_cache_record(&self, id: &str) -> Result<u64, String>;
+}
+
+/// Docker implementation of [`BuildCacheRuntime`]
+pub struct DockerBuildCacheRuntime {
+    docker: Arc<bollard::Docker>,
+}
+
+impl DockerBuildCacheRuntime {
+    pub fn new(docker: Arc<bollard::Docker>) -> Self {
+        Self { docker }
+    }
+}
+
+#[async_trait::async_trait]
+impl BuildCacheRuntime for DockerBuildCacheRuntime {
+    async fn list_cache_records(&self) -> Result<Vec<CacheRecord>, String> {
+        let usage = self
+            .docker
+            .df(None::<bollard::query_parameters::DataUsageOptions>)
+            .await
+            .map_err(|e| format!("Failed to read build cache usage: {}", e))?;
+        Ok(usage
+            .build_cache
+            .unwrap_or_default()
+            .into_iter()
+            .filter_map(|record| {
+                Some(CacheRecord {
+                    id: record.id?,
+                    description: record.description.unwrap_or_default(),
+                    size_bytes: record.size.unwrap_or(0).max(0) as u64,
+                    in_use: record.in_use.unwrap_or(false),
+                })
+            })
+            .collect())
+    }
+
+    async fn remove_cache_record(&self, id: &str) -> Result<u64, String> {
+        let mut filters: HashMap<String, Vec<String>> = HashMap::new();
+        filters.insert("id".to_string(), vec![id.to_string()]);
+        let options = bollard::query_parameters::PruneBuildOptionsBuilder::default()
+            .all(true)
+            .filters(&filters)
+            .build();
+        let result = self
+            .docker
+            .prune_build(Some(options))
+            .await
+            .map_err(|e| format!("Failed to remove build cache {}: {}", id, e))?;
+        Ok(result.space_reclaimed.unwrap_or(0).max(0) as u64)
+    }
+}
+
+/// A persistent build cache of a project
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, ToSchema)]
+pub struct BuildCacheUsage {
+    /// Name of the cache (e.g. "go-mod", "npm")
+    #[schema(example = "go-mod")]
+    pub name: String,
+    /// Disk space used by the cache
+    #[schema(example = 268435456)]
+    pub size_bytes: u64,
+    /// Whether a build is using the cache right now
+    pub in_use: bool,
+}
+
+/// Result of clearing build caches
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, ToSchema)]
+pub struct ClearedBuildCaches {
+    /// Caches that were removed
+    pub cleared: Vec<String>,
+    /// Caches left alone because a build is using them
+    pub skipped_in_use: Vec<String>,
+    pub space_reclaimed_bytes: u64,
+}
+
+/// Name of the project cache a record belongs to, if it is one
+fn project_cache_name(description: &str, prefix: &str) -> Option<String> {
+    let start = description.find(&format!("\"{}", prefix))? + 1 + prefix.len();
+    let rest = &description[start..];
+    let name = &rest[..rest.find('"')?];
+    (!name.is_empty()).then(|| name.to_string())
+}
+
+/// Reports and clears the persistent build caches of projects
+pub struct BuildCacheService {
+    runtime: Arc<dyn BuildCacheRuntime>,
+}
+
+impl BuildCacheService {
+    pub fn new(runtime: Arc<dyn BuildCacheRuntime>) -> Self {
+        Self { runtime }
+    }
+
+    /// The project's cache records, with the name of the cache each belongs to
+    async fn project_records(
+        &self,
+        project_id: i32,
+    ) -> Result<Vec<(String, CacheRecord)>, DeploymentError> {
+        let prefix = cache_id_prefix(project_id);
+        let records = self
+            .runtime
+            .list_cache_records()
+            .await
+            .map_err(DeploymentError::Other)?;
+        Ok(records
+            .into_iter()
+            .filter_map(|record| {
+                project_cache_name(&record.description, &prefix).map(|name| (name, record))
+            })
+            .collect())
+    }
+
+    /// The project's build caches and their sizes, by name
+    pub async fn list_caches(
+        &self,
+        project_id: i32,
+    ) -> Result<Vec<BuildCacheUsage>, DeploymentError> {
+        let mut caches: BTreeMap<String, BuildCacheUsage> = BTreeMap::new();
+        for (name, record) in self.project_records(project_id).await? {
+            let usage = caches
+                .entry(name.clone())
+                .or_insert_with(|| BuildCacheUsage {
+                    name,
+                    size_bytes: 0,
+                    in_use: false,
+                });
+            usage.size_bytes += record.size_bytes;
+            usage.in_use |= record.in_use;
+        }
+        Ok(caches.into_values().collect())
+    }
+
+    /// Clear one of the project's build caches, or all of them when `name` is None
+    ///
+    /// Caches a running build is using are skipped so the build is not broken.
+    pub async fn clear_caches(
+        &self,
+        project_id: i32,
+        name: Option<&str>,
+    ) -> Result<ClearedBuildCaches, DeploymentError> {
+        let records: Vec<(String, CacheRecord)> = self
+            .project_records(project_id)
+            .await?
+            .into_iter()
+            .filter(|(cache, _)| name.is_none_or(|name| cache == name))
+            .collect();
+        if let Some(name) = name {
+            if records.is_empty() {
+                return Err(DeploymentError::NotFound(format!(
+                    "Build cache '{}' not found",
+                    name
+                )));
+            }
+        }
+
+        let mut result = ClearedBuildCaches::default();
+        for (cache, record) in records {
+            if record.in_use {
+                if !result.skipped_in_use.contains(&cache) {
+                    result.skipped_in_use.push(cache);
+                }
+                continue;
+            }
+            match self.runtime.remove_cache_record(&record.id).await {
+                Ok(reclaimed) => {
+                    result.space_reclaimed_bytes += reclaimed;
+                    if !result.cleared.contains(&cache) {
+                        result.cleared.push(cache);
+                    }
+                }
+                Err(e) => warn!(
+                    "Failed to clear build cache of project {}: {}",
+                    project_id, e
+                ),
+            }
+        }
+
+        info!(
+            "Cleared build caches {:?} of project {} ({} bytes reclaimed)",
+            result.cleared, project_id, result.space_reclaimed_bytes
+        );
+        Ok(result)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Mutex;
+
+    struct MockRuntime {
+        records: Vec<CacheRecord>,
+        removed: Mutex<Vec<String>>,
+    }
+
+    #[async_trait::async_trait]
+    impl BuildCacheRuntime for MockRuntime {
+        async fn list_cache_records(&self) -> Result<Vec<CacheRecord>, String> {
+            Ok(self.records.clone())
+        }
+
+        async fn remove_cache_record(&self, id: &str) -> Result<u64, String> {
+            self.removed.lock().unwrap().push(id.to_string());
+            Ok(self
+                .records
+                .iter()
+                .find(|r| r.id == id)
+                .map(|r| r.size_bytes)
+                .unwrap_or(0))
+        }
+    }
+
+    fn record(id: &str, cache_id: &str, size_bytes: u64, in_use: bool) -> CacheRecord {
+        CacheRecord {
+            id: id.to_string(),
+            description: format!(
+                "cached mount /root/.cache/temps from exec /bin/sh -c go build with id \"{}\"",
+                cache_id
+            ),
+            size_bytes,
+            in_use,
+        }
+    }
+
+    fn service() -> (BuildCacheService, Arc<MockRuntime>) {
+        let runtime = Arc::new(MockRuntime {
+            records: vec![
+                record("a", "temps-p1-go-mod", 100, false),
+                record("b", "temps-p1-go-mod", 50, false),
+                record("c", "temps-p1-go-build", 30, true),
+                record("d", "temps-p12-go-mod", 1000, false),
+                CacheRecord {
+                    id: "e".to_string(),
+                    description: "pulled from docker.io/library/golang".to_string(),
+                    size_bytes: 5000,
+                    in_use: false,
+                },
+            ],
+            removed: Mutex::new(Vec::new()),
+        });
+        (BuildCacheService::new(runtime.clone()), runtime)
+    }
+
+    #[test]
+    fn test_project_cache_name() {
+        let description = "cached mount /go from exec go build with id \"temps-p1-go-mod\"";
+        assert_eq!(
+            project_cache_name(description, "temps-p1-"),
+            Some("go-mod".to_string())
+        );
+        assert_eq!(project_cache_name(description, "temps-p2-"), None);
+        assert_eq!(
+            project_cache_name("local source for context", "temps-p1-"),
+            None
+        );
+    }
+
+    #[tokio::test]
+    async fn test_list_caches_sums_records_per_cache() {
+        let (service, _) = service();
+        let caches = service.list_caches(1).await.unwrap();
+        assert_eq!(
+            caches,
+            vec![
+                BuildCacheUsage {
+                    name: "go-build".to_string(),
+                    size_bytes: 30,
+                    in_use: true,
+                },
+                BuildCacheUsage {
+                    name: "go-mod".to_string(),
+                    size_bytes: 150,
+                    in_use: false,
+                },
+            ]
+        );
+    }
+
+    #[tokio::test]
+    async fn test_clear_caches_skips_caches_in_use() {
+        let (service, runtime) = service();
+        let result = service.clear_caches(1, None).await.unwrap();
+        assert_eq!(result.cleared, vec!["go-mod".to_string()]);
+        assert_eq!(result.skipped_in_use, vec!["go-build".to_string()]);
+        assert_eq!(result.space_reclaimed_bytes, 150);
+        assert_eq!(*runtime.removed.lock().unwrap(), vec!["a", "b"]);
+
+        assert!(matches!(
+            service.clear_caches(1, Some("npm")).await,
+            Err(DeploymentError::NotFound(_))
+        ));
+    }
+}
@@ -0,0 +1,334 @@
+//! Build Phase Breakdown
+//!
+//! Where a deployment's build spent its time: fetching the source, then each phase of
+//! the image build. Set next to the averages of the environment's recent builds, it
+//! shows which phase made a build slow and whether the build cache helped.
+
+use std::collections::HashMap;
+
+use sea_orm::{ColumnTrait, DatabaseConnection, DbErr, EntityTrait, QueryFilter};
+use serde::Serialize;
+use temps_entities::deployments::BuildPhaseTimings;
+use temps_entities::{deployment_jobs, deployments};
+use utoipa::ToSchema;
+
+use super::build_alerts::MIN_HISTORY;
+
+/// Job fetching the source of a deployment
+const GIT_FETCH_JOB_ID: &str = "download_repo";
+/// Job building the image of a deployment
+const BUILD_JOB_ID: &str = "build_image";
+
+/// Phase of a deployment's build
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum BuildPhaseName {
+    /// Cloning or fetching the repository
+    GitFetch,
+    ContextUpload,
+    BaseImage,
+    DependencyInstall,
+    Compile,
+    ImageExport,
+    /// Moving the image from a build node
+    Push,
+    /// Steps that run no command, such as `COPY`
+    Other,
+    /// The whole image build
+    Total,
+}
+
+impl BuildPhaseName {
+    pub fn label(&self) -> &'static str {
+        match self {
+            BuildPhaseName::GitFetch => "git fetch",
+            BuildPhaseName::ContextUpload => "context upload",
+            BuildPhaseName::BaseImage => "base images",
+            BuildPhaseName::DependencyInstall => "dependency install",
+            BuildPhaseName::Compile => "compile",
+            BuildPhaseName::ImageExport => "image export",
+            BuildPhaseName::Push => "push",
+            BuildPhaseName::Other => "other steps",
+            BuildPhaseName::Total => "build",
+        }
+    }
+}
+
+/// Timings of one deployment's build
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct BuildSample {
+    /// Time the source fetch job ran
+    pub git_fetch_ms: Option<u64>,
+    /// Time the image build job ran
+    pub build_ms: Option<u64>,
+    pub phases: Option<BuildPhaseTimings>,
+}
+
+impl BuildSample {
+    /// Timings of a deployment from its recorded phases and its jobs
+    pub fn new(deployment: &deployments::Model, jobs: &[deployment_jobs::Model]) -> Self {
+        let job_ms = |job_id: &str| {
+            jobs.iter()
+                .find(|job| job.job_id == job_id)
+                .and_then(|job| Some((job.finished_at? - job.started_at?).num_milliseconds()))
+                .map(|ms| ms.max(0) as u64)
+        };
+        Self {
+            git_fetch_ms: job_ms(GIT_FETCH_JOB_ID),
+            build_ms: job_ms(BUILD_JOB_ID),
+            phases: deployment
+                .metadata
+                .as_ref()
+                .and_then(|metadata| metadata.build_phases.clone()),
+        }
+    }
+
+    /// Time spent in a phase; None when the build didn't record it
+    pub fn phase_ms(&self, phase: BuildPhaseName) -> Option<u64> {
+        let phases = self.phases.as_ref();
+        match phase {
+            BuildPhaseName::GitFetch => self.git_fetch_ms,
+            BuildPhaseName::Total => self.build_ms,
+            BuildPhaseName::ContextUpload => phases.map(|p| p.context_upload_ms),
+            BuildPhaseName::BaseImage => phases.map(|p| p.base_image_ms),
+            BuildPhaseName::DependencyInstall => phases.map(|p| p.dependency_install_ms),
+            BuildPhaseName::Compile => phases.map(|p| p.compile_ms),
+            BuildPhaseName::ImageExport => phases.map(|p| p.image_export_ms),
+            BuildPhaseName::Other => phases.map(|p| p.other_ms),
+            BuildPhaseName::Push => phases.and_then(|p| p.push_ms),
+        }
+    }
+
+    /// Share of the build's steps taken from the cache
+    pub fn cache_hit_percent(&self) -> Option<u32> {
+        self.phases
+            .as_ref()
+            .filter(|phases| phases.steps > 0)
+            .map(|phases| phases.cached_steps * 100 / phases.steps)
+    }
+}
+
+/// Phases in the order a build runs them
+const PHASES: &[BuildPhaseName] = &[
+    BuildPhaseName::GitFetch,
+    BuildPhaseName::ContextUpload,
+    BuildPhaseName::BaseImage,
+    BuildPhaseName::DependencyInstall,
+    BuildPhaseName::Compile,
+    BuildPhaseName::Other,
+    BuildPhaseName::ImageExport,
+    BuildPhaseName::Push,
+    BuildPhaseName::Total,
+];
+
+/// Average of the values recent builds recorded; None while too few builds are known
+fn average(values: impl Iterator<Item = Option<u64>>, builds: usize) -> Option<u64> {
+    if builds < MIN_HISTORY {
+        return None;
+    }
+    let values: Vec<u64> = values.flatten().collect();
+    if values.is_empty() {
+        return None;
+    }
+    Some(values.iter().sum::<u64>() / values.len() as u64)
+}
+
+/// Phase of a build next to the environment's average
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, ToSchema)]
+pub struct BuildPhaseComparison {
+    pub phase: BuildPhaseName,
+    /// Time the build spent in the phase; null when it wasn't recorded
+    pub duration_ms: Option<u64>,
+    /// Average of the environment's recent builds
+    pub average_ms: Option<u64>,
+    /// How much longer (positive) or shorter (negative) than average, in percent
+    pub change_percent: Option<i64>,
+}
+
+/// Where a deployment's build spent its time
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, ToSchema)]
+pub struct BuildBreakdown {
+    pub deployment_id: i32,
+    /// Image build phases; null for deployments that didn't build an image, and for
+    /// builders that don't report their steps
+    pub phases: Option<BuildPhaseTimings>,
+    /// Share of the build's steps taken from the build cache
+    pub cache_hit_percent: Option<u32>,
+    /// Average cache share of the environment's recent builds
+    pub average_cache_hit_percent: Option<u32>,
+    /// Recent successful builds of the environment the averages are taken over; there
+    /// are no averages below 3
+    pub compared_builds: usize,
+    /// Each phase, in the order the build ran them
+    pub comparison: Vec<BuildPhaseComparison>,
+}
+
+impl BuildBreakdown {
+    pub fn new(deployment_id: i32, sample: &BuildSample, history: &[BuildSample]) -> Self {
+        let comparison = PHASES
+            .iter()
+            .map(|&phase| {
+                let duration_ms = sample.phase_ms(phase);
+                let average_ms = average(
+                    history.iter().map(|build| build.phase_ms(phase)),
+                    history.len(),
+                );
+                let change_percent = match (duration_ms, average_ms) {
+                    (Some(duration), Some(average)) if average > 0 => {
+                        Some((duration as i64 - average as i64) * 100 / average as i64)
+                    }
+                    _ => None,
+                };
+                BuildPhaseComparison {
+                    phase,
+                    duration_ms,
+                    average_ms,
+                    change_percent,
+                }
+            })
+            .collect();
+
+        Self {
+            deployment_id,
+            phases: sample.phases.clone(),
+            cache_hit_percent: sample.cache_hit_percent(),
+            average_cache_hit_percent: average(
+                history
+                    .iter()
+                    .map(|build| build.cache_hit_percent().map(u64::from)),
+                history.len(),
+            )
+            .map(|percent| percent as u32),
+            compared_builds: history.len(),
+            comparison,
+        }
+    }
+}
+
+/// Phase recent builds spent the most time in on average, with that average
+///
+/// The source fetch counts; the total doesn't.
+pub fn slowest_phase(history: &[BuildSample]) -> Option<(BuildPhaseName, u64)> {
+    PHASES
+        .iter()
+        .filter(|&&phase| phase != BuildPhaseName::Total)
+        .filter_map(|&phase| {
+            average(
+                history.iter().map(|build| build.phase_ms(phase)),
+                history.len(),
+            )
+            .map(|ms| (phase, ms))
+        })
+        .filter(|(_, ms)| *ms > 0)
+        .max_by_key(|(_, ms)| *ms)
+}
+
+/// Timings of each of the deployments, in the same order
+pub async fn load_build_samples(
+    db: &DatabaseConnection,
+    deployments: &[deployments::Model],
+) -> Result<Vec<BuildSample>, DbErr> {
+    if deployments.is_empty() {
+        return Ok(Vec::new());
+    }
+    let mut jobs: HashMap<i32, Vec<deployment_jobs::Model>> = HashMap::new();
+    for job in deployment_jobs::Entity::find()
+        .filter(
+            deployment_jobs::Column::DeploymentId
+                .is_in(deployments.iter().map(|deployment| deployment.id)),
+        )
+        .filter(deployment_jobs::Column::JobId.is_in([GIT_FETCH_JOB_ID, BUILD_JOB_ID]))
+        .all(db)
+        .await?
+    {
+        jobs.entry(job.deployment_id).or_default().push(job);
+    }
+
+    Ok(deployments
+        .iter()
+        .map(|deployment| {
+            BuildSample::new(
+                deployment,
+                jobs.get(&deployment.id).map(Vec::as_slice).unwrap_or(&[]),
+            )
+        })
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn sample(git_fetch_ms: u64, dependency_install_ms: u64, compile_ms: u64) -> BuildSample {
+        BuildSample {
+            git_fetch_ms: Some(git_fetch_ms),
+            build_ms: Some(dependency_install_ms + compile_ms),
+            phases: Some(BuildPhaseTimings {
+                dependency_install_ms,
+                compile_ms,
+                steps: 10,
+                cached_steps: 5,
+                ..Default::default()
+            }),
+        }
+    }
+
+    fn phase(breakdown: &BuildBreakdown, phase: BuildPhaseName) -> &BuildPhaseComparison {
+        breakdown
+            .comparison
+            .iter()
+            .find(|comparison| comparison.phase == phase)
+            .unwrap()
+    }
+
+    #[test]
+    fn test_breakdown_compares_phases_with_recent_average() {
+        let history = vec![
+            sample(2_000, 30_000, 60_000),
+            sample(2_000, 30_000, 60_000),
+            sample(2_000, 30_000, 60_000),
+        ];
+        let breakdown = BuildBreakdown::new(42, &sample(2_000, 90_000, 60_000), &history);
+
+        let install = phase(&breakdown, BuildPhaseName::DependencyInstall);
+        assert_eq!(install.duration_ms, Some(90_000));
+        assert_eq!(install.average_ms, Some(30_000));
+        assert_eq!(install.change_percent, Some(200));
+        assert_eq!(
+            phase(&breakdown, BuildPhaseName::Compile).change_percent,
+            Some(0)
+        );
+        // Builds on this server have no push
+        let push = phase(&breakdown, BuildPhaseName::Push);
+        assert_eq!((push.duration_ms, push.average_ms), (None, None));
+        assert_eq!(breakdown.cache_hit_percent, Some(50));
+        assert_eq!(breakdown.average_cache_hit_percent, Some(50));
+    }
+
+    #[test]
+    fn test_breakdown_needs_history_for_averages() {
+        let history = vec![sample(2_000, 30_000, 60_000)];
+        let breakdown = BuildBreakdown::new(42, &sample(2_000, 90_000, 60_000), &history);
+
+        assert_eq!(breakdown.compared_builds, 1);
+        assert!(breakdown
+            .comparison
+            .iter()
+            .all(|comparison| comparison.average_ms.is_none()));
+        assert_eq!(breakdown.average_cache_hit_percent, None);
+    }
+
+    #[test]
+    fn test_slowest_phase_ignores_total() {
+        let history = vec![
+            sample(2_000, 30_000, 60_000),
+            sample(4_000, 40_000, 50_000),
+            sample(3_000, 20_000, 70_000),
+        ];
+        assert_eq!(
+            slowest_phase(&history),
+            Some((BuildPhaseName::Compile, 60_000))
+        );
+        assert_eq!(slowest_phase(&history[..2]), None);
+    }
+}
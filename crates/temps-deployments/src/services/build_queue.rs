@@ -0,0 +1,397 @@
+//! Build Queue
+//!
+//! Caps how many deployment pipelines run at the same time across all projects.
+//! A pipeline takes a slot before it starts and gives it back when it finishes;
+//! pipelines that find every slot taken wait for one to free up. The limit is read
+//! from the build queue settings on every attempt, so changes apply without a restart.
+//! Waiting pipelines are served by priority, then in arrival order: a pipeline only
+//! takes a free slot when no waiting pipeline is ahead of it. Running pipelines are
+//! never interrupted for a higher priority one.
+
+use serde::Serialize;
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+use temps_core::BuildQueueSettings;
+use temps_entities::deployment_config::BuildPriority;
+use temps_entities::{deployments, environments};
+use tokio::sync::Notify;
+use tracing::{info, warn};
+use utoipa::ToSchema;
+
+use super::{BASE_IMAGE_UPDATE_TRIGGER, MANUAL_TRIGGER};
+
+/// How often waiting pipelines re-read the limit, in case it was raised or a pipeline
+/// ahead of them stopped waiting
+const LIMIT_RECHECK_INTERVAL: Duration = Duration::from_secs(5);
+
+/// Slot bookkeeping, shared with the slots so they can release themselves on drop
+#[derive(Debug, Default)]
+struct BuildSlots {
+    running: Mutex<usize>,
+    released: Notify,
+}
+
+impl BuildSlots {
+    fn try_acquire(self: &Arc<Self>, limit: usize) -> Option<BuildSlot> {
+        let mut running = self.running.lock().unwrap();
+        if *running >= limit {
+            return None;
+        }
+        *running += 1;
+        Some(BuildSlot {
+            slots: self.clone(),
+        })
+    }
+
+    fn release(&self) {
+        {
+            let mut running = self.running.lock().unwrap();
+            *running = running.saturating_sub(1);
+        }
+        self.released.notify_waiters();
+    }
+}
+
+/// A held build slot; the slot is released when this is dropped
+#[derive(Debug)]
+pub struct BuildSlot {
+    slots: Arc<BuildSlots>,
+}
+
+impl Drop for BuildSlot {
+    fn drop(&mut self) {
+        self.slots.release();
+    }
+}
+
+#[derive(Debug, Clone)]
+struct Waiter {
+    deployment_id: i32,
+    priority: BuildPriority,
+    since: Instant,
+}
+
+/// Deployments waiting for a slot, highest priority first and in arrival order within
+/// a priority
+#[derive(Debug, Default)]
+struct WaitingDeployments {
+    waiters: Mutex<Vec<Waiter>>,
+}
+
+impl WaitingDeployments {
+    fn join(self: &Arc<Self>, deployment_id: i32, priority: BuildPriority) -> WaitingEntry {
+        let mut waiters = self.waiters.lock().unwrap();
+        let index = waiters
+            .iter()
+            .position(|waiter| waiter.priority < priority)
+            .unwrap_or(waiters.len());
+        waiters.insert(
+            index,
+            Waiter {
+                deployment_id,
+                priority,
+                since: Instant::now(),
+            },
+        );
+        WaitingEntry {
+            waiting: self.clone(),
+            deployment_id,
+        }
+    }
+
+    /// Waiting deployments served before this one
+    fn ahead_of(&self, deployment_id: i32) -> usize {
+        self.waiters
+            .lock()
+            .unwrap()
+            .iter()
+            .position(|waiter| waiter.deployment_id == deployment_id)
+            .unwrap_or(0)
+    }
+
+    fn position(&self, deployment_id: i32) -> Option<usize> {
+        self.waiters
+            .lock()
+            .unwrap()
+            .iter()
+            .position(|waiter| waiter.deployment_id == deployment_id)
+            .map(|index| index + 1)
+    }
+
+    fn snapshot(&self) -> Vec<Waiter> {
+        self.waiters.lock().unwrap().clone()
+    }
+}
+
+/// Place in the waiting list; leaves the list when dropped, also if the wait is abandoned
+struct WaitingEntry {
+    waiting: Arc<WaitingDeployments>,
+    deployment_id: i32,
+}
+
+impl Drop for WaitingEntry {
+    fn drop(&mut self) {
+        let mut waiters = self.waiting.waiters.lock().unwrap();
+        if let Some(index) = waiters
+            .iter()
+            .position(|waiter| waiter.deployment_id == self.deployment_id)
+        {
+            waiters.remove(index);
+        }
+    }
+}
+
+/// Priority of a deployment in the build queue
+///
+/// Starts from its environment's priority; deploys a person started go one level up
+/// and rebuilds the scheduler started one level down.
+pub fn effective_build_priority(
+    environment: &environments::Model,
+    deployment: &deployments::Model,
+) -> BuildPriority {
+    let trigger = deployment
+        .context_vars
+        .as_ref()
+        .and_then(|vars| vars.get("trigger"))
+        .and_then(|trigger| trigger.as_str());
+    priority_for_trigger(environment.build_priority(), trigger)
+}
+
+fn priority_for_trigger(priority: BuildPriority, trigger: Option<&str>) -> BuildPriority {
+    match trigger {
+        Some(MANUAL_TRIGGER) => priority.raised(),
+        Some(BASE_IMAGE_UPDATE_TRIGGER) => priority.lowered(),
+        _ => priority,
+    }
+}
+
+/// Deployment waiting for a build slot
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct QueuedBuild {
+    pub deployment_id: i32,
+    /// Effective priority, after adjusting the environment's for how the deploy started
+    pub priority: BuildPriority,
+    /// Place in the queue, starting at 1
+    pub position: usize,
+    pub waiting_seconds: u64,
+}
+
+/// Running and waiting deployment pipelines
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct BuildQueueStatus {
+    pub max_concurrent_builds: usize,
+    pub running: usize,
+    /// In the order they will start
+    pub waiting: Vec<QueuedBuild>,
+}
+
+/// Global limit on concurrently running deployment pipelines
+pub struct BuildQueue {
+    config_service: Arc<temps_config::ConfigService>,
+    slots: Arc<BuildSlots>,
+    waiting: Arc<WaitingDeployments>,
+}
+
+impl BuildQueue {
+    pub fn new(config_service: Arc<temps_config::ConfigService>) -> Self {
+        Self {
+            config_service,
+            slots: Arc::new(BuildSlots::default()),
+            waiting: Arc::new(WaitingDeployments::default()),
+        }
+    }
+
+    async fn settings(&self) -> BuildQueueSettings {
+        match self.config_service.get_settings().await {
+            Ok(settings) => settings.build_queue,
+            Err(e) => {
+                warn!("Failed to load build queue settings, using defaults: {}", e);
+                BuildQueueSettings::default()
+            }
+        }
+    }
+
+    /// Maximum pipelines that may run at once (at least 1)
+    pub async fn max_concurrent_builds(&self) -> usize {
+        (self.settings().await.max_concurrent_builds as usize).max(1)
+    }
+
+    /// Environments deployed at once during a project-wide deploy when the project
+    /// does not set its own limit (at least 1)
+    pub async fn default_project_deploy_concurrency(&self) -> usize {
+        (self.settings().await.default_project_deploy_concurrency as usize).max(1)
+    }
+
+    /// How long a deployment may wait for a slot before people are notified
+    pub async fn queue_wait_alert(&self) -> Option<Duration> {
+        match self.settings().await.queue_wait_alert_seconds {
+            0 => None,
+            seconds => Some(Duration::from_secs(seconds as u64)),
+        }
+    }
+
+    /// Percentage of the usual build time after which a running build is reported
+    pub async fn slow_build_alert_percent(&self) -> Option<u32> {
+        match self.settings().await.slow_build_alert_percent {
+            0 => None,
+            percent => Some(percent),
+        }
+    }
+
+    /// Position of a deployment among those waiting for a slot, starting at 1; None
+    /// when it isn't waiting
+    pub fn queue_position(&self, deployment_id: i32) -> Option<usize> {
+        self.waiting.position(deployment_id)
+    }
+
+    /// Running pipelines and the deployments waiting for a slot
+    pub async fn status(&self) -> BuildQueueStatus {
+        BuildQueueStatus {
+            max_concurrent_builds: self.max_concurrent_builds().await,
+            running: *self.slots.running.lock().unwrap(),
+            waiting: self
+                .waiting
+                .snapshot()
+                .into_iter()
+                .enumerate()
+                .map(|(index, waiter)| QueuedBuild {
+                    deployment_id: waiter.deployment_id,
+                    priority: waiter.priority,
+                    position: index + 1,
+                    waiting_seconds: waiter.since.elapsed().as_secs(),
+                })
+                .collect(),
+        }
+    }
+
+    /// Wait for a free slot and take it, after the waiting deployments ahead of this one
+    pub async fn acquire(&self, deployment_id: i32, priority: BuildPriority) -> BuildSlot {
+        let _waiting = self.waiting.join(deployment_id, priority);
+        let mut waited = false;
+        let slot = loop {
+            // Register for wake-ups before checking so a release in between isn't missed
+            let released = self.slots.released.notified();
+            let limit = self.max_concurrent_builds().await;
+            // Free slots go to the deployments ahead first
+            let ahead = self.waiting.ahead_of(deployment_id);
+            if let Some(slot) = self.slots.try_acquire(limit.saturating_sub(ahead)) {
+                break slot;
+            }
+            if !waited {
+                info!(
+                    "Build queue full ({} running), deployment {} is waiting for a slot \
+                     with {:?} priority",
+                    limit, deployment_id, priority
+                );
+                waited = true;
+            }
+            let _ = tokio::time::timeout(LIMIT_RECHECK_INTERVAL, released).await;
+        };
+
+        if waited {
+            info!("Deployment {} got a build slot", deployment_id);
+        }
+        slot
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_slots_are_limited() {
+        let slots = Arc::new(BuildSlots::default());
+        let first = slots.try_acquire(2);
+        let second = slots.try_acquire(2);
+        assert!(first.is_some());
+        assert!(second.is_some());
+        assert!(slots.try_acquire(2).is_none());
+    }
+
+    #[test]
+    fn test_dropping_a_slot_frees_it() {
+        let slots = Arc::new(BuildSlots::default());
+        let slot = slots.try_acquire(1).unwrap();
+        assert!(slots.try_acquire(1).is_none());
+
+        drop(slot);
+        assert_eq!(*slots.running.lock().unwrap(), 0);
+        assert!(slots.try_acquire(1).is_some());
+    }
+
+    #[test]
+    fn test_waiting_positions_follow_arrival_order() {
+        let waiting = Arc::new(WaitingDeployments::default());
+        let first = waiting.join(10, BuildPriority::Normal);
+        let second = waiting.join(11, BuildPriority::Normal);
+        assert_eq!(waiting.position(10), Some(1));
+        assert_eq!(waiting.position(11), Some(2));
+
+        drop(first);
+        assert_eq!(waiting.position(10), None);
+        assert_eq!(waiting.position(11), Some(1));
+        drop(second);
+        assert!(waiting.waiters.lock().unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_higher_priority_deployments_go_first() {
+        let waiting = Arc::new(WaitingDeployments::default());
+        let _preview = waiting.join(10, BuildPriority::Low);
+        let _staging = waiting.join(11, BuildPriority::Normal);
+        let _hotfix = waiting.join(12, BuildPriority::High);
+        let _second_preview = waiting.join(13, BuildPriority::Low);
+        let _second_hotfix = waiting.join(14, BuildPriority::High);
+
+        let order: Vec<i32> = waiting
+            .snapshot()
+            .iter()
+            .map(|waiter| waiter.deployment_id)
+            .collect();
+        assert_eq!(order, vec![12, 14, 11, 10, 13]);
+        assert_eq!(waiting.ahead_of(12), 0);
+        assert_eq!(waiting.ahead_of(10), 3);
+
+        // With one slot free only the first waiter may take it
+        let slots = Arc::new(BuildSlots::default());
+        let _running = slots.try_acquire(2).unwrap();
+        assert!(slots.try_acquire(2 - waiting.ahead_of(10)).is_none());
+        assert!(slots.try_acquire(2 - waiting.ahead_of(12)).is_some());
+    }
+
+    #[test]
+    fn test_priority_follows_trigger() {
+        assert_eq!(
+            priority_for_trigger(BuildPriority::Normal, Some("git_push")),
+            BuildPriority::Normal
+        );
+        assert_eq!(
+            priority_for_trigger(BuildPriority::Low, Some(MANUAL_TRIGGER)),
+            BuildPriority::Normal
+        );
+        assert_eq!(
+            priority_for_trigger(BuildPriority::High, Some(MANUAL_TRIGGER)),
+            BuildPriority::High
+        );
+        assert_eq!(
+            priority_for_trigger(BuildPriority::High, Some(BASE_IMAGE_UPDATE_TRIGGER)),
+            BuildPriority::Normal
+        );
+        assert_eq!(
+            priority_for_trigger(BuildPriority::Low, None),
+            BuildPriority::Low
+        );
+    }
+
+    #[test]
+    fn test_lowering_the_limit_keeps_running_slots() {
+        let slots = Arc::new(BuildSlots::default());
+        let _a = slots.try_acquire(3).unwrap();
+        let _b = slots.try_acquire(3).unwrap();
+
+        // Running builds are not interrupted, new ones wait
+        assert!(slots.try_acquire(1).is_none());
+        assert_eq!(*slots.running.lock().unwrap(), 2);
+    }
+}
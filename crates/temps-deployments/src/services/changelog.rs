@@ -0,0 +1,482 @@
+//! Changelog
+//!
+//! A curated history of what shipped in a project, for people following its releases
+//! rather than debugging it: one entry per finished deployment with its release note,
+//! who started it and how it ended. Unlike the audit log it leaves out everything
+//! but deploys.
+//!
+//! A deployment's release note is given when it is triggered, or set later. Without
+//! one, the entry shows the first line of the deployed commit's message. The feed is
+//! also rendered as RSS.
+
+use std::collections::{HashMap, HashSet};
+use std::sync::Arc;
+
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, QueryOrder, QuerySelect, Set,
+};
+use serde::Serialize;
+use temps_core::UtcDateTime;
+use temps_database::DbConnection;
+use temps_entities::{deployments, environments, projects, users};
+use utoipa::ToSchema;
+
+use super::DeploymentError;
+
+/// Entries returned when no limit is given
+const DEFAULT_CHANGELOG_LIMIT: u64 = 50;
+
+/// Most entries returned at once
+const MAX_CHANGELOG_LIMIT: u64 = 200;
+
+/// Longest release note accepted
+pub const MAX_RELEASE_NOTE_LENGTH: usize = 2000;
+
+/// How a deployment in the changelog ended
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ChangelogOutcome {
+    Succeeded,
+    Failed,
+    Cancelled,
+}
+
+impl ChangelogOutcome {
+    /// Outcome of a deployment in `state`; None while it is still in progress
+    pub fn from_state(state: &str) -> Option<Self> {
+        match state {
+            // Paused and stopped deployments went live before they were taken down
+            "completed" | "deployed" | "paused" | "stopped" => Some(Self::Succeeded),
+            "failed" => Some(Self::Failed),
+            "cancelled" => Some(Self::Cancelled),
+            _ => None,
+        }
+    }
+}
+
+/// Deployment states listed in the changelog
+const CHANGELOG_STATES: &[&str] = &[
+    "completed",
+    "deployed",
+    "paused",
+    "stopped",
+    "failed",
+    "cancelled",
+];
+
+/// A deployment in a project's changelog
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ChangelogEntry {
+    pub deployment_id: i32,
+    pub environment_id: i32,
+    #[schema(example = "production")]
+    pub environment_name: String,
+    /// Release note, or the first line of the commit message when none was given
+    #[schema(example = "Faster checkout and a fix for duplicate emails")]
+    pub note: Option<String>,
+    /// Whether `note` is a release note rather than a commit message
+    pub has_release_note: bool,
+    pub commit_sha: Option<String>,
+    pub branch: Option<String>,
+    pub tag: Option<String>,
+    /// The user who started the deployment, or the commit's author for deployments
+    /// of a push
+    #[schema(example = "Jane Doe")]
+    pub triggered_by: Option<String>,
+    /// ID of the user who started the deployment
+    pub triggered_by_user_id: Option<i32>,
+    pub outcome: ChangelogOutcome,
+    pub is_rollback: bool,
+    /// When the deployment finished
+    #[schema(value_type = String, format = "date-time", example = "2024-12-01T12:00:00Z")]
+    pub deployed_at: UtcDateTime,
+}
+
+/// Lists a project's changelog and edits release notes
+pub struct ChangelogService {
+    db: Arc<DbConnection>,
+}
+
+impl ChangelogService {
+    pub fn new(db: Arc<DbConnection>) -> Self {
+        Self { db }
+    }
+
+    /// Finished deployments of a project, newest first
+    pub async fn list_changelog(
+        &self,
+        project_id: i32,
+        environment_id: Option<i32>,
+        limit: Option<u64>,
+    ) -> Result<Vec<ChangelogEntry>, DeploymentError> {
+        let limit = limit
+            .unwrap_or(DEFAULT_CHANGELOG_LIMIT)
+            .clamp(1, MAX_CHANGELOG_LIMIT);
+        let mut query = deployments::Entity::find()
+            .filter(deployments::Column::ProjectId.eq(project_id))
+            .filter(deployments::Column::State.is_in(CHANGELOG_STATES.iter().copied()));
+        if let Some(environment_id) = environment_id {
+            query = query.filter(deployments::Column::EnvironmentId.eq(environment_id));
+        }
+        let deployments = query
+            .order_by_desc(deployments::Column::CreatedAt)
+            .limit(limit)
+            .all(self.db.as_ref())
+            .await?;
+
+        self.entries(project_id, deployments).await
+    }
+
+    /// Set or clear the release note of a deployment
+    pub async fn set_release_note(
+        &self,
+        project_id: i32,
+        deployment_id: i32,
+        release_note: Option<String>,
+    ) -> Result<deployments::Model, DeploymentError> {
+        let release_note = normalize_release_note(release_note)?;
+        let deployment = deployments::Entity::find_by_id(deployment_id)
+            .filter(deployments::Column::ProjectId.eq(project_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| {
+                DeploymentError::NotFound(format!("Deployment {} not found", deployment_id))
+            })?;
+
+        let mut metadata = deployment.metadata.clone().unwrap_or_default();
+        metadata.release_note = release_note;
+        let mut active: deployments::ActiveModel = deployment.into();
+        active.metadata = Set(Some(metadata));
+        active.updated_at = Set(chrono::Utc::now());
+        Ok(active.update(self.db.as_ref()).await?)
+    }
+
+    /// Changelog entry of a single deployment, if it has finished
+    pub async fn entry(
+        &self,
+        deployment: deployments::Model,
+    ) -> Result<Option<ChangelogEntry>, DeploymentError> {
+        let project_id = deployment.project_id;
+        Ok(self
+            .entries(project_id, vec![deployment])
+            .await?
+            .into_iter()
+            .next())
+    }
+
+    /// Project the RSS feed is rendered for
+    pub async fn get_project(&self, project_id: i32) -> Result<projects::Model, DeploymentError> {
+        projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound(format!("Project {} not found", project_id)))
+    }
+
+    async fn entries(
+        &self,
+        project_id: i32,
+        deployments: Vec<deployments::Model>,
+    ) -> Result<Vec<ChangelogEntry>, DeploymentError> {
+        let environment_names: HashMap<i32, String> = environments::Entity::find()
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|environment| (environment.id, environment.name))
+            .collect();
+
+        let user_ids: HashSet<i32> = deployments
+            .iter()
+            .filter_map(|d| d.metadata.as_ref()?.triggered_by_user_id)
+            .collect();
+        let user_names: HashMap<i32, String> = if user_ids.is_empty() {
+            HashMap::new()
+        } else {
+            users::Entity::find()
+                .filter(users::Column::Id.is_in(user_ids))
+                .all(self.db.as_ref())
+                .await?
+                .into_iter()
+                .map(|user| (user.id, user.name))
+                .collect()
+        };
+
+        Ok(deployments
+            .into_iter()
+            .filter_map(|deployment| {
+                let environment_name = environment_names
+                    .get(&deployment.environment_id)
+                    .cloned()
+                    .unwrap_or_default();
+                changelog_entry(deployment, environment_name, &user_names)
+            })
+            .collect())
+    }
+}
+
+/// Trim a release note, treating a blank one as none
+pub fn normalize_release_note(
+    release_note: Option<String>,
+) -> Result<Option<String>, DeploymentError> {
+    let Some(note) = release_note
+        .map(|note| note.trim().to_string())
+        .filter(|note| !note.is_empty())
+    else {
+        return Ok(None);
+    };
+    if note.chars().count() > MAX_RELEASE_NOTE_LENGTH {
+        return Err(DeploymentError::InvalidInput(format!(
+            "Release note is longer than {} characters",
+            MAX_RELEASE_NOTE_LENGTH
+        )));
+    }
+    Ok(Some(note))
+}
+
+fn changelog_entry(
+    deployment: deployments::Model,
+    environment_name: String,
+    user_names: &HashMap<i32, String>,
+) -> Option<ChangelogEntry> {
+    let outcome = ChangelogOutcome::from_state(&deployment.state)?;
+    let note = deployment.changelog_note();
+    let metadata = deployment.metadata.clone().unwrap_or_default();
+    let has_release_note = metadata
+        .release_note
+        .as_deref()
+        .is_some_and(|note| !note.trim().is_empty());
+    let triggered_by = match metadata.triggered_by_user_id {
+        Some(user_id) => user_names.get(&user_id).cloned(),
+        None => deployment.commit_author.clone(),
+    };
+
+    Some(ChangelogEntry {
+        deployment_id: deployment.id,
+        environment_id: deployment.environment_id,
+        environment_name,
+        note,
+        has_release_note,
+        commit_sha: deployment.commit_sha,
+        branch: deployment.branch_ref,
+        tag: deployment.tag_ref,
+        triggered_by,
+        triggered_by_user_id: metadata.triggered_by_user_id,
+        outcome,
+        is_rollback: metadata.is_rollback,
+        deployed_at: deployment.finished_at.unwrap_or(deployment.created_at),
+    })
+}
+
+/// Escape text for XML element content
+fn escape_xml(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => escaped.push_str("&amp;"),
+            '<' => escaped.push_str("&lt;"),
+            '>' => escaped.push_str("&gt;"),
+            '"' => escaped.push_str("&quot;"),
+            '\'' => escaped.push_str("&apos;"),
+            _ => escaped.push(c),
+        }
+    }
+    escaped
+}
+
+/// Render a project's changelog as an RSS 2.0 feed
+///
+/// `console_url` is the external URL of the console that entries link to.
+pub fn render_changelog_rss(
+    project: &projects::Model,
+    entries: &[ChangelogEntry],
+    console_url: &str,
+) -> String {
+    render_rss(&project.name, &project.slug, entries, console_url)
+}
+
+fn render_rss(
+    project_name: &str,
+    project_slug: &str,
+    entries: &[ChangelogEntry],
+    console_url: &str,
+) -> String {
+    let console_url = console_url.trim_end_matches('/');
+    let project_url = format!("{}/projects/{}", console_url, project_slug);
+
+    let mut rss = String::new();
+    rss.push_str("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n");
+    rss.push_str("<rss version=\"2.0\">\n<channel>\n");
+    rss.push_str(&format!(
+        "<title>{} changelog</title>\n",
+        escape_xml(project_name)
+    ));
+    rss.push_str(&format!("<link>{}</link>\n", escape_xml(&project_url)));
+    rss.push_str(&format!(
+        "<description>Releases of {}</description>\n",
+        escape_xml(project_name)
+    ));
+    if let Some(latest) = entries.first() {
+        rss.push_str(&format!(
+            "<lastBuildDate>{}</lastBuildDate>\n",
+            latest.deployed_at.to_rfc2822()
+        ));
+    }
+
+    for entry in entries {
+        let link = format!("{}/deployments/{}", project_url, entry.deployment_id);
+        let summary = entry
+            .note
+            .as_deref()
+            .and_then(|note| note.lines().next())
+            .map(str::to_string)
+            .unwrap_or_else(|| format!("Deployment #{}", entry.deployment_id));
+        let title = match entry.outcome {
+            ChangelogOutcome::Succeeded => format!("{}: {}", entry.environment_name, summary),
+            ChangelogOutcome::Failed => {
+                format!("{}: {} (failed)", entry.environment_name, summary)
+            }
+            ChangelogOutcome::Cancelled => {
+                format!("{}: {} (cancelled)", entry.environment_name, summary)
+            }
+        };
+
+        let mut description = entry.note.clone().unwrap_or_default();
+        let mut details = Vec::new();
+        if let Some(git_ref) = entry.tag.as_ref().or(entry.branch.as_ref()) {
+            details.push(git_ref.clone());
+        }
+        if let Some(sha) = &entry.commit_sha {
+            details.push(sha.chars().take(7).collect());
+        }
+        if let Some(triggered_by) = &entry.triggered_by {
+            details.push(format!("by {}", triggered_by));
+        }
+        if !details.is_empty() {
+            if !description.is_empty() {
+                description.push_str("\n\n");
+            }
+            description.push_str(&details.join(" · "));
+        }
+
+        rss.push_str("<item>\n");
+        rss.push_str(&format!("<title>{}</title>\n", escape_xml(&title)));
+        rss.push_str(&format!("<link>{}</link>\n", escape_xml(&link)));
+        rss.push_str(&format!(
+            "<guid isPermaLink=\"false\">{}-deployment-{}</guid>\n",
+            escape_xml(project_slug),
+            entry.deployment_id
+        ));
+        rss.push_str(&format!(
+            "<description>{}</description>\n",
+            escape_xml(&description)
+        ));
+        if let Some(triggered_by) = &entry.triggered_by {
+            rss.push_str(&format!("<author>{}</author>\n", escape_xml(triggered_by)));
+        }
+        rss.push_str(&format!(
+            "<pubDate>{}</pubDate>\n",
+            entry.deployed_at.to_rfc2822()
+        ));
+        rss.push_str("</item>\n");
+    }
+
+    rss.push_str("</channel>\n</rss>\n");
+    rss
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chrono::Utc;
+
+    fn deployment(state: &str) -> deployments::Model {
+        deployments::Model {
+            id: 7,
+            project_id: 1,
+            environment_id: 2,
+            created_at: Utc::now(),
+            updated_at: Utc::now(),
+            slug: "app-7".to_string(),
+            state: state.to_string(),
+            metadata: None,
+            deploying_at: None,
+            ready_at: None,
+            started_at: None,
+            finished_at: None,
+            context_vars: None,
+            branch_ref: Some("main".to_string()),
+            tag_ref: None,
+            commit_sha: Some("0123456789abcdef".to_string()),
+            commit_message: Some("Fix checkout totals\n\nRounded per line".to_string()),
+            commit_author: Some("dev@example.com".to_string()),
+            commit_json: None,
+            cancelled_reason: None,
+            static_dir_location: None,
+            screenshot_location: None,
+            image_name: None,
+            deployment_config: None,
+        }
+    }
+
+    #[test]
+    fn test_note_falls_back_to_commit_subject() {
+        let entry =
+            changelog_entry(deployment("deployed"), "production".into(), &HashMap::new()).unwrap();
+        assert_eq!(entry.note.as_deref(), Some("Fix checkout totals"));
+        assert!(!entry.has_release_note);
+        assert_eq!(entry.triggered_by.as_deref(), Some("dev@example.com"));
+        assert_eq!(entry.outcome, ChangelogOutcome::Succeeded);
+    }
+
+    #[test]
+    fn test_release_note_and_user_win() {
+        let mut deployment = deployment("failed");
+        deployment.metadata = Some(deployments::DeploymentMetadata {
+            release_note: Some("New billing page".to_string()),
+            triggered_by_user_id: Some(3),
+            ..Default::default()
+        });
+        let users = HashMap::from([(3, "Jane Doe".to_string())]);
+
+        let entry = changelog_entry(deployment, "production".into(), &users).unwrap();
+        assert_eq!(entry.note.as_deref(), Some("New billing page"));
+        assert!(entry.has_release_note);
+        assert_eq!(entry.triggered_by.as_deref(), Some("Jane Doe"));
+        assert_eq!(entry.outcome, ChangelogOutcome::Failed);
+    }
+
+    #[test]
+    fn test_in_progress_deployments_are_left_out() {
+        assert!(changelog_entry(deployment("running"), String::new(), &HashMap::new()).is_none());
+        assert!(changelog_entry(deployment("pending"), String::new(), &HashMap::new()).is_none());
+    }
+
+    #[test]
+    fn test_normalize_release_note() {
+        assert_eq!(
+            normalize_release_note(Some("  ".to_string())).unwrap(),
+            None
+        );
+        assert_eq!(
+            normalize_release_note(Some(" v2 ".to_string())).unwrap(),
+            Some("v2".to_string())
+        );
+        assert!(normalize_release_note(Some("x".repeat(MAX_RELEASE_NOTE_LENGTH + 1))).is_err());
+    }
+
+    #[test]
+    fn test_rss_escapes_notes() {
+        let entry = changelog_entry(deployment("deployed"), "production".into(), &HashMap::new())
+            .map(|mut entry| {
+                entry.note = Some("Support <b> & \"quotes\"".to_string());
+                entry
+            })
+            .unwrap();
+        let rss = render_rss("Shop", "shop", &[entry], "https://temps.example.com/");
+        assert!(
+            rss.contains("<title>production: Support &lt;b&gt; &amp; &quot;quotes&quot;</title>")
+        );
+        assert!(rss.contains("<link>https://temps.example.com/projects/shop/deployments/7</link>"));
+        assert!(rss.contains("main · 0123456 · by dev@example.com"));
+    }
+}
@@ -0,0 +1,118 @@
+//! Cleanup Pace
+//!
+//! The nightly cleanup can delete thousands of deployments, logs and artifacts on a
+//! large install. A `CleanupPace` spaces those deletes out to the configured rate and
+//! stops them once the cleanup window closes, so the sweep stays off-peak and
+//! doesn't compete with live traffic for storage and database time.
+
+use temps_core::CleanupSettings;
+use tokio::sync::Mutex;
+use tokio::time::{Duration, Instant};
+
+/// Rate, concurrency and deadline shared by the deletes of one cleanup run
+pub struct CleanupPace {
+    concurrency: usize,
+    interval: Option<Duration>,
+    deadline: Option<Instant>,
+    /// Earliest moment of the next delete
+    next_slot: Mutex<Instant>,
+}
+
+impl CleanupPace {
+    /// No rate limit and no deadline, for manual runs
+    pub fn unlimited() -> Self {
+        Self {
+            concurrency: 1,
+            interval: None,
+            deadline: None,
+            next_slot: Mutex::new(Instant::now()),
+        }
+    }
+
+    /// Pace of a scheduled run starting now, ending with the cleanup window
+    pub fn from_settings(settings: &CleanupSettings) -> Self {
+        let now = Instant::now();
+        Self {
+            concurrency: settings.concurrency(),
+            interval: settings.delete_interval(),
+            deadline: settings.window().map(|window| now + window),
+            next_slot: Mutex::new(now),
+        }
+    }
+
+    /// Deletes run at once
+    pub fn concurrency(&self) -> usize {
+        self.concurrency
+    }
+
+    /// Whether the cleanup window has closed
+    pub fn window_closed(&self) -> bool {
+        self.deadline
+            .is_some_and(|deadline| Instant::now() >= deadline)
+    }
+
+    /// Wait for the next delete slot. Returns false, without waiting, once the
+    /// window has closed or would close before the slot.
+    pub async fn acquire(&self) -> bool {
+        let Some(interval) = self.interval else {
+            return !self.window_closed();
+        };
+        let slot = {
+            let mut next_slot = self.next_slot.lock().await;
+            let slot = (*next_slot).max(Instant::now());
+            if self.deadline.is_some_and(|deadline| slot >= deadline) {
+                return false;
+            }
+            *next_slot = slot + interval;
+            slot
+        };
+        tokio::time::sleep_until(slot).await;
+        true
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn settings(max_deletes_per_second: u32) -> CleanupSettings {
+        CleanupSettings {
+            window_start_hour: 2,
+            window_end_hour: 2,
+            concurrency: 4,
+            max_deletes_per_second,
+        }
+    }
+
+    #[tokio::test]
+    async fn test_deletes_are_spaced_to_the_rate() {
+        let pace = CleanupPace::from_settings(&settings(50));
+        let started = std::time::Instant::now();
+        for _ in 0..4 {
+            assert!(pace.acquire().await);
+        }
+        // Three waits of 20ms after the first delete
+        assert!(started.elapsed() >= std::time::Duration::from_millis(60));
+    }
+
+    #[tokio::test]
+    async fn test_closed_window_stops_deletes() {
+        let pace = CleanupPace {
+            deadline: Some(Instant::now()),
+            ..CleanupPace::from_settings(&settings(0))
+        };
+        assert!(pace.window_closed());
+        assert!(!pace.acquire().await);
+    }
+
+    #[test]
+    fn test_window_wraps_around_midnight() {
+        let mut settings = settings(0);
+        assert_eq!(settings.window(), None);
+        settings.window_start_hour = 22;
+        settings.window_end_hour = 4;
+        assert_eq!(settings.window(), Some(Duration::from_secs(6 * 3600)));
+        assert_eq!(settings.concurrency(), 4);
+        assert_eq!(settings.delete_interval(), None);
+    }
+}
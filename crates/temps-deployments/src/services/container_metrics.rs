@@ -0,0 +1,558 @@
+//! Container Metrics History
+//!
+//! Samples the CPU, memory and network usage of every environment's running
+//! containers and stores it in the `environment_metrics` hypertable. Containers come
+//! and go with each deployment, so samples are summed per environment rather than
+//! kept per container id; network counters are stored as the bytes transferred since
+//! the previous sample.
+//!
+//! Samples are kept at full resolution for `container_metrics.raw_retention_days`;
+//! the `environment_metrics_hourly` continuous aggregate keeps hourly averages and
+//! peaks for `container_metrics.hourly_retention_days`. Range queries read whichever
+//! of the two covers the range, bucketed to at most [`MAX_POINTS`] points.
+
+use std::collections::HashMap;
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+use chrono::Utc;
+use futures::StreamExt;
+use sea_orm::{ColumnTrait, ConnectionTrait, EntityTrait, FromQueryResult, QueryFilter};
+use serde::Serialize;
+use temps_core::UtcDateTime;
+use temps_database::DbConnection;
+use temps_deployer::{ContainerDeployer, ContainerStats};
+use temps_entities::{deployment_containers, environment_metrics, environments};
+use tokio::sync::Mutex;
+use tracing::{debug, error, info, warn};
+use utoipa::ToSchema;
+
+use super::{DeploymentError, ResourceAlertService};
+
+/// Most points returned for a range
+pub const MAX_POINTS: i64 = 500;
+
+/// Containers whose stats are read at the same time
+const STATS_CONCURRENCY: usize = 8;
+
+/// Time between two prunings of old metrics
+const PRUNE_INTERVAL: Duration = Duration::from_secs(3600);
+
+/// Bucket widths of range queries, in seconds
+const BUCKET_STEPS: [i64; 11] = [
+    60, 300, 900, 1800, 3600, 10_800, 21_600, 43_200, 86_400, 259_200, 604_800,
+];
+
+/// Where the points of a range come from
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum MetricsSource {
+    /// Samples at full resolution
+    Raw,
+    /// Hourly aggregates of older samples
+    Hourly,
+}
+
+/// Usage of an environment's containers over one bucket
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct EnvironmentMetricsPoint {
+    /// Start of the bucket
+    #[schema(value_type = String, format = "date-time", example = "2024-01-01T00:00:00Z")]
+    pub timestamp: UtcDateTime,
+    /// Average CPU usage, in percent of one host
+    pub cpu_percent: f64,
+    pub max_cpu_percent: f64,
+    /// Average memory usage
+    pub memory_bytes: i64,
+    pub max_memory_bytes: i64,
+    pub memory_limit_bytes: Option<i64>,
+    /// Bytes received during the bucket
+    pub network_rx_bytes: i64,
+    /// Bytes sent during the bucket
+    pub network_tx_bytes: i64,
+    /// Most containers running at once
+    pub container_count: i32,
+}
+
+/// Usage history of an environment
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct EnvironmentMetricsHistory {
+    pub environment_id: i32,
+    #[schema(value_type = String, format = "date-time", example = "2024-01-01T00:00:00Z")]
+    pub start_time: UtcDateTime,
+    #[schema(value_type = String, format = "date-time", example = "2024-01-01T00:00:00Z")]
+    pub end_time: UtcDateTime,
+    /// Width of each bucket, in seconds
+    pub resolution_seconds: i64,
+    pub source: MetricsSource,
+    pub points: Vec<EnvironmentMetricsPoint>,
+}
+
+/// Pick the table and bucket width for a range of `range_seconds` starting at
+/// `start`, given that samples at full resolution exist since `raw_since`
+pub fn choose_resolution(
+    range_seconds: i64,
+    start: UtcDateTime,
+    raw_since: UtcDateTime,
+    collection_interval_seconds: i64,
+) -> (MetricsSource, i64) {
+    let ideal = (range_seconds + MAX_POINTS - 1) / MAX_POINTS;
+    let ideal = ideal.max(collection_interval_seconds).max(1);
+    let step = BUCKET_STEPS
+        .iter()
+        .copied()
+        .find(|step| *step >= ideal)
+        .unwrap_or(ideal);
+
+    if step < 3600 && start >= raw_since {
+        (MetricsSource::Raw, step)
+    } else {
+        (MetricsSource::Hourly, step.max(3600))
+    }
+}
+
+/// Bytes a cumulative counter grew since `previous`; a counter that went down was
+/// reset (e.g. the container restarted), so all of its value is new
+pub fn counter_delta(previous: Option<u64>, current: u64) -> u64 {
+    match previous {
+        None => 0,
+        Some(previous) if current >= previous => current - previous,
+        Some(_) => current,
+    }
+}
+
+/// Sum of the containers' stats; network counters are turned into deltas against
+/// `counters`, which is updated with the new values
+fn aggregate_stats(
+    stats: &[ContainerStats],
+    counters: &mut HashMap<String, (u64, u64)>,
+) -> (f64, i64, Option<i64>, i64, i64) {
+    let mut cpu_percent = 0.0;
+    let mut memory_bytes: u64 = 0;
+    let mut memory_limit_bytes: Option<u64> = Some(0);
+    let mut network_rx_bytes: u64 = 0;
+    let mut network_tx_bytes: u64 = 0;
+
+    for stat in stats {
+        cpu_percent += stat.cpu_percent;
+        memory_bytes = memory_bytes.saturating_add(stat.memory_bytes);
+        memory_limit_bytes = match (memory_limit_bytes, stat.memory_limit_bytes) {
+            (Some(total), Some(limit)) => Some(total.saturating_add(limit)),
+            _ => None,
+        };
+
+        let previous = counters.get(&stat.container_id).copied();
+        network_rx_bytes = network_rx_bytes.saturating_add(counter_delta(
+            previous.map(|(rx, _)| rx),
+            stat.network_rx_bytes,
+        ));
+        network_tx_bytes = network_tx_bytes.saturating_add(counter_delta(
+            previous.map(|(_, tx)| tx),
+            stat.network_tx_bytes,
+        ));
+        counters.insert(
+            stat.container_id.clone(),
+            (stat.network_rx_bytes, stat.network_tx_bytes),
+        );
+    }
+
+    let to_i64 = |value: u64| i64::try_from(value).unwrap_or(i64::MAX);
+    (
+        cpu_percent,
+        to_i64(memory_bytes),
+        memory_limit_bytes.filter(|_| !stats.is_empty()).map(to_i64),
+        to_i64(network_rx_bytes),
+        to_i64(network_tx_bytes),
+    )
+}
+
+/// Records and serves the resource usage history of environments
+pub struct ContainerMetricsService {
+    db: Arc<DbConnection>,
+    deployer: Arc<dyn ContainerDeployer>,
+    config_service: Arc<temps_config::ConfigService>,
+    /// Last network counters of each container, for deltas between samples
+    counters: Mutex<HashMap<String, (u64, u64)>>,
+    alerts: Option<Arc<ResourceAlertService>>,
+}
+
+impl ContainerMetricsService {
+    pub fn new(
+        db: Arc<DbConnection>,
+        deployer: Arc<dyn ContainerDeployer>,
+        config_service: Arc<temps_config::ConfigService>,
+    ) -> Self {
+        Self {
+            db,
+            deployer,
+            config_service,
+            counters: Mutex::new(HashMap::new()),
+            alerts: None,
+        }
+    }
+
+    /// Evaluate resource alert rules against each round of samples
+    pub fn with_alerts(mut self, alerts: Arc<ResourceAlertService>) -> Self {
+        self.alerts = Some(alerts);
+        self
+    }
+
+    async fn settings(&self) -> temps_core::ContainerMetricsSettings {
+        match self.config_service.get_settings().await {
+            Ok(settings) => settings.container_metrics,
+            Err(e) => {
+                warn!("Failed to load container metrics settings: {}", e);
+                temps_core::ContainerMetricsSettings::default()
+            }
+        }
+    }
+
+    /// Sample the running containers of every environment; returns the samples
+    /// recorded
+    pub async fn collect(&self) -> Result<Vec<environment_metrics::Model>, DeploymentError> {
+        let environments = environments::Entity::find()
+            .filter(environments::Column::DeletedAt.is_null())
+            .filter(environments::Column::CurrentDeploymentId.is_not_null())
+            .all(self.db.as_ref())
+            .await?;
+        if environments.is_empty() {
+            return Ok(Vec::new());
+        }
+
+        let deployment_ids: Vec<i32> = environments
+            .iter()
+            .filter_map(|environment| environment.current_deployment_id)
+            .collect();
+        let containers = deployment_containers::Entity::find()
+            .filter(deployment_containers::Column::DeploymentId.is_in(deployment_ids))
+            .filter(deployment_containers::Column::DeletedAt.is_null())
+            .all(self.db.as_ref())
+            .await?;
+
+        let stats: Vec<(i32, ContainerStats)> = futures::stream::iter(containers)
+            .map(|container| async move {
+                match self
+                    .deployer
+                    .get_container_stats(&container.container_id)
+                    .await
+                {
+                    Ok(stats) => Some((container.deployment_id, stats)),
+                    Err(e) => {
+                        debug!("No stats for container {}: {}", container.container_name, e);
+                        None
+                    }
+                }
+            })
+            .buffer_unordered(STATS_CONCURRENCY)
+            .filter_map(|stats| async move { stats })
+            .collect()
+            .await;
+
+        let mut by_deployment: HashMap<i32, Vec<ContainerStats>> = HashMap::new();
+        for (deployment_id, stats) in stats {
+            by_deployment.entry(deployment_id).or_default().push(stats);
+        }
+
+        let now = Utc::now();
+        let mut counters = self.counters.lock().await;
+        let mut seen = HashMap::new();
+        let mut rows = Vec::new();
+        for environment in &environments {
+            let Some(deployment_id) = environment.current_deployment_id else {
+                continue;
+            };
+            let Some(stats) = by_deployment.get(&deployment_id) else {
+                continue;
+            };
+            let (cpu_percent, memory_bytes, memory_limit_bytes, rx, tx) =
+                aggregate_stats(stats, &mut counters);
+            for stat in stats {
+                if let Some(values) = counters.get(&stat.container_id) {
+                    seen.insert(stat.container_id.clone(), *values);
+                }
+            }
+            rows.push(environment_metrics::Model {
+                environment_id: environment.id,
+                timestamp: now,
+                project_id: environment.project_id,
+                deployment_id: Some(deployment_id),
+                container_count: stats.len() as i32,
+                cpu_percent,
+                memory_bytes,
+                memory_limit_bytes,
+                network_rx_bytes: rx,
+                network_tx_bytes: tx,
+            });
+        }
+        // Forget containers that are gone
+        *counters = seen;
+        drop(counters);
+
+        if !rows.is_empty() {
+            environment_metrics::Entity::insert_many(
+                rows.iter()
+                    .cloned()
+                    .map(environment_metrics::ActiveModel::from),
+            )
+            .exec_without_returning(self.db.as_ref())
+            .await?;
+        }
+        Ok(rows)
+    }
+
+    /// Drop samples and hourly aggregates past their retention
+    pub async fn prune(
+        &self,
+        settings: &temps_core::ContainerMetricsSettings,
+    ) -> Result<(), DeploymentError> {
+        // The hourly aggregate is refreshed from the last few hours of samples, so
+        // those are always kept
+        let raw_days = settings.raw_retention_days.max(1) as i32;
+        let hourly_days = settings.hourly_retention_days.max(raw_days as u32) as i32;
+
+        for (relation, days) in [
+            ("environment_metrics", raw_days),
+            ("environment_metrics_hourly", hourly_days),
+        ] {
+            self.db
+                .execute(sea_orm::Statement::from_sql_and_values(
+                    sea_orm::DatabaseBackend::Postgres,
+                    format!(
+                        "SELECT drop_chunks('{}', older_than => NOW() - make_interval(days => $1))",
+                        relation
+                    ),
+                    vec![days.into()],
+                ))
+                .await?;
+        }
+        Ok(())
+    }
+
+    /// Usage history of an environment between two times
+    pub async fn get_history(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        start_time: UtcDateTime,
+        end_time: UtcDateTime,
+    ) -> Result<EnvironmentMetricsHistory, DeploymentError> {
+        if end_time <= start_time {
+            return Err(DeploymentError::InvalidInput(
+                "end_time must be after start_time".to_string(),
+            ));
+        }
+        environments::Entity::find_by_id(environment_id)
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Environment not found".to_string()))?;
+
+        let settings = self.settings().await;
+        let raw_since =
+            Utc::now() - chrono::Duration::days(i64::from(settings.raw_retention_days.max(1)));
+        let (source, resolution_seconds) = choose_resolution(
+            (end_time - start_time).num_seconds(),
+            start_time,
+            raw_since,
+            settings.collection_interval_seconds as i64,
+        );
+
+        #[derive(FromQueryResult)]
+        struct BucketResult {
+            bucket: UtcDateTime,
+            cpu_percent: Option<f64>,
+            max_cpu_percent: Option<f64>,
+            memory_bytes: Option<f64>,
+            max_memory_bytes: Option<i64>,
+            memory_limit_bytes: Option<i64>,
+            network_rx_bytes: Option<i64>,
+            network_tx_bytes: Option<i64>,
+            container_count: Option<i32>,
+        }
+
+        let query = match source {
+            MetricsSource::Raw => {
+                r#"
+                SELECT
+                    time_bucket(make_interval(secs => $1), timestamp) AS bucket,
+                    AVG(cpu_percent)::float8 AS cpu_percent,
+                    MAX(cpu_percent)::float8 AS max_cpu_percent,
+                    AVG(memory_bytes)::float8 AS memory_bytes,
+                    MAX(memory_bytes)::int8 AS max_memory_bytes,
+                    MAX(memory_limit_bytes)::int8 AS memory_limit_bytes,
+                    SUM(network_rx_bytes)::int8 AS network_rx_bytes,
+                    SUM(network_tx_bytes)::int8 AS network_tx_bytes,
+                    MAX(container_count)::int4 AS container_count
+                FROM environment_metrics
+                WHERE environment_id = $2
+                  AND timestamp >= $3
+                  AND timestamp < $4
+                GROUP BY 1
+                ORDER BY 1 ASC
+                "#
+            }
+            MetricsSource::Hourly => {
+                r#"
+                SELECT
+                    time_bucket(make_interval(secs => $1), bucket) AS bucket,
+                    (SUM(avg_cpu_percent * samples) / NULLIF(SUM(samples), 0))::float8 AS cpu_percent,
+                    MAX(max_cpu_percent)::float8 AS max_cpu_percent,
+                    (SUM(avg_memory_bytes * samples) / NULLIF(SUM(samples), 0))::float8 AS memory_bytes,
+                    MAX(max_memory_bytes)::int8 AS max_memory_bytes,
+                    MAX(memory_limit_bytes)::int8 AS memory_limit_bytes,
+                    SUM(network_rx_bytes)::int8 AS network_rx_bytes,
+                    SUM(network_tx_bytes)::int8 AS network_tx_bytes,
+                    MAX(container_count)::int4 AS container_count
+                FROM environment_metrics_hourly
+                WHERE environment_id = $2
+                  AND bucket >= $3
+                  AND bucket < $4
+                GROUP BY 1
+                ORDER BY 1 ASC
+                "#
+            }
+        };
+
+        let results = environment_metrics::Entity::find()
+            .from_raw_sql(sea_orm::Statement::from_sql_and_values(
+                sea_orm::DatabaseBackend::Postgres,
+                query,
+                vec![
+                    (resolution_seconds as f64).into(),
+                    environment_id.into(),
+                    start_time.into(),
+                    end_time.into(),
+                ],
+            ))
+            .into_model::<BucketResult>()
+            .all(self.db.as_ref())
+            .await?;
+
+        let points = results
+            .into_iter()
+            .map(|r| EnvironmentMetricsPoint {
+                timestamp: r.bucket,
+                cpu_percent: r.cpu_percent.unwrap_or(0.0),
+                max_cpu_percent: r.max_cpu_percent.unwrap_or(0.0),
+                memory_bytes: r.memory_bytes.unwrap_or(0.0) as i64,
+                max_memory_bytes: r.max_memory_bytes.unwrap_or(0),
+                memory_limit_bytes: r.memory_limit_bytes,
+                network_rx_bytes: r.network_rx_bytes.unwrap_or(0),
+                network_tx_bytes: r.network_tx_bytes.unwrap_or(0),
+                container_count: r.container_count.unwrap_or(0),
+            })
+            .collect();
+
+        Ok(EnvironmentMetricsHistory {
+            environment_id,
+            start_time,
+            end_time,
+            resolution_seconds,
+            source,
+            points,
+        })
+    }
+
+    /// Sample periodically while `container_metrics.enabled` is on, pruning old
+    /// metrics every hour
+    pub async fn start_scheduler(&self) {
+        info!("Container metrics collector started");
+        let mut last_prune: Option<Instant> = None;
+
+        loop {
+            let settings = self.settings().await;
+            let interval = settings.collection_interval_seconds.max(10);
+            tokio::time::sleep(Duration::from_secs(interval)).await;
+
+            if !settings.enabled {
+                continue;
+            }
+
+            match self.collect().await {
+                Ok(samples) => {
+                    debug!("Sampled metrics of {} environments", samples.len());
+                    if let Some(alerts) = &self.alerts {
+                        alerts.evaluate(&samples).await;
+                    }
+                }
+                Err(e) => error!("Failed to collect container metrics: {}", e),
+            }
+
+            if last_prune.map_or(true, |at| at.elapsed() >= PRUNE_INTERVAL) {
+                last_prune = Some(Instant::now());
+                if let Err(e) = self.prune(&settings).await {
+                    error!("Failed to prune container metrics: {}", e);
+                }
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn stats(container_id: &str, cpu: f64, memory: u64, rx: u64, tx: u64) -> ContainerStats {
+        ContainerStats {
+            container_id: container_id.to_string(),
+            container_name: container_id.to_string(),
+            cpu_percent: cpu,
+            memory_bytes: memory,
+            memory_limit_bytes: Some(1024),
+            memory_percent: None,
+            network_rx_bytes: rx,
+            network_tx_bytes: tx,
+            timestamp: Utc::now(),
+        }
+    }
+
+    #[test]
+    fn test_counter_delta() {
+        assert_eq!(counter_delta(None, 500), 0);
+        assert_eq!(counter_delta(Some(100), 500), 400);
+        // Counter reset by a restart
+        assert_eq!(counter_delta(Some(900), 300), 300);
+    }
+
+    #[test]
+    fn test_aggregate_stats_sums_containers() {
+        let mut counters = HashMap::new();
+        let first = [stats("a", 10.0, 100, 1000, 50), stats("b", 5.5, 200, 0, 0)];
+        let (cpu, memory, limit, rx, tx) = aggregate_stats(&first, &mut counters);
+        assert_eq!(cpu, 15.5);
+        assert_eq!(memory, 300);
+        assert_eq!(limit, Some(2048));
+        assert_eq!((rx, tx), (0, 0));
+
+        let second = [stats("a", 1.0, 100, 1500, 80)];
+        let (_, _, _, rx, tx) = aggregate_stats(&second, &mut counters);
+        assert_eq!((rx, tx), (500, 30));
+    }
+
+    #[test]
+    fn test_choose_resolution() {
+        let now = Utc::now();
+        let raw_since = now - chrono::Duration::days(7);
+
+        // Last hour of raw samples
+        assert_eq!(
+            choose_resolution(3600, now - chrono::Duration::hours(1), raw_since, 30),
+            (MetricsSource::Raw, 60)
+        );
+        // A day of raw samples, in 5 minute buckets
+        assert_eq!(
+            choose_resolution(86_400, now - chrono::Duration::days(1), raw_since, 30),
+            (MetricsSource::Raw, 300)
+        );
+        // Older than the raw retention
+        assert_eq!(
+            choose_resolution(3600, now - chrono::Duration::days(30), raw_since, 30),
+            (MetricsSource::Hourly, 3600)
+        );
+        // Ninety days, from hourly aggregates
+        assert_eq!(
+            choose_resolution(90 * 86_400, now - chrono::Duration::days(90), raw_since, 30),
+            (MetricsSource::Hourly, 21_600)
+        );
+    }
+}
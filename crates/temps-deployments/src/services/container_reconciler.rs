@@ -0,0 +1,590 @@
+//! Container Reconciler
+//!
+//! Compares the desired container state (active `deployment_containers` rows of each
+//! environment's current deployment) with what is actually running on the container
+//! runtime, and heals drift:
+//! - containers that exist but are not running are started again
+//! - containers that were recreated outside Temps (e.g. after a daemon restart) are adopted
+//!   by matching their `sh.temps.deployment-id` label and name
+//! - containers that are gone entirely are marked as `missing`, and the environment's
+//!   current deployment is redeployed (its image with the same configuration) to bring
+//!   them back, unless a deployment of that environment is already in progress
+//!
+//! Containers recorded as stopped (a user stopped them, or their project) stay stopped.
+//!
+//! Reconciliation runs on startup and every time the runtime event stream reconnects.
+
+use sea_orm::{ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, Set};
+use std::collections::{HashMap, HashSet};
+use std::sync::Arc;
+use temps_deployer::events::{RuntimeEvent, RuntimeEventHandler};
+use temps_deployer::labels::deployment_id_from_labels;
+use temps_deployer::{ContainerDeployer, ContainerStatus, ManagedContainer};
+use temps_entities::{deployment_containers, deployments, environments};
+use tracing::{debug, error, info, warn};
+
+use super::resource_gc_service::FINISHED_STATES;
+use super::{DeploymentError, PromotionService};
+
+/// A container Temps expects to be running
+#[derive(Debug, Clone, PartialEq)]
+pub struct DesiredContainer {
+    /// `deployment_containers.id`
+    pub record_id: i32,
+    pub project_id: i32,
+    pub environment_id: i32,
+    pub deployment_id: i32,
+    pub container_id: String,
+    pub container_name: String,
+}
+
+/// Action required to bring the runtime back in line with the desired state
+#[derive(Debug, Clone, PartialEq)]
+pub enum ReconcileAction {
+    /// Container exists but is not running
+    Start {
+        record_id: i32,
+        container_id: String,
+    },
+    /// Record points to a container ID that no longer exists, but a labeled container
+    /// with the same name belongs to the same deployment
+    Adopt {
+        record_id: i32,
+        container_id: String,
+    },
+    /// No matching container exists on the runtime. The record is marked as `missing`
+    /// and the environment is redeployed, see [`plan_redeploys`]
+    Missing {
+        record_id: i32,
+        container_name: String,
+    },
+}
+
+/// Summary of a reconciliation pass
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct ReconcileSummary {
+    pub checked: usize,
+    pub started: usize,
+    pub adopted: usize,
+    pub missing: usize,
+    pub redeployed: usize,
+}
+
+/// Redeploy of an environment's current deployment, to recreate its missing containers
+#[derive(Debug, Clone, PartialEq)]
+pub struct Redeploy {
+    pub project_id: i32,
+    pub environment_id: i32,
+    pub deployment_id: i32,
+}
+
+fn needs_start(status: &ContainerStatus) -> bool {
+    !matches!(status, ContainerStatus::Running)
+}
+
+/// Whether a `deployment_containers` row with this recorded status should be running.
+/// Containers stopped through the API keep their row with status `stopped`.
+fn should_run(recorded_status: Option<&str>) -> bool {
+    !matches!(recorded_status, Some("stopped") | Some("removed"))
+}
+
+/// Compute the actions required to reconcile `desired` with `actual`
+pub fn plan_reconciliation(
+    desired: &[DesiredContainer],
+    actual: &[ManagedContainer],
+) -> Vec<ReconcileAction> {
+    let by_id: HashMap<&str, &ManagedContainer> = actual
+        .iter()
+        .map(|c| (c.container_id.as_str(), c))
+        .collect();
+
+    let mut actions = Vec::new();
+
+    for wanted in desired {
+        if let Some(container) = by_id.get(wanted.container_id.as_str()) {
+            if needs_start(&container.status) {
+                actions.push(ReconcileAction::Start {
+                    record_id: wanted.record_id,
+                    container_id: container.container_id.clone(),
+                });
+            }
+            continue;
+        }
+
+        let adoptable = actual.iter().find(|c| {
+            c.container_name == wanted.container_name
+                && deployment_id_from_labels(&c.labels) == Some(wanted.deployment_id)
+        });
+
+        match adoptable {
+            Some(container) => {
+                actions.push(ReconcileAction::Adopt {
+                    record_id: wanted.record_id,
+                    container_id: container.container_id.clone(),
+                });
+                if needs_start(&container.status) {
+                    actions.push(ReconcileAction::Start {
+                        record_id: wanted.record_id,
+                        container_id: container.container_id.clone(),
+                    });
+                }
+            }
+            None => actions.push(ReconcileAction::Missing {
+                record_id: wanted.record_id,
+                container_name: wanted.container_name.clone(),
+            }),
+        }
+    }
+
+    actions
+}
+
+/// Environments to redeploy because containers of their current deployment are missing:
+/// one redeploy per environment, skipping environments with a deployment in progress
+/// (which replaces the containers anyway, or is an earlier redeploy still running)
+pub fn plan_redeploys(
+    desired: &[DesiredContainer],
+    actions: &[ReconcileAction],
+    environments_in_progress: &HashSet<i32>,
+) -> Vec<Redeploy> {
+    let by_record: HashMap<i32, &DesiredContainer> =
+        desired.iter().map(|c| (c.record_id, c)).collect();
+
+    let mut planned = HashSet::new();
+    let mut redeploys = Vec::new();
+    for action in actions {
+        let ReconcileAction::Missing { record_id, .. } = action else {
+            continue;
+        };
+        let Some(container) = by_record.get(record_id) else {
+            continue;
+        };
+        if environments_in_progress.contains(&container.environment_id)
+            || !planned.insert(container.environment_id)
+        {
+            continue;
+        }
+        redeploys.push(Redeploy {
+            project_id: container.project_id,
+            environment_id: container.environment_id,
+            deployment_id: container.deployment_id,
+        });
+    }
+
+    redeploys
+}
+
+/// Reconciles desired vs actual container state
+pub struct ContainerReconciler {
+    db: Arc<DatabaseConnection>,
+    deployer: Arc<dyn ContainerDeployer>,
+    promotion_service: Option<Arc<PromotionService>>,
+}
+
+impl ContainerReconciler {
+    pub fn new(db: Arc<DatabaseConnection>, deployer: Arc<dyn ContainerDeployer>) -> Self {
+        Self {
+            db,
+            deployer,
+            promotion_service: None,
+        }
+    }
+
+    /// Redeploy environments whose containers are missing. Without it missing
+    /// containers are only marked
+    pub fn with_promotion_service(mut self, promotion_service: Arc<PromotionService>) -> Self {
+        self.promotion_service = Some(promotion_service);
+        self
+    }
+
+    /// Load the containers of every environment's current deployment, except the
+    /// ones recorded as stopped
+    async fn load_desired_state(&self) -> Result<Vec<DesiredContainer>, DeploymentError> {
+        let current_deployments: HashMap<i32, (i32, i32)> = environments::Entity::find()
+            .filter(environments::Column::DeletedAt.is_null())
+            .filter(environments::Column::CurrentDeploymentId.is_not_null())
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .filter_map(|env| {
+                env.current_deployment_id
+                    .map(|deployment_id| (deployment_id, (env.project_id, env.id)))
+            })
+            .collect();
+
+        if current_deployments.is_empty() {
+            return Ok(Vec::new());
+        }
+
+        let containers = deployment_containers::Entity::find()
+            .filter(
+                deployment_containers::Column::DeploymentId
+                    .is_in(current_deployments.keys().copied()),
+            )
+            .filter(deployment_containers::Column::DeletedAt.is_null())
+            .all(self.db.as_ref())
+            .await?;
+
+        Ok(containers
+            .into_iter()
+            .filter(|c| should_run(c.status.as_deref()))
+            .filter_map(|c| {
+                let (project_id, environment_id) = *current_deployments.get(&c.deployment_id)?;
+                Some(DesiredContainer {
+                    record_id: c.id,
+                    project_id,
+                    environment_id,
+                    deployment_id: c.deployment_id,
+                    container_id: c.container_id,
+                    container_name: c.container_name,
+                })
+            })
+            .collect())
+    }
+
+    /// Environments, among `environment_ids`, with a deployment that hasn't finished
+    async fn environments_in_progress(
+        &self,
+        environment_ids: Vec<i32>,
+    ) -> Result<HashSet<i32>, DeploymentError> {
+        if environment_ids.is_empty() {
+            return Ok(HashSet::new());
+        }
+
+        Ok(deployments::Entity::find()
+            .filter(deployments::Column::EnvironmentId.is_in(environment_ids))
+            .filter(deployments::Column::State.is_not_in(FINISHED_STATES.iter().copied()))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|d| d.environment_id)
+            .collect())
+    }
+
+    /// Redeploy the current deployment of each environment with missing containers
+    async fn redeploy_missing(
+        &self,
+        desired: &[DesiredContainer],
+        actions: &[ReconcileAction],
+    ) -> Result<usize, DeploymentError> {
+        let Some(promotion_service) = &self.promotion_service else {
+            return Ok(0);
+        };
+
+        let affected: Vec<i32> = actions
+            .iter()
+            .filter_map(|action| match action {
+                ReconcileAction::Missing { record_id, .. } => desired
+                    .iter()
+                    .find(|c| c.record_id == *record_id)
+                    .map(|c| c.environment_id),
+                _ => None,
+            })
+            .collect();
+        let in_progress = self.environments_in_progress(affected).await?;
+
+        let mut redeployed = 0;
+        for redeploy in plan_redeploys(desired, actions, &in_progress) {
+            match promotion_service
+                .redeploy_current(redeploy.project_id, redeploy.environment_id)
+                .await
+            {
+                Ok(deployment) => {
+                    info!(
+                        "Redeploying deployment {} of environment {} as deployment {} \
+                         to recreate its missing containers",
+                        redeploy.deployment_id, redeploy.environment_id, deployment.id
+                    );
+                    redeployed += 1;
+                }
+                Err(e) => {
+                    error!(
+                        "Failed to redeploy environment {} to recreate its missing containers: {}",
+                        redeploy.environment_id, e
+                    );
+                }
+            }
+        }
+
+        Ok(redeployed)
+    }
+
+    async fn update_record(
+        &self,
+        record_id: i32,
+        container_id: Option<String>,
+        status: &str,
+    ) -> Result<(), DeploymentError> {
+        let Some(record) = deployment_containers::Entity::find_by_id(record_id)
+            .one(self.db.as_ref())
+            .await?
+        else {
+            return Ok(());
+        };
+
+        let mut active: deployment_containers::ActiveModel = record.into();
+        if let Some(container_id) = container_id {
+            active.container_id = Set(container_id);
+        }
+        active.status = Set(Some(status.to_string()));
+        active.update(self.db.as_ref()).await?;
+        Ok(())
+    }
+
+    /// Run a full reconciliation pass
+    pub async fn reconcile(&self) -> Result<ReconcileSummary, DeploymentError> {
+        let desired = self.load_desired_state().await?;
+        let actual = self
+            .deployer
+            .list_managed_containers()
+            .await
+            .map_err(|e| DeploymentError::Other(format!("Failed to list containers: {}", e)))?;
+
+        let actions = plan_reconciliation(&desired, &actual);
+        let mut summary = ReconcileSummary {
+            checked: desired.len(),
+            ..Default::default()
+        };
+
+        for action in actions.iter().cloned() {
+            match action {
+                ReconcileAction::Start {
+                    record_id,
+                    container_id,
+                } => match self.deployer.start_container(&container_id).await {
+                    Ok(()) => {
+                        info!("Restarted container {} during reconciliation", container_id);
+                        self.update_record(record_id, None, "running").await?;
+                        summary.started += 1;
+                    }
+                    Err(e) => {
+                        error!(
+                            "Failed to restart container {} during reconciliation: {}",
+                            container_id, e
+                        );
+                    }
+                },
+                ReconcileAction::Adopt {
+                    record_id,
+                    container_id,
+                } => {
+                    info!(
+                        "Adopting container {} for deployment_container {}",
+                        container_id, record_id
+                    );
+                    self.update_record(record_id, Some(container_id), "running")
+                        .await?;
+                    summary.adopted += 1;
+                }
+                ReconcileAction::Missing {
+                    record_id,
+                    container_name,
+                } => {
+                    warn!(
+                        "Container {} (deployment_container {}) is missing from the runtime",
+                        container_name, record_id
+                    );
+                    self.update_record(record_id, None, "missing").await?;
+                    summary.missing += 1;
+                }
+            }
+        }
+
+        summary.redeployed = self.redeploy_missing(&desired, &actions).await?;
+
+        Ok(summary)
+    }
+}
+
+#[async_trait::async_trait]
+impl RuntimeEventHandler for ContainerReconciler {
+    async fn on_connected(&self, reconnected: bool) {
+        if reconnected {
+            info!("Container runtime reconnected, reconciling container state");
+        }
+
+        match self.reconcile().await {
+            Ok(summary) => {
+                if summary.started > 0 || summary.adopted > 0 || summary.missing > 0 {
+                    info!(
+                        "Container reconciliation: checked {}, restarted {}, adopted {}, missing {}, \
+                         redeployed {} environments",
+                        summary.checked,
+                        summary.started,
+                        summary.adopted,
+                        summary.missing,
+                        summary.redeployed
+                    );
+                } else {
+                    debug!(
+                        "Container reconciliation: {} containers in sync",
+                        summary.checked
+                    );
+                }
+            }
+            Err(e) => error!("Container reconciliation failed: {}", e),
+        }
+    }
+
+    async fn on_event(&self, event: RuntimeEvent) {
+        match event {
+            RuntimeEvent::ContainerDied {
+                container_id,
+                labels,
+            } => {
+                debug!(
+                    "Managed container {} died (deployment {:?})",
+                    container_id,
+                    deployment_id_from_labels(&labels)
+                );
+            }
+            RuntimeEvent::ContainerStarted { container_id, .. } => {
+                debug!("Managed container {} started", container_id);
+            }
+            RuntimeEvent::ContainerDestroyed { container_id, .. } => {
+                debug!("Managed container {} destroyed", container_id);
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use temps_deployer::labels::deployment_labels;
+
+    fn desired(record_id: i32, deployment_id: i32, id: &str, name: &str) -> DesiredContainer {
+        DesiredContainer {
+            record_id,
+            project_id: 1,
+            environment_id: deployment_id * 100,
+            deployment_id,
+            container_id: id.to_string(),
+            container_name: name.to_string(),
+        }
+    }
+
+    fn actual(
+        id: &str,
+        name: &str,
+        deployment_id: i32,
+        status: ContainerStatus,
+    ) -> ManagedContainer {
+        ManagedContainer {
+            container_id: id.to_string(),
+            container_name: name.to_string(),
+            status,
+            labels: deployment_labels(1, 1, deployment_id),
+        }
+    }
+
+    #[test]
+    fn test_in_sync_produces_no_actions() {
+        let actions = plan_reconciliation(
+            &[desired(1, 10, "abc", "app")],
+            &[actual("abc", "app", 10, ContainerStatus::Running)],
+        );
+        assert!(actions.is_empty());
+    }
+
+    #[test]
+    fn test_stopped_container_is_started() {
+        let actions = plan_reconciliation(
+            &[desired(1, 10, "abc", "app")],
+            &[actual("abc", "app", 10, ContainerStatus::Exited)],
+        );
+        assert_eq!(
+            actions,
+            vec![ReconcileAction::Start {
+                record_id: 1,
+                container_id: "abc".to_string()
+            }]
+        );
+    }
+
+    #[test]
+    fn test_containers_recorded_as_stopped_stay_stopped() {
+        assert!(should_run(None));
+        assert!(should_run(Some("running")));
+        assert!(should_run(Some("missing")));
+        assert!(!should_run(Some("stopped")));
+        assert!(!should_run(Some("removed")));
+    }
+
+    #[test]
+    fn test_recreated_container_is_adopted_by_label() {
+        let actions = plan_reconciliation(
+            &[desired(1, 10, "old-id", "app")],
+            &[actual("new-id", "app", 10, ContainerStatus::Stopped)],
+        );
+        assert_eq!(
+            actions,
+            vec![
+                ReconcileAction::Adopt {
+                    record_id: 1,
+                    container_id: "new-id".to_string()
+                },
+                ReconcileAction::Start {
+                    record_id: 1,
+                    container_id: "new-id".to_string()
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn test_container_from_other_deployment_is_not_adopted() {
+        let actions = plan_reconciliation(
+            &[desired(1, 10, "old-id", "app")],
+            &[actual("new-id", "app", 11, ContainerStatus::Running)],
+        );
+        assert_eq!(
+            actions,
+            vec![ReconcileAction::Missing {
+                record_id: 1,
+                container_name: "app".to_string()
+            }]
+        );
+    }
+
+    #[test]
+    fn test_missing_containers_redeploy_their_environment_once() {
+        let desired = [
+            desired(1, 10, "web-1", "web-1"),
+            desired(2, 10, "web-2", "web-2"),
+            desired(3, 20, "api-1", "api-1"),
+        ];
+        let actions = plan_reconciliation(&desired, &[]);
+        assert_eq!(actions.len(), 3);
+
+        assert_eq!(
+            plan_redeploys(&desired, &actions, &HashSet::new()),
+            vec![
+                Redeploy {
+                    project_id: 1,
+                    environment_id: 1000,
+                    deployment_id: 10
+                },
+                Redeploy {
+                    project_id: 1,
+                    environment_id: 2000,
+                    deployment_id: 20
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn test_environment_with_deployment_in_progress_is_not_redeployed() {
+        let desired = [
+            desired(1, 10, "web-1", "web-1"),
+            desired(2, 20, "api-1", "api-1"),
+        ];
+        let actions = plan_reconciliation(
+            &desired,
+            &[actual("api-1", "api-1", 20, ContainerStatus::Running)],
+        );
+
+        assert!(plan_redeploys(&desired, &actions, &HashSet::from([1000])).is_empty());
+    }
+}
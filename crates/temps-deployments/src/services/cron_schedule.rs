@@ -0,0 +1,123 @@
+//! Cron Schedules in a Timezone
+//!
+//! Schedules are wall-clock times in the cron's timezone: `0 2 * * *` runs at 02:00
+//! local time whether the zone is on standard or daylight saving time. The two days a
+//! year the clocks change are handled like cron does:
+//!
+//! - a time skipped when the clocks go forward runs when they jump, e.g. 02:30 runs
+//!   at 03:00;
+//! - a time that happens twice when the clocks go back runs once, the first time.
+
+use chrono::{DateTime, Duration, LocalResult, NaiveDateTime, TimeZone, Utc};
+use chrono_tz::Tz;
+use cron::Schedule;
+
+/// Longest clocks go forward at once, in minutes
+const MAX_DST_GAP_MINUTES: i64 = 180;
+
+/// Wall-clock times looked at before giving up on a next run; times that fall in an
+/// hour repeated by a change to standard time are skipped
+const MAX_SCHEDULE_CANDIDATES: usize = 1024;
+
+/// Parse an IANA timezone name, e.g. `Europe/Madrid`
+pub fn parse_cron_timezone(timezone: &str) -> Result<Tz, String> {
+    timezone.trim().parse::<Tz>().map_err(|_| {
+        format!(
+            "'{}' is not an IANA timezone, such as Europe/Madrid or America/New_York",
+            timezone
+        )
+    })
+}
+
+/// First time the schedule fires after the instant, evaluated in the timezone
+pub fn next_run_after(
+    schedule: &Schedule,
+    timezone: Tz,
+    after: DateTime<Utc>,
+) -> Option<DateTime<Utc>> {
+    // Walk the schedule over wall-clock times, which have no offsets to change
+    let wall_clock = Utc.from_utc_datetime(&after.with_timezone(&timezone).naive_local());
+    schedule
+        .after(&wall_clock)
+        .take(MAX_SCHEDULE_CANDIDATES)
+        .filter_map(|wall_time| local_instant(timezone, wall_time.naive_utc()))
+        .find(|instant| *instant > after)
+}
+
+/// Instant a wall-clock time of the timezone stands for
+fn local_instant(timezone: Tz, wall_time: NaiveDateTime) -> Option<DateTime<Utc>> {
+    let instant = match timezone.from_local_datetime(&wall_time) {
+        LocalResult::Single(instant) => Some(instant),
+        // Repeated when the clocks go back: the first one
+        LocalResult::Ambiguous(earliest, _) => Some(earliest),
+        // Skipped when the clocks go forward: when they jump
+        LocalResult::None => (1..=MAX_DST_GAP_MINUTES).find_map(|minutes| {
+            timezone
+                .from_local_datetime(&(wall_time + Duration::minutes(minutes)))
+                .earliest()
+        }),
+    };
+    instant.map(|instant| instant.with_timezone(&Utc))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::str::FromStr;
+
+    fn utc(timestamp: &str) -> DateTime<Utc> {
+        DateTime::parse_from_rfc3339(timestamp)
+            .unwrap()
+            .with_timezone(&Utc)
+    }
+
+    #[test]
+    fn test_schedules_follow_the_local_clock() {
+        let nightly = Schedule::from_str("0 0 2 * * *").unwrap();
+        let madrid = parse_cron_timezone("Europe/Madrid").unwrap();
+
+        // 02:00 in Madrid is 00:00 UTC in summer and 01:00 UTC in winter
+        assert_eq!(
+            next_run_after(&nightly, madrid, utc("2026-07-01T12:00:00Z")),
+            Some(utc("2026-07-02T00:00:00Z"))
+        );
+        assert_eq!(
+            next_run_after(&nightly, madrid, utc("2026-12-01T12:00:00Z")),
+            Some(utc("2026-12-02T01:00:00Z"))
+        );
+        assert!(parse_cron_timezone("Mars/Olympus_Mons").is_err());
+    }
+
+    #[test]
+    fn test_times_skipped_by_the_clocks_going_forward_run_when_they_jump() {
+        // Madrid skips 02:00-03:00 on 29 March 2026
+        let schedule = Schedule::from_str("0 30 2 * * *").unwrap();
+        let madrid = parse_cron_timezone("Europe/Madrid").unwrap();
+
+        assert_eq!(
+            next_run_after(&schedule, madrid, utc("2026-03-28T12:00:00Z")),
+            // 03:00 CEST
+            Some(utc("2026-03-29T01:00:00Z"))
+        );
+        assert_eq!(
+            next_run_after(&schedule, madrid, utc("2026-03-29T01:00:00Z")),
+            Some(utc("2026-03-30T00:30:00Z"))
+        );
+    }
+
+    #[test]
+    fn test_times_repeated_by_the_clocks_going_back_run_once() {
+        // New York repeats 01:00-02:00 on 1 November 2026
+        let schedule = Schedule::from_str("0 30 1 * * *").unwrap();
+        let new_york = parse_cron_timezone("America/New_York").unwrap();
+
+        // 01:30 EDT
+        let first = next_run_after(&schedule, new_york, utc("2026-10-31T12:00:00Z")).unwrap();
+        assert_eq!(first, utc("2026-11-01T05:30:00Z"));
+        // Not again at 01:30 EST, but the next day
+        assert_eq!(
+            next_run_after(&schedule, new_york, first),
+            Some(utc("2026-11-02T06:30:00Z"))
+        );
+    }
+}
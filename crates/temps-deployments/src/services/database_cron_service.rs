@@ -4,6 +4,7 @@
 
 use async_trait::async_trait;
 use chrono::{DateTime, Timelike as _, Utc};
+use chrono_tz::Tz;
 use cron::Schedule;
 use sea_orm::{
     ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, PaginatorTrait, QueryFilter,
@@ -19,6 +20,7 @@ use thiserror::Error;
 use tokio::time::{self, Duration};
 use tracing::{debug, error, info, warn};
 
+use super::cron_schedule::{next_run_after, parse_cron_timezone};
 use crate::jobs::configure_crons::{CronConfig, CronConfigError, CronConfigService};
 
 #[derive(Error, Debug)]
@@ -71,6 +73,8 @@ pub struct DatabaseCronConfigService {
     db: Arc<DatabaseConnection>,
     http_client: Arc<reqwest::Client>,
     queue: Arc<dyn temps_core::JobQueue>,
+    /// Source of the default cron timezone; UTC without it
+    config_service: Option<Arc<temps_config::ConfigService>>,
 }
 
 impl DatabaseCronConfigService {
@@ -79,9 +83,42 @@ impl DatabaseCronConfigService {
             db,
             http_client: Arc::new(reqwest::Client::new()),
             queue,
+            config_service: None,
         }
     }
 
+    pub fn with_config_service(mut self, config_service: Arc<temps_config::ConfigService>) -> Self {
+        self.config_service = Some(config_service);
+        self
+    }
+
+    /// Timezone of the crons that don't set their own
+    pub async fn default_timezone(&self) -> Tz {
+        let Some(config_service) = &self.config_service else {
+            return Tz::UTC;
+        };
+        match config_service.get_settings().await {
+            Ok(settings) => {
+                parse_cron_timezone(&settings.crons.default_timezone).unwrap_or_else(|e| {
+                    warn!("Invalid default cron timezone, using UTC: {}", e);
+                    Tz::UTC
+                })
+            }
+            Err(e) => {
+                warn!("Failed to load cron settings, using UTC: {}", e);
+                Tz::UTC
+            }
+        }
+    }
+
+    /// Timezone a cron's schedule is evaluated in
+    pub fn cron_timezone(cron: &crons::Model, default_timezone: Tz) -> Tz {
+        cron.timezone
+            .as_deref()
+            .and_then(|timezone| parse_cron_timezone(timezone).ok())
+            .unwrap_or(default_timezone)
+    }
+
     /// Validate a cron schedule expression
     fn validate_cron_schedule(schedule: &str) -> Result<(), CronConfigError> {
         let schedule_str = schedule.to_string();
@@ -110,13 +147,13 @@ impl DatabaseCronConfigService {
         Ok(())
     }
 
-    /// Calculate the next run time for a cron schedule
-    fn calculate_next_run(schedule: &str) -> Result<UtcDateTime, CronConfigError> {
+    /// Calculate the next run time for a cron schedule in a timezone
+    fn calculate_next_run(schedule: &str, timezone: Tz) -> Result<UtcDateTime, CronConfigError> {
         let parsed_schedule = cron::Schedule::from_str(schedule).map_err(|e| {
             CronConfigError::InvalidSchedule(format!("Invalid cron expression: {}", e))
         })?;
 
-        let next_run = parsed_schedule.upcoming(Utc).next().ok_or_else(|| {
+        let next_run = next_run_after(&parsed_schedule, timezone, Utc::now()).ok_or_else(|| {
             CronConfigError::InvalidSchedule("No upcoming execution time".to_string())
         })?;
 
@@ -142,7 +179,13 @@ impl CronConfigService for DatabaseCronConfigService {
         // Validate all cron schedules first
         for cron in &cron_configs {
             Self::validate_cron_schedule(&cron.schedule)?;
+            if let Some(timezone) = &cron.timezone {
+                parse_cron_timezone(timezone).map_err(|e| {
+                    CronConfigError::ConfigError(format!("Cron job '{}': {}", cron.path, e))
+                })?;
+            }
         }
+        let default_timezone = self.default_timezone().await;
 
         // Fetch existing crons for this project/environment
         let existing_crons = crons::Entity::find()
@@ -193,21 +236,38 @@ impl CronConfigService for DatabaseCronConfigService {
 
         // Process each cron from the repo
         for cron_config in cron_configs {
+            let timezone = cron_config
+                .timezone
+                .as_deref()
+                .and_then(|timezone| parse_cron_timezone(timezone).ok())
+                .unwrap_or(default_timezone);
+
             // Check if cron already exists
             let existing_cron = existing_crons.iter().find(|c| c.path == cron_config.path);
 
             match existing_cron {
-                Some(cron) if cron.schedule != cron_config.schedule => {
+                Some(cron)
+                    if cron.schedule != cron_config.schedule
+                        || cron.timezone != cron_config.timezone =>
+                {
                     // Update schedule if it changed
                     info!(
-                        "Updating cron job '{}': schedule changed from '{}' to '{}'",
-                        cron.path, cron.schedule, cron_config.schedule
+                        "Updating cron job '{}': schedule changed from '{}' ({}) to '{}' ({})",
+                        cron.path,
+                        cron.schedule,
+                        cron.timezone.as_deref().unwrap_or("default timezone"),
+                        cron_config.schedule,
+                        cron_config
+                            .timezone
+                            .as_deref()
+                            .unwrap_or("default timezone")
                     );
 
-                    let next_run = Self::calculate_next_run(&cron_config.schedule)?;
+                    let next_run = Self::calculate_next_run(&cron_config.schedule, timezone)?;
 
                     let mut cron_update: crons::ActiveModel = cron.clone().into();
                     cron_update.schedule = Set(cron_config.schedule.clone());
+                    cron_update.timezone = Set(cron_config.timezone.clone());
                     cron_update.updated_at = Set(Utc::now());
                     cron_update.next_run = Set(Some(next_run));
                     cron_update.update(self.db.as_ref()).await.map_err(|e| {
@@ -229,7 +289,7 @@ impl CronConfigService for DatabaseCronConfigService {
                     // Create new cron
                     info!("Creating new cron job '{}'", cron_config.path);
 
-                    let next_run = Self::calculate_next_run(&cron_config.schedule)?;
+                    let next_run = Self::calculate_next_run(&cron_config.schedule, timezone)?;
                     let now = Utc::now();
 
                     let new_cron = crons::ActiveModel {
@@ -241,6 +301,7 @@ impl CronConfigService for DatabaseCronConfigService {
                         updated_at: Set(now),
                         next_run: Set(Some(next_run)),
                         deleted_at: Set(None),
+                        timezone: Set(cron_config.timezone.clone()),
                         ..Default::default()
                     };
 
@@ -340,6 +401,7 @@ impl DatabaseCronConfigService {
 
     pub async fn start_cron_scheduler(&self) {
         debug!("Starting cron scheduler");
+        let mut last_default_timezone = None;
 
         loop {
             let now = Utc::now();
@@ -357,6 +419,18 @@ impl DatabaseCronConfigService {
                 continue;
             }
 
+            // Crons without a timezone of their own follow the default one
+            let default_timezone = self.default_timezone().await;
+            if last_default_timezone.is_some_and(|last| last != default_timezone) {
+                if let Err(e) = self
+                    .reschedule_default_timezone_crons(default_timezone, now)
+                    .await
+                {
+                    error!("Failed to reschedule crons to {}: {}", default_timezone, e);
+                }
+            }
+            last_default_timezone = Some(default_timezone);
+
             // Use a block to ensure db connection is dropped after use
             let crons_list = match crons::Entity::find().all(self.db.as_ref()).await {
                 Ok(crons_list) => crons_list,
@@ -371,7 +445,7 @@ impl DatabaseCronConfigService {
             for chunk in crons_list.chunks(10) {
                 let futures: Vec<_> = chunk
                     .iter()
-                    .map(|cron| self.process_cron(cron, now))
+                    .map(|cron| self.process_cron(cron, now, default_timezone))
                     .collect();
 
                 let results = futures::future::join_all(futures).await;
@@ -393,10 +467,40 @@ impl DatabaseCronConfigService {
         }
     }
 
+    /// Recalculate the next run of the crons in the default timezone after it changed
+    async fn reschedule_default_timezone_crons(
+        &self,
+        default_timezone: Tz,
+        now: DateTime<Utc>,
+    ) -> Result<(), CronServiceError> {
+        let crons_list = crons::Entity::find()
+            .filter(crons::Column::Timezone.is_null())
+            .filter(crons::Column::DeletedAt.is_null())
+            .all(self.db.as_ref())
+            .await?;
+        info!(
+            "Default cron timezone changed to {}, rescheduling {} cron(s)",
+            default_timezone,
+            crons_list.len()
+        );
+
+        for cron in crons_list {
+            let Ok(schedule) = Schedule::from_str(&cron.schedule) else {
+                continue;
+            };
+            let next_run = next_run_after(&schedule, default_timezone, now);
+            let mut cron_update: crons::ActiveModel = cron.into();
+            cron_update.next_run = Set(next_run);
+            cron_update.update(self.db.as_ref()).await?;
+        }
+        Ok(())
+    }
+
     async fn process_cron(
         &self,
         cron: &crons::Model,
         now: DateTime<Utc>,
+        default_timezone: Tz,
     ) -> Result<(), CronServiceError> {
         // Skip if cron is deleted
         if cron.deleted_at.is_some() {
@@ -408,13 +512,14 @@ impl DatabaseCronConfigService {
                 schedule: cron.schedule.clone(),
                 message: e.to_string(),
             })?;
+        let timezone = Self::cron_timezone(cron, default_timezone);
         let next_run = cron.next_run;
 
         let should_run = match next_run {
             Some(next) => next <= now,
             None => {
                 // If next_run is not set, calculate it from the schedule
-                if let Some(next) = schedule.upcoming(Utc).next() {
+                if let Some(next) = next_run_after(&schedule, timezone, now) {
                     next <= now
                 } else {
                     false
@@ -424,7 +529,7 @@ impl DatabaseCronConfigService {
 
         if should_run {
             // Calculate the next run time
-            let next_run = schedule.upcoming(Utc).next();
+            let next_run = next_run_after(&schedule, timezone, now);
 
             // Update the next_run time in the database
             if let Some(next_run) = next_run {
@@ -659,7 +764,7 @@ mod tests {
     #[test]
     fn test_calculate_next_run() {
         // Should return a future timestamp
-        let next_run = DatabaseCronConfigService::calculate_next_run("0 0 * * * *");
+        let next_run = DatabaseCronConfigService::calculate_next_run("0 0 * * * *", Tz::UTC);
         assert!(next_run.is_ok());
 
         let next_run_time = next_run.unwrap();
@@ -681,6 +786,7 @@ mod tests {
         let configs = vec![CronConfig {
             path: "/api/cron/cleanup".to_string(),
             schedule: "0 0 * * * *".to_string(),
+            timezone: None,
         }];
 
         service
@@ -717,6 +823,7 @@ mod tests {
         let configs = vec![CronConfig {
             path: "/api/cron/task".to_string(),
             schedule: "0 0 * * * *".to_string(),
+            timezone: None,
         }];
         service
             .configure_crons(project.id, environment.id, configs)
@@ -726,6 +833,7 @@ mod tests {
         let updated_configs = vec![CronConfig {
             path: "/api/cron/task".to_string(),
             schedule: "0 */5 * * * *".to_string(),
+            timezone: None,
         }];
         service
             .configure_crons(project.id, environment.id, updated_configs)
@@ -745,6 +853,57 @@ mod tests {
         Ok(())
     }
 
+    #[tokio::test]
+    async fn test_configure_crons_timezone() -> Result<(), Box<dyn std::error::Error>> {
+        let test_db = TestDatabase::with_migrations().await?;
+        let db = test_db.connection_arc();
+        let queue = Arc::new(MockQueue);
+
+        let (project, environment) = create_test_project_and_environment(db.as_ref()).await?;
+
+        let service = DatabaseCronConfigService::new(db.clone(), queue);
+
+        let nightly = |timezone: Option<&str>| {
+            vec![CronConfig {
+                path: "/api/cron/nightly".to_string(),
+                schedule: "0 0 2 * * *".to_string(),
+                timezone: timezone.map(str::to_string),
+            }]
+        };
+        service
+            .configure_crons(project.id, environment.id, nightly(None))
+            .await?;
+
+        // Moving the same schedule to another timezone reschedules it
+        service
+            .configure_crons(
+                project.id,
+                environment.id,
+                nightly(Some("America/New_York")),
+            )
+            .await?;
+        let cron = crons::Entity::find()
+            .filter(crons::Column::ProjectId.eq(project.id))
+            .filter(crons::Column::DeletedAt.is_null())
+            .one(db.as_ref())
+            .await?
+            .unwrap();
+        assert_eq!(cron.timezone.as_deref(), Some("America/New_York"));
+        let next_run = cron
+            .next_run
+            .unwrap()
+            .with_timezone(&chrono_tz::America::New_York);
+        assert_eq!(chrono::Timelike::hour(&next_run), 2);
+
+        // Unknown timezones are rejected
+        let result = service
+            .configure_crons(project.id, environment.id, nightly(Some("Nowhere/City")))
+            .await;
+        assert!(matches!(result, Err(CronConfigError::ConfigError(_))));
+
+        Ok(())
+    }
+
     #[tokio::test]
     async fn test_configure_crons_delete_removed() -> Result<(), Box<dyn std::error::Error>> {
         let test_db = TestDatabase::with_migrations().await?;
@@ -761,10 +920,12 @@ mod tests {
             CronConfig {
                 path: "/api/cron/task1".to_string(),
                 schedule: "0 0 * * * *".to_string(),
+                timezone: None,
             },
             CronConfig {
                 path: "/api/cron/task2".to_string(),
                 schedule: "0 0 * * * *".to_string(),
+                timezone: None,
             },
         ];
         service
@@ -775,6 +936,7 @@ mod tests {
         let updated_configs = vec![CronConfig {
             path: "/api/cron/task1".to_string(),
             schedule: "0 0 * * * *".to_string(),
+            timezone: None,
         }];
         service
             .configure_crons(project.id, environment.id, updated_configs)
@@ -0,0 +1,110 @@
+//! Deploy Gates
+//!
+//! Records the verdict an external system posts back for a deployment waiting on its
+//! deploy gate (see `jobs::deploy_gate`, which waits for it). The callback proves itself
+//! with the token sent in the gate's request; a verdict can only be given once.
+
+use std::sync::Arc;
+
+use chrono::Utc;
+use sea_orm::{ActiveModelTrait, EntityTrait, Set};
+use serde::Deserialize;
+use temps_database::DbConnection;
+use temps_entities::deployments::{self, DeployGateReport, DeployGateState};
+use tracing::info;
+use utoipa::ToSchema;
+
+use super::DeploymentError;
+use crate::jobs::hash_callback_token;
+
+/// Verdict posted to a deploy gate's callback URL
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct DeployGateVerdict {
+    /// `passed` or `failed` (also `success`, `failure`, ...); `pending` only updates
+    /// the message and details URL
+    #[schema(example = "passed")]
+    pub status: String,
+    /// Explanation shown on the deployment
+    #[serde(default)]
+    #[schema(example = "412 end-to-end tests passed")]
+    pub message: Option<String>,
+    /// Link to the external run
+    #[serde(default)]
+    #[schema(example = "https://ci.example.com/runs/7")]
+    pub details_url: Option<String>,
+}
+
+pub struct DeployGateService {
+    db: Arc<DbConnection>,
+}
+
+impl DeployGateService {
+    pub fn new(db: Arc<DbConnection>) -> Self {
+        Self { db }
+    }
+
+    /// Record the verdict of a deployment's pending deploy gate
+    ///
+    /// A token that doesn't belong to the deployment's gate gets the same answer as a
+    /// deployment without one, so callers can't probe for deployments.
+    pub async fn record_verdict(
+        &self,
+        deployment_id: i32,
+        token: &str,
+        verdict: DeployGateVerdict,
+    ) -> Result<DeployGateReport, DeploymentError> {
+        let state = DeployGateState::from_status(&verdict.status).ok_or_else(|| {
+            DeploymentError::InvalidInput(format!(
+                "Unknown deploy gate status '{}'; use 'passed' or 'failed'",
+                verdict.status
+            ))
+        })?;
+
+        let not_found = || {
+            DeploymentError::NotFound(format!(
+                "Deployment {} has no deploy gate for this token",
+                deployment_id
+            ))
+        };
+        let deployment = deployments::Entity::find_by_id(deployment_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(not_found)?;
+        let mut metadata = deployment.metadata.clone().unwrap_or_default();
+        let report = metadata
+            .deploy_gate
+            .as_mut()
+            .filter(|report| {
+                report.callback_token_hash.as_deref() == Some(hash_callback_token(token).as_str())
+            })
+            .ok_or_else(not_found)?;
+        if report.state != DeployGateState::Pending {
+            return Err(DeploymentError::InvalidDeploymentState(format!(
+                "The deploy gate of deployment {} already has a verdict",
+                deployment_id
+            )));
+        }
+
+        if verdict.message.is_some() {
+            report.message = verdict.message;
+        }
+        if verdict.details_url.is_some() {
+            report.details_url = verdict.details_url;
+        }
+        if state != DeployGateState::Pending {
+            report.state = state;
+            report.completed_at = Some(Utc::now());
+        }
+        let report = report.clone();
+
+        let mut active_deployment: deployments::ActiveModel = deployment.into();
+        active_deployment.metadata = Set(Some(metadata));
+        active_deployment.update(self.db.as_ref()).await?;
+
+        info!(
+            "Deploy gate of deployment {} reported {:?}",
+            deployment_id, report.state
+        );
+        Ok(report)
+    }
+}
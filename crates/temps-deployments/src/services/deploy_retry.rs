@@ -0,0 +1,200 @@
+//! Deploy Retries
+//!
+//! Decides whether a failed deploy is worth running again. Failures are classified by
+//! their message: network, registry and timeout errors are transient and may succeed
+//! on another attempt, anything else (a compile error, a failed smoke test) would fail
+//! the same way and is permanent. Messages matching no pattern are permanent, so an
+//! unknown failure is never retried. Operators extend both lists under the
+//! `deploy_retry` settings; permanent patterns are checked first.
+
+use temps_core::DeployRetrySettings;
+
+/// Failure messages retried without any settings, matched case-insensitively
+pub const TRANSIENT_FAILURE_PATTERNS: &[&str] = &[
+    "timed out",
+    "timeout",
+    "deadline exceeded",
+    "connection reset",
+    "connection refused",
+    "connection closed",
+    "broken pipe",
+    "unexpected eof",
+    "network is unreachable",
+    "no route to host",
+    "temporary failure in name resolution",
+    "no such host",
+    "server misbehaving",
+    "econnreset",
+    "econnrefused",
+    "etimedout",
+    "eai_again",
+    "tls handshake",
+    "toomanyrequests",
+    "too many requests",
+    "502 bad gateway",
+    "503 service unavailable",
+    "504 gateway timeout",
+    "service unavailable",
+    "failed to pull",
+    "error pulling image",
+    "failed to push",
+    "failed to fetch",
+    "failed to resolve source metadata",
+];
+
+/// Failure messages never retried, even when a transient pattern also matches
+pub const PERMANENT_FAILURE_PATTERNS: &[&str] = &[
+    "error[e",
+    "syntaxerror",
+    "syntax error",
+    "compilation failed",
+    "failed to compile",
+    "could not compile",
+    "type error",
+    "cannot find module",
+    "module not found",
+    "dockerfile parse error",
+    "invalid reference format",
+    "manifest unknown",
+    "unauthorized",
+    "authentication required",
+    "access denied",
+    "no space left on device",
+    "smoke test",
+    "deploy gate failed",
+    "invalid job config",
+];
+
+/// Whether a failure may go away on another attempt
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum FailureClass {
+    Transient,
+    Permanent,
+}
+
+/// Classifies deploy failures from the built-in patterns and the operator's
+#[derive(Debug, Clone)]
+pub struct FailureClassifier {
+    transient: Vec<String>,
+    permanent: Vec<String>,
+}
+
+impl FailureClassifier {
+    pub fn new(settings: &DeployRetrySettings) -> Self {
+        Self {
+            transient: patterns(TRANSIENT_FAILURE_PATTERNS, &settings.transient_patterns),
+            permanent: patterns(PERMANENT_FAILURE_PATTERNS, &settings.permanent_patterns),
+        }
+    }
+
+    pub fn classify(&self, message: &str) -> FailureClass {
+        let message = message.to_lowercase();
+        if self
+            .permanent
+            .iter()
+            .any(|pattern| message.contains(pattern.as_str()))
+        {
+            return FailureClass::Permanent;
+        }
+        if self
+            .transient
+            .iter()
+            .any(|pattern| message.contains(pattern.as_str()))
+        {
+            FailureClass::Transient
+        } else {
+            FailureClass::Permanent
+        }
+    }
+}
+
+impl Default for FailureClassifier {
+    fn default() -> Self {
+        Self::new(&DeployRetrySettings::default())
+    }
+}
+
+fn patterns(built_in: &[&str], extra: &[String]) -> Vec<String> {
+    built_in
+        .iter()
+        .map(|pattern| pattern.to_string())
+        .chain(
+            extra
+                .iter()
+                .map(|pattern| pattern.trim().to_lowercase())
+                .filter(|pattern| !pattern.is_empty()),
+        )
+        .collect()
+}
+
+/// Whether a workflow failure came from cancelling the deployment
+pub fn is_cancellation(message: &str) -> bool {
+    message.contains("cancelled") || message.contains("Cancelled")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_network_and_registry_failures_are_transient() {
+        let classifier = FailureClassifier::default();
+        for message in [
+            "Job execution failed: Failed to clone repository: Connection reset by peer",
+            "Job execution failed: Build failed: failed to resolve source metadata for \
+             docker.io/library/node:20: dial tcp: lookup registry-1.docker.io: Temporary \
+             failure in name resolution",
+            "Job execution failed: Failed to pull image: toomanyrequests: You have reached \
+             your pull rate limit",
+            "Job execution failed: Failed to push image: net/http: TLS handshake timeout",
+        ] {
+            assert_eq!(
+                classifier.classify(message),
+                FailureClass::Transient,
+                "{}",
+                message
+            );
+        }
+    }
+
+    #[test]
+    fn test_deterministic_failures_are_never_retried() {
+        let classifier = FailureClassifier::default();
+        for message in [
+            "Job execution failed: Build failed: error[E0425]: cannot find value `x`",
+            "Job execution failed: Build failed: Failed to compile. Module not found",
+            "Job execution failed: 2 of 3 smoke test(s) failed",
+            // A timeout inside a compile error is still a compile error
+            "Job execution failed: SyntaxError: Unexpected token in timeout.js",
+            // Unknown failures aren't retried
+            "Job execution failed: Build failed: exit code: 1",
+        ] {
+            assert_eq!(
+                classifier.classify(message),
+                FailureClass::Permanent,
+                "{}",
+                message
+            );
+        }
+    }
+
+    #[test]
+    fn test_operator_patterns_extend_classification() {
+        let classifier = FailureClassifier::new(&DeployRetrySettings {
+            transient_patterns: vec!["  Artifactory Is Rebooting ".to_string(), String::new()],
+            permanent_patterns: vec!["quota exceeded".to_string()],
+        });
+        assert_eq!(
+            classifier.classify("Build failed: artifactory is rebooting, try later"),
+            FailureClass::Transient
+        );
+        assert_eq!(
+            classifier.classify("Failed to push: quota exceeded"),
+            FailureClass::Permanent
+        );
+        assert_eq!(
+            classifier.classify("Failed to push: connection refused"),
+            FailureClass::Transient
+        );
+    }
+}
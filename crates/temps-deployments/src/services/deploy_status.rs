@@ -0,0 +1,297 @@
+//! Deploy Status
+//!
+//! Outcome of a deployment for clients waiting on it, such as a CI pipeline. The
+//! deployment's state is reduced to whether it is still going and how it ended; a
+//! failed deployment also gets a stable reason code, taken from the job that failed
+//! and, where the job alone doesn't tell, its error message. Codes are part of the
+//! API: new ones may be added, existing ones keep their meaning.
+
+use serde::Serialize;
+use temps_entities::deployment_jobs;
+use temps_entities::types::JobStatus;
+use utoipa::ToSchema;
+
+use super::deploy_retry::is_cancellation;
+
+/// How far a deployment got
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum DeployOutcome {
+    /// Waiting to start
+    Pending,
+    Running,
+    /// Finished; a dry run that built and checked the app also succeeded
+    Succeeded,
+    Failed,
+    Cancelled,
+}
+
+impl DeployOutcome {
+    /// Outcome of a deployment in the given state
+    ///
+    /// Deployments are only stopped or paused after they went live, so those count as
+    /// succeeded. Unknown states count as running, so clients keep waiting rather
+    /// than report an outcome that may not hold.
+    pub fn from_state(state: &str) -> Self {
+        match state {
+            "pending" | "queued" => DeployOutcome::Pending,
+            "completed" | "deployed" | "built" | "stopped" | "paused" => DeployOutcome::Succeeded,
+            "failed" => DeployOutcome::Failed,
+            "cancelled" => DeployOutcome::Cancelled,
+            _ => DeployOutcome::Running,
+        }
+    }
+
+    /// Whether the deployment is over, and won't change outcome again
+    pub fn is_finished(&self) -> bool {
+        matches!(
+            self,
+            DeployOutcome::Succeeded | DeployOutcome::Failed | DeployOutcome::Cancelled
+        )
+    }
+}
+
+/// Why a deployment failed
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum DeployFailureReason {
+    /// Cancelled by a user, or because its environment was deleted
+    Cancelled,
+    /// Ran past the deploy timeout
+    TimedOut,
+    /// The repository or artifact couldn't be fetched
+    SourceFetchFailed,
+    BuildFailed,
+    /// The image scan found vulnerabilities over the allowed severity
+    VulnerabilityScanFailed,
+    /// The containers couldn't be started
+    DeployFailed,
+    /// The containers started but the app never became healthy
+    HealthCheckFailed,
+    SmokeTestFailed,
+    DeployGateFailed,
+    /// Failed in a step with no more specific code
+    Unknown,
+}
+
+/// Error messages of a deploy job that mean the app was started but didn't come up
+const HEALTH_CHECK_FAILURE_PATTERNS: &[&str] = &[
+    "health check",
+    "startup probe",
+    "didn't start",
+    "connectivity checks",
+    "crashed during startup",
+    "exited on start",
+    "failed to start",
+    "too long to start",
+];
+
+impl DeployFailureReason {
+    /// Reason a job of the given type failed with the given error
+    fn of_job(job_type: &str, error: &str) -> Self {
+        match job_type {
+            "DownloadRepoJob" | "FetchArtifactJob" => DeployFailureReason::SourceFetchFailed,
+            "BuildImageJob" | "BuildStaticJob" => DeployFailureReason::BuildFailed,
+            "ScanVulnerabilitiesJob" => DeployFailureReason::VulnerabilityScanFailed,
+            "DeployImageJob" | "DeployContainerJob" | "DeployStaticJob" | "DeployBasicJob" => {
+                let error = error.to_lowercase();
+                if HEALTH_CHECK_FAILURE_PATTERNS
+                    .iter()
+                    .any(|pattern| error.contains(pattern))
+                {
+                    DeployFailureReason::HealthCheckFailed
+                } else {
+                    DeployFailureReason::DeployFailed
+                }
+            }
+            "HealthCheckJob" => DeployFailureReason::HealthCheckFailed,
+            "SmokeTestJob" => DeployFailureReason::SmokeTestFailed,
+            "DeployGateJob" => DeployFailureReason::DeployGateFailed,
+            _ => DeployFailureReason::Unknown,
+        }
+    }
+}
+
+/// Why a deployment failed, and the job it failed in
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DeployFailure {
+    pub reason: DeployFailureReason,
+    /// Job that failed; None when the deployment failed outside of its jobs
+    pub job_id: Option<String>,
+    pub message: Option<String>,
+}
+
+/// Why a deployment failed or was cancelled; None while it runs and once it succeeded
+///
+/// `jobs` are the deployment's jobs in execution order; the first one that failed is
+/// the one reported.
+pub fn deploy_failure(
+    outcome: DeployOutcome,
+    cancelled_reason: Option<&str>,
+    jobs: &[deployment_jobs::Model],
+) -> Option<DeployFailure> {
+    let message = cancelled_reason.map(str::to_string);
+    match outcome {
+        DeployOutcome::Cancelled => {
+            return Some(DeployFailure {
+                reason: DeployFailureReason::Cancelled,
+                job_id: None,
+                message,
+            })
+        }
+        DeployOutcome::Failed => {}
+        _ => return None,
+    }
+
+    // A timeout fails whichever job was running when it hit
+    if let Some(reason) = cancelled_reason {
+        if reason.starts_with("Deploy timed out") {
+            return Some(DeployFailure {
+                reason: DeployFailureReason::TimedOut,
+                job_id: None,
+                message,
+            });
+        }
+        if is_cancellation(reason) {
+            return Some(DeployFailure {
+                reason: DeployFailureReason::Cancelled,
+                job_id: None,
+                message,
+            });
+        }
+    }
+
+    let failed_job = jobs.iter().find(|job| job.status == JobStatus::Failure);
+    Some(match failed_job {
+        Some(job) => DeployFailure {
+            reason: DeployFailureReason::of_job(
+                &job.job_type,
+                job.error_message.as_deref().unwrap_or_default(),
+            ),
+            job_id: Some(job.job_id.clone()),
+            message: job.error_message.clone().or(message),
+        },
+        None => DeployFailure {
+            reason: DeployFailureReason::Unknown,
+            job_id: None,
+            message,
+        },
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use chrono::Utc;
+
+    fn job(
+        job_id: &str,
+        job_type: &str,
+        status: JobStatus,
+        error: Option<&str>,
+    ) -> deployment_jobs::Model {
+        deployment_jobs::Model {
+            id: 1,
+            deployment_id: 1,
+            job_id: job_id.to_string(),
+            job_type: job_type.to_string(),
+            name: job_id.to_string(),
+            description: None,
+            status,
+            created_at: Utc::now(),
+            updated_at: Utc::now(),
+            started_at: None,
+            finished_at: None,
+            log_id: format!("{}.log", job_id),
+            error_message: error.map(str::to_string),
+            job_config: None,
+            outputs: None,
+            dependencies: None,
+            execution_order: None,
+        }
+    }
+
+    #[test]
+    fn test_outcome_of_deployment_states() {
+        assert_eq!(DeployOutcome::from_state("pending"), DeployOutcome::Pending);
+        assert_eq!(DeployOutcome::from_state("running"), DeployOutcome::Running);
+        assert_eq!(
+            DeployOutcome::from_state("completed"),
+            DeployOutcome::Succeeded
+        );
+        assert_eq!(DeployOutcome::from_state("built"), DeployOutcome::Succeeded);
+        assert_eq!(DeployOutcome::from_state("failed"), DeployOutcome::Failed);
+        assert!(DeployOutcome::from_state("cancelled").is_finished());
+        assert!(!DeployOutcome::from_state("something-new").is_finished());
+    }
+
+    #[test]
+    fn test_failure_reason_comes_from_the_failed_job() {
+        let jobs = vec![
+            job("download_repo", "DownloadRepoJob", JobStatus::Success, None),
+            job("build_image", "BuildImageJob", JobStatus::Success, None),
+            job(
+                "deploy_image",
+                "DeployImageJob",
+                JobStatus::Failure,
+                Some("Application health check failed - server returned error status codes for 60 seconds"),
+            ),
+            job("mark_deployment_complete", "MarkDeploymentCompleteJob", JobStatus::Cancelled, None),
+        ];
+        let failure =
+            deploy_failure(DeployOutcome::Failed, Some("Job execution failed"), &jobs).unwrap();
+        assert_eq!(failure.reason, DeployFailureReason::HealthCheckFailed);
+        assert_eq!(failure.job_id.as_deref(), Some("deploy_image"));
+        assert!(failure
+            .message
+            .unwrap()
+            .starts_with("Application health check failed"));
+
+        let jobs = vec![job(
+            "build_image",
+            "BuildImageJob",
+            JobStatus::Failure,
+            Some("Build failed: exit code: 1"),
+        )];
+        assert_eq!(
+            deploy_failure(DeployOutcome::Failed, None, &jobs)
+                .unwrap()
+                .reason,
+            DeployFailureReason::BuildFailed
+        );
+        // Succeeded and running deployments have no failure
+        assert!(deploy_failure(DeployOutcome::Succeeded, None, &jobs).is_none());
+        assert!(deploy_failure(DeployOutcome::Running, None, &jobs).is_none());
+    }
+
+    #[test]
+    fn test_timeouts_and_cancellations_override_the_failed_job() {
+        let jobs = vec![job(
+            "build_image",
+            "BuildImageJob",
+            JobStatus::Failure,
+            Some("Build aborted"),
+        )];
+        let failure = deploy_failure(
+            DeployOutcome::Failed,
+            Some("Deploy timed out after 1800s during build_image"),
+            &jobs,
+        )
+        .unwrap();
+        assert_eq!(failure.reason, DeployFailureReason::TimedOut);
+        assert_eq!(failure.job_id, None);
+
+        assert_eq!(
+            deploy_failure(DeployOutcome::Cancelled, Some("Cancelled by user"), &jobs)
+                .unwrap()
+                .reason,
+            DeployFailureReason::Cancelled
+        );
+        assert_eq!(
+            deploy_failure(DeployOutcome::Failed, None, &[])
+                .unwrap()
+                .reason,
+            DeployFailureReason::Unknown
+        );
+    }
+}
@@ -0,0 +1,341 @@
+//! Deployment Artifacts
+//!
+//! Retains the built artifact of a deployment — for static deploys, a gzipped
+//! tarball of the output directory — in blob storage, so it can be downloaded later
+//! for debugging or re-hosting. Retention is opt-in per project or environment via
+//! `artifact_retention_days`; expired artifacts are deleted by the nightly cleanup
+//! scheduler.
+
+use std::path::Path;
+use std::sync::Arc;
+
+use bytes::Bytes;
+use chrono::{Duration, Utc};
+use futures::Stream;
+use sea_orm::{ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, QueryOrder, Set};
+use sha2::{Digest, Sha256};
+use temps_blob::{services::PutOptions, BlobError, BlobService};
+use temps_core::UtcDateTime;
+use temps_database::DbConnection;
+use temps_entities::{deployment_artifacts, environments, projects};
+use tracing::{debug, info, warn};
+
+use super::{CleanupPace, DeploymentError};
+
+/// Largest artifact that is retained; bigger bundles are skipped
+pub const MAX_ARTIFACT_BYTES: usize = 512 * 1024 * 1024;
+
+/// Content type of static bundle artifacts
+const STATIC_BUNDLE_CONTENT_TYPE: &str = "application/gzip";
+
+impl From<BlobError> for DeploymentError {
+    fn from(error: BlobError) -> Self {
+        match error {
+            BlobError::NotFound(path) => {
+                DeploymentError::NotFound(format!("Artifact file {} not found", path))
+            }
+            _ => DeploymentError::Other(error.to_string()),
+        }
+    }
+}
+
+/// Blob path of an artifact, inside the project's blob namespace
+fn artifact_pathname(deployment_id: i32, file_name: &str) -> String {
+    format!(
+        ".temps/artifacts/deployments/{}/{}",
+        deployment_id, file_name
+    )
+}
+
+/// When an artifact retained now for `retention_days` expires
+fn artifact_expiry(now: UtcDateTime, retention_days: u32) -> UtcDateTime {
+    now + Duration::days(retention_days as i64)
+}
+
+/// Pack a directory into a gzipped tarball, with paths relative to the directory
+fn archive_directory(dir: &Path) -> std::io::Result<Vec<u8>> {
+    let encoder = flate2::write::GzEncoder::new(Vec::new(), flate2::Compression::default());
+    let mut archive = tar::Builder::new(encoder);
+    archive.follow_symlinks(false);
+    archive.append_dir_all(".", dir)?;
+    archive.into_inner()?.finish()
+}
+
+/// Stores, lists and expires deployment artifacts
+pub struct DeploymentArtifactService {
+    db: Arc<DbConnection>,
+    blob_service: Arc<BlobService>,
+}
+
+impl DeploymentArtifactService {
+    pub fn new(db: Arc<DbConnection>, blob_service: Arc<BlobService>) -> Self {
+        Self { db, blob_service }
+    }
+
+    /// Days to keep an environment's artifacts, or None when they are not retained
+    pub async fn retention_days(
+        &self,
+        environment_id: i32,
+    ) -> Result<Option<u32>, DeploymentError> {
+        let environment = environments::Entity::find_by_id(environment_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Environment not found".to_string()))?;
+        let project_config = projects::Entity::find_by_id(environment.project_id)
+            .one(self.db.as_ref())
+            .await?
+            .and_then(|project| project.deployment_config)
+            .unwrap_or_default();
+        Ok(environment
+            .get_effective_deployment_config(&project_config)
+            .artifact_retention_days
+            .filter(|days| *days > 0))
+    }
+
+    /// Archive a static deploy's output directory and retain it for the deployment
+    ///
+    /// Returns None when the environment does not retain artifacts or the bundle is
+    /// larger than `MAX_ARTIFACT_BYTES`.
+    pub async fn retain_static_bundle(
+        &self,
+        deployment_id: i32,
+        project_id: i32,
+        environment_id: i32,
+        source_dir: &Path,
+    ) -> Result<Option<deployment_artifacts::Model>, DeploymentError> {
+        let Some(retention_days) = self.retention_days(environment_id).await? else {
+            debug!(
+                "Artifact retention is off for environment {}, not keeping the bundle of deployment {}",
+                environment_id, deployment_id
+            );
+            return Ok(None);
+        };
+
+        let dir = source_dir.to_path_buf();
+        let bundle = tokio::task::spawn_blocking(move || archive_directory(&dir))
+            .await
+            .map_err(|e| DeploymentError::Other(format!("Archiving task failed: {}", e)))?
+            .map_err(|e| DeploymentError::Other(format!("Failed to archive bundle: {}", e)))?;
+
+        if bundle.len() > MAX_ARTIFACT_BYTES {
+            warn!(
+                "Static bundle of deployment {} is {} bytes, over the {} byte artifact limit; not retaining it",
+                deployment_id,
+                bundle.len(),
+                MAX_ARTIFACT_BYTES
+            );
+            return Ok(None);
+        }
+
+        let file_name = format!("deployment-{}-static.tar.gz", deployment_id);
+        let sha256 = hex::encode(Sha256::digest(&bundle));
+        let size_bytes = bundle.len() as i64;
+        let pathname = artifact_pathname(deployment_id, &file_name);
+
+        self.blob_service
+            .put(
+                project_id,
+                &pathname,
+                Bytes::from(bundle),
+                PutOptions {
+                    content_type: Some(STATIC_BUNDLE_CONTENT_TYPE.to_string()),
+                    add_random_suffix: false,
+                },
+            )
+            .await?;
+
+        let artifact = deployment_artifacts::ActiveModel {
+            deployment_id: Set(deployment_id),
+            project_id: Set(project_id),
+            environment_id: Set(environment_id),
+            kind: Set(deployment_artifacts::ARTIFACT_KIND_STATIC_BUNDLE.to_string()),
+            file_name: Set(file_name),
+            pathname: Set(pathname),
+            content_type: Set(STATIC_BUNDLE_CONTENT_TYPE.to_string()),
+            size_bytes: Set(size_bytes),
+            sha256: Set(sha256),
+            expires_at: Set(Some(artifact_expiry(Utc::now(), retention_days))),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        info!(
+            "Retained static bundle of deployment {} for {} days ({} bytes)",
+            deployment_id, retention_days, size_bytes
+        );
+        Ok(Some(artifact))
+    }
+
+    /// Artifacts retained for a deployment, newest first
+    pub async fn list_artifacts(
+        &self,
+        project_id: i32,
+        deployment_id: i32,
+    ) -> Result<Vec<deployment_artifacts::Model>, DeploymentError> {
+        Ok(deployment_artifacts::Entity::find()
+            .filter(deployment_artifacts::Column::ProjectId.eq(project_id))
+            .filter(deployment_artifacts::Column::DeploymentId.eq(deployment_id))
+            .order_by_desc(deployment_artifacts::Column::CreatedAt)
+            .all(self.db.as_ref())
+            .await?)
+    }
+
+    async fn get_artifact(
+        &self,
+        project_id: i32,
+        deployment_id: i32,
+        artifact_id: i32,
+    ) -> Result<deployment_artifacts::Model, DeploymentError> {
+        deployment_artifacts::Entity::find_by_id(artifact_id)
+            .filter(deployment_artifacts::Column::ProjectId.eq(project_id))
+            .filter(deployment_artifacts::Column::DeploymentId.eq(deployment_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Artifact not found".to_string()))
+    }
+
+    /// Open an artifact for download
+    pub async fn download_artifact(
+        &self,
+        project_id: i32,
+        deployment_id: i32,
+        artifact_id: i32,
+    ) -> Result<
+        (
+            deployment_artifacts::Model,
+            impl Stream<Item = Result<Bytes, std::io::Error>>,
+        ),
+        DeploymentError,
+    > {
+        let artifact = self
+            .get_artifact(project_id, deployment_id, artifact_id)
+            .await?;
+        // Expired artifacts are only removed on the next cleanup run
+        if artifact
+            .expires_at
+            .is_some_and(|expires_at| expires_at <= Utc::now())
+        {
+            return Err(DeploymentError::NotFound(
+                "Artifact has expired".to_string(),
+            ));
+        }
+        let (stream, _, _) = self
+            .blob_service
+            .download(artifact.project_id, &artifact.pathname)
+            .await?;
+        Ok((artifact, stream))
+    }
+
+    /// Delete artifacts whose retention has run out, keeping to `pace`. Returns how
+    /// many were removed and their total size in bytes.
+    pub async fn delete_expired(&self, pace: &CleanupPace) -> Result<(u64, u64), DeploymentError> {
+        let expired = deployment_artifacts::Entity::find()
+            .filter(deployment_artifacts::Column::ExpiresAt.lte(Utc::now()))
+            .all(self.db.as_ref())
+            .await?;
+        let (mut deleted, mut bytes) = (0, 0);
+        for artifact in expired {
+            if !pace.acquire().await {
+                debug!("Cleanup window closed, expired artifacts left for the next run");
+                break;
+            }
+            let size_bytes = artifact.size_bytes.max(0) as u64;
+            if self.delete_artifact(artifact).await? {
+                deleted += 1;
+                bytes += size_bytes;
+            }
+        }
+        Ok((deleted, bytes))
+    }
+
+    /// Delete all artifacts of a deployment, returning how many were removed
+    pub async fn delete_for_deployment(&self, deployment_id: i32) -> Result<u64, DeploymentError> {
+        let artifacts = deployment_artifacts::Entity::find()
+            .filter(deployment_artifacts::Column::DeploymentId.eq(deployment_id))
+            .all(self.db.as_ref())
+            .await?;
+        let mut deleted = 0;
+        for artifact in artifacts {
+            if self.delete_artifact(artifact).await? {
+                deleted += 1;
+            }
+        }
+        Ok(deleted)
+    }
+
+    /// Delete an artifact's file and record, returning whether it was removed
+    async fn delete_artifact(
+        &self,
+        artifact: deployment_artifacts::Model,
+    ) -> Result<bool, DeploymentError> {
+        // A missing file still lets the row go; any other failure is retried next run
+        match self
+            .blob_service
+            .del(artifact.project_id, vec![artifact.pathname.clone()])
+            .await
+        {
+            Ok(_) | Err(BlobError::NotFound(_)) => {}
+            Err(e) => {
+                warn!(
+                    "Failed to delete artifact {} ({}): {}",
+                    artifact.id, artifact.pathname, e
+                );
+                return Ok(false);
+            }
+        }
+        deployment_artifacts::Entity::delete_by_id(artifact.id)
+            .exec(self.db.as_ref())
+            .await?;
+        Ok(true)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_artifact_pathname_is_per_deployment() {
+        assert_eq!(
+            artifact_pathname(42, "deployment-42-static.tar.gz"),
+            ".temps/artifacts/deployments/42/deployment-42-static.tar.gz"
+        );
+    }
+
+    #[test]
+    fn test_artifact_expiry() {
+        let now = Utc::now();
+        assert_eq!(artifact_expiry(now, 30) - now, Duration::days(30));
+    }
+
+    #[test]
+    fn test_archive_directory_round_trips() {
+        let dir = std::env::temp_dir().join(format!("temps-artifact-{}", uuid::Uuid::new_v4()));
+        std::fs::create_dir_all(dir.join("assets")).unwrap();
+        std::fs::write(dir.join("index.html"), "<h1>hi</h1>").unwrap();
+        std::fs::write(dir.join("assets/app.js"), "console.log(1)").unwrap();
+
+        let bundle = archive_directory(&dir).unwrap();
+        std::fs::remove_dir_all(&dir).unwrap();
+
+        let mut archive = tar::Archive::new(flate2::read::GzDecoder::new(bundle.as_slice()));
+        let mut paths: Vec<String> = archive
+            .entries()
+            .unwrap()
+            .filter_map(|entry| {
+                let entry = entry.ok()?;
+                entry.header().entry_type().is_file().then(|| {
+                    entry
+                        .path()
+                        .unwrap()
+                        .to_string_lossy()
+                        .trim_start_matches("./")
+                        .to_string()
+                })
+            })
+            .collect();
+        paths.sort();
+        assert_eq!(paths, vec!["assets/app.js", "index.html"]);
+    }
+}
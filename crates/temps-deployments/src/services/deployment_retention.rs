@@ -0,0 +1,676 @@
+//! Deployment Retention
+//!
+//! Every deploy adds a deployment record with its jobs, logs and artifacts, so the
+//! history grows without bound. The nightly cleanup prunes the deployments of each
+//! environment that fall outside the `deployment_retention` settings (the last N
+//! deployments, or the last N days), together with their job logs, retained
+//! artifacts and, when it is beyond the image retention count, their image.
+//!
+//! Always kept:
+//! - the environment's current deployment and its last successful one
+//! - deployments that are still in progress
+//! - deployments with active containers
+//! - deployments that analytics data (session replays, performance metrics) belongs
+//!   to, since deleting the deployment would delete that data with it
+//!
+//! A dry run produces the same report without removing anything. The nightly run
+//! keeps to the cleanup's pace (see `CleanupPace`) and leaves what it can't prune
+//! before the cleanup window closes to the next night.
+
+use chrono::Utc;
+use futures::StreamExt;
+use sea_orm::{
+    ColumnTrait, DatabaseConnection, EntityTrait, PaginatorTrait, QueryFilter, QueryOrder,
+    QuerySelect,
+};
+use serde::Serialize;
+use std::collections::{HashMap, HashSet};
+use std::sync::Arc;
+use temps_core::{DBDateTime, DeploymentRetentionSettings};
+use temps_entities::deployment_config::DeploymentConfig;
+use temps_entities::{
+    deployment_artifacts, deployment_containers, deployment_jobs, deployments, environments,
+    performance_metrics, projects, session_replay_sessions,
+};
+use temps_logs::LogService;
+use tracing::{debug, info, warn};
+use utoipa::ToSchema;
+
+use super::resource_gc_service::{
+    normalize_image_ref, retained_image_count, rollback_images, FINISHED_STATES,
+};
+use super::{CleanupPace, DeploymentArtifactService, DeploymentError, GcRuntime};
+
+/// Deployment states of a successful deployment
+const SUCCESSFUL_STATES: &[&str] = &["completed", "deployed"];
+
+/// Why a deployment is pruned
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum PruneReason {
+    /// Not among the environment's last `keep_last` deployments
+    BeyondKeepLast,
+    /// Created more than `max_age_days` ago
+    OlderThanMaxAge,
+}
+
+/// A deployment selected for pruning
+#[derive(Debug, Clone, PartialEq, Serialize, ToSchema)]
+pub struct PrunedDeployment {
+    pub deployment_id: i32,
+    pub project_id: i32,
+    pub environment_id: i32,
+    pub slug: String,
+    pub state: String,
+    /// When the deployment was created (ISO 8601)
+    pub created_at: String,
+    pub reason: PruneReason,
+    /// Image removed with the deployment, when it is beyond the image retention count
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub image: Option<String>,
+    /// Whether the deployment was removed (always false for dry runs)
+    pub removed: bool,
+    /// Whether its image was removed (always false for dry runs)
+    pub image_removed: bool,
+    /// Error message if removal failed
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+/// Result of a retention pass
+#[derive(Debug, Clone, Default, Serialize, ToSchema)]
+pub struct RetentionReport {
+    pub dry_run: bool,
+    pub deployments: Vec<PrunedDeployment>,
+    /// Deployments actually removed
+    pub removed_deployments: u64,
+    /// Images actually removed
+    pub removed_images: u64,
+    /// Whether the pass stopped at the end of the cleanup window, leaving the rest
+    /// to the next run
+    pub interrupted: bool,
+    /// When the pass ran (ISO 8601)
+    pub ran_at: String,
+}
+
+/// Retention limits of deployment history
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct RetentionPolicy {
+    pub keep_last: Option<u32>,
+    pub max_age_days: Option<u32>,
+}
+
+impl From<&DeploymentRetentionSettings> for RetentionPolicy {
+    fn from(settings: &DeploymentRetentionSettings) -> Self {
+        Self {
+            keep_last: settings.keep_last.map(|count| count.max(1)),
+            max_age_days: settings.max_age_days.filter(|days| *days > 0),
+        }
+    }
+}
+
+/// Select the deployments of one environment to prune
+///
+/// `deployments` are the environment's deployments, newest first; `pinned` are
+/// deployments that must be kept regardless of the policy.
+pub fn plan_retention(
+    deployments: &[deployments::Model],
+    current_deployment_id: Option<i32>,
+    pinned: &HashSet<i32>,
+    policy: &RetentionPolicy,
+    now: DBDateTime,
+) -> Vec<(usize, PruneReason)> {
+    let last_successful = deployments
+        .iter()
+        .find(|d| SUCCESSFUL_STATES.contains(&d.state.as_str()))
+        .map(|d| d.id);
+    let cutoff = policy
+        .max_age_days
+        .map(|days| now - chrono::Duration::days(days as i64));
+
+    deployments
+        .iter()
+        .enumerate()
+        .filter(|(_, d)| {
+            Some(d.id) != current_deployment_id
+                && Some(d.id) != last_successful
+                && !pinned.contains(&d.id)
+                && FINISHED_STATES.contains(&d.state.as_str())
+        })
+        .filter_map(|(index, d)| {
+            if policy
+                .keep_last
+                .is_some_and(|keep_last| index >= keep_last as usize)
+            {
+                Some((index, PruneReason::BeyondKeepLast))
+            } else if cutoff.is_some_and(|cutoff| d.created_at < cutoff) {
+                Some((index, PruneReason::OlderThanMaxAge))
+            } else {
+                None
+            }
+        })
+        .collect()
+}
+
+/// Prunes deployment history beyond the configured retention
+pub struct DeploymentRetentionService {
+    db: Arc<DatabaseConnection>,
+    config_service: Arc<temps_config::ConfigService>,
+    log_service: Arc<LogService>,
+    artifact_service: Arc<DeploymentArtifactService>,
+    runtime: Arc<dyn GcRuntime>,
+    /// Serializes concurrent runs (nightly cleanup vs manual trigger)
+    run_lock: tokio::sync::Mutex<()>,
+}
+
+impl DeploymentRetentionService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        config_service: Arc<temps_config::ConfigService>,
+        log_service: Arc<LogService>,
+        artifact_service: Arc<DeploymentArtifactService>,
+        runtime: Arc<dyn GcRuntime>,
+    ) -> Self {
+        Self {
+            db,
+            config_service,
+            log_service,
+            artifact_service,
+            runtime,
+            run_lock: tokio::sync::Mutex::new(()),
+        }
+    }
+
+    /// Prune deployments when retention is enabled, as the nightly cleanup does
+    pub async fn run_if_enabled(
+        &self,
+        pace: &CleanupPace,
+    ) -> Result<Option<RetentionReport>, DeploymentError> {
+        let settings = self
+            .config_service
+            .get_settings()
+            .await
+            .map_err(|e| DeploymentError::Other(format!("Failed to load settings: {}", e)))?;
+        if !settings.deployment_retention.enabled {
+            debug!("Deployment retention disabled, skipping run");
+            return Ok(None);
+        }
+        self.run_paced(false, pace).await.map(Some)
+    }
+
+    /// Prune deployments beyond the configured retention. With `dry_run` nothing is
+    /// removed.
+    pub async fn run(&self, dry_run: bool) -> Result<RetentionReport, DeploymentError> {
+        self.run_paced(dry_run, &CleanupPace::unlimited()).await
+    }
+
+    /// Prune deployments beyond the configured retention, keeping to `pace`
+    pub async fn run_paced(
+        &self,
+        dry_run: bool,
+        pace: &CleanupPace,
+    ) -> Result<RetentionReport, DeploymentError> {
+        let _guard = self.run_lock.lock().await;
+
+        let settings = self
+            .config_service
+            .get_settings()
+            .await
+            .map_err(|e| DeploymentError::Other(format!("Failed to load settings: {}", e)))?;
+        let policy = RetentionPolicy::from(&settings.deployment_retention);
+        let now = Utc::now();
+
+        // Deployments with active containers are never pruned, and their images are kept
+        let active_containers = deployment_containers::Entity::find()
+            .filter(deployment_containers::Column::DeletedAt.is_null())
+            .all(self.db.as_ref())
+            .await?;
+        let mut pinned: HashSet<i32> = HashSet::new();
+        let mut kept_images: HashSet<String> = HashSet::new();
+        for container in active_containers {
+            pinned.insert(container.deployment_id);
+            if let Some(image) = container.image_name {
+                kept_images.insert(normalize_image_ref(&image));
+            }
+        }
+
+        let envs = environments::Entity::find()
+            .filter(environments::Column::DeletedAt.is_null())
+            .all(self.db.as_ref())
+            .await?;
+        let project_configs: HashMap<i32, Option<DeploymentConfig>> = projects::Entity::find()
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|project| (project.id, project.deployment_config))
+            .collect();
+
+        // Plan every environment first: an image pruned in one environment may be
+        // retained by another (promotions deploy the same image)
+        let mut planned: Vec<(deployments::Model, PruneReason)> = Vec::new();
+        for env in envs {
+            let env_deployments = deployments::Entity::find()
+                .filter(deployments::Column::EnvironmentId.eq(env.id))
+                .order_by_desc(deployments::Column::CreatedAt)
+                .all(self.db.as_ref())
+                .await?;
+
+            let image_count = retained_image_count(
+                project_configs
+                    .get(&env.project_id)
+                    .and_then(|config| config.as_ref()),
+                env.deployment_config.as_ref(),
+                settings.garbage_collection.retained_images,
+            );
+            kept_images.extend(rollback_images(
+                &env_deployments,
+                env.current_deployment_id,
+                image_count,
+            ));
+
+            let plan = plan_retention(
+                &env_deployments,
+                env.current_deployment_id,
+                &pinned,
+                &policy,
+                now,
+            );
+            if plan.is_empty() {
+                continue;
+            }
+
+            let candidate_ids: Vec<i32> =
+                plan.iter().map(|(i, _)| env_deployments[*i].id).collect();
+            let with_analytics = self.deployments_with_analytics(&candidate_ids).await?;
+            if !with_analytics.is_empty() {
+                debug!(
+                    "Keeping {} deployments of environment {} that analytics data belongs to",
+                    with_analytics.len(),
+                    env.id
+                );
+            }
+
+            let mut env_deployments: Vec<Option<deployments::Model>> =
+                env_deployments.into_iter().map(Some).collect();
+            for (index, reason) in plan {
+                if let Some(deployment) = env_deployments[index].take() {
+                    if !with_analytics.contains(&deployment.id) {
+                        planned.push((deployment, reason));
+                    }
+                }
+            }
+        }
+
+        // Only images that still exist are removed; the garbage collector may have
+        // removed the others already
+        let existing_images: HashSet<String> =
+            if planned.iter().any(|(d, _)| d.image_name.is_some()) {
+                self.runtime
+                    .list_images()
+                    .await
+                    .map_err(DeploymentError::Other)?
+                    .into_iter()
+                    .flat_map(|image| image.tags)
+                    .map(|tag| normalize_image_ref(&tag))
+                    .collect()
+            } else {
+                HashSet::new()
+            };
+
+        let mut report = RetentionReport {
+            dry_run,
+            ran_at: now.to_rfc3339_opts(chrono::SecondsFormat::Secs, true),
+            ..Default::default()
+        };
+        let items = planned.into_iter().map(|(deployment, reason)| {
+            let image = deployment.image_name.clone().filter(|image| {
+                let image = normalize_image_ref(image);
+                !kept_images.contains(&image) && existing_images.contains(&image)
+            });
+            let item = PrunedDeployment {
+                deployment_id: deployment.id,
+                project_id: deployment.project_id,
+                environment_id: deployment.environment_id,
+                slug: deployment.slug.clone(),
+                state: deployment.state.clone(),
+                created_at: deployment
+                    .created_at
+                    .to_rfc3339_opts(chrono::SecondsFormat::Secs, true),
+                reason,
+                image,
+                removed: false,
+                image_removed: false,
+                error: None,
+            };
+            (deployment, item)
+        });
+
+        if dry_run {
+            report.deployments = items.map(|(_, item)| item).collect();
+        } else {
+            // Deployments are pruned `concurrency` at a time; the report keeps their order
+            report.deployments = futures::stream::iter(items)
+                .map(|(deployment, mut item)| async move {
+                    if pace.acquire().await {
+                        self.prune_deployment(&deployment, &mut item).await;
+                    }
+                    item
+                })
+                .buffered(pace.concurrency())
+                .collect()
+                .await;
+            for item in &report.deployments {
+                if item.removed {
+                    report.removed_deployments += 1;
+                }
+                if item.image_removed {
+                    report.removed_images += 1;
+                }
+                if !item.removed && item.error.is_none() {
+                    report.interrupted = true;
+                }
+            }
+        }
+
+        if dry_run {
+            info!(
+                "🧹 Deployment retention dry run: {} deployments would be pruned",
+                report.deployments.len()
+            );
+        } else {
+            info!(
+                "🧹 Deployment retention pruned {} deployments and {} images",
+                report.removed_deployments, report.removed_images
+            );
+            if report.interrupted {
+                info!(
+                    "Cleanup window closed, the remaining deployments are pruned by the next run"
+                );
+            }
+        }
+
+        Ok(report)
+    }
+
+    /// Deployments among `ids` that session replays or performance metrics belong to
+    async fn deployments_with_analytics(
+        &self,
+        ids: &[i32],
+    ) -> Result<HashSet<i32>, DeploymentError> {
+        let mut referenced: HashSet<i32> = HashSet::new();
+        for chunk in ids.chunks(500) {
+            let replays: Vec<i32> = session_replay_sessions::Entity::find()
+                .select_only()
+                .column(session_replay_sessions::Column::DeploymentId)
+                .filter(session_replay_sessions::Column::DeploymentId.is_in(chunk.to_vec()))
+                .distinct()
+                .into_tuple()
+                .all(self.db.as_ref())
+                .await?;
+            let metrics: Vec<i32> = performance_metrics::Entity::find()
+                .select_only()
+                .column(performance_metrics::Column::DeploymentId)
+                .filter(performance_metrics::Column::DeploymentId.is_in(chunk.to_vec()))
+                .distinct()
+                .into_tuple()
+                .all(self.db.as_ref())
+                .await?;
+            referenced.extend(replays);
+            referenced.extend(metrics);
+        }
+        Ok(referenced)
+    }
+
+    /// Remove a deployment's artifacts, job logs, record and image, recording the
+    /// outcome on `item`
+    async fn prune_deployment(&self, deployment: &deployments::Model, item: &mut PrunedDeployment) {
+        // Artifacts live in blob storage; the record must outlive any that can't be deleted
+        if let Err(e) = self
+            .artifact_service
+            .delete_for_deployment(deployment.id)
+            .await
+        {
+            warn!(
+                "Failed to delete artifacts of deployment {}: {}",
+                deployment.id, e
+            );
+            item.error = Some(format!("Failed to delete artifacts: {}", e));
+            return;
+        }
+        match deployment_artifacts::Entity::find()
+            .filter(deployment_artifacts::Column::DeploymentId.eq(deployment.id))
+            .count(self.db.as_ref())
+            .await
+        {
+            Ok(0) => {}
+            Ok(remaining) => {
+                item.error = Some(format!("{} artifacts could not be deleted", remaining));
+                return;
+            }
+            Err(e) => {
+                item.error = Some(e.to_string());
+                return;
+            }
+        }
+
+        let log_ids: Vec<String> = match deployment_jobs::Entity::find()
+            .select_only()
+            .column(deployment_jobs::Column::LogId)
+            .filter(deployment_jobs::Column::DeploymentId.eq(deployment.id))
+            .into_tuple()
+            .all(self.db.as_ref())
+            .await
+        {
+            Ok(log_ids) => log_ids,
+            Err(e) => {
+                item.error = Some(e.to_string());
+                return;
+            }
+        };
+
+        // Jobs, domains, container records and promotions are deleted with it
+        if let Err(e) = deployments::Entity::delete_by_id(deployment.id)
+            .exec(self.db.as_ref())
+            .await
+        {
+            warn!("Failed to delete deployment {}: {}", deployment.id, e);
+            item.error = Some(e.to_string());
+            return;
+        }
+        item.removed = true;
+        debug!(
+            "Pruned deployment {} ({}) of environment {}",
+            deployment.id, deployment.slug, deployment.environment_id
+        );
+
+        for log_id in log_ids.iter().filter(|log_id| !log_id.is_empty()) {
+            if let Err(e) = self.log_service.delete_log(log_id).await {
+                warn!(
+                    "Failed to delete log {} of deployment {}: {}",
+                    log_id, deployment.id, e
+                );
+            }
+        }
+
+        if let Some(image) = &item.image {
+            match self.runtime.remove_image(image).await {
+                Ok(()) => item.image_removed = true,
+                Err(e) => {
+                    warn!(
+                        "Failed to remove image {} of deployment {}: {}",
+                        image, deployment.id, e
+                    );
+                    item.error = Some(e);
+                }
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn deployment(id: i32, state: &str, age_days: i64) -> deployments::Model {
+        let created_at = Utc::now() - chrono::Duration::days(age_days);
+        deployments::Model {
+            id,
+            project_id: 1,
+            environment_id: 1,
+            created_at,
+            updated_at: created_at,
+            slug: format!("deployment-{}", id),
+            state: state.to_string(),
+            metadata: None,
+            deploying_at: None,
+            ready_at: None,
+            started_at: None,
+            finished_at: None,
+            context_vars: None,
+            branch_ref: None,
+            tag_ref: None,
+            commit_sha: None,
+            commit_message: None,
+            commit_author: None,
+            commit_json: None,
+            cancelled_reason: None,
+            static_dir_location: None,
+            screenshot_location: None,
+            image_name: Some(format!("app-{}", id)),
+            deployment_config: None,
+        }
+    }
+
+    fn pruned_ids(
+        deployments: &[deployments::Model],
+        current: Option<i32>,
+        pinned: &HashSet<i32>,
+        policy: RetentionPolicy,
+    ) -> Vec<i32> {
+        plan_retention(deployments, current, pinned, &policy, Utc::now())
+            .into_iter()
+            .map(|(index, _)| deployments[index].id)
+            .collect()
+    }
+
+    #[test]
+    fn test_keep_last_prunes_older_deployments() {
+        // Newest first
+        let deployments: Vec<_> = (1..=5)
+            .rev()
+            .map(|id| deployment(id, "completed", 10 - id as i64))
+            .collect();
+        let policy = RetentionPolicy {
+            keep_last: Some(2),
+            max_age_days: None,
+        };
+
+        assert_eq!(
+            pruned_ids(&deployments, Some(5), &HashSet::new(), policy),
+            vec![3, 2, 1]
+        );
+    }
+
+    #[test]
+    fn test_current_last_successful_running_and_pinned_are_kept() {
+        let deployments = vec![
+            deployment(6, "failed", 1),
+            deployment(5, "running", 2),
+            deployment(4, "failed", 3),
+            deployment(3, "completed", 4),
+            deployment(2, "failed", 5),
+            deployment(1, "completed", 6),
+        ];
+        let policy = RetentionPolicy {
+            keep_last: Some(1),
+            max_age_days: None,
+        };
+
+        // 1 is current, 3 the last successful, 5 in progress and 2 pinned
+        assert_eq!(
+            pruned_ids(&deployments, Some(1), &HashSet::from([2]), policy),
+            vec![4]
+        );
+    }
+
+    #[test]
+    fn test_max_age_prunes_old_deployments() {
+        let deployments = vec![
+            deployment(3, "completed", 1),
+            deployment(2, "failed", 40),
+            deployment(1, "cancelled", 100),
+        ];
+        let policy = RetentionPolicy {
+            keep_last: None,
+            max_age_days: Some(30),
+        };
+
+        let plan = plan_retention(&deployments, Some(3), &HashSet::new(), &policy, Utc::now());
+        assert_eq!(
+            plan,
+            vec![
+                (1, PruneReason::OlderThanMaxAge),
+                (2, PruneReason::OlderThanMaxAge)
+            ]
+        );
+    }
+
+    #[test]
+    fn test_no_limits_prune_nothing() {
+        let deployments = vec![deployment(2, "completed", 1), deployment(1, "failed", 1000)];
+        assert!(pruned_ids(
+            &deployments,
+            Some(2),
+            &HashSet::new(),
+            RetentionPolicy::default()
+        )
+        .is_empty());
+    }
+
+    #[test]
+    fn test_policy_from_settings_clamps_limits() {
+        let policy = RetentionPolicy::from(&DeploymentRetentionSettings {
+            enabled: true,
+            keep_last: Some(0),
+            max_age_days: Some(0),
+        });
+        assert_eq!(policy.keep_last, Some(1));
+        assert_eq!(policy.max_age_days, None);
+    }
+
+    #[test]
+    fn test_retained_images_follow_image_retention() {
+        let deployments = vec![
+            deployment(4, "failed", 1),
+            deployment(3, "completed", 2),
+            deployment(2, "completed", 3),
+            deployment(1, "completed", 4),
+        ];
+        let images = rollback_images(&deployments, Some(3), 2);
+        assert_eq!(images, vec!["app-3:latest", "app-2:latest"]);
+    }
+
+    #[test]
+    fn test_running_image_is_kept_beyond_image_retention() {
+        // Rolled back to deployment 1, older than the one image retained
+        let deployments = vec![
+            deployment(3, "completed", 1),
+            deployment(2, "completed", 2),
+            deployment(1, "completed", 3),
+        ];
+        let images = rollback_images(&deployments, Some(1), 1);
+        assert_eq!(images, vec!["app-3:latest", "app-1:latest"]);
+    }
+
+    #[test]
+    fn test_images_of_deployments_in_progress_are_kept() {
+        let deployments = vec![
+            deployment(4, "pending", 1),
+            deployment(3, "running", 2),
+            deployment(2, "failed", 3),
+            deployment(1, "completed", 4),
+        ];
+        let images = rollback_images(&deployments, Some(1), 1);
+        assert_eq!(images, vec!["app-1:latest", "app-4:latest", "app-3:latest"]);
+    }
+}
@@ -0,0 +1,237 @@
+//! Disk Space Guard
+//!
+//! Refuses to start new image builds when the data disk is above the configured
+//! critical threshold. Before refusing, it runs the orphaned resource garbage collector
+//! once and re-checks, so builds are only blocked when reclaiming space did not help.
+//!
+//! Also reports current disk usage broken down by images, volumes, logs and backups.
+
+use chrono::{DateTime, Utc};
+use sea_orm::{ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, QuerySelect};
+use serde::Serialize;
+use std::path::PathBuf;
+use std::sync::Arc;
+use temps_core::DiskSpaceAlertSettings;
+use temps_entities::backups;
+use temps_monitoring::{directory_size, disk_info_for_path, format_bytes, DiskInfo, DiskPressure};
+use tracing::{info, warn};
+use utoipa::ToSchema;
+
+use super::{DeploymentError, GcRuntime, ResourceGcService};
+use crate::jobs::BuildCapacityCheck;
+
+/// Disk usage broken down by what is consuming it
+#[derive(Debug, Clone, Default, Serialize, ToSchema)]
+pub struct DiskUsageBreakdown {
+    /// Images built by Temps
+    pub images_bytes: u64,
+    /// Docker volumes (measured on their host mountpoints)
+    pub volumes_bytes: u64,
+    /// Deployment and pipeline logs
+    pub logs_bytes: u64,
+    /// Static deployment files
+    pub static_bytes: u64,
+    /// Completed backups (stored on S3, not counted against the local disk)
+    pub backups_bytes: u64,
+}
+
+/// Current disk usage of the data disk
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct DiskUsageReport {
+    pub mount_point: String,
+    pub total_bytes: u64,
+    pub used_bytes: u64,
+    pub available_bytes: u64,
+    pub usage_percent: f64,
+    /// `ok`, `warning` or `critical`
+    #[schema(value_type = String, example = "ok")]
+    pub pressure: DiskPressure,
+    pub threshold_percent: u32,
+    pub critical_threshold_percent: u32,
+    pub breakdown: DiskUsageBreakdown,
+    pub checked_at: DateTime<Utc>,
+}
+
+/// Blocks builds when disk space is critical, garbage collecting first
+pub struct DiskSpaceGuard {
+    db: Arc<DatabaseConnection>,
+    config_service: Arc<temps_config::ConfigService>,
+    resource_gc_service: Arc<ResourceGcService>,
+    runtime: Arc<dyn GcRuntime>,
+}
+
+impl DiskSpaceGuard {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        config_service: Arc<temps_config::ConfigService>,
+        resource_gc_service: Arc<ResourceGcService>,
+        runtime: Arc<dyn GcRuntime>,
+    ) -> Self {
+        Self {
+            db,
+            config_service,
+            resource_gc_service,
+            runtime,
+        }
+    }
+
+    async fn get_settings(&self) -> Result<DiskSpaceAlertSettings, DeploymentError> {
+        let settings = self
+            .config_service
+            .get_settings()
+            .await
+            .map_err(|e| DeploymentError::Other(format!("Failed to load settings: {}", e)))?;
+        Ok(settings.disk_space_alert)
+    }
+
+    fn monitor_path(&self, settings: &DiskSpaceAlertSettings) -> PathBuf {
+        settings
+            .monitor_path
+            .as_ref()
+            .map(PathBuf::from)
+            .unwrap_or_else(|| self.config_service.data_dir())
+    }
+
+    fn disk_info(&self, settings: &DiskSpaceAlertSettings) -> Result<DiskInfo, DeploymentError> {
+        disk_info_for_path(&self.monitor_path(settings))
+            .map_err(|e| DeploymentError::Other(format!("Failed to read disk usage: {}", e)))
+    }
+
+    async fn backups_bytes(&self) -> Result<u64, DeploymentError> {
+        let sizes: Vec<Option<i32>> = backups::Entity::find()
+            .select_only()
+            .column(backups::Column::SizeBytes)
+            .filter(backups::Column::State.eq("completed"))
+            .into_tuple()
+            .all(self.db.as_ref())
+            .await?;
+
+        Ok(sizes
+            .into_iter()
+            .flatten()
+            .map(|size| size.max(0) as u64)
+            .sum())
+    }
+
+    async fn breakdown(&self) -> Result<DiskUsageBreakdown, DeploymentError> {
+        let images_bytes = match self.runtime.list_images().await {
+            Ok(images) => images.iter().map(|image| image.size_bytes).sum(),
+            Err(e) => {
+                warn!("Failed to list images for disk usage: {}", e);
+                0
+            }
+        };
+
+        let volume_mountpoints = match self.runtime.list_volume_mountpoints().await {
+            Ok(mountpoints) => mountpoints,
+            Err(e) => {
+                warn!("Failed to list volumes for disk usage: {}", e);
+                Vec::new()
+            }
+        };
+
+        let log_dir = self.config_service.log_data_dir();
+        let static_dir = self.config_service.static_dir();
+
+        // Walking directories is blocking I/O
+        let (volumes_bytes, logs_bytes, static_bytes) = tokio::task::spawn_blocking(move || {
+            let volumes_bytes = volume_mountpoints
+                .iter()
+                .map(|mountpoint| directory_size(std::path::Path::new(mountpoint)))
+                .sum();
+            (
+                volumes_bytes,
+                directory_size(&log_dir),
+                directory_size(&static_dir),
+            )
+        })
+        .await
+        .map_err(|e| DeploymentError::Other(format!("Disk usage scan failed: {}", e)))?;
+
+        Ok(DiskUsageBreakdown {
+            images_bytes,
+            volumes_bytes,
+            logs_bytes,
+            static_bytes,
+            backups_bytes: self.backups_bytes().await?,
+        })
+    }
+
+    /// Report current disk usage of the data disk with a breakdown by category
+    pub async fn usage(&self) -> Result<DiskUsageReport, DeploymentError> {
+        let settings = self.get_settings().await?;
+        let disk = self.disk_info(&settings)?;
+        let breakdown = self.breakdown().await?;
+
+        Ok(DiskUsageReport {
+            pressure: DiskPressure::from_usage(disk.usage_percent, &settings),
+            mount_point: disk.mount_point,
+            total_bytes: disk.total_bytes,
+            used_bytes: disk.used_bytes,
+            available_bytes: disk.available_bytes,
+            usage_percent: disk.usage_percent,
+            threshold_percent: settings.threshold_percent,
+            critical_threshold_percent: settings.critical_threshold_percent,
+            breakdown,
+            checked_at: Utc::now(),
+        })
+    }
+
+    /// Check whether there is enough disk space to start a build
+    ///
+    /// Returns the reason the build must be refused, if any. When usage is critical,
+    /// garbage collection runs first; the build is refused only if usage is still
+    /// critical afterwards.
+    pub async fn check_build_capacity(&self) -> Result<Option<String>, DeploymentError> {
+        let settings = self.get_settings().await?;
+        if !settings.enabled {
+            return Ok(None);
+        }
+
+        let disk = self.disk_info(&settings)?;
+        if DiskPressure::from_usage(disk.usage_percent, &settings) != DiskPressure::Critical {
+            return Ok(None);
+        }
+
+        warn!(
+            "Disk usage on {} is critical ({:.1}%), running garbage collection before build",
+            disk.mount_point, disk.usage_percent
+        );
+        match self.resource_gc_service.run(false).await {
+            Ok(report) => info!(
+                "Garbage collection reclaimed {} before build",
+                format_bytes(report.reclaimed_bytes)
+            ),
+            Err(e) => warn!("Garbage collection before build failed: {}", e),
+        }
+
+        let disk = self.disk_info(&settings)?;
+        if DiskPressure::from_usage(disk.usage_percent, &settings) == DiskPressure::Critical {
+            return Ok(Some(format!(
+                "Disk usage on {} is {:.1}% ({} free), above the critical threshold of {}%. \
+                Free up disk space or raise the threshold in Settings > System Monitoring.",
+                disk.mount_point,
+                disk.usage_percent,
+                format_bytes(disk.available_bytes),
+                settings.critical_threshold_percent
+            )));
+        }
+
+        Ok(None)
+    }
+}
+
+#[async_trait::async_trait]
+impl BuildCapacityCheck for DiskSpaceGuard {
+    async fn ensure_build_capacity(&self) -> Result<(), String> {
+        match self.check_build_capacity().await {
+            Ok(None) => Ok(()),
+            Ok(Some(reason)) => Err(reason),
+            // Failing to measure disk usage must not block deployments
+            Err(e) => {
+                warn!("Disk space check failed, allowing build: {}", e);
+                Ok(())
+            }
+        }
+    }
+}
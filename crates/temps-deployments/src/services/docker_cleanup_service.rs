@@ -1,12 +1,22 @@
 //! Docker Cleanup Service
 //!
-//! Manages nightly cleanup of unused Docker images and build caches to save disk space.
-//! Runs as a background task scheduled at 2 AM UTC daily.
+//! Manages nightly cleanup of unused Docker images and build caches to save disk space,
+//! deletes deployment artifacts whose retention has run out and prunes deployment
+//! history beyond its retention.
+//! Runs as a background task scheduled daily at the start of the `cleanup` settings'
+//! window (2 AM UTC by default). Deletes keep to the configured pace and stop when
+//! the window closes; a run never overlaps the next one.
 
 use chrono::Timelike as _;
+use serde::Serialize;
 use std::sync::Arc;
+use temps_core::CleanupSettings;
+use tokio::sync::{Mutex, RwLock};
 use tokio::time::{sleep, Duration};
 use tracing::{debug, error, info, warn};
+use utoipa::ToSchema;
+
+use super::{CleanupPace, DeploymentArtifactService, DeploymentRetentionService};
 
 /// Trait for Docker operations (mockable for testing)
 #[async_trait::async_trait]
@@ -25,6 +35,32 @@ pub struct PruneStats {
     pub space_reclaimed_mb: u64,
 }
 
+/// Outcome of a nightly cleanup run
+#[derive(Debug, Clone, Default, Serialize, ToSchema)]
+pub struct CleanupRunReport {
+    /// When the run started (ISO 8601)
+    pub started_at: String,
+    /// When the run completed (ISO 8601)
+    pub completed_at: String,
+    pub duration_seconds: u64,
+    /// Unused Docker images removed
+    pub images_deleted: u64,
+    /// Disk space freed by removing unused images, in megabytes
+    pub image_space_reclaimed_mb: u64,
+    /// Expired deployment artifacts removed
+    pub artifacts_removed: u64,
+    /// Storage freed by removing expired artifacts, in bytes
+    pub artifact_bytes_reclaimed: u64,
+    /// Deployments pruned beyond their retention
+    pub deployments_removed: u64,
+    /// Images of pruned deployments removed
+    pub deployment_images_removed: u64,
+    /// Whether the cleanup window closed before everything was deleted
+    pub window_closed: bool,
+    /// Steps of the run that failed
+    pub errors: Vec<String>,
+}
+
 /// Default Docker client implementation using the Docker daemon
 #[derive(Clone)]
 pub struct DefaultDockerClient;
@@ -107,6 +143,16 @@ pub struct DockerCleanupService {
     cleanup_hour: u32,
     /// Maximum number of days build cache can be unused before deletion (default: 7)
     max_cache_age_days: i64,
+    /// Expires retained deployment artifacts, when configured
+    artifact_service: Option<Arc<DeploymentArtifactService>>,
+    /// Prunes old deployments, when configured
+    retention_service: Option<Arc<DeploymentRetentionService>>,
+    /// Source of the cleanup window and pace; without it the cleanup starts at
+    /// `cleanup_hour` with the default pace
+    config_service: Option<Arc<temps_config::ConfigService>>,
+    /// Held for the length of a run, so runs never overlap
+    run_lock: Mutex<()>,
+    last_run: RwLock<Option<CleanupRunReport>>,
 }
 
 impl DockerCleanupService {
@@ -115,6 +161,11 @@ impl DockerCleanupService {
             docker_client,
             cleanup_hour: 2, // 2 AM UTC
             max_cache_age_days: 7,
+            artifact_service: None,
+            retention_service: None,
+            config_service: None,
+            run_lock: Mutex::new(()),
+            last_run: RwLock::new(None),
         }
     }
 
@@ -128,38 +179,77 @@ impl DockerCleanupService {
         self
     }
 
-    /// Calculate seconds until the next scheduled cleanup
-    fn seconds_until_next_cleanup(&self) -> u64 {
-        let now = chrono::Utc::now();
-
-        // Calculate target time (today at cleanup_hour)
-        let target_time = now
-            .with_hour(self.cleanup_hour)
-            .and_then(|t| t.with_minute(0))
-            .and_then(|t| t.with_second(0))
-            .expect("Failed to calculate target cleanup time");
-
-        let next_cleanup = if target_time > now {
-            // Cleanup time hasn't passed today
-            target_time
-        } else {
-            // Cleanup time already passed today, schedule for tomorrow
-            target_time + chrono::Duration::days(1)
+    pub fn with_artifact_service(
+        mut self,
+        artifact_service: Arc<DeploymentArtifactService>,
+    ) -> Self {
+        self.artifact_service = Some(artifact_service);
+        self
+    }
+
+    pub fn with_retention_service(
+        mut self,
+        retention_service: Arc<DeploymentRetentionService>,
+    ) -> Self {
+        self.retention_service = Some(retention_service);
+        self
+    }
+
+    pub fn with_config_service(mut self, config_service: Arc<temps_config::ConfigService>) -> Self {
+        self.config_service = Some(config_service);
+        self
+    }
+
+    /// Report of the last completed run since the server started
+    pub async fn last_run(&self) -> Option<CleanupRunReport> {
+        self.last_run.read().await.clone()
+    }
+
+    /// Whether a cleanup is running right now
+    pub fn is_running(&self) -> bool {
+        self.run_lock.try_lock().is_err()
+    }
+
+    /// Current cleanup settings, falling back to the defaults at `cleanup_hour`
+    async fn cleanup_settings(&self) -> CleanupSettings {
+        let defaults = CleanupSettings {
+            window_start_hour: self.cleanup_hour,
+            window_end_hour: (self.cleanup_hour + 4) % 24,
+            ..Default::default()
+        };
+        let Some(config_service) = &self.config_service else {
+            return defaults;
         };
+        match config_service.get_settings().await {
+            Ok(settings) => settings.cleanup,
+            Err(e) => {
+                warn!("Failed to load cleanup settings, using defaults: {}", e);
+                defaults
+            }
+        }
+    }
 
-        let duration = next_cleanup - now;
-        duration.num_seconds().max(0) as u64
+    /// Calculate seconds until the next scheduled cleanup
+    fn seconds_until_next_cleanup(&self) -> u64 {
+        seconds_until_hour(chrono::Utc::now(), self.cleanup_hour)
     }
 
     /// Start the cleanup scheduler (blocking, should be spawned in tokio task)
+    ///
+    /// The next start is computed once a run has completed, so a run that lasts past
+    /// the next start skips it rather than overlapping it.
     pub async fn start_cleanup_scheduler(&self) {
+        let settings = self.cleanup_settings().await;
         info!(
-            "Docker cleanup scheduler started (cleanup hour: {}:00 UTC)",
-            self.cleanup_hour
+            "Docker cleanup scheduler started (cleanup window: {}:00-{}:00 UTC)",
+            settings.window_start_hour(),
+            settings.window_end_hour % 24
         );
 
         loop {
-            let seconds_until_cleanup = self.seconds_until_next_cleanup();
+            let settings = self.cleanup_settings().await;
+            let seconds_until_cleanup =
+                seconds_until_hour(chrono::Utc::now(), settings.window_start_hour());
             let hours = seconds_until_cleanup / 3600;
             let minutes = (seconds_until_cleanup % 3600) / 60;
 
@@ -178,13 +268,27 @@ impl DockerCleanupService {
         }
     }
 
-    /// Perform the actual cleanup
-    async fn perform_cleanup(&self) {
+    /// Perform the actual cleanup, returning its report. Returns None without doing
+    /// anything when a cleanup is already running.
+    pub async fn perform_cleanup(&self) -> Option<CleanupRunReport> {
+        let Ok(_guard) = self.run_lock.try_lock() else {
+            warn!("⚠️ Previous cleanup is still running, skipping this run");
+            return None;
+        };
         info!("🧹 Starting nightly Docker cleanup");
 
+        let started_at = chrono::Utc::now();
+        let pace = CleanupPace::from_settings(&self.cleanup_settings().await);
+        let mut report = CleanupRunReport {
+            started_at: started_at.to_rfc3339_opts(chrono::SecondsFormat::Secs, true),
+            ..Default::default()
+        };
+
         // Cleanup unused images
         match self.docker_client.prune_images(true).await {
             Ok(stats) => {
+                report.images_deleted = stats.images_deleted;
+                report.image_space_reclaimed_mb = stats.space_reclaimed_mb;
                 if stats.images_deleted > 0 {
                     info!(
                         "✅ Removed {} unused Docker images, freed {} MB",
@@ -196,6 +300,9 @@ impl DockerCleanupService {
             }
             Err(e) => {
                 error!("❌ Failed to prune Docker images: {}", e);
+                report
+                    .errors
+                    .push(format!("Failed to prune Docker images: {}", e));
             }
         }
 
@@ -224,10 +331,89 @@ impl DockerCleanupService {
             }
         }
 
-        info!("🧹 Nightly Docker cleanup completed");
+        // Delete deployment artifacts past their retention
+        if let Some(artifact_service) = &self.artifact_service {
+            match artifact_service.delete_expired(&pace).await {
+                Ok((0, _)) => info!("✅ No expired deployment artifacts to remove"),
+                Ok((count, bytes)) => {
+                    info!(
+                        "✅ Removed {} expired deployment artifacts, freed {} MB",
+                        count,
+                        bytes / (1024 * 1024)
+                    );
+                    report.artifacts_removed = count;
+                    report.artifact_bytes_reclaimed = bytes;
+                }
+                Err(e) => {
+                    error!("❌ Failed to remove expired deployment artifacts: {}", e);
+                    report.errors.push(format!(
+                        "Failed to remove expired deployment artifacts: {}",
+                        e
+                    ));
+                }
+            }
+        }
+
+        // Prune deployment history beyond its retention
+        if let Some(retention_service) = &self.retention_service {
+            match retention_service.run_if_enabled(&pace).await {
+                Ok(None) => {}
+                Ok(Some(retention)) => {
+                    if retention.removed_deployments == 0 {
+                        info!("✅ No deployments beyond their retention to remove")
+                    } else {
+                        info!(
+                            "✅ Pruned {} old deployments and {} of their images",
+                            retention.removed_deployments, retention.removed_images
+                        )
+                    }
+                    report.deployments_removed = retention.removed_deployments;
+                    report.deployment_images_removed = retention.removed_images;
+                    report.window_closed |= retention.interrupted;
+                }
+                Err(e) => {
+                    error!("❌ Failed to prune old deployments: {}", e);
+                    report
+                        .errors
+                        .push(format!("Failed to prune old deployments: {}", e));
+                }
+            }
+        }
+
+        let completed_at = chrono::Utc::now();
+        report.window_closed |= pace.window_closed();
+        report.completed_at = completed_at.to_rfc3339_opts(chrono::SecondsFormat::Secs, true);
+        report.duration_seconds = (completed_at - started_at).num_seconds().max(0) as u64;
+        info!(
+            "🧹 Nightly Docker cleanup completed in {}s",
+            report.duration_seconds
+        );
+        *self.last_run.write().await = Some(report.clone());
+        Some(report)
     }
 }
 
+/// Seconds from `now` until the next time the clock reads `hour`:00 UTC
+fn seconds_until_hour(now: chrono::DateTime<chrono::Utc>, hour: u32) -> u64 {
+    // Calculate target time (today at hour)
+    let target_time = now
+        .with_hour(hour % 24)
+        .and_then(|t| t.with_minute(0))
+        .and_then(|t| t.with_second(0))
+        .expect("Failed to calculate target cleanup time");
+
+    let next_cleanup = if target_time > now {
+        // Cleanup time hasn't passed today
+        target_time
+    } else {
+        // Cleanup time already passed today, schedule for tomorrow
+        target_time + chrono::Duration::days(1)
+    };
+
+    let duration = next_cleanup - now;
+    duration.num_seconds().max(0) as u64
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -287,6 +473,38 @@ mod tests {
         let service = DockerCleanupService::new(Arc::new(mock));
 
         // Test cleanup runs without error
-        service.perform_cleanup().await;
+        let report = service.perform_cleanup().await.unwrap();
+        assert_eq!(report.images_deleted, 5);
+        assert_eq!(report.image_space_reclaimed_mb, 1024);
+        assert!(report.errors.is_empty());
+        assert_eq!(service.last_run().await.unwrap().images_deleted, 5);
+        assert!(!service.is_running());
+    }
+
+    #[tokio::test]
+    async fn test_overlapping_cleanup_is_skipped() {
+        let mock = MockDockerClient {
+            prune_images_result: Err("daemon unavailable".to_string()),
+            prune_cache_result: Ok(String::new()),
+        };
+        let service = DockerCleanupService::new(Arc::new(mock));
+
+        let guard = service.run_lock.lock().await;
+        assert!(service.is_running());
+        assert!(service.perform_cleanup().await.is_none());
+        drop(guard);
+
+        let report = service.perform_cleanup().await.unwrap();
+        assert_eq!(report.errors.len(), 1);
+    }
+
+    #[test]
+    fn test_seconds_until_hour() {
+        let now = chrono::DateTime::parse_from_rfc3339("2026-10-14T01:30:00Z")
+            .unwrap()
+            .with_timezone(&chrono::Utc);
+        assert_eq!(seconds_until_hour(now, 2), 30 * 60);
+        // Already past today's start: tomorrow
+        assert_eq!(seconds_until_hour(now, 1), 24 * 3600 - 30 * 60);
     }
 }
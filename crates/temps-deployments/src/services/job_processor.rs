@@ -13,6 +13,9 @@ use temps_entities::{
 };
 use tracing::{debug, error, info, warn};
 
+/// Trigger recorded in the context of deployments a person started
+pub const MANUAL_TRIGGER: &str = "manual";
+
 #[derive(Debug)]
 pub enum JobProcessorError {
     QueueError(String),
@@ -266,6 +269,104 @@ impl JobProcessorService {
     }
 }
 
+/// Find a non-deleted environment belonging to the project
+async fn find_project_environment(
+    db: Arc<DbConnection>,
+    project: &temps_entities::projects::Model,
+    environment_id: i32,
+) -> Result<temps_entities::environments::Model, String> {
+    use temps_entities::environments;
+
+    environments::Entity::find_by_id(environment_id)
+        .filter(environments::Column::ProjectId.eq(project.id))
+        .filter(environments::Column::DeletedAt.is_null())
+        .one(db.as_ref())
+        .await
+        .map_err(|e| format!("Database error finding environment: {}", e))?
+        .ok_or_else(|| "Environment not found for project".to_string())
+}
+
+/// Effective auto-deploy tag pattern of an environment (environment overrides project)
+fn effective_tag_pattern(
+    project_config: Option<&temps_entities::deployment_config::DeploymentConfig>,
+    environment_config: Option<&temps_entities::deployment_config::DeploymentConfig>,
+) -> Option<String> {
+    let config = match (project_config, environment_config) {
+        (Some(project_config), Some(env_config)) => project_config.merge(env_config),
+        (Some(config), None) | (None, Some(config)) => config.clone(),
+        (None, None) => return None,
+    };
+    config.auto_deploy_tag_pattern
+}
+
+/// Find environments whose auto-deploy tag pattern matches the pushed tag
+///
+/// Returns `None` when no environment of the project has a tag pattern configured,
+/// in which case the push is routed by branch as before.
+async fn find_environments_for_tag(
+    db: Arc<DbConnection>,
+    project: &temps_entities::projects::Model,
+    tag: &str,
+) -> Result<Option<Vec<temps_entities::environments::Model>>, String> {
+    use temps_entities::environments;
+
+    let project_environments = environments::Entity::find()
+        .filter(environments::Column::ProjectId.eq(project.id))
+        .filter(environments::Column::DeletedAt.is_null())
+        .all(db.as_ref())
+        .await
+        .map_err(|e| format!("Database error finding environments: {}", e))?;
+
+    let mut has_pattern = false;
+    let mut matched = Vec::new();
+    for environment in project_environments {
+        let Some(pattern) = effective_tag_pattern(
+            project.deployment_config.as_ref(),
+            environment.deployment_config.as_ref(),
+        ) else {
+            continue;
+        };
+        has_pattern = true;
+        if temps_core::ref_matches_pattern(&pattern, tag) {
+            info!(
+                "Tag '{}' matches pattern '{}' of environment '{}'",
+                tag, pattern, environment.name
+            );
+            matched.push(environment);
+        }
+    }
+
+    Ok(has_pattern.then_some(matched))
+}
+
+/// Queue one push event per environment the tag deploys to
+async fn queue_tag_deployments(
+    queue: &dyn JobQueue,
+    job: &temps_core::GitPushEventJob,
+    environments: &[temps_entities::environments::Model],
+) {
+    if environments.is_empty() {
+        info!(
+            "Tag {:?} pushed to {}/{} matches no environment tag pattern, skipping deployment",
+            job.tag, job.owner, job.repo
+        );
+        return;
+    }
+
+    for environment in environments {
+        let env_job = temps_core::GitPushEventJob {
+            environment_id: Some(environment.id),
+            ..job.clone()
+        };
+        if let Err(e) = queue.send(Job::GitPushEvent(env_job)).await {
+            error!(
+                "Failed to queue tag deployment for environment {}: {}",
+                environment.id, e
+            );
+        }
+    }
+}
+
 /// Find environment matching the branch, or create/use preview environment
 async fn find_or_create_environment_for_branch(
     db: Arc<DbConnection>,
@@ -472,6 +573,7 @@ async fn create_preview_environment(
 
 /// Copy project environment variables marked for preview to a preview environment
 /// Creates junction table entries linking env vars with include_in_preview=true to the new environment
+/// Shared variables aren't linked: preview environments get the ones included in previews anyway
 async fn copy_environment_variables_to_preview(
     db: Arc<DbConnection>,
     preview_environment_id: i32,
@@ -483,6 +585,7 @@ async fn copy_environment_variables_to_preview(
     let preview_env_vars = env_vars::Entity::find()
         .filter(env_vars::Column::ProjectId.eq(project_id))
         .filter(env_vars::Column::IncludeInPreview.eq(true))
+        .filter(env_vars::Column::Shared.eq(false))
         .all(db.as_ref())
         .await
         .map_err(|e| format!("Failed to query project environment variables: {}", e))?;
@@ -576,20 +679,57 @@ async fn process_git_push_event(
         }
     };
 
-    // Find environment matching the branch, or fallback to preview environment
-    let environment =
-        match find_or_create_environment_for_branch(db.clone(), &project, job.branch.as_deref())
-            .await
-        {
-            Ok(env) => env,
-            Err(e) => {
-                error!(
-                    "Failed to find or create environment for project {}: {}",
-                    project.id, e
-                );
-                return;
+    // Tag pushes are routed to the environments whose tag pattern matches
+    if job.environment_id.is_none() {
+        if let Some(tag) = job.tag.as_deref() {
+            match find_environments_for_tag(db.clone(), &project, tag).await {
+                Ok(Some(environments)) => {
+                    queue_tag_deployments(queue.as_ref(), &job, &environments).await;
+                    return;
+                }
+                // No environment auto-deploys tags, fall back to branch routing
+                Ok(None) => {}
+                Err(e) => {
+                    error!(
+                        "Failed to find environments for tag {} in project {}: {}",
+                        tag, project.id, e
+                    );
+                    return;
+                }
             }
-        };
+        }
+    }
+
+    // Use the requested environment, or find the one matching the branch,
+    // falling back to the preview environment
+    let environment = match job.environment_id {
+        Some(environment_id) => {
+            match find_project_environment(db.clone(), &project, environment_id).await {
+                Ok(env) => env,
+                Err(e) => {
+                    error!(
+                        "Failed to find environment {} for project {}: {}",
+                        environment_id, project.id, e
+                    );
+                    return;
+                }
+            }
+        }
+        None => {
+            match find_or_create_environment_for_branch(db.clone(), &project, job.branch.as_deref())
+                .await
+            {
+                Ok(env) => env,
+                Err(e) => {
+                    error!(
+                        "Failed to find or create environment for project {}: {}",
+                        project.id, e
+                    );
+                    return;
+                }
+            }
+        }
+    };
 
     // Check for duplicate deployment (same project, environment, and commit)
     // This prevents duplicate deployments from being created if:
@@ -597,14 +737,20 @@ async fn process_git_push_event(
     // - GitHub sends duplicate webhooks
     // - Race condition between concurrent push events
     use sea_orm::{EntityTrait, PaginatorTrait, QueryOrder};
+    // A dry run doesn't stand in for a deploy of the same commit, nor the reverse
     let existing_deployment = deployments::Entity::find()
         .filter(deployments::Column::ProjectId.eq(project.id))
         .filter(deployments::Column::EnvironmentId.eq(environment.id))
         .filter(deployments::Column::CommitSha.eq(&job.commit))
         .filter(deployments::Column::State.is_in(vec!["pending", "running", "deploying", "ready"]))
         .order_by_desc(deployments::Column::CreatedAt)
-        .one(db.as_ref())
-        .await;
+        .all(db.as_ref())
+        .await
+        .map(|deployments| {
+            deployments
+                .into_iter()
+                .find(|deployment| deployment.is_dry_run() == job.dry_run)
+        });
 
     if let Ok(Some(existing)) = existing_deployment {
         info!(
@@ -689,7 +835,11 @@ async fn process_git_push_event(
             repo: job.repo.clone(),
             branch: job.branch.clone().unwrap_or_default(),
             commit: job.commit.clone(),
+            tag: job.tag.clone(),
         }),
+        release_note: job.release_note.clone(),
+        triggered_by_user_id: job.triggered_by,
+        dry_run: job.dry_run,
         ..Default::default()
     };
 
@@ -707,10 +857,17 @@ async fn process_git_push_event(
         commit_author: sea_orm::Set(commit_info.as_ref().map(|c| c.author.clone())),
         started_at: sea_orm::Set(None),
         finished_at: sea_orm::Set(None),
-        context_vars: sea_orm::Set(Some(serde_json::json!({
-            "trigger": "git_push",
-            "source": "webhook"
-        }))),
+        context_vars: sea_orm::Set(Some(if job.manual {
+            serde_json::json!({
+                "trigger": MANUAL_TRIGGER,
+                "source": "api"
+            })
+        } else {
+            serde_json::json!({
+                "trigger": "git_push",
+                "source": "webhook"
+            })
+        })),
         deploying_at: sea_orm::Set(None),
         ready_at: sea_orm::Set(None),
         static_dir_location: sea_orm::Set(None),
@@ -757,19 +914,21 @@ async fn process_git_push_event(
         );
     }
 
-    // Update project's last_deployment timestamp
-    let mut active_project: temps_entities::projects::ActiveModel = project.clone().into();
-    active_project.last_deployment = sea_orm::Set(Some(Utc::now()));
-    if let Err(e) = active_project.update(db.as_ref()).await {
-        error!(
-            "Failed to update last_deployment for project {}: {}",
-            project.id, e
-        );
-    } else {
-        debug!(
-            "Updated last_deployment timestamp for project {}",
-            project.id
-        );
+    // Update project's last_deployment timestamp; a dry run ships nothing
+    if !job.dry_run {
+        let mut active_project: temps_entities::projects::ActiveModel = project.clone().into();
+        active_project.last_deployment = sea_orm::Set(Some(Utc::now()));
+        if let Err(e) = active_project.update(db.as_ref()).await {
+            error!(
+                "Failed to update last_deployment for project {}: {}",
+                project.id, e
+            );
+        } else {
+            debug!(
+                "Updated last_deployment timestamp for project {}",
+                project.id
+            );
+        }
     }
 
     // Create jobs for this deployment using the workflow planner
@@ -1012,6 +1171,11 @@ mod tests {
             tag: None,
             commit: "abc123".to_string(),
             project_id: 0,
+            environment_id: None,
+            manual: false,
+            release_note: None,
+            triggered_by: None,
+            dry_run: false,
         };
 
         // Try to find the project (should return None)
@@ -1751,4 +1915,32 @@ mod tests {
 
         Ok(())
     }
+
+    #[test]
+    fn test_effective_tag_pattern_environment_overrides_project() {
+        use temps_entities::deployment_config::DeploymentConfig;
+
+        let project_config = DeploymentConfig {
+            auto_deploy_tag_pattern: Some("v*".to_string()),
+            ..Default::default()
+        };
+        let env_config = DeploymentConfig {
+            auto_deploy_tag_pattern: Some("release-*".to_string()),
+            ..Default::default()
+        };
+
+        assert_eq!(effective_tag_pattern(None, None), None);
+        assert_eq!(
+            effective_tag_pattern(Some(&project_config), None),
+            Some("v*".to_string())
+        );
+        assert_eq!(
+            effective_tag_pattern(Some(&project_config), Some(&env_config)),
+            Some("release-*".to_string())
+        );
+        assert_eq!(
+            effective_tag_pattern(Some(&project_config), Some(&DeploymentConfig::default())),
+            Some("v*".to_string())
+        );
+    }
 }
@@ -0,0 +1,369 @@
+//! Log Streams
+//!
+//! Caps how many live log tails are open at once: across the server, for one user
+//! and on the logs of one project. A tail takes a permit before it starts streaming
+//! and gives it back when it ends, however it ends. Tails over a limit are rejected
+//! rather than queued. The permit also carries when the tail has to close: after
+//! sending nothing for the idle timeout, or once it has been open for the maximum
+//! duration. Limits are read from the log stream settings for every new tail, so
+//! changes apply without a restart; tails already open keep the timeouts they
+//! started with.
+
+use serde::Serialize;
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+use temps_core::LogStreamSettings;
+use thiserror::Error;
+use tokio::time::Instant;
+use tracing::warn;
+use utoipa::ToSchema;
+
+/// A limit a new log stream ran into
+#[derive(Error, Debug, Clone, Copy, PartialEq, Eq)]
+pub enum LogStreamLimitExceeded {
+    #[error("The server already has {0} log streams open, the most it allows")]
+    Total(usize),
+
+    #[error("You already have {0} log streams open, the most one user can have")]
+    User(usize),
+
+    #[error("This project already has {0} log streams open, the most one project can have")]
+    Project(usize),
+}
+
+impl LogStreamLimitExceeded {
+    pub fn limit(&self) -> usize {
+        match self {
+            Self::Total(limit) | Self::User(limit) | Self::Project(limit) => *limit,
+        }
+    }
+}
+
+/// Why a log stream was closed by the server
+#[derive(Error, Debug, Clone, Copy, PartialEq, Eq)]
+pub enum LogStreamClosed {
+    /// Nothing was sent for the idle timeout
+    #[error("No new logs for a while; reconnect to resume")]
+    Idle,
+    /// The stream was open for the maximum duration
+    #[error("Log stream open for its maximum duration; reconnect to resume")]
+    MaxDuration,
+}
+
+impl LogStreamClosed {
+    pub fn reason(&self) -> &'static str {
+        match self {
+            Self::Idle => "idle",
+            Self::MaxDuration => "max_duration",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy)]
+struct StreamLimits {
+    total: usize,
+    per_user: usize,
+    per_project: usize,
+}
+
+/// Open streams, in total and by user and project
+#[derive(Debug, Default)]
+struct StreamCounts {
+    total: usize,
+    by_user: HashMap<i32, usize>,
+    by_project: HashMap<i32, usize>,
+}
+
+impl StreamCounts {
+    fn try_open(
+        &mut self,
+        user_id: i32,
+        project_id: i32,
+        limits: StreamLimits,
+    ) -> Result<(), LogStreamLimitExceeded> {
+        if self.total >= limits.total {
+            return Err(LogStreamLimitExceeded::Total(limits.total));
+        }
+        if self.by_user.get(&user_id).copied().unwrap_or(0) >= limits.per_user {
+            return Err(LogStreamLimitExceeded::User(limits.per_user));
+        }
+        if self.by_project.get(&project_id).copied().unwrap_or(0) >= limits.per_project {
+            return Err(LogStreamLimitExceeded::Project(limits.per_project));
+        }
+        self.total += 1;
+        *self.by_user.entry(user_id).or_insert(0) += 1;
+        *self.by_project.entry(project_id).or_insert(0) += 1;
+        Ok(())
+    }
+
+    fn close(&mut self, user_id: i32, project_id: i32) {
+        self.total = self.total.saturating_sub(1);
+        decrement(&mut self.by_user, user_id);
+        decrement(&mut self.by_project, project_id);
+    }
+}
+
+fn decrement(counts: &mut HashMap<i32, usize>, id: i32) {
+    if let Some(count) = counts.get_mut(&id) {
+        *count = count.saturating_sub(1);
+        if *count == 0 {
+            counts.remove(&id);
+        }
+    }
+}
+
+/// An open log stream's place under the limits; it is given back when this is dropped
+#[derive(Debug)]
+pub struct LogStreamPermit {
+    counts: Arc<Mutex<StreamCounts>>,
+    user_id: i32,
+    project_id: i32,
+    idle_timeout: Duration,
+    deadline: Instant,
+}
+
+impl LogStreamPermit {
+    /// Why the stream has to close, given when it last sent something; None while it
+    /// can stay open
+    pub fn closed_since(&self, last_sent: Instant) -> Option<LogStreamClosed> {
+        let now = Instant::now();
+        if now >= self.deadline {
+            Some(LogStreamClosed::MaxDuration)
+        } else if now >= last_sent + self.idle_timeout {
+            Some(LogStreamClosed::Idle)
+        } else {
+            None
+        }
+    }
+
+    /// Next item of a stream, unless the log stream has to close before one comes
+    ///
+    /// Each item restarts the idle timeout.
+    pub async fn next<S>(&self, stream: &mut S) -> Result<Option<S::Item>, LogStreamClosed>
+    where
+        S: futures::Stream + Unpin,
+    {
+        use futures::StreamExt;
+
+        if Instant::now() >= self.deadline {
+            return Err(LogStreamClosed::MaxDuration);
+        }
+        let idle_deadline = Instant::now() + self.idle_timeout;
+        let until = idle_deadline.min(self.deadline);
+        match tokio::time::timeout_at(until, stream.next()).await {
+            Ok(item) => Ok(item),
+            Err(_) if until == self.deadline => Err(LogStreamClosed::MaxDuration),
+            Err(_) => Err(LogStreamClosed::Idle),
+        }
+    }
+}
+
+impl Drop for LogStreamPermit {
+    fn drop(&mut self) {
+        self.counts
+            .lock()
+            .unwrap()
+            .close(self.user_id, self.project_id);
+    }
+}
+
+/// Open log streams of a user
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct UserLogStreams {
+    pub user_id: i32,
+    pub active: usize,
+}
+
+/// Open log streams on the logs of a project
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ProjectLogStreams {
+    pub project_id: i32,
+    pub active: usize,
+}
+
+/// Open log streams and the limits on them
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct LogStreamStatus {
+    pub max_streams: usize,
+    pub max_streams_per_user: usize,
+    pub max_streams_per_project: usize,
+    pub idle_timeout_seconds: u64,
+    pub max_duration_seconds: u64,
+    /// Log streams open across all users
+    pub active: usize,
+    /// Users with open streams, most streams first
+    pub by_user: Vec<UserLogStreams>,
+    /// Projects with open streams, most streams first
+    pub by_project: Vec<ProjectLogStreams>,
+}
+
+/// Limits on concurrently open log tail streams
+pub struct LogStreamLimiter {
+    config_service: Arc<temps_config::ConfigService>,
+    counts: Arc<Mutex<StreamCounts>>,
+}
+
+impl LogStreamLimiter {
+    pub fn new(config_service: Arc<temps_config::ConfigService>) -> Self {
+        Self {
+            config_service,
+            counts: Arc::new(Mutex::new(StreamCounts::default())),
+        }
+    }
+
+    async fn settings(&self) -> LogStreamSettings {
+        match self.config_service.get_settings().await {
+            Ok(settings) => settings.log_streams,
+            Err(e) => {
+                warn!("Failed to load log stream settings, using defaults: {}", e);
+                LogStreamSettings::default()
+            }
+        }
+    }
+
+    /// Take a permit for a new log stream of a user on a project's logs
+    pub async fn acquire(
+        &self,
+        user_id: i32,
+        project_id: i32,
+    ) -> Result<LogStreamPermit, LogStreamLimitExceeded> {
+        let settings = self.settings().await;
+        let limits = StreamLimits {
+            total: settings.max_streams(),
+            per_user: settings.max_streams_per_user(),
+            per_project: settings.max_streams_per_project(),
+        };
+        self.counts
+            .lock()
+            .unwrap()
+            .try_open(user_id, project_id, limits)?;
+
+        Ok(LogStreamPermit {
+            counts: self.counts.clone(),
+            user_id,
+            project_id,
+            idle_timeout: settings.idle_timeout(),
+            deadline: Instant::now() + settings.max_duration(),
+        })
+    }
+
+    /// Open log streams, in total and by user and project
+    pub async fn status(&self) -> LogStreamStatus {
+        let settings = self.settings().await;
+        let (active, mut by_user, mut by_project) = {
+            let counts = self.counts.lock().unwrap();
+            (
+                counts.total,
+                counts
+                    .by_user
+                    .iter()
+                    .map(|(&user_id, &active)| UserLogStreams { user_id, active })
+                    .collect::<Vec<_>>(),
+                counts
+                    .by_project
+                    .iter()
+                    .map(|(&project_id, &active)| ProjectLogStreams { project_id, active })
+                    .collect::<Vec<_>>(),
+            )
+        };
+        by_user.sort_by(|a, b| b.active.cmp(&a.active).then(a.user_id.cmp(&b.user_id)));
+        by_project.sort_by(|a, b| {
+            b.active
+                .cmp(&a.active)
+                .then(a.project_id.cmp(&b.project_id))
+        });
+
+        LogStreamStatus {
+            max_streams: settings.max_streams(),
+            max_streams_per_user: settings.max_streams_per_user(),
+            max_streams_per_project: settings.max_streams_per_project(),
+            idle_timeout_seconds: settings.idle_timeout().as_secs(),
+            max_duration_seconds: settings.max_duration().as_secs(),
+            active,
+            by_user,
+            by_project,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const LIMITS: StreamLimits = StreamLimits {
+        total: 3,
+        per_user: 2,
+        per_project: 2,
+    };
+
+    #[test]
+    fn test_stream_counts_limits() {
+        let mut counts = StreamCounts::default();
+        assert!(counts.try_open(1, 10, LIMITS).is_ok());
+        assert!(counts.try_open(1, 11, LIMITS).is_ok());
+        assert_eq!(
+            counts.try_open(1, 12, LIMITS),
+            Err(LogStreamLimitExceeded::User(2))
+        );
+        assert!(counts.try_open(2, 10, LIMITS).is_ok());
+        assert_eq!(
+            counts.try_open(3, 10, LIMITS),
+            Err(LogStreamLimitExceeded::Total(3))
+        );
+
+        counts.close(1, 11);
+        assert_eq!(
+            counts.try_open(3, 10, LIMITS),
+            Err(LogStreamLimitExceeded::Project(2))
+        );
+        assert!(counts.try_open(3, 12, LIMITS).is_ok());
+    }
+
+    #[test]
+    fn test_stream_counts_close_forgets_idle_users_and_projects() {
+        let mut counts = StreamCounts::default();
+        counts.try_open(1, 10, LIMITS).unwrap();
+        counts.close(1, 10);
+        assert_eq!(counts.total, 0);
+        assert!(counts.by_user.is_empty());
+        assert!(counts.by_project.is_empty());
+
+        // Closing more than was opened doesn't underflow
+        counts.close(1, 10);
+        assert_eq!(counts.total, 0);
+    }
+
+    fn permit(idle_timeout: Duration, max_duration: Duration) -> LogStreamPermit {
+        LogStreamPermit {
+            counts: Arc::new(Mutex::new(StreamCounts::default())),
+            user_id: 1,
+            project_id: 10,
+            idle_timeout,
+            deadline: Instant::now() + max_duration,
+        }
+    }
+
+    #[tokio::test]
+    async fn test_permit_closes_idle_and_long_streams() {
+        let idle = permit(Duration::from_millis(20), Duration::from_secs(60));
+        let mut silent = futures::stream::pending::<()>();
+        assert_eq!(idle.next(&mut silent).await, Err(LogStreamClosed::Idle));
+        assert_eq!(idle.closed_since(Instant::now()), None);
+        assert_eq!(
+            idle.closed_since(Instant::now() - Duration::from_secs(1)),
+            Some(LogStreamClosed::Idle)
+        );
+
+        let expired = permit(Duration::from_secs(60), Duration::ZERO);
+        let mut lines = futures::stream::iter(["line"]);
+        assert_eq!(
+            expired.next(&mut lines).await,
+            Err(LogStreamClosed::MaxDuration)
+        );
+        assert_eq!(
+            expired.closed_since(Instant::now()),
+            Some(LogStreamClosed::MaxDuration)
+        );
+    }
+}
@@ -31,3 +31,78 @@ pub use docker_cleanup_service::*;
 
 pub mod deployment_token_service;
 pub use deployment_token_service::*;
+
+pub mod container_reconciler;
+pub use container_reconciler::*;
+
+pub mod resource_gc_service;
+pub use resource_gc_service::*;
+
+pub mod disk_space_guard;
+pub use disk_space_guard::*;
+
+pub mod build_queue;
+pub use build_queue::*;
+
+pub mod deploy_retry;
+pub use deploy_retry::*;
+
+pub mod project_lifecycle;
+pub use project_lifecycle::*;
+
+pub mod deployment_artifacts;
+pub use deployment_artifacts::*;
+
+pub mod promotions;
+pub use promotions::*;
+
+pub mod deploy_gates;
+pub use deploy_gates::*;
+
+pub mod build_cache;
+pub use build_cache::*;
+
+pub mod deployment_retention;
+pub use deployment_retention::*;
+
+pub mod build_alerts;
+pub use build_alerts::*;
+
+pub mod base_image_updates;
+pub use base_image_updates::*;
+
+pub mod container_metrics;
+pub use container_metrics::*;
+
+pub mod resource_alerts;
+pub use resource_alerts::*;
+
+pub mod changelog;
+pub use changelog::*;
+
+pub mod cleanup_pace;
+pub use cleanup_pace::*;
+
+pub mod artifact_deploys;
+pub use artifact_deploys::*;
+
+pub mod build_nodes;
+pub use build_nodes::*;
+
+pub mod cron_schedule;
+pub use cron_schedule::*;
+
+pub mod deploy_status;
+pub use deploy_status::*;
+
+pub mod runtime_config;
+pub use runtime_config::*;
+
+pub mod build_phases;
+pub use build_phases::*;
+
+pub mod success_kind;
+pub use success_kind::*;
+
+pub mod log_streams;
+pub use log_streams::*;
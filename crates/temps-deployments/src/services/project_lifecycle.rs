@@ -0,0 +1,1560 @@
+//! Project Lifecycle
+//!
+//! Starts, stops and restarts a whole project: the managed services linked to it and
+//! the containers of all its environments. Linked services start in dependency order,
+//! each waiting for the services it depends on to accept connections, and the app
+//! containers, which depend on every linked service, start last. Stopping runs in
+//! reverse.
+//!
+//! A project-wide deploy starts the linked services the same way, then rebuilds and
+//! redeploys the project's environments a few at a time. The number running at once
+//! is the project's deploy concurrency, capped by the global build queue limit.
+//!
+//! Bulk operations start, stop, restart or redeploy a chosen set of a project's
+//! services and environments in the background, in the same order and a limited
+//! number at a time, recording how each one went instead of stopping at the first
+//! failure.
+
+use chrono::{DateTime, Utc};
+use futures::future::join_all;
+use futures::stream::{self, StreamExt};
+use sea_orm::{ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, QueryOrder};
+use serde::{Deserialize, Serialize};
+use std::collections::{HashMap, HashSet};
+use std::sync::Arc;
+use std::time::Duration;
+use temps_entities::tags::Tags;
+use temps_entities::{deployments, environments, external_services, project_services, projects};
+use temps_providers::{DependencyError, ServiceDependencyManager};
+use tokio::sync::RwLock;
+use tracing::{error, info, warn};
+use utoipa::ToSchema;
+
+use super::{BuildQueue, DeploymentError, DeploymentService, PromotionService};
+
+/// How often a project-wide deploy checks on the deployments it triggered
+const DEPLOY_POLL_INTERVAL: Duration = Duration::from_secs(5);
+/// How long to wait for a triggered deployment to show up before assuming it was
+/// skipped (e.g. the same commit is already deployed or deploying)
+const DEPLOY_START_TIMEOUT: Duration = Duration::from_secs(120);
+/// Longest a single environment may take to build and deploy
+const DEPLOY_TIMEOUT: Duration = Duration::from_secs(60 * 60);
+
+/// What a project start/stop/restart acted on, in order
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ProjectLifecycleReport {
+    pub project_id: i32,
+    /// Managed service IDs grouped in the order they were started (or stopped);
+    /// services in the same group were handled in parallel
+    pub service_layers: Vec<Vec<i32>>,
+    /// Environments whose containers were started (or stopped)
+    pub environment_ids: Vec<i32>,
+}
+
+impl From<DependencyError> for DeploymentError {
+    fn from(error: DependencyError) -> Self {
+        match error {
+            DependencyError::Cycle(_) | DependencyError::Validation(_) => {
+                DeploymentError::InvalidInput(error.to_string())
+            }
+            _ => DeploymentError::DeploymentError(error.to_string()),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ProjectDeployState {
+    /// Linked services are being started in dependency order
+    StartingServices,
+    /// Environments are being built and deployed
+    Deploying,
+    /// Every environment finished (some may have failed)
+    Completed,
+    /// The deploy could not run, e.g. a linked service failed to start
+    Failed,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum EnvironmentDeployStatus {
+    /// Waiting for a free deploy slot
+    Pending,
+    /// Deployment triggered and in progress
+    Running,
+    Succeeded,
+    Failed,
+    /// No new deployment was created, e.g. the commit is already deployed
+    Skipped,
+}
+
+/// Progress of one environment within a project-wide deploy
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct EnvironmentDeployProgress {
+    pub environment_id: i32,
+    pub environment_name: String,
+    pub status: EnvironmentDeployStatus,
+    pub deployment_id: Option<i32>,
+    pub error: Option<String>,
+}
+
+/// Aggregate progress of a project-wide deploy
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ProjectDeployProgress {
+    pub run_id: String,
+    pub project_id: i32,
+    pub state: ProjectDeployState,
+    /// Environments deployed at the same time
+    pub concurrency: usize,
+    /// Managed service IDs grouped in the order they were started
+    pub service_layers: Vec<Vec<i32>>,
+    pub total: usize,
+    pub pending: usize,
+    pub running: usize,
+    pub succeeded: usize,
+    pub failed: usize,
+    pub skipped: usize,
+    pub environments: Vec<EnvironmentDeployProgress>,
+    pub started_at: DateTime<Utc>,
+    pub finished_at: Option<DateTime<Utc>>,
+    pub error: Option<String>,
+}
+
+impl ProjectDeployProgress {
+    fn recount(&mut self) {
+        let count = |status: EnvironmentDeployStatus| {
+            self.environments
+                .iter()
+                .filter(|env| env.status == status)
+                .count()
+        };
+        self.total = self.environments.len();
+        self.pending = count(EnvironmentDeployStatus::Pending);
+        self.running = count(EnvironmentDeployStatus::Running);
+        self.succeeded = count(EnvironmentDeployStatus::Succeeded);
+        self.failed = count(EnvironmentDeployStatus::Failed);
+        self.skipped = count(EnvironmentDeployStatus::Skipped);
+    }
+
+    fn is_finished(&self) -> bool {
+        matches!(
+            self.state,
+            ProjectDeployState::Completed | ProjectDeployState::Failed
+        )
+    }
+}
+
+/// Environments to deploy at once: the project's own limit (or the default), never
+/// more than the global build limit and never less than one
+pub fn effective_deploy_concurrency(
+    project_concurrency: Option<i32>,
+    default_concurrency: usize,
+    max_concurrent_builds: usize,
+) -> usize {
+    project_concurrency
+        .filter(|n| *n > 0)
+        .map(|n| n as usize)
+        .unwrap_or(default_concurrency)
+        .min(max_concurrent_builds)
+        .max(1)
+}
+
+/// Targets a bulk operation acts on at once when not requested otherwise
+pub const DEFAULT_BULK_CONCURRENCY: usize = 4;
+/// Most targets a bulk operation may act on at once
+pub const MAX_BULK_CONCURRENCY: usize = 16;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum BulkAction {
+    Start,
+    Stop,
+    Restart,
+    /// Start the services, then rebuild and redeploy the environments
+    Redeploy,
+}
+
+impl BulkAction {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            BulkAction::Start => "start",
+            BulkAction::Stop => "stop",
+            BulkAction::Restart => "restart",
+            BulkAction::Redeploy => "redeploy",
+        }
+    }
+
+    fn stops(&self) -> bool {
+        matches!(self, BulkAction::Stop | BulkAction::Restart)
+    }
+
+    fn starts(&self) -> bool {
+        !matches!(self, BulkAction::Stop)
+    }
+}
+
+/// A bulk operation across a project's services and environments
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct BulkOperationRequest {
+    pub action: BulkAction,
+    /// Environments whose containers are acted on (default: all of the project's
+    /// environments; for redeploys, all but previews)
+    #[serde(default)]
+    pub environment_ids: Option<Vec<i32>>,
+    /// Linked managed services acted on (default: all linked services). Services
+    /// shared with other projects are never stopped.
+    #[serde(default)]
+    pub service_ids: Option<Vec<i32>>,
+    /// Only the services among those having all of these tags
+    #[serde(default)]
+    pub service_tags: Option<Tags>,
+    /// Targets acted on at once (default: 4; for redeploys the project's deploy
+    /// concurrency). Redeploys are capped by the global build limit.
+    #[serde(default)]
+    pub concurrency: Option<usize>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum BulkOperationState {
+    Running,
+    /// Every target was handled (some may have failed)
+    Completed,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum BulkTargetKind {
+    /// A managed service linked to the project
+    Service,
+    /// The containers of an environment
+    Environment,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum BulkTargetStatus {
+    /// Waiting for its dependencies or a free slot
+    Pending,
+    Running,
+    Succeeded,
+    Failed,
+    /// Left alone, e.g. a shared service on stop, or a dependency failed
+    Skipped,
+}
+
+/// Progress of one service or environment within a bulk operation
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct BulkTargetProgress {
+    pub kind: BulkTargetKind,
+    pub id: i32,
+    pub name: String,
+    pub status: BulkTargetStatus,
+    /// Deployment created by a redeploy
+    pub deployment_id: Option<i32>,
+    pub error: Option<String>,
+}
+
+/// Progress, and once finished the summary, of a bulk operation
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct BulkOperationProgress {
+    pub run_id: String,
+    pub project_id: i32,
+    pub action: BulkAction,
+    pub state: BulkOperationState,
+    /// Targets acted on at once
+    pub concurrency: usize,
+    pub total: usize,
+    pub pending: usize,
+    pub running: usize,
+    pub succeeded: usize,
+    pub failed: usize,
+    pub skipped: usize,
+    /// Services in dependency order, then environments
+    pub targets: Vec<BulkTargetProgress>,
+    pub started_at: DateTime<Utc>,
+    pub finished_at: Option<DateTime<Utc>>,
+}
+
+impl BulkOperationProgress {
+    fn recount(&mut self) {
+        let count = |status: BulkTargetStatus| {
+            self.targets
+                .iter()
+                .filter(|target| target.status == status)
+                .count()
+        };
+        self.total = self.targets.len();
+        self.pending = count(BulkTargetStatus::Pending);
+        self.running = count(BulkTargetStatus::Running);
+        self.succeeded = count(BulkTargetStatus::Succeeded);
+        self.failed = count(BulkTargetStatus::Failed);
+        self.skipped = count(BulkTargetStatus::Skipped);
+    }
+
+    fn is_finished(&self) -> bool {
+        self.state != BulkOperationState::Running
+    }
+}
+
+/// Targets a bulk operation acts on at once: the requested number (or the default),
+/// between one and the maximum
+pub fn effective_bulk_concurrency(requested: Option<usize>) -> usize {
+    requested
+        .unwrap_or(DEFAULT_BULK_CONCURRENCY)
+        .clamp(1, MAX_BULK_CONCURRENCY)
+}
+
+/// First dependency of a service that isn't available
+fn unavailable_dependency(
+    service_id: i32,
+    dependencies: &[(i32, i32)],
+    unavailable: &HashSet<i32>,
+) -> Option<i32> {
+    dependencies
+        .iter()
+        .filter(|(service, _)| *service == service_id)
+        .map(|(_, depends_on)| *depends_on)
+        .find(|depends_on| unavailable.contains(depends_on))
+}
+
+/// What a bulk operation acts on, resolved when it is started
+struct BulkPlan {
+    action: BulkAction,
+    concurrency: usize,
+    main_branch: String,
+    environments: Vec<environments::Model>,
+    /// Selected services other projects use, which are never stopped
+    shared: HashSet<i32>,
+    /// Layers the selected services are stopped in, dependents first
+    stop_layers: Vec<Vec<i32>>,
+    /// Layers the selected services and their dependencies are started in
+    start_layers: Vec<Vec<i32>>,
+    /// `(service, depends_on)` pairs between the started services
+    dependencies: Vec<(i32, i32)>,
+    service_names: HashMap<i32, String>,
+}
+
+impl BulkPlan {
+    fn service_name(&self, service_id: i32) -> String {
+        self.service_names
+            .get(&service_id)
+            .cloned()
+            .unwrap_or_else(|| format!("service {}", service_id))
+    }
+}
+
+/// Starts and stops a project's services and app containers in dependency order
+pub struct ProjectLifecycleService {
+    db: Arc<DatabaseConnection>,
+    deployment_service: Arc<DeploymentService>,
+    dependency_manager: Arc<ServiceDependencyManager>,
+    build_queue: Arc<BuildQueue>,
+    promotion_service: Arc<PromotionService>,
+    /// Latest project-wide deploy of each project
+    deploy_runs: RwLock<HashMap<i32, ProjectDeployProgress>>,
+    /// Latest bulk operation of each project
+    bulk_runs: RwLock<HashMap<i32, BulkOperationProgress>>,
+}
+
+impl ProjectLifecycleService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        deployment_service: Arc<DeploymentService>,
+        dependency_manager: Arc<ServiceDependencyManager>,
+        build_queue: Arc<BuildQueue>,
+        promotion_service: Arc<PromotionService>,
+    ) -> Self {
+        Self {
+            db,
+            deployment_service,
+            dependency_manager,
+            build_queue,
+            promotion_service,
+            deploy_runs: RwLock::new(HashMap::new()),
+            bulk_runs: RwLock::new(HashMap::new()),
+        }
+    }
+
+    async fn get_project(&self, project_id: i32) -> Result<projects::Model, DeploymentError> {
+        projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Project not found".to_string()))
+    }
+
+    async fn ensure_project(&self, project_id: i32) -> Result<(), DeploymentError> {
+        self.get_project(project_id).await?;
+        Ok(())
+    }
+
+    async fn linked_services(&self, project_id: i32) -> Result<Vec<i32>, DeploymentError> {
+        Ok(project_services::Entity::find()
+            .filter(project_services::Column::ProjectId.eq(project_id))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|link| link.service_id)
+            .collect())
+    }
+
+    /// Linked services that no other project uses, and so are safe to stop
+    async fn exclusive_services(&self, project_id: i32) -> Result<Vec<i32>, DeploymentError> {
+        let linked = self.linked_services(project_id).await?;
+
+        let shared: HashSet<i32> = project_services::Entity::find()
+            .filter(project_services::Column::ServiceId.is_in(linked.clone()))
+            .filter(project_services::Column::ProjectId.ne(project_id))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|link| link.service_id)
+            .collect();
+
+        Ok(linked
+            .into_iter()
+            .filter(|id| !shared.contains(id))
+            .collect())
+    }
+
+    async fn environment_ids(&self, project_id: i32) -> Result<Vec<i32>, DeploymentError> {
+        Ok(environments::Entity::find()
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .filter(environments::Column::DeletedAt.is_null())
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|env| env.id)
+            .collect())
+    }
+
+    /// Start the project's services in dependency order, then its app containers
+    pub async fn start_project(
+        &self,
+        project_id: i32,
+    ) -> Result<ProjectLifecycleReport, DeploymentError> {
+        self.ensure_project(project_id).await?;
+
+        let services = self.linked_services(project_id).await?;
+        let service_layers = self.dependency_manager.start_services(&services).await?;
+
+        // Environments are independent of each other
+        let environment_ids = self.environment_ids(project_id).await?;
+        let results = join_all(environment_ids.iter().map(|env_id| {
+            self.deployment_service
+                .start_all_containers(project_id, *env_id)
+        }))
+        .await;
+        for result in results {
+            result?;
+        }
+
+        info!(
+            "Started project {}: services {:?}, environments {:?}",
+            project_id, service_layers, environment_ids
+        );
+        Ok(ProjectLifecycleReport {
+            project_id,
+            service_layers,
+            environment_ids,
+        })
+    }
+
+    /// Stop the project's app containers, then its services in reverse dependency order
+    ///
+    /// Services shared with other projects keep running.
+    pub async fn stop_project(
+        &self,
+        project_id: i32,
+    ) -> Result<ProjectLifecycleReport, DeploymentError> {
+        self.ensure_project(project_id).await?;
+
+        let environment_ids = self.environment_ids(project_id).await?;
+        let results = join_all(environment_ids.iter().map(|env_id| {
+            self.deployment_service
+                .stop_all_containers(project_id, *env_id)
+        }))
+        .await;
+        for (env_id, result) in environment_ids.iter().zip(results) {
+            if let Err(e) = result {
+                warn!("Failed to stop containers of environment {}: {}", env_id, e);
+            }
+        }
+
+        let services = self.exclusive_services(project_id).await?;
+        let service_layers = self.dependency_manager.stop_services(&services).await?;
+
+        info!(
+            "Stopped project {}: environments {:?}, services {:?}",
+            project_id, environment_ids, service_layers
+        );
+        Ok(ProjectLifecycleReport {
+            project_id,
+            service_layers,
+            environment_ids,
+        })
+    }
+
+    /// Stop and start the whole project, in dependency order both ways
+    pub async fn restart_project(
+        &self,
+        project_id: i32,
+    ) -> Result<ProjectLifecycleReport, DeploymentError> {
+        self.stop_project(project_id).await?;
+        self.start_project(project_id).await
+    }
+
+    /// Start a project-wide deploy in the background and return its initial progress
+    ///
+    /// Linked services are started in dependency order first, then every non-preview
+    /// environment is rebuilt and redeployed, a limited number at a time.
+    pub async fn deploy_project(
+        self: &Arc<Self>,
+        project_id: i32,
+    ) -> Result<ProjectDeployProgress, DeploymentError> {
+        let project = self.get_project(project_id).await?;
+
+        let environments: Vec<environments::Model> = environments::Entity::find()
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .filter(environments::Column::DeletedAt.is_null())
+            .filter(environments::Column::IsPreview.eq(false))
+            .order_by_asc(environments::Column::Id)
+            .all(self.db.as_ref())
+            .await?;
+        if environments.is_empty() {
+            return Err(DeploymentError::InvalidInput(
+                "Project has no environments to deploy".to_string(),
+            ));
+        }
+
+        let concurrency = effective_deploy_concurrency(
+            project.deploy_concurrency,
+            self.build_queue.default_project_deploy_concurrency().await,
+            self.build_queue.max_concurrent_builds().await,
+        );
+
+        let mut progress = ProjectDeployProgress {
+            run_id: uuid::Uuid::new_v4().to_string(),
+            project_id,
+            state: ProjectDeployState::StartingServices,
+            concurrency,
+            service_layers: Vec::new(),
+            total: 0,
+            pending: 0,
+            running: 0,
+            succeeded: 0,
+            failed: 0,
+            skipped: 0,
+            environments: environments
+                .iter()
+                .map(|env| EnvironmentDeployProgress {
+                    environment_id: env.id,
+                    environment_name: env.name.clone(),
+                    status: EnvironmentDeployStatus::Pending,
+                    deployment_id: None,
+                    error: None,
+                })
+                .collect(),
+            started_at: Utc::now(),
+            finished_at: None,
+            error: None,
+        };
+        progress.recount();
+
+        {
+            if self
+                .bulk_runs
+                .read()
+                .await
+                .get(&project_id)
+                .is_some_and(|run| !run.is_finished())
+            {
+                return Err(DeploymentError::InvalidDeploymentState(
+                    "A bulk operation is running for this project".to_string(),
+                ));
+            }
+            let mut runs = self.deploy_runs.write().await;
+            if runs.get(&project_id).is_some_and(|run| !run.is_finished()) {
+                return Err(DeploymentError::InvalidDeploymentState(
+                    "A project-wide deploy is already running for this project".to_string(),
+                ));
+            }
+            runs.insert(project_id, progress.clone());
+        }
+
+        info!(
+            "Deploying project {}: {} environments, {} at a time",
+            project_id,
+            environments.len(),
+            concurrency
+        );
+        let service = self.clone();
+        tokio::spawn(async move {
+            service
+                .run_project_deploy(project, environments, concurrency)
+                .await;
+        });
+
+        Ok(progress)
+    }
+
+    /// Progress of the latest project-wide deploy of a project
+    pub async fn get_deploy_progress(
+        &self,
+        project_id: i32,
+    ) -> Result<ProjectDeployProgress, DeploymentError> {
+        self.deploy_runs
+            .read()
+            .await
+            .get(&project_id)
+            .cloned()
+            .ok_or_else(|| {
+                DeploymentError::NotFound("No project-wide deploy has been run".to_string())
+            })
+    }
+
+    async fn update_progress(
+        &self,
+        project_id: i32,
+        update: impl FnOnce(&mut ProjectDeployProgress),
+    ) {
+        if let Some(progress) = self.deploy_runs.write().await.get_mut(&project_id) {
+            update(progress);
+            progress.recount();
+        }
+    }
+
+    async fn update_environment(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        status: EnvironmentDeployStatus,
+        deployment_id: Option<i32>,
+        error: Option<String>,
+    ) {
+        self.update_progress(project_id, |progress| {
+            if let Some(env) = progress
+                .environments
+                .iter_mut()
+                .find(|env| env.environment_id == environment_id)
+            {
+                env.status = status;
+                env.deployment_id = deployment_id.or(env.deployment_id);
+                env.error = error;
+            }
+        })
+        .await;
+    }
+
+    async fn run_project_deploy(
+        &self,
+        project: projects::Model,
+        environments: Vec<environments::Model>,
+        concurrency: usize,
+    ) {
+        let project_id = project.id;
+
+        // Dependents (the app) must not deploy before their services are up
+        let services = match self.linked_services(project_id).await {
+            Ok(services) => services,
+            Err(e) => {
+                self.fail_deploy(project_id, e.to_string()).await;
+                return;
+            }
+        };
+        match self.dependency_manager.start_services(&services).await {
+            Ok(service_layers) => {
+                self.update_progress(project_id, |progress| {
+                    progress.service_layers = service_layers;
+                    progress.state = ProjectDeployState::Deploying;
+                })
+                .await;
+            }
+            Err(e) => {
+                self.fail_deploy(project_id, DeploymentError::from(e).to_string())
+                    .await;
+                return;
+            }
+        }
+
+        stream::iter(environments)
+            .for_each_concurrent(concurrency, |env| {
+                let branch = env
+                    .branch
+                    .clone()
+                    .unwrap_or_else(|| project.main_branch.clone());
+                async move {
+                    self.deploy_environment(project_id, env.id, branch).await;
+                }
+            })
+            .await;
+
+        self.update_progress(project_id, |progress| {
+            progress.state = ProjectDeployState::Completed;
+            progress.finished_at = Some(Utc::now());
+        })
+        .await;
+        if let Ok(progress) = self.get_deploy_progress(project_id).await {
+            info!(
+                "Project {} deploy finished: {} succeeded, {} failed, {} skipped",
+                project_id, progress.succeeded, progress.failed, progress.skipped
+            );
+        }
+    }
+
+    async fn fail_deploy(&self, project_id: i32, reason: String) {
+        error!("Project {} deploy failed: {}", project_id, reason);
+        self.update_progress(project_id, |progress| {
+            progress.state = ProjectDeployState::Failed;
+            progress.finished_at = Some(Utc::now());
+            progress.error = Some(reason);
+        })
+        .await;
+    }
+
+    /// Trigger a deployment of one environment and wait for it to finish
+    async fn deploy_environment(&self, project_id: i32, environment_id: i32, branch: String) {
+        self.update_environment(
+            project_id,
+            environment_id,
+            EnvironmentDeployStatus::Running,
+            None,
+            None,
+        )
+        .await;
+
+        let (status, deployment_id, error) = self
+            .trigger_and_wait(project_id, environment_id, branch)
+            .await;
+        self.update_environment(project_id, environment_id, status, deployment_id, error)
+            .await;
+    }
+
+    /// Trigger a deployment of one environment and wait for its outcome
+    async fn trigger_and_wait(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        branch: String,
+    ) -> (EnvironmentDeployStatus, Option<i32>, Option<String>) {
+        let triggered_at = Utc::now();
+        if let Err(e) = self
+            .deployment_service
+            .trigger_pipeline(project_id, environment_id, Some(branch), None, None)
+            .await
+        {
+            return (EnvironmentDeployStatus::Failed, None, Some(e.to_string()));
+        }
+
+        match tokio::time::timeout(
+            DEPLOY_TIMEOUT,
+            self.wait_for_deployment(environment_id, triggered_at),
+        )
+        .await
+        {
+            Ok(Ok(outcome)) => outcome,
+            Ok(Err(e)) => (EnvironmentDeployStatus::Failed, None, Some(e.to_string())),
+            Err(_) => (
+                EnvironmentDeployStatus::Failed,
+                None,
+                Some(format!(
+                    "Deployment did not finish within {} minutes",
+                    DEPLOY_TIMEOUT.as_secs() / 60
+                )),
+            ),
+        }
+    }
+
+    /// Wait for the deployment created by a trigger to reach a final state
+    async fn wait_for_deployment(
+        &self,
+        environment_id: i32,
+        triggered_at: DateTime<Utc>,
+    ) -> Result<(EnvironmentDeployStatus, Option<i32>, Option<String>), DeploymentError> {
+        loop {
+            tokio::time::sleep(DEPLOY_POLL_INTERVAL).await;
+
+            let deployment = deployments::Entity::find()
+                .filter(deployments::Column::EnvironmentId.eq(environment_id))
+                .filter(deployments::Column::CreatedAt.gte(triggered_at))
+                .order_by_desc(deployments::Column::CreatedAt)
+                .one(self.db.as_ref())
+                .await?;
+
+            let Some(deployment) = deployment else {
+                let waited = (Utc::now() - triggered_at).to_std().unwrap_or_default();
+                if waited > DEPLOY_START_TIMEOUT {
+                    return Ok((
+                        EnvironmentDeployStatus::Skipped,
+                        None,
+                        Some("No new deployment was created".to_string()),
+                    ));
+                }
+                continue;
+            };
+
+            if let Some(outcome) = deployment_outcome(deployment) {
+                return Ok(outcome);
+            }
+        }
+    }
+
+    /// Wait for a deployment to reach a final state
+    async fn wait_for_outcome(
+        &self,
+        deployment_id: i32,
+    ) -> Result<(EnvironmentDeployStatus, Option<i32>, Option<String>), DeploymentError> {
+        loop {
+            tokio::time::sleep(DEPLOY_POLL_INTERVAL).await;
+
+            let deployment = deployments::Entity::find_by_id(deployment_id)
+                .one(self.db.as_ref())
+                .await?
+                .ok_or_else(|| DeploymentError::NotFound("Deployment not found".to_string()))?;
+            if let Some(outcome) = deployment_outcome(deployment) {
+                return Ok(outcome);
+            }
+        }
+    }
+
+    /// Start a bulk operation in the background and return its initial progress
+    ///
+    /// Services are stopped dependents first and started dependencies first, each
+    /// once everything it depends on is up. Environments are stopped before the
+    /// services and started (or redeployed) after them, a limited number at a time. A
+    /// failure doesn't end the operation: whatever depends on a failed service is
+    /// skipped, and everything else carries on.
+    pub async fn start_bulk_operation(
+        self: &Arc<Self>,
+        project_id: i32,
+        request: BulkOperationRequest,
+    ) -> Result<BulkOperationProgress, DeploymentError> {
+        let project = self.get_project(project_id).await?;
+        let action = request.action;
+
+        let mut query = environments::Entity::find()
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .filter(environments::Column::DeletedAt.is_null())
+            .order_by_asc(environments::Column::Id);
+        let environments = match &request.environment_ids {
+            Some(ids) => {
+                let found = query
+                    .filter(environments::Column::Id.is_in(ids.clone()))
+                    .all(self.db.as_ref())
+                    .await?;
+                if let Some(missing) = ids.iter().find(|id| !found.iter().any(|e| e.id == **id)) {
+                    return Err(DeploymentError::NotFound(format!(
+                        "Environment {} not found in this project",
+                        missing
+                    )));
+                }
+                found
+            }
+            None => {
+                if action == BulkAction::Redeploy {
+                    query = query.filter(environments::Column::IsPreview.eq(false));
+                }
+                query.all(self.db.as_ref()).await?
+            }
+        };
+
+        let linked = self.linked_services(project_id).await?;
+        let services = match &request.service_ids {
+            Some(ids) => {
+                if let Some(id) = ids.iter().find(|id| !linked.contains(id)) {
+                    return Err(DeploymentError::InvalidInput(format!(
+                        "Service {} is not linked to this project",
+                        id
+                    )));
+                }
+                ids.clone()
+            }
+            None => linked,
+        };
+        let services = match request.service_tags.filter(|tags| !tags.is_empty()) {
+            Some(tags) if !services.is_empty() => external_services::Entity::find()
+                .filter(external_services::Column::Id.is_in(services.clone()))
+                .filter(tags.contained_in(external_services::Column::Tags))
+                .all(self.db.as_ref())
+                .await?
+                .into_iter()
+                .map(|service| service.id)
+                .collect(),
+            _ => services,
+        };
+
+        // Services other projects use keep running
+        let shared: HashSet<i32> = if action.stops() {
+            let exclusive = self.exclusive_services(project_id).await?;
+            services
+                .iter()
+                .copied()
+                .filter(|id| !exclusive.contains(id))
+                .collect()
+        } else {
+            HashSet::new()
+        };
+        let stop_layers = if action.stops() {
+            let stoppable: Vec<i32> = services
+                .iter()
+                .copied()
+                .filter(|id| !shared.contains(id))
+                .collect();
+            self.dependency_manager.stop_order(&stoppable).await?
+        } else {
+            Vec::new()
+        };
+        let (start_layers, dependencies) = if action.starts() {
+            self.dependency_manager.start_order(&services).await?
+        } else {
+            (Vec::new(), Vec::new())
+        };
+
+        // Services in the order they are handled, then shared services left alone
+        let layers = if action.starts() {
+            &start_layers
+        } else {
+            &stop_layers
+        };
+        let mut service_order: Vec<i32> = layers.iter().flatten().copied().collect();
+        for id in &services {
+            if !service_order.contains(id) {
+                service_order.push(*id);
+            }
+        }
+        if service_order.is_empty() && environments.is_empty() {
+            return Err(DeploymentError::InvalidInput(
+                "No services or environments to act on".to_string(),
+            ));
+        }
+
+        let service_names: HashMap<i32, String> = external_services::Entity::find()
+            .filter(external_services::Column::Id.is_in(service_order.clone()))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|service| (service.id, service.name))
+            .collect();
+
+        let concurrency = if action == BulkAction::Redeploy {
+            effective_deploy_concurrency(
+                request
+                    .concurrency
+                    .and_then(|n| i32::try_from(n).ok())
+                    .or(project.deploy_concurrency),
+                self.build_queue.default_project_deploy_concurrency().await,
+                self.build_queue.max_concurrent_builds().await,
+            )
+        } else {
+            effective_bulk_concurrency(request.concurrency)
+        };
+
+        let mut targets: Vec<BulkTargetProgress> = service_order
+            .iter()
+            .map(|id| {
+                let skipped = shared.contains(id);
+                BulkTargetProgress {
+                    kind: BulkTargetKind::Service,
+                    id: *id,
+                    name: service_names
+                        .get(id)
+                        .cloned()
+                        .unwrap_or_else(|| format!("service {}", id)),
+                    status: if skipped {
+                        BulkTargetStatus::Skipped
+                    } else {
+                        BulkTargetStatus::Pending
+                    },
+                    deployment_id: None,
+                    error: skipped.then(|| "Shared with other projects, left running".to_string()),
+                }
+            })
+            .collect();
+        targets.extend(environments.iter().map(|env| BulkTargetProgress {
+            kind: BulkTargetKind::Environment,
+            id: env.id,
+            name: env.name.clone(),
+            status: BulkTargetStatus::Pending,
+            deployment_id: None,
+            error: None,
+        }));
+
+        let mut progress = BulkOperationProgress {
+            run_id: uuid::Uuid::new_v4().to_string(),
+            project_id,
+            action,
+            state: BulkOperationState::Running,
+            concurrency,
+            total: 0,
+            pending: 0,
+            running: 0,
+            succeeded: 0,
+            failed: 0,
+            skipped: 0,
+            targets,
+            started_at: Utc::now(),
+            finished_at: None,
+        };
+        progress.recount();
+
+        {
+            let deploy_running = self
+                .deploy_runs
+                .read()
+                .await
+                .get(&project_id)
+                .is_some_and(|run| !run.is_finished());
+            let mut runs = self.bulk_runs.write().await;
+            if deploy_running || runs.get(&project_id).is_some_and(|run| !run.is_finished()) {
+                return Err(DeploymentError::InvalidDeploymentState(
+                    "A bulk operation or project-wide deploy is already running for this project"
+                        .to_string(),
+                ));
+            }
+            runs.insert(project_id, progress.clone());
+        }
+
+        info!(
+            "Bulk {} of project {}: {} services, {} environments, {} at a time",
+            action.as_str(),
+            project_id,
+            service_order.len(),
+            environments.len(),
+            concurrency
+        );
+        let plan = BulkPlan {
+            action,
+            concurrency,
+            main_branch: project.main_branch,
+            environments,
+            shared,
+            stop_layers,
+            start_layers,
+            dependencies,
+            service_names,
+        };
+        let service = self.clone();
+        tokio::spawn(async move {
+            service.run_bulk_operation(project_id, plan).await;
+        });
+
+        Ok(progress)
+    }
+
+    /// Progress of the latest bulk operation of a project
+    pub async fn get_bulk_operation(
+        &self,
+        project_id: i32,
+    ) -> Result<BulkOperationProgress, DeploymentError> {
+        self.bulk_runs
+            .read()
+            .await
+            .get(&project_id)
+            .cloned()
+            .ok_or_else(|| DeploymentError::NotFound("No bulk operation has been run".to_string()))
+    }
+
+    async fn update_bulk_target(
+        &self,
+        project_id: i32,
+        kind: BulkTargetKind,
+        id: i32,
+        status: BulkTargetStatus,
+        deployment_id: Option<i32>,
+        error: Option<String>,
+    ) {
+        if let Some(progress) = self.bulk_runs.write().await.get_mut(&project_id) {
+            if let Some(target) = progress
+                .targets
+                .iter_mut()
+                .find(|target| target.kind == kind && target.id == id)
+            {
+                target.status = status;
+                target.deployment_id = deployment_id.or(target.deployment_id);
+                target.error = error;
+            }
+            progress.recount();
+        }
+    }
+
+    async fn run_bulk_operation(&self, project_id: i32, plan: BulkPlan) {
+        let action = plan.action;
+        // Services that failed, or were skipped because one they depend on failed
+        let mut unavailable: HashSet<i32> = HashSet::new();
+        // Environments that failed to stop, and aren't started again
+        let mut failed_environments: HashSet<i32> = HashSet::new();
+        let final_status = |last_step: bool| {
+            if last_step {
+                BulkTargetStatus::Succeeded
+            } else {
+                BulkTargetStatus::Running
+            }
+        };
+
+        if action.stops() {
+            let results: Vec<(i32, Result<(), DeploymentError>)> = stream::iter(&plan.environments)
+                .map(|env| async move {
+                    self.update_bulk_target(
+                        project_id,
+                        BulkTargetKind::Environment,
+                        env.id,
+                        BulkTargetStatus::Running,
+                        None,
+                        None,
+                    )
+                    .await;
+                    let result = self
+                        .deployment_service
+                        .stop_all_containers(project_id, env.id)
+                        .await;
+                    (env.id, result)
+                })
+                .buffer_unordered(plan.concurrency)
+                .collect()
+                .await;
+            for (env_id, result) in results {
+                let (status, error) = match result {
+                    Ok(()) => (final_status(action == BulkAction::Stop), None),
+                    Err(e) => {
+                        failed_environments.insert(env_id);
+                        (BulkTargetStatus::Failed, Some(e.to_string()))
+                    }
+                };
+                self.update_bulk_target(
+                    project_id,
+                    BulkTargetKind::Environment,
+                    env_id,
+                    status,
+                    None,
+                    error,
+                )
+                .await;
+            }
+
+            for layer in &plan.stop_layers {
+                for result in self
+                    .run_service_layer(project_id, layer, plan.concurrency, false)
+                    .await
+                {
+                    let (id, status, error) = match result {
+                        (id, Ok(())) => (id, final_status(action == BulkAction::Stop), None),
+                        (id, Err(e)) => {
+                            unavailable.insert(id);
+                            (id, BulkTargetStatus::Failed, Some(e))
+                        }
+                    };
+                    self.update_bulk_target(
+                        project_id,
+                        BulkTargetKind::Service,
+                        id,
+                        status,
+                        None,
+                        error,
+                    )
+                    .await;
+                }
+            }
+        }
+
+        if action.starts() {
+            for layer in &plan.start_layers {
+                let mut ready = Vec::new();
+                for id in layer {
+                    // Shared services weren't stopped, and failed ones stay as they are
+                    if plan.shared.contains(id) || unavailable.contains(id) {
+                        continue;
+                    }
+                    match unavailable_dependency(*id, &plan.dependencies, &unavailable) {
+                        Some(dependency) => {
+                            unavailable.insert(*id);
+                            self.update_bulk_target(
+                                project_id,
+                                BulkTargetKind::Service,
+                                *id,
+                                BulkTargetStatus::Skipped,
+                                None,
+                                Some(format!(
+                                    "Depends on {}, which is unavailable",
+                                    plan.service_name(dependency)
+                                )),
+                            )
+                            .await;
+                        }
+                        None => ready.push(*id),
+                    }
+                }
+
+                for result in self
+                    .run_service_layer(project_id, &ready, plan.concurrency, true)
+                    .await
+                {
+                    let (id, status, error) = match result {
+                        (id, Ok(())) => (id, BulkTargetStatus::Succeeded, None),
+                        (id, Err(e)) => {
+                            unavailable.insert(id);
+                            (id, BulkTargetStatus::Failed, Some(e))
+                        }
+                    };
+                    self.update_bulk_target(
+                        project_id,
+                        BulkTargetKind::Service,
+                        id,
+                        status,
+                        None,
+                        error,
+                    )
+                    .await;
+                }
+            }
+
+            // The app depends on every linked service
+            let missing_service = plan
+                .start_layers
+                .iter()
+                .flatten()
+                .find(|id| unavailable.contains(id))
+                .copied();
+            let environments: Vec<&environments::Model> = plan
+                .environments
+                .iter()
+                .filter(|env| !failed_environments.contains(&env.id))
+                .collect();
+            if let Some(service_id) = missing_service {
+                for env in environments {
+                    self.update_bulk_target(
+                        project_id,
+                        BulkTargetKind::Environment,
+                        env.id,
+                        BulkTargetStatus::Skipped,
+                        None,
+                        Some(format!(
+                            "Service {} is unavailable",
+                            plan.service_name(service_id)
+                        )),
+                    )
+                    .await;
+                }
+            } else {
+                stream::iter(environments)
+                    .for_each_concurrent(plan.concurrency, |env| {
+                        let plan = &plan;
+                        async move {
+                            self.update_bulk_target(
+                                project_id,
+                                BulkTargetKind::Environment,
+                                env.id,
+                                BulkTargetStatus::Running,
+                                None,
+                                None,
+                            )
+                            .await;
+                            let (status, deployment_id, error) =
+                                self.start_environment(project_id, env, plan).await;
+                            self.update_bulk_target(
+                                project_id,
+                                BulkTargetKind::Environment,
+                                env.id,
+                                status,
+                                deployment_id,
+                                error,
+                            )
+                            .await;
+                        }
+                    })
+                    .await;
+            }
+        }
+
+        if let Some(progress) = self.bulk_runs.write().await.get_mut(&project_id) {
+            progress.state = BulkOperationState::Completed;
+            progress.finished_at = Some(Utc::now());
+            info!(
+                "Bulk {} of project {} finished: {} succeeded, {} failed, {} skipped",
+                action.as_str(),
+                project_id,
+                progress.succeeded,
+                progress.failed,
+                progress.skipped
+            );
+        }
+    }
+
+    /// Start (or stop) a layer of services, a limited number at a time
+    async fn run_service_layer(
+        &self,
+        project_id: i32,
+        layer: &[i32],
+        concurrency: usize,
+        start: bool,
+    ) -> Vec<(i32, Result<(), String>)> {
+        stream::iter(layer)
+            .map(|id| async move {
+                self.update_bulk_target(
+                    project_id,
+                    BulkTargetKind::Service,
+                    *id,
+                    BulkTargetStatus::Running,
+                    None,
+                    None,
+                )
+                .await;
+                let result = if start {
+                    self.dependency_manager.start_service(*id).await
+                } else {
+                    self.dependency_manager.stop_service(*id).await
+                };
+                if let Err(e) = &result {
+                    warn!("Bulk operation failed on service {}: {}", id, e);
+                }
+                (*id, result.map_err(|e| e.to_string()))
+            })
+            .buffer_unordered(concurrency)
+            .collect()
+            .await
+    }
+
+    /// Start an environment's containers, or redeploy it
+    async fn start_environment(
+        &self,
+        project_id: i32,
+        environment: &environments::Model,
+        plan: &BulkPlan,
+    ) -> (BulkTargetStatus, Option<i32>, Option<String>) {
+        if plan.action == BulkAction::Redeploy {
+            let branch = environment
+                .branch
+                .clone()
+                .unwrap_or_else(|| plan.main_branch.clone());
+            let (status, deployment_id, error) = self
+                .trigger_and_wait(project_id, environment.id, branch)
+                .await;
+            let status = match status {
+                EnvironmentDeployStatus::Succeeded => BulkTargetStatus::Succeeded,
+                EnvironmentDeployStatus::Skipped => BulkTargetStatus::Skipped,
+                _ => BulkTargetStatus::Failed,
+            };
+            return (status, deployment_id, error);
+        }
+
+        match self
+            .deployment_service
+            .start_all_containers(project_id, environment.id)
+            .await
+        {
+            Ok(()) => (BulkTargetStatus::Succeeded, None, None),
+            Err(e) => (BulkTargetStatus::Failed, None, Some(e.to_string())),
+        }
+    }
+}
+
+/// Outcome of a deployment that reached a final state; None while it runs
+fn deployment_outcome(
+    deployment: deployments::Model,
+) -> Option<(EnvironmentDeployStatus, Option<i32>, Option<String>)> {
+    match deployment.state.as_str() {
+        "completed" | "deployed" | "ready" => Some((
+            EnvironmentDeployStatus::Succeeded,
+            Some(deployment.id),
+            None,
+        )),
+        "failed" | "cancelled" => Some((
+            EnvironmentDeployStatus::Failed,
+            Some(deployment.id),
+            deployment
+                .cancelled_reason
+                .or_else(|| Some(format!("Deployment {}", deployment.state))),
+        )),
+        _ => None,
+    }
+}
+
+#[async_trait::async_trait]
+impl temps_core::EnvironmentRedeployer for ProjectLifecycleService {
+    async fn redeploy_environment(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<i32, Box<dyn std::error::Error + Send + Sync>> {
+        let deployment = self
+            .promotion_service
+            .redeploy_current(project_id, environment_id)
+            .await?;
+
+        let outcome = tokio::time::timeout(DEPLOY_TIMEOUT, self.wait_for_outcome(deployment.id))
+            .await
+            .map_err(|_| {
+                format!(
+                    "Deployment {} did not finish within {} minutes",
+                    deployment.id,
+                    DEPLOY_TIMEOUT.as_secs() / 60
+                )
+            })??;
+        match outcome {
+            (EnvironmentDeployStatus::Succeeded, Some(deployment_id), _) => Ok(deployment_id),
+            (_, _, error) => Err(error
+                .unwrap_or_else(|| "Deployment did not succeed".to_string())
+                .into()),
+        }
+    }
+
+    async fn restart_environment(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
+        self.deployment_service
+            .restart_all_containers(project_id, environment_id)
+            .await?;
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_deploy_concurrency_defaults_and_caps() {
+        // Project limit wins, but never beyond the global build limit
+        assert_eq!(effective_deploy_concurrency(Some(3), 2, 4), 3);
+        assert_eq!(effective_deploy_concurrency(Some(10), 2, 4), 4);
+        // Unset or invalid project limits fall back to the default
+        assert_eq!(effective_deploy_concurrency(None, 2, 4), 2);
+        assert_eq!(effective_deploy_concurrency(Some(0), 2, 4), 2);
+        assert_eq!(effective_deploy_concurrency(None, 8, 4), 4);
+        // Always at least one
+        assert_eq!(effective_deploy_concurrency(None, 0, 4), 1);
+    }
+
+    #[test]
+    fn test_progress_counts_environment_statuses() {
+        let env = |id, status| EnvironmentDeployProgress {
+            environment_id: id,
+            environment_name: format!("env-{}", id),
+            status,
+            deployment_id: None,
+            error: None,
+        };
+        let mut progress = ProjectDeployProgress {
+            run_id: "run".to_string(),
+            project_id: 1,
+            state: ProjectDeployState::Deploying,
+            concurrency: 2,
+            service_layers: vec![],
+            total: 0,
+            pending: 0,
+            running: 0,
+            succeeded: 0,
+            failed: 0,
+            skipped: 0,
+            environments: vec![
+                env(1, EnvironmentDeployStatus::Succeeded),
+                env(2, EnvironmentDeployStatus::Running),
+                env(3, EnvironmentDeployStatus::Running),
+                env(4, EnvironmentDeployStatus::Pending),
+                env(5, EnvironmentDeployStatus::Failed),
+            ],
+            started_at: Utc::now(),
+            finished_at: None,
+            error: None,
+        };
+        progress.recount();
+
+        assert_eq!(progress.total, 5);
+        assert_eq!(progress.pending, 1);
+        assert_eq!(progress.running, 2);
+        assert_eq!(progress.succeeded, 1);
+        assert_eq!(progress.failed, 1);
+        assert_eq!(progress.skipped, 0);
+        assert!(!progress.is_finished());
+    }
+
+    #[test]
+    fn test_bulk_concurrency_defaults_and_caps() {
+        assert_eq!(effective_bulk_concurrency(None), DEFAULT_BULK_CONCURRENCY);
+        assert_eq!(effective_bulk_concurrency(Some(2)), 2);
+        assert_eq!(effective_bulk_concurrency(Some(0)), 1);
+        assert_eq!(effective_bulk_concurrency(Some(100)), MAX_BULK_CONCURRENCY);
+    }
+
+    #[test]
+    fn test_unavailable_dependency() {
+        // 3 depends on 1 and 2, 4 depends on 3
+        let dependencies = [(3, 1), (3, 2), (4, 3)];
+        let unavailable = HashSet::from([2]);
+        assert_eq!(
+            unavailable_dependency(3, &dependencies, &unavailable),
+            Some(2)
+        );
+        assert_eq!(unavailable_dependency(4, &dependencies, &unavailable), None);
+        assert_eq!(unavailable_dependency(1, &dependencies, &unavailable), None);
+    }
+
+    #[test]
+    fn test_bulk_request_defaults_to_everything() {
+        let request: BulkOperationRequest =
+            serde_json::from_value(serde_json::json!({ "action": "restart" })).unwrap();
+        assert_eq!(request.action, BulkAction::Restart);
+        assert!(request.environment_ids.is_none());
+        assert!(request.service_ids.is_none());
+        assert!(request.service_tags.is_none());
+
+        let request: BulkOperationRequest = serde_json::from_value(serde_json::json!({
+            "action": "stop",
+            "service_tags": { "tier": "cache" }
+        }))
+        .unwrap();
+        assert_eq!(
+            request
+                .service_tags
+                .as_ref()
+                .and_then(|tags| tags.get("tier")),
+            Some("cache")
+        );
+        assert!(BulkAction::Restart.stops() && BulkAction::Restart.starts());
+        assert!(!BulkAction::Stop.starts());
+        assert!(!BulkAction::Redeploy.stops());
+    }
+
+    #[test]
+    fn test_bulk_progress_counts_target_statuses() {
+        let target = |kind, id, status| BulkTargetProgress {
+            kind,
+            id,
+            name: format!("target-{}", id),
+            status,
+            deployment_id: None,
+            error: None,
+        };
+        let mut progress = BulkOperationProgress {
+            run_id: "run".to_string(),
+            project_id: 1,
+            action: BulkAction::Start,
+            state: BulkOperationState::Running,
+            concurrency: 4,
+            total: 0,
+            pending: 0,
+            running: 0,
+            succeeded: 0,
+            failed: 0,
+            skipped: 0,
+            targets: vec![
+                target(BulkTargetKind::Service, 1, BulkTargetStatus::Failed),
+                target(BulkTargetKind::Service, 2, BulkTargetStatus::Skipped),
+                target(BulkTargetKind::Environment, 1, BulkTargetStatus::Skipped),
+                target(BulkTargetKind::Environment, 2, BulkTargetStatus::Running),
+            ],
+            started_at: Utc::now(),
+            finished_at: None,
+        };
+        progress.recount();
+
+        assert_eq!(progress.total, 4);
+        assert_eq!(progress.failed, 1);
+        assert_eq!(progress.skipped, 2);
+        assert_eq!(progress.running, 1);
+        assert!(!progress.is_finished());
+        progress.state = BulkOperationState::Completed;
+        assert!(progress.is_finished());
+    }
+}
@@ -0,0 +1,687 @@
+//! Environment Promotions
+//!
+//! Promotes a deployment from a lower environment to a higher one — e.g. the build
+//! tested in staging to production — by deploying the same image, pinned by its ID,
+//! instead of rebuilding from source. The promoted deployment keeps the target
+//! environment's own variables, resources and domains; only the variables the target
+//! lists in `promotion.carry_env_vars` come from the source environment.
+//!
+//! Targets with `promotion.require_approval` hold promotions until someone other than
+//! the requester approves them. Every promotion is recorded, linking the source
+//! deployment to the deployment it became.
+//!
+//! An environment's current deployment can be redeployed the same way, so a change
+//! to its variables reaches the containers without building the branch again.
+
+use std::collections::HashMap;
+use std::sync::Arc;
+
+use bollard::Docker;
+use chrono::Utc;
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, EntityTrait, PaginatorTrait, QueryFilter, QueryOrder, Set,
+};
+use temps_core::{Job, JobQueue};
+use temps_database::DbConnection;
+use temps_entities::deployment_config::DeploymentConfigSnapshot;
+use temps_entities::deployment_promotions::{
+    self, PROMOTION_STATUS_DEPLOYING, PROMOTION_STATUS_PENDING_APPROVAL, PROMOTION_STATUS_REJECTED,
+};
+use temps_entities::deployments::{self, DeploymentMetadata};
+use temps_entities::{environments, projects};
+use tracing::{debug, error, info};
+
+use super::{DeploymentError, WorkflowExecutionService, WorkflowPlanner};
+
+/// Deployment states a deployment can be promoted from
+const PROMOTABLE_STATES: [&str; 2] = ["deployed", "completed"];
+
+/// Status a new promotion starts in
+fn initial_status(require_approval: bool) -> &'static str {
+    if require_approval {
+        PROMOTION_STATUS_PENDING_APPROVAL
+    } else {
+        PROMOTION_STATUS_DEPLOYING
+    }
+}
+
+/// Check that a reviewer may approve or reject a pending promotion
+fn check_reviewable(
+    promotion: &deployment_promotions::Model,
+    reviewer_id: i32,
+) -> Result<(), DeploymentError> {
+    if promotion.status != PROMOTION_STATUS_PENDING_APPROVAL {
+        return Err(DeploymentError::Conflict(format!(
+            "Promotion {} is '{}', only pending promotions can be reviewed",
+            promotion.id, promotion.status
+        )));
+    }
+    if promotion.requested_by == Some(reviewer_id) {
+        return Err(DeploymentError::InvalidInput(
+            "A promotion must be reviewed by someone other than its requester".to_string(),
+        ));
+    }
+    Ok(())
+}
+
+/// Promotes deployments between environments and keeps their lineage
+pub struct PromotionService {
+    db: Arc<DbConnection>,
+    queue: Arc<dyn JobQueue>,
+    docker: Arc<Docker>,
+    workflow_planner: Arc<WorkflowPlanner>,
+    workflow_executor: Arc<WorkflowExecutionService>,
+}
+
+impl PromotionService {
+    pub fn new(
+        db: Arc<DbConnection>,
+        queue: Arc<dyn JobQueue>,
+        docker: Arc<Docker>,
+        workflow_planner: Arc<WorkflowPlanner>,
+        workflow_executor: Arc<WorkflowExecutionService>,
+    ) -> Self {
+        Self {
+            db,
+            queue,
+            docker,
+            workflow_planner,
+            workflow_executor,
+        }
+    }
+
+    /// Promote a deployment to another environment of the same project
+    ///
+    /// Deploys right away unless the target environment requires approval, in which
+    /// case the promotion is returned pending.
+    pub async fn promote(
+        &self,
+        project_id: i32,
+        source_deployment_id: i32,
+        target_environment_id: i32,
+        requested_by: i32,
+    ) -> Result<deployment_promotions::Model, DeploymentError> {
+        let source = deployments::Entity::find_by_id(source_deployment_id)
+            .filter(deployments::Column::ProjectId.eq(project_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Deployment not found".to_string()))?;
+
+        if !PROMOTABLE_STATES.contains(&source.state.as_str()) {
+            return Err(DeploymentError::InvalidDeploymentState(format!(
+                "Cannot promote a deployment in '{}' state. Only deployed or completed deployments can be promoted.",
+                source.state
+            )));
+        }
+        if source.environment_id == target_environment_id {
+            return Err(DeploymentError::InvalidInput(
+                "A deployment can only be promoted to a different environment".to_string(),
+            ));
+        }
+
+        let project = projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Project not found".to_string()))?;
+        let target = environments::Entity::find_by_id(target_environment_id)
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .filter(environments::Column::DeletedAt.is_null())
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Target environment not found".to_string()))?;
+        if target.is_preview {
+            return Err(DeploymentError::InvalidInput(
+                "Deployments cannot be promoted to preview environments".to_string(),
+            ));
+        }
+
+        // Pin the image by ID now, so the approved promotion deploys exactly the
+        // image that was reviewed even if the tag is reused
+        let image_digest = self.pin_image(&source).await?;
+
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let require_approval = target
+            .get_effective_deployment_config(&project_config)
+            .promotion
+            .is_some_and(|promotion| promotion.require_approval);
+
+        let promotion = deployment_promotions::ActiveModel {
+            project_id: Set(project_id),
+            source_deployment_id: Set(source.id),
+            source_environment_id: Set(source.environment_id),
+            target_environment_id: Set(target.id),
+            target_deployment_id: Set(None),
+            image_name: Set(source.image_name.clone()),
+            image_digest: Set(image_digest),
+            status: Set(initial_status(require_approval).to_string()),
+            requested_by: Set(Some(requested_by)),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        info!(
+            "Promotion {} of deployment {} to environment {} requested by user {}{}",
+            promotion.id,
+            source.id,
+            target.name,
+            requested_by,
+            if require_approval {
+                ", waiting for approval"
+            } else {
+                ""
+            }
+        );
+
+        if require_approval {
+            return Ok(promotion);
+        }
+        self.start_promotion(promotion, &project, &target, &source)
+            .await
+    }
+
+    /// Approve a pending promotion and deploy it
+    pub async fn approve(
+        &self,
+        project_id: i32,
+        promotion_id: i32,
+        reviewer_id: i32,
+        comment: Option<String>,
+    ) -> Result<deployment_promotions::Model, DeploymentError> {
+        let promotion = self.get_promotion(project_id, promotion_id).await?;
+        check_reviewable(&promotion, reviewer_id)?;
+
+        let project = projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Project not found".to_string()))?;
+        let target = environments::Entity::find_by_id(promotion.target_environment_id)
+            .filter(environments::Column::DeletedAt.is_null())
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Target environment not found".to_string()))?;
+        let source = deployments::Entity::find_by_id(promotion.source_deployment_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Deployment not found".to_string()))?;
+
+        let promotion = self
+            .review(promotion, reviewer_id, PROMOTION_STATUS_DEPLOYING, comment)
+            .await?;
+
+        info!(
+            "Promotion {} approved by user {}",
+            promotion.id, reviewer_id
+        );
+        self.start_promotion(promotion, &project, &target, &source)
+            .await
+    }
+
+    /// Reject a pending promotion; nothing is deployed
+    pub async fn reject(
+        &self,
+        project_id: i32,
+        promotion_id: i32,
+        reviewer_id: i32,
+        comment: Option<String>,
+    ) -> Result<deployment_promotions::Model, DeploymentError> {
+        let promotion = self.get_promotion(project_id, promotion_id).await?;
+        check_reviewable(&promotion, reviewer_id)?;
+
+        let promotion = self
+            .review(promotion, reviewer_id, PROMOTION_STATUS_REJECTED, comment)
+            .await?;
+
+        info!(
+            "Promotion {} rejected by user {}",
+            promotion.id, reviewer_id
+        );
+        Ok(promotion)
+    }
+
+    /// Record the review of a pending promotion
+    ///
+    /// The status only changes if the promotion is still pending, so of two reviews
+    /// racing each other only one goes through; the other gets a conflict.
+    async fn review(
+        &self,
+        promotion: deployment_promotions::Model,
+        reviewer_id: i32,
+        status: &str,
+        comment: Option<String>,
+    ) -> Result<deployment_promotions::Model, DeploymentError> {
+        use sea_orm::sea_query::Expr;
+
+        let now = Utc::now();
+        let result = deployment_promotions::Entity::update_many()
+            .filter(deployment_promotions::Column::Id.eq(promotion.id))
+            .filter(deployment_promotions::Column::Status.eq(PROMOTION_STATUS_PENDING_APPROVAL))
+            .col_expr(deployment_promotions::Column::Status, Expr::value(status))
+            .col_expr(
+                deployment_promotions::Column::ReviewedBy,
+                Expr::value(Some(reviewer_id)),
+            )
+            .col_expr(
+                deployment_promotions::Column::ReviewedAt,
+                Expr::value(Some(now)),
+            )
+            .col_expr(
+                deployment_promotions::Column::ReviewComment,
+                Expr::value(comment.clone()),
+            )
+            .col_expr(deployment_promotions::Column::UpdatedAt, Expr::value(now))
+            .exec(self.db.as_ref())
+            .await?;
+        if result.rows_affected == 0 {
+            return Err(DeploymentError::Conflict(format!(
+                "Promotion {} was reviewed in the meantime",
+                promotion.id
+            )));
+        }
+
+        Ok(deployment_promotions::Model {
+            status: status.to_string(),
+            reviewed_by: Some(reviewer_id),
+            reviewed_at: Some(now),
+            review_comment: comment,
+            updated_at: now,
+            ..promotion
+        })
+    }
+
+    /// A project's promotions, newest first
+    pub async fn list_promotions(
+        &self,
+        project_id: i32,
+    ) -> Result<Vec<deployment_promotions::Model>, DeploymentError> {
+        Ok(deployment_promotions::Entity::find()
+            .filter(deployment_promotions::Column::ProjectId.eq(project_id))
+            .order_by_desc(deployment_promotions::Column::CreatedAt)
+            .all(self.db.as_ref())
+            .await?)
+    }
+
+    /// Promotions a deployment was part of, as either the source or the result
+    pub async fn deployment_lineage(
+        &self,
+        project_id: i32,
+        deployment_id: i32,
+    ) -> Result<Vec<deployment_promotions::Model>, DeploymentError> {
+        Ok(deployment_promotions::Entity::find()
+            .filter(deployment_promotions::Column::ProjectId.eq(project_id))
+            .filter(
+                deployment_promotions::Column::SourceDeploymentId
+                    .eq(deployment_id)
+                    .or(deployment_promotions::Column::TargetDeploymentId.eq(deployment_id)),
+            )
+            .order_by_desc(deployment_promotions::Column::CreatedAt)
+            .all(self.db.as_ref())
+            .await?)
+    }
+
+    pub async fn get_promotion(
+        &self,
+        project_id: i32,
+        promotion_id: i32,
+    ) -> Result<deployment_promotions::Model, DeploymentError> {
+        deployment_promotions::Entity::find_by_id(promotion_id)
+            .filter(deployment_promotions::Column::ProjectId.eq(project_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Promotion not found".to_string()))
+    }
+
+    /// Redeploy the current deployment of an environment with the environment's
+    /// current variables
+    ///
+    /// The new deployment runs the same image, pinned by ID, or serves the same static
+    /// files, so nothing is built: only what changed in the environment since is
+    /// picked up, e.g. the rotated credentials of a linked service.
+    pub async fn redeploy_current(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<deployments::Model, DeploymentError> {
+        let project = projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Project not found".to_string()))?;
+        let environment = environments::Entity::find_by_id(environment_id)
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .filter(environments::Column::DeletedAt.is_null())
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Environment not found".to_string()))?;
+        let current_deployment_id = environment.current_deployment_id.ok_or_else(|| {
+            DeploymentError::InvalidDeploymentState(format!(
+                "Environment {} has no current deployment to redeploy",
+                environment.name
+            ))
+        })?;
+        let source = deployments::Entity::find_by_id(current_deployment_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Deployment not found".to_string()))?;
+
+        let source_metadata = source.metadata.clone().unwrap_or_default();
+        let image = match source_metadata.promoted_image.clone() {
+            Some(image) => Some(image),
+            None => self.pin_image(&source).await?,
+        };
+        let metadata = DeploymentMetadata {
+            promoted_from_id: None,
+            promoted_image: image,
+            redeployed_from_id: Some(source.id),
+            is_rollback: false,
+            rolled_back_from_id: None,
+            smoke_tests: None,
+            deploy_gate: None,
+            connection_drain: None,
+            cdn_purge: None,
+            deploy_attempts: Vec::new(),
+            build_phases: None,
+            ..source_metadata
+        };
+
+        let deployment = self
+            .insert_deployment(
+                &project,
+                &environment,
+                &source,
+                metadata,
+                serde_json::json!({
+                    "trigger": "redeploy",
+                    "source": "system",
+                    "redeployed_from": source.id
+                }),
+            )
+            .await?;
+        info!(
+            "Redeploying deployment {} of environment {} as deployment {}",
+            source.id, environment.name, deployment.id
+        );
+
+        self.launch(&deployment, &environment).await?;
+        Ok(deployment)
+    }
+
+    /// Image of a deployment pinned by its ID; None for a static deployment
+    async fn pin_image(
+        &self,
+        deployment: &deployments::Model,
+    ) -> Result<Option<String>, DeploymentError> {
+        match (&deployment.static_dir_location, &deployment.image_name) {
+            (Some(_), _) => Ok(None),
+            (None, Some(image_name)) => {
+                crate::utils::docker_inspect::get_image_id(&self.docker, image_name)
+                    .await
+                    .map_err(|e| {
+                        DeploymentError::InvalidDeploymentState(format!(
+                            "The image of deployment {} is no longer available: {}",
+                            deployment.id, e
+                        ))
+                    })
+            }
+            (None, None) => Err(DeploymentError::InvalidDeploymentState(format!(
+                "Deployment {} has no image or static files to deploy",
+                deployment.id
+            ))),
+        }
+    }
+
+    /// Create the promoted deployment in the target environment and run it
+    async fn start_promotion(
+        &self,
+        promotion: deployment_promotions::Model,
+        project: &projects::Model,
+        target: &environments::Model,
+        source: &deployments::Model,
+    ) -> Result<deployment_promotions::Model, DeploymentError> {
+        // Build information carries over; the rollback markers, smoke test results,
+        // deploy gate verdict, connection drain, CDN purge and failed attempts of the
+        // source don't
+        let source_metadata = source.metadata.clone().unwrap_or_default();
+        let metadata = DeploymentMetadata {
+            promoted_from_id: Some(source.id),
+            promoted_image: promotion.image_digest.clone(),
+            redeployed_from_id: None,
+            is_rollback: false,
+            rolled_back_from_id: None,
+            smoke_tests: None,
+            deploy_gate: None,
+            connection_drain: None,
+            cdn_purge: None,
+            deploy_attempts: Vec::new(),
+            build_phases: None,
+            ..source_metadata
+        };
+
+        let deployment = self
+            .insert_deployment(
+                project,
+                target,
+                source,
+                metadata,
+                serde_json::json!({
+                    "trigger": "promotion",
+                    "source": "api",
+                    "promotion_id": promotion.id,
+                    "promoted_from": source.id
+                }),
+            )
+            .await?;
+
+        let mut active: deployment_promotions::ActiveModel = promotion.into();
+        active.target_deployment_id = Set(Some(deployment.id));
+        let promotion = active.update(self.db.as_ref()).await?;
+
+        info!(
+            "Promotion {}: deploying deployment {} to environment {} as deployment {}",
+            promotion.id, source.id, target.name, deployment.id
+        );
+
+        self.launch(&deployment, target).await?;
+        Ok(promotion)
+    }
+
+    /// Create a pending deployment in `target` of what `source` runs
+    async fn insert_deployment(
+        &self,
+        project: &projects::Model,
+        target: &environments::Model,
+        source: &deployments::Model,
+        metadata: DeploymentMetadata,
+        context_vars: serde_json::Value,
+    ) -> Result<deployments::Model, DeploymentError> {
+        let deployment_number = deployments::Entity::find()
+            .filter(deployments::Column::ProjectId.eq(project.id))
+            .count(self.db.as_ref())
+            .await?
+            + 1;
+
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let deployment_config_snapshot = DeploymentConfigSnapshot::from_config(
+            &target.get_effective_deployment_config(&project_config),
+            HashMap::new(),
+        );
+
+        let now = Utc::now();
+        Ok(deployments::ActiveModel {
+            project_id: Set(project.id),
+            environment_id: Set(target.id),
+            slug: Set(format!("{}-{}", project.slug, deployment_number)),
+            state: Set("pending".to_string()),
+            metadata: Set(Some(metadata)),
+            branch_ref: Set(source.branch_ref.clone()),
+            tag_ref: Set(source.tag_ref.clone()),
+            commit_sha: Set(source.commit_sha.clone()),
+            commit_message: Set(source.commit_message.clone()),
+            commit_author: Set(source.commit_author.clone()),
+            commit_json: Set(source.commit_json.clone()),
+            context_vars: Set(Some(context_vars)),
+            static_dir_location: Set(source.static_dir_location.clone()),
+            image_name: Set(source.image_name.clone()),
+            deployment_config: Set(Some(deployment_config_snapshot)),
+            created_at: Set(now),
+            updated_at: Set(now),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?)
+    }
+
+    /// Plan the jobs of a new deployment and run them in the background
+    async fn launch(
+        &self,
+        deployment: &deployments::Model,
+        target: &environments::Model,
+    ) -> Result<(), DeploymentError> {
+        let created_event = Job::DeploymentCreated(temps_core::DeploymentCreatedJob {
+            deployment_id: deployment.id,
+            project_id: deployment.project_id,
+            environment_id: target.id,
+            environment_name: target.name.clone(),
+            branch: deployment.branch_ref.clone(),
+            commit_sha: deployment.commit_sha.clone(),
+        });
+        if let Err(e) = self.queue.send(created_event).await {
+            error!("Failed to send DeploymentCreated event: {}", e);
+        }
+
+        let jobs = self
+            .workflow_planner
+            .create_deployment_jobs(deployment.id)
+            .await
+            .map_err(|e| {
+                DeploymentError::PipelineError(format!(
+                    "Failed to plan deployment {}: {}",
+                    deployment.id, e
+                ))
+            });
+        let jobs = match jobs {
+            Ok(jobs) => jobs,
+            Err(e) => {
+                if let Err(db_error) = mark_failed(&self.db, deployment.id, e.to_string()).await {
+                    error!("Failed to update deployment status: {}", db_error);
+                }
+                return Err(e);
+            }
+        };
+        debug!(
+            "Created {} jobs for deployment {}",
+            jobs.len(),
+            deployment.id
+        );
+
+        self.mark_running(deployment.id).await;
+        let db = self.db.clone();
+        let workflow_executor = self.workflow_executor.clone();
+        let deployment_id = deployment.id;
+        tokio::spawn(async move {
+            if let Err(e) = workflow_executor
+                .execute_deployment_workflow(deployment_id)
+                .await
+            {
+                error!(
+                    "Workflow execution failed for deployment {}: {}",
+                    deployment_id, e
+                );
+                if let Err(db_error) = mark_failed(&db, deployment_id, e.to_string()).await {
+                    error!("Failed to update deployment status: {}", db_error);
+                }
+            }
+        });
+
+        Ok(())
+    }
+
+    async fn mark_running(&self, deployment_id: i32) {
+        let result = deployments::ActiveModel {
+            id: Set(deployment_id),
+            state: Set("running".to_string()),
+            started_at: Set(Some(Utc::now())),
+            updated_at: Set(Utc::now()),
+            ..Default::default()
+        }
+        .update(self.db.as_ref())
+        .await;
+        if let Err(e) = result {
+            error!(
+                "Failed to mark deployment {} as running: {}",
+                deployment_id, e
+            );
+        }
+    }
+}
+
+/// Mark a deployment failed with the reason
+async fn mark_failed(
+    db: &DbConnection,
+    deployment_id: i32,
+    reason: String,
+) -> Result<deployments::Model, sea_orm::DbErr> {
+    deployments::ActiveModel {
+        id: Set(deployment_id),
+        state: Set("failed".to_string()),
+        cancelled_reason: Set(Some(reason)),
+        finished_at: Set(Some(Utc::now())),
+        updated_at: Set(Utc::now()),
+        ..Default::default()
+    }
+    .update(db)
+    .await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn pending(requested_by: Option<i32>) -> deployment_promotions::Model {
+        let now = Utc::now();
+        deployment_promotions::Model {
+            id: 1,
+            project_id: 1,
+            source_deployment_id: 10,
+            source_environment_id: 2,
+            target_environment_id: 3,
+            target_deployment_id: None,
+            image_name: Some("temps-app:10".to_string()),
+            image_digest: Some("sha256:abc".to_string()),
+            status: PROMOTION_STATUS_PENDING_APPROVAL.to_string(),
+            requested_by,
+            reviewed_by: None,
+            reviewed_at: None,
+            review_comment: None,
+            created_at: now,
+            updated_at: now,
+        }
+    }
+
+    #[test]
+    fn test_initial_status_follows_approval_gate() {
+        assert_eq!(initial_status(true), PROMOTION_STATUS_PENDING_APPROVAL);
+        assert_eq!(initial_status(false), PROMOTION_STATUS_DEPLOYING);
+    }
+
+    #[test]
+    fn test_requester_cannot_review_own_promotion() {
+        assert!(matches!(
+            check_reviewable(&pending(Some(5)), 5),
+            Err(DeploymentError::InvalidInput(_))
+        ));
+        assert!(check_reviewable(&pending(Some(5)), 6).is_ok());
+        assert!(check_reviewable(&pending(None), 6).is_ok());
+    }
+
+    #[test]
+    fn test_only_pending_promotions_are_reviewable() {
+        let promotion = deployment_promotions::Model {
+            status: PROMOTION_STATUS_DEPLOYING.to_string(),
+            ..pending(Some(5))
+        };
+        assert!(matches!(
+            check_reviewable(&promotion, 6),
+            Err(DeploymentError::Conflict(_))
+        ));
+    }
+}
@@ -0,0 +1,740 @@
+//! Resource Alerts
+//!
+//! Threshold rules on the usage recorded by [`super::ContainerMetricsService`], e.g.
+//! memory above 90% of the limit for 5 minutes or CPU above 80% sustained. Each round
+//! of samples is checked against the environment's rules: a rule fires once its
+//! metric has exceeded the threshold for the rule's duration, and resolves once the
+//! metric has stayed below the (lower) resolve threshold for as long. Firing opens an
+//! incident on the project's status page and sends a notification; resolving closes
+//! the incident and sends another.
+
+use std::collections::HashSet;
+use std::sync::Arc;
+
+use chrono::Utc;
+use sea_orm::{ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, QueryOrder, Set};
+use serde::{Deserialize, Serialize};
+use temps_core::notifications::{
+    NotificationData, NotificationPriority, NotificationService, NotificationType,
+};
+use temps_core::UtcDateTime;
+use temps_database::DbConnection;
+use temps_entities::resource_alert_rules::{
+    self, ALERT_METRIC_CPU_PERCENT, ALERT_METRIC_MEMORY_PERCENT,
+};
+use temps_entities::{
+    environment_metrics, environments, status_incident_updates, status_incidents,
+};
+use tracing::{error, info, warn};
+use utoipa::ToSchema;
+
+use super::DeploymentError;
+
+/// Sustained duration of rules that don't set one
+const DEFAULT_DURATION_SECONDS: i32 = 300;
+
+/// Incident severities
+const SEVERITIES: [&str; 3] = ["minor", "major", "critical"];
+
+/// Request to create or replace a resource alert rule
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct ResourceAlertRuleRequest {
+    #[schema(example = "Memory near limit")]
+    pub name: String,
+    /// `cpu_percent` (summed over containers, in percent of one host) or
+    /// `memory_percent` (of the containers' memory limits)
+    #[schema(example = "memory_percent")]
+    pub metric: String,
+    /// Fire when the metric stays above this
+    #[schema(example = 90.0)]
+    pub threshold: f64,
+    /// Resolve when the metric stays below this (default: 90% of the threshold)
+    #[serde(default)]
+    #[schema(example = 80.0)]
+    pub resolve_threshold: Option<f64>,
+    /// How long the metric must stay past a threshold, in seconds (default: 300)
+    #[serde(default)]
+    #[schema(example = 300)]
+    pub duration_seconds: Option<i32>,
+    /// Severity of the incident: minor, major or critical (default: major)
+    #[serde(default)]
+    pub severity: Option<String>,
+    #[serde(default = "default_enabled")]
+    pub enabled: bool,
+}
+
+fn default_enabled() -> bool {
+    true
+}
+
+/// Resource alert rule and its current state
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ResourceAlertRuleResponse {
+    pub id: i32,
+    pub project_id: i32,
+    pub environment_id: i32,
+    pub name: String,
+    pub metric: String,
+    pub threshold: f64,
+    pub resolve_threshold: f64,
+    pub duration_seconds: i32,
+    pub severity: String,
+    pub enabled: bool,
+    pub firing: bool,
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub last_fired_at: Option<UtcDateTime>,
+    /// Incident opened when the rule last fired
+    pub incident_id: Option<i32>,
+    #[schema(value_type = String, format = "date-time")]
+    pub created_at: UtcDateTime,
+    #[schema(value_type = String, format = "date-time")]
+    pub updated_at: UtcDateTime,
+}
+
+impl From<resource_alert_rules::Model> for ResourceAlertRuleResponse {
+    fn from(rule: resource_alert_rules::Model) -> Self {
+        Self {
+            id: rule.id,
+            project_id: rule.project_id,
+            environment_id: rule.environment_id,
+            name: rule.name,
+            metric: rule.metric,
+            threshold: rule.threshold,
+            resolve_threshold: rule.resolve_threshold,
+            duration_seconds: rule.duration_seconds,
+            severity: rule.severity,
+            enabled: rule.enabled,
+            firing: rule.firing,
+            last_fired_at: rule.last_fired_at,
+            incident_id: rule.incident_id,
+            created_at: rule.created_at,
+            updated_at: rule.updated_at,
+        }
+    }
+}
+
+/// Evaluation state of a rule
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct AlertState {
+    pub firing: bool,
+    pub breaching_since: Option<UtcDateTime>,
+    pub recovering_since: Option<UtcDateTime>,
+}
+
+/// Change of a rule's state worth telling people about
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum AlertTransition {
+    Fired,
+    Resolved,
+}
+
+/// Next state of a rule given the latest value of its metric, `None` when it has no
+/// value (e.g. memory of containers without a limit)
+///
+/// Values between the resolve threshold and the threshold keep a firing rule firing
+/// and a quiet rule quiet, and restart the clock of a pending change.
+pub fn evaluate_rule(
+    state: AlertState,
+    value: Option<f64>,
+    threshold: f64,
+    resolve_threshold: f64,
+    duration: chrono::Duration,
+    now: UtcDateTime,
+) -> (AlertState, Option<AlertTransition>) {
+    let Some(value) = value else {
+        let state = AlertState {
+            breaching_since: None,
+            ..state
+        };
+        return (state, None);
+    };
+
+    if !state.firing {
+        if value <= threshold {
+            return (
+                AlertState {
+                    breaching_since: None,
+                    ..state
+                },
+                None,
+            );
+        }
+        let since = state.breaching_since.unwrap_or(now);
+        if now - since >= duration {
+            let state = AlertState {
+                firing: true,
+                breaching_since: None,
+                recovering_since: None,
+            };
+            return (state, Some(AlertTransition::Fired));
+        }
+        return (
+            AlertState {
+                breaching_since: Some(since),
+                ..state
+            },
+            None,
+        );
+    }
+
+    if value >= resolve_threshold {
+        return (
+            AlertState {
+                recovering_since: None,
+                ..state
+            },
+            None,
+        );
+    }
+    let since = state.recovering_since.unwrap_or(now);
+    if now - since >= duration {
+        let state = AlertState {
+            firing: false,
+            breaching_since: None,
+            recovering_since: None,
+        };
+        return (state, Some(AlertTransition::Resolved));
+    }
+    (
+        AlertState {
+            recovering_since: Some(since),
+            ..state
+        },
+        None,
+    )
+}
+
+/// Value of a rule's metric in a sample
+pub fn metric_value(metric: &str, sample: &environment_metrics::Model) -> Option<f64> {
+    match metric {
+        ALERT_METRIC_CPU_PERCENT => Some(sample.cpu_percent),
+        ALERT_METRIC_MEMORY_PERCENT => sample
+            .memory_limit_bytes
+            .filter(|limit| *limit > 0)
+            .map(|limit| sample.memory_bytes as f64 / limit as f64 * 100.0),
+        _ => None,
+    }
+}
+
+fn metric_label(metric: &str) -> &'static str {
+    match metric {
+        ALERT_METRIC_MEMORY_PERCENT => "Memory",
+        _ => "CPU",
+    }
+}
+
+/// Evaluates resource alert rules and manages their incidents
+pub struct ResourceAlertService {
+    db: Arc<DbConnection>,
+    notification_service: Option<Arc<dyn NotificationService>>,
+}
+
+impl ResourceAlertService {
+    pub fn new(db: Arc<DbConnection>) -> Self {
+        Self {
+            db,
+            notification_service: None,
+        }
+    }
+
+    /// Also send alerts through the notification providers
+    pub fn with_notification_service(
+        mut self,
+        notification_service: Arc<dyn NotificationService>,
+    ) -> Self {
+        self.notification_service = Some(notification_service);
+        self
+    }
+
+    async fn find_environment(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<environments::Model, DeploymentError> {
+        environments::Entity::find_by_id(environment_id)
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Environment not found".to_string()))
+    }
+
+    async fn find_rule(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        rule_id: i32,
+    ) -> Result<resource_alert_rules::Model, DeploymentError> {
+        resource_alert_rules::Entity::find_by_id(rule_id)
+            .filter(resource_alert_rules::Column::ProjectId.eq(project_id))
+            .filter(resource_alert_rules::Column::EnvironmentId.eq(environment_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Alert rule not found".to_string()))
+    }
+
+    pub async fn list_rules(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<Vec<ResourceAlertRuleResponse>, DeploymentError> {
+        self.find_environment(project_id, environment_id).await?;
+        let rules = resource_alert_rules::Entity::find()
+            .filter(resource_alert_rules::Column::EnvironmentId.eq(environment_id))
+            .order_by_asc(resource_alert_rules::Column::Id)
+            .all(self.db.as_ref())
+            .await?;
+        Ok(rules.into_iter().map(Into::into).collect())
+    }
+
+    pub async fn create_rule(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        request: ResourceAlertRuleRequest,
+        user_id: i32,
+    ) -> Result<ResourceAlertRuleResponse, DeploymentError> {
+        self.find_environment(project_id, environment_id).await?;
+        let (resolve_threshold, duration_seconds, severity) = validate_request(&request)?;
+
+        let rule = resource_alert_rules::ActiveModel {
+            project_id: Set(project_id),
+            environment_id: Set(environment_id),
+            name: Set(request.name.trim().to_string()),
+            metric: Set(request.metric),
+            threshold: Set(request.threshold),
+            resolve_threshold: Set(resolve_threshold),
+            duration_seconds: Set(duration_seconds),
+            severity: Set(severity),
+            enabled: Set(request.enabled),
+            firing: Set(false),
+            created_by: Set(user_id),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        info!(
+            "Created resource alert rule {} for environment {}",
+            rule.id, environment_id
+        );
+        Ok(rule.into())
+    }
+
+    /// Replace a rule's settings; a firing rule keeps firing unless it is disabled,
+    /// which resolves its incident
+    pub async fn update_rule(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        rule_id: i32,
+        request: ResourceAlertRuleRequest,
+    ) -> Result<ResourceAlertRuleResponse, DeploymentError> {
+        let rule = self.find_rule(project_id, environment_id, rule_id).await?;
+        let (resolve_threshold, duration_seconds, severity) = validate_request(&request)?;
+
+        if rule.firing && !request.enabled {
+            self.resolve_incident(&rule, "Alert rule disabled").await;
+        }
+
+        let mut active: resource_alert_rules::ActiveModel = rule.into();
+        active.name = Set(request.name.trim().to_string());
+        active.metric = Set(request.metric);
+        active.threshold = Set(request.threshold);
+        active.resolve_threshold = Set(resolve_threshold);
+        active.duration_seconds = Set(duration_seconds);
+        active.severity = Set(severity);
+        active.enabled = Set(request.enabled);
+        if !request.enabled {
+            active.firing = Set(false);
+            active.breaching_since = Set(None);
+            active.recovering_since = Set(None);
+        }
+        let rule = active.update(self.db.as_ref()).await?;
+        Ok(rule.into())
+    }
+
+    pub async fn delete_rule(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        rule_id: i32,
+    ) -> Result<(), DeploymentError> {
+        let rule = self.find_rule(project_id, environment_id, rule_id).await?;
+        if rule.firing {
+            self.resolve_incident(&rule, "Alert rule deleted").await;
+        }
+        resource_alert_rules::Entity::delete_by_id(rule.id)
+            .exec(self.db.as_ref())
+            .await?;
+        Ok(())
+    }
+
+    /// Check the rules of the sampled environments against their new samples
+    pub async fn evaluate(&self, samples: &[environment_metrics::Model]) {
+        if samples.is_empty() {
+            return;
+        }
+        let environment_ids: HashSet<i32> =
+            samples.iter().map(|sample| sample.environment_id).collect();
+        let rules = match resource_alert_rules::Entity::find()
+            .filter(resource_alert_rules::Column::Enabled.eq(true))
+            .filter(resource_alert_rules::Column::EnvironmentId.is_in(environment_ids))
+            .all(self.db.as_ref())
+            .await
+        {
+            Ok(rules) => rules,
+            Err(e) => {
+                error!("Failed to load resource alert rules: {}", e);
+                return;
+            }
+        };
+
+        for rule in rules {
+            let Some(sample) = samples
+                .iter()
+                .find(|sample| sample.environment_id == rule.environment_id)
+            else {
+                continue;
+            };
+            if let Err(e) = self.evaluate_one(rule, sample).await {
+                error!("Failed to evaluate resource alert rule: {}", e);
+            }
+        }
+    }
+
+    async fn evaluate_one(
+        &self,
+        rule: resource_alert_rules::Model,
+        sample: &environment_metrics::Model,
+    ) -> Result<(), DeploymentError> {
+        let state = AlertState {
+            firing: rule.firing,
+            breaching_since: rule.breaching_since,
+            recovering_since: rule.recovering_since,
+        };
+        let value = metric_value(&rule.metric, sample);
+        let (next, transition) = evaluate_rule(
+            state,
+            value,
+            rule.threshold,
+            rule.resolve_threshold,
+            chrono::Duration::seconds(i64::from(rule.duration_seconds)),
+            sample.timestamp,
+        );
+        if next == state {
+            return Ok(());
+        }
+
+        let mut incident_id = rule.incident_id;
+        let mut last_fired_at = rule.last_fired_at;
+        match transition {
+            Some(AlertTransition::Fired) => {
+                last_fired_at = Some(sample.timestamp);
+                incident_id = self.open_incident(&rule, value.unwrap_or_default()).await;
+                self.notify(&rule, AlertTransition::Fired, value.unwrap_or_default())
+                    .await;
+            }
+            Some(AlertTransition::Resolved) => {
+                self.resolve_incident(
+                    &rule,
+                    &format!(
+                        "{} usage back below {}% ({:.1}%)",
+                        metric_label(&rule.metric),
+                        rule.resolve_threshold,
+                        value.unwrap_or_default()
+                    ),
+                )
+                .await;
+                self.notify(&rule, AlertTransition::Resolved, value.unwrap_or_default())
+                    .await;
+            }
+            None => {}
+        }
+
+        let mut active: resource_alert_rules::ActiveModel = rule.into();
+        active.firing = Set(next.firing);
+        active.breaching_since = Set(next.breaching_since);
+        active.recovering_since = Set(next.recovering_since);
+        active.last_fired_at = Set(last_fired_at);
+        active.incident_id = Set(incident_id);
+        active.update(self.db.as_ref()).await?;
+        Ok(())
+    }
+
+    /// Open an incident for a rule that fired; returns its id
+    async fn open_incident(&self, rule: &resource_alert_rules::Model, value: f64) -> Option<i32> {
+        let title = format!(
+            "{}: {} usage above {}%",
+            rule.name,
+            metric_label(&rule.metric),
+            rule.threshold
+        );
+        let message = format!(
+            "{} usage has been above {}% for {} seconds (now {:.1}%)",
+            metric_label(&rule.metric),
+            rule.threshold,
+            rule.duration_seconds,
+            value
+        );
+        let incident = status_incidents::ActiveModel {
+            project_id: Set(rule.project_id),
+            environment_id: Set(Some(rule.environment_id)),
+            monitor_id: Set(None),
+            title: Set(title),
+            description: Set(Some(message.clone())),
+            severity: Set(rule.severity.clone()),
+            status: Set("investigating".to_string()),
+            started_at: Set(Utc::now()),
+            resolved_at: Set(None),
+            ..Default::default()
+        };
+        let incident = match incident.insert(self.db.as_ref()).await {
+            Ok(incident) => incident,
+            Err(e) => {
+                error!(
+                    "Failed to open incident for resource alert rule {}: {}",
+                    rule.id, e
+                );
+                return None;
+            }
+        };
+        let update = status_incident_updates::ActiveModel {
+            incident_id: Set(incident.id),
+            status: Set("investigating".to_string()),
+            message: Set(message),
+            ..Default::default()
+        };
+        if let Err(e) = update.insert(self.db.as_ref()).await {
+            warn!("Failed to add update to incident {}: {}", incident.id, e);
+        }
+        Some(incident.id)
+    }
+
+    /// Resolve the incident a rule opened, if it is still open
+    async fn resolve_incident(&self, rule: &resource_alert_rules::Model, message: &str) {
+        let Some(incident_id) = rule.incident_id else {
+            return;
+        };
+        let incident = match status_incidents::Entity::find_by_id(incident_id)
+            .one(self.db.as_ref())
+            .await
+        {
+            Ok(Some(incident)) if incident.status != "resolved" => incident,
+            Ok(_) => return,
+            Err(e) => {
+                error!("Failed to load incident {}: {}", incident_id, e);
+                return;
+            }
+        };
+
+        let mut active: status_incidents::ActiveModel = incident.into();
+        active.status = Set("resolved".to_string());
+        active.resolved_at = Set(Some(Utc::now()));
+        if let Err(e) = active.update(self.db.as_ref()).await {
+            error!("Failed to resolve incident {}: {}", incident_id, e);
+            return;
+        }
+        let update = status_incident_updates::ActiveModel {
+            incident_id: Set(incident_id),
+            status: Set("resolved".to_string()),
+            message: Set(message.to_string()),
+            ..Default::default()
+        };
+        if let Err(e) = update.insert(self.db.as_ref()).await {
+            warn!("Failed to add update to incident {}: {}", incident_id, e);
+        }
+    }
+
+    async fn notify(
+        &self,
+        rule: &resource_alert_rules::Model,
+        transition: AlertTransition,
+        value: f64,
+    ) {
+        let Some(notification_service) = &self.notification_service else {
+            return;
+        };
+
+        let label = metric_label(&rule.metric);
+        let (title, message, notification_type, priority) = match transition {
+            AlertTransition::Fired => (
+                format!("{}: {} usage above {}%", rule.name, label, rule.threshold),
+                format!(
+                    "{} usage has been above {}% for {} seconds (now {:.1}%).",
+                    label, rule.threshold, rule.duration_seconds, value
+                ),
+                NotificationType::Alert,
+                match rule.severity.as_str() {
+                    "critical" => NotificationPriority::Critical,
+                    "minor" => NotificationPriority::Normal,
+                    _ => NotificationPriority::High,
+                },
+            ),
+            AlertTransition::Resolved => (
+                format!("Resolved: {}", rule.name),
+                format!(
+                    "{} usage is back below {}% (now {:.1}%).",
+                    label, rule.resolve_threshold, value
+                ),
+                NotificationType::Info,
+                NotificationPriority::Normal,
+            ),
+        };
+
+        let mut metadata = std::collections::HashMap::new();
+        metadata.insert("project_id".to_string(), rule.project_id.to_string());
+        metadata.insert(
+            "environment_id".to_string(),
+            rule.environment_id.to_string(),
+        );
+        metadata.insert("alert_rule_id".to_string(), rule.id.to_string());
+        metadata.insert("metric".to_string(), rule.metric.clone());
+        metadata.insert("value".to_string(), format!("{:.1}", value));
+
+        let notification = NotificationData {
+            id: temps_core::uuid::Uuid::new_v4().to_string(),
+            title,
+            message,
+            notification_type,
+            priority,
+            severity: Some(rule.severity.clone()),
+            timestamp: Utc::now(),
+            metadata,
+            bypass_throttling: false,
+        };
+        if let Err(e) = notification_service.send_notification(notification).await {
+            error!("Failed to send resource alert for rule {}: {}", rule.id, e);
+        }
+    }
+}
+
+/// Check a rule request; returns its resolve threshold, duration and severity with
+/// defaults applied
+fn validate_request(
+    request: &ResourceAlertRuleRequest,
+) -> Result<(f64, i32, String), DeploymentError> {
+    if request.name.trim().is_empty() {
+        return Err(DeploymentError::InvalidInput(
+            "Alert rule name is required".to_string(),
+        ));
+    }
+    if ![ALERT_METRIC_CPU_PERCENT, ALERT_METRIC_MEMORY_PERCENT].contains(&request.metric.as_str()) {
+        return Err(DeploymentError::InvalidInput(format!(
+            "Unknown metric '{}'; use '{}' or '{}'",
+            request.metric, ALERT_METRIC_CPU_PERCENT, ALERT_METRIC_MEMORY_PERCENT
+        )));
+    }
+    if !request.threshold.is_finite() || request.threshold <= 0.0 {
+        return Err(DeploymentError::InvalidInput(
+            "Threshold must be a positive percentage".to_string(),
+        ));
+    }
+    let resolve_threshold = request.resolve_threshold.unwrap_or(request.threshold * 0.9);
+    if !resolve_threshold.is_finite()
+        || resolve_threshold < 0.0
+        || resolve_threshold > request.threshold
+    {
+        return Err(DeploymentError::InvalidInput(
+            "Resolve threshold must be between 0 and the threshold".to_string(),
+        ));
+    }
+    let duration_seconds = request.duration_seconds.unwrap_or(DEFAULT_DURATION_SECONDS);
+    if duration_seconds < 0 {
+        return Err(DeploymentError::InvalidInput(
+            "Duration can't be negative".to_string(),
+        ));
+    }
+    let severity = request
+        .severity
+        .clone()
+        .unwrap_or_else(|| "major".to_string());
+    if !SEVERITIES.contains(&severity.as_str()) {
+        return Err(DeploymentError::InvalidInput(
+            "Invalid severity. Must be one of: minor, major, critical".to_string(),
+        ));
+    }
+    Ok((resolve_threshold, duration_seconds, severity))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const QUIET: AlertState = AlertState {
+        firing: false,
+        breaching_since: None,
+        recovering_since: None,
+    };
+
+    #[test]
+    fn test_rule_fires_after_sustained_breach() {
+        let start = Utc::now();
+        let duration = chrono::Duration::minutes(5);
+        let at = |minutes| start + chrono::Duration::minutes(minutes);
+
+        let (state, transition) = evaluate_rule(QUIET, Some(95.0), 90.0, 80.0, duration, at(0));
+        assert_eq!(transition, None);
+        assert_eq!(state.breaching_since, Some(at(0)));
+
+        let (state, transition) = evaluate_rule(state, Some(92.0), 90.0, 80.0, duration, at(4));
+        assert_eq!(transition, None);
+
+        let (state, transition) = evaluate_rule(state, Some(91.0), 90.0, 80.0, duration, at(5));
+        assert_eq!(transition, Some(AlertTransition::Fired));
+        assert!(state.firing);
+
+        // A dip below the threshold restarts the clock
+        let (state, _) = evaluate_rule(QUIET, Some(95.0), 90.0, 80.0, duration, at(0));
+        let (state, _) = evaluate_rule(state, Some(85.0), 90.0, 80.0, duration, at(3));
+        let (state, transition) = evaluate_rule(state, Some(95.0), 90.0, 80.0, duration, at(5));
+        assert_eq!(transition, None);
+        assert_eq!(state.breaching_since, Some(at(5)));
+    }
+
+    #[test]
+    fn test_rule_resolves_below_resolve_threshold_only() {
+        let start = Utc::now();
+        let duration = chrono::Duration::minutes(5);
+        let at = |minutes| start + chrono::Duration::minutes(minutes);
+        let firing = AlertState {
+            firing: true,
+            ..QUIET
+        };
+
+        // Between the two thresholds: still firing
+        let (state, transition) = evaluate_rule(firing, Some(85.0), 90.0, 80.0, duration, at(0));
+        assert_eq!((state, transition), (firing, None));
+
+        let (state, _) = evaluate_rule(state, Some(70.0), 90.0, 80.0, duration, at(1));
+        let (state, transition) = evaluate_rule(state, Some(75.0), 90.0, 80.0, duration, at(6));
+        assert_eq!(transition, Some(AlertTransition::Resolved));
+        assert_eq!(state, QUIET);
+
+        // No value keeps a firing rule firing
+        let (state, transition) = evaluate_rule(firing, None, 90.0, 80.0, duration, at(0));
+        assert_eq!((state, transition), (firing, None));
+    }
+
+    #[test]
+    fn test_memory_percent_needs_a_limit() {
+        let mut sample = environment_metrics::Model {
+            environment_id: 1,
+            timestamp: Utc::now(),
+            project_id: 1,
+            deployment_id: None,
+            container_count: 1,
+            cpu_percent: 12.5,
+            memory_bytes: 250,
+            memory_limit_bytes: Some(500),
+            network_rx_bytes: 0,
+            network_tx_bytes: 0,
+        };
+        assert_eq!(metric_value(ALERT_METRIC_CPU_PERCENT, &sample), Some(12.5));
+        assert_eq!(
+            metric_value(ALERT_METRIC_MEMORY_PERCENT, &sample),
+            Some(50.0)
+        );
+        sample.memory_limit_bytes = None;
+        assert_eq!(metric_value(ALERT_METRIC_MEMORY_PERCENT, &sample), None);
+    }
+}
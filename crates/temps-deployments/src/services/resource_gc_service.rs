@@ -0,0 +1,869 @@
+//! Orphaned Resource Garbage Collection
+//!
+//! Failed or superseded deployments leave behind stopped containers, images and networks.
+//! This service periodically removes Docker resources owned by Temps (identified by the
+//! `sh.temps.managed` / `built-by=temps` labels) that are no longer referenced by any
+//! active deployment:
+//! - stopped containers that do not belong to an active `deployment_containers` row
+//! - images that are not among the last `retained_images` deployments of an environment
+//!   and are not used by any remaining container. A project or environment can set its
+//!   own `retained_images` in its deployment config; the image an environment currently
+//!   runs, and those of deployments still in progress, are kept regardless of the count.
+//! - managed networks no container is attached to, except the network deployments and
+//!   services run on
+//!
+//! Volumes are left alone: deployments don't create any, and the volumes of managed
+//! services hold their data.
+//!
+//! A dry run produces the same report without removing anything.
+
+use sea_orm::{ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, QueryOrder};
+use serde::Serialize;
+use std::collections::{HashMap, HashSet};
+use std::sync::Arc;
+use temps_deployer::labels::{deployment_id_from_labels, LABEL_MANAGED};
+use tokio::time::{sleep, Duration};
+use tracing::{debug, error, info, warn};
+use utoipa::ToSchema;
+
+use super::DeploymentError;
+use temps_entities::deployment_config::DeploymentConfig;
+use temps_entities::{
+    deployment_containers, deployment_promotions, deployments, environments, projects,
+};
+
+/// Image owned by Temps, as seen on the runtime
+#[derive(Debug, Clone)]
+pub struct GcImage {
+    pub id: String,
+    pub tags: Vec<String>,
+    pub size_bytes: u64,
+    /// Creation time (Unix seconds)
+    pub created: i64,
+}
+
+/// Container owned by Temps, as seen on the runtime
+#[derive(Debug, Clone)]
+pub struct GcContainer {
+    pub id: String,
+    pub name: String,
+    /// Image reference (tag or ID) the container was created from
+    pub image: String,
+    pub running: bool,
+    pub labels: HashMap<String, String>,
+}
+
+/// Network owned by Temps that no container is attached to
+#[derive(Debug, Clone)]
+pub struct GcNetwork {
+    pub id: String,
+    pub name: String,
+}
+
+/// Trait for the runtime operations needed by the garbage collector (mockable for testing)
+#[async_trait::async_trait]
+pub trait GcRuntime: Send + Sync {
+    async fn list_images(&self) -> Result<Vec<GcImage>, String>;
+    async fn list_containers(&self) -> Result<Vec<GcContainer>, String>;
+    async fn list_unused_networks(&self) -> Result<Vec<GcNetwork>, String>;
+    async fn remove_container(&self, id: &str) -> Result<(), String>;
+    async fn remove_image(&self, id: &str) -> Result<(), String>;
+    async fn remove_network(&self, id: &str) -> Result<(), String>;
+
+    /// Host mountpoints of all volumes, used to report disk usage
+    async fn list_volume_mountpoints(&self) -> Result<Vec<String>, String> {
+        Ok(Vec::new())
+    }
+}
+
+/// Docker implementation of [`GcRuntime`]
+pub struct DockerGcRuntime {
+    docker: Arc<bollard::Docker>,
+}
+
+impl DockerGcRuntime {
+    pub fn new(docker: Arc<bollard::Docker>) -> Self {
+        Self { docker }
+    }
+
+    fn label_filter(label: &str) -> HashMap<String, Vec<String>> {
+        let mut filters: HashMap<String, Vec<String>> = HashMap::new();
+        filters.insert("label".to_string(), vec![label.to_string()]);
+        filters
+    }
+}
+
+#[async_trait::async_trait]
+impl GcRuntime for DockerGcRuntime {
+    async fn list_images(&self) -> Result<Vec<GcImage>, String> {
+        // Images built before managed labels existed only carry `built-by=temps`
+        let images = self
+            .docker
+            .list_images(Some(bollard::query_parameters::ListImagesOptions {
+                filters: Some(Self::label_filter("built-by=temps")),
+                ..Default::default()
+            }))
+            .await
+            .map_err(|e| format!("Failed to list images: {}", e))?;
+
+        Ok(images
+            .into_iter()
+            .map(|image| GcImage {
+                id: image.id,
+                tags: image
+                    .repo_tags
+                    .into_iter()
+                    .filter(|tag| tag != "<none>:<none>")
+                    .collect(),
+                size_bytes: image.size.max(0) as u64,
+                created: image.created,
+            })
+            .collect())
+    }
+
+    async fn list_containers(&self) -> Result<Vec<GcContainer>, String> {
+        let containers = self
+            .docker
+            .list_containers(Some(bollard::query_parameters::ListContainersOptions {
+                all: true,
+                filters: Some(Self::label_filter(&format!("{}=true", LABEL_MANAGED))),
+                ..Default::default()
+            }))
+            .await
+            .map_err(|e| format!("Failed to list containers: {}", e))?;
+
+        Ok(containers
+            .into_iter()
+            .filter_map(|container| {
+                let running = container
+                    .state
+                    .as_ref()
+                    .map(|s| s.to_string() == "running" || s.to_string() == "restarting")
+                    .unwrap_or(false);
+                Some(GcContainer {
+                    id: container.id?,
+                    name: container
+                        .names
+                        .and_then(|names| names.into_iter().next())
+                        .unwrap_or_default()
+                        .trim_start_matches('/')
+                        .to_string(),
+                    image: container.image.unwrap_or_default(),
+                    running,
+                    labels: container.labels.unwrap_or_default(),
+                })
+            })
+            .collect())
+    }
+
+    async fn list_unused_networks(&self) -> Result<Vec<GcNetwork>, String> {
+        let mut filters = Self::label_filter(&format!("{}=true", LABEL_MANAGED));
+        filters.insert("dangling".to_string(), vec!["true".to_string()]);
+
+        let networks = self
+            .docker
+            .list_networks(Some(bollard::query_parameters::ListNetworksOptions {
+                filters: Some(filters),
+            }))
+            .await
+            .map_err(|e| format!("Failed to list networks: {}", e))?;
+
+        Ok(networks
+            .into_iter()
+            .filter_map(|network| {
+                Some(GcNetwork {
+                    id: network.id?,
+                    name: network.name.unwrap_or_default(),
+                })
+            })
+            .collect())
+    }
+
+    async fn remove_container(&self, id: &str) -> Result<(), String> {
+        self.docker
+            .remove_container(
+                id,
+                Some(bollard::query_parameters::RemoveContainerOptions {
+                    force: true,
+                    ..Default::default()
+                }),
+            )
+            .await
+            .map_err(|e| format!("Failed to remove container {}: {}", id, e))
+    }
+
+    async fn remove_image(&self, id: &str) -> Result<(), String> {
+        self.docker
+            .remove_image(
+                id,
+                None::<bollard::query_parameters::RemoveImageOptions>,
+                None,
+            )
+            .await
+            .map(|_| ())
+            .map_err(|e| format!("Failed to remove image {}: {}", id, e))
+    }
+
+    async fn remove_network(&self, id: &str) -> Result<(), String> {
+        self.docker
+            .remove_network(id)
+            .await
+            .map_err(|e| format!("Failed to remove network {}: {}", id, e))
+    }
+
+    async fn list_volume_mountpoints(&self) -> Result<Vec<String>, String> {
+        let response = self
+            .docker
+            .list_volumes(None::<bollard::query_parameters::ListVolumesOptions>)
+            .await
+            .map_err(|e| format!("Failed to list volumes: {}", e))?;
+
+        Ok(response
+            .volumes
+            .unwrap_or_default()
+            .into_iter()
+            .map(|volume| volume.mountpoint)
+            .filter(|mountpoint| !mountpoint.is_empty())
+            .collect())
+    }
+}
+
+/// Kind of resource collected
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum GcResourceKind {
+    Container,
+    Image,
+    Network,
+}
+
+/// A resource selected for removal
+#[derive(Debug, Clone, PartialEq, Serialize, ToSchema)]
+pub struct GcItem {
+    pub kind: GcResourceKind,
+    /// Container, image or network ID
+    pub id: String,
+    /// Human readable name (container name, first image tag or network name)
+    pub name: String,
+    /// Size in bytes, when known (images only)
+    pub size_bytes: u64,
+    /// Whether the resource was removed (always false for dry runs)
+    pub removed: bool,
+    /// Error message if removal failed
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+/// Result of a garbage collection pass
+#[derive(Debug, Clone, Default, Serialize, ToSchema)]
+pub struct GcReport {
+    pub dry_run: bool,
+    pub items: Vec<GcItem>,
+    /// Total size of the selected resources in bytes
+    pub reclaimable_bytes: u64,
+    /// Size of the resources actually removed in bytes
+    pub reclaimed_bytes: u64,
+    /// When the pass ran (ISO 8601)
+    pub ran_at: String,
+}
+
+/// References that must be preserved, loaded from the database
+#[derive(Debug, Clone, Default)]
+pub struct GcReferences {
+    /// Deployment IDs with active (non-deleted) container records
+    pub active_deployment_ids: HashSet<i32>,
+    /// Container IDs recorded as active
+    pub active_container_ids: HashSet<String>,
+    /// Image references (tags or IDs) that must be kept
+    pub retained_images: HashSet<String>,
+    /// Creation time (Unix seconds) of the oldest deployment still in progress. Images
+    /// created since then may be what its build is producing, so they are kept
+    pub oldest_in_progress_since: Option<i64>,
+}
+
+/// Namespaced name of an image tag, defaulting to `:latest` when untagged
+pub(crate) fn normalize_image_ref(reference: &str) -> String {
+    let last_segment = reference.rsplit('/').next().unwrap_or(reference);
+    if reference.starts_with("sha256:") || last_segment.contains(':') {
+        reference.to_string()
+    } else {
+        format!("{}:latest", reference)
+    }
+}
+
+/// Number of deployment images an environment keeps for rollbacks: its own or its
+/// project's `retained_images`, else the global setting
+pub(crate) fn retained_image_count(
+    project_config: Option<&DeploymentConfig>,
+    environment_config: Option<&DeploymentConfig>,
+    default: u32,
+) -> u32 {
+    environment_config
+        .and_then(|config| config.retained_images)
+        .or_else(|| project_config.and_then(|config| config.retained_images))
+        .unwrap_or(default)
+        .max(1)
+}
+
+/// Deployment states in which a deployment no longer changes
+pub(crate) const FINISHED_STATES: &[&str] =
+    &["completed", "deployed", "failed", "cancelled", "stopped"];
+
+/// Images a deployment runs: its tag, and the image ID it was pinned to when it
+/// reuses the image of another deployment (promotions and redeploys)
+fn deployment_images(deployment: &deployments::Model) -> impl Iterator<Item = String> + '_ {
+    let promoted_image = deployment
+        .metadata
+        .as_ref()
+        .and_then(|metadata| metadata.promoted_image.as_deref());
+    deployment
+        .image_name
+        .as_deref()
+        .into_iter()
+        .chain(promoted_image)
+        .map(normalize_image_ref)
+}
+
+/// Images an environment keeps: those of its last `count` successful deployments for
+/// rollbacks, the one it currently runs however old it is, and those of deployments
+/// still in progress (pending, building, deploying...)
+///
+/// `deployments` are the environment's deployments, newest first.
+pub(crate) fn rollback_images(
+    deployments: &[deployments::Model],
+    current_deployment_id: Option<i32>,
+    count: u32,
+) -> Vec<String> {
+    let mut images: Vec<String> = deployments
+        .iter()
+        .filter(|d| d.image_name.is_some())
+        .filter(|d| {
+            Some(d.id) == current_deployment_id
+                || matches!(d.state.as_str(), "completed" | "deployed")
+        })
+        .take(count.max(1) as usize)
+        .filter_map(|d| d.image_name.as_deref().map(normalize_image_ref))
+        .collect();
+
+    let current_images = deployments
+        .iter()
+        .filter(|d| Some(d.id) == current_deployment_id)
+        .flat_map(deployment_images);
+    let in_progress_images = deployments
+        .iter()
+        .filter(|d| !FINISHED_STATES.contains(&d.state.as_str()))
+        .flat_map(deployment_images);
+    for image in current_images.chain(in_progress_images) {
+        if !images.contains(&image) {
+            images.push(image);
+        }
+    }
+    images
+}
+
+/// Select the resources that can safely be removed
+pub fn plan_gc(
+    references: &GcReferences,
+    images: &[GcImage],
+    containers: &[GcContainer],
+    networks: &[GcNetwork],
+) -> Vec<GcItem> {
+    let mut items = Vec::new();
+    let retained: HashSet<String> = references
+        .retained_images
+        .iter()
+        .map(|r| normalize_image_ref(r))
+        .collect();
+
+    // Containers: only stopped ones that no active deployment references
+    let mut surviving_images: HashSet<String> = HashSet::new();
+    for container in containers {
+        let referenced = references.active_container_ids.contains(&container.id)
+            || deployment_id_from_labels(&container.labels)
+                .map(|id| references.active_deployment_ids.contains(&id))
+                .unwrap_or(false);
+
+        if container.running || referenced {
+            surviving_images.insert(normalize_image_ref(&container.image));
+            continue;
+        }
+
+        items.push(GcItem {
+            kind: GcResourceKind::Container,
+            id: container.id.clone(),
+            name: container.name.clone(),
+            size_bytes: 0,
+            removed: false,
+            error: None,
+        });
+    }
+
+    // Images: keep retained tags and IDs, anything still used by a surviving container,
+    // and anything created while a deployment was in progress
+    for image in images {
+        let in_use = retained.contains(&image.id)
+            || surviving_images.contains(&image.id)
+            || image.tags.iter().any(|tag| {
+                let tag = normalize_image_ref(tag);
+                retained.contains(&tag) || surviving_images.contains(&tag)
+            });
+        let maybe_building = references
+            .oldest_in_progress_since
+            .is_some_and(|since| image.created >= since);
+        if in_use || maybe_building {
+            continue;
+        }
+
+        items.push(GcItem {
+            kind: GcResourceKind::Image,
+            id: image.id.clone(),
+            name: image
+                .tags
+                .first()
+                .cloned()
+                .unwrap_or_else(|| image.id.clone()),
+            size_bytes: image.size_bytes,
+            removed: false,
+            error: None,
+        });
+    }
+
+    // Networks: the one deployments and services run on stays even when empty
+    for network in networks {
+        if network.name == *temps_core::NETWORK_NAME {
+            continue;
+        }
+        items.push(GcItem {
+            kind: GcResourceKind::Network,
+            id: network.id.clone(),
+            name: network.name.clone(),
+            size_bytes: 0,
+            removed: false,
+            error: None,
+        });
+    }
+
+    items
+}
+
+/// Periodic garbage collector for orphaned Temps resources
+pub struct ResourceGcService {
+    db: Arc<DatabaseConnection>,
+    runtime: Arc<dyn GcRuntime>,
+    config_service: Arc<temps_config::ConfigService>,
+    /// Serializes concurrent runs (scheduler vs manual trigger)
+    run_lock: tokio::sync::Mutex<()>,
+}
+
+impl ResourceGcService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        runtime: Arc<dyn GcRuntime>,
+        config_service: Arc<temps_config::ConfigService>,
+    ) -> Self {
+        Self {
+            db,
+            runtime,
+            config_service,
+            run_lock: tokio::sync::Mutex::new(()),
+        }
+    }
+
+    /// Load references that must survive garbage collection
+    ///
+    /// `retained_images` is the global count, used by environments that don't set their own.
+    async fn load_references(&self, retained_images: u32) -> Result<GcReferences, DeploymentError> {
+        let mut references = GcReferences::default();
+
+        let active_containers = deployment_containers::Entity::find()
+            .filter(deployment_containers::Column::DeletedAt.is_null())
+            .all(self.db.as_ref())
+            .await?;
+        for container in active_containers {
+            references
+                .active_deployment_ids
+                .insert(container.deployment_id);
+            references
+                .active_container_ids
+                .insert(container.container_id);
+            if let Some(image) = container.image_name {
+                references.retained_images.insert(image);
+            }
+        }
+
+        let envs = environments::Entity::find()
+            .filter(environments::Column::DeletedAt.is_null())
+            .all(self.db.as_ref())
+            .await?;
+        let project_configs: HashMap<i32, Option<DeploymentConfig>> = projects::Entity::find()
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|project| (project.id, project.deployment_config))
+            .collect();
+
+        let mut env_deployments: HashMap<i32, Vec<deployments::Model>> = HashMap::new();
+        if !envs.is_empty() {
+            let all_deployments = deployments::Entity::find()
+                .filter(deployments::Column::EnvironmentId.is_in(envs.iter().map(|env| env.id)))
+                .filter(deployments::Column::ImageName.is_not_null())
+                .order_by_desc(deployments::Column::CreatedAt)
+                .all(self.db.as_ref())
+                .await?;
+            for deployment in all_deployments {
+                env_deployments
+                    .entry(deployment.environment_id)
+                    .or_default()
+                    .push(deployment);
+            }
+        }
+
+        for env in envs {
+            if let Some(current) = env.current_deployment_id {
+                references.active_deployment_ids.insert(current);
+            }
+
+            let count = retained_image_count(
+                project_configs
+                    .get(&env.project_id)
+                    .and_then(|config| config.as_ref()),
+                env.deployment_config.as_ref(),
+                retained_images,
+            );
+            let recent = env_deployments.remove(&env.id).unwrap_or_default();
+
+            references.retained_images.extend(rollback_images(
+                &recent,
+                env.current_deployment_id,
+                count,
+            ));
+        }
+
+        // Deployments in progress anywhere, including ones still building that have no
+        // image yet
+        let in_progress = deployments::Entity::find()
+            .filter(deployments::Column::State.is_not_in(FINISHED_STATES.iter().copied()))
+            .all(self.db.as_ref())
+            .await?;
+        for deployment in &in_progress {
+            references
+                .retained_images
+                .extend(deployment_images(deployment));
+        }
+        references.oldest_in_progress_since = in_progress
+            .iter()
+            .map(|deployment| deployment.created_at.timestamp())
+            .min();
+
+        // Promotions waiting for approval deploy the image ID they pinned once approved
+        let pending_promotions = deployment_promotions::Entity::find()
+            .filter(
+                deployment_promotions::Column::Status
+                    .eq(deployment_promotions::PROMOTION_STATUS_PENDING_APPROVAL),
+            )
+            .all(self.db.as_ref())
+            .await?;
+        references
+            .retained_images
+            .extend(pending_promotions.into_iter().flat_map(|promotion| {
+                promotion
+                    .image_digest
+                    .into_iter()
+                    .chain(promotion.image_name)
+            }));
+
+        Ok(references)
+    }
+
+    /// Run garbage collection. With `dry_run` nothing is removed.
+    pub async fn run(&self, dry_run: bool) -> Result<GcReport, DeploymentError> {
+        let _guard = self.run_lock.lock().await;
+
+        let settings = self
+            .config_service
+            .get_settings()
+            .await
+            .map_err(|e| DeploymentError::Other(format!("Failed to load settings: {}", e)))?;
+        let references = self
+            .load_references(settings.garbage_collection.retained_images)
+            .await?;
+
+        let images = self
+            .runtime
+            .list_images()
+            .await
+            .map_err(DeploymentError::Other)?;
+        let containers = self
+            .runtime
+            .list_containers()
+            .await
+            .map_err(DeploymentError::Other)?;
+        let networks = self
+            .runtime
+            .list_unused_networks()
+            .await
+            .map_err(DeploymentError::Other)?;
+
+        let mut items = plan_gc(&references, &images, &containers, &networks);
+        let reclaimable_bytes = items.iter().map(|i| i.size_bytes).sum();
+        let mut reclaimed_bytes = 0;
+
+        if !dry_run {
+            // Containers first so the images they use can be removed afterwards
+            for item in items.iter_mut() {
+                let result = match item.kind {
+                    GcResourceKind::Container => self.runtime.remove_container(&item.id).await,
+                    GcResourceKind::Image => self.runtime.remove_image(&item.id).await,
+                    GcResourceKind::Network => self.runtime.remove_network(&item.id).await,
+                };
+                match result {
+                    Ok(()) => {
+                        debug!("GC removed {:?} {}", item.kind, item.name);
+                        item.removed = true;
+                        reclaimed_bytes += item.size_bytes;
+                    }
+                    Err(e) => {
+                        warn!("GC failed to remove {:?} {}: {}", item.kind, item.name, e);
+                        item.error = Some(e);
+                    }
+                }
+            }
+        }
+
+        let report = GcReport {
+            dry_run,
+            items,
+            reclaimable_bytes,
+            reclaimed_bytes,
+            ran_at: chrono::Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true),
+        };
+
+        let count = |kind: GcResourceKind| {
+            report
+                .items
+                .iter()
+                .filter(|i| i.kind == kind && (dry_run || i.removed))
+                .count()
+        };
+        if dry_run {
+            info!(
+                "🧹 GC dry run: {} containers, {} images, {} networks reclaimable ({} MB)",
+                count(GcResourceKind::Container),
+                count(GcResourceKind::Image),
+                count(GcResourceKind::Network),
+                report.reclaimable_bytes / (1024 * 1024)
+            );
+        } else {
+            info!(
+                "🧹 GC removed {} containers, {} images, {} networks, freed {} MB",
+                count(GcResourceKind::Container),
+                count(GcResourceKind::Image),
+                count(GcResourceKind::Network),
+                report.reclaimed_bytes / (1024 * 1024)
+            );
+        }
+
+        Ok(report)
+    }
+
+    /// Start the periodic GC scheduler (blocking, should be spawned in tokio task)
+    pub async fn start_gc_scheduler(&self) {
+        info!("Orphaned resource GC scheduler started");
+
+        loop {
+            let settings = self
+                .config_service
+                .get_settings()
+                .await
+                .map(|s| s.garbage_collection)
+                .unwrap_or_default();
+
+            sleep(Duration::from_secs(settings.interval_seconds.max(300))).await;
+
+            if !settings.enabled {
+                debug!("Orphaned resource GC disabled, skipping run");
+                continue;
+            }
+
+            if let Err(e) = self.run(false).await {
+                error!("❌ Orphaned resource GC failed: {}", e);
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use temps_deployer::labels::deployment_labels;
+
+    fn container(id: &str, image: &str, deployment_id: i32, running: bool) -> GcContainer {
+        GcContainer {
+            id: id.to_string(),
+            name: format!("name-{}", id),
+            image: image.to_string(),
+            running,
+            labels: deployment_labels(1, 1, deployment_id),
+        }
+    }
+
+    fn image(id: &str, tag: &str, size: u64) -> GcImage {
+        GcImage {
+            id: id.to_string(),
+            tags: vec![tag.to_string()],
+            size_bytes: size,
+            created: 1_000,
+        }
+    }
+
+    fn ids(items: &[GcItem], kind: GcResourceKind) -> Vec<String> {
+        items
+            .iter()
+            .filter(|i| i.kind == kind)
+            .map(|i| i.id.clone())
+            .collect()
+    }
+
+    #[test]
+    fn test_stopped_orphaned_containers_are_collected() {
+        let references = GcReferences {
+            active_deployment_ids: HashSet::from([2]),
+            ..Default::default()
+        };
+        let items = plan_gc(
+            &references,
+            &[],
+            &[
+                container("old", "app-1:latest", 1, false),
+                container("current", "app-2:latest", 2, false),
+                container("running", "app-3:latest", 3, true),
+            ],
+            &[],
+        );
+
+        assert_eq!(ids(&items, GcResourceKind::Container), vec!["old"]);
+    }
+
+    #[test]
+    fn test_retained_and_in_use_images_are_kept() {
+        let references = GcReferences {
+            active_deployment_ids: HashSet::from([3]),
+            retained_images: HashSet::from(["app-2".to_string()]),
+            ..Default::default()
+        };
+        let items = plan_gc(
+            &references,
+            &[
+                image("sha256:1", "app-1:latest", 100),
+                image("sha256:2", "app-2:latest", 200),
+                image("sha256:3", "app-3:latest", 300),
+            ],
+            &[container("c3", "app-3:latest", 3, true)],
+            &[],
+        );
+
+        assert_eq!(ids(&items, GcResourceKind::Image), vec!["sha256:1"]);
+        assert_eq!(items[0].size_bytes, 100);
+    }
+
+    #[test]
+    fn test_images_pinned_by_id_are_kept() {
+        let references = GcReferences {
+            retained_images: HashSet::from(["sha256:2".to_string()]),
+            ..Default::default()
+        };
+        let items = plan_gc(
+            &references,
+            &[
+                image("sha256:1", "app-1:latest", 100),
+                image("sha256:2", "app-2:latest", 200),
+            ],
+            &[],
+            &[],
+        );
+
+        assert_eq!(ids(&items, GcResourceKind::Image), vec!["sha256:1"]);
+    }
+
+    #[test]
+    fn test_images_newer_than_oldest_build_in_progress_are_kept() {
+        let references = GcReferences {
+            oldest_in_progress_since: Some(2_000),
+            ..Default::default()
+        };
+        let items = plan_gc(
+            &references,
+            &[
+                image("sha256:1", "app-1:latest", 100),
+                GcImage {
+                    created: 2_500,
+                    ..image("sha256:2", "app-2:latest", 200)
+                },
+            ],
+            &[],
+            &[],
+        );
+
+        assert_eq!(ids(&items, GcResourceKind::Image), vec!["sha256:1"]);
+    }
+
+    #[test]
+    fn test_retained_image_count_overrides() {
+        let keep = |count| DeploymentConfig {
+            retained_images: Some(count),
+            ..Default::default()
+        };
+
+        assert_eq!(retained_image_count(None, None, 3), 3);
+        assert_eq!(retained_image_count(Some(&keep(10)), None, 3), 10);
+        assert_eq!(retained_image_count(Some(&keep(10)), Some(&keep(1)), 3), 1);
+        assert_eq!(
+            retained_image_count(Some(&DeploymentConfig::default()), Some(&keep(5)), 3),
+            5
+        );
+    }
+
+    #[test]
+    fn test_image_of_collected_container_is_collected() {
+        let items = plan_gc(
+            &GcReferences::default(),
+            &[image("sha256:1", "app-1:latest", 100)],
+            &[container("old", "app-1:latest", 1, false)],
+            &[],
+        );
+
+        assert_eq!(ids(&items, GcResourceKind::Container), vec!["old"]);
+        assert_eq!(ids(&items, GcResourceKind::Image), vec!["sha256:1"]);
+    }
+
+    #[test]
+    fn test_shared_network_is_never_collected() {
+        let network = |id: &str, name: &str| GcNetwork {
+            id: id.to_string(),
+            name: name.to_string(),
+        };
+
+        let items = plan_gc(
+            &GcReferences::default(),
+            &[],
+            &[],
+            &[
+                network("n1", temps_core::NETWORK_NAME.as_str()),
+                network("n2", "temps-old-network"),
+            ],
+        );
+
+        assert_eq!(ids(&items, GcResourceKind::Network), vec!["n2"]);
+    }
+
+    #[test]
+    fn test_normalize_image_ref() {
+        assert_eq!(normalize_image_ref("app"), "app:latest");
+        assert_eq!(normalize_image_ref("app:v1"), "app:v1");
+        assert_eq!(
+            normalize_image_ref("registry:5000/app"),
+            "registry:5000/app:latest"
+        );
+        assert_eq!(normalize_image_ref("sha256:abc"), "sha256:abc");
+    }
+}
@@ -0,0 +1,463 @@
+//! Runtime Config
+//!
+//! Non-secret runtime config values of an environment, such as feature flags and log
+//! levels, that change without a rebuild. The values are written as a JSON object to
+//! a file on the host whose directory is mounted read-only into every container at
+//! `/etc/temps`, so rewriting it changes what the running containers see.
+//!
+//! After a change the containers pick it up the way the environment's
+//! `config_reload` says: apps that can reload get a signal or watch the file
+//! themselves, the others are restarted in place. A reload that fails falls back to a
+//! restart. Every change is recorded with how it was applied.
+
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+
+use bollard::Docker;
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, QueryOrder, QuerySelect, Set,
+};
+use temps_database::DbConnection;
+use temps_entities::deployment_config::{
+    ConfigReloadMethod, RUNTIME_CONFIG_DIR, RUNTIME_CONFIG_FILE,
+};
+use temps_entities::runtime_config_changes::{
+    self, CONFIG_CHANGE_METHOD_DEFERRED, CONFIG_CHANGE_METHOD_RELOAD, CONFIG_CHANGE_METHOD_RESTART,
+    CONFIG_CHANGE_STATUS_APPLIED, CONFIG_CHANGE_STATUS_FAILED,
+};
+use temps_entities::{deployment_containers, environments, projects, runtime_config_values};
+use tokio::sync::Mutex;
+use tracing::{info, warn};
+
+use super::DeploymentError;
+
+/// Directory under the data directory holding each environment's runtime config
+const RUNTIME_CONFIG_DATA_DIR: &str = "runtime-config";
+/// Longest runtime config key
+pub const MAX_RUNTIME_CONFIG_KEY_LENGTH: usize = 128;
+/// Longest runtime config value, in bytes
+pub const MAX_RUNTIME_CONFIG_VALUE_LENGTH: usize = 4096;
+/// Changes listed per environment
+const CHANGE_HISTORY_LIMIT: u64 = 50;
+
+/// Check a runtime config key: a letter or `_`, then letters, digits, `_`, `.` or `-`
+pub fn validate_runtime_config_key(key: &str) -> Result<(), DeploymentError> {
+    let mut chars = key.chars();
+    let valid = key.len() <= MAX_RUNTIME_CONFIG_KEY_LENGTH
+        && chars
+            .next()
+            .is_some_and(|c| c.is_ascii_alphabetic() || c == '_')
+        && chars.all(|c| c.is_ascii_alphanumeric() || matches!(c, '_' | '.' | '-'));
+    if !valid {
+        return Err(DeploymentError::InvalidInput(format!(
+            "'{}' is not a runtime config key: use up to {} letters, digits, '_', '.' or '-', \
+             starting with a letter or '_'",
+            key, MAX_RUNTIME_CONFIG_KEY_LENGTH
+        )));
+    }
+    Ok(())
+}
+
+/// Host directory mounted into an environment's containers at `/etc/temps`
+pub fn runtime_config_host_dir(data_dir: &Path, environment_id: i32) -> PathBuf {
+    data_dir
+        .join(RUNTIME_CONFIG_DATA_DIR)
+        .join(environment_id.to_string())
+}
+
+/// Mount of an environment's runtime config directory into its containers
+pub fn runtime_config_mount(host_dir: PathBuf) -> temps_deployer::BindMount {
+    temps_deployer::BindMount {
+        host_path: host_dir,
+        container_path: RUNTIME_CONFIG_DIR.into(),
+        read_only: true,
+    }
+}
+
+/// Contents of the runtime config file
+fn render_runtime_config(values: &BTreeMap<String, String>) -> String {
+    let mut rendered = serde_json::to_string_pretty(values).unwrap_or_else(|_| "{}".to_string());
+    rendered.push('\n');
+    rendered
+}
+
+/// Write an environment's values to its runtime config file
+///
+/// The file is replaced in one rename, so a watching app never reads it half
+/// written. Returns the directory to mount.
+pub async fn write_runtime_config_file(
+    db: &DbConnection,
+    data_dir: &Path,
+    environment_id: i32,
+) -> Result<PathBuf, DeploymentError> {
+    let values: BTreeMap<String, String> = runtime_config_values::Entity::find()
+        .filter(runtime_config_values::Column::EnvironmentId.eq(environment_id))
+        .all(db)
+        .await?
+        .into_iter()
+        .map(|value| (value.key, value.value))
+        .collect();
+
+    let dir = runtime_config_host_dir(data_dir, environment_id);
+    let io_error = |e: std::io::Error| {
+        DeploymentError::Other(format!(
+            "Failed to write runtime config of environment {}: {}",
+            environment_id, e
+        ))
+    };
+    tokio::fs::create_dir_all(&dir).await.map_err(io_error)?;
+    let staging = dir.join(format!(".{}.tmp", RUNTIME_CONFIG_FILE));
+    tokio::fs::write(&staging, render_runtime_config(&values))
+        .await
+        .map_err(io_error)?;
+    tokio::fs::rename(&staging, dir.join(RUNTIME_CONFIG_FILE))
+        .await
+        .map_err(io_error)?;
+
+    Ok(dir)
+}
+
+/// How a change reached the running containers
+struct ApplyOutcome {
+    method: &'static str,
+    error: Option<String>,
+}
+
+/// Changes the runtime config of environments and gets it to their containers
+pub struct RuntimeConfigService {
+    db: Arc<DbConnection>,
+    docker: Arc<Docker>,
+    config_service: Arc<temps_config::ConfigService>,
+    /// Changes are written and applied one at a time
+    apply_lock: Mutex<()>,
+}
+
+impl RuntimeConfigService {
+    pub fn new(
+        db: Arc<DbConnection>,
+        docker: Arc<Docker>,
+        config_service: Arc<temps_config::ConfigService>,
+    ) -> Self {
+        Self {
+            db,
+            docker,
+            config_service,
+            apply_lock: Mutex::new(()),
+        }
+    }
+
+    /// Runtime config values of an environment, by key
+    pub async fn list_values(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<BTreeMap<String, String>, DeploymentError> {
+        self.find_environment(project_id, environment_id).await?;
+        Ok(runtime_config_values::Entity::find()
+            .filter(runtime_config_values::Column::EnvironmentId.eq(environment_id))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|value| (value.key, value.value))
+            .collect())
+    }
+
+    /// Recent changes to an environment's runtime config, latest first
+    pub async fn list_changes(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<Vec<runtime_config_changes::Model>, DeploymentError> {
+        self.find_environment(project_id, environment_id).await?;
+        Ok(runtime_config_changes::Entity::find()
+            .filter(runtime_config_changes::Column::EnvironmentId.eq(environment_id))
+            .order_by_desc(runtime_config_changes::Column::Id)
+            .limit(CHANGE_HISTORY_LIMIT)
+            .all(self.db.as_ref())
+            .await?)
+    }
+
+    /// Set a runtime config value, or remove it with None, and apply it to the running
+    /// containers
+    ///
+    /// The value is saved even when the containers can't pick it up; the change is
+    /// then recorded as failed and applies when they next start.
+    pub async fn set_value(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        key: &str,
+        value: Option<String>,
+        changed_by: i32,
+    ) -> Result<runtime_config_changes::Model, DeploymentError> {
+        validate_runtime_config_key(key)?;
+        if let Some(value) = &value {
+            if value.len() > MAX_RUNTIME_CONFIG_VALUE_LENGTH {
+                return Err(DeploymentError::InvalidInput(format!(
+                    "Runtime config values can be at most {} bytes",
+                    MAX_RUNTIME_CONFIG_VALUE_LENGTH
+                )));
+            }
+        }
+        let environment = self.find_environment(project_id, environment_id).await?;
+        let project = projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Project not found".to_string()))?;
+
+        let _guard = self.apply_lock.lock().await;
+
+        let existing = runtime_config_values::Entity::find()
+            .filter(runtime_config_values::Column::EnvironmentId.eq(environment_id))
+            .filter(runtime_config_values::Column::Key.eq(key))
+            .one(self.db.as_ref())
+            .await?;
+        match (&value, existing) {
+            (Some(value), Some(existing)) => {
+                let mut active: runtime_config_values::ActiveModel = existing.into();
+                active.value = Set(value.clone());
+                active.update(self.db.as_ref()).await?;
+            }
+            (Some(value), None) => {
+                runtime_config_values::ActiveModel {
+                    environment_id: Set(environment_id),
+                    key: Set(key.to_string()),
+                    value: Set(value.clone()),
+                    ..Default::default()
+                }
+                .insert(self.db.as_ref())
+                .await?;
+            }
+            (None, Some(existing)) => {
+                runtime_config_values::Entity::delete_by_id(existing.id)
+                    .exec(self.db.as_ref())
+                    .await?;
+            }
+            (None, None) => {
+                return Err(DeploymentError::NotFound(format!(
+                    "Runtime config value '{}' not found",
+                    key
+                )));
+            }
+        }
+
+        write_runtime_config_file(
+            self.db.as_ref(),
+            &self.config_service.data_dir(),
+            environment_id,
+        )
+        .await?;
+
+        let config = environment
+            .get_effective_deployment_config(&project.deployment_config.unwrap_or_default());
+        let outcome = self.apply(&environment, &config).await?;
+
+        let change = runtime_config_changes::ActiveModel {
+            environment_id: Set(environment_id),
+            key: Set(key.to_string()),
+            value: Set(value),
+            status: Set(if outcome.error.is_some() {
+                CONFIG_CHANGE_STATUS_FAILED.to_string()
+            } else {
+                CONFIG_CHANGE_STATUS_APPLIED.to_string()
+            }),
+            method: Set(outcome.method.to_string()),
+            error: Set(outcome.error),
+            changed_by: Set(changed_by),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        info!(
+            "Runtime config '{}' of environment {} changed, applied by {} ({})",
+            key, environment_id, change.method, change.status
+        );
+        Ok(change)
+    }
+
+    /// Get the rewritten file to the running containers that have it mounted
+    async fn apply(
+        &self,
+        environment: &environments::Model,
+        config: &temps_entities::deployment_config::DeploymentConfig,
+    ) -> Result<ApplyOutcome, DeploymentError> {
+        let Some(deployment_id) = environment.current_deployment_id else {
+            return Ok(ApplyOutcome {
+                method: CONFIG_CHANGE_METHOD_DEFERRED,
+                error: None,
+            });
+        };
+        let containers = deployment_containers::Entity::find()
+            .filter(deployment_containers::Column::DeploymentId.eq(deployment_id))
+            .filter(deployment_containers::Column::DeletedAt.is_null())
+            .filter(deployment_containers::Column::Status.eq("running"))
+            .all(self.db.as_ref())
+            .await?;
+
+        // Containers deployed before runtime config don't see the file
+        let mut mounted = Vec::new();
+        for container in containers {
+            if self.has_runtime_config_mount(&container.container_id).await {
+                mounted.push(container.container_id);
+            }
+        }
+        if mounted.is_empty() {
+            return Ok(ApplyOutcome {
+                method: CONFIG_CHANGE_METHOD_DEFERRED,
+                error: None,
+            });
+        }
+
+        let config_reload = config.config_reload.clone().unwrap_or_default();
+        match config_reload.method() {
+            ConfigReloadMethod::Watch => {
+                return Ok(ApplyOutcome {
+                    method: CONFIG_CHANGE_METHOD_RELOAD,
+                    error: None,
+                });
+            }
+            ConfigReloadMethod::Signal => {
+                match self
+                    .signal_containers(&mounted, config_reload.signal())
+                    .await
+                {
+                    Ok(()) => {
+                        return Ok(ApplyOutcome {
+                            method: CONFIG_CHANGE_METHOD_RELOAD,
+                            error: None,
+                        });
+                    }
+                    Err(e) => warn!(
+                        "Config reload of environment {} failed, restarting instead: {}",
+                        environment.id, e
+                    ),
+                }
+            }
+            ConfigReloadMethod::Restart => {}
+        }
+
+        let error = self
+            .restart_containers(&mounted, config.stop_timeout_seconds())
+            .await
+            .err();
+        Ok(ApplyOutcome {
+            method: CONFIG_CHANGE_METHOD_RESTART,
+            error,
+        })
+    }
+
+    async fn has_runtime_config_mount(&self, container_id: &str) -> bool {
+        match self
+            .docker
+            .inspect_container(
+                container_id,
+                None::<bollard::query_parameters::InspectContainerOptions>,
+            )
+            .await
+        {
+            Ok(container) => container
+                .mounts
+                .unwrap_or_default()
+                .iter()
+                .any(|mount| mount.destination.as_deref() == Some(RUNTIME_CONFIG_DIR)),
+            Err(e) => {
+                warn!("Failed to inspect container {}: {}", container_id, e);
+                false
+            }
+        }
+    }
+
+    async fn signal_containers(
+        &self,
+        container_ids: &[String],
+        signal: &str,
+    ) -> Result<(), String> {
+        for container_id in container_ids {
+            self.docker
+                .kill_container(
+                    container_id,
+                    Some(bollard::query_parameters::KillContainerOptions {
+                        signal: signal.to_string(),
+                    }),
+                )
+                .await
+                .map_err(|e| format!("Failed to signal container {}: {}", container_id, e))?;
+        }
+        Ok(())
+    }
+
+    /// Restart the containers one at a time, so replicas keep serving
+    async fn restart_containers(
+        &self,
+        container_ids: &[String],
+        stop_timeout_seconds: u32,
+    ) -> Result<(), String> {
+        for container_id in container_ids {
+            self.docker
+                .restart_container(
+                    container_id,
+                    Some(bollard::query_parameters::RestartContainerOptions {
+                        t: Some(stop_timeout_seconds as i32),
+                        ..Default::default()
+                    }),
+                )
+                .await
+                .map_err(|e| format!("Failed to restart container {}: {}", container_id, e))?;
+        }
+        Ok(())
+    }
+
+    async fn find_environment(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<environments::Model, DeploymentError> {
+        environments::Entity::find_by_id(environment_id)
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .filter(environments::Column::DeletedAt.is_null())
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Environment not found".to_string()))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_validate_runtime_config_key() {
+        for key in ["FEATURE_CHECKOUT", "log.level", "_internal", "new-ui"] {
+            assert!(validate_runtime_config_key(key).is_ok(), "{}", key);
+        }
+        for key in ["", "1st", "with space", "path/like", &"k".repeat(129)] {
+            assert!(validate_runtime_config_key(key).is_err(), "{}", key);
+        }
+    }
+
+    #[test]
+    fn test_runtime_config_file_is_a_sorted_json_object() {
+        let values = BTreeMap::from([
+            ("LOG_LEVEL".to_string(), "debug".to_string()),
+            ("FEATURE_CHECKOUT".to_string(), "on".to_string()),
+        ]);
+        let rendered = render_runtime_config(&values);
+
+        assert!(rendered.find("FEATURE_CHECKOUT") < rendered.find("LOG_LEVEL"));
+        let parsed: BTreeMap<String, String> = serde_json::from_str(&rendered).unwrap();
+        assert_eq!(parsed, values);
+        assert_eq!(render_runtime_config(&BTreeMap::new()), "{}\n");
+    }
+
+    #[test]
+    fn test_runtime_config_is_mounted_read_only() {
+        let dir = runtime_config_host_dir(Path::new("/var/lib/temps"), 7);
+        assert_eq!(dir, PathBuf::from("/var/lib/temps/runtime-config/7"));
+
+        let mount = runtime_config_mount(dir);
+        assert_eq!(mount.container_path, PathBuf::from("/etc/temps"));
+        assert!(mount.read_only);
+    }
+}
@@ -14,7 +14,68 @@ use crate::services::types::{
     Deployment, DeploymentDomain, DeploymentEnvironment, DeploymentListResponse,
 };
 use crate::UpdateDeploymentSettingsRequest;
+use temps_core::pagination::{CursorPage, ListRequest, ListSpec, Sort};
 use temps_core::WorkflowTask;
+use temps_database::queries::{
+    list_filter, list_select, page_from_models, ListField, ListFieldKind,
+};
+
+fn newest_first() -> Sort {
+    Sort::desc("created_at")
+}
+
+/// Sorting and filtering of a project's deployment list
+pub const DEPLOYMENT_LIST: ListSpec = ListSpec {
+    sort_fields: &["created_at", "id"],
+    filter_fields: &["state", "environment_id", "branch", "created_at"],
+    default_sort: newest_first,
+};
+
+const DEPLOYMENT_FIELDS: [ListField<deployments::Column>; 5] = [
+    ListField::new(
+        "created_at",
+        deployments::Column::CreatedAt,
+        ListFieldKind::Timestamp,
+    ),
+    ListField::new("id", deployments::Column::Id, ListFieldKind::Integer),
+    ListField::new("state", deployments::Column::State, ListFieldKind::Text),
+    ListField::new(
+        "environment_id",
+        deployments::Column::EnvironmentId,
+        ListFieldKind::Integer,
+    ),
+    ListField::new(
+        "branch",
+        deployments::Column::BranchRef,
+        ListFieldKind::Text,
+    ),
+];
+
+/// Container log settings of an environment
+fn container_logs_config(
+    project: &projects::Model,
+    environment: &environments::Model,
+) -> temps_entities::deployment_config::ContainerLogsConfig {
+    environment
+        .get_effective_deployment_config(&project.deployment_config.clone().unwrap_or_default())
+        .container_logs
+        .unwrap_or_default()
+}
+
+/// Reads the app's own timestamps from an environment's container logs, when
+/// configured
+fn log_timestamp_extractor(
+    config: &temps_entities::deployment_config::ContainerLogsConfig,
+) -> Option<temps_logs::TimestampExtractor> {
+    config.timestamp.as_ref().map(|timestamp| {
+        temps_logs::TimestampExtractor::new(
+            timestamp.field.clone(),
+            temps_logs::TimestampFormat::from_name(timestamp.format()),
+            timestamp.max_future_skew_seconds(),
+            timestamp.max_past_skew_seconds(),
+        )
+    })
+}
 
 /// Parameters for container log retrieval
 pub struct ContainerLogParams {
@@ -22,6 +83,8 @@ pub struct ContainerLogParams {
     pub end_date: Option<i64>,
     pub tail: Option<String>,
     pub timestamps: bool,
+    /// Collapse repeated lines; defaults to the environment's container log settings
+    pub coalesce: Option<bool>,
 }
 
 #[derive(Error, Debug)]
@@ -41,6 +104,9 @@ pub enum DeploymentError {
     #[error("Invalid deployment state: {0}")]
     InvalidDeploymentState(String),
 
+    #[error("Conflict: {0}")]
+    Conflict(String),
+
     #[error("Pipeline error: {0}")]
     PipelineError(String),
 
@@ -145,6 +211,10 @@ impl DeploymentService {
         })?;
 
         let container_id = container.container_id;
+        let logs_config = container_logs_config(&project, &environment);
+        let coalesce = params
+            .coalesce
+            .unwrap_or_else(|| logs_config.coalesces_repeats());
         let stream_result = self
             .docker_log_service
             .get_container_logs(
@@ -168,7 +238,10 @@ impl DeploymentService {
             item.map_err(|container_err| std::io::Error::other(container_err.to_string()))
         });
 
-        Ok(mapped_stream)
+        // App timestamps go first, so repeats are collapsed over the app's times
+        let timestamped =
+            temps_logs::apply_timestamps(mapped_stream, log_timestamp_extractor(&logs_config));
+        Ok(temps_logs::coalesce_lines(timestamped, coalesce))
     }
 
     /// Get logs for a specific container by container ID
@@ -223,6 +296,10 @@ impl DeploymentService {
             })?;
 
         // Get logs from the Docker log service
+        let logs_config = container_logs_config(&project, &environment);
+        let coalesce = params
+            .coalesce
+            .unwrap_or_else(|| logs_config.coalesces_repeats());
         let stream_result = self
             .docker_log_service
             .get_container_logs(
@@ -246,7 +323,9 @@ impl DeploymentService {
             item.map_err(|container_err| std::io::Error::other(container_err.to_string()))
         });
 
-        Ok(mapped_stream)
+        let timestamped =
+            temps_logs::apply_timestamps(mapped_stream, log_timestamp_extractor(&logs_config));
+        Ok(temps_logs::coalesce_lines(timestamped, coalesce))
     }
 
     /// List all containers for a specific environment
@@ -388,6 +467,53 @@ impl DeploymentService {
             });
         }
 
+        Ok(DeploymentListResponse {
+            deployments: self.with_info(project_id, results).await?,
+            total: total as i64,
+            page: page as i64,
+            per_page: per_page as i64,
+        })
+    }
+
+    /// A page of a project's deployments
+    pub async fn list_project_deployments(
+        &self,
+        project_id: i32,
+        request: &ListRequest,
+    ) -> Result<CursorPage<Deployment>, DeploymentError> {
+        let invalid =
+            |e: temps_core::pagination::ListError| DeploymentError::InvalidInput(e.to_string());
+        let project_deployments =
+            deployments::Entity::find().filter(deployments::Column::ProjectId.eq(project_id));
+
+        let total = list_filter(project_deployments.clone(), request, &DEPLOYMENT_FIELDS)
+            .map_err(invalid)?
+            .count(self.db.as_ref())
+            .await?;
+        let rows = list_select(
+            project_deployments,
+            request,
+            &DEPLOYMENT_FIELDS,
+            deployments::Column::Id,
+        )
+        .map_err(invalid)?
+        .all(self.db.as_ref())
+        .await?;
+        let page = page_from_models(rows, request, &DEPLOYMENT_FIELDS, deployments::Column::Id);
+
+        Ok(CursorPage {
+            items: self.with_info(project_id, page.items).await?,
+            next_cursor: page.next_cursor,
+            total: Some(total),
+        })
+    }
+
+    /// Environment and current-deployment details of each deployment
+    async fn with_info(
+        &self,
+        project_id: i32,
+        results: Vec<deployments::Model>,
+    ) -> Result<Vec<Deployment>, DeploymentError> {
         // Collect all unique environment IDs
         let env_ids: Vec<i32> = results
             .iter()
@@ -420,12 +546,7 @@ impl DeploymentService {
             );
         }
 
-        Ok(DeploymentListResponse {
-            deployments: deployments_with_info,
-            total: total as i64,
-            page: page as i64,
-            per_page: per_page as i64,
-        })
+        Ok(deployments_with_info)
     }
 
     pub async fn get_last_deployment(
@@ -588,6 +709,11 @@ impl DeploymentService {
             tag: tag.clone(),
             commit: commit.clone().unwrap_or_default(),
             project_id,
+            environment_id: Some(environment_id),
+            manual: true,
+            release_note: None,
+            triggered_by: None,
+            dry_run: false,
         };
 
         tracing::debug!(
@@ -688,7 +814,10 @@ impl DeploymentService {
 
             // Step 1: Execute DeployImageJob with external image
             // CRITICAL: Use "deploy_container" as job_id so MarkDeploymentCompleteJob can find the outputs
-            let deploy_job = crate::jobs::DeployImageJobBuilder::new()
+            let effective_config = environment.get_effective_deployment_config(
+                &project.deployment_config.clone().unwrap_or_default(),
+            );
+            let mut deploy_job = crate::jobs::DeployImageJobBuilder::new()
                 .job_id("deploy_container".to_string())
                 .build_job_id("external-image".to_string()) // Placeholder, will use external image instead
                 .target(crate::jobs::DeploymentTarget::Docker {
@@ -726,7 +855,40 @@ impl DeploymentService {
                 .log_service(self.log_service.clone())
                 .build(self.deployer.clone())
                 .map_err(|e| DeploymentError::Other(format!("Failed to create deploy job: {}", e)))?
-                .with_external_image_tag(image_name.clone()); // Use existing image without rebuild
+                .with_external_image_tag(image_name.clone()) // Use existing image without rebuild
+                // The process types of the image's Procfile, scaled as configured now
+                .with_procfile(
+                    target_deployment
+                        .metadata
+                        .as_ref()
+                        .map(|metadata| metadata.process_types.clone())
+                        .unwrap_or_default(),
+                )
+                .with_processes(effective_config.processes.unwrap_or_default());
+            if let Some(start_command) = effective_config.start_command {
+                deploy_job = deploy_job.with_start_command(start_command);
+            }
+            if let Some(entrypoint) = effective_config.entrypoint {
+                deploy_job = deploy_job.with_entrypoint(entrypoint);
+            }
+            if let Some(args) = effective_config.args {
+                deploy_job = deploy_job.with_args(args);
+            }
+            if let Some(startup_probe) = effective_config.startup_probe {
+                deploy_job = deploy_job.with_startup_probe(startup_probe);
+            }
+            if let Some(exec_health_check) = effective_config.exec_health_check {
+                deploy_job = deploy_job.with_exec_health_check(exec_health_check);
+            }
+            // The environment's current runtime config, not the one of the target deployment
+            let runtime_config_dir = super::write_runtime_config_file(
+                self.db.as_ref(),
+                &self.config_service.data_dir(),
+                environment_id,
+            )
+            .await?;
+            deploy_job =
+                deploy_job.with_bind_mount(super::runtime_config_mount(runtime_config_dir));
 
             // Create workflow context for execution with a mock log writer
             let mock_log_writer = Arc::new(crate::test_utils::MockLogWriter::new(0));
@@ -1367,6 +1529,35 @@ impl DeploymentService {
         Ok(jobs)
     }
 
+    /// Where a deployment's build spent its time, next to the recent builds of its
+    /// environment
+    pub async fn get_build_breakdown(
+        &self,
+        project_id: i32,
+        deployment_id: i32,
+    ) -> Result<super::BuildBreakdown, DeploymentError> {
+        let deployment = deployments::Entity::find()
+            .filter(deployments::Column::ProjectId.eq(project_id))
+            .filter(deployments::Column::Id.eq(deployment_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| {
+                DeploymentError::NotFound(format!(
+                    "deployment {} for project {} not found",
+                    deployment_id, project_id
+                ))
+            })?;
+        let jobs = self.get_deployment_jobs(deployment_id).await?;
+        let sample = super::BuildSample::new(&deployment, &jobs);
+
+        let recent =
+            super::recent_builds(self.db.as_ref(), deployment.environment_id, deployment.id)
+                .await?;
+        let history = super::load_build_samples(self.db.as_ref(), &recent).await?;
+
+        Ok(super::BuildBreakdown::new(deployment.id, &sample, &history))
+    }
+
     /// Cancel all running deployments with a given reason
     /// This is typically called during server shutdown or startup
     pub async fn cancel_running_deployments(
@@ -1635,6 +1826,48 @@ impl DeploymentService {
         Ok(())
     }
 
+    /// Stop waiting for the previous deployment's connections to drain
+    ///
+    /// The drain step picks the request up at its next check and stops the previous
+    /// deployment right away, closing the connections it still serves.
+    pub async fn cancel_connection_drain(
+        &self,
+        project_id: i32,
+        deployment_id: i32,
+    ) -> Result<deployments::ConnectionDrainReport, DeploymentError> {
+        use temps_entities::deployments::ConnectionDrainState;
+
+        let deployment = deployments::Entity::find_by_id(deployment_id)
+            .filter(deployments::Column::ProjectId.eq(project_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DeploymentError::NotFound("Deployment not found".to_string()))?;
+
+        let mut metadata = deployment.metadata.clone().unwrap_or_default();
+        let report = metadata
+            .connection_drain
+            .as_mut()
+            .filter(|report| report.state == ConnectionDrainState::Draining)
+            .ok_or_else(|| {
+                DeploymentError::InvalidDeploymentState(format!(
+                    "Deployment {} isn't draining connections",
+                    deployment_id
+                ))
+            })?;
+        report.cancel_requested = true;
+        let report = report.clone();
+
+        let mut active_deployment: deployments::ActiveModel = deployment.into();
+        active_deployment.metadata = Set(Some(metadata));
+        active_deployment.update(self.db.as_ref()).await?;
+
+        info!(
+            "Connection drain before deployment {} cancelled by user",
+            deployment_id
+        );
+        Ok(report)
+    }
+
     /// Get detailed information about a specific container
     pub async fn get_container_detail(
         &self,
@@ -1677,6 +1910,20 @@ impl DeploymentService {
         Ok((container, env_info))
     }
 
+    /// Whether an environment's variables are protected secrets; environments that
+    /// can't be found count as protected
+    pub async fn environment_has_protected_secrets(
+        &self,
+        environment_id: i32,
+    ) -> Result<bool, DeploymentError> {
+        use temps_entities::environments;
+
+        let environment = environments::Entity::find_by_id(environment_id)
+            .one(self.db.as_ref())
+            .await?;
+        Ok(environment.is_none_or(|environment| environment.has_protected_secrets()))
+    }
+
     /// Stop a specific container
     pub async fn stop_container(
         &self,
@@ -1784,6 +2031,26 @@ impl DeploymentService {
         Ok(env_vars)
     }
 
+    /// Get the `sh.temps.*` deploy metadata labels of a container
+    pub async fn get_container_labels(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        container_id: String,
+    ) -> Result<HashMap<String, String>, DeploymentError> {
+        let (container, _) = self
+            .get_container_detail(project_id, environment_id, container_id)
+            .await?;
+
+        let container_info = self
+            .deployer
+            .get_container_info(&container.container_id)
+            .await
+            .map_err(|e| DeploymentError::Other(format!("Failed to get container info: {}", e)))?;
+
+        Ok(temps_deployer::labels::temps_labels(&container_info.labels))
+    }
+
     /// Stop all containers in an environment
     pub async fn stop_all_containers(
         &self,
@@ -2285,6 +2552,7 @@ mod tests {
                 created_at: chrono::Utc::now(),
                 ports: vec![],
                 environment_vars: HashMap::new(),
+                labels: HashMap::new(),
                 status: temps_deployer::ContainerStatus::Running,
             })
         });
@@ -2878,6 +3146,7 @@ mod tests {
                     end_date: None,
                     tail: None,
                     timestamps: false,
+                    coalesce: None,
                 },
             )
             .await;
@@ -0,0 +1,97 @@
+//! Deployment Success Kinds
+//!
+//! Tells a successful deployment apart by what came before it in its environment:
+//! the environment's first success, the first one after a failure, or a routine one.
+//! The kind travels with the DeploymentSucceeded event, so that webhooks can send a
+//! project's first production deployment to a louder channel than everyday ones.
+
+use sea_orm::{ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, QueryOrder, QuerySelect};
+use temps_core::DeploymentSuccessKind;
+use temps_entities::deployments;
+
+use super::{DeployOutcome, DeploymentError};
+
+/// States of a deployment that went live; stopped and paused ones did before
+const SUCCEEDED_STATES: &[&str] = &["completed", "deployed", "stopped", "paused"];
+
+/// Earlier deployments looked at, enough to see past a few dry runs
+const HISTORY_SIZE: u64 = 20;
+
+/// Kind of a success given the outcome of the environment's latest earlier
+/// deployment that finished, and whether any earlier one succeeded
+///
+/// Cancelled deployments neither broke nor fixed anything, so they are left out of
+/// `last_outcome`.
+pub fn success_kind(
+    last_outcome: Option<DeployOutcome>,
+    succeeded_before: bool,
+) -> DeploymentSuccessKind {
+    if !succeeded_before {
+        DeploymentSuccessKind::First
+    } else if last_outcome == Some(DeployOutcome::Failed) {
+        DeploymentSuccessKind::Recovery
+    } else {
+        DeploymentSuccessKind::Routine
+    }
+}
+
+/// Kind of a deployment's success, from its environment's earlier deployments
+///
+/// Dry runs never serve traffic and don't count.
+pub async fn classify_success(
+    db: &DatabaseConnection,
+    environment_id: i32,
+    deployment_id: i32,
+) -> Result<DeploymentSuccessKind, DeploymentError> {
+    let earlier = |states: Vec<&'static str>| {
+        deployments::Entity::find()
+            .filter(deployments::Column::EnvironmentId.eq(environment_id))
+            .filter(deployments::Column::Id.lt(deployment_id))
+            .filter(deployments::Column::State.is_in(states))
+            .order_by_desc(deployments::Column::Id)
+            .limit(HISTORY_SIZE)
+    };
+
+    let mut finished_states = SUCCEEDED_STATES.to_vec();
+    finished_states.push("failed");
+    let last_outcome = earlier(finished_states)
+        .all(db)
+        .await?
+        .into_iter()
+        .find(|deployment| !deployment.is_dry_run())
+        .map(|deployment| DeployOutcome::from_state(&deployment.state));
+
+    let succeeded_before = match last_outcome {
+        Some(DeployOutcome::Succeeded) => true,
+        _ => earlier(SUCCEEDED_STATES.to_vec())
+            .all(db)
+            .await?
+            .iter()
+            .any(|deployment| !deployment.is_dry_run()),
+    };
+
+    Ok(success_kind(last_outcome, succeeded_before))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_success_kind() {
+        assert_eq!(success_kind(None, false), DeploymentSuccessKind::First);
+        // Failures before the environment ever went live don't make a recovery
+        assert_eq!(
+            success_kind(Some(DeployOutcome::Failed), false),
+            DeploymentSuccessKind::First
+        );
+        assert_eq!(
+            success_kind(Some(DeployOutcome::Failed), true),
+            DeploymentSuccessKind::Recovery
+        );
+        assert_eq!(
+            success_kind(Some(DeployOutcome::Succeeded), true),
+            DeploymentSuccessKind::Routine
+        );
+    }
+}
@@ -5,23 +5,34 @@
 use sea_orm::{ColumnTrait, EntityTrait, QueryFilter, QueryOrder};
 use std::collections::HashMap;
 use std::sync::Arc;
+use std::time::{Duration, Instant};
 use temps_core::{
-    Job, JobQueue, WorkflowBuilder, WorkflowCancellationProvider, WorkflowError, WorkflowExecutor,
+    Job, JobQueue, WorkflowBuilder, WorkflowCancellationProvider, WorkflowConfig, WorkflowError,
+    WorkflowExecutor,
 };
 use temps_database::DbConnection;
 use temps_deployer::{static_deployer::StaticDeployer, ContainerDeployer, ImageBuilder};
+use temps_entities::deployment_config::{BuilderConfig, BuilderKind};
+use temps_entities::deployments::DeployAttempt;
 use temps_entities::{deployment_jobs, deployments, environments, projects};
 use temps_git::GitProviderManagerTrait;
 use temps_logs::LogService;
 use tracing::{debug, error, info, warn};
 
+use crate::jobs::shared_stage::SharedStageLocks;
 use crate::jobs::{
-    BuildImageJobBuilder, ConfigureCronsJobBuilder, CronConfigService, DeployImageJobBuilder,
-    DeployStaticJob, DeploymentTarget, DownloadRepoBuilder,
+    BuildCapacityCheck, BuildImageJobBuilder, ConfigureCronsJobBuilder, CronConfigService,
+    DeployImageJobBuilder, DeployStaticJob, DeploymentTarget, DownloadRepoBuilder,
+};
+use crate::services::{
+    effective_build_priority, is_cancellation, BuildAlertService, BuildNodeScheduler, BuildQueue,
+    BuildSlot, DeploymentArtifactService, DeploymentJobTracker, FailureClass, FailureClassifier,
 };
-use crate::services::DeploymentJobTracker;
 use temps_screenshots::ScreenshotService;
 
+/// Seconds between checks for a cancel request while waiting to retry a deployment
+const RETRY_CANCEL_CHECK_INTERVAL: Duration = Duration::from_secs(5);
+
 /// Service for executing deployment workflows
 pub struct WorkflowExecutionService {
     db: Arc<DbConnection>,
@@ -34,6 +45,15 @@ pub struct WorkflowExecutionService {
     cron_service: Arc<dyn CronConfigService>,
     config_service: Arc<temps_config::ConfigService>,
     screenshot_service: Arc<ScreenshotService>,
+    build_capacity_check: Option<Arc<dyn BuildCapacityCheck>>,
+    build_queue: Option<Arc<BuildQueue>>,
+    build_alerts: Option<Arc<BuildAlertService>>,
+    artifact_service: Option<Arc<DeploymentArtifactService>>,
+    connections: Option<Arc<temps_routes::ConnectionTracker>>,
+    encryption_service: Option<Arc<temps_core::EncryptionService>>,
+    build_node_scheduler: Option<Arc<BuildNodeScheduler>>,
+    /// Shared build stages being built, so services deployed together build them once
+    shared_stage_locks: Arc<SharedStageLocks>,
 }
 
 impl WorkflowExecutionService {
@@ -61,50 +81,293 @@ impl WorkflowExecutionService {
             cron_service,
             config_service,
             screenshot_service,
+            build_capacity_check: None,
+            build_queue: None,
+            build_alerts: None,
+            artifact_service: None,
+            connections: None,
+            encryption_service: None,
+            build_node_scheduler: None,
+            shared_stage_locks: Arc::new(SharedStageLocks::new()),
         }
     }
 
-    /// Get the container deployer (for cancelling deployments)
-    pub fn container_deployer(&self) -> Arc<dyn ContainerDeployer> {
-        self.container_deployer.clone()
+    /// Refuse to start image builds when the check fails (e.g. disk space is critical)
+    pub fn with_build_capacity_check(
+        mut self,
+        build_capacity_check: Arc<dyn BuildCapacityCheck>,
+    ) -> Self {
+        self.build_capacity_check = Some(build_capacity_check);
+        self
     }
 
-    /// Execute the workflow for a deployment using its job records
-    pub async fn execute_deployment_workflow(
+    /// Wait for a free slot in the global build queue before running each workflow
+    pub fn with_build_queue(mut self, build_queue: Arc<BuildQueue>) -> Self {
+        self.build_queue = Some(build_queue);
+        self
+    }
+
+    /// Alert when a deployment waits long for a build slot or builds slower than usual
+    pub fn with_build_alerts(mut self, build_alerts: Arc<BuildAlertService>) -> Self {
+        self.build_alerts = Some(build_alerts);
+        self
+    }
+
+    /// Retain build artifacts of static deploys for environments that keep them
+    pub fn with_artifact_service(
+        mut self,
+        artifact_service: Arc<DeploymentArtifactService>,
+    ) -> Self {
+        self.artifact_service = Some(artifact_service);
+        self
+    }
+
+    /// Open connection counts of the proxy, shown while a deployment drains
+    pub fn with_connection_tracker(
+        mut self,
+        connections: Arc<temps_routes::ConnectionTracker>,
+    ) -> Self {
+        self.connections = Some(connections);
+        self
+    }
+
+    /// Decrypts the credentials artifacts are downloaded with and the secrets of
+    /// deploy gates and CDN purges
+    pub fn with_encryption_service(
+        mut self,
+        encryption_service: Arc<temps_core::EncryptionService>,
+    ) -> Self {
+        self.encryption_service = Some(encryption_service);
+        self
+    }
+
+    /// Run image builds on the configured build nodes instead of the local daemon
+    pub fn with_build_node_scheduler(
+        mut self,
+        build_node_scheduler: Arc<BuildNodeScheduler>,
+    ) -> Self {
+        self.build_node_scheduler = Some(build_node_scheduler);
+        self
+    }
+
+    async fn image_update_settings(&self) -> temps_core::ImageUpdateSettings {
+        match self.config_service.get_settings().await {
+            Ok(settings) => settings.image_updates,
+            Err(e) => {
+                warn!(
+                    "Failed to load image update settings, using defaults: {}",
+                    e
+                );
+                temps_core::ImageUpdateSettings::default()
+            }
+        }
+    }
+
+    async fn failure_classifier(&self) -> FailureClassifier {
+        match self.config_service.get_settings().await {
+            Ok(settings) => FailureClassifier::new(&settings.deploy_retry),
+            Err(e) => {
+                warn!(
+                    "Failed to load deploy retry settings, using defaults: {}",
+                    e
+                );
+                FailureClassifier::default()
+            }
+        }
+    }
+
+    /// Save a failed attempt of a deployment on its metadata
+    async fn record_deploy_attempt(&self, deployment_id: i32, attempt: DeployAttempt) {
+        use sea_orm::{ActiveModelTrait, Set};
+
+        let deployment = match self.get_deployment(deployment_id).await {
+            Ok(deployment) => deployment,
+            Err(e) => {
+                warn!(
+                    "Failed to record attempt {} of deployment {}: {}",
+                    attempt.attempt, deployment_id, e
+                );
+                return;
+            }
+        };
+        let mut metadata = deployment.metadata.clone().unwrap_or_default();
+        metadata.deploy_attempts.push(attempt);
+
+        let mut active_deployment: deployments::ActiveModel = deployment.into();
+        active_deployment.metadata = Set(Some(metadata));
+        if let Err(e) = active_deployment.update(self.db.as_ref()).await {
+            warn!(
+                "Failed to record an attempt of deployment {}: {}",
+                deployment_id, e
+            );
+        }
+    }
+
+    /// Note an upcoming retry in the log of the job that failed
+    async fn log_retry(&self, deployment_id: i32, message: String) {
+        let failed_job = deployment_jobs::Entity::find()
+            .filter(deployment_jobs::Column::DeploymentId.eq(deployment_id))
+            .filter(deployment_jobs::Column::Status.eq(temps_entities::types::JobStatus::Failure))
+            .one(self.db.as_ref())
+            .await;
+        if let Ok(Some(job)) = failed_job {
+            if let Err(e) = self
+                .log_service
+                .append_structured_log(&job.log_id, temps_logs::LogLevel::Warning, message)
+                .await
+            {
+                warn!("Failed to write retry note to job {}: {}", job.job_id, e);
+            }
+        }
+    }
+
+    /// Wait out the backoff before a retry; false when the deployment was cancelled
+    /// meanwhile
+    async fn wait_before_retry(&self, deployment_id: i32, backoff: Duration) -> bool {
+        let started = Instant::now();
+        loop {
+            let deployment = deployments::Entity::find_by_id(deployment_id)
+                .one(self.db.as_ref())
+                .await;
+            if let Ok(Some(deployment)) = deployment {
+                if deployment.state == "cancelled" {
+                    info!(
+                        "Deployment {} was cancelled before its retry",
+                        deployment_id
+                    );
+                    return false;
+                }
+            }
+
+            let remaining = backoff.saturating_sub(started.elapsed());
+            if remaining.is_zero() {
+                return true;
+            }
+            tokio::time::sleep(remaining.min(RETRY_CANCEL_CHECK_INTERVAL)).await;
+        }
+    }
+
+    /// Put every job of a deployment back to pending for another run of the workflow
+    async fn reset_jobs_for_retry(&self, deployment_id: i32) -> Result<(), WorkflowExecutionError> {
+        use sea_orm::{ConnectionTrait, Statement};
+
+        let sql = r#"
+            UPDATE deployment_jobs
+            SET status = $1, error_message = NULL, outputs = NULL,
+                started_at = NULL, finished_at = NULL, updated_at = $2
+            WHERE deployment_id = $3
+        "#;
+        self.db
+            .as_ref()
+            .execute(Statement::from_sql_and_values(
+                sea_orm::DatabaseBackend::Postgres,
+                sql,
+                vec![
+                    temps_entities::types::JobStatus::Pending.into(),
+                    chrono::Utc::now().into(),
+                    deployment_id.into(),
+                ],
+            ))
+            .await?;
+        Ok(())
+    }
+
+    /// Take a build queue slot, alerting once the wait passes the configured threshold
+    async fn acquire_build_slot(
         &self,
-        deployment_id: i32,
-    ) -> Result<(), WorkflowExecutionError> {
-        info!(
-            "Starting workflow execution for deployment {}",
-            deployment_id
+        build_queue: &BuildQueue,
+        deployment: &deployments::Model,
+        environment: &environments::Model,
+    ) -> BuildSlot {
+        let wait_started = Instant::now();
+        let acquire = build_queue.acquire(
+            deployment.id,
+            effective_build_priority(environment, deployment),
         );
+        tokio::pin!(acquire);
 
-        // Load deployment, project, and environment
-        let deployment = self.get_deployment(deployment_id).await?;
-        let project = self.get_project(deployment.project_id).await?;
-        let environment = self.get_environment(deployment.environment_id).await?;
+        let alert_after = match &self.build_alerts {
+            Some(_) => build_queue.queue_wait_alert().await,
+            None => None,
+        };
+        let slot = match (&self.build_alerts, alert_after) {
+            (Some(build_alerts), Some(alert_after)) => {
+                tokio::select! {
+                    slot = &mut acquire => slot,
+                    _ = tokio::time::sleep(alert_after) => {
+                        if let Some(position) = build_queue.queue_position(deployment.id) {
+                            build_alerts
+                                .queue_delayed(
+                                    deployment,
+                                    &environment.name,
+                                    position,
+                                    wait_started.elapsed(),
+                                )
+                                .await;
+                        }
+                        acquire.await
+                    }
+                }
+            }
+            _ => acquire.await,
+        };
 
-        // Load all jobs for this deployment
-        let db_jobs = self.get_deployment_jobs(deployment_id).await?;
+        // started_at was set when the deployment was queued; move it to when the build
+        // got its slot so build durations (and typical build times) exclude the wait
+        if wait_started.elapsed() >= Duration::from_secs(1) {
+            self.mark_build_started(deployment.id).await;
+        }
+        slot
+    }
 
-        if db_jobs.is_empty() {
-            return Err(WorkflowExecutionError::NoJobsFound(deployment_id));
+    async fn mark_build_started(&self, deployment_id: i32) {
+        use sea_orm::sea_query::Expr;
+
+        if let Err(e) = deployments::Entity::update_many()
+            .filter(deployments::Column::Id.eq(deployment_id))
+            .col_expr(
+                deployments::Column::StartedAt,
+                Expr::current_timestamp().into(),
+            )
+            .exec(self.db.as_ref())
+            .await
+        {
+            warn!(
+                "Failed to update start time of deployment {}: {}",
+                deployment_id, e
+            );
         }
+    }
 
-        debug!(
-            "Found {} jobs for deployment {}",
-            db_jobs.len(),
-            deployment_id
-        );
+    /// Get the container deployer (for cancelling deployments)
+    pub fn container_deployer(&self) -> Arc<dyn ContainerDeployer> {
+        self.container_deployer.clone()
+    }
+
+    fn encryption_service(&self) -> Result<&temps_core::EncryptionService, WorkflowExecutionError> {
+        self.encryption_service.as_deref().ok_or_else(|| {
+            WorkflowExecutionError::JobCreationFailed(
+                "No encryption service to decrypt secrets with".to_string(),
+            )
+        })
+    }
 
+    /// Build the workflow of a deployment from its job records
+    async fn build_workflow(
+        &self,
+        project: &projects::Model,
+        environment: &environments::Model,
+        deployment: &deployments::Model,
+        db_jobs: &[deployment_jobs::Model],
+    ) -> Result<WorkflowConfig, WorkflowExecutionError> {
         // Create a no-op log writer since jobs handle their own logging
         let noop_log_writer = Arc::new(NoOpLogWriter);
 
         // Build workflow from jobs
         let mut workflow_builder = WorkflowBuilder::new()
-            .with_workflow_run_id(format!("deployment-{}", deployment_id))
+            .with_workflow_run_id(format!("deployment-{}", deployment.id))
             .with_deployment_context(
-                deployment_id,
+                deployment.id,
                 deployment.project_id,
                 deployment.environment_id,
             )
@@ -118,7 +381,7 @@ impl WorkflowExecutionService {
 
         // Convert database job records to actual job instances
         // Create log paths for each job
-        for db_job in &db_jobs {
+        for db_job in db_jobs {
             // Create log path for this job
             self.log_service
                 .create_log_path(&db_job.log_id)
@@ -136,7 +399,7 @@ impl WorkflowExecutionService {
             );
 
             let job = self
-                .create_job_from_record(&project, &environment, &deployment, db_job)
+                .create_job_from_record(project, environment, deployment, db_job)
                 .await?;
 
             // Parse dependencies from database record
@@ -159,9 +422,63 @@ impl WorkflowExecutionService {
 
         info!("Built workflow with {} jobs", workflow.jobs.len());
 
+        Ok(workflow)
+    }
+
+    /// Execute the workflow for a deployment using its job records
+    pub async fn execute_deployment_workflow(
+        &self,
+        deployment_id: i32,
+    ) -> Result<(), WorkflowExecutionError> {
+        info!(
+            "Starting workflow execution for deployment {}",
+            deployment_id
+        );
+
+        // Load deployment, project, and environment
+        let deployment = self.get_deployment(deployment_id).await?;
+        let project = self.get_project(deployment.project_id).await?;
+        let environment = self.get_environment(deployment.environment_id).await?;
+
+        // Load all jobs for this deployment
+        let db_jobs = self.get_deployment_jobs(deployment_id).await?;
+
+        if db_jobs.is_empty() {
+            return Err(WorkflowExecutionError::NoJobsFound(deployment_id));
+        }
+
+        debug!(
+            "Found {} jobs for deployment {}",
+            db_jobs.len(),
+            deployment_id
+        );
+
+        let mut workflow = self
+            .build_workflow(&project, &environment, &deployment, &db_jobs)
+            .await?;
+
         // Create job tracker for updating deployment_jobs table
         let job_tracker = Arc::new(DeploymentJobTracker::new(self.db.clone(), deployment_id));
 
+        // Hold a build queue slot until the workflow (and teardown) finishes
+        let mut build_slot = match &self.build_queue {
+            Some(build_queue) => Some(
+                self.acquire_build_slot(build_queue, &deployment, &environment)
+                    .await,
+            ),
+            None => None,
+        };
+
+        // Report the build if it runs well past its usual duration
+        let _slow_build_watch = match (&self.build_alerts, &self.build_queue) {
+            (Some(build_alerts), Some(build_queue)) => {
+                build_queue.slow_build_alert_percent().await.map(|percent| {
+                    build_alerts.watch_build(deployment.clone(), environment.name.clone(), percent)
+                })
+            }
+            _ => None,
+        };
+
         // Execute workflow
         let executor = WorkflowExecutor::new(Some(job_tracker));
 
@@ -171,10 +488,122 @@ impl WorkflowExecutionService {
             deployment_id,
         ));
 
-        match executor
-            .execute_workflow(workflow, cancellation_provider)
-            .await
-        {
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let effective_config = environment.get_effective_deployment_config(&project_config);
+
+        // The deploy timeout spans all attempts. Running past it aborts the running
+        // jobs, whose cleanup removes the new containers; the previous deployment is
+        // only torn down at the cutover, so it keeps serving.
+        let deploy_timeout = effective_config.deploy_timeout();
+        let deploy_deadline = Instant::now() + deploy_timeout;
+        workflow.timeout = Some(deploy_timeout);
+
+        // Failures classified as transient run the workflow again when the service
+        // retries deploys
+        let retry_policy = effective_config.auto_retry;
+        let classifier = match &retry_policy {
+            Some(_) => Some(self.failure_classifier().await),
+            None => None,
+        };
+
+        let mut attempt = 1;
+        let result = loop {
+            let started_at = chrono::Utc::now();
+            let error = match executor
+                .execute_workflow(workflow, cancellation_provider.clone())
+                .await
+            {
+                Ok(context) => break Ok(context),
+                // Report the deploy's timeout rather than what was left of it
+                Err(WorkflowError::WorkflowTimedOut { phase, .. }) => {
+                    break Err(WorkflowError::WorkflowTimedOut {
+                        phase,
+                        timeout_seconds: deploy_timeout.as_secs(),
+                    });
+                }
+                Err(e) => e,
+            };
+
+            let error_message = error.to_string();
+            let (Some(policy), Some(classifier)) = (&retry_policy, &classifier) else {
+                break Err(error);
+            };
+            if is_cancellation(&error_message) {
+                break Err(error);
+            }
+
+            let transient = classifier.classify(&error_message) == FailureClass::Transient;
+            let retry = transient && attempt <= policy.max_retries();
+            let backoff = Duration::from_secs(policy.backoff_before_retry(attempt) as u64);
+            let failed_at = chrono::Utc::now();
+            self.record_deploy_attempt(
+                deployment_id,
+                DeployAttempt {
+                    attempt,
+                    error: error_message.clone(),
+                    transient,
+                    started_at,
+                    failed_at,
+                    retry_at: retry
+                        .then(|| failed_at + chrono::Duration::seconds(backoff.as_secs() as i64)),
+                },
+            )
+            .await;
+            if !retry {
+                if transient {
+                    info!(
+                        "Deployment {} failed with a transient error after {} attempt(s), \
+                         not retrying again",
+                        deployment_id, attempt
+                    );
+                }
+                break Err(error);
+            }
+
+            warn!(
+                "Attempt {} of deployment {} failed with a transient error, retrying in {}s: {}",
+                attempt,
+                deployment_id,
+                backoff.as_secs(),
+                error_message
+            );
+            self.log_retry(
+                deployment_id,
+                format!(
+                    "⚠️  Attempt {} failed with a transient error; retrying in {}s (retry {} of {})",
+                    attempt,
+                    backoff.as_secs(),
+                    attempt,
+                    policy.max_retries()
+                ),
+            )
+            .await;
+
+            // Free the build slot for other deployments while waiting
+            drop(build_slot.take());
+            if !self.wait_before_retry(deployment_id, backoff).await {
+                break Err(WorkflowError::WorkflowCancelled);
+            }
+
+            self.reset_jobs_for_retry(deployment_id).await?;
+            workflow = self
+                .build_workflow(&project, &environment, &deployment, &db_jobs)
+                .await?;
+            workflow.timeout = Some(deploy_deadline.saturating_duration_since(Instant::now()));
+            if let Some(build_queue) = &self.build_queue {
+                build_slot = Some(
+                    self.acquire_build_slot(build_queue, &deployment, &environment)
+                        .await,
+                );
+            }
+            attempt += 1;
+            info!(
+                "Starting attempt {} of deployment {}",
+                attempt, deployment_id
+            );
+        };
+
+        match result {
             Ok(_context) => {
                 info!(
                     "Workflow execution completed successfully for deployment {}",
@@ -185,6 +614,15 @@ impl WorkflowExecutionService {
                 // is now handled by the MarkDeploymentCompleteJob that runs as part of the workflow.
                 // We don't perform any additional updates here to avoid duplicate database writes.
 
+                // A dry run never took over, the current deployment keeps serving
+                if deployment.is_dry_run() {
+                    info!(
+                        "Dry run {} passed, the current deployment keeps serving",
+                        deployment_id
+                    );
+                    return Ok(());
+                }
+
                 // NOW teardown previous deployment for zero-downtime deployment
                 // This happens AFTER the new deployment is fully running
                 info!("Checking for previous deployments to teardown after successful deployment");
@@ -216,10 +654,8 @@ impl WorkflowExecutionService {
             Err(e) => {
                 // Check if this is a cancellation error
                 let error_message = format!("{}", e);
-                let is_cancellation =
-                    error_message.contains("cancelled") || error_message.contains("Cancelled");
 
-                if is_cancellation {
+                if is_cancellation(&error_message) {
                     info!(
                         "Workflow execution cancelled for deployment {}: {}",
                         deployment_id, e
@@ -242,6 +678,15 @@ impl WorkflowExecutionService {
                         "Deployment {} cancellation completed - workflow stopped gracefully",
                         deployment_id
                     );
+                } else if matches!(e, WorkflowError::WorkflowTimedOut { .. })
+                    && self.get_deployment(deployment_id).await?.state == "completed"
+                {
+                    // Traffic already moved to this deployment and the previous one was
+                    // torn down; only a step after the cutover ran past the timeout
+                    warn!(
+                        "Deployment {} is live, but a later step timed out: {}",
+                        deployment_id, e
+                    );
                 } else {
                     error!(
                         "Workflow execution failed for deployment {}: {}",
@@ -420,6 +865,64 @@ impl WorkflowExecutionService {
                 Ok(Arc::new(job))
             }
 
+            "FetchArtifactJob" => {
+                let config = db_job.job_config.as_ref().ok_or_else(|| {
+                    WorkflowExecutionError::MissingJobConfig(db_job.job_id.clone())
+                })?;
+
+                let url = config.get("url").and_then(|v| v.as_str()).ok_or_else(|| {
+                    WorkflowExecutionError::InvalidJobConfig("url is required".to_string())
+                })?;
+                let kind = config
+                    .get("kind")
+                    .cloned()
+                    .and_then(|v| serde_json::from_value(v).ok())
+                    .unwrap_or_default();
+                let project_slug = config
+                    .get("project_slug")
+                    .and_then(|v| v.as_str())
+                    .unwrap_or_default();
+
+                let mut job = crate::jobs::FetchArtifactJob::new(
+                    db_job.job_id.clone(),
+                    url.to_string(),
+                    kind,
+                    project_slug.to_string(),
+                    self.image_builder.clone(),
+                )
+                .with_log_id(db_job.log_id.clone())
+                .with_log_service(self.log_service.clone());
+
+                if let Some(sha256) = config.get("sha256").and_then(|v| v.as_str()) {
+                    job = job.with_sha256(sha256.to_string());
+                }
+                if let Some(image_tag) = config.get("image_tag").and_then(|v| v.as_str()) {
+                    job = job.with_image_tag(image_tag.to_string());
+                }
+                // The header is stored encrypted and only decrypted for the download
+                if let Some(encrypted) = config
+                    .get("encrypted_authorization")
+                    .and_then(|v| v.as_str())
+                {
+                    let encryption_service = self.encryption_service.as_ref().ok_or_else(|| {
+                        WorkflowExecutionError::JobCreationFailed(
+                            "No encryption service to decrypt the artifact's credentials"
+                                .to_string(),
+                        )
+                    })?;
+                    let authorization =
+                        encryption_service.decrypt_string(encrypted).map_err(|e| {
+                            WorkflowExecutionError::JobCreationFailed(format!(
+                                "Failed to decrypt the artifact's credentials: {}",
+                                e
+                            ))
+                        })?;
+                    job = job.with_authorization(authorization);
+                }
+
+                Ok(Arc::new(job))
+            }
+
             "BuildImageJob" => {
                 let config = db_job.job_config.as_ref().ok_or_else(|| {
                     WorkflowExecutionError::MissingJobConfig(db_job.job_id.clone())
@@ -458,7 +961,9 @@ impl WorkflowExecutionService {
                     .image_tag(image_tag)
                     .dockerfile_path(dockerfile_path.to_string())
                     .log_id(db_job.log_id.clone())
-                    .log_service(self.log_service.clone());
+                    .log_service(self.log_service.clone())
+                    // A dry run shows what would be uploaded to the builder
+                    .list_context(deployment.is_dry_run());
 
                 // Pass preset (always available since it's required)
                 // Convert preset enum to string for builder
@@ -466,15 +971,57 @@ impl WorkflowExecutionService {
                 builder = builder.preset(preset_str);
 
                 // Add build args if present
-                if let Some(build_args_value) = config.get("build_args") {
-                    if let Some(build_args_obj) = build_args_value.as_object() {
-                        let build_args: Vec<(String, String)> = build_args_obj
+                let mut build_args: Vec<(String, String)> = config
+                    .get("build_args")
+                    .and_then(|v| v.as_object())
+                    .map(|build_args_obj| {
+                        build_args_obj
                             .iter()
                             .filter_map(|(k, v)| v.as_str().map(|s| (k.clone(), s.to_string())))
-                            .collect();
-                        builder = builder.build_args(build_args);
+                            .collect()
+                    })
+                    .unwrap_or_default();
+
+                // Build-only variables, whose values are masked in the build log. The
+                // job only names them; their values are resolved now, just before the
+                // build, so secrets aren't stored with the job
+                let masked_build_args = config
+                    .get("masked_build_args")
+                    .and_then(|v| serde_json::from_value::<Vec<String>>(v.clone()).ok())
+                    .unwrap_or_default();
+                if masked_build_args
+                    .iter()
+                    .any(|key| !build_args.iter().any(|(k, _)| k == key))
+                {
+                    let secrets = super::workflow_planner::build_only_variables(
+                        self.db.as_ref(),
+                        project,
+                        environment,
+                    )
+                    .await
+                    .map_err(|e| {
+                        WorkflowExecutionError::JobCreationFailed(format!(
+                            "Failed to resolve build-only variables: {}",
+                            e
+                        ))
+                    })?;
+                    for key in &masked_build_args {
+                        if build_args.iter().any(|(k, _)| k == key) {
+                            continue;
+                        }
+                        // A variable deleted since the deploy was planned is left out
+                        if let Some(value) = secrets.get(key) {
+                            build_args.push((key.clone(), value.clone()));
+                        }
                     }
                 }
+                if !build_args.is_empty() {
+                    builder = builder.build_args(build_args);
+                }
+                let with_secrets = !masked_build_args.is_empty();
+                if with_secrets {
+                    builder = builder.masked_build_args(masked_build_args);
+                }
 
                 // Add build context if present (for monorepo subdirectories)
                 if let Some(build_context_value) = config.get("build_context") {
@@ -485,7 +1032,77 @@ impl WorkflowExecutionService {
                     }
                 }
 
-                let job = builder.build(self.image_builder.clone())?;
+                if let Some(capacity_check) = &self.build_capacity_check {
+                    builder = builder.capacity_check(capacity_check.clone());
+                }
+
+                // Language and additional build caches
+                if let Some(build_cache) = config
+                    .get("build_cache")
+                    .and_then(|v| serde_json::from_value(v.clone()).ok())
+                {
+                    builder = builder.build_cache(build_cache);
+                }
+
+                // Dockerfile, Nixpacks or Buildpacks
+                let builder_config: Option<BuilderConfig> = config
+                    .get("builder")
+                    .and_then(|v| serde_json::from_value(v.clone()).ok());
+                let node_labels = builder_config
+                    .as_ref()
+                    .map(|builder_config| builder_config.node_labels.clone())
+                    .unwrap_or_default();
+                let buildpacks = builder_config
+                    .as_ref()
+                    .is_some_and(|builder_config| builder_config.kind == BuilderKind::Buildpacks);
+                if let Some(builder_config) = builder_config {
+                    builder = builder.builder(builder_config);
+                }
+
+                // Stage built once and reused by the images of the repository's services
+                if let Some(shared_build) = config
+                    .get("shared_build")
+                    .and_then(|v| serde_json::from_value(v.clone()).ok())
+                {
+                    builder = builder.shared_build(shared_build, self.shared_stage_locks.clone());
+                }
+
+                // Replaces the build command the preset or Nixpacks detects
+                if let Some(build_command) = config.get("build_command").and_then(|v| v.as_str()) {
+                    builder = builder.build_command(build_command.to_string());
+                }
+
+                // One platform, or several for a multi-platform image
+                if let Some(platforms) = config
+                    .get("build_platforms")
+                    .and_then(|v| serde_json::from_value::<Vec<String>>(v.clone()).ok())
+                    .filter(|platforms| !platforms.is_empty())
+                {
+                    builder = builder.target_platform(platforms.join(","));
+                }
+
+                builder =
+                    builder.pull_policy(self.image_update_settings().await.base_image_pull_policy);
+
+                // Build nodes don't run buildpacks builds; those stay on the local daemon
+                let image_builder = match &self.build_node_scheduler {
+                    Some(scheduler) if !buildpacks => {
+                        let placement =
+                            scheduler
+                                .place(&node_labels, with_secrets)
+                                .await
+                                .map_err(|e| {
+                                    WorkflowExecutionError::JobCreationFailed(e.to_string())
+                                })?;
+                        if let Some(node) = placement.node {
+                            builder = builder.build_node(node);
+                        }
+                        placement.image_builder
+                    }
+                    _ => self.image_builder.clone(),
+                };
+
+                let job = builder.build(image_builder)?;
 
                 Ok(Arc::new(job))
             }
@@ -554,7 +1171,7 @@ impl WorkflowExecutionService {
                         WorkflowExecutionError::DeploymentNotFound(db_job.deployment_id)
                     })?;
 
-                let job = DeployImageJobBuilder::new()
+                let mut job = DeployImageJobBuilder::new()
                     .job_id(db_job.job_id.clone())
                     .build_job_id(build_job_id)
                     .target(DeploymentTarget::Docker {
@@ -570,6 +1187,121 @@ impl WorkflowExecutionService {
                     .log_service(self.log_service.clone())
                     .build(self.container_deployer.clone())?;
 
+                // Promotions deploy an existing image instead of one built by this workflow
+                if let Some(external_image) = config.get("external_image").and_then(|v| v.as_str())
+                {
+                    job = job.with_external_image_tag(external_image.to_string());
+                }
+                // Promotions run the process types of the source deployment's Procfile
+                if let Some(procfile) = config.get("procfile").and_then(|v| {
+                    serde_json::from_value::<Vec<temps_entities::deployments::ProcessType>>(
+                        v.clone(),
+                    )
+                    .ok()
+                }) {
+                    job = job.with_procfile(procfile);
+                }
+                job = job
+                    .with_pull_policy(self.image_update_settings().await.deploy_image_pull_policy);
+
+                // Deploy metadata for the containers' `sh.temps.*` labels
+                job = job.with_deploy_metadata(temps_deployer::labels::DeployMetadata {
+                    commit_sha: deployment.commit_sha.clone(),
+                    branch: deployment.branch_ref.clone(),
+                    environment: Some(environment.name.clone()),
+                    triggered_by: deployment
+                        .context_vars
+                        .as_ref()
+                        .and_then(|vars| vars.get("trigger"))
+                        .and_then(|v| v.as_str())
+                        .map(|v| v.to_string()),
+                    image_digest: None,
+                });
+
+                let effective_config = environment.get_effective_deployment_config(
+                    &project.deployment_config.clone().unwrap_or_default(),
+                );
+
+                // Bounded local logs: the configured driver, or json-file with rotation
+                let container_logs = effective_config.container_logs.unwrap_or_default();
+                job = job.with_log_config(temps_deployer::ContainerLogConfig {
+                    driver: container_logs.driver().as_str().to_string(),
+                    options: container_logs.driver_options(),
+                });
+
+                // The host's tz database, for images that ship without one
+                if effective_config
+                    .locale_defaults
+                    .as_ref()
+                    .is_some_and(|locale_defaults| locale_defaults.mount_tzdata())
+                {
+                    job = job.with_bind_mount(temps_deployer::BindMount {
+                        host_path: temps_entities::deployment_config::TZDATA_PATH.into(),
+                        container_path: temps_entities::deployment_config::TZDATA_PATH.into(),
+                        read_only: true,
+                    });
+                }
+
+                // Runtime config values, changeable without a redeploy
+                let runtime_config_dir = super::write_runtime_config_file(
+                    self.db.as_ref(),
+                    &self.config_service.data_dir(),
+                    environment.id,
+                )
+                .await
+                .map_err(|e| WorkflowExecutionError::JobCreationFailed(e.to_string()))?;
+                job = job.with_bind_mount(super::runtime_config_mount(runtime_config_dir));
+
+                job = job.with_stop_signal(
+                    effective_config.stop_signal.clone(),
+                    effective_config.stop_timeout_seconds,
+                );
+                job = job.with_processes(effective_config.processes.clone().unwrap_or_default());
+                if let Some(start_command) = effective_config.start_command.clone() {
+                    job = job.with_start_command(start_command);
+                }
+                if let Some(entrypoint) = effective_config.entrypoint.clone() {
+                    job = job.with_entrypoint(entrypoint);
+                }
+                if let Some(args) = effective_config.args.clone() {
+                    job = job.with_args(args);
+                }
+                if let Some(startup_probe) = effective_config.startup_probe.clone() {
+                    job = job.with_startup_probe(startup_probe);
+                }
+                if let Some(exec_health_check) = effective_config.exec_health_check.clone() {
+                    job = job.with_exec_health_check(exec_health_check);
+                }
+
+                if let Some(container_dns) = effective_config.container_dns {
+                    job = job.with_dns(temps_deployer::ContainerDns {
+                        nameservers: container_dns.nameservers,
+                        search_domains: container_dns.search_domains,
+                        extra_hosts: container_dns
+                            .extra_hosts
+                            .iter()
+                            .map(|host| host.to_docker())
+                            .collect(),
+                    });
+                }
+
+                if let Some(container_limits) = effective_config.container_limits {
+                    job = job.with_container_limits(
+                        container_limits
+                            .ulimits
+                            .iter()
+                            .map(|ulimit| temps_deployer::Ulimit {
+                                name: ulimit.name.clone(),
+                                soft: ulimit.soft,
+                                hard: ulimit.hard(),
+                            })
+                            .collect(),
+                        container_limits
+                            .shm_size_mb
+                            .map(|mb| u64::from(mb) * 1024 * 1024),
+                    );
+                }
+
                 Ok(Arc::new(job))
             }
 
@@ -627,6 +1359,12 @@ impl WorkflowExecutionService {
                         )
                     })? as i32;
 
+                // Jobs planned before the drain step existed tear down themselves
+                let teardown_previous = config
+                    .get("teardown_previous")
+                    .and_then(|v| v.as_bool())
+                    .unwrap_or(true);
+
                 let job = crate::jobs::MarkDeploymentCompleteJobBuilder::new()
                     .job_id(db_job.job_id.clone())
                     .deployment_id(deployment_id)
@@ -635,11 +1373,84 @@ impl WorkflowExecutionService {
                     .log_service(self.log_service.clone())
                     .container_deployer(self.container_deployer.clone())
                     .queue(self.queue.clone())
+                    .teardown_previous(teardown_previous)
                     .build()?;
 
                 Ok(Arc::new(job))
             }
 
+            "FinishDryRunJob" => {
+                let config = db_job.job_config.as_ref().ok_or_else(|| {
+                    WorkflowExecutionError::MissingJobConfig(db_job.job_id.clone())
+                })?;
+
+                let deployment_id = config
+                    .get("deployment_id")
+                    .and_then(|v| v.as_i64())
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(
+                            "deployment_id is required".to_string(),
+                        )
+                    })? as i32;
+
+                // Static dry runs only build, there are no containers to remove
+                let deploy_job_id = config
+                    .get("deploy_job_id")
+                    .and_then(|v| v.as_str())
+                    .map(|v| v.to_string());
+
+                let job = crate::jobs::FinishDryRunJob::new(
+                    db_job.job_id.clone(),
+                    deployment_id,
+                    deploy_job_id,
+                    self.db.clone(),
+                    self.container_deployer.clone(),
+                )
+                .with_log_id(db_job.log_id.clone())
+                .with_log_service(self.log_service.clone());
+
+                Ok(Arc::new(job))
+            }
+
+            "DrainConnectionsJob" => {
+                let config = db_job.job_config.as_ref().ok_or_else(|| {
+                    WorkflowExecutionError::MissingJobConfig(db_job.job_id.clone())
+                })?;
+
+                let deployment_id = config
+                    .get("deployment_id")
+                    .and_then(|v| v.as_i64())
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(
+                            "deployment_id is required".to_string(),
+                        )
+                    })? as i32;
+
+                let timeout_seconds = config
+                    .get("timeout_seconds")
+                    .and_then(|v| v.as_u64())
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(
+                            "timeout_seconds is required".to_string(),
+                        )
+                    })? as u32;
+
+                let mut job = crate::jobs::DrainConnectionsJob::new(
+                    db_job.job_id.clone(),
+                    deployment_id,
+                    timeout_seconds,
+                    self.db.clone(),
+                    self.container_deployer.clone(),
+                )
+                .with_log_id(db_job.log_id.clone())
+                .with_log_service(self.log_service.clone());
+                if let Some(connections) = &self.connections {
+                    job = job.with_connections(connections.clone());
+                }
+
+                Ok(Arc::new(job))
+            }
+
             "TakeScreenshotJob" => {
                 // Screenshot service is always available now
                 let screenshot_service = &self.screenshot_service;
@@ -757,6 +1568,172 @@ impl WorkflowExecutionService {
                 Ok(Arc::new(job))
             }
 
+            "SmokeTestJob" => {
+                let config = db_job.job_config.as_ref().ok_or_else(|| {
+                    WorkflowExecutionError::MissingJobConfig(db_job.job_id.clone())
+                })?;
+
+                let deployment_id = config
+                    .get("deployment_id")
+                    .and_then(|v| v.as_i64())
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(
+                            "deployment_id is required".to_string(),
+                        )
+                    })? as i32;
+
+                let deploy_job_id = config
+                    .get("deploy_job_id")
+                    .and_then(|v| v.as_str())
+                    .unwrap_or("deploy_container")
+                    .to_string();
+
+                let smoke_tests = config
+                    .get("smoke_tests")
+                    .cloned()
+                    .and_then(|v| {
+                        serde_json::from_value::<
+                            temps_entities::deployment_config::SmokeTestsConfig,
+                        >(v)
+                        .ok()
+                    })
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(
+                            "smoke_tests is required".to_string(),
+                        )
+                    })?;
+
+                let job = crate::jobs::SmokeTestJob::new(
+                    db_job.job_id.clone(),
+                    deployment_id,
+                    deploy_job_id,
+                    smoke_tests,
+                    self.db.clone(),
+                    self.container_deployer.clone(),
+                )
+                .with_log_id(db_job.log_id.clone())
+                .with_log_service(self.log_service.clone());
+
+                Ok(Arc::new(job))
+            }
+
+            "DeployGateJob" => {
+                let config = db_job.job_config.as_ref().ok_or_else(|| {
+                    WorkflowExecutionError::MissingJobConfig(db_job.job_id.clone())
+                })?;
+
+                let deployment_id = config
+                    .get("deployment_id")
+                    .and_then(|v| v.as_i64())
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(
+                            "deployment_id is required".to_string(),
+                        )
+                    })? as i32;
+
+                let dependencies: Vec<String> = config
+                    .get("dependencies")
+                    .cloned()
+                    .and_then(|v| serde_json::from_value(v).ok())
+                    .unwrap_or_default();
+
+                let deploy_job_id = config
+                    .get("deploy_job_id")
+                    .and_then(|v| v.as_str())
+                    .map(|s| s.to_string());
+
+                let callback_url = config
+                    .get("callback_url")
+                    .and_then(|v| v.as_str())
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(
+                            "callback_url is required".to_string(),
+                        )
+                    })?
+                    .to_string();
+
+                // Read from the environment now, with its secrets decrypted
+                let deploy_gate = environment
+                    .get_effective_deployment_config(
+                        &project.deployment_config.clone().unwrap_or_default(),
+                    )
+                    .deploy_gate
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(format!(
+                            "The environment of deployment {} no longer has a deploy gate",
+                            deployment_id
+                        ))
+                    })?
+                    .unsealed(self.encryption_service()?)
+                    .map_err(|e| {
+                        WorkflowExecutionError::JobCreationFailed(format!(
+                            "Failed to read the deploy gate's secrets: {}",
+                            e
+                        ))
+                    })?;
+
+                let job = crate::jobs::DeployGateJob::new(
+                    db_job.job_id.clone(),
+                    deployment_id,
+                    dependencies,
+                    deploy_job_id,
+                    callback_url,
+                    deploy_gate,
+                    self.db.clone(),
+                    self.container_deployer.clone(),
+                )
+                .with_log_id(db_job.log_id.clone())
+                .with_log_service(self.log_service.clone());
+
+                Ok(Arc::new(job))
+            }
+
+            "PurgeCdnJob" => {
+                let config = db_job.job_config.as_ref().ok_or_else(|| {
+                    WorkflowExecutionError::MissingJobConfig(db_job.job_id.clone())
+                })?;
+
+                let deployment_id = config
+                    .get("deployment_id")
+                    .and_then(|v| v.as_i64())
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(
+                            "deployment_id is required".to_string(),
+                        )
+                    })? as i32;
+
+                // Read from the environment now, with its API token decrypted
+                let cdn_purge = environment
+                    .get_effective_deployment_config(
+                        &project.deployment_config.clone().unwrap_or_default(),
+                    )
+                    .cdn_purge
+                    .ok_or_else(|| {
+                        WorkflowExecutionError::InvalidJobConfig(format!(
+                            "The environment of deployment {} no longer has a CDN purge",
+                            deployment_id
+                        ))
+                    })?
+                    .unsealed(self.encryption_service()?)
+                    .map_err(|e| {
+                        WorkflowExecutionError::JobCreationFailed(format!(
+                            "Failed to read the CDN purge's API token: {}",
+                            e
+                        ))
+                    })?;
+
+                let job = crate::jobs::PurgeCdnJob::new(
+                    db_job.job_id.clone(),
+                    deployment_id,
+                    cdn_purge,
+                    self.db.clone(),
+                )
+                .with_log_id(db_job.log_id.clone())
+                .with_log_service(self.log_service.clone());
+
+                Ok(Arc::new(job))
+            }
+
             "DeployStaticJob" => {
                 let config = db_job.job_config.as_ref().ok_or_else(|| {
                     WorkflowExecutionError::MissingJobConfig(db_job.job_id.clone())
@@ -807,6 +1784,10 @@ impl WorkflowExecutionService {
                 )
                 .with_log_id(db_job.log_id.clone())
                 .with_log_service(self.log_service.clone());
+                let job = match &self.artifact_service {
+                    Some(artifact_service) => job.with_artifact_service(artifact_service.clone()),
+                    None => job,
+                };
 
                 Ok(Arc::new(job))
             }
@@ -905,6 +1886,20 @@ impl WorkflowExecutionService {
                     None
                 };
 
+                let kind = super::classify_success(
+                    self.db.as_ref(),
+                    updated_deployment.environment_id,
+                    updated_deployment.id,
+                )
+                .await
+                .unwrap_or_else(|e| {
+                    warn!(
+                        "Failed to classify success of deployment {}: {}",
+                        deployment_id, e
+                    );
+                    temps_core::DeploymentSuccessKind::Routine
+                });
+
                 let event = Job::DeploymentSucceeded(temps_core::DeploymentSucceededJob {
                     deployment_id: updated_deployment.id,
                     project_id: updated_deployment.project_id,
@@ -912,6 +1907,7 @@ impl WorkflowExecutionService {
                     environment_name: environment.name.clone(),
                     commit_sha: updated_deployment.commit_sha.clone(),
                     url,
+                    kind,
                 });
                 if let Err(e) = self.queue.send(event).await {
                     error!("Failed to send DeploymentSucceeded event: {}", e);
@@ -1354,6 +2350,7 @@ mod tests {
                 image_name: "mock-image:latest".to_string(),
                 size_bytes: 1024,
                 build_duration_ms: 1000,
+                phases: None,
             })
         }
 
@@ -1523,6 +2520,7 @@ mod tests {
                 created_at: chrono::Utc::now(),
                 ports: vec![],
                 environment_vars: HashMap::new(),
+                labels: HashMap::new(),
                 status: temps_deployer::ContainerStatus::Running,
             })
         }
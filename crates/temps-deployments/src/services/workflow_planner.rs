@@ -6,6 +6,7 @@ use sea_orm::{ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, Qu
 use serde_json;
 use std::sync::Arc;
 use temps_core::EncryptionService;
+use temps_entities::deployments::{RemoteArtifact, RemoteArtifactKind};
 use temps_entities::{deployment_jobs, deployments, environments, projects, types::JobStatus};
 use temps_logs::LogService;
 use tracing::{debug, info};
@@ -56,7 +57,8 @@ impl WorkflowPlanner {
 
     /// Gather all environment variables for a deployment
     /// This includes:
-    /// 1. Environment variables from the env_vars table for the specific environment (via env_var_environments junction table)
+    /// 1. Environment variables from the env_vars table for the specific environment (via env_var_environments junction table),
+    ///    merged with the project's shared variables, except build-only ones, which the containers never see
     /// 2. Runtime environment variables from external services linked to the project
     /// 3. Sentry DSN environment variables (SENTRY_DSN and NEXT_PUBLIC_SENTRY_DSN) - auto-generated per project/environment
     /// 4. Deployment token environment variables (TEMPS_API_URL and TEMPS_API_TOKEN) - for API access from deployed apps
@@ -70,7 +72,7 @@ impl WorkflowPlanner {
         environment: &environments::Model,
     ) -> anyhow::Result<std::collections::HashMap<String, String>> {
         use std::collections::HashMap;
-        use temps_entities::{env_var_environments, env_vars, project_services};
+        use temps_entities::project_services;
 
         let mut env_vars_map = HashMap::new();
 
@@ -80,31 +82,26 @@ impl WorkflowPlanner {
         // Can be overridden by user-defined environment variables
         env_vars_map.insert("HOST".to_string(), "0.0.0.0".to_string());
 
-        // 1. Get environment variables for this project and environment
-        // Query through the env_var_environments junction table to get all env vars
-        // associated with this environment
-        let env_var_ids: Vec<i32> = env_var_environments::Entity::find()
-            .filter(env_var_environments::Column::EnvironmentId.eq(environment.id))
-            .all(self.db.as_ref())
-            .await?
-            .into_iter()
-            .map(|eve| eve.env_var_id)
-            .collect();
-
-        if !env_var_ids.is_empty() {
-            let env_vars_list = env_vars::Entity::find()
-                .filter(env_vars::Column::Id.is_in(env_var_ids))
-                .filter(env_vars::Column::ProjectId.eq(project.id))
-                .all(self.db.as_ref())
-                .await?;
-
-            for env_var in env_vars_list {
+        // Add the configured timezone and locale (UTC and C.UTF-8 unless set), so
+        // they're explicit rather than whatever the image happens to default to
+        // Can be overridden by user-defined environment variables
+        let locale_defaults = environment
+            .get_effective_deployment_config(&project.deployment_config.clone().unwrap_or_default())
+            .locale_defaults
+            .unwrap_or_default();
+        env_vars_map.extend(locale_defaults.env_vars());
+
+        // 1. Get environment variables for this project and environment: the ones
+        // linked to it through the env_var_environments junction table, merged with the
+        // project's shared variables
+        for env_var in self.resolved_variables(project, environment).await? {
+            if !env_var.build_only {
                 env_vars_map.insert(env_var.key, env_var.value);
             }
         }
 
         debug!(
-            "📦 Loaded {} environment variables from env_vars table via env_var_environments",
+            "📦 Loaded {} environment variables from env_vars table",
             env_vars_map.len()
         );
 
@@ -447,6 +444,486 @@ impl WorkflowPlanner {
         3000
     }
 
+    /// Build-only environment variables of an environment, passed to the build as
+    /// build arguments but not to the containers
+    async fn gather_build_only_variables(
+        &self,
+        project: &projects::Model,
+        environment: &environments::Model,
+    ) -> anyhow::Result<std::collections::HashMap<String, String>> {
+        build_only_variables(self.db.as_ref(), project, environment).await
+    }
+
+    async fn resolved_variables(
+        &self,
+        project: &projects::Model,
+        environment: &environments::Model,
+    ) -> anyhow::Result<Vec<temps_entities::env_vars::ResolvedVariable>> {
+        resolved_variables(self.db.as_ref(), project, environment).await
+    }
+
+    /// Environment variables a promotion carries over from the source environment
+    ///
+    /// Only the keys listed in the target environment's `promotion.carry_env_vars`
+    /// are taken; keys the source environment doesn't define are left out.
+    async fn carried_env_vars(
+        &self,
+        project: &projects::Model,
+        environment: &environments::Model,
+        source_environment_id: i32,
+    ) -> anyhow::Result<std::collections::HashMap<String, String>> {
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let carry_keys = environment
+            .get_effective_deployment_config(&project_config)
+            .promotion
+            .map(|promotion| promotion.carry_env_vars)
+            .unwrap_or_default();
+        if carry_keys.is_empty() || source_environment_id == environment.id {
+            return Ok(std::collections::HashMap::new());
+        }
+
+        let source_environment = environments::Entity::find_by_id(source_environment_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| anyhow::anyhow!("Source environment not found"))?;
+        let mut source_vars = self
+            .gather_environment_variables(project, &source_environment)
+            .await?;
+        Ok(carry_keys
+            .into_iter()
+            .filter_map(|key| source_vars.remove_entry(&key))
+            .collect())
+    }
+
+    /// Smoke test job run between deploying the containers and the cutover, when the
+    /// environment has smoke tests
+    fn smoke_test_job(
+        environment: &environments::Model,
+        project: &projects::Model,
+        deployment: &deployments::Model,
+    ) -> Option<JobDefinition> {
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let smoke_tests = environment
+            .get_effective_deployment_config(&project_config)
+            .smoke_tests
+            .filter(|smoke_tests| !smoke_tests.checks.is_empty())?;
+
+        Some(JobDefinition {
+            job_id: "smoke_tests".to_string(),
+            job_type: "SmokeTestJob".to_string(),
+            name: "Run Smoke Tests".to_string(),
+            description: Some("Check the new containers before they receive traffic".to_string()),
+            dependencies: vec!["deploy_container".to_string()],
+            job_config: Some(serde_json::json!({
+                "deployment_id": deployment.id,
+                "deploy_job_id": "deploy_container",
+                "smoke_tests": smoke_tests
+            })),
+            required_for_completion: true,
+        })
+    }
+
+    /// Drain job run after the cutover, when the environment gives the previous
+    /// deployment's open connections time to finish; it stops the previous deployment
+    /// in place of the cutover job
+    fn drain_connections_job(
+        environment: &environments::Model,
+        project: &projects::Model,
+        deployment: &deployments::Model,
+    ) -> Option<JobDefinition> {
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let timeout_seconds = environment
+            .get_effective_deployment_config(&project_config)
+            .connection_drain_seconds
+            .filter(|seconds| *seconds > 0)?;
+
+        Some(JobDefinition {
+            job_id: "drain_connections".to_string(),
+            job_type: "DrainConnectionsJob".to_string(),
+            name: "Drain Connections".to_string(),
+            description: Some(format!(
+                "Let the previous deployment finish its open connections (up to {}s), then stop it",
+                timeout_seconds
+            )),
+            dependencies: vec!["mark_deployment_complete".to_string()],
+            job_config: Some(serde_json::json!({
+                "deployment_id": deployment.id,
+                "timeout_seconds": timeout_seconds
+            })),
+            // The new deployment already serves traffic
+            required_for_completion: false,
+        })
+    }
+
+    /// Job purging the CDN's cache once the new deployment serves traffic, when the
+    /// environment has a CDN purge configured
+    ///
+    /// The job reads the purge config from the environment when it runs, so the API
+    /// token isn't copied into the job config.
+    fn cdn_purge_job(
+        environment: &environments::Model,
+        project: &projects::Model,
+        deployment: &deployments::Model,
+    ) -> Option<JobDefinition> {
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let cdn_purge = environment
+            .get_effective_deployment_config(&project_config)
+            .cdn_purge?;
+
+        Some(JobDefinition {
+            job_id: "purge_cdn".to_string(),
+            job_type: "PurgeCdnJob".to_string(),
+            name: "Purge CDN Cache".to_string(),
+            description: Some(format!("Purge the {} cache", cdn_purge.provider)),
+            dependencies: vec!["mark_deployment_complete".to_string()],
+            job_config: Some(serde_json::json!({
+                "deployment_id": deployment.id
+            })),
+            // A failed purge only leaves stale cache entries behind
+            required_for_completion: false,
+        })
+    }
+
+    /// End a dry run's jobs with the job removing its containers, after `last_job_id`
+    ///
+    /// Nothing runs after it: no cutover, deploy gate or post-deployment jobs.
+    fn finish_dry_run_plan(
+        mut jobs: Vec<JobDefinition>,
+        deployment: &deployments::Model,
+        last_job_id: &str,
+        deploy_job_id: Option<&str>,
+    ) -> Vec<JobDefinition> {
+        jobs.push(JobDefinition {
+            job_id: "finish_dry_run".to_string(),
+            job_type: "FinishDryRunJob".to_string(),
+            name: "Finish Dry Run".to_string(),
+            description: Some(
+                "Remove the dry run's containers; the current deployment keeps serving".to_string(),
+            ),
+            dependencies: vec![last_job_id.to_string()],
+            job_config: Some(serde_json::json!({
+                "deployment_id": deployment.id,
+                "deploy_job_id": deploy_job_id
+            })),
+            required_for_completion: true,
+        });
+        info!("Planned {} jobs for dry run {}", jobs.len(), deployment.id);
+        jobs
+    }
+
+    /// Add the deploy gate job between `dependencies` and the cutover, when the
+    /// environment has a deploy gate
+    ///
+    /// The job reads the gate from the environment when it runs, so its secrets aren't
+    /// copied into the job config. Returns the jobs the cutover depends on.
+    async fn add_deploy_gate_job(
+        &self,
+        jobs: &mut Vec<JobDefinition>,
+        environment: &environments::Model,
+        project: &projects::Model,
+        deployment: &deployments::Model,
+        dependencies: Vec<String>,
+    ) -> anyhow::Result<Vec<String>> {
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        if environment
+            .get_effective_deployment_config(&project_config)
+            .deploy_gate
+            .is_none()
+        {
+            return Ok(dependencies);
+        }
+
+        let base_url = self
+            .config_service
+            .get_external_url_or_default()
+            .await
+            .map_err(|e| {
+                anyhow::anyhow!(
+                    "Failed to get the external URL for the deploy gate callback: {}",
+                    e
+                )
+            })?;
+        let callback_url = format!(
+            "{}/api/webhook/deployments/{}/gate",
+            base_url.trim_end_matches('/'),
+            deployment.id
+        );
+        // Static deployments have no new containers to remove if the gate fails
+        let deploy_job_id = jobs
+            .iter()
+            .any(|job| job.job_id == "deploy_container")
+            .then_some("deploy_container");
+
+        jobs.push(JobDefinition {
+            job_id: "deploy_gate".to_string(),
+            job_type: "DeployGateJob".to_string(),
+            name: "Wait for Deploy Gate".to_string(),
+            description: Some("Wait for an external system to approve the new version".to_string()),
+            dependencies: dependencies.clone(),
+            job_config: Some(serde_json::json!({
+                "deployment_id": deployment.id,
+                "dependencies": dependencies,
+                "deploy_job_id": deploy_job_id,
+                "callback_url": callback_url
+            })),
+            required_for_completion: true,
+        });
+        Ok(vec!["deploy_gate".to_string()])
+    }
+
+    /// Plan jobs for a promoted or redeployed deployment
+    ///
+    /// A promotion deploys what the source deployment already runs — its image, pinned
+    /// by ID, or its static files — so nothing is downloaded or built. The container
+    /// gets the target environment's variables plus the ones carried over. A redeploy
+    /// is planned the same way, with the source deployment in the same environment, so
+    /// nothing is carried over.
+    async fn plan_promotion_jobs(
+        &self,
+        project: &projects::Model,
+        environment: &environments::Model,
+        deployment: &deployments::Model,
+        source_deployment_id: i32,
+    ) -> anyhow::Result<Vec<JobDefinition>> {
+        let mut jobs = Vec::new();
+
+        let source_deployment = deployments::Entity::find_by_id(source_deployment_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| {
+                anyhow::anyhow!("Promoted deployment {} not found", source_deployment_id)
+            })?;
+
+        info!(
+            "Planning promotion of deployment {} to deployment {} (env: {})",
+            source_deployment_id, deployment.id, environment.name
+        );
+
+        // Static files stay in the source deployment's directory, which the promoted
+        // deployment serves as well, so only routing has to move
+        let deploy_dependencies = if deployment.static_dir_location.is_some() {
+            Vec::new()
+        } else {
+            let image = deployment
+                .metadata
+                .as_ref()
+                .and_then(|metadata| metadata.promoted_image.clone())
+                .or_else(|| deployment.image_name.clone())
+                .ok_or_else(|| anyhow::anyhow!("Promoted deployment has no image to deploy"))?;
+
+            let mut deploy_env_vars = self
+                .gather_environment_variables(project, environment)
+                .await?;
+            let carried = self
+                .carried_env_vars(project, environment, source_deployment.environment_id)
+                .await?;
+            debug!(
+                "Carrying {} environment variables over from the source environment: {}",
+                carried.len(),
+                carried.keys().cloned().collect::<Vec<_>>().join(", ")
+            );
+            deploy_env_vars.extend(carried);
+
+            let exposed_port = self
+                .resolve_exposed_port(environment, project, Some(&image))
+                .await;
+            deploy_env_vars.insert("PORT".to_string(), exposed_port.to_string());
+
+            let replicas = environment
+                .deployment_config
+                .as_ref()
+                .map(|c| c.replicas)
+                .or_else(|| project.deployment_config.as_ref().map(|c| c.replicas))
+                .unwrap_or(1);
+
+            // The image has no build context to read the Procfile from
+            let process_types = source_deployment
+                .metadata
+                .as_ref()
+                .map(|metadata| metadata.process_types.clone())
+                .unwrap_or_default();
+
+            jobs.push(JobDefinition {
+                job_id: "deploy_container".to_string(),
+                job_type: "DeployImageJob".to_string(),
+                name: "Deploy Container".to_string(),
+                description: Some("Deploy the promoted container image".to_string()),
+                dependencies: vec![],
+                job_config: Some(serde_json::json!({
+                    "port": exposed_port,
+                    "replicas": replicas,
+                    "environment_variables": deploy_env_vars,
+                    "image_name": deployment.image_name,
+                    "external_image": image,
+                    "procfile": process_types
+                })),
+                required_for_completion: true,
+            });
+
+            // The promoted image passed the source environment's smoke tests, not
+            // necessarily this environment's
+            match Self::smoke_test_job(environment, project, deployment) {
+                Some(smoke_test_job) => {
+                    jobs.push(smoke_test_job);
+                    vec!["smoke_tests".to_string()]
+                }
+                None => vec!["deploy_container".to_string()],
+            }
+        };
+        self.add_cutover_jobs(
+            &mut jobs,
+            environment,
+            project,
+            deployment,
+            deploy_dependencies,
+        )
+        .await?;
+
+        Ok(jobs)
+    }
+
+    /// Add the deploy gate, the cutover after `deploy_dependencies` and the jobs
+    /// that follow it: the drain of the previous deployment and the CDN purge
+    async fn add_cutover_jobs(
+        &self,
+        jobs: &mut Vec<JobDefinition>,
+        environment: &environments::Model,
+        project: &projects::Model,
+        deployment: &deployments::Model,
+        deploy_dependencies: Vec<String>,
+    ) -> anyhow::Result<()> {
+        let deploy_dependencies = self
+            .add_deploy_gate_job(jobs, environment, project, deployment, deploy_dependencies)
+            .await?;
+
+        let drain_job = Self::drain_connections_job(environment, project, deployment);
+        jobs.push(JobDefinition {
+            job_id: "mark_deployment_complete".to_string(),
+            job_type: "MarkDeploymentCompleteJob".to_string(),
+            name: "Mark Deployment Complete".to_string(),
+            description: Some(
+                "Mark deployment as complete and update environment routing".to_string(),
+            ),
+            dependencies: deploy_dependencies,
+            job_config: Some(serde_json::json!({
+                "deployment_id": deployment.id,
+                "teardown_previous": drain_job.is_none()
+            })),
+            required_for_completion: true,
+        });
+        jobs.extend(drain_job);
+        jobs.extend(Self::cdn_purge_job(environment, project, deployment));
+        Ok(())
+    }
+
+    /// Job downloading the artifact a deployment was started with; an image is
+    /// loaded under `image_tag`
+    fn fetch_artifact_job(
+        project: &projects::Model,
+        artifact: &RemoteArtifact,
+        image_tag: Option<&str>,
+    ) -> JobDefinition {
+        JobDefinition {
+            job_id: "fetch_artifact".to_string(),
+            job_type: "FetchArtifactJob".to_string(),
+            name: "Fetch Artifact".to_string(),
+            description: Some("Download the build artifact from its URL".to_string()),
+            dependencies: vec![],
+            job_config: Some(serde_json::json!({
+                "url": artifact.url,
+                "kind": artifact.kind,
+                "sha256": artifact.sha256,
+                "encrypted_authorization": artifact.encrypted_authorization,
+                "project_slug": project.slug,
+                "image_tag": image_tag
+            })),
+            required_for_completion: true,
+        }
+    }
+
+    /// Plan jobs for a deployment of an image artifact
+    ///
+    /// The image is run as it is: nothing is built, and with no build context there
+    /// is no Procfile or `.temps.yaml` to read.
+    async fn plan_artifact_image_jobs(
+        &self,
+        project: &projects::Model,
+        environment: &environments::Model,
+        deployment: &deployments::Model,
+        artifact: &RemoteArtifact,
+    ) -> anyhow::Result<Vec<JobDefinition>> {
+        let image_name = format!("temps-{}:{}", project.slug, deployment.id);
+        let mut jobs = vec![Self::fetch_artifact_job(
+            project,
+            artifact,
+            Some(&image_name),
+        )];
+
+        let mut deploy_env_vars = self
+            .gather_environment_variables(project, environment)
+            .await?;
+        let exposed_port = self
+            .resolve_exposed_port(environment, project, Some(&image_name))
+            .await;
+        deploy_env_vars.insert("PORT".to_string(), exposed_port.to_string());
+
+        let replicas = environment
+            .deployment_config
+            .as_ref()
+            .map(|c| c.replicas)
+            .or_else(|| project.deployment_config.as_ref().map(|c| c.replicas))
+            .unwrap_or(1);
+
+        jobs.push(JobDefinition {
+            job_id: "deploy_container".to_string(),
+            job_type: "DeployImageJob".to_string(),
+            name: "Deploy Container".to_string(),
+            description: Some("Deploy the image of the artifact".to_string()),
+            dependencies: vec!["fetch_artifact".to_string()],
+            job_config: Some(serde_json::json!({
+                "port": exposed_port,
+                "replicas": replicas,
+                "environment_variables": deploy_env_vars,
+                "image_name": image_name,
+                "external_image": image_name
+            })),
+            required_for_completion: true,
+        });
+
+        let deploy_job_id = match Self::smoke_test_job(environment, project, deployment) {
+            Some(smoke_test_job) => {
+                jobs.push(smoke_test_job);
+                "smoke_tests".to_string()
+            }
+            None => "deploy_container".to_string(),
+        };
+
+        if deployment.is_dry_run() {
+            return Ok(Self::finish_dry_run_plan(
+                jobs,
+                deployment,
+                &deploy_job_id,
+                Some("deploy_container"),
+            ));
+        }
+
+        self.add_cutover_jobs(
+            &mut jobs,
+            environment,
+            project,
+            deployment,
+            vec![deploy_job_id],
+        )
+        .await?;
+
+        info!(
+            "Planned {} jobs for image artifact deployment {}",
+            jobs.len(),
+            deployment.id
+        );
+        Ok(jobs)
+    }
+
     /// Plan jobs based on project configuration
     /// Uses the 3 generic jobs: DownloadRepoJob -> BuildImageJob -> DeployImageJob
     async fn plan_jobs_for_project(
@@ -455,6 +932,30 @@ impl WorkflowPlanner {
         environment: &environments::Model,
         deployment: &deployments::Model,
     ) -> anyhow::Result<Vec<JobDefinition>> {
+        if let Some(source_deployment_id) = deployment
+            .metadata
+            .as_ref()
+            .and_then(|metadata| metadata.promoted_from_id.or(metadata.redeployed_from_id))
+        {
+            return self
+                .plan_promotion_jobs(project, environment, deployment, source_deployment_id)
+                .await;
+        }
+
+        // A deployment of a CI build fetches its artifact instead of the repository
+        let remote_artifact = deployment
+            .metadata
+            .as_ref()
+            .and_then(|metadata| metadata.remote_artifact.clone());
+        if let Some(artifact) = remote_artifact
+            .as_ref()
+            .filter(|artifact| artifact.kind == RemoteArtifactKind::Image)
+        {
+            return self
+                .plan_artifact_image_jobs(project, environment, deployment, artifact)
+                .await;
+        }
+
         let mut jobs = Vec::new();
 
         debug!("Planning jobs for project: {}", project.name);
@@ -467,12 +968,22 @@ impl WorkflowPlanner {
             "📦 Gathered {} environment variables for deployment",
             env_vars.len()
         );
+        let build_only_vars = self
+            .gather_build_only_variables(project, environment)
+            .await?;
+        // Build args whose values the build job masks in its log
+        let mut masked_build_args: Vec<String> = build_only_vars.keys().cloned().collect();
+        masked_build_args.sort();
 
         // Check if git info is available
         let has_git_info = !project.repo_owner.is_empty() && !project.repo_name.is_empty();
 
-        // Job 1: Download repository (only if git info is available)
-        if has_git_info {
+        // Job 1: Fetch the build context, or download the repository (only if git info
+        // is available)
+        let source_job_id = if let Some(artifact) = &remote_artifact {
+            jobs.push(Self::fetch_artifact_job(project, artifact, None));
+            Some("fetch_artifact")
+        } else if has_git_info {
             // Determine which branch/commit to use for this deployment
             // Priority: deployment.branch_ref > deployment.commit_sha > project.main_branch
             let branch_or_commit = deployment
@@ -505,14 +1016,25 @@ impl WorkflowPlanner {
                 })),
                 required_for_completion: true, // Core deployment job
             });
+            Some("download_repo")
         } else {
             debug!("Skipping download_repo job - no git info available");
-        }
+            None
+        };
 
         // Check if this preset supports static deployment using temps-presets
         // Get the preset instance and check if it has a static output directory
         let preset_instance = temps_presets::get_preset_by_slug(project.preset.as_str());
-        let static_output_dir = preset_instance.as_ref().and_then(|p| p.static_output_dir());
+        // A static site output directory in the deployment config turns any build into
+        // a static deploy, and takes precedence over the preset's
+        let project_config = project.deployment_config.clone().unwrap_or_default();
+        let effective_config = environment.get_effective_deployment_config(&project_config);
+        let configured_output_dir = effective_config
+            .static_site
+            .clone()
+            .and_then(|site| site.output_dir);
+        let static_output_dir = configured_output_dir
+            .or_else(|| preset_instance.as_ref().and_then(|p| p.static_output_dir()));
 
         debug!(
             "Preset {} static output directory: {:?}",
@@ -521,12 +1043,9 @@ impl WorkflowPlanner {
 
         // Job 2: Build container image (skip for static deployments)
         // The BuildImageJob will generate Dockerfile from preset if it doesn't exist
-        // Depends on download_repo only if git info is available
-        let build_dependencies = if has_git_info {
-            vec!["download_repo".to_string()]
-        } else {
-            vec![]
-        };
+        // Depends on the job that fetched the source, if any
+        let build_dependencies: Vec<String> =
+            source_job_id.map(str::to_string).into_iter().collect();
 
         // Determine deployment strategy: Static or Container
         let deploy_job_id = if let Some(output_dir) = static_output_dir {
@@ -534,10 +1053,13 @@ impl WorkflowPlanner {
             debug!("📦 Using static deployment for preset {}", project.preset);
             debug!("📂 Static output directory: {}", output_dir);
 
-            // Convert environment variables to build args
+            // Convert environment variables to build args; build-only ones are only
+            // named in masked_build_args and resolved when the build starts
             let mut build_args_map = serde_json::Map::new();
             for (key, value) in &env_vars {
-                build_args_map.insert(key.clone(), serde_json::Value::String(value.clone()));
+                if !build_only_vars.contains_key(key) {
+                    build_args_map.insert(key.clone(), serde_json::Value::String(value.clone()));
+                }
             }
 
             // Parse preset_config if present (for Dockerfile preset)
@@ -565,11 +1087,27 @@ impl WorkflowPlanner {
                 job_config: Some(serde_json::json!({
                     "dockerfile_path": dockerfile_path,
                     "build_args": build_args_map,
-                    "build_context": build_context
+                    "masked_build_args": masked_build_args,
+                    "build_context": build_context,
+                    "build_cache": effective_config.build_cache,
+                    "builder": effective_config.builder,
+                    "shared_build": effective_config.shared_build,
+                    "build_command": effective_config.build_command,
+                    "build_platforms": effective_config.build_platforms
                 })),
                 required_for_completion: true,
             });
 
+            // A static dry run checks that the site builds; its files are never published
+            if deployment.is_dry_run() {
+                return Ok(Self::finish_dry_run_plan(
+                    jobs,
+                    deployment,
+                    "build_image",
+                    None,
+                ));
+            }
+
             // Job 3: Deploy static files (extracts from built image and deploys to filesystem)
             jobs.push(JobDefinition {
                 job_id: "deploy_static".to_string(),
@@ -594,10 +1132,13 @@ impl WorkflowPlanner {
                 project.preset
             );
 
-            // Convert environment variables to build args
+            // Convert environment variables to build args; build-only ones are only
+            // named in masked_build_args and resolved when the build starts
             let mut build_args_map = serde_json::Map::new();
             for (key, value) in &env_vars {
-                build_args_map.insert(key.clone(), serde_json::Value::String(value.clone()));
+                if !build_only_vars.contains_key(key) {
+                    build_args_map.insert(key.clone(), serde_json::Value::String(value.clone()));
+                }
             }
 
             // Parse preset_config if present (for Dockerfile preset)
@@ -624,7 +1165,13 @@ impl WorkflowPlanner {
                 job_config: Some(serde_json::json!({
                     "dockerfile_path": dockerfile_path,
                     "build_args": build_args_map,
-                    "build_context": build_context
+                    "masked_build_args": masked_build_args,
+                    "build_context": build_context,
+                    "build_cache": effective_config.build_cache,
+                    "builder": effective_config.builder,
+                    "shared_build": effective_config.shared_build,
+                    "build_command": effective_config.build_command,
+                    "build_platforms": effective_config.build_platforms
                 })),
                 required_for_completion: true,
             });
@@ -667,13 +1214,45 @@ impl WorkflowPlanner {
                 required_for_completion: true,
             });
 
-            "deploy_container".to_string()
+            // Smoke tests gate the cutover
+            match Self::smoke_test_job(environment, project, deployment) {
+                Some(smoke_test_job) => {
+                    debug!("Added smoke_tests job between deploy_container and cutover");
+                    jobs.push(smoke_test_job);
+                    "smoke_tests".to_string()
+                }
+                None => "deploy_container".to_string(),
+            }
         };
 
+        // A dry run stops short of the cutover: its containers are removed once the
+        // checks passed
+        if deployment.is_dry_run() {
+            return Ok(Self::finish_dry_run_plan(
+                jobs,
+                deployment,
+                &deploy_job_id,
+                Some("deploy_container"),
+            ));
+        }
+
+        // An external deploy gate has the last word before the cutover
+        let cutover_dependencies = self
+            .add_deploy_gate_job(
+                &mut jobs,
+                environment,
+                project,
+                deployment,
+                vec![deploy_job_id],
+            )
+            .await?;
+
         // Job 4: Mark deployment as complete
         // This synthetic job marks the deployment as "Completed" and updates environment routing
         // It acts as a barrier between core deployment jobs and optional post-deployment jobs
-        // Depends on either deploy_static or deploy_container depending on deployment strategy
+        // Depends on deploy_static, deploy_container, smoke_tests or deploy_gate depending on
+        // deployment strategy
+        let drain_job = Self::drain_connections_job(environment, project, deployment);
         jobs.push(JobDefinition {
             job_id: "mark_deployment_complete".to_string(),
             job_type: "MarkDeploymentCompleteJob".to_string(),
@@ -681,19 +1260,32 @@ impl WorkflowPlanner {
             description: Some(
                 "Mark deployment as complete and update environment routing".to_string(),
             ),
-            dependencies: vec![deploy_job_id],
+            dependencies: cutover_dependencies,
             job_config: Some(serde_json::json!({
-                "deployment_id": deployment.id
+                "deployment_id": deployment.id,
+                "teardown_previous": drain_job.is_none()
             })),
             required_for_completion: true, // Critical job - ensures deployment is marked complete
         });
         debug!("Added mark_deployment_complete job as barrier between core and optional jobs");
 
-        // Job 5: Configure cron jobs (only if git info is available)
+        // The previous deployment drains its open connections after the cutover and is
+        // stopped once they are done
+        if let Some(drain_job) = drain_job {
+            jobs.push(drain_job);
+            debug!("Added drain_connections job after mark_deployment_complete");
+        }
+
+        if let Some(purge_job) = Self::cdn_purge_job(environment, project, deployment) {
+            jobs.push(purge_job);
+            debug!("Added purge_cdn job after mark_deployment_complete");
+        }
+
+        // Job 5: Configure cron jobs (only if the source was fetched)
         // This job reads .temps.yaml from the repository and configures cron jobs
         // It runs AFTER deployment is marked complete (via mark_deployment_complete job)
         // NOT required for deployment completion - if it fails, deployment still succeeds
-        if has_git_info {
+        if let Some(source_job_id) = source_job_id {
             jobs.push(JobDefinition {
                 job_id: "configure_crons".to_string(),
                 job_type: "ConfigureCronsJob".to_string(),
@@ -704,7 +1296,7 @@ impl WorkflowPlanner {
                 job_config: Some(serde_json::json!({
                     "project_id": project.id,
                     "environment_id": deployment.environment_id,
-                    "download_job_id": "download_repo"
+                    "download_job_id": source_job_id
                 })),
                 required_for_completion: false, // Post-deployment job - not required for deployment success
             });
@@ -737,10 +1329,10 @@ impl WorkflowPlanner {
             debug!("Skipping screenshot job - screenshots are disabled in config");
         }
 
-        // Job 7: Scan for vulnerabilities (only if git info is available)
+        // Job 7: Scan for vulnerabilities (only if the source was fetched)
         // This runs in parallel with other post-deployment jobs AFTER deployment is marked complete
         // NOT required for deployment completion - if it fails, deployment still succeeds
-        if has_git_info {
+        if let Some(source_job_id) = source_job_id {
             jobs.push(JobDefinition {
                 job_id: "scan_vulnerabilities".to_string(),
                 job_type: "ScanVulnerabilitiesJob".to_string(),
@@ -755,7 +1347,7 @@ impl WorkflowPlanner {
                     "environment_id": deployment.environment_id,
                     "branch": deployment.branch_ref,
                     "commit_hash": deployment.commit_sha,
-                    "download_job_id": "download_repo",
+                    "download_job_id": source_job_id,
                     "build_job_id": "build_image"
                 })),
                 required_for_completion: false, // Post-deployment job - not required for deployment success
@@ -772,6 +1364,62 @@ impl WorkflowPlanner {
     }
 }
 
+/// Build-only variables of an environment with their values
+///
+/// The planner only records the names of these in the build job; the build job
+/// resolves the values when it starts, so secrets aren't stored with the job.
+pub(crate) async fn build_only_variables(
+    db: &DatabaseConnection,
+    project: &projects::Model,
+    environment: &environments::Model,
+) -> anyhow::Result<std::collections::HashMap<String, String>> {
+    Ok(resolved_variables(db, project, environment)
+        .await?
+        .into_iter()
+        .filter(|var| var.build_only)
+        .map(|var| (var.key, var.value))
+        .collect())
+}
+
+/// Variables of an environment merged with the project's shared ones, the
+/// environment's winning, with references to shared variables replaced
+///
+/// A reference to a shared variable that doesn't exist fails the deployment.
+async fn resolved_variables(
+    db: &DatabaseConnection,
+    project: &projects::Model,
+    environment: &environments::Model,
+) -> anyhow::Result<Vec<temps_entities::env_vars::ResolvedVariable>> {
+    use temps_entities::{env_var_environments, env_vars};
+
+    let shared = env_vars::Entity::find()
+        .filter(env_vars::Column::ProjectId.eq(project.id))
+        .filter(env_vars::Column::Shared.eq(true))
+        .all(db)
+        .await?;
+
+    let env_var_ids: Vec<i32> = env_var_environments::Entity::find()
+        .filter(env_var_environments::Column::EnvironmentId.eq(environment.id))
+        .all(db)
+        .await?
+        .into_iter()
+        .map(|eve| eve.env_var_id)
+        .collect();
+    let own = if env_var_ids.is_empty() {
+        Vec::new()
+    } else {
+        env_vars::Entity::find()
+            .filter(env_vars::Column::Id.is_in(env_var_ids))
+            .filter(env_vars::Column::ProjectId.eq(project.id))
+            .filter(env_vars::Column::Shared.eq(false))
+            .all(db)
+            .await?
+    };
+
+    env_vars::resolve_variables(&shared, &own, environment.is_preview)
+        .map_err(|e| anyhow::anyhow!("Failed to resolve environment variables: {}", e))
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -1145,6 +1793,388 @@ mod tests {
         Ok(())
     }
 
+    #[tokio::test]
+    async fn test_deploy_gate_runs_before_cutover() -> Result<(), Box<dyn std::error::Error>> {
+        use temps_entities::deployment_config::{DeployGateConfig, DeploymentConfig};
+
+        let test_db = TestDatabase::with_migrations().await?;
+        let db = test_db.connection_arc();
+        let log_service = Arc::new(LogService::new(std::env::temp_dir()));
+        let config_service = create_test_config_service(db.clone());
+        let dsn_service = create_test_dsn_service(db.clone());
+        let external_service_manager = create_test_external_service_manager(db.clone());
+        let planner = WorkflowPlanner::new(
+            db.clone(),
+            log_service,
+            external_service_manager,
+            config_service,
+            dsn_service,
+            create_test_encryption_service(),
+        );
+
+        let (project, _environment, deployment) =
+            create_test_project(db.as_ref(), Preset::NextJs).await?;
+        let mut active_project: projects::ActiveModel = project.into();
+        active_project.deployment_config = Set(Some(DeploymentConfig {
+            deploy_gate: Some(DeployGateConfig {
+                url: "https://ci.example.com/hooks/temps-deploy".to_string(),
+                secret: Some("signing-secret".to_string()),
+                ..Default::default()
+            }),
+            ..Default::default()
+        }));
+        active_project.update(db.as_ref()).await?;
+
+        let jobs = planner.create_deployment_jobs(deployment.id).await?;
+        let dependencies = |job_id: &str| -> Vec<String> {
+            let job = jobs.iter().find(|j| j.job_id == job_id).unwrap();
+            serde_json::from_value(job.dependencies.clone().unwrap()).unwrap()
+        };
+        assert_eq!(dependencies("deploy_gate"), vec!["deploy_container"]);
+        assert_eq!(
+            dependencies("mark_deployment_complete"),
+            vec!["deploy_gate"]
+        );
+
+        let gate_job = jobs.iter().find(|j| j.job_id == "deploy_gate").unwrap();
+        let config = gate_job.job_config.clone().unwrap();
+        assert_eq!(config["deploy_job_id"], "deploy_container");
+        assert!(config["callback_url"]
+            .as_str()
+            .unwrap()
+            .ends_with(&format!("/api/webhook/deployments/{}/gate", deployment.id)));
+        // The job reads the gate when it runs
+        assert!(config.get("deploy_gate").is_none());
+        assert!(!config.to_string().contains("signing-secret"));
+
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_dry_run_stops_before_cutover() -> Result<(), Box<dyn std::error::Error>> {
+        use temps_entities::deployment_config::{DeployGateConfig, DeploymentConfig};
+
+        let test_db = TestDatabase::with_migrations().await?;
+        let db = test_db.connection_arc();
+        let log_service = Arc::new(LogService::new(std::env::temp_dir()));
+        let config_service = create_test_config_service(db.clone());
+        let dsn_service = create_test_dsn_service(db.clone());
+        let external_service_manager = create_test_external_service_manager(db.clone());
+        let planner = WorkflowPlanner::new(
+            db.clone(),
+            log_service,
+            external_service_manager,
+            config_service,
+            dsn_service,
+            create_test_encryption_service(),
+        );
+
+        let (project, _environment, deployment) =
+            create_test_project(db.as_ref(), Preset::NextJs).await?;
+        let mut active_project: projects::ActiveModel = project.into();
+        active_project.deployment_config = Set(Some(DeploymentConfig {
+            deploy_gate: Some(DeployGateConfig {
+                url: "https://ci.example.com/hooks/temps-deploy".to_string(),
+                ..Default::default()
+            }),
+            ..Default::default()
+        }));
+        active_project.update(db.as_ref()).await?;
+        let mut active_deployment: deployments::ActiveModel = deployment.clone().into();
+        active_deployment.metadata = Set(Some(deployments::DeploymentMetadata {
+            dry_run: true,
+            ..Default::default()
+        }));
+        active_deployment.update(db.as_ref()).await?;
+
+        let jobs = planner.create_deployment_jobs(deployment.id).await?;
+        let job_ids: Vec<&str> = jobs.iter().map(|j| j.job_id.as_str()).collect();
+        assert_eq!(
+            job_ids,
+            vec![
+                "download_repo",
+                "build_image",
+                "deploy_container",
+                "finish_dry_run"
+            ]
+        );
+
+        let finish_job = jobs.last().unwrap();
+        let dependencies: Vec<String> =
+            serde_json::from_value(finish_job.dependencies.clone().unwrap())?;
+        assert_eq!(dependencies, vec!["deploy_container"]);
+        let config = finish_job.job_config.clone().unwrap();
+        assert_eq!(config["deploy_job_id"], "deploy_container");
+        assert_eq!(config["deployment_id"], deployment.id);
+
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_artifact_deployments_fetch_instead_of_cloning(
+    ) -> Result<(), Box<dyn std::error::Error>> {
+        let test_db = TestDatabase::with_migrations().await?;
+        let db = test_db.connection_arc();
+        let log_service = Arc::new(LogService::new(std::env::temp_dir()));
+        let config_service = create_test_config_service(db.clone());
+        let dsn_service = create_test_dsn_service(db.clone());
+        let external_service_manager = create_test_external_service_manager(db.clone());
+        let planner = WorkflowPlanner::new(
+            db.clone(),
+            log_service,
+            external_service_manager,
+            config_service,
+            dsn_service,
+            create_test_encryption_service(),
+        );
+
+        let (project, _environment, deployment) =
+            create_test_project(db.as_ref(), Preset::NextJs).await?;
+        let set_artifact = |kind| {
+            let mut active_deployment: deployments::ActiveModel = deployment.clone().into();
+            active_deployment.metadata = Set(Some(deployments::DeploymentMetadata {
+                remote_artifact: Some(RemoteArtifact {
+                    url: "https://ci.example.com/builds/812/app.tar.gz".to_string(),
+                    kind,
+                    sha256: Some("ab".repeat(32)),
+                    encrypted_authorization: None,
+                }),
+                ..Default::default()
+            }));
+            active_deployment
+        };
+
+        // A build context is built like a checkout
+        set_artifact(RemoteArtifactKind::BuildContext)
+            .update(db.as_ref())
+            .await?;
+        let jobs = planner.create_deployment_jobs(deployment.id).await?;
+        assert_eq!(jobs[0].job_id, "fetch_artifact");
+        assert!(!jobs.iter().any(|j| j.job_id == "download_repo"));
+        let build_job = jobs.iter().find(|j| j.job_id == "build_image").unwrap();
+        let dependencies: Vec<String> =
+            serde_json::from_value(build_job.dependencies.clone().unwrap())?;
+        assert_eq!(dependencies, vec!["fetch_artifact"]);
+
+        // An image is deployed as it is
+        deployment_jobs::Entity::delete_many()
+            .filter(deployment_jobs::Column::DeploymentId.eq(deployment.id))
+            .exec(db.as_ref())
+            .await?;
+        set_artifact(RemoteArtifactKind::Image)
+            .update(db.as_ref())
+            .await?;
+        let jobs = planner.create_deployment_jobs(deployment.id).await?;
+        let job_ids: Vec<&str> = jobs.iter().map(|j| j.job_id.as_str()).collect();
+        assert_eq!(
+            job_ids,
+            vec![
+                "fetch_artifact",
+                "deploy_container",
+                "mark_deployment_complete"
+            ]
+        );
+        let image_name = format!("temps-{}:{}", project.slug, deployment.id);
+        assert_eq!(jobs[0].job_config.clone().unwrap()["image_tag"], image_name);
+        assert_eq!(
+            jobs[1].job_config.clone().unwrap()["external_image"],
+            image_name
+        );
+
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_connection_drain_runs_after_cutover() -> Result<(), Box<dyn std::error::Error>> {
+        use temps_entities::deployment_config::DeploymentConfig;
+
+        let test_db = TestDatabase::with_migrations().await?;
+        let db = test_db.connection_arc();
+        let log_service = Arc::new(LogService::new(std::env::temp_dir()));
+        let config_service = create_test_config_service(db.clone());
+        let dsn_service = create_test_dsn_service(db.clone());
+        let external_service_manager = create_test_external_service_manager(db.clone());
+        let planner = WorkflowPlanner::new(
+            db.clone(),
+            log_service,
+            external_service_manager,
+            config_service,
+            dsn_service,
+            create_test_encryption_service(),
+        );
+
+        let (project, _environment, deployment) =
+            create_test_project(db.as_ref(), Preset::NextJs).await?;
+
+        // Without a drain period the cutover stops the previous deployment itself
+        let jobs = planner.create_deployment_jobs(deployment.id).await?;
+        assert!(!jobs.iter().any(|j| j.job_id == "drain_connections"));
+
+        let mut active_project: projects::ActiveModel = project.into();
+        active_project.deployment_config = Set(Some(DeploymentConfig {
+            connection_drain_seconds: Some(120),
+            ..Default::default()
+        }));
+        active_project.update(db.as_ref()).await?;
+
+        let jobs = planner.create_deployment_jobs(deployment.id).await?;
+        let drain_job = jobs
+            .iter()
+            .find(|j| j.job_id == "drain_connections")
+            .unwrap();
+        let dependencies: Vec<String> =
+            serde_json::from_value(drain_job.dependencies.clone().unwrap()).unwrap();
+        assert_eq!(dependencies, vec!["mark_deployment_complete"]);
+        assert_eq!(
+            drain_job.job_config.as_ref().unwrap()["timeout_seconds"],
+            120
+        );
+
+        let cutover = jobs
+            .iter()
+            .find(|j| j.job_id == "mark_deployment_complete")
+            .unwrap();
+        assert_eq!(
+            cutover.job_config.as_ref().unwrap()["teardown_previous"],
+            false
+        );
+
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_cdn_purge_runs_after_cutover() -> Result<(), Box<dyn std::error::Error>> {
+        use temps_entities::deployment_config::{
+            CdnProvider, CdnPurgeConfig, CdnPurgeScope, DeploymentConfig,
+        };
+
+        let test_db = TestDatabase::with_migrations().await?;
+        let db = test_db.connection_arc();
+        let log_service = Arc::new(LogService::new(std::env::temp_dir()));
+        let config_service = create_test_config_service(db.clone());
+        let dsn_service = create_test_dsn_service(db.clone());
+        let external_service_manager = create_test_external_service_manager(db.clone());
+        let planner = WorkflowPlanner::new(
+            db.clone(),
+            log_service,
+            external_service_manager,
+            config_service,
+            dsn_service,
+            create_test_encryption_service(),
+        );
+
+        let (project, _environment, deployment) =
+            create_test_project(db.as_ref(), Preset::NextJs).await?;
+
+        let jobs = planner.create_deployment_jobs(deployment.id).await?;
+        assert!(!jobs.iter().any(|j| j.job_id == "purge_cdn"));
+
+        let mut active_project: projects::ActiveModel = project.into();
+        active_project.deployment_config = Set(Some(DeploymentConfig {
+            cdn_purge: Some(CdnPurgeConfig {
+                provider: CdnProvider::Fastly,
+                api_token: "token".to_string(),
+                service_id: Some("svc1".to_string()),
+                scope: CdnPurgeScope::Tags,
+                tags: vec!["html".to_string()],
+                ..Default::default()
+            }),
+            ..Default::default()
+        }));
+        active_project.update(db.as_ref()).await?;
+
+        let jobs = planner.create_deployment_jobs(deployment.id).await?;
+        let purge_job = jobs.iter().find(|j| j.job_id == "purge_cdn").unwrap();
+        let dependencies: Vec<String> =
+            serde_json::from_value(purge_job.dependencies.clone().unwrap()).unwrap();
+        assert_eq!(dependencies, vec!["mark_deployment_complete"]);
+        let config = purge_job.job_config.clone().unwrap();
+        assert_eq!(config["_required_for_completion"], false);
+        assert_eq!(config["deployment_id"], deployment.id);
+        // The job reads the purge config, API token included, when it runs
+        assert!(config.get("cdn_purge").is_none());
+
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_build_only_variables_stay_out_of_containers(
+    ) -> Result<(), Box<dyn std::error::Error>> {
+        use temps_entities::{env_var_environments, env_vars};
+
+        let test_db = TestDatabase::with_migrations().await?;
+        let db = test_db.connection_arc();
+        let log_service = Arc::new(LogService::new(std::env::temp_dir()));
+        let config_service = create_test_config_service(db.clone());
+        let dsn_service = create_test_dsn_service(db.clone());
+        let external_service_manager = create_test_external_service_manager(db.clone());
+        let planner = WorkflowPlanner::new(
+            db.clone(),
+            log_service,
+            external_service_manager,
+            config_service,
+            dsn_service,
+            create_test_encryption_service(),
+        );
+
+        let (project, environment, deployment) =
+            create_test_project(db.as_ref(), Preset::NextJs).await?;
+        for (key, value, build_only) in [
+            ("API_URL", "https://api.example.com", false),
+            ("NPM_TOKEN", "npm_secret", true),
+        ] {
+            let var = env_vars::ActiveModel {
+                project_id: Set(project.id),
+                key: Set(key.to_string()),
+                value: Set(value.to_string()),
+                build_only: Set(build_only),
+                include_in_preview: Set(true),
+                created_at: Set(Utc::now()),
+                updated_at: Set(Utc::now()),
+                ..Default::default()
+            }
+            .insert(db.as_ref())
+            .await?;
+            env_var_environments::ActiveModel {
+                env_var_id: Set(var.id),
+                environment_id: Set(environment.id),
+                created_at: Set(Utc::now()),
+                ..Default::default()
+            }
+            .insert(db.as_ref())
+            .await?;
+        }
+
+        let jobs = planner.create_deployment_jobs(deployment.id).await?;
+
+        let build_config = jobs
+            .iter()
+            .find(|j| j.job_id == "build_image")
+            .and_then(|j| j.job_config.clone())
+            .unwrap();
+        // Build-only secrets are resolved when the build starts, not stored with the job
+        assert!(build_config["build_args"].get("NPM_TOKEN").is_none());
+        assert_eq!(
+            build_config["build_args"]["API_URL"],
+            "https://api.example.com"
+        );
+        assert_eq!(
+            build_config["masked_build_args"],
+            serde_json::json!(["NPM_TOKEN"])
+        );
+
+        let deploy_config = jobs
+            .iter()
+            .find(|j| j.job_id == "deploy_container")
+            .and_then(|j| j.job_config.clone())
+            .unwrap();
+        let container_env = &deploy_config["environment_variables"];
+        assert_eq!(container_env["API_URL"], "https://api.example.com");
+        assert!(container_env.get("NPM_TOKEN").is_none());
+
+        Ok(())
+    }
+
     #[tokio::test]
     async fn test_log_id_format() -> Result<(), Box<dyn std::error::Error>> {
         let test_db = TestDatabase::with_migrations().await?;
@@ -92,6 +92,23 @@ pub async fn get_primary_port(docker: &Docker, image_name: &str) -> anyhow::Resu
     Ok(ports.first().copied())
 }
 
+/// Get the ID (content digest, e.g. "sha256:…") of a local Docker image
+///
+/// Unlike a tag, the ID always refers to exactly the same image, so it can be used to
+/// deploy the image again elsewhere.
+///
+/// # Returns
+/// * `Ok(Some(id))` - The image ID
+/// * `Ok(None)` - The image has no ID
+/// * `Err(anyhow::Error)` - If image inspection fails (e.g. the image was removed)
+pub async fn get_image_id(docker: &Docker, image_name: &str) -> anyhow::Result<Option<String>> {
+    let image_info = docker
+        .inspect_image(image_name)
+        .await
+        .map_err(|e| anyhow::anyhow!("Failed to inspect image {}: {}", image_name, e))?;
+    Ok(image_info.id)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -1 +1,2 @@
 pub mod docker_inspect;
+pub mod procfile;
@@ -0,0 +1,227 @@
+//! Procfile parsing
+//!
+//! A Procfile lists the process types of an app, one `name: command` per line, the
+//! way Heroku reads it. Every type runs in containers of the app's image: `web`
+//! gets the environment's traffic, the others (workers, schedulers) run beside it.
+
+use std::collections::BTreeMap;
+
+use temps_entities::deployment_config::{
+    is_valid_process_type, ProcessTypeConfig, MAX_PROCESS_TYPES, WEB_PROCESS_TYPE,
+};
+use temps_entities::deployments::ProcessType;
+
+/// File name of the Procfile, at the root of the build context
+pub const PROCFILE_NAME: &str = "Procfile";
+
+/// Parse the contents of a Procfile
+///
+/// Blank lines and `#` comments are skipped. Names must be unique.
+pub fn parse_procfile(contents: &str) -> Result<Vec<ProcessType>, String> {
+    let mut process_types: Vec<ProcessType> = Vec::new();
+    for (index, line) in contents.lines().enumerate() {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with('#') {
+            continue;
+        }
+        let (name, command) = line.split_once(':').ok_or_else(|| {
+            format!(
+                "Line {} of the Procfile isn't of the form 'name: command'",
+                index + 1
+            )
+        })?;
+        let (name, command) = (name.trim(), command.trim());
+        if !is_valid_process_type(name) {
+            return Err(format!(
+                "Invalid process type '{}' on line {} of the Procfile",
+                name,
+                index + 1
+            ));
+        }
+        if command.is_empty() {
+            return Err(format!("Process type '{}' has no command", name));
+        }
+        if process_types.iter().any(|process| process.name == name) {
+            return Err(format!("Process type '{}' is defined twice", name));
+        }
+        process_types.push(ProcessType {
+            name: name.to_string(),
+            command: command.to_string(),
+        });
+    }
+
+    let others = process_types
+        .iter()
+        .filter(|process| process.name != WEB_PROCESS_TYPE)
+        .count();
+    if others > MAX_PROCESS_TYPES {
+        return Err(format!(
+            "The Procfile defines {} process types besides web; at most {} are supported",
+            others, MAX_PROCESS_TYPES
+        ));
+    }
+    Ok(process_types)
+}
+
+/// A process type to run, with its containers
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ProcessPlan {
+    pub name: String,
+    /// Shell command of the process; None runs the image's own command
+    pub command: Option<String>,
+    pub replicas: u32,
+}
+
+impl ProcessPlan {
+    pub fn is_web(&self) -> bool {
+        self.name == WEB_PROCESS_TYPE
+    }
+
+    /// Command of the containers, run by a shell like Heroku does
+    pub fn container_command(&self) -> Option<Vec<String>> {
+        self.command
+            .as_ref()
+            .map(|command| vec!["/bin/sh".to_string(), "-c".to_string(), command.clone()])
+    }
+}
+
+/// The process types a deployment runs, `web` first
+///
+/// `web` always runs, with `web_replicas` containers and the image's command unless
+/// the Procfile or the configuration gives one. Other types come from the Procfile
+/// and from configured commands; a configured command replaces the Procfile's.
+pub fn plan_processes(
+    procfile: &[ProcessType],
+    web_replicas: u32,
+    configured: &BTreeMap<String, ProcessTypeConfig>,
+) -> Vec<ProcessPlan> {
+    let command_of = |name: &str| {
+        configured
+            .get(name)
+            .and_then(|config| config.command.clone())
+            .or_else(|| {
+                procfile
+                    .iter()
+                    .find(|process| process.name == name)
+                    .map(|process| process.command.clone())
+            })
+    };
+
+    let mut plans = vec![ProcessPlan {
+        name: WEB_PROCESS_TYPE.to_string(),
+        command: command_of(WEB_PROCESS_TYPE),
+        replicas: web_replicas,
+    }];
+
+    let mut names: Vec<&str> = procfile
+        .iter()
+        .map(|process| process.name.as_str())
+        .collect();
+    for (name, config) in configured {
+        if config.command.is_some() && !names.contains(&name.as_str()) {
+            names.push(name);
+        }
+    }
+    for name in names {
+        if name == WEB_PROCESS_TYPE {
+            continue;
+        }
+        let Some(command) = command_of(name) else {
+            continue;
+        };
+        plans.push(ProcessPlan {
+            name: name.to_string(),
+            command: Some(command),
+            replicas: configured
+                .get(name)
+                .map(ProcessTypeConfig::replicas)
+                .unwrap_or(1),
+        });
+    }
+    plans
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const PROCFILE: &str = "\
+# Processes of the app
+web: bundle exec puma -C config/puma.rb
+
+worker:   bundle exec sidekiq
+release: bin/rails db:migrate
+";
+
+    #[test]
+    fn parses_process_types() {
+        let process_types = parse_procfile(PROCFILE).unwrap();
+        assert_eq!(process_types.len(), 3);
+        assert_eq!(process_types[0].name, "web");
+        assert_eq!(
+            process_types[0].command,
+            "bundle exec puma -C config/puma.rb"
+        );
+        assert_eq!(process_types[1].command, "bundle exec sidekiq");
+    }
+
+    #[test]
+    fn rejects_malformed_procfiles() {
+        assert!(parse_procfile("web bundle exec puma").is_err());
+        assert!(parse_procfile("web:").is_err());
+        assert!(parse_procfile("my worker: run").is_err());
+        assert!(parse_procfile("worker: a\nworker: b").is_err());
+    }
+
+    #[test]
+    fn plans_web_and_workers() {
+        let process_types = parse_procfile(PROCFILE).unwrap();
+        let configured: BTreeMap<String, ProcessTypeConfig> =
+            serde_json::from_value(serde_json::json!({
+                "worker": { "replicas": 3 },
+                "release": { "replicas": 0 },
+                "clock": { "command": "bin/clock" }
+            }))
+            .unwrap();
+
+        let plans = plan_processes(&process_types, 2, &configured);
+        let summary: Vec<(&str, u32)> = plans
+            .iter()
+            .map(|plan| (plan.name.as_str(), plan.replicas))
+            .collect();
+        assert_eq!(
+            summary,
+            vec![("web", 2), ("worker", 3), ("release", 0), ("clock", 1)]
+        );
+        assert_eq!(
+            plans[0].container_command().unwrap(),
+            vec!["/bin/sh", "-c", "bundle exec puma -C config/puma.rb"]
+        );
+    }
+
+    #[test]
+    fn configured_command_replaces_procfile() {
+        let process_types = parse_procfile("web: npm start\nworker: node worker.js").unwrap();
+        let configured: BTreeMap<String, ProcessTypeConfig> =
+            serde_json::from_value(serde_json::json!({
+                "web": { "command": "node server.js" },
+                "worker": { "command": "node worker.js --queue=mail" }
+            }))
+            .unwrap();
+
+        let plans = plan_processes(&process_types, 1, &configured);
+        assert_eq!(plans[0].command.as_deref(), Some("node server.js"));
+        assert_eq!(
+            plans[1].command.as_deref(),
+            Some("node worker.js --queue=mail")
+        );
+    }
+
+    #[test]
+    fn without_procfile_web_runs_the_image_command() {
+        let plans = plan_processes(&[], 1, &BTreeMap::new());
+        assert_eq!(plans.len(), 1);
+        assert!(plans[0].is_web());
+        assert_eq!(plans[0].container_command(), None);
+    }
+}
@@ -160,6 +160,7 @@ cron:
         .map(|job| CronConfig {
             path: job.path.clone(),
             schedule: job.schedule.clone(),
+            timezone: job.timezone.clone(),
         })
         .collect();
 
@@ -178,6 +179,7 @@ fn test_noop_cron_service() {
     let configs = vec![CronConfig {
         path: "/test".to_string(),
         schedule: "* * * * *".to_string(),
+        timezone: None,
     }];
 
     // Should succeed without doing anything
@@ -192,10 +194,12 @@ async fn test_mock_cron_service_success() {
         CronConfig {
             path: "/api/cron/task1".to_string(),
             schedule: "0 0 * * *".to_string(),
+            timezone: None,
         },
         CronConfig {
             path: "/api/cron/task2".to_string(),
             schedule: "0 12 * * *".to_string(),
+            timezone: None,
         },
     ];
 
@@ -214,6 +218,7 @@ async fn test_mock_cron_service_failure() {
     let configs = vec![CronConfig {
         path: "/api/cron/task".to_string(),
         schedule: "0 0 * * *".to_string(),
+        timezone: None,
     }];
 
     let result = service.configure_crons(1, 1, configs).await;
@@ -280,6 +285,7 @@ cron: []
         .map(|job| CronConfig {
             path: job.path.clone(),
             schedule: job.schedule.clone(),
+            timezone: job.timezone.clone(),
         })
         .collect();
 
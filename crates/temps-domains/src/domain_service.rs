@@ -10,8 +10,8 @@ use thiserror::Error;
 use tracing::{debug, error, info, warn};
 
 use crate::tls::{
-    CertificateProvider, CertificateRepository, ChallengeType, ProvisioningResult, RepositoryError,
-    TlsError,
+    validate_uploaded_certificate, AcmeDirectory, CertificateProvider, CertificateRepository,
+    ChallengeType, ProvisioningResult, RepositoryError, TlsError, UPLOADED_VERIFICATION_METHOD,
 };
 
 #[derive(Error, Debug)]
@@ -24,6 +24,8 @@ pub enum DomainServiceError {
     InvalidDomain(String),
     #[error("Challenge error: {0}")]
     Challenge(String),
+    #[error("Invalid certificate: {0}")]
+    InvalidCertificate(String),
     #[error("TLS error: {0}")]
     Tls(#[from] TlsError),
     #[error("Provider error: {0}")]
@@ -57,10 +59,14 @@ impl DomainService {
     }
 
     /// Step 1: Create a domain record in the database
+    ///
+    /// `acme_directory` is "production", "staging" or a directory URL; None uses the
+    /// server default.
     pub async fn create_domain(
         &self,
         domain_name: &str,
         challenge_type: &str,
+        acme_directory: Option<&str>,
     ) -> Result<domains::Model, DomainServiceError> {
         info!(
             "Creating domain: {} with challenge type: {}",
@@ -75,6 +81,14 @@ impl DomainService {
             )));
         }
 
+        let acme_directory = acme_directory
+            .map(|directory| {
+                AcmeDirectory::parse(directory)
+                    .map(|directory| directory.key().to_string())
+                    .map_err(DomainServiceError::InvalidDomain)
+            })
+            .transpose()?;
+
         // Validate challenge type
         let verification_method = match challenge_type {
             "http-01" | "dns-01" => challenge_type.to_string(),
@@ -105,6 +119,7 @@ impl DomainService {
             status: Set("pending".to_string()),
             is_wildcard: Set(domain_name.starts_with("*.")),
             verification_method: Set(verification_method),
+            acme_directory: Set(acme_directory),
             dns_challenge_token: Set(None),
             dns_challenge_value: Set(None),
             http_challenge_token: Set(None),
@@ -127,6 +142,54 @@ impl DomainService {
         Ok(domain)
     }
 
+    /// Change the ACME directory the domain's certificates are requested from
+    ///
+    /// Takes effect on the next provisioning or renewal. A pending order belongs to
+    /// the previous directory's account, so it is dropped.
+    pub async fn set_acme_directory(
+        &self,
+        domain_id: i32,
+        acme_directory: Option<&str>,
+    ) -> Result<domains::Model, DomainServiceError> {
+        let acme_directory = acme_directory
+            .map(|directory| {
+                AcmeDirectory::parse(directory)
+                    .map(|directory| directory.key().to_string())
+                    .map_err(DomainServiceError::InvalidDomain)
+            })
+            .transpose()?;
+
+        let domain = domains::Entity::find_by_id(domain_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| DomainServiceError::NotFound(domain_id.to_string()))?;
+
+        if domain.acme_directory == acme_directory {
+            return Ok(domain);
+        }
+
+        if let Some(existing_order) = self.repository.find_acme_order_by_domain(domain.id).await? {
+            info!(
+                "Deleting ACME order for domain: {} after changing its ACME directory",
+                domain.domain
+            );
+            self.repository
+                .delete_acme_order(&existing_order.order_url)
+                .await?;
+        }
+
+        info!(
+            "ACME directory for domain {} set to {}",
+            domain.domain,
+            acme_directory.as_deref().unwrap_or("the server default")
+        );
+
+        let mut domain_active: domains::ActiveModel = domain.into();
+        domain_active.acme_directory = Set(acme_directory);
+        domain_active.updated_at = Set(Utc::now());
+        Ok(domain_active.update(self.db.as_ref()).await?)
+    }
+
     /// Step 2: Request a Let's Encrypt challenge for the domain
     pub async fn request_challenge(
         &self,
@@ -152,6 +215,13 @@ impl DomainService {
             .await?
             .ok_or_else(|| DomainServiceError::NotFound(domain_name.to_string()))?;
 
+        if domain.verification_method == UPLOADED_VERIFICATION_METHOD {
+            return Err(DomainServiceError::Challenge(format!(
+                "Domain {} serves an uploaded certificate; upload a new one to replace it",
+                domain_name
+            )));
+        }
+
         // Clean up any existing order for this domain (important for renewals)
         // This ensures we always start fresh with a new challenge
         if let Some(existing_order) = self.repository.find_acme_order_by_domain(domain.id).await? {
@@ -477,6 +547,83 @@ impl DomainService {
         }
     }
 
+    /// Store a certificate issued outside of ACME for the domain
+    ///
+    /// The domain is created if it doesn't exist yet. The proxy serves the uploaded
+    /// certificate from then on and ACME is no longer attempted for it; it isn't
+    /// renewed, so uploading a new certificate is the only way to replace it.
+    pub async fn upload_certificate(
+        &self,
+        domain_name: &str,
+        certificate_pem: &str,
+        private_key_pem: &str,
+        chain_pem: Option<&str>,
+    ) -> Result<domains::Model, DomainServiceError> {
+        if !self.is_valid_domain(domain_name) {
+            return Err(DomainServiceError::InvalidDomain(format!(
+                "Invalid domain format: {}",
+                domain_name
+            )));
+        }
+
+        let uploaded =
+            validate_uploaded_certificate(domain_name, certificate_pem, private_key_pem, chain_pem)
+                .map_err(DomainServiceError::InvalidCertificate)?;
+
+        let encrypted_private_key = self
+            .encryption_service
+            .encrypt_string(&uploaded.private_key_pem)
+            .map_err(|e| {
+                DomainServiceError::Internal(format!("Failed to encrypt private key: {}", e))
+            })?;
+
+        let existing = domains::Entity::find()
+            .filter(domains::Column::Domain.eq(domain_name))
+            .one(self.db.as_ref())
+            .await?;
+
+        let mut domain_active = match &existing {
+            Some(domain) => {
+                // A pending ACME order would otherwise replace the upload when completed
+                if let Some(order) = self.repository.find_acme_order_by_domain(domain.id).await? {
+                    self.repository.delete_acme_order(&order.order_url).await?;
+                }
+                domain.clone().into()
+            }
+            None => domains::ActiveModel {
+                domain: Set(domain_name.to_string()),
+                is_wildcard: Set(domain_name.starts_with("*.")),
+                ..Default::default()
+            },
+        };
+        domain_active.status = Set("active".to_string());
+        domain_active.verification_method = Set(UPLOADED_VERIFICATION_METHOD.to_string());
+        domain_active.certificate = Set(Some(uploaded.certificate_pem));
+        domain_active.private_key = Set(Some(encrypted_private_key));
+        domain_active.expiration_time = Set(Some(uploaded.expiration_time));
+        domain_active.last_renewed = Set(Some(Utc::now()));
+        domain_active.dns_challenge_token = Set(None);
+        domain_active.dns_challenge_value = Set(None);
+        domain_active.http_challenge_token = Set(None);
+        domain_active.http_challenge_key_authorization = Set(None);
+        domain_active.last_error = Set(None);
+        domain_active.last_error_type = Set(None);
+
+        let domain = if existing.is_some() {
+            domain_active.update(self.db.as_ref()).await?
+        } else {
+            domain_active.insert(self.db.as_ref()).await?
+        };
+
+        info!(
+            "Uploaded certificate for {} (covers {}), expires {}",
+            domain_name,
+            uploaded.names.join(", "),
+            uploaded.expiration_time
+        );
+        Ok(domain)
+    }
+
     /// Get domain by name
     pub async fn get_domain(
         &self,
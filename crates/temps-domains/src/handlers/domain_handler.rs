@@ -3,7 +3,7 @@ use super::types::{
     DnsChallengeRecordResult, DnsCompletionResponse, DomainAppState, DomainChallengeResponse,
     DomainError, DomainResponse, HttpChallengeDebugResponse, ListDomainsResponse,
     ListOrdersResponse, ProvisionResponse, SetupDnsChallengeRequest, SetupDnsChallengeResponse,
-    TxtRecord,
+    TxtRecord, UpdateAcmeDirectoryRequest, UploadCertificateRequest,
 };
 use crate::tls::{ProviderError, RepositoryError, TlsError};
 use crate::DomainServiceError;
@@ -15,7 +15,7 @@ use axum::{
     extract::{Path, State},
     http::StatusCode,
     response::IntoResponse,
-    routing::{delete, get, post},
+    routing::{delete, get, post, put},
     Json, Router,
 };
 use std::sync::Arc;
@@ -160,6 +160,12 @@ impl From<DomainServiceError> for Problem {
                 .title("Challenge Error")
                 .detail(msg)
                 .build(),
+            DomainServiceError::InvalidCertificate(msg) => {
+                ErrorBuilder::new(StatusCode::BAD_REQUEST)
+                    .title("Invalid Certificate")
+                    .detail(msg)
+                    .build()
+            }
             DomainServiceError::Tls(e) => Problem::from(e),
             DomainServiceError::Provider(e) => Problem::from(e),
             DomainServiceError::Repository(e) => Problem::from(e),
@@ -185,9 +191,11 @@ impl From<DomainServiceError> for Problem {
         finalize_order,
         list_domains,
         renew_domain,
+        upload_certificate,
         get_challenge_token,
         create_or_recreate_order,
         cancel_domain_order,
+        update_acme_directory,
         get_domain_order,
         list_orders,
         get_http_challenge_debug,
@@ -210,7 +218,9 @@ impl From<DomainServiceError> for Problem {
             ChallengeError,
             SetupDnsChallengeRequest,
             SetupDnsChallengeResponse,
-            DnsChallengeRecordResult
+            DnsChallengeRecordResult,
+            UpdateAcmeDirectoryRequest,
+            UploadCertificateRequest
         )
     ),
     info(
@@ -278,7 +288,11 @@ async fn create_domain(
     // Step 1: Create the domain in the database
     let domain = app_state
         .domain_service
-        .create_domain(&request.domain, &request.challenge_type)
+        .create_domain(
+            &request.domain,
+            &request.challenge_type,
+            request.acme_directory.as_deref(),
+        )
         .await
         .map_err(|e| {
             error!("Failed to create domain {}: {}", request.domain, e);
@@ -744,6 +758,53 @@ async fn cancel_domain_order(
     Ok((StatusCode::OK, Json(DomainResponse::from(domain))))
 }
 
+/// Change the ACME directory of a domain
+///
+/// Switches the domain between Let's Encrypt production and staging, or to another
+/// ACME CA by directory URL. Takes effect on the next provisioning or renewal; a
+/// pending order is dropped, since it belongs to the previous directory.
+#[utoipa::path(
+    put,
+    path = "/domains/{domain_id}/acme-directory",
+    request_body = UpdateAcmeDirectoryRequest,
+    responses(
+        (status = 200, description = "ACME directory updated", body = DomainResponse),
+        (status = 400, description = "Invalid ACME directory"),
+        (status = 401, description = "Unauthorized"),
+        (status = 404, description = "Domain not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("domain_id" = i32, Path, description = "Domain ID")
+    ),
+    tag = "Domains",
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn update_acme_directory(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<DomainAppState>>,
+    Path(domain_id): Path<i32>,
+    Json(request): Json<UpdateAcmeDirectoryRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DomainsWrite);
+
+    let domain = app_state
+        .domain_service
+        .set_acme_directory(domain_id, request.acme_directory.as_deref())
+        .await
+        .map_err(|e| {
+            error!(
+                "Failed to update ACME directory for domain ID {}: {}",
+                domain_id, e
+            );
+            e
+        })?;
+
+    Ok((StatusCode::OK, Json(DomainResponse::from(domain))))
+}
+
 /// Delete a domain
 #[utoipa::path(
     delete,
@@ -903,6 +964,62 @@ async fn renew_domain(
     }
 }
 
+/// Upload a certificate for a domain
+///
+/// Serves a certificate issued outside of ACME (a corporate CA, an EV certificate)
+/// for the domain, creating the domain if needed. The private key must match the
+/// certificate, and the certificate must be valid and cover the domain. Uploaded
+/// certificates are not renewed automatically; a notification is sent before they
+/// expire.
+#[utoipa::path(
+    post,
+    path = "/domains/{domain}/certificate",
+    request_body = UploadCertificateRequest,
+    responses(
+        (status = 200, description = "Certificate uploaded", body = DomainResponse),
+        (status = 400, description = "Invalid certificate or private key"),
+        (status = 401, description = "Unauthorized"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("domain" = String, Path, description = "Domain name")
+    ),
+    tag = "Domains",
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+async fn upload_certificate(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<DomainAppState>>,
+    Path(domain): Path<String>,
+    Json(request): Json<UploadCertificateRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, DomainsWrite);
+
+    info!(
+        "Uploading certificate for domain: {} for user: {}",
+        domain,
+        auth.user_id()
+    );
+
+    let uploaded = app_state
+        .domain_service
+        .upload_certificate(
+            &domain,
+            &request.certificate,
+            &request.private_key,
+            request.certificate_chain.as_deref(),
+        )
+        .await
+        .map_err(|e| {
+            error!("Failed to upload certificate for {}: {}", domain, e);
+            e
+        })?;
+
+    Ok((StatusCode::OK, Json(DomainResponse::from(uploaded))))
+}
+
 /// Get domain challenge details
 #[utoipa::path(
     get,
@@ -1225,12 +1342,18 @@ async fn get_domain_order(
     // Convert order to response
     let mut response = AcmeOrderResponse::from(order.clone());
 
-    // Fetch live challenge validation status from Let's Encrypt
-    if let Ok(Some(challenge_json)) = app_state
-        .tls_service
-        .get_live_challenge_status(&order.order_url, &order.email)
-        .await
-    {
+    // Fetch live challenge validation status from the domain's ACME directory
+    let domain = app_state.domain_service.get_domain_by_id(domain_id).await?;
+    let challenge_status = match domain {
+        Some(domain) => {
+            app_state
+                .tls_service
+                .get_live_challenge_status(&domain.domain, &order.order_url, &order.email)
+                .await
+        }
+        None => Ok(None),
+    };
+    if let Ok(Some(challenge_json)) = challenge_status {
         // Convert JSON to ChallengeValidationStatus
         if let Ok(challenge_status) =
             serde_json::from_value::<ChallengeValidationStatus>(challenge_json)
@@ -1649,6 +1772,7 @@ pub fn configure_routes() -> Router<Arc<DomainAppState>> {
         .route("/domains/{domain}", delete(delete_domain))
         .route("/domains/{domain}/provision", post(provision_domain))
         .route("/domains/{domain}/renew", post(renew_domain))
+        .route("/domains/{domain}/certificate", post(upload_certificate))
         .route("/domains/{domain}/challenge", get(get_domain_challenge))
         .route("/domains/{domain}/dns-completion", get(get_dns_completion))
         .route(
@@ -1664,6 +1788,10 @@ pub fn configure_routes() -> Router<Arc<DomainAppState>> {
         .route("/domains/{domain_id}/order", get(get_domain_order))
         .route("/domains/{domain_id}/order", delete(cancel_domain_order))
         .route("/domains/{domain_id}/order/finalize", post(finalize_order))
+        .route(
+            "/domains/{domain_id}/acme-directory",
+            put(update_acme_directory),
+        )
         // DNS challenge auto-provisioning
         .route("/domains/{domain_id}/setup-dns", post(setup_dns_challenge))
         .route("/orders", get(list_orders))
@@ -46,6 +46,30 @@ pub struct CreateDomainRequest {
     /// Challenge type for Let's Encrypt validation. Options: "http-01" (default) or "dns-01"
     #[serde(default = "default_challenge_type")]
     pub challenge_type: String,
+    /// ACME directory to request certificates from: "production", "staging" or a
+    /// directory URL of another ACME CA. Defaults to the server setting.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "staging")]
+    pub acme_directory: Option<String>,
+}
+
+/// Certificate issued outside of ACME, e.g. by a corporate CA
+#[derive(Serialize, Deserialize, ToSchema)]
+pub struct UploadCertificateRequest {
+    /// PEM-encoded certificate, optionally followed by its chain
+    pub certificate: String,
+    /// PEM-encoded private key of the certificate
+    pub private_key: String,
+    /// PEM-encoded intermediate certificates, if not included in `certificate`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub certificate_chain: Option<String>,
+}
+
+#[derive(Serialize, Deserialize, ToSchema)]
+pub struct UpdateAcmeDirectoryRequest {
+    /// "production", "staging" or a directory URL; null goes back to the server default
+    #[schema(example = "staging")]
+    pub acme_directory: Option<String>,
 }
 
 fn default_challenge_type() -> String {
@@ -65,6 +89,8 @@ pub struct DomainResponse {
     pub last_error_type: Option<String>,
     pub is_wildcard: bool,
     pub verification_method: String,
+    /// ACME directory set on the domain; None uses the server default
+    pub acme_directory: Option<String>,
     pub created_at: i64,
     pub updated_at: i64,
     /// The PEM-encoded certificate chain (can be displayed in browser or downloaded)
@@ -123,6 +149,7 @@ impl From<temps_entities::domains::Model> for DomainResponse {
             last_error_type: domain.last_error_type,
             is_wildcard: domain.is_wildcard,
             verification_method: domain.verification_method,
+            acme_directory: domain.acme_directory,
             created_at: domain.created_at.timestamp_millis(),
             updated_at: domain.updated_at.timestamp_millis(),
             certificate: domain.certificate,
@@ -163,6 +190,7 @@ impl From<crate::tls::models::Certificate> for DomainResponse {
             last_error_type,
             is_wildcard: cert.is_wildcard,
             verification_method: cert.verification_method,
+            acme_directory: None,
             created_at: chrono::Utc::now().timestamp_millis(),
             updated_at: chrono::Utc::now().timestamp_millis(),
             certificate: Some(cert.certificate_pem),
@@ -5,13 +5,15 @@ use std::sync::Arc;
 use temps_core::plugin::{
     PluginContext, PluginError, PluginRoutes, ServiceRegistrationContext, TempsPlugin,
 };
+use temps_core::{Job, JobQueue, JobReceiver};
 use tracing;
 use utoipa::openapi::OpenApi;
 use utoipa::OpenApi as OpenApiTrait;
 
 use crate::{
     handlers::{self, create_domain_app_state_with_dns, DomainAppState},
-    tls::{repository::DefaultCertificateRepository, TlsServiceBuilder},
+    tls::{repository::DefaultCertificateRepository, CertificateRepository, TlsServiceBuilder},
+    DomainService,
 };
 use rustls::crypto::CryptoProvider;
 use temps_dns::services::DnsProviderService;
@@ -23,6 +25,98 @@ impl DomainsPlugin {
     pub fn new() -> Self {
         Self
     }
+
+    /// Provision the certificates other services ask for, such as those of the
+    /// hostnames environments are assigned
+    async fn process_jobs(
+        mut receiver: Box<dyn JobReceiver>,
+        domain_service: Arc<DomainService>,
+        repository: Arc<dyn CertificateRepository>,
+        config_service: Arc<temps_config::ConfigService>,
+    ) {
+        loop {
+            match receiver.recv().await {
+                Ok(Job::ProvisionCertificate(job)) => {
+                    match Self::provision_certificate(
+                        &job.domain,
+                        &domain_service,
+                        repository.as_ref(),
+                        &config_service,
+                    )
+                    .await
+                    {
+                        Ok(()) => tracing::info!("Certificate for {} is in place", job.domain),
+                        Err(e) => tracing::warn!(
+                            "Couldn't provision a certificate for {}: {}",
+                            job.domain,
+                            e
+                        ),
+                    }
+                }
+                Ok(_) => {}
+                Err(e) => {
+                    tracing::error!("Error receiving job: {:?}", e);
+                    tokio::time::sleep(tokio::time::Duration::from_secs(1)).await;
+                }
+            }
+        }
+    }
+
+    /// Issue a Let's Encrypt certificate for the domain over HTTP-01, unless one
+    /// (including a wildcard one) already covers it
+    ///
+    /// The domain is created if it doesn't exist yet, so when provisioning fails it
+    /// can be retried from the domains page.
+    async fn provision_certificate(
+        domain: &str,
+        domain_service: &DomainService,
+        repository: &dyn CertificateRepository,
+        config_service: &temps_config::ConfigService,
+    ) -> Result<(), String> {
+        if repository
+            .find_certificate_for_sni(domain)
+            .await
+            .map_err(|e| e.to_string())?
+            .is_some()
+        {
+            return Ok(());
+        }
+
+        if domain_service
+            .get_domain(domain)
+            .await
+            .map_err(|e| e.to_string())?
+            .is_none()
+        {
+            domain_service
+                .create_domain(domain, "http-01", None)
+                .await
+                .map_err(|e| e.to_string())?;
+        }
+
+        let email = config_service
+            .get_settings()
+            .await
+            .map_err(|e| e.to_string())?
+            .letsencrypt
+            .email
+            .filter(|email| !email.trim().is_empty())
+            .ok_or_else(|| {
+                "no Let's Encrypt email is configured; set one in the settings or provision it from the domains page".to_string()
+            })?;
+
+        let challenge = domain_service
+            .request_challenge(domain, &email)
+            .await
+            .map_err(|e| e.to_string())?;
+        if challenge.status != "completed" {
+            domain_service
+                .complete_challenge(domain, &email)
+                .await
+                .map_err(|e| e.to_string())?;
+        }
+        Ok(())
+    }
 }
 
 impl Default for DomainsPlugin {
@@ -54,10 +148,13 @@ impl TempsPlugin for DomainsPlugin {
 
             // Create certificate provider
             // Email will be provided at runtime from the authenticated user
-            // Environment is controlled by LETSENCRYPT_MODE env var (default: "production")
-            let cert_provider = Arc::new(crate::tls::providers::LetsEncryptProvider::new(
-                repository.clone(),
-            ));
+            // The ACME directory is set per domain, defaulting to the letsencrypt settings
+            // (LETSENCRYPT_MODE and ACME_DIRECTORY_URL env vars override them)
+            let config_service = context.require_service::<temps_config::ConfigService>();
+            let cert_provider = Arc::new(
+                crate::tls::providers::LetsEncryptProvider::new(repository.clone())
+                    .with_config_service(config_service.clone()),
+            );
 
             // Try to get notification service (optional)
             let notification_service =
@@ -100,6 +197,20 @@ impl TempsPlugin for DomainsPlugin {
                 encryption_service.clone(),
             ));
 
+            // Certificates requested through the queue, such as those of the hostnames
+            // environments get from domain patterns
+            if let Some(queue_service) = context.get_service::<dyn JobQueue>() {
+                let job_receiver = queue_service.subscribe();
+                let domain_service = domain_service.clone();
+                let repository = repository.clone();
+                tokio::spawn(Self::process_jobs(
+                    job_receiver,
+                    domain_service,
+                    repository,
+                    config_service,
+                ));
+            }
+
             // Get DnsProviderService (requires dns plugin to be registered first)
             let dns_provider_service = context.require_service::<DnsProviderService>();
 
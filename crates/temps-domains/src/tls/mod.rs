@@ -3,13 +3,17 @@ pub mod models;
 pub mod providers;
 pub mod repository;
 pub mod service;
+pub mod upload;
 
 // Re-export main types
 pub use errors::{BuilderError, ProviderError, RepositoryError, TlsError};
 pub use models::{
-    AcmeAccount, Certificate, CertificateFilter, CertificateStatus, ChallengeData,
+    AcmeAccount, AcmeDirectory, Certificate, CertificateFilter, CertificateStatus, ChallengeData,
     ChallengeStrategy, ChallengeType, DnsChallengeData, ProvisioningResult, ValidationResult,
 };
 pub use providers::{CertificateProvider, LetsEncryptProvider};
 pub use repository::{CertificateRepository, DefaultCertificateRepository};
 pub use service::{TlsService, TlsServiceBuilder};
+pub use upload::{
+    validate_uploaded_certificate, UploadedCertificate, UPLOADED_VERIFICATION_METHOD,
+};
@@ -54,11 +54,82 @@ pub struct CertificateFilter {
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct AcmeAccount {
     pub email: String,
+    /// Key of the directory the account is registered with, see [`AcmeDirectory::key`]
     pub environment: String,
+    pub directory_url: String,
     pub credentials: String,
     pub created_at: UtcDateTime,
 }
 
+/// ACME directory certificates are requested from
+///
+/// Accounts are registered per directory, so switching a domain to staging or to
+/// another CA registers a new account there instead of reusing the production one.
+#[derive(Debug, Clone, PartialEq, Eq, Default)]
+pub enum AcmeDirectory {
+    #[default]
+    LetsEncryptProduction,
+    /// Untrusted certificates with much higher rate limits, for testing setups
+    LetsEncryptStaging,
+    /// Any other ACME CA, e.g. ZeroSSL or an internal CA, by directory URL
+    Custom(String),
+}
+
+impl AcmeDirectory {
+    /// Parse "production", "staging" or a directory URL
+    pub fn parse(value: &str) -> Result<Self, String> {
+        let value = value.trim();
+        match value {
+            "" | "production" => return Ok(Self::LetsEncryptProduction),
+            "staging" => return Ok(Self::LetsEncryptStaging),
+            _ => {}
+        }
+        if value == instant_acme::LetsEncrypt::Production.url() {
+            return Ok(Self::LetsEncryptProduction);
+        }
+        if value == instant_acme::LetsEncrypt::Staging.url() {
+            return Ok(Self::LetsEncryptStaging);
+        }
+
+        let url = url::Url::parse(value)
+            .map_err(|e| format!("Invalid ACME directory URL '{}': {}", value, e))?;
+        if url.scheme() != "https" || url.host_str().is_none() {
+            return Err(format!(
+                "ACME directory must be 'production', 'staging' or an https:// URL, got '{}'",
+                value
+            ));
+        }
+        Ok(Self::Custom(value.to_string()))
+    }
+
+    /// Value stored in settings and on the domain, and the account key
+    pub fn key(&self) -> &str {
+        match self {
+            Self::LetsEncryptProduction => "production",
+            Self::LetsEncryptStaging => "staging",
+            Self::Custom(url) => url,
+        }
+    }
+
+    pub fn url(&self) -> &str {
+        match self {
+            Self::LetsEncryptProduction => instant_acme::LetsEncrypt::Production.url(),
+            Self::LetsEncryptStaging => instant_acme::LetsEncrypt::Staging.url(),
+            Self::Custom(url) => url,
+        }
+    }
+
+    pub fn is_staging(&self) -> bool {
+        matches!(self, Self::LetsEncryptStaging)
+    }
+}
+
+impl std::fmt::Display for AcmeDirectory {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(self.key())
+    }
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct AcmeOrder {
     pub id: i32,
@@ -272,6 +343,30 @@ mod tests {
     use super::*;
     use chrono::Duration;
 
+    #[test]
+    fn test_acme_directory_parse() {
+        assert_eq!(
+            AcmeDirectory::parse("").unwrap(),
+            AcmeDirectory::LetsEncryptProduction
+        );
+        assert_eq!(
+            AcmeDirectory::parse("staging").unwrap(),
+            AcmeDirectory::LetsEncryptStaging
+        );
+        assert_eq!(
+            AcmeDirectory::parse("https://acme-staging-v02.api.letsencrypt.org/directory").unwrap(),
+            AcmeDirectory::LetsEncryptStaging
+        );
+
+        let zerossl = AcmeDirectory::parse(" https://acme.zerossl.com/v2/DV90 ").unwrap();
+        assert_eq!(zerossl.key(), "https://acme.zerossl.com/v2/DV90");
+        assert_eq!(zerossl.url(), zerossl.key());
+        assert!(!zerossl.is_staging());
+
+        assert!(AcmeDirectory::parse("http://ca.internal/acme/directory").is_err());
+        assert!(AcmeDirectory::parse("testing").is_err());
+    }
+
     #[test]
     fn test_certificate_expiry() {
         let mut cert = Certificate {
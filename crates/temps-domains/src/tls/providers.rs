@@ -1,14 +1,14 @@
 use async_trait::async_trait;
 use chrono::{TimeZone, Utc};
 use instant_acme::{
-    Account, AccountCredentials, ChallengeType as AcmeChallengeType, Identifier, NewAccount,
-    NewOrder, Order, OrderStatus,
+    Account, AccountCredentials, ChallengeType as AcmeChallengeType, ExternalAccountKey,
+    Identifier, NewAccount, NewOrder, Order, OrderStatus,
 };
 use rcgen::{CertificateParams, DistinguishedName, KeyPair};
 use serde_json;
 use std::sync::Arc;
 use temps_core::UtcDateTime;
-use tracing::{debug, error, info};
+use tracing::{debug, error, info, warn};
 
 use super::errors::ProviderError;
 use super::models::*;
@@ -45,46 +45,111 @@ pub trait CertificateProvider: Send + Sync {
 
 pub struct LetsEncryptProvider {
     repository: Arc<dyn CertificateRepository>,
-    environment: String,
+    config_service: Option<Arc<temps_config::ConfigService>>,
 }
 
 impl LetsEncryptProvider {
     pub fn new(repository: Arc<dyn CertificateRepository>) -> Self {
-        // Read environment from LETSENCRYPT_MODE env var, default to "production"
-        let environment =
-            std::env::var("LETSENCRYPT_MODE").unwrap_or_else(|_| "production".to_string());
-
         Self {
             repository,
-            environment,
+            config_service: None,
         }
     }
 
-    fn get_acme_url(&self) -> String {
-        // Allow custom ACME directory URL for testing (e.g., Pebble)
+    /// Read the default ACME directory and external account bindings from the settings
+    pub fn with_config_service(mut self, config_service: Arc<temps_config::ConfigService>) -> Self {
+        self.config_service = Some(config_service);
+        self
+    }
+
+    /// Directory used for domains that don't set their own
+    ///
+    /// ACME_DIRECTORY_URL (e.g. a local Pebble) and LETSENCRYPT_MODE take precedence
+    /// over the `letsencrypt.environment` setting.
+    async fn default_directory(&self) -> AcmeDirectory {
         if let Ok(custom_url) = std::env::var("ACME_DIRECTORY_URL") {
-            return custom_url;
+            return AcmeDirectory::Custom(custom_url);
         }
 
-        if self.environment == "production" {
-            instant_acme::LetsEncrypt::Production.url().to_string()
-        } else {
-            instant_acme::LetsEncrypt::Staging.url().to_string()
+        if let Ok(mode) = std::env::var("LETSENCRYPT_MODE") {
+            return AcmeDirectory::parse(&mode).unwrap_or(AcmeDirectory::LetsEncryptStaging);
+        }
+
+        if let Some(config_service) = &self.config_service {
+            match config_service.get_settings().await {
+                Ok(settings) => match AcmeDirectory::parse(&settings.letsencrypt.environment) {
+                    Ok(directory) => return directory,
+                    Err(e) => warn!("Ignoring letsencrypt.environment setting: {}", e),
+                },
+                Err(e) => warn!("Could not load settings for the ACME directory: {}", e),
+            }
         }
+
+        AcmeDirectory::default()
+    }
+
+    /// Directory the domain's certificates are requested from
+    pub async fn directory_for(&self, domain: &str) -> Result<AcmeDirectory, ProviderError> {
+        match self.repository.find_acme_directory(domain).await? {
+            Some(directory) => {
+                AcmeDirectory::parse(&directory).map_err(ProviderError::Configuration)
+            }
+            None => Ok(self.default_directory().await),
+        }
+    }
+
+    /// External account binding configured for the directory, if any
+    async fn external_account_key(
+        &self,
+        directory: &AcmeDirectory,
+    ) -> Result<Option<ExternalAccountKey>, ProviderError> {
+        use base64::{engine::general_purpose::URL_SAFE_NO_PAD, Engine as _};
+
+        let Some(config_service) = &self.config_service else {
+            return Ok(None);
+        };
+        let settings = config_service
+            .get_settings()
+            .await
+            .map_err(|e| ProviderError::Configuration(format!("Failed to load settings: {}", e)))?;
+
+        let Some(account) = settings
+            .letsencrypt
+            .external_accounts
+            .iter()
+            .find(|account| account.directory_url.trim() == directory.url())
+        else {
+            return Ok(None);
+        };
+
+        let hmac_key = URL_SAFE_NO_PAD
+            .decode(account.hmac_key.trim().trim_end_matches('='))
+            .map_err(|e| {
+                ProviderError::Configuration(format!(
+                    "Invalid HMAC key for external account {}: {}",
+                    account.key_id, e
+                ))
+            })?;
+
+        Ok(Some(ExternalAccountKey::new(
+            account.key_id.clone(),
+            &hmac_key,
+        )))
     }
 
     async fn get_or_create_acme_account(
         &self,
         email: &str,
+        directory: &AcmeDirectory,
     ) -> Result<(Account, AccountCredentials), ProviderError> {
         info!(
-            "Getting or creating ACME account for email: {} environment: {}",
-            email, self.environment
+            "Getting or creating ACME account for email: {} directory: {}",
+            email, directory
         );
 
         if let Some(account) = self
             .repository
-            .find_acme_account(email, &self.environment)
+            .find_acme_account(email, directory.key())
             .await?
         {
             let account_creds: AccountCredentials = serde_json::from_str(&account.credentials)
@@ -102,15 +167,15 @@ impl LetsEncryptProvider {
 
             Ok((acme_account, account_creds_clone))
         } else {
-            let acme_url = self.get_acme_url();
+            let external_account = self.external_account_key(directory).await?;
             let (acme_account, credentials) = Account::create(
                 &NewAccount {
                     contact: &[format!("mailto:{}", email).as_str()],
                     terms_of_service_agreed: true,
                     only_return_existing: false,
                 },
-                &acme_url,
-                None,
+                directory.url(),
+                external_account.as_ref(),
             )
             .await?;
 
@@ -120,7 +185,8 @@ impl LetsEncryptProvider {
 
             let acme_account_data = AcmeAccount {
                 email: email.to_string(),
-                environment: self.environment.clone(),
+                environment: directory.key().to_string(),
+                directory_url: directory.url().to_string(),
                 credentials: account_creds_str,
                 created_at: Utc::now(),
             };
@@ -391,7 +457,8 @@ impl CertificateProvider for LetsEncryptProvider {
             vec![Identifier::Dns(domain.to_string())]
         };
 
-        let (acme_account, _) = self.get_or_create_acme_account(email).await?;
+        let directory = self.directory_for(domain).await?;
+        let (acme_account, _) = self.get_or_create_acme_account(email, &directory).await?;
 
         let mut order = acme_account
             .new_order(&NewOrder {
@@ -437,7 +504,8 @@ impl CertificateProvider for LetsEncryptProvider {
             challenge_data.challenge_type, domain, email
         );
 
-        let (acme_account, _) = self.get_or_create_acme_account(email).await?;
+        let directory = self.directory_for(domain).await?;
+        let (acme_account, _) = self.get_or_create_acme_account(email, &directory).await?;
 
         // Load the existing order using the stored order URL
         let order_url = challenge_data.order_url.as_ref().ok_or_else(|| {
@@ -520,11 +588,10 @@ impl CertificateProvider for LetsEncryptProvider {
         }
 
         // Warn about staging environment
-        if self.environment != "production" {
-            result.warnings.push(format!(
-                "Using {} environment - certificates will not be trusted",
-                self.environment
-            ));
+        if self.directory_for(domain).await?.is_staging() {
+            result
+                .warnings
+                .push("Using Let's Encrypt staging - certificates will not be trusted".to_string());
         }
 
         Ok(result)
@@ -551,12 +618,14 @@ impl LetsEncryptProvider {
     /// This fetches the current state of the challenge directly from the ACME server
     pub async fn get_challenge_status(
         &self,
+        domain: &str,
         order_url: &str,
         email: &str,
     ) -> Result<Option<serde_json::Value>, ProviderError> {
         debug!("Fetching live challenge status for order: {}", order_url);
 
-        let (acme_account, _) = self.get_or_create_acme_account(email).await?;
+        let directory = self.directory_for(domain).await?;
+        let (acme_account, _) = self.get_or_create_acme_account(email, &directory).await?;
 
         // Load the order from Let's Encrypt
         let mut order = acme_account.order(order_url.to_string()).await?;
@@ -651,4 +720,20 @@ mod tests {
         assert_eq!(challenges.len(), 2);
         assert!(challenges.contains(&ChallengeType::Http01));
     }
+
+    #[tokio::test]
+    async fn test_domain_acme_directory_overrides_default() {
+        let repo = Arc::new(MockCertificateRepository::new());
+        repo.set_acme_directory("app.example.com", "https://acme.zerossl.com/v2/DV90")
+            .await;
+        repo.set_acme_directory("broken.example.com", "ftp://ca.internal")
+            .await;
+        let provider = LetsEncryptProvider::new(repo);
+
+        assert_eq!(
+            provider.directory_for("app.example.com").await.unwrap(),
+            AcmeDirectory::Custom("https://acme.zerossl.com/v2/DV90".to_string())
+        );
+        assert!(provider.directory_for("broken.example.com").await.is_err());
+    }
 }
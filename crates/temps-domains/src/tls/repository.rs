@@ -58,6 +58,8 @@ pub trait CertificateRepository: Send + Sync {
         email: &str,
         environment: &str,
     ) -> Result<Option<AcmeAccount>, RepositoryError>;
+    /// ACME directory the domain requests its certificates from, if it has its own
+    async fn find_acme_directory(&self, domain: &str) -> Result<Option<String>, RepositoryError>;
 
     // ACME order operations
     async fn save_acme_order(&self, order: AcmeOrder) -> Result<AcmeOrder, RepositoryError>;
@@ -535,7 +537,7 @@ impl CertificateRepository for DefaultCertificateRepository {
         let new_account = acme_accounts::ActiveModel {
             email: Set(account.email),
             environment: Set(account.environment),
-            url: Set("https://acme-v02.api.letsencrypt.org/directory".to_string()), // Default URL
+            url: Set(account.directory_url),
             account_data: Set(account.credentials),
             created_at: Set(account.created_at),
             updated_at: Set(Utc::now()),
@@ -565,11 +567,23 @@ impl CertificateRepository for DefaultCertificateRepository {
         Ok(result.map(|r| AcmeAccount {
             email: r.email,
             environment: r.environment,
+            directory_url: r.url,
             credentials: r.account_data,
             created_at: r.created_at,
         }))
     }
 
+    async fn find_acme_directory(&self, domain: &str) -> Result<Option<String>, RepositoryError> {
+        use temps_entities::domains;
+
+        let result = domains::Entity::find()
+            .filter(domains::Column::Domain.eq(domain))
+            .one(self.db.as_ref())
+            .await?;
+
+        Ok(result.and_then(|d| d.acme_directory))
+    }
+
     async fn save_acme_order(&self, order: AcmeOrder) -> Result<AcmeOrder, RepositoryError> {
         use temps_entities::acme_orders;
 
@@ -828,6 +842,7 @@ pub mod test_utils {
         challenges: Arc<RwLock<HashMap<String, DnsChallengeData>>>,
         http_challenges: Arc<RwLock<HashMap<String, HttpChallengeData>>>,
         accounts: Arc<RwLock<HashMap<String, AcmeAccount>>>,
+        acme_directories: Arc<RwLock<HashMap<String, String>>>,
     }
 
     impl Default for MockCertificateRepository {
@@ -843,8 +858,16 @@ pub mod test_utils {
                 challenges: Arc::new(RwLock::new(HashMap::new())),
                 http_challenges: Arc::new(RwLock::new(HashMap::new())),
                 accounts: Arc::new(RwLock::new(HashMap::new())),
+                acme_directories: Arc::new(RwLock::new(HashMap::new())),
             }
         }
+
+        pub async fn set_acme_directory(&self, domain: &str, directory: &str) {
+            self.acme_directories
+                .write()
+                .await
+                .insert(domain.to_string(), directory.to_string());
+        }
     }
 
     #[async_trait]
@@ -986,6 +1009,13 @@ pub mod test_utils {
             Ok(accounts.get(&key).cloned())
         }
 
+        async fn find_acme_directory(
+            &self,
+            domain: &str,
+        ) -> Result<Option<String>, RepositoryError> {
+            Ok(self.acme_directories.read().await.get(domain).cloned())
+        }
+
         async fn save_acme_order(&self, order: AcmeOrder) -> Result<AcmeOrder, RepositoryError> {
             // For mock, just return the order with an ID if it doesn't have one
             Ok(order)
@@ -14,6 +14,7 @@ use super::errors::{BuilderError, TlsError};
 use super::models::*;
 use super::providers::CertificateProvider;
 use super::repository::CertificateRepository;
+use super::upload::UPLOADED_VERIFICATION_METHOD;
 
 /// Type alias for the Tokio-based DNS resolver
 type TokioResolver = Resolver<TokioConnectionProvider>;
@@ -238,6 +239,14 @@ impl TlsService {
             "Renewing certificate for domain: {} with email: {}",
             domain, email
         );
+        if let Some(cert) = self.repository.find_certificate(domain).await? {
+            if cert.verification_method == UPLOADED_VERIFICATION_METHOD {
+                return Err(TlsError::ManualActionRequired(format!(
+                    "{} serves an uploaded certificate; upload a new one to renew it",
+                    domain
+                )));
+            }
+        }
         self.provision_certificate(domain, email).await
     }
 
@@ -263,8 +272,9 @@ impl TlsService {
     }
 
     /// Check and automatically renew expiring certificates
-    /// - HTTP-01 certificates: Auto-renew
+    /// - HTTP-01 certificates: Auto-renew, unless TLS is terminated upstream
     /// - DNS-01 certificates: Send notification for manual renewal
+    /// - Uploaded certificates: Send notification to upload a new one
     ///
     /// Threshold: 30 days before expiration
     pub async fn check_and_renew_certificates(
@@ -284,14 +294,23 @@ impl TlsService {
             manual_action_needed: Vec::new(),
         };
 
+        let tls_terminated_upstream = self.tls_terminated_upstream().await;
+
         for cert in expiring {
             match cert.verification_method.as_str() {
+                "http-01" if tls_terminated_upstream => {
+                    // The upstream proxy serves the certificates clients see
+                    info!(
+                        "Skipping HTTP-01 renewal for {}: TLS is terminated upstream",
+                        cert.domain
+                    );
+                }
                 "http-01" => {
                     // HTTP-01: Attempt automatic renewal
                     self.handle_http01_renewal(&cert, &mut report).await;
                 }
-                "dns-01" => {
-                    // DNS-01: Notify user for manual renewal
+                "dns-01" | UPLOADED_VERIFICATION_METHOD => {
+                    // DNS-01 and uploaded: Notify user for manual renewal
                     self.handle_dns01_notification(&cert, &mut report).await;
                 }
                 _ => {
@@ -309,6 +328,18 @@ impl TlsService {
         Ok(report)
     }
 
+    /// Whether TLS is terminated by an upstream proxy, per the trusted proxy settings
+    async fn tls_terminated_upstream(&self) -> bool {
+        match &self.config_service {
+            Some(config_service) => config_service
+                .get_settings()
+                .await
+                .map(|settings| settings.trusted_proxies.tls_terminated_upstream)
+                .unwrap_or(false),
+            None => false,
+        }
+    }
+
     /// Get email for ACME certificate provisioning
     /// Priority: 1) LetsEncrypt email from settings, 2) First user email, 3) Fallback
     async fn get_acme_email(&self) -> String {
@@ -408,8 +439,8 @@ impl TlsService {
         let days_remaining = cert.days_until_expiry();
 
         info!(
-            "⚠️  DNS-01 certificate for {} needs manual renewal (expires in {} days)",
-            cert.domain, days_remaining
+            "⚠️  {} certificate for {} needs manual renewal (expires in {} days)",
+            cert.verification_method, cert.domain, days_remaining
         );
 
         report.manual_action_needed.push(ManualRenewalNeeded {
@@ -543,15 +574,26 @@ impl TlsService {
 
         let days_remaining = cert.days_until_expiry();
 
-        let notification = NotificationData {
-            id: uuid::Uuid::new_v4().to_string(),
-            title: format!("Action Required: Renew Certificate for {}", cert.domain),
-            message: format!(
+        let message = if cert.verification_method == UPLOADED_VERIFICATION_METHOD {
+            format!(
+                "Your uploaded certificate for {} will expire in {} days.\n\nUploaded certificates are not renewed automatically. Get a new certificate from your certificate authority and upload it under Temps Dashboard → Domains → {}.\n\nYour current certificate is served until it expires.",
+                cert.domain,
+                days_remaining,
+                cert.domain
+            )
+        } else {
+            format!(
                 "Your wildcard certificate for {} will expire in {} days.\n\nSince this is a DNS-01 certificate, you need to manually renew it:\n1. Go to Temps Dashboard → Domains → {}\n2. Click 'Renew Certificate'\n3. Add the provided DNS TXT record\n4. Click 'Finalize Renewal'\n\nYour current certificate remains active during renewal.",
                 cert.domain,
                 days_remaining,
                 cert.domain
-            ),
+            )
+        };
+
+        let notification = NotificationData {
+            id: uuid::Uuid::new_v4().to_string(),
+            title: format!("Action Required: Renew Certificate for {}", cert.domain),
+            message,
             notification_type: if days_remaining <= 7 {
                 NotificationType::Alert
             } else {
@@ -576,7 +618,10 @@ impl TlsService {
                 ("domain".to_string(), cert.domain.clone()),
                 ("expires_at".to_string(), cert.expiration_time.to_rfc3339()),
                 ("days_remaining".to_string(), days_remaining.to_string()),
-                ("verification_method".to_string(), "dns-01".to_string()),
+                (
+                    "verification_method".to_string(),
+                    cert.verification_method.clone(),
+                ),
                 ("is_wildcard".to_string(), cert.is_wildcard.to_string()),
             ]),
             bypass_throttling: days_remaining <= 7,
@@ -689,6 +734,7 @@ impl TlsService {
     /// This retrieves the current state of the ACME challenge directly from the server
     pub async fn get_live_challenge_status(
         &self,
+        domain: &str,
         order_url: &str,
         email: &str,
     ) -> Result<Option<serde_json::Value>, TlsError> {
@@ -700,7 +746,7 @@ impl TlsService {
 
         if let Some(lets_encrypt_provider) = provider_any.downcast_ref::<LetsEncryptProvider>() {
             lets_encrypt_provider
-                .get_challenge_status(order_url, email)
+                .get_challenge_status(domain, order_url, email)
                 .await
                 .map_err(TlsError::Provider)
         } else {
@@ -1370,6 +1416,7 @@ mod tests {
         let account = AcmeAccount {
             email: "test_acme_account@example.com".to_string(),
             environment: "staging".to_string(),
+            directory_url: "https://acme-staging-v02.api.letsencrypt.org/directory".to_string(),
             credentials: r#"{"id":"test123","key":"secret"}"#.to_string(),
             created_at: chrono::Utc::now(),
         };
@@ -1758,6 +1805,7 @@ mod tests {
         let account = AcmeAccount {
             email: "persist@example.com".to_string(),
             environment: "staging".to_string(),
+            directory_url: "https://acme-staging-v02.api.letsencrypt.org/directory".to_string(),
             credentials: r#"{"id":"account123","key":"secret"}"#.to_string(),
             created_at: chrono::Utc::now(),
         };
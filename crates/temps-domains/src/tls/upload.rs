@@ -0,0 +1,203 @@
+//! Certificates uploaded for a domain instead of issued through ACME
+//!
+//! Some domains need certificates ACME can't issue, e.g. from a corporate CA or EV
+//! certificates. An upload is checked before it's stored: the private key has to
+//! match the certificate, and the certificate has to be valid now and cover the
+//! domain. Nothing renews uploaded certificates, so renewal checks warn about them
+//! instead.
+
+use chrono::{TimeZone, Utc};
+use rustls::pki_types::CertificateDer;
+use temps_core::UtcDateTime;
+use x509_parser::certificate::X509Certificate;
+use x509_parser::extensions::GeneralName;
+
+/// Verification method of domains serving an uploaded certificate
+pub const UPLOADED_VERIFICATION_METHOD: &str = "uploaded";
+
+/// An uploaded certificate that passed validation
+#[derive(Debug, Clone)]
+pub struct UploadedCertificate {
+    /// Leaf certificate followed by the chain, PEM-encoded
+    pub certificate_pem: String,
+    pub private_key_pem: String,
+    pub expiration_time: UtcDateTime,
+    /// DNS names the certificate covers
+    pub names: Vec<String>,
+}
+
+/// Check an uploaded certificate, private key and optional chain for a domain
+///
+/// The certificate PEM may already contain the chain after the leaf certificate.
+pub fn validate_uploaded_certificate(
+    domain: &str,
+    certificate_pem: &str,
+    private_key_pem: &str,
+    chain_pem: Option<&str>,
+) -> Result<UploadedCertificate, String> {
+    let mut full_chain = certificate_pem.trim().to_string();
+    if let Some(chain) = chain_pem.map(str::trim).filter(|chain| !chain.is_empty()) {
+        full_chain.push('\n');
+        full_chain.push_str(chain);
+    }
+    full_chain.push('\n');
+
+    let chain: Vec<CertificateDer<'static>> = rustls_pemfile::certs(&mut full_chain.as_bytes())
+        .collect::<Result<_, _>>()
+        .map_err(|e| format!("Failed to parse certificate: {}", e))?;
+    let leaf = chain
+        .first()
+        .ok_or_else(|| "No certificate found in the PEM data".to_string())?;
+    let (_, x509) = x509_parser::parse_x509_certificate(leaf.as_ref())
+        .map_err(|e| format!("Failed to parse certificate: {}", e))?;
+
+    let validity = x509.validity();
+    let now = Utc::now().timestamp();
+    if validity.not_after.timestamp() <= now {
+        return Err(format!("Certificate expired on {}", validity.not_after));
+    }
+    if validity.not_before.timestamp() > now {
+        return Err(format!(
+            "Certificate is not valid before {}",
+            validity.not_before
+        ));
+    }
+    let expiration_time = Utc
+        .timestamp_opt(validity.not_after.timestamp(), 0)
+        .single()
+        .ok_or_else(|| "Invalid certificate expiration time".to_string())?;
+
+    let names = certificate_names(&x509);
+    if !names.iter().any(|name| name_covers(name, domain)) {
+        return Err(format!(
+            "Certificate for {} doesn't cover {}",
+            if names.is_empty() {
+                "no names".to_string()
+            } else {
+                names.join(", ")
+            },
+            domain
+        ));
+    }
+
+    let private_key = rustls_pemfile::private_key(&mut private_key_pem.as_bytes())
+        .map_err(|e| format!("Failed to parse private key: {}", e))?
+        .ok_or_else(|| "No private key found in the PEM data".to_string())?;
+    let signing_key = rustls::crypto::ring::sign::any_supported_type(&private_key)
+        .map_err(|e| format!("Unsupported private key: {}", e))?;
+    rustls::sign::CertifiedKey::new(chain, signing_key)
+        .keys_match()
+        .map_err(|_| "Private key doesn't match the certificate".to_string())?;
+
+    Ok(UploadedCertificate {
+        certificate_pem: full_chain,
+        private_key_pem: private_key_pem.trim().to_string(),
+        expiration_time,
+        names,
+    })
+}
+
+/// DNS names in the subject alternative names, or the common name without any
+fn certificate_names(x509: &X509Certificate<'_>) -> Vec<String> {
+    let san_names: Vec<String> = x509
+        .subject_alternative_name()
+        .ok()
+        .flatten()
+        .map(|san| {
+            san.value
+                .general_names
+                .iter()
+                .filter_map(|name| match name {
+                    GeneralName::DNSName(name) => Some(name.to_lowercase()),
+                    _ => None,
+                })
+                .collect()
+        })
+        .unwrap_or_default();
+    if !san_names.is_empty() {
+        return san_names;
+    }
+
+    x509.subject()
+        .iter_common_name()
+        .filter_map(|cn| cn.as_str().ok())
+        .map(str::to_lowercase)
+        .collect()
+}
+
+/// Whether a certificate name covers the domain; a wildcard covers one label
+fn name_covers(name: &str, domain: &str) -> bool {
+    let domain = domain.to_lowercase();
+    if name == domain {
+        return true;
+    }
+    let Some(suffix) = name.strip_prefix("*.") else {
+        return false;
+    };
+    match domain.split_once('.') {
+        Some((label, rest)) => !label.is_empty() && label != "*" && rest == suffix,
+        None => false,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rcgen::{CertificateParams, KeyPair};
+
+    fn self_signed(
+        names: &[&str],
+        params: impl FnOnce(&mut CertificateParams),
+    ) -> (String, String) {
+        let key = KeyPair::generate().unwrap();
+        let mut cert_params =
+            CertificateParams::new(names.iter().map(|n| n.to_string()).collect::<Vec<_>>())
+                .unwrap();
+        params(&mut cert_params);
+        let cert = cert_params.self_signed(&key).unwrap();
+        (cert.pem(), key.serialize_pem())
+    }
+
+    #[test]
+    fn test_valid_upload() {
+        let (cert, key) = self_signed(&["*.example.com", "example.com"], |_| {});
+
+        let uploaded = validate_uploaded_certificate("app.example.com", &cert, &key, None).unwrap();
+        assert_eq!(uploaded.names, ["*.example.com", "example.com"]);
+        assert!(uploaded.expiration_time > Utc::now());
+
+        assert!(validate_uploaded_certificate("*.example.com", &cert, &key, None).is_ok());
+        assert!(validate_uploaded_certificate("example.com", &cert, &key, Some("")).is_ok());
+    }
+
+    #[test]
+    fn test_rejected_uploads() {
+        let (cert, key) = self_signed(&["app.example.com"], |_| {});
+        let (_, other_key) = self_signed(&["app.example.com"], |_| {});
+        let (expired, expired_key) = self_signed(&["app.example.com"], |params| {
+            params.not_before = rcgen::date_time_ymd(2020, 1, 1);
+            params.not_after = rcgen::date_time_ymd(2021, 1, 1);
+        });
+
+        let err =
+            validate_uploaded_certificate("app.example.com", &cert, &other_key, None).unwrap_err();
+        assert!(err.contains("doesn't match"), "{}", err);
+
+        let err = validate_uploaded_certificate("api.example.com", &cert, &key, None).unwrap_err();
+        assert!(err.contains("doesn't cover"), "{}", err);
+
+        let err = validate_uploaded_certificate("app.example.com", &expired, &expired_key, None)
+            .unwrap_err();
+        assert!(err.contains("expired"), "{}", err);
+
+        assert!(validate_uploaded_certificate("app.example.com", "", &key, None).is_err());
+    }
+
+    #[test]
+    fn test_wildcard_covers_one_label() {
+        assert!(name_covers("*.example.com", "App.Example.com"));
+        assert!(!name_covers("*.example.com", "a.b.example.com"));
+        assert!(!name_covers("*.example.com", "example.com"));
+        assert!(!name_covers("app.example.com", "*.example.com"));
+    }
+}
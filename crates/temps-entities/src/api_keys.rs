@@ -15,6 +15,7 @@ pub struct Model {
     pub user_id: i32,
     pub role_type: String,           // Role enum as string
     pub permissions: Option<String>, // JSON array of permission strings for custom roles
+    pub log_scope: Option<String>,   // JSON of the projects/environments whose logs it can read
     pub is_active: bool,
     pub expires_at: Option<DBDateTime>,
     pub last_used_at: Option<DBDateTime>,
@@ -0,0 +1,59 @@
+//! Credential Rotation Policies Entity
+//!
+//! A schedule for rotating a managed service's credentials every `interval_days`.
+//! Scheduled rotations run with the same coordination as on-demand ones and are
+//! attributed to the user who last configured the schedule.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "credential_rotation_policies")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    #[sea_orm(unique)]
+    pub service_id: i32,
+    pub interval_days: i32,
+    /// When the next scheduled rotation is due
+    pub next_rotation_at: DBDateTime,
+    /// User who last configured the schedule
+    pub updated_by: i32,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::external_services::Entity",
+        from = "Column::ServiceId",
+        to = "super::external_services::Column::Id"
+    )]
+    Service,
+}
+
+impl Related<super::external_services::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Service.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(now);
+        }
+        self.updated_at = Set(now);
+
+        Ok(self)
+    }
+}
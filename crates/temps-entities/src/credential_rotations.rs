@@ -0,0 +1,80 @@
+//! Credential Rotations Entity
+//!
+//! One rotation of a managed service's credentials: a new credential is created and
+//! verified, the linked apps are redeployed with it, and the old credential is
+//! revoked. If the apps can't be moved over, the rotation is rolled back to the old
+//! credential.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+/// Rotation in progress
+pub const ROTATION_STATUS_RUNNING: &str = "running";
+/// Apps use the new credential and the old one is revoked
+pub const ROTATION_STATUS_COMPLETED: &str = "completed";
+/// The new credential failed verification; apps were moved back to the old one
+pub const ROTATION_STATUS_ROLLED_BACK: &str = "rolled_back";
+/// The rotation stopped; the error says which credentials remain valid
+pub const ROTATION_STATUS_FAILED: &str = "failed";
+
+/// Requested by a user
+pub const ROTATION_TRIGGER_MANUAL: &str = "manual";
+/// Started by the service's rotation schedule
+pub const ROTATION_TRIGGER_SCHEDULED: &str = "scheduled";
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "credential_rotations")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub service_id: i32,
+    /// One of the ROTATION_TRIGGER_* values
+    pub trigger: String,
+    /// One of the ROTATION_STATUS_* values
+    pub status: String,
+    pub old_username: String,
+    pub new_username: String,
+    /// Environments redeployed with the new credential, as a JSON array of IDs
+    pub environment_ids: Json,
+    pub error: Option<String>,
+    pub triggered_by: i32,
+    pub started_at: DBDateTime,
+    pub finished_at: Option<DBDateTime>,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::external_services::Entity",
+        from = "Column::ServiceId",
+        to = "super::external_services::Column::Id"
+    )]
+    Service,
+}
+
+impl Related<super::external_services::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Service.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(now);
+        }
+        self.updated_at = Set(now);
+
+        Ok(self)
+    }
+}
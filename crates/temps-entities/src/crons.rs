@@ -17,6 +17,8 @@ pub struct Model {
     pub updated_at: DBDateTime,
     pub next_run: Option<DBDateTime>,
     pub deleted_at: Option<DBDateTime>,
+    /// IANA timezone the schedule is evaluated in; None for the platform's default
+    pub timezone: Option<String>,
 }
 
 #[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
@@ -0,0 +1,72 @@
+//! Database Imports Entity
+//!
+//! Import of an existing external database into a managed service. A `dump` import
+//! loads the data once; a `replication` import keeps the managed service in sync
+//! with the source through logical replication until the user cuts over. Either
+//! way, the tables and row counts of both sides are compared once it is loaded.
+
+use sea_orm::entity::prelude::*;
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+/// Data is copied once, from a dump of the source or a dump file
+pub const IMPORT_MODE_DUMP: &str = "dump";
+/// Data is copied, then kept in sync with the source until cutover
+pub const IMPORT_MODE_REPLICATION: &str = "replication";
+
+/// Data is being loaded
+pub const IMPORT_STATUS_RUNNING: &str = "running";
+/// Replicating changes from the source, waiting for cutover
+pub const IMPORT_STATUS_SYNCING: &str = "syncing";
+/// Catching up with the source and stopping replication
+pub const IMPORT_STATUS_CUTTING_OVER: &str = "cutting_over";
+/// Loaded and verified; the managed service no longer depends on the source
+pub const IMPORT_STATUS_COMPLETED: &str = "completed";
+/// Loading or verification failed, or the import was aborted
+pub const IMPORT_STATUS_FAILED: &str = "failed";
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "database_imports")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    #[sea_orm(unique)]
+    pub service_id: i32,
+    /// One of the IMPORT_MODE_* values
+    pub mode: String,
+    /// One of the IMPORT_STATUS_* values
+    pub status: String,
+    /// Where the data comes from, without credentials
+    pub source_description: String,
+    /// Encrypted connection details of the source, kept until the import finishes
+    #[serde(skip_serializing)]
+    pub source_config: Option<String>,
+    /// Server version of the source, e.g. `16.4`
+    pub source_version: Option<String>,
+    /// Major version of the managed service
+    pub target_version: Option<String>,
+    /// Comparison of the tables and row counts of the source and the service
+    pub verification: Option<Json>,
+    pub error: Option<String>,
+    pub created_by: i32,
+    pub started_at: DBDateTime,
+    pub finished_at: Option<DBDateTime>,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::external_services::Entity",
+        from = "Column::ServiceId",
+        to = "super::external_services::Column::Id"
+    )]
+    Service,
+}
+
+impl Related<super::external_services::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Service.def()
+    }
+}
+
+impl ActiveModelBehavior for ActiveModel {}
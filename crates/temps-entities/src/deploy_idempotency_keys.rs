@@ -0,0 +1,58 @@
+//! Deploy Idempotency Keys Entity
+//!
+//! An idempotency key a deploy trigger was sent with, and what the trigger deployed.
+//! The row is written when the trigger claims the key, before the deploy is queued;
+//! the branch, tag and commit are filled in once it is.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "deploy_idempotency_keys")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub project_id: i32,
+    /// Value of the Idempotency-Key header, unique per project
+    pub idempotency_key: String,
+    pub environment_id: i32,
+    /// What the trigger deployed; None while it is being queued
+    pub branch: Option<String>,
+    pub tag: Option<String>,
+    pub commit: Option<String>,
+    pub dry_run: bool,
+    pub created_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::projects::Entity",
+        from = "Column::ProjectId",
+        to = "super::projects::Column::Id"
+    )]
+    Project,
+}
+
+impl Related<super::projects::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Project.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(chrono::Utc::now());
+        }
+
+        Ok(self)
+    }
+}
@@ -0,0 +1,67 @@
+//! Deployment Artifacts Entity
+//!
+//! A build artifact retained for a deployment, such as the bundle of a static-site
+//! deploy. The file itself lives in blob storage under `pathname`; the row records
+//! where, how large it is, and when the cleanup scheduler may delete it.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+/// Artifact kind for the gzipped tarball of a static deploy's output directory
+pub const ARTIFACT_KIND_STATIC_BUNDLE: &str = "static_bundle";
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "deployment_artifacts")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub deployment_id: i32,
+    pub project_id: i32,
+    pub environment_id: i32,
+    /// What the artifact is, e.g. "static_bundle"
+    pub kind: String,
+    /// File name offered when the artifact is downloaded
+    pub file_name: String,
+    /// Blob storage path, within the project's namespace
+    pub pathname: String,
+    pub content_type: String,
+    pub size_bytes: i64,
+    /// Hex-encoded SHA-256 of the file
+    pub sha256: String,
+    /// When the artifact is deleted; kept indefinitely when null
+    pub expires_at: Option<DBDateTime>,
+    pub created_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::deployments::Entity",
+        from = "Column::DeploymentId",
+        to = "super::deployments::Column::Id"
+    )]
+    Deployment,
+}
+
+impl Related<super::deployments::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Deployment.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(chrono::Utc::now());
+        }
+
+        Ok(self)
+    }
+}
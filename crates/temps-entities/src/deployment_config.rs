@@ -6,8 +6,22 @@
 use sea_orm::FromJsonQueryResult;
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
+use temps_core::EncryptionService;
 use utoipa::ToSchema;
 
+/// Largest request body the proxy accepts by default (100 MiB); larger bodies get 413
+pub const DEFAULT_MAX_REQUEST_BODY_BYTES: u64 = 100 * 1024 * 1024;
+/// Largest request line plus headers the proxy accepts by default; larger get 431
+pub const DEFAULT_MAX_REQUEST_HEADER_BYTES: u32 = 32 * 1024;
+/// Default seconds to wait for a regular response; slower upstreams get 504
+pub const DEFAULT_REQUEST_TIMEOUT_SECONDS: u32 = 300;
+/// Default seconds to wait for the client to send more of its request; slower get 408
+pub const DEFAULT_CLIENT_TIMEOUT_SECONDS: u32 = 60;
+/// Default seconds to establish a connection to the upstream; slower get 504
+pub const DEFAULT_CONNECT_TIMEOUT_SECONDS: u32 = 10;
+/// Default seconds an unused upstream connection is kept for reuse
+pub const DEFAULT_UPSTREAM_IDLE_TIMEOUT_SECONDS: u32 = 60;
+
 /// Security configuration for projects and environments
 ///
 /// This configuration can be set at three levels:
@@ -224,346 +238,4680 @@ impl RateLimitConfig {
     }
 }
 
-/// Deployment configuration shared between projects and environments
-///
-/// This configuration can be set at the project level (as defaults) and
-/// overridden at the environment level for specific deployments.
-///
-/// Note: Environment variables are managed separately and are not part of this config.
-#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema, FromJsonQueryResult)]
-#[serde(rename_all = "camelCase")]
-pub struct DeploymentConfig {
-    /// CPU request in millicores (e.g., 100 = 0.1 CPU, 1000 = 1 CPU)
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub cpu_request: Option<i32>,
+/// Protocol the proxy speaks to a service's containers
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum UpstreamProtocol {
+    /// HTTP/1.1
+    #[default]
+    Http1,
+    /// HTTP/2 over plain TCP with prior knowledge, as gRPC servers usually expect
+    H2c,
+    /// HTTP/2 over TLS; the container's certificate is not verified
+    H2,
+}
 
-    /// CPU limit in millicores (e.g., 2000 = 2 CPUs)
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub cpu_limit: Option<i32>,
+impl UpstreamProtocol {
+    pub fn is_http2(&self) -> bool {
+        matches!(self, UpstreamProtocol::H2c | UpstreamProtocol::H2)
+    }
+}
 
-    /// Memory request in megabytes (e.g., 128 = 128MB)
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub memory_request: Option<i32>,
+/// Whether a service is reached over HTTP, HTTPS or both
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum HttpsMode {
+    /// Redirect HTTP to HTTPS once an active certificate covers the host, and serve
+    /// HTTP until then
+    #[default]
+    Auto,
+    /// Always redirect HTTP to HTTPS
+    ForceHttps,
+    /// Serve both HTTP and HTTPS without redirecting
+    Both,
+    /// Serve HTTP only; HTTPS requests are redirected to HTTP
+    HttpOnly,
+}
 
-    /// Memory limit in megabytes (e.g., 512 = 512MB)
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub memory_limit: Option<i32>,
+/// Status the proxy answers HTTP to HTTPS redirects with unless a service sets one
+pub const DEFAULT_HTTPS_REDIRECT_STATUS: u16 = 301;
 
-    /// Port exposed by the container
-    /// If not specified, will be auto-detected from Docker image or default to 3000
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub exposed_port: Option<i32>,
+/// Seconds a container gets to exit after its stop signal unless a service sets it
+pub const DEFAULT_STOP_TIMEOUT_SECONDS: u32 = 10;
 
-    /// Enable automatic deployments on git push
-    #[serde(default)]
-    pub automatic_deploy: bool,
+/// Longest stop timeout a service may set; stopping waits on the Docker API,
+/// whose requests time out after two minutes
+pub const MAX_STOP_TIMEOUT_SECONDS: u32 = 120;
 
-    /// Enable performance metrics collection (speed insights)
-    #[serde(default)]
-    pub performance_metrics_enabled: bool,
+/// Most deployment images a service may keep for rollbacks
+pub const MAX_RETAINED_IMAGES: u32 = 100;
 
-    /// Enable session recording for analytics
-    #[serde(default)]
-    pub session_recording_enabled: bool,
+/// Time a whole deploy (build, push, start and health checks) may take unless a
+/// service sets it
+pub const DEFAULT_DEPLOY_TIMEOUT_SECONDS: u32 = 3600;
 
-    /// Number of replicas/instances to run
-    /// Defaults to 1 replica
-    #[serde(default = "default_replicas")]
-    pub replicas: i32,
+/// Shortest and longest deploy timeout a service may set
+pub const MIN_DEPLOY_TIMEOUT_SECONDS: u32 = 60;
+pub const MAX_DEPLOY_TIMEOUT_SECONDS: u32 = 86_400;
 
-    /// Security configuration (headers, rate limiting, attack mode, etc.)
-    /// These settings inherit and override from parent level (Environment > Project > Global)
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub security: Option<SecurityConfig>,
-}
+/// Longest Cache-Control rule list a static site may have
+pub const MAX_CACHE_CONTROL_RULES: usize = 50;
 
-/// Deployment configuration snapshot for deployments
+/// How the proxy serves a static site deployment
 ///
-/// This extends DeploymentConfig with environment variables to capture
-/// the complete state of a deployment at the time it was created.
-#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema, FromJsonQueryResult)]
+/// Static sites have no app container: the build output is extracted and served by
+/// the proxy, and each deployment gets its own directory, so a redeploy swaps the
+/// served files in one step when routing moves to the new deployment.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
 #[serde(rename_all = "camelCase")]
-pub struct DeploymentConfigSnapshot {
-    /// CPU request in millicores
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub cpu_request: Option<i32>,
-
-    /// CPU limit in millicores
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub cpu_limit: Option<i32>,
-
-    /// Memory request in megabytes
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub memory_request: Option<i32>,
+pub struct StaticSiteConfig {
+    /// Build output directory inside the built image, e.g. `/app/dist`. Setting it
+    /// deploys the service as a static site even when its preset runs a server.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "/app/dist")]
+    pub output_dir: Option<String>,
 
-    /// Memory limit in megabytes
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub memory_limit: Option<i32>,
-
-    /// Port exposed by the container
-    #[serde(skip_serializing_if = "Option::is_none")]
-    pub exposed_port: Option<i32>,
-
-    /// Environment variables used for this deployment
-    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
-    pub environment_variables: HashMap<String, String>,
-
-    /// Enable automatic deployments on git push
-    #[serde(default)]
-    pub automatic_deploy: bool,
-
-    /// Enable performance metrics collection
-    #[serde(default)]
-    pub performance_metrics_enabled: bool,
+    /// Serve `index.html` for extension-less paths that match no file, so client-side
+    /// routes load the app; on when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub spa_fallback: Option<bool>,
 
-    /// Enable session recording
-    #[serde(default)]
-    pub session_recording_enabled: bool,
+    /// Page served with status 404 for paths that match no file, relative to the
+    /// output directory; `404.html` is used when unset and the site has one
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "404.html")]
+    pub not_found_page: Option<String>,
 
-    /// Number of replicas
-    #[serde(default = "default_replicas")]
-    pub replicas: i32,
+    /// Cache-Control values by path; the first matching rule wins and unmatched
+    /// paths use the built-in defaults (long-lived for hashed assets, revalidate
+    /// for everything else)
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub cache_control: Vec<CacheControlRule>,
 }
 
-fn default_replicas() -> i32 {
-    1
+/// Cache-Control value for the paths matching a pattern
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct CacheControlRule {
+    /// Exact path (`/sw.js`), prefix (`/assets/*`) or suffix (`*.woff2`)
+    #[schema(example = "/assets/*")]
+    pub pattern: String,
+    /// Cache-Control header value for matching paths
+    #[schema(example = "public, max-age=31536000, immutable")]
+    pub value: String,
 }
 
-impl Default for DeploymentConfig {
-    fn default() -> Self {
-        Self {
-            cpu_request: None,
-            cpu_limit: None,
-            memory_request: None,
-            memory_limit: None,
-            exposed_port: None,
-            automatic_deploy: false,
-            performance_metrics_enabled: false,
-            session_recording_enabled: false,
-            replicas: 1,
-            security: None,
+impl CacheControlRule {
+    pub fn matches(&self, path: &str) -> bool {
+        if let Some(prefix) = self.pattern.strip_suffix('*') {
+            path.starts_with(prefix)
+        } else if let Some(suffix) = self.pattern.strip_prefix('*') {
+            path.ends_with(suffix)
+        } else {
+            path == self.pattern
         }
     }
 }
 
-impl Default for DeploymentConfigSnapshot {
-    fn default() -> Self {
-        Self {
-            cpu_request: None,
-            cpu_limit: None,
-            memory_request: None,
-            memory_limit: None,
-            exposed_port: None,
-            environment_variables: HashMap::new(),
-            automatic_deploy: false,
-            performance_metrics_enabled: false,
-            session_recording_enabled: false,
-            replicas: 1,
-        }
+impl StaticSiteConfig {
+    pub fn spa_fallback(&self) -> bool {
+        self.spa_fallback.unwrap_or(true)
     }
-}
 
-impl DeploymentConfig {
-    /// Create a new deployment configuration with default values
-    pub fn new() -> Self {
-        Self::default()
+    /// Page to serve for paths that match no file
+    pub fn not_found_page(&self) -> &str {
+        self.not_found_page.as_deref().unwrap_or("404.html")
     }
 
-    /// Merge this config with another, preferring values from `other`
-    ///
-    /// This is useful for merging environment-level config (other) with
-    /// project-level config (self) to get the effective configuration.
-    pub fn merge(&self, other: &DeploymentConfig) -> DeploymentConfig {
-        DeploymentConfig {
-            cpu_request: other.cpu_request.or(self.cpu_request),
-            cpu_limit: other.cpu_limit.or(self.cpu_limit),
-            memory_request: other.memory_request.or(self.memory_request),
-            memory_limit: other.memory_limit.or(self.memory_limit),
-            exposed_port: other.exposed_port.or(self.exposed_port),
-            automatic_deploy: other.automatic_deploy || self.automatic_deploy,
-            performance_metrics_enabled: other.performance_metrics_enabled
-                || self.performance_metrics_enabled,
-            session_recording_enabled: other.session_recording_enabled
-                || self.session_recording_enabled,
-            // Use other's replicas if > 0, otherwise use self's replicas
-            replicas: if other.replicas > 0 {
-                other.replicas
-            } else {
-                self.replicas
-            },
-            // Merge security configurations
-            security: match (&self.security, &other.security) {
-                (Some(base), Some(override_security)) => Some(base.merge(override_security)),
-                (Some(base), None) => Some(base.clone()),
-                (None, Some(override_security)) => Some(override_security.clone()),
-                (None, None) => None,
-            },
-        }
+    /// Configured Cache-Control value for a request path
+    pub fn cache_control_for(&self, path: &str) -> Option<&str> {
+        self.cache_control
+            .iter()
+            .find(|rule| rule.matches(path))
+            .map(|rule| rule.value.as_str())
     }
 
-    /// Validate the resource configuration
     pub fn validate(&self) -> Result<(), String> {
-        // CPU request should not exceed CPU limit
-        if let (Some(request), Some(limit)) = (self.cpu_request, self.cpu_limit) {
-            if request > limit {
-                return Err("CPU request cannot exceed CPU limit".to_string());
+        if let Some(output_dir) = &self.output_dir {
+            if !output_dir.starts_with('/') {
+                return Err(format!(
+                    "Static output directory '{}' must be an absolute path",
+                    output_dir
+                ));
             }
         }
-
-        // Memory request should not exceed memory limit
-        if let (Some(request), Some(limit)) = (self.memory_request, self.memory_limit) {
-            if request > limit {
-                return Err("Memory request cannot exceed memory limit".to_string());
+        if let Some(page) = &self.not_found_page {
+            if page.is_empty() || page.split('/').any(|segment| segment == "..") {
+                return Err(format!("Invalid not found page '{}'", page));
             }
         }
-
-        // Port should be in valid range
-        if let Some(port) = self.exposed_port {
-            if !(1..=65535).contains(&port) {
-                return Err(format!("Port {} is not in valid range (1-65535)", port));
+        if self.cache_control.len() > MAX_CACHE_CONTROL_RULES {
+            return Err(format!(
+                "A static site can have at most {} Cache-Control rules",
+                MAX_CACHE_CONTROL_RULES
+            ));
+        }
+        for rule in &self.cache_control {
+            let pattern = rule.pattern.trim_start_matches('*').trim_end_matches('*');
+            if pattern.is_empty() || pattern.contains('*') {
+                return Err(format!(
+                    "Cache-Control pattern '{}' must be a path, `prefix*` or `*suffix`",
+                    rule.pattern
+                ));
+            }
+            if rule.value.trim().is_empty() || rule.value.contains(['\r', '\n']) {
+                return Err(format!(
+                    "Invalid Cache-Control value for pattern '{}'",
+                    rule.pattern
+                ));
             }
         }
-
         Ok(())
     }
 }
 
-impl DeploymentConfigSnapshot {
-    /// Create a snapshot from a DeploymentConfig and environment variables
-    pub fn from_config(
-        config: &DeploymentConfig,
-        environment_variables: HashMap<String, String>,
-    ) -> Self {
-        Self {
-            cpu_request: config.cpu_request,
-            cpu_limit: config.cpu_limit,
-            memory_request: config.memory_request,
-            memory_limit: config.memory_limit,
-            exposed_port: config.exposed_port,
-            environment_variables,
-            automatic_deploy: config.automatic_deploy,
-            performance_metrics_enabled: config.performance_metrics_enabled,
-            session_recording_enabled: config.session_recording_enabled,
-            replicas: config.replicas,
-        }
-    }
+/// How deployments are promoted into an environment from another one
+///
+/// Promotion deploys the image a deployment in a lower environment (e.g. staging)
+/// already runs, without rebuilding it. The promoted deployment keeps the target
+/// environment's own environment variables, resources and domains; only the
+/// variables listed in `carry_env_vars` are taken from the source environment.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct PromotionConfig {
+    /// Promotions into this environment wait for approval by someone other than the
+    /// requester before they are deployed
+    #[serde(default)]
+    pub require_approval: bool,
 
-    /// Validate the resource configuration
+    /// Environment variables whose values are carried over from the source
+    /// environment, overriding this environment's values for the promoted deployment
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    #[schema(example = json!(["FEATURE_FLAGS"]))]
+    pub carry_env_vars: Vec<String>,
+}
+
+impl PromotionConfig {
     pub fn validate(&self) -> Result<(), String> {
-        // CPU request should not exceed CPU limit
-        if let (Some(request), Some(limit)) = (self.cpu_request, self.cpu_limit) {
-            if request > limit {
-                return Err("CPU request cannot exceed CPU limit".to_string());
+        for key in &self.carry_env_vars {
+            let mut chars = key.chars();
+            let valid = chars
+                .next()
+                .is_some_and(|c| c.is_ascii_alphabetic() || c == '_')
+                && chars.all(|c| c.is_ascii_alphanumeric() || c == '_');
+            if !valid {
+                return Err(format!(
+                    "'{}' is not a valid environment variable name to carry on promotion",
+                    key
+                ));
             }
         }
+        Ok(())
+    }
+}
 
-        // Memory request should not exceed memory limit
-        if let (Some(request), Some(limit)) = (self.memory_request, self.memory_limit) {
-            if request > limit {
-                return Err("Memory request cannot exceed memory limit".to_string());
+/// Builder image Cloud Native Buildpacks builds use unless a service sets one
+pub const DEFAULT_BUILDPACKS_BUILDER: &str = "paketobuildpacks/builder-jammy-base";
+
+/// Most buildpacks a service can list
+pub const MAX_BUILDPACKS: usize = 20;
+
+/// Maximum number of labels a build can require of its build node
+pub const MAX_BUILD_NODE_LABELS: usize = 10;
+
+/// What turns a service's source into its container image
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum BuilderKind {
+    /// The repository's Dockerfile, or one generated from the project's preset
+    #[default]
+    Dockerfile,
+    /// A Dockerfile generated by Nixpacks, even when the repository has one
+    Nixpacks,
+    /// Cloud Native Buildpacks through the `pack` CLI, without a Dockerfile
+    Buildpacks,
+}
+
+/// Builder of a service's images
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct BuilderConfig {
+    #[serde(default)]
+    pub kind: BuilderKind,
+
+    /// Buildpacks builder image; `DEFAULT_BUILDPACKS_BUILDER` when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "heroku/builder:24")]
+    pub builder_image: Option<String>,
+
+    /// Buildpacks to run, in order, instead of the ones the builder detects
+    /// Takes IDs (`paketo-buildpacks/nodejs`), images (`docker://...`) or URLs
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub buildpacks: Vec<String>,
+
+    /// Labels a build node needs to run the build; any node (or the local Docker
+    /// when none are configured) when empty. Buildpacks builds always run locally
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    #[schema(example = json!(["arm64", "gpu"]))]
+    pub node_labels: Vec<String>,
+}
+
+impl BuilderConfig {
+    /// Buildpacks builder image to build with
+    pub fn builder_image(&self) -> &str {
+        self.builder_image
+            .as_deref()
+            .unwrap_or(DEFAULT_BUILDPACKS_BUILDER)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.kind != BuilderKind::Buildpacks
+            && (self.builder_image.is_some() || !self.buildpacks.is_empty())
+        {
+            return Err(
+                "A builder image and buildpacks can only be set for the buildpacks builder"
+                    .to_string(),
+            );
+        }
+        if self.buildpacks.len() > MAX_BUILDPACKS {
+            return Err(format!(
+                "At most {} buildpacks can be configured",
+                MAX_BUILDPACKS
+            ));
+        }
+        // Both end up as `pack` arguments
+        let valid_reference =
+            |reference: &str| !reference.is_empty() && !reference.starts_with('-');
+        if let Some(image) = &self.builder_image {
+            if !valid_reference(image) || image.chars().any(char::is_whitespace) {
+                return Err(format!("Builder image '{}' is not a valid image", image));
             }
         }
-
-        // Port should be in valid range
-        if let Some(port) = self.exposed_port {
-            if !(1..=65535).contains(&port) {
-                return Err(format!("Port {} is not in valid range (1-65535)", port));
+        for buildpack in &self.buildpacks {
+            if !valid_reference(buildpack) || buildpack.chars().any(char::is_whitespace) {
+                return Err(format!(
+                    "Buildpack '{}' is not a valid reference",
+                    buildpack
+                ));
             }
         }
-
+        if self.node_labels.len() > MAX_BUILD_NODE_LABELS {
+            return Err(format!(
+                "At most {} build node labels can be required",
+                MAX_BUILD_NODE_LABELS
+            ));
+        }
+        if let Some(label) = self
+            .node_labels
+            .iter()
+            .find(|label| label.is_empty() || label.chars().any(char::is_whitespace))
+        {
+            return Err(format!(
+                "Build node label '{}' must be a single word",
+                label
+            ));
+        }
         Ok(())
     }
 }
 
-#[cfg(test)]
-mod tests {
-    use super::*;
+/// Platforms images can be built for
+pub const SUPPORTED_BUILD_PLATFORMS: &[&str] = &["linux/amd64", "linux/arm64", "linux/arm/v7"];
 
-    #[test]
-    fn test_default_config() {
-        let config = DeploymentConfig::default();
-        assert_eq!(config.cpu_request, None);
-        assert_eq!(config.cpu_limit, None);
-        assert!(!config.automatic_deploy);
-        assert!(!config.performance_metrics_enabled);
-        assert!(!config.session_recording_enabled);
+fn validate_build_platforms(platforms: &[String]) -> Result<(), String> {
+    if platforms.is_empty() {
+        return Err("Set at least one build platform, or none for the native one".to_string());
     }
+    for (i, platform) in platforms.iter().enumerate() {
+        if !SUPPORTED_BUILD_PLATFORMS.contains(&platform.as_str()) {
+            return Err(format!(
+                "Build platform '{}' must be one of {}",
+                platform,
+                SUPPORTED_BUILD_PLATFORMS.join(", ")
+            ));
+        }
+        if platforms[..i].contains(platform) {
+            return Err(format!("Build platform '{}' is listed twice", platform));
+        }
+    }
+    Ok(())
+}
 
-    #[test]
-    fn test_merge_configs() {
-        let project_config = DeploymentConfig {
-            cpu_request: Some(100),
+/// Persistent caches mounted into builds
+///
+/// Package manager and compiler caches (the Go module and build caches, the npm,
+/// pnpm and yarn stores, pip's cache) are kept in BuildKit cache mounts that are
+/// shared by all builds of the project, so dependencies are not downloaded and
+/// compiled from scratch on every deploy.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct BuildCacheConfig {
+    /// Mount the caches of the languages detected in the repository (default: true)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub language_caches: Option<bool>,
+
+    /// Additional directories to keep between builds
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub extra_caches: Vec<BuildCacheMount>,
+}
+
+/// A directory kept between builds in a cache mount
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct BuildCacheMount {
+    /// Name of the cache, used to report its size and clear it
+    #[schema(example = "cargo-registry")]
+    pub name: String,
+
+    /// Absolute path of the directory inside the build container
+    #[schema(example = "/usr/local/cargo/registry")]
+    pub target: String,
+
+    /// Environment variable pointing the tool at the directory, if it needs one
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "CARGO_HOME")]
+    pub env_var: Option<String>,
+}
+
+/// Most additional caches a project can mount
+pub const MAX_EXTRA_BUILD_CACHES: usize = 10;
+
+impl BuildCacheConfig {
+    /// Whether the caches of detected languages are mounted (default: true)
+    pub fn language_caches(&self) -> bool {
+        self.language_caches.unwrap_or(true)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.extra_caches.len() > MAX_EXTRA_BUILD_CACHES {
+            return Err(format!(
+                "At most {} additional build caches can be configured",
+                MAX_EXTRA_BUILD_CACHES
+            ));
+        }
+        let mut names = std::collections::HashSet::new();
+        for cache in &self.extra_caches {
+            let valid_name = !cache.name.is_empty()
+                && cache.name.len() <= 32
+                && cache
+                    .name
+                    .chars()
+                    .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-');
+            if !valid_name {
+                return Err(format!(
+                    "Build cache name '{}' must be 1-32 lowercase letters, digits or dashes",
+                    cache.name
+                ));
+            }
+            if !names.insert(cache.name.as_str()) {
+                return Err(format!("Build cache '{}' is configured twice", cache.name));
+            }
+            if !cache.target.starts_with('/') || cache.target.split('/').any(|p| p == "..") {
+                return Err(format!(
+                    "Build cache '{}' must target an absolute path",
+                    cache.name
+                ));
+            }
+            if let Some(env_var) = &cache.env_var {
+                let mut chars = env_var.chars();
+                let valid = chars
+                    .next()
+                    .is_some_and(|c| c.is_ascii_alphabetic() || c == '_')
+                    && chars.all(|c| c.is_ascii_alphanumeric() || c == '_');
+                if !valid {
+                    return Err(format!(
+                        "'{}' is not a valid environment variable name for build cache '{}'",
+                        env_var, cache.name
+                    ));
+                }
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Build stage a monorepo service shares with the other services of its repository
+///
+/// Services of a monorepo deployed as projects of the same repository can share a
+/// build stage, such as the compile of a common library. Projects naming the same
+/// stage build it once for the content of its paths, and every service's image is
+/// built on top of it: the stage's image is passed to the service's Dockerfile as the
+/// `SHARED_BUILD_IMAGE` build arg, for `FROM ${SHARED_BUILD_IMAGE}` or
+/// `COPY --from=${SHARED_BUILD_IMAGE}`. The stage only sees the files under its paths
+/// and none of the services' variables.
+///
+/// On a push, a service of the stage is only rebuilt when the push changed its own
+/// directory or the shared code: the stage's paths or its Dockerfile.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct SharedBuildConfig {
+    /// Name of the stage; the projects of a repository naming the same stage share it
+    #[schema(example = "workspace")]
+    pub name: String,
+
+    /// Dockerfile building the stage, relative to the repository root
+    #[schema(example = "docker/shared.Dockerfile")]
+    pub dockerfile: String,
+
+    /// Directories and files of the shared code, relative to the repository root
+    #[schema(example = json!(["packages/common", "Cargo.lock"]))]
+    pub paths: Vec<String>,
+}
+
+/// Most paths a shared build stage can list
+pub const MAX_SHARED_BUILD_PATHS: usize = 50;
+
+/// Build arg carrying the image of the shared stage to a service's Dockerfile
+pub const SHARED_BUILD_IMAGE_ARG: &str = "SHARED_BUILD_IMAGE";
+
+/// A path relative to the repository root without `./` and surrounding slashes;
+/// empty for the root itself
+pub fn normalize_repo_path(path: &str) -> &str {
+    let mut path = path.trim().trim_matches('/');
+    while let Some(rest) = path.strip_prefix("./") {
+        path = rest.trim_start_matches('/');
+    }
+    if path == "." {
+        ""
+    } else {
+        path
+    }
+}
+
+/// Whether a changed file is `path` or lies under it; everything is under the root
+fn path_contains(path: &str, changed_file: &str) -> bool {
+    let path = normalize_repo_path(path);
+    let changed_file = normalize_repo_path(changed_file);
+    path.is_empty()
+        || changed_file == path
+        || changed_file
+            .strip_prefix(path)
+            .is_some_and(|rest| rest.starts_with('/'))
+}
+
+impl SharedBuildConfig {
+    /// Whether a push changing these files rebuilds a service of the stage living in
+    /// `service_directory`
+    pub fn rebuilds_for(&self, service_directory: &str, changed_files: &[String]) -> bool {
+        changed_files.iter().any(|file| {
+            path_contains(service_directory, file)
+                || path_contains(&self.dockerfile, file)
+                || self.paths.iter().any(|path| path_contains(path, file))
+        })
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        let valid_name = !self.name.is_empty()
+            && self.name.len() <= 32
+            && self
+                .name
+                .chars()
+                .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-');
+        if !valid_name {
+            return Err(format!(
+                "Shared build stage name '{}' must be 1-32 lowercase letters, digits or dashes",
+                self.name
+            ));
+        }
+        let relative =
+            |path: &str| !path.trim().starts_with('/') && !path.split('/').any(|part| part == "..");
+        if normalize_repo_path(&self.dockerfile).is_empty() || !relative(&self.dockerfile) {
+            return Err(format!(
+                "Shared build Dockerfile '{}' must be a file in the repository",
+                self.dockerfile
+            ));
+        }
+        if self.paths.is_empty() {
+            return Err(format!(
+                "Shared build stage '{}' needs the paths of its shared code",
+                self.name
+            ));
+        }
+        if self.paths.len() > MAX_SHARED_BUILD_PATHS {
+            return Err(format!(
+                "A shared build stage can list at most {} paths",
+                MAX_SHARED_BUILD_PATHS
+            ));
+        }
+        if let Some(path) = self
+            .paths
+            .iter()
+            .find(|path| normalize_repo_path(path).is_empty() || !relative(path))
+        {
+            return Err(format!(
+                "Shared build path '{}' must be a directory or file below the repository root",
+                path
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// Docker log driver of a service's containers
+///
+/// Only drivers whose logs the Docker logs API can read are offered, since log
+/// streaming and the log aggregator read container output through that API.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "kebab-case")]
+pub enum ContainerLogDriver {
+    /// JSON files on the Docker host, rotated by size
+    #[default]
+    JsonFile,
+    /// Docker's compressed local format, rotated by size
+    Local,
+    /// The host's systemd journal; rotation is governed by journald.conf
+    Journald,
+}
+
+impl ContainerLogDriver {
+    /// Name of the driver as Docker knows it
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            ContainerLogDriver::JsonFile => "json-file",
+            ContainerLogDriver::Local => "local",
+            ContainerLogDriver::Journald => "journald",
+        }
+    }
+
+    /// Whether Docker rotates the driver's files itself
+    pub fn rotates(&self) -> bool {
+        !matches!(self, ContainerLogDriver::Journald)
+    }
+}
+
+/// Rotated log size when not configured, in megabytes
+pub const DEFAULT_LOG_MAX_SIZE_MB: u32 = 10;
+/// Rotated log files kept when not configured
+pub const DEFAULT_LOG_MAX_FILES: u32 = 3;
+
+/// Local logging of a service's containers
+///
+/// Container output is kept on the Docker host until it is read; rotation bounds the
+/// disk it takes to `max_size_mb * max_files` per container (30 MB by default).
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ContainerLogsConfig {
+    /// Log driver (default: json-file)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub driver: Option<ContainerLogDriver>,
+
+    /// Size at which a log file is rotated, in megabytes (default: 10)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 10)]
+    pub max_size_mb: Option<u32>,
+
+    /// Log files kept per container, including the current one (default: 3)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 3)]
+    pub max_files: Option<u32>,
+
+    /// Collapse consecutive identical lines into one with a count when logs are
+    /// streamed (default: false)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub coalesce_repeats: Option<bool>,
+
+    /// Order lines by the timestamps the app writes in them rather than by when
+    /// Docker read them (default: Docker's time)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub timestamp: Option<LogTimestampConfig>,
+}
+
+impl ContainerLogsConfig {
+    pub fn driver(&self) -> ContainerLogDriver {
+        self.driver.unwrap_or_default()
+    }
+
+    pub fn coalesces_repeats(&self) -> bool {
+        self.coalesce_repeats.unwrap_or(false)
+    }
+
+    /// Docker log options for the driver
+    pub fn driver_options(&self) -> HashMap<String, String> {
+        let mut options = HashMap::new();
+        if self.driver().rotates() {
+            options.insert(
+                "max-size".to_string(),
+                format!("{}m", self.max_size_mb.unwrap_or(DEFAULT_LOG_MAX_SIZE_MB)),
+            );
+            options.insert(
+                "max-file".to_string(),
+                self.max_files.unwrap_or(DEFAULT_LOG_MAX_FILES).to_string(),
+            );
+        }
+        options
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if !self.driver().rotates() && (self.max_size_mb.is_some() || self.max_files.is_some()) {
+            return Err(format!(
+                "Log rotation of the {} driver is configured on the host, not per service",
+                self.driver().as_str()
+            ));
+        }
+        if let Some(max_size_mb) = self.max_size_mb {
+            if !(1..=1024).contains(&max_size_mb) {
+                return Err("Log file size must be between 1 and 1024 MB".to_string());
+            }
+        }
+        if let Some(max_files) = self.max_files {
+            if !(1..=100).contains(&max_files) {
+                return Err("Between 1 and 100 log files can be kept".to_string());
+            }
+        }
+        if let Some(timestamp) = &self.timestamp {
+            timestamp.validate()?;
+        }
+        Ok(())
+    }
+}
+
+/// Largest skew accepted when not configured for timestamps ahead of Docker's clock
+pub const DEFAULT_MAX_FUTURE_SKEW_SECONDS: u32 = 5 * 60;
+/// Largest skew accepted when not configured for timestamps behind Docker's clock
+pub const DEFAULT_MAX_PAST_SKEW_SECONDS: u32 = 60 * 60;
+/// Largest skew that can be configured either way (7 days)
+pub const MAX_LOG_TIMESTAMP_SKEW_SECONDS: u32 = 7 * 24 * 60 * 60;
+/// Format names understood besides strftime patterns
+pub const LOG_TIMESTAMP_FORMATS: &[&str] = &["rfc3339", "unix", "unix_ms"];
+
+/// Timestamps the app writes in its own log lines
+///
+/// Lines are ordered by the time Docker read them unless the app's timestamp is
+/// used instead. It is read from a field of JSON lines, or from the start of plain
+/// lines when no field is set. A line whose timestamp is missing, unparseable or
+/// too far from Docker's clock keeps the time Docker read it.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct LogTimestampConfig {
+    /// Field of JSON lines holding the timestamp; unset reads it from the start of
+    /// the line
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "time")]
+    pub field: Option<String>,
+
+    /// `rfc3339`, `unix`, `unix_ms` or a strftime pattern such as
+    /// `%Y-%m-%d %H:%M:%S%.3f`, read as UTC (default: rfc3339)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "rfc3339")]
+    pub format: Option<String>,
+
+    /// How far ahead of Docker's clock a timestamp may be, in seconds (default: 300)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_future_skew_seconds: Option<u32>,
+
+    /// How far behind Docker's clock a timestamp may be, in seconds (default: 3600)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_past_skew_seconds: Option<u32>,
+}
+
+impl LogTimestampConfig {
+    pub fn format(&self) -> &str {
+        self.format.as_deref().unwrap_or("rfc3339")
+    }
+
+    pub fn max_future_skew_seconds(&self) -> u32 {
+        self.max_future_skew_seconds
+            .unwrap_or(DEFAULT_MAX_FUTURE_SKEW_SECONDS)
+    }
+
+    pub fn max_past_skew_seconds(&self) -> u32 {
+        self.max_past_skew_seconds
+            .unwrap_or(DEFAULT_MAX_PAST_SKEW_SECONDS)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if let Some(field) = &self.field {
+            if field.trim().is_empty() || field.len() > 128 {
+                return Err("Timestamp field must be between 1 and 128 characters".to_string());
+            }
+        }
+        let format = self.format();
+        if !LOG_TIMESTAMP_FORMATS.contains(&format) {
+            let invalid = format.is_empty()
+                || chrono::format::StrftimeItems::new(format)
+                    .any(|item| item == chrono::format::Item::Error);
+            if invalid {
+                return Err(format!(
+                    "Timestamp format '{}' is neither one of {} nor a strftime pattern",
+                    format,
+                    LOG_TIMESTAMP_FORMATS.join(", ")
+                ));
+            }
+        }
+        for skew in [self.max_future_skew_seconds, self.max_past_skew_seconds]
+            .into_iter()
+            .flatten()
+        {
+            if !(1..=MAX_LOG_TIMESTAMP_SKEW_SECONDS).contains(&skew) {
+                return Err(format!(
+                    "Timestamp skew must be between 1 and {} seconds",
+                    MAX_LOG_TIMESTAMP_SKEW_SECONDS
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Timezone of a service's containers when not configured
+pub const DEFAULT_TIMEZONE: &str = "UTC";
+/// Locale of a service's containers when not configured
+pub const DEFAULT_LOCALE: &str = "C.UTF-8";
+/// Where the tz database lives, on the host and in the containers
+pub const TZDATA_PATH: &str = "/usr/share/zoneinfo";
+
+/// Timezone and locale of a service's containers
+///
+/// Injected as `TZ` and `LANG`, which the service's own environment variables
+/// override. Images without tzdata (alpine, distroless) ignore `TZ`, so the host's
+/// tz database can be mounted read-only into the containers.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct LocaleDefaultsConfig {
+    /// IANA timezone, e.g. `Europe/Berlin` (default: UTC)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "Europe/Berlin")]
+    pub timezone: Option<String>,
+
+    /// Locale, e.g. `de_DE.UTF-8` (default: C.UTF-8)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "de_DE.UTF-8")]
+    pub locale: Option<String>,
+
+    /// Mount the host's tz database read-only at /usr/share/zoneinfo (default: false)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub mount_tzdata: Option<bool>,
+}
+
+impl LocaleDefaultsConfig {
+    pub fn timezone(&self) -> &str {
+        self.timezone.as_deref().unwrap_or(DEFAULT_TIMEZONE)
+    }
+
+    pub fn locale(&self) -> &str {
+        self.locale.as_deref().unwrap_or(DEFAULT_LOCALE)
+    }
+
+    pub fn mount_tzdata(&self) -> bool {
+        self.mount_tzdata.unwrap_or(false)
+    }
+
+    /// `TZ` and `LANG` environment variables of the containers
+    pub fn env_vars(&self) -> HashMap<String, String> {
+        HashMap::from([
+            ("TZ".to_string(), self.timezone().to_string()),
+            ("LANG".to_string(), self.locale().to_string()),
+        ])
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if let Some(timezone) = &self.timezone {
+            if !is_valid_timezone_name(timezone) {
+                return Err(format!(
+                    "'{}' is not an IANA timezone name such as Europe/Berlin or UTC",
+                    timezone
+                ));
+            }
+        }
+        if let Some(locale) = &self.locale {
+            if !is_valid_locale_name(locale) {
+                return Err(format!(
+                    "'{}' is not a locale name such as en_US.UTF-8 or C.UTF-8",
+                    locale
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Whether a name looks like a tz database entry: `/`-separated segments of letters,
+/// digits, `_`, `-` and `+`, without relative path segments
+fn is_valid_timezone_name(name: &str) -> bool {
+    name.len() <= 64
+        && !name.starts_with('/')
+        && name.split('/').all(|segment| {
+            !segment.is_empty()
+                && segment != "."
+                && segment != ".."
+                && segment
+                    .chars()
+                    .all(|c| c.is_ascii_alphanumeric() || matches!(c, '_' | '-' | '+'))
+        })
+        && name.chars().next().is_some_and(|c| c.is_ascii_alphabetic())
+}
+
+/// Whether a name has the POSIX locale shape `language[_TERRITORY][.codeset][@modifier]`
+fn is_valid_locale_name(name: &str) -> bool {
+    let (rest, modifier) = match name.split_once('@') {
+        Some((rest, modifier)) => (rest, Some(modifier)),
+        None => (name, None),
+    };
+    let (rest, codeset) = match rest.split_once('.') {
+        Some((rest, codeset)) => (rest, Some(codeset)),
+        None => (rest, None),
+    };
+    let (language, territory) = match rest.split_once('_') {
+        Some((language, territory)) => (language, Some(territory)),
+        None => (rest, None),
+    };
+
+    let is_word = |part: &str, chars: fn(char) -> bool| {
+        !part.is_empty() && part.len() <= 16 && part.chars().all(chars)
+    };
+    (language == "C" || language == "POSIX" || is_word(language, |c| c.is_ascii_lowercase()))
+        && territory.is_none_or(|t| is_word(t, |c| c.is_ascii_alphanumeric()))
+        && codeset.is_none_or(|c| is_word(c, |c| c.is_ascii_alphanumeric() || c == '-'))
+        && modifier.is_none_or(|m| is_word(m, |c| c.is_ascii_alphanumeric()))
+}
+
+/// Seconds between startup probe attempts when not configured
+pub const DEFAULT_STARTUP_PROBE_PERIOD_SECONDS: u32 = 10;
+/// Seconds a startup probe attempt may take when not configured
+pub const DEFAULT_STARTUP_PROBE_TIMEOUT_SECONDS: u32 = 5;
+/// Failed startup probe attempts allowed when not configured; 10 minutes at the
+/// default period
+pub const DEFAULT_STARTUP_PROBE_FAILURE_THRESHOLD: u32 = 60;
+
+/// Probe a new container must pass before its regular health checks begin
+///
+/// Slow-starting apps (a JVM warming up, migrations run on boot) can take longer to
+/// come up than the health checks allow, and get their deploy failed while still
+/// booting. The startup probe gives them `period_seconds * failure_threshold` to
+/// answer with a 2xx or 3xx, counting connection errors and error statuses alike;
+/// once it passes, the regular health checks run with their usual cadence and
+/// timeouts. A container that exits while being probed fails right away.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct StartupProbeConfig {
+    /// Path requested; the health check path when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "/health/started")]
+    pub path: Option<String>,
+
+    /// Seconds between attempts (default: 10)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 10)]
+    pub period_seconds: Option<u32>,
+
+    /// Seconds each attempt may take (default: 5)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 5)]
+    pub timeout_seconds: Option<u32>,
+
+    /// Failed attempts allowed before the deploy fails (default: 60)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 60)]
+    pub failure_threshold: Option<u32>,
+}
+
+impl StartupProbeConfig {
+    pub fn period_seconds(&self) -> u32 {
+        self.period_seconds
+            .unwrap_or(DEFAULT_STARTUP_PROBE_PERIOD_SECONDS)
+    }
+
+    pub fn timeout_seconds(&self) -> u32 {
+        self.timeout_seconds
+            .unwrap_or(DEFAULT_STARTUP_PROBE_TIMEOUT_SECONDS)
+    }
+
+    pub fn failure_threshold(&self) -> u32 {
+        self.failure_threshold
+            .unwrap_or(DEFAULT_STARTUP_PROBE_FAILURE_THRESHOLD)
+    }
+
+    /// Longest an app gets to start
+    pub fn startup_budget(&self) -> std::time::Duration {
+        std::time::Duration::from_secs(
+            self.period_seconds() as u64 * self.failure_threshold() as u64,
+        )
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if let Some(path) = &self.path {
+            if !path.starts_with('/') {
+                return Err("Startup probe path must start with '/'".to_string());
+            }
+        }
+        if !(1..=300).contains(&self.period_seconds()) {
+            return Err("Startup probe period must be between 1 and 300 seconds".to_string());
+        }
+        if !(1..=60).contains(&self.timeout_seconds()) {
+            return Err("Startup probe timeout must be between 1 and 60 seconds".to_string());
+        }
+        if self.timeout_seconds() > self.period_seconds() {
+            return Err("Startup probe timeout can't be longer than its period".to_string());
+        }
+        if !(1..=1000).contains(&self.failure_threshold()) {
+            return Err("Startup probe failure threshold must be between 1 and 1000".to_string());
+        }
+        Ok(())
+    }
+}
+
+/// Seconds between exec health check runs when not configured
+pub const DEFAULT_EXEC_HEALTH_CHECK_INTERVAL_SECONDS: u32 = 5;
+/// Seconds an exec health check run may take when not configured
+pub const DEFAULT_EXEC_HEALTH_CHECK_TIMEOUT_SECONDS: u32 = 5;
+/// Seconds after start in which failed runs don't count when not configured
+pub const DEFAULT_EXEC_HEALTH_CHECK_START_PERIOD_SECONDS: u32 = 30;
+/// Passing runs in a row that make a container healthy when not configured
+pub const DEFAULT_EXEC_HEALTH_CHECK_SUCCESS_THRESHOLD: u32 = 2;
+/// Failed runs in a row that fail the deploy when not configured; a minute at the
+/// default interval
+pub const DEFAULT_EXEC_HEALTH_CHECK_FAILURE_THRESHOLD: u32 = 12;
+
+/// Health check running a command inside the container, like Docker's
+/// `HEALTHCHECK CMD`
+///
+/// For apps that don't answer HTTP on a port — listening on a unix socket, or
+/// checked with a CLI of their own — the command's exit code is their health: 0 is
+/// healthy, anything else, or running past the timeout, is a failed run. It replaces
+/// the HTTP health check of new containers. Runs failing within the start period
+/// don't count, so booting apps aren't failed while coming up.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ExecHealthCheckConfig {
+    /// Command and its arguments, run without a shell; wrap it in `sh -c` for one
+    #[schema(example = json!(["curl", "-fsS", "--unix-socket", "/run/app.sock", "http://localhost/health"]))]
+    pub command: Vec<String>,
+
+    /// Seconds between runs (default: 5)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 5)]
+    pub interval_seconds: Option<u32>,
+
+    /// Seconds each run may take (default: 5)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 5)]
+    pub timeout_seconds: Option<u32>,
+
+    /// Seconds after the container starts in which failed runs don't count
+    /// (default: 30)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 30)]
+    pub start_period_seconds: Option<u32>,
+
+    /// Passing runs in a row that make the container healthy (default: 2)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 2)]
+    pub success_threshold: Option<u32>,
+
+    /// Failed runs in a row that fail the deploy (default: 12)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 12)]
+    pub failure_threshold: Option<u32>,
+}
+
+impl ExecHealthCheckConfig {
+    pub fn interval_seconds(&self) -> u32 {
+        self.interval_seconds
+            .unwrap_or(DEFAULT_EXEC_HEALTH_CHECK_INTERVAL_SECONDS)
+    }
+
+    pub fn timeout_seconds(&self) -> u32 {
+        self.timeout_seconds
+            .unwrap_or(DEFAULT_EXEC_HEALTH_CHECK_TIMEOUT_SECONDS)
+    }
+
+    pub fn start_period_seconds(&self) -> u32 {
+        self.start_period_seconds
+            .unwrap_or(DEFAULT_EXEC_HEALTH_CHECK_START_PERIOD_SECONDS)
+    }
+
+    pub fn success_threshold(&self) -> u32 {
+        self.success_threshold
+            .unwrap_or(DEFAULT_EXEC_HEALTH_CHECK_SUCCESS_THRESHOLD)
+    }
+
+    pub fn failure_threshold(&self) -> u32 {
+        self.failure_threshold
+            .unwrap_or(DEFAULT_EXEC_HEALTH_CHECK_FAILURE_THRESHOLD)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        match self.command.first() {
+            Some(program) if !program.trim().is_empty() => {}
+            _ => return Err("Exec health check needs a command".to_string()),
+        }
+        if self.command.iter().any(|arg| arg.contains('\0')) {
+            return Err("Exec health check command can't contain NUL bytes".to_string());
+        }
+        if !(1..=300).contains(&self.interval_seconds()) {
+            return Err("Exec health check interval must be between 1 and 300 seconds".to_string());
+        }
+        if !(1..=60).contains(&self.timeout_seconds()) {
+            return Err("Exec health check timeout must be between 1 and 60 seconds".to_string());
+        }
+        if self.timeout_seconds() > self.interval_seconds() {
+            return Err("Exec health check timeout can't be longer than its interval".to_string());
+        }
+        if self.start_period_seconds() > 3600 {
+            return Err("Exec health check start period can't be longer than an hour".to_string());
+        }
+        if !(1..=10).contains(&self.success_threshold()) {
+            return Err("Exec health check success threshold must be between 1 and 10".to_string());
+        }
+        if !(1..=1000).contains(&self.failure_threshold()) {
+            return Err(
+                "Exec health check failure threshold must be between 1 and 1000".to_string(),
+            );
+        }
+        Ok(())
+    }
+}
+
+/// Failed health checks in a row that mark a service unhealthy when not configured
+pub const DEFAULT_UNHEALTHY_THRESHOLD: u32 = 3;
+/// Most failed health checks in a row a threshold can wait for
+pub const MAX_HEALTH_THRESHOLD: u32 = 1000;
+
+/// Health of a running service, from its health checks failed in a row
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ServiceHealthState {
+    /// Its last health check passed
+    Healthy,
+    /// Failed fewer checks in a row than make it unhealthy; a blip so far
+    Failing,
+    /// Failed at least the unhealthy threshold of checks in a row
+    Unhealthy,
+}
+
+impl ServiceHealthState {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Healthy => "healthy",
+            Self::Failing => "failing",
+            Self::Unhealthy => "unhealthy",
+        }
+    }
+}
+
+/// How many failed health checks in a row a running service tolerates
+///
+/// A single failed check is a blip and doesn't change much. Once `unhealthyAfter`
+/// checks fail in a row the service is marked unhealthy, for its status and alerts;
+/// once the higher `restartAfter` fail its containers are restarted, and again after
+/// every further `restartAfter` failures while it stays down. A passing check resets
+/// the count. Services are never restarted automatically unless `restartAfter` is set.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct HealthThresholdsConfig {
+    /// Failed checks in a row that mark the service unhealthy (default: 3)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 3)]
+    pub unhealthy_after: Option<u32>,
+
+    /// Failed checks in a row that restart the service's containers; higher than
+    /// `unhealthyAfter`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 10)]
+    pub restart_after: Option<u32>,
+}
+
+impl HealthThresholdsConfig {
+    pub fn unhealthy_after(&self) -> u32 {
+        self.unhealthy_after.unwrap_or(DEFAULT_UNHEALTHY_THRESHOLD)
+    }
+
+    /// Health of a service that failed this many checks in a row
+    pub fn state_after(&self, consecutive_failures: u32) -> ServiceHealthState {
+        if consecutive_failures == 0 {
+            ServiceHealthState::Healthy
+        } else if consecutive_failures < self.unhealthy_after() {
+            ServiceHealthState::Failing
+        } else {
+            ServiceHealthState::Unhealthy
+        }
+    }
+
+    /// Whether the failed check that made this many in a row restarts the service
+    pub fn restart_due(&self, consecutive_failures: u32) -> bool {
+        match self.restart_after {
+            Some(restart_after) if restart_after > 0 => {
+                consecutive_failures > 0 && consecutive_failures % restart_after == 0
+            }
+            _ => false,
+        }
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if !(1..=MAX_HEALTH_THRESHOLD).contains(&self.unhealthy_after()) {
+            return Err(format!(
+                "Unhealthy threshold must be between 1 and {} failed checks",
+                MAX_HEALTH_THRESHOLD
+            ));
+        }
+        if let Some(restart_after) = self.restart_after {
+            if !(1..=MAX_HEALTH_THRESHOLD).contains(&restart_after) {
+                return Err(format!(
+                    "Restart threshold must be between 1 and {} failed checks",
+                    MAX_HEALTH_THRESHOLD
+                ));
+            }
+            if restart_after <= self.unhealthy_after() {
+                return Err(format!(
+                    "Restart threshold ({}) must be higher than the unhealthy threshold ({})",
+                    restart_after,
+                    self.unhealthy_after()
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Directory the runtime config file is mounted at in the containers
+pub const RUNTIME_CONFIG_DIR: &str = "/etc/temps";
+/// Runtime config file in it: a JSON object of the values, by key
+pub const RUNTIME_CONFIG_FILE: &str = "runtime-config.json";
+/// Signal a service reloading by signal gets when none is configured
+pub const DEFAULT_CONFIG_RELOAD_SIGNAL: &str = "SIGHUP";
+
+/// How running containers pick up a changed runtime config value
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ConfigReloadMethod {
+    /// The containers are restarted in place; for apps that read their config once
+    #[default]
+    Restart,
+    /// The containers get a signal, after which the app reads the file again
+    Signal,
+    /// Nothing is sent; the app watches the file
+    Watch,
+}
+
+/// Reload of a service's runtime config without a redeploy
+///
+/// Runtime config values are non-secret settings, such as feature flags or log
+/// levels, kept in `/etc/temps/runtime-config.json`, which is mounted read-only into
+/// the containers. Changing a value rewrites the file without rebuilding or
+/// recreating the containers; apps that can reload are signalled or watch the file,
+/// and the others are restarted in place. A reload that fails falls back to a restart.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ConfigReloadConfig {
+    /// How the app picks up changes (default: restart)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub method: Option<ConfigReloadMethod>,
+
+    /// Signal sent with the signal method, e.g. `SIGUSR1` (default: SIGHUP)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "SIGHUP")]
+    pub signal: Option<String>,
+}
+
+impl ConfigReloadConfig {
+    pub fn method(&self) -> ConfigReloadMethod {
+        self.method.unwrap_or_default()
+    }
+
+    pub fn signal(&self) -> &str {
+        self.signal
+            .as_deref()
+            .unwrap_or(DEFAULT_CONFIG_RELOAD_SIGNAL)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if let Some(signal) = &self.signal {
+            if self.method() != ConfigReloadMethod::Signal {
+                return Err("A config reload signal needs the signal method".to_string());
+            }
+            if !is_stop_signal(signal) {
+                return Err(format!(
+                    "Config reload signal '{}' is not a signal name such as SIGHUP or a signal number",
+                    signal
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Time a smoke test may take when not configured, in seconds
+pub const DEFAULT_SMOKE_TEST_TIMEOUT_SECONDS: u32 = 10;
+/// Smoke tests a service can have
+pub const MAX_SMOKE_TESTS: usize = 50;
+
+/// HTTP methods a smoke test can send
+const SMOKE_TEST_METHODS: &[&str] = &["GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"];
+
+/// HTTP check run against a new deployment before it receives traffic
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct SmokeTest {
+    /// Name shown in the deployment's results
+    #[schema(example = "health")]
+    pub name: String,
+
+    /// HTTP method (default: GET)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "GET")]
+    pub method: Option<String>,
+
+    /// Path requested from each new container, starting with `/`
+    #[schema(example = "/api/health")]
+    pub path: String,
+
+    /// Request headers
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub headers: HashMap<String, String>,
+
+    /// Request body
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub body: Option<String>,
+
+    /// Expected response status (default: any 2xx status)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 200)]
+    pub expect_status: Option<u16>,
+
+    /// Text the response body must contain
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "\"status\":\"ok\"")]
+    pub expect_body_contains: Option<String>,
+}
+
+impl SmokeTest {
+    pub fn method(&self) -> String {
+        self.method
+            .as_deref()
+            .map(|method| method.to_uppercase())
+            .unwrap_or_else(|| "GET".to_string())
+    }
+
+    /// Why a response fails the test, None when it passes
+    pub fn check_response(&self, status: u16, body: &str) -> Option<String> {
+        match self.expect_status {
+            Some(expected) if status != expected => {
+                return Some(format!("Expected status {}, got {}", expected, status));
+            }
+            None if !(200..300).contains(&status) => {
+                return Some(format!("Expected a 2xx status, got {}", status));
+            }
+            _ => {}
+        }
+        if let Some(expected) = &self.expect_body_contains {
+            if !body.contains(expected.as_str()) {
+                return Some(format!("Response body does not contain '{}'", expected));
+            }
+        }
+        None
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.name.trim().is_empty() {
+            return Err("Smoke tests need a name".to_string());
+        }
+        if !self.path.starts_with('/') {
+            return Err(format!(
+                "Path of smoke test '{}' must start with '/'",
+                self.name
+            ));
+        }
+        if !SMOKE_TEST_METHODS.contains(&self.method().as_str()) {
+            return Err(format!(
+                "Smoke test '{}' uses unsupported HTTP method '{}'",
+                self.name,
+                self.method()
+            ));
+        }
+        if let Some(status) = self.expect_status {
+            if !(100..=599).contains(&status) {
+                return Err(format!(
+                    "Smoke test '{}' expects status {}, which is not an HTTP status",
+                    self.name, status
+                ));
+            }
+        }
+        if self.expect_body_contains.is_some() && self.method() == "HEAD" {
+            return Err(format!(
+                "Smoke test '{}' checks the body of a HEAD response, which has none",
+                self.name
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// Smoke tests of a service
+///
+/// Run against each new container's internal address once it is healthy and before
+/// traffic is switched to it. If any check fails the deployment fails, the new
+/// containers are removed and the current deployment keeps serving.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct SmokeTestsConfig {
+    /// Checks, run in order
+    #[serde(default)]
+    pub checks: Vec<SmokeTest>,
+
+    /// Time each check may take, in seconds (default: 10)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 10)]
+    pub timeout_seconds: Option<u32>,
+}
+
+impl SmokeTestsConfig {
+    pub fn timeout_seconds(&self) -> u32 {
+        self.timeout_seconds
+            .unwrap_or(DEFAULT_SMOKE_TEST_TIMEOUT_SECONDS)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.checks.len() > MAX_SMOKE_TESTS {
+            return Err(format!(
+                "At most {} smoke tests can be configured",
+                MAX_SMOKE_TESTS
+            ));
+        }
+        for (index, check) in self.checks.iter().enumerate() {
+            check.validate()?;
+            if self.checks[..index]
+                .iter()
+                .any(|other| other.name == check.name)
+            {
+                return Err(format!("Smoke test '{}' is defined twice", check.name));
+            }
+        }
+        if let Some(timeout_seconds) = self.timeout_seconds {
+            if !(1..=300).contains(&timeout_seconds) {
+                return Err("Smoke test timeout must be between 1 and 300 seconds".to_string());
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Nameservers a container can have (the resolv.conf limit)
+pub const MAX_DNS_NAMESERVERS: usize = 3;
+/// Search domains a container can have
+pub const MAX_DNS_SEARCH_DOMAINS: usize = 6;
+/// `/etc/hosts` entries a service can add
+pub const MAX_EXTRA_HOSTS: usize = 50;
+/// Address Docker replaces with the host's gateway address in an extra host
+pub const HOST_GATEWAY: &str = "host-gateway";
+
+/// `/etc/hosts` entry of a service's containers
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ExtraHost {
+    /// Name resolved to the address
+    #[schema(example = "db.internal.example.com")]
+    pub hostname: String,
+
+    /// IPv4 or IPv6 address, or `host-gateway` for the Docker host
+    #[schema(example = "10.0.0.12")]
+    pub ip: String,
+}
+
+impl ExtraHost {
+    /// The entry as Docker's `--add-host` takes it, `hostname:ip`
+    pub fn to_docker(&self) -> String {
+        format!("{}:{}", self.hostname, self.ip)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if !is_valid_hostname(&self.hostname) {
+            return Err(format!(
+                "'{}' is not a valid host name for an extra host",
+                self.hostname
+            ));
+        }
+        if self.ip != HOST_GATEWAY && self.ip.parse::<std::net::IpAddr>().is_err() {
+            return Err(format!(
+                "Extra host '{}' maps to '{}', which is not an IP address or {}",
+                self.hostname, self.ip, HOST_GATEWAY
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// Name resolution of a service's containers (`--dns`, `--dns-search`, `--add-host`)
+///
+/// Containers on the platform network keep using Docker's embedded resolver, which
+/// answers for the other services on the network and forwards every other lookup to
+/// the configured nameservers, so service discovery works either way. Without
+/// nameservers, lookups are forwarded to the host's resolvers.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ContainerDnsConfig {
+    /// Nameservers external lookups are forwarded to, at most 3
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    #[schema(example = json!(["10.0.0.2", "1.1.1.1"]))]
+    pub nameservers: Vec<String>,
+
+    /// Domains searched for unqualified names, at most 6
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    #[schema(example = json!(["internal.example.com"]))]
+    pub search_domains: Vec<String>,
+
+    /// Entries added to the containers' `/etc/hosts`
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub extra_hosts: Vec<ExtraHost>,
+}
+
+impl ContainerDnsConfig {
+    pub fn is_empty(&self) -> bool {
+        self.nameservers.is_empty() && self.search_domains.is_empty() && self.extra_hosts.is_empty()
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.nameservers.len() > MAX_DNS_NAMESERVERS {
+            return Err(format!(
+                "At most {} nameservers can be configured",
+                MAX_DNS_NAMESERVERS
+            ));
+        }
+        for nameserver in &self.nameservers {
+            if nameserver.parse::<std::net::IpAddr>().is_err() {
+                return Err(format!("Nameserver '{}' is not an IP address", nameserver));
+            }
+        }
+
+        if self.search_domains.len() > MAX_DNS_SEARCH_DOMAINS {
+            return Err(format!(
+                "At most {} search domains can be configured",
+                MAX_DNS_SEARCH_DOMAINS
+            ));
+        }
+        for domain in &self.search_domains {
+            if !is_valid_hostname(domain) {
+                return Err(format!("'{}' is not a valid search domain", domain));
+            }
+        }
+
+        if self.extra_hosts.len() > MAX_EXTRA_HOSTS {
+            return Err(format!(
+                "At most {} extra hosts can be configured",
+                MAX_EXTRA_HOSTS
+            ));
+        }
+        for (index, host) in self.extra_hosts.iter().enumerate() {
+            host.validate()?;
+            if self.extra_hosts[..index]
+                .iter()
+                .any(|other| other.hostname.eq_ignore_ascii_case(&host.hostname))
+            {
+                return Err(format!("Extra host '{}' is defined twice", host.hostname));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Whether a name is a DNS host name: dot-separated labels of letters, digits and
+/// inner hyphens, up to 63 characters each and 253 in total
+fn is_valid_hostname(name: &str) -> bool {
+    let name = name.strip_suffix('.').unwrap_or(name);
+    !name.is_empty()
+        && name.len() <= 253
+        && name.split('.').all(|label| {
+            !label.is_empty()
+                && label.len() <= 63
+                && !label.starts_with('-')
+                && !label.ends_with('-')
+                && label.chars().all(|c| c.is_ascii_alphanumeric() || c == '-')
+        })
+}
+
+/// Ulimits a service can set, with the largest value each accepts; `None` also
+/// accepts -1, unlimited
+pub const SUPPORTED_ULIMITS: &[(&str, Option<i64>)] = &[
+    ("nofile", Some(1_048_576)),
+    ("nproc", Some(4_194_304)),
+    ("memlock", None),
+    ("stack", None),
+    ("core", None),
+];
+/// Largest `/dev/shm` of a container, in megabytes
+pub const MAX_SHM_SIZE_MB: u32 = 32 * 1024;
+
+/// Resource limit of a container's processes (`--ulimit name=soft:hard`)
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct UlimitConfig {
+    /// One of `nofile`, `nproc`, `memlock`, `stack` or `core`
+    #[schema(example = "nofile")]
+    pub name: String,
+
+    /// Limit the processes start with
+    #[schema(example = 65536)]
+    pub soft: i64,
+
+    /// Ceiling processes can raise the soft limit to; the soft limit when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 65536)]
+    pub hard: Option<i64>,
+}
+
+impl UlimitConfig {
+    pub fn hard(&self) -> i64 {
+        self.hard.unwrap_or(self.soft)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        let Some((_, max)) = SUPPORTED_ULIMITS
+            .iter()
+            .find(|(name, _)| *name == self.name)
+        else {
+            return Err(format!(
+                "Unsupported ulimit '{}'; supported ulimits are {}",
+                self.name,
+                SUPPORTED_ULIMITS
+                    .iter()
+                    .map(|(name, _)| *name)
+                    .collect::<Vec<_>>()
+                    .join(", ")
+            ));
+        };
+        for value in [self.soft, self.hard()] {
+            let in_bounds = match max {
+                Some(max) => (1..=*max).contains(&value),
+                None => value >= -1,
+            };
+            if !in_bounds {
+                return Err(match max {
+                    Some(max) => format!("Ulimit '{}' must be between 1 and {}", self.name, max),
+                    None => format!(
+                        "Ulimit '{}' must be 0 or more, or -1 for unlimited",
+                        self.name
+                    ),
+                });
+            }
+        }
+        // -1, unlimited, is above every other value
+        let hard = self.hard();
+        if hard != -1 && (self.soft == -1 || self.soft > hard) {
+            return Err(format!(
+                "The soft limit of ulimit '{}' can't exceed its hard limit",
+                self.name
+            ));
+        }
+        Ok(())
+    }
+}
+
+/// Ulimits and shared memory of a service's containers (`--ulimit`, `--shm-size`)
+///
+/// Databases and headless browsers often need more open files, processes or
+/// `/dev/shm` than Docker's defaults. Unset values keep those defaults.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ContainerLimitsConfig {
+    /// Ulimits of the containers, each name at most once
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub ulimits: Vec<UlimitConfig>,
+
+    /// Size of `/dev/shm` in megabytes; Docker's 64 MB when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 1024)]
+    pub shm_size_mb: Option<u32>,
+}
+
+impl ContainerLimitsConfig {
+    pub fn validate(&self) -> Result<(), String> {
+        for (index, ulimit) in self.ulimits.iter().enumerate() {
+            ulimit.validate()?;
+            if self.ulimits[..index]
+                .iter()
+                .any(|other| other.name == ulimit.name)
+            {
+                return Err(format!("Ulimit '{}' is set twice", ulimit.name));
+            }
+        }
+        if let Some(shm_size_mb) = self.shm_size_mb {
+            if !(1..=MAX_SHM_SIZE_MB).contains(&shm_size_mb) {
+                return Err(format!(
+                    "Shared memory size must be between 1 and {} MB",
+                    MAX_SHM_SIZE_MB
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Seconds a deploy gate waits for its verdict when not configured
+pub const DEFAULT_DEPLOY_GATE_TIMEOUT_SECONDS: u32 = 1800;
+/// Seconds between status checks of a polling deploy gate when not configured
+pub const DEFAULT_DEPLOY_GATE_POLL_INTERVAL_SECONDS: u32 = 10;
+
+/// What a stored secret is returned as in API responses. An update sending it back
+/// keeps the stored secret
+pub const MASKED_SECRET: &str = "******";
+
+/// Turn a secret from an update into what is stored: the stored ciphertext when it was
+/// sent back masked, else its encryption
+fn seal_secret(
+    value: &mut String,
+    stored: Option<&str>,
+    encryption: &EncryptionService,
+) -> Result<(), String> {
+    if value == MASKED_SECRET {
+        *value = stored
+            .ok_or_else(|| "A masked secret was sent, but none is stored".to_string())?
+            .to_string();
+        return Ok(());
+    }
+    *value = encryption
+        .encrypt_string(value)
+        .map_err(|e| format!("Failed to encrypt secret: {}", e))?;
+    Ok(())
+}
+
+fn open_secret(value: &str, encryption: &EncryptionService) -> Result<String, String> {
+    encryption
+        .decrypt_string(value)
+        .map_err(|e| format!("Failed to decrypt secret: {}", e))
+}
+
+/// How a deploy gate learns the external system's verdict
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum DeployGateMode {
+    /// The external system posts the verdict to the callback URL sent with the request
+    #[default]
+    Callback,
+    /// Temps polls a status URL until it reports a verdict
+    Poll,
+}
+
+/// External check a new deployment must pass before it receives traffic
+///
+/// Once the new containers are healthy (and the smoke tests passed), the deploy context
+/// is POSTed to `url`, e.g. to start an end-to-end suite in CI. The deployment then
+/// waits for the verdict, from a callback or by polling a status URL, and fails if it
+/// is negative or doesn't arrive in time; the current deployment keeps serving.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct DeployGateConfig {
+    /// URL the deploy context is POSTed to
+    #[schema(example = "https://ci.example.com/hooks/temps-deploy")]
+    pub url: String,
+
+    /// How the verdict is received (default: callback)
+    #[serde(default)]
+    pub mode: DeployGateMode,
+
+    /// Headers sent with the request and with status checks, e.g. `Authorization`.
+    /// Values of credential headers are stored encrypted and returned masked
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub headers: HashMap<String, String>,
+
+    /// Secret the request body is signed with, sent as `X-Webhook-Signature`
+    /// (`sha256=` HMAC of `{timestamp}.{body}`) like Temps webhooks. Stored encrypted
+    /// and returned masked
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub secret: Option<String>,
+
+    /// Status URL polled in poll mode; a `statusUrl` in the response to the request
+    /// takes precedence
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "https://ci.example.com/api/runs/latest")]
+    pub status_url: Option<String>,
+
+    /// Seconds between status checks in poll mode (default: 10)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 10)]
+    pub poll_interval_seconds: Option<u32>,
+
+    /// Seconds to wait for the verdict before failing the deployment (default: 1800)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 1800)]
+    pub timeout_seconds: Option<u32>,
+}
+
+impl DeployGateConfig {
+    /// Whether a header carries credentials, e.g. `Authorization` or `X-Api-Key`
+    pub fn is_sensitive_header(name: &str) -> bool {
+        let name = name.to_ascii_lowercase();
+        name == "cookie"
+            || ["auth", "token", "secret", "key", "password", "signature"]
+                .iter()
+                .any(|word| name.contains(word))
+    }
+
+    /// Copy for API responses, with the secret and credential headers masked
+    pub fn masked(&self) -> Self {
+        let mut gate = self.clone();
+        if let Some(secret) = gate.secret.as_mut() {
+            *secret = MASKED_SECRET.to_string();
+        }
+        for (name, value) in gate.headers.iter_mut() {
+            if Self::is_sensitive_header(name) {
+                *value = MASKED_SECRET.to_string();
+            }
+        }
+        gate
+    }
+
+    /// Encrypt the secret and credential headers of a gate about to be stored; the
+    /// ones sent back masked keep what `stored` has
+    pub fn seal(
+        &mut self,
+        stored: Option<&DeployGateConfig>,
+        encryption: &EncryptionService,
+    ) -> Result<(), String> {
+        if let Some(secret) = self.secret.as_mut() {
+            seal_secret(
+                secret,
+                stored.and_then(|gate| gate.secret.as_deref()),
+                encryption,
+            )?;
+        }
+        for (name, value) in self.headers.iter_mut() {
+            if Self::is_sensitive_header(name) {
+                let stored_value = stored
+                    .and_then(|gate| gate.headers.get(name))
+                    .map(String::as_str);
+                seal_secret(value, stored_value, encryption)?;
+            }
+        }
+        Ok(())
+    }
+
+    /// Copy with the secret and credential headers decrypted, to call the gate with
+    pub fn unsealed(&self, encryption: &EncryptionService) -> Result<Self, String> {
+        let mut gate = self.clone();
+        if let Some(secret) = gate.secret.as_mut() {
+            *secret = open_secret(secret, encryption)?;
+        }
+        for (name, value) in gate.headers.iter_mut() {
+            if Self::is_sensitive_header(name) {
+                *value = open_secret(value, encryption)?;
+            }
+        }
+        Ok(gate)
+    }
+
+    pub fn poll_interval_seconds(&self) -> u32 {
+        self.poll_interval_seconds
+            .unwrap_or(DEFAULT_DEPLOY_GATE_POLL_INTERVAL_SECONDS)
+    }
+
+    pub fn timeout_seconds(&self) -> u32 {
+        self.timeout_seconds
+            .unwrap_or(DEFAULT_DEPLOY_GATE_TIMEOUT_SECONDS)
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if !is_http_url(&self.url) {
+            return Err(format!(
+                "Deploy gate URL '{}' must be an http(s) URL",
+                self.url
+            ));
+        }
+        if let Some(status_url) = &self.status_url {
+            if !is_http_url(status_url) {
+                return Err(format!(
+                    "Deploy gate status URL '{}' must be an http(s) URL",
+                    status_url
+                ));
+            }
+        }
+        if let Some(poll_interval_seconds) = self.poll_interval_seconds {
+            if !(5..=300).contains(&poll_interval_seconds) {
+                return Err(
+                    "Deploy gate poll interval must be between 5 and 300 seconds".to_string(),
+                );
+            }
+        }
+        if let Some(timeout_seconds) = self.timeout_seconds {
+            if !(30..=21600).contains(&timeout_seconds) {
+                return Err(
+                    "Deploy gate timeout must be between 30 seconds and 6 hours".to_string()
+                );
+            }
+        }
+        if self
+            .secret
+            .as_deref()
+            .is_some_and(|secret| secret.is_empty())
+        {
+            return Err("Deploy gate secret can't be empty".to_string());
+        }
+        Ok(())
+    }
+}
+
+/// Process type of a Procfile that receives the environment's traffic
+pub const WEB_PROCESS_TYPE: &str = "web";
+
+/// Most process types an app runs besides `web`
+pub const MAX_PROCESS_TYPES: usize = 20;
+
+/// Most containers of a single process type
+pub const MAX_PROCESS_REPLICAS: u32 = 50;
+
+/// Whether a name can be a Procfile process type: letters, digits, `-` and `_`
+pub fn is_valid_process_type(name: &str) -> bool {
+    !name.is_empty()
+        && name.len() <= 63
+        && name
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_')
+}
+
+/// Scaling and command of one process type of the app's Procfile
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ProcessTypeConfig {
+    /// Containers running the process type (default: 1); 0 stops it. The `web` process
+    /// is scaled with the environment's `replicas` instead
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 2)]
+    pub replicas: Option<u32>,
+
+    /// Command run in place of the Procfile's; defines the process type when the
+    /// Procfile has none of that name
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "bundle exec sidekiq -c 5")]
+    pub command: Option<String>,
+}
+
+impl ProcessTypeConfig {
+    pub fn replicas(&self) -> u32 {
+        self.replicas.unwrap_or(1)
+    }
+}
+
+/// Validate the process types configured for an app
+fn validate_processes(
+    processes: &std::collections::BTreeMap<String, ProcessTypeConfig>,
+) -> Result<(), String> {
+    if processes.len() > MAX_PROCESS_TYPES + 1 {
+        return Err(format!(
+            "At most {} process types can be configured besides web",
+            MAX_PROCESS_TYPES
+        ));
+    }
+    for (name, process) in processes {
+        if !is_valid_process_type(name) {
+            return Err(format!(
+                "Invalid process type '{}': use letters, digits, '-' and '_'",
+                name
+            ));
+        }
+        if name == WEB_PROCESS_TYPE && process.replicas.is_some() {
+            return Err("The web process is scaled with replicas".to_string());
+        }
+        if process.replicas.is_some_and(|r| r > MAX_PROCESS_REPLICAS) {
+            return Err(format!(
+                "Process type '{}' can run at most {} replicas",
+                name, MAX_PROCESS_REPLICAS
+            ));
+        }
+        if process
+            .command
+            .as_deref()
+            .is_some_and(|command| command.trim().is_empty())
+        {
+            return Err(format!("Command of process type '{}' can't be empty", name));
+        }
+    }
+    Ok(())
+}
+
+/// Longest build or start command override, and longest entrypoint or arguments
+/// override in all
+pub const MAX_COMMAND_OVERRIDE_LENGTH: usize = 4096;
+
+/// Validate a build or start command set in place of the detected one
+fn validate_command_override(label: &str, command: &str) -> Result<(), String> {
+    if command.trim().is_empty() {
+        return Err(format!("{} can't be empty", label));
+    }
+    if command.len() > MAX_COMMAND_OVERRIDE_LENGTH {
+        return Err(format!(
+            "{} can be at most {} characters",
+            label, MAX_COMMAND_OVERRIDE_LENGTH
+        ));
+    }
+    // Generated Dockerfiles run it on a single RUN line
+    if command.contains(['\n', '\r']) {
+        return Err(format!("{} must be a single line", label));
+    }
+    Ok(())
+}
+
+/// Validate an entrypoint or arguments set in place of the image's
+fn validate_exec_override(label: &str, values: &[String]) -> Result<(), String> {
+    if values.is_empty() {
+        return Err(format!("{} can't be empty", label));
+    }
+    if values.iter().map(String::len).sum::<usize>() > MAX_COMMAND_OVERRIDE_LENGTH {
+        return Err(format!(
+            "{} can be at most {} characters in all",
+            label, MAX_COMMAND_OVERRIDE_LENGTH
+        ));
+    }
+    if values.iter().any(|value| value.contains('\0')) {
+        return Err(format!("{} can't contain NUL characters", label));
+    }
+    Ok(())
+}
+
+/// Most tags or URLs a deploy purges from the CDN
+pub const MAX_CDN_PURGE_ITEMS: usize = 500;
+
+/// CDN fronting an environment
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum CdnProvider {
+    #[default]
+    Cloudflare,
+    Fastly,
+}
+
+impl std::fmt::Display for CdnProvider {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            CdnProvider::Cloudflare => write!(f, "Cloudflare"),
+            CdnProvider::Fastly => write!(f, "Fastly"),
+        }
+    }
+}
+
+/// What a deploy purges from the CDN's cache
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum CdnPurgeScope {
+    /// Everything cached for the zone or service
+    #[default]
+    All,
+    /// Objects with any of the configured cache tags (Fastly surrogate keys)
+    Tags,
+    /// The configured URLs
+    Urls,
+}
+
+/// Cache purge of the CDN in front of an environment, after each successful deploy
+///
+/// Once a new version receives traffic, the CDN is asked to drop what it cached of
+/// the previous one. A failed purge is reported on the deployment as a warning; the
+/// deployment still succeeds.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct CdnPurgeConfig {
+    pub provider: CdnProvider,
+
+    /// API token allowed to purge: a Cloudflare token with the Cache Purge
+    /// permission, or a Fastly token with the purge_select or purge_all scope. Stored
+    /// encrypted and returned masked
+    pub api_token: String,
+
+    /// Cloudflare zone of the environment's domains
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "023e105f4ecef8ad9ca31a8372d0c353")]
+    pub zone_id: Option<String>,
+
+    /// Fastly service of the environment's domains
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "SU1Z0isxPaozGVKXdv0eY")]
+    pub service_id: Option<String>,
+
+    /// What is purged (default: all)
+    #[serde(default)]
+    pub scope: CdnPurgeScope,
+
+    /// Cache tags purged with the `tags` scope
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    #[schema(example = json!(["assets", "html"]))]
+    pub tags: Vec<String>,
+
+    /// Absolute URLs purged with the `urls` scope
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    #[schema(example = json!(["https://example.com/", "https://example.com/index.html"]))]
+    pub urls: Vec<String>,
+}
+
+impl CdnPurgeConfig {
+    /// Copy for API responses, with the API token masked
+    pub fn masked(&self) -> Self {
+        Self {
+            api_token: MASKED_SECRET.to_string(),
+            ..self.clone()
+        }
+    }
+
+    /// Encrypt the API token of a purge config about to be stored; a token sent back
+    /// masked keeps what `stored` has
+    pub fn seal(
+        &mut self,
+        stored: Option<&CdnPurgeConfig>,
+        encryption: &EncryptionService,
+    ) -> Result<(), String> {
+        seal_secret(
+            &mut self.api_token,
+            stored.map(|purge| purge.api_token.as_str()),
+            encryption,
+        )
+    }
+
+    /// Copy with the API token decrypted, to call the CDN with
+    pub fn unsealed(&self, encryption: &EncryptionService) -> Result<Self, String> {
+        Ok(Self {
+            api_token: open_secret(&self.api_token, encryption)?,
+            ..self.clone()
+        })
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.api_token.trim().is_empty() {
+            return Err("CDN purge API token can't be empty".to_string());
+        }
+        let target = match self.provider {
+            CdnProvider::Cloudflare => self.zone_id.as_deref(),
+            CdnProvider::Fastly => self.service_id.as_deref(),
+        };
+        match target {
+            Some(id) if !id.is_empty() && id.chars().all(|c| c.is_ascii_alphanumeric()) => {}
+            Some(id) if !id.is_empty() => {
+                return Err(format!("Invalid {} ID '{}'", self.provider, id));
+            }
+            _ => {
+                return Err(match self.provider {
+                    CdnProvider::Cloudflare => "Cloudflare CDN purge needs a zone ID",
+                    CdnProvider::Fastly => "Fastly CDN purge needs a service ID",
+                }
+                .to_string())
+            }
+        }
+
+        match self.scope {
+            CdnPurgeScope::All => {}
+            CdnPurgeScope::Tags => {
+                if self.tags.is_empty() {
+                    return Err("CDN purge by tag needs at least one tag".to_string());
+                }
+                if self.tags.len() > MAX_CDN_PURGE_ITEMS {
+                    return Err(format!(
+                        "At most {} CDN purge tags can be configured",
+                        MAX_CDN_PURGE_ITEMS
+                    ));
+                }
+                if let Some(tag) = self
+                    .tags
+                    .iter()
+                    .find(|tag| tag.is_empty() || tag.chars().any(char::is_whitespace))
+                {
+                    return Err(format!("Invalid CDN purge tag '{}'", tag));
+                }
+            }
+            CdnPurgeScope::Urls => {
+                if self.urls.is_empty() {
+                    return Err("CDN purge by URL needs at least one URL".to_string());
+                }
+                if self.urls.len() > MAX_CDN_PURGE_ITEMS {
+                    return Err(format!(
+                        "At most {} CDN purge URLs can be configured",
+                        MAX_CDN_PURGE_ITEMS
+                    ));
+                }
+                if let Some(url) = self.urls.iter().find(|url| !is_http_url(url)) {
+                    return Err(format!("CDN purge URL '{}' must be an http(s) URL", url));
+                }
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Whether a value names a signal the way Docker takes it: `SIGQUIT`, `QUIT`,
+/// `SIGRTMIN+3` or a signal number
+fn is_stop_signal(signal: &str) -> bool {
+    if let Ok(number) = signal.parse::<u32>() {
+        return (1..=64).contains(&number);
+    }
+    let name = signal.strip_prefix("SIG").unwrap_or(signal);
+    let mut chars = name.chars();
+    chars.next().is_some_and(|c| c.is_ascii_uppercase())
+        && chars.all(|c| c.is_ascii_uppercase() || c.is_ascii_digit() || c == '+' || c == '-')
+}
+
+/// Whether a URL is an http(s) URL with a host
+fn is_http_url(url: &str) -> bool {
+    let Some(rest) = url
+        .strip_prefix("https://")
+        .or_else(|| url.strip_prefix("http://"))
+    else {
+        return false;
+    };
+    let host = rest.split(['/', '?', '#']).next().unwrap_or_default();
+    !host.is_empty() && !url.chars().any(char::is_whitespace)
+}
+
+/// Place of an environment's deploys in the build queue
+///
+/// When every build slot is taken, waiting deploys start in priority order and, within
+/// a priority, in arrival order. Running builds are never interrupted.
+#[derive(
+    Debug,
+    Clone,
+    Copy,
+    Default,
+    PartialEq,
+    Eq,
+    PartialOrd,
+    Ord,
+    Hash,
+    Serialize,
+    Deserialize,
+    ToSchema,
+)]
+#[serde(rename_all = "snake_case")]
+pub enum BuildPriority {
+    Low,
+    #[default]
+    Normal,
+    High,
+}
+
+impl BuildPriority {
+    /// One level up, for deploys a person started
+    pub fn raised(self) -> Self {
+        match self {
+            Self::Low => Self::Normal,
+            Self::Normal | Self::High => Self::High,
+        }
+    }
+
+    /// One level down, for deploys the scheduler started
+    pub fn lowered(self) -> Self {
+        match self {
+            Self::High => Self::Normal,
+            Self::Normal | Self::Low => Self::Low,
+        }
+    }
+}
+
+/// Retries a deploy makes when not configured
+pub const DEFAULT_AUTO_RETRY_MAX_RETRIES: u32 = 2;
+/// Seconds before the first retry of a deploy when not configured
+pub const DEFAULT_AUTO_RETRY_BACKOFF_SECONDS: u32 = 30;
+/// Longest wait between retries of a deploy when not configured
+pub const DEFAULT_AUTO_RETRY_MAX_BACKOFF_SECONDS: u32 = 600;
+
+/// Automatic retries of a deploy that failed for a transient reason
+///
+/// A failed deploy is run again when its failure is classified as transient (a
+/// network, registry or timeout error, and whatever the operator adds under the
+/// `deploy_retry` settings). The wait before each retry doubles from
+/// `backoff_seconds` up to `max_backoff_seconds`. Deterministic failures such as a
+/// compile error fail the deploy right away.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct AutoRetryConfig {
+    /// Retries after the first attempt (default: 2)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 2)]
+    pub max_retries: Option<u32>,
+
+    /// Seconds before the first retry (default: 30)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 30)]
+    pub backoff_seconds: Option<u32>,
+
+    /// Longest wait between retries in seconds (default: 600)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 600)]
+    pub max_backoff_seconds: Option<u32>,
+}
+
+impl AutoRetryConfig {
+    pub fn max_retries(&self) -> u32 {
+        self.max_retries.unwrap_or(DEFAULT_AUTO_RETRY_MAX_RETRIES)
+    }
+
+    pub fn backoff_seconds(&self) -> u32 {
+        self.backoff_seconds
+            .unwrap_or(DEFAULT_AUTO_RETRY_BACKOFF_SECONDS)
+    }
+
+    pub fn max_backoff_seconds(&self) -> u32 {
+        self.max_backoff_seconds
+            .unwrap_or(DEFAULT_AUTO_RETRY_MAX_BACKOFF_SECONDS)
+            .max(self.backoff_seconds())
+    }
+
+    /// Seconds to wait before the given retry, counting from 1
+    pub fn backoff_before_retry(&self, retry: u32) -> u32 {
+        let doublings = retry.saturating_sub(1).min(31);
+        self.backoff_seconds()
+            .saturating_mul(1u32 << doublings)
+            .min(self.max_backoff_seconds())
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if let Some(max_retries) = self.max_retries {
+            if !(1..=10).contains(&max_retries) {
+                return Err("Deploy retries must be between 1 and 10".to_string());
+            }
+        }
+        if let Some(backoff_seconds) = self.backoff_seconds {
+            if !(1..=3600).contains(&backoff_seconds) {
+                return Err("Deploy retry backoff must be between 1 second and 1 hour".to_string());
+            }
+        }
+        if let Some(max_backoff_seconds) = self.max_backoff_seconds {
+            if !(1..=21600).contains(&max_backoff_seconds) {
+                return Err(
+                    "Deploy retry maximum backoff must be between 1 second and 6 hours".to_string(),
+                );
+            }
+            if max_backoff_seconds < self.backoff_seconds() {
+                return Err(
+                    "Deploy retry maximum backoff can't be shorter than the backoff".to_string(),
+                );
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Milliseconds a queued request waits for a slot when not configured
+pub const DEFAULT_CONCURRENCY_QUEUE_TIMEOUT_MS: u32 = 1000;
+
+/// Cap on the requests the proxy has in flight to a service at once
+///
+/// Rate limits count requests over a time window; this bounds how many are being
+/// served at the same moment, which is what overloads single-threaded or
+/// resource-bound apps during a spike. Requests past `max_in_flight` wait for a slot
+/// in a queue of up to `queue_size`, for at most `queue_timeout_ms`; with the queue
+/// full, or once the wait runs out, they get 503 with `Retry-After`. WebSockets and
+/// streams, which stay open by design, don't count against the limit.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ConcurrencyLimitConfig {
+    /// Most requests proxied to the service at once
+    #[schema(example = 20)]
+    pub max_in_flight: u32,
+
+    /// Requests that may wait for a slot (default: 0, rejecting requests over the
+    /// limit right away)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 10)]
+    pub queue_size: Option<u32>,
+
+    /// Longest a request waits in the queue in milliseconds (default: 1000)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = 1000)]
+    pub queue_timeout_ms: Option<u32>,
+}
+
+impl ConcurrencyLimitConfig {
+    pub fn queue_size(&self) -> u32 {
+        self.queue_size.unwrap_or(0)
+    }
+
+    pub fn queue_timeout(&self) -> std::time::Duration {
+        std::time::Duration::from_millis(
+            self.queue_timeout_ms
+                .unwrap_or(DEFAULT_CONCURRENCY_QUEUE_TIMEOUT_MS) as u64,
+        )
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if !(1..=100_000).contains(&self.max_in_flight) {
+            return Err("Concurrency limit must be between 1 and 100000 requests".to_string());
+        }
+        if self.queue_size() > 10_000 {
+            return Err("Concurrency queue can't hold more than 10000 requests".to_string());
+        }
+        if let Some(queue_timeout_ms) = self.queue_timeout_ms {
+            if !(1..=60_000).contains(&queue_timeout_ms) {
+                return Err(
+                    "Concurrency queue timeout must be between 1 millisecond and 1 minute"
+                        .to_string(),
+                );
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Deployment configuration shared between projects and environments
+///
+/// This configuration can be set at the project level (as defaults) and
+/// overridden at the environment level for specific deployments.
+///
+/// Note: Environment variables are managed separately and are not part of this config.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema, FromJsonQueryResult)]
+#[serde(rename_all = "camelCase")]
+pub struct DeploymentConfig {
+    /// CPU request in millicores (e.g., 100 = 0.1 CPU, 1000 = 1 CPU)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub cpu_request: Option<i32>,
+
+    /// CPU limit in millicores (e.g., 2000 = 2 CPUs)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub cpu_limit: Option<i32>,
+
+    /// Memory request in megabytes (e.g., 128 = 128MB)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub memory_request: Option<i32>,
+
+    /// Memory limit in megabytes (e.g., 512 = 512MB)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub memory_limit: Option<i32>,
+
+    /// Port exposed by the container
+    /// If not specified, will be auto-detected from Docker image or default to 3000
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub exposed_port: Option<i32>,
+
+    /// Enable automatic deployments on git push
+    #[serde(default)]
+    pub automatic_deploy: bool,
+
+    /// Glob pattern of git tags that trigger a deployment when pushed (e.g. `v*`)
+    /// Tag pushes only deploy to environments whose pattern matches the tag
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub auto_deploy_tag_pattern: Option<String>,
+
+    /// Enable performance metrics collection (speed insights)
+    #[serde(default)]
+    pub performance_metrics_enabled: bool,
+
+    /// Enable session recording for analytics
+    #[serde(default)]
+    pub session_recording_enabled: bool,
+
+    /// Number of replicas/instances to run
+    /// Defaults to 1 replica
+    #[serde(default = "default_replicas")]
+    pub replicas: i32,
+
+    /// Security configuration (headers, rate limiting, attack mode, etc.)
+    /// These settings inherit and override from parent level (Environment > Project > Global)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub security: Option<SecurityConfig>,
+
+    /// Protocol the proxy uses to reach the containers; HTTP/1.1 when unset
+    /// Services speaking gRPC need `h2c` (or `h2` if they terminate TLS themselves)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub upstream_protocol: Option<UpstreamProtocol>,
+
+    /// Seconds the proxy waits for the upstream to send more of a regular response
+    /// before answering 504; defaults to `DEFAULT_REQUEST_TIMEOUT_SECONDS`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub request_timeout_seconds: Option<u32>,
+
+    /// Seconds a streaming request (gRPC, SSE, WebSocket) may go without any data
+    /// before the proxy closes it; no limit when unset, so long-lived streams stay open
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stream_timeout_seconds: Option<u32>,
+
+    /// Seconds a WebSocket may go without any frames (ping/pong included) before the
+    /// proxy closes it; falls back to `stream_timeout_seconds` when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub websocket_idle_timeout_seconds: Option<u32>,
+
+    /// Maximum lifetime of a WebSocket in seconds, after which the proxy closes it and
+    /// the client has to reconnect; no limit when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub websocket_max_duration_seconds: Option<u32>,
+
+    /// Whether the proxy may hold back response bytes, to compress them; off streams
+    /// every chunk to the client as soon as the upstream sends it, for SSE, chunked
+    /// responses and large downloads. On when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub response_buffering: Option<bool>,
+
+    /// Longest the previous deployment keeps serving its open connections (long polls,
+    /// WebSockets, streams) after a new deployment goes live, before its containers are
+    /// stopped; the deploy's drain step stops them sooner once the connections finish
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub connection_drain_seconds: Option<u32>,
+
+    /// Largest request body in bytes; larger requests get 413
+    /// Defaults to `DEFAULT_MAX_REQUEST_BODY_BYTES`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_request_body_bytes: Option<u64>,
+
+    /// Largest request line plus headers in bytes; larger requests get 431
+    /// Defaults to `DEFAULT_MAX_REQUEST_HEADER_BYTES`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_request_header_bytes: Option<u32>,
+
+    /// Seconds the proxy waits for the client to send more of its request before
+    /// answering 408; defaults to `DEFAULT_CLIENT_TIMEOUT_SECONDS`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub client_timeout_seconds: Option<u32>,
+
+    /// Seconds the proxy waits to write request data to the upstream before answering
+    /// 504; no limit when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub write_timeout_seconds: Option<u32>,
+
+    /// Seconds an unused upstream connection is kept for reuse
+    /// Defaults to `DEFAULT_UPSTREAM_IDLE_TIMEOUT_SECONDS`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub idle_timeout_seconds: Option<u32>,
+
+    /// Seconds the proxy waits to connect to the upstream before answering 504
+    /// Defaults to `DEFAULT_CONNECT_TIMEOUT_SECONDS`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub connect_timeout_seconds: Option<u32>,
+
+    /// Most requests proxied to the service at once, with an optional queue for the
+    /// ones over it; no limit when unset. An environment's settings replace the
+    /// project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub concurrency_limit: Option<ConcurrencyLimitConfig>,
+
+    /// Whether the service is served over HTTP, HTTPS or both; `auto` when unset,
+    /// which redirects to HTTPS once the host has an active certificate
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub https_mode: Option<HttpsMode>,
+
+    /// Status of the redirects between HTTP and HTTPS: 301, 302, 307 or 308
+    /// Defaults to `DEFAULT_HTTPS_REDIRECT_STATUS`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub https_redirect_status: Option<u16>,
+
+    /// Signal sent to the containers to stop them gracefully, e.g. `SIGQUIT` for
+    /// nginx or unicorn; `SIGTERM` when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stop_signal: Option<String>,
+
+    /// Seconds the containers get to exit after the stop signal before they are killed
+    /// Defaults to `DEFAULT_STOP_TIMEOUT_SECONDS`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stop_timeout_seconds: Option<u32>,
+
+    /// Days to keep the build artifacts of each deployment (e.g. the bundle of a
+    /// static deploy) in blob storage; artifacts are not retained when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub artifact_retention_days: Option<u32>,
+
+    /// Number of the most recent deployment images kept for rollbacks, in place of
+    /// the `garbage_collection.retained_images` setting. The image the environment
+    /// currently runs is always kept on top of these.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub retained_images: Option<u32>,
+
+    /// Seconds the whole deploy may take, from the first job to the switch of
+    /// traffic; defaults to `DEFAULT_DEPLOY_TIMEOUT_SECONDS`. A deploy running past it
+    /// is aborted and fails, naming the step that was running, while the previous
+    /// deployment keeps serving.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub deploy_timeout_seconds: Option<u32>,
+
+    /// Static site hosting: output directory, SPA fallback, 404 page and caching
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub static_site: Option<StaticSiteConfig>,
+
+    /// Promotion into this environment: approval gate and carried-over variables
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub promotion: Option<PromotionConfig>,
+
+    /// Persistent package manager and compiler caches for builds
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub build_cache: Option<BuildCacheConfig>,
+
+    /// Builder of the images: Dockerfile (the default), Nixpacks or Buildpacks
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub builder: Option<BuilderConfig>,
+
+    /// Build stage shared with other services of the repository, built once and
+    /// reused by each of their images
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub shared_build: Option<SharedBuildConfig>,
+
+    /// Build command run in place of the one the builder detects
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "npm run build:prod")]
+    pub build_command: Option<String>,
+
+    /// Command the containers start with in place of the image's; the command of the
+    /// web process. An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = "node dist/server.js")]
+    pub start_command: Option<String>,
+
+    /// Entrypoint the web containers run in place of the image's (`--entrypoint`),
+    /// e.g. a supervisor wrapping its command or another binary of the image
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = json!(["/usr/bin/tini", "--"]))]
+    pub entrypoint: Option<Vec<String>>,
+
+    /// Arguments the web containers start with in place of the image's command,
+    /// passed to the entrypoint as they are, without a shell
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(example = json!(["serve", "--port", "8080"]))]
+    pub args: Option<Vec<String>>,
+
+    /// Platforms the images are built for, e.g. `linux/amd64` and `linux/arm64`;
+    /// the control plane's own platform when unset
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub build_platforms: Option<Vec<String>>,
+
+    /// Log driver and local log rotation of the containers
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub container_logs: Option<ContainerLogsConfig>,
+
+    /// Timezone and locale of the containers (`TZ` and `LANG`)
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub locale_defaults: Option<LocaleDefaultsConfig>,
+
+    /// Probe new containers must pass before their regular health checks begin, for
+    /// apps that take long to boot. An environment's settings replace the project's
+    /// as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub startup_probe: Option<StartupProbeConfig>,
+
+    /// Command run inside new containers whose exit code is their health, in place of
+    /// the HTTP health check. An environment's settings replace the project's as a
+    /// whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub exec_health_check: Option<ExecHealthCheckConfig>,
+
+    /// Failed health checks in a row that mark the running service unhealthy, and
+    /// the higher number that restarts it
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub health_thresholds: Option<HealthThresholdsConfig>,
+
+    /// How running containers pick up changed runtime config values
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub config_reload: Option<ConfigReloadConfig>,
+
+    /// HTTP checks run against new containers before they receive traffic
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub smoke_tests: Option<SmokeTestsConfig>,
+
+    /// Nameservers, search domains and `/etc/hosts` entries of the containers
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub container_dns: Option<ContainerDnsConfig>,
+
+    /// Ulimits and `/dev/shm` size of the containers
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub container_limits: Option<ContainerLimitsConfig>,
+
+    /// External check the new containers must pass before they receive traffic
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub deploy_gate: Option<DeployGateConfig>,
+
+    /// Cache purge of the CDN in front of the environment after successful deploys
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub cdn_purge: Option<CdnPurgeConfig>,
+
+    /// Replicas and commands of the process types of the app's Procfile, by name
+    /// Every type besides `web` runs in containers of the same image that get no
+    /// traffic. An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub processes: Option<std::collections::BTreeMap<String, ProcessTypeConfig>>,
+
+    /// Automatic retries of deploys that failed for a transient reason
+    /// An environment's settings replace the project's as a whole
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub auto_retry: Option<AutoRetryConfig>,
+
+    /// Priority of deploys waiting for a build slot; set on environments, where it
+    /// defaults to high for production and low for previews
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub build_priority: Option<BuildPriority>,
+
+    /// Whether this environment's variables may only be read or changed with the
+    /// protected secrets permissions; set on environments, where it defaults to
+    /// protected for production
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub protected_secrets: Option<bool>,
+}
+
+/// Deployment configuration snapshot for deployments
+///
+/// This extends DeploymentConfig with environment variables to capture
+/// the complete state of a deployment at the time it was created.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema, FromJsonQueryResult)]
+#[serde(rename_all = "camelCase")]
+pub struct DeploymentConfigSnapshot {
+    /// CPU request in millicores
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub cpu_request: Option<i32>,
+
+    /// CPU limit in millicores
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub cpu_limit: Option<i32>,
+
+    /// Memory request in megabytes
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub memory_request: Option<i32>,
+
+    /// Memory limit in megabytes
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub memory_limit: Option<i32>,
+
+    /// Port exposed by the container
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub exposed_port: Option<i32>,
+
+    /// Environment variables used for this deployment
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub environment_variables: HashMap<String, String>,
+
+    /// Enable automatic deployments on git push
+    #[serde(default)]
+    pub automatic_deploy: bool,
+
+    /// Enable performance metrics collection
+    #[serde(default)]
+    pub performance_metrics_enabled: bool,
+
+    /// Enable session recording
+    #[serde(default)]
+    pub session_recording_enabled: bool,
+
+    /// Number of replicas
+    #[serde(default = "default_replicas")]
+    pub replicas: i32,
+}
+
+fn default_replicas() -> i32 {
+    1
+}
+
+impl Default for DeploymentConfig {
+    fn default() -> Self {
+        Self {
+            cpu_request: None,
+            cpu_limit: None,
+            memory_request: None,
+            memory_limit: None,
+            exposed_port: None,
+            automatic_deploy: false,
+            auto_deploy_tag_pattern: None,
+            performance_metrics_enabled: false,
+            session_recording_enabled: false,
+            replicas: 1,
+            security: None,
+            upstream_protocol: None,
+            request_timeout_seconds: None,
+            stream_timeout_seconds: None,
+            websocket_idle_timeout_seconds: None,
+            websocket_max_duration_seconds: None,
+            response_buffering: None,
+            connection_drain_seconds: None,
+            max_request_body_bytes: None,
+            max_request_header_bytes: None,
+            client_timeout_seconds: None,
+            write_timeout_seconds: None,
+            idle_timeout_seconds: None,
+            connect_timeout_seconds: None,
+            https_mode: None,
+            https_redirect_status: None,
+            stop_signal: None,
+            stop_timeout_seconds: None,
+            artifact_retention_days: None,
+            retained_images: None,
+            deploy_timeout_seconds: None,
+            static_site: None,
+            promotion: None,
+            build_cache: None,
+            builder: None,
+            shared_build: None,
+            build_command: None,
+            start_command: None,
+            entrypoint: None,
+            args: None,
+            build_platforms: None,
+            container_logs: None,
+            locale_defaults: None,
+            smoke_tests: None,
+            container_dns: None,
+            container_limits: None,
+            deploy_gate: None,
+            cdn_purge: None,
+            processes: None,
+            auto_retry: None,
+            build_priority: None,
+            protected_secrets: None,
+            concurrency_limit: None,
+            startup_probe: None,
+            config_reload: None,
+            exec_health_check: None,
+            health_thresholds: None,
+        }
+    }
+}
+
+impl Default for DeploymentConfigSnapshot {
+    fn default() -> Self {
+        Self {
+            cpu_request: None,
+            cpu_limit: None,
+            memory_request: None,
+            memory_limit: None,
+            exposed_port: None,
+            environment_variables: HashMap::new(),
+            automatic_deploy: false,
+            performance_metrics_enabled: false,
+            session_recording_enabled: false,
+            replicas: 1,
+        }
+    }
+}
+
+impl DeploymentConfig {
+    /// Create a new deployment configuration with default values
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Copy for API responses, with the secrets it stores masked
+    pub fn masked(&self) -> Self {
+        let mut config = self.clone();
+        config.deploy_gate = config.deploy_gate.map(|gate| gate.masked());
+        config.cdn_purge = config.cdn_purge.map(|purge| purge.masked());
+        config
+    }
+
+    /// Merge this config with another, preferring values from `other`
+    ///
+    /// This is useful for merging environment-level config (other) with
+    /// project-level config (self) to get the effective configuration.
+    pub fn merge(&self, other: &DeploymentConfig) -> DeploymentConfig {
+        DeploymentConfig {
+            cpu_request: other.cpu_request.or(self.cpu_request),
+            cpu_limit: other.cpu_limit.or(self.cpu_limit),
+            memory_request: other.memory_request.or(self.memory_request),
+            memory_limit: other.memory_limit.or(self.memory_limit),
+            exposed_port: other.exposed_port.or(self.exposed_port),
+            automatic_deploy: other.automatic_deploy || self.automatic_deploy,
+            auto_deploy_tag_pattern: other
+                .auto_deploy_tag_pattern
+                .clone()
+                .or_else(|| self.auto_deploy_tag_pattern.clone()),
+            performance_metrics_enabled: other.performance_metrics_enabled
+                || self.performance_metrics_enabled,
+            session_recording_enabled: other.session_recording_enabled
+                || self.session_recording_enabled,
+            // Use other's replicas if > 0, otherwise use self's replicas
+            replicas: if other.replicas > 0 {
+                other.replicas
+            } else {
+                self.replicas
+            },
+            // Merge security configurations
+            security: match (&self.security, &other.security) {
+                (Some(base), Some(override_security)) => Some(base.merge(override_security)),
+                (Some(base), None) => Some(base.clone()),
+                (None, Some(override_security)) => Some(override_security.clone()),
+                (None, None) => None,
+            },
+            upstream_protocol: other.upstream_protocol.or(self.upstream_protocol),
+            request_timeout_seconds: other
+                .request_timeout_seconds
+                .or(self.request_timeout_seconds),
+            stream_timeout_seconds: other.stream_timeout_seconds.or(self.stream_timeout_seconds),
+            websocket_idle_timeout_seconds: other
+                .websocket_idle_timeout_seconds
+                .or(self.websocket_idle_timeout_seconds),
+            websocket_max_duration_seconds: other
+                .websocket_max_duration_seconds
+                .or(self.websocket_max_duration_seconds),
+            response_buffering: other.response_buffering.or(self.response_buffering),
+            connection_drain_seconds: other
+                .connection_drain_seconds
+                .or(self.connection_drain_seconds),
+            max_request_body_bytes: other.max_request_body_bytes.or(self.max_request_body_bytes),
+            max_request_header_bytes: other
+                .max_request_header_bytes
+                .or(self.max_request_header_bytes),
+            client_timeout_seconds: other.client_timeout_seconds.or(self.client_timeout_seconds),
+            write_timeout_seconds: other.write_timeout_seconds.or(self.write_timeout_seconds),
+            idle_timeout_seconds: other.idle_timeout_seconds.or(self.idle_timeout_seconds),
+            connect_timeout_seconds: other
+                .connect_timeout_seconds
+                .or(self.connect_timeout_seconds),
+            https_mode: other.https_mode.or(self.https_mode),
+            https_redirect_status: other.https_redirect_status.or(self.https_redirect_status),
+            stop_signal: other
+                .stop_signal
+                .clone()
+                .or_else(|| self.stop_signal.clone()),
+            stop_timeout_seconds: other.stop_timeout_seconds.or(self.stop_timeout_seconds),
+            artifact_retention_days: other
+                .artifact_retention_days
+                .or(self.artifact_retention_days),
+            retained_images: other.retained_images.or(self.retained_images),
+            deploy_timeout_seconds: other.deploy_timeout_seconds.or(self.deploy_timeout_seconds),
+            static_site: other
+                .static_site
+                .clone()
+                .or_else(|| self.static_site.clone()),
+            promotion: other.promotion.clone().or_else(|| self.promotion.clone()),
+            build_cache: other
+                .build_cache
+                .clone()
+                .or_else(|| self.build_cache.clone()),
+            builder: other.builder.clone().or_else(|| self.builder.clone()),
+            shared_build: other
+                .shared_build
+                .clone()
+                .or_else(|| self.shared_build.clone()),
+            build_command: other
+                .build_command
+                .clone()
+                .or_else(|| self.build_command.clone()),
+            start_command: other
+                .start_command
+                .clone()
+                .or_else(|| self.start_command.clone()),
+            entrypoint: other.entrypoint.clone().or_else(|| self.entrypoint.clone()),
+            args: other.args.clone().or_else(|| self.args.clone()),
+            container_logs: other
+                .container_logs
+                .clone()
+                .or_else(|| self.container_logs.clone()),
+            locale_defaults: other
+                .locale_defaults
+                .clone()
+                .or_else(|| self.locale_defaults.clone()),
+            smoke_tests: other
+                .smoke_tests
+                .clone()
+                .or_else(|| self.smoke_tests.clone()),
+            container_dns: other
+                .container_dns
+                .clone()
+                .or_else(|| self.container_dns.clone()),
+            container_limits: other
+                .container_limits
+                .clone()
+                .or_else(|| self.container_limits.clone()),
+            deploy_gate: other
+                .deploy_gate
+                .clone()
+                .or_else(|| self.deploy_gate.clone()),
+            cdn_purge: other.cdn_purge.clone().or_else(|| self.cdn_purge.clone()),
+            processes: other.processes.clone().or_else(|| self.processes.clone()),
+            auto_retry: other.auto_retry.clone().or_else(|| self.auto_retry.clone()),
+            build_priority: other.build_priority.or(self.build_priority),
+            build_platforms: other
+                .build_platforms
+                .clone()
+                .or_else(|| self.build_platforms.clone()),
+            protected_secrets: other.protected_secrets.or(self.protected_secrets),
+            concurrency_limit: other
+                .concurrency_limit
+                .clone()
+                .or_else(|| self.concurrency_limit.clone()),
+            startup_probe: other
+                .startup_probe
+                .clone()
+                .or_else(|| self.startup_probe.clone()),
+            config_reload: other
+                .config_reload
+                .clone()
+                .or_else(|| self.config_reload.clone()),
+            exec_health_check: other
+                .exec_health_check
+                .clone()
+                .or_else(|| self.exec_health_check.clone()),
+            health_thresholds: other
+                .health_thresholds
+                .clone()
+                .or_else(|| self.health_thresholds.clone()),
+        }
+    }
+
+    /// Time the whole deploy may take before it's aborted
+    pub fn deploy_timeout(&self) -> std::time::Duration {
+        std::time::Duration::from_secs(
+            self.deploy_timeout_seconds
+                .unwrap_or(DEFAULT_DEPLOY_TIMEOUT_SECONDS) as u64,
+        )
+    }
+
+    /// Largest request body the proxy accepts, in bytes
+    pub fn max_request_body_bytes(&self) -> u64 {
+        self.max_request_body_bytes
+            .unwrap_or(DEFAULT_MAX_REQUEST_BODY_BYTES)
+    }
+
+    /// Largest request line plus headers the proxy accepts, in bytes
+    pub fn max_request_header_bytes(&self) -> u32 {
+        self.max_request_header_bytes
+            .unwrap_or(DEFAULT_MAX_REQUEST_HEADER_BYTES)
+    }
+
+    /// Seconds to wait for more of a regular response
+    pub fn request_timeout(&self) -> u32 {
+        self.request_timeout_seconds
+            .unwrap_or(DEFAULT_REQUEST_TIMEOUT_SECONDS)
+    }
+
+    /// Seconds to wait for the client to send more of its request
+    pub fn client_timeout(&self) -> u32 {
+        self.client_timeout_seconds
+            .unwrap_or(DEFAULT_CLIENT_TIMEOUT_SECONDS)
+    }
+
+    /// Seconds to wait for a connection to the upstream
+    pub fn connect_timeout(&self) -> u32 {
+        self.connect_timeout_seconds
+            .unwrap_or(DEFAULT_CONNECT_TIMEOUT_SECONDS)
+    }
+
+    /// Seconds an unused upstream connection is kept for reuse
+    pub fn idle_timeout(&self) -> u32 {
+        self.idle_timeout_seconds
+            .unwrap_or(DEFAULT_UPSTREAM_IDLE_TIMEOUT_SECONDS)
+    }
+
+    /// Idle timeout for WebSocket connections, in seconds
+    pub fn websocket_idle_timeout(&self) -> Option<u32> {
+        self.websocket_idle_timeout_seconds
+            .or(self.stream_timeout_seconds)
+    }
+
+    /// Whether the proxy may buffer responses before sending them on
+    pub fn response_buffering(&self) -> bool {
+        self.response_buffering.unwrap_or(true)
+    }
+
+    /// Protocol the proxy uses to reach the containers
+    pub fn upstream_protocol(&self) -> UpstreamProtocol {
+        self.upstream_protocol.unwrap_or_default()
+    }
+
+    /// How the service is served over HTTP and HTTPS
+    pub fn https_mode(&self) -> HttpsMode {
+        self.https_mode.unwrap_or_default()
+    }
+
+    /// Status of the redirects between HTTP and HTTPS
+    pub fn https_redirect_status(&self) -> u16 {
+        self.https_redirect_status
+            .unwrap_or(DEFAULT_HTTPS_REDIRECT_STATUS)
+    }
+
+    /// Seconds containers get to exit after the stop signal
+    pub fn stop_timeout_seconds(&self) -> u32 {
+        self.stop_timeout_seconds
+            .unwrap_or(DEFAULT_STOP_TIMEOUT_SECONDS)
+    }
+
+    /// Validate the resource configuration
+    pub fn validate(&self) -> Result<(), String> {
+        // CPU request should not exceed CPU limit
+        if let (Some(request), Some(limit)) = (self.cpu_request, self.cpu_limit) {
+            if request > limit {
+                return Err("CPU request cannot exceed CPU limit".to_string());
+            }
+        }
+
+        // Memory request should not exceed memory limit
+        if let (Some(request), Some(limit)) = (self.memory_request, self.memory_limit) {
+            if request > limit {
+                return Err("Memory request cannot exceed memory limit".to_string());
+            }
+        }
+
+        // Port should be in valid range
+        if let Some(port) = self.exposed_port {
+            if !(1..=65535).contains(&port) {
+                return Err(format!("Port {} is not in valid range (1-65535)", port));
+            }
+        }
+
+        let timeouts = [
+            self.request_timeout_seconds,
+            self.stream_timeout_seconds,
+            self.websocket_idle_timeout_seconds,
+            self.websocket_max_duration_seconds,
+            self.client_timeout_seconds,
+            self.write_timeout_seconds,
+            self.idle_timeout_seconds,
+            self.connect_timeout_seconds,
+        ];
+        if timeouts.contains(&Some(0)) {
+            return Err("Proxy timeouts must be at least 1 second".to_string());
+        }
+        if self.max_request_body_bytes == Some(0) {
+            return Err("Maximum request body size must be at least 1 byte".to_string());
+        }
+        if let Some(header_bytes) = self.max_request_header_bytes {
+            if header_bytes < 1024 {
+                return Err("Maximum request header size must be at least 1024 bytes".to_string());
+            }
+        }
+        if let Some(status) = self.https_redirect_status {
+            if ![301, 302, 307, 308].contains(&status) {
+                return Err(format!(
+                    "HTTPS redirect status {} must be 301, 302, 307 or 308",
+                    status
+                ));
+            }
+        }
+        if let Some(signal) = &self.stop_signal {
+            if !is_stop_signal(signal) {
+                return Err(format!(
+                    "Stop signal '{}' must be a signal name like SIGQUIT or a number from 1 to 64",
+                    signal
+                ));
+            }
+        }
+        if let Some(seconds) = self.stop_timeout_seconds {
+            if !(1..=MAX_STOP_TIMEOUT_SECONDS).contains(&seconds) {
+                return Err(format!(
+                    "Stop timeout must be between 1 and {} seconds",
+                    MAX_STOP_TIMEOUT_SECONDS
+                ));
+            }
+        }
+        if self.artifact_retention_days == Some(0) {
+            return Err("Artifact retention must be at least 1 day".to_string());
+        }
+        if let Some(count) = self.retained_images {
+            if !(1..=MAX_RETAINED_IMAGES).contains(&count) {
+                return Err(format!(
+                    "Retained images must be between 1 and {}",
+                    MAX_RETAINED_IMAGES
+                ));
+            }
+        }
+        if let Some(seconds) = self.deploy_timeout_seconds {
+            if !(MIN_DEPLOY_TIMEOUT_SECONDS..=MAX_DEPLOY_TIMEOUT_SECONDS).contains(&seconds) {
+                return Err(format!(
+                    "Deploy timeout must be between {} seconds and {} hours",
+                    MIN_DEPLOY_TIMEOUT_SECONDS,
+                    MAX_DEPLOY_TIMEOUT_SECONDS / 3600
+                ));
+            }
+        }
+        if let Some(static_site) = &self.static_site {
+            static_site.validate()?;
+        }
+        if let Some(promotion) = &self.promotion {
+            promotion.validate()?;
+        }
+        if let Some(build_cache) = &self.build_cache {
+            build_cache.validate()?;
+        }
+        if let Some(builder) = &self.builder {
+            builder.validate()?;
+        }
+        if let Some(shared_build) = &self.shared_build {
+            shared_build.validate()?;
+            if self
+                .builder
+                .as_ref()
+                .is_some_and(|builder| builder.kind != BuilderKind::Dockerfile)
+            {
+                return Err(
+                    "A shared build stage can only be used with the Dockerfile builder".to_string(),
+                );
+            }
+            if self
+                .build_platforms
+                .as_ref()
+                .is_some_and(|platforms| platforms.len() > 1)
+            {
+                return Err(
+                    "A shared build stage can't be used for multi-platform images".to_string(),
+                );
+            }
+        }
+        if let Some(platforms) = &self.build_platforms {
+            validate_build_platforms(platforms)?;
+            let buildpacks = self
+                .builder
+                .as_ref()
+                .is_some_and(|builder| builder.kind == BuilderKind::Buildpacks);
+            if buildpacks && platforms.len() > 1 {
+                return Err("The buildpacks builder can only build for one platform".to_string());
+            }
+        }
+        if let Some(build_command) = &self.build_command {
+            validate_command_override("Build command", build_command)?;
+            if self
+                .builder
+                .as_ref()
+                .is_some_and(|builder| builder.kind == BuilderKind::Buildpacks)
+            {
+                return Err("The buildpacks builder can't run a custom build command".to_string());
+            }
+        }
+        if let Some(start_command) = &self.start_command {
+            validate_command_override("Start command", start_command)?;
+            let web_command = self
+                .processes
+                .as_ref()
+                .and_then(|processes| processes.get(WEB_PROCESS_TYPE))
+                .is_some_and(|web| web.command.is_some());
+            if web_command {
+                return Err(
+                    "Set the start command or the command of the web process, not both".to_string(),
+                );
+            }
+        }
+        if let Some(entrypoint) = &self.entrypoint {
+            validate_exec_override("Entrypoint", entrypoint)?;
+            if entrypoint[0].trim().is_empty() {
+                return Err("Entrypoint must start with the program to run".to_string());
+            }
+        }
+        if let Some(args) = &self.args {
+            validate_exec_override("Arguments", args)?;
+            let web_command = self
+                .processes
+                .as_ref()
+                .and_then(|processes| processes.get(WEB_PROCESS_TYPE))
+                .is_some_and(|web| web.command.is_some());
+            if self.start_command.is_some() || web_command {
+                return Err(
+                    "Set the arguments or a command of the web process, not both".to_string(),
+                );
+            }
+        }
+        if let Some(container_logs) = &self.container_logs {
+            container_logs.validate()?;
+        }
+        if let Some(locale_defaults) = &self.locale_defaults {
+            locale_defaults.validate()?;
+        }
+        if let Some(smoke_tests) = &self.smoke_tests {
+            smoke_tests.validate()?;
+        }
+        if let Some(container_dns) = &self.container_dns {
+            container_dns.validate()?;
+        }
+        if let Some(container_limits) = &self.container_limits {
+            container_limits.validate()?;
+            if let (Some(shm_size_mb), Some(memory_limit)) =
+                (container_limits.shm_size_mb, self.memory_limit)
+            {
+                // /dev/shm is memory, charged to the container
+                if i64::from(shm_size_mb) > i64::from(memory_limit) {
+                    return Err("Shared memory size cannot exceed memory limit".to_string());
+                }
+            }
+        }
+        if let Some(deploy_gate) = &self.deploy_gate {
+            deploy_gate.validate()?;
+        }
+        if let Some(cdn_purge) = &self.cdn_purge {
+            cdn_purge.validate()?;
+        }
+        if let Some(processes) = &self.processes {
+            validate_processes(processes)?;
+        }
+        if let Some(auto_retry) = &self.auto_retry {
+            auto_retry.validate()?;
+        }
+        if let Some(concurrency_limit) = &self.concurrency_limit {
+            concurrency_limit.validate()?;
+        }
+        if let Some(startup_probe) = &self.startup_probe {
+            startup_probe.validate()?;
+            if startup_probe.startup_budget() >= self.deploy_timeout() {
+                return Err(
+                    "Startup probe may take longer than the whole deploy; raise the deploy \
+                     timeout or lower the probe's period or failure threshold"
+                        .to_string(),
+                );
+            }
+        }
+        if let Some(config_reload) = &self.config_reload {
+            config_reload.validate()?;
+        }
+        if let Some(exec_health_check) = &self.exec_health_check {
+            exec_health_check.validate()?;
+        }
+        if let Some(health_thresholds) = &self.health_thresholds {
+            health_thresholds.validate()?;
+        }
+
+        Ok(())
+    }
+}
+
+impl DeploymentConfigSnapshot {
+    /// Create a snapshot from a DeploymentConfig and environment variables
+    pub fn from_config(
+        config: &DeploymentConfig,
+        environment_variables: HashMap<String, String>,
+    ) -> Self {
+        Self {
+            cpu_request: config.cpu_request,
+            cpu_limit: config.cpu_limit,
+            memory_request: config.memory_request,
+            memory_limit: config.memory_limit,
+            exposed_port: config.exposed_port,
+            environment_variables,
+            automatic_deploy: config.automatic_deploy,
+            performance_metrics_enabled: config.performance_metrics_enabled,
+            session_recording_enabled: config.session_recording_enabled,
+            replicas: config.replicas,
+        }
+    }
+
+    /// Validate the resource configuration
+    pub fn validate(&self) -> Result<(), String> {
+        // CPU request should not exceed CPU limit
+        if let (Some(request), Some(limit)) = (self.cpu_request, self.cpu_limit) {
+            if request > limit {
+                return Err("CPU request cannot exceed CPU limit".to_string());
+            }
+        }
+
+        // Memory request should not exceed memory limit
+        if let (Some(request), Some(limit)) = (self.memory_request, self.memory_limit) {
+            if request > limit {
+                return Err("Memory request cannot exceed memory limit".to_string());
+            }
+        }
+
+        // Port should be in valid range
+        if let Some(port) = self.exposed_port {
+            if !(1..=65535).contains(&port) {
+                return Err(format!("Port {} is not in valid range (1-65535)", port));
+            }
+        }
+
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_default_config() {
+        let config = DeploymentConfig::default();
+        assert_eq!(config.cpu_request, None);
+        assert_eq!(config.cpu_limit, None);
+        assert!(!config.automatic_deploy);
+        assert!(!config.performance_metrics_enabled);
+        assert!(!config.session_recording_enabled);
+    }
+
+    #[test]
+    fn test_merge_configs() {
+        let project_config = DeploymentConfig {
+            cpu_request: Some(100),
             cpu_limit: Some(1000),
             memory_request: Some(128),
             memory_limit: Some(512),
             exposed_port: Some(3000),
             automatic_deploy: true,
+            auto_deploy_tag_pattern: None,
             performance_metrics_enabled: true,
             session_recording_enabled: false,
             replicas: 2,
             security: None,
+            upstream_protocol: None,
+            request_timeout_seconds: Some(30),
+            stream_timeout_seconds: None,
+            websocket_idle_timeout_seconds: None,
+            websocket_max_duration_seconds: None,
+            response_buffering: None,
+            connection_drain_seconds: None,
+            max_request_body_bytes: None,
+            max_request_header_bytes: None,
+            client_timeout_seconds: None,
+            write_timeout_seconds: None,
+            idle_timeout_seconds: None,
+            connect_timeout_seconds: None,
+            https_mode: None,
+            https_redirect_status: None,
+            stop_signal: None,
+            stop_timeout_seconds: None,
+            artifact_retention_days: None,
+            retained_images: None,
+            deploy_timeout_seconds: None,
+            static_site: None,
+            promotion: None,
+            build_cache: None,
+            builder: None,
+            shared_build: None,
+            build_command: None,
+            start_command: None,
+            entrypoint: None,
+            args: None,
+            build_platforms: None,
+            container_logs: None,
+            locale_defaults: None,
+            smoke_tests: None,
+            container_dns: None,
+            container_limits: None,
+            deploy_gate: None,
+            cdn_purge: None,
+            processes: None,
+            auto_retry: None,
+            build_priority: None,
+            protected_secrets: None,
+            concurrency_limit: None,
+            startup_probe: None,
+            config_reload: None,
+            exec_health_check: None,
+            health_thresholds: None,
+        };
+
+        let env_config = DeploymentConfig {
+            cpu_request: Some(200),   // Override
+            cpu_limit: None,          // Use project default
+            memory_request: None,     // Use project default
+            memory_limit: Some(1024), // Override
+            exposed_port: Some(8080), // Override
+            automatic_deploy: false,
+            auto_deploy_tag_pattern: None,
+            performance_metrics_enabled: false,
+            session_recording_enabled: true, // Override
+            replicas: 5,                     // Override
+            security: None,
+            upstream_protocol: Some(UpstreamProtocol::H2c), // Override
+            request_timeout_seconds: None,
+            stream_timeout_seconds: Some(600), // Override
+            websocket_idle_timeout_seconds: None,
+            websocket_max_duration_seconds: None,
+            response_buffering: None,
+            connection_drain_seconds: None,
+            max_request_body_bytes: None,
+            max_request_header_bytes: None,
+            client_timeout_seconds: None,
+            write_timeout_seconds: None,
+            idle_timeout_seconds: None,
+            connect_timeout_seconds: None,
+            https_mode: None,
+            https_redirect_status: None,
+            stop_signal: None,
+            stop_timeout_seconds: None,
+            artifact_retention_days: None,
+            retained_images: None,
+            deploy_timeout_seconds: None,
+            static_site: None,
+            promotion: None,
+            build_cache: None,
+            builder: None,
+            shared_build: None,
+            build_command: None,
+            start_command: None,
+            entrypoint: None,
+            args: None,
+            build_platforms: None,
+            container_logs: None,
+            locale_defaults: None,
+            smoke_tests: None,
+            container_dns: None,
+            container_limits: None,
+            deploy_gate: None,
+            cdn_purge: None,
+            processes: None,
+            auto_retry: None,
+            build_priority: None,
+            protected_secrets: None,
+            concurrency_limit: None,
+            startup_probe: None,
+            config_reload: None,
+            exec_health_check: None,
+            health_thresholds: None,
+        };
+
+        let merged = project_config.merge(&env_config);
+
+        assert_eq!(merged.cpu_request, Some(200));
+        assert_eq!(merged.cpu_limit, Some(1000));
+        assert_eq!(merged.memory_request, Some(128));
+        assert_eq!(merged.memory_limit, Some(1024));
+        assert_eq!(merged.exposed_port, Some(8080));
+        assert!(merged.automatic_deploy); // true || false = true
+        assert!(merged.performance_metrics_enabled); // true || false = true
+        assert!(merged.session_recording_enabled);
+        assert_eq!(merged.replicas, 5);
+        assert_eq!(merged.upstream_protocol(), UpstreamProtocol::H2c);
+        assert_eq!(merged.request_timeout_seconds, Some(30));
+        assert_eq!(merged.stream_timeout_seconds, Some(600));
+        assert_eq!(merged.websocket_idle_timeout(), Some(600));
+    }
+
+    #[test]
+    fn test_validation() {
+        let valid_config = DeploymentConfig {
+            cpu_request: Some(100),
+            cpu_limit: Some(1000),
+            memory_request: Some(128),
+            memory_limit: Some(512),
+            exposed_port: Some(3000),
+            security: None,
+            ..Default::default()
+        };
+        assert!(valid_config.validate().is_ok());
+
+        let invalid_cpu = DeploymentConfig {
+            cpu_request: Some(2000),
+            cpu_limit: Some(1000),
+            security: None,
+            ..Default::default()
+        };
+        assert!(invalid_cpu.validate().is_err());
+
+        let invalid_memory = DeploymentConfig {
+            memory_request: Some(1024),
+            memory_limit: Some(512),
+            security: None,
+            ..Default::default()
+        };
+        assert!(invalid_memory.validate().is_err());
+
+        let invalid_port = DeploymentConfig {
+            exposed_port: Some(70000),
+            ..Default::default()
+        };
+        assert!(invalid_port.validate().is_err());
+
+        let zero_timeout = DeploymentConfig {
+            stream_timeout_seconds: Some(0),
+            ..Default::default()
+        };
+        assert!(zero_timeout.validate().is_err());
+    }
+
+    #[test]
+    fn test_proxy_limit_defaults() {
+        let config = DeploymentConfig::default();
+        assert_eq!(
+            config.max_request_body_bytes(),
+            DEFAULT_MAX_REQUEST_BODY_BYTES
+        );
+        assert_eq!(config.request_timeout(), DEFAULT_REQUEST_TIMEOUT_SECONDS);
+        assert_eq!(config.connect_timeout(), DEFAULT_CONNECT_TIMEOUT_SECONDS);
+
+        let config = DeploymentConfig {
+            max_request_body_bytes: Some(1024),
+            connect_timeout_seconds: Some(2),
+            ..Default::default()
+        };
+        assert_eq!(config.max_request_body_bytes(), 1024);
+        assert_eq!(config.connect_timeout(), 2);
+
+        let tiny_headers = DeploymentConfig {
+            max_request_header_bytes: Some(100),
+            ..Default::default()
+        };
+        assert!(tiny_headers.validate().is_err());
+    }
+
+    #[test]
+    fn test_https_mode_defaults_and_merge() {
+        let config = DeploymentConfig::default();
+        assert_eq!(config.https_mode(), HttpsMode::Auto);
+        assert_eq!(
+            config.https_redirect_status(),
+            DEFAULT_HTTPS_REDIRECT_STATUS
+        );
+
+        let project: DeploymentConfig =
+            serde_json::from_str(r#"{"httpsMode": "force_https", "httpsRedirectStatus": 308}"#)
+                .unwrap();
+        let environment = DeploymentConfig {
+            https_mode: Some(HttpsMode::HttpOnly),
+            ..Default::default()
+        };
+        let effective = project.merge(&environment);
+        assert_eq!(effective.https_mode(), HttpsMode::HttpOnly);
+        assert_eq!(effective.https_redirect_status(), 308);
+
+        let bad_status = DeploymentConfig {
+            https_redirect_status: Some(303),
+            ..Default::default()
+        };
+        assert!(bad_status.validate().is_err());
+    }
+
+    #[test]
+    fn test_stop_signal_validation() {
+        let config = DeploymentConfig::default();
+        assert_eq!(config.stop_signal, None);
+        assert_eq!(config.stop_timeout_seconds(), DEFAULT_STOP_TIMEOUT_SECONDS);
+
+        for signal in ["SIGQUIT", "QUIT", "SIGRTMIN+3", "SIGWINCH", "3"] {
+            let config = DeploymentConfig {
+                stop_signal: Some(signal.to_string()),
+                stop_timeout_seconds: Some(30),
+                ..Default::default()
+            };
+            assert!(config.validate().is_ok(), "{}", signal);
+        }
+        for signal in ["", "sigquit", "SIG", "65", "0", "SIGQUIT; rm"] {
+            let config = DeploymentConfig {
+                stop_signal: Some(signal.to_string()),
+                ..Default::default()
+            };
+            assert!(config.validate().is_err(), "{}", signal);
+        }
+        for seconds in [0, MAX_STOP_TIMEOUT_SECONDS + 1] {
+            let config = DeploymentConfig {
+                stop_timeout_seconds: Some(seconds),
+                ..Default::default()
+            };
+            assert!(config.validate().is_err());
+        }
+    }
+
+    #[test]
+    fn test_static_site_cache_rules_and_defaults() {
+        let site: StaticSiteConfig = serde_json::from_str(
+            r#"{"cacheControl": [
+                {"pattern": "/sw.js", "value": "no-store"},
+                {"pattern": "/assets/*", "value": "public, max-age=600"},
+                {"pattern": "*.woff2", "value": "public, max-age=31536000"}
+            ]}"#,
+        )
+        .unwrap();
+        assert!(site.spa_fallback());
+        assert_eq!(site.not_found_page(), "404.html");
+        assert_eq!(site.cache_control_for("/sw.js"), Some("no-store"));
+        assert_eq!(
+            site.cache_control_for("/assets/app.js"),
+            Some("public, max-age=600")
+        );
+        assert_eq!(
+            site.cache_control_for("/fonts/inter.woff2"),
+            Some("public, max-age=31536000")
+        );
+        assert_eq!(site.cache_control_for("/index.html"), None);
+        assert!(site.validate().is_ok());
+
+        let bad_pattern = StaticSiteConfig {
+            cache_control: vec![CacheControlRule {
+                pattern: "/a/*/b".to_string(),
+                value: "no-store".to_string(),
+            }],
+            ..Default::default()
+        };
+        assert!(bad_pattern.validate().is_err());
+
+        let escaping_page = StaticSiteConfig {
+            not_found_page: Some("../secrets.html".to_string()),
+            ..Default::default()
+        };
+        assert!(escaping_page.validate().is_err());
+    }
+
+    #[test]
+    fn test_builder_validation() {
+        let config: BuilderConfig = serde_json::from_str(
+            r#"{"kind": "buildpacks", "buildpacks": ["paketo-buildpacks/nodejs"]}"#,
+        )
+        .unwrap();
+        assert_eq!(config.builder_image(), DEFAULT_BUILDPACKS_BUILDER);
+        assert!(config.validate().is_ok());
+
+        let nixpacks_with_buildpacks = BuilderConfig {
+            kind: BuilderKind::Nixpacks,
+            buildpacks: vec!["paketo-buildpacks/nodejs".to_string()],
+            ..Default::default()
+        };
+        assert!(nixpacks_with_buildpacks.validate().is_err());
+
+        let flag_as_image = BuilderConfig {
+            kind: BuilderKind::Buildpacks,
+            builder_image: Some("--publish".to_string()),
+            ..Default::default()
+        };
+        assert!(flag_as_image.validate().is_err());
+
+        let spaced_label = BuilderConfig {
+            node_labels: vec!["gpu".to_string(), "big box".to_string()],
+            ..Default::default()
+        };
+        assert!(spaced_label.validate().is_err());
+    }
+
+    #[test]
+    fn test_build_platforms_validation() {
+        let platforms = |list: &[&str]| DeploymentConfig {
+            build_platforms: Some(list.iter().map(|p| p.to_string()).collect()),
+            ..Default::default()
+        };
+        assert!(platforms(&["linux/amd64", "linux/arm64"])
+            .validate()
+            .is_ok());
+        assert!(platforms(&[]).validate().is_err());
+        assert!(platforms(&["linux/amd64", "linux/amd64"])
+            .validate()
+            .is_err());
+        assert!(platforms(&["windows/amd64"]).validate().is_err());
+
+        let buildpacks = DeploymentConfig {
+            builder: Some(BuilderConfig {
+                kind: BuilderKind::Buildpacks,
+                ..Default::default()
+            }),
+            ..platforms(&["linux/amd64", "linux/arm64"])
+        };
+        assert!(buildpacks.validate().is_err());
+    }
+
+    #[test]
+    fn test_retained_images_validation() {
+        let keep = |count| DeploymentConfig {
+            retained_images: Some(count),
+            ..Default::default()
+        };
+        assert!(keep(1).validate().is_ok());
+        assert!(keep(MAX_RETAINED_IMAGES).validate().is_ok());
+        assert!(keep(0).validate().is_err());
+        assert!(keep(MAX_RETAINED_IMAGES + 1).validate().is_err());
+        assert_eq!(keep(2).merge(&keep(20)).retained_images, Some(20));
+    }
+
+    #[test]
+    fn test_deploy_timeout() {
+        let timeout = |seconds| DeploymentConfig {
+            deploy_timeout_seconds: Some(seconds),
+            ..Default::default()
+        };
+        assert_eq!(
+            DeploymentConfig::default().deploy_timeout(),
+            std::time::Duration::from_secs(DEFAULT_DEPLOY_TIMEOUT_SECONDS as u64)
+        );
+        assert_eq!(
+            timeout(900).deploy_timeout(),
+            std::time::Duration::from_secs(900)
+        );
+        assert!(timeout(MIN_DEPLOY_TIMEOUT_SECONDS).validate().is_ok());
+        assert!(timeout(30).validate().is_err());
+        assert!(timeout(MAX_DEPLOY_TIMEOUT_SECONDS + 1).validate().is_err());
+        assert_eq!(
+            timeout(600).merge(&timeout(1800)).deploy_timeout_seconds,
+            Some(1800)
+        );
+    }
+
+    #[test]
+    fn test_build_cache_validation() {
+        let config: BuildCacheConfig = serde_json::from_str(
+            r#"{"extraCaches": [
+                {"name": "cargo-registry", "target": "/usr/local/cargo/registry"},
+                {"name": "gradle", "target": "/root/.gradle", "envVar": "GRADLE_USER_HOME"}
+            ]}"#,
+        )
+        .unwrap();
+        assert!(config.language_caches());
+        assert!(config.validate().is_ok());
+
+        let cache = |name: &str, target: &str| BuildCacheMount {
+            name: name.to_string(),
+            target: target.to_string(),
+            env_var: None,
+        };
+        let invalid = [
+            vec![cache("Cargo", "/usr/local/cargo")],
+            vec![cache("cargo", "usr/local/cargo")],
+            vec![cache("cargo", "/usr/../etc")],
+            vec![cache("cargo", "/a"), cache("cargo", "/b")],
+        ];
+        for extra_caches in invalid {
+            let config = BuildCacheConfig {
+                extra_caches,
+                ..Default::default()
+            };
+            assert!(config.validate().is_err(), "{:?}", config);
+        }
+    }
+
+    #[test]
+    fn test_shared_build_rebuilds_for() {
+        let stage = SharedBuildConfig {
+            name: "workspace".to_string(),
+            dockerfile: "docker/shared.Dockerfile".to_string(),
+            paths: vec!["./packages/common/".to_string(), "Cargo.lock".to_string()],
+        };
+        let changed = |files: &[&str]| files.iter().map(|f| f.to_string()).collect::<Vec<_>>();
+
+        assert!(stage.rebuilds_for("apps/api", &changed(&["apps/api/src/main.rs"])));
+        assert!(!stage.rebuilds_for("apps/api", &changed(&["apps/web/index.ts"])));
+        // A sibling directory sharing a prefix isn't the service's
+        assert!(!stage.rebuilds_for("apps/api", &changed(&["apps/api-docs/README.md"])));
+        // Shared code rebuilds every service of the stage
+        assert!(stage.rebuilds_for("apps/api", &changed(&["packages/common/lib.rs"])));
+        assert!(stage.rebuilds_for("apps/web", &changed(&["Cargo.lock"])));
+        assert!(stage.rebuilds_for("apps/web", &changed(&["docker/shared.Dockerfile"])));
+        // A service at the repository root is rebuilt by any change
+        assert!(stage.rebuilds_for(".", &changed(&["apps/web/index.ts"])));
+        assert!(!stage.rebuilds_for("apps/api", &[]));
+    }
+
+    #[test]
+    fn test_shared_build_validation() {
+        let stage = |name: &str, dockerfile: &str, paths: &[&str]| SharedBuildConfig {
+            name: name.to_string(),
+            dockerfile: dockerfile.to_string(),
+            paths: paths.iter().map(|p| p.to_string()).collect(),
         };
+        assert!(stage("workspace", "shared.Dockerfile", &["packages"])
+            .validate()
+            .is_ok());
+        for invalid in [
+            stage("Workspace", "shared.Dockerfile", &["packages"]),
+            stage("workspace", "", &["packages"]),
+            stage("workspace", "../shared.Dockerfile", &["packages"]),
+            stage("workspace", "shared.Dockerfile", &[]),
+            stage("workspace", "shared.Dockerfile", &["."]),
+            stage("workspace", "shared.Dockerfile", &["/etc"]),
+        ] {
+            assert!(invalid.validate().is_err(), "{:?}", invalid);
+        }
 
-        let env_config = DeploymentConfig {
-            cpu_request: Some(200),   // Override
-            cpu_limit: None,          // Use project default
-            memory_request: None,     // Use project default
-            memory_limit: Some(1024), // Override
-            exposed_port: Some(8080), // Override
-            automatic_deploy: false,
-            performance_metrics_enabled: false,
-            session_recording_enabled: true, // Override
-            replicas: 5,                     // Override
-            security: None,
+        let config = DeploymentConfig {
+            shared_build: Some(stage("workspace", "shared.Dockerfile", &["packages"])),
+            builder: Some(BuilderConfig {
+                kind: BuilderKind::Nixpacks,
+                ..Default::default()
+            }),
+            ..Default::default()
         };
+        assert!(config.validate().is_err());
+    }
 
-        let merged = project_config.merge(&env_config);
+    #[test]
+    fn test_container_logs_defaults_and_validation() {
+        let defaults = ContainerLogsConfig::default();
+        assert_eq!(defaults.driver(), ContainerLogDriver::JsonFile);
+        let options = defaults.driver_options();
+        assert_eq!(options.get("max-size").map(String::as_str), Some("10m"));
+        assert_eq!(options.get("max-file").map(String::as_str), Some("3"));
 
-        assert_eq!(merged.cpu_request, Some(200));
-        assert_eq!(merged.cpu_limit, Some(1000));
-        assert_eq!(merged.memory_request, Some(128));
-        assert_eq!(merged.memory_limit, Some(1024));
-        assert_eq!(merged.exposed_port, Some(8080));
-        assert!(merged.automatic_deploy); // true || false = true
-        assert!(merged.performance_metrics_enabled); // true || false = true
-        assert!(merged.session_recording_enabled);
-        assert_eq!(merged.replicas, 5);
+        let journald: ContainerLogsConfig =
+            serde_json::from_str(r#"{"driver": "journald"}"#).unwrap();
+        assert_eq!(journald.driver().as_str(), "journald");
+        assert!(journald.driver_options().is_empty());
+        assert!(journald.validate().is_ok());
+        assert!(!journald.coalesces_repeats());
+
+        let coalescing: ContainerLogsConfig =
+            serde_json::from_str(r#"{"coalesceRepeats": true}"#).unwrap();
+        assert!(coalescing.coalesces_repeats());
+
+        let journald_rotation = ContainerLogsConfig {
+            max_files: Some(5),
+            ..journald
+        };
+        assert!(journald_rotation.validate().is_err());
+
+        let too_big = ContainerLogsConfig {
+            max_size_mb: Some(4096),
+            ..Default::default()
+        };
+        assert!(too_big.validate().is_err());
     }
 
     #[test]
-    fn test_validation() {
-        let valid_config = DeploymentConfig {
-            cpu_request: Some(100),
-            cpu_limit: Some(1000),
-            memory_request: Some(128),
-            memory_limit: Some(512),
-            exposed_port: Some(3000),
-            security: None,
+    fn test_log_timestamp_validation() {
+        let defaults = LogTimestampConfig::default();
+        assert_eq!(defaults.format(), "rfc3339");
+        assert_eq!(defaults.max_future_skew_seconds(), 300);
+        assert!(defaults.validate().is_ok());
+
+        let logs: ContainerLogsConfig = serde_json::from_str(
+            r#"{"timestamp": {"field": "ts", "format": "%Y-%m-%d %H:%M:%S%.3f"}}"#,
+        )
+        .unwrap();
+        assert!(logs.validate().is_ok());
+
+        for invalid in [
+            r#"{"field": ""}"#,
+            r#"{"format": "%Y-%m-%d %Q"}"#,
+            r#"{"format": ""}"#,
+            r#"{"maxFutureSkewSeconds": 0}"#,
+            r#"{"maxPastSkewSeconds": 1000000}"#,
+        ] {
+            let config: LogTimestampConfig = serde_json::from_str(invalid).unwrap();
+            assert!(config.validate().is_err(), "{}", invalid);
+        }
+    }
+
+    #[test]
+    fn test_locale_defaults_env_vars_and_validation() {
+        let defaults = LocaleDefaultsConfig::default();
+        let env_vars = defaults.env_vars();
+        assert_eq!(env_vars.get("TZ").map(String::as_str), Some("UTC"));
+        assert_eq!(env_vars.get("LANG").map(String::as_str), Some("C.UTF-8"));
+        assert!(!defaults.mount_tzdata());
+
+        let berlin = LocaleDefaultsConfig {
+            timezone: Some("Europe/Berlin".to_string()),
+            locale: Some("de_DE.UTF-8".to_string()),
+            mount_tzdata: Some(true),
+        };
+        assert!(berlin.validate().is_ok());
+        assert_eq!(
+            berlin.env_vars().get("TZ").map(String::as_str),
+            Some("Europe/Berlin")
+        );
+
+        for timezone in ["America/Argentina/Buenos_Aires", "Etc/GMT+3", "UTC"] {
+            let config = LocaleDefaultsConfig {
+                timezone: Some(timezone.to_string()),
+                ..Default::default()
+            };
+            assert!(config.validate().is_ok(), "{} should be valid", timezone);
+        }
+        for timezone in ["", "/etc/passwd", "Europe/../../etc", "Europe Berlin"] {
+            let config = LocaleDefaultsConfig {
+                timezone: Some(timezone.to_string()),
+                ..Default::default()
+            };
+            assert!(config.validate().is_err(), "{} should be invalid", timezone);
+        }
+
+        for locale in ["C", "POSIX", "en_US", "sr_RS.UTF-8@latin", "ja_JP.eucJP"] {
+            let config = LocaleDefaultsConfig {
+                locale: Some(locale.to_string()),
+                ..Default::default()
+            };
+            assert!(config.validate().is_ok(), "{} should be valid", locale);
+        }
+        for locale in ["", "en US", "EN_us", "en_US.", "de_DE.UTF-8; rm"] {
+            let config = LocaleDefaultsConfig {
+                locale: Some(locale.to_string()),
+                ..Default::default()
+            };
+            assert!(config.validate().is_err(), "{} should be invalid", locale);
+        }
+    }
+
+    #[test]
+    fn test_smoke_test_response_matchers() {
+        let health = SmokeTest {
+            name: "health".to_string(),
+            path: "/health".to_string(),
             ..Default::default()
         };
-        assert!(valid_config.validate().is_ok());
+        assert_eq!(health.method(), "GET");
+        assert_eq!(health.check_response(204, ""), None);
+        assert!(health.check_response(301, "").is_some());
+        assert!(health.check_response(503, "").is_some());
 
-        let invalid_cpu = DeploymentConfig {
-            cpu_request: Some(2000),
-            cpu_limit: Some(1000),
-            security: None,
+        let login = SmokeTest {
+            name: "login".to_string(),
+            method: Some("post".to_string()),
+            path: "/login".to_string(),
+            expect_status: Some(401),
+            expect_body_contains: Some("invalid credentials".to_string()),
             ..Default::default()
         };
-        assert!(invalid_cpu.validate().is_err());
+        assert_eq!(login.method(), "POST");
+        assert_eq!(
+            login.check_response(401, r#"{"error":"invalid credentials"}"#),
+            None
+        );
+        assert_eq!(
+            login.check_response(200, "welcome"),
+            Some("Expected status 401, got 200".to_string())
+        );
+        assert_eq!(
+            login.check_response(401, "{}"),
+            Some("Response body does not contain 'invalid credentials'".to_string())
+        );
+    }
 
-        let invalid_memory = DeploymentConfig {
-            memory_request: Some(1024),
-            memory_limit: Some(512),
-            security: None,
+    #[test]
+    fn test_smoke_tests_validation() {
+        let check = |name: &str, path: &str| SmokeTest {
+            name: name.to_string(),
+            path: path.to_string(),
             ..Default::default()
         };
-        assert!(invalid_memory.validate().is_err());
+        let config = SmokeTestsConfig {
+            checks: vec![check("home", "/"), check("api", "/api/health")],
+            timeout_seconds: None,
+        };
+        assert!(config.validate().is_ok());
+        assert_eq!(config.timeout_seconds(), DEFAULT_SMOKE_TEST_TIMEOUT_SECONDS);
 
-        let invalid_port = DeploymentConfig {
-            exposed_port: Some(70000),
+        let duplicate = SmokeTestsConfig {
+            checks: vec![check("home", "/"), check("home", "/index.html")],
             ..Default::default()
         };
-        assert!(invalid_port.validate().is_err());
+        assert!(duplicate.validate().is_err());
+
+        for invalid in [
+            check("", "/"),
+            check("relative", "health"),
+            SmokeTest {
+                method: Some("TRACE".to_string()),
+                ..check("trace", "/")
+            },
+            SmokeTest {
+                expect_status: Some(42),
+                ..check("status", "/")
+            },
+            SmokeTest {
+                method: Some("HEAD".to_string()),
+                expect_body_contains: Some("ok".to_string()),
+                ..check("head", "/")
+            },
+        ] {
+            assert!(
+                invalid.validate().is_err(),
+                "{:?} should be invalid",
+                invalid
+            );
+        }
+
+        let slow = SmokeTestsConfig {
+            timeout_seconds: Some(0),
+            ..config
+        };
+        assert!(slow.validate().is_err());
+    }
+
+    #[test]
+    fn test_container_limits_validation() {
+        let ulimit = |name: &str, soft: i64, hard: Option<i64>| UlimitConfig {
+            name: name.to_string(),
+            soft,
+            hard,
+        };
+        let config = ContainerLimitsConfig {
+            ulimits: vec![
+                ulimit("nofile", 65536, None),
+                ulimit("nproc", 4096, Some(8192)),
+                ulimit("memlock", -1, None),
+                ulimit("core", 0, Some(-1)),
+            ],
+            shm_size_mb: Some(1024),
+        };
+        assert!(config.validate().is_ok());
+        assert_eq!(config.ulimits[0].hard(), 65536);
+
+        let invalid = [
+            vec![ulimit("files", 1024, None)],
+            vec![ulimit("nofile", -1, None)],
+            vec![ulimit("nofile", 2_000_000, None)],
+            vec![ulimit("nproc", 8192, Some(4096))],
+            vec![ulimit("stack", -1, Some(8_388_608))],
+            vec![ulimit("nofile", 1024, None), ulimit("nofile", 4096, None)],
+        ];
+        for ulimits in invalid {
+            let config = ContainerLimitsConfig {
+                ulimits,
+                shm_size_mb: None,
+            };
+            assert!(config.validate().is_err(), "{:?}", config);
+        }
+        for shm_size_mb in [0, MAX_SHM_SIZE_MB + 1] {
+            let config = ContainerLimitsConfig {
+                shm_size_mb: Some(shm_size_mb),
+                ..Default::default()
+            };
+            assert!(config.validate().is_err());
+        }
+
+        let deployment_config: DeploymentConfig = serde_json::from_value(serde_json::json!({
+            "memoryLimit": 512,
+            "containerLimits": { "shmSizeMb": 1024 }
+        }))
+        .unwrap();
+        assert!(deployment_config.validate().is_err());
+    }
+
+    #[test]
+    fn test_container_dns_validation() {
+        let config = ContainerDnsConfig {
+            nameservers: vec!["10.0.0.2".to_string(), "2606:4700:4700::1111".to_string()],
+            search_domains: vec!["internal.example.com".to_string()],
+            extra_hosts: vec![
+                ExtraHost {
+                    hostname: "db.internal".to_string(),
+                    ip: "10.0.0.12".to_string(),
+                },
+                ExtraHost {
+                    hostname: "metadata".to_string(),
+                    ip: HOST_GATEWAY.to_string(),
+                },
+            ],
+        };
+        assert!(config.validate().is_ok());
+        assert!(ContainerDnsConfig::default().is_empty());
+        assert_eq!(config.extra_hosts[0].to_docker(), "db.internal:10.0.0.12");
+
+        let invalid = [
+            ContainerDnsConfig {
+                nameservers: vec!["dns.example.com".to_string()],
+                ..Default::default()
+            },
+            ContainerDnsConfig {
+                nameservers: vec!["1.1.1.1".to_string(); MAX_DNS_NAMESERVERS + 1],
+                ..Default::default()
+            },
+            ContainerDnsConfig {
+                search_domains: vec!["-bad.example.com".to_string()],
+                ..Default::default()
+            },
+            ContainerDnsConfig {
+                extra_hosts: vec![ExtraHost {
+                    hostname: "db internal".to_string(),
+                    ip: "10.0.0.12".to_string(),
+                }],
+                ..Default::default()
+            },
+            ContainerDnsConfig {
+                extra_hosts: vec![ExtraHost {
+                    hostname: "db".to_string(),
+                    ip: "10.0.0".to_string(),
+                }],
+                ..Default::default()
+            },
+            ContainerDnsConfig {
+                extra_hosts: vec![
+                    ExtraHost {
+                        hostname: "db".to_string(),
+                        ip: "10.0.0.12".to_string(),
+                    },
+                    ExtraHost {
+                        hostname: "DB".to_string(),
+                        ip: "10.0.0.13".to_string(),
+                    },
+                ],
+                ..Default::default()
+            },
+        ];
+        for config in invalid {
+            assert!(
+                config.validate().is_err(),
+                "{:?} should be rejected",
+                config
+            );
+        }
+    }
+
+    #[test]
+    fn test_deploy_gate_validation() {
+        let config = DeployGateConfig {
+            url: "https://ci.example.com/hooks/temps-deploy".to_string(),
+            ..Default::default()
+        };
+        assert!(config.validate().is_ok());
+        assert_eq!(config.mode, DeployGateMode::Callback);
+        assert_eq!(
+            config.timeout_seconds(),
+            DEFAULT_DEPLOY_GATE_TIMEOUT_SECONDS
+        );
+
+        let config: DeployGateConfig = serde_json::from_value(serde_json::json!({
+            "url": "http://ci.internal:8080/deploy",
+            "mode": "poll",
+            "statusUrl": "http://ci.internal:8080/deploy/status",
+            "pollIntervalSeconds": 30
+        }))
+        .unwrap();
+        assert!(config.validate().is_ok());
+        assert_eq!(config.mode, DeployGateMode::Poll);
+        assert_eq!(config.poll_interval_seconds(), 30);
+
+        let invalid = [
+            DeployGateConfig {
+                url: "ci.example.com/deploy".to_string(),
+                ..Default::default()
+            },
+            DeployGateConfig {
+                status_url: Some("https:///status".to_string()),
+                ..config.clone()
+            },
+            DeployGateConfig {
+                poll_interval_seconds: Some(1),
+                ..config.clone()
+            },
+            DeployGateConfig {
+                timeout_seconds: Some(7 * 60 * 60),
+                ..config.clone()
+            },
+            DeployGateConfig {
+                secret: Some(String::new()),
+                ..config.clone()
+            },
+        ];
+        for config in invalid {
+            assert!(
+                config.validate().is_err(),
+                "{:?} should be rejected",
+                config
+            );
+        }
+    }
+
+    #[test]
+    fn test_deploy_gate_secrets_are_sealed_and_masked() {
+        let encryption = EncryptionService::new(
+            "0000000000000000000000000000000000000000000000000000000000000000",
+        )
+        .unwrap();
+        let mut gate = DeployGateConfig {
+            url: "https://ci.example.com/hooks/temps-deploy".to_string(),
+            headers: HashMap::from([
+                ("Authorization".to_string(), "Bearer ci-token".to_string()),
+                ("X-Team".to_string(), "platform".to_string()),
+            ]),
+            secret: Some("signing-secret".to_string()),
+            ..Default::default()
+        };
+        gate.seal(None, &encryption).unwrap();
+        assert_ne!(gate.secret.as_deref(), Some("signing-secret"));
+        assert_ne!(gate.headers["Authorization"], "Bearer ci-token");
+        assert_eq!(gate.headers["X-Team"], "platform");
+
+        let masked = gate.masked();
+        assert_eq!(masked.secret.as_deref(), Some(MASKED_SECRET));
+        assert_eq!(masked.headers["Authorization"], MASKED_SECRET);
+        assert_eq!(masked.headers["X-Team"], "platform");
+
+        // Sending the masked values back keeps the stored ones
+        let mut update = masked.clone();
+        update.seal(Some(&gate), &encryption).unwrap();
+        assert_eq!(update, gate);
+
+        let unsealed = update.unsealed(&encryption).unwrap();
+        assert_eq!(unsealed.secret.as_deref(), Some("signing-secret"));
+        assert_eq!(unsealed.headers["Authorization"], "Bearer ci-token");
+
+        // Nothing stored to keep
+        assert!(masked.clone().seal(None, &encryption).is_err());
+    }
+
+    #[test]
+    fn test_cdn_purge_api_token_is_sealed_and_masked() {
+        let encryption = EncryptionService::new(
+            "0000000000000000000000000000000000000000000000000000000000000000",
+        )
+        .unwrap();
+        let mut purge = CdnPurgeConfig {
+            provider: CdnProvider::Cloudflare,
+            api_token: "cf-token".to_string(),
+            zone_id: Some("023e105f4ecef8ad9ca31a8372d0c353".to_string()),
+            ..Default::default()
+        };
+        purge.seal(None, &encryption).unwrap();
+        assert_ne!(purge.api_token, "cf-token");
+
+        let config = DeploymentConfig {
+            cdn_purge: Some(purge.clone()),
+            ..Default::default()
+        };
+        let response = serde_json::to_value(config.masked()).unwrap();
+        assert_eq!(response["cdnPurge"]["apiToken"], MASKED_SECRET);
+        assert_eq!(
+            response["cdnPurge"]["zoneId"],
+            "023e105f4ecef8ad9ca31a8372d0c353"
+        );
+
+        // Sending the masked token back keeps the stored one
+        let mut update = purge.masked();
+        update.seal(Some(&purge), &encryption).unwrap();
+        assert_eq!(update, purge);
+        assert_eq!(update.unsealed(&encryption).unwrap().api_token, "cf-token");
+    }
+
+    #[test]
+    fn test_cdn_purge_validation() {
+        let config: CdnPurgeConfig = serde_json::from_value(serde_json::json!({
+            "provider": "cloudflare",
+            "apiToken": "token",
+            "zoneId": "023e105f4ecef8ad9ca31a8372d0c353"
+        }))
+        .unwrap();
+        assert!(config.validate().is_ok());
+        assert_eq!(config.scope, CdnPurgeScope::All);
+
+        let config: CdnPurgeConfig = serde_json::from_value(serde_json::json!({
+            "provider": "fastly",
+            "apiToken": "token",
+            "serviceId": "SU1Z0isxPaozGVKXdv0eY",
+            "scope": "urls",
+            "urls": ["https://example.com/", "https://example.com/app.js"]
+        }))
+        .unwrap();
+        assert!(config.validate().is_ok());
+
+        let invalid = [
+            CdnPurgeConfig {
+                api_token: " ".to_string(),
+                ..config.clone()
+            },
+            CdnPurgeConfig {
+                service_id: None,
+                ..config.clone()
+            },
+            CdnPurgeConfig {
+                provider: CdnProvider::Cloudflare,
+                ..config.clone()
+            },
+            CdnPurgeConfig {
+                service_id: Some("../purge_all".to_string()),
+                ..config.clone()
+            },
+            CdnPurgeConfig {
+                urls: vec!["example.com/app.js".to_string()],
+                ..config.clone()
+            },
+            CdnPurgeConfig {
+                scope: CdnPurgeScope::Tags,
+                ..config.clone()
+            },
+            CdnPurgeConfig {
+                scope: CdnPurgeScope::Tags,
+                tags: vec!["assets html".to_string()],
+                ..config.clone()
+            },
+        ];
+        for config in invalid {
+            assert!(
+                config.validate().is_err(),
+                "{:?} should be rejected",
+                config
+            );
+        }
+    }
+
+    #[test]
+    fn test_process_types_validation() {
+        let config = DeploymentConfig {
+            processes: Some(
+                serde_json::from_value(serde_json::json!({
+                    "worker": { "replicas": 3 },
+                    "clock": { "command": "bin/clock" },
+                    "web": { "command": "bin/rails server" }
+                }))
+                .unwrap(),
+            ),
+            ..Default::default()
+        };
+        assert!(config.validate().is_ok());
+        let processes = config.processes.as_ref().unwrap();
+        assert_eq!(processes["worker"].replicas(), 3);
+        assert_eq!(processes["clock"].replicas(), 1);
+
+        let invalid = [
+            serde_json::json!({ "web": { "replicas": 2 } }),
+            serde_json::json!({ "worker queue": {} }),
+            serde_json::json!({ "worker": { "replicas": 51 } }),
+            serde_json::json!({ "worker": { "command": " " } }),
+        ];
+        for processes in invalid {
+            let config = DeploymentConfig {
+                processes: Some(serde_json::from_value(processes.clone()).unwrap()),
+                ..Default::default()
+            };
+            assert!(
+                config.validate().is_err(),
+                "{} should be rejected",
+                processes
+            );
+        }
+    }
+
+    #[test]
+    fn test_command_overrides_validation() {
+        let config: DeploymentConfig = serde_json::from_value(serde_json::json!({
+            "buildCommand": "npm run build:prod",
+            "startCommand": "node dist/server.js"
+        }))
+        .unwrap();
+        assert!(config.validate().is_ok());
+        assert_eq!(config.build_command.as_deref(), Some("npm run build:prod"));
+
+        let invalid = [
+            serde_json::json!({ "buildCommand": "  " }),
+            serde_json::json!({ "startCommand": "" }),
+            serde_json::json!({ "buildCommand": "npm ci\nnpm run build" }),
+            serde_json::json!({
+                "buildCommand": "npm run build",
+                "builder": { "kind": "buildpacks" }
+            }),
+            serde_json::json!({
+                "startCommand": "node server.js",
+                "processes": { "web": { "command": "npm start" } }
+            }),
+        ];
+        for config in invalid {
+            let parsed: DeploymentConfig = serde_json::from_value(config.clone()).unwrap();
+            assert!(parsed.validate().is_err(), "{} should be rejected", config);
+        }
+
+        // An environment's command replaces the project's
+        let project = DeploymentConfig {
+            start_command: Some("npm start".to_string()),
+            ..Default::default()
+        };
+        let environment = DeploymentConfig {
+            start_command: Some("node server.js".to_string()),
+            ..Default::default()
+        };
+        assert_eq!(
+            project.merge(&environment).start_command.as_deref(),
+            Some("node server.js")
+        );
+    }
+
+    #[test]
+    fn test_entrypoint_and_args_overrides() {
+        let config: DeploymentConfig = serde_json::from_value(serde_json::json!({
+            "entrypoint": ["/usr/bin/tini", "--"],
+            "args": ["serve", "--port", "8080"]
+        }))
+        .unwrap();
+        assert!(config.validate().is_ok());
+        assert_eq!(config.args.as_ref().unwrap().len(), 3);
+
+        // A wrapping entrypoint runs the start command
+        let wrapped: DeploymentConfig = serde_json::from_value(serde_json::json!({
+            "entrypoint": ["dumb-init"],
+            "startCommand": "node server.js"
+        }))
+        .unwrap();
+        assert!(wrapped.validate().is_ok());
+
+        let invalid = [
+            serde_json::json!({ "entrypoint": [] }),
+            serde_json::json!({ "entrypoint": [" ", "--"] }),
+            serde_json::json!({ "args": [] }),
+            serde_json::json!({ "args": ["serve"], "startCommand": "node server.js" }),
+            serde_json::json!({
+                "args": ["serve"],
+                "processes": { "web": { "command": "npm start" } }
+            }),
+        ];
+        for config in invalid {
+            let parsed: DeploymentConfig = serde_json::from_value(config.clone()).unwrap();
+            assert!(parsed.validate().is_err(), "{} should be rejected", config);
+        }
+
+        let project = DeploymentConfig {
+            entrypoint: Some(vec!["/app/server".to_string()]),
+            args: Some(vec!["--verbose".to_string()]),
+            ..Default::default()
+        };
+        let environment = DeploymentConfig {
+            args: Some(vec!["--quiet".to_string()]),
+            ..Default::default()
+        };
+        let merged = project.merge(&environment);
+        assert_eq!(merged.entrypoint, project.entrypoint);
+        assert_eq!(merged.args, environment.args);
+    }
+
+    #[test]
+    fn test_auto_retry_backoff() {
+        let config = AutoRetryConfig::default();
+        assert!(config.validate().is_ok());
+        assert_eq!(config.max_retries(), DEFAULT_AUTO_RETRY_MAX_RETRIES);
+        assert_eq!(config.backoff_before_retry(1), 30);
+        assert_eq!(config.backoff_before_retry(2), 60);
+        assert_eq!(config.backoff_before_retry(6), 600);
+
+        let config: AutoRetryConfig = serde_json::from_value(serde_json::json!({
+            "maxRetries": 5,
+            "backoffSeconds": 10,
+            "maxBackoffSeconds": 45
+        }))
+        .unwrap();
+        assert!(config.validate().is_ok());
+        assert_eq!(config.backoff_before_retry(3), 40);
+        assert_eq!(config.backoff_before_retry(4), 45);
+        assert_eq!(config.backoff_before_retry(40), 45);
+
+        let invalid = [
+            AutoRetryConfig {
+                max_retries: Some(0),
+                ..Default::default()
+            },
+            AutoRetryConfig {
+                max_retries: Some(11),
+                ..Default::default()
+            },
+            AutoRetryConfig {
+                backoff_seconds: Some(0),
+                ..Default::default()
+            },
+            AutoRetryConfig {
+                backoff_seconds: Some(120),
+                max_backoff_seconds: Some(60),
+                ..Default::default()
+            },
+        ];
+        for config in invalid {
+            assert!(
+                config.validate().is_err(),
+                "{:?} should be rejected",
+                config
+            );
+        }
+    }
+
+    #[test]
+    fn test_concurrency_limit_validation() {
+        let config: ConcurrencyLimitConfig =
+            serde_json::from_value(serde_json::json!({ "maxInFlight": 4 })).unwrap();
+        assert!(config.validate().is_ok());
+        assert_eq!(config.queue_size(), 0);
+        assert_eq!(
+            config.queue_timeout(),
+            std::time::Duration::from_millis(DEFAULT_CONCURRENCY_QUEUE_TIMEOUT_MS as u64)
+        );
+
+        let limit = |max_in_flight, queue_size, queue_timeout_ms| ConcurrencyLimitConfig {
+            max_in_flight,
+            queue_size,
+            queue_timeout_ms,
+        };
+        assert!(limit(10, Some(5), Some(250)).validate().is_ok());
+        for config in [
+            limit(0, None, None),
+            limit(10, Some(10_001), None),
+            limit(10, Some(5), Some(0)),
+            limit(10, Some(5), Some(60_001)),
+        ] {
+            assert!(
+                config.validate().is_err(),
+                "{:?} should be rejected",
+                config
+            );
+        }
+    }
+
+    #[test]
+    fn test_startup_probe_validation() {
+        let probe = StartupProbeConfig::default();
+        assert!(probe.validate().is_ok());
+        assert_eq!(probe.startup_budget(), std::time::Duration::from_secs(600));
+
+        let invalid = [
+            StartupProbeConfig {
+                path: Some("health".to_string()),
+                ..Default::default()
+            },
+            StartupProbeConfig {
+                period_seconds: Some(0),
+                ..Default::default()
+            },
+            StartupProbeConfig {
+                period_seconds: Some(2),
+                timeout_seconds: Some(5),
+                ..Default::default()
+            },
+            StartupProbeConfig {
+                failure_threshold: Some(0),
+                ..Default::default()
+            },
+        ];
+        for probe in invalid {
+            assert!(probe.validate().is_err(), "{:?} should be rejected", probe);
+        }
+
+        // The probe has to fit in the deploy
+        let mut config = DeploymentConfig {
+            startup_probe: Some(StartupProbeConfig {
+                period_seconds: Some(60),
+                failure_threshold: Some(90),
+                ..Default::default()
+            }),
+            ..Default::default()
+        };
+        assert!(config.validate().is_err());
+        config.deploy_timeout_seconds = Some(7200);
+        assert!(config.validate().is_ok());
+    }
+
+    #[test]
+    fn test_config_reload_validation() {
+        let reload = ConfigReloadConfig::default();
+        assert_eq!(reload.method(), ConfigReloadMethod::Restart);
+        assert!(reload.validate().is_ok());
+
+        let reload = ConfigReloadConfig {
+            method: Some(ConfigReloadMethod::Signal),
+            signal: None,
+        };
+        assert_eq!(reload.signal(), "SIGHUP");
+        assert!(reload.validate().is_ok());
+
+        for signal in ["SIGUSR1", "USR2", "10"] {
+            let reload = ConfigReloadConfig {
+                method: Some(ConfigReloadMethod::Signal),
+                signal: Some(signal.to_string()),
+            };
+            assert!(reload.validate().is_ok(), "{} should be accepted", signal);
+        }
+        assert!(ConfigReloadConfig {
+            method: Some(ConfigReloadMethod::Signal),
+            signal: Some("sighup".to_string()),
+        }
+        .validate()
+        .is_err());
+        // A signal is only sent with the signal method
+        assert!(ConfigReloadConfig {
+            method: Some(ConfigReloadMethod::Watch),
+            signal: Some("SIGHUP".to_string()),
+        }
+        .validate()
+        .is_err());
+    }
+
+    #[test]
+    fn test_exec_health_check_validation() {
+        let check: ExecHealthCheckConfig = serde_json::from_str(
+            r#"{"command": ["curl", "-fsS", "--unix-socket", "/run/app.sock", "http://localhost/health"]}"#,
+        )
+        .unwrap();
+        assert!(check.validate().is_ok());
+        assert_eq!(check.interval_seconds(), 5);
+        assert_eq!(check.success_threshold(), 2);
+        assert_eq!(check.failure_threshold(), 12);
+
+        let command = vec!["pg_isready".to_string()];
+        let invalid = [
+            ExecHealthCheckConfig::default(),
+            ExecHealthCheckConfig {
+                command: vec![" ".to_string()],
+                ..Default::default()
+            },
+            ExecHealthCheckConfig {
+                command: command.clone(),
+                interval_seconds: Some(2),
+                timeout_seconds: Some(5),
+                ..Default::default()
+            },
+            ExecHealthCheckConfig {
+                command: command.clone(),
+                success_threshold: Some(0),
+                ..Default::default()
+            },
+            ExecHealthCheckConfig {
+                command: command.clone(),
+                failure_threshold: Some(0),
+                ..Default::default()
+            },
+        ];
+        for check in invalid {
+            assert!(check.validate().is_err(), "{:?} should be rejected", check);
+        }
+
+        let config = DeploymentConfig {
+            exec_health_check: Some(ExecHealthCheckConfig::default()),
+            ..Default::default()
+        };
+        assert!(config.validate().is_err());
+    }
+
+    #[test]
+    fn test_health_thresholds() {
+        let thresholds = HealthThresholdsConfig {
+            unhealthy_after: None,
+            restart_after: Some(10),
+        };
+        assert!(thresholds.validate().is_ok());
+        assert_eq!(thresholds.state_after(0), ServiceHealthState::Healthy);
+        assert_eq!(thresholds.state_after(2), ServiceHealthState::Failing);
+        assert_eq!(thresholds.state_after(3), ServiceHealthState::Unhealthy);
+        assert!(!thresholds.restart_due(9));
+        assert!(thresholds.restart_due(10));
+        assert!(!thresholds.restart_due(11));
+        // Restarted again while it stays down
+        assert!(thresholds.restart_due(20));
+
+        // Never restarted unless asked to
+        assert!(!HealthThresholdsConfig::default().restart_due(1000));
+
+        for invalid in [
+            HealthThresholdsConfig {
+                unhealthy_after: Some(0),
+                restart_after: None,
+            },
+            HealthThresholdsConfig {
+                unhealthy_after: Some(5),
+                restart_after: Some(5),
+            },
+            HealthThresholdsConfig {
+                unhealthy_after: None,
+                restart_after: Some(MAX_HEALTH_THRESHOLD + 1),
+            },
+        ] {
+            assert!(
+                invalid.validate().is_err(),
+                "{:?} should be rejected",
+                invalid
+            );
+        }
+    }
+
+    #[test]
+    fn test_upstream_protocol_defaults_to_http1() {
+        let config: DeploymentConfig = serde_json::from_str("{}").unwrap();
+        assert_eq!(config.upstream_protocol(), UpstreamProtocol::Http1);
+        assert!(!config.upstream_protocol().is_http2());
+
+        let config: DeploymentConfig =
+            serde_json::from_str(r#"{"upstreamProtocol": "h2c"}"#).unwrap();
+        assert_eq!(config.upstream_protocol(), UpstreamProtocol::H2c);
+        assert!(config.upstream_protocol().is_http2());
     }
 
     #[test]
@@ -575,10 +4923,57 @@ mod tests {
             memory_limit: Some(512),
             exposed_port: Some(3000),
             automatic_deploy: true,
+            auto_deploy_tag_pattern: None,
             performance_metrics_enabled: true,
             session_recording_enabled: false,
             replicas: 3,
             security: None,
+            upstream_protocol: Some(UpstreamProtocol::H2),
+            request_timeout_seconds: Some(60),
+            stream_timeout_seconds: Some(3600),
+            websocket_idle_timeout_seconds: None,
+            websocket_max_duration_seconds: None,
+            response_buffering: None,
+            connection_drain_seconds: None,
+            max_request_body_bytes: None,
+            max_request_header_bytes: None,
+            client_timeout_seconds: None,
+            write_timeout_seconds: None,
+            idle_timeout_seconds: None,
+            connect_timeout_seconds: None,
+            https_mode: None,
+            https_redirect_status: None,
+            stop_signal: None,
+            stop_timeout_seconds: None,
+            artifact_retention_days: None,
+            retained_images: None,
+            deploy_timeout_seconds: None,
+            static_site: None,
+            promotion: None,
+            build_cache: None,
+            builder: None,
+            shared_build: None,
+            build_command: None,
+            start_command: None,
+            entrypoint: None,
+            args: None,
+            build_platforms: None,
+            container_logs: None,
+            locale_defaults: None,
+            smoke_tests: None,
+            container_dns: None,
+            container_limits: None,
+            deploy_gate: None,
+            cdn_purge: None,
+            processes: None,
+            auto_retry: None,
+            build_priority: None,
+            protected_secrets: None,
+            concurrency_limit: None,
+            startup_probe: None,
+            config_reload: None,
+            exec_health_check: None,
+            health_thresholds: None,
         };
 
         let json = serde_json::to_value(&config).unwrap();
@@ -596,10 +4991,57 @@ mod tests {
             memory_limit: Some(512),
             exposed_port: Some(3000),
             automatic_deploy: true,
+            auto_deploy_tag_pattern: None,
             performance_metrics_enabled: true,
             session_recording_enabled: false,
             replicas: 2,
             security: None,
+            upstream_protocol: None,
+            request_timeout_seconds: Some(30),
+            stream_timeout_seconds: None,
+            websocket_idle_timeout_seconds: None,
+            websocket_max_duration_seconds: None,
+            response_buffering: None,
+            connection_drain_seconds: None,
+            max_request_body_bytes: None,
+            max_request_header_bytes: None,
+            client_timeout_seconds: None,
+            write_timeout_seconds: None,
+            idle_timeout_seconds: None,
+            connect_timeout_seconds: None,
+            https_mode: None,
+            https_redirect_status: None,
+            stop_signal: None,
+            stop_timeout_seconds: None,
+            artifact_retention_days: None,
+            retained_images: None,
+            deploy_timeout_seconds: None,
+            static_site: None,
+            promotion: None,
+            build_cache: None,
+            builder: None,
+            shared_build: None,
+            build_command: None,
+            start_command: None,
+            entrypoint: None,
+            args: None,
+            build_platforms: None,
+            container_logs: None,
+            locale_defaults: None,
+            smoke_tests: None,
+            container_dns: None,
+            container_limits: None,
+            deploy_gate: None,
+            cdn_purge: None,
+            processes: None,
+            auto_retry: None,
+            build_priority: None,
+            protected_secrets: None,
+            concurrency_limit: None,
+            startup_probe: None,
+            config_reload: None,
+            exec_health_check: None,
+            health_thresholds: None,
         };
 
         let mut env_vars = HashMap::new();
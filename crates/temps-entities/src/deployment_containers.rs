@@ -20,6 +20,8 @@ pub struct Model {
     pub deployed_at: DBDateTime,
     pub ready_at: Option<DBDateTime>,
     pub deleted_at: Option<DBDateTime>,
+    /// Procfile process type the container runs; only `web` containers get traffic
+    pub process_type: String,
 }
 
 #[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
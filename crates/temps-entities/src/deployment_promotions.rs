@@ -0,0 +1,78 @@
+//! Deployment Promotions Entity
+//!
+//! A promotion of a deployment's image from one environment to another, e.g. from
+//! staging to production, without rebuilding it. The row links the source deployment
+//! to the deployment it became (the promotion lineage) and records the approval the
+//! promotion went through when the target environment requires one.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+/// Waiting for someone other than the requester to approve it
+pub const PROMOTION_STATUS_PENDING_APPROVAL: &str = "pending_approval";
+/// The target deployment was created and is being (or has been) deployed
+pub const PROMOTION_STATUS_DEPLOYING: &str = "deploying";
+/// Rejected by a reviewer; nothing was deployed
+pub const PROMOTION_STATUS_REJECTED: &str = "rejected";
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "deployment_promotions")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub project_id: i32,
+    /// Deployment whose image is promoted
+    pub source_deployment_id: i32,
+    pub source_environment_id: i32,
+    pub target_environment_id: i32,
+    /// Deployment created in the target environment, once the promotion is deployed
+    pub target_deployment_id: Option<i32>,
+    /// Image tag of the source deployment; None for static deployments
+    pub image_name: Option<String>,
+    /// Image ID the tag resolved to when the promotion was requested, which is what
+    /// gets deployed
+    pub image_digest: Option<String>,
+    /// One of the PROMOTION_STATUS_* values
+    pub status: String,
+    pub requested_by: Option<i32>,
+    pub reviewed_by: Option<i32>,
+    pub reviewed_at: Option<DBDateTime>,
+    pub review_comment: Option<String>,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::deployments::Entity",
+        from = "Column::SourceDeploymentId",
+        to = "super::deployments::Column::Id"
+    )]
+    SourceDeployment,
+    #[sea_orm(
+        belongs_to = "super::deployments::Entity",
+        from = "Column::TargetDeploymentId",
+        to = "super::deployments::Column::Id"
+    )]
+    TargetDeployment,
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(now);
+        }
+        self.updated_at = Set(now);
+
+        Ok(self)
+    }
+}
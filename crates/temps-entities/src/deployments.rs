@@ -5,7 +5,7 @@ use serde::{Deserialize, Serialize};
 use temps_core::DBDateTime;
 use utoipa::ToSchema;
 
-use super::deployment_config::DeploymentConfigSnapshot;
+use super::deployment_config::{CdnProvider, CdnPurgeScope, DeploymentConfigSnapshot};
 
 /// Git push event information that triggered the deployment
 #[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
@@ -19,6 +19,240 @@ pub struct GitPushEvent {
     pub branch: String,
     /// Commit SHA
     pub commit: String,
+    /// Tag that was pushed or deployed, if any
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tag: Option<String>,
+}
+
+/// Base image a build started from, with its registry digest at build time
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct BaseImageDigest {
+    /// Image reference as written in the Dockerfile (e.g. `node:20-alpine`)
+    pub image: String,
+    /// Registry digest (`sha256:...`)
+    pub digest: String,
+}
+
+/// Newer digest of a base image, found by the base image update check
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct BaseImageUpdate {
+    pub image: String,
+    /// Digest the previous build used
+    pub previous_digest: String,
+    /// Digest now published under the same tag
+    pub digest: String,
+}
+
+/// Outcome of one smoke test against a new deployment
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct SmokeTestResult {
+    pub name: String,
+    pub method: String,
+    /// URL the check was sent to, the replica's internal address and the test's path
+    pub url: String,
+    /// Response status; None when no response was received
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub status: Option<u16>,
+    pub passed: bool,
+    /// Why the check failed
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    pub duration_ms: i64,
+}
+
+/// Where a deployment's image build spent its time, in milliseconds
+///
+/// Steps of different build stages can run in parallel, so the phases can add up to
+/// more than the build took.
+#[derive(Clone, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct BuildPhaseTimings {
+    /// Packing and sending the build context
+    pub context_upload_ms: u64,
+    /// Resolving and pulling base images
+    pub base_image_ms: u64,
+    /// Steps running a package manager, such as `npm ci` or `pip install`
+    pub dependency_install_ms: u64,
+    /// Other `RUN` steps
+    pub compile_ms: u64,
+    /// Writing the image's layers
+    pub image_export_ms: u64,
+    /// `COPY`, `WORKDIR` and the other steps that run no command
+    pub other_ms: u64,
+    /// Moving the image from a build node; None for builds on this server
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub push_ms: Option<u64>,
+    pub steps: u32,
+    /// Steps taken from the build cache instead of run
+    pub cached_steps: u32,
+}
+
+/// Smoke tests run against a new deployment before it received traffic
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct SmokeTestReport {
+    /// Whether every check passed; a failed check fails the deployment
+    pub passed: bool,
+    pub results: Vec<SmokeTestResult>,
+}
+
+/// Verdict of a deploy gate
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum DeployGateState {
+    /// Waiting for the external system
+    Pending,
+    Passed,
+    Failed,
+}
+
+impl DeployGateState {
+    /// State reported by an external system, accepting the usual CI status names
+    pub fn from_status(status: &str) -> Option<Self> {
+        match status.trim().to_lowercase().as_str() {
+            "pending" | "queued" | "running" | "in_progress" => Some(Self::Pending),
+            "passed" | "success" | "succeeded" => Some(Self::Passed),
+            "failed" | "failure" | "error" | "cancelled" | "canceled" => Some(Self::Failed),
+            _ => None,
+        }
+    }
+}
+
+/// External check of a new deployment before it received traffic
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct DeployGateReport {
+    pub state: DeployGateState,
+    /// URL the deploy context was posted to
+    pub url: String,
+    /// Explanation given by the external system, or why the gate failed
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub message: Option<String>,
+    /// Link to the external run, e.g. the CI job
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub details_url: Option<String>,
+    /// SHA-256 of the token a callback must present; the token itself is only sent
+    /// to the external system
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub callback_token_hash: Option<String>,
+    #[schema(value_type = String, format = "date-time")]
+    pub started_at: chrono::DateTime<chrono::Utc>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub completed_at: Option<chrono::DateTime<chrono::Utc>>,
+}
+
+/// Progress of the drain of the previous deployment's connections
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ConnectionDrainState {
+    /// Waiting for open connections to finish
+    Draining,
+    /// Every connection finished before the timeout
+    Drained,
+    /// The timeout passed with connections still open
+    TimedOut,
+    /// Cancelled by a user, stopping the previous deployment right away
+    Cancelled,
+}
+
+/// Drain of the previous deployment's open connections after the cutover, before its
+/// containers are stopped
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ConnectionDrainReport {
+    pub state: ConnectionDrainState,
+    /// Longest the drain waits, from the environment's `connection_drain_seconds`
+    pub timeout_seconds: u32,
+    /// Deployments being drained
+    pub deployment_ids: Vec<i32>,
+    /// Connections the previous deployments still serve; None when the proxy doesn't
+    /// share its counts with this server
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub in_flight_connections: Option<usize>,
+    /// Set by a user to stop waiting and force-stop the previous deployments
+    #[serde(default)]
+    pub cancel_requested: bool,
+    #[schema(value_type = String, format = "date-time")]
+    pub started_at: chrono::DateTime<chrono::Utc>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub completed_at: Option<chrono::DateTime<chrono::Utc>>,
+}
+
+/// Purge of the CDN's cache after the deployment received traffic
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct CdnPurgeReport {
+    pub provider: CdnProvider,
+    pub scope: CdnPurgeScope,
+    /// Whether the CDN accepted every purge request
+    pub succeeded: bool,
+    /// Why the purge failed
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub message: Option<String>,
+    #[schema(value_type = String, format = "date-time")]
+    pub started_at: chrono::DateTime<chrono::Utc>,
+    #[schema(value_type = String, format = "date-time")]
+    pub completed_at: chrono::DateTime<chrono::Utc>,
+}
+
+/// Process type of the app's Procfile
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct ProcessType {
+    #[schema(example = "worker")]
+    pub name: String,
+    #[schema(example = "bundle exec sidekiq")]
+    pub command: String,
+}
+
+/// How a deployment uses the artifact it was fetched from
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum RemoteArtifactKind {
+    /// A tarball of the app's source, built like a checkout of its repository
+    #[default]
+    BuildContext,
+    /// An image archive written by `docker save`, run as it is
+    Image,
+}
+
+/// Tarball a deployment was fetched from, in place of the project's repository
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct RemoteArtifact {
+    #[schema(example = "https://ci.example.com/builds/812/app.tar.gz")]
+    pub url: String,
+    pub kind: RemoteArtifactKind,
+    /// Hex SHA-256 the downloaded file must have
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub sha256: Option<String>,
+    /// `Authorization` header sent with the download, encrypted
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub encrypted_authorization: Option<String>,
+}
+
+/// A failed run of a deployment's workflow under an automatic retry policy
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct DeployAttempt {
+    /// Run of the workflow, starting at 1
+    pub attempt: u32,
+    pub error: String,
+    /// Whether the failure was classified as transient, and so could be retried
+    pub transient: bool,
+    #[schema(value_type = String, format = "date-time")]
+    pub started_at: chrono::DateTime<chrono::Utc>,
+    #[schema(value_type = String, format = "date-time")]
+    pub failed_at: chrono::DateTime<chrono::Utc>,
+    /// When the next attempt starts; None when the deployment wasn't retried
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub retry_at: Option<chrono::DateTime<chrono::Utc>>,
 }
 
 /// Deployment metadata - typed information about the deployment
@@ -62,9 +296,94 @@ pub struct DeploymentMetadata {
     #[serde(skip_serializing_if = "Option::is_none")]
     pub rolled_back_from_id: Option<i32>,
 
+    /// ID of the deployment in another environment this was promoted from (if applicable)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub promoted_from_id: Option<i32>,
+
+    /// Image a promoted or redeployed deployment runs, pinned by ID so it is exactly
+    /// the one that was promoted or redeployed
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub promoted_image: Option<String>,
+
+    /// ID of the deployment of the same environment whose image this redeploys with
+    /// the environment's current variables (if applicable)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub redeployed_from_id: Option<i32>,
+
     /// Custom labels/tags for the deployment
     #[serde(default, skip_serializing_if = "Vec::is_empty")]
     pub labels: Vec<String>,
+
+    /// Base images the build started from
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub base_images: Vec<BaseImageDigest>,
+
+    /// Where the image build spent its time; None for deployments that didn't build,
+    /// and for builders that don't report their steps
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub build_phases: Option<BuildPhaseTimings>,
+
+    /// Base image updates this deployment was rebuilt for; empty for deployments of a
+    /// code change
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub base_image_updates: Vec<BaseImageUpdate>,
+
+    /// Smoke tests run against the new containers before cutover
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub smoke_tests: Option<SmokeTestReport>,
+
+    /// External check run against the new containers before cutover
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub deploy_gate: Option<DeployGateReport>,
+
+    /// Drain of the previous deployment's connections after the cutover
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub connection_drain: Option<ConnectionDrainReport>,
+
+    /// Purge of the CDN's cache after the cutover
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub cdn_purge: Option<CdnPurgeReport>,
+
+    /// Process types of the Procfile the deployment's image was built with; empty
+    /// when the app has no Procfile. Promotions of the deployment run the same ones
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub process_types: Vec<ProcessType>,
+
+    /// Failed attempts of a deployment retried automatically, oldest first
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub deploy_attempts: Vec<DeployAttempt>,
+
+    /// Release note of the deployment, shown in the project's changelog instead of
+    /// the commit message
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub release_note: Option<String>,
+
+    /// User who started the deployment; None for deployments of a push
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub triggered_by_user_id: Option<i32>,
+
+    /// Whether the deployment only builds and checks the app: its containers are
+    /// removed afterwards and it never receives traffic
+    #[serde(default)]
+    pub dry_run: bool,
+
+    /// Artifact the deployment was fetched from, for deployments of a CI build
+    /// rather than of the repository
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub remote_artifact: Option<RemoteArtifact>,
+}
+
+impl DeploymentMetadata {
+    /// Whether this deployment rebuilt unchanged code to pick up newer base images
+    pub fn is_base_image_rebuild(&self) -> bool {
+        !self.base_image_updates.is_empty()
+    }
+
+    /// Whether this deployment runs the image of another deployment, promoted or
+    /// redeployed, instead of building one
+    pub fn reuses_image(&self) -> bool {
+        self.promoted_from_id.is_some() || self.redeployed_from_id.is_some()
+    }
 }
 
 #[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
@@ -103,6 +422,31 @@ pub struct Model {
     pub deployment_config: Option<DeploymentConfigSnapshot>,
 }
 
+impl Model {
+    /// Whether the deployment is a dry run, which never ships
+    pub fn is_dry_run(&self) -> bool {
+        self.metadata
+            .as_ref()
+            .is_some_and(|metadata| metadata.dry_run)
+    }
+
+    /// Text of the deployment's changelog entry: its release note, or else the first
+    /// line of its commit message
+    pub fn changelog_note(&self) -> Option<String> {
+        self.metadata
+            .as_ref()
+            .and_then(|metadata| metadata.release_note.as_deref())
+            .map(str::trim)
+            .filter(|note| !note.is_empty())
+            .or_else(|| {
+                self.commit_message
+                    .as_deref()
+                    .and_then(|message| message.lines().map(str::trim).find(|l| !l.is_empty()))
+            })
+            .map(str::to_string)
+    }
+}
+
 #[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
 pub enum Relation {
     #[sea_orm(
@@ -23,6 +23,8 @@ pub struct Model {
     pub last_error_type: Option<String>,
     pub is_wildcard: bool,
     pub verification_method: String,
+    /// ACME directory certificates are requested from; None uses the server default
+    pub acme_directory: Option<String>,
     pub created_at: DBDateTime,
     pub updated_at: DBDateTime,
 }
@@ -2,7 +2,9 @@ use async_trait::async_trait;
 use sea_orm::entity::prelude::*;
 use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
 use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
 use temps_core::DBDateTime;
+use utoipa::ToSchema;
 
 #[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
 #[sea_orm(table_name = "env_vars")]
@@ -17,6 +19,11 @@ pub struct Model {
     pub updated_at: DBDateTime,
     /// Include this environment variable in preview environments
     pub include_in_preview: bool,
+    /// Only passed to builds as a build argument; the running containers never see it
+    pub build_only: bool,
+    /// Shared by every environment of the project instead of linked to some of them;
+    /// an environment's own variable with the same key wins
+    pub shared: bool,
 }
 
 #[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
@@ -59,6 +66,119 @@ impl Related<super::env_var_environments::Entity> for Entity {
     }
 }
 
+/// Start of a reference to a shared variable in a value, as in `${shared.API_TOKEN}`
+pub const SHARED_REFERENCE_PREFIX: &str = "${shared.";
+
+/// Where an environment's value of a variable comes from
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum VariableSource {
+    /// A variable shared by every environment of the project
+    Shared,
+    /// A variable of the environment itself
+    Environment,
+}
+
+/// A variable as an environment gets it, after shared variables are merged in and
+/// references to them replaced
+#[derive(Clone, Debug, PartialEq, Eq)]
+pub struct ResolvedVariable {
+    pub key: String,
+    pub value: String,
+    pub source: VariableSource,
+    /// Variable the value comes from
+    pub variable_id: i32,
+    /// Whether the environment's variable replaces a shared one with the same key
+    pub overrides_shared: bool,
+    /// Shared variables referenced by the value
+    pub references: Vec<String>,
+    pub build_only: bool,
+}
+
+/// Names of the shared variables a value references, in order of appearance
+pub fn shared_references(value: &str) -> Vec<String> {
+    let mut names: Vec<String> = Vec::new();
+    let mut rest = value;
+    while let Some(start) = rest.find(SHARED_REFERENCE_PREFIX) {
+        let after = &rest[start + SHARED_REFERENCE_PREFIX.len()..];
+        let Some(end) = after.find('}') else {
+            break;
+        };
+        let name = &after[..end];
+        if !name.is_empty() && !names.iter().any(|known| known == name) {
+            names.push(name.to_string());
+        }
+        rest = &after[end + 1..];
+    }
+    names
+}
+
+/// Variables an environment gets from the project's shared variables and its own
+///
+/// The environment's variables win over shared ones with the same key. Preview
+/// environments only get the shared variables included in previews. References to
+/// shared variables are replaced with their values; a reference to a shared variable
+/// the environment doesn't get is an error, so nothing deploys with a value left
+/// half resolved.
+pub fn resolve_variables(
+    shared: &[Model],
+    own: &[Model],
+    preview: bool,
+) -> Result<Vec<ResolvedVariable>, String> {
+    let shared: Vec<&Model> = shared
+        .iter()
+        .filter(|var| !preview || var.include_in_preview)
+        .collect();
+    let shared_values: HashMap<&str, &str> = shared
+        .iter()
+        .map(|var| (var.key.as_str(), var.value.as_str()))
+        .collect();
+
+    let mut resolved: BTreeMap<String, ResolvedVariable> = BTreeMap::new();
+    let sources = shared
+        .iter()
+        .copied()
+        .map(|var| (var, VariableSource::Shared))
+        .chain(own.iter().map(|var| (var, VariableSource::Environment)));
+    for (var, source) in sources {
+        let references = shared_references(&var.value);
+        let mut value = var.value.clone();
+        for name in &references {
+            let shared_value = shared_values.get(name.as_str()).ok_or_else(|| {
+                format!(
+                    "Variable {} references the shared variable {}, which {}",
+                    var.key,
+                    name,
+                    if preview {
+                        "doesn't exist or isn't included in preview environments"
+                    } else {
+                        "doesn't exist"
+                    }
+                )
+            })?;
+            value = value.replace(
+                &format!("{}{}}}", SHARED_REFERENCE_PREFIX, name),
+                shared_value,
+            );
+        }
+
+        resolved.insert(
+            var.key.clone(),
+            ResolvedVariable {
+                key: var.key.clone(),
+                value,
+                source,
+                variable_id: var.id,
+                overrides_shared: source == VariableSource::Environment
+                    && shared_values.contains_key(var.key.as_str()),
+                references,
+                build_only: var.build_only,
+            },
+        );
+    }
+    Ok(resolved.into_values().collect())
+}
+
 #[async_trait]
 impl ActiveModelBehavior for ActiveModel {
     async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
@@ -81,3 +201,70 @@ impl ActiveModelBehavior for ActiveModel {
         Ok(self)
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn var(id: i32, key: &str, value: &str, shared: bool) -> Model {
+        Model {
+            id,
+            project_id: 1,
+            environment_id: None,
+            key: key.to_string(),
+            value: value.to_string(),
+            created_at: chrono::Utc::now(),
+            updated_at: chrono::Utc::now(),
+            include_in_preview: true,
+            build_only: false,
+            shared,
+        }
+    }
+
+    #[test]
+    fn test_shared_references() {
+        assert_eq!(
+            shared_references("Bearer ${shared.TOKEN} at ${shared.API_URL}/${shared.TOKEN}"),
+            vec!["TOKEN".to_string(), "API_URL".to_string()]
+        );
+        assert!(shared_references("${shared.} ${other.X} $shared.Y ${shared.Z").is_empty());
+    }
+
+    #[test]
+    fn test_environment_variables_win_over_shared() {
+        let shared = [
+            var(1, "API_URL", "https://api.example.com", true),
+            var(2, "API_TOKEN", "s3cret", true),
+        ];
+        let own = [
+            var(3, "API_URL", "https://staging-api.example.com", false),
+            var(4, "AUTH_HEADER", "Bearer ${shared.API_TOKEN}", false),
+        ];
+
+        let resolved = resolve_variables(&shared, &own, false).unwrap();
+        let by_key: HashMap<&str, &ResolvedVariable> =
+            resolved.iter().map(|var| (var.key.as_str(), var)).collect();
+        assert_eq!(resolved.len(), 3);
+        assert_eq!(by_key["API_URL"].value, "https://staging-api.example.com");
+        assert_eq!(by_key["API_URL"].source, VariableSource::Environment);
+        assert!(by_key["API_URL"].overrides_shared);
+        assert_eq!(by_key["API_TOKEN"].source, VariableSource::Shared);
+        assert_eq!(by_key["AUTH_HEADER"].value, "Bearer s3cret");
+        assert_eq!(by_key["AUTH_HEADER"].references, vec!["API_TOKEN"]);
+        assert!(!by_key["AUTH_HEADER"].overrides_shared);
+    }
+
+    #[test]
+    fn test_unresolved_references_are_errors() {
+        let mut token = var(1, "API_TOKEN", "s3cret", true);
+        let own = [var(2, "AUTH_HEADER", "Bearer ${shared.API_TOKEN}", false)];
+        assert!(resolve_variables(&[], &own, false).is_err());
+
+        // Shared variables left out of previews can't be referenced there either
+        token.include_in_preview = false;
+        let shared = [token];
+        assert!(resolve_variables(&shared, &own, false).is_ok());
+        let error = resolve_variables(&shared, &own, true).unwrap_err();
+        assert!(error.contains("preview"));
+    }
+}
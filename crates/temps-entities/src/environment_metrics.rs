@@ -0,0 +1,50 @@
+//! Environment Metrics Entity
+//!
+//! Resource usage of an environment's running containers, sampled periodically and
+//! summed over its containers (replicas of one deployment, or the old and new
+//! containers during a cutover). Stored in a TimescaleDB hypertable with an hourly
+//! continuous aggregate, `environment_metrics_hourly`, for longer ranges.
+
+use sea_orm::entity::prelude::*;
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Serialize, Deserialize)]
+#[sea_orm(table_name = "environment_metrics")]
+pub struct Model {
+    #[sea_orm(primary_key, auto_increment = false)]
+    pub environment_id: i32,
+    #[sea_orm(primary_key, auto_increment = false)]
+    pub timestamp: DBDateTime,
+    pub project_id: i32,
+    /// Deployment the containers belonged to when sampled
+    pub deployment_id: Option<i32>,
+    pub container_count: i32,
+    /// CPU usage summed over the containers, in percent of one host
+    pub cpu_percent: f64,
+    pub memory_bytes: i64,
+    /// Sum of the containers' memory limits, when all of them have one
+    pub memory_limit_bytes: Option<i64>,
+    /// Bytes received since the previous sample
+    pub network_rx_bytes: i64,
+    /// Bytes sent since the previous sample
+    pub network_tx_bytes: i64,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::environments::Entity",
+        from = "Column::EnvironmentId",
+        to = "super::environments::Column::Id"
+    )]
+    Environment,
+}
+
+impl Related<super::environments::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Environment.def()
+    }
+}
+
+impl ActiveModelBehavior for ActiveModel {}
@@ -4,7 +4,8 @@ use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
 use serde::{Deserialize, Serialize};
 use temps_core::DBDateTime;
 
-use super::deployment_config::{DeploymentConfig, SecurityConfig};
+use super::deployment_config::{BuildPriority, DeploymentConfig, SecurityConfig};
+use super::routing_rules::RoutingRuleList;
 use super::upstream_config::UpstreamList;
 
 #[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
@@ -31,6 +32,9 @@ pub struct Model {
     /// Indicates if this is a preview environment (auto-created per branch)
     /// Use the 'branch' field to track which branch this preview is for
     pub is_preview: bool,
+    /// Header and host rules sending some of this environment's requests to other
+    /// environments of the project
+    pub routing_rules: RoutingRuleList,
 }
 
 impl Model {
@@ -75,6 +79,38 @@ impl Model {
         // Chain: global -> project -> environment
         global_config.merge(&project_security).merge(&env_security)
     }
+
+    /// Whether this environment's variables are protected secrets
+    ///
+    /// Protection is set per environment; when it isn't, the production environment
+    /// is protected and every other environment is not.
+    pub fn has_protected_secrets(&self) -> bool {
+        self.deployment_config
+            .as_ref()
+            .and_then(|config| config.protected_secrets)
+            .unwrap_or_else(|| self.is_production())
+    }
+
+    /// Priority of this environment's deploys in the build queue
+    ///
+    /// Set per environment; when it isn't, production deploys are high priority,
+    /// preview deploys low and the rest normal.
+    pub fn build_priority(&self) -> BuildPriority {
+        self.deployment_config
+            .as_ref()
+            .and_then(|config| config.build_priority)
+            .unwrap_or(if self.is_production() {
+                BuildPriority::High
+            } else if self.is_preview {
+                BuildPriority::Low
+            } else {
+                BuildPriority::Normal
+            })
+    }
+
+    fn is_production(&self) -> bool {
+        self.name.eq_ignore_ascii_case("production") || self.slug.eq_ignore_ascii_case("production")
+    }
 }
 
 #[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
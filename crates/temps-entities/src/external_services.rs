@@ -4,6 +4,8 @@ use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
 use serde::{Deserialize, Serialize};
 use temps_core::DBDateTime;
 
+use super::tags::Tags;
+
 #[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
 #[sea_orm(table_name = "external_services")]
 pub struct Model {
@@ -18,6 +20,16 @@ pub struct Model {
     pub slug: Option<String>,
     /// Encrypted JSON configuration for the service
     pub config: Option<String>,
+    /// Key/value tags for organizing and filtering services
+    #[sea_orm(column_type = "JsonBinary")]
+    pub tags: Tags,
+    /// Whether Temps runs the service; unmanaged services are hosted elsewhere and
+    /// only their connection details are stored
+    pub managed: bool,
+    /// CPU limit of the service's container in millicores; None for no limit
+    pub cpu_limit: Option<i32>,
+    /// Memory limit of the service's container in megabytes; None for no limit
+    pub memory_limit: Option<i32>,
 }
 
 #[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
@@ -5,13 +5,19 @@ pub mod audit_logs;
 pub mod backup_schedules;
 pub mod backups;
 pub mod challenge_sessions;
+pub mod credential_rotation_policies;
+pub mod credential_rotations;
 pub mod cron_executions;
 pub mod crons;
 pub mod custom_routes;
+pub mod database_imports;
+pub mod deploy_idempotency_keys;
+pub mod deployment_artifacts;
 pub mod deployment_config;
 pub mod deployment_containers;
 pub mod deployment_domains;
 pub mod deployment_jobs;
+pub mod deployment_promotions;
 pub mod deployment_tokens;
 pub mod deployments;
 pub mod dns_managed_domains;
@@ -23,6 +29,7 @@ pub mod emails;
 pub mod env_var_environments;
 pub mod env_vars;
 pub mod environment_domains;
+pub mod environment_metrics;
 pub mod environments;
 pub mod external_service_backups;
 pub mod external_services;
@@ -32,9 +39,11 @@ pub mod git_provider_connections;
 pub mod git_providers;
 pub mod ip_access_control;
 pub mod ip_geolocations;
+pub mod log_search_history;
 pub mod notification_preferences;
 pub mod notification_providers;
 pub mod notifications;
+pub mod path_rules;
 pub mod performance_metrics;
 pub mod preset;
 pub mod project_custom_domains;
@@ -42,10 +51,21 @@ pub mod project_services;
 pub mod projects;
 pub mod proxy_logs;
 pub mod repositories;
+pub mod repository_deploy_keys;
+pub mod repository_webhooks;
 pub mod request_sessions;
+pub mod resource_alert_rules;
 pub mod roles;
+pub mod routing_rules;
+pub mod runtime_config_changes;
+pub mod runtime_config_values;
 pub mod s3_sources;
+pub mod saved_log_searches;
+pub mod service_dependencies;
+pub mod service_recovery_policies;
+pub mod service_scalings;
 pub mod sessions;
+pub mod tags;
 pub mod tls_acme_certificates;
 pub mod types;
 pub mod upstream_config;
@@ -81,4 +101,7 @@ pub mod webhooks;
 pub mod vulnerabilities;
 pub mod vulnerability_scans;
 
+// WireGuard VPN entities
+pub mod wireguard_peers;
+
 pub mod prelude;
@@ -0,0 +1,41 @@
+//! Log Search History Entity
+//!
+//! The proxy log searches a user ran on a project, most recent first, so they can run
+//! them again. Only the latest few per user and project are kept.
+
+use sea_orm::entity::prelude::*;
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "log_search_history")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub project_id: i32,
+    pub user_id: i32,
+    /// Filters in the `/proxy-logs` query format, without project, dates or paging
+    pub filters: Json,
+    pub time_range: String,
+    /// Saved search that was run, if any
+    pub saved_search_id: Option<i32>,
+    pub searched_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::projects::Entity",
+        from = "Column::ProjectId",
+        to = "super::projects::Column::Id"
+    )]
+    Project,
+}
+
+impl Related<super::projects::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Project.def()
+    }
+}
+
+impl ActiveModelBehavior for ActiveModel {}
@@ -0,0 +1,274 @@
+//! Path-based routing rules for custom domains
+//!
+//! A custom domain can restrict which paths it serves and map them onto a different
+//! path on the environment it routes to, so several domains can front one service:
+//! `docs.example.com/*` → `/docs/*` and `example.com/*` → `/*` on the same upstream.
+//! Rules can also send a prefix to another environment, so one domain (and its one
+//! certificate) can front several services: `example.com/api` → the API service,
+//! `example.com/` → the web app.
+
+use sea_orm::FromJsonQueryResult;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Maximum number of rules on a single domain
+pub const MAX_PATH_RULES: usize = 50;
+
+/// A single path rule
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct PathRule {
+    /// Request path prefix this rule matches, on segment boundaries
+    #[schema(example = "/")]
+    pub path_prefix: String,
+    /// Prefix that replaces `path_prefix` before the request is proxied; the path is
+    /// passed through unchanged when unset
+    #[schema(example = "/docs")]
+    #[serde(default)]
+    pub rewrite_to: Option<String>,
+    /// Remove `path_prefix` before the request is proxied (`/api/users` → `/users`)
+    #[serde(default)]
+    pub strip_prefix: bool,
+    /// Environment to send matching requests to; the domain's own environment when unset
+    #[serde(default)]
+    pub environment_id: Option<i32>,
+}
+
+impl PathRule {
+    fn matches(&self, path: &str) -> bool {
+        let prefix = self.path_prefix.trim_end_matches('/');
+        if prefix.is_empty() {
+            return true;
+        }
+        match path.strip_prefix(prefix) {
+            Some(rest) => rest.is_empty() || rest.starts_with('/'),
+            None => false,
+        }
+    }
+
+    /// Path to send upstream for a request path this rule matches
+    pub fn apply(&self, path: &str) -> String {
+        let rewrite_to = match (&self.rewrite_to, self.strip_prefix) {
+            (Some(rewrite_to), _) => rewrite_to.as_str(),
+            (None, true) => "/",
+            (None, false) => return path.to_string(),
+        };
+        let rest = path
+            .strip_prefix(self.path_prefix.trim_end_matches('/'))
+            .unwrap_or(path);
+        let rewrite_to = rewrite_to.trim_end_matches('/');
+        match (rewrite_to.is_empty(), rest.is_empty()) {
+            (true, true) => "/".to_string(),
+            (true, false) => rest.to_string(),
+            (false, _) => format!("{}{}", rewrite_to, rest),
+        }
+    }
+}
+
+/// The path rules of a custom domain
+///
+/// Stored in the project_custom_domains.path_rules JSONB column. An empty list serves
+/// every path unchanged.
+#[derive(
+    Debug, Clone, PartialEq, Eq, Default, Serialize, Deserialize, ToSchema, FromJsonQueryResult,
+)]
+#[serde(transparent)]
+pub struct PathRuleList {
+    pub rules: Vec<PathRule>,
+}
+
+impl PathRuleList {
+    pub fn is_empty(&self) -> bool {
+        self.rules.is_empty()
+    }
+
+    /// The rule a request path falls under: the one with the longest matching prefix
+    pub fn match_rule(&self, path: &str) -> Option<&PathRule> {
+        self.rules
+            .iter()
+            .filter(|rule| rule.matches(path))
+            .max_by_key(|rule| rule.path_prefix.trim_end_matches('/').len())
+    }
+
+    /// Path to proxy a request to, or None when the domain doesn't serve this path
+    pub fn resolve(&self, path: &str) -> Option<String> {
+        if self.rules.is_empty() {
+            return Some(path.to_string());
+        }
+        self.match_rule(path).map(|rule| rule.apply(path))
+    }
+
+    /// Environments the rules send requests to, other than the domain's own
+    pub fn target_environments(&self) -> Vec<i32> {
+        let mut ids: Vec<i32> = self
+            .rules
+            .iter()
+            .filter_map(|rule| rule.environment_id)
+            .collect();
+        ids.sort_unstable();
+        ids.dedup();
+        ids
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.rules.len() > MAX_PATH_RULES {
+            return Err(format!(
+                "A domain can have at most {} path rules",
+                MAX_PATH_RULES
+            ));
+        }
+        let mut prefixes = std::collections::HashSet::new();
+        for rule in &self.rules {
+            if !rule.path_prefix.starts_with('/') {
+                return Err(format!(
+                    "Path prefix '{}' must start with '/'",
+                    rule.path_prefix
+                ));
+            }
+            if rule.strip_prefix && rule.rewrite_to.is_some() {
+                return Err(format!(
+                    "Path prefix '{}' can either strip its prefix or rewrite it, not both",
+                    rule.path_prefix
+                ));
+            }
+            if let Some(rewrite_to) = &rule.rewrite_to {
+                if !rewrite_to.starts_with('/') {
+                    return Err(format!(
+                        "Rewrite target '{}' must start with '/'",
+                        rewrite_to
+                    ));
+                }
+            }
+            if rule.path_prefix.contains(['?', '#']) {
+                return Err(format!(
+                    "Path prefix '{}' must not contain a query or fragment",
+                    rule.path_prefix
+                ));
+            }
+            // `/api` and `/api/` match the same requests, so only one of them may exist
+            if !prefixes.insert(rule.path_prefix.trim_end_matches('/')) {
+                return Err(format!(
+                    "Path prefix '{}' overlaps with another rule for the same path",
+                    rule.path_prefix
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rule(path_prefix: &str, rewrite_to: Option<&str>) -> PathRule {
+        PathRule {
+            path_prefix: path_prefix.to_string(),
+            rewrite_to: rewrite_to.map(|r| r.to_string()),
+            strip_prefix: false,
+            environment_id: None,
+        }
+    }
+
+    #[test]
+    fn test_prefixes_route_to_different_environments() {
+        let rules = PathRuleList {
+            rules: vec![
+                PathRule {
+                    environment_id: Some(7),
+                    strip_prefix: true,
+                    ..rule("/api", None)
+                },
+                rule("/", None),
+            ],
+        };
+
+        let api = rules.match_rule("/api/users").unwrap();
+        assert_eq!(api.environment_id, Some(7));
+        assert_eq!(api.apply("/api/users"), "/users");
+        assert_eq!(api.apply("/api"), "/");
+
+        let web = rules.match_rule("/about").unwrap();
+        assert_eq!(web.environment_id, None);
+        assert_eq!(web.apply("/about"), "/about");
+        assert_eq!(rules.target_environments(), vec![7]);
+    }
+
+    #[test]
+    fn test_rules_deserialize_with_defaults() {
+        let rules: PathRuleList =
+            serde_json::from_str(r#"[{"path_prefix": "/docs", "rewrite_to": null}]"#).unwrap();
+        assert!(!rules.rules[0].strip_prefix);
+        assert_eq!(rules.rules[0].environment_id, None);
+    }
+
+    #[test]
+    fn test_no_rules_serve_everything() {
+        let rules = PathRuleList::default();
+        assert_eq!(rules.resolve("/anything"), Some("/anything".to_string()));
+    }
+
+    #[test]
+    fn test_root_rewrite_maps_onto_subpath() {
+        let rules = PathRuleList {
+            rules: vec![rule("/", Some("/docs"))],
+        };
+        assert_eq!(rules.resolve("/"), Some("/docs/".to_string()));
+        assert_eq!(rules.resolve("/intro"), Some("/docs/intro".to_string()));
+    }
+
+    #[test]
+    fn test_longest_prefix_wins_and_matches_segments() {
+        let rules = PathRuleList {
+            rules: vec![rule("/api", None), rule("/api/v2", Some("/v2"))],
+        };
+        assert_eq!(rules.resolve("/api/users"), Some("/api/users".to_string()));
+        assert_eq!(
+            rules.resolve("/api/v2/users"),
+            Some("/v2/users".to_string())
+        );
+        assert_eq!(rules.resolve("/api/v2"), Some("/v2".to_string()));
+        assert_eq!(rules.resolve("/apix"), None);
+        assert_eq!(rules.resolve("/other"), None);
+    }
+
+    #[test]
+    fn test_strip_prefix_to_root() {
+        let rules = PathRuleList {
+            rules: vec![rule("/blog", Some("/"))],
+        };
+        assert_eq!(rules.resolve("/blog"), Some("/".to_string()));
+        assert_eq!(rules.resolve("/blog/post"), Some("/post".to_string()));
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(PathRuleList {
+            rules: vec![rule("/", None), rule("/docs", Some("/d"))]
+        }
+        .validate()
+        .is_ok());
+        assert!(PathRuleList {
+            rules: vec![rule("docs", None)]
+        }
+        .validate()
+        .is_err());
+        assert!(PathRuleList {
+            rules: vec![rule("/docs", None), rule("/docs/", None)]
+        }
+        .validate()
+        .is_err());
+        assert!(PathRuleList {
+            rules: vec![rule("/docs", Some("docs"))]
+        }
+        .validate()
+        .is_err());
+        assert!(PathRuleList {
+            rules: vec![PathRule {
+                strip_prefix: true,
+                ..rule("/docs", Some("/d"))
+            }]
+        }
+        .validate()
+        .is_err());
+    }
+}
@@ -5,6 +5,8 @@ pub use super::api_keys::Entity as ApiKeys;
 pub use super::audit_logs::Entity as AuditLogs;
 pub use super::backup_schedules::Entity as BackupSchedules;
 pub use super::backups::Entity as Backups;
+pub use super::credential_rotation_policies::Entity as CredentialRotationPolicies;
+pub use super::credential_rotations::Entity as CredentialRotations;
 pub use super::cron_executions::Entity as CronExecutions;
 pub use super::crons::Entity as Crons;
 pub use super::custom_routes::Entity as CustomRoutes;
@@ -12,11 +14,15 @@ pub use super::deployment_config::{DeploymentConfig, DeploymentConfigSnapshot};
 pub use super::deployment_containers::Entity as DeploymentContainers;
 pub use super::deployment_domains::Entity as DeploymentDomains;
 pub use super::deployment_jobs::Entity as DeploymentJobs;
-pub use super::deployments::{DeploymentMetadata, Entity as Deployments, GitPushEvent};
+pub use super::deployments::{
+    BaseImageDigest, BaseImageUpdate, DeploymentMetadata, Entity as Deployments, GitPushEvent,
+    SmokeTestReport, SmokeTestResult,
+};
 pub use super::domains::Entity as Domains;
 pub use super::env_var_environments::Entity as EnvVarEnvironments;
 pub use super::env_vars::Entity as EnvVars;
 pub use super::environment_domains::Entity as EnvironmentDomains;
+pub use super::environment_metrics::Entity as EnvironmentMetrics;
 pub use super::environments::Entity as Environments;
 pub use super::error_events::Entity as ErrorEvents;
 pub use super::error_groups::Entity as ErrorGroups;
@@ -41,6 +47,7 @@ pub use super::repositories::{
 pub use super::request_sessions::Entity as RequestSessions;
 pub use super::roles::Entity as Roles;
 pub use super::s3_sources::Entity as S3Sources;
+pub use super::service_dependencies::Entity as ServiceDependencies;
 pub use super::session_replay_events::Entity as SessionReplayEvents;
 pub use super::session_replay_sessions::Entity as SessionReplaySessions;
 pub use super::sessions::Entity as Sessions;
@@ -51,3 +58,4 @@ pub use super::users::Entity as Users;
 pub use super::visitor::Entity as Visitor;
 pub use super::webhook_deliveries::Entity as WebhookDeliveries;
 pub use super::webhooks::Entity as Webhooks;
+pub use super::wireguard_peers::Entity as WireguardPeers;
@@ -4,6 +4,8 @@ use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
 use serde::{Deserialize, Serialize};
 use temps_core::DBDateTime;
 
+use crate::path_rules::PathRuleList;
+
 #[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
 #[sea_orm(table_name = "project_custom_domains")]
 pub struct Model {
@@ -20,6 +22,49 @@ pub struct Model {
     pub created_at: DBDateTime,
     pub updated_at: DBDateTime,
     pub certificate_id: Option<i32>,
+    /// On an apex domain, redirect between it and its www subdomain
+    /// (`www_to_apex` or `apex_to_www`)
+    pub canonical_redirect: Option<String>,
+    /// Redirect plain HTTP requests for this domain to HTTPS
+    pub force_https: bool,
+    /// When DNS was last confirmed to point at this server
+    pub dns_verified_at: Option<DBDateTime>,
+    /// Which paths this domain serves and where they map on the environment
+    pub path_rules: PathRuleList,
+}
+
+/// Which of an apex domain and its www subdomain is the canonical host
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum CanonicalRedirect {
+    /// `www.example.com` redirects to `example.com`
+    WwwToApex,
+    /// `example.com` redirects to `www.example.com`
+    ApexToWww,
+}
+
+impl CanonicalRedirect {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            CanonicalRedirect::WwwToApex => "www_to_apex",
+            CanonicalRedirect::ApexToWww => "apex_to_www",
+        }
+    }
+
+    pub fn parse(value: &str) -> Option<Self> {
+        match value {
+            "www_to_apex" => Some(CanonicalRedirect::WwwToApex),
+            "apex_to_www" => Some(CanonicalRedirect::ApexToWww),
+            _ => None,
+        }
+    }
+}
+
+impl Model {
+    pub fn canonical_redirect(&self) -> Option<CanonicalRedirect> {
+        self.canonical_redirect
+            .as_deref()
+            .and_then(CanonicalRedirect::parse)
+    }
 }
 
 #[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
@@ -11,6 +11,12 @@ pub struct Model {
     pub id: i32,
     pub project_id: i32,
     pub service_id: i32,
+    /// User of its own the project connects to the service with
+    pub app_username: Option<String>,
+    /// Encrypted password of `app_username`
+    pub app_password: Option<String>,
+    /// Most connections the project's user can open at once
+    pub connection_limit: Option<i32>,
     pub created_at: DBDateTime,
     pub updated_at: DBDateTime,
 }
@@ -6,6 +6,7 @@ use temps_core::DBDateTime;
 
 use super::deployment_config::DeploymentConfig;
 use super::preset::{Preset, PresetConfig};
+use super::tags::Tags;
 
 #[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
 #[sea_orm(table_name = "projects")]
@@ -41,6 +42,12 @@ pub struct Model {
     pub attack_mode: bool,
     /// Enable automatic preview environment creation for each branch
     pub enable_preview_environments: bool,
+    /// Maximum environments built and deployed at once during a project-wide deploy
+    /// (None = server default)
+    pub deploy_concurrency: Option<i32>,
+    /// Key/value tags for organizing and filtering projects
+    #[sea_orm(column_type = "JsonBinary")]
+    pub tags: Tags,
 }
 
 #[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
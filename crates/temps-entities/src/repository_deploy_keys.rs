@@ -0,0 +1,70 @@
+//! Repository Deploy Keys Entity
+//!
+//! An SSH keypair of a repository, for fetching it over SSH rather than with the git
+//! provider connection's token. The public key is added to the repository at the git
+//! host as a read-only deploy key; builds use the private key once `use_for_builds`
+//! is turned on, and only trust the host keys in `known_hosts`.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "repository_deploy_keys")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    #[sea_orm(unique)]
+    pub repository_id: i32,
+    /// Public key in OpenSSH format, to add to the git host
+    pub public_key: String,
+    /// Private key, encrypted with the server's encryption key
+    #[serde(skip_serializing)]
+    pub private_key_encrypted: String,
+    /// SHA256 fingerprint of the key, as shown by git hosts
+    pub fingerprint: String,
+    /// known_hosts lines of the git host's SSH server; other host keys are refused
+    pub known_hosts: String,
+    /// Whether builds fetch the repository over SSH with this key
+    pub use_for_builds: bool,
+    /// User who generated the key
+    pub created_by: i32,
+    /// Last build that fetched the repository with the key
+    pub last_used_at: Option<DBDateTime>,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::repositories::Entity",
+        from = "Column::RepositoryId",
+        to = "super::repositories::Column::Id"
+    )]
+    Repository,
+}
+
+impl Related<super::repositories::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Repository.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(now);
+        }
+        self.updated_at = Set(now);
+
+        Ok(self)
+    }
+}
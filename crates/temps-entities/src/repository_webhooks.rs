@@ -0,0 +1,65 @@
+//! Repository Webhooks Entity
+//!
+//! The secret push webhooks of a repository are verified with, when the git provider
+//! sends them straight to Temps rather than through a GitHub App. The provider signs
+//! each delivery with the secret (GitHub, Gitea and Bitbucket) or sends it as a token
+//! (GitLab); deliveries that don't match are rejected and counted.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "repository_webhooks")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    #[sea_orm(unique)]
+    pub repository_id: i32,
+    /// Webhook secret, encrypted with the server's encryption key
+    #[serde(skip_serializing)]
+    pub secret_encrypted: String,
+    /// User who set up the webhook; rejected deliveries are audit-logged under them
+    pub created_by: i32,
+    /// Last delivery that passed verification
+    pub last_delivery_at: Option<DBDateTime>,
+    /// Last delivery rejected for a missing or wrong signature
+    pub last_rejected_at: Option<DBDateTime>,
+    pub rejected_count: i64,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::repositories::Entity",
+        from = "Column::RepositoryId",
+        to = "super::repositories::Column::Id"
+    )]
+    Repository,
+}
+
+impl Related<super::repositories::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Repository.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(now);
+        }
+        self.updated_at = Set(now);
+
+        Ok(self)
+    }
+}
@@ -0,0 +1,93 @@
+//! Resource Alert Rules Entity
+//!
+//! Threshold on an environment's CPU or memory usage, e.g. memory above 90% of its
+//! limit for 5 minutes. The rule fires once the threshold has been exceeded for
+//! `duration_seconds`, and only resolves once usage has stayed below the lower
+//! `resolve_threshold` for as long, so usage hovering around the threshold doesn't
+//! flap. A firing rule opens an incident, resolved along with it.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+/// CPU usage summed over the environment's containers, in percent of one host
+pub const ALERT_METRIC_CPU_PERCENT: &str = "cpu_percent";
+/// Memory usage in percent of the containers' memory limits
+pub const ALERT_METRIC_MEMORY_PERCENT: &str = "memory_percent";
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Serialize, Deserialize)]
+#[sea_orm(table_name = "resource_alert_rules")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub project_id: i32,
+    pub environment_id: i32,
+    pub name: String,
+    /// One of the ALERT_METRIC_* values
+    pub metric: String,
+    pub threshold: f64,
+    /// Usage must fall below this to resolve; at most `threshold`
+    pub resolve_threshold: f64,
+    /// How long usage must stay past a threshold to fire or resolve
+    pub duration_seconds: i32,
+    /// Severity of the incident: minor, major or critical
+    pub severity: String,
+    pub enabled: bool,
+    pub firing: bool,
+    /// Since when usage has exceeded the threshold, while not firing
+    pub breaching_since: Option<DBDateTime>,
+    /// Since when usage has been below the resolve threshold, while firing
+    pub recovering_since: Option<DBDateTime>,
+    pub last_fired_at: Option<DBDateTime>,
+    /// Incident opened when the rule last fired
+    pub incident_id: Option<i32>,
+    pub created_by: i32,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::environments::Entity",
+        from = "Column::EnvironmentId",
+        to = "super::environments::Column::Id"
+    )]
+    Environment,
+    #[sea_orm(
+        belongs_to = "super::status_incidents::Entity",
+        from = "Column::IncidentId",
+        to = "super::status_incidents::Column::Id"
+    )]
+    Incident,
+}
+
+impl Related<super::environments::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Environment.def()
+    }
+}
+
+impl Related<super::status_incidents::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Incident.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(now);
+        }
+        self.updated_at = Set(now);
+
+        Ok(self)
+    }
+}
@@ -0,0 +1,266 @@
+//! Header and host routing rules for environments
+//!
+//! An environment can send some of its requests to another environment of the same
+//! project, e.g. everything with `X-Temps-Target: qa` to the QA service or every
+//! request for `beta.example.com` to the next version. Rules are checked in priority
+//! order, lowest first; a request no rule matches is served by the environment itself.
+
+use sea_orm::FromJsonQueryResult;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Maximum number of rules on a single environment
+pub const MAX_ROUTING_RULES: usize = 50;
+
+/// A single routing rule; every condition it sets has to match
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct RoutingRule {
+    /// Order the rule is checked in, lowest first; rules with the same priority are
+    /// checked in list order
+    #[serde(default)]
+    pub priority: i32,
+    /// Request header to match, case-insensitive
+    #[schema(example = "X-Temps-Target")]
+    #[serde(default)]
+    pub header: Option<String>,
+    /// Value `header` must have; any value matches when unset
+    #[schema(example = "qa")]
+    #[serde(default)]
+    pub header_value: Option<String>,
+    /// Request host to match, exactly or as a `*.example.com` wildcard for any subdomain
+    #[schema(example = "beta.example.com")]
+    #[serde(default)]
+    pub host: Option<String>,
+    /// Environment to send matching requests to
+    pub environment_id: i32,
+}
+
+impl RoutingRule {
+    fn matches<'h>(&self, host: &str, header: &impl Fn(&str) -> Option<&'h str>) -> bool {
+        if let Some(pattern) = &self.host {
+            if !host_matches(pattern, host) {
+                return false;
+            }
+        }
+        if let Some(name) = &self.header {
+            match (header(name), &self.header_value) {
+                (None, _) => return false,
+                (Some(value), Some(expected)) if value != expected => return false,
+                _ => {}
+            }
+        }
+        true
+    }
+}
+
+fn host_matches(pattern: &str, host: &str) -> bool {
+    let pattern = pattern.to_lowercase();
+    let host = host.to_lowercase();
+    match pattern.strip_prefix("*.") {
+        Some(suffix) => host
+            .strip_suffix(suffix)
+            .is_some_and(|label| label.len() > 1 && label.ends_with('.')),
+        None => pattern == host,
+    }
+}
+
+/// The routing rules of an environment
+///
+/// Stored in the environments.routing_rules JSONB column. An empty list serves every
+/// request from the environment itself.
+#[derive(
+    Debug, Clone, PartialEq, Eq, Default, Serialize, Deserialize, ToSchema, FromJsonQueryResult,
+)]
+#[serde(transparent)]
+pub struct RoutingRuleList {
+    pub rules: Vec<RoutingRule>,
+}
+
+impl RoutingRuleList {
+    pub fn is_empty(&self) -> bool {
+        self.rules.is_empty()
+    }
+
+    /// The first rule by priority matching a request, given its host and a lookup of
+    /// its headers
+    pub fn match_rule<'h>(
+        &self,
+        host: &str,
+        header: impl Fn(&str) -> Option<&'h str>,
+    ) -> Option<&RoutingRule> {
+        self.rules
+            .iter()
+            .filter(|rule| rule.matches(host, &header))
+            .min_by_key(|rule| rule.priority)
+    }
+
+    /// Environments the rules send requests to
+    pub fn target_environments(&self) -> Vec<i32> {
+        let mut ids: Vec<i32> = self.rules.iter().map(|rule| rule.environment_id).collect();
+        ids.sort_unstable();
+        ids.dedup();
+        ids
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.rules.len() > MAX_ROUTING_RULES {
+            return Err(format!(
+                "An environment can have at most {} routing rules",
+                MAX_ROUTING_RULES
+            ));
+        }
+        for rule in &self.rules {
+            if rule.header.is_none() && rule.host.is_none() {
+                return Err(
+                    "A routing rule must match a header, a host or both; use the environment \
+                     itself for every request"
+                        .to_string(),
+                );
+            }
+            if let Some(header) = &rule.header {
+                let valid = !header.is_empty()
+                    && header
+                        .bytes()
+                        .all(|b| b.is_ascii_alphanumeric() || b"!#$%&'*+-.^_`|~".contains(&b));
+                if !valid {
+                    return Err(format!("'{}' is not a valid header name", header));
+                }
+            }
+            if rule.header_value.is_some() && rule.header.is_none() {
+                return Err("A routing rule with a header value must name the header".to_string());
+            }
+            if let Some(host) = &rule.host {
+                let name = host.strip_prefix("*.").unwrap_or(host);
+                if name.is_empty() || name.contains(['*', '/', ':', ' ']) {
+                    return Err(format!("'{}' is not a valid host", host));
+                }
+            }
+            if rule.environment_id <= 0 {
+                return Err(format!(
+                    "Routing rule targets invalid environment {}",
+                    rule.environment_id
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    fn header_rule(priority: i32, header: &str, value: Option<&str>, env: i32) -> RoutingRule {
+        RoutingRule {
+            priority,
+            header: Some(header.to_string()),
+            header_value: value.map(|v| v.to_string()),
+            host: None,
+            environment_id: env,
+        }
+    }
+
+    fn host_rule(priority: i32, host: &str, env: i32) -> RoutingRule {
+        RoutingRule {
+            priority,
+            header: None,
+            header_value: None,
+            host: Some(host.to_string()),
+            environment_id: env,
+        }
+    }
+
+    type Headers = HashMap<&'static str, &'static str>;
+
+    fn lookup<'m>(headers: &'m Headers) -> impl Fn(&str) -> Option<&'m str> + 'm {
+        move |name| headers.get(name.to_lowercase().as_str()).copied()
+    }
+
+    #[test]
+    fn test_priority_order_and_fall_through() {
+        let rules = RoutingRuleList {
+            rules: vec![
+                header_rule(10, "X-Temps-Target", None, 3),
+                header_rule(1, "X-Temps-Target", Some("qa"), 2),
+                host_rule(5, "beta.example.com", 4),
+            ],
+        };
+
+        let qa = HashMap::from([("x-temps-target", "qa")]);
+        let other = HashMap::from([("x-temps-target", "next")]);
+        let none = HashMap::new();
+
+        let matched = |host: &str, headers: &Headers| {
+            rules
+                .match_rule(host, lookup(headers))
+                .map(|r| r.environment_id)
+        };
+        assert_eq!(matched("beta.example.com", &qa), Some(2));
+        assert_eq!(matched("beta.example.com", &other), Some(4));
+        assert_eq!(matched("app.example.com", &other), Some(3));
+        assert_eq!(matched("app.example.com", &none), None);
+        assert_eq!(rules.target_environments(), vec![2, 3, 4]);
+    }
+
+    #[test]
+    fn test_equal_priorities_keep_list_order() {
+        let rules = RoutingRuleList {
+            rules: vec![
+                host_rule(0, "*.example.com", 2),
+                host_rule(0, "app.example.com", 3),
+            ],
+        };
+        let none = HashMap::new();
+        assert_eq!(
+            rules
+                .match_rule("App.Example.com", lookup(&none))
+                .map(|r| r.environment_id),
+            Some(2)
+        );
+        assert!(rules.match_rule("example.com", lookup(&none)).is_none());
+    }
+
+    #[test]
+    fn test_rules_deserialize_with_defaults() {
+        let rules: RoutingRuleList =
+            serde_json::from_str(r#"[{"header": "X-Canary", "environment_id": 7}]"#).unwrap();
+        assert_eq!(rules.rules[0].priority, 0);
+        assert_eq!(rules.rules[0].host, None);
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(RoutingRuleList {
+            rules: vec![
+                header_rule(0, "X-Canary", Some("1"), 2),
+                host_rule(1, "*.example.com", 3)
+            ]
+        }
+        .validate()
+        .is_ok());
+        assert!(RoutingRuleList {
+            rules: vec![RoutingRule {
+                host: None,
+                ..host_rule(0, "", 2)
+            }]
+        }
+        .validate()
+        .is_err());
+        assert!(RoutingRuleList {
+            rules: vec![header_rule(0, "X Canary", None, 2)]
+        }
+        .validate()
+        .is_err());
+        assert!(RoutingRuleList {
+            rules: vec![host_rule(0, "*.*.example.com", 2)]
+        }
+        .validate()
+        .is_err());
+        assert!(RoutingRuleList {
+            rules: vec![header_rule(0, "X-Canary", None, 0)]
+        }
+        .validate()
+        .is_err());
+    }
+}
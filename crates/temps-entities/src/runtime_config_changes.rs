@@ -0,0 +1,72 @@
+//! Runtime Config Changes Entity
+//!
+//! One change to a runtime config value of an environment, with how the running
+//! containers picked it up.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+/// The running containers have the change
+pub const CONFIG_CHANGE_STATUS_APPLIED: &str = "applied";
+/// The value was saved, but the running containers couldn't be reloaded or restarted
+pub const CONFIG_CHANGE_STATUS_FAILED: &str = "failed";
+
+/// The app reloaded its config, by signal or by watching the file
+pub const CONFIG_CHANGE_METHOD_RELOAD: &str = "reload";
+/// The containers were restarted in place
+pub const CONFIG_CHANGE_METHOD_RESTART: &str = "restart";
+/// No running container has the config file mounted; the change applies when the
+/// containers next start, or with the next deploy for containers deployed before
+/// runtime config
+pub const CONFIG_CHANGE_METHOD_DEFERRED: &str = "deferred";
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "runtime_config_changes")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub environment_id: i32,
+    pub key: String,
+    /// New value; None when the value was removed
+    pub value: Option<String>,
+    /// One of the CONFIG_CHANGE_STATUS_* values
+    pub status: String,
+    /// One of the CONFIG_CHANGE_METHOD_* values
+    pub method: String,
+    pub error: Option<String>,
+    pub changed_by: i32,
+    pub created_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::environments::Entity",
+        from = "Column::EnvironmentId",
+        to = "super::environments::Column::Id"
+    )]
+    Environment,
+}
+
+impl Related<super::environments::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Environment.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(chrono::Utc::now());
+        }
+
+        Ok(self)
+    }
+}
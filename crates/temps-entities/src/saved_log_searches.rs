@@ -0,0 +1,70 @@
+//! Saved Log Searches Entity
+//!
+//! A named proxy log search shared by everyone on a project: the filters of the
+//! `/proxy-logs` endpoint and a time range relative to when it runs, e.g. 5xx
+//! responses on `/api` in the last hour. A search can be promoted to an alert, which
+//! fires once it matches at least `alert_threshold` requests in its time range and
+//! resolves once it matches fewer again.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "saved_log_searches")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub project_id: i32,
+    pub name: String,
+    pub description: Option<String>,
+    /// Filters in the `/proxy-logs` query format, without project, dates or paging
+    pub filters: Json,
+    /// Time range searched, back from when the search runs: `15m`, `1h`, `7d`, ...
+    pub time_range: String,
+    /// Fire an alert when the search matches at least this many requests; not an
+    /// alert when unset
+    pub alert_threshold: Option<i64>,
+    /// Severity of the alert: minor, major or critical
+    pub alert_severity: Option<String>,
+    pub alert_enabled: bool,
+    pub alert_firing: bool,
+    pub last_alerted_at: Option<DBDateTime>,
+    pub created_by: i32,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::projects::Entity",
+        from = "Column::ProjectId",
+        to = "super::projects::Column::Id"
+    )]
+    Project,
+}
+
+impl Related<super::projects::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Project.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(now);
+        }
+        self.updated_at = Set(now);
+
+        Ok(self)
+    }
+}
@@ -0,0 +1,60 @@
+//! Service Dependencies Entity
+//!
+//! Declares that a managed service depends on another one, e.g. an API gateway on
+//! its Postgres database. Dependencies decide start order (dependencies first, once
+//! healthy) and stop order (dependents first).
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "service_dependencies")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    /// The dependent service
+    pub service_id: i32,
+    /// The service that must be running before `service_id` starts
+    pub depends_on_service_id: i32,
+    pub created_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::external_services::Entity",
+        from = "Column::ServiceId",
+        to = "super::external_services::Column::Id"
+    )]
+    Service,
+    #[sea_orm(
+        belongs_to = "super::external_services::Entity",
+        from = "Column::DependsOnServiceId",
+        to = "super::external_services::Column::Id"
+    )]
+    DependsOnService,
+}
+
+/// Related services resolve to the service depended on
+impl Related<super::external_services::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::DependsOnService.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(chrono::Utc::now());
+        }
+
+        Ok(self)
+    }
+}
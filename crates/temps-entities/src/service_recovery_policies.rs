@@ -0,0 +1,68 @@
+//! Service Recovery Policies Entity
+//!
+//! Opt-in policy to restart or redeploy the apps linked to a managed service when the
+//! service recovers from an outage, for apps that don't reconnect on their own. At
+//! most one automatic action runs per `cooldown_seconds`, so a flapping service
+//! doesn't cause a restart storm.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+/// Restart the containers of the linked environments
+pub const RECOVERY_ACTION_RESTART: &str = "restart";
+/// Rebuild and redeploy the linked environments
+pub const RECOVERY_ACTION_REDEPLOY: &str = "redeploy";
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "service_recovery_policies")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    #[sea_orm(unique)]
+    pub service_id: i32,
+    /// `restart` or `redeploy`
+    pub action: String,
+    /// Least time between two automatic actions
+    pub cooldown_seconds: i32,
+    /// When linked apps were last restarted or redeployed after a recovery
+    pub last_triggered_at: Option<DBDateTime>,
+    /// User who last configured the policy
+    pub updated_by: i32,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::external_services::Entity",
+        from = "Column::ServiceId",
+        to = "super::external_services::Column::Id"
+    )]
+    Service,
+}
+
+impl Related<super::external_services::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Service.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(now);
+        }
+        self.updated_at = Set(now);
+
+        Ok(self)
+    }
+}
@@ -0,0 +1,81 @@
+//! Service Scalings Entity
+//!
+//! One change to the CPU and memory limits of a managed service's container, with how
+//! it was applied: online, by restarting the container, or on the service's next start
+//! when it was stopped.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+/// Scaling in progress
+pub const SCALING_STATUS_RUNNING: &str = "running";
+/// The service runs with the new limits
+pub const SCALING_STATUS_COMPLETED: &str = "completed";
+/// The limits couldn't be applied; the service keeps its old ones
+pub const SCALING_STATUS_FAILED: &str = "failed";
+
+/// Applied to the running container and service, without a restart
+pub const SCALING_METHOD_ONLINE: &str = "online";
+/// Applied by restarting the container
+pub const SCALING_METHOD_RESTART: &str = "restart";
+/// The service was stopped; the limits apply when it starts
+pub const SCALING_METHOD_DEFERRED: &str = "deferred";
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "service_scalings")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub service_id: i32,
+    /// One of the SCALING_STATUS_* values
+    pub status: String,
+    /// One of the SCALING_METHOD_* values; None until the scaling finished
+    pub method: Option<String>,
+    /// Limits before the change, in millicores and megabytes
+    pub old_cpu_limit: Option<i32>,
+    pub old_memory_limit: Option<i32>,
+    /// Limits requested, in millicores and megabytes
+    pub cpu_limit: Option<i32>,
+    pub memory_limit: Option<i32>,
+    pub error: Option<String>,
+    pub requested_by: i32,
+    pub started_at: DBDateTime,
+    pub finished_at: Option<DBDateTime>,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "super::external_services::Entity",
+        from = "Column::ServiceId",
+        to = "super::external_services::Column::Id"
+    )]
+    Service,
+}
+
+impl Related<super::external_services::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Service.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+        if insert && self.created_at.is_not_set() {
+            self.created_at = Set(now);
+        }
+        self.updated_at = Set(now);
+
+        Ok(self)
+    }
+}
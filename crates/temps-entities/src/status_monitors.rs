@@ -15,6 +15,13 @@ pub struct Model {
     pub monitor_type: String, // web, api, desktop
     pub check_interval_seconds: i32,
     pub is_active: bool,
+    /// Checks failed in a row, reset by a passing one
+    pub consecutive_failures: i32,
+    /// healthy, failing (failed fewer checks in a row than make it unhealthy) or unhealthy
+    pub health_state: String,
+    /// Restarts made automatically since the checks started failing
+    pub auto_restarts: i32,
+    pub last_auto_restart_at: Option<DBDateTime>,
     pub created_at: DBDateTime,
     pub updated_at: DBDateTime,
 }
@@ -0,0 +1,180 @@
+//! Tags
+//!
+//! Key/value metadata users put on projects and managed services to organize and find
+//! them, e.g. `team=payments` or `tier=critical`. Tags are distinct from the
+//! `sh.temps.*` labels the platform puts on containers: they are only read by Temps
+//! itself, never by Docker.
+//!
+//! Tags are stored as a JSONB object with a GIN index, so filters such as "has all of
+//! these tags" are answered from the index.
+
+use sea_orm::sea_query::{Alias, Expr, PgBinOper, SimpleExpr};
+use sea_orm::{ColumnTrait, FromJsonQueryResult};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use utoipa::ToSchema;
+
+/// Tags a project or service can have
+pub const MAX_TAGS: usize = 50;
+/// Longest tag key
+pub const MAX_TAG_KEY_LENGTH: usize = 63;
+/// Longest tag value
+pub const MAX_TAG_VALUE_LENGTH: usize = 255;
+
+/// Tags of a project or service, keyed by name
+#[derive(
+    Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema, FromJsonQueryResult,
+)]
+#[serde(transparent)]
+#[schema(example = json!({"team": "payments", "tier": "critical"}))]
+pub struct Tags(pub BTreeMap<String, String>);
+
+impl Tags {
+    pub fn is_empty(&self) -> bool {
+        self.0.is_empty()
+    }
+
+    pub fn get(&self, key: &str) -> Option<&str> {
+        self.0.get(key).map(String::as_str)
+    }
+
+    /// Whether these tags include every tag of the selector
+    pub fn matches(&self, selector: &Tags) -> bool {
+        selector
+            .0
+            .iter()
+            .all(|(key, value)| self.0.get(key) == Some(value))
+    }
+
+    /// Parse a tag filter of the form `team=payments,tier=critical`
+    pub fn parse_selector(selector: &str) -> Result<Self, String> {
+        let mut tags = BTreeMap::new();
+        for pair in selector.split(',').filter(|pair| !pair.trim().is_empty()) {
+            let (key, value) = pair
+                .split_once('=')
+                .ok_or_else(|| format!("Tag filter '{}' is not of the form key=value", pair))?;
+            tags.insert(key.trim().to_string(), value.trim().to_string());
+        }
+        let tags = Tags(tags);
+        tags.validate()?;
+        Ok(tags)
+    }
+
+    /// Condition that a tags column includes every one of these tags
+    ///
+    /// Uses JSONB containment (`@>`), which the column's GIN index answers.
+    pub fn contained_in<C: ColumnTrait>(&self, column: C) -> SimpleExpr {
+        let value = serde_json::to_value(&self.0).unwrap_or_default();
+        Expr::col(column).binary(
+            PgBinOper::Contains,
+            Expr::val(value).cast_as(Alias::new("jsonb")),
+        )
+    }
+
+    pub fn validate(&self) -> Result<(), String> {
+        if self.0.len() > MAX_TAGS {
+            return Err(format!("At most {} tags can be set", MAX_TAGS));
+        }
+        for (key, value) in &self.0 {
+            if !is_valid_tag_key(key) {
+                return Err(format!(
+                    "Tag key '{}' must be 1 to {} letters, digits, '.', '_', '-' or '/', \
+                     starting with a letter or digit",
+                    key, MAX_TAG_KEY_LENGTH
+                ));
+            }
+            if value.len() > MAX_TAG_VALUE_LENGTH {
+                return Err(format!(
+                    "Value of tag '{}' is longer than {} characters",
+                    key, MAX_TAG_VALUE_LENGTH
+                ));
+            }
+            if value.chars().any(|c| c.is_control() || c == ',') {
+                return Err(format!(
+                    "Value of tag '{}' can't contain commas or control characters",
+                    key
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+impl From<BTreeMap<String, String>> for Tags {
+    fn from(tags: BTreeMap<String, String>) -> Self {
+        Tags(tags)
+    }
+}
+
+fn is_valid_tag_key(key: &str) -> bool {
+    !key.is_empty()
+        && key.len() <= MAX_TAG_KEY_LENGTH
+        && key
+            .chars()
+            .next()
+            .is_some_and(|c| c.is_ascii_alphanumeric())
+        && key
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '.' | '_' | '-' | '/'))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn tags(pairs: &[(&str, &str)]) -> Tags {
+        Tags(
+            pairs
+                .iter()
+                .map(|(key, value)| (key.to_string(), value.to_string()))
+                .collect(),
+        )
+    }
+
+    #[test]
+    fn test_parse_selector() {
+        assert_eq!(
+            Tags::parse_selector("team=payments, tier=critical").unwrap(),
+            tags(&[("team", "payments"), ("tier", "critical")])
+        );
+        assert_eq!(
+            Tags::parse_selector("owner=").unwrap(),
+            tags(&[("owner", "")])
+        );
+        assert!(Tags::parse_selector("").unwrap().is_empty());
+        assert!(Tags::parse_selector("team").is_err());
+        assert!(Tags::parse_selector("-team=payments").is_err());
+    }
+
+    #[test]
+    fn test_matches_requires_every_tag() {
+        let project = tags(&[("team", "payments"), ("tier", "critical")]);
+        assert!(project.matches(&tags(&[("team", "payments")])));
+        assert!(project.matches(&Tags::default()));
+        assert!(!project.matches(&tags(&[("team", "payments"), ("tier", "low")])));
+        assert!(!project.matches(&tags(&[("region", "eu")])));
+    }
+
+    #[test]
+    fn test_validation() {
+        assert!(tags(&[("app.kubernetes.io/part-of", "shop")])
+            .validate()
+            .is_ok());
+        assert!(tags(&[("team name", "payments")]).validate().is_err());
+        assert!(tags(&[("team", "a,b")]).validate().is_err());
+        assert!(tags(&[("team", &"x".repeat(MAX_TAG_VALUE_LENGTH + 1))])
+            .validate()
+            .is_err());
+
+        let too_many = Tags(
+            (0..=MAX_TAGS)
+                .map(|i| (format!("tag-{}", i), String::new()))
+                .collect(),
+        );
+        assert!(too_many.validate().is_err());
+        assert_eq!(
+            serde_json::to_value(tags(&[("team", "payments")])).unwrap(),
+            serde_json::json!({"team": "payments"})
+        );
+    }
+}
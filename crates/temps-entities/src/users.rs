@@ -22,6 +22,7 @@ pub struct Model {
     pub mfa_secret: Option<String>,
     pub mfa_enabled: bool,
     pub mfa_recovery_codes: Option<String>,
+    pub log_scope: Option<String>, // JSON of the projects/environments whose logs the user can read
     pub created_at: DBDateTime,
     pub updated_at: DBDateTime,
 }
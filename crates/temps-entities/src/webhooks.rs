@@ -15,6 +15,9 @@ pub struct Model {
     pub secret: Option<String>,
     /// JSON array of event types to subscribe to
     pub events: String,
+    /// JSON array of the kinds of successful deployments to get deployment.succeeded
+    /// events for; all of them when not set
+    pub deployment_kinds: Option<String>,
     pub enabled: bool,
     pub created_at: DBDateTime,
     pub updated_at: DBDateTime,
@@ -0,0 +1,75 @@
+//! WireGuard Peers Entity
+//!
+//! A peer is a client device (e.g. an operator's laptop) allowed to tunnel into the
+//! private network to reach internal services and managed databases. The peer's
+//! private key is only ever shown once in the generated client config and never stored.
+
+use async_trait::async_trait;
+use sea_orm::entity::prelude::*;
+use sea_orm::{ActiveValue::Set, ConnectionTrait, DbErr};
+use serde::{Deserialize, Serialize};
+use temps_core::DBDateTime;
+
+#[derive(Clone, Debug, PartialEq, DeriveEntityModel, Eq, Serialize, Deserialize)]
+#[sea_orm(table_name = "wireguard_peers")]
+pub struct Model {
+    #[sea_orm(primary_key)]
+    pub id: i32,
+    pub name: String,
+    /// Base64-encoded Curve25519 public key of the peer
+    pub public_key: String,
+    /// Preshared key, encrypted with the platform's encryption key
+    pub encrypted_preshared_key: String,
+    /// Tunnel address assigned to the peer (e.g. "10.66.0.2")
+    pub address: String,
+    /// JSON array of CIDR subnets the peer is allowed to reach
+    pub allowed_subnets: Json,
+    /// User who created this peer
+    pub created_by: Option<i32>,
+    /// Last WireGuard handshake observed on the interface
+    pub last_handshake_at: Option<DBDateTime>,
+    /// Set when the peer is revoked; revoked peers are removed from the interface
+    pub revoked_at: Option<DBDateTime>,
+    pub revoked_by: Option<i32>,
+    pub created_at: DBDateTime,
+    pub updated_at: DBDateTime,
+}
+
+#[derive(Copy, Clone, Debug, EnumIter, DeriveRelation)]
+pub enum Relation {
+    #[sea_orm(
+        belongs_to = "crate::users::Entity",
+        from = "Column::CreatedBy",
+        to = "crate::users::Column::Id"
+    )]
+    Creator,
+}
+
+impl Related<crate::users::Entity> for Entity {
+    fn to() -> RelationDef {
+        Relation::Creator.def()
+    }
+}
+
+#[async_trait]
+impl ActiveModelBehavior for ActiveModel {
+    async fn before_save<C>(mut self, _db: &C, insert: bool) -> Result<Self, DbErr>
+    where
+        C: ConnectionTrait,
+    {
+        let now = chrono::Utc::now();
+
+        if insert {
+            if self.created_at.is_not_set() {
+                self.created_at = Set(now);
+            }
+            if self.updated_at.is_not_set() {
+                self.updated_at = Set(now);
+            }
+        } else {
+            self.updated_at = Set(now);
+        }
+
+        Ok(self)
+    }
+}
@@ -11,6 +11,8 @@ pub struct EnvironmentSettingsUpdatedFields {
     pub branch: Option<String>,
     pub replicas: Option<i32>,
     pub security_updated: bool,
+    pub routing_rules_updated: bool,
+    pub protected_secrets: Option<bool>,
 }
 
 // Add these new audit structs after the other audit structs
@@ -82,3 +84,149 @@ impl AuditOperation for EnvironmentDeletedAudit {
             .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
     }
 }
+
+#[derive(Debug, Clone, Serialize)]
+pub struct EnvironmentVariablesImportedAudit {
+    pub context: AuditContext,
+    pub project_id: i32,
+    pub environment_ids: Vec<i32>,
+    pub created: Vec<String>,
+    pub updated: Vec<String>,
+    pub protected: bool,
+}
+
+impl AuditOperation for EnvironmentVariablesImportedAudit {
+    fn operation_type(&self) -> String {
+        "ENVIRONMENT_VARIABLES_IMPORTED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct EnvironmentSecretsExportedAudit {
+    pub context: AuditContext,
+    pub project_id: i32,
+    pub environment_id: i32,
+    pub keys: Vec<String>,
+    pub protected: bool,
+}
+
+impl AuditOperation for EnvironmentSecretsExportedAudit {
+    fn operation_type(&self) -> String {
+        "ENVIRONMENT_SECRETS_EXPORTED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+#[derive(Debug, Clone, Copy, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum EnvironmentVariableChange {
+    Created,
+    Updated,
+    Deleted,
+}
+
+/// A variable was created, changed or deleted; `environment_ids` are all environments
+/// the change affects, before and after it
+#[derive(Debug, Clone, Serialize)]
+pub struct EnvironmentVariableChangedAudit {
+    pub context: AuditContext,
+    pub project_id: i32,
+    pub variable_id: i32,
+    pub key: String,
+    pub change: EnvironmentVariableChange,
+    pub environment_ids: Vec<i32>,
+    pub protected: bool,
+}
+
+impl AuditOperation for EnvironmentVariableChangedAudit {
+    fn operation_type(&self) -> String {
+        match self.change {
+            EnvironmentVariableChange::Created => "ENVIRONMENT_VARIABLE_CREATED",
+            EnvironmentVariableChange::Updated => "ENVIRONMENT_VARIABLE_UPDATED",
+            EnvironmentVariableChange::Deleted => "ENVIRONMENT_VARIABLE_DELETED",
+        }
+        .to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+/// Values of protected variables were read
+#[derive(Debug, Clone, Serialize)]
+pub struct ProtectedSecretsReadAudit {
+    pub context: AuditContext,
+    pub project_id: i32,
+    pub environment_ids: Vec<i32>,
+    pub keys: Vec<String>,
+}
+
+impl AuditOperation for ProtectedSecretsReadAudit {
+    fn operation_type(&self) -> String {
+        "PROTECTED_SECRETS_READ".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
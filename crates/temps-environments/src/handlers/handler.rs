@@ -1,17 +1,19 @@
 use super::audit::{
-    EnvironmentDeletedAudit, EnvironmentSettingsUpdatedAudit, EnvironmentSettingsUpdatedFields,
+    EnvironmentDeletedAudit, EnvironmentSecretsExportedAudit, EnvironmentSettingsUpdatedAudit,
+    EnvironmentSettingsUpdatedFields, EnvironmentVariableChange, EnvironmentVariableChangedAudit,
+    EnvironmentVariablesImportedAudit, ProtectedSecretsReadAudit,
 };
 use super::types::AppState;
 use axum::Router;
 use axum::{
     extract::{Extension, Path, Query, State},
-    http::StatusCode,
+    http::{header, StatusCode},
     response::IntoResponse,
     routing::{delete, get, post, put},
     Json,
 };
 use std::sync::Arc;
-use temps_auth::{permission_guard, RequireAuth};
+use temps_auth::{permission_guard, AuthContext, Permission, RequireAuth};
 use temps_core::AuditContext;
 use temps_core::RequestMetadata;
 use tracing::{error, info};
@@ -19,8 +21,10 @@ use utoipa::OpenApi;
 
 use super::types::{
     AddEnvironmentDomainRequest, CreateEnvironmentRequest, CreateEnvironmentVariableRequest,
-    EnvironmentDomainResponse, EnvironmentInfo, EnvironmentResponse, EnvironmentVariableResponse,
-    EnvironmentVariableValueResponse, GetEnvironmentVariablesQuery,
+    DotenvParseError, EnvironmentDomainResponse, EnvironmentInfo, EnvironmentResponse,
+    EnvironmentVariableResponse, EnvironmentVariableValueResponse, ExportEnvironmentVariablesQuery,
+    GetEnvironmentVariablesQuery, ImportEnvironmentVariablesRequest,
+    ImportEnvironmentVariablesResponse, ResolvedEnvironmentVariableResponse,
     UpdateEnvironmentSettingsRequest,
 };
 use temps_core::problemdetails::Problem;
@@ -52,6 +56,68 @@ impl From<crate::services::env_var_service::EnvVarError> for Problem {
     }
 }
 
+/// Require the protected secrets read permission when the access is `protected`
+fn protected_secrets_read_guard(auth: &AuthContext, protected: bool) -> Result<(), Problem> {
+    if protected {
+        permission_guard!(auth, ProtectedSecretsRead);
+    }
+    Ok(())
+}
+
+/// Require the protected secrets write permission when the change is `protected`
+fn protected_secrets_write_guard(auth: &AuthContext, protected: bool) -> Result<(), Problem> {
+    if protected {
+        permission_guard!(auth, ProtectedSecretsWrite);
+    }
+    Ok(())
+}
+
+fn audit_context(auth: &AuthContext, metadata: &RequestMetadata) -> AuditContext {
+    AuditContext {
+        user_id: auth.user_id(),
+        ip_address: Some(metadata.ip_address.clone()),
+        user_agent: metadata.user_agent.clone(),
+    }
+}
+
+async fn create_audit_log(state: &AppState, operation: &dyn temps_core::AuditOperation) {
+    if let Err(e) = state.audit_service.create_audit_log(operation).await {
+        error!("Failed to create audit log: {:?}", e);
+    }
+}
+
+fn environment_variable_response(
+    var: crate::services::EnvVarWithEnvironments,
+    protected: bool,
+    masked: bool,
+) -> EnvironmentVariableResponse {
+    EnvironmentVariableResponse {
+        id: var.id,
+        key: var.key,
+        value: if masked {
+            crate::services::dotenv::MASKED_VALUE.to_string()
+        } else {
+            var.value
+        },
+        created_at: var.created_at.timestamp_millis(),
+        updated_at: var.updated_at.timestamp_millis(),
+        environments: var
+            .environments
+            .into_iter()
+            .map(|env| EnvironmentInfo {
+                id: env.id,
+                name: env.name,
+                main_url: env.main_url,
+                current_deployment_id: env.current_deployment_id,
+            })
+            .collect(),
+        include_in_preview: var.include_in_preview,
+        build_only: var.build_only,
+        shared: var.shared,
+        protected,
+    }
+}
+
 /// Get all environments for a project
 #[utoipa::path(
     get,
@@ -257,6 +323,9 @@ pub async fn delete_environment_domain(
 }
 
 /// Get environment variables for a project, optionally filtered by environment
+///
+/// Values of variables used by a protected environment are masked unless the caller
+/// has the protected secrets read permission.
 #[utoipa::path(
     get,
     path = "/projects/{project_id}/env-vars",
@@ -276,6 +345,7 @@ pub async fn get_environment_variables(
     Path(project_id): Path<i32>,
     Query(params): Query<GetEnvironmentVariablesQuery>,
     RequireAuth(auth): RequireAuth,
+    Extension(metadata): Extension<RequestMetadata>,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, EnvironmentsRead);
 
@@ -283,33 +353,40 @@ pub async fn get_environment_variables(
         .env_var_service
         .get_environment_variables(project_id, params.environment_id)
         .await?;
+    let scope = state.env_var_service.secret_scope(project_id).await?;
+    let can_read_protected = auth.has_permission(&Permission::ProtectedSecretsRead);
 
+    let mut revealed_keys = Vec::new();
+    let mut revealed_environments = std::collections::BTreeSet::new();
     let response: Vec<EnvironmentVariableResponse> = vars
         .into_iter()
-        .map(|v| EnvironmentVariableResponse {
-            id: v.id,
-            key: v.key,
-            value: v.value,
-            created_at: v.created_at.timestamp_millis(),
-            updated_at: v.updated_at.timestamp_millis(),
-            environments: v
-                .environments
-                .into_iter()
-                .map(|env| EnvironmentInfo {
-                    id: env.id,
-                    name: env.name,
-                    main_url: env.main_url,
-                    current_deployment_id: env.current_deployment_id,
-                })
-                .collect(),
-            include_in_preview: v.include_in_preview,
+        .map(|v| {
+            let protected = scope.covers_variable(v.id);
+            if protected && can_read_protected {
+                revealed_keys.push(v.key.clone());
+                revealed_environments.extend(scope.variable_environments(v.id));
+            }
+            environment_variable_response(v, protected, protected && !can_read_protected)
         })
         .collect();
 
+    if !revealed_keys.is_empty() {
+        let audit_event = ProtectedSecretsReadAudit {
+            context: audit_context(&auth, &metadata),
+            project_id,
+            environment_ids: revealed_environments.into_iter().collect(),
+            keys: revealed_keys,
+        };
+        create_audit_log(&state, &audit_event).await;
+    }
+
     Ok(Json(response))
 }
 
 /// Create a new environment variable
+///
+/// Adding a variable to a protected environment, or sharing one with a project that
+/// has a protected environment, requires the protected secrets write permission.
 #[utoipa::path(
     post,
     path = "/projects/{project_id}/env-vars",
@@ -318,6 +395,7 @@ pub async fn get_environment_variables(
     responses(
         (status = 201, description = "Environment variables created successfully", body = EnvironmentVariableResponse),
         (status = 400, description = "Invalid input"),
+        (status = 403, description = "Insufficient permissions for a protected environment"),
         (status = 404, description = "Project not found"),
         (status = 500, description = "Internal server error")
     ),
@@ -329,10 +407,22 @@ pub async fn create_environment_variable(
     State(state): State<Arc<AppState>>,
     Path(project_id): Path<i32>,
     RequireAuth(auth): RequireAuth,
+    Extension(metadata): Extension<RequestMetadata>,
     Json(request): Json<CreateEnvironmentVariableRequest>,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, EnvironmentsCreate);
 
+    let scope = state.env_var_service.secret_scope(project_id).await?;
+    let (protected, environment_ids) = if request.shared {
+        (scope.covers_project(), scope.project_environments())
+    } else {
+        (
+            scope.covers_environments(&request.environment_ids),
+            request.environment_ids.clone(),
+        )
+    };
+    protected_secrets_write_guard(&auth, protected)?;
+
     let var = state
         .env_var_service
         .create_environment_variable(
@@ -341,39 +431,40 @@ pub async fn create_environment_variable(
             request.key,
             request.value,
             request.include_in_preview,
+            request.build_only,
+            request.shared,
         )
         .await
         .map_err(Problem::from)?;
 
-    let response = EnvironmentVariableResponse {
-        id: var.id,
-        key: var.key,
-        value: var.value,
-        created_at: var.created_at.timestamp_millis(),
-        updated_at: var.updated_at.timestamp_millis(),
-        environments: var
-            .environments
-            .into_iter()
-            .map(|env| EnvironmentInfo {
-                id: env.id,
-                name: env.name,
-                main_url: env.main_url,
-                current_deployment_id: env.current_deployment_id,
-            })
-            .collect(),
-        include_in_preview: var.include_in_preview,
+    let audit_event = EnvironmentVariableChangedAudit {
+        context: audit_context(&auth, &metadata),
+        project_id,
+        variable_id: var.id,
+        key: var.key.clone(),
+        change: EnvironmentVariableChange::Created,
+        environment_ids,
+        protected,
     };
+    create_audit_log(&state, &audit_event).await;
 
-    Ok((StatusCode::CREATED, Json(response)))
+    Ok((
+        StatusCode::CREATED,
+        Json(environment_variable_response(var, protected, false)),
+    ))
 }
 
 /// Delete an environment variable
+///
+/// Deleting a variable used by a protected environment requires the protected secrets
+/// write permission.
 #[utoipa::path(
     delete,
     path = "/projects/{project_id}/env-vars/{var_id}",
     tag = "Projects",
     responses(
         (status = 204, description = "Environment variable deleted successfully"),
+        (status = 403, description = "Insufficient permissions for a protected environment"),
         (status = 404, description = "Project or variable not found"),
         (status = 500, description = "Internal server error")
     ),
@@ -386,18 +477,37 @@ pub async fn delete_environment_variable(
     State(state): State<Arc<AppState>>,
     Path((project_id, var_id)): Path<(i32, i32)>,
     RequireAuth(auth): RequireAuth,
+    Extension(metadata): Extension<RequestMetadata>,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, EnvironmentsDelete);
 
+    let scope = state.env_var_service.secret_scope(project_id).await?;
+    let protected = scope.covers_variable(var_id);
+    protected_secrets_write_guard(&auth, protected)?;
+
     state
         .env_var_service
         .delete_environment_variable(project_id, var_id)
         .await?;
 
+    let audit_event = EnvironmentVariableChangedAudit {
+        context: audit_context(&auth, &metadata),
+        project_id,
+        variable_id: var_id,
+        key: scope.variable_key(var_id).unwrap_or_default().to_string(),
+        change: EnvironmentVariableChange::Deleted,
+        environment_ids: scope.variable_environments(var_id),
+        protected,
+    };
+    create_audit_log(&state, &audit_event).await;
+
     Ok(StatusCode::NO_CONTENT.into_response())
 }
 
 /// Update an environment variable
+///
+/// Changing a variable that is, or will be, used by a protected environment requires
+/// the protected secrets write permission.
 #[utoipa::path(
     put,
     path = "/projects/{project_id}/env-vars/{var_id}",
@@ -406,6 +516,7 @@ pub async fn delete_environment_variable(
     responses(
         (status = 200, description = "Environment variables updated successfully", body = EnvironmentVariableResponse),
         (status = 400, description = "Invalid input"),
+        (status = 403, description = "Insufficient permissions for a protected environment"),
         (status = 404, description = "Project or variable not found"),
         (status = 500, description = "Internal server error")
     ),
@@ -418,10 +529,24 @@ pub async fn update_environment_variable(
     State(state): State<Arc<AppState>>,
     Path((project_id, var_id)): Path<(i32, i32)>,
     RequireAuth(auth): RequireAuth,
+    Extension(metadata): Extension<RequestMetadata>,
     Json(request): Json<CreateEnvironmentVariableRequest>,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, EnvironmentsWrite);
 
+    let scope = state.env_var_service.secret_scope(project_id).await?;
+    // Both the environments the variable leaves and the ones it moves to are affected
+    let mut environment_ids: std::collections::BTreeSet<i32> =
+        scope.variable_environments(var_id).into_iter().collect();
+    let protected = if request.shared {
+        environment_ids.extend(scope.project_environments());
+        scope.covers_variable(var_id) || scope.covers_project()
+    } else {
+        environment_ids.extend(request.environment_ids.iter().copied());
+        scope.covers_variable(var_id) || scope.covers_environments(&request.environment_ids)
+    };
+    protected_secrets_write_guard(&auth, protected)?;
+
     let var = state
         .env_var_service
         .update_environment_variable(
@@ -431,38 +556,42 @@ pub async fn update_environment_variable(
             request.value,
             request.environment_ids,
             request.include_in_preview,
+            request.build_only,
+            request.shared,
         )
         .await?;
 
-    let response = EnvironmentVariableResponse {
-        id: var.id,
-        key: var.key,
-        value: var.value,
-        created_at: var.created_at.timestamp_millis(),
-        updated_at: var.updated_at.timestamp_millis(),
-        environments: var
-            .environments
-            .into_iter()
-            .map(|env| EnvironmentInfo {
-                id: env.id,
-                name: env.name,
-                main_url: env.main_url,
-                current_deployment_id: env.current_deployment_id,
-            })
-            .collect(),
-        include_in_preview: var.include_in_preview,
+    let audit_event = EnvironmentVariableChangedAudit {
+        context: audit_context(&auth, &metadata),
+        project_id,
+        variable_id: var.id,
+        key: var.key.clone(),
+        change: EnvironmentVariableChange::Updated,
+        environment_ids: environment_ids.into_iter().collect(),
+        protected,
     };
+    create_audit_log(&state, &audit_event).await;
 
-    Ok(Json(response))
+    let protected = if var.shared {
+        scope.covers_project()
+    } else {
+        let new_environment_ids: Vec<i32> = var.environments.iter().map(|env| env.id).collect();
+        scope.covers_environments(&new_environment_ids)
+    };
+    Ok(Json(environment_variable_response(var, protected, false)))
 }
 
 /// Get environment variable value by key
+///
+/// Reading the value of a variable used by a protected environment requires the
+/// protected secrets read permission, and is recorded in the audit log.
 #[utoipa::path(
     get,
     path = "/projects/{project_id}/env-vars/{key}/value",
     tag = "Projects",
     responses(
         (status = 200, description = "Environment variable value", body = EnvironmentVariableValueResponse),
+        (status = 403, description = "Insufficient permissions for a protected environment"),
         (status = 404, description = "Project or variable not found"),
         (status = 500, description = "Internal server error")
     ),
@@ -477,15 +606,272 @@ pub async fn get_environment_variable_value(
     Path((project_id, key)): Path<(i32, String)>,
     Query(params): Query<GetEnvironmentVariablesQuery>,
     RequireAuth(auth): RequireAuth,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsRead);
+
+    let var = state
+        .env_var_service
+        .get_environment_variable_by_key(project_id, &key, params.environment_id)
+        .await?;
+    let scope = state.env_var_service.secret_scope(project_id).await?;
+    let protected = scope.covers_variable(var.id);
+    protected_secrets_read_guard(&auth, protected)?;
+
+    if protected {
+        let audit_event = ProtectedSecretsReadAudit {
+            context: audit_context(&auth, &metadata),
+            project_id,
+            environment_ids: scope.variable_environments(var.id),
+            keys: vec![var.key],
+        };
+        create_audit_log(&state, &audit_event).await;
+    }
+
+    Ok(Json(EnvironmentVariableValueResponse { value: var.value }))
+}
+
+/// Get the variables an environment gets
+///
+/// Lists the environment's own variables merged with the project's shared ones, with
+/// references to shared variables replaced, and where each value comes from. Values
+/// that are, or embed, protected secrets are masked for users without the protected
+/// secrets read permission.
+#[utoipa::path(
+    get,
+    path = "/projects/{project_id}/environments/{environment_id}/env-vars/resolved",
+    tag = "Projects",
+    responses(
+        (status = 200, description = "The environment's variables", body = Vec<ResolvedEnvironmentVariableResponse>),
+        (status = 400, description = "A variable references a shared variable that doesn't exist"),
+        (status = 404, description = "Project or environment not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("project_id" = i32, Path, description = "Project ID or slug"),
+        ("environment_id" = i32, Path, description = "Environment ID")
+    )
+)]
+pub async fn get_resolved_environment_variables(
+    State(state): State<Arc<AppState>>,
+    Path((project_id, environment_id)): Path<(i32, i32)>,
+    RequireAuth(auth): RequireAuth,
+    Extension(metadata): Extension<RequestMetadata>,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, EnvironmentsRead);
 
-    let value = state
+    let vars = state
         .env_var_service
-        .get_environment_variable_value(project_id, &key, params.environment_id)
+        .resolve_environment_variables(project_id, environment_id)
         .await?;
+    let scope = state.env_var_service.secret_scope(project_id).await?;
+    let can_read_protected = auth.has_permission(&Permission::ProtectedSecretsRead);
+
+    let mut revealed_keys = Vec::new();
+    let response: Vec<ResolvedEnvironmentVariableResponse> = vars
+        .into_iter()
+        .map(|var| {
+            let protected = scope.covers_variable(var.variable_id)
+                || var
+                    .references
+                    .iter()
+                    .any(|name| scope.covers_shared_key(name));
+            if protected && can_read_protected {
+                revealed_keys.push(var.key.clone());
+            }
+            ResolvedEnvironmentVariableResponse {
+                key: var.key,
+                value: if protected && !can_read_protected {
+                    crate::services::dotenv::MASKED_VALUE.to_string()
+                } else {
+                    var.value
+                },
+                source: var.source,
+                variable_id: var.variable_id,
+                overrides_shared: var.overrides_shared,
+                references: var.references,
+                build_only: var.build_only,
+                protected,
+            }
+        })
+        .collect();
+
+    if !revealed_keys.is_empty() {
+        let audit_event = ProtectedSecretsReadAudit {
+            context: audit_context(&auth, &metadata),
+            project_id,
+            environment_ids: vec![environment_id],
+            keys: revealed_keys,
+        };
+        create_audit_log(&state, &audit_event).await;
+    }
 
-    Ok(Json(EnvironmentVariableValueResponse { value }))
+    Ok(Json(response))
+}
+
+/// Import environment variables from a `.env` file
+///
+/// The whole file is applied in one transaction. If any line can't be parsed nothing
+/// is imported, and the problems are listed with their line numbers. Importing into a
+/// protected environment, or overwriting variables shared with one, requires the
+/// protected secrets write permission.
+#[utoipa::path(
+    post,
+    path = "/projects/{project_id}/env-vars/import",
+    tag = "Projects",
+    request_body = ImportEnvironmentVariablesRequest,
+    responses(
+        (status = 200, description = "Environment variables imported", body = ImportEnvironmentVariablesResponse),
+        (status = 400, description = "The file could not be parsed, or invalid environments"),
+        (status = 403, description = "Insufficient permissions for a protected environment"),
+        (status = 404, description = "Project not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("project_id" = i32, Path, description = "Project ID or slug")
+    )
+)]
+pub async fn import_environment_variables(
+    State(state): State<Arc<AppState>>,
+    Path(project_id): Path<i32>,
+    RequireAuth(auth): RequireAuth,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<ImportEnvironmentVariablesRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsCreate);
+    if request.overwrite {
+        permission_guard!(auth, EnvironmentsWrite);
+    }
+
+    let vars = crate::services::parse_dotenv(&request.content).map_err(|errors| {
+        let detail = errors
+            .iter()
+            .map(|e| e.to_string())
+            .collect::<Vec<_>>()
+            .join("; ");
+        let errors: Vec<DotenvParseError> = errors
+            .into_iter()
+            .map(|e| DotenvParseError {
+                line: e.line,
+                message: e.message,
+            })
+            .collect();
+        temps_core::error_builder::bad_request()
+            .title("Invalid .env File")
+            .detail(detail)
+            .value("errors", errors)
+            .build()
+    })?;
+
+    let keys: Vec<String> = vars.iter().map(|(key, _)| key.clone()).collect();
+    let scope = state.env_var_service.secret_scope(project_id).await?;
+    let protected = scope.covers_import(&request.environment_ids, &keys, request.overwrite);
+    protected_secrets_write_guard(&auth, protected)?;
+
+    let environment_ids = request.environment_ids.clone();
+    let result = state
+        .env_var_service
+        .import_environment_variables(
+            project_id,
+            request.environment_ids,
+            vars,
+            request.include_in_preview,
+            request.build_only,
+            request.overwrite,
+        )
+        .await?;
+
+    let audit_event = EnvironmentVariablesImportedAudit {
+        context: audit_context(&auth, &metadata),
+        project_id,
+        environment_ids,
+        created: result.created.clone(),
+        updated: result.updated.clone(),
+        protected,
+    };
+    create_audit_log(&state, &audit_event).await;
+
+    Ok(Json(ImportEnvironmentVariablesResponse::from(result)))
+}
+
+/// Export an environment's variables as a `.env` file
+///
+/// Variables that look like secrets (passwords, tokens, keys, ...) are masked and
+/// commented out unless `include_secrets` is set, which requires write access to
+/// environments. Exporting a protected environment, or one sharing variables with a
+/// protected environment, requires the protected secrets read permission.
+#[utoipa::path(
+    get,
+    path = "/projects/{project_id}/env-vars/export",
+    tag = "Projects",
+    responses(
+        (status = 200, description = "The environment's variables as a .env file", content_type = "text/plain", body = String),
+        (status = 403, description = "Insufficient permissions to export secrets"),
+        (status = 404, description = "Project not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("project_id" = i32, Path, description = "Project ID or slug"),
+        ("environment_id" = i32, Query, description = "Environment to export"),
+        ("include_secrets" = Option<bool>, Query, description = "Export the values of secrets instead of masking them")
+    )
+)]
+pub async fn export_environment_variables(
+    State(state): State<Arc<AppState>>,
+    Path(project_id): Path<i32>,
+    Query(params): Query<ExportEnvironmentVariablesQuery>,
+    RequireAuth(auth): RequireAuth,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, EnvironmentsRead);
+    if params.include_secrets {
+        permission_guard!(auth, EnvironmentsWrite);
+    }
+
+    let scope = state.env_var_service.secret_scope(project_id).await?;
+    let protected = scope.covers_environment_variables(params.environment_id);
+    protected_secrets_read_guard(&auth, protected)?;
+
+    let vars = state
+        .env_var_service
+        .export_environment_variables(project_id, params.environment_id)
+        .await?;
+
+    if params.include_secrets {
+        let audit_event = EnvironmentSecretsExportedAudit {
+            context: audit_context(&auth, &metadata),
+            project_id,
+            environment_id: params.environment_id,
+            keys: vars
+                .iter()
+                .map(|(key, _)| key.clone())
+                .filter(|key| crate::services::dotenv::is_secret_key(key))
+                .collect(),
+            protected,
+        };
+        create_audit_log(&state, &audit_event).await;
+    } else if protected {
+        let audit_event = ProtectedSecretsReadAudit {
+            context: audit_context(&auth, &metadata),
+            project_id,
+            environment_ids: vec![params.environment_id],
+            keys: vars
+                .iter()
+                .map(|(key, _)| key.clone())
+                .filter(|key| !crate::services::dotenv::is_secret_key(key))
+                .collect(),
+        };
+        create_audit_log(&state, &audit_event).await;
+    }
+
+    Ok((
+        StatusCode::OK,
+        [
+            (header::CONTENT_TYPE, "text/plain; charset=utf-8"),
+            (header::CONTENT_DISPOSITION, "attachment; filename=\".env\""),
+        ],
+        crate::services::render_dotenv(&vars, params.include_secrets),
+    ))
 }
 
 /// Update environment settings
@@ -522,6 +908,14 @@ pub async fn update_environment_settings(
         .get_environment(project_id, env_id)
         .await?;
 
+    // Lifting or adding protection is a change to protected secrets
+    if settings
+        .protected_secrets
+        .is_some_and(|protected| protected != environment.has_protected_secrets())
+    {
+        permission_guard!(auth, ProtectedSecretsWrite);
+    }
+
     let updated_environment = state
         .environment_service
         .update_environment_settings(project_id, env_id, settings.clone())
@@ -542,6 +936,8 @@ pub async fn update_environment_settings(
         branch: settings.branch,
         replicas: settings.replicas,
         security_updated: settings.security.is_some(),
+        routing_rules_updated: settings.routing_rules.is_some(),
+        protected_secrets: settings.protected_secrets,
     };
 
     let audit_event = EnvironmentSettingsUpdatedAudit {
@@ -728,6 +1124,14 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
             "/projects/{project_id}/env-vars",
             post(create_environment_variable),
         )
+        .route(
+            "/projects/{project_id}/env-vars/import",
+            post(import_environment_variables),
+        )
+        .route(
+            "/projects/{project_id}/env-vars/export",
+            get(export_environment_variables),
+        )
         .route(
             "/projects/{project_id}/env-vars/{var_id}",
             put(update_environment_variable),
@@ -740,6 +1144,10 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
             "/projects/{project_id}/env-vars/{key}/value",
             get(get_environment_variable_value),
         )
+        .route(
+            "/projects/{project_id}/environments/{environment_id}/env-vars/resolved",
+            get(get_resolved_environment_variables),
+        )
 }
 
 #[derive(OpenApi)]
@@ -758,6 +1166,9 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
         update_environment_variable,
         delete_environment_variable,
         get_environment_variable_value,
+        get_resolved_environment_variables,
+        import_environment_variables,
+        export_environment_variables,
     ),
     components(
         schemas(
@@ -769,7 +1180,15 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
             EnvironmentVariableResponse,
             CreateEnvironmentVariableRequest,
             EnvironmentVariableValueResponse,
+            ResolvedEnvironmentVariableResponse,
+            temps_entities::env_vars::VariableSource,
+            temps_entities::routing_rules::RoutingRule,
+            temps_entities::routing_rules::RoutingRuleList,
             GetEnvironmentVariablesQuery,
+            ImportEnvironmentVariablesRequest,
+            ImportEnvironmentVariablesResponse,
+            DotenvParseError,
+            ExportEnvironmentVariablesQuery,
             EnvironmentInfo,
         )
     ),
@@ -2,6 +2,7 @@ use serde::{Deserialize, Serialize};
 use std::sync::Arc;
 use temps_core::{AuditLogger, DeploymentCanceller};
 use temps_entities::deployment_config::DeploymentConfig;
+use temps_entities::routing_rules::RoutingRuleList;
 use utoipa::ToSchema;
 
 use crate::services::env_var_service::EnvVarService;
@@ -36,6 +37,15 @@ pub struct CreateEnvironmentVariableRequest {
     /// Include this environment variable in preview environments (default: true)
     #[serde(default = "default_include_in_preview")]
     pub include_in_preview: bool,
+    /// Only pass the variable to builds, as a build argument, and not to the running
+    /// containers; its value is masked in build logs (default: false)
+    #[serde(default)]
+    pub build_only: bool,
+    /// Share the variable with every environment of the project, current and future,
+    /// instead of the selected ones; an environment's own variable with the same key
+    /// wins. Other variables reference it as `${shared.KEY}` (default: false)
+    #[serde(default)]
+    pub shared: bool,
 }
 
 fn default_include_in_preview() -> bool {
@@ -52,6 +62,34 @@ pub struct EnvironmentVariableResponse {
     pub environments: Vec<EnvironmentInfo>,
     /// Include this environment variable in preview environments
     pub include_in_preview: bool,
+    /// Build-time only: passed to builds, never to the running containers
+    pub build_only: bool,
+    /// Shared with every environment of the project
+    pub shared: bool,
+    /// Used by a protected environment; the value is masked for users without the
+    /// protected secrets read permission
+    pub protected: bool,
+}
+
+/// A variable as an environment gets it, and where its value comes from
+#[derive(Serialize, Deserialize, ToSchema)]
+pub struct ResolvedEnvironmentVariableResponse {
+    pub key: String,
+    /// Value with references to shared variables replaced
+    pub value: String,
+    /// `shared` for the project's shared variables, `environment` for the
+    /// environment's own
+    pub source: temps_entities::env_vars::VariableSource,
+    /// Variable the value comes from
+    pub variable_id: i32,
+    /// The environment's variable replaces a shared one with the same key
+    pub overrides_shared: bool,
+    /// Shared variables the value references
+    pub references: Vec<String>,
+    pub build_only: bool,
+    /// The value is, or embeds, a protected secret; it is masked for users without the
+    /// protected secrets read permission
+    pub protected: bool,
 }
 
 #[derive(Serialize, Deserialize, ToSchema)]
@@ -81,13 +119,18 @@ pub struct EnvironmentResponse {
     /// Indicates if this is a preview environment (auto-created per branch)
     /// For preview environments, 'branch' contains the feature branch name
     pub is_preview: bool,
+    /// The environment's variables are protected secrets
+    pub protected_secrets: bool,
     /// Deployment configuration for this environment (overrides project-level config)
     #[serde(skip_serializing_if = "Option::is_none")]
     pub deployment_config: Option<DeploymentConfig>,
+    /// Header and host rules sending matching requests to other environments
+    pub routing_rules: RoutingRuleList,
 }
 
 impl From<temps_entities::environments::Model> for EnvironmentResponse {
     fn from(env: temps_entities::environments::Model) -> Self {
+        let protected_secrets = env.has_protected_secrets();
         Self {
             id: env.id,
             project_id: env.project_id,
@@ -99,7 +142,9 @@ impl From<temps_entities::environments::Model> for EnvironmentResponse {
             updated_at: env.updated_at.timestamp_millis(),
             branch: env.branch,
             is_preview: env.is_preview,
-            deployment_config: env.deployment_config,
+            protected_secrets,
+            deployment_config: env.deployment_config.map(|config| config.masked()),
+            routing_rules: env.routing_rules,
         }
     }
 }
@@ -121,6 +166,65 @@ pub struct AddEnvironmentDomainRequest {
     pub is_primary: bool,
 }
 
+/// Request to import environment variables from a `.env` file
+#[derive(Serialize, Deserialize, ToSchema)]
+pub struct ImportEnvironmentVariablesRequest {
+    /// Contents of the `.env` file
+    #[schema(example = "DATABASE_URL=postgres://localhost/app\nPORT=3000\n")]
+    pub content: String,
+    /// Environments to import the variables into
+    pub environment_ids: Vec<i32>,
+    /// Include new variables in preview environments (default: true)
+    #[serde(default = "default_include_in_preview")]
+    pub include_in_preview: bool,
+    /// Import new variables as build-time only (default: false)
+    #[serde(default)]
+    pub build_only: bool,
+    /// Replace the values of variables that already exist (default: keep them)
+    #[serde(default)]
+    pub overwrite: bool,
+}
+
+/// Keys affected by an import of environment variables
+#[derive(Serialize, Deserialize, ToSchema)]
+pub struct ImportEnvironmentVariablesResponse {
+    /// Keys added to environments that did not have them
+    pub created: Vec<String>,
+    /// Keys whose existing value was replaced
+    pub updated: Vec<String>,
+    /// Keys that already had the imported value
+    pub unchanged: Vec<String>,
+    /// Keys that already exist with a different value and were kept
+    pub skipped: Vec<String>,
+}
+
+impl From<crate::services::EnvVarImportResult> for ImportEnvironmentVariablesResponse {
+    fn from(result: crate::services::EnvVarImportResult) -> Self {
+        Self {
+            created: result.created,
+            updated: result.updated,
+            unchanged: result.unchanged,
+            skipped: result.skipped,
+        }
+    }
+}
+
+/// A line of a `.env` file that could not be parsed
+#[derive(Serialize, Deserialize, ToSchema)]
+pub struct DotenvParseError {
+    /// 1-based line number
+    pub line: usize,
+    pub message: String,
+}
+
+#[derive(Deserialize, ToSchema)]
+pub struct ExportEnvironmentVariablesQuery {
+    pub environment_id: i32,
+    /// Export the values of secrets instead of masking them (requires write access)
+    #[serde(default)]
+    pub include_secrets: bool,
+}
+
 #[derive(Serialize, Deserialize, ToSchema)]
 pub struct EnvironmentVariableValueResponse {
     pub value: String,
@@ -147,6 +251,11 @@ pub struct UpdateEnvironmentSettingsRequest {
     /// Enable/disable automatic deployments for this environment
     #[serde(skip_serializing_if = "Option::is_none")]
     pub automatic_deploy: Option<bool>,
+    /// Glob pattern of git tags that deploy to this environment when pushed (e.g. `v*`)
+    /// An empty string removes the pattern
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = "v*")]
+    pub auto_deploy_tag_pattern: Option<String>,
     /// Enable/disable performance metrics collection
     #[serde(skip_serializing_if = "Option::is_none")]
     pub performance_metrics_enabled: Option<bool>,
@@ -156,6 +265,185 @@ pub struct UpdateEnvironmentSettingsRequest {
     /// Security configuration for this environment (overrides project-level settings)
     #[serde(skip_serializing_if = "Option::is_none")]
     pub security: Option<temps_entities::deployment_config::SecurityConfig>,
+    /// Protocol the proxy uses to reach this environment's containers: `http1`, `h2c`
+    /// (gRPC) or `h2`
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub upstream_protocol: Option<temps_entities::deployment_config::UpstreamProtocol>,
+    /// Seconds the proxy waits for a regular response
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 60)]
+    pub request_timeout_seconds: Option<u32>,
+    /// Seconds a streaming request (gRPC, SSE, WebSocket) may stay idle
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 3600)]
+    pub stream_timeout_seconds: Option<u32>,
+    /// Seconds a WebSocket may go without frames; defaults to the stream timeout
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 300)]
+    pub websocket_idle_timeout_seconds: Option<u32>,
+    /// Maximum lifetime of a WebSocket in seconds
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 86400)]
+    pub websocket_max_duration_seconds: Option<u32>,
+    /// Whether the proxy may buffer responses; false streams them through as they
+    /// arrive, for SSE and downloads
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = false)]
+    pub response_buffering: Option<bool>,
+    /// Longest the previous deployment keeps serving open connections after a deploy
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 30)]
+    pub connection_drain_seconds: Option<u32>,
+    /// Largest request body in bytes; larger requests get 413
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 104857600)]
+    pub max_request_body_bytes: Option<u64>,
+    /// Largest request line plus headers in bytes; larger requests get 431
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 32768)]
+    pub max_request_header_bytes: Option<u32>,
+    /// Seconds to wait for the client to send more of its request (408)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 60)]
+    pub client_timeout_seconds: Option<u32>,
+    /// Seconds to wait when writing to the upstream (504)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 60)]
+    pub write_timeout_seconds: Option<u32>,
+    /// Seconds an unused upstream connection is kept for reuse
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 60)]
+    pub idle_timeout_seconds: Option<u32>,
+    /// Seconds to wait for a connection to the upstream (504)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 10)]
+    pub connect_timeout_seconds: Option<u32>,
+    /// Most requests proxied to the service at once, with an optional queue for the
+    /// ones over it (503 when full)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub concurrency_limit: Option<temps_entities::deployment_config::ConcurrencyLimitConfig>,
+    /// `auto` (redirect to HTTPS once the host has an active certificate),
+    /// `force_https`, `both` or `http_only`
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub https_mode: Option<temps_entities::deployment_config::HttpsMode>,
+    /// Status of the redirects between HTTP and HTTPS: 301, 302, 307 or 308
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 308)]
+    pub https_redirect_status: Option<u16>,
+    /// Signal that stops the containers gracefully; `SIGTERM` when unset
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = "SIGQUIT")]
+    pub stop_signal: Option<String>,
+    /// Seconds the containers get to exit after the stop signal (1-120)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 30)]
+    pub stop_timeout_seconds: Option<u32>,
+    /// Days to keep each deployment's build artifacts; not retained when unset
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 30)]
+    pub artifact_retention_days: Option<u32>,
+    /// Number of recent deployment images kept for rollbacks (1-100), in place of the
+    /// global garbage collection setting; the running image is always kept
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 10)]
+    pub retained_images: Option<u32>,
+    /// Seconds the whole deploy may take (60 to 86400, default 3600) before it's
+    /// aborted and fails
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = 1800)]
+    pub deploy_timeout_seconds: Option<u32>,
+    /// Static site hosting: output directory, SPA fallback, 404 page and caching
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub static_site: Option<temps_entities::deployment_config::StaticSiteConfig>,
+    /// Promotion into this environment: approval gate and carried-over variables
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub promotion: Option<temps_entities::deployment_config::PromotionConfig>,
+    /// Persistent package manager and compiler caches for builds
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub build_cache: Option<temps_entities::deployment_config::BuildCacheConfig>,
+    /// Builder of the images: Dockerfile, Nixpacks or Cloud Native Buildpacks
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub builder: Option<temps_entities::deployment_config::BuilderConfig>,
+    /// Build stage shared with the other services of the repository
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub shared_build: Option<temps_entities::deployment_config::SharedBuildConfig>,
+    /// Build command run in place of the one the builder detects
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = "npm run build:prod")]
+    pub build_command: Option<String>,
+    /// Command the containers start with in place of the image's
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = "node dist/server.js")]
+    pub start_command: Option<String>,
+    /// Entrypoint the web containers run in place of the image's
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = json!(["/usr/bin/tini", "--"]))]
+    pub entrypoint: Option<Vec<String>>,
+    /// Arguments the web containers start with in place of the image's command
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = json!(["serve", "--port", "8080"]))]
+    pub args: Option<Vec<String>>,
+    /// Platforms to build the images for; the control plane's platform when unset
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = json!(["linux/amd64", "linux/arm64"]))]
+    pub build_platforms: Option<Vec<String>>,
+    /// Log driver and local log rotation of the containers
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub container_logs: Option<temps_entities::deployment_config::ContainerLogsConfig>,
+    /// Timezone and locale of the containers (`TZ` and `LANG`)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub locale_defaults: Option<temps_entities::deployment_config::LocaleDefaultsConfig>,
+    /// Probe new containers must pass before their regular health checks begin, for
+    /// apps that take long to boot
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub startup_probe: Option<temps_entities::deployment_config::StartupProbeConfig>,
+    /// How running containers pick up changed runtime config values
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub config_reload: Option<temps_entities::deployment_config::ConfigReloadConfig>,
+    /// Command run inside new containers whose exit code is their health, in place of
+    /// the HTTP health check
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub exec_health_check: Option<temps_entities::deployment_config::ExecHealthCheckConfig>,
+    /// Failed health checks in a row that mark the service unhealthy and restart it
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub health_thresholds: Option<temps_entities::deployment_config::HealthThresholdsConfig>,
+    /// HTTP checks run against new containers before they receive traffic
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub smoke_tests: Option<temps_entities::deployment_config::SmokeTestsConfig>,
+    /// Nameservers, search domains and `/etc/hosts` entries of the containers
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub container_dns: Option<temps_entities::deployment_config::ContainerDnsConfig>,
+    /// Ulimits and `/dev/shm` size of the containers
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub container_limits: Option<temps_entities::deployment_config::ContainerLimitsConfig>,
+    /// External check the new containers must pass before they receive traffic
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub deploy_gate: Option<temps_entities::deployment_config::DeployGateConfig>,
+    /// Cache purge of the CDN in front of the environment after successful deploys
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub cdn_purge: Option<temps_entities::deployment_config::CdnPurgeConfig>,
+    /// Replicas and commands of the Procfile's process types, by name; applied on the
+    /// next deploy
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub processes: Option<
+        std::collections::BTreeMap<String, temps_entities::deployment_config::ProcessTypeConfig>,
+    >,
+    /// Automatic retries of deploys that failed for a transient reason
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub auto_retry: Option<temps_entities::deployment_config::AutoRetryConfig>,
+    /// Priority of the environment's deploys in the build queue; production is high
+    /// and previews low unless set otherwise
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(example = "high")]
+    pub build_priority: Option<temps_entities::deployment_config::BuildPriority>,
+    /// Restrict the environment's variables to users with the protected secrets
+    /// permissions; production is protected unless set otherwise
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub protected_secrets: Option<bool>,
+    /// Header and host rules sending matching requests to other environments of the
+    /// project, replacing the current rules; an empty list removes them
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub routing_rules: Option<RoutingRuleList>,
 }
 
 #[derive(Serialize, Deserialize, ToSchema)]
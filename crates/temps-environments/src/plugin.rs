@@ -44,7 +44,10 @@ impl TempsPlugin for EnvironmentsPlugin {
             // Create EnvironmentService with queue service
             let environment_service = Arc::new(
                 EnvironmentService::new(db.clone(), config_service)
-                    .with_queue_service(queue_service),
+                    .with_queue_service(queue_service)
+                    .with_encryption_service(
+                        context.require_service::<temps_core::EncryptionService>(),
+                    ),
             );
             context.register_service(environment_service);
             let env_var_service = Arc::new(EnvVarService::new(db.clone()));
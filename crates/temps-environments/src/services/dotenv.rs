@@ -0,0 +1,365 @@
+//! `.env` files
+//!
+//! Parses `.env` files for bulk import of environment variables and renders
+//! variables back into one for export. Supported syntax:
+//!
+//! - `KEY=value`, optionally prefixed with `export `
+//! - blank lines and `#` comments, including trailing ` # comments` after unquoted values
+//! - `'single quoted'` values, taken literally
+//! - `"double quoted"` values, with `\n`, `\r`, `\t`, `\"` and `\\` escapes
+//!
+//! Quoted values may span several lines. Variable references (`${OTHER}`) are not
+//! expanded.
+
+use std::collections::HashMap;
+use std::fmt;
+
+/// Value written for secrets left out of an export
+pub const MASKED_VALUE: &str = "********";
+
+/// A problem with a line of a `.env` file
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DotenvError {
+    /// 1-based line number
+    pub line: usize,
+    pub message: String,
+}
+
+impl fmt::Display for DotenvError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "line {}: {}", self.line, self.message)
+    }
+}
+
+/// Parse a `.env` file into its variables, in file order
+///
+/// All problems of the file are reported at once rather than stopping at the first.
+pub fn parse_dotenv(content: &str) -> Result<Vec<(String, String)>, Vec<DotenvError>> {
+    let lines: Vec<&str> = content.lines().collect();
+    let mut vars: Vec<(String, String)> = Vec::new();
+    let mut defined_on: HashMap<String, usize> = HashMap::new();
+    let mut errors = Vec::new();
+
+    let mut index = 0;
+    while index < lines.len() {
+        let line_number = index + 1;
+        let line = lines[index].trim();
+        index += 1;
+
+        if line.is_empty() || line.starts_with('#') {
+            continue;
+        }
+
+        let line = line
+            .strip_prefix("export ")
+            .map(str::trim_start)
+            .unwrap_or(line);
+        let Some((key, rest)) = line.split_once('=') else {
+            errors.push(DotenvError {
+                line: line_number,
+                message: "expected KEY=value".to_string(),
+            });
+            continue;
+        };
+
+        let key = key.trim();
+        if !is_valid_key(key) {
+            errors.push(DotenvError {
+                line: line_number,
+                message: format!(
+                    "invalid variable name '{}': use letters, digits and underscores, not starting with a digit",
+                    key
+                ),
+            });
+            continue;
+        }
+
+        let rest = rest.trim_start();
+        let value = match rest.chars().next() {
+            Some(quote @ ('"' | '\'')) => {
+                // Quoted values run until the closing quote, possibly on a later line
+                let mut text = rest[1..].to_string();
+                let mut closed = find_closing_quote(&text, quote);
+                while closed.is_none() && index < lines.len() {
+                    text.push('\n');
+                    text.push_str(lines[index]);
+                    index += 1;
+                    closed = find_closing_quote(&text, quote);
+                }
+                let Some(end) = closed else {
+                    errors.push(DotenvError {
+                        line: line_number,
+                        message: format!("unterminated {} quoted value", quote_name(quote)),
+                    });
+                    continue;
+                };
+
+                let trailing = text[end + 1..].trim();
+                if !trailing.is_empty() && !trailing.starts_with('#') {
+                    errors.push(DotenvError {
+                        line: line_number,
+                        message: format!(
+                            "unexpected characters after the closing {} quote",
+                            quote_name(quote)
+                        ),
+                    });
+                    continue;
+                }
+
+                if quote == '"' {
+                    unescape(&text[..end])
+                } else {
+                    text[..end].to_string()
+                }
+            }
+            _ => {
+                // A `#` starts a comment only after whitespace, so `a#b` stays intact
+                let value = match rest.find(" #").or_else(|| rest.find("\t#")) {
+                    Some(comment) => &rest[..comment],
+                    None if rest.starts_with('#') => "",
+                    None => rest,
+                };
+                value.trim_end().to_string()
+            }
+        };
+
+        if let Some(first) = defined_on.get(key) {
+            errors.push(DotenvError {
+                line: line_number,
+                message: format!("'{}' is already defined on line {}", key, first),
+            });
+            continue;
+        }
+        defined_on.insert(key.to_string(), line_number);
+        vars.push((key.to_string(), value));
+    }
+
+    if errors.is_empty() {
+        Ok(vars)
+    } else {
+        Err(errors)
+    }
+}
+
+/// Render variables as a `.env` file, quoting values where needed
+///
+/// Variables are written in the given order; the output parses back to the same
+/// variables with [`parse_dotenv`]. Unless `include_secrets` is set, variables that
+/// look like secrets (see [`is_secret_key`]) are written as comments with a masked
+/// value, so importing the file again leaves their stored values alone.
+pub fn render_dotenv(vars: &[(String, String)], include_secrets: bool) -> String {
+    let mut output = String::new();
+    for (key, value) in vars {
+        if !include_secrets && is_secret_key(key) {
+            output.push_str(&format!(
+                "# {}={} (secret, not exported)\n",
+                key, MASKED_VALUE
+            ));
+            continue;
+        }
+        output.push_str(key);
+        output.push('=');
+        output.push_str(&quote_value(value));
+        output.push('\n');
+    }
+    output
+}
+
+/// Whether a variable is likely to hold a secret, judging by its name
+pub fn is_secret_key(key: &str) -> bool {
+    let key = key.to_lowercase();
+    [
+        "password",
+        "secret",
+        "token",
+        "key",
+        "auth",
+        "credential",
+        "private",
+    ]
+    .iter()
+    .any(|word| key.contains(word))
+}
+
+fn is_valid_key(key: &str) -> bool {
+    let mut chars = key.chars();
+    matches!(chars.next(), Some(c) if c.is_ascii_alphabetic() || c == '_')
+        && chars.all(|c| c.is_ascii_alphanumeric() || c == '_')
+}
+
+fn quote_name(quote: char) -> &'static str {
+    if quote == '"' {
+        "double"
+    } else {
+        "single"
+    }
+}
+
+/// Byte offset of the quote closing a value, skipping escaped double quotes
+fn find_closing_quote(text: &str, quote: char) -> Option<usize> {
+    let mut escaped = false;
+    for (offset, c) in text.char_indices() {
+        if escaped {
+            escaped = false;
+        } else if c == '\\' && quote == '"' {
+            escaped = true;
+        } else if c == quote {
+            return Some(offset);
+        }
+    }
+    None
+}
+
+fn unescape(text: &str) -> String {
+    let mut output = String::with_capacity(text.len());
+    let mut chars = text.chars();
+    while let Some(c) = chars.next() {
+        if c != '\\' {
+            output.push(c);
+            continue;
+        }
+        match chars.next() {
+            Some('n') => output.push('\n'),
+            Some('r') => output.push('\r'),
+            Some('t') => output.push('\t'),
+            Some(other @ ('"' | '\\')) => output.push(other),
+            // Unknown escapes are kept as written
+            Some(other) => {
+                output.push('\\');
+                output.push(other);
+            }
+            None => output.push('\\'),
+        }
+    }
+    output
+}
+
+fn quote_value(value: &str) -> String {
+    let plain = value.chars().all(|c| {
+        c.is_ascii_alphanumeric()
+            || matches!(c, '_' | '-' | '.' | '/' | ':' | '@' | ',' | '+' | '=')
+    });
+    if plain {
+        return value.to_string();
+    }
+    if !value.contains('\'') && !value.contains(['\n', '\r']) {
+        return format!("'{}'", value);
+    }
+
+    let mut quoted = String::with_capacity(value.len() + 2);
+    quoted.push('"');
+    for c in value.chars() {
+        match c {
+            '"' => quoted.push_str("\\\""),
+            '\\' => quoted.push_str("\\\\"),
+            '\n' => quoted.push_str("\\n"),
+            '\r' => quoted.push_str("\\r"),
+            '\t' => quoted.push_str("\\t"),
+            c => quoted.push(c),
+        }
+    }
+    quoted.push('"');
+    quoted
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn vars(pairs: &[(&str, &str)]) -> Vec<(String, String)> {
+        pairs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect()
+    }
+
+    #[test]
+    fn test_parse_dotenv() {
+        let content = "# Database\n\
+            DATABASE_URL=postgres://user@localhost/app\n\
+            \n\
+            export PORT = 3000 # the HTTP port\n\
+            GREETING=\"Hello, \\\"world\\\"\\n\"\n\
+            LITERAL='no $expansion \\n here'\n\
+            COLOR=#fff\n\
+            ANCHOR=page#top\n\
+            EMPTY=\n\
+            CERT=\"-----BEGIN-----\n\
+            abc\n\
+            -----END-----\"\n";
+
+        assert_eq!(
+            parse_dotenv(content).unwrap(),
+            vars(&[
+                ("DATABASE_URL", "postgres://user@localhost/app"),
+                ("PORT", "3000"),
+                ("GREETING", "Hello, \"world\"\n"),
+                ("LITERAL", "no $expansion \\n here"),
+                ("COLOR", ""),
+                ("ANCHOR", "page#top"),
+                ("EMPTY", ""),
+                ("CERT", "-----BEGIN-----\nabc\n-----END-----"),
+            ])
+        );
+    }
+
+    #[test]
+    fn test_parse_dotenv_reports_errors_with_line_numbers() {
+        let content = "GOOD=1\n\
+            not a variable\n\
+            1BAD=x\n\
+            QUOTED=\"value\" trailing\n\
+            GOOD=2\n\
+            OPEN='never closed\n\
+            still open\n";
+
+        let errors = parse_dotenv(content).unwrap_err();
+        let lines: Vec<usize> = errors.iter().map(|e| e.line).collect();
+        assert_eq!(lines, vec![2, 3, 4, 5, 6]);
+        assert_eq!(errors[3].message, "'GOOD' is already defined on line 1");
+        assert_eq!(
+            errors[4].to_string(),
+            "line 6: unterminated single quoted value"
+        );
+    }
+
+    #[test]
+    fn test_render_dotenv_round_trips() {
+        let exported = vars(&[
+            ("PLAIN", "postgres://db:5432/app"),
+            ("SPACES", "hello world"),
+            ("QUOTE", "it's \"here\""),
+            ("MULTILINE", "a\nb\\c"),
+            ("EMPTY", ""),
+        ]);
+
+        let rendered = render_dotenv(&exported, false);
+        assert!(rendered.starts_with("PLAIN=postgres://db:5432/app\nSPACES='hello world'\n"));
+        assert_eq!(parse_dotenv(&rendered).unwrap(), exported);
+    }
+
+    #[test]
+    fn test_render_dotenv_masks_secrets() {
+        let exported = vars(&[("PORT", "3000"), ("API_TOKEN", "tok_123")]);
+
+        let masked = render_dotenv(&exported, false);
+        assert_eq!(
+            masked,
+            "PORT=3000\n# API_TOKEN=******** (secret, not exported)\n"
+        );
+        assert_eq!(parse_dotenv(&masked).unwrap(), vars(&[("PORT", "3000")]));
+
+        let full = render_dotenv(&exported, true);
+        assert_eq!(parse_dotenv(&full).unwrap(), exported);
+    }
+
+    #[test]
+    fn test_is_secret_key() {
+        assert!(is_secret_key("DATABASE_PASSWORD"));
+        assert!(is_secret_key("stripe_secret"));
+        assert!(is_secret_key("GITHUB_TOKEN"));
+        assert!(is_secret_key("AWS_ACCESS_KEY_ID"));
+        assert!(!is_secret_key("PORT"));
+        assert!(!is_secret_key("NODE_ENV"));
+    }
+}
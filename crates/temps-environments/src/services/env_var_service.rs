@@ -1,11 +1,14 @@
 use sea_orm::{
-    ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, QueryOrder, Set, TransactionTrait,
+    ActiveModelTrait, ColumnTrait, Condition, EntityTrait, QueryFilter, QueryOrder, Set,
+    TransactionTrait,
 };
+use std::collections::BTreeSet;
 use std::sync::Arc;
 use temps_entities::{env_var_environments, env_vars, environments};
 use thiserror::Error;
 
-use super::types::{EnvVarEnvironment, EnvVarWithEnvironments};
+use super::secret_access::SecretScope;
+use super::types::{EnvVarEnvironment, EnvVarImportResult, EnvVarWithEnvironments};
 
 #[derive(Error, Debug)]
 pub enum EnvVarError {
@@ -127,6 +130,8 @@ impl EnvVarService {
                     updated_at: var.updated_at,
                     environments,
                     include_in_preview: var.include_in_preview,
+                    build_only: var.build_only,
+                    shared: var.shared,
                 })
             })
             .collect();
@@ -141,7 +146,12 @@ impl EnvVarService {
         key: String,
         value: String,
         include_in_preview: bool,
+        build_only: bool,
+        shared: bool,
     ) -> Result<EnvVarWithEnvironments, EnvVarError> {
+        self.validate_sharing(project_id, None, &key, &value, &environment_ids, shared)
+            .await?;
+
         // Check for conflicts before creating the new env var
         let existing_env_vars = env_vars::Entity::find()
             .filter(env_vars::Column::ProjectId.eq(project_id))
@@ -179,6 +189,8 @@ impl EnvVarService {
                         key: Set(key.clone()),
                         value: Set(value.clone()),
                         include_in_preview: Set(include_in_preview),
+                        build_only: Set(build_only),
+                        shared: Set(shared),
                         created_at: Set(chrono::Utc::now()),
                         updated_at: Set(chrono::Utc::now()),
                         environment_id: Set(None),
@@ -222,6 +234,8 @@ impl EnvVarService {
                         updated_at: var.updated_at,
                         environments,
                         include_in_preview: var.include_in_preview,
+                        build_only: var.build_only,
+                        shared: var.shared,
                     })
                 })
             })
@@ -238,7 +252,19 @@ impl EnvVarService {
         value: String,
         environment_ids: Vec<i32>,
         include_in_preview: bool,
+        build_only: bool,
+        shared: bool,
     ) -> Result<EnvVarWithEnvironments, EnvVarError> {
+        self.validate_sharing(
+            project_id,
+            Some(var_id),
+            &key,
+            &value,
+            &environment_ids,
+            shared,
+        )
+        .await?;
+
         let result = self
             .db
             .transaction::<_, EnvVarWithEnvironments, EnvVarError>(|txn| {
@@ -256,6 +282,8 @@ impl EnvVarService {
                     active_var.key = Set(key.clone());
                     active_var.value = Set(value.clone());
                     active_var.include_in_preview = Set(include_in_preview);
+                    active_var.build_only = Set(build_only);
+                    active_var.shared = Set(shared);
                     active_var.updated_at = Set(chrono::Utc::now());
                     let var = active_var.update(txn).await?;
 
@@ -300,6 +328,8 @@ impl EnvVarService {
                         updated_at: var.updated_at,
                         environments,
                         include_in_preview: var.include_in_preview,
+                        build_only: var.build_only,
+                        shared: var.shared,
                     })
                 })
             })
@@ -337,19 +367,272 @@ impl EnvVarService {
         Ok(())
     }
 
-    pub async fn get_environment_variable_value(
+    /// Import variables in bulk, e.g. parsed from a `.env` file, into environments
+    ///
+    /// Runs in a single transaction, so either the whole import is applied or none of
+    /// it is. Environments that don't have a key yet get it as a new variable; where a
+    /// key already exists with a different value, the value is replaced when
+    /// `overwrite` is set and kept otherwise. Replacing a value changes it in every
+    /// environment the existing variable is shared with.
+    pub async fn import_environment_variables(
+        &self,
+        project_id: i32,
+        environment_ids: Vec<i32>,
+        vars: Vec<(String, String)>,
+        include_in_preview: bool,
+        build_only: bool,
+        overwrite: bool,
+    ) -> Result<EnvVarImportResult, EnvVarError> {
+        let target_ids: BTreeSet<i32> = environment_ids.into_iter().collect();
+        if target_ids.is_empty() {
+            return Err(EnvVarError::InvalidInput(
+                "Select at least one environment to import into".to_string(),
+            ));
+        }
+
+        let result = self
+            .db
+            .transaction::<_, EnvVarImportResult, EnvVarError>(|txn| {
+                Box::pin(async move {
+                    let environments = environments::Entity::find()
+                        .filter(environments::Column::ProjectId.eq(project_id))
+                        .filter(environments::Column::Id.is_in(target_ids.iter().copied()))
+                        .all(txn)
+                        .await?;
+                    if environments.len() != target_ids.len() {
+                        return Err(EnvVarError::InvalidInput(
+                            "One or more environments do not belong to this project".to_string(),
+                        ));
+                    }
+
+                    let keys: Vec<String> = vars.iter().map(|(key, _)| key.clone()).collect();
+                    let existing = env_vars::Entity::find()
+                        .filter(env_vars::Column::ProjectId.eq(project_id))
+                        .filter(env_vars::Column::Key.is_in(keys))
+                        .find_with_related(env_var_environments::Entity)
+                        .all(txn)
+                        .await?;
+
+                    let mut result = EnvVarImportResult::default();
+                    for (key, value) in &vars {
+                        let mut covered: BTreeSet<i32> = BTreeSet::new();
+                        for (var, links) in existing.iter().filter(|(var, _)| &var.key == key) {
+                            let linked: Vec<i32> = links
+                                .iter()
+                                .map(|link| link.environment_id)
+                                .filter(|id| target_ids.contains(id))
+                                .collect();
+                            if linked.is_empty() {
+                                continue;
+                            }
+                            covered.extend(linked);
+
+                            let outcome = if &var.value == value {
+                                &mut result.unchanged
+                            } else if overwrite {
+                                let mut active_var: env_vars::ActiveModel = var.clone().into();
+                                active_var.value = Set(value.clone());
+                                active_var.updated_at = Set(chrono::Utc::now());
+                                active_var.update(txn).await?;
+                                &mut result.updated
+                            } else {
+                                &mut result.skipped
+                            };
+                            if !outcome.contains(key) {
+                                outcome.push(key.clone());
+                            }
+                        }
+
+                        let missing: Vec<i32> = target_ids.difference(&covered).copied().collect();
+                        if missing.is_empty() {
+                            continue;
+                        }
+
+                        let var = env_vars::ActiveModel {
+                            project_id: Set(project_id),
+                            key: Set(key.clone()),
+                            value: Set(value.clone()),
+                            include_in_preview: Set(include_in_preview),
+                            build_only: Set(build_only),
+                            created_at: Set(chrono::Utc::now()),
+                            updated_at: Set(chrono::Utc::now()),
+                            environment_id: Set(None),
+                            ..Default::default()
+                        }
+                        .insert(txn)
+                        .await?;
+                        for env_id in missing {
+                            env_var_environments::ActiveModel {
+                                env_var_id: Set(var.id),
+                                environment_id: Set(env_id),
+                                created_at: Set(chrono::Utc::now()),
+                                ..Default::default()
+                            }
+                            .insert(txn)
+                            .await?;
+                        }
+                        result.created.push(key.clone());
+                    }
+
+                    Ok(result)
+                })
+            })
+            .await?;
+
+        Ok(result)
+    }
+
+    /// Variables of an environment as key/value pairs, sorted by key, the way the
+    /// environment gets them: shared variables included, references replaced
+    pub async fn export_environment_variables(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<Vec<(String, String)>, EnvVarError> {
+        Ok(self
+            .resolve_environment_variables(project_id, environment_id)
+            .await?
+            .into_iter()
+            .map(|var| (var.key, var.value))
+            .collect())
+    }
+
+    /// Variable with the given key, as used by `environment_id` when given: the
+    /// environment's own, or else the shared one
+    pub async fn get_environment_variable_by_key(
         &self,
         project_id: i32,
         key: &str,
-        _environment_id: Option<i32>,
-    ) -> Result<String, EnvVarError> {
-        let var = env_vars::Entity::find()
+        environment_id: Option<i32>,
+    ) -> Result<env_vars::Model, EnvVarError> {
+        let mut query = env_vars::Entity::find()
             .filter(env_vars::Column::ProjectId.eq(project_id))
-            .filter(env_vars::Column::Key.eq(key))
+            .filter(env_vars::Column::Key.eq(key));
+        if let Some(environment_id) = environment_id {
+            let linked = env_var_environments::Entity::find()
+                .filter(env_var_environments::Column::EnvironmentId.eq(environment_id))
+                .all(self.db.as_ref())
+                .await?
+                .into_iter()
+                .map(|link| link.env_var_id);
+            query = query.filter(
+                Condition::any()
+                    .add(env_vars::Column::Id.is_in(linked))
+                    .add(env_vars::Column::Shared.eq(true)),
+            );
+        }
+
+        let var = query
+            .order_by_asc(env_vars::Column::Shared)
             .one(self.db.as_ref())
             .await?
             .ok_or_else(|| EnvVarError::Other("Environment variable not found".to_string()))?;
 
-        Ok(var.value)
+        Ok(var)
+    }
+
+    /// Variables an environment gets, with the project's shared variables merged in
+    /// and references to them replaced, each with where its value comes from
+    pub async fn resolve_environment_variables(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<Vec<env_vars::ResolvedVariable>, EnvVarError> {
+        let environment = environments::Entity::find_by_id(environment_id)
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| EnvVarError::NotFound("Environment not found".to_string()))?;
+
+        let shared = env_vars::Entity::find()
+            .filter(env_vars::Column::ProjectId.eq(project_id))
+            .filter(env_vars::Column::Shared.eq(true))
+            .all(self.db.as_ref())
+            .await?;
+        let linked = env_var_environments::Entity::find()
+            .filter(env_var_environments::Column::EnvironmentId.eq(environment.id))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|link| link.env_var_id);
+        let own = env_vars::Entity::find()
+            .filter(env_vars::Column::ProjectId.eq(project_id))
+            .filter(env_vars::Column::Shared.eq(false))
+            .filter(env_vars::Column::Id.is_in(linked))
+            .all(self.db.as_ref())
+            .await?;
+
+        env_vars::resolve_variables(&shared, &own, environment.is_preview)
+            .map_err(EnvVarError::InvalidInput)
+    }
+
+    /// Check a variable against the rules for shared variables: they aren't linked to
+    /// environments, their keys are unique and they can't reference each other. The
+    /// shared variables other variables reference have to exist.
+    async fn validate_sharing(
+        &self,
+        project_id: i32,
+        var_id: Option<i32>,
+        key: &str,
+        value: &str,
+        environment_ids: &[i32],
+        shared: bool,
+    ) -> Result<(), EnvVarError> {
+        let references = env_vars::shared_references(value);
+        let shared_vars = env_vars::Entity::find()
+            .filter(env_vars::Column::ProjectId.eq(project_id))
+            .filter(env_vars::Column::Shared.eq(true))
+            .all(self.db.as_ref())
+            .await?;
+
+        if shared {
+            if !environment_ids.is_empty() {
+                return Err(EnvVarError::InvalidInput(
+                    "Shared variables apply to every environment; don't select environments"
+                        .to_string(),
+                ));
+            }
+            if !references.is_empty() {
+                return Err(EnvVarError::InvalidInput(
+                    "Shared variables can't reference other shared variables".to_string(),
+                ));
+            }
+            if shared_vars
+                .iter()
+                .any(|var| var.key == key && Some(var.id) != var_id)
+            {
+                return Err(EnvVarError::InvalidInput(format!(
+                    "Shared variable '{}' already exists in this project",
+                    key
+                )));
+            }
+            return Ok(());
+        }
+
+        if let Some(missing) = references
+            .iter()
+            .find(|name| !shared_vars.iter().any(|var| &var.key == *name))
+        {
+            return Err(EnvVarError::InvalidInput(format!(
+                "Shared variable '{}' doesn't exist in this project",
+                missing
+            )));
+        }
+        Ok(())
+    }
+
+    /// Which environments and variables of a project are protected
+    pub async fn secret_scope(&self, project_id: i32) -> Result<SecretScope, EnvVarError> {
+        let environments = environments::Entity::find()
+            .filter(environments::Column::ProjectId.eq(project_id))
+            .filter(environments::Column::DeletedAt.is_null())
+            .all(self.db.as_ref())
+            .await?;
+        let variables = env_vars::Entity::find()
+            .filter(env_vars::Column::ProjectId.eq(project_id))
+            .find_with_related(env_var_environments::Entity)
+            .all(self.db.as_ref())
+            .await?;
+        Ok(SecretScope::new(&environments, variables))
     }
 }
@@ -1,16 +1,20 @@
 use sea_orm::{
-    ActiveModelTrait, ColumnTrait, DbErr, EntityTrait, QueryFilter, QueryOrder, Set,
-    TransactionTrait,
+    ActiveModelTrait, ColumnTrait, ConnectionTrait, DbErr, EntityTrait, PaginatorTrait,
+    QueryFilter, QueryOrder, Set, TransactionTrait,
 };
 use serde::Serialize;
 use slug::slugify;
 use std::sync::Arc;
 use temps_core::problemdetails::Problem;
-use temps_core::{EnvironmentCreatedJob, Job, JobQueue};
-use temps_entities::{environment_domains, environments, projects};
+use temps_core::{EnvironmentCreatedJob, Job, JobQueue, ProvisionCertificateJob};
+use temps_entities::routing_rules::RoutingRuleList;
+use temps_entities::{environment_domains, environments, project_custom_domains, projects};
 use thiserror::Error;
 use tracing::{info, warn};
 
+/// Most numbered suffixes tried for a hostname from a domain pattern that's taken
+const MAX_DOMAIN_SUFFIX: u32 = 100;
+
 #[derive(Error, Debug)]
 pub enum EnvironmentError {
     #[error("Database connection error: {0}")]
@@ -78,6 +82,7 @@ pub struct EnvironmentService {
     db: Arc<temps_database::DbConnection>,
     config_service: Arc<temps_config::ConfigService>,
     queue_service: Option<Arc<dyn JobQueue>>,
+    encryption_service: Option<Arc<temps_core::EncryptionService>>,
 }
 
 impl EnvironmentService {
@@ -89,6 +94,7 @@ impl EnvironmentService {
             db,
             config_service,
             queue_service: None,
+            encryption_service: None,
         }
     }
 
@@ -97,6 +103,22 @@ impl EnvironmentService {
         self
     }
 
+    /// Encrypts the secrets of deployment configs before they are stored; without it
+    /// configs with secrets are refused
+    pub fn with_encryption_service(
+        mut self,
+        encryption_service: Arc<temps_core::EncryptionService>,
+    ) -> Self {
+        self.encryption_service = Some(encryption_service);
+        self
+    }
+
+    fn encryption_service(&self) -> Result<&temps_core::EncryptionService, EnvironmentError> {
+        self.encryption_service.as_deref().ok_or_else(|| {
+            EnvironmentError::Other("No encryption service to store secrets with".to_string())
+        })
+    }
+
     pub async fn compute_environment_url(&self, environment_slug: &str) -> String {
         let settings = self.config_service.get_settings().await.unwrap_or_default();
 
@@ -121,6 +143,87 @@ impl EnvironmentService {
         format!("{}.{}", environment_slug, base_domain)
     }
 
+    /// Give a new environment the hostname of the platform's domain pattern for it
+    ///
+    /// A hostname another environment or custom domain already has gets `-2`, `-3`...
+    /// appended to its first label. Returns the hostname when its certificate is to be
+    /// requested.
+    async fn assign_pattern_domain<C: ConnectionTrait>(
+        &self,
+        conn: &C,
+        project: &projects::Model,
+        environment: &environments::Model,
+    ) -> Result<Option<String>, DbErr> {
+        let settings = self.config_service.get_settings().await.unwrap_or_default();
+        let Some(pattern) = settings.environment_domains.pattern_for(&environment.slug) else {
+            return Ok(None);
+        };
+        let Some(hostname) = pattern.render(&project.slug, &environment.slug) else {
+            warn!(
+                "Domain pattern '{}' makes no valid hostname for environment {} of project {}",
+                pattern.pattern, environment.slug, project.slug
+            );
+            return Ok(None);
+        };
+
+        let mut assigned = None;
+        for suffix in 1..=MAX_DOMAIN_SUFFIX {
+            let candidate = suffixed_hostname(&hostname, suffix);
+            let taken = environment_domains::Entity::find()
+                .filter(environment_domains::Column::Domain.eq(&candidate))
+                .count(conn)
+                .await?
+                > 0
+                || project_custom_domains::Entity::find()
+                    .filter(project_custom_domains::Column::Domain.eq(&candidate))
+                    .count(conn)
+                    .await?
+                    > 0;
+            if !taken {
+                assigned = Some(candidate);
+                break;
+            }
+        }
+        let Some(hostname) = assigned else {
+            warn!(
+                "No free hostname for environment {} of project {}: {} and its suffixed variants are taken",
+                environment.slug, project.slug, hostname
+            );
+            return Ok(None);
+        };
+
+        environment_domains::ActiveModel {
+            environment_id: Set(environment.id),
+            domain: Set(hostname.clone()),
+            created_at: Set(chrono::Utc::now()),
+            ..Default::default()
+        }
+        .insert(conn)
+        .await?;
+        info!(
+            "Assigned {} to environment {} of project {}",
+            hostname, environment.slug, project.slug
+        );
+
+        Ok(pattern.provision_certificate.then_some(hostname))
+    }
+
+    /// Queue provisioning the certificate of a hostname assigned to an environment
+    async fn request_certificate(&self, hostname: String) {
+        let Some(queue_service) = &self.queue_service else {
+            return;
+        };
+        let job = Job::ProvisionCertificate(ProvisionCertificateJob {
+            domain: hostname.clone(),
+        });
+        if let Err(e) = queue_service.send(job).await {
+            warn!(
+                "Failed to request a certificate for {}: {}; provision it from the domains page",
+                hostname, e
+            );
+        }
+    }
+
     #[allow(clippy::too_many_arguments)]
     pub async fn create_environment(
         &self,
@@ -164,10 +267,57 @@ impl EnvironmentService {
                 memory_limit,
                 exposed_port: None,
                 automatic_deploy: false,
+                auto_deploy_tag_pattern: None,
                 performance_metrics_enabled: false,
                 session_recording_enabled: false,
                 replicas: 1,
                 security: None,
+                upstream_protocol: None,
+                request_timeout_seconds: None,
+                stream_timeout_seconds: None,
+                websocket_idle_timeout_seconds: None,
+                websocket_max_duration_seconds: None,
+                response_buffering: None,
+                connection_drain_seconds: None,
+                max_request_body_bytes: None,
+                max_request_header_bytes: None,
+                client_timeout_seconds: None,
+                write_timeout_seconds: None,
+                idle_timeout_seconds: None,
+                connect_timeout_seconds: None,
+                https_mode: None,
+                https_redirect_status: None,
+                stop_signal: None,
+                stop_timeout_seconds: None,
+                artifact_retention_days: None,
+                retained_images: None,
+                deploy_timeout_seconds: None,
+                static_site: None,
+                promotion: None,
+                build_cache: None,
+                builder: None,
+                shared_build: None,
+                build_command: None,
+                start_command: None,
+                entrypoint: None,
+                args: None,
+                build_platforms: None,
+                container_logs: None,
+                locale_defaults: None,
+                smoke_tests: None,
+                container_dns: None,
+                container_limits: None,
+                deploy_gate: None,
+                cdn_purge: None,
+                processes: None,
+                auto_retry: None,
+                build_priority: None,
+                protected_secrets: None,
+                concurrency_limit: None,
+                startup_probe: None,
+                config_reload: None,
+                exec_health_check: None,
+                health_thresholds: None,
             })),
             branch: Set(Some(branch)),
             ..Default::default()
@@ -185,8 +335,16 @@ impl EnvironmentService {
 
         new_domain.insert(&txn).await?;
 
+        let certificate_domain = self
+            .assign_pattern_domain(&txn, &project, &environment)
+            .await?;
+
         txn.commit().await?;
 
+        if let Some(hostname) = certificate_domain {
+            self.request_certificate(hostname).await;
+        }
+
         // Emit EnvironmentCreated job
         if let Some(queue_service) = &self.queue_service {
             let env_created_job = Job::EnvironmentCreated(EnvironmentCreatedJob {
@@ -410,10 +568,19 @@ impl EnvironmentService {
             .await
             .map_err(|e| EnvironmentError::Other(e.to_string()))?;
 
+        let certificate_domain = self
+            .assign_pattern_domain(&txn, &project, &environment)
+            .await
+            .map_err(|e| EnvironmentError::Other(e.to_string()))?;
+
         txn.commit()
             .await
             .map_err(|e| EnvironmentError::Other(e.to_string()))?;
 
+        if let Some(hostname) = certificate_domain {
+            self.request_certificate(hostname).await;
+        }
+
         Ok(environment)
     }
 
@@ -454,6 +621,14 @@ impl EnvironmentService {
         if let Some(automatic_deploy) = settings.automatic_deploy {
             deployment_config.automatic_deploy = automatic_deploy;
         }
+        if let Some(pattern) = settings.auto_deploy_tag_pattern {
+            let pattern = pattern.trim();
+            deployment_config.auto_deploy_tag_pattern = if pattern.is_empty() {
+                None
+            } else {
+                Some(pattern.to_string())
+            };
+        }
         if let Some(performance_metrics_enabled) = settings.performance_metrics_enabled {
             deployment_config.performance_metrics_enabled = performance_metrics_enabled;
         }
@@ -463,12 +638,172 @@ impl EnvironmentService {
         if let Some(security) = settings.security {
             deployment_config.security = Some(security);
         }
+        if settings.upstream_protocol.is_some() {
+            deployment_config.upstream_protocol = settings.upstream_protocol;
+        }
+        if settings.request_timeout_seconds.is_some() {
+            deployment_config.request_timeout_seconds = settings.request_timeout_seconds;
+        }
+        if settings.stream_timeout_seconds.is_some() {
+            deployment_config.stream_timeout_seconds = settings.stream_timeout_seconds;
+        }
+        if settings.websocket_idle_timeout_seconds.is_some() {
+            deployment_config.websocket_idle_timeout_seconds =
+                settings.websocket_idle_timeout_seconds;
+        }
+        if settings.websocket_max_duration_seconds.is_some() {
+            deployment_config.websocket_max_duration_seconds =
+                settings.websocket_max_duration_seconds;
+        }
+        if settings.response_buffering.is_some() {
+            deployment_config.response_buffering = settings.response_buffering;
+        }
+        if settings.connection_drain_seconds.is_some() {
+            deployment_config.connection_drain_seconds = settings.connection_drain_seconds;
+        }
+        if settings.max_request_body_bytes.is_some() {
+            deployment_config.max_request_body_bytes = settings.max_request_body_bytes;
+        }
+        if settings.max_request_header_bytes.is_some() {
+            deployment_config.max_request_header_bytes = settings.max_request_header_bytes;
+        }
+        if settings.client_timeout_seconds.is_some() {
+            deployment_config.client_timeout_seconds = settings.client_timeout_seconds;
+        }
+        if settings.write_timeout_seconds.is_some() {
+            deployment_config.write_timeout_seconds = settings.write_timeout_seconds;
+        }
+        if settings.idle_timeout_seconds.is_some() {
+            deployment_config.idle_timeout_seconds = settings.idle_timeout_seconds;
+        }
+        if settings.connect_timeout_seconds.is_some() {
+            deployment_config.connect_timeout_seconds = settings.connect_timeout_seconds;
+        }
+        if let Some(concurrency_limit) = settings.concurrency_limit {
+            deployment_config.concurrency_limit = Some(concurrency_limit);
+        }
+        if settings.https_mode.is_some() {
+            deployment_config.https_mode = settings.https_mode;
+        }
+        if settings.https_redirect_status.is_some() {
+            deployment_config.https_redirect_status = settings.https_redirect_status;
+        }
+        if settings.stop_signal.is_some() {
+            deployment_config.stop_signal = settings.stop_signal;
+        }
+        if settings.stop_timeout_seconds.is_some() {
+            deployment_config.stop_timeout_seconds = settings.stop_timeout_seconds;
+        }
+        if settings.artifact_retention_days.is_some() {
+            deployment_config.artifact_retention_days = settings.artifact_retention_days;
+        }
+        if settings.retained_images.is_some() {
+            deployment_config.retained_images = settings.retained_images;
+        }
+        if settings.deploy_timeout_seconds.is_some() {
+            deployment_config.deploy_timeout_seconds = settings.deploy_timeout_seconds;
+        }
+        if let Some(static_site) = settings.static_site {
+            deployment_config.static_site = Some(static_site);
+        }
+        if let Some(promotion) = settings.promotion {
+            deployment_config.promotion = Some(promotion);
+        }
+        if let Some(build_cache) = settings.build_cache {
+            deployment_config.build_cache = Some(build_cache);
+        }
+        if let Some(builder) = settings.builder {
+            deployment_config.builder = Some(builder);
+        }
+        if let Some(shared_build) = settings.shared_build {
+            deployment_config.shared_build = Some(shared_build);
+        }
+        if let Some(build_command) = settings.build_command {
+            deployment_config.build_command = Some(build_command);
+        }
+        if let Some(start_command) = settings.start_command {
+            deployment_config.start_command = Some(start_command);
+        }
+        if let Some(entrypoint) = settings.entrypoint {
+            deployment_config.entrypoint = Some(entrypoint);
+        }
+        if let Some(args) = settings.args {
+            deployment_config.args = Some(args);
+        }
+        if let Some(build_platforms) = settings.build_platforms {
+            deployment_config.build_platforms = Some(build_platforms);
+        }
+        if let Some(container_logs) = settings.container_logs {
+            deployment_config.container_logs = Some(container_logs);
+        }
+        if let Some(locale_defaults) = settings.locale_defaults {
+            deployment_config.locale_defaults = Some(locale_defaults);
+        }
+        if let Some(startup_probe) = settings.startup_probe {
+            deployment_config.startup_probe = Some(startup_probe);
+        }
+        if let Some(config_reload) = settings.config_reload {
+            deployment_config.config_reload = Some(config_reload);
+        }
+        if let Some(exec_health_check) = settings.exec_health_check {
+            deployment_config.exec_health_check = Some(exec_health_check);
+        }
+        if let Some(health_thresholds) = settings.health_thresholds {
+            deployment_config.health_thresholds = Some(health_thresholds);
+        }
+        if let Some(smoke_tests) = settings.smoke_tests {
+            deployment_config.smoke_tests = Some(smoke_tests);
+        }
+        if let Some(container_dns) = settings.container_dns {
+            deployment_config.container_dns = Some(container_dns);
+        }
+        if let Some(container_limits) = settings.container_limits {
+            deployment_config.container_limits = Some(container_limits);
+        }
+        if let Some(mut deploy_gate) = settings.deploy_gate {
+            deploy_gate
+                .seal(
+                    deployment_config.deploy_gate.as_ref(),
+                    self.encryption_service()?,
+                )
+                .map_err(|e| {
+                    EnvironmentError::InvalidInput(format!("Invalid deploy gate: {}", e))
+                })?;
+            deployment_config.deploy_gate = Some(deploy_gate);
+        }
+        if let Some(mut cdn_purge) = settings.cdn_purge {
+            cdn_purge
+                .seal(
+                    deployment_config.cdn_purge.as_ref(),
+                    self.encryption_service()?,
+                )
+                .map_err(|e| EnvironmentError::InvalidInput(format!("Invalid CDN purge: {}", e)))?;
+            deployment_config.cdn_purge = Some(cdn_purge);
+        }
+        if let Some(processes) = settings.processes {
+            deployment_config.processes = Some(processes);
+        }
+        if let Some(auto_retry) = settings.auto_retry {
+            deployment_config.auto_retry = Some(auto_retry);
+        }
+        if settings.build_priority.is_some() {
+            deployment_config.build_priority = settings.build_priority;
+        }
+        if settings.protected_secrets.is_some() {
+            deployment_config.protected_secrets = settings.protected_secrets;
+        }
 
         // Validate the deployment config
         deployment_config.validate().map_err(|e| {
             EnvironmentError::InvalidInput(format!("Invalid deployment config: {}", e))
         })?;
 
+        if let Some(routing_rules) = settings.routing_rules {
+            self.validate_routing_rules(&environment, &routing_rules)
+                .await?;
+            active_model.routing_rules = Set(routing_rules);
+        }
+
         active_model.deployment_config = Set(Some(deployment_config));
         active_model.branch = Set(settings.branch);
         active_model.updated_at = Set(chrono::Utc::now());
@@ -481,6 +816,40 @@ impl EnvironmentService {
         Ok(updated_environment)
     }
 
+    /// Check that routing rules only send requests to other environments of the
+    /// same project
+    async fn validate_routing_rules(
+        &self,
+        environment: &environments::Model,
+        routing_rules: &RoutingRuleList,
+    ) -> Result<(), EnvironmentError> {
+        routing_rules
+            .validate()
+            .map_err(EnvironmentError::InvalidInput)?;
+
+        let targets = routing_rules.target_environments();
+        if targets.contains(&environment.id) {
+            return Err(EnvironmentError::InvalidInput(
+                "A routing rule can't target the environment it belongs to".to_string(),
+            ));
+        }
+        if targets.is_empty() {
+            return Ok(());
+        }
+        let found = environments::Entity::find()
+            .filter(environments::Column::ProjectId.eq(environment.project_id))
+            .filter(environments::Column::Id.is_in(targets.clone()))
+            .filter(environments::Column::DeletedAt.is_null())
+            .count(self.db.as_ref())
+            .await?;
+        if found as usize != targets.len() {
+            return Err(EnvironmentError::InvalidInput(
+                "Routing rules can only target environments of the same project".to_string(),
+            ));
+        }
+        Ok(())
+    }
+
     pub async fn get_environment_domains(
         &self,
         project_id: i32,
@@ -619,6 +988,17 @@ impl EnvironmentService {
     }
 }
 
+/// The hostname with `-<suffix>` appended to its first label; unchanged for suffix 1
+fn suffixed_hostname(hostname: &str, suffix: u32) -> String {
+    if suffix <= 1 {
+        return hostname.to_string();
+    }
+    match hostname.split_once('.') {
+        Some((label, rest)) => format!("{}-{}.{}", label, suffix, rest),
+        None => format!("{}-{}", hostname, suffix),
+    }
+}
+
 // #[cfg(test)]
 // mod tests {
 //     use super::*;
@@ -1,5 +1,10 @@
+pub mod dotenv;
 pub mod env_var_service;
 pub mod environment_service;
+pub mod secret_access;
+pub use dotenv::{parse_dotenv, render_dotenv, DotenvError};
 pub use env_var_service::*;
 pub use environment_service::*;
+pub use secret_access::SecretScope;
 mod types;
+pub use types::{EnvVarImportResult, EnvVarWithEnvironments};
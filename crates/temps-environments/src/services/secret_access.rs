@@ -0,0 +1,296 @@
+//! Secret access scoping
+//!
+//! Variables of protected environments (by default, production) are secrets that
+//! only users with the protected secrets permissions may read or change. A variable
+//! is protected when any environment it is used by is protected, since its value is
+//! shared by all of them. Where it can't be told which environments a request
+//! touches, it is treated as protected. Shared variables are used by every
+//! environment of the project.
+
+use std::collections::{BTreeSet, HashMap};
+
+use temps_entities::{env_var_environments, env_vars, environments};
+
+/// A variable and the environments it is used by
+#[derive(Debug, Clone)]
+struct ScopedVariable {
+    key: String,
+    environment_ids: BTreeSet<i32>,
+    shared: bool,
+}
+
+/// Which of a project's environments and variables are protected
+#[derive(Debug, Clone, Default)]
+pub struct SecretScope {
+    environment_ids: BTreeSet<i32>,
+    protected_environment_ids: BTreeSet<i32>,
+    variables: HashMap<i32, ScopedVariable>,
+}
+
+impl SecretScope {
+    pub(crate) fn new(
+        environments: &[environments::Model],
+        variables: Vec<(env_vars::Model, Vec<env_var_environments::Model>)>,
+    ) -> Self {
+        let environment_ids: BTreeSet<i32> = environments.iter().map(|env| env.id).collect();
+        Self {
+            protected_environment_ids: environments
+                .iter()
+                .filter(|env| env.has_protected_secrets())
+                .map(|env| env.id)
+                .collect(),
+            variables: variables
+                .into_iter()
+                .map(|(var, links)| {
+                    let scoped = ScopedVariable {
+                        key: var.key,
+                        environment_ids: if var.shared {
+                            environment_ids.clone()
+                        } else {
+                            links.iter().map(|link| link.environment_id).collect()
+                        },
+                        shared: var.shared,
+                    };
+                    (var.id, scoped)
+                })
+                .collect(),
+            environment_ids,
+        }
+    }
+
+    /// Whether any of the environments is protected
+    ///
+    /// Environments that aren't part of the project, and an empty selection, count as
+    /// protected.
+    pub fn covers_environments(&self, environment_ids: &[i32]) -> bool {
+        environment_ids.is_empty()
+            || environment_ids.iter().any(|id| {
+                !self.environment_ids.contains(id) || self.protected_environment_ids.contains(id)
+            })
+    }
+
+    /// Whether a shared variable would be protected: any of the project's environments
+    /// is, or the project has none yet
+    pub fn covers_project(&self) -> bool {
+        let ids: Vec<i32> = self.environment_ids.iter().copied().collect();
+        self.covers_environments(&ids)
+    }
+
+    /// Whether the shared variable with this key is protected
+    pub fn covers_shared_key(&self, key: &str) -> bool {
+        self.variables
+            .iter()
+            .any(|(id, var)| var.shared && var.key == key && self.covers_variable(*id))
+    }
+
+    /// All environments of the project, which shared variables are used by
+    pub fn project_environments(&self) -> Vec<i32> {
+        self.environment_ids.iter().copied().collect()
+    }
+
+    /// Whether a variable is protected
+    ///
+    /// Unknown variables, and variables not used by any environment, count as
+    /// protected.
+    pub fn covers_variable(&self, var_id: i32) -> bool {
+        match self.variables.get(&var_id) {
+            Some(var) => {
+                let ids: Vec<i32> = var.environment_ids.iter().copied().collect();
+                self.covers_environments(&ids)
+            }
+            None => true,
+        }
+    }
+
+    /// Whether exporting an environment reveals protected values: the environment is
+    /// protected, or one of its variables is shared with a protected environment
+    pub fn covers_environment_variables(&self, environment_id: i32) -> bool {
+        self.covers_environments(&[environment_id])
+            || self
+                .variables
+                .iter()
+                .filter(|(_, var)| var.environment_ids.contains(&environment_id))
+                .any(|(id, _)| self.covers_variable(*id))
+    }
+
+    /// Whether importing these keys into the environments changes protected values
+    ///
+    /// With `overwrite`, an import replaces the value of existing variables, which
+    /// changes it in every environment the variable is shared with. Shared variables
+    /// aren't replaced: the imported value overrides them in the selected environments.
+    pub fn covers_import(&self, environment_ids: &[i32], keys: &[String], overwrite: bool) -> bool {
+        if self.covers_environments(environment_ids) {
+            return true;
+        }
+        overwrite
+            && self.variables.iter().any(|(id, var)| {
+                !var.shared
+                    && keys.contains(&var.key)
+                    && environment_ids
+                        .iter()
+                        .any(|env_id| var.environment_ids.contains(env_id))
+                    && self.covers_variable(*id)
+            })
+    }
+
+    /// Key of a variable
+    pub fn variable_key(&self, var_id: i32) -> Option<&str> {
+        self.variables.get(&var_id).map(|var| var.key.as_str())
+    }
+
+    /// Environments a variable is used by
+    pub fn variable_environments(&self, var_id: i32) -> Vec<i32> {
+        self.variables
+            .get(&var_id)
+            .map(|var| var.environment_ids.iter().copied().collect())
+            .unwrap_or_default()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use temps_entities::deployment_config::DeploymentConfig;
+    use temps_entities::upstream_config::UpstreamList;
+
+    fn environment(id: i32, name: &str, protected_secrets: Option<bool>) -> environments::Model {
+        environments::Model {
+            id,
+            name: name.to_string(),
+            slug: name.to_lowercase(),
+            subdomain: String::new(),
+            last_deployment: None,
+            host: String::new(),
+            upstreams: UpstreamList::new(),
+            created_at: chrono::Utc::now(),
+            updated_at: chrono::Utc::now(),
+            project_id: 1,
+            current_deployment_id: None,
+            branch: None,
+            deleted_at: None,
+            deployment_config: Some(DeploymentConfig {
+                protected_secrets,
+                ..Default::default()
+            }),
+            is_preview: false,
+            routing_rules: Default::default(),
+        }
+    }
+
+    fn variable(
+        id: i32,
+        key: &str,
+        environment_ids: &[i32],
+    ) -> (env_vars::Model, Vec<env_var_environments::Model>) {
+        let var = env_vars::Model {
+            id,
+            project_id: 1,
+            environment_id: None,
+            key: key.to_string(),
+            value: "value".to_string(),
+            created_at: chrono::Utc::now(),
+            updated_at: chrono::Utc::now(),
+            include_in_preview: true,
+            build_only: false,
+            shared: false,
+        };
+        let links = environment_ids
+            .iter()
+            .map(|environment_id| env_var_environments::Model {
+                id: id * 100 + environment_id,
+                env_var_id: id,
+                environment_id: *environment_id,
+                created_at: chrono::Utc::now(),
+            })
+            .collect();
+        (var, links)
+    }
+
+    /// Production (1, protected by default), staging (2) and an explicitly protected
+    /// "billing" environment (3)
+    fn scope() -> SecretScope {
+        SecretScope::new(
+            &[
+                environment(1, "Production", None),
+                environment(2, "Staging", None),
+                environment(3, "Billing", Some(true)),
+            ],
+            vec![
+                variable(10, "DATABASE_URL", &[1]),
+                variable(11, "FEATURE_FLAGS", &[2]),
+                variable(12, "SENTRY_DSN", &[1, 2]),
+                variable(13, "ORPHANED", &[]),
+            ],
+        )
+    }
+
+    #[test]
+    fn test_production_is_protected_by_default() {
+        assert!(environment(1, "Production", None).has_protected_secrets());
+        assert!(!environment(1, "Production", Some(false)).has_protected_secrets());
+        assert!(!environment(2, "Staging", None).has_protected_secrets());
+    }
+
+    #[test]
+    fn test_covers_environments() {
+        let scope = scope();
+        assert!(!scope.covers_environments(&[2]));
+        assert!(scope.covers_environments(&[1]));
+        assert!(scope.covers_environments(&[2, 3]));
+        // Ambiguous scopes fail closed
+        assert!(scope.covers_environments(&[]));
+        assert!(scope.covers_environments(&[99]));
+    }
+
+    #[test]
+    fn test_shared_variables_are_protected() {
+        let scope = scope();
+        assert!(scope.covers_variable(10));
+        assert!(!scope.covers_variable(11));
+        assert!(scope.covers_variable(12));
+        assert!(scope.covers_variable(13));
+        assert!(scope.covers_variable(99));
+
+        // Staging shares SENTRY_DSN with production
+        assert!(scope.covers_environment_variables(2));
+        assert_eq!(scope.variable_environments(12), vec![1, 2]);
+    }
+
+    #[test]
+    fn test_project_shared_variables_reach_every_environment() {
+        let (mut shared, _) = variable(20, "API_URL", &[]);
+        shared.shared = true;
+        let staging_only = SecretScope::new(
+            &[environment(2, "Staging", None)],
+            vec![(shared.clone(), vec![])],
+        );
+        assert!(!staging_only.covers_variable(20));
+
+        let scope = SecretScope::new(
+            &[
+                environment(1, "Production", None),
+                environment(2, "Staging", None),
+            ],
+            vec![(shared, vec![])],
+        );
+        assert!(scope.covers_variable(20));
+        assert!(scope.covers_project());
+        assert!(scope.covers_shared_key("API_URL"));
+        assert!(!staging_only.covers_project());
+        assert!(scope.covers_environment_variables(2));
+        assert_eq!(scope.variable_environments(20), vec![1, 2]);
+        // Importing overrides the shared variable in staging, production keeps it
+        assert!(!scope.covers_import(&[2], &["API_URL".to_string()], true));
+    }
+
+    #[test]
+    fn test_covers_import() {
+        let scope = scope();
+        let keys = vec!["SENTRY_DSN".to_string(), "NEW_KEY".to_string()];
+        assert!(!scope.covers_import(&[2], &keys, false));
+        // Overwriting SENTRY_DSN would change production's value too
+        assert!(scope.covers_import(&[2], &keys, true));
+        assert!(!scope.covers_import(&[2], &["FEATURE_FLAGS".to_string()], true));
+        assert!(scope.covers_import(&[1], &["NEW_KEY".to_string()], false));
+    }
+}
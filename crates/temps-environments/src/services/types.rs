@@ -20,4 +20,22 @@ pub struct EnvVarWithEnvironments {
     pub updated_at: UtcDateTime,
     pub environments: Vec<EnvVarEnvironment>,
     pub include_in_preview: bool,
+    pub build_only: bool,
+    pub shared: bool,
+}
+
+/// Outcome of a bulk import of environment variables, by key
+///
+/// A key shows up in every list that applies to it, e.g. when it was updated in one
+/// of the selected environments and created in another.
+#[derive(Debug, Default, Serialize)]
+pub struct EnvVarImportResult {
+    /// Keys added to environments that did not have them
+    pub created: Vec<String>,
+    /// Keys whose existing value was replaced
+    pub updated: Vec<String>,
+    /// Keys that already had the imported value
+    pub unchanged: Vec<String>,
+    /// Keys that already exist with a different value and were kept as they are
+    pub skipped: Vec<String>,
 }
@@ -44,3 +44,88 @@ impl AuditOperation for PipelineTriggeredAudit {
             .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
     }
 }
+
+/// A webhook delivery that failed signature verification
+#[derive(Debug, Clone, Serialize)]
+pub struct WebhookRejectedAudit {
+    /// Attributed to the user who set up the webhook
+    pub context: AuditContext,
+    pub repository_id: i32,
+    pub repository: String,
+    pub provider: String,
+    pub reason: String,
+}
+
+impl AuditOperation for WebhookRejectedAudit {
+    fn operation_type(&self) -> String {
+        "GIT_WEBHOOK_REJECTED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+/// Change to a repository's deploy key
+#[derive(Debug, Clone, Copy, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum DeployKeyAction {
+    Generated,
+    Rotated,
+    Updated,
+    Revoked,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct DeployKeyAudit {
+    pub context: AuditContext,
+    pub action: DeployKeyAction,
+    pub repository_id: i32,
+    pub repository: String,
+    pub fingerprint: String,
+    /// Fingerprint of the key a rotation replaced, to remove from the git host
+    pub replaced_fingerprint: Option<String>,
+    pub use_for_builds: bool,
+}
+
+impl AuditOperation for DeployKeyAudit {
+    fn operation_type(&self) -> String {
+        match self.action {
+            DeployKeyAction::Generated => "GIT_DEPLOY_KEY_GENERATED",
+            DeployKeyAction::Rotated => "GIT_DEPLOY_KEY_ROTATED",
+            DeployKeyAction::Updated => "GIT_DEPLOY_KEY_UPDATED",
+            DeployKeyAction::Revoked => "GIT_DEPLOY_KEY_REVOKED",
+        }
+        .to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
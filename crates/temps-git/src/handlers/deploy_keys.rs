@@ -0,0 +1,377 @@
+//! Deploy Key Handlers
+//!
+//! Endpoints to manage a repository's SSH deploy key: generate or rotate it, show its
+//! public key to add to the git host, switch builds between SSH and token fetches, and
+//! revoke it. Every change is recorded in the audit log.
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    routing::get,
+    Extension, Json, Router,
+};
+use serde::{Deserialize, Serialize};
+use temps_auth::{permission_check, Permission, RequireAuth};
+use temps_core::problemdetails::{new as problem_new, Problem};
+use temps_core::{AuditLogger, RequestMetadata, UtcDateTime};
+use temps_entities::{repositories, repository_deploy_keys};
+use tracing::{error, info};
+use utoipa::{OpenApi, ToSchema};
+
+use super::audit::{AuditContext, DeployKeyAction, DeployKeyAudit};
+use super::types::GitAppState as AppState;
+use crate::services::deploy_keys::DeployKeyError;
+
+impl From<DeployKeyError> for Problem {
+    fn from(error: DeployKeyError) -> Self {
+        match error {
+            DeployKeyError::DatabaseError(e) => problem_new(StatusCode::INTERNAL_SERVER_ERROR)
+                .with_title("Database Error")
+                .with_detail(e.to_string()),
+            DeployKeyError::RepositoryNotFound(id) => problem_new(StatusCode::NOT_FOUND)
+                .with_title("Repository Not Found")
+                .with_detail(format!("Repository {} was not found", id)),
+            DeployKeyError::NotConfigured(id) => problem_new(StatusCode::NOT_FOUND)
+                .with_title("Deploy Key Not Configured")
+                .with_detail(format!("No deploy key is set up for repository {}", id)),
+            DeployKeyError::NoSshUrl(id) => problem_new(StatusCode::BAD_REQUEST)
+                .with_title("No SSH URL")
+                .with_detail(format!(
+                    "Repository {} has no SSH clone URL; sync the repository first",
+                    id
+                )),
+            DeployKeyError::InvalidKnownHosts(msg) => problem_new(StatusCode::BAD_REQUEST)
+                .with_title("Invalid Known Hosts")
+                .with_detail(msg),
+            DeployKeyError::EncryptionError(msg) => problem_new(StatusCode::INTERNAL_SERVER_ERROR)
+                .with_title("Encryption Error")
+                .with_detail(msg),
+            DeployKeyError::SshError(msg) => problem_new(StatusCode::INTERNAL_SERVER_ERROR)
+                .with_title("SSH Error")
+                .with_detail(msg),
+        }
+    }
+}
+
+/// A repository's SSH deploy key
+#[derive(Serialize, ToSchema)]
+pub struct DeployKeyResponse {
+    pub repository_id: i32,
+    /// Public key to add to the repository at the git host, read-only
+    #[schema(example = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI... temps-deploy-acme/web")]
+    pub public_key: String,
+    #[schema(example = "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8")]
+    pub fingerprint: String,
+    /// Host keys the git host has to present; fetches refuse any other
+    pub known_hosts: String,
+    /// Whether builds fetch the repository over SSH with this key rather than with the
+    /// git provider connection's token
+    pub use_for_builds: bool,
+    pub created_by: i32,
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub last_used_at: Option<UtcDateTime>,
+    #[schema(value_type = String, format = "date-time")]
+    pub created_at: UtcDateTime,
+    #[schema(value_type = String, format = "date-time")]
+    pub updated_at: UtcDateTime,
+}
+
+impl From<repository_deploy_keys::Model> for DeployKeyResponse {
+    fn from(deploy_key: repository_deploy_keys::Model) -> Self {
+        Self {
+            repository_id: deploy_key.repository_id,
+            public_key: deploy_key.public_key,
+            fingerprint: deploy_key.fingerprint,
+            known_hosts: deploy_key.known_hosts,
+            use_for_builds: deploy_key.use_for_builds,
+            created_by: deploy_key.created_by,
+            last_used_at: deploy_key.last_used_at,
+            created_at: deploy_key.created_at,
+            updated_at: deploy_key.updated_at,
+        }
+    }
+}
+
+#[derive(Deserialize, ToSchema)]
+pub struct GenerateDeployKeyRequest {
+    /// known_hosts lines of the git host's SSH server. When not set, the previous key's
+    /// are kept, or the host is asked for them for a repository's first key
+    #[serde(default)]
+    pub known_hosts: Option<String>,
+}
+
+#[derive(Deserialize, ToSchema)]
+pub struct UpdateDeployKeyRequest {
+    /// Fetch the repository over SSH with the deploy key (true) or with the connection's
+    /// token (false)
+    pub use_for_builds: Option<bool>,
+    /// Replaces the pinned host keys of the git host
+    pub known_hosts: Option<String>,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(
+        get_deploy_key,
+        generate_deploy_key,
+        update_deploy_key,
+        revoke_deploy_key
+    ),
+    components(schemas(
+        DeployKeyResponse,
+        GenerateDeployKeyRequest,
+        UpdateDeployKeyRequest
+    )),
+    tags(
+        (name = "Repositories", description = "Repository management endpoints")
+    )
+)]
+pub struct DeployKeysApiDoc;
+
+pub fn configure_routes() -> Router<Arc<AppState>> {
+    Router::new().route(
+        "/repositories/{repository_id}/deploy-key",
+        get(get_deploy_key)
+            .post(generate_deploy_key)
+            .patch(update_deploy_key)
+            .delete(revoke_deploy_key),
+    )
+}
+
+async fn audit_deploy_key(
+    audit_service: &dyn AuditLogger,
+    user_id: i32,
+    metadata: &RequestMetadata,
+    action: DeployKeyAction,
+    repository: &repositories::Model,
+    deploy_key: &repository_deploy_keys::Model,
+    replaced_fingerprint: Option<String>,
+) {
+    let audit = DeployKeyAudit {
+        context: AuditContext {
+            user_id,
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        action,
+        repository_id: repository.id,
+        repository: repository.full_name.clone(),
+        fingerprint: deploy_key.fingerprint.clone(),
+        replaced_fingerprint,
+        use_for_builds: deploy_key.use_for_builds,
+    };
+    if let Err(e) = audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+}
+
+/// Get a repository's deploy key
+#[utoipa::path(
+    get,
+    path = "/repositories/{repository_id}/deploy-key",
+    params(("repository_id" = i32, Path, description = "Repository ID")),
+    responses(
+        (status = 200, description = "Repository deploy key", body = DeployKeyResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No deploy key is set up"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Repositories",
+    security(("bearer_auth" = []))
+)]
+pub async fn get_deploy_key(
+    State(state): State<Arc<AppState>>,
+    RequireAuth(auth): RequireAuth,
+    Path(repository_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_check!(auth, Permission::GitRepositoriesRead);
+
+    let deploy_key = state
+        .deploy_key_service
+        .get_deploy_key(repository_id)
+        .await?
+        .ok_or(DeployKeyError::NotConfigured(repository_id))?;
+    Ok(Json(DeployKeyResponse::from(deploy_key)))
+}
+
+/// Generate or rotate a repository's deploy key
+///
+/// Creates a new ed25519 keypair; add its public key to the repository at the git host
+/// as a read-only deploy key. Replacing a key keeps whether builds use it, so add the
+/// new public key to the host before the next build and remove the old one.
+#[utoipa::path(
+    post,
+    path = "/repositories/{repository_id}/deploy-key",
+    request_body = GenerateDeployKeyRequest,
+    params(("repository_id" = i32, Path, description = "Repository ID")),
+    responses(
+        (status = 201, description = "Deploy key generated", body = DeployKeyResponse),
+        (status = 400, description = "Invalid known_hosts or no SSH clone URL"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Repository not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Repositories",
+    security(("bearer_auth" = []))
+)]
+pub async fn generate_deploy_key(
+    State(state): State<Arc<AppState>>,
+    RequireAuth(auth): RequireAuth,
+    Path(repository_id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<GenerateDeployKeyRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_check!(auth, Permission::GitConnectionsWrite);
+
+    let repository = state
+        .deploy_key_service
+        .get_repository(repository_id)
+        .await?;
+    let (deploy_key, replaced_fingerprint) = state
+        .deploy_key_service
+        .generate_key(
+            repository_id,
+            auth.user_id(),
+            request.known_hosts.as_deref(),
+        )
+        .await?;
+    info!(
+        "Generated deploy key {} for repository {} by user {}",
+        deploy_key.fingerprint,
+        repository.full_name,
+        auth.user_id()
+    );
+
+    let action = match replaced_fingerprint {
+        Some(_) => DeployKeyAction::Rotated,
+        None => DeployKeyAction::Generated,
+    };
+    audit_deploy_key(
+        state.audit_service.as_ref(),
+        auth.user_id(),
+        &metadata,
+        action,
+        &repository,
+        &deploy_key,
+        replaced_fingerprint,
+    )
+    .await;
+
+    Ok((
+        StatusCode::CREATED,
+        Json(DeployKeyResponse::from(deploy_key)),
+    ))
+}
+
+/// Update a repository's deploy key
+///
+/// Turns fetching over SSH with the key on or off for the repository's builds, or
+/// replaces the git host's pinned host keys.
+#[utoipa::path(
+    patch,
+    path = "/repositories/{repository_id}/deploy-key",
+    request_body = UpdateDeployKeyRequest,
+    params(("repository_id" = i32, Path, description = "Repository ID")),
+    responses(
+        (status = 200, description = "Deploy key updated", body = DeployKeyResponse),
+        (status = 400, description = "Invalid known_hosts"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No deploy key is set up"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Repositories",
+    security(("bearer_auth" = []))
+)]
+pub async fn update_deploy_key(
+    State(state): State<Arc<AppState>>,
+    RequireAuth(auth): RequireAuth,
+    Path(repository_id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<UpdateDeployKeyRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_check!(auth, Permission::GitConnectionsWrite);
+
+    let repository = state
+        .deploy_key_service
+        .get_repository(repository_id)
+        .await?;
+    let deploy_key = state
+        .deploy_key_service
+        .update_deploy_key(
+            repository_id,
+            request.use_for_builds,
+            request.known_hosts.as_deref(),
+        )
+        .await?;
+
+    audit_deploy_key(
+        state.audit_service.as_ref(),
+        auth.user_id(),
+        &metadata,
+        DeployKeyAction::Updated,
+        &repository,
+        &deploy_key,
+        None,
+    )
+    .await;
+
+    Ok(Json(DeployKeyResponse::from(deploy_key)))
+}
+
+/// Revoke a repository's deploy key
+///
+/// Deletes the key; builds fetch the repository with the connection's token again.
+/// Remove the public key from the git host as well.
+#[utoipa::path(
+    delete,
+    path = "/repositories/{repository_id}/deploy-key",
+    params(("repository_id" = i32, Path, description = "Repository ID")),
+    responses(
+        (status = 204, description = "Deploy key revoked"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No deploy key is set up"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Repositories",
+    security(("bearer_auth" = []))
+)]
+pub async fn revoke_deploy_key(
+    State(state): State<Arc<AppState>>,
+    RequireAuth(auth): RequireAuth,
+    Path(repository_id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_check!(auth, Permission::GitConnectionsWrite);
+
+    let repository = state
+        .deploy_key_service
+        .get_repository(repository_id)
+        .await?;
+    let deploy_key = state.deploy_key_service.revoke_key(repository_id).await?;
+    info!(
+        "Revoked deploy key {} of repository {} by user {}",
+        deploy_key.fingerprint,
+        repository.full_name,
+        auth.user_id()
+    );
+
+    audit_deploy_key(
+        state.audit_service.as_ref(),
+        auth.user_id(),
+        &metadata,
+        DeployKeyAction::Revoked,
+        &repository,
+        &deploy_key,
+        None,
+    )
+    .await;
+
+    Ok(StatusCode::NO_CONTENT)
+}
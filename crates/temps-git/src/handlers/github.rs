@@ -18,7 +18,9 @@ use temps_core::problemdetails::{new as problem_new, Problem};
 // use crate::services::project::crud::ProjectCrud;
 // use crate::services::project::pipelines::ProjectPipelines;
 
+use crate::services::git_provider::GitProviderType;
 use crate::services::github::GithubAppServiceError;
+use crate::services::webhook_verification::push_changed_paths;
 use octocrab::models::webhook_events::payload::InstallationRepositoriesWebhookEventAction;
 use octocrab::models::webhook_events::{EventInstallation, WebhookEvent, WebhookEventPayload};
 
@@ -277,7 +279,16 @@ async fn github_webhook_events(
     match webhook_event.specific {
         WebhookEventPayload::Push(push_event) => {
             info!("Push event received");
-            handle_push_event(&state, webhook_event_clone, push_event, installation_id).await;
+            let payload = serde_json::from_slice::<serde_json::Value>(&body).unwrap_or_default();
+            let changed_paths = push_changed_paths(&GitProviderType::GitHub, &payload);
+            handle_push_event(
+                &state,
+                webhook_event_clone,
+                push_event,
+                installation_id,
+                changed_paths,
+            )
+            .await;
         }
         WebhookEventPayload::InstallationRepositories(installation_repos) => {
             info!("Installation repositories event received");
@@ -309,6 +320,7 @@ async fn handle_push_event(
     webhook_event: WebhookEvent,
     push_event: Box<octocrab::models::webhook_events::payload::PushWebhookEventPayload>,
     installation_id: i32,
+    changed_paths: Option<Vec<String>>,
 ) {
     let repo = webhook_event.repository.unwrap();
     let repo_owner = repo.owner.unwrap().login;
@@ -342,7 +354,14 @@ async fn handle_push_event(
     // Use git provider manager to handle the push event
     if let Err(e) = state
         .git_provider_manager
-        .handle_push_event(repo_owner, repo_name, branch, tag, push_event.after.clone())
+        .handle_push_event(
+            repo_owner,
+            repo_name,
+            branch,
+            tag,
+            push_event.after.clone(),
+            changed_paths,
+        )
         .await
     {
         error!(
@@ -1,9 +1,11 @@
 pub mod audit;
 pub mod base;
+pub mod deploy_keys;
 pub mod github;
 pub mod gitlab;
 pub mod public;
 pub mod repositories;
+pub mod repository_webhooks;
 pub mod types;
 pub mod update_token;
 
@@ -13,13 +15,17 @@ use std::sync::Arc;
 
 // Re-export the API documentation
 pub use base::GitProvidersApiDoc;
+pub use deploy_keys::DeployKeysApiDoc;
 pub use public::PublicRepositoriesApiDoc;
+pub use repository_webhooks::RepositoryWebhooksApiDoc;
 
-/// Configure all routes for git providers including base, GitHub, GitLab, and public repos
+/// Configure all routes for git providers including base, GitHub, GitLab, public repos, repository webhooks and deploy keys
 pub fn configure_routes() -> Router<Arc<AppState>> {
     // Combine all route modules
     base::configure_routes()
         .merge(github::configure_routes())
         .merge(gitlab::configure_routes())
         .merge(public::configure_routes())
+        .merge(repository_webhooks::configure_routes())
+        .merge(deploy_keys::configure_routes())
 }
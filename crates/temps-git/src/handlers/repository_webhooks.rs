@@ -0,0 +1,378 @@
+//! Repository Webhook Handlers
+//!
+//! Endpoints to set up a repository's push webhook secret, and the receiver the git
+//! provider sends push events to. Deliveries are verified with the repository's
+//! secret before they trigger pipelines; rejected ones get a 401 and an audit log.
+
+use std::sync::Arc;
+
+use axum::{
+    body::Bytes,
+    extract::{Path, State},
+    http::{HeaderMap, StatusCode},
+    response::IntoResponse,
+    routing::{get, post},
+    Extension, Json, Router,
+};
+use serde::Serialize;
+use temps_auth::{permission_check, Permission, RequireAuth};
+use temps_core::problemdetails::{new as problem_new, Problem};
+use temps_core::{RequestMetadata, UtcDateTime};
+use temps_entities::repository_webhooks;
+use tracing::{error, info, warn};
+use utoipa::{OpenApi, ToSchema};
+
+use super::audit::{AuditContext, WebhookRejectedAudit};
+use super::types::GitAppState as AppState;
+use crate::services::repository_webhooks::RepositoryWebhookError;
+use crate::services::webhook_verification::{parse_push_event, push_changed_paths};
+
+impl From<RepositoryWebhookError> for Problem {
+    fn from(error: RepositoryWebhookError) -> Self {
+        match error {
+            RepositoryWebhookError::DatabaseError(e) => {
+                problem_new(StatusCode::INTERNAL_SERVER_ERROR)
+                    .with_title("Database Error")
+                    .with_detail(e.to_string())
+            }
+            RepositoryWebhookError::RepositoryNotFound(id) => problem_new(StatusCode::NOT_FOUND)
+                .with_title("Repository Not Found")
+                .with_detail(format!("Repository {} was not found", id)),
+            RepositoryWebhookError::NotConfigured(id) => problem_new(StatusCode::NOT_FOUND)
+                .with_title("Webhook Not Configured")
+                .with_detail(format!("No webhook secret is set up for repository {}", id)),
+            RepositoryWebhookError::UnsupportedProvider(provider) => {
+                problem_new(StatusCode::BAD_REQUEST)
+                    .with_title("Unsupported Provider")
+                    .with_detail(format!(
+                        "Webhooks of {} repositories can't be verified",
+                        provider
+                    ))
+            }
+            RepositoryWebhookError::EncryptionError(msg) => {
+                problem_new(StatusCode::INTERNAL_SERVER_ERROR)
+                    .with_title("Encryption Error")
+                    .with_detail(msg)
+            }
+            RepositoryWebhookError::Rejected { .. } => problem_new(StatusCode::UNAUTHORIZED)
+                .with_title("Invalid Webhook Signature")
+                .with_detail("The webhook signature validation failed"),
+        }
+    }
+}
+
+/// A repository's push webhook
+#[derive(Serialize, ToSchema)]
+pub struct RepositoryWebhookResponse {
+    pub repository_id: i32,
+    /// URL to configure as the webhook's payload URL at the git provider
+    #[schema(example = "https://temps.example.com/api/webhook/git/repositories/42/events")]
+    pub webhook_url: String,
+    pub created_by: i32,
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub last_delivery_at: Option<UtcDateTime>,
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub last_rejected_at: Option<UtcDateTime>,
+    /// Deliveries rejected since the secret was generated
+    pub rejected_count: i64,
+    #[schema(value_type = String, format = "date-time")]
+    pub created_at: UtcDateTime,
+    #[schema(value_type = String, format = "date-time")]
+    pub updated_at: UtcDateTime,
+}
+
+/// A newly generated webhook secret, only shown once
+#[derive(Serialize, ToSchema)]
+pub struct RepositoryWebhookSecretResponse {
+    #[serde(flatten)]
+    pub webhook: RepositoryWebhookResponse,
+    /// Secret to configure at the git provider; sent as the token for GitLab and used
+    /// to sign deliveries for GitHub, Gitea and Bitbucket
+    pub secret: String,
+}
+
+#[derive(Serialize, ToSchema)]
+pub struct WebhookDeliveryResponse {
+    pub message: String,
+    /// Pushed branches and tags that pipelines were triggered for
+    pub triggered: usize,
+}
+
+#[derive(OpenApi)]
+#[openapi(
+    paths(
+        rotate_webhook_secret,
+        get_repository_webhook,
+        delete_webhook_secret,
+        repository_webhook_events
+    ),
+    components(schemas(
+        RepositoryWebhookResponse,
+        RepositoryWebhookSecretResponse,
+        WebhookDeliveryResponse
+    )),
+    tags(
+        (name = "Repositories", description = "Repository management endpoints")
+    )
+)]
+pub struct RepositoryWebhooksApiDoc;
+
+pub fn configure_routes() -> Router<Arc<AppState>> {
+    Router::new()
+        .route(
+            "/repositories/{repository_id}/webhook",
+            get(get_repository_webhook),
+        )
+        .route(
+            "/repositories/{repository_id}/webhook-secret",
+            post(rotate_webhook_secret).delete(delete_webhook_secret),
+        )
+        .route(
+            "/webhook/git/repositories/{repository_id}/events",
+            post(repository_webhook_events),
+        )
+}
+
+async fn webhook_response(
+    state: &AppState,
+    webhook: repository_webhooks::Model,
+) -> Result<RepositoryWebhookResponse, Problem> {
+    let external_url = state
+        .config_service
+        .get_external_url_or_default()
+        .await
+        .map_err(|e| {
+            problem_new(StatusCode::INTERNAL_SERVER_ERROR)
+                .with_title("Configuration Error")
+                .with_detail(e.to_string())
+        })?;
+    Ok(RepositoryWebhookResponse {
+        repository_id: webhook.repository_id,
+        webhook_url: format!(
+            "{}/api/webhook/git/repositories/{}/events",
+            external_url.trim_end_matches('/'),
+            webhook.repository_id
+        ),
+        created_by: webhook.created_by,
+        last_delivery_at: webhook.last_delivery_at,
+        last_rejected_at: webhook.last_rejected_at,
+        rejected_count: webhook.rejected_count,
+        created_at: webhook.created_at,
+        updated_at: webhook.updated_at,
+    })
+}
+
+/// Generate a repository's webhook secret
+///
+/// Replaces the previous secret, if any; deliveries signed with it are rejected from
+/// now on. The secret is only returned by this request.
+#[utoipa::path(
+    post,
+    path = "/repositories/{repository_id}/webhook-secret",
+    params(("repository_id" = i32, Path, description = "Repository ID")),
+    responses(
+        (status = 201, description = "Webhook secret generated", body = RepositoryWebhookSecretResponse),
+        (status = 400, description = "Webhooks of the repository's provider can't be verified"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Repository not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Repositories",
+    security(("bearer_auth" = []))
+)]
+pub async fn rotate_webhook_secret(
+    State(state): State<Arc<AppState>>,
+    RequireAuth(auth): RequireAuth,
+    Path(repository_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_check!(auth, Permission::GitConnectionsWrite);
+
+    let (webhook, secret) = state
+        .repository_webhook_service
+        .rotate_secret(repository_id, auth.user_id())
+        .await?;
+    info!(
+        "Generated webhook secret for repository {} by user {}",
+        repository_id,
+        auth.user_id()
+    );
+
+    Ok((
+        StatusCode::CREATED,
+        Json(RepositoryWebhookSecretResponse {
+            webhook: webhook_response(&state, webhook).await?,
+            secret,
+        }),
+    ))
+}
+
+/// Get a repository's webhook
+#[utoipa::path(
+    get,
+    path = "/repositories/{repository_id}/webhook",
+    params(("repository_id" = i32, Path, description = "Repository ID")),
+    responses(
+        (status = 200, description = "Repository webhook", body = RepositoryWebhookResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No webhook secret is set up"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Repositories",
+    security(("bearer_auth" = []))
+)]
+pub async fn get_repository_webhook(
+    State(state): State<Arc<AppState>>,
+    RequireAuth(auth): RequireAuth,
+    Path(repository_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_check!(auth, Permission::GitRepositoriesRead);
+
+    let webhook = state
+        .repository_webhook_service
+        .get_webhook(repository_id)
+        .await?
+        .ok_or(RepositoryWebhookError::NotConfigured(repository_id))?;
+    Ok(Json(webhook_response(&state, webhook).await?))
+}
+
+/// Delete a repository's webhook secret
+///
+/// Deliveries to the repository's webhook are rejected afterwards.
+#[utoipa::path(
+    delete,
+    path = "/repositories/{repository_id}/webhook-secret",
+    params(("repository_id" = i32, Path, description = "Repository ID")),
+    responses(
+        (status = 204, description = "Webhook secret deleted"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No webhook secret is set up"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Repositories",
+    security(("bearer_auth" = []))
+)]
+pub async fn delete_webhook_secret(
+    State(state): State<Arc<AppState>>,
+    RequireAuth(auth): RequireAuth,
+    Path(repository_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_check!(auth, Permission::GitConnectionsWrite);
+
+    state
+        .repository_webhook_service
+        .delete_webhook(repository_id)
+        .await?;
+    Ok(StatusCode::NO_CONTENT)
+}
+
+/// Receive a push webhook of a repository
+///
+/// Called by the git provider. The delivery's signature (GitHub, Gitea, Bitbucket)
+/// or token (GitLab) is checked against the repository's webhook secret; pushes then
+/// trigger pipelines of the projects deploying the repository.
+#[utoipa::path(
+    post,
+    path = "/webhook/git/repositories/{repository_id}/events",
+    params(("repository_id" = i32, Path, description = "Repository ID")),
+    responses(
+        (status = 200, description = "Delivery accepted", body = WebhookDeliveryResponse),
+        (status = 401, description = "Missing or invalid signature"),
+        (status = 404, description = "Repository not found or no webhook secret set up"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Repositories"
+)]
+pub async fn repository_webhook_events(
+    State(state): State<Arc<AppState>>,
+    Path(repository_id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    headers: HeaderMap,
+    body: Bytes,
+) -> Result<Json<WebhookDeliveryResponse>, Problem> {
+    let delivery = match state
+        .repository_webhook_service
+        .verify_delivery(repository_id, &headers, &body)
+        .await
+    {
+        Ok(delivery) => delivery,
+        Err(RepositoryWebhookError::Rejected {
+            reason,
+            repository,
+            created_by,
+            provider,
+        }) => {
+            warn!(
+                "Rejected {} webhook for {} from {}: {}",
+                provider, repository, metadata.ip_address, reason
+            );
+            let audit = WebhookRejectedAudit {
+                context: AuditContext {
+                    user_id: created_by,
+                    ip_address: Some(metadata.ip_address.clone()),
+                    user_agent: metadata.user_agent.clone(),
+                },
+                repository_id,
+                repository,
+                provider: provider.to_string(),
+                reason: reason.to_string(),
+            };
+            if let Err(e) = state.audit_service.create_audit_log(&audit).await {
+                error!("Failed to create audit log: {}", e);
+            }
+            return Err(problem_new(StatusCode::UNAUTHORIZED)
+                .with_title("Invalid Webhook Signature")
+                .with_detail("The webhook signature validation failed"));
+        }
+        Err(RepositoryWebhookError::NotConfigured(_)) => {
+            // Nothing to verify against, so nothing from this repository is trusted
+            warn!(
+                "Rejected webhook for repository {} without a webhook secret from {}",
+                repository_id, metadata.ip_address
+            );
+            return Err(problem_new(StatusCode::UNAUTHORIZED)
+                .with_title("Invalid Webhook Signature")
+                .with_detail("No webhook secret is set up for this repository"));
+        }
+        Err(e) => return Err(e.into()),
+    };
+
+    let payload: serde_json::Value = serde_json::from_slice(&body).map_err(|e| {
+        problem_new(StatusCode::BAD_REQUEST)
+            .with_title("Invalid Payload")
+            .with_detail(format!("Webhook payload isn't valid JSON: {}", e))
+    })?;
+    let Some(pushed) = parse_push_event(&delivery.provider, &headers, &payload) else {
+        return Ok(Json(WebhookDeliveryResponse {
+            message: "Event ignored".to_string(),
+            triggered: 0,
+        }));
+    };
+
+    let changed_paths = push_changed_paths(&delivery.provider, &payload);
+    let repository = delivery.repository;
+    for pushed_ref in &pushed {
+        info!(
+            "Push to {}/{} ({:?}{:?}) at {}",
+            repository.owner, repository.name, pushed_ref.branch, pushed_ref.tag, pushed_ref.commit
+        );
+        state
+            .git_provider_manager
+            .handle_push_event(
+                repository.owner.clone(),
+                repository.name.clone(),
+                pushed_ref.branch.clone(),
+                pushed_ref.tag.clone(),
+                pushed_ref.commit.clone(),
+                changed_paths.clone(),
+            )
+            .await?;
+    }
+
+    Ok(Json(WebhookDeliveryResponse {
+        message: "Push event processed".to_string(),
+        triggered: pushed.len(),
+    }))
+}
@@ -1,6 +1,7 @@
 use crate::services::{
-    cache::GitProviderCacheManager, git_provider_manager::GitProviderManager,
-    github::GithubAppService, repository::RepositoryService,
+    cache::GitProviderCacheManager, deploy_keys::DeployKeyService,
+    git_provider_manager::GitProviderManager, github::GithubAppService,
+    repository::RepositoryService, repository_webhooks::RepositoryWebhookService,
 };
 use serde::{Deserialize, Serialize};
 use std::sync::Arc;
@@ -16,6 +17,8 @@ pub struct GitAppState {
     pub github_service: Arc<GithubAppService>,
     pub config_service: Arc<ConfigService>,
     pub cache_manager: Arc<GitProviderCacheManager>,
+    pub repository_webhook_service: Arc<RepositoryWebhookService>,
+    pub deploy_key_service: Arc<DeployKeyService>,
 }
 
 pub fn create_git_app_state(
@@ -25,6 +28,8 @@ pub fn create_git_app_state(
     audit_service: Arc<dyn AuditLogger>,
     github_service: Arc<GithubAppService>,
     cache_manager: Arc<GitProviderCacheManager>,
+    repository_webhook_service: Arc<RepositoryWebhookService>,
+    deploy_key_service: Arc<DeployKeyService>,
 ) -> Arc<GitAppState> {
     Arc::new(GitAppState {
         git_provider_manager,
@@ -33,6 +38,8 @@ pub fn create_git_app_state(
         github_service,
         config_service,
         cache_manager,
+        repository_webhook_service,
+        deploy_key_service,
     })
 }
 
@@ -19,7 +19,9 @@ use temps_core::{EncryptionService, JobQueue};
 use tracing;
 use utoipa::{openapi::OpenApi, OpenApi as OpenApiTrait};
 
-use crate::handlers::{self, GitProvidersApiDoc, PublicRepositoriesApiDoc};
+use crate::handlers::{
+    self, DeployKeysApiDoc, GitProvidersApiDoc, PublicRepositoriesApiDoc, RepositoryWebhooksApiDoc,
+};
 use crate::services::{
     git_provider_manager::GitProviderManager, github::GithubAppService,
     repository::RepositoryService,
@@ -110,6 +112,20 @@ impl TempsPlugin for GitPlugin {
             ));
             context.register_service(github_service.clone());
 
+            // Create RepositoryWebhookService
+            let repository_webhook_service = Arc::new(
+                crate::services::repository_webhooks::RepositoryWebhookService::new(
+                    db.clone(),
+                    encryption_service.clone(),
+                ),
+            );
+
+            // Create DeployKeyService
+            let deploy_key_service = Arc::new(crate::services::deploy_keys::DeployKeyService::new(
+                db.clone(),
+                encryption_service.clone(),
+            ));
+
             // Create cache manager
             let cache_manager = Arc::new(crate::services::cache::GitProviderCacheManager::new());
 
@@ -121,6 +137,8 @@ impl TempsPlugin for GitPlugin {
                 audit_service,
                 github_service,
                 cache_manager,
+                repository_webhook_service,
+                deploy_key_service,
             );
             context.register_plugin_state("git", git_app_state);
 
@@ -144,6 +162,8 @@ impl TempsPlugin for GitPlugin {
     fn openapi_schema(&self) -> Option<OpenApi> {
         let mut schema = GitProvidersApiDoc::openapi();
         schema.merge(PublicRepositoriesApiDoc::openapi());
+        schema.merge(RepositoryWebhooksApiDoc::openapi());
+        schema.merge(DeployKeysApiDoc::openapi());
         Some(schema)
     }
 }
@@ -0,0 +1,506 @@
+//! Per-repository SSH deploy keys
+//!
+//! A private repository can be fetched over SSH with a deploy key rather than with
+//! its git provider connection's token. Temps generates the keypair, shows the public
+//! key to add to the git host and stores the private key encrypted. The git host's
+//! host keys are pinned when the key is generated; fetches refuse any other.
+
+use std::path::Path;
+use std::sync::Arc;
+
+use sea_orm::{
+    prelude::*, ActiveModelTrait, ActiveValue::Set, DatabaseConnection, EntityTrait, QueryFilter,
+};
+use temps_core::EncryptionService;
+use temps_entities::{repositories, repository_deploy_keys};
+use thiserror::Error;
+use tokio::process::Command;
+
+/// Seconds ssh-keyscan waits for the git host to answer
+const KEYSCAN_TIMEOUT_SECS: u32 = 10;
+
+#[derive(Error, Debug)]
+pub enum DeployKeyError {
+    #[error("Database error: {0}")]
+    DatabaseError(#[from] sea_orm::DbErr),
+
+    #[error("Repository {0} not found")]
+    RepositoryNotFound(i32),
+
+    #[error("No deploy key is set up for repository {0}")]
+    NotConfigured(i32),
+
+    #[error("Repository {0} has no SSH clone URL")]
+    NoSshUrl(i32),
+
+    #[error("Invalid known_hosts: {0}")]
+    InvalidKnownHosts(String),
+
+    #[error("Encryption error: {0}")]
+    EncryptionError(String),
+
+    #[error("SSH error: {0}")]
+    SshError(String),
+}
+
+/// What a build needs to fetch a repository over SSH
+pub struct SshFetchSource {
+    pub deploy_key_id: i32,
+    pub ssh_url: String,
+    pub default_branch: String,
+    private_key: String,
+    known_hosts: String,
+}
+
+impl SshFetchSource {
+    /// Clone the repository into a directory with the deploy key
+    ///
+    /// The key only lives in a private temporary directory for the duration of the
+    /// clone, and the git host has to present one of the pinned host keys.
+    pub async fn clone_into(&self, target_dir: &Path) -> Result<(), DeployKeyError> {
+        let key_dir = tempfile::tempdir().map_err(|e| {
+            DeployKeyError::SshError(format!("Failed to create key directory: {}", e))
+        })?;
+        let key_path = key_dir.path().join("id_deploy");
+        let known_hosts_path = key_dir.path().join("known_hosts");
+        write_private_file(&key_path, &self.private_key)?;
+        write_private_file(&known_hosts_path, &self.known_hosts)?;
+
+        let output = Command::new("git")
+            .arg("clone")
+            .arg("--")
+            .arg(&self.ssh_url)
+            .arg(target_dir)
+            .env("GIT_SSH_COMMAND", ssh_command(&key_path, &known_hosts_path))
+            .env("GIT_TERMINAL_PROMPT", "0")
+            .output()
+            .await
+            .map_err(|e| DeployKeyError::SshError(format!("Failed to run git clone: {}", e)))?;
+
+        if !output.status.success() {
+            let stderr = String::from_utf8_lossy(&output.stderr);
+            return Err(DeployKeyError::SshError(format!(
+                "git clone over SSH failed: {}",
+                stderr.trim()
+            )));
+        }
+        Ok(())
+    }
+}
+
+pub struct DeployKeyService {
+    db: Arc<DatabaseConnection>,
+    encryption_service: Arc<EncryptionService>,
+}
+
+impl DeployKeyService {
+    pub fn new(db: Arc<DatabaseConnection>, encryption_service: Arc<EncryptionService>) -> Self {
+        Self {
+            db,
+            encryption_service,
+        }
+    }
+
+    pub async fn get_deploy_key(
+        &self,
+        repository_id: i32,
+    ) -> Result<Option<repository_deploy_keys::Model>, DeployKeyError> {
+        Ok(repository_deploy_keys::Entity::find()
+            .filter(repository_deploy_keys::Column::RepositoryId.eq(repository_id))
+            .one(self.db.as_ref())
+            .await?)
+    }
+
+    /// Generate a deploy key for a repository, replacing any previous one
+    ///
+    /// The git host's host keys are pinned from `known_hosts` when given, kept from the
+    /// replaced key otherwise, and read from the host with ssh-keyscan for a repository's
+    /// first key. Whether builds use the key is kept when rotating. Returns the key and
+    /// the fingerprint of the one it replaced.
+    pub async fn generate_key(
+        &self,
+        repository_id: i32,
+        user_id: i32,
+        known_hosts: Option<&str>,
+    ) -> Result<(repository_deploy_keys::Model, Option<String>), DeployKeyError> {
+        let repository = self.get_repository(repository_id).await?;
+        let ssh_url = repository
+            .ssh_url
+            .as_deref()
+            .ok_or(DeployKeyError::NoSshUrl(repository_id))?;
+        let existing = self.get_deploy_key(repository_id).await?;
+
+        let known_hosts = match (known_hosts, &existing) {
+            (Some(known_hosts), _) => normalize_known_hosts(known_hosts)?,
+            (None, Some(existing)) => existing.known_hosts.clone(),
+            (None, None) => {
+                let (host, port) = ssh_host(ssh_url).ok_or_else(|| {
+                    DeployKeyError::SshError(format!("Can't tell the host of {}", ssh_url))
+                })?;
+                scan_host_keys(&host, port).await?
+            }
+        };
+
+        let keypair = generate_keypair(&format!("temps-deploy-{}", repository.full_name)).await?;
+        let private_key_encrypted = self
+            .encryption_service
+            .encrypt_string(&keypair.private_key)
+            .map_err(|e| DeployKeyError::EncryptionError(e.to_string()))?;
+
+        match existing {
+            Some(existing) => {
+                let replaced_fingerprint = existing.fingerprint.clone();
+                let mut active: repository_deploy_keys::ActiveModel = existing.into();
+                active.public_key = Set(keypair.public_key);
+                active.private_key_encrypted = Set(private_key_encrypted);
+                active.fingerprint = Set(keypair.fingerprint);
+                active.known_hosts = Set(known_hosts);
+                active.created_by = Set(user_id);
+                active.last_used_at = Set(None);
+                let deploy_key = active.update(self.db.as_ref()).await?;
+                Ok((deploy_key, Some(replaced_fingerprint)))
+            }
+            None => {
+                let deploy_key = repository_deploy_keys::ActiveModel {
+                    repository_id: Set(repository_id),
+                    public_key: Set(keypair.public_key),
+                    private_key_encrypted: Set(private_key_encrypted),
+                    fingerprint: Set(keypair.fingerprint),
+                    known_hosts: Set(known_hosts),
+                    use_for_builds: Set(false),
+                    created_by: Set(user_id),
+                    ..Default::default()
+                }
+                .insert(self.db.as_ref())
+                .await?;
+                Ok((deploy_key, None))
+            }
+        }
+    }
+
+    /// Switch a repository's builds between SSH and token fetches, or re-pin the git
+    /// host's host keys
+    pub async fn update_deploy_key(
+        &self,
+        repository_id: i32,
+        use_for_builds: Option<bool>,
+        known_hosts: Option<&str>,
+    ) -> Result<repository_deploy_keys::Model, DeployKeyError> {
+        let deploy_key = self
+            .get_deploy_key(repository_id)
+            .await?
+            .ok_or(DeployKeyError::NotConfigured(repository_id))?;
+
+        let mut active: repository_deploy_keys::ActiveModel = deploy_key.into();
+        if let Some(use_for_builds) = use_for_builds {
+            active.use_for_builds = Set(use_for_builds);
+        }
+        if let Some(known_hosts) = known_hosts {
+            active.known_hosts = Set(normalize_known_hosts(known_hosts)?);
+        }
+        Ok(active.update(self.db.as_ref()).await?)
+    }
+
+    /// Delete a repository's deploy key; builds fetch it with the connection's token again
+    ///
+    /// Returns the deleted key.
+    pub async fn revoke_key(
+        &self,
+        repository_id: i32,
+    ) -> Result<repository_deploy_keys::Model, DeployKeyError> {
+        let deploy_key = self
+            .get_deploy_key(repository_id)
+            .await?
+            .ok_or(DeployKeyError::NotConfigured(repository_id))?;
+        repository_deploy_keys::Entity::delete_by_id(deploy_key.id)
+            .exec(self.db.as_ref())
+            .await?;
+        Ok(deploy_key)
+    }
+
+    /// Whether builds fetch a repository over SSH with its deploy key
+    pub async fn builds_use_deploy_key(
+        &self,
+        connection_id: i32,
+        owner: &str,
+        name: &str,
+    ) -> Result<bool, DeployKeyError> {
+        Ok(self
+            .find_build_key(connection_id, owner, name)
+            .await?
+            .is_some())
+    }
+
+    /// Where and how to fetch a repository over SSH, if builds use its deploy key
+    pub async fn ssh_fetch_source(
+        &self,
+        connection_id: i32,
+        owner: &str,
+        name: &str,
+    ) -> Result<Option<SshFetchSource>, DeployKeyError> {
+        let Some((repository, deploy_key)) =
+            self.find_build_key(connection_id, owner, name).await?
+        else {
+            return Ok(None);
+        };
+        let ssh_url = repository
+            .ssh_url
+            .ok_or(DeployKeyError::NoSshUrl(repository.id))?;
+        let private_key = self
+            .encryption_service
+            .decrypt_string(&deploy_key.private_key_encrypted)
+            .map_err(|e| DeployKeyError::EncryptionError(e.to_string()))?;
+
+        Ok(Some(SshFetchSource {
+            deploy_key_id: deploy_key.id,
+            ssh_url,
+            default_branch: repository.default_branch,
+            private_key,
+            known_hosts: deploy_key.known_hosts,
+        }))
+    }
+
+    /// Record that a build fetched a repository with a deploy key
+    pub async fn mark_used(&self, deploy_key_id: i32) -> Result<(), DeployKeyError> {
+        repository_deploy_keys::ActiveModel {
+            id: Set(deploy_key_id),
+            last_used_at: Set(Some(chrono::Utc::now())),
+            ..Default::default()
+        }
+        .update(self.db.as_ref())
+        .await?;
+        Ok(())
+    }
+
+    async fn find_build_key(
+        &self,
+        connection_id: i32,
+        owner: &str,
+        name: &str,
+    ) -> Result<Option<(repositories::Model, repository_deploy_keys::Model)>, DeployKeyError> {
+        let Some(repository) = repositories::Entity::find()
+            .filter(repositories::Column::GitProviderConnectionId.eq(connection_id))
+            .filter(repositories::Column::Owner.eq(owner))
+            .filter(repositories::Column::Name.eq(name))
+            .one(self.db.as_ref())
+            .await?
+        else {
+            return Ok(None);
+        };
+        let deploy_key = repository_deploy_keys::Entity::find()
+            .filter(repository_deploy_keys::Column::RepositoryId.eq(repository.id))
+            .filter(repository_deploy_keys::Column::UseForBuilds.eq(true))
+            .one(self.db.as_ref())
+            .await?;
+        Ok(deploy_key.map(|deploy_key| (repository, deploy_key)))
+    }
+
+    pub async fn get_repository(
+        &self,
+        repository_id: i32,
+    ) -> Result<repositories::Model, DeployKeyError> {
+        repositories::Entity::find_by_id(repository_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or(DeployKeyError::RepositoryNotFound(repository_id))
+    }
+}
+
+struct Keypair {
+    private_key: String,
+    public_key: String,
+    fingerprint: String,
+}
+
+/// A new ed25519 keypair from ssh-keygen
+async fn generate_keypair(comment: &str) -> Result<Keypair, DeployKeyError> {
+    let key_dir = tempfile::tempdir()
+        .map_err(|e| DeployKeyError::SshError(format!("Failed to create key directory: {}", e)))?;
+    let key_path = key_dir.path().join("id_ed25519");
+
+    let output = Command::new("ssh-keygen")
+        .args(["-q", "-t", "ed25519", "-N", "", "-C", comment, "-f"])
+        .arg(&key_path)
+        .output()
+        .await
+        .map_err(|e| DeployKeyError::SshError(format!("Failed to run ssh-keygen: {}", e)))?;
+    if !output.status.success() {
+        return Err(DeployKeyError::SshError(format!(
+            "ssh-keygen failed: {}",
+            String::from_utf8_lossy(&output.stderr).trim()
+        )));
+    }
+
+    let public_key_path = key_path.with_extension("pub");
+    let output = Command::new("ssh-keygen")
+        .arg("-l")
+        .arg("-f")
+        .arg(&public_key_path)
+        .output()
+        .await
+        .map_err(|e| DeployKeyError::SshError(format!("Failed to run ssh-keygen: {}", e)))?;
+    // "256 SHA256:... comment (ED25519)"
+    let fingerprint = String::from_utf8_lossy(&output.stdout)
+        .split_whitespace()
+        .nth(1)
+        .map(str::to_string)
+        .ok_or_else(|| DeployKeyError::SshError("ssh-keygen printed no fingerprint".to_string()))?;
+
+    let read = |path: &Path| {
+        std::fs::read_to_string(path)
+            .map_err(|e| DeployKeyError::SshError(format!("Failed to read generated key: {}", e)))
+    };
+    Ok(Keypair {
+        private_key: read(&key_path)?,
+        public_key: read(&public_key_path)?.trim().to_string(),
+        fingerprint,
+    })
+}
+
+/// known_hosts lines of an SSH server, read with ssh-keyscan
+async fn scan_host_keys(host: &str, port: Option<u16>) -> Result<String, DeployKeyError> {
+    let mut command = Command::new("ssh-keyscan");
+    command.arg("-T").arg(KEYSCAN_TIMEOUT_SECS.to_string());
+    if let Some(port) = port {
+        command.arg("-p").arg(port.to_string());
+    }
+    let output = command
+        .arg(host)
+        .output()
+        .await
+        .map_err(|e| DeployKeyError::SshError(format!("Failed to run ssh-keyscan: {}", e)))?;
+
+    normalize_known_hosts(&String::from_utf8_lossy(&output.stdout)).map_err(|_| {
+        DeployKeyError::SshError(format!(
+            "Couldn't read the host keys of {}; pass its known_hosts lines instead",
+            host
+        ))
+    })
+}
+
+/// known_hosts lines without blank lines and comments
+fn normalize_known_hosts(known_hosts: &str) -> Result<String, DeployKeyError> {
+    let mut lines = Vec::new();
+    for line in known_hosts.lines().map(str::trim) {
+        if line.is_empty() || line.starts_with('#') {
+            continue;
+        }
+        // Hosts, key type and key, after an optional @cert-authority or @revoked marker
+        if line.split_whitespace().count() < 3 {
+            return Err(DeployKeyError::InvalidKnownHosts(format!(
+                "`{}` isn't a known_hosts line",
+                line
+            )));
+        }
+        lines.push(line);
+    }
+    if lines.is_empty() {
+        return Err(DeployKeyError::InvalidKnownHosts(
+            "no host keys were given".to_string(),
+        ));
+    }
+    Ok(format!("{}\n", lines.join("\n")))
+}
+
+/// Host and port of an SSH clone URL, like `git@github.com:owner/repo.git` or
+/// `ssh://git@git.example.com:2222/owner/repo.git`
+fn ssh_host(url: &str) -> Option<(String, Option<u16>)> {
+    let (host, port) = match url.strip_prefix("ssh://") {
+        Some(rest) => {
+            let authority = rest.split('/').next()?;
+            let host_port = authority.rsplit('@').next()?;
+            match host_port.split_once(':') {
+                Some((host, port)) => (host, Some(port.parse().ok()?)),
+                None => (host_port, None),
+            }
+        }
+        None => {
+            // scp-like syntax; URLs with another scheme aren't SSH
+            let (user_host, path) = url.split_once(':')?;
+            if path.starts_with("//") {
+                return None;
+            }
+            (user_host.rsplit('@').next()?, None)
+        }
+    };
+    // A host starting with a dash would be taken for an option of ssh-keyscan
+    if host.is_empty() || host.contains('/') || host.starts_with('-') {
+        return None;
+    }
+    Some((host.to_string(), port))
+}
+
+/// ssh command git runs to fetch with a deploy key, trusting only the pinned host keys
+fn ssh_command(key_path: &Path, known_hosts_path: &Path) -> String {
+    format!(
+        "ssh -i '{}' -o IdentitiesOnly=yes -o IdentityAgent=none -o BatchMode=yes \
+         -o StrictHostKeyChecking=yes -o UserKnownHostsFile='{}' -o GlobalKnownHostsFile=/dev/null",
+        key_path.display(),
+        known_hosts_path.display()
+    )
+}
+
+/// Write a file only the server's user can read
+fn write_private_file(path: &Path, contents: &str) -> Result<(), DeployKeyError> {
+    use std::io::Write;
+    use std::os::unix::fs::OpenOptionsExt;
+
+    let mut file = std::fs::OpenOptions::new()
+        .write(true)
+        .create_new(true)
+        .mode(0o600)
+        .open(path)
+        .and_then(|mut file| {
+            file.write_all(contents.as_bytes())?;
+            Ok(file)
+        })
+        .map_err(|e| DeployKeyError::SshError(format!("Failed to write key file: {}", e)))?;
+    // ssh refuses keys without a trailing newline
+    if !contents.ends_with('\n') {
+        file.write_all(b"\n")
+            .map_err(|e| DeployKeyError::SshError(format!("Failed to write key file: {}", e)))?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_ssh_host() {
+        assert_eq!(
+            ssh_host("git@github.com:owner/repo.git"),
+            Some(("github.com".to_string(), None))
+        );
+        assert_eq!(
+            ssh_host("ssh://git@git.example.com:2222/owner/repo.git"),
+            Some(("git.example.com".to_string(), Some(2222)))
+        );
+        assert_eq!(
+            ssh_host("ssh://gitlab.com/owner/repo.git"),
+            Some(("gitlab.com".to_string(), None))
+        );
+        assert_eq!(ssh_host("https://github.com/owner/repo.git"), None);
+        assert_eq!(ssh_host("-oProxyCommand=x:owner/repo.git"), None);
+    }
+
+    #[test]
+    fn test_normalize_known_hosts() {
+        let known_hosts = "# github.com:22 SSH-2.0-babeld\n\ngithub.com ssh-ed25519 AAAAC3Nza\n";
+        assert_eq!(
+            normalize_known_hosts(known_hosts).unwrap(),
+            "github.com ssh-ed25519 AAAAC3Nza\n"
+        );
+        assert!(normalize_known_hosts("github.com ssh-ed25519").is_err());
+        assert!(normalize_known_hosts("# only a comment\n").is_err());
+    }
+
+    #[test]
+    fn test_ssh_command_checks_host_keys_strictly() {
+        let command = ssh_command(Path::new("/tmp/k/id"), Path::new("/tmp/k/known_hosts"));
+        assert!(command.contains("-i '/tmp/k/id'"));
+        assert!(command.contains("StrictHostKeyChecking=yes"));
+        assert!(command.contains("UserKnownHostsFile='/tmp/k/known_hosts'"));
+    }
+}
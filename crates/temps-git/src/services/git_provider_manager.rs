@@ -9,6 +9,7 @@ use thiserror::Error;
 use tokio::sync::RwLock;
 use tracing::{debug, error, info};
 
+use super::deploy_keys::DeployKeyService;
 use super::git_provider::{
     AuthMethod, GitProviderError, GitProviderFactory, GitProviderService, GitProviderType,
 };
@@ -116,6 +117,10 @@ impl GitProviderManager {
         }
     }
 
+    fn deploy_key_service(&self) -> DeployKeyService {
+        DeployKeyService::new(self.db.clone(), self.encryption_service.clone())
+    }
+
     /// Get provider service by ID
     pub async fn get_provider_service(
         &self,
@@ -3056,6 +3061,9 @@ impl GitProviderManager {
     }
 
     /// Handle push event by queueing GitPushEventJob for all matching projects
+    ///
+    /// When the files the push changed are known, projects sharing a build stage
+    /// are only deployed if the push touched their directory or the shared code.
     pub async fn handle_push_event(
         &self,
         owner: String,
@@ -3063,6 +3071,7 @@ impl GitProviderManager {
         branch: Option<String>,
         tag: Option<String>,
         commit: String,
+        changed_paths: Option<Vec<String>>,
     ) -> Result<(), GitProviderManagerError> {
         use sea_orm::{ColumnTrait, EntityTrait, QueryFilter};
         use temps_entities::projects;
@@ -3096,6 +3105,24 @@ impl GitProviderManager {
 
         // Queue a GitPushEventJob for each project
         for project in matching_projects {
+            let shared_build = project
+                .deployment_config
+                .as_ref()
+                .and_then(|config| config.shared_build.as_ref());
+            if let (Some(shared_build), Some(paths)) = (shared_build, &changed_paths) {
+                if !shared_build.rebuilds_for(&project.directory, paths) {
+                    tracing::info!(
+                        "Push to {}/{} at {} changed neither project {} nor its shared build stage '{}', skipping",
+                        owner,
+                        repo,
+                        commit,
+                        project.id,
+                        shared_build.name
+                    );
+                    continue;
+                }
+            }
+
             let push_job = temps_core::GitPushEventJob {
                 owner: owner.clone(),
                 repo: repo.clone(),
@@ -3103,6 +3130,11 @@ impl GitProviderManager {
                 tag: tag.clone(),
                 commit: commit.clone(),
                 project_id: project.id,
+                environment_id: None,
+                manual: false,
+                release_note: None,
+                triggered_by: None,
+                dry_run: false,
             };
 
             if let Err(e) = self
@@ -3165,41 +3197,69 @@ impl GitProviderManagerTrait for GitProviderManager {
             })?;
         }
 
-        // Get connection and provider
-        let connection = self
-            .get_connection(connection_id)
+        let deploy_keys = self.deploy_key_service();
+        let ssh_source = deploy_keys
+            .ssh_fetch_source(connection_id, repo_owner, repo_name)
             .await
-            .map_err(|_| TraitError::ConnectionNotFound(connection_id))?;
+            .map_err(|e| TraitError::CloneError(e.to_string()))?;
 
-        let provider_service = self
-            .get_provider_service(connection.provider_id)
-            .await
-            .map_err(|_| TraitError::ProviderNotFound(connection.provider_id))?;
+        let default_branch = match ssh_source {
+            // The repository is fetched with its deploy key, without the connection's token
+            Some(source) => {
+                source
+                    .clone_into(target_dir)
+                    .await
+                    .map_err(|e| TraitError::CloneError(e.to_string()))?;
+                if let Err(e) = deploy_keys.mark_used(source.deploy_key_id).await {
+                    tracing::warn!(
+                        "Failed to record use of deploy key {}: {}",
+                        source.deploy_key_id,
+                        e
+                    );
+                }
+                source.default_branch
+            }
+            None => {
+                // Get connection and provider
+                let connection = self
+                    .get_connection(connection_id)
+                    .await
+                    .map_err(|_| TraitError::ConnectionNotFound(connection_id))?;
 
-        let access_token = self
-            .validate_and_refresh_connection_token(connection_id)
-            .await
-            .map_err(|e| TraitError::DecryptionError(e.to_string()))?;
+                let provider_service = self
+                    .get_provider_service(connection.provider_id)
+                    .await
+                    .map_err(|_| TraitError::ProviderNotFound(connection.provider_id))?;
 
-        // Get repository info
-        let repo = provider_service
-            .get_repository(&access_token, repo_owner, repo_name)
-            .await
-            .map_err(|e| TraitError::CloneError(format!("Failed to get repository: {}", e)))?;
+                let access_token = self
+                    .validate_and_refresh_connection_token(connection_id)
+                    .await
+                    .map_err(|e| TraitError::DecryptionError(e.to_string()))?;
 
-        // Clone the repository
-        provider_service
-            .clone_repository(
-                &repo.clone_url,
-                target_dir.to_str().unwrap(),
-                Some(&access_token),
-            )
-            .await
-            .map_err(|e| TraitError::CloneError(format!("Failed to clone: {}", e)))?;
+                // Get repository info
+                let repo = provider_service
+                    .get_repository(&access_token, repo_owner, repo_name)
+                    .await
+                    .map_err(|e| {
+                        TraitError::CloneError(format!("Failed to get repository: {}", e))
+                    })?;
+
+                // Clone the repository
+                provider_service
+                    .clone_repository(
+                        &repo.clone_url,
+                        target_dir.to_str().unwrap(),
+                        Some(&access_token),
+                    )
+                    .await
+                    .map_err(|e| TraitError::CloneError(format!("Failed to clone: {}", e)))?;
+                repo.default_branch
+            }
+        };
 
         // Checkout specific ref if provided
         if let Some(ref_name) = branch_or_ref {
-            if ref_name != repo.default_branch {
+            if ref_name != default_branch {
                 let output = tokio::process::Command::new("git")
                     .arg("checkout")
                     .arg(ref_name)
@@ -3223,6 +3283,20 @@ impl GitProviderManagerTrait for GitProviderManager {
         Ok(())
     }
 
+    async fn uses_deploy_key(
+        &self,
+        connection_id: i32,
+        repo_owner: &str,
+        repo_name: &str,
+    ) -> Result<bool, super::git_provider_manager_trait::GitProviderManagerError> {
+        use super::git_provider_manager_trait::GitProviderManagerError as TraitError;
+
+        self.deploy_key_service()
+            .builds_use_deploy_key(connection_id, repo_owner, repo_name)
+            .await
+            .map_err(|e| TraitError::Other(e.to_string()))
+    }
+
     async fn get_repository_info(
         &self,
         connection_id: i32,
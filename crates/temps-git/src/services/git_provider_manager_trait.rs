@@ -67,6 +67,17 @@ pub trait GitProviderManagerTrait: Send + Sync {
         branch_or_ref: Option<&str>,
     ) -> Result<(), GitProviderManagerError>;
 
+    /// Whether builds fetch the repository over SSH with its deploy key rather than with
+    /// the connection's token; such repositories are cloned, never downloaded as archives
+    async fn uses_deploy_key(
+        &self,
+        _connection_id: i32,
+        _repo_owner: &str,
+        _repo_name: &str,
+    ) -> Result<bool, GitProviderManagerError> {
+        Ok(false)
+    }
+
     /// Get repository information
     async fn get_repository_info(
         &self,
@@ -1,12 +1,9 @@
 use chrono::Utc;
-use hex;
-use hmac::{Hmac, Mac};
 use octocrab::models::{AppId, InstallationId, InstallationRepositories, InstallationToken};
 use octocrab::params::apps::CreateInstallationAccessToken;
 use octocrab::Octocrab;
 use sea_orm::{ActiveModelTrait, ColumnTrait, EntityTrait, QueryFilter, Set, TransactionTrait};
 use serde::{Deserialize, Serialize};
-use sha2::Sha256;
 use std::sync::Arc;
 use temps_core::UtcDateTime;
 use temps_database::DbConnection;
@@ -16,6 +13,8 @@ use thiserror::Error;
 use tracing::{debug, error, info, warn};
 use url::Url;
 
+use super::webhook_verification::verify_hmac_sha256;
+
 #[derive(Error, Debug)]
 pub enum GithubAppServiceError {
     #[error("Database error: {0}")]
@@ -1367,27 +1366,14 @@ impl GithubAppService {
         }
 
         let original_signature = signature.ok_or(GithubAppServiceError::InvalidWebhookSignature)?;
+        let digest = original_signature
+            .strip_prefix("sha256=")
+            .ok_or(GithubAppServiceError::InvalidWebhookSignature)?;
 
-        if !original_signature.starts_with("sha256=") {
-            return Err(GithubAppServiceError::InvalidWebhookSignature);
-        }
-
-        // Try each GitHub App's webhook secret
+        // Try each GitHub App's webhook secret, comparing in constant time
         for github_app in github_apps {
             // webhook_secret is already decrypted in GitHubAppData
-            let webhook_secret = github_app.webhook_secret;
-
-            let mut mac = match Hmac::<Sha256>::new_from_slice(webhook_secret.as_bytes()) {
-                Ok(mac) => mac,
-                Err(_) => continue, // Skip this app if HMAC creation fails
-            };
-
-            mac.update(body);
-            let result = mac.finalize();
-            let code_bytes = result.into_bytes();
-            let expected_signature = format!("sha256={}", hex::encode(code_bytes));
-
-            if original_signature == expected_signature {
+            if verify_hmac_sha256(digest, body, &github_app.webhook_secret).is_ok() {
                 debug!("Valid signature for GitHub App: {}", github_app.name);
                 return Ok(());
             }
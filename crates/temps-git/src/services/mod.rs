@@ -1,4 +1,5 @@
 pub mod cache;
+pub mod deploy_keys;
 pub mod git_provider;
 pub mod git_provider_manager;
 pub mod git_provider_manager_trait;
@@ -9,3 +10,5 @@ pub mod github_provider;
 pub mod gitlab_provider;
 pub mod public_repo;
 pub mod repository;
+pub mod repository_webhooks;
+pub mod webhook_verification;
@@ -0,0 +1,232 @@
+//! Per-repository webhook secrets
+//!
+//! A repository's git provider can send push webhooks straight to Temps. Each
+//! repository gets its own secret, which is shown once when it's generated and
+//! stored encrypted; deliveries are verified with the scheme of the repository's
+//! provider before they trigger anything.
+
+use std::sync::Arc;
+
+use axum::http::HeaderMap;
+use sea_orm::{
+    prelude::*, ActiveModelTrait, ActiveValue::Set, DatabaseConnection, EntityTrait, QueryFilter,
+};
+use temps_core::EncryptionService;
+use temps_entities::{git_provider_connections, git_providers, repositories, repository_webhooks};
+use thiserror::Error;
+
+use super::git_provider::GitProviderType;
+use super::webhook_verification::{verifier_for, WebhookVerificationError};
+
+#[derive(Error, Debug)]
+pub enum RepositoryWebhookError {
+    #[error("Database error: {0}")]
+    DatabaseError(#[from] sea_orm::DbErr),
+
+    #[error("Repository {0} not found")]
+    RepositoryNotFound(i32),
+
+    #[error("No webhook secret is set up for repository {0}")]
+    NotConfigured(i32),
+
+    #[error("Unsupported provider: {0}")]
+    UnsupportedProvider(String),
+
+    #[error("Encryption error: {0}")]
+    EncryptionError(String),
+
+    /// The delivery failed verification
+    #[error("Webhook rejected: {reason}")]
+    Rejected {
+        reason: WebhookVerificationError,
+        /// Full name of the repository, like `owner/name`
+        repository: String,
+        /// User who set up the webhook
+        created_by: i32,
+        provider: GitProviderType,
+    },
+}
+
+/// A delivery that passed verification
+pub struct VerifiedDelivery {
+    pub repository: repositories::Model,
+    pub provider: GitProviderType,
+}
+
+pub struct RepositoryWebhookService {
+    db: Arc<DatabaseConnection>,
+    encryption_service: Arc<EncryptionService>,
+}
+
+impl RepositoryWebhookService {
+    pub fn new(db: Arc<DatabaseConnection>, encryption_service: Arc<EncryptionService>) -> Self {
+        Self {
+            db,
+            encryption_service,
+        }
+    }
+
+    pub async fn get_webhook(
+        &self,
+        repository_id: i32,
+    ) -> Result<Option<repository_webhooks::Model>, RepositoryWebhookError> {
+        Ok(repository_webhooks::Entity::find()
+            .filter(repository_webhooks::Column::RepositoryId.eq(repository_id))
+            .one(self.db.as_ref())
+            .await?)
+    }
+
+    /// Generate a new secret for a repository's webhook, replacing any previous one
+    ///
+    /// Returns the webhook and the plain secret, which isn't retrievable afterwards.
+    pub async fn rotate_secret(
+        &self,
+        repository_id: i32,
+        user_id: i32,
+    ) -> Result<(repository_webhooks::Model, String), RepositoryWebhookError> {
+        let repository = self.get_repository(repository_id).await?;
+        // Make sure deliveries of the repository can be verified at all
+        let provider = self.provider_type(&repository).await?;
+        verifier_for(&provider)
+            .map_err(|_| RepositoryWebhookError::UnsupportedProvider(provider.to_string()))?;
+
+        let secret = generate_secret();
+        let secret_encrypted = self
+            .encryption_service
+            .encrypt_string(&secret)
+            .map_err(|e| RepositoryWebhookError::EncryptionError(e.to_string()))?;
+
+        let webhook = match self.get_webhook(repository_id).await? {
+            Some(existing) => {
+                let mut active: repository_webhooks::ActiveModel = existing.into();
+                active.secret_encrypted = Set(secret_encrypted);
+                active.created_by = Set(user_id);
+                active.rejected_count = Set(0);
+                active.last_rejected_at = Set(None);
+                active.update(self.db.as_ref()).await?
+            }
+            None => {
+                repository_webhooks::ActiveModel {
+                    repository_id: Set(repository_id),
+                    secret_encrypted: Set(secret_encrypted),
+                    created_by: Set(user_id),
+                    rejected_count: Set(0),
+                    ..Default::default()
+                }
+                .insert(self.db.as_ref())
+                .await?
+            }
+        };
+
+        Ok((webhook, secret))
+    }
+
+    /// Remove a repository's webhook secret; later deliveries are rejected
+    pub async fn delete_webhook(&self, repository_id: i32) -> Result<(), RepositoryWebhookError> {
+        let result = repository_webhooks::Entity::delete_many()
+            .filter(repository_webhooks::Column::RepositoryId.eq(repository_id))
+            .exec(self.db.as_ref())
+            .await?;
+        if result.rows_affected == 0 {
+            return Err(RepositoryWebhookError::NotConfigured(repository_id));
+        }
+        Ok(())
+    }
+
+    /// Verify a delivery for a repository and count it as delivered or rejected
+    pub async fn verify_delivery(
+        &self,
+        repository_id: i32,
+        headers: &HeaderMap,
+        body: &[u8],
+    ) -> Result<VerifiedDelivery, RepositoryWebhookError> {
+        let repository = self.get_repository(repository_id).await?;
+        let webhook = self
+            .get_webhook(repository_id)
+            .await?
+            .ok_or(RepositoryWebhookError::NotConfigured(repository_id))?;
+        let provider = self.provider_type(&repository).await?;
+
+        let secret = self
+            .encryption_service
+            .decrypt_string(&webhook.secret_encrypted)
+            .map_err(|e| RepositoryWebhookError::EncryptionError(e.to_string()))?;
+        let verified =
+            verifier_for(&provider).and_then(|verifier| verifier.verify(headers, body, &secret));
+
+        let now = chrono::Utc::now();
+        let created_by = webhook.created_by;
+        let rejected_count = webhook.rejected_count;
+        let mut active: repository_webhooks::ActiveModel = webhook.into();
+        match verified {
+            Ok(()) => {
+                active.last_delivery_at = Set(Some(now));
+                active.update(self.db.as_ref()).await?;
+                Ok(VerifiedDelivery {
+                    repository,
+                    provider,
+                })
+            }
+            Err(reason) => {
+                active.last_rejected_at = Set(Some(now));
+                active.rejected_count = Set(rejected_count + 1);
+                active.update(self.db.as_ref()).await?;
+                Err(RepositoryWebhookError::Rejected {
+                    reason,
+                    repository: repository.full_name,
+                    created_by,
+                    provider,
+                })
+            }
+        }
+    }
+
+    async fn get_repository(
+        &self,
+        repository_id: i32,
+    ) -> Result<repositories::Model, RepositoryWebhookError> {
+        repositories::Entity::find_by_id(repository_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or(RepositoryWebhookError::RepositoryNotFound(repository_id))
+    }
+
+    async fn provider_type(
+        &self,
+        repository: &repositories::Model,
+    ) -> Result<GitProviderType, RepositoryWebhookError> {
+        let provider_type =
+            git_provider_connections::Entity::find_by_id(repository.git_provider_connection_id)
+                .find_also_related(git_providers::Entity)
+                .one(self.db.as_ref())
+                .await?
+                .and_then(|(_, provider)| provider)
+                .map(|provider| provider.provider_type)
+                .ok_or(RepositoryWebhookError::RepositoryNotFound(repository.id))?;
+
+        GitProviderType::try_from(provider_type.as_str())
+            .map_err(|_| RepositoryWebhookError::UnsupportedProvider(provider_type))
+    }
+}
+
+/// A random 64 character hex secret
+fn generate_secret() -> String {
+    format!(
+        "{}{}",
+        uuid::Uuid::new_v4().simple(),
+        uuid::Uuid::new_v4().simple()
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_generated_secrets_are_long_and_distinct() {
+        let secret = generate_secret();
+        assert_eq!(secret.len(), 64);
+        assert!(secret.chars().all(|c| c.is_ascii_hexdigit()));
+        assert_ne!(secret, generate_secret());
+    }
+}
@@ -0,0 +1,572 @@
+//! Verification of inbound git webhooks
+//!
+//! Every provider proves a delivery came from it differently: GitHub, Gitea and
+//! Bitbucket sign the body with HMAC-SHA256 and send the digest in a header, while
+//! GitLab sends the shared secret itself as a token. A `WebhookVerifier` checks one
+//! of these schemes, comparing in constant time, and push payloads are parsed into
+//! the refs that were pushed.
+
+use axum::http::HeaderMap;
+use hmac::{Hmac, Mac};
+use serde_json::Value;
+use sha2::Sha256;
+use thiserror::Error;
+
+use super::git_provider::GitProviderType;
+
+#[derive(Error, Debug, PartialEq, Eq)]
+pub enum WebhookVerificationError {
+    #[error("Missing {0} header")]
+    MissingSignature(&'static str),
+
+    #[error("Malformed {0} header")]
+    MalformedSignature(&'static str),
+
+    #[error("Signature doesn't match")]
+    InvalidSignature,
+
+    #[error("Webhooks of {0} repositories can't be verified")]
+    UnsupportedProvider(String),
+}
+
+/// A provider's scheme of proving a webhook delivery
+pub trait WebhookVerifier: Send + Sync {
+    /// Header carrying the signature or token
+    fn header(&self) -> &'static str;
+
+    /// Check that `body` was sent by someone knowing `secret`
+    fn verify(
+        &self,
+        headers: &HeaderMap,
+        body: &[u8],
+        secret: &str,
+    ) -> Result<(), WebhookVerificationError>;
+}
+
+/// HMAC-SHA256 of the body as hex, optionally behind a prefix like `sha256=`
+pub struct HmacSha256Verifier {
+    header: &'static str,
+    prefix: &'static str,
+}
+
+impl HmacSha256Verifier {
+    /// GitHub: `X-Hub-Signature-256: sha256=<hex>`
+    pub fn github() -> Self {
+        Self {
+            header: "X-Hub-Signature-256",
+            prefix: "sha256=",
+        }
+    }
+
+    /// Bitbucket Cloud and Data Center: `X-Hub-Signature: sha256=<hex>`
+    pub fn bitbucket() -> Self {
+        Self {
+            header: "X-Hub-Signature",
+            prefix: "sha256=",
+        }
+    }
+
+    /// Gitea: `X-Gitea-Signature: <hex>`
+    pub fn gitea() -> Self {
+        Self {
+            header: "X-Gitea-Signature",
+            prefix: "",
+        }
+    }
+}
+
+impl WebhookVerifier for HmacSha256Verifier {
+    fn header(&self) -> &'static str {
+        self.header
+    }
+
+    fn verify(
+        &self,
+        headers: &HeaderMap,
+        body: &[u8],
+        secret: &str,
+    ) -> Result<(), WebhookVerificationError> {
+        let signature = header_value(headers, self.header)?;
+        let digest = signature
+            .strip_prefix(self.prefix)
+            .ok_or(WebhookVerificationError::MalformedSignature(self.header))?;
+        verify_hmac_sha256(digest, body, secret).map_err(|e| match e {
+            WebhookVerificationError::MalformedSignature(_) => {
+                WebhookVerificationError::MalformedSignature(self.header)
+            }
+            e => e,
+        })
+    }
+}
+
+/// The shared secret sent as is, like GitLab's `X-Gitlab-Token`
+pub struct TokenVerifier {
+    header: &'static str,
+}
+
+impl TokenVerifier {
+    pub fn gitlab() -> Self {
+        Self {
+            header: "X-Gitlab-Token",
+        }
+    }
+}
+
+impl WebhookVerifier for TokenVerifier {
+    fn header(&self) -> &'static str {
+        self.header
+    }
+
+    fn verify(
+        &self,
+        headers: &HeaderMap,
+        _body: &[u8],
+        secret: &str,
+    ) -> Result<(), WebhookVerificationError> {
+        let token = header_value(headers, self.header)?;
+        if constant_time_eq(token.as_bytes(), secret.as_bytes()) {
+            Ok(())
+        } else {
+            Err(WebhookVerificationError::InvalidSignature)
+        }
+    }
+}
+
+/// The verifier of a provider's webhooks
+pub fn verifier_for(
+    provider: &GitProviderType,
+) -> Result<Box<dyn WebhookVerifier>, WebhookVerificationError> {
+    match provider {
+        GitProviderType::GitHub => Ok(Box::new(HmacSha256Verifier::github())),
+        GitProviderType::GitLab => Ok(Box::new(TokenVerifier::gitlab())),
+        GitProviderType::Bitbucket => Ok(Box::new(HmacSha256Verifier::bitbucket())),
+        GitProviderType::Gitea => Ok(Box::new(HmacSha256Verifier::gitea())),
+        GitProviderType::Generic => Err(WebhookVerificationError::UnsupportedProvider(
+            provider.to_string(),
+        )),
+    }
+}
+
+/// Check a hex HMAC-SHA256 digest of `body` in constant time
+pub fn verify_hmac_sha256(
+    digest_hex: &str,
+    body: &[u8],
+    secret: &str,
+) -> Result<(), WebhookVerificationError> {
+    let digest = hex::decode(digest_hex.trim())
+        .map_err(|_| WebhookVerificationError::MalformedSignature("signature"))?;
+    let mut mac = Hmac::<Sha256>::new_from_slice(secret.as_bytes())
+        .map_err(|_| WebhookVerificationError::InvalidSignature)?;
+    mac.update(body);
+    mac.verify_slice(&digest)
+        .map_err(|_| WebhookVerificationError::InvalidSignature)
+}
+
+fn header_value<'a>(
+    headers: &'a HeaderMap,
+    name: &'static str,
+) -> Result<&'a str, WebhookVerificationError> {
+    headers
+        .get(name)
+        .ok_or(WebhookVerificationError::MissingSignature(name))?
+        .to_str()
+        .map_err(|_| WebhookVerificationError::MalformedSignature(name))
+}
+
+/// Compare two byte strings without returning early on the first difference
+fn constant_time_eq(a: &[u8], b: &[u8]) -> bool {
+    if a.len() != b.len() {
+        return false;
+    }
+    a.iter().zip(b).fold(0u8, |diff, (x, y)| diff | (x ^ y)) == 0
+}
+
+/// A ref that was pushed to
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PushedRef {
+    pub branch: Option<String>,
+    pub tag: Option<String>,
+    pub commit: String,
+}
+
+impl PushedRef {
+    /// From a full ref like `refs/heads/main` and the commit it points to now
+    fn from_git_ref(git_ref: &str, commit: &str) -> Option<Self> {
+        // A deleted ref points to the all-zero commit
+        if commit.is_empty() || commit.bytes().all(|b| b == b'0') {
+            return None;
+        }
+        if let Some(branch) = git_ref.strip_prefix("refs/heads/") {
+            Some(Self {
+                branch: Some(branch.to_string()),
+                tag: None,
+                commit: commit.to_string(),
+            })
+        } else {
+            git_ref.strip_prefix("refs/tags/").map(|tag| Self {
+                branch: None,
+                tag: Some(tag.to_string()),
+                commit: commit.to_string(),
+            })
+        }
+    }
+}
+
+/// The refs a push webhook reports, or `None` when the delivery isn't a push
+///
+/// Deleted branches and tags are left out.
+pub fn parse_push_event(
+    provider: &GitProviderType,
+    headers: &HeaderMap,
+    payload: &Value,
+) -> Option<Vec<PushedRef>> {
+    let event = |name: &str| {
+        headers
+            .get(name)
+            .and_then(|value| value.to_str().ok())
+            .unwrap_or_default()
+    };
+    let str_field = |value: &Value, field: &str| {
+        value
+            .get(field)
+            .and_then(Value::as_str)
+            .unwrap_or_default()
+            .to_string()
+    };
+
+    match provider {
+        GitProviderType::GitHub | GitProviderType::Gitea => {
+            let event_header = match provider {
+                GitProviderType::Gitea => "X-Gitea-Event",
+                _ => "X-GitHub-Event",
+            };
+            if event(event_header) != "push" {
+                return None;
+            }
+            let pushed =
+                PushedRef::from_git_ref(&str_field(payload, "ref"), &str_field(payload, "after"));
+            Some(pushed.into_iter().collect())
+        }
+        GitProviderType::GitLab => {
+            if !matches!(event("X-Gitlab-Event"), "Push Hook" | "Tag Push Hook") {
+                return None;
+            }
+            let mut commit = str_field(payload, "checkout_sha");
+            if commit.is_empty() {
+                commit = str_field(payload, "after");
+            }
+            let pushed = PushedRef::from_git_ref(&str_field(payload, "ref"), &commit);
+            Some(pushed.into_iter().collect())
+        }
+        GitProviderType::Bitbucket => match event("X-Event-Key") {
+            // Bitbucket Cloud; `new` is null for deleted refs
+            "repo:push" => {
+                let changes = payload
+                    .pointer("/push/changes")
+                    .and_then(Value::as_array)
+                    .cloned()
+                    .unwrap_or_default();
+                Some(
+                    changes
+                        .iter()
+                        .filter_map(|change| {
+                            let new = change.get("new").filter(|new| !new.is_null())?;
+                            let name = str_field(new, "name");
+                            let commit = new
+                                .pointer("/target/hash")
+                                .and_then(Value::as_str)
+                                .unwrap_or_default();
+                            let git_ref = match new.get("type").and_then(Value::as_str)? {
+                                "branch" => format!("refs/heads/{}", name),
+                                "tag" | "annotated_tag" => format!("refs/tags/{}", name),
+                                _ => return None,
+                            };
+                            PushedRef::from_git_ref(&git_ref, commit)
+                        })
+                        .collect(),
+                )
+            }
+            // Bitbucket Data Center
+            "repo:refs_changed" => {
+                let changes = payload
+                    .get("changes")
+                    .and_then(Value::as_array)
+                    .cloned()
+                    .unwrap_or_default();
+                Some(
+                    changes
+                        .iter()
+                        .filter(|change| str_field(change, "type") != "DELETE")
+                        .filter_map(|change| {
+                            let git_ref = change.pointer("/ref/id").and_then(Value::as_str)?;
+                            PushedRef::from_git_ref(git_ref, &str_field(change, "toHash"))
+                        })
+                        .collect(),
+                )
+            }
+            _ => None,
+        },
+        GitProviderType::Generic => None,
+    }
+}
+
+/// Most commits GitHub lists in a push payload; a push of more may have changed files
+/// that aren't listed
+const GITHUB_LISTED_COMMITS: usize = 20;
+
+/// Files a push changed, or `None` when the payload doesn't tell all of them
+///
+/// GitHub, Gitea and GitLab list the files each pushed commit added, modified and
+/// removed. New branches, force pushes and pushes of more commits than the payload
+/// lists only show part of what changed, and Bitbucket lists no files at all.
+pub fn push_changed_paths(provider: &GitProviderType, payload: &Value) -> Option<Vec<String>> {
+    if !matches!(
+        provider,
+        GitProviderType::GitHub | GitProviderType::Gitea | GitProviderType::GitLab
+    ) {
+        return None;
+    }
+    let before = payload
+        .get("before")
+        .and_then(Value::as_str)
+        .unwrap_or_default();
+    if before.is_empty() || before.bytes().all(|b| b == b'0') {
+        return None;
+    }
+    if payload.get("forced").and_then(Value::as_bool) == Some(true) {
+        return None;
+    }
+    let commits = payload.get("commits").and_then(Value::as_array)?;
+    if commits.is_empty() {
+        return None;
+    }
+    match provider {
+        GitProviderType::GitHub if commits.len() >= GITHUB_LISTED_COMMITS => return None,
+        GitProviderType::GitLab => {
+            let total = payload
+                .get("total_commits_count")
+                .and_then(Value::as_u64)
+                .unwrap_or(commits.len() as u64);
+            if total > commits.len() as u64 {
+                return None;
+            }
+        }
+        _ => {}
+    }
+
+    let mut paths: Vec<String> = commits
+        .iter()
+        .flat_map(|commit| {
+            ["added", "modified", "removed"]
+                .into_iter()
+                .filter_map(|field| commit.get(field).and_then(Value::as_array))
+                .flatten()
+                .filter_map(Value::as_str)
+                .map(str::to_string)
+                .collect::<Vec<_>>()
+        })
+        .collect();
+    paths.sort();
+    paths.dedup();
+    Some(paths)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use axum::http::HeaderValue;
+    use serde_json::json;
+
+    fn sign(body: &[u8], secret: &str) -> String {
+        let mut mac = Hmac::<Sha256>::new_from_slice(secret.as_bytes()).unwrap();
+        mac.update(body);
+        hex::encode(mac.finalize().into_bytes())
+    }
+
+    fn headers(pairs: &[(&'static str, &str)]) -> HeaderMap {
+        let mut headers = HeaderMap::new();
+        for (name, value) in pairs {
+            headers.insert(*name, HeaderValue::from_str(value).unwrap());
+        }
+        headers
+    }
+
+    #[test]
+    fn test_hmac_signatures_of_each_provider() {
+        let body = br#"{"ref":"refs/heads/main"}"#;
+        let digest = sign(body, "s3cret");
+
+        let github = verifier_for(&GitProviderType::GitHub).unwrap();
+        let signed = headers(&[("X-Hub-Signature-256", &format!("sha256={}", digest))]);
+        assert_eq!(github.verify(&signed, body, "s3cret"), Ok(()));
+        assert_eq!(
+            github.verify(&signed, body, "other"),
+            Err(WebhookVerificationError::InvalidSignature)
+        );
+        assert_eq!(
+            github.verify(&signed, b"{\"ref\":\"refs/heads/evil\"}", "s3cret"),
+            Err(WebhookVerificationError::InvalidSignature)
+        );
+
+        let bitbucket = verifier_for(&GitProviderType::Bitbucket).unwrap();
+        let signed = headers(&[("X-Hub-Signature", &format!("sha256={}", digest))]);
+        assert_eq!(bitbucket.verify(&signed, body, "s3cret"), Ok(()));
+
+        let gitea = verifier_for(&GitProviderType::Gitea).unwrap();
+        let signed = headers(&[("X-Gitea-Signature", &digest)]);
+        assert_eq!(gitea.verify(&signed, body, "s3cret"), Ok(()));
+    }
+
+    #[test]
+    fn test_missing_and_malformed_signatures() {
+        let github = verifier_for(&GitProviderType::GitHub).unwrap();
+        assert_eq!(
+            github.verify(&HeaderMap::new(), b"{}", "s3cret"),
+            Err(WebhookVerificationError::MissingSignature(
+                "X-Hub-Signature-256"
+            ))
+        );
+        assert_eq!(
+            github.verify(
+                &headers(&[("X-Hub-Signature-256", "sha1=abcdef")]),
+                b"{}",
+                "s3cret"
+            ),
+            Err(WebhookVerificationError::MalformedSignature(
+                "X-Hub-Signature-256"
+            ))
+        );
+        assert_eq!(
+            github.verify(
+                &headers(&[("X-Hub-Signature-256", "sha256=not-hex")]),
+                b"{}",
+                "s3cret"
+            ),
+            Err(WebhookVerificationError::MalformedSignature(
+                "X-Hub-Signature-256"
+            ))
+        );
+        assert!(verifier_for(&GitProviderType::Generic).is_err());
+    }
+
+    #[test]
+    fn test_gitlab_token() {
+        let gitlab = verifier_for(&GitProviderType::GitLab).unwrap();
+        let body = b"{}";
+        assert_eq!(
+            gitlab.verify(&headers(&[("X-Gitlab-Token", "s3cret")]), body, "s3cret"),
+            Ok(())
+        );
+        assert_eq!(
+            gitlab.verify(&headers(&[("X-Gitlab-Token", "s3cre")]), body, "s3cret"),
+            Err(WebhookVerificationError::InvalidSignature)
+        );
+    }
+
+    #[test]
+    fn test_push_changed_paths() {
+        let before = "a1b2c3d4e5f60718293a4b5c6d7e8f9012345678";
+        let payload = json!({
+            "before": before,
+            "commits": [
+                {"added": ["apps/api/new.rs"], "modified": ["Cargo.lock"], "removed": []},
+                {"added": [], "modified": ["Cargo.lock"], "removed": ["apps/web/old.ts"]}
+            ]
+        });
+        assert_eq!(
+            push_changed_paths(&GitProviderType::GitHub, &payload),
+            Some(vec![
+                "Cargo.lock".to_string(),
+                "apps/api/new.rs".to_string(),
+                "apps/web/old.ts".to_string(),
+            ])
+        );
+        assert_eq!(
+            push_changed_paths(&GitProviderType::Bitbucket, &payload),
+            None
+        );
+
+        // New branches and force pushes don't list everything that changed
+        let new_branch = json!({
+            "before": "0000000000000000000000000000000000000000",
+            "commits": [{"modified": ["README.md"]}]
+        });
+        assert_eq!(
+            push_changed_paths(&GitProviderType::Gitea, &new_branch),
+            None
+        );
+        let forced = json!({"before": before, "forced": true, "commits": [{"modified": ["a"]}]});
+        assert_eq!(push_changed_paths(&GitProviderType::GitHub, &forced), None);
+
+        let truncated = json!({
+            "before": before,
+            "total_commits_count": 30,
+            "commits": [{"modified": ["README.md"]}]
+        });
+        assert_eq!(
+            push_changed_paths(&GitProviderType::GitLab, &truncated),
+            None
+        );
+    }
+
+    #[test]
+    fn test_parse_push_events() {
+        let sha = "a1b2c3d4e5f60718293a4b5c6d7e8f9012345678";
+
+        let github = parse_push_event(
+            &GitProviderType::GitHub,
+            &headers(&[("X-GitHub-Event", "push")]),
+            &json!({"ref": "refs/heads/main", "after": sha}),
+        );
+        assert_eq!(
+            github,
+            Some(vec![PushedRef {
+                branch: Some("main".to_string()),
+                tag: None,
+                commit: sha.to_string(),
+            }])
+        );
+        assert_eq!(
+            parse_push_event(
+                &GitProviderType::GitHub,
+                &headers(&[("X-GitHub-Event", "ping")]),
+                &json!({}),
+            ),
+            None
+        );
+
+        let deleted = parse_push_event(
+            &GitProviderType::GitLab,
+            &headers(&[("X-Gitlab-Event", "Push Hook")]),
+            &json!({
+                "ref": "refs/heads/feature",
+                "after": "0000000000000000000000000000000000000000",
+                "checkout_sha": null
+            }),
+        );
+        assert_eq!(deleted, Some(vec![]));
+
+        let bitbucket_cloud = parse_push_event(
+            &GitProviderType::Bitbucket,
+            &headers(&[("X-Event-Key", "repo:push")]),
+            &json!({"push": {"changes": [
+                {"new": {"type": "tag", "name": "v1.2.0", "target": {"hash": sha}}},
+                {"new": null, "old": {"type": "branch", "name": "old"}}
+            ]}}),
+        )
+        .unwrap();
+        assert_eq!(bitbucket_cloud.len(), 1);
+        assert_eq!(bitbucket_cloud[0].tag.as_deref(), Some("v1.2.0"));
+
+        let bitbucket_server = parse_push_event(
+            &GitProviderType::Bitbucket,
+            &headers(&[("X-Event-Key", "repo:refs_changed")]),
+            &json!({"changes": [
+                {"ref": {"id": "refs/heads/main", "displayId": "main", "type": "BRANCH"},
+                 "toHash": sha, "type": "UPDATE"}
+            ]}),
+        )
+        .unwrap();
+        assert_eq!(bitbucket_server[0].branch.as_deref(), Some("main"));
+    }
+}
@@ -0,0 +1,626 @@
+//! Docker Compose file model
+//!
+//! Deserializes the parts of the Compose specification the importer maps to Temps.
+//! Every other key is kept in `extra`, so the plan can flag what it can't import
+//! instead of silently dropping it.
+
+use serde::Deserialize;
+use serde_yaml::Value;
+use std::collections::BTreeMap;
+use temps_import_types::{ImportError, ImportResult};
+
+/// A parsed `docker-compose.yml`
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ComposeFile {
+    /// Project name
+    #[serde(default)]
+    pub name: Option<String>,
+    #[serde(default)]
+    pub services: BTreeMap<String, ComposeService>,
+    /// Top-level named volume definitions
+    #[serde(default)]
+    pub volumes: BTreeMap<String, Value>,
+    /// Top-level keys the importer doesn't map (networks, secrets, configs, ...)
+    #[serde(flatten)]
+    pub extra: BTreeMap<String, Value>,
+}
+
+impl ComposeFile {
+    /// Parse a compose file, resolving YAML anchors and merge keys (`<<: *defaults`)
+    pub fn parse(content: &str) -> ImportResult<Self> {
+        let mut value: Value = serde_yaml::from_str(content).map_err(|e| {
+            ImportError::InvalidConfiguration(format!("Invalid compose file: {}", e))
+        })?;
+        value.apply_merge().map_err(|e| {
+            ImportError::InvalidConfiguration(format!("Invalid compose file: {}", e))
+        })?;
+        let file: ComposeFile = serde_yaml::from_value(value).map_err(|e| {
+            ImportError::InvalidConfiguration(format!("Invalid compose file: {}", e))
+        })?;
+
+        if file.services.is_empty() {
+            return Err(ImportError::InvalidConfiguration(
+                "The compose file defines no services".to_string(),
+            ));
+        }
+        Ok(file)
+    }
+}
+
+/// A service of a compose file
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ComposeService {
+    #[serde(default)]
+    pub image: Option<String>,
+    #[serde(default)]
+    pub build: Option<BuildSpec>,
+    #[serde(default)]
+    pub ports: Vec<PortSpec>,
+    /// Ports reachable by other services only
+    #[serde(default)]
+    pub expose: Vec<Scalar>,
+    #[serde(default)]
+    pub environment: EnvironmentSpec,
+    #[serde(default)]
+    pub volumes: Vec<VolumeSpec>,
+    #[serde(default)]
+    pub depends_on: DependsOnSpec,
+    #[serde(default)]
+    pub deploy: Option<DeploySpec>,
+    /// Keys the importer doesn't map
+    #[serde(flatten)]
+    pub extra: BTreeMap<String, Value>,
+}
+
+/// `build:` — a context path, or the long form
+#[derive(Debug, Clone, Deserialize)]
+#[serde(untagged)]
+pub enum BuildSpec {
+    Context(String),
+    Long {
+        #[serde(default)]
+        context: Option<String>,
+        #[serde(default)]
+        dockerfile: Option<String>,
+        #[serde(default)]
+        args: ArgsSpec,
+        #[serde(default)]
+        target: Option<String>,
+        #[serde(flatten)]
+        extra: BTreeMap<String, Value>,
+    },
+}
+
+/// Build arguments, as a map or a `KEY=value` list
+#[derive(Debug, Clone, Deserialize)]
+#[serde(untagged)]
+pub enum ArgsSpec {
+    List(Vec<String>),
+    Map(BTreeMap<String, Option<Scalar>>),
+}
+
+impl Default for ArgsSpec {
+    fn default() -> Self {
+        ArgsSpec::List(Vec::new())
+    }
+}
+
+impl ArgsSpec {
+    /// The arguments with a value; `None` for arguments taken from the host
+    pub fn entries(&self) -> Vec<(String, Option<String>)> {
+        match self {
+            ArgsSpec::List(items) => items.iter().map(|item| split_assignment(item)).collect(),
+            ArgsSpec::Map(map) => map
+                .iter()
+                .map(|(k, v)| (k.clone(), v.as_ref().map(Scalar::to_string)))
+                .collect(),
+        }
+    }
+}
+
+/// `environment:` — a map or a `KEY=value` list
+#[derive(Debug, Clone, Deserialize)]
+#[serde(untagged)]
+pub enum EnvironmentSpec {
+    List(Vec<String>),
+    Map(BTreeMap<String, Option<Scalar>>),
+}
+
+impl Default for EnvironmentSpec {
+    fn default() -> Self {
+        EnvironmentSpec::List(Vec::new())
+    }
+}
+
+impl EnvironmentSpec {
+    /// The variables; `None` for variables taken from the host
+    pub fn entries(&self) -> Vec<(String, Option<String>)> {
+        match self {
+            EnvironmentSpec::List(items) => {
+                items.iter().map(|item| split_assignment(item)).collect()
+            }
+            EnvironmentSpec::Map(map) => map
+                .iter()
+                .map(|(k, v)| (k.clone(), v.as_ref().map(Scalar::to_string)))
+                .collect(),
+        }
+    }
+
+    pub fn get(&self, key: &str) -> Option<String> {
+        self.entries()
+            .into_iter()
+            .find(|(k, _)| k == key)
+            .and_then(|(_, v)| v)
+    }
+}
+
+fn split_assignment(item: &str) -> (String, Option<String>) {
+    match item.split_once('=') {
+        Some((key, value)) => (key.to_string(), Some(value.to_string())),
+        None => (item.to_string(), None),
+    }
+}
+
+/// A YAML scalar written where compose expects a string
+#[derive(Debug, Clone, Deserialize)]
+#[serde(untagged)]
+pub enum Scalar {
+    String(String),
+    Integer(i64),
+    Float(f64),
+    Bool(bool),
+}
+
+impl std::fmt::Display for Scalar {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Scalar::String(s) => write!(f, "{}", s),
+            Scalar::Integer(i) => write!(f, "{}", i),
+            Scalar::Float(n) => write!(f, "{}", n),
+            Scalar::Bool(b) => write!(f, "{}", b),
+        }
+    }
+}
+
+/// An entry of `ports:`
+#[derive(Debug, Clone, Deserialize)]
+#[serde(untagged)]
+pub enum PortSpec {
+    Short(Scalar),
+    Long {
+        target: u16,
+        #[serde(default)]
+        published: Option<Scalar>,
+        #[serde(default)]
+        protocol: Option<String>,
+    },
+}
+
+/// A port published by a service
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PublishedPort {
+    pub container_port: u16,
+    pub host_port: Option<u16>,
+    pub protocol: String,
+}
+
+impl PortSpec {
+    /// Parse the `[host_ip:][published:]target[/protocol]` short form or the long form
+    pub fn parse(&self) -> Result<PublishedPort, String> {
+        match self {
+            PortSpec::Long {
+                target,
+                published,
+                protocol,
+            } => Ok(PublishedPort {
+                container_port: *target,
+                host_port: published
+                    .as_ref()
+                    .map(|p| parse_port(&p.to_string()))
+                    .transpose()?,
+                protocol: protocol.clone().unwrap_or_else(|| "tcp".to_string()),
+            }),
+            PortSpec::Short(spec) => {
+                let spec = spec.to_string();
+                let (mapping, protocol) = match spec.split_once('/') {
+                    Some((mapping, protocol)) => (mapping, protocol.to_string()),
+                    None => (spec.as_str(), "tcp".to_string()),
+                };
+                // An IPv6 host IP is bracketed; everything before the last two parts is the IP
+                let parts: Vec<&str> = mapping.rsplitn(3, ':').collect();
+                let (container_port, host_port) = match parts.as_slice() {
+                    [target] => (parse_port(target)?, None),
+                    [target, published, ..] => {
+                        let host_port = if published.is_empty() {
+                            None
+                        } else {
+                            Some(parse_port(published)?)
+                        };
+                        (parse_port(target)?, host_port)
+                    }
+                    [] => return Err(format!("invalid port '{}'", spec)),
+                };
+                Ok(PublishedPort {
+                    container_port,
+                    host_port,
+                    protocol,
+                })
+            }
+        }
+    }
+}
+
+fn parse_port(port: &str) -> Result<u16, String> {
+    if port.contains('-') {
+        return Err(format!("port ranges like '{}' are not supported", port));
+    }
+    port.parse::<u16>()
+        .map_err(|_| format!("invalid port '{}'", port))
+}
+
+/// An entry of `volumes:`
+#[derive(Debug, Clone, Deserialize)]
+#[serde(untagged)]
+pub enum VolumeSpec {
+    Short(String),
+    Long {
+        #[serde(rename = "type", default)]
+        volume_type: Option<String>,
+        #[serde(default)]
+        source: Option<String>,
+        target: String,
+        #[serde(default)]
+        read_only: bool,
+    },
+}
+
+/// A volume mounted into a service
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct MountedVolume {
+    /// Volume name or host path; None for anonymous volumes
+    pub source: Option<String>,
+    pub target: String,
+    pub read_only: bool,
+    /// "bind", "volume" or "tmpfs"
+    pub volume_type: String,
+}
+
+impl VolumeSpec {
+    /// Parse the `[source:]target[:mode]` short form or the long form
+    pub fn parse(&self) -> MountedVolume {
+        match self {
+            VolumeSpec::Long {
+                volume_type,
+                source,
+                target,
+                read_only,
+            } => MountedVolume {
+                volume_type: volume_type.clone().unwrap_or_else(|| {
+                    if source.as_deref().is_some_and(is_host_path) {
+                        "bind".to_string()
+                    } else {
+                        "volume".to_string()
+                    }
+                }),
+                source: source.clone(),
+                target: target.clone(),
+                read_only: *read_only,
+            },
+            VolumeSpec::Short(spec) => {
+                let parts: Vec<&str> = spec.split(':').collect();
+                let (source, target, mode) = match parts.as_slice() {
+                    [target] => (None, target.to_string(), None),
+                    [source, target] => (Some(source.to_string()), target.to_string(), None),
+                    [source, target, mode, ..] => (
+                        Some(source.to_string()),
+                        target.to_string(),
+                        Some(mode.to_string()),
+                    ),
+                    [] => (None, String::new(), None),
+                };
+                MountedVolume {
+                    volume_type: if source.as_deref().is_some_and(is_host_path) {
+                        "bind".to_string()
+                    } else {
+                        "volume".to_string()
+                    },
+                    read_only: mode
+                        .as_deref()
+                        .is_some_and(|mode| mode.split(',').any(|m| m == "ro")),
+                    source,
+                    target,
+                }
+            }
+        }
+    }
+}
+
+fn is_host_path(source: &str) -> bool {
+    source.starts_with('/')
+        || source.starts_with('.')
+        || source.starts_with('~')
+        || source.starts_with('$')
+}
+
+/// `depends_on:` — a list of services, or a map with start conditions
+#[derive(Debug, Clone, Deserialize)]
+#[serde(untagged)]
+pub enum DependsOnSpec {
+    List(Vec<String>),
+    Map(BTreeMap<String, DependsOnCondition>),
+}
+
+impl Default for DependsOnSpec {
+    fn default() -> Self {
+        DependsOnSpec::List(Vec::new())
+    }
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct DependsOnCondition {
+    #[serde(default)]
+    pub condition: Option<String>,
+}
+
+impl DependsOnSpec {
+    /// The services depended on, with the start condition if one is set
+    pub fn entries(&self) -> Vec<(String, Option<String>)> {
+        match self {
+            DependsOnSpec::List(services) => services.iter().map(|s| (s.clone(), None)).collect(),
+            DependsOnSpec::Map(map) => map
+                .iter()
+                .map(|(service, c)| (service.clone(), c.condition.clone()))
+                .collect(),
+        }
+    }
+}
+
+/// `deploy:`
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct DeploySpec {
+    #[serde(default)]
+    pub replicas: Option<u32>,
+    #[serde(default)]
+    pub resources: Option<ResourcesSpec>,
+    #[serde(flatten)]
+    pub extra: BTreeMap<String, Value>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ResourcesSpec {
+    #[serde(default)]
+    pub limits: Option<ResourceSpec>,
+    #[serde(default)]
+    pub reservations: Option<ResourceSpec>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ResourceSpec {
+    #[serde(default)]
+    pub cpus: Option<Scalar>,
+    #[serde(default)]
+    pub memory: Option<Scalar>,
+}
+
+impl ResourceSpec {
+    /// CPUs in millicores
+    pub fn cpu_millicores(&self) -> Option<i32> {
+        let cpus: f64 = self.cpus.as_ref()?.to_string().parse().ok()?;
+        Some((cpus * 1000.0).round() as i32)
+    }
+
+    /// Memory in MB, from a compose byte value like "512m" or "1g"
+    pub fn memory_mb(&self) -> Option<i32> {
+        let memory = self.memory.as_ref()?.to_string().trim().to_lowercase();
+        let digits_end = memory
+            .find(|c: char| !c.is_ascii_digit() && c != '.')
+            .unwrap_or(memory.len());
+        let amount: f64 = memory[..digits_end].parse().ok()?;
+        let bytes = match memory[digits_end..].trim_end_matches('b') {
+            "" => amount,
+            "k" => amount * 1024.0,
+            "m" => amount * 1024.0 * 1024.0,
+            "g" => amount * 1024.0 * 1024.0 * 1024.0,
+            _ => return None,
+        };
+        Some((bytes / (1024.0 * 1024.0)).round() as i32)
+    }
+}
+
+/// Resolve compose variable interpolation in a value
+///
+/// `${VAR:-default}` and `${VAR-default}` resolve to their default and `$$` to a
+/// literal `$`, since the importer can't see the host environment compose would read.
+/// Returns the names of variables that have no default.
+pub fn interpolate(value: &str) -> (String, Vec<String>) {
+    let mut output = String::with_capacity(value.len());
+    let mut unresolved = Vec::new();
+    let mut rest = value;
+    while let Some(start) = rest.find('$') {
+        output.push_str(&rest[..start]);
+        let after = &rest[start + 1..];
+        if let Some(stripped) = after.strip_prefix('$') {
+            output.push('$');
+            rest = stripped;
+        } else if let Some(braced) = after.strip_prefix('{') {
+            let Some(end) = braced.find('}') else {
+                output.push_str(&rest[start..]);
+                rest = "";
+                continue;
+            };
+            let expression = &braced[..end];
+            let (name, default) = match expression.find([':', '-', '?', '+']) {
+                Some(i) => {
+                    let operator = &expression[i..];
+                    let default = operator
+                        .strip_prefix(":-")
+                        .or_else(|| operator.strip_prefix('-'));
+                    (&expression[..i], default)
+                }
+                None => (expression, None),
+            };
+            match default {
+                Some(default) => output.push_str(default),
+                None => unresolved.push(name.to_string()),
+            }
+            rest = &braced[end + 1..];
+        } else {
+            let name_len = after
+                .find(|c: char| !c.is_ascii_alphanumeric() && c != '_')
+                .unwrap_or(after.len());
+            if name_len == 0 {
+                output.push('$');
+            } else {
+                unresolved.push(after[..name_len].to_string());
+            }
+            rest = &after[name_len..];
+        }
+    }
+    output.push_str(rest);
+    (output, unresolved)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_compose_file() {
+        let file = ComposeFile::parse(
+            r#"
+x-env: &env
+  NODE_ENV: production
+services:
+  web:
+    build:
+      context: ./web
+      dockerfile: Dockerfile.prod
+      args:
+        - VERSION=1
+    ports:
+      - "8080:3000"
+      - target: 9229
+    environment:
+      <<: *env
+      PORT: 3000
+      DEBUG:
+    depends_on:
+      db:
+        condition: service_healthy
+    privileged: true
+  db:
+    image: postgres:16
+    environment:
+      - POSTGRES_PASSWORD=secret
+    volumes:
+      - pgdata:/var/lib/postgresql/data
+volumes:
+  pgdata:
+networks:
+  backend:
+"#,
+        )
+        .unwrap();
+
+        let web = &file.services["web"];
+        assert!(matches!(
+            &web.build,
+            Some(BuildSpec::Long { context: Some(c), dockerfile: Some(d), .. })
+                if c == "./web" && d == "Dockerfile.prod"
+        ));
+        assert_eq!(
+            web.environment.entries(),
+            vec![
+                ("DEBUG".to_string(), None),
+                ("NODE_ENV".to_string(), Some("production".to_string())),
+                ("PORT".to_string(), Some("3000".to_string())),
+            ]
+        );
+        assert_eq!(
+            web.depends_on.entries(),
+            vec![("db".to_string(), Some("service_healthy".to_string()))]
+        );
+        assert!(web.extra.contains_key("privileged"));
+        assert_eq!(
+            file.services["db"].environment.get("POSTGRES_PASSWORD"),
+            Some("secret".to_string())
+        );
+        assert!(file.volumes.contains_key("pgdata"));
+        assert!(file.extra.contains_key("networks"));
+
+        assert!(ComposeFile::parse("services: {}").is_err());
+        assert!(ComposeFile::parse("services: [").is_err());
+    }
+
+    #[test]
+    fn test_parse_ports() {
+        let port = |spec: &str| PortSpec::Short(Scalar::String(spec.to_string())).parse();
+        assert_eq!(
+            port("8080:80").unwrap(),
+            PublishedPort {
+                container_port: 80,
+                host_port: Some(8080),
+                protocol: "tcp".to_string()
+            }
+        );
+        assert_eq!(port("3000").unwrap().host_port, None);
+        let udp = port("127.0.0.1:5353:53/udp").unwrap();
+        assert_eq!((udp.container_port, udp.host_port), (53, Some(5353)));
+        assert_eq!(udp.protocol, "udp");
+        assert!(port("8000-8010:8000-8010").is_err());
+        assert_eq!(
+            PortSpec::Short(Scalar::Integer(5432))
+                .parse()
+                .unwrap()
+                .container_port,
+            5432
+        );
+    }
+
+    #[test]
+    fn test_parse_volumes() {
+        let named = VolumeSpec::Short("pgdata:/var/lib/postgresql/data".to_string()).parse();
+        assert_eq!(named.volume_type, "volume");
+        assert_eq!(named.source.as_deref(), Some("pgdata"));
+
+        let bind = VolumeSpec::Short("./config:/etc/app:ro".to_string()).parse();
+        assert_eq!(bind.volume_type, "bind");
+        assert!(bind.read_only);
+
+        let anonymous = VolumeSpec::Short("/tmp/cache".to_string()).parse();
+        assert_eq!(anonymous.source, None);
+        assert_eq!(anonymous.target, "/tmp/cache");
+    }
+
+    #[test]
+    fn test_resources() {
+        let spec = ResourceSpec {
+            cpus: Some(Scalar::String("0.5".to_string())),
+            memory: Some(Scalar::String("512M".to_string())),
+        };
+        assert_eq!(spec.cpu_millicores(), Some(500));
+        assert_eq!(spec.memory_mb(), Some(512));
+        let gigabytes = ResourceSpec {
+            cpus: Some(Scalar::Integer(2)),
+            memory: Some(Scalar::String("1.5gb".to_string())),
+        };
+        assert_eq!(gigabytes.cpu_millicores(), Some(2000));
+        assert_eq!(gigabytes.memory_mb(), Some(1536));
+    }
+
+    #[test]
+    fn test_interpolate() {
+        assert_eq!(
+            interpolate("postgres://${DB_USER:-app}@db/${DB_NAME-app}"),
+            ("postgres://app@db/app".to_string(), vec![])
+        );
+        assert_eq!(
+            interpolate("$${literal} ${SECRET} $HOME"),
+            (
+                "${literal}  ".to_string(),
+                vec!["SECRET".to_string(), "HOME".to_string()]
+            )
+        );
+        assert_eq!(interpolate("price: 5$"), ("price: 5$".to_string(), vec![]));
+    }
+}
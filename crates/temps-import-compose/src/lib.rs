@@ -0,0 +1,14 @@
+//! Docker Compose Importer
+//!
+//! Turns a `docker-compose.yml` into a plan of Temps resources: databases, caches
+//! and object storage become managed services, services with a build context become
+//! projects deployed from the repository. Features Temps can't reproduce are
+//! reported in the plan.
+
+pub mod compose;
+pub mod plan;
+
+pub use compose::ComposeFile;
+pub use plan::{
+    plan_compose, ComposeImportPlan, ComposeServicePlan, ServiceTarget, UnsupportedFeature,
+};
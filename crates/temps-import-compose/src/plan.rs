@@ -0,0 +1,911 @@
+//! Compose import plans
+//!
+//! Maps the services of a compose file to Temps: database, cache and object storage
+//! images become managed services, services with a build context become projects
+//! built from the repository the file belongs to. Whatever Temps can't reproduce is
+//! listed as an unsupported feature rather than dropped.
+
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, BTreeSet};
+use temps_import_types::plan::{BuildConfiguration, EnvironmentVariable, ResourceLimits};
+use temps_import_types::{ImportError, ImportResult};
+use utoipa::ToSchema;
+
+use crate::compose::{interpolate, BuildSpec, ComposeFile, ComposeService};
+
+/// Ports treated as the HTTP port of a service when it exposes several
+const HTTP_PORTS: [u16; 6] = [80, 443, 8080, 8000, 3000, 5000];
+
+/// Plan for onboarding the services of a compose file
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ComposeImportPlan {
+    /// Name of the stack, used as the prefix of the created resources
+    #[schema(example = "shop")]
+    pub name: String,
+    /// Services in creation order: every service comes after the ones it depends on
+    pub services: Vec<ComposeServicePlan>,
+    /// Compose features that won't be imported
+    pub unsupported: Vec<UnsupportedFeature>,
+    pub warnings: Vec<String>,
+}
+
+impl ComposeImportPlan {
+    /// Whether any service will be built from the repository
+    pub fn has_projects(&self) -> bool {
+        self.services
+            .iter()
+            .any(|s| matches!(s.target, ServiceTarget::Project { .. }))
+    }
+}
+
+/// What a compose service becomes in Temps
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct ComposeServicePlan {
+    /// Compose service name
+    #[schema(example = "web")]
+    pub name: String,
+    pub target: ServiceTarget,
+    /// Container port the proxy routes traffic to
+    pub port: Option<u16>,
+    pub env_vars: Vec<EnvironmentVariable>,
+    /// Services this one depends on
+    pub depends_on: Vec<String>,
+    /// Managed services linked to the project; Temps injects their connection variables
+    pub linked_services: Vec<String>,
+    pub resources: ResourceLimits,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[serde(tag = "kind", rename_all = "snake_case")]
+pub enum ServiceTarget {
+    /// A project built from the repository with the Dockerfile preset
+    Project { build: BuildConfiguration },
+    /// A Temps managed service (postgres, redis, mongodb or s3)
+    ManagedService {
+        service_type: String,
+        #[schema(example = "postgres:16-alpine")]
+        docker_image: String,
+        /// Service parameters taken from the image's environment (credentials, database)
+        parameters: BTreeMap<String, String>,
+    },
+}
+
+/// A compose feature the import leaves out
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct UnsupportedFeature {
+    /// Service using the feature; None for top-level settings
+    pub service: Option<String>,
+    /// Compose key, e.g. "privileged" or "deploy.replicas"
+    #[schema(example = "privileged")]
+    pub feature: String,
+    pub detail: String,
+}
+
+/// Generate the import plan of a compose file
+///
+/// `name` overrides the stack name; it defaults to the file's `name:`. Fails on
+/// references Temps can't resolve: dependencies on services that don't exist and
+/// dependency cycles.
+pub fn plan_compose(file: &ComposeFile, name: Option<&str>) -> ImportResult<ComposeImportPlan> {
+    let name = slugify(name.or(file.name.as_deref()).unwrap_or("compose"));
+    let mut planner = Planner::default();
+
+    for key in file.extra.keys() {
+        if key == "version" || key.starts_with("x-") {
+            continue;
+        }
+        let detail = match key.as_str() {
+            "networks" => "Temps connects services itself; custom networks are not created",
+            "secrets" | "configs" => {
+                "Compose secrets and configs are not imported; set them as environment variables"
+            }
+            _ => "Not supported by the compose importer",
+        };
+        planner.unsupported(None, key, detail);
+    }
+
+    let mut services = Vec::new();
+    for (service_name, service) in &file.services {
+        if let Some(plan) = planner.plan_service(service_name, service)? {
+            services.push(plan);
+        }
+    }
+
+    for dependency in file
+        .services
+        .values()
+        .flat_map(|service| service.depends_on.entries())
+        .map(|(dependency, _)| dependency)
+    {
+        if !file.services.contains_key(&dependency) {
+            return Err(ImportError::InvalidConfiguration(format!(
+                "A service depends on '{}', which is not defined",
+                dependency
+            )));
+        }
+    }
+
+    planner.link_services(&mut services, file);
+    let services = creation_order(services)?;
+
+    Ok(ComposeImportPlan {
+        name,
+        services,
+        unsupported: planner.unsupported,
+        warnings: planner.warnings,
+    })
+}
+
+#[derive(Default)]
+struct Planner {
+    unsupported: Vec<UnsupportedFeature>,
+    warnings: Vec<String>,
+}
+
+impl Planner {
+    fn unsupported(&mut self, service: Option<&str>, feature: &str, detail: impl Into<String>) {
+        self.unsupported.push(UnsupportedFeature {
+            service: service.map(|s| s.to_string()),
+            feature: feature.to_string(),
+            detail: detail.into(),
+        });
+    }
+
+    fn plan_service(
+        &mut self,
+        name: &str,
+        service: &ComposeService,
+    ) -> ImportResult<Option<ComposeServicePlan>> {
+        let image = service.image.as_deref().map(|image| {
+            let (image, unresolved) = interpolate(image);
+            if !unresolved.is_empty() {
+                self.warnings.push(format!(
+                    "The image of '{}' uses variables without a default: {}",
+                    name,
+                    unresolved.join(", ")
+                ));
+            }
+            image
+        });
+
+        let managed_type = match (&service.build, image.as_deref()) {
+            (None, Some(image)) => managed_service_type(image),
+            _ => None,
+        };
+        let target = match (managed_type, &service.build) {
+            (Some(service_type), _) => ServiceTarget::ManagedService {
+                service_type: service_type.to_string(),
+                docker_image: image.clone().unwrap_or_default(),
+                parameters: managed_parameters(service_type, service),
+            },
+            (None, Some(build)) => match self.build_configuration(name, build) {
+                Some(build) => ServiceTarget::Project { build },
+                None => return Ok(None),
+            },
+            (None, None) => {
+                self.unsupported(
+                    Some(name),
+                    "image",
+                    format!(
+                        "'{}' runs the prebuilt image {} without a build context; only \
+                         databases, caches and object storage images can be imported, other \
+                         services need a build context in the repository",
+                        name,
+                        image.as_deref().unwrap_or("(none)")
+                    ),
+                );
+                return Ok(None);
+            }
+        };
+        let managed = matches!(target, ServiceTarget::ManagedService { .. });
+
+        let port = self.primary_port(name, service, managed);
+        let env_vars = if managed {
+            self.flag_managed_environment(name, service);
+            Vec::new()
+        } else {
+            self.environment(name, service)
+        };
+
+        for volume in service.volumes.iter().map(|v| v.parse()) {
+            let mount = match &volume.source {
+                Some(source) => format!("{}:{}", source, volume.target),
+                None => volume.target.clone(),
+            };
+            let detail = if managed {
+                format!(
+                    "Temps stores the data of managed services itself; the data in {} is not migrated",
+                    mount
+                )
+            } else {
+                format!(
+                    "Temps containers don't mount volumes; {} ({}) is not imported. Keep \
+                     persistent data in a managed service or object storage",
+                    mount, volume.volume_type
+                )
+            };
+            self.unsupported(Some(name), "volumes", detail);
+        }
+
+        let mut depends_on = Vec::new();
+        for (dependency, condition) in service.depends_on.entries() {
+            if let Some(condition) = condition.filter(|c| c != "service_started") {
+                self.unsupported(
+                    Some(name),
+                    &format!("depends_on.{}", dependency),
+                    format!(
+                        "Services are created in dependency order, but startup doesn't wait for '{}'",
+                        condition
+                    ),
+                );
+            }
+            depends_on.push(dependency);
+        }
+
+        let resources = self.resources(name, service, managed);
+
+        for key in service.extra.keys() {
+            if key.starts_with("x-") {
+                continue;
+            }
+            let detail = match key.as_str() {
+                "command" | "entrypoint" | "working_dir" | "user" => format!(
+                    "Temps runs the image's own {}; set it in the Dockerfile",
+                    key
+                ),
+                "env_file" => "Env files are not read; import them with the .env import of \
+                               environment variables"
+                    .to_string(),
+                "healthcheck" => "Temps health-checks the routed port; the compose health \
+                                  check is not imported"
+                    .to_string(),
+                "restart" => "Temps restarts containers that stop on its own".to_string(),
+                "container_name" | "hostname" => {
+                    "Temps names containers itself; other services reach this one through \
+                     Temps"
+                        .to_string()
+                }
+                "networks" | "network_mode" | "links" | "extra_hosts" => {
+                    "Temps connects services itself; custom networking is not imported".to_string()
+                }
+                "profiles" => "Services are imported regardless of their profiles".to_string(),
+                _ => "Not supported by the compose importer".to_string(),
+            };
+            self.unsupported(Some(name), key, detail);
+        }
+
+        Ok(Some(ComposeServicePlan {
+            name: name.to_string(),
+            target,
+            port,
+            env_vars,
+            depends_on,
+            linked_services: Vec::new(),
+            resources,
+        }))
+    }
+
+    fn build_configuration(&mut self, name: &str, build: &BuildSpec) -> Option<BuildConfiguration> {
+        let (context, dockerfile, args, target) = match build {
+            BuildSpec::Context(context) => (Some(context.clone()), None, Vec::new(), None),
+            BuildSpec::Long {
+                context,
+                dockerfile,
+                args,
+                target,
+                extra,
+            } => {
+                for key in extra.keys() {
+                    self.unsupported(
+                        Some(name),
+                        &format!("build.{}", key),
+                        "Not supported by the compose importer",
+                    );
+                }
+                (
+                    context.clone(),
+                    dockerfile.clone(),
+                    args.entries(),
+                    target.clone(),
+                )
+            }
+        };
+
+        let context = context.unwrap_or_else(|| ".".to_string());
+        let Some(context) = repository_path(&context) else {
+            self.unsupported(
+                Some(name),
+                "build.context",
+                format!(
+                    "The build context '{}' is outside the repository; only contexts inside \
+                     the repository can be built",
+                    context
+                ),
+            );
+            return None;
+        };
+
+        let mut build_args = std::collections::HashMap::new();
+        for (key, value) in args {
+            match value {
+                Some(value) => {
+                    build_args.insert(key, interpolate(&value).0);
+                }
+                None => self.unsupported(
+                    Some(name),
+                    &format!("build.args.{}", key),
+                    "Takes its value from the host environment",
+                ),
+            }
+        }
+
+        Some(BuildConfiguration {
+            context,
+            dockerfile,
+            args: build_args,
+            target,
+        })
+    }
+
+    fn primary_port(&mut self, name: &str, service: &ComposeService, managed: bool) -> Option<u16> {
+        let mut ports = Vec::new();
+        for spec in &service.ports {
+            match spec.parse() {
+                Ok(port) => ports.push(port),
+                Err(e) => self.unsupported(Some(name), "ports", e),
+            }
+        }
+        if managed {
+            if ports.iter().any(|p| p.host_port.is_some()) {
+                self.warnings.push(format!(
+                    "'{}' publishes ports on the host; Temps manages the ports of managed services",
+                    name
+                ));
+            }
+            return None;
+        }
+
+        for spec in &service.expose {
+            match spec.to_string().parse::<u16>() {
+                Ok(container_port) if !ports.iter().any(|p| p.container_port == container_port) => {
+                    ports.push(crate::compose::PublishedPort {
+                        container_port,
+                        host_port: None,
+                        protocol: "tcp".to_string(),
+                    })
+                }
+                Ok(_) => {}
+                Err(_) => self.unsupported(
+                    Some(name),
+                    "expose",
+                    format!("Invalid or ranged port '{}'", spec),
+                ),
+            }
+        }
+
+        let tcp: Vec<_> = ports.iter().filter(|p| p.protocol == "tcp").collect();
+        let primary = tcp
+            .iter()
+            .find(|p| HTTP_PORTS.contains(&p.container_port))
+            .or_else(|| tcp.first())
+            .map(|p| p.container_port);
+
+        for port in &ports {
+            if Some(port.container_port) == primary && port.protocol == "tcp" {
+                if let Some(host_port) = port.host_port {
+                    self.warnings.push(format!(
+                        "'{}' publishes port {} on the host; Temps serves it through its proxy instead",
+                        name, host_port
+                    ));
+                }
+            } else {
+                self.unsupported(
+                    Some(name),
+                    "ports",
+                    format!(
+                        "Only one HTTP port is routed per project; {}/{} is not reachable",
+                        port.container_port, port.protocol
+                    ),
+                );
+            }
+        }
+        primary
+    }
+
+    fn environment(&mut self, name: &str, service: &ComposeService) -> Vec<EnvironmentVariable> {
+        let mut env_vars = Vec::new();
+        for (key, value) in service.environment.entries() {
+            let Some(value) = value else {
+                self.unsupported(
+                    Some(name),
+                    &format!("environment.{}", key),
+                    "Takes its value from the host environment; set it in Temps after the import",
+                );
+                continue;
+            };
+            let (value, unresolved) = interpolate(&value);
+            if !unresolved.is_empty() {
+                self.warnings.push(format!(
+                    "{} of '{}' uses variables without a default ({}); check its value after the import",
+                    key,
+                    name,
+                    unresolved.join(", ")
+                ));
+            }
+            env_vars.push(EnvironmentVariable {
+                is_secret: is_secret_key(&key),
+                key,
+                value,
+            });
+        }
+        env_vars
+    }
+
+    /// Managed services are configured through their parameters; other variables are dropped
+    fn flag_managed_environment(&mut self, name: &str, service: &ComposeService) {
+        let ignored: Vec<String> = service
+            .environment
+            .entries()
+            .into_iter()
+            .map(|(key, _)| key)
+            .filter(|key| !PARAMETER_VARIABLES.contains(&key.as_str()))
+            .collect();
+        if !ignored.is_empty() {
+            self.unsupported(
+                Some(name),
+                "environment",
+                format!(
+                    "Only the credentials and database of managed services are carried over; {} \
+                     not imported",
+                    ignored.join(", ")
+                ),
+            );
+        }
+    }
+
+    fn resources(&mut self, name: &str, service: &ComposeService, managed: bool) -> ResourceLimits {
+        let mut resources = ResourceLimits {
+            cpu_limit: None,
+            memory_limit: None,
+            cpu_request: None,
+            memory_request: None,
+        };
+        let Some(deploy) = &service.deploy else {
+            return resources;
+        };
+
+        if let Some(spec) = &deploy.resources {
+            if managed {
+                self.unsupported(
+                    Some(name),
+                    "deploy.resources",
+                    "Resource limits of managed services are not imported",
+                );
+            } else {
+                if let Some(limits) = &spec.limits {
+                    resources.cpu_limit = limits.cpu_millicores();
+                    resources.memory_limit = limits.memory_mb();
+                }
+                if let Some(reservations) = &spec.reservations {
+                    resources.cpu_request = reservations.cpu_millicores();
+                    resources.memory_request = reservations.memory_mb();
+                }
+            }
+        }
+        if deploy.replicas.is_some_and(|replicas| replicas != 1) {
+            self.unsupported(
+                Some(name),
+                "deploy.replicas",
+                "Set the number of replicas in the environment settings after the import",
+            );
+        }
+        for key in deploy.extra.keys() {
+            self.unsupported(
+                Some(name),
+                &format!("deploy.{}", key),
+                "Not supported by the compose importer",
+            );
+        }
+        resources
+    }
+
+    /// Link projects to the managed services they use and point out hostname references
+    ///
+    /// In compose, services reach each other by service name on a shared network. Temps
+    /// instead injects the connection variables of linked managed services, and projects
+    /// reach each other through their URLs.
+    fn link_services(&mut self, services: &mut [ComposeServicePlan], file: &ComposeFile) {
+        let managed: BTreeSet<String> = services
+            .iter()
+            .filter(|s| matches!(s.target, ServiceTarget::ManagedService { .. }))
+            .map(|s| s.name.clone())
+            .collect();
+        let hosts: BTreeSet<&String> = file.services.keys().collect();
+
+        for service in services.iter_mut() {
+            if !matches!(service.target, ServiceTarget::Project { .. }) {
+                continue;
+            }
+            let mut linked: BTreeSet<String> = service
+                .depends_on
+                .iter()
+                .filter(|dependency| managed.contains(*dependency))
+                .cloned()
+                .collect();
+
+            for var in &service.env_vars {
+                for host in referenced_hosts(&var.value, &hosts) {
+                    if host == &service.name {
+                        continue;
+                    }
+                    if managed.contains(host) {
+                        linked.insert(host.clone());
+                        self.warnings.push(format!(
+                            "{} of '{}' reaches '{}' by its compose hostname; Temps links '{}' \
+                             and injects its connection variables, use those instead",
+                            var.key, service.name, host, host
+                        ));
+                    } else {
+                        self.warnings.push(format!(
+                            "{} of '{}' reaches '{}' by its compose hostname; use the URL of \
+                             the '{}' project instead",
+                            var.key, service.name, host, host
+                        ));
+                    }
+                }
+            }
+            service.linked_services = linked.into_iter().collect();
+        }
+    }
+}
+
+/// Order services so each comes after its dependencies, alphabetically otherwise
+fn creation_order(services: Vec<ComposeServicePlan>) -> ImportResult<Vec<ComposeServicePlan>> {
+    let names: BTreeSet<String> = services.iter().map(|s| s.name.clone()).collect();
+    let mut remaining: BTreeMap<String, ComposeServicePlan> = services
+        .into_iter()
+        .map(|service| (service.name.clone(), service))
+        .collect();
+    let mut ordered: Vec<ComposeServicePlan> = Vec::new();
+
+    while !remaining.is_empty() {
+        let ready = remaining
+            .values()
+            .find(|service| {
+                service.depends_on.iter().all(|dependency| {
+                    // Dependencies that are not imported don't hold the service back
+                    !names.contains(dependency) || ordered.iter().any(|s| &s.name == dependency)
+                })
+            })
+            .map(|service| service.name.clone());
+        match ready {
+            Some(name) => ordered.extend(remaining.remove(&name)),
+            None => {
+                let cycle: Vec<&str> = remaining.keys().map(|s| s.as_str()).collect();
+                return Err(ImportError::InvalidConfiguration(format!(
+                    "The services {} depend on each other in a cycle",
+                    cycle.join(", ")
+                )));
+            }
+        }
+    }
+    Ok(ordered)
+}
+
+/// Environment variables the managed service parameters are read from
+const PARAMETER_VARIABLES: [&str; 9] = [
+    "POSTGRES_USER",
+    "POSTGRES_PASSWORD",
+    "POSTGRES_DB",
+    "REDIS_PASSWORD",
+    "MONGO_INITDB_ROOT_USERNAME",
+    "MONGO_INITDB_ROOT_PASSWORD",
+    "MONGO_INITDB_DATABASE",
+    "MINIO_ROOT_USER",
+    "MINIO_ROOT_PASSWORD",
+];
+
+/// Managed service type matching an image, if Temps offers one
+fn managed_service_type(image: &str) -> Option<&'static str> {
+    match image_repository(image).as_str() {
+        "postgres"
+        | "postgis/postgis"
+        | "pgvector/pgvector"
+        | "timescale/timescaledb"
+        | "timescale/timescaledb-ha" => Some("postgres"),
+        "redis" | "redis/redis-stack-server" => Some("redis"),
+        "mongo" => Some("mongodb"),
+        "minio/minio" => Some("s3"),
+        _ => None,
+    }
+}
+
+/// Repository of an image reference, without registry, tag or digest
+fn image_repository(image: &str) -> String {
+    let image = image.split('@').next().unwrap_or(image);
+    let image = match image.rfind(':') {
+        Some(colon) if !image[colon..].contains('/') => &image[..colon],
+        _ => image,
+    };
+    let mut parts: Vec<&str> = image.split('/').collect();
+    if parts.len() > 1
+        && (parts[0].contains('.') || parts[0].contains(':') || parts[0] == "localhost")
+    {
+        parts.remove(0);
+    }
+    if parts.len() > 1 && parts[0] == "library" {
+        parts.remove(0);
+    }
+    parts.join("/").to_lowercase()
+}
+
+fn managed_parameters(service_type: &str, service: &ComposeService) -> BTreeMap<String, String> {
+    let env = |key: &str| {
+        service
+            .environment
+            .get(key)
+            .map(|value| interpolate(&value).0)
+            .filter(|value| !value.is_empty())
+    };
+    let mut parameters = BTreeMap::new();
+    match service_type {
+        "postgres" => {
+            let username = env("POSTGRES_USER").unwrap_or_else(|| "postgres".to_string());
+            let database = env("POSTGRES_DB").unwrap_or_else(|| username.clone());
+            parameters.insert("username".to_string(), username);
+            parameters.insert("database".to_string(), database);
+            parameters.extend(env("POSTGRES_PASSWORD").map(|p| ("password".to_string(), p)));
+        }
+        "redis" => {
+            parameters.extend(env("REDIS_PASSWORD").map(|p| ("password".to_string(), p)));
+        }
+        "mongodb" => {
+            let username = env("MONGO_INITDB_ROOT_USERNAME").unwrap_or_else(|| "admin".to_string());
+            let database = env("MONGO_INITDB_DATABASE").unwrap_or_else(|| "admin".to_string());
+            parameters.insert("username".to_string(), username);
+            parameters.insert("database".to_string(), database);
+            parameters
+                .extend(env("MONGO_INITDB_ROOT_PASSWORD").map(|p| ("password".to_string(), p)));
+        }
+        "s3" => {
+            parameters.extend(env("MINIO_ROOT_USER").map(|k| ("access_key".to_string(), k)));
+            parameters.extend(env("MINIO_ROOT_PASSWORD").map(|k| ("secret_key".to_string(), k)));
+        }
+        _ => {}
+    }
+    parameters
+}
+
+/// A build context as a path inside the repository, or None if it points outside
+fn repository_path(context: &str) -> Option<String> {
+    if context.starts_with('/') || context.contains("://") || context.starts_with("git@") {
+        return None;
+    }
+    let mut parts = Vec::new();
+    for part in context.split('/') {
+        match part {
+            "" | "." => {}
+            ".." => return None,
+            part => parts.push(part),
+        }
+    }
+    if parts.is_empty() {
+        Some(".".to_string())
+    } else {
+        Some(parts.join("/"))
+    }
+}
+
+/// Service names appearing as a host in a value, e.g. `db` in `postgres://u@db:5432/app`
+fn referenced_hosts<'a>(value: &str, hosts: &BTreeSet<&'a String>) -> Vec<&'a String> {
+    let tokens: BTreeSet<&str> = value
+        .split(|c: char| !c.is_ascii_alphanumeric() && c != '-' && c != '_' && c != '.')
+        .collect();
+    hosts
+        .iter()
+        .filter(|host| tokens.contains(host.as_str()))
+        .copied()
+        .collect()
+}
+
+fn is_secret_key(key: &str) -> bool {
+    let key = key.to_uppercase();
+    ["SECRET", "PASSWORD", "TOKEN", "KEY"]
+        .iter()
+        .any(|word| key.contains(word))
+}
+
+fn slugify(name: &str) -> String {
+    let slug = name
+        .to_lowercase()
+        .replace(|c: char| !c.is_ascii_alphanumeric() && c != '-', "-")
+        .trim_matches('-')
+        .to_string();
+    if slug.is_empty() {
+        "compose".to_string()
+    } else {
+        slug
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const STACK: &str = r#"
+name: Shop
+services:
+  web:
+    build: ./web
+    ports:
+      - "8080:3000"
+      - "9229:9229"
+    environment:
+      DATABASE_URL: postgres://shop:${DB_PASSWORD:-secret}@db:5432/shop
+      API_URL: http://api:4000
+      SESSION_SECRET: s3cret
+      FROM_HOST:
+    depends_on:
+      - api
+      - cache
+    deploy:
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 512M
+  api:
+    build:
+      context: services/api
+      dockerfile: Dockerfile.prod
+      target: runtime
+    expose:
+      - "4000"
+    depends_on:
+      db:
+        condition: service_healthy
+    command: ["node", "server.js"]
+  db:
+    image: postgres:16-alpine
+    environment:
+      POSTGRES_USER: shop
+      POSTGRES_PASSWORD: secret
+      PGDATA: /data
+    volumes:
+      - pgdata:/var/lib/postgresql/data
+  cache:
+    image: redis:7
+  proxy:
+    image: nginx:1.27
+volumes:
+  pgdata:
+networks:
+  default:
+"#;
+
+    fn plan() -> ComposeImportPlan {
+        plan_compose(&ComposeFile::parse(STACK).unwrap(), None).unwrap()
+    }
+
+    fn service<'a>(plan: &'a ComposeImportPlan, name: &str) -> &'a ComposeServicePlan {
+        plan.services.iter().find(|s| s.name == name).unwrap()
+    }
+
+    fn flagged(plan: &ComposeImportPlan, service: Option<&str>, feature: &str) -> bool {
+        plan.unsupported
+            .iter()
+            .any(|u| u.service.as_deref() == service && u.feature == feature)
+    }
+
+    #[test]
+    fn test_plan_maps_services() {
+        let plan = plan();
+        assert_eq!(plan.name, "shop");
+
+        // Dependencies come first: db before api, api and cache before web
+        let order: Vec<&str> = plan.services.iter().map(|s| s.name.as_str()).collect();
+        assert_eq!(order, vec!["cache", "db", "api", "web"]);
+
+        match &service(&plan, "db").target {
+            ServiceTarget::ManagedService {
+                service_type,
+                docker_image,
+                parameters,
+            } => {
+                assert_eq!(service_type, "postgres");
+                assert_eq!(docker_image, "postgres:16-alpine");
+                assert_eq!(parameters["username"], "shop");
+                assert_eq!(parameters["database"], "shop");
+                assert_eq!(parameters["password"], "secret");
+            }
+            other => panic!("expected a managed service, got {:?}", other),
+        }
+
+        let api = service(&plan, "api");
+        match &api.target {
+            ServiceTarget::Project { build } => {
+                assert_eq!(build.context, "services/api");
+                assert_eq!(build.dockerfile.as_deref(), Some("Dockerfile.prod"));
+                assert_eq!(build.target.as_deref(), Some("runtime"));
+            }
+            other => panic!("expected a project, got {:?}", other),
+        }
+        assert_eq!(api.port, Some(4000));
+        assert_eq!(api.linked_services, vec!["db".to_string()]);
+
+        let web = service(&plan, "web");
+        assert_eq!(web.port, Some(3000));
+        assert_eq!(web.resources.cpu_limit, Some(500));
+        assert_eq!(web.resources.memory_limit, Some(512));
+        assert_eq!(
+            web.linked_services,
+            vec!["cache".to_string(), "db".to_string()]
+        );
+        let database_url = web
+            .env_vars
+            .iter()
+            .find(|v| v.key == "DATABASE_URL")
+            .unwrap();
+        assert_eq!(database_url.value, "postgres://shop:secret@db:5432/shop");
+        assert!(web
+            .env_vars
+            .iter()
+            .any(|v| v.key == "SESSION_SECRET" && v.is_secret));
+    }
+
+    #[test]
+    fn test_plan_flags_unsupported_features() {
+        let plan = plan();
+
+        assert!(flagged(&plan, Some("proxy"), "image"));
+        assert!(!plan.services.iter().any(|s| s.name == "proxy"));
+        assert!(flagged(&plan, Some("web"), "environment.FROM_HOST"));
+        assert!(flagged(&plan, Some("web"), "ports"));
+        assert!(flagged(&plan, Some("api"), "command"));
+        assert!(flagged(&plan, Some("api"), "depends_on.db"));
+        assert!(flagged(&plan, Some("db"), "volumes"));
+        assert!(flagged(&plan, Some("db"), "environment"));
+        assert!(flagged(&plan, None, "networks"));
+
+        assert!(plan.warnings.iter().any(|w| w.contains("API_URL of 'web'")));
+        assert!(plan
+            .warnings
+            .iter()
+            .any(|w| w.contains("publishes port 8080")));
+    }
+
+    #[test]
+    fn test_plan_rejects_broken_dependencies() {
+        let missing =
+            ComposeFile::parse("services:\n  web:\n    build: .\n    depends_on: [db]\n").unwrap();
+        assert!(plan_compose(&missing, None).is_err());
+
+        let cycle = ComposeFile::parse(
+            "services:\n  a:\n    build: ./a\n    depends_on: [b]\n  b:\n    build: ./b\n    depends_on: [a]\n",
+        )
+        .unwrap();
+        assert!(plan_compose(&cycle, Some("stack")).is_err());
+    }
+
+    #[test]
+    fn test_build_context_outside_the_repository() {
+        let file = ComposeFile::parse("services:\n  web:\n    build: ../other\n").unwrap();
+        let plan = plan_compose(&file, None).unwrap();
+        assert!(plan.services.is_empty());
+        assert!(flagged(&plan, Some("web"), "build.context"));
+        assert_eq!(repository_path("./web/"), Some("web".to_string()));
+        assert_eq!(repository_path("."), Some(".".to_string()));
+    }
+
+    #[test]
+    fn test_image_repository() {
+        assert_eq!(image_repository("postgres:16"), "postgres");
+        assert_eq!(image_repository("docker.io/library/redis:7"), "redis");
+        assert_eq!(
+            image_repository("localhost:5000/pgvector/pgvector:pg16"),
+            "pgvector/pgvector"
+        );
+        assert_eq!(image_repository("mongo@sha256:abc"), "mongo");
+        assert_eq!(managed_service_type("minio/minio:latest"), Some("s3"));
+        assert_eq!(managed_service_type("nginx"), None);
+    }
+}
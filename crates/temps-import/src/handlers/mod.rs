@@ -15,8 +15,9 @@ use temps_core::problemdetails::Problem;
 use utoipa::OpenApi;
 
 use types::{
-    CreatePlanRequest, CreatePlanResponse, DiscoverRequest, DiscoverResponse, ExecuteImportRequest,
-    ExecuteImportResponse, ImportSourceInfo, ImportStatusResponse,
+    CreateComposePlanRequest, CreateComposePlanResponse, CreatePlanRequest, CreatePlanResponse,
+    DiscoverRequest, DiscoverResponse, ExecuteComposeImportRequest, ExecuteComposeImportResponse,
+    ExecuteImportRequest, ExecuteImportResponse, ImportSourceInfo, ImportStatusResponse,
 };
 
 /// Configure routes for the import API
@@ -26,6 +27,8 @@ pub fn configure_routes() -> Router<Arc<types::AppState>> {
         .route("/imports/discover", post(discover_workloads))
         .route("/imports/plan", post(create_plan))
         .route("/imports/execute", post(execute_import))
+        .route("/imports/compose/plan", post(create_compose_plan))
+        .route("/imports/compose/execute", post(execute_compose_import))
         .route("/imports/{session_id}", get(get_import_status))
 }
 
@@ -151,6 +154,79 @@ async fn execute_import(
     Ok((StatusCode::ACCEPTED, Json(result)))
 }
 
+/// Plan the import of a Docker Compose file
+///
+/// Databases, caches and object storage become managed services, and services
+/// with a build context become projects built from the repository. Features that
+/// can't be imported are listed in the plan's `unsupported` field.
+#[utoipa::path(
+    post,
+    path = "/imports/compose/plan",
+    tag = "Imports",
+    request_body = CreateComposePlanRequest,
+    responses(
+        (status = 200, description = "Compose import plan created", body = CreateComposePlanResponse),
+        (status = 400, description = "Invalid compose file"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn create_compose_plan(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<types::AppState>>,
+    Json(request): Json<types::CreateComposePlanRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_check!(auth, temps_auth::Permission::ImportsCreate);
+
+    let result = state
+        .compose_import_service
+        .create_plan(
+            auth.user_id(),
+            &request.content,
+            request.name.as_deref(),
+            request.repository_id,
+        )
+        .await?;
+
+    Ok(Json(result))
+}
+
+/// Execute a compose import plan
+#[utoipa::path(
+    post,
+    path = "/imports/compose/execute",
+    tag = "Imports",
+    request_body = ExecuteComposeImportRequest,
+    responses(
+        (status = 200, description = "Compose import executed", body = ExecuteComposeImportResponse),
+        (status = 400, description = "Plan can't be executed"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Import session not found"),
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn execute_compose_import(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<types::AppState>>,
+    Json(request): Json<types::ExecuteComposeImportRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_check!(auth, temps_auth::Permission::ImportsCreate);
+
+    let result = state
+        .compose_import_service
+        .execute(
+            auth.user_id(),
+            request.session_id,
+            request.main_branch,
+            request.dry_run.unwrap_or(false),
+        )
+        .await?;
+
+    Ok(Json(result))
+}
+
 /// Get import status
 #[utoipa::path(
     get,
@@ -186,6 +262,8 @@ async fn get_import_status(
         discover_workloads,
         create_plan,
         execute_import,
+        create_compose_plan,
+        execute_compose_import,
         get_import_status,
     ),
     components(schemas(
@@ -197,6 +275,15 @@ async fn get_import_status(
         types::ExecuteImportRequest,
         types::ExecuteImportResponse,
         types::ImportStatusResponse,
+        types::CreateComposePlanRequest,
+        types::CreateComposePlanResponse,
+        types::ExecuteComposeImportRequest,
+        types::ExecuteComposeImportResponse,
+        types::ComposeCreatedResource,
+        temps_import_compose::ComposeImportPlan,
+        temps_import_compose::ComposeServicePlan,
+        temps_import_compose::ServiceTarget,
+        temps_import_compose::UnsupportedFeature,
     )),
     tags(
         (name = "Imports", description = "Import workloads from external sources")
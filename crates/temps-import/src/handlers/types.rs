@@ -7,11 +7,12 @@ use temps_import_types::{
 };
 use utoipa::ToSchema;
 
-use crate::services::ImportOrchestrator;
+use crate::services::{ComposeImportService, ImportOrchestrator};
 
 /// Application state for handlers
 pub struct AppState {
     pub import_orchestrator: Arc<ImportOrchestrator>,
+    pub compose_import_service: Arc<ComposeImportService>,
 }
 
 /// Information about an import source
@@ -158,3 +159,64 @@ pub struct ImportStatusResponse {
     #[serde(with = "chrono::serde::ts_seconds")]
     pub updated_at: chrono::DateTime<chrono::Utc>,
 }
+
+/// Request to plan the import of a Docker Compose file
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct CreateComposePlanRequest {
+    /// Content of the `docker-compose.yml`
+    pub content: String,
+    /// Stack name, used as the prefix of the created resources (default: the file's `name`)
+    #[schema(example = "shop")]
+    pub name: Option<String>,
+    /// Repository the compose file belongs to; required to build services with a build context
+    pub repository_id: Option<i32>,
+}
+
+/// Response with a compose import plan
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct CreateComposePlanResponse {
+    /// Session ID for executing the plan
+    pub session_id: String,
+    pub plan: temps_import_compose::ComposeImportPlan,
+    /// Whether the plan can be executed
+    pub can_execute: bool,
+    /// Why the plan can't be executed, if it can't
+    pub blocked_reason: Option<String>,
+}
+
+/// Request to execute a compose import plan
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct ExecuteComposeImportRequest {
+    /// Session ID from plan creation
+    pub session_id: String,
+    /// Branch the projects deploy from
+    #[schema(example = "main")]
+    pub main_branch: String,
+    /// Dry run mode (don't create resources)
+    pub dry_run: Option<bool>,
+}
+
+/// A resource created by a compose import
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ComposeCreatedResource {
+    /// Compose service the resource was created for
+    pub service: String,
+    /// "project" or "external_service"
+    #[schema(example = "project")]
+    pub resource_type: String,
+    /// Resource ID (None in dry run mode)
+    pub resource_id: Option<i32>,
+    pub resource_name: String,
+}
+
+/// Response from a compose import
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ExecuteComposeImportResponse {
+    pub session_id: String,
+    pub status: ImportExecutionStatus,
+    /// Created resources, in creation order
+    pub resources: Vec<ComposeCreatedResource>,
+    /// Services that failed to import, with the error
+    pub errors: Vec<String>,
+    pub warnings: Vec<String>,
+}
@@ -9,7 +9,10 @@ use temps_core::plugin::{
 };
 use utoipa::{openapi::OpenApi, OpenApi as UtoimaOpenApi};
 
-use crate::{handlers, services::ImportOrchestrator};
+use crate::{
+    handlers,
+    services::{ComposeImportService, ImportOrchestrator},
+};
 
 /// Import plugin for managing workload imports
 pub struct ImportPlugin;
@@ -42,12 +45,14 @@ impl TempsPlugin for ImportPlugin {
             let project_service = context.require_service::<temps_projects::ProjectService>();
             let deployment_service =
                 context.require_service::<temps_deployments::DeploymentService>();
+            let external_service_manager =
+                context.require_service::<temps_providers::ExternalServiceManager>();
 
             // Create import orchestrator with all required services
             let mut orchestrator = ImportOrchestrator::new(
                 db.clone(),
                 git_provider_manager,
-                project_service,
+                project_service.clone(),
                 deployment_service,
             );
 
@@ -65,6 +70,13 @@ impl TempsPlugin for ImportPlugin {
             let orchestrator = Arc::new(orchestrator);
             context.register_service(orchestrator);
 
+            let compose_import_service = Arc::new(ComposeImportService::new(
+                db.clone(),
+                project_service,
+                external_service_manager,
+            ));
+            context.register_service(compose_import_service);
+
             tracing::debug!("Import plugin services registered successfully");
             Ok(())
         })
@@ -75,8 +87,13 @@ impl TempsPlugin for ImportPlugin {
             .get_service::<ImportOrchestrator>()
             .expect("ImportOrchestrator must be registered before configuring routes");
 
+        let compose_import_service = context
+            .get_service::<ComposeImportService>()
+            .expect("ComposeImportService must be registered before configuring routes");
+
         let app_state = Arc::new(handlers::types::AppState {
             import_orchestrator,
+            compose_import_service,
         });
 
         let routes = handlers::configure_routes().with_state(app_state);
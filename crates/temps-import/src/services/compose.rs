@@ -0,0 +1,394 @@
+//! Docker Compose import service
+//!
+//! Plans the import of a compose file and executes it: managed services are
+//! created first, then the projects built from the repository, each linked to the
+//! managed services it uses.
+
+use sea_orm::{DatabaseConnection, EntityTrait};
+use std::collections::{HashMap, HashSet};
+use std::sync::{Arc, RwLock};
+use temps_import_compose::{plan_compose, ComposeFile, ComposeImportPlan, ServiceTarget};
+use temps_providers::{CreateExternalServiceRequest, ExternalServiceManager, ServiceType};
+use tracing::{debug, info, warn};
+use uuid::Uuid;
+
+use super::{ImportServiceError, ImportServiceResult};
+use crate::handlers::types::{
+    ComposeCreatedResource, CreateComposePlanResponse, ExecuteComposeImportResponse,
+    ImportExecutionStatus,
+};
+
+/// Stored compose import session
+#[derive(Debug, Clone)]
+struct ComposeSession {
+    user_id: i32,
+    plan: ComposeImportPlan,
+    git_provider_connection_id: Option<i32>,
+    repo_owner: Option<String>,
+    repo_name: Option<String>,
+}
+
+/// Service importing Docker Compose stacks
+pub struct ComposeImportService {
+    db: Arc<DatabaseConnection>,
+    project_service: Arc<temps_projects::ProjectService>,
+    external_service_manager: Arc<ExternalServiceManager>,
+    /// In-memory session storage, like the orchestrator's
+    sessions: Arc<RwLock<HashMap<String, ComposeSession>>>,
+}
+
+impl ComposeImportService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        project_service: Arc<temps_projects::ProjectService>,
+        external_service_manager: Arc<ExternalServiceManager>,
+    ) -> Self {
+        Self {
+            db,
+            project_service,
+            external_service_manager,
+            sessions: Arc::new(RwLock::new(HashMap::new())),
+        }
+    }
+
+    /// Parse a compose file and plan its import
+    pub async fn create_plan(
+        &self,
+        user_id: i32,
+        content: &str,
+        name: Option<&str>,
+        repository_id: Option<i32>,
+    ) -> ImportServiceResult<CreateComposePlanResponse> {
+        let file = ComposeFile::parse(content)?;
+        let plan = plan_compose(&file, name)?;
+
+        let (git_provider_connection_id, repo_owner, repo_name) = match repository_id {
+            Some(repo_id) => {
+                use temps_entities::repositories;
+                let repository = repositories::Entity::find_by_id(repo_id)
+                    .one(self.db.as_ref())
+                    .await
+                    .map_err(|e| {
+                        ImportServiceError::Internal(format!("Failed to fetch repository: {}", e))
+                    })?
+                    .ok_or_else(|| {
+                        ImportServiceError::Validation(format!("Repository {} not found", repo_id))
+                    })?;
+                (
+                    Some(repository.git_provider_connection_id),
+                    Some(repository.owner),
+                    Some(repository.name),
+                )
+            }
+            None => (None, None, None),
+        };
+
+        let blocked_reason = blocked_reason(&plan, repository_id.is_some());
+        let session_id = Uuid::new_v4().to_string();
+        {
+            let mut sessions = self.sessions.write().unwrap();
+            sessions.insert(
+                session_id.clone(),
+                ComposeSession {
+                    user_id,
+                    plan: plan.clone(),
+                    git_provider_connection_id,
+                    repo_owner,
+                    repo_name,
+                },
+            );
+        }
+
+        info!(
+            "Created compose import plan for session: {} ({} services, {} unsupported features)",
+            session_id,
+            plan.services.len(),
+            plan.unsupported.len()
+        );
+
+        Ok(CreateComposePlanResponse {
+            session_id,
+            plan,
+            can_execute: blocked_reason.is_none(),
+            blocked_reason,
+        })
+    }
+
+    /// Execute a compose import plan
+    ///
+    /// Services are created in the plan's order. A service that fails is reported and
+    /// the services depending on it are skipped; the others are still created.
+    pub async fn execute(
+        &self,
+        user_id: i32,
+        session_id: String,
+        main_branch: String,
+        dry_run: bool,
+    ) -> ImportServiceResult<ExecuteComposeImportResponse> {
+        info!(
+            "Executing compose import for session: {} (dry_run: {})",
+            session_id, dry_run
+        );
+
+        let session = {
+            let sessions = self.sessions.read().unwrap();
+            sessions
+                .get(&session_id)
+                .cloned()
+                .ok_or_else(|| ImportServiceError::SessionNotFound(session_id.clone()))?
+        };
+        if session.user_id != user_id {
+            warn!(
+                "User {} attempted to execute compose session {} owned by user {}",
+                user_id, session_id, session.user_id
+            );
+            return Err(ImportServiceError::SessionNotFound(session_id));
+        }
+        if let Some(reason) = blocked_reason(&session.plan, session.repo_name.is_some()) {
+            return Err(ImportServiceError::Validation(reason));
+        }
+
+        let plan = &session.plan;
+        let mut resources = Vec::new();
+        let mut errors = Vec::new();
+        let mut failed: HashSet<&str> = HashSet::new();
+        // Compose service name -> created external service ID
+        let mut external_service_ids: HashMap<&str, i32> = HashMap::new();
+
+        for service in &plan.services {
+            if let Some(dependency) = service
+                .depends_on
+                .iter()
+                .find(|d| failed.contains(d.as_str()))
+            {
+                errors.push(format!(
+                    "Skipped '{}' because '{}' failed to import",
+                    service.name, dependency
+                ));
+                failed.insert(&service.name);
+                continue;
+            }
+
+            let resource_name = format!("{}-{}", plan.name, service.name);
+            let created = match &service.target {
+                ServiceTarget::ManagedService {
+                    service_type,
+                    docker_image,
+                    parameters,
+                } => self
+                    .create_external_service(
+                        &resource_name,
+                        service_type,
+                        docker_image,
+                        parameters,
+                        dry_run,
+                    )
+                    .await
+                    .map(|id| {
+                        if let Some(id) = id {
+                            external_service_ids.insert(&service.name, id);
+                        }
+                        ComposeCreatedResource {
+                            service: service.name.clone(),
+                            resource_type: "external_service".to_string(),
+                            resource_id: id,
+                            resource_name: resource_name.clone(),
+                        }
+                    }),
+                ServiceTarget::Project { build } => {
+                    let storage_service_ids = service
+                        .linked_services
+                        .iter()
+                        .filter_map(|linked| external_service_ids.get(linked.as_str()).copied())
+                        .collect();
+
+                    // Project variables are passed to Dockerfile builds as build args, so
+                    // compose build args become variables; service variables win on conflict
+                    let mut environment_variables: Vec<(String, String)> = build
+                        .args
+                        .iter()
+                        .filter(|(key, _)| !service.env_vars.iter().any(|v| &v.key == *key))
+                        .map(|(key, value)| (key.clone(), value.clone()))
+                        .collect();
+                    environment_variables.sort();
+                    environment_variables.extend(
+                        service
+                            .env_vars
+                            .iter()
+                            .map(|v| (v.key.clone(), v.value.clone())),
+                    );
+
+                    let request = temps_projects::services::types::CreateProjectRequest {
+                        name: resource_name.clone(),
+                        repo_name: session.repo_name.clone(),
+                        repo_owner: session.repo_owner.clone(),
+                        directory: build.context.clone(),
+                        main_branch: main_branch.clone(),
+                        preset: "dockerfile".to_string(),
+                        preset_config: Some(serde_json::json!({
+                            "dockerfilePath": build.dockerfile.as_deref().unwrap_or("Dockerfile"),
+                            "buildContext": ".",
+                            "target": build.target,
+                        })),
+                        environment_variables: Some(environment_variables),
+                        automatic_deploy: false,
+                        storage_service_ids,
+                        is_public_repo: None,
+                        git_url: None,
+                        git_provider_connection_id: session.git_provider_connection_id,
+                        exposed_port: service.port.map(i32::from),
+                    };
+                    self.create_project(request, &service.resources, dry_run)
+                        .await
+                        .map(|id| ComposeCreatedResource {
+                            service: service.name.clone(),
+                            resource_type: "project".to_string(),
+                            resource_id: id,
+                            resource_name: resource_name.clone(),
+                        })
+                }
+            };
+
+            match created {
+                Ok(resource) => {
+                    info!(
+                        "✓ Imported compose service {} as {} {}",
+                        service.name, resource.resource_type, resource.resource_name
+                    );
+                    resources.push(resource);
+                }
+                Err(e) => {
+                    warn!("Failed to import compose service {}: {}", service.name, e);
+                    errors.push(format!("Failed to import '{}': {}", service.name, e));
+                    failed.insert(&service.name);
+                }
+            }
+        }
+
+        if !dry_run {
+            // The stack is created; executing the session again would duplicate it
+            self.sessions.write().unwrap().remove(&session_id);
+        }
+
+        Ok(ExecuteComposeImportResponse {
+            session_id,
+            status: if errors.is_empty() {
+                ImportExecutionStatus::Completed
+            } else {
+                ImportExecutionStatus::Failed
+            },
+            resources,
+            errors,
+            warnings: plan.warnings.clone(),
+        })
+    }
+
+    async fn create_external_service(
+        &self,
+        name: &str,
+        service_type: &str,
+        docker_image: &str,
+        parameters: &std::collections::BTreeMap<String, String>,
+        dry_run: bool,
+    ) -> Result<Option<i32>, String> {
+        let service_type = ServiceType::from_str(service_type).map_err(|e| e.to_string())?;
+        let mut parameters: HashMap<String, serde_json::Value> = parameters
+            .iter()
+            .map(|(key, value)| (key.clone(), serde_json::Value::String(value.clone())))
+            .collect();
+        parameters.insert(
+            "docker_image".to_string(),
+            serde_json::Value::String(docker_image.to_string()),
+        );
+
+        if dry_run {
+            debug!("Dry run: would create {} service {}", service_type, name);
+            return Ok(None);
+        }
+        let service = self
+            .external_service_manager
+            .create_service(CreateExternalServiceRequest {
+                name: name.to_string(),
+                service_type,
+                version: None,
+                parameters,
+            })
+            .await
+            .map_err(|e| format!("Failed to create service: {}", e))?;
+        Ok(Some(service.id))
+    }
+
+    async fn create_project(
+        &self,
+        request: temps_projects::services::types::CreateProjectRequest,
+        resources: &temps_import_types::ResourceLimits,
+        dry_run: bool,
+    ) -> Result<Option<i32>, String> {
+        if dry_run {
+            debug!("Dry run: would create project {}", request.name);
+            return Ok(None);
+        }
+        let project = self
+            .project_service
+            .create_project(request)
+            .await
+            .map_err(|e| format!("Failed to create project: {}", e))?;
+
+        let has_resources = resources.cpu_limit.is_some()
+            || resources.memory_limit.is_some()
+            || resources.cpu_request.is_some()
+            || resources.memory_request.is_some();
+        if has_resources {
+            self.project_service
+                .update_deployment_settings(
+                    &project.id.to_string(),
+                    temps_projects::services::types::UpdateDeploymentSettingsRequest {
+                        cpu_request: resources.cpu_request,
+                        cpu_limit: resources.cpu_limit,
+                        memory_request: resources.memory_request,
+                        memory_limit: resources.memory_limit,
+                    },
+                )
+                .await
+                .map_err(|e| format!("Failed to apply resource limits: {}", e))?;
+        }
+        Ok(Some(project.id))
+    }
+}
+
+/// Why a plan can't be executed, if it can't
+fn blocked_reason(plan: &ComposeImportPlan, has_repository: bool) -> Option<String> {
+    if plan.services.is_empty() {
+        return Some("None of the compose services can be imported".to_string());
+    }
+    if plan.has_projects() && !has_repository {
+        return Some(
+            "Services with a build context are built from a repository; select the repository \
+             the compose file belongs to"
+                .to_string(),
+        );
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_blocked_reason() {
+        let plan = |content: &str| {
+            plan_compose(&ComposeFile::parse(content).unwrap(), Some("stack")).unwrap()
+        };
+
+        let databases = plan("services:\n  db:\n    image: postgres:16\n");
+        assert_eq!(blocked_reason(&databases, false), None);
+
+        let app = plan("services:\n  web:\n    build: .\n");
+        assert!(blocked_reason(&app, false).is_some());
+        assert_eq!(blocked_reason(&app, true), None);
+
+        let nothing = plan("services:\n  proxy:\n    image: nginx\n");
+        assert!(blocked_reason(&nothing, true).is_some());
+    }
+}
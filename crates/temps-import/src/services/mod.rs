@@ -1,7 +1,9 @@
 //! Import orchestration services
 
+mod compose;
 mod orchestrator;
 
+pub use compose::ComposeImportService;
 pub use orchestrator::ImportOrchestrator;
 
 use axum::http::StatusCode;
@@ -0,0 +1,234 @@
+//! Coalescing of repeated log lines
+//!
+//! Some apps write the same line thousands of times, for instance while retrying a
+//! connection. When coalescing is enabled, consecutive identical lines of a log
+//! stream are collapsed into the first of them, followed by how many times it was
+//! written and when:
+//!
+//! ```text
+//! 2024-01-20T10:23:52.789Z connection refused, retrying [repeated 1200 times between 2024-01-20T10:23:52Z and 2024-01-20T10:25:04Z]
+//! ```
+//!
+//! Lines are compared without the timestamp Docker prefixes them with, and a line is
+//! held until a different one arrives, the stream goes quiet for a moment, or the run
+//! has lasted a while, so a live tail never waits long for a line.
+
+use std::time::Duration;
+
+use chrono::{DateTime, SecondsFormat, Utc};
+use futures::{Stream, StreamExt};
+use tokio::time::Instant;
+
+/// How long a line is held for repeats once the stream goes quiet
+pub const DEFAULT_IDLE_FLUSH: Duration = Duration::from_secs(1);
+/// Longest run of repeats collapsed into one line
+pub const DEFAULT_MAX_RUN: Duration = Duration::from_secs(60);
+
+/// A line and its consecutive repeats
+struct Run {
+    /// First occurrence, as read
+    line: String,
+    /// The line without its timestamp, compared with the next ones
+    message: String,
+    count: u64,
+    first_at: DateTime<Utc>,
+    last_at: DateTime<Utc>,
+    started: Instant,
+    last_received: Instant,
+}
+
+impl Run {
+    fn format(self) -> String {
+        if self.count == 1 {
+            return self.line;
+        }
+        let newline = if self.line.ends_with('\n') { "\n" } else { "" };
+        format!(
+            "{} [repeated {} times between {} and {}]{}",
+            self.line.trim_end_matches(['\r', '\n']),
+            self.count,
+            self.first_at.to_rfc3339_opts(SecondsFormat::Secs, true),
+            self.last_at.to_rfc3339_opts(SecondsFormat::Secs, true),
+            newline
+        )
+    }
+}
+
+/// Collapses consecutive identical lines of one log stream
+pub struct LineCoalescer {
+    idle_flush: Duration,
+    max_run: Duration,
+    run: Option<Run>,
+}
+
+impl Default for LineCoalescer {
+    fn default() -> Self {
+        Self::new(DEFAULT_IDLE_FLUSH, DEFAULT_MAX_RUN)
+    }
+}
+
+impl LineCoalescer {
+    pub fn new(idle_flush: Duration, max_run: Duration) -> Self {
+        Self {
+            idle_flush,
+            max_run,
+            run: None,
+        }
+    }
+
+    /// Take the next line, received at `now`; returns the line to emit, if the
+    /// previous run has ended
+    pub fn push(&mut self, line: String, now: DateTime<Utc>) -> Option<String> {
+        let (timestamp, message) = split_timestamp(&line);
+        let at = timestamp.unwrap_or(now);
+        let message = message.trim_end_matches(['\r', '\n']).to_string();
+        let received = Instant::now();
+
+        if let Some(run) = self.run.as_mut() {
+            if run.message == message && received.duration_since(run.started) < self.max_run {
+                run.count += 1;
+                run.last_at = at;
+                run.last_received = received;
+                return None;
+            }
+        }
+
+        let finished = self.flush();
+        self.run = Some(Run {
+            line,
+            message,
+            count: 1,
+            first_at: at,
+            last_at: at,
+            started: received,
+            last_received: received,
+        });
+        finished
+    }
+
+    /// End the current run, returning its line
+    pub fn flush(&mut self) -> Option<String> {
+        self.run.take().map(Run::format)
+    }
+
+    /// When the held line should be emitted if no other line arrives
+    pub fn deadline(&self) -> Option<Instant> {
+        self.run
+            .as_ref()
+            .map(|run| (run.last_received + self.idle_flush).min(run.started + self.max_run))
+    }
+}
+
+/// Split the RFC 3339 timestamp Docker prefixes lines with from the message
+fn split_timestamp(line: &str) -> (Option<DateTime<Utc>>, &str) {
+    if let Some((prefix, message)) = line.split_once(' ') {
+        if let Ok(timestamp) = DateTime::parse_from_rfc3339(prefix) {
+            return (Some(timestamp.with_timezone(&Utc)), message);
+        }
+    }
+    (None, line)
+}
+
+/// Collapse consecutive identical lines of a log stream when `enabled`; otherwise
+/// lines are passed through as they are
+pub fn coalesce_lines<S, E>(lines: S, enabled: bool) -> impl Stream<Item = Result<String, E>>
+where
+    S: Stream<Item = Result<String, E>>,
+{
+    async_stream::stream! {
+        let mut lines = Box::pin(lines);
+        let mut coalescer = LineCoalescer::default();
+        loop {
+            let next = match coalescer.deadline() {
+                Some(deadline) => tokio::select! {
+                    next = lines.next() => Some(next),
+                    _ = tokio::time::sleep_until(deadline) => None,
+                },
+                None => Some(lines.next().await),
+            };
+            match next {
+                // The held line waited long enough
+                None => {
+                    if let Some(line) = coalescer.flush() {
+                        yield Ok(line);
+                    }
+                }
+                Some(Some(Ok(line))) if enabled => {
+                    if let Some(line) = coalescer.push(line, Utc::now()) {
+                        yield Ok(line);
+                    }
+                }
+                Some(Some(item)) => {
+                    if let Some(line) = coalescer.flush() {
+                        yield Ok(line);
+                    }
+                    let failed = item.is_err();
+                    yield item;
+                    if failed {
+                        break;
+                    }
+                }
+                Some(None) => {
+                    if let Some(line) = coalescer.flush() {
+                        yield Ok(line);
+                    }
+                    break;
+                }
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_collapses_repeated_timestamped_lines() {
+        let mut coalescer = LineCoalescer::default();
+        let now = Utc::now();
+        assert_eq!(
+            coalescer.push("2024-01-20T10:23:52.789Z starting\n".to_string(), now),
+            None
+        );
+        assert_eq!(
+            coalescer.push("2024-01-20T10:23:53.000Z retrying\n".to_string(), now),
+            Some("2024-01-20T10:23:52.789Z starting\n".to_string())
+        );
+        assert_eq!(
+            coalescer.push("2024-01-20T10:23:54.000Z retrying\n".to_string(), now),
+            None
+        );
+        assert_eq!(
+            coalescer.push("2024-01-20T10:24:10.500Z retrying\n".to_string(), now),
+            None
+        );
+        assert_eq!(
+            coalescer.flush(),
+            Some(
+                "2024-01-20T10:23:53.000Z retrying [repeated 3 times between \
+                 2024-01-20T10:23:53Z and 2024-01-20T10:24:10Z]\n"
+                    .to_string()
+            )
+        );
+        assert_eq!(coalescer.flush(), None);
+    }
+
+    #[tokio::test]
+    async fn test_stream_passes_lines_through_when_disabled() {
+        let lines =
+            futures::stream::iter(["a\n", "a\n", "b\n"].map(|line| Ok::<_, ()>(line.to_string())));
+        let collected: Vec<_> = coalesce_lines(lines, false).collect().await;
+        assert_eq!(collected.len(), 3);
+
+        let lines =
+            futures::stream::iter(["a\n", "a\n", "b\n"].map(|line| Ok::<_, ()>(line.to_string())));
+        let collected: Vec<String> = coalesce_lines(lines, true)
+            .map(|line| line.unwrap())
+            .collect()
+            .await;
+        assert_eq!(collected.len(), 2);
+        assert!(collected[0].starts_with("a [repeated 2 times between "));
+        assert_eq!(collected[1], "b\n");
+    }
+}
@@ -14,7 +14,7 @@ use tokio::io::{AsyncBufReadExt, AsyncReadExt, AsyncSeekExt, BufReader, SeekFrom
 use tokio::time::Duration;
 use tracing::{debug, trace};
 
-use crate::structured_logs::{LogEntry, LogLevel, StructuredLogService};
+use crate::structured_logs::{LogContextWindow, LogEntry, LogLevel, StructuredLogService};
 
 pub struct LogService {
     log_base_path: PathBuf,
@@ -145,6 +145,25 @@ impl LogService {
         })
     }
 
+    /// Delete a log's plain and structured files, if present
+    pub async fn delete_log(&self, log_id: &str) -> Result<(), std::io::Error> {
+        // Log IDs with a path or extension name a single file
+        let mut paths = vec![self.get_log_path(log_id)];
+        let structured_path = self.structured_service.get_log_path(log_id);
+        if !paths.contains(&structured_path) {
+            paths.push(structured_path);
+        }
+
+        for path in paths {
+            match tokio::fs::remove_file(&path).await {
+                Ok(()) => debug!("Deleted log file {:?}", path),
+                Err(e) if e.kind() == std::io::ErrorKind::NotFound => {}
+                Err(e) => return Err(e),
+            }
+        }
+        Ok(())
+    }
+
     // ========== Structured Logging Helpers ==========
     // These methods provide convenient access to structured logging
     // while maintaining backward compatibility with existing append_to_log() usage
@@ -195,6 +214,20 @@ impl LogService {
         self.structured_service.search_logs(log_id, query).await
     }
 
+    /// Read a structured log line and the entries around it
+    ///
+    /// Returns None when the log has no such line.
+    pub async fn get_structured_log_context(
+        &self,
+        log_id: &str,
+        line: u64,
+        context: LogContextWindow,
+    ) -> Result<Option<Vec<LogEntry>>, std::io::Error> {
+        self.structured_service
+            .read_context(log_id, line, context)
+            .await
+    }
+
     /// Filter structured logs by level
     ///
     /// Returns only logs matching the specified level (info, success, warning, error)
@@ -281,6 +314,29 @@ mod tests {
         assert!(full_path.parent().unwrap().exists());
     }
 
+    #[tokio::test]
+    async fn test_delete_log_removes_plain_and_structured_files() {
+        let temp_dir = TempDir::new().unwrap();
+        let log_service = LogService::new(temp_dir.path().to_path_buf());
+
+        let log_id = "test-delete";
+        log_service.log_info(log_id, "Line").await.unwrap();
+        tokio::fs::write(log_service.get_log_path(log_id), "plain\n")
+            .await
+            .unwrap();
+
+        log_service.delete_log(log_id).await.unwrap();
+        assert!(!log_service.get_log_path(log_id).exists());
+        assert!(log_service
+            .get_structured_logs(log_id)
+            .await
+            .unwrap()
+            .is_empty());
+
+        // Deleting a missing log is not an error
+        log_service.delete_log(log_id).await.unwrap();
+    }
+
     #[tokio::test]
     async fn test_append_and_read_log() {
         let temp_dir = TempDir::new().unwrap();
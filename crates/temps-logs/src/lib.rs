@@ -7,6 +7,13 @@
 //! - Appending to logs asynchronously
 //! - Tailing logs in real-time
 //! - Reading log content
+//! - Reading the lines around a log line, by count or time window
+//!
+//! ## Repeated Line Coalescing (`coalesce`)
+//! - Collapsing consecutive identical lines into one with a count and time range
+//!
+//! ## App Timestamps (`timestamps`)
+//! - Ordering container lines by the timestamps the app writes, within a skew bound
 //!
 //! ## Docker Container Logging (`docker_logs`)
 //! - Retrieving container logs efficiently
@@ -14,13 +21,17 @@
 //! - Checking container status
 //! - Saving container logs to files
 
+pub mod coalesce;
 pub mod docker_logs;
 pub mod file_logs;
 pub mod plugin;
 pub mod structured_logs;
+pub mod timestamps;
 
 // Re-export the main types for convenience
+pub use coalesce::{coalesce_lines, LineCoalescer};
 pub use docker_logs::{DockerLogError, DockerLogService};
 pub use file_logs::LogService;
 pub use plugin::LogsPlugin;
-pub use structured_logs::{LogEntry, LogLevel, StructuredLogService};
+pub use structured_logs::{LogContextWindow, LogEntry, LogLevel, StructuredLogService};
+pub use timestamps::{apply_timestamps, TimestampExtractor, TimestampFormat};
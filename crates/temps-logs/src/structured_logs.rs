@@ -19,8 +19,9 @@
 //! }
 //! ```
 
-use chrono::{DateTime, Utc};
+use chrono::{DateTime, Duration, Utc};
 use serde::{Deserialize, Serialize};
+use std::collections::VecDeque;
 use std::path::PathBuf;
 use tokio::fs::OpenOptions;
 use tokio::io::{AsyncBufReadExt, AsyncWriteExt, BufReader};
@@ -89,6 +90,53 @@ impl LogEntry {
     }
 }
 
+/// Lines fetched on either side of a log line when neither a count nor a time
+/// window is given
+pub const DEFAULT_CONTEXT_LINES: usize = 10;
+
+/// Most lines fetched on either side of a log line
+pub const MAX_CONTEXT_LINES: usize = 500;
+
+/// Longest time window fetched on either side of a log line, in seconds
+pub const MAX_CONTEXT_WINDOW_SECONDS: i64 = 3600;
+
+/// Which entries around a log line to fetch
+///
+/// A count limits how many lines are fetched on that side, a window how far from the
+/// line's timestamp they may be; with both, entries have to satisfy both. A side
+/// without a count takes the default when there's no window either, and the maximum
+/// when there is.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct LogContextWindow {
+    pub before: usize,
+    pub after: usize,
+    pub window: Option<Duration>,
+}
+
+impl LogContextWindow {
+    /// Clamp requested counts and window to the maximums
+    pub fn new(before: Option<usize>, after: Option<usize>, window_seconds: Option<i64>) -> Self {
+        let window = window_seconds
+            .map(|seconds| Duration::seconds(seconds.clamp(0, MAX_CONTEXT_WINDOW_SECONDS)));
+        let default_lines = if window.is_some() {
+            MAX_CONTEXT_LINES
+        } else {
+            DEFAULT_CONTEXT_LINES
+        };
+        Self {
+            before: before.unwrap_or(default_lines).min(MAX_CONTEXT_LINES),
+            after: after.unwrap_or(default_lines).min(MAX_CONTEXT_LINES),
+            window,
+        }
+    }
+}
+
+impl Default for LogContextWindow {
+    fn default() -> Self {
+        Self::new(None, None, None)
+    }
+}
+
 /// Structured log service using JSONL format
 pub struct StructuredLogService {
     log_base_path: PathBuf,
@@ -170,6 +218,78 @@ impl StructuredLogService {
         Ok(entries)
     }
 
+    /// Read a log line and the entries around it, or None when the log has no such line
+    ///
+    /// The file is read once and only as far as the last entry returned; at most
+    /// `before` earlier lines are kept in memory while looking for the line.
+    pub async fn read_context(
+        &self,
+        log_id: &str,
+        line: u64,
+        context: LogContextWindow,
+    ) -> Result<Option<Vec<LogEntry>>, std::io::Error> {
+        let log_path = self.get_log_path(log_id);
+
+        if line == 0 || !log_path.exists() {
+            return Ok(None);
+        }
+
+        let file = tokio::fs::File::open(log_path).await?;
+        let reader = BufReader::new(file);
+        let mut lines = reader.lines();
+        let mut before: VecDeque<String> = VecDeque::with_capacity(context.before);
+        let mut current = 0;
+
+        let target = loop {
+            let Some(raw) = lines.next_line().await? else {
+                return Ok(None);
+            };
+            current += 1;
+            if current == line {
+                break LogEntry::from_jsonl(&raw).ok();
+            }
+            if context.before > 0 {
+                if before.len() == context.before {
+                    before.pop_front();
+                }
+                before.push_back(raw);
+            }
+        };
+        let Some(target) = target else {
+            return Ok(None);
+        };
+
+        let in_window = |entry: &LogEntry| match context.window {
+            Some(window) => (entry.timestamp - target.timestamp).abs() <= window,
+            None => true,
+        };
+
+        let mut entries: Vec<LogEntry> = before
+            .iter()
+            .filter_map(|raw| LogEntry::from_jsonl(raw).ok())
+            .filter(|entry| in_window(entry))
+            .collect();
+        entries.push(target.clone());
+
+        let mut after = 0;
+        while after < context.after {
+            let Some(raw) = lines.next_line().await? else {
+                break;
+            };
+            let Ok(entry) = LogEntry::from_jsonl(&raw) else {
+                continue;
+            };
+            // Entries are appended in order, so nothing later falls back into the window
+            if !in_window(&entry) {
+                break;
+            }
+            entries.push(entry);
+            after += 1;
+        }
+
+        Ok(Some(entries))
+    }
+
     /// Search logs by text (case-insensitive)
     pub async fn search_logs(
         &self,
@@ -253,6 +373,67 @@ mod tests {
         assert_eq!(error_json, r#""error""#);
     }
 
+    #[tokio::test]
+    async fn test_read_context() {
+        let temp_dir = TempDir::new().unwrap();
+        let service = StructuredLogService::new(temp_dir.path().to_path_buf());
+        for i in 1..=30 {
+            service
+                .append_log(
+                    "context",
+                    LogEntry::new(LogLevel::Info, format!("line {}", i)),
+                )
+                .await
+                .unwrap();
+        }
+
+        let lines = |entries: Vec<LogEntry>| entries.iter().map(|e| e.line).collect::<Vec<_>>();
+
+        let default = service
+            .read_context("context", 15, LogContextWindow::default())
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(lines(default), (5..=25).collect::<Vec<_>>());
+
+        let custom = service
+            .read_context("context", 2, LogContextWindow::new(Some(5), Some(1), None))
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(lines(custom), vec![1, 2, 3]);
+
+        // Everything was logged within the window, so only the line limit applies
+        let window = service
+            .read_context(
+                "context",
+                29,
+                LogContextWindow::new(Some(2), None, Some(60)),
+            )
+            .await
+            .unwrap()
+            .unwrap();
+        assert_eq!(lines(window), vec![27, 28, 29, 30]);
+
+        assert!(service
+            .read_context("context", 31, LogContextWindow::default())
+            .await
+            .unwrap()
+            .is_none());
+    }
+
+    #[test]
+    fn test_context_window_is_bounded() {
+        let context = LogContextWindow::new(Some(10_000), None, Some(86_400));
+        assert_eq!(context.before, MAX_CONTEXT_LINES);
+        assert_eq!(context.after, MAX_CONTEXT_LINES);
+        assert_eq!(
+            context.window,
+            Some(Duration::seconds(MAX_CONTEXT_WINDOW_SECONDS))
+        );
+        assert_eq!(LogContextWindow::default().after, DEFAULT_CONTEXT_LINES);
+    }
+
     #[tokio::test]
     async fn test_append_and_read_logs() {
         let temp_dir = TempDir::new().unwrap();
@@ -0,0 +1,264 @@
+//! Log timestamps written by the app
+//!
+//! Docker prefixes every line with the time it read it. Apps that log their own,
+//! more precise timestamps can have those used instead, so lines are shown in the
+//! order the app wrote them. The app's timestamp replaces Docker's prefix:
+//!
+//! ```text
+//! 2024-01-20T10:23:53.000000000Z {"time":"2024-01-20T10:23:52.789Z","msg":"ready"}
+//! 2024-01-20T10:23:52.789Z {"time":"2024-01-20T10:23:52.789Z","msg":"ready"}
+//! ```
+//!
+//! A timestamp too far from Docker's clock is taken for a mistake (a container
+//! with a wrong clock, a date logged in another unit) and Docker's time is kept.
+
+use chrono::{DateTime, Duration, NaiveDateTime, SecondsFormat, TimeZone, Utc};
+use futures::{Stream, StreamExt};
+
+/// Format of the app's timestamps
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum TimestampFormat {
+    Rfc3339,
+    /// Seconds since the epoch, with an optional fraction
+    Unix,
+    /// Milliseconds since the epoch
+    UnixMillis,
+    /// strftime pattern, read as UTC unless it has an offset
+    Pattern(String),
+}
+
+impl TimestampFormat {
+    /// Format from its configured name; anything else is a strftime pattern
+    pub fn from_name(name: &str) -> Self {
+        match name {
+            "rfc3339" => TimestampFormat::Rfc3339,
+            "unix" => TimestampFormat::Unix,
+            "unix_ms" => TimestampFormat::UnixMillis,
+            pattern => TimestampFormat::Pattern(pattern.to_string()),
+        }
+    }
+
+    fn parse(&self, value: &str) -> Option<DateTime<Utc>> {
+        match self {
+            TimestampFormat::Rfc3339 => DateTime::parse_from_rfc3339(value)
+                .ok()
+                .map(|timestamp| timestamp.with_timezone(&Utc)),
+            TimestampFormat::Unix => {
+                let seconds: f64 = value.parse().ok()?;
+                if !seconds.is_finite() {
+                    return None;
+                }
+                DateTime::from_timestamp_micros((seconds * 1_000_000.0) as i64)
+            }
+            TimestampFormat::UnixMillis => DateTime::from_timestamp_millis(value.parse().ok()?),
+            TimestampFormat::Pattern(pattern) => DateTime::parse_from_str(value, pattern)
+                .map(|timestamp| timestamp.with_timezone(&Utc))
+                .or_else(|_| {
+                    NaiveDateTime::parse_from_str(value, pattern)
+                        .map(|timestamp| Utc.from_utc_datetime(&timestamp))
+                })
+                .ok(),
+        }
+    }
+
+    /// Parse a timestamp at the start of a plain line, returning the rest of it
+    fn parse_leading<'a>(&self, line: &'a str) -> Option<(DateTime<Utc>, &'a str)> {
+        match self {
+            TimestampFormat::Pattern(pattern) => {
+                if let Ok((timestamp, rest)) = DateTime::parse_and_remainder(line, pattern) {
+                    return Some((timestamp.with_timezone(&Utc), rest));
+                }
+                NaiveDateTime::parse_and_remainder(line, pattern)
+                    .ok()
+                    .map(|(timestamp, rest)| (Utc.from_utc_datetime(&timestamp), rest))
+            }
+            _ => {
+                let (token, rest) = line.split_once(char::is_whitespace).unwrap_or((line, ""));
+                let token = token.trim_start_matches('[').trim_end_matches([']', ',']);
+                self.parse(token).map(|timestamp| (timestamp, rest))
+            }
+        }
+    }
+}
+
+/// Reads the app's timestamp from container log lines
+#[derive(Debug, Clone)]
+pub struct TimestampExtractor {
+    /// Field of JSON lines; None reads the start of the line
+    field: Option<String>,
+    format: TimestampFormat,
+    max_future_skew: Duration,
+    max_past_skew: Duration,
+}
+
+impl TimestampExtractor {
+    pub fn new(
+        field: Option<String>,
+        format: TimestampFormat,
+        max_future_skew_seconds: u32,
+        max_past_skew_seconds: u32,
+    ) -> Self {
+        Self {
+            field,
+            format,
+            max_future_skew: Duration::seconds(max_future_skew_seconds.into()),
+            max_past_skew: Duration::seconds(max_past_skew_seconds.into()),
+        }
+    }
+
+    /// Timestamp the app wrote in a message, if any
+    pub fn extract(&self, message: &str) -> Option<DateTime<Utc>> {
+        match &self.field {
+            Some(field) => {
+                let message = message.trim();
+                if !message.starts_with('{') {
+                    return None;
+                }
+                let value: serde_json::Value = serde_json::from_str(message).ok()?;
+                match value.get(field)? {
+                    serde_json::Value::String(timestamp) => self.format.parse(timestamp),
+                    serde_json::Value::Number(timestamp) => {
+                        self.format.parse(&timestamp.to_string())
+                    }
+                    _ => None,
+                }
+            }
+            None => self
+                .format
+                .parse_leading(message)
+                .map(|(timestamp, _)| timestamp),
+        }
+    }
+
+    /// Time a line is ordered by: the app's timestamp unless it is missing or too
+    /// far from when Docker read the line
+    pub fn canonical_time(&self, message: &str, read_at: DateTime<Utc>) -> DateTime<Utc> {
+        match self.extract(message) {
+            Some(timestamp)
+                if timestamp <= read_at + self.max_future_skew
+                    && timestamp >= read_at - self.max_past_skew =>
+            {
+                timestamp
+            }
+            _ => read_at,
+        }
+    }
+
+    /// Replace the timestamp Docker prefixed a line with by the canonical one;
+    /// lines without Docker's timestamp are returned as they are
+    pub fn apply(&self, line: String) -> String {
+        let Some((prefix, message)) = line.split_once(' ') else {
+            return line;
+        };
+        let Ok(read_at) = DateTime::parse_from_rfc3339(prefix) else {
+            return line;
+        };
+        let read_at = read_at.with_timezone(&Utc);
+        let canonical = self.canonical_time(message, read_at);
+        if canonical == read_at {
+            return line;
+        }
+        format!(
+            "{} {}",
+            canonical.to_rfc3339_opts(SecondsFormat::AutoSi, true),
+            message
+        )
+    }
+}
+
+/// Use the app's timestamps in a stream of timestamped lines when an extractor is
+/// given; otherwise lines are passed through as they are
+pub fn apply_timestamps<S, E>(
+    lines: S,
+    extractor: Option<TimestampExtractor>,
+) -> impl Stream<Item = Result<String, E>>
+where
+    S: Stream<Item = Result<String, E>>,
+{
+    lines.map(move |line| match &extractor {
+        Some(extractor) => line.map(|line| extractor.apply(line)),
+        None => line,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn at(timestamp: &str) -> DateTime<Utc> {
+        DateTime::parse_from_rfc3339(timestamp)
+            .unwrap()
+            .with_timezone(&Utc)
+    }
+
+    #[test]
+    fn test_json_field_replaces_docker_time() {
+        let extractor = TimestampExtractor::new(
+            Some("time".to_string()),
+            TimestampFormat::Rfc3339,
+            300,
+            3600,
+        );
+        assert_eq!(
+            extractor.apply(
+                "2024-01-20T10:23:53.000000000Z {\"time\":\"2024-01-20T10:23:52.789Z\"}\n"
+                    .to_string()
+            ),
+            "2024-01-20T10:23:52.789Z {\"time\":\"2024-01-20T10:23:52.789Z\"}\n"
+        );
+        // Lines without the field keep Docker's time
+        let plain = "2024-01-20T10:23:53.000000000Z starting\n".to_string();
+        assert_eq!(extractor.apply(plain.clone()), plain);
+
+        let millis = TimestampExtractor::new(
+            Some("ts".to_string()),
+            TimestampFormat::UnixMillis,
+            300,
+            3600,
+        );
+        assert_eq!(
+            millis.extract(r#"{"ts": 1705746232789, "msg": "ready"}"#),
+            Some(at("2024-01-20T10:23:52.789Z"))
+        );
+    }
+
+    #[test]
+    fn test_leading_timestamp_of_plain_lines() {
+        let pattern = TimestampExtractor::new(
+            None,
+            TimestampFormat::from_name("%Y-%m-%d %H:%M:%S%.3f"),
+            300,
+            3600,
+        );
+        assert_eq!(
+            pattern.extract("2024-01-20 10:23:52.789 INFO ready"),
+            Some(at("2024-01-20T10:23:52.789Z"))
+        );
+
+        let rfc3339 = TimestampExtractor::new(None, TimestampFormat::Rfc3339, 300, 3600);
+        assert_eq!(
+            rfc3339.extract("[2024-01-20T10:23:52.789+01:00] ready"),
+            Some(at("2024-01-20T09:23:52.789Z"))
+        );
+        assert_eq!(rfc3339.extract("ready"), None);
+    }
+
+    #[test]
+    fn test_skewed_timestamps_keep_docker_time() {
+        let extractor = TimestampExtractor::new(None, TimestampFormat::Unix, 60, 3600);
+        let read_at = at("2024-01-20T10:23:53Z");
+        assert_eq!(
+            extractor.canonical_time("1705746232.5 ready", read_at),
+            at("2024-01-20T10:23:52.500Z")
+        );
+        // Two hours behind, and a day ahead
+        assert_eq!(
+            extractor.canonical_time("1705739033 ready", read_at),
+            read_at
+        );
+        assert_eq!(
+            extractor.canonical_time("1705832633 ready", read_at),
+            read_at
+        );
+    }
+}
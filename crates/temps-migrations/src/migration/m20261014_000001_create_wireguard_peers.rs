@@ -0,0 +1,146 @@
+//! Migration to create wireguard_peers table
+//!
+//! WireGuard peers give operators VPN access to internal services and managed
+//! databases without exposing their ports publicly.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(WireguardPeers::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(WireguardPeers::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(ColumnDef::new(WireguardPeers::Name).string().not_null())
+                    .col(
+                        ColumnDef::new(WireguardPeers::PublicKey)
+                            .string()
+                            .not_null()
+                            .unique_key(),
+                    )
+                    .col(
+                        ColumnDef::new(WireguardPeers::EncryptedPresharedKey)
+                            .text()
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(WireguardPeers::Address).string().not_null())
+                    .col(
+                        ColumnDef::new(WireguardPeers::AllowedSubnets)
+                            .json()
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(WireguardPeers::CreatedBy).integer().null())
+                    .col(
+                        ColumnDef::new(WireguardPeers::LastHandshakeAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(WireguardPeers::RevokedAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(ColumnDef::new(WireguardPeers::RevokedBy).integer().null())
+                    .col(
+                        ColumnDef::new(WireguardPeers::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(WireguardPeers::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        // Add foreign key to users table for created_by (optional)
+        manager
+            .create_foreign_key(
+                ForeignKey::create()
+                    .name("fk_wireguard_peers_created_by")
+                    .from(WireguardPeers::Table, WireguardPeers::CreatedBy)
+                    .to(Users::Table, Users::Id)
+                    .on_delete(ForeignKeyAction::SetNull)
+                    .to_owned(),
+            )
+            .await?;
+
+        // Create index on address for allocation lookups
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_wireguard_peers_address")
+                    .table(WireguardPeers::Table)
+                    .col(WireguardPeers::Address)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_index(
+                Index::drop()
+                    .name("idx_wireguard_peers_address")
+                    .table(WireguardPeers::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .drop_foreign_key(
+                ForeignKey::drop()
+                    .name("fk_wireguard_peers_created_by")
+                    .table(WireguardPeers::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .drop_table(Table::drop().table(WireguardPeers::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum WireguardPeers {
+    Table,
+    Id,
+    Name,
+    PublicKey,
+    EncryptedPresharedKey,
+    Address,
+    AllowedSubnets,
+    CreatedBy,
+    LastHandshakeAt,
+    RevokedAt,
+    RevokedBy,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum Users {
+    Table,
+    Id,
+}
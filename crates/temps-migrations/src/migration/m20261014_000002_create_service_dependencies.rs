@@ -0,0 +1,136 @@
+//! Migration to create service_dependencies table
+//!
+//! A dependency declares that a managed service must be running and healthy before
+//! another one starts (and is stopped after it).
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(ServiceDependencies::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(ServiceDependencies::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceDependencies::ServiceId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceDependencies::DependsOnServiceId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceDependencies::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_foreign_key(
+                ForeignKey::create()
+                    .name("fk_service_dependencies_service_id")
+                    .from(ServiceDependencies::Table, ServiceDependencies::ServiceId)
+                    .to(ExternalServices::Table, ExternalServices::Id)
+                    .on_delete(ForeignKeyAction::Cascade)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_foreign_key(
+                ForeignKey::create()
+                    .name("fk_service_dependencies_depends_on_service_id")
+                    .from(
+                        ServiceDependencies::Table,
+                        ServiceDependencies::DependsOnServiceId,
+                    )
+                    .to(ExternalServices::Table, ExternalServices::Id)
+                    .on_delete(ForeignKeyAction::Cascade)
+                    .to_owned(),
+            )
+            .await?;
+
+        // A dependency can only be declared once
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_service_dependencies_unique")
+                    .table(ServiceDependencies::Table)
+                    .col(ServiceDependencies::ServiceId)
+                    .col(ServiceDependencies::DependsOnServiceId)
+                    .unique()
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_index(
+                Index::drop()
+                    .name("idx_service_dependencies_unique")
+                    .table(ServiceDependencies::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .drop_foreign_key(
+                ForeignKey::drop()
+                    .name("fk_service_dependencies_depends_on_service_id")
+                    .table(ServiceDependencies::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .drop_foreign_key(
+                ForeignKey::drop()
+                    .name("fk_service_dependencies_service_id")
+                    .table(ServiceDependencies::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .drop_table(Table::drop().table(ServiceDependencies::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum ServiceDependencies {
+    Table,
+    Id,
+    ServiceId,
+    DependsOnServiceId,
+    CreatedAt,
+}
+
+#[derive(DeriveIden)]
+enum ExternalServices {
+    Table,
+    Id,
+}
@@ -0,0 +1,39 @@
+//! Migration to add deploy_concurrency column to projects table
+//!
+//! Limits how many environments build and deploy at the same time during a
+//! project-wide deploy. NULL means the server default.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE projects
+            ADD COLUMN IF NOT EXISTS deploy_concurrency INTEGER NULL
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE projects DROP COLUMN IF EXISTS deploy_concurrency
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
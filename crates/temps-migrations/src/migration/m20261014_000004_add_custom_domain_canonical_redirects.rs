@@ -0,0 +1,56 @@
+//! Migration to add apex/www canonical redirects and DNS verification to custom domains
+//!
+//! - canonical_redirect: on an apex domain, redirect between it and its www subdomain
+//!   ('www_to_apex' or 'apex_to_www')
+//! - force_https: redirect plain HTTP requests for the domain to HTTPS
+//! - dns_verified_at: when DNS was last confirmed to point at this server
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE project_custom_domains
+            ADD COLUMN IF NOT EXISTS canonical_redirect VARCHAR(32) NULL,
+            ADD COLUMN IF NOT EXISTS force_https BOOLEAN NOT NULL DEFAULT TRUE,
+            ADD COLUMN IF NOT EXISTS dns_verified_at TIMESTAMPTZ NULL
+            "#,
+        )
+        .await?;
+
+        // Domains that are already active were set up before DNS verification existed
+        db.execute_unprepared(
+            r#"
+            UPDATE project_custom_domains
+            SET dns_verified_at = updated_at
+            WHERE status = 'active' AND dns_verified_at IS NULL
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE project_custom_domains
+            DROP COLUMN IF EXISTS canonical_redirect,
+            DROP COLUMN IF EXISTS force_https,
+            DROP COLUMN IF EXISTS dns_verified_at
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
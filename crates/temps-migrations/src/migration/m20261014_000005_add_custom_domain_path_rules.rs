@@ -0,0 +1,40 @@
+//! Migration to add path-based routing rules to custom domains
+//!
+//! - path_rules: JSON list of `{ path_prefix, rewrite_to }` rules deciding which paths
+//!   the domain serves and where they map on its environment; empty serves everything
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE project_custom_domains
+            ADD COLUMN IF NOT EXISTS path_rules JSONB NOT NULL DEFAULT '[]'::jsonb
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE project_custom_domains
+            DROP COLUMN IF EXISTS path_rules
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
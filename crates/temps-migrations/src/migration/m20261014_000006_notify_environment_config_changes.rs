@@ -0,0 +1,79 @@
+//! Migration to reload routes when an environment's deployment config changes
+//!
+//! The proxy reads per-service settings (timeouts, body limits, upstream protocol)
+//! from the cached environment, so config edits must reach it without a restart.
+//! Previously only `current_deployment_id` changes were notified.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+                CREATE OR REPLACE FUNCTION notify_environment_route_change()
+                RETURNS TRIGGER AS $$
+                BEGIN
+                    -- current_deployment_id decides which deployment receives traffic,
+                    -- deployment_config holds the proxy settings for the environment
+                    IF TG_OP = 'UPDATE' THEN
+                        IF (OLD.current_deployment_id IS DISTINCT FROM NEW.current_deployment_id)
+                           OR (OLD.deployment_config IS DISTINCT FROM NEW.deployment_config)
+                        THEN
+                            PERFORM pg_notify('project_route_change', json_build_object(
+                                'action', 'ENVIRONMENT_UPDATE',
+                                'environment_id', NEW.id,
+                                'project_id', NEW.project_id,
+                                'deployment_id', NEW.current_deployment_id,
+                                'timestamp', CURRENT_TIMESTAMP
+                            )::text);
+                        END IF;
+                        RETURN NEW;
+                    END IF;
+
+                    RETURN COALESCE(NEW, OLD);
+                END;
+                $$ LANGUAGE plpgsql;
+                "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+                CREATE OR REPLACE FUNCTION notify_environment_route_change()
+                RETURNS TRIGGER AS $$
+                BEGIN
+                    IF TG_OP = 'UPDATE' THEN
+                        IF (OLD.current_deployment_id IS DISTINCT FROM NEW.current_deployment_id) THEN
+                            PERFORM pg_notify('project_route_change', json_build_object(
+                                'action', 'ENVIRONMENT_UPDATE',
+                                'environment_id', NEW.id,
+                                'project_id', NEW.project_id,
+                                'deployment_id', NEW.current_deployment_id,
+                                'timestamp', CURRENT_TIMESTAMP
+                            )::text);
+                        END IF;
+                        RETURN NEW;
+                    END IF;
+
+                    RETURN COALESCE(NEW, OLD);
+                END;
+                $$ LANGUAGE plpgsql;
+                "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
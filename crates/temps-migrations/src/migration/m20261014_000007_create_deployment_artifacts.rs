@@ -0,0 +1,181 @@
+//! Migration to create deployment_artifacts table
+//!
+//! Records the build artifacts (e.g. static bundles) retained in blob storage for a
+//! deployment, and when they expire.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(DeploymentArtifacts::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::DeploymentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::ProjectId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::EnvironmentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::Kind)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::FileName)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::Pathname)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::ContentType)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::SizeBytes)
+                            .big_integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::Sha256)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::ExpiresAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentArtifacts::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_foreign_key(
+                ForeignKey::create()
+                    .name("fk_deployment_artifacts_deployment_id")
+                    .from(
+                        DeploymentArtifacts::Table,
+                        DeploymentArtifacts::DeploymentId,
+                    )
+                    .to(Deployments::Table, Deployments::Id)
+                    .on_delete(ForeignKeyAction::Cascade)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_deployment_artifacts_deployment_id")
+                    .table(DeploymentArtifacts::Table)
+                    .col(DeploymentArtifacts::DeploymentId)
+                    .to_owned(),
+            )
+            .await?;
+
+        // The cleanup scheduler looks artifacts up by expiry
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_deployment_artifacts_expires_at")
+                    .table(DeploymentArtifacts::Table)
+                    .col(DeploymentArtifacts::ExpiresAt)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_index(
+                Index::drop()
+                    .name("idx_deployment_artifacts_expires_at")
+                    .table(DeploymentArtifacts::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .drop_index(
+                Index::drop()
+                    .name("idx_deployment_artifacts_deployment_id")
+                    .table(DeploymentArtifacts::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .drop_foreign_key(
+                ForeignKey::drop()
+                    .name("fk_deployment_artifacts_deployment_id")
+                    .table(DeploymentArtifacts::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .drop_table(Table::drop().table(DeploymentArtifacts::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum DeploymentArtifacts {
+    Table,
+    Id,
+    DeploymentId,
+    ProjectId,
+    EnvironmentId,
+    Kind,
+    FileName,
+    Pathname,
+    ContentType,
+    SizeBytes,
+    Sha256,
+    ExpiresAt,
+    CreatedAt,
+}
+
+#[derive(DeriveIden)]
+enum Deployments {
+    Table,
+    Id,
+}
@@ -0,0 +1,227 @@
+//! Migration to create deployment_promotions table
+//!
+//! Records promotions of a deployment's image from one environment to another
+//! (e.g. staging to production): which deployment was promoted, the deployment it
+//! became, and the approval it went through.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(DeploymentPromotions::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::ProjectId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::SourceDeploymentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::SourceEnvironmentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::TargetEnvironmentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::TargetDeploymentId)
+                            .integer()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::ImageName)
+                            .string()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::ImageDigest)
+                            .string()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::Status)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::RequestedBy)
+                            .integer()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::ReviewedBy)
+                            .integer()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::ReviewedAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::ReviewComment)
+                            .text()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(DeploymentPromotions::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_foreign_key(
+                ForeignKey::create()
+                    .name("fk_deployment_promotions_source_deployment_id")
+                    .from(
+                        DeploymentPromotions::Table,
+                        DeploymentPromotions::SourceDeploymentId,
+                    )
+                    .to(Deployments::Table, Deployments::Id)
+                    .on_delete(ForeignKeyAction::Cascade)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_foreign_key(
+                ForeignKey::create()
+                    .name("fk_deployment_promotions_target_deployment_id")
+                    .from(
+                        DeploymentPromotions::Table,
+                        DeploymentPromotions::TargetDeploymentId,
+                    )
+                    .to(Deployments::Table, Deployments::Id)
+                    .on_delete(ForeignKeyAction::SetNull)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_deployment_promotions_project_id")
+                    .table(DeploymentPromotions::Table)
+                    .col(DeploymentPromotions::ProjectId)
+                    .to_owned(),
+            )
+            .await?;
+
+        // Lineage lookups go from either end of a promotion
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_deployment_promotions_source_deployment_id")
+                    .table(DeploymentPromotions::Table)
+                    .col(DeploymentPromotions::SourceDeploymentId)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_deployment_promotions_target_deployment_id")
+                    .table(DeploymentPromotions::Table)
+                    .col(DeploymentPromotions::TargetDeploymentId)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        for index in [
+            "idx_deployment_promotions_target_deployment_id",
+            "idx_deployment_promotions_source_deployment_id",
+            "idx_deployment_promotions_project_id",
+        ] {
+            manager
+                .drop_index(
+                    Index::drop()
+                        .name(index)
+                        .table(DeploymentPromotions::Table)
+                        .to_owned(),
+                )
+                .await?;
+        }
+
+        for foreign_key in [
+            "fk_deployment_promotions_target_deployment_id",
+            "fk_deployment_promotions_source_deployment_id",
+        ] {
+            manager
+                .drop_foreign_key(
+                    ForeignKey::drop()
+                        .name(foreign_key)
+                        .table(DeploymentPromotions::Table)
+                        .to_owned(),
+                )
+                .await?;
+        }
+
+        manager
+            .drop_table(Table::drop().table(DeploymentPromotions::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum DeploymentPromotions {
+    Table,
+    Id,
+    ProjectId,
+    SourceDeploymentId,
+    SourceEnvironmentId,
+    TargetEnvironmentId,
+    TargetDeploymentId,
+    ImageName,
+    ImageDigest,
+    Status,
+    RequestedBy,
+    ReviewedBy,
+    ReviewedAt,
+    ReviewComment,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum Deployments {
+    Table,
+    Id,
+}
@@ -0,0 +1,228 @@
+//! Migration to create credential_rotation_policies and credential_rotations tables
+//!
+//! Schedules for rotating managed service credentials, and the history of every
+//! rotation with its outcome.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(CredentialRotationPolicies::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(CredentialRotationPolicies::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotationPolicies::ServiceId)
+                            .integer()
+                            .not_null()
+                            .unique_key(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotationPolicies::IntervalDays)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotationPolicies::NextRotationAt)
+                            .timestamp_with_time_zone()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotationPolicies::UpdatedBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotationPolicies::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotationPolicies::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_credential_rotation_policies_service_id")
+                            .from(
+                                CredentialRotationPolicies::Table,
+                                CredentialRotationPolicies::ServiceId,
+                            )
+                            .to(ExternalServices::Table, ExternalServices::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        // The scheduler looks up due rotations
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_credential_rotation_policies_next_rotation_at")
+                    .table(CredentialRotationPolicies::Table)
+                    .col(CredentialRotationPolicies::NextRotationAt)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_table(
+                Table::create()
+                    .table(CredentialRotations::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(CredentialRotations::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::ServiceId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::Trigger)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::Status)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::OldUsername)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::NewUsername)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::EnvironmentIds)
+                            .json()
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(CredentialRotations::Error).text().null())
+                    .col(
+                        ColumnDef::new(CredentialRotations::TriggeredBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::StartedAt)
+                            .timestamp_with_time_zone()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::FinishedAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(CredentialRotations::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_credential_rotations_service_id")
+                            .from(CredentialRotations::Table, CredentialRotations::ServiceId)
+                            .to(ExternalServices::Table, ExternalServices::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_credential_rotations_service_id")
+                    .table(CredentialRotations::Table)
+                    .col(CredentialRotations::ServiceId)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(Table::drop().table(CredentialRotations::Table).to_owned())
+            .await?;
+        manager
+            .drop_table(
+                Table::drop()
+                    .table(CredentialRotationPolicies::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum CredentialRotationPolicies {
+    Table,
+    Id,
+    ServiceId,
+    IntervalDays,
+    NextRotationAt,
+    UpdatedBy,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum CredentialRotations {
+    Table,
+    Id,
+    ServiceId,
+    Trigger,
+    Status,
+    OldUsername,
+    NewUsername,
+    EnvironmentIds,
+    Error,
+    TriggeredBy,
+    StartedAt,
+    FinishedAt,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum ExternalServices {
+    Table,
+    Id,
+}
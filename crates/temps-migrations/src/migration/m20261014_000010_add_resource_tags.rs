@@ -0,0 +1,85 @@
+//! Migration to add tags columns to projects and external_services tables
+//!
+//! Tags are a JSONB object of key/value pairs. The GIN indexes (jsonb_path_ops)
+//! answer containment filters such as `tags @> '{"team": "payments"}'`.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE projects
+            ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '{}'::jsonb
+            "#,
+        )
+        .await?;
+
+        // Index for tag containment filters
+        db.execute_unprepared(
+            r#"
+            CREATE INDEX IF NOT EXISTS idx_projects_tags
+            ON projects USING GIN (tags jsonb_path_ops)
+            "#,
+        )
+        .await?;
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE external_services
+            ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '{}'::jsonb
+            "#,
+        )
+        .await?;
+
+        db.execute_unprepared(
+            r#"
+            CREATE INDEX IF NOT EXISTS idx_external_services_tags
+            ON external_services USING GIN (tags jsonb_path_ops)
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            DROP INDEX IF EXISTS idx_external_services_tags
+            "#,
+        )
+        .await?;
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE external_services DROP COLUMN IF EXISTS tags
+            "#,
+        )
+        .await?;
+
+        db.execute_unprepared(
+            r#"
+            DROP INDEX IF EXISTS idx_projects_tags
+            "#,
+        )
+        .await?;
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE projects DROP COLUMN IF EXISTS tags
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
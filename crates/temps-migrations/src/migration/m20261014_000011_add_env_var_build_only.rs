@@ -0,0 +1,39 @@
+//! Migration to add build_only column to env_vars table
+//!
+//! Build-only variables (e.g. tokens for private package registries) are passed to
+//! image builds as build arguments and never set in the running containers.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE env_vars
+            ADD COLUMN IF NOT EXISTS build_only BOOLEAN NOT NULL DEFAULT FALSE
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE env_vars DROP COLUMN IF EXISTS build_only
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
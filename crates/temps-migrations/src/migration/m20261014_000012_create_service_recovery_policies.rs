@@ -0,0 +1,111 @@
+//! Migration to create the service_recovery_policies table
+//!
+//! Opt-in policies that restart or redeploy the apps linked to a managed service
+//! when it recovers from an outage.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(ServiceRecoveryPolicies::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(ServiceRecoveryPolicies::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceRecoveryPolicies::ServiceId)
+                            .integer()
+                            .not_null()
+                            .unique_key(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceRecoveryPolicies::Action)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceRecoveryPolicies::CooldownSeconds)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceRecoveryPolicies::LastTriggeredAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceRecoveryPolicies::UpdatedBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceRecoveryPolicies::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceRecoveryPolicies::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_service_recovery_policies_service_id")
+                            .from(
+                                ServiceRecoveryPolicies::Table,
+                                ServiceRecoveryPolicies::ServiceId,
+                            )
+                            .to(ExternalServices::Table, ExternalServices::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(
+                Table::drop()
+                    .table(ServiceRecoveryPolicies::Table)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum ServiceRecoveryPolicies {
+    Table,
+    Id,
+    ServiceId,
+    Action,
+    CooldownSeconds,
+    LastTriggeredAt,
+    UpdatedBy,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum ExternalServices {
+    Table,
+    Id,
+}
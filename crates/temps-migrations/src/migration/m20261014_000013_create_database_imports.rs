@@ -0,0 +1,117 @@
+//! Migration to create the database_imports table
+//!
+//! Imports of existing external databases into managed services: a one-off load
+//! from a dump, or a continuous sync until cutover.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(DatabaseImports::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(DatabaseImports::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(DatabaseImports::ServiceId)
+                            .integer()
+                            .not_null()
+                            .unique_key(),
+                    )
+                    .col(ColumnDef::new(DatabaseImports::Mode).string().not_null())
+                    .col(ColumnDef::new(DatabaseImports::Status).string().not_null())
+                    .col(
+                        ColumnDef::new(DatabaseImports::SourceDescription)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(DatabaseImports::SourceConfig).text().null())
+                    .col(
+                        ColumnDef::new(DatabaseImports::SourceVersion)
+                            .string()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DatabaseImports::TargetVersion)
+                            .string()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DatabaseImports::Verification)
+                            .json_binary()
+                            .null(),
+                    )
+                    .col(ColumnDef::new(DatabaseImports::Error).text().null())
+                    .col(
+                        ColumnDef::new(DatabaseImports::CreatedBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DatabaseImports::StartedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(DatabaseImports::FinishedAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_database_imports_service_id")
+                            .from(DatabaseImports::Table, DatabaseImports::ServiceId)
+                            .to(ExternalServices::Table, ExternalServices::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(Table::drop().table(DatabaseImports::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum DatabaseImports {
+    Table,
+    Id,
+    ServiceId,
+    Mode,
+    Status,
+    SourceDescription,
+    SourceConfig,
+    SourceVersion,
+    TargetVersion,
+    Verification,
+    Error,
+    CreatedBy,
+    StartedAt,
+    FinishedAt,
+}
+
+#[derive(DeriveIden)]
+enum ExternalServices {
+    Table,
+    Id,
+}
@@ -0,0 +1,178 @@
+//! Migration to create the environment_metrics hypertable
+//!
+//! Samples of the CPU, memory and network usage of each environment's running
+//! containers, summed over its containers so the number of series stays bounded by
+//! the number of environments. An hourly continuous aggregate keeps the history once
+//! raw samples are dropped.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(EnvironmentMetrics::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::Timestamp)
+                            .timestamp_with_time_zone()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::EnvironmentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::ProjectId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::DeploymentId)
+                            .integer()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::ContainerCount)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::CpuPercent)
+                            .double()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::MemoryBytes)
+                            .big_integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::MemoryLimitBytes)
+                            .big_integer()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::NetworkRxBytes)
+                            .big_integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(EnvironmentMetrics::NetworkTxBytes)
+                            .big_integer()
+                            .not_null(),
+                    )
+                    .primary_key(
+                        Index::create()
+                            .col(EnvironmentMetrics::EnvironmentId)
+                            .col(EnvironmentMetrics::Timestamp),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_environment_metrics_environment_id")
+                            .from(EnvironmentMetrics::Table, EnvironmentMetrics::EnvironmentId)
+                            .to(Environments::Table, Environments::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+                SELECT create_hypertable('environment_metrics', 'timestamp',
+                    chunk_time_interval => INTERVAL '1 day',
+                    if_not_exists => TRUE);
+                "#,
+        )
+        .await?;
+
+        // Hourly averages and peaks; network bytes are per sample, so they add up
+        db.execute_unprepared(
+            r#"
+                CREATE MATERIALIZED VIEW IF NOT EXISTS environment_metrics_hourly
+                WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+                SELECT
+                    environment_id,
+                    project_id,
+                    time_bucket('1 hour', timestamp) AS bucket,
+                    COUNT(*) AS samples,
+                    AVG(cpu_percent) AS avg_cpu_percent,
+                    MAX(cpu_percent) AS max_cpu_percent,
+                    AVG(memory_bytes) AS avg_memory_bytes,
+                    MAX(memory_bytes) AS max_memory_bytes,
+                    MAX(memory_limit_bytes) AS memory_limit_bytes,
+                    SUM(network_rx_bytes) AS network_rx_bytes,
+                    SUM(network_tx_bytes) AS network_tx_bytes,
+                    MAX(container_count) AS container_count
+                FROM environment_metrics
+                GROUP BY environment_id, project_id, bucket
+                WITH NO DATA;
+                "#,
+        )
+        .await?;
+
+        // The refresh window must stay within the raw retention, which is at least a day
+        db.execute_unprepared(
+            r#"
+                SELECT add_continuous_aggregate_policy('environment_metrics_hourly',
+                    start_offset => INTERVAL '6 hours',
+                    end_offset => INTERVAL '1 hour',
+                    schedule_interval => INTERVAL '30 minutes',
+                    if_not_exists => TRUE);
+                "#,
+        )
+        .await?;
+
+        db.execute_unprepared(
+            r#"
+                CREATE INDEX IF NOT EXISTS idx_environment_metrics_hourly_environment_bucket
+                    ON environment_metrics_hourly (environment_id, bucket DESC);
+                "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .get_connection()
+            .execute_unprepared("DROP MATERIALIZED VIEW IF EXISTS environment_metrics_hourly;")
+            .await?;
+        manager
+            .drop_table(Table::drop().table(EnvironmentMetrics::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum EnvironmentMetrics {
+    Table,
+    Timestamp,
+    EnvironmentId,
+    ProjectId,
+    DeploymentId,
+    ContainerCount,
+    CpuPercent,
+    MemoryBytes,
+    MemoryLimitBytes,
+    NetworkRxBytes,
+    NetworkTxBytes,
+}
+
+#[derive(DeriveIden)]
+enum Environments {
+    Table,
+    Id,
+}
@@ -0,0 +1,184 @@
+//! Migration to create the resource_alert_rules table
+//!
+//! Threshold rules on an environment's recorded CPU and memory usage. Each rule
+//! carries its evaluation state, so a restart doesn't fire or resolve alerts again.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(ResourceAlertRules::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::ProjectId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::EnvironmentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(ResourceAlertRules::Name).string().not_null())
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::Metric)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::Threshold)
+                            .double()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::ResolveThreshold)
+                            .double()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::DurationSeconds)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::Severity)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::Enabled)
+                            .boolean()
+                            .not_null()
+                            .default(true),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::Firing)
+                            .boolean()
+                            .not_null()
+                            .default(false),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::BreachingSince)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::RecoveringSince)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::LastFiredAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::IncidentId)
+                            .integer()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::CreatedBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(ResourceAlertRules::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_resource_alert_rules_environment_id")
+                            .from(ResourceAlertRules::Table, ResourceAlertRules::EnvironmentId)
+                            .to(Environments::Table, Environments::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_resource_alert_rules_incident_id")
+                            .from(ResourceAlertRules::Table, ResourceAlertRules::IncidentId)
+                            .to(StatusIncidents::Table, StatusIncidents::Id)
+                            .on_delete(ForeignKeyAction::SetNull),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_resource_alert_rules_environment_id")
+                    .table(ResourceAlertRules::Table)
+                    .col(ResourceAlertRules::EnvironmentId)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(Table::drop().table(ResourceAlertRules::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum ResourceAlertRules {
+    Table,
+    Id,
+    ProjectId,
+    EnvironmentId,
+    Name,
+    Metric,
+    Threshold,
+    ResolveThreshold,
+    DurationSeconds,
+    Severity,
+    Enabled,
+    Firing,
+    BreachingSince,
+    RecoveringSince,
+    LastFiredAt,
+    IncidentId,
+    CreatedBy,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum Environments {
+    Table,
+    Id,
+}
+
+#[derive(DeriveIden)]
+enum StatusIncidents {
+    Table,
+    Id,
+}
@@ -0,0 +1,42 @@
+//! Migration to reload routes when a certificate is issued, renewed or removed
+//!
+//! The proxy redirects a service to HTTPS once an active certificate covers its
+//! host, so the route table has to learn about certificate changes without a restart.
+//! Only status and certificate updates notify; challenge bookkeeping does not.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+                CREATE TRIGGER domains_certificate_changes_trigger
+                AFTER INSERT OR DELETE OR UPDATE OF status, certificate ON domains
+                FOR EACH STATEMENT
+                EXECUTE FUNCTION notify_route_table_change();
+                "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+                DROP TRIGGER IF EXISTS domains_certificate_changes_trigger ON domains;
+                "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
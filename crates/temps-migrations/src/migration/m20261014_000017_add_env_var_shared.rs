@@ -0,0 +1,39 @@
+//! Migration to add shared column to env_vars table
+//!
+//! Shared variables belong to the project rather than to some of its environments:
+//! every environment gets them, unless it has its own variable with the same key.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE env_vars
+            ADD COLUMN IF NOT EXISTS shared BOOLEAN NOT NULL DEFAULT FALSE
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE env_vars DROP COLUMN IF EXISTS shared
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
@@ -0,0 +1,39 @@
+//! Migration to add acme_directory column to domains table
+//!
+//! A domain can request its certificates from Let's Encrypt staging or another ACME
+//! CA instead of the server default. NULL keeps the server default.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE domains
+            ADD COLUMN IF NOT EXISTS acme_directory TEXT
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE domains DROP COLUMN IF EXISTS acme_directory
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
@@ -0,0 +1,102 @@
+//! Migration to add header and host routing rules to environments
+//!
+//! - routing_rules: JSON list of `{ priority, header, header_value, host, environment_id }`
+//!   rules sending matching requests to another environment; empty serves everything
+//!   from the environment itself
+//!
+//! The route change notification now also fires when the rules change, so the proxy
+//! picks them up without a restart.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE environments
+            ADD COLUMN IF NOT EXISTS routing_rules JSONB NOT NULL DEFAULT '[]'::jsonb
+            "#,
+        )
+        .await?;
+
+        db.execute_unprepared(
+            r#"
+                CREATE OR REPLACE FUNCTION notify_environment_route_change()
+                RETURNS TRIGGER AS $$
+                BEGIN
+                    -- current_deployment_id decides which deployment receives traffic,
+                    -- deployment_config holds the proxy settings for the environment and
+                    -- routing_rules send some of its requests elsewhere
+                    IF TG_OP = 'UPDATE' THEN
+                        IF (OLD.current_deployment_id IS DISTINCT FROM NEW.current_deployment_id)
+                           OR (OLD.deployment_config IS DISTINCT FROM NEW.deployment_config)
+                           OR (OLD.routing_rules IS DISTINCT FROM NEW.routing_rules)
+                        THEN
+                            PERFORM pg_notify('project_route_change', json_build_object(
+                                'action', 'ENVIRONMENT_UPDATE',
+                                'environment_id', NEW.id,
+                                'project_id', NEW.project_id,
+                                'deployment_id', NEW.current_deployment_id,
+                                'timestamp', CURRENT_TIMESTAMP
+                            )::text);
+                        END IF;
+                        RETURN NEW;
+                    END IF;
+
+                    RETURN COALESCE(NEW, OLD);
+                END;
+                $$ LANGUAGE plpgsql;
+                "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+                CREATE OR REPLACE FUNCTION notify_environment_route_change()
+                RETURNS TRIGGER AS $$
+                BEGIN
+                    IF TG_OP = 'UPDATE' THEN
+                        IF (OLD.current_deployment_id IS DISTINCT FROM NEW.current_deployment_id)
+                           OR (OLD.deployment_config IS DISTINCT FROM NEW.deployment_config)
+                        THEN
+                            PERFORM pg_notify('project_route_change', json_build_object(
+                                'action', 'ENVIRONMENT_UPDATE',
+                                'environment_id', NEW.id,
+                                'project_id', NEW.project_id,
+                                'deployment_id', NEW.current_deployment_id,
+                                'timestamp', CURRENT_TIMESTAMP
+                            )::text);
+                        END IF;
+                        RETURN NEW;
+                    END IF;
+
+                    RETURN COALESCE(NEW, OLD);
+                END;
+                $$ LANGUAGE plpgsql;
+                "#,
+        )
+        .await?;
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE environments
+            DROP COLUMN IF EXISTS routing_rules
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
@@ -0,0 +1,232 @@
+//! Migration to create the saved_log_searches and log_search_history tables
+//!
+//! Saved searches are shared by everyone on a project and may be promoted to alerts;
+//! each one carries its alert state, so a restart doesn't fire or resolve it again.
+//! The history keeps the latest searches each user ran on a project.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(SavedLogSearches::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(SavedLogSearches::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::ProjectId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(SavedLogSearches::Name).string().not_null())
+                    .col(ColumnDef::new(SavedLogSearches::Description).text().null())
+                    .col(
+                        ColumnDef::new(SavedLogSearches::Filters)
+                            .json_binary()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::TimeRange)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::AlertThreshold)
+                            .big_integer()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::AlertSeverity)
+                            .string()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::AlertEnabled)
+                            .boolean()
+                            .not_null()
+                            .default(false),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::AlertFiring)
+                            .boolean()
+                            .not_null()
+                            .default(false),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::LastAlertedAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::CreatedBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(SavedLogSearches::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_saved_log_searches_project_id")
+                            .from(SavedLogSearches::Table, SavedLogSearches::ProjectId)
+                            .to(Projects::Table, Projects::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_saved_log_searches_project_id")
+                    .table(SavedLogSearches::Table)
+                    .col(SavedLogSearches::ProjectId)
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_table(
+                Table::create()
+                    .table(LogSearchHistory::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(LogSearchHistory::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(LogSearchHistory::ProjectId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(LogSearchHistory::UserId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(LogSearchHistory::Filters)
+                            .json_binary()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(LogSearchHistory::TimeRange)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(LogSearchHistory::SavedSearchId)
+                            .integer()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(LogSearchHistory::SearchedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_log_search_history_project_id")
+                            .from(LogSearchHistory::Table, LogSearchHistory::ProjectId)
+                            .to(Projects::Table, Projects::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_log_search_history_saved_search_id")
+                            .from(LogSearchHistory::Table, LogSearchHistory::SavedSearchId)
+                            .to(SavedLogSearches::Table, SavedLogSearches::Id)
+                            .on_delete(ForeignKeyAction::SetNull),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_log_search_history_project_user")
+                    .table(LogSearchHistory::Table)
+                    .col(LogSearchHistory::ProjectId)
+                    .col(LogSearchHistory::UserId)
+                    .col(LogSearchHistory::SearchedAt)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(Table::drop().table(LogSearchHistory::Table).to_owned())
+            .await?;
+        manager
+            .drop_table(Table::drop().table(SavedLogSearches::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum SavedLogSearches {
+    Table,
+    Id,
+    ProjectId,
+    Name,
+    Description,
+    Filters,
+    TimeRange,
+    AlertThreshold,
+    AlertSeverity,
+    AlertEnabled,
+    AlertFiring,
+    LastAlertedAt,
+    CreatedBy,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum LogSearchHistory {
+    Table,
+    Id,
+    ProjectId,
+    UserId,
+    Filters,
+    TimeRange,
+    SavedSearchId,
+    SearchedAt,
+}
+
+#[derive(DeriveIden)]
+enum Projects {
+    Table,
+    Id,
+}
@@ -0,0 +1,125 @@
+//! Migration to create the repository_webhooks table
+//!
+//! A repository webhook holds the secret that push webhooks sent straight from a git
+//! provider to Temps are verified with, one per repository. Deliveries are counted
+//! so rejected ones stand out.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(RepositoryWebhooks::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(RepositoryWebhooks::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryWebhooks::RepositoryId)
+                            .integer()
+                            .not_null()
+                            .unique_key(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryWebhooks::SecretEncrypted)
+                            .text()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryWebhooks::CreatedBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryWebhooks::LastDeliveryAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryWebhooks::LastRejectedAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryWebhooks::RejectedCount)
+                            .big_integer()
+                            .not_null()
+                            .default(0),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryWebhooks::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryWebhooks::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_repository_webhooks_repository_id")
+                            .from(RepositoryWebhooks::Table, RepositoryWebhooks::RepositoryId)
+                            .to(Repositories::Table, Repositories::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_repository_webhooks_created_by")
+                            .from(RepositoryWebhooks::Table, RepositoryWebhooks::CreatedBy)
+                            .to(Users::Table, Users::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(Table::drop().table(RepositoryWebhooks::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum RepositoryWebhooks {
+    Table,
+    Id,
+    RepositoryId,
+    SecretEncrypted,
+    CreatedBy,
+    LastDeliveryAt,
+    LastRejectedAt,
+    RejectedCount,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum Repositories {
+    Table,
+    Id,
+}
+
+#[derive(DeriveIden)]
+enum Users {
+    Table,
+    Id,
+}
@@ -0,0 +1,40 @@
+//! Migration to add managed column to external_services table
+//!
+//! Services that aren't managed are hosted elsewhere, like an existing RDS
+//! instance: Temps only stores their connection details, and doesn't run,
+//! provision or back them up.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE external_services
+            ADD COLUMN IF NOT EXISTS managed BOOLEAN NOT NULL DEFAULT TRUE
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE external_services DROP COLUMN IF EXISTS managed
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
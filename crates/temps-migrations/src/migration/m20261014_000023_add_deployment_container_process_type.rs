@@ -0,0 +1,40 @@
+//! Migration to add process_type column to deployment_containers table
+//!
+//! Apps with a Procfile run a container per replica of each process type. Only
+//! the `web` containers receive traffic from the proxy; existing containers are
+//! all `web`.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE deployment_containers
+            ADD COLUMN IF NOT EXISTS process_type VARCHAR(63) NOT NULL DEFAULT 'web'
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE deployment_containers DROP COLUMN IF EXISTS process_type
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
@@ -0,0 +1,39 @@
+//! Migration to add timezone column to crons table
+//!
+//! Schedules are evaluated in the cron's IANA timezone; crons without one, which
+//! includes every existing cron, use the platform's default timezone.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE crons
+            ADD COLUMN IF NOT EXISTS timezone VARCHAR(64)
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE crons DROP COLUMN IF EXISTS timezone
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
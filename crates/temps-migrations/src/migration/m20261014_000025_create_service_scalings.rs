@@ -0,0 +1,155 @@
+//! Migration to add resource limits to external_services and create the
+//! service_scalings table
+//!
+//! CPU and memory limits of managed service containers, and the history of every
+//! change to them with how it was applied.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE external_services
+            ADD COLUMN IF NOT EXISTS cpu_limit INTEGER,
+            ADD COLUMN IF NOT EXISTS memory_limit INTEGER
+            "#,
+        )
+        .await?;
+
+        manager
+            .create_table(
+                Table::create()
+                    .table(ServiceScalings::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(ServiceScalings::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceScalings::ServiceId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(ServiceScalings::Status).string().not_null())
+                    .col(ColumnDef::new(ServiceScalings::Method).string().null())
+                    .col(
+                        ColumnDef::new(ServiceScalings::OldCpuLimit)
+                            .integer()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceScalings::OldMemoryLimit)
+                            .integer()
+                            .null(),
+                    )
+                    .col(ColumnDef::new(ServiceScalings::CpuLimit).integer().null())
+                    .col(
+                        ColumnDef::new(ServiceScalings::MemoryLimit)
+                            .integer()
+                            .null(),
+                    )
+                    .col(ColumnDef::new(ServiceScalings::Error).text().null())
+                    .col(
+                        ColumnDef::new(ServiceScalings::RequestedBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceScalings::StartedAt)
+                            .timestamp_with_time_zone()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceScalings::FinishedAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceScalings::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(ServiceScalings::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_service_scalings_service_id")
+                            .from(ServiceScalings::Table, ServiceScalings::ServiceId)
+                            .to(ExternalServices::Table, ExternalServices::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_service_scalings_service_id")
+                    .table(ServiceScalings::Table)
+                    .col(ServiceScalings::ServiceId)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(Table::drop().table(ServiceScalings::Table).to_owned())
+            .await?;
+
+        let db = manager.get_connection();
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE external_services
+            DROP COLUMN IF EXISTS cpu_limit,
+            DROP COLUMN IF EXISTS memory_limit
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum ServiceScalings {
+    Table,
+    Id,
+    ServiceId,
+    Status,
+    Method,
+    OldCpuLimit,
+    OldMemoryLimit,
+    CpuLimit,
+    MemoryLimit,
+    Error,
+    RequestedBy,
+    StartedAt,
+    FinishedAt,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum ExternalServices {
+    Table,
+    Id,
+}
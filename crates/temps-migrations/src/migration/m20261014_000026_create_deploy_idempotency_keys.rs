@@ -0,0 +1,131 @@
+//! Migration to create the deploy_idempotency_keys table
+//!
+//! Idempotency keys sent with deploy triggers, with what each trigger deployed, so a
+//! repeated trigger gets the first one's deploy back instead of starting another.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(DeployIdempotencyKeys::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(DeployIdempotencyKeys::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(DeployIdempotencyKeys::ProjectId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeployIdempotencyKeys::IdempotencyKey)
+                            .string_len(255)
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeployIdempotencyKeys::EnvironmentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeployIdempotencyKeys::Branch)
+                            .string()
+                            .null(),
+                    )
+                    .col(ColumnDef::new(DeployIdempotencyKeys::Tag).string().null())
+                    .col(
+                        ColumnDef::new(DeployIdempotencyKeys::Commit)
+                            .string()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(DeployIdempotencyKeys::DryRun)
+                            .boolean()
+                            .not_null()
+                            .default(false),
+                    )
+                    .col(
+                        ColumnDef::new(DeployIdempotencyKeys::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_deploy_idempotency_keys_project_id")
+                            .from(
+                                DeployIdempotencyKeys::Table,
+                                DeployIdempotencyKeys::ProjectId,
+                            )
+                            .to(Projects::Table, Projects::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        // One trigger per key and project; a repeated trigger conflicts on it
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_deploy_idempotency_keys_project_key")
+                    .table(DeployIdempotencyKeys::Table)
+                    .col(DeployIdempotencyKeys::ProjectId)
+                    .col(DeployIdempotencyKeys::IdempotencyKey)
+                    .unique()
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_deploy_idempotency_keys_created_at")
+                    .table(DeployIdempotencyKeys::Table)
+                    .col(DeployIdempotencyKeys::CreatedAt)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(Table::drop().table(DeployIdempotencyKeys::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum DeployIdempotencyKeys {
+    Table,
+    Id,
+    ProjectId,
+    IdempotencyKey,
+    EnvironmentId,
+    Branch,
+    Tag,
+    Commit,
+    DryRun,
+    CreatedAt,
+}
+
+#[derive(DeriveIden)]
+enum Projects {
+    Table,
+    Id,
+}
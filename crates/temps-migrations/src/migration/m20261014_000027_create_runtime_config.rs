@@ -0,0 +1,189 @@
+//! Migration to create the runtime_config_values and runtime_config_changes tables
+//!
+//! Non-secret runtime config values of each environment, written to a file mounted
+//! into its containers, and the history of every change with how the running
+//! containers picked it up: by reloading, by restarting, or on the next deploy.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(RuntimeConfigValues::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(RuntimeConfigValues::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(RuntimeConfigValues::EnvironmentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RuntimeConfigValues::Key)
+                            .string_len(128)
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(RuntimeConfigValues::Value).text().not_null())
+                    .col(
+                        ColumnDef::new(RuntimeConfigValues::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(RuntimeConfigValues::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_runtime_config_values_environment_id")
+                            .from(
+                                RuntimeConfigValues::Table,
+                                RuntimeConfigValues::EnvironmentId,
+                            )
+                            .to(Environments::Table, Environments::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_runtime_config_values_environment_key")
+                    .table(RuntimeConfigValues::Table)
+                    .col(RuntimeConfigValues::EnvironmentId)
+                    .col(RuntimeConfigValues::Key)
+                    .unique()
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_table(
+                Table::create()
+                    .table(RuntimeConfigChanges::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(RuntimeConfigChanges::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(RuntimeConfigChanges::EnvironmentId)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RuntimeConfigChanges::Key)
+                            .string_len(128)
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(RuntimeConfigChanges::Value).text().null())
+                    .col(
+                        ColumnDef::new(RuntimeConfigChanges::Status)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RuntimeConfigChanges::Method)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(ColumnDef::new(RuntimeConfigChanges::Error).text().null())
+                    .col(
+                        ColumnDef::new(RuntimeConfigChanges::ChangedBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RuntimeConfigChanges::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_runtime_config_changes_environment_id")
+                            .from(
+                                RuntimeConfigChanges::Table,
+                                RuntimeConfigChanges::EnvironmentId,
+                            )
+                            .to(Environments::Table, Environments::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        manager
+            .create_index(
+                Index::create()
+                    .name("idx_runtime_config_changes_environment_id")
+                    .table(RuntimeConfigChanges::Table)
+                    .col(RuntimeConfigChanges::EnvironmentId)
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(Table::drop().table(RuntimeConfigChanges::Table).to_owned())
+            .await?;
+        manager
+            .drop_table(Table::drop().table(RuntimeConfigValues::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum RuntimeConfigValues {
+    Table,
+    Id,
+    EnvironmentId,
+    Key,
+    Value,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum RuntimeConfigChanges {
+    Table,
+    Id,
+    EnvironmentId,
+    Key,
+    Value,
+    Status,
+    Method,
+    Error,
+    ChangedBy,
+    CreatedAt,
+}
+
+#[derive(DeriveIden)]
+enum Environments {
+    Table,
+    Id,
+}
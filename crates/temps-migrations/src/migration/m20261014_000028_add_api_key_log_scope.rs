@@ -0,0 +1,39 @@
+//! Migration to add log_scope column to api_keys table
+//!
+//! JSON of the projects and environments whose logs the key can read; keys without
+//! one, which includes every existing key, read the logs of every project.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE api_keys
+            ADD COLUMN IF NOT EXISTS log_scope TEXT
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE api_keys DROP COLUMN IF EXISTS log_scope
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
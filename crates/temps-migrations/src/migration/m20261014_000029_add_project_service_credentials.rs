@@ -0,0 +1,45 @@
+//! Migration to add app credential columns to project_services table
+//!
+//! Managed services that support it give each linked project a user of its own;
+//! app_password is encrypted. Links without one, which includes every existing link,
+//! connect with the service's credentials.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE project_services
+            ADD COLUMN IF NOT EXISTS app_username TEXT,
+            ADD COLUMN IF NOT EXISTS app_password TEXT,
+            ADD COLUMN IF NOT EXISTS connection_limit INTEGER
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE project_services
+            DROP COLUMN IF EXISTS app_username,
+            DROP COLUMN IF EXISTS app_password,
+            DROP COLUMN IF EXISTS connection_limit
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
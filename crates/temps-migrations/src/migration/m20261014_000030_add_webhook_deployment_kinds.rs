@@ -0,0 +1,40 @@
+//! Migration to add deployment_kinds column to webhooks table
+//!
+//! JSON list of the kinds of successful deployments (first, recovery, routine) the
+//! webhook gets deployment.succeeded events for; webhooks without one, which includes
+//! every existing webhook, get all of them.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE webhooks
+            ADD COLUMN IF NOT EXISTS deployment_kinds TEXT
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE webhooks DROP COLUMN IF EXISTS deployment_kinds
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
@@ -0,0 +1,141 @@
+//! Migration to create the repository_deploy_keys table
+//!
+//! A deploy key is an SSH keypair of one repository: the public key is added to the
+//! git host, the private key is stored encrypted, and builds can fetch the repository
+//! over SSH with it instead of the connection's token. The host keys the git host is
+//! pinned to are stored next to it.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .create_table(
+                Table::create()
+                    .table(RepositoryDeployKeys::Table)
+                    .if_not_exists()
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::Id)
+                            .integer()
+                            .not_null()
+                            .auto_increment()
+                            .primary_key(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::RepositoryId)
+                            .integer()
+                            .not_null()
+                            .unique_key(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::PublicKey)
+                            .text()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::PrivateKeyEncrypted)
+                            .text()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::Fingerprint)
+                            .string()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::KnownHosts)
+                            .text()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::UseForBuilds)
+                            .boolean()
+                            .not_null()
+                            .default(false),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::CreatedBy)
+                            .integer()
+                            .not_null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::LastUsedAt)
+                            .timestamp_with_time_zone()
+                            .null(),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::CreatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .col(
+                        ColumnDef::new(RepositoryDeployKeys::UpdatedAt)
+                            .timestamp_with_time_zone()
+                            .not_null()
+                            .default(Expr::current_timestamp()),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_repository_deploy_keys_repository_id")
+                            .from(
+                                RepositoryDeployKeys::Table,
+                                RepositoryDeployKeys::RepositoryId,
+                            )
+                            .to(Repositories::Table, Repositories::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .foreign_key(
+                        ForeignKey::create()
+                            .name("fk_repository_deploy_keys_created_by")
+                            .from(RepositoryDeployKeys::Table, RepositoryDeployKeys::CreatedBy)
+                            .to(Users::Table, Users::Id)
+                            .on_delete(ForeignKeyAction::Cascade),
+                    )
+                    .to_owned(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        manager
+            .drop_table(Table::drop().table(RepositoryDeployKeys::Table).to_owned())
+            .await?;
+
+        Ok(())
+    }
+}
+
+#[derive(DeriveIden)]
+enum RepositoryDeployKeys {
+    Table,
+    Id,
+    RepositoryId,
+    PublicKey,
+    PrivateKeyEncrypted,
+    Fingerprint,
+    KnownHosts,
+    UseForBuilds,
+    CreatedBy,
+    LastUsedAt,
+    CreatedAt,
+    UpdatedAt,
+}
+
+#[derive(DeriveIden)]
+enum Repositories {
+    Table,
+    Id,
+}
+
+#[derive(DeriveIden)]
+enum Users {
+    Table,
+    Id,
+}
@@ -0,0 +1,47 @@
+//! Migration to add the health state columns to status_monitors table
+//!
+//! Each monitor keeps how many of its checks failed in a row, the health state the
+//! streak puts its environment in (healthy, failing or unhealthy), and the automatic
+//! restarts made since the streak began. Existing monitors start out healthy.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE status_monitors
+            ADD COLUMN IF NOT EXISTS consecutive_failures INTEGER NOT NULL DEFAULT 0,
+            ADD COLUMN IF NOT EXISTS health_state VARCHAR NOT NULL DEFAULT 'healthy',
+            ADD COLUMN IF NOT EXISTS auto_restarts INTEGER NOT NULL DEFAULT 0,
+            ADD COLUMN IF NOT EXISTS last_auto_restart_at TIMESTAMPTZ
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE status_monitors
+            DROP COLUMN IF EXISTS consecutive_failures,
+            DROP COLUMN IF EXISTS health_state,
+            DROP COLUMN IF EXISTS auto_restarts,
+            DROP COLUMN IF EXISTS last_auto_restart_at
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
@@ -0,0 +1,40 @@
+//! Migration to add log_scope column to users table
+//!
+//! JSON of the projects and environments whose logs the user can read, such as a
+//! contractor assigned to one service. Users without one, which includes every
+//! existing user, read the logs of every project their role allows.
+
+use sea_orm_migration::prelude::*;
+
+#[derive(DeriveMigrationName)]
+pub struct Migration;
+
+#[async_trait::async_trait]
+impl MigrationTrait for Migration {
+    async fn up(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE users
+            ADD COLUMN IF NOT EXISTS log_scope TEXT
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+
+    async fn down(&self, manager: &SchemaManager) -> Result<(), DbErr> {
+        let db = manager.get_connection();
+
+        db.execute_unprepared(
+            r#"
+            ALTER TABLE users DROP COLUMN IF EXISTS log_scope
+            "#,
+        )
+        .await?;
+
+        Ok(())
+    }
+}
@@ -21,6 +21,39 @@ mod m20251209_000001_add_environments_route_trigger;
 mod m20251210_000001_add_vulnerability_class_fields;
 mod m20260103_000001_add_visitor_has_activity;
 mod m20260103_000002_add_utm_fields_to_sessions;
+mod m20261014_000001_create_wireguard_peers;
+mod m20261014_000002_create_service_dependencies;
+mod m20261014_000003_add_project_deploy_concurrency;
+mod m20261014_000004_add_custom_domain_canonical_redirects;
+mod m20261014_000005_add_custom_domain_path_rules;
+mod m20261014_000006_notify_environment_config_changes;
+mod m20261014_000007_create_deployment_artifacts;
+mod m20261014_000008_create_deployment_promotions;
+mod m20261014_000009_create_credential_rotations;
+mod m20261014_000010_add_resource_tags;
+mod m20261014_000011_add_env_var_build_only;
+mod m20261014_000012_create_service_recovery_policies;
+mod m20261014_000013_create_database_imports;
+mod m20261014_000014_create_environment_metrics;
+mod m20261014_000015_create_resource_alert_rules;
+mod m20261014_000016_notify_certificate_changes;
+mod m20261014_000017_add_env_var_shared;
+mod m20261014_000018_add_domain_acme_directory;
+mod m20261014_000019_add_environment_routing_rules;
+mod m20261014_000020_create_saved_log_searches;
+mod m20261014_000021_create_repository_webhooks;
+mod m20261014_000022_add_external_service_managed;
+mod m20261014_000023_add_deployment_container_process_type;
+mod m20261014_000024_add_cron_timezone;
+mod m20261014_000025_create_service_scalings;
+mod m20261014_000026_create_deploy_idempotency_keys;
+mod m20261014_000027_create_runtime_config;
+mod m20261014_000028_add_api_key_log_scope;
+mod m20261014_000029_add_project_service_credentials;
+mod m20261014_000030_add_webhook_deployment_kinds;
+mod m20261014_000031_create_repository_deploy_keys;
+mod m20261014_000032_add_status_monitor_health_state;
+mod m20261014_000033_add_user_log_scope;
 
 pub struct Migrator;
 
@@ -49,6 +82,39 @@ impl MigratorTrait for Migrator {
             Box::new(m20251210_000001_add_vulnerability_class_fields::Migration),
             Box::new(m20260103_000001_add_visitor_has_activity::Migration),
             Box::new(m20260103_000002_add_utm_fields_to_sessions::Migration),
+            Box::new(m20261014_000001_create_wireguard_peers::Migration),
+            Box::new(m20261014_000002_create_service_dependencies::Migration),
+            Box::new(m20261014_000003_add_project_deploy_concurrency::Migration),
+            Box::new(m20261014_000004_add_custom_domain_canonical_redirects::Migration),
+            Box::new(m20261014_000005_add_custom_domain_path_rules::Migration),
+            Box::new(m20261014_000006_notify_environment_config_changes::Migration),
+            Box::new(m20261014_000007_create_deployment_artifacts::Migration),
+            Box::new(m20261014_000008_create_deployment_promotions::Migration),
+            Box::new(m20261014_000009_create_credential_rotations::Migration),
+            Box::new(m20261014_000010_add_resource_tags::Migration),
+            Box::new(m20261014_000011_add_env_var_build_only::Migration),
+            Box::new(m20261014_000012_create_service_recovery_policies::Migration),
+            Box::new(m20261014_000013_create_database_imports::Migration),
+            Box::new(m20261014_000014_create_environment_metrics::Migration),
+            Box::new(m20261014_000015_create_resource_alert_rules::Migration),
+            Box::new(m20261014_000016_notify_certificate_changes::Migration),
+            Box::new(m20261014_000017_add_env_var_shared::Migration),
+            Box::new(m20261014_000018_add_domain_acme_directory::Migration),
+            Box::new(m20261014_000019_add_environment_routing_rules::Migration),
+            Box::new(m20261014_000020_create_saved_log_searches::Migration),
+            Box::new(m20261014_000021_create_repository_webhooks::Migration),
+            Box::new(m20261014_000022_add_external_service_managed::Migration),
+            Box::new(m20261014_000023_add_deployment_container_process_type::Migration),
+            Box::new(m20261014_000024_add_cron_timezone::Migration),
+            Box::new(m20261014_000025_create_service_scalings::Migration),
+            Box::new(m20261014_000026_create_deploy_idempotency_keys::Migration),
+            Box::new(m20261014_000027_create_runtime_config::Migration),
+            Box::new(m20261014_000028_add_api_key_log_scope::Migration),
+            Box::new(m20261014_000029_add_project_service_credentials::Migration),
+            Box::new(m20261014_000030_add_webhook_deployment_kinds::Migration),
+            Box::new(m20261014_000031_create_repository_deploy_keys::Migration),
+            Box::new(m20261014_000032_add_status_monitor_health_state::Migration),
+            Box::new(m20261014_000033_add_user_log_scope::Migration),
         ]
     }
 }
@@ -5,7 +5,7 @@
 use anyhow::Result;
 use chrono::{DateTime, Utc};
 use serde::{Deserialize, Serialize};
-use std::path::PathBuf;
+use std::path::{Path, PathBuf};
 use std::sync::Arc;
 use sysinfo::Disks;
 use temps_config::ConfigService;
@@ -60,6 +60,31 @@ pub struct DiskSpaceAlert {
     pub available_human: String,
 }
 
+/// Disk pressure level derived from the configured thresholds
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum DiskPressure {
+    /// Usage is below the alert threshold
+    Ok,
+    /// Usage is at or above the alert threshold
+    Warning,
+    /// Usage is at or above the critical threshold; new builds are refused
+    Critical,
+}
+
+impl DiskPressure {
+    /// Classify a usage percentage against the configured thresholds
+    pub fn from_usage(usage_percent: f64, settings: &DiskSpaceAlertSettings) -> Self {
+        if usage_percent >= settings.critical_threshold_percent as f64 {
+            DiskPressure::Critical
+        } else if usage_percent >= settings.threshold_percent as f64 {
+            DiskPressure::Warning
+        } else {
+            DiskPressure::Ok
+        }
+    }
+}
+
 #[derive(Debug, Error)]
 pub enum DiskSpaceError {
     #[error("Configuration error: {0}")]
@@ -105,46 +130,7 @@ impl DiskSpaceMonitor {
 
     /// Get disk information for all disks or a specific path
     pub fn get_disk_info(&self, path: Option<&str>) -> Result<Vec<DiskInfo>, DiskSpaceError> {
-        let disks = Disks::new_with_refreshed_list();
-        let mut disk_infos = Vec::new();
-
-        for disk in disks.list() {
-            let mount_point = disk.mount_point().to_string_lossy().to_string();
-            let total = disk.total_space();
-            let available = disk.available_space();
-            let used = total.saturating_sub(available);
-            let usage_percent = if total > 0 {
-                (used as f64 / total as f64) * 100.0
-            } else {
-                0.0
-            };
-
-            // Filter by path if specified
-            if let Some(target_path) = path {
-                // Check if the target path is under this mount point
-                if !target_path.starts_with(&mount_point) && mount_point != "/" {
-                    continue;
-                }
-            }
-
-            disk_infos.push(DiskInfo {
-                mount_point,
-                total_bytes: total,
-                used_bytes: used,
-                available_bytes: available,
-                usage_percent,
-                file_system: disk.file_system().to_string_lossy().to_string(),
-            });
-        }
-
-        // If we have a specific path and multiple matches, return only the most specific one
-        if path.is_some() && disk_infos.len() > 1 {
-            // Sort by mount point length (longest = most specific) and take the first
-            disk_infos.sort_by(|a, b| b.mount_point.len().cmp(&a.mount_point.len()));
-            disk_infos.truncate(1);
-        }
-
-        Ok(disk_infos)
+        collect_disk_info(path)
     }
 
     /// Check disk space against the configured threshold
@@ -217,7 +203,7 @@ impl DiskSpaceMonitor {
         }
 
         for alert in alerts {
-            let severity = if alert.usage_percent >= 95.0 {
+            let severity = if alert.usage_percent >= settings.critical_threshold_percent as f64 {
                 NotificationPriority::Critical
             } else if alert.usage_percent >= 90.0 {
                 NotificationPriority::High
@@ -339,8 +325,87 @@ impl DiskSpaceMonitor {
     }
 }
 
+/// Get disk information for all disks, or only the disk holding `path`
+pub fn collect_disk_info(path: Option<&str>) -> Result<Vec<DiskInfo>, DiskSpaceError> {
+    let disks = Disks::new_with_refreshed_list();
+    let mut disk_infos = Vec::new();
+
+    for disk in disks.list() {
+        let mount_point = disk.mount_point().to_string_lossy().to_string();
+        let total = disk.total_space();
+        let available = disk.available_space();
+        let used = total.saturating_sub(available);
+        let usage_percent = if total > 0 {
+            (used as f64 / total as f64) * 100.0
+        } else {
+            0.0
+        };
+
+        // Filter by path if specified
+        if let Some(target_path) = path {
+            // Check if the target path is under this mount point
+            if !target_path.starts_with(&mount_point) && mount_point != "/" {
+                continue;
+            }
+        }
+
+        disk_infos.push(DiskInfo {
+            mount_point,
+            total_bytes: total,
+            used_bytes: used,
+            available_bytes: available,
+            usage_percent,
+            file_system: disk.file_system().to_string_lossy().to_string(),
+        });
+    }
+
+    // If we have a specific path and multiple matches, return only the most specific one
+    if path.is_some() && disk_infos.len() > 1 {
+        // Sort by mount point length (longest = most specific) and take the first
+        disk_infos.sort_by(|a, b| b.mount_point.len().cmp(&a.mount_point.len()));
+        disk_infos.truncate(1);
+    }
+
+    Ok(disk_infos)
+}
+
+/// Get disk information for the disk holding `path`
+pub fn disk_info_for_path(path: &Path) -> Result<DiskInfo, DiskSpaceError> {
+    let path_str = path.to_string_lossy();
+    collect_disk_info(Some(&path_str))?
+        .into_iter()
+        .next()
+        .ok_or_else(|| DiskSpaceError::DiskNotFound(path_str.to_string()))
+}
+
+/// Recursively compute the size in bytes of all files under `path`
+///
+/// Missing paths count as zero and unreadable entries are skipped, so this never fails.
+/// Symlinks are not followed.
+pub fn directory_size(path: &Path) -> u64 {
+    let Ok(metadata) = std::fs::symlink_metadata(path) else {
+        return 0;
+    };
+
+    if metadata.is_file() {
+        return metadata.len();
+    }
+    if !metadata.is_dir() {
+        return 0;
+    }
+
+    let Ok(entries) = std::fs::read_dir(path) else {
+        return 0;
+    };
+
+    entries
+        .filter_map(|entry| entry.ok())
+        .map(|entry| directory_size(&entry.path()))
+        .sum()
+}
+
 /// Format bytes into a human-readable string
-fn format_bytes(bytes: u64) -> String {
+pub fn format_bytes(bytes: u64) -> String {
     const KB: u64 = 1024;
     const MB: u64 = KB * 1024;
     const GB: u64 = MB * 1024;
@@ -666,6 +731,40 @@ mod tests {
         assert_eq!(mock_service.notification_count(), 0);
     }
 
+    #[test]
+    fn test_disk_pressure_levels() {
+        let settings = DiskSpaceAlertSettings {
+            threshold_percent: 80,
+            critical_threshold_percent: 95,
+            ..Default::default()
+        };
+
+        assert_eq!(DiskPressure::from_usage(79.9, &settings), DiskPressure::Ok);
+        assert_eq!(
+            DiskPressure::from_usage(80.0, &settings),
+            DiskPressure::Warning
+        );
+        assert_eq!(
+            DiskPressure::from_usage(94.9, &settings),
+            DiskPressure::Warning
+        );
+        assert_eq!(
+            DiskPressure::from_usage(95.0, &settings),
+            DiskPressure::Critical
+        );
+    }
+
+    #[test]
+    fn test_directory_size() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(dir.path().join("a.log"), vec![0u8; 100]).unwrap();
+        std::fs::create_dir(dir.path().join("nested")).unwrap();
+        std::fs::write(dir.path().join("nested").join("b.log"), vec![0u8; 50]).unwrap();
+
+        assert_eq!(directory_size(dir.path()), 150);
+        assert_eq!(directory_size(&dir.path().join("missing")), 0);
+    }
+
     #[test]
     fn test_disk_space_error_display() {
         let config_err = DiskSpaceError::Configuration("test config error".to_string());
@@ -391,6 +391,8 @@ mod tests {
             git_url: None,
             git_provider_connection_id: None,
             attack_mode: false,
+            deploy_concurrency: None,
+            tags: Default::default(),
         };
 
         let scan = vulnerability_scans::Model {
@@ -0,0 +1,253 @@
+//! Build cache mounts
+//!
+//! Package manager and compiler caches are kept between builds in BuildKit cache
+//! mounts (`RUN --mount=type=cache`). Each cache has an ID scoped to the project, so
+//! projects never share a cache, and is mounted with `sharing=locked` so concurrent
+//! builds of the same project take turns using it instead of writing to it at the
+//! same time.
+
+use std::path::Path;
+
+/// Directory under which the language caches are mounted in build containers
+pub const CACHE_MOUNT_ROOT: &str = "/root/.cache/temps";
+
+/// A cache directory mounted into every `RUN` instruction of a build
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CacheMount {
+    /// Name of the cache within the project (e.g. "go-mod")
+    pub name: String,
+    /// Absolute path the cache is mounted at
+    pub target: String,
+    /// Environment variable pointing the tool at the cache, if it needs one
+    pub env_var: Option<String>,
+}
+
+impl CacheMount {
+    pub fn new(name: &str, target: &str, env_var: Option<&str>) -> Self {
+        Self {
+            name: name.to_string(),
+            target: target.to_string(),
+            env_var: env_var.map(|v| v.to_string()),
+        }
+    }
+
+    fn language(name: &str, env_var: &str) -> Self {
+        Self::new(
+            name,
+            &format!("{}/{}", CACHE_MOUNT_ROOT, name),
+            Some(env_var),
+        )
+    }
+}
+
+/// BuildKit cache ID of a project's cache
+pub fn cache_id(project_id: i32, name: &str) -> String {
+    format!("{}{}", cache_id_prefix(project_id), name)
+}
+
+/// Prefix shared by the cache IDs of a project
+pub fn cache_id_prefix(project_id: i32) -> String {
+    format!("temps-p{}-", project_id)
+}
+
+/// Caches for the languages and package managers used by the project at `path`
+pub fn detect_language_caches(path: &Path) -> Vec<CacheMount> {
+    let has = |file: &str| path.join(file).exists();
+    let mut caches = Vec::new();
+
+    if has("go.mod") {
+        caches.push(CacheMount::language("go-mod", "GOMODCACHE"));
+        caches.push(CacheMount::language("go-build", "GOCACHE"));
+    }
+
+    if has("pnpm-lock.yaml") {
+        caches.push(CacheMount::language("pnpm", "npm_config_store_dir"));
+    } else if has("yarn.lock") {
+        caches.push(CacheMount::language("yarn", "YARN_CACHE_FOLDER"));
+    } else if has("bun.lockb") || has("bun.lock") {
+        caches.push(CacheMount::language("bun", "BUN_INSTALL_CACHE_DIR"));
+    } else if has("package.json") {
+        caches.push(CacheMount::language("npm", "npm_config_cache"));
+    }
+
+    if has("uv.lock") {
+        caches.push(CacheMount::language("uv", "UV_CACHE_DIR"));
+    } else if has("requirements.txt") || has("pyproject.toml") || has("Pipfile") {
+        caches.push(CacheMount::language("pip", "PIP_CACHE_DIR"));
+    }
+
+    caches
+}
+
+/// Mount a project's caches into a Dockerfile
+///
+/// Every `RUN` instruction gets a cache mount per cache, unless it already mounts
+/// something at that target, and every stage sets the caches' environment variables
+/// right after its `FROM`. Requires BuildKit.
+pub fn apply_cache_mounts(dockerfile: &str, project_id: i32, caches: &[CacheMount]) -> String {
+    if caches.is_empty() {
+        return dockerfile.to_string();
+    }
+
+    let env_line = {
+        let vars: Vec<String> = caches
+            .iter()
+            .filter_map(|cache| {
+                cache
+                    .env_var
+                    .as_ref()
+                    .map(|var| format!("{}={}", var, cache.target))
+            })
+            .collect();
+        (!vars.is_empty()).then(|| format!("ENV {}", vars.join(" ")))
+    };
+
+    let mut output = Vec::new();
+    // A line ending in a backslash continues the previous instruction
+    let mut continuation = false;
+    for line in dockerfile.lines() {
+        let trimmed = line.trim_start();
+        let indent = &line[..line.len() - trimmed.len()];
+        let is_continuation = continuation;
+        continuation = trimmed.ends_with('\\');
+
+        if is_continuation || trimmed.starts_with('#') {
+            output.push(line.to_string());
+            continue;
+        }
+
+        match instruction(trimmed) {
+            Some("FROM") => {
+                output.push(line.to_string());
+                if let Some(env_line) = &env_line {
+                    output.push(env_line.clone());
+                }
+            }
+            Some("RUN") => {
+                let rest = trimmed[3..].trim_start();
+                let mounts: Vec<String> = caches
+                    .iter()
+                    .filter(|cache| !mounts_target(trimmed, &cache.target))
+                    .map(|cache| {
+                        format!(
+                            "--mount=type=cache,id={},target={},sharing=locked",
+                            cache_id(project_id, &cache.name),
+                            cache.target
+                        )
+                    })
+                    .collect();
+                if mounts.is_empty() {
+                    output.push(line.to_string());
+                } else {
+                    output.push(format!("{}RUN {} {}", indent, mounts.join(" "), rest));
+                }
+            }
+            _ => output.push(line.to_string()),
+        }
+    }
+
+    let mut result = output.join("\n");
+    if dockerfile.ends_with('\n') {
+        result.push('\n');
+    }
+    result
+}
+
+/// The instruction keyword of a Dockerfile line, uppercased
+fn instruction(line: &str) -> Option<&'static str> {
+    let keyword = line.split_whitespace().next()?;
+    if keyword.eq_ignore_ascii_case("FROM") {
+        Some("FROM")
+    } else if keyword.eq_ignore_ascii_case("RUN") {
+        Some("RUN")
+    } else {
+        None
+    }
+}
+
+/// Whether a `RUN` line already mounts something at `target`
+fn mounts_target(line: &str, target: &str) -> bool {
+    line.split_whitespace()
+        .filter(|word| word.starts_with("--mount="))
+        .any(|mount| {
+            mount.split(',').any(|option| {
+                option
+                    .strip_prefix("target=")
+                    .or_else(|| option.strip_prefix("dst="))
+                    .or_else(|| option.strip_prefix("destination="))
+                    == Some(target)
+            })
+        })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::TempDir;
+
+    fn names(caches: &[CacheMount]) -> Vec<&str> {
+        caches.iter().map(|c| c.name.as_str()).collect()
+    }
+
+    #[test]
+    fn test_detect_language_caches() {
+        let dir = TempDir::new().unwrap();
+        assert!(detect_language_caches(dir.path()).is_empty());
+
+        fs::write(dir.path().join("go.mod"), "module example.com/app").unwrap();
+        fs::write(dir.path().join("package.json"), "{}").unwrap();
+        fs::write(dir.path().join("pnpm-lock.yaml"), "").unwrap();
+        let caches = detect_language_caches(dir.path());
+        assert_eq!(names(&caches), vec!["go-mod", "go-build", "pnpm"]);
+        assert_eq!(caches[0].target, "/root/.cache/temps/go-mod");
+        assert_eq!(caches[0].env_var.as_deref(), Some("GOMODCACHE"));
+        assert_eq!(caches[1].env_var.as_deref(), Some("GOCACHE"));
+    }
+
+    #[test]
+    fn test_cache_ids_are_scoped_to_the_project() {
+        assert_eq!(cache_id(1, "npm"), "temps-p1-npm");
+        assert!(!cache_id(12, "npm").starts_with(&cache_id_prefix(1)));
+    }
+
+    #[test]
+    fn test_apply_cache_mounts() {
+        let dockerfile = "FROM golang:1.22 AS build\n\
+            WORKDIR /app\n\
+            RUN go mod download\n\
+            RUN --mount=type=cache,target=/root/.cache/temps/go-build go build \\\n    \
+            -o /app/server .\n\
+            FROM alpine\n\
+            COPY --from=build /app/server /server\n";
+        let caches = vec![
+            CacheMount::language("go-mod", "GOMODCACHE"),
+            CacheMount::language("go-build", "GOCACHE"),
+        ];
+
+        let result = apply_cache_mounts(dockerfile, 7, &caches);
+        let lines: Vec<&str> = result.lines().collect();
+        let env = "ENV GOMODCACHE=/root/.cache/temps/go-mod GOCACHE=/root/.cache/temps/go-build";
+        assert_eq!(lines[1], env);
+        assert_eq!(
+            lines[3],
+            "RUN --mount=type=cache,id=temps-p7-go-mod,target=/root/.cache/temps/go-mod,sharing=locked \
+             --mount=type=cache,id=temps-p7-go-build,target=/root/.cache/temps/go-build,sharing=locked \
+             go mod download"
+        );
+        // The existing mount of the build cache is kept and not doubled
+        assert!(lines[4].starts_with(
+            "RUN --mount=type=cache,id=temps-p7-go-mod,target=/root/.cache/temps/go-mod,sharing=locked \
+             --mount=type=cache,target=/root/.cache/temps/go-build go build"
+        ));
+        assert_eq!(lines[5], "    -o /app/server .");
+        assert_eq!(lines[7], env);
+        assert!(result.ends_with('\n'));
+    }
+
+    #[test]
+    fn test_apply_no_cache_mounts() {
+        let dockerfile = "FROM node:20\nRUN npm ci\n";
+        assert_eq!(apply_cache_mounts(dockerfile, 1, &[]), dockerfile);
+    }
+}
@@ -17,6 +17,9 @@ mod go_preset;
 mod python_preset;
 mod java_preset;
 
+// Build cache mounts for language package managers and compilers
+pub mod build_cache;
+
 // Preset configuration schemas
 // Source abstraction for file access
 pub mod source;
@@ -428,10 +428,12 @@ impl NixpacksPreset {
     /// 5. Read the generated Dockerfile from .nixpacks/Dockerfile
     ///
     /// Returns both the Dockerfile content and the build args that should be passed to docker build.
+    /// A `build_command` replaces the build phase command nixpacks detects.
     async fn generate_dockerfile_content(
         &self,
         path: &Path,
         build_vars: Option<&Vec<String>>,
+        build_command: Option<&str>,
     ) -> Result<DockerfileWithArgs, String> {
         let path_str = path
             .to_str()
@@ -443,10 +445,16 @@ impl NixpacksPreset {
         let app =
             App::new(path_str).map_err(|e| format!("Failed to create nixpacks app: {}", e))?;
 
-        // Create environment from build variables
-        let env_vars: Vec<&str> = build_vars
+        // Create environment from build variables and the build command override,
+        // which nixpacks reads from NIXPACKS_BUILD_CMD
+        let build_command_env =
+            build_command.map(|command| format!("NIXPACKS_BUILD_CMD={}", command));
+        let mut env_vars: Vec<&str> = build_vars
             .map(|vars| vars.iter().map(|s| s.as_str()).collect())
             .unwrap_or_default();
+        if let Some(env) = &build_command_env {
+            env_vars.push(env.as_str());
+        }
 
         let environment = Environment::from_envs(env_vars)
             .map_err(|e| format!("Failed to create environment: {}", e))?;
@@ -558,7 +566,7 @@ impl Preset for NixpacksPreset {
 
     async fn dockerfile(&self, config: DockerfileConfig<'_>) -> DockerfileWithArgs {
         match self
-            .generate_dockerfile_content(config.local_path, config.build_vars)
+            .generate_dockerfile_content(config.local_path, config.build_vars, config.build_command)
             .await
         {
             Ok(dockerfile_with_args) => dockerfile_with_args,
@@ -579,7 +587,7 @@ COPY . .
     }
 
     async fn dockerfile_with_build_dir(&self, local_path: &Path) -> DockerfileWithArgs {
-        match self.generate_dockerfile_content(local_path, None).await {
+        match self.generate_dockerfile_content(local_path, None, None).await {
             Ok(dockerfile_with_args) => dockerfile_with_args,
             Err(e) => {
                 warn!("Failed to generate nixpacks Dockerfile: {}", e);
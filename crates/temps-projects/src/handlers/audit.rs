@@ -54,6 +54,7 @@ pub struct PipelineTriggeredAudit {
     pub branch: Option<String>,
     pub tag: Option<String>,
     pub commit: Option<String>,
+    pub dry_run: bool,
 }
 
 impl AuditOperation for PipelineTriggeredAudit {
@@ -79,6 +80,44 @@ impl AuditOperation for PipelineTriggeredAudit {
     }
 }
 
+/// A deploy trigger that repeated an idempotency key and got the earlier trigger back
+#[derive(Debug, Clone, Serialize)]
+pub struct DeployTriggerDeduplicatedAudit {
+    pub context: AuditContext,
+    pub project_id: i32,
+    pub project_slug: String,
+    pub environment_id: i32,
+    pub environment_slug: String,
+    pub idempotency_key: String,
+    /// When the first trigger with the key was received
+    pub first_triggered_at: chrono::DateTime<chrono::Utc>,
+    pub deployment_id: Option<i32>,
+    pub commit: Option<String>,
+}
+
+impl AuditOperation for DeployTriggerDeduplicatedAudit {
+    fn operation_type(&self) -> String {
+        "DEPLOY_TRIGGER_DEDUPLICATED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
 #[derive(Debug, Clone, Serialize)]
 pub struct ProjectDeletedAudit {
     pub context: AuditContext,
@@ -1,7 +1,10 @@
 use super::types::{
     AppState, CustomDomainRequest, CustomDomainResponse, CustomDomainWithInfo, DomainEnvironment,
     DomainInfo, ListCustomDomainsResponse, UpdateCustomDomainRequest,
+    VerifyCustomDomainDnsResponse,
 };
+use crate::services::custom_domains::{CustomDomainError, CustomDomainService};
+use crate::services::domain_dns::{DnsRecord, DomainDnsCheck};
 use axum::{
     extract::{Path, State},
     http::StatusCode,
@@ -14,6 +17,7 @@ use std::sync::Arc;
 use temps_auth::{permission_guard, RequireAuth};
 use temps_core::problemdetails;
 use temps_core::problemdetails::Problem;
+use temps_entities::path_rules::{PathRule, PathRuleList};
 use temps_entities::{domains, environments, project_custom_domains};
 use tracing::{error, info};
 use utoipa::OpenApi;
@@ -27,6 +31,7 @@ use utoipa::OpenApi;
         update_custom_domain,
         delete_custom_domain,
         link_custom_domain_to_certificate,
+        verify_custom_domain_dns,
     ),
     components(
         schemas(
@@ -34,6 +39,10 @@ use utoipa::OpenApi;
             CustomDomainResponse,
             UpdateCustomDomainRequest,
             ListCustomDomainsResponse,
+            VerifyCustomDomainDnsResponse,
+            DomainDnsCheck,
+            DnsRecord,
+            PathRule,
         )
     ),
     tags((name = "Custom Domains", description = "Custom domain management for projects"))
@@ -73,6 +82,21 @@ pub async fn create_custom_domain(
         request.domain, project_id
     );
 
+    // Reject a bad canonical redirect or path rules before the domain gets created
+    if let Some(mode) = request
+        .canonical_redirect
+        .as_deref()
+        .filter(|m| !m.is_empty())
+    {
+        CustomDomainService::parse_canonical_redirect(&request.domain, mode)?;
+    }
+    let path_rules = request.path_rules.map(|rules| PathRuleList { rules });
+    if let Some(path_rules) = &path_rules {
+        path_rules
+            .validate()
+            .map_err(CustomDomainError::InvalidDomain)?;
+    }
+
     let custom_domain = state
         .custom_domain_service
         .create_custom_domain(
@@ -85,6 +109,29 @@ pub async fn create_custom_domain(
         )
         .await?;
 
+    let custom_domain = if request.canonical_redirect.is_some() || request.force_https.is_some() {
+        state
+            .custom_domain_service
+            .update_redirect_settings(
+                custom_domain.id,
+                request.canonical_redirect,
+                request.force_https,
+            )
+            .await?
+    } else {
+        custom_domain
+    };
+
+    let custom_domain = match path_rules {
+        Some(path_rules) => {
+            state
+                .custom_domain_service
+                .set_path_rules(custom_domain.id, path_rules)
+                .await?
+        }
+        None => custom_domain,
+    };
+
     // Fetch additional info for response
     let domain_with_info = get_domain_with_info(&state, custom_domain).await?;
 
@@ -290,6 +337,25 @@ pub async fn update_custom_domain(
         updated_domain
     };
 
+    let updated_domain = if request.canonical_redirect.is_some() || request.force_https.is_some() {
+        state
+            .custom_domain_service
+            .update_redirect_settings(domain_id, request.canonical_redirect, request.force_https)
+            .await?
+    } else {
+        updated_domain
+    };
+
+    let updated_domain = match request.path_rules {
+        Some(rules) => {
+            state
+                .custom_domain_service
+                .set_path_rules(domain_id, PathRuleList { rules })
+                .await?
+        }
+        None => updated_domain,
+    };
+
     let domain_with_info = get_domain_with_info(&state, updated_domain).await?;
 
     Ok((
@@ -416,6 +482,71 @@ pub async fn link_custom_domain_to_certificate(
     ))
 }
 
+/// Check a custom domain's DNS records
+///
+/// Looks up the records published for the domain and compares them with the records
+/// it needs (A/ALIAS for apex domains, CNAME for subdomains). When the domain points
+/// at the platform it is marked as verified, and activated if a certificate is linked.
+#[utoipa::path(
+    post,
+    path = "/{project_id}/custom-domains/{domain_id}/verify-dns",
+    responses(
+        (status = 200, description = "DNS checked", body = VerifyCustomDomainDnsResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 404, description = "Custom domain not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("domain_id" = i32, Path, description = "Custom domain ID")
+    ),
+    tag = "Custom Domains",
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+pub async fn verify_custom_domain_dns(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<AppState>>,
+    Path((project_id, domain_id)): Path<(i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    info!(
+        "Verifying DNS for custom domain: {} for project: {}",
+        domain_id, project_id
+    );
+
+    // Verify domain belongs to project
+    let existing_domain = state
+        .custom_domain_service
+        .get_custom_domain(domain_id)
+        .await?
+        .ok_or_else(|| {
+            problemdetails::new(StatusCode::NOT_FOUND)
+                .with_title("Custom domain not found")
+                .with_detail(format!("Custom domain with ID {} not found", domain_id))
+        })?;
+
+    if existing_domain.project_id != project_id {
+        return Err(problemdetails::new(StatusCode::NOT_FOUND)
+            .with_title("Custom domain not found")
+            .with_detail("Domain does not belong to the specified project"));
+    }
+
+    let (updated_domain, dns) = state.custom_domain_service.verify_dns(domain_id).await?;
+
+    let domain_with_info = get_domain_with_info(&state, updated_domain).await?;
+
+    Ok((
+        StatusCode::OK,
+        Json(VerifyCustomDomainDnsResponse {
+            domain: CustomDomainResponse::from(domain_with_info),
+            dns,
+        }),
+    ))
+}
+
 // Helper function to get domain with additional info
 async fn get_domain_with_info(
     state: &Arc<AppState>,
@@ -483,4 +614,8 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
             "/projects/{project_id}/custom-domains/{domain_id}/link-certificate/{certificate_id}",
             post(link_custom_domain_to_certificate),
         )
+        .route(
+            "/projects/{project_id}/custom-domains/{domain_id}/verify-dns",
+            post(verify_custom_domain_dns),
+        )
 }
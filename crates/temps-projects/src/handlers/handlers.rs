@@ -5,10 +5,13 @@ use super::audit::{
 use utoipa::OpenApi;
 
 use super::AppState;
+use crate::services::deploy_idempotency::{
+    DeployIdempotencyService, IdempotencyClaim, IDEMPOTENCY_KEY_HEADER,
+};
 use axum::Router;
 use axum::{
     extract::{Extension, Path, Query, State},
-    http::StatusCode,
+    http::{HeaderMap, StatusCode},
     response::IntoResponse,
     routing::{delete, get, patch, post, put},
     Json,
@@ -17,13 +20,14 @@ use std::sync::Arc;
 use temps_auth::permission_guard;
 use temps_auth::RequireAuth;
 use temps_core::RequestMetadata;
+use temps_entities::tags::Tags;
 use tracing::{debug, error, info};
 
 use super::types::{
     CreateProjectRequest, PaginatedProjectList, PaginationParams, ProjectResponse,
-    ProjectStatisticsResponse, TriggerPipelinePayload, TriggerPipelineResponse,
-    UpdateAutomaticDeployRequest, UpdateDeploymentConfigRequest, UpdateGitSettingsRequest,
-    UpdateProjectSettingsRequest,
+    ProjectStatisticsResponse, RepositoryTagResponse, TriggerPipelinePayload,
+    TriggerPipelineResponse, UpdateAutomaticDeployRequest, UpdateDeploymentConfigRequest,
+    UpdateGitSettingsRequest, UpdateProjectSettingsRequest,
 };
 use sea_orm::{ColumnTrait, EntityTrait, QueryFilter};
 use temps_core::problemdetails;
@@ -48,6 +52,7 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
             "/projects/{id}/trigger-pipeline",
             post(trigger_project_pipeline),
         )
+        .route("/projects/{id}/tags", get(list_project_tags))
         .route(
             "/projects/{project_id}/settings",
             post(update_project_settings),
@@ -79,6 +84,7 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
         update_automatic_deploy,
         update_project_deployment_config,
         trigger_project_pipeline,
+        list_project_tags,
         get_project_statistics,
         list_presets,
     ),
@@ -94,6 +100,7 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
             UpdateDeploymentConfigRequest,
             TriggerPipelinePayload,
             TriggerPipelineResponse,
+            RepositoryTagResponse,
             ProjectStatisticsResponse,
             super::types::PresetResponse,
             super::types::ListPresetsResponse,
@@ -199,10 +206,12 @@ pub async fn create_project(
     tag = "Projects",
     params(
         ("page" = Option<i64>, Query, description = "Page number (1-based)"),
-        ("per_page" = Option<i64>, Query, description = "Number of items per page")
+        ("per_page" = Option<i64>, Query, description = "Number of items per page"),
+        ("tags" = Option<String>, Query, description = "Only projects having all of these tags, e.g. team=payments,tier=critical")
     ),
     responses(
         (status = 200, description = "List of projects", body = PaginatedProjectList),
+        (status = 400, description = "Invalid tag filter"),
         (status = 401, description = "Unauthorized"),
         (status = 500, description = "Internal server error")
     ),
@@ -219,10 +228,20 @@ pub async fn get_projects(
 
     let page = params.page.unwrap_or(1);
     let per_page = params.per_page.unwrap_or(10);
+    let tags = params
+        .tags
+        .as_deref()
+        .map(Tags::parse_selector)
+        .transpose()
+        .map_err(|e| {
+            problemdetails::new(StatusCode::BAD_REQUEST)
+                .with_title("Invalid tag filter")
+                .with_detail(e)
+        })?;
 
     let (projects, total) = state
         .project_service
-        .get_projects_paginated(page, per_page)
+        .get_projects_paginated(page, per_page, tags)
         .await
         .map_err(Problem::from)?;
 
@@ -476,6 +495,8 @@ pub async fn update_project_settings(
             settings.directory.clone(),
             settings.attack_mode,
             settings.enable_preview_environments,
+            settings.deploy_concurrency,
+            settings.tags.clone(),
         )
         .await
         .map_err(Problem::from)?;
@@ -745,17 +766,24 @@ pub async fn update_project_deployment_config(
 }
 
 /// Trigger pipeline for a specific project
+///
+/// Send an `Idempotency-Key` header to make retries safe: a trigger repeated with the
+/// same key within the dedup window (`deploy_idempotency.window_minutes` in the
+/// settings, a day by default) returns the first trigger, with `deduplicated` set,
+/// instead of starting another deploy. A trigger that fails releases its key.
 #[utoipa::path(
     post,
     path = "/projects/{id}/trigger-pipeline",
     params(
         ("id" = i32, Path, description = "Project ID"),
+        ("Idempotency-Key" = Option<String>, Header, description = "Key deduplicating retries of the trigger, up to 255 printable ASCII characters"),
     ),
     request_body = TriggerPipelinePayload,
     responses(
-        (status = 200, description = "Pipeline triggered successfully", body = TriggerPipelineResponse),
+        (status = 200, description = "Pipeline triggered successfully, or the trigger with the same idempotency key", body = TriggerPipelineResponse),
         (status = 404, description = "Project not found"),
         (status = 400, description = "Invalid request"),
+        (status = 422, description = "Idempotency key already used for another environment"),
         (status = 500, description = "Internal server error")
     ),
     tag = "Projects"
@@ -765,6 +793,7 @@ pub async fn trigger_project_pipeline(
     Path(id): Path<i32>,
     RequireAuth(auth): RequireAuth,
     Extension(metadata): Extension<RequestMetadata>,
+    headers: HeaderMap,
     Json(payload): Json<super::types::TriggerPipelinePayload>,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, ProjectsWrite);
@@ -806,6 +835,54 @@ pub async fn trigger_project_pipeline(
         user_agent: metadata.user_agent,
     };
 
+    // A retried trigger gets the first one back
+    let idempotency_claim = match headers
+        .get(IDEMPOTENCY_KEY_HEADER)
+        .map(|value| value.to_str().unwrap_or_default())
+    {
+        Some(key) => {
+            let key = DeployIdempotencyService::validate_key(key)?;
+            match state
+                .deploy_idempotency_service
+                .claim(id, environment.id, &key)
+                .await?
+            {
+                IdempotencyClaim::Claimed(claim) => Some(claim),
+                IdempotencyClaim::Duplicate(duplicate) => {
+                    let trigger = duplicate.trigger;
+                    let audit_event = super::audit::DeployTriggerDeduplicatedAudit {
+                        context: audit_context,
+                        project_id: id,
+                        project_slug: project.slug.clone(),
+                        environment_id: environment.id,
+                        environment_slug: environment.slug.clone(),
+                        idempotency_key: key,
+                        first_triggered_at: trigger.created_at,
+                        deployment_id: duplicate.deployment_id,
+                        commit: trigger.commit.clone(),
+                    };
+                    if let Err(e) = state.audit_service.create_audit_log(&audit_event).await {
+                        error!("Failed to create audit log: {:?}", e);
+                    }
+
+                    let response = TriggerPipelineResponse {
+                        message: "Pipeline already triggered with this idempotency key".to_string(),
+                        project_id: id,
+                        environment_id: trigger.environment_id,
+                        branch: trigger.branch,
+                        tag: trigger.tag,
+                        commit: trigger.commit,
+                        dry_run: trigger.dry_run,
+                        deployment_id: duplicate.deployment_id,
+                        deduplicated: true,
+                    };
+                    return Ok(Json(response).into_response());
+                }
+            }
+        }
+        None => None,
+    };
+
     // Create audit event
     let audit_event = super::audit::PipelineTriggeredAudit {
         context: audit_context,
@@ -816,6 +893,7 @@ pub async fn trigger_project_pipeline(
         branch: payload.branch.clone(),
         tag: payload.tag.clone(),
         commit: payload.commit.clone(),
+        dry_run: payload.dry_run,
     };
 
     // Log the audit event
@@ -825,7 +903,7 @@ pub async fn trigger_project_pipeline(
     }
 
     // Trigger the pipeline
-    let (project_id, triggered_env_id, branch, tag, commit) = state
+    let triggered = state
         .project_service
         .trigger_pipeline(
             id,
@@ -833,25 +911,102 @@ pub async fn trigger_project_pipeline(
             payload.branch,
             payload.tag,
             payload.commit,
+            payload.release_note,
+            auth.user_id_opt(),
+            payload.dry_run,
         )
-        .await
-        .map_err(|e| {
+        .await;
+    let (project_id, triggered_env_id, branch, tag, commit) = match triggered {
+        Ok(triggered) => triggered,
+        Err(e) => {
             error!("Error triggering pipeline: {:?}", e);
-            Problem::from(e)
-        })?;
+            if let Some(claim) = idempotency_claim {
+                state.deploy_idempotency_service.release(claim).await;
+            }
+            return Err(Problem::from(e));
+        }
+    };
+
+    if let Some(claim) = idempotency_claim {
+        if let Err(e) = state
+            .deploy_idempotency_service
+            .record(
+                claim,
+                branch.clone(),
+                tag.clone(),
+                commit.clone(),
+                payload.dry_run,
+            )
+            .await
+        {
+            error!(
+                "Failed to record idempotency key of pipeline trigger: {}",
+                e
+            );
+        }
+    }
 
     let response = super::types::TriggerPipelineResponse {
-        message: "Pipeline triggered successfully".to_string(),
+        message: if payload.dry_run {
+            "Dry run triggered successfully".to_string()
+        } else {
+            "Pipeline triggered successfully".to_string()
+        },
         project_id,
         environment_id: triggered_env_id,
         branch,
         tag,
         commit,
+        dry_run: payload.dry_run,
+        deployment_id: None,
+        deduplicated: false,
     };
 
     Ok(Json(response).into_response())
 }
 
+/// List the tags available in a project's repository
+#[utoipa::path(
+    get,
+    path = "/projects/{id}/tags",
+    params(
+        ("id" = i32, Path, description = "Project ID"),
+    ),
+    responses(
+        (status = 200, description = "Repository tags", body = Vec<RepositoryTagResponse>),
+        (status = 400, description = "Project has no git provider connection"),
+        (status = 404, description = "Project not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    tag = "Projects",
+    security(
+        ("bearer_auth" = [])
+    )
+)]
+pub async fn list_project_tags(
+    State(state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    RequireAuth(auth): RequireAuth,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsRead);
+
+    let tags = state
+        .project_service
+        .list_repository_tags(id)
+        .await
+        .map_err(Problem::from)?;
+
+    let response: Vec<RepositoryTagResponse> = tags
+        .into_iter()
+        .map(|tag| RepositoryTagResponse {
+            name: tag.name,
+            commit_sha: tag.commit_sha,
+        })
+        .collect();
+
+    Ok(Json(response))
+}
+
 /// Get project statistics
 #[utoipa::path(
     get,
@@ -1,9 +1,13 @@
 use serde::{Deserialize, Serialize};
 use temps_core::UtcDateTime;
 use temps_entities::deployment_config::DeploymentConfig;
+use temps_entities::path_rules::PathRule;
+use temps_entities::tags::Tags;
 use utoipa::ToSchema;
 
 use crate::services::custom_domains::CustomDomainService;
+use crate::services::deploy_idempotency::DeployIdempotencyService;
+use crate::services::domain_dns::{is_apex_domain, DomainDnsCheck};
 use crate::services::project::ProjectService;
 use crate::services::types::ProjectError;
 use http::StatusCode;
@@ -16,6 +20,7 @@ use temps_presets::preset_config_schema::PresetConfigSchema;
 pub struct AppState {
     pub project_service: Arc<ProjectService>,
     pub custom_domain_service: Arc<CustomDomainService>,
+    pub deploy_idempotency_service: Arc<DeployIdempotencyService>,
     pub audit_service: Arc<dyn AuditLogger>,
 }
 
@@ -185,11 +190,22 @@ pub struct CreateProjectRequest {
 
 #[derive(Serialize, Deserialize, ToSchema)]
 pub struct TriggerPipelinePayload {
+    /// Branch to deploy (defaults to the project's main branch unless a tag is given)
     pub branch: Option<String>,
+    /// Git tag to deploy, e.g. a release tag like `v1.2.0`
     pub tag: Option<String>,
+    /// Exact commit SHA to deploy
     pub commit: Option<String>,
     /// Optional environment ID - if not provided, will use the project's preview environment
     pub environment_id: Option<i32>,
+    /// Release note shown in the project's changelog; defaults to the commit message
+    #[serde(default)]
+    #[schema(example = "Faster checkout and a fix for duplicate emails")]
+    pub release_note: Option<String>,
+    /// Build the app and run its checks against throwaway containers, without
+    /// shipping it; the current deployment keeps serving
+    #[serde(default)]
+    pub dry_run: bool,
 }
 
 #[derive(Serialize, Deserialize, ToSchema)]
@@ -200,6 +216,21 @@ pub struct TriggerPipelineResponse {
     pub branch: Option<String>,
     pub tag: Option<String>,
     pub commit: Option<String>,
+    pub dry_run: bool,
+    /// Deployment the trigger started, once known; a new trigger is queued, so this
+    /// is only set when a repeated trigger finds the deployment of the first one
+    #[serde(default)]
+    pub deployment_id: Option<i32>,
+    /// Whether the request repeated an earlier trigger's idempotency key and got that
+    /// trigger back instead of starting a new deploy
+    #[serde(default)]
+    pub deduplicated: bool,
+}
+
+#[derive(Serialize, Deserialize, ToSchema)]
+pub struct RepositoryTagResponse {
+    pub name: String,
+    pub commit_sha: String,
 }
 
 #[derive(Serialize, Deserialize, ToSchema)]
@@ -218,6 +249,8 @@ fn default_performance_metrics() -> bool {
 pub struct PaginationParams {
     pub page: Option<i64>,
     pub per_page: Option<i64>,
+    /// Only projects having all of these tags, e.g. `team=payments,tier=critical`
+    pub tags: Option<String>,
 }
 
 #[derive(Serialize, Deserialize, ToSchema)]
@@ -248,6 +281,11 @@ pub struct ProjectResponse {
     pub attack_mode: bool,
     /// Enable automatic preview environment creation for each branch
     pub enable_preview_environments: bool,
+    /// Maximum environments built and deployed at once during a project-wide deploy
+    /// (None = server default)
+    pub deploy_concurrency: Option<i32>,
+    /// Key/value tags of the project
+    pub tags: Tags,
 }
 
 #[derive(Debug, Serialize, Deserialize, ToSchema)]
@@ -274,6 +312,8 @@ impl ProjectResponse {
             git_provider_connection_id: project.git_provider_connection_id,
             attack_mode: project.attack_mode,
             enable_preview_environments: project.enable_preview_environments,
+            deploy_concurrency: project.deploy_concurrency,
+            tags: project.tags,
             deployment_config: DeploymentConfig {
                 cpu_request: project
                     .deployment_config
@@ -305,6 +345,10 @@ impl ProjectResponse {
                     .clone()
                     .map(|c| c.automatic_deploy)
                     .unwrap_or(false),
+                auto_deploy_tag_pattern: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.auto_deploy_tag_pattern),
                 performance_metrics_enabled: project
                     .deployment_config
                     .clone()
@@ -321,6 +365,171 @@ impl ProjectResponse {
                     .map(|c| c.replicas)
                     .unwrap_or(1), // Default
                 security: project.deployment_config.clone().and_then(|c| c.security),
+                upstream_protocol: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.upstream_protocol),
+                request_timeout_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.request_timeout_seconds),
+                stream_timeout_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.stream_timeout_seconds),
+                websocket_idle_timeout_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.websocket_idle_timeout_seconds),
+                websocket_max_duration_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.websocket_max_duration_seconds),
+                response_buffering: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.response_buffering),
+                connection_drain_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.connection_drain_seconds),
+                max_request_body_bytes: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.max_request_body_bytes),
+                max_request_header_bytes: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.max_request_header_bytes),
+                client_timeout_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.client_timeout_seconds),
+                write_timeout_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.write_timeout_seconds),
+                idle_timeout_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.idle_timeout_seconds),
+                connect_timeout_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.connect_timeout_seconds),
+                https_mode: project.deployment_config.clone().and_then(|c| c.https_mode),
+                https_redirect_status: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.https_redirect_status),
+                stop_signal: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.stop_signal),
+                stop_timeout_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.stop_timeout_seconds),
+                artifact_retention_days: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.artifact_retention_days),
+                retained_images: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.retained_images),
+                deploy_timeout_seconds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.deploy_timeout_seconds),
+                static_site: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.static_site),
+                promotion: project.deployment_config.clone().and_then(|c| c.promotion),
+                build_cache: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.build_cache),
+                builder: project.deployment_config.clone().and_then(|c| c.builder),
+                shared_build: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.shared_build),
+                build_command: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.build_command),
+                start_command: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.start_command),
+                entrypoint: project.deployment_config.clone().and_then(|c| c.entrypoint),
+                args: project.deployment_config.clone().and_then(|c| c.args),
+                build_platforms: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.build_platforms),
+                auto_retry: project.deployment_config.clone().and_then(|c| c.auto_retry),
+                build_priority: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.build_priority),
+                container_logs: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.container_logs),
+                locale_defaults: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.locale_defaults),
+                startup_probe: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.startup_probe),
+                config_reload: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.config_reload),
+                exec_health_check: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.exec_health_check),
+                health_thresholds: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.health_thresholds),
+                smoke_tests: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.smoke_tests),
+                container_dns: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.container_dns),
+                container_limits: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.container_limits),
+                deploy_gate: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.deploy_gate)
+                    .map(|gate| gate.masked()),
+                cdn_purge: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.cdn_purge)
+                    .map(|purge| purge.masked()),
+                processes: project.deployment_config.clone().and_then(|c| c.processes),
+                protected_secrets: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.protected_secrets),
+                concurrency_limit: project
+                    .deployment_config
+                    .clone()
+                    .and_then(|c| c.concurrency_limit),
             },
         }
     }
@@ -333,6 +542,14 @@ pub struct CustomDomainRequest {
     pub status_code: Option<i32>,
     pub branch: Option<String>,
     pub environment_id: i32,
+    /// Redirect between an apex domain and its www host: "www_to_apex" or "apex_to_www"
+    #[schema(example = "www_to_apex")]
+    pub canonical_redirect: Option<String>,
+    /// Redirect plain HTTP requests to HTTPS (defaults to true)
+    pub force_https: Option<bool>,
+    /// Paths this domain serves, where they map, and which environment serves each; all
+    /// paths go to the domain's environment when empty
+    pub path_rules: Option<Vec<PathRule>>,
 }
 
 #[derive(Serialize, Deserialize, ToSchema)]
@@ -358,6 +575,13 @@ pub struct CustomDomainResponse {
     pub updated_at: i64,
     pub expiration_time: Option<i64>,
     pub last_renewed: Option<i64>,
+    /// Whether the domain is an apex (root) domain
+    pub is_apex: bool,
+    pub canonical_redirect: Option<String>,
+    pub force_https: bool,
+    /// When DNS was last confirmed to point at the platform
+    pub dns_verified_at: Option<i64>,
+    pub path_rules: Vec<PathRule>,
 }
 
 impl From<CustomDomainWithInfo> for CustomDomainResponse {
@@ -391,6 +615,11 @@ impl From<CustomDomainWithInfo> for CustomDomainResponse {
             last_renewed: domain_info
                 .as_ref()
                 .and_then(|info| info.last_renewed.map(|dt| dt.timestamp_millis())),
+            is_apex: is_apex_domain(&domain.domain),
+            canonical_redirect: domain.canonical_redirect,
+            force_https: domain.force_https,
+            dns_verified_at: domain.dns_verified_at.map(|dt| dt.timestamp_millis()),
+            path_rules: domain.path_rules.rules,
         }
     }
 }
@@ -432,6 +661,105 @@ pub struct UpdateDeploymentConfigRequest {
     pub session_recording_enabled: Option<bool>,
     pub replicas: Option<i32>,
     pub security: Option<temps_entities::deployment_config::SecurityConfig>,
+    /// Protocol the proxy uses to reach the containers: `http1`, `h2c` (gRPC) or `h2`
+    pub upstream_protocol: Option<temps_entities::deployment_config::UpstreamProtocol>,
+    /// Seconds the proxy waits for a regular response
+    pub request_timeout_seconds: Option<u32>,
+    /// Seconds a streaming request (gRPC, SSE, WebSocket) may stay idle
+    pub stream_timeout_seconds: Option<u32>,
+    /// Seconds a WebSocket may go without frames; defaults to the stream timeout
+    pub websocket_idle_timeout_seconds: Option<u32>,
+    /// Maximum lifetime of a WebSocket in seconds
+    pub websocket_max_duration_seconds: Option<u32>,
+    /// Whether the proxy may buffer responses; false streams them through as they
+    /// arrive, for SSE and downloads
+    pub response_buffering: Option<bool>,
+    /// Longest the previous deployment keeps serving open connections after a deploy
+    pub connection_drain_seconds: Option<u32>,
+    /// Largest request body in bytes; larger requests get 413
+    pub max_request_body_bytes: Option<u64>,
+    /// Largest request line plus headers in bytes; larger requests get 431
+    pub max_request_header_bytes: Option<u32>,
+    /// Seconds to wait for the client to send more of its request (408)
+    pub client_timeout_seconds: Option<u32>,
+    /// Seconds to wait when writing to the upstream (504)
+    pub write_timeout_seconds: Option<u32>,
+    /// Seconds an unused upstream connection is kept for reuse
+    pub idle_timeout_seconds: Option<u32>,
+    /// Seconds to wait for a connection to the upstream (504)
+    pub connect_timeout_seconds: Option<u32>,
+    /// Most requests proxied to the service at once, with an optional queue for the
+    /// ones over it (503 when full)
+    pub concurrency_limit: Option<temps_entities::deployment_config::ConcurrencyLimitConfig>,
+    /// `auto` (redirect to HTTPS once the host has an active certificate),
+    /// `force_https`, `both` or `http_only`
+    pub https_mode: Option<temps_entities::deployment_config::HttpsMode>,
+    /// Status of the redirects between HTTP and HTTPS: 301, 302, 307 or 308
+    pub https_redirect_status: Option<u16>,
+    /// Signal that stops the containers gracefully; `SIGTERM` when unset
+    pub stop_signal: Option<String>,
+    /// Seconds the containers get to exit after the stop signal (1-120)
+    pub stop_timeout_seconds: Option<u32>,
+    /// Days to keep each deployment's build artifacts; not retained when unset
+    pub artifact_retention_days: Option<u32>,
+    /// Number of recent deployment images kept for rollbacks (1-100), in place of the
+    /// global garbage collection setting; the running image is always kept
+    pub retained_images: Option<u32>,
+    /// Seconds the whole deploy may take (60 to 86400, default 3600) before it's
+    /// aborted and fails
+    pub deploy_timeout_seconds: Option<u32>,
+    /// Static site hosting: output directory, SPA fallback, 404 page and caching
+    pub static_site: Option<temps_entities::deployment_config::StaticSiteConfig>,
+    /// Promotion into environments: approval gate and carried-over variables
+    pub promotion: Option<temps_entities::deployment_config::PromotionConfig>,
+    /// Persistent package manager and compiler caches for builds
+    pub build_cache: Option<temps_entities::deployment_config::BuildCacheConfig>,
+    /// Builder of the images: Dockerfile, Nixpacks or Cloud Native Buildpacks
+    pub builder: Option<temps_entities::deployment_config::BuilderConfig>,
+    /// Build stage shared with the other services of the repository
+    pub shared_build: Option<temps_entities::deployment_config::SharedBuildConfig>,
+    /// Build command run in place of the one the builder detects
+    pub build_command: Option<String>,
+    /// Command the containers start with in place of the image's
+    pub start_command: Option<String>,
+    /// Entrypoint the web containers run in place of the image's
+    pub entrypoint: Option<Vec<String>>,
+    /// Arguments the web containers start with in place of the image's command
+    pub args: Option<Vec<String>>,
+    /// Platforms to build the images for, e.g. `linux/amd64` and `linux/arm64`; the
+    /// control plane's platform when unset
+    pub build_platforms: Option<Vec<String>>,
+    /// Automatic retries of deploys that failed for a transient reason
+    pub auto_retry: Option<temps_entities::deployment_config::AutoRetryConfig>,
+    /// Log driver and local log rotation of the containers
+    pub container_logs: Option<temps_entities::deployment_config::ContainerLogsConfig>,
+    /// Timezone and locale of the containers (`TZ` and `LANG`)
+    pub locale_defaults: Option<temps_entities::deployment_config::LocaleDefaultsConfig>,
+    /// Probe new containers must pass before their regular health checks begin, for
+    /// apps that take long to boot
+    pub startup_probe: Option<temps_entities::deployment_config::StartupProbeConfig>,
+    /// How running containers pick up changed runtime config values
+    pub config_reload: Option<temps_entities::deployment_config::ConfigReloadConfig>,
+    /// Command run inside new containers whose exit code is their health, in place of
+    /// the HTTP health check
+    pub exec_health_check: Option<temps_entities::deployment_config::ExecHealthCheckConfig>,
+    /// Failed health checks in a row that mark the service unhealthy and restart it
+    pub health_thresholds: Option<temps_entities::deployment_config::HealthThresholdsConfig>,
+    /// HTTP checks run against new containers before they receive traffic
+    pub smoke_tests: Option<temps_entities::deployment_config::SmokeTestsConfig>,
+    /// Nameservers, search domains and `/etc/hosts` entries of the containers
+    pub container_dns: Option<temps_entities::deployment_config::ContainerDnsConfig>,
+    /// Ulimits and `/dev/shm` size of the containers
+    pub container_limits: Option<temps_entities::deployment_config::ContainerLimitsConfig>,
+    /// External check the new containers must pass before they receive traffic
+    pub deploy_gate: Option<temps_entities::deployment_config::DeployGateConfig>,
+    /// Cache purge of the CDN in front of the project's environments after successful
+    /// deploys
+    pub cdn_purge: Option<temps_entities::deployment_config::CdnPurgeConfig>,
+    /// Replicas and commands of the Procfile's process types, by name
+    pub processes: Option<
+        std::collections::BTreeMap<String, temps_entities::deployment_config::ProcessTypeConfig>,
+    >,
 }
 
 #[derive(Serialize, Deserialize, Clone, ToSchema)]
@@ -447,6 +775,12 @@ pub struct UpdateProjectSettingsRequest {
     pub attack_mode: Option<bool>,
     /// Enable automatic preview environment creation for each branch
     pub enable_preview_environments: Option<bool>,
+    /// Maximum environments built and deployed at once during a project-wide deploy
+    /// (0 resets to the server default)
+    #[schema(example = 2)]
+    pub deploy_concurrency: Option<i32>,
+    /// Replaces all tags of the project (an empty object removes them)
+    pub tags: Option<Tags>,
 }
 
 #[derive(Serialize, Deserialize, ToSchema)]
@@ -575,6 +909,18 @@ pub struct UpdateCustomDomainRequest {
     pub redirect_to: Option<String>,
     pub status_code: Option<i32>,
     pub branch: Option<String>,
+    /// "www_to_apex", "apex_to_www", or an empty string to remove the canonical redirect
+    #[schema(example = "apex_to_www")]
+    pub canonical_redirect: Option<String>,
+    pub force_https: Option<bool>,
+    /// Replaces the domain's path rules; an empty list serves all paths
+    pub path_rules: Option<Vec<PathRule>>,
+}
+
+#[derive(Serialize, ToSchema)]
+pub struct VerifyCustomDomainDnsResponse {
+    pub domain: CustomDomainResponse,
+    pub dns: DomainDnsCheck,
 }
 
 #[derive(Serialize, Deserialize, ToSchema)]
@@ -717,6 +1063,28 @@ impl From<ProjectError> for Problem {
     }
 }
 
+impl From<crate::services::deploy_idempotency::DeployIdempotencyError> for Problem {
+    fn from(error: crate::services::deploy_idempotency::DeployIdempotencyError) -> Self {
+        use crate::services::deploy_idempotency::DeployIdempotencyError;
+
+        match error {
+            DeployIdempotencyError::Database(e) => {
+                problemdetails::new(StatusCode::INTERNAL_SERVER_ERROR)
+                    .with_title("Database Error")
+                    .with_detail(e.to_string())
+            }
+            DeployIdempotencyError::InvalidKey(_) => problemdetails::new(StatusCode::BAD_REQUEST)
+                .with_title("Invalid Idempotency Key")
+                .with_detail(error.to_string()),
+            DeployIdempotencyError::KeyReused { .. } => {
+                problemdetails::new(StatusCode::UNPROCESSABLE_ENTITY)
+                    .with_title("Idempotency Key Reused")
+                    .with_detail(error.to_string())
+            }
+        }
+    }
+}
+
 // Custom Domain Error conversions
 impl From<crate::services::custom_domains::CustomDomainError> for Problem {
     fn from(error: crate::services::custom_domains::CustomDomainError) -> Self {
@@ -9,6 +9,8 @@ use utoipa::openapi::OpenApi;
 use utoipa::OpenApi as OpenApiTrait;
 
 use crate::services::custom_domains::CustomDomainService;
+use crate::services::deploy_idempotency::DeployIdempotencyService;
+use crate::services::domain_dns::DomainDnsVerifier;
 use crate::services::project::ProjectService;
 
 /// Projects Plugin for managing project lifecycle and configurations
@@ -50,15 +52,28 @@ impl TempsPlugin for ProjectsPlugin {
             let project_service = Arc::new(ProjectService::new(
                 db.clone(),
                 queue_service,
-                config_service,
+                config_service.clone(),
                 external_service_manager,
                 git_provider_manager,
                 environment_service,
+                context.require_service::<temps_core::EncryptionService>(),
             ));
             context.register_service(project_service);
 
+            let deploy_idempotency_service = Arc::new(DeployIdempotencyService::new(
+                db.clone(),
+                config_service.clone(),
+            ));
+            context.register_service(deploy_idempotency_service);
+
             // Create CustomDomainService
-            let custom_domain_service = Arc::new(CustomDomainService::new(db.clone()));
+            let dns_verifier = Arc::new(DomainDnsVerifier::new(config_service));
+            let mut custom_domain_service =
+                CustomDomainService::new(db.clone()).with_dns_verifier(dns_verifier);
+            if let Some(hostname_locks) = context.get_service::<temps_core::HostnameLocks>() {
+                custom_domain_service = custom_domain_service.with_hostname_locks(hostname_locks);
+            }
+            let custom_domain_service = Arc::new(custom_domain_service);
             context.register_service(custom_domain_service);
 
             tracing::debug!("Projects plugin services registered successfully");
@@ -69,10 +84,12 @@ impl TempsPlugin for ProjectsPlugin {
     fn configure_routes(&self, context: &PluginContext) -> Option<PluginRoutes> {
         let project_service = context.require_service::<ProjectService>();
         let custom_domain_service = context.require_service::<CustomDomainService>();
+        let deploy_idempotency_service = context.require_service::<DeployIdempotencyService>();
         let audit_service = context.require_service::<dyn temps_core::AuditLogger>();
         let app_state = Arc::new(crate::handlers::AppState {
             project_service,
             custom_domain_service,
+            deploy_idempotency_service,
             audit_service,
         });
         let routes = crate::handlers::configure_routes().with_state(app_state);
@@ -1,11 +1,18 @@
-use sea_orm::{ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, Set};
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, Set,
+    TransactionTrait,
+};
 use std::sync::Arc;
-use temps_core::url_validation;
-use temps_entities::project_custom_domains;
+use temps_core::{url_validation, HostnameLocks};
+use temps_entities::path_rules::PathRuleList;
+use temps_entities::project_custom_domains::{self, CanonicalRedirect};
+use temps_entities::{custom_routes, domains, environment_domains, environments};
 use thiserror::Error;
 use tracing::{debug, info};
 use url::Url;
 
+use super::domain_dns::{is_apex_domain, DomainDnsCheck, DomainDnsVerifier};
+
 #[derive(Error, Debug)]
 pub enum CustomDomainError {
     #[error("Database error: {0}")]
@@ -26,11 +33,28 @@ pub enum CustomDomainError {
 
 pub struct CustomDomainService {
     db: Arc<DatabaseConnection>,
+    dns_verifier: Option<Arc<DomainDnsVerifier>>,
+    hostname_locks: Arc<HostnameLocks>,
 }
 
 impl CustomDomainService {
     pub fn new(db: Arc<DatabaseConnection>) -> Self {
-        Self { db }
+        Self {
+            db,
+            dns_verifier: None,
+            hostname_locks: Arc::new(HostnameLocks::new()),
+        }
+    }
+
+    /// Share hostname locks with the other services that change routing
+    pub fn with_hostname_locks(mut self, hostname_locks: Arc<HostnameLocks>) -> Self {
+        self.hostname_locks = hostname_locks;
+        self
+    }
+
+    pub fn with_dns_verifier(mut self, dns_verifier: Arc<DomainDnsVerifier>) -> Self {
+        self.dns_verifier = Some(dns_verifier);
+        self
     }
 
     /// Normalizes a redirect URL by adding https:// scheme if missing
@@ -199,6 +223,7 @@ impl CustomDomainService {
             "Creating custom domain: {} for project: {}",
             domain, project_id
         );
+        let _hostname = self.hostname_locks.lock([&domain]).await;
 
         // Check if domain already exists
         if let Some(_existing) = project_custom_domains::Entity::find()
@@ -211,6 +236,7 @@ impl CustomDomainService {
                 domain
             )));
         }
+        self.ensure_host_unclaimed(&domain, environment_id).await?;
 
         // Validate and normalize redirect URL if provided
         let normalized_redirect = if let Some(ref redirect_url) = redirect_to {
@@ -235,7 +261,7 @@ impl CustomDomainService {
             branch: Set(branch),
             status: Set("pending".to_string()),
             message: Set(None),
-            certificate_id: Set(None),
+            certificate_id: Set(self.find_certificate_id(&domain).await?),
             ..Default::default()
         };
 
@@ -317,6 +343,14 @@ impl CustomDomainService {
             .ok_or_else(|| {
                 CustomDomainError::NotFound(format!("Custom domain with ID {} not found", id))
             })?;
+        let _hostnames = self
+            .hostname_locks
+            .lock(
+                [Some(&custom_domain.domain), domain.as_ref()]
+                    .into_iter()
+                    .flatten(),
+            )
+            .await;
 
         let mut active_model: project_custom_domains::ActiveModel = custom_domain.clone().into();
 
@@ -341,6 +375,13 @@ impl CustomDomainService {
             custom_domain.domain.as_str()
         };
 
+        if domain.is_some() || environment_id.is_some() {
+            self.ensure_host_unclaimed(
+                final_domain,
+                environment_id.unwrap_or(custom_domain.environment_id),
+            )
+            .await?;
+        }
         if let Some(env_id) = environment_id {
             active_model.environment_id = Set(env_id);
         }
@@ -432,9 +473,20 @@ impl CustomDomainService {
                 CustomDomainError::NotFound(format!("Custom domain with ID {} not found", id))
             })?;
 
+        // The domain only goes live once its DNS has been verified to point here
+        let dns_verified = custom_domain.dns_verified_at.is_some();
         let mut active_model: project_custom_domains::ActiveModel = custom_domain.into();
         active_model.certificate_id = Set(Some(certificate_id));
-        active_model.status = Set("active".to_string());
+        if dns_verified {
+            active_model.status = Set("active".to_string());
+            active_model.message = Set(None);
+        } else {
+            active_model.status = Set("pending".to_string());
+            active_model.message = Set(Some(
+                "Waiting for DNS to point to this platform; verify DNS to activate the domain"
+                    .to_string(),
+            ));
+        }
 
         let updated_domain = active_model.update(self.db.as_ref()).await?;
 
@@ -445,21 +497,306 @@ impl CustomDomainService {
         Ok(updated_domain)
     }
 
+    /// Check a custom domain's DNS and record the result
+    ///
+    /// When the domain points at the platform it is marked as DNS verified, and
+    /// activated if a certificate is already linked. Domains that are already active
+    /// are left active when the check fails, so a flaky lookup doesn't take them down.
+    pub async fn verify_dns(
+        &self,
+        id: i32,
+    ) -> Result<(project_custom_domains::Model, DomainDnsCheck), CustomDomainError> {
+        let dns_verifier = self.dns_verifier.as_ref().ok_or_else(|| {
+            CustomDomainError::Internal("DNS verification is not configured".to_string())
+        })?;
+
+        let custom_domain = project_custom_domains::Entity::find_by_id(id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| {
+                CustomDomainError::NotFound(format!("Custom domain with ID {} not found", id))
+            })?;
+
+        let check = dns_verifier.check(&custom_domain.domain).await?;
+        info!(
+            "DNS check for custom domain {}: points to platform = {}",
+            custom_domain.domain, check.points_to_platform
+        );
+
+        let has_certificate = custom_domain.certificate_id.is_some();
+        let is_active = custom_domain.status == "active";
+        let mut active_model: project_custom_domains::ActiveModel = custom_domain.into();
+        if check.points_to_platform {
+            active_model.dns_verified_at = Set(Some(chrono::Utc::now()));
+            if has_certificate {
+                active_model.status = Set("active".to_string());
+                active_model.message = Set(None);
+            } else {
+                active_model.message =
+                    Set(Some("DNS verified; waiting for a certificate".to_string()));
+            }
+        } else if !is_active {
+            active_model.message = Set(Some(check.message.clone()));
+        }
+
+        let updated_domain = active_model.update(self.db.as_ref()).await?;
+        Ok((updated_domain, check))
+    }
+
+    /// Parse a canonical redirect mode and check it can be used on `domain`
+    pub fn parse_canonical_redirect(
+        domain: &str,
+        mode: &str,
+    ) -> Result<CanonicalRedirect, CustomDomainError> {
+        let mode = CanonicalRedirect::parse(mode).ok_or_else(|| {
+            CustomDomainError::InvalidDomain(format!(
+                "Unknown canonical redirect '{}', expected 'www_to_apex' or 'apex_to_www'",
+                mode
+            ))
+        })?;
+        if !is_apex_domain(domain) {
+            return Err(CustomDomainError::InvalidDomain(format!(
+                "Canonical redirects are configured on the apex domain, and {} is not one",
+                domain
+            )));
+        }
+        Ok(mode)
+    }
+
+    /// Update how requests for a domain are redirected
+    ///
+    /// `canonical_redirect` is `www_to_apex` or `apex_to_www` and can only be set on an
+    /// apex domain; an empty string clears it. Setting one makes sure the `www` host is
+    /// attached to the same environment too, so both hosts get routed and certificates
+    /// are issued for each. `force_https` controls the HTTP→HTTPS redirect.
+    pub async fn update_redirect_settings(
+        &self,
+        id: i32,
+        canonical_redirect: Option<String>,
+        force_https: Option<bool>,
+    ) -> Result<project_custom_domains::Model, CustomDomainError> {
+        let custom_domain = project_custom_domains::Entity::find_by_id(id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| {
+                CustomDomainError::NotFound(format!("Custom domain with ID {} not found", id))
+            })?;
+
+        let mut active_model: project_custom_domains::ActiveModel = custom_domain.clone().into();
+
+        if let Some(mode) = canonical_redirect {
+            if mode.is_empty() {
+                active_model.canonical_redirect = Set(None);
+            } else {
+                let mode = Self::parse_canonical_redirect(&custom_domain.domain, &mode)?;
+                if custom_domain.redirect_to.is_some() {
+                    return Err(CustomDomainError::InvalidDomain(format!(
+                        "{} already redirects elsewhere; remove that redirect first",
+                        custom_domain.domain
+                    )));
+                }
+
+                self.ensure_www_domain(
+                    &custom_domain,
+                    force_https.unwrap_or(custom_domain.force_https),
+                )
+                .await?;
+                active_model.canonical_redirect = Set(Some(mode.as_str().to_string()));
+            }
+        }
+        if let Some(force_https) = force_https {
+            active_model.force_https = Set(force_https);
+        }
+
+        let updated_domain = active_model.update(self.db.as_ref()).await?;
+
+        debug!("Custom domain redirect settings updated: ID {}", id);
+        Ok(updated_domain)
+    }
+
+    /// Fail if the host already routes somewhere other than the environment, through a
+    /// custom route or another environment's domain
+    ///
+    /// Callers hold the host's lock, so nothing claims it between this check and
+    /// their write.
+    async fn ensure_host_unclaimed(
+        &self,
+        domain: &str,
+        environment_id: i32,
+    ) -> Result<(), CustomDomainError> {
+        if custom_routes::Entity::find()
+            .filter(custom_routes::Column::Domain.eq(domain))
+            .filter(custom_routes::Column::Enabled.eq(true))
+            .one(self.db.as_ref())
+            .await?
+            .is_some()
+        {
+            return Err(CustomDomainError::DuplicateDomain(format!(
+                "Domain {} is already routed by a custom route",
+                domain
+            )));
+        }
+        if environment_domains::Entity::find()
+            .filter(environment_domains::Column::Domain.eq(domain))
+            .filter(environment_domains::Column::EnvironmentId.ne(environment_id))
+            .one(self.db.as_ref())
+            .await?
+            .is_some()
+        {
+            return Err(CustomDomainError::DuplicateDomain(format!(
+                "Domain {} is already used by another environment",
+                domain
+            )));
+        }
+        Ok(())
+    }
+
+    /// Attach `www.<apex>` to the apex domain's environment if it isn't already
+    async fn ensure_www_domain(
+        &self,
+        apex: &project_custom_domains::Model,
+        force_https: bool,
+    ) -> Result<(), CustomDomainError> {
+        let www_domain = format!("www.{}", apex.domain.to_lowercase());
+        let _hostname = self.hostname_locks.lock([&www_domain]).await;
+
+        if let Some(existing) = self.get_custom_domain_by_domain(&www_domain).await? {
+            if existing.project_id != apex.project_id {
+                return Err(CustomDomainError::DuplicateDomain(format!(
+                    "Domain {} is attached to another project",
+                    www_domain
+                )));
+            }
+            return Ok(());
+        }
+        self.ensure_host_unclaimed(&www_domain, apex.environment_id)
+            .await?;
+
+        info!(
+            "Adding {} alongside {} for its canonical redirect",
+            www_domain, apex.domain
+        );
+        project_custom_domains::ActiveModel {
+            project_id: Set(apex.project_id),
+            environment_id: Set(apex.environment_id),
+            domain: Set(www_domain),
+            branch: Set(apex.branch.clone()),
+            status: Set("pending".to_string()),
+            force_https: Set(force_https),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+        Ok(())
+    }
+
+    /// Replace the path rules of a custom domain
+    pub async fn set_path_rules(
+        &self,
+        id: i32,
+        path_rules: PathRuleList,
+    ) -> Result<project_custom_domains::Model, CustomDomainError> {
+        path_rules
+            .validate()
+            .map_err(CustomDomainError::InvalidDomain)?;
+
+        let custom_domain = project_custom_domains::Entity::find_by_id(id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| {
+                CustomDomainError::NotFound(format!("Custom domain with ID {} not found", id))
+            })?;
+
+        // Rules may only send requests to live environments of the domain's own project
+        let target_environments = path_rules.target_environments();
+        if !target_environments.is_empty() {
+            let found = environments::Entity::find()
+                .filter(environments::Column::Id.is_in(target_environments.clone()))
+                .filter(environments::Column::ProjectId.eq(custom_domain.project_id))
+                .filter(environments::Column::DeletedAt.is_null())
+                .all(self.db.as_ref())
+                .await?;
+            if let Some(missing) = target_environments
+                .iter()
+                .find(|id| !found.iter().any(|env| env.id == **id))
+            {
+                return Err(CustomDomainError::InvalidDomain(format!(
+                    "Environment {} does not exist in this project",
+                    missing
+                )));
+            }
+        }
+
+        info!(
+            "Setting {} path rules on custom domain {}",
+            path_rules.rules.len(),
+            custom_domain.domain
+        );
+        let mut active_model: project_custom_domains::ActiveModel = custom_domain.into();
+        active_model.path_rules = Set(path_rules);
+        Ok(active_model.update(self.db.as_ref()).await?)
+    }
+
+    /// Find a certificate covering a domain, preferring an exact match over a wildcard
+    async fn find_certificate_id(&self, domain: &str) -> Result<Option<i32>, CustomDomainError> {
+        let domain = domain.to_lowercase();
+        let mut names = vec![domain.clone()];
+        if let Some((_, parent)) = domain.split_once('.') {
+            if parent.contains('.') {
+                names.push(format!("*.{}", parent));
+            }
+        }
+
+        let certificates = domains::Entity::find()
+            .filter(domains::Column::Domain.is_in(names))
+            .all(self.db.as_ref())
+            .await?;
+        Ok(certificates
+            .iter()
+            .find(|cert| cert.domain == domain)
+            .or_else(|| certificates.first())
+            .map(|cert| cert.id))
+    }
+
     /// Delete custom domain
+    ///
+    /// Other domains of the environment keep routing. Removing the www half of an
+    /// apex/www pair also drops the apex's canonical redirect, so it doesn't send
+    /// visitors to a host that no longer routes anywhere.
     pub async fn delete_custom_domain(&self, id: i32) -> Result<(), CustomDomainError> {
         info!("Deleting custom domain ID: {}", id);
 
-        let result = project_custom_domains::Entity::delete_by_id(id)
-            .exec(self.db.as_ref())
-            .await?;
+        let custom_domain = project_custom_domains::Entity::find_by_id(id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| {
+                CustomDomainError::NotFound(format!("Custom domain with ID {} not found", id))
+            })?;
 
-        if result.rows_affected == 0 {
-            return Err(CustomDomainError::NotFound(format!(
-                "Custom domain with ID {} not found",
-                id
-            )));
+        let txn = self.db.begin().await?;
+        if let Some(apex) = custom_domain.domain.to_lowercase().strip_prefix("www.") {
+            if let Some(apex_domain) = project_custom_domains::Entity::find()
+                .filter(project_custom_domains::Column::Domain.eq(apex))
+                .filter(project_custom_domains::Column::ProjectId.eq(custom_domain.project_id))
+                .filter(project_custom_domains::Column::CanonicalRedirect.is_not_null())
+                .one(&txn)
+                .await?
+            {
+                info!(
+                    "Removing canonical redirect from {} along with {}",
+                    apex_domain.domain, custom_domain.domain
+                );
+                let mut active_model: project_custom_domains::ActiveModel = apex_domain.into();
+                active_model.canonical_redirect = Set(None);
+                active_model.update(&txn).await?;
+            }
         }
 
+        project_custom_domains::Entity::delete_by_id(id)
+            .exec(&txn)
+            .await?;
+        txn.commit().await?;
+
         debug!("Custom domain deleted successfully: ID {}", id);
         Ok(())
     }
@@ -1383,4 +1720,236 @@ mod tests {
             e => panic!("Expected CircularRedirect error, got: {:?}", e),
         }
     }
+
+    #[tokio::test]
+    async fn test_canonical_redirect_adds_www_domain() {
+        let test_db = temps_database::test_utils::TestDatabase::with_migrations()
+            .await
+            .unwrap();
+        let service = CustomDomainService::new(test_db.db.clone());
+        let (project_id, env_id) = setup_test_data(&test_db.db).await;
+
+        let apex = service
+            .create_custom_domain(
+                project_id,
+                env_id,
+                "canonical.com".to_string(),
+                None,
+                None,
+                None,
+            )
+            .await
+            .unwrap();
+        assert!(apex.force_https);
+
+        let updated = service
+            .update_redirect_settings(apex.id, Some("apex_to_www".to_string()), Some(false))
+            .await
+            .unwrap();
+        assert_eq!(
+            updated.canonical_redirect(),
+            Some(CanonicalRedirect::ApexToWww)
+        );
+        assert!(!updated.force_https);
+
+        let www = service
+            .get_custom_domain_by_domain("www.canonical.com")
+            .await
+            .unwrap()
+            .expect("www domain should be attached");
+        assert_eq!(www.environment_id, env_id);
+        assert_eq!(www.status, "pending");
+
+        // Clearing keeps the www domain attached
+        let cleared = service
+            .update_redirect_settings(apex.id, Some("".to_string()), None)
+            .await
+            .unwrap();
+        assert_eq!(cleared.canonical_redirect, None);
+    }
+
+    #[tokio::test]
+    async fn test_canonical_redirect_requires_apex_domain() {
+        let test_db = temps_database::test_utils::TestDatabase::with_migrations()
+            .await
+            .unwrap();
+        let service = CustomDomainService::new(test_db.db.clone());
+        let (project_id, env_id) = setup_test_data(&test_db.db).await;
+
+        let domain = service
+            .create_custom_domain(
+                project_id,
+                env_id,
+                "app.canonical.com".to_string(),
+                None,
+                None,
+                None,
+            )
+            .await
+            .unwrap();
+
+        let result = service
+            .update_redirect_settings(domain.id, Some("www_to_apex".to_string()), None)
+            .await;
+        assert!(matches!(result, Err(CustomDomainError::InvalidDomain(_))));
+    }
+
+    #[tokio::test]
+    async fn test_link_certificate_waits_for_dns() {
+        let test_db = temps_database::test_utils::TestDatabase::with_migrations()
+            .await
+            .unwrap();
+        let service = CustomDomainService::new(test_db.db.clone());
+        let (project_id, env_id) = setup_test_data(&test_db.db).await;
+
+        let domain = service
+            .create_custom_domain(
+                project_id,
+                env_id,
+                "unverified.com".to_string(),
+                None,
+                None,
+                None,
+            )
+            .await
+            .unwrap();
+        let certificate = temps_entities::domains::ActiveModel {
+            domain: Set("unverified.com".to_string()),
+            status: Set("active".to_string()),
+            is_wildcard: Set(false),
+            verification_method: Set("http-01".to_string()),
+            ..Default::default()
+        }
+        .insert(test_db.db.as_ref())
+        .await
+        .unwrap();
+
+        let linked = service
+            .link_certificate(domain.id, certificate.id)
+            .await
+            .unwrap();
+        assert_eq!(linked.certificate_id, Some(certificate.id));
+        assert_eq!(linked.status, "pending");
+    }
+
+    #[tokio::test]
+    async fn test_multiple_domains_per_environment() {
+        let test_db = temps_database::test_utils::TestDatabase::with_migrations()
+            .await
+            .unwrap();
+        let service = CustomDomainService::new(test_db.db.clone());
+        let (project_id, env_id) = setup_test_data(&test_db.db).await;
+
+        let wildcard = temps_entities::domains::ActiveModel {
+            domain: Set("*.multi.com".to_string()),
+            status: Set("active".to_string()),
+            is_wildcard: Set(true),
+            verification_method: Set("dns-01".to_string()),
+            ..Default::default()
+        }
+        .insert(test_db.db.as_ref())
+        .await
+        .unwrap();
+
+        let apex = service
+            .create_custom_domain(
+                project_id,
+                env_id,
+                "multi.com".to_string(),
+                None,
+                None,
+                None,
+            )
+            .await
+            .unwrap();
+        let docs = service
+            .create_custom_domain(
+                project_id,
+                env_id,
+                "docs.multi.com".to_string(),
+                None,
+                None,
+                None,
+            )
+            .await
+            .unwrap();
+        // The wildcard certificate covers the subdomain but not the apex
+        assert_eq!(docs.certificate_id, Some(wildcard.id));
+        assert_eq!(apex.certificate_id, None);
+
+        let docs = service
+            .set_path_rules(
+                docs.id,
+                PathRuleList {
+                    rules: vec![temps_entities::path_rules::PathRule {
+                        path_prefix: "/".to_string(),
+                        rewrite_to: Some("/docs".to_string()),
+                        strip_prefix: false,
+                        environment_id: None,
+                    }],
+                },
+            )
+            .await
+            .unwrap();
+        assert_eq!(
+            docs.path_rules.resolve("/intro"),
+            Some("/docs/intro".to_string())
+        );
+
+        service
+            .update_redirect_settings(apex.id, Some("www_to_apex".to_string()), None)
+            .await
+            .unwrap();
+        let www = service
+            .get_custom_domain_by_domain("www.multi.com")
+            .await
+            .unwrap()
+            .unwrap();
+
+        // Removing www drops the apex's canonical redirect and leaves the others alone
+        service.delete_custom_domain(www.id).await.unwrap();
+        let apex = service.get_custom_domain(apex.id).await.unwrap().unwrap();
+        assert_eq!(apex.canonical_redirect, None);
+        let domains = service
+            .list_custom_domains_for_environment(env_id)
+            .await
+            .unwrap();
+        assert_eq!(domains.len(), 2);
+    }
+
+    #[tokio::test]
+    async fn test_invalid_path_rules_are_rejected() {
+        let test_db = temps_database::test_utils::TestDatabase::with_migrations()
+            .await
+            .unwrap();
+        let service = CustomDomainService::new(test_db.db.clone());
+        let (project_id, env_id) = setup_test_data(&test_db.db).await;
+
+        let domain = service
+            .create_custom_domain(
+                project_id,
+                env_id,
+                "rules.com".to_string(),
+                None,
+                None,
+                None,
+            )
+            .await
+            .unwrap();
+
+        let result = service
+            .set_path_rules(
+                domain.id,
+                PathRuleList {
+                    rules: vec![temps_entities::path_rules::PathRule {
+                        path_prefix: "docs".to_string(),
+                        rewrite_to: None,
+                        strip_prefix: false,
+                        environment_id: None,
+                    }],
+                },
+            )
+            .await;
+        assert!(matches!(result, Err(CustomDomainError::InvalidDomain(_))));
+    }
 }
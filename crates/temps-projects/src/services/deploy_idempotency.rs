@@ -0,0 +1,332 @@
+//! Idempotency Keys for Deploy Triggers
+//!
+//! CI systems and webhook senders deliver at least once: a trigger whose response got
+//! lost is sent again. A trigger sent with an `Idempotency-Key` header claims the key
+//! for its project before the deploy is queued; the same key sent again within the
+//! dedup window gets the first trigger's deploy back instead of starting another one.
+//! Once the window has passed the key can be claimed again.
+
+use std::sync::Arc;
+
+use chrono::Utc;
+use sea_orm::sea_query::OnConflict;
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, DatabaseConnection, DbErr, EntityTrait, QueryFilter, QueryOrder,
+    Set,
+};
+use temps_entities::{deploy_idempotency_keys, deployments};
+use thiserror::Error;
+use tracing::{info, warn};
+
+/// Header deploy triggers carry their idempotency key in
+pub const IDEMPOTENCY_KEY_HEADER: &str = "Idempotency-Key";
+
+/// Longest idempotency key accepted
+pub const MAX_IDEMPOTENCY_KEY_LENGTH: usize = 255;
+
+#[derive(Error, Debug)]
+pub enum DeployIdempotencyError {
+    #[error("Database error: {0}")]
+    Database(#[from] DbErr),
+    #[error("Invalid idempotency key: {0}")]
+    InvalidKey(String),
+    #[error("Idempotency key '{key}' was already used to deploy environment {environment_id}")]
+    KeyReused { key: String, environment_id: i32 },
+}
+
+/// Outcome of claiming an idempotency key for a trigger
+#[derive(Debug)]
+pub enum IdempotencyClaim {
+    /// First use of the key within the window: trigger the deploy, then record or
+    /// release the claim
+    Claimed(deploy_idempotency_keys::Model),
+    /// The key was already used within the window
+    Duplicate(DeduplicatedTrigger),
+}
+
+/// The trigger a repeated request deduplicates to
+#[derive(Debug)]
+pub struct DeduplicatedTrigger {
+    pub trigger: deploy_idempotency_keys::Model,
+    /// Deployment the first trigger started; None while it is still queued
+    pub deployment_id: Option<i32>,
+}
+
+pub struct DeployIdempotencyService {
+    db: Arc<DatabaseConnection>,
+    config_service: Arc<temps_config::ConfigService>,
+}
+
+impl DeployIdempotencyService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        config_service: Arc<temps_config::ConfigService>,
+    ) -> Self {
+        Self { db, config_service }
+    }
+
+    /// Check an idempotency key: printable ASCII, at most 255 characters
+    pub fn validate_key(key: &str) -> Result<String, DeployIdempotencyError> {
+        let key = key.trim();
+        if key.is_empty() {
+            return Err(DeployIdempotencyError::InvalidKey(
+                "the key is empty".to_string(),
+            ));
+        }
+        if key.len() > MAX_IDEMPOTENCY_KEY_LENGTH {
+            return Err(DeployIdempotencyError::InvalidKey(format!(
+                "the key is longer than {} characters",
+                MAX_IDEMPOTENCY_KEY_LENGTH
+            )));
+        }
+        if !key.chars().all(|c| c.is_ascii_graphic()) {
+            return Err(DeployIdempotencyError::InvalidKey(
+                "the key may only contain printable ASCII characters".to_string(),
+            ));
+        }
+        Ok(key.to_string())
+    }
+
+    /// Claim a key for a trigger of the environment, or find the trigger that did
+    ///
+    /// A key claimed for another environment of the project is an error rather than a
+    /// duplicate: the request is not a retry of the first one.
+    pub async fn claim(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+        key: &str,
+    ) -> Result<IdempotencyClaim, DeployIdempotencyError> {
+        let window = self
+            .config_service
+            .get_settings()
+            .await
+            .unwrap_or_default()
+            .deploy_idempotency
+            .window();
+        let now = Utc::now();
+
+        // A key past its window is free to claim again
+        deploy_idempotency_keys::Entity::delete_many()
+            .filter(deploy_idempotency_keys::Column::ProjectId.eq(project_id))
+            .filter(deploy_idempotency_keys::Column::IdempotencyKey.eq(key))
+            .filter(deploy_idempotency_keys::Column::CreatedAt.lt(now - window))
+            .exec(self.db.as_ref())
+            .await?;
+
+        let claim = deploy_idempotency_keys::ActiveModel {
+            project_id: Set(project_id),
+            idempotency_key: Set(key.to_string()),
+            environment_id: Set(environment_id),
+            dry_run: Set(false),
+            created_at: Set(now),
+            ..Default::default()
+        };
+        // Concurrent retries race on the unique index; only one of them inserts
+        let inserted = deploy_idempotency_keys::Entity::insert(claim)
+            .on_conflict(
+                OnConflict::columns([
+                    deploy_idempotency_keys::Column::ProjectId,
+                    deploy_idempotency_keys::Column::IdempotencyKey,
+                ])
+                .do_nothing()
+                .to_owned(),
+            )
+            .exec(self.db.as_ref())
+            .await;
+
+        match inserted {
+            Ok(result) => {
+                let claimed = deploy_idempotency_keys::Entity::find_by_id(result.last_insert_id)
+                    .one(self.db.as_ref())
+                    .await?
+                    .ok_or_else(|| {
+                        DbErr::RecordNotFound(format!(
+                            "Idempotency key {} of project {}",
+                            key, project_id
+                        ))
+                    })?;
+                Ok(IdempotencyClaim::Claimed(claimed))
+            }
+            Err(DbErr::RecordNotInserted) => {
+                let trigger = deploy_idempotency_keys::Entity::find()
+                    .filter(deploy_idempotency_keys::Column::ProjectId.eq(project_id))
+                    .filter(deploy_idempotency_keys::Column::IdempotencyKey.eq(key))
+                    .one(self.db.as_ref())
+                    .await?
+                    .ok_or_else(|| {
+                        DbErr::RecordNotFound(format!(
+                            "Idempotency key {} of project {}",
+                            key, project_id
+                        ))
+                    })?;
+
+                if trigger.environment_id != environment_id {
+                    return Err(DeployIdempotencyError::KeyReused {
+                        key: key.to_string(),
+                        environment_id: trigger.environment_id,
+                    });
+                }
+
+                let deployment_id = self.find_deployment(&trigger).await?;
+                info!(
+                    "Deploy trigger for project {} deduplicated by idempotency key {}",
+                    project_id, key
+                );
+                Ok(IdempotencyClaim::Duplicate(DeduplicatedTrigger {
+                    trigger,
+                    deployment_id,
+                }))
+            }
+            Err(e) => Err(e.into()),
+        }
+    }
+
+    /// Record what a claimed trigger queued, for the repeated requests to return
+    pub async fn record(
+        &self,
+        claim: deploy_idempotency_keys::Model,
+        branch: Option<String>,
+        tag: Option<String>,
+        commit: Option<String>,
+        dry_run: bool,
+    ) -> Result<(), DeployIdempotencyError> {
+        let mut active: deploy_idempotency_keys::ActiveModel = claim.into();
+        active.branch = Set(branch);
+        active.tag = Set(tag);
+        active.commit = Set(commit);
+        active.dry_run = Set(dry_run);
+        active.update(self.db.as_ref()).await?;
+        Ok(())
+    }
+
+    /// Give up a claim whose trigger failed, so a retry can trigger the deploy
+    pub async fn release(&self, claim: deploy_idempotency_keys::Model) {
+        if let Err(e) = deploy_idempotency_keys::Entity::delete_by_id(claim.id)
+            .exec(self.db.as_ref())
+            .await
+        {
+            warn!(
+                "Failed to release idempotency key {} of project {}: {}",
+                claim.idempotency_key, claim.project_id, e
+            );
+        }
+    }
+
+    /// Deployment a trigger started: the first one of its commit to the environment
+    /// since the trigger
+    async fn find_deployment(
+        &self,
+        trigger: &deploy_idempotency_keys::Model,
+    ) -> Result<Option<i32>, DbErr> {
+        let Some(commit) = trigger.commit.as_ref() else {
+            return Ok(None);
+        };
+
+        let deployment = deployments::Entity::find()
+            .filter(deployments::Column::ProjectId.eq(trigger.project_id))
+            .filter(deployments::Column::EnvironmentId.eq(trigger.environment_id))
+            .filter(deployments::Column::CommitSha.eq(commit.as_str()))
+            .filter(deployments::Column::CreatedAt.gte(trigger.created_at))
+            .order_by_asc(deployments::Column::Id)
+            .one(self.db.as_ref())
+            .await?;
+
+        Ok(deployment.map(|deployment| deployment.id))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use temps_database::test_utils::TestDatabase;
+    use temps_entities::preset::Preset;
+
+    fn config_service(db: Arc<DatabaseConnection>) -> Arc<temps_config::ConfigService> {
+        let server_config = Arc::new(
+            temps_config::ServerConfig::new(
+                "127.0.0.1:3000".to_string(),
+                "postgresql://test".to_string(),
+                None,
+                None,
+            )
+            .unwrap(),
+        );
+        Arc::new(temps_config::ConfigService::new(server_config, db))
+    }
+
+    #[test]
+    fn test_validate_key() {
+        assert_eq!(
+            DeployIdempotencyService::validate_key(" ci-run-4182 ").unwrap(),
+            "ci-run-4182"
+        );
+        assert!(DeployIdempotencyService::validate_key("  ").is_err());
+        assert!(DeployIdempotencyService::validate_key("run 4182").is_err());
+        assert!(DeployIdempotencyService::validate_key(&"k".repeat(256)).is_err());
+    }
+
+    #[tokio::test]
+    async fn test_repeated_key_returns_the_first_trigger() {
+        let test_db = TestDatabase::with_migrations().await.unwrap();
+        let db = test_db.db.clone();
+        let service = DeployIdempotencyService::new(db.clone(), config_service(db.clone()));
+
+        let project = temps_entities::projects::ActiveModel {
+            name: Set("Test Project".to_string()),
+            slug: Set("test-project".to_string()),
+            repo_name: Set("test-repo".to_string()),
+            repo_owner: Set("test-owner".to_string()),
+            directory: Set("/".to_string()),
+            main_branch: Set("main".to_string()),
+            preset: Set(Preset::Nixpacks),
+            ..Default::default()
+        }
+        .insert(db.as_ref())
+        .await
+        .unwrap();
+
+        let claim = match service.claim(project.id, 7, "ci-run-1").await.unwrap() {
+            IdempotencyClaim::Claimed(claim) => claim,
+            IdempotencyClaim::Duplicate(_) => panic!("first use of the key is a claim"),
+        };
+        service
+            .record(
+                claim,
+                Some("main".to_string()),
+                None,
+                Some("abc123".to_string()),
+                false,
+            )
+            .await
+            .unwrap();
+
+        match service.claim(project.id, 7, "ci-run-1").await.unwrap() {
+            IdempotencyClaim::Duplicate(duplicate) => {
+                assert_eq!(duplicate.trigger.commit.as_deref(), Some("abc123"));
+                assert_eq!(duplicate.deployment_id, None);
+            }
+            IdempotencyClaim::Claimed(_) => panic!("repeated key must be deduplicated"),
+        }
+
+        // The same key for another environment isn't a retry
+        assert!(matches!(
+            service.claim(project.id, 8, "ci-run-1").await,
+            Err(DeployIdempotencyError::KeyReused {
+                environment_id: 7,
+                ..
+            })
+        ));
+
+        // A released claim lets the retry through
+        let other = match service.claim(project.id, 7, "ci-run-2").await.unwrap() {
+            IdempotencyClaim::Claimed(claim) => claim,
+            IdempotencyClaim::Duplicate(_) => panic!("first use of the key is a claim"),
+        };
+        service.release(other).await;
+        assert!(matches!(
+            service.claim(project.id, 7, "ci-run-2").await.unwrap(),
+            IdempotencyClaim::Claimed(_)
+        ));
+    }
+}
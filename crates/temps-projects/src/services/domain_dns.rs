@@ -0,0 +1,354 @@
+//! DNS checks for custom domains
+//!
+//! Works out which records a custom domain needs so it reaches this platform, looks up
+//! what is actually published, and reports whether the domain already points here.
+//! Apex domains (`example.com`) cannot hold a CNAME, so they need A records, or an
+//! ALIAS/ANAME record at DNS providers that support one; subdomains use a CNAME.
+
+use hickory_resolver::config::{ResolverConfig, ResolverOpts};
+use hickory_resolver::name_server::TokioConnectionProvider;
+use hickory_resolver::proto::rr::RecordType;
+use hickory_resolver::Resolver;
+use serde::Serialize;
+use std::net::IpAddr;
+use std::sync::Arc;
+use tracing::{debug, warn};
+use url::Url;
+use utoipa::ToSchema;
+
+use super::custom_domains::CustomDomainError;
+
+type TokioResolver = Resolver<TokioConnectionProvider>;
+
+/// Second-level suffixes under which the registrable domain has three labels
+const MULTI_LABEL_SUFFIXES: &[&str] = &[
+    "co.uk", "org.uk", "ac.uk", "gov.uk", "me.uk", "com.au", "net.au", "org.au", "co.nz", "org.nz",
+    "co.jp", "co.za", "co.in", "com.br", "com.mx", "com.ar", "com.cn", "com.tr", "com.sg",
+    "com.hk",
+];
+
+/// A DNS record, either one the domain needs or one that was found
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, ToSchema)]
+pub struct DnsRecord {
+    /// Record type (A, CNAME, ALIAS)
+    #[schema(example = "A")]
+    pub record_type: String,
+    /// Record name
+    #[schema(example = "example.com")]
+    pub name: String,
+    /// Record value
+    #[schema(example = "203.0.113.10")]
+    pub value: String,
+}
+
+impl DnsRecord {
+    fn new(record_type: &str, name: &str, value: &str) -> Self {
+        Self {
+            record_type: record_type.to_string(),
+            name: name.to_string(),
+            value: value.to_string(),
+        }
+    }
+}
+
+/// Result of checking a custom domain's DNS records
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct DomainDnsCheck {
+    pub domain: String,
+    /// Whether the domain is an apex (root) domain
+    pub is_apex: bool,
+    /// Records to create so the domain points at this platform
+    pub required: Vec<DnsRecord>,
+    /// Records that can be used instead of the required ones, if the DNS provider supports them
+    pub alternatives: Vec<DnsRecord>,
+    /// Records currently published for the domain
+    pub detected: Vec<DnsRecord>,
+    /// Whether the domain currently resolves to this platform
+    pub points_to_platform: bool,
+    /// Human readable summary of the check
+    pub message: String,
+}
+
+/// Where custom domains need to point: the host of the platform's external URL
+#[derive(Debug, Clone, Default)]
+pub struct PlatformTarget {
+    /// Hostname to CNAME/ALIAS to, when the external URL uses a name rather than an IP
+    pub hostname: Option<String>,
+    /// IPv4 addresses the platform is reachable at
+    pub ips: Vec<String>,
+}
+
+/// Whether a domain is an apex (root) domain rather than a subdomain
+pub fn is_apex_domain(domain: &str) -> bool {
+    let domain = domain.trim_end_matches('.').to_lowercase();
+    let labels: Vec<&str> = domain.split('.').filter(|l| !l.is_empty()).collect();
+    match labels.len() {
+        0 | 1 => false,
+        2 => !MULTI_LABEL_SUFFIXES.contains(&domain.as_str()),
+        3 => MULTI_LABEL_SUFFIXES.contains(&labels[1..].join(".").as_str()),
+        _ => false,
+    }
+}
+
+/// Records a domain needs to point at the platform, and the alternatives to them
+pub fn required_records(domain: &str, target: &PlatformTarget) -> (Vec<DnsRecord>, Vec<DnsRecord>) {
+    let a_records: Vec<DnsRecord> = target
+        .ips
+        .iter()
+        .map(|ip| DnsRecord::new("A", domain, ip))
+        .collect();
+
+    match &target.hostname {
+        Some(hostname) if is_apex_domain(domain) => {
+            (a_records, vec![DnsRecord::new("ALIAS", domain, hostname)])
+        }
+        Some(hostname) => (vec![DnsRecord::new("CNAME", domain, hostname)], a_records),
+        None => (a_records, Vec::new()),
+    }
+}
+
+/// Whether the detected records point the domain at the platform
+///
+/// A CNAME/ALIAS to the platform hostname counts, as do A records, which the resolver
+/// follows through CNAMEs, that match one of the platform's addresses.
+pub fn points_to_platform(target: &PlatformTarget, detected: &[DnsRecord]) -> bool {
+    detected
+        .iter()
+        .any(|record| match record.record_type.as_str() {
+            "A" => target.ips.contains(&record.value),
+            "CNAME" => target
+                .hostname
+                .as_deref()
+                .is_some_and(|hostname| record.value.eq_ignore_ascii_case(hostname)),
+            _ => false,
+        })
+}
+
+/// Checks custom domain DNS against the platform's external URL
+pub struct DomainDnsVerifier {
+    config_service: Arc<temps_config::ConfigService>,
+    resolver: TokioResolver,
+}
+
+impl DomainDnsVerifier {
+    pub fn new(config_service: Arc<temps_config::ConfigService>) -> Self {
+        let mut options = ResolverOpts::default();
+        options.cache_size = 0; // Always check the live records
+
+        let resolver = Resolver::builder_with_config(
+            ResolverConfig::cloudflare(),
+            TokioConnectionProvider::default(),
+        )
+        .with_options(options)
+        .build();
+
+        Self {
+            config_service,
+            resolver,
+        }
+    }
+
+    async fn platform_target(&self) -> Result<Option<PlatformTarget>, CustomDomainError> {
+        let external_url = self
+            .config_service
+            .get_external_url()
+            .await
+            .map_err(|e| CustomDomainError::Internal(e.to_string()))?;
+        let Some(external_url) = external_url else {
+            return Ok(None);
+        };
+
+        let host = Url::parse(&external_url)
+            .ok()
+            .and_then(|url| url.host_str().map(|h| h.to_string()))
+            .ok_or_else(|| {
+                CustomDomainError::Internal(format!("External URL '{}' has no host", external_url))
+            })?;
+        let host = host
+            .trim_start_matches('[')
+            .trim_end_matches(']')
+            .to_string();
+
+        if host.parse::<IpAddr>().is_ok() {
+            return Ok(Some(PlatformTarget {
+                hostname: None,
+                ips: vec![host],
+            }));
+        }
+
+        let ips = self.lookup_a(&host).await;
+        if ips.is_empty() {
+            warn!(
+                "Platform host {} does not resolve to any IPv4 address",
+                host
+            );
+        }
+        Ok(Some(PlatformTarget {
+            hostname: Some(host),
+            ips,
+        }))
+    }
+
+    async fn lookup_a(&self, name: &str) -> Vec<String> {
+        match self.resolver.ipv4_lookup(name).await {
+            Ok(lookup) => lookup.iter().map(|ip| ip.to_string()).collect(),
+            Err(e) => {
+                debug!("A lookup failed for {}: {}", name, e);
+                Vec::new()
+            }
+        }
+    }
+
+    async fn detect_records(&self, domain: &str) -> Vec<DnsRecord> {
+        let mut detected = Vec::new();
+
+        match self.resolver.lookup(domain, RecordType::CNAME).await {
+            Ok(lookup) => {
+                for record in lookup.iter() {
+                    if let Some(cname) = record.as_cname() {
+                        let target = cname.to_string();
+                        detected.push(DnsRecord::new(
+                            "CNAME",
+                            domain,
+                            target.trim_end_matches('.'),
+                        ));
+                    }
+                }
+            }
+            Err(e) => debug!("CNAME lookup failed for {}: {}", domain, e),
+        }
+
+        for ip in self.lookup_a(domain).await {
+            detected.push(DnsRecord::new("A", domain, &ip));
+        }
+
+        detected
+    }
+
+    /// Check whether a domain's published records point at the platform
+    pub async fn check(&self, domain: &str) -> Result<DomainDnsCheck, CustomDomainError> {
+        let is_apex = is_apex_domain(domain);
+        let detected = self.detect_records(domain).await;
+
+        let Some(target) = self.platform_target().await? else {
+            return Ok(DomainDnsCheck {
+                domain: domain.to_string(),
+                is_apex,
+                required: Vec::new(),
+                alternatives: Vec::new(),
+                detected,
+                points_to_platform: false,
+                message: "Set the external URL in the platform settings so the required \
+                          DNS records can be determined"
+                    .to_string(),
+            });
+        };
+
+        let (required, alternatives) = required_records(domain, &target);
+        let points_to_platform = points_to_platform(&target, &detected);
+        let message = if points_to_platform {
+            format!("{} points to this platform", domain)
+        } else if detected.is_empty() {
+            format!("No A or CNAME records found for {}", domain)
+        } else {
+            format!(
+                "{} does not point to this platform yet; DNS changes can take a while to propagate",
+                domain
+            )
+        };
+
+        Ok(DomainDnsCheck {
+            domain: domain.to_string(),
+            is_apex,
+            required,
+            alternatives,
+            detected,
+            points_to_platform,
+            message,
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn target() -> PlatformTarget {
+        PlatformTarget {
+            hostname: Some("edge.temps.example".to_string()),
+            ips: vec!["203.0.113.10".to_string()],
+        }
+    }
+
+    #[test]
+    fn test_is_apex_domain() {
+        assert!(is_apex_domain("example.com"));
+        assert!(is_apex_domain("Example.COM."));
+        assert!(is_apex_domain("example.co.uk"));
+        assert!(!is_apex_domain("www.example.com"));
+        assert!(!is_apex_domain("app.example.co.uk"));
+        assert!(!is_apex_domain("co.uk"));
+        assert!(!is_apex_domain("localhost"));
+    }
+
+    #[test]
+    fn test_apex_requires_a_records_with_alias_alternative() {
+        let (required, alternatives) = required_records("example.com", &target());
+        assert_eq!(
+            required,
+            vec![DnsRecord::new("A", "example.com", "203.0.113.10")]
+        );
+        assert_eq!(
+            alternatives,
+            vec![DnsRecord::new("ALIAS", "example.com", "edge.temps.example")]
+        );
+    }
+
+    #[test]
+    fn test_subdomain_requires_cname() {
+        let (required, alternatives) = required_records("www.example.com", &target());
+        assert_eq!(
+            required,
+            vec![DnsRecord::new(
+                "CNAME",
+                "www.example.com",
+                "edge.temps.example"
+            )]
+        );
+        assert_eq!(alternatives.len(), 1);
+        assert_eq!(alternatives[0].record_type, "A");
+    }
+
+    #[test]
+    fn test_ip_only_platform_requires_a_records() {
+        let target = PlatformTarget {
+            hostname: None,
+            ips: vec!["203.0.113.10".to_string()],
+        };
+        let (required, alternatives) = required_records("www.example.com", &target);
+        assert_eq!(required[0].record_type, "A");
+        assert!(alternatives.is_empty());
+    }
+
+    #[test]
+    fn test_points_to_platform() {
+        let target = target();
+        assert!(points_to_platform(
+            &target,
+            &[DnsRecord::new("A", "example.com", "203.0.113.10")]
+        ));
+        assert!(points_to_platform(
+            &target,
+            &[DnsRecord::new(
+                "CNAME",
+                "www.example.com",
+                "Edge.Temps.Example"
+            )]
+        ));
+        assert!(!points_to_platform(
+            &target,
+            &[DnsRecord::new("A", "example.com", "198.51.100.7")]
+        ));
+        assert!(!points_to_platform(&target, &[]));
+    }
+}
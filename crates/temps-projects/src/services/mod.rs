@@ -1,9 +1,15 @@
 pub mod custom_domains;
+pub mod deploy_idempotency;
+pub mod domain_dns;
 pub mod env_vars;
 pub mod project;
 pub mod types;
 
 pub use custom_domains::{CustomDomainError, CustomDomainService};
+pub use deploy_idempotency::{
+    DeployIdempotencyError, DeployIdempotencyService, IdempotencyClaim, IDEMPOTENCY_KEY_HEADER,
+};
+pub use domain_dns::{DnsRecord, DomainDnsCheck, DomainDnsVerifier};
 pub use env_vars::{EnvVarError, EnvVarService};
 pub use project::*;
 pub use types::{EnvVarEnvironment, EnvVarWithEnvironments};
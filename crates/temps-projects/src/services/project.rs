@@ -7,6 +7,7 @@ use sea_orm::{
 };
 use temps_core::{Job, ProjectCreatedJob, ProjectDeletedJob, ProjectUpdatedJob};
 use temps_entities::projects;
+use temps_entities::tags::Tags;
 use temps_git::services::public_repo::PublicRepoProviderFactory;
 
 use serde::Serialize;
@@ -78,6 +79,8 @@ pub struct ProjectService {
     pub git_provider_manager: Arc<temps_git::GitProviderManager>,
     env_var_service: Arc<EnvVarService>,
     environment_service: Arc<temps_environments::EnvironmentService>,
+    /// Encrypts the secrets of deployment configs before they are stored
+    encryption_service: Arc<temps_core::EncryptionService>,
 }
 
 impl ProjectService {
@@ -88,6 +91,7 @@ impl ProjectService {
         external_service_manager: Arc<temps_providers::ExternalServiceManager>,
         git_provider_manager: Arc<temps_git::GitProviderManager>,
         environment_service: Arc<temps_environments::EnvironmentService>,
+        encryption_service: Arc<temps_core::EncryptionService>,
     ) -> Self {
         let env_var_service = Arc::new(EnvVarService::new(db.clone()));
 
@@ -99,6 +103,7 @@ impl ProjectService {
             git_provider_manager,
             env_var_service,
             environment_service,
+            encryption_service,
         }
     }
 
@@ -172,10 +177,57 @@ impl ProjectService {
             memory_limit: Some(DEFAULT_MEMORY_LIMIT),
             exposed_port: request.exposed_port,
             automatic_deploy: request.automatic_deploy,
+            auto_deploy_tag_pattern: None,
             performance_metrics_enabled: false, // Default to false
             session_recording_enabled: false,
             replicas: 1, // Default replicas
             security: None,
+            upstream_protocol: None,
+            request_timeout_seconds: None,
+            stream_timeout_seconds: None,
+            websocket_idle_timeout_seconds: None,
+            websocket_max_duration_seconds: None,
+            response_buffering: None,
+            connection_drain_seconds: None,
+            max_request_body_bytes: None,
+            max_request_header_bytes: None,
+            client_timeout_seconds: None,
+            write_timeout_seconds: None,
+            idle_timeout_seconds: None,
+            connect_timeout_seconds: None,
+            https_mode: None,
+            https_redirect_status: None,
+            stop_signal: None,
+            stop_timeout_seconds: None,
+            artifact_retention_days: None,
+            retained_images: None,
+            deploy_timeout_seconds: None,
+            static_site: None,
+            promotion: None,
+            build_cache: None,
+            builder: None,
+            shared_build: None,
+            build_command: None,
+            start_command: None,
+            entrypoint: None,
+            args: None,
+            build_platforms: None,
+            container_logs: None,
+            locale_defaults: None,
+            smoke_tests: None,
+            container_dns: None,
+            container_limits: None,
+            deploy_gate: None,
+            cdn_purge: None,
+            processes: None,
+            auto_retry: None,
+            build_priority: None,
+            protected_secrets: None,
+            concurrency_limit: None,
+            startup_probe: None,
+            config_reload: None,
+            exec_health_check: None,
+            health_thresholds: None,
         });
 
         let project = projects::ActiveModel {
@@ -256,7 +308,7 @@ impl ProjectService {
 
             for storage_service_id in request.storage_service_ids {
                 self.external_service_manager
-                    .link_service_to_project(storage_service_id, project_found_db.id)
+                    .link_service_to_project(storage_service_id, project_found_db.id, None)
                     .await
                     .map_err(|e| {
                         ProjectError::Other(format!("Failed to create storage service: {}", e))
@@ -511,6 +563,18 @@ impl ProjectService {
                 );
             }
         }
+        // Drop the users the project connects to its services with; the links
+        // themselves are deleted with the project below
+        if let Err(e) = self
+            .external_service_manager
+            .revoke_project_credentials(project_id)
+            .await
+        {
+            warn!(
+                "Failed to revoke service credentials of project {}: {}",
+                project_id, e
+            );
+        }
         let txn = self.db.begin().await?;
 
         use temps_entities::{
@@ -673,6 +737,8 @@ impl ProjectService {
         directory: Option<String>,
         attack_mode: Option<bool>,
         enable_preview_environments: Option<bool>,
+        deploy_concurrency: Option<i32>,
+        tags: Option<Tags>,
     ) -> Result<Project, ProjectError> {
         // Get the current project
         let mut project = projects::Entity::find_by_id(project_id)
@@ -804,6 +870,46 @@ impl ProjectService {
             active_project.update(self.db.as_ref()).await?;
         }
 
+        // Update deploy concurrency if provided (0 resets to the server default)
+        if let Some(concurrency) = deploy_concurrency {
+            if concurrency < 0 {
+                return Err(ProjectError::InvalidInput(
+                    "Deploy concurrency must be at least 1, or 0 for the default".to_string(),
+                ));
+            }
+
+            // Reload project to ensure we have the latest state
+            let project = projects::Entity::find_by_id(project_id)
+                .one(self.db.as_ref())
+                .await?
+                .ok_or(ProjectError::NotFound(format!(
+                    "Project {} not found",
+                    project_id
+                )))?;
+
+            let mut active_project: projects::ActiveModel = project.into();
+            active_project.deploy_concurrency = Set((concurrency > 0).then_some(concurrency));
+            active_project.update(self.db.as_ref()).await?;
+        }
+
+        // Replace the project's tags if provided
+        if let Some(tags) = tags {
+            tags.validate().map_err(ProjectError::InvalidInput)?;
+
+            // Reload project to ensure we have the latest state
+            let project = projects::Entity::find_by_id(project_id)
+                .one(self.db.as_ref())
+                .await?
+                .ok_or(ProjectError::NotFound(format!(
+                    "Project {} not found",
+                    project_id
+                )))?;
+
+            let mut active_project: projects::ActiveModel = project.into();
+            active_project.tags = Set(tags);
+            active_project.update(self.db.as_ref()).await?;
+        }
+
         // Update git-related fields if any are provided
         let needs_git_update = main_branch.is_some()
             || repo_owner.is_some()
@@ -1006,6 +1112,7 @@ impl ProjectService {
         &self,
         page: i64,
         per_page: i64,
+        tags: Option<Tags>,
     ) -> Result<(Vec<Project>, i64), ProjectError> {
         use sea_orm::PaginatorTrait;
         use sea_orm::QueryOrder;
@@ -1013,15 +1120,22 @@ impl ProjectService {
         // Calculate offset
         let offset = ((page - 1) * per_page) as u64;
 
+        // Only projects having every requested tag
+        let mut query = projects::Entity::find();
+        if let Some(tags) = tags.filter(|tags| !tags.is_empty()) {
+            query = query.filter(tags.contained_in(projects::Column::Tags));
+        }
+
         // Get total count
-        let total = projects::Entity::find()
+        let total = query
+            .clone()
             .count(self.db.as_ref())
             .await
             .map_err(|e| ProjectError::DatabaseConnectionError(e.to_string()))?
             as i64;
 
         // Get paginated projects
-        let projects = projects::Entity::find()
+        let projects = query
             .order_by_desc(projects::Column::LastDeployment)
             .offset(offset)
             .limit(per_page as u64)
@@ -1166,6 +1280,150 @@ impl ProjectService {
         if let Some(security) = config.security {
             deployment_config.security = Some(security);
         }
+        if let Some(upstream_protocol) = config.upstream_protocol {
+            deployment_config.upstream_protocol = Some(upstream_protocol);
+        }
+        if let Some(request_timeout_seconds) = config.request_timeout_seconds {
+            deployment_config.request_timeout_seconds = Some(request_timeout_seconds);
+        }
+        if let Some(stream_timeout_seconds) = config.stream_timeout_seconds {
+            deployment_config.stream_timeout_seconds = Some(stream_timeout_seconds);
+        }
+        if let Some(idle_timeout) = config.websocket_idle_timeout_seconds {
+            deployment_config.websocket_idle_timeout_seconds = Some(idle_timeout);
+        }
+        if let Some(max_duration) = config.websocket_max_duration_seconds {
+            deployment_config.websocket_max_duration_seconds = Some(max_duration);
+        }
+        if let Some(response_buffering) = config.response_buffering {
+            deployment_config.response_buffering = Some(response_buffering);
+        }
+        if let Some(drain_seconds) = config.connection_drain_seconds {
+            deployment_config.connection_drain_seconds = Some(drain_seconds);
+        }
+        if let Some(max_request_body_bytes) = config.max_request_body_bytes {
+            deployment_config.max_request_body_bytes = Some(max_request_body_bytes);
+        }
+        if let Some(max_request_header_bytes) = config.max_request_header_bytes {
+            deployment_config.max_request_header_bytes = Some(max_request_header_bytes);
+        }
+        if let Some(client_timeout_seconds) = config.client_timeout_seconds {
+            deployment_config.client_timeout_seconds = Some(client_timeout_seconds);
+        }
+        if let Some(write_timeout_seconds) = config.write_timeout_seconds {
+            deployment_config.write_timeout_seconds = Some(write_timeout_seconds);
+        }
+        if let Some(idle_timeout_seconds) = config.idle_timeout_seconds {
+            deployment_config.idle_timeout_seconds = Some(idle_timeout_seconds);
+        }
+        if let Some(connect_timeout_seconds) = config.connect_timeout_seconds {
+            deployment_config.connect_timeout_seconds = Some(connect_timeout_seconds);
+        }
+        if let Some(concurrency_limit) = config.concurrency_limit {
+            deployment_config.concurrency_limit = Some(concurrency_limit);
+        }
+        if let Some(https_mode) = config.https_mode {
+            deployment_config.https_mode = Some(https_mode);
+        }
+        if let Some(https_redirect_status) = config.https_redirect_status {
+            deployment_config.https_redirect_status = Some(https_redirect_status);
+        }
+        if let Some(stop_signal) = config.stop_signal {
+            deployment_config.stop_signal = Some(stop_signal);
+        }
+        if let Some(stop_timeout_seconds) = config.stop_timeout_seconds {
+            deployment_config.stop_timeout_seconds = Some(stop_timeout_seconds);
+        }
+        if let Some(artifact_retention_days) = config.artifact_retention_days {
+            deployment_config.artifact_retention_days = Some(artifact_retention_days);
+        }
+        if let Some(retained_images) = config.retained_images {
+            deployment_config.retained_images = Some(retained_images);
+        }
+        if let Some(deploy_timeout_seconds) = config.deploy_timeout_seconds {
+            deployment_config.deploy_timeout_seconds = Some(deploy_timeout_seconds);
+        }
+        if let Some(static_site) = config.static_site {
+            deployment_config.static_site = Some(static_site);
+        }
+        if let Some(promotion) = config.promotion {
+            deployment_config.promotion = Some(promotion);
+        }
+        if let Some(build_cache) = config.build_cache {
+            deployment_config.build_cache = Some(build_cache);
+        }
+        if let Some(builder) = config.builder {
+            deployment_config.builder = Some(builder);
+        }
+        if let Some(shared_build) = config.shared_build {
+            deployment_config.shared_build = Some(shared_build);
+        }
+        if let Some(build_command) = config.build_command {
+            deployment_config.build_command = Some(build_command);
+        }
+        if let Some(start_command) = config.start_command {
+            deployment_config.start_command = Some(start_command);
+        }
+        if let Some(entrypoint) = config.entrypoint {
+            deployment_config.entrypoint = Some(entrypoint);
+        }
+        if let Some(args) = config.args {
+            deployment_config.args = Some(args);
+        }
+        if let Some(build_platforms) = config.build_platforms {
+            deployment_config.build_platforms = Some(build_platforms);
+        }
+        if let Some(auto_retry) = config.auto_retry {
+            deployment_config.auto_retry = Some(auto_retry);
+        }
+        if let Some(container_logs) = config.container_logs {
+            deployment_config.container_logs = Some(container_logs);
+        }
+        if let Some(locale_defaults) = config.locale_defaults {
+            deployment_config.locale_defaults = Some(locale_defaults);
+        }
+        if let Some(startup_probe) = config.startup_probe {
+            deployment_config.startup_probe = Some(startup_probe);
+        }
+        if let Some(config_reload) = config.config_reload {
+            deployment_config.config_reload = Some(config_reload);
+        }
+        if let Some(exec_health_check) = config.exec_health_check {
+            deployment_config.exec_health_check = Some(exec_health_check);
+        }
+        if let Some(health_thresholds) = config.health_thresholds {
+            deployment_config.health_thresholds = Some(health_thresholds);
+        }
+        if let Some(smoke_tests) = config.smoke_tests {
+            deployment_config.smoke_tests = Some(smoke_tests);
+        }
+        if let Some(container_dns) = config.container_dns {
+            deployment_config.container_dns = Some(container_dns);
+        }
+        if let Some(container_limits) = config.container_limits {
+            deployment_config.container_limits = Some(container_limits);
+        }
+        if let Some(mut deploy_gate) = config.deploy_gate {
+            deploy_gate
+                .seal(
+                    deployment_config.deploy_gate.as_ref(),
+                    &self.encryption_service,
+                )
+                .map_err(|e| ProjectError::InvalidInput(format!("Invalid deploy gate: {}", e)))?;
+            deployment_config.deploy_gate = Some(deploy_gate);
+        }
+        if let Some(mut cdn_purge) = config.cdn_purge {
+            cdn_purge
+                .seal(
+                    deployment_config.cdn_purge.as_ref(),
+                    &self.encryption_service,
+                )
+                .map_err(|e| ProjectError::InvalidInput(format!("Invalid CDN purge: {}", e)))?;
+            deployment_config.cdn_purge = Some(cdn_purge);
+        }
+        if let Some(processes) = config.processes {
+            deployment_config.processes = Some(processes);
+        }
 
         // Validate the deployment config
         deployment_config
@@ -1292,6 +1550,8 @@ impl ProjectService {
             deployment_config: deployment_config.clone(),
             attack_mode: db_project.attack_mode,
             enable_preview_environments: db_project.enable_preview_environments,
+            deploy_concurrency: db_project.deploy_concurrency,
+            tags: db_project.tags,
         }
     }
 
@@ -1405,6 +1665,11 @@ impl ProjectService {
             tag: None, // No tag for initial deployment
             commit: commit_sha.clone(),
             project_id: project.id, // Include project_id
+            environment_id: None,
+            manual: false,
+            release_note: None,
+            triggered_by: None,
+            dry_run: false,
         };
 
         self.queue_service
@@ -1425,6 +1690,10 @@ impl ProjectService {
     }
 
     /// Trigger a pipeline for a specific project and environment
+    ///
+    /// `release_note` and `triggered_by` end up in the deployment's changelog entry. A
+    /// `dry_run` builds and checks the app without shipping it.
+    #[allow(clippy::too_many_arguments)]
     pub async fn trigger_pipeline(
         &self,
         project_id: i32,
@@ -1432,6 +1701,9 @@ impl ProjectService {
         branch: Option<String>,
         tag: Option<String>,
         commit: Option<String>,
+        release_note: Option<String>,
+        triggered_by: Option<i32>,
+        dry_run: bool,
     ) -> Result<(i32, i32, Option<String>, Option<String>, Option<String>), ProjectError> {
         // Get the project to validate it exists and get repository information
         let project = temps_entities::projects::Entity::find_by_id(project_id)
@@ -1459,65 +1731,74 @@ impl ProjectService {
             ));
         }
 
-        // Use provided branch/commit or fall back to project defaults
-        let branch_to_use = branch.unwrap_or(project.main_branch.clone());
+        // Use provided branch/commit or fall back to project defaults.
+        // A tag deployment checks out the tag itself, so no branch is implied.
+        let branch_to_use = match (branch, &tag) {
+            (None, Some(_)) => None,
+            (branch, _) => Some(branch.unwrap_or(project.main_branch.clone())),
+        };
 
-        // Fetch the latest commit from the branch if no commit was provided
+        // Deploy the exact commit requested, the commit a tag points to,
+        // or the latest commit on the branch
         let commit_to_use = if let Some(commit) = commit {
             commit
-        } else if let Some(connection_id) = project.git_provider_connection_id {
-            // Fetch latest commit from the branch using authenticated git provider
-            match self
-                .git_provider_manager
-                .get_branch_latest_commit(
-                    connection_id,
-                    &project.repo_owner,
-                    &project.repo_name,
-                    &branch_to_use,
-                )
-                .await
-            {
-                Ok(commit_info) => {
-                    info!(
-                        "Fetched latest commit from branch {}: {} ({})",
-                        branch_to_use, commit_info.sha, commit_info.message
-                    );
-                    commit_info.sha
-                }
-                Err(e) => {
-                    warn!(
-                        "Failed to fetch latest commit from branch {}: {}, using placeholder",
-                        branch_to_use, e
-                    );
-                    format!("manual-trigger-{}", chrono::Utc::now().timestamp())
+        } else if let Some(ref tag_name) = tag {
+            self.resolve_tag_commit(&project, tag_name).await?
+        } else {
+            let branch_to_use = branch_to_use.clone().unwrap_or_default();
+            if let Some(connection_id) = project.git_provider_connection_id {
+                // Fetch latest commit from the branch using authenticated git provider
+                match self
+                    .git_provider_manager
+                    .get_branch_latest_commit(
+                        connection_id,
+                        &project.repo_owner,
+                        &project.repo_name,
+                        &branch_to_use,
+                    )
+                    .await
+                {
+                    Ok(commit_info) => {
+                        info!(
+                            "Fetched latest commit from branch {}: {} ({})",
+                            branch_to_use, commit_info.sha, commit_info.message
+                        );
+                        commit_info.sha
+                    }
+                    Err(e) => {
+                        warn!(
+                            "Failed to fetch latest commit from branch {}: {}, using placeholder",
+                            branch_to_use, e
+                        );
+                        format!("manual-trigger-{}", chrono::Utc::now().timestamp())
+                    }
                 }
-            }
-        } else if project.is_public_repo {
-            // For public repos without git provider connection, fetch from public API
-            let provider_name = if let Some(ref git_url) = project.git_url {
-                if git_url.contains("github.com") {
-                    "github"
-                } else if git_url.contains("gitlab.com") {
-                    "gitlab"
-                } else {
-                    return Err(ProjectError::InvalidInput(format!(
+            } else if project.is_public_repo {
+                // For public repos without git provider connection, fetch from public API
+                let provider_name = if let Some(ref git_url) = project.git_url {
+                    if git_url.contains("github.com") {
+                        "github"
+                    } else if git_url.contains("gitlab.com") {
+                        "gitlab"
+                    } else {
+                        return Err(ProjectError::InvalidInput(format!(
                         "Unknown git provider for public repo URL: {}. Only GitHub and GitLab public repos are supported.",
                         git_url
                     )));
-                }
-            } else {
-                // No git_url, try to infer from repo structure (assume GitHub for public repos)
-                "github"
-            };
+                    }
+                } else {
+                    // No git_url, try to infer from repo structure (assume GitHub for public repos)
+                    "github"
+                };
 
-            let provider = PublicRepoProviderFactory::create(provider_name).map_err(|e| {
-                ProjectError::Other(format!(
-                    "Failed to create public repo provider for {}: {}",
-                    provider_name, e
-                ))
-            })?;
+                let provider = PublicRepoProviderFactory::create(provider_name).map_err(|e| {
+                    ProjectError::Other(format!(
+                        "Failed to create public repo provider for {}: {}",
+                        provider_name, e
+                    ))
+                })?;
 
-            let branches = provider
+                let branches = provider
                 .list_branches(&project.repo_owner, &project.repo_name)
                 .await
                 .map_err(|e| {
@@ -1527,43 +1808,51 @@ impl ProjectService {
                     ))
                 })?;
 
-            // Find the target branch
-            let branch_info = branches
-                .iter()
-                .find(|b| b.name == branch_to_use)
-                .ok_or_else(|| {
-                    ProjectError::NotFound(format!(
-                        "Branch '{}' not found in public repo {}/{}. Available branches: {}",
-                        branch_to_use,
-                        project.repo_owner,
-                        project.repo_name,
-                        branches
-                            .iter()
-                            .take(10)
-                            .map(|b| b.name.as_str())
-                            .collect::<Vec<_>>()
-                            .join(", ")
-                    ))
-                })?;
+                // Find the target branch
+                let branch_info = branches
+                    .iter()
+                    .find(|b| b.name == branch_to_use)
+                    .ok_or_else(|| {
+                        ProjectError::NotFound(format!(
+                            "Branch '{}' not found in public repo {}/{}. Available branches: {}",
+                            branch_to_use,
+                            project.repo_owner,
+                            project.repo_name,
+                            branches
+                                .iter()
+                                .take(10)
+                                .map(|b| b.name.as_str())
+                                .collect::<Vec<_>>()
+                                .join(", ")
+                        ))
+                    })?;
 
-            info!(
-                "Fetched latest commit from public repo {}/{} branch {}: {}",
-                project.repo_owner, project.repo_name, branch_to_use, branch_info.commit_sha
-            );
-            branch_info.commit_sha.clone()
-        } else {
-            warn!("No git provider connection found for project, using placeholder commit");
-            format!("manual-trigger-{}", chrono::Utc::now().timestamp())
+                info!(
+                    "Fetched latest commit from public repo {}/{} branch {}: {}",
+                    project.repo_owner, project.repo_name, branch_to_use, branch_info.commit_sha
+                );
+                branch_info.commit_sha.clone()
+            } else {
+                warn!("No git provider connection found for project, using placeholder commit");
+                format!("manual-trigger-{}", chrono::Utc::now().timestamp())
+            }
         };
 
         // Create GitPushEvent job to trigger pipeline
         let git_push_job = temps_core::GitPushEventJob {
             owner: project.repo_owner.clone(),
             repo: project.repo_name.clone(),
-            branch: Some(branch_to_use.clone()),
+            branch: branch_to_use.clone(),
             tag: tag.clone(),
             commit: commit_to_use.clone(),
             project_id, // Include project_id
+            environment_id: Some(environment_id),
+            manual: true,
+            release_note: release_note
+                .map(|note| note.trim().to_string())
+                .filter(|note| !note.is_empty()),
+            triggered_by,
+            dry_run,
         };
 
         // Send the job to the queue
@@ -1575,19 +1864,90 @@ impl ProjectService {
             })?;
 
         info!(
-            "Triggered pipeline for project {} ({}), environment {} ({}), branch: {}",
-            project_id, project.name, environment_id, environment.name, branch_to_use
+            "Triggered {} for project {} ({}), environment {} ({}), branch: {:?}, tag: {:?}",
+            if dry_run { "dry run" } else { "pipeline" },
+            project_id,
+            project.name,
+            environment_id,
+            environment.name,
+            branch_to_use,
+            tag
         );
 
         // Return the details for the response
         Ok((
             project_id,
             environment_id,
-            Some(branch_to_use),
+            branch_to_use,
             tag,
             Some(commit_to_use),
         ))
     }
+
+    /// List the tags of a project's repository through its git provider connection
+    pub async fn list_repository_tags(
+        &self,
+        project_id: i32,
+    ) -> Result<Vec<temps_git::services::git_provider::GitProviderTag>, ProjectError> {
+        let project = temps_entities::projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await
+            .map_err(|e| ProjectError::Other(e.to_string()))?
+            .ok_or_else(|| ProjectError::NotFound("Project not found".to_string()))?;
+
+        self.fetch_repository_tags(&project).await
+    }
+
+    async fn fetch_repository_tags(
+        &self,
+        project: &temps_entities::projects::Model,
+    ) -> Result<Vec<temps_git::services::git_provider::GitProviderTag>, ProjectError> {
+        let connection_id = project.git_provider_connection_id.ok_or_else(|| {
+            ProjectError::InvalidInput(
+                "Project has no git provider connection to list tags from".to_string(),
+            )
+        })?;
+
+        let repo_api = self
+            .git_provider_manager
+            .get_repository_api(connection_id, &project.repo_owner, &project.repo_name)
+            .await
+            .map_err(|e| ProjectError::GitHubError(e.to_string()))?;
+
+        repo_api
+            .get_tags()
+            .await
+            .map_err(|e| ProjectError::GitHubError(format!("Failed to list tags: {}", e)))
+    }
+
+    /// Resolve the commit a tag points to
+    ///
+    /// Projects without a git provider connection cannot look tags up; they fall back to
+    /// a placeholder commit and the tag is still checked out by name at build time.
+    async fn resolve_tag_commit(
+        &self,
+        project: &temps_entities::projects::Model,
+        tag: &str,
+    ) -> Result<String, ProjectError> {
+        if project.git_provider_connection_id.is_none() {
+            warn!(
+                "No git provider connection for project {}, deploying tag {} with placeholder commit",
+                project.id, tag
+            );
+            return Ok(format!("manual-trigger-{}", chrono::Utc::now().timestamp()));
+        }
+
+        let tags = self.fetch_repository_tags(project).await?;
+        let found = tags.into_iter().find(|t| t.name == tag).ok_or_else(|| {
+            ProjectError::NotFound(format!(
+                "Tag '{}' not found in repository {}/{}",
+                tag, project.repo_owner, project.repo_name
+            ))
+        })?;
+
+        info!("Resolved tag {} to commit {}", tag, found.commit_sha);
+        Ok(found.commit_sha)
+    }
 }
 
 #[cfg(test)]
@@ -1687,6 +2047,7 @@ mod tests {
             external_service_manager,
             git_provider_manager,
             environment_service,
+            encryption_service,
         )
     }
 
@@ -1794,6 +2155,8 @@ mod tests {
                 None,
                 None,
                 None,
+                None,
+                None,
             )
             .await;
 
@@ -1812,6 +2175,102 @@ mod tests {
         }
     }
 
+    #[tokio::test]
+    async fn test_project_tags_filter_list() {
+        let test_db = TestDatabase::with_migrations().await.unwrap();
+        let db = test_db.db.clone();
+        let mock_queue = Arc::new(MockJobQueue::new());
+        let project_service = create_test_services(db.clone(), mock_queue.clone()).await;
+
+        let mut project_ids = Vec::new();
+        for name in ["payments-api", "payments-web", "search"] {
+            let project = temps_entities::projects::ActiveModel {
+                name: Set(name.to_string()),
+                slug: Set(name.to_string()),
+                repo_name: Set(name.to_string()),
+                repo_owner: Set("test-owner".to_string()),
+                directory: Set(".".to_string()),
+                main_branch: Set("main".to_string()),
+                preset: Set(Preset::Nixpacks),
+                ..Default::default()
+            };
+            project_ids.push(project.insert(db.as_ref()).await.unwrap().id);
+        }
+
+        let tag_sets = [
+            "team=payments,tier=critical",
+            "team=payments",
+            "team=search,tier=critical",
+        ];
+        for (project_id, tags) in project_ids.iter().zip(tag_sets) {
+            project_service
+                .update_project_settings(
+                    *project_id,
+                    None,
+                    None,
+                    None,
+                    None,
+                    None,
+                    None,
+                    None,
+                    None,
+                    None,
+                    None,
+                    Some(Tags::parse_selector(tags).unwrap()),
+                )
+                .await
+                .unwrap();
+        }
+
+        let (projects, total) = project_service
+            .get_projects_paginated(1, 10, Some(Tags::parse_selector("team=payments").unwrap()))
+            .await
+            .unwrap();
+        assert_eq!(total, 2);
+        assert!(projects
+            .iter()
+            .all(|p| p.tags.get("team") == Some("payments")));
+
+        let (projects, total) = project_service
+            .get_projects_paginated(
+                1,
+                10,
+                Some(Tags::parse_selector("team=payments,tier=critical").unwrap()),
+            )
+            .await
+            .unwrap();
+        assert_eq!(total, 1);
+        assert_eq!(projects[0].id, project_ids[0]);
+
+        let (_, total) = project_service
+            .get_projects_paginated(1, 10, None)
+            .await
+            .unwrap();
+        assert_eq!(total, 3);
+
+        let invalid = Tags::from(std::collections::BTreeMap::from([(
+            "team name".to_string(),
+            "payments".to_string(),
+        )]));
+        let result = project_service
+            .update_project_settings(
+                project_ids[0],
+                None,
+                None,
+                None,
+                None,
+                None,
+                None,
+                None,
+                None,
+                None,
+                None,
+                Some(invalid),
+            )
+            .await;
+        assert!(matches!(result, Err(ProjectError::InvalidInput(_))));
+    }
+
     #[tokio::test]
     async fn test_update_project_event_includes_correct_data() {
         // Setup test database
@@ -61,6 +61,8 @@ pub struct Project {
     pub deployment_config: Option<temps_entities::prelude::DeploymentConfig>,
     pub attack_mode: bool,
     pub enable_preview_environments: bool,
+    pub deploy_concurrency: Option<i32>,
+    pub tags: temps_entities::tags::Tags,
 }
 
 #[derive(Deserialize)]
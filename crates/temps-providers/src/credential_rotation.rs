@@ -0,0 +1,878 @@
+//! Managed service credential rotation
+//!
+//! Rotates the credentials of managed PostgreSQL services without breaking the apps
+//! using them. A PostgreSQL role has a single password, so credentials alternate
+//! between two superuser roles, `<user>` and `<user>_alt`:
+//!
+//! 1. the other role is given a new password and login, and a connection with it is
+//!    verified
+//! 2. it becomes the service's stored credential, which linked apps receive through
+//!    their service environment variables on their next deployment
+//! 3. every linked environment is redeployed, one at a time, while the old credential
+//!    still works for the containers being replaced
+//! 4. the new credential is verified again and the old role loses its login
+//!
+//! If a redeploy or the final verification fails, the rotation is rolled back: the
+//! old credential is stored again, the environments already moved are redeployed
+//! with it and the new role loses its login. Rotations run on demand or on a
+//! per-service schedule, and each one is recorded and audited.
+
+use chrono::{DateTime, Duration, Utc};
+use sea_orm::sqlx::{self, postgres::PgConnectOptions, Connection, PgConnection};
+use sea_orm::{
+    sea_query::Expr, ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter,
+    QueryOrder, QuerySelect, Set,
+};
+use serde::Serialize;
+use std::collections::{HashMap, HashSet};
+use std::sync::{Arc, Mutex};
+use temps_core::{AuditContext, AuditLogger, EnvironmentRedeployer};
+use temps_entities::{
+    credential_rotation_policies, credential_rotations, environments, project_services,
+};
+use thiserror::Error;
+use tokio::sync::RwLock;
+use tracing::{debug, error, info, warn};
+use utoipa::ToSchema;
+
+use crate::externalsvc::postgres::{generate_password, PostgresInputConfig};
+use crate::externalsvc::ServiceType;
+use crate::handlers::audit::ExternalServiceCredentialsRotatedAudit;
+use crate::services::{ExternalServiceError, ExternalServiceManager};
+
+/// How often the scheduler looks for due rotations
+const SCHEDULE_CHECK_INTERVAL_SECS: u64 = 15 * 60;
+/// Suffix of the second role credentials alternate with
+const ALTERNATE_ROLE_SUFFIX: &str = "_alt";
+/// Longest rotation schedule, in days
+const MAX_INTERVAL_DAYS: i32 = 365;
+/// Number of past rotations listed per service
+const HISTORY_LIMIT: u64 = 50;
+/// User agent of audit entries written for scheduled rotations
+const SCHEDULER_USER_AGENT: &str = "temps-credential-rotation";
+
+#[derive(Error, Debug)]
+pub enum CredentialRotationError {
+    #[error("Credential rotation is not supported for {0} services")]
+    Unsupported(String),
+
+    #[error("A credential rotation is already running for service {0}")]
+    AlreadyRunning(i32),
+
+    #[error("{0}")]
+    Validation(String),
+
+    #[error(transparent)]
+    Service(#[from] ExternalServiceError),
+
+    #[error("Credential error: {0}")]
+    Credential(String),
+}
+
+impl From<sea_orm::DbErr> for CredentialRotationError {
+    fn from(err: sea_orm::DbErr) -> Self {
+        CredentialRotationError::Service(err.into())
+    }
+}
+
+/// A rotation of a service's credentials
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct CredentialRotationInfo {
+    pub id: i32,
+    pub service_id: i32,
+    /// manual or scheduled
+    #[schema(example = "manual")]
+    pub trigger: String,
+    /// running, completed, rolled_back or failed
+    #[schema(example = "completed")]
+    pub status: String,
+    #[schema(example = "postgres")]
+    pub old_username: String,
+    #[schema(example = "postgres_alt")]
+    pub new_username: String,
+    /// Environments redeployed with the new credential
+    pub environment_ids: Vec<i32>,
+    pub error: Option<String>,
+    pub triggered_by: i32,
+    pub started_at: DateTime<Utc>,
+    pub finished_at: Option<DateTime<Utc>>,
+}
+
+impl From<credential_rotations::Model> for CredentialRotationInfo {
+    fn from(rotation: credential_rotations::Model) -> Self {
+        Self {
+            id: rotation.id,
+            service_id: rotation.service_id,
+            trigger: rotation.trigger,
+            status: rotation.status,
+            old_username: rotation.old_username,
+            new_username: rotation.new_username,
+            environment_ids: serde_json::from_value(rotation.environment_ids).unwrap_or_default(),
+            error: rotation.error,
+            triggered_by: rotation.triggered_by,
+            started_at: rotation.started_at,
+            finished_at: rotation.finished_at,
+        }
+    }
+}
+
+/// A service's rotation schedule
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct CredentialRotationPolicyInfo {
+    pub service_id: i32,
+    #[schema(example = 30)]
+    pub interval_days: i32,
+    pub next_rotation_at: DateTime<Utc>,
+    pub updated_by: i32,
+}
+
+impl From<credential_rotation_policies::Model> for CredentialRotationPolicyInfo {
+    fn from(policy: credential_rotation_policies::Model) -> Self {
+        Self {
+            service_id: policy.service_id,
+            interval_days: policy.interval_days,
+            next_rotation_at: policy.next_rotation_at,
+            updated_by: policy.updated_by,
+        }
+    }
+}
+
+#[derive(Clone)]
+struct Credential {
+    username: String,
+    password: String,
+}
+
+/// Where to connect to a service
+#[derive(Debug, Clone)]
+struct Endpoint {
+    host: String,
+    port: u16,
+    database: String,
+}
+
+/// Outcome of a rotation, as stored on its record
+struct RotationOutcome {
+    status: &'static str,
+    environment_ids: Vec<i32>,
+    error: Option<String>,
+}
+
+/// The role credentials alternate to from `username`
+fn alternate_username(username: &str) -> String {
+    match username.strip_suffix(ALTERNATE_ROLE_SUFFIX) {
+        Some(base) if !base.is_empty() => base.to_string(),
+        _ => format!("{}{}", username, ALTERNATE_ROLE_SUFFIX),
+    }
+}
+
+pub(crate) fn quote_identifier(identifier: &str) -> String {
+    format!("\"{}\"", identifier.replace('"', "\"\""))
+}
+
+pub(crate) fn quote_literal(literal: &str) -> String {
+    format!("'{}'", literal.replace('\'', "''"))
+}
+
+/// SQL giving a role login with a new password, creating it if it doesn't exist
+fn grant_login_sql(credential: &Credential, exists: bool) -> String {
+    format!(
+        "{} ROLE {} WITH LOGIN SUPERUSER PASSWORD {}",
+        if exists { "ALTER" } else { "CREATE" },
+        quote_identifier(&credential.username),
+        quote_literal(&credential.password)
+    )
+}
+
+/// SQL taking away a role's login and password; the role and what it owns remain
+fn revoke_login_sql(username: &str) -> String {
+    format!(
+        "ALTER ROLE {} WITH NOLOGIN PASSWORD NULL",
+        quote_identifier(username)
+    )
+}
+
+/// Coordinates credential rotations of managed services
+pub struct CredentialRotationService {
+    db: Arc<DatabaseConnection>,
+    external_service_manager: Arc<ExternalServiceManager>,
+    audit_service: Arc<dyn AuditLogger>,
+    /// Redeploys linked environments; provided by the deployments plugin once every
+    /// plugin has registered its services
+    redeployer: RwLock<Option<Arc<dyn EnvironmentRedeployer>>>,
+    /// Services with a rotation in progress
+    rotating: Mutex<HashSet<i32>>,
+}
+
+impl CredentialRotationService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        external_service_manager: Arc<ExternalServiceManager>,
+        audit_service: Arc<dyn AuditLogger>,
+    ) -> Self {
+        Self {
+            db,
+            external_service_manager,
+            audit_service,
+            redeployer: RwLock::new(None),
+            rotating: Mutex::new(HashSet::new()),
+        }
+    }
+
+    pub async fn set_redeployer(&self, redeployer: Arc<dyn EnvironmentRedeployer>) {
+        *self.redeployer.write().await = Some(redeployer);
+    }
+
+    async fn postgres_config(
+        &self,
+        service_id: i32,
+    ) -> Result<PostgresInputConfig, CredentialRotationError> {
+        let service = self
+            .external_service_manager
+            .get_service_config(service_id)
+            .await?;
+        if service.service_type != ServiceType::Postgres {
+            return Err(CredentialRotationError::Unsupported(
+                service.service_type.to_string(),
+            ));
+        }
+        serde_json::from_value(service.parameters).map_err(|e| {
+            CredentialRotationError::Credential(format!("Invalid service configuration: {}", e))
+        })
+    }
+
+    /// Start rotating a service's credentials
+    ///
+    /// The rotation runs in the background; its record is returned right away and
+    /// updated as it finishes.
+    pub async fn rotate(
+        self: &Arc<Self>,
+        service_id: i32,
+        trigger: &str,
+        context: AuditContext,
+    ) -> Result<CredentialRotationInfo, CredentialRotationError> {
+        let config = self.postgres_config(service_id).await?;
+        let port = config
+            .port
+            .as_deref()
+            .unwrap_or("5432")
+            .parse::<u16>()
+            .map_err(|e| CredentialRotationError::Credential(format!("Invalid port: {}", e)))?;
+        let endpoint = Endpoint {
+            host: config.host.clone(),
+            port,
+            database: config.database.clone(),
+        };
+        let old = Credential {
+            username: config.username.clone(),
+            password: config.password.clone().unwrap_or_default(),
+        };
+        let new = Credential {
+            username: alternate_username(&config.username),
+            password: generate_password(),
+        };
+
+        if !self.rotating.lock().unwrap().insert(service_id) {
+            return Err(CredentialRotationError::AlreadyRunning(service_id));
+        }
+        let started = self
+            .start_rotation(service_id, trigger, &context, &old, &new)
+            .await;
+        let (rotation, environments) = match started {
+            Ok(started) => started,
+            Err(e) => {
+                self.rotating.lock().unwrap().remove(&service_id);
+                return Err(e);
+            }
+        };
+
+        info!(
+            "Rotating credentials of service {} from {} to {} ({} linked environments)",
+            service_id,
+            old.username,
+            new.username,
+            environments.len()
+        );
+        let service = self.clone();
+        let record = rotation.clone();
+        tokio::spawn(async move {
+            let outcome = service
+                .run_rotation(service_id, &endpoint, &old, &new, &environments)
+                .await;
+            service.finish_rotation(record, outcome, context).await;
+        });
+
+        Ok(rotation.into())
+    }
+
+    /// Check that the rotation can coordinate the linked apps, and record it
+    async fn start_rotation(
+        &self,
+        service_id: i32,
+        trigger: &str,
+        context: &AuditContext,
+        old: &Credential,
+        new: &Credential,
+    ) -> Result<(credential_rotations::Model, Vec<(i32, i32)>), CredentialRotationError> {
+        let environments = self.linked_environments(service_id).await?;
+        if !environments.is_empty() && self.redeployer.read().await.is_none() {
+            return Err(CredentialRotationError::Validation(
+                "Linked apps can't be redeployed, so their credentials can't be rotated"
+                    .to_string(),
+            ));
+        }
+
+        let rotation = credential_rotations::ActiveModel {
+            service_id: Set(service_id),
+            trigger: Set(trigger.to_string()),
+            status: Set(credential_rotations::ROTATION_STATUS_RUNNING.to_string()),
+            old_username: Set(old.username.clone()),
+            new_username: Set(new.username.clone()),
+            environment_ids: Set(serde_json::json!([])),
+            error: Set(None),
+            triggered_by: Set(context.user_id),
+            started_at: Set(Utc::now()),
+            finished_at: Set(None),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        Ok((rotation, environments))
+    }
+
+    /// Deployed, non-deleted environments of the projects linked to a service, as
+    /// `(project_id, environment_id)` pairs
+    async fn linked_environments(
+        &self,
+        service_id: i32,
+    ) -> Result<Vec<(i32, i32)>, CredentialRotationError> {
+        let project_ids: Vec<i32> = project_services::Entity::find()
+            .filter(project_services::Column::ServiceId.eq(service_id))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|link| link.project_id)
+            .collect();
+        if project_ids.is_empty() {
+            return Ok(Vec::new());
+        }
+
+        Ok(environments::Entity::find()
+            .filter(environments::Column::ProjectId.is_in(project_ids))
+            .filter(environments::Column::DeletedAt.is_null())
+            .filter(environments::Column::CurrentDeploymentId.is_not_null())
+            .order_by_asc(environments::Column::Id)
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|env| (env.project_id, env.id))
+            .collect())
+    }
+
+    async fn run_rotation(
+        &self,
+        service_id: i32,
+        endpoint: &Endpoint,
+        old: &Credential,
+        new: &Credential,
+        environments: &[(i32, i32)],
+    ) -> RotationOutcome {
+        let failed = |error: String| RotationOutcome {
+            status: credential_rotations::ROTATION_STATUS_FAILED,
+            environment_ids: Vec::new(),
+            error: Some(error),
+        };
+
+        // 1. Create the new credential and check it works before any app uses it
+        if let Err(e) = grant_login(endpoint, old, new).await {
+            return failed(format!("Failed to create the new credential: {}", e));
+        }
+        if let Err(e) = verify(endpoint, new).await {
+            if let Err(revoke_error) = revoke_login(endpoint, old, &new.username).await {
+                warn!(
+                    "Failed to revoke unverified credential {}: {}",
+                    new.username, revoke_error
+                );
+            }
+            return failed(format!("The new credential failed verification: {}", e));
+        }
+
+        // 2. Store it, so deployments receive it through the service variables
+        if let Err(e) = self.store_credential(service_id, new).await {
+            let _ = revoke_login(endpoint, old, &new.username).await;
+            return failed(format!("Failed to store the new credential: {}", e));
+        }
+
+        // 3. Move the apps over, one environment at a time
+        let redeployer = self.redeployer.read().await.clone();
+        let mut moved = Vec::new();
+        for (project_id, environment_id) in environments {
+            let Some(redeployer) = &redeployer else {
+                break;
+            };
+            match redeployer
+                .redeploy_environment(*project_id, *environment_id)
+                .await
+            {
+                Ok(deployment_id) => {
+                    debug!(
+                        "Environment {} redeployed with rotated credential (deployment {})",
+                        environment_id, deployment_id
+                    );
+                    moved.push((*project_id, *environment_id));
+                }
+                Err(e) => {
+                    return self
+                        .roll_back(
+                            service_id,
+                            endpoint,
+                            old,
+                            new,
+                            &moved,
+                            format!("Environment {} failed to redeploy: {}", environment_id, e),
+                        )
+                        .await;
+                }
+            }
+        }
+
+        // 4. Verify again with the apps moved, then revoke the old credential
+        if let Err(e) = verify(endpoint, new).await {
+            return self
+                .roll_back(
+                    service_id,
+                    endpoint,
+                    old,
+                    new,
+                    &moved,
+                    format!("The new credential failed verification: {}", e),
+                )
+                .await;
+        }
+        let environment_ids: Vec<i32> = moved.iter().map(|(_, id)| *id).collect();
+        if let Err(e) = revoke_login(endpoint, new, &old.username).await {
+            return RotationOutcome {
+                status: credential_rotations::ROTATION_STATUS_FAILED,
+                environment_ids,
+                error: Some(format!(
+                    "Apps use the new credential, but the old one could not be revoked: {}",
+                    e
+                )),
+            };
+        }
+
+        RotationOutcome {
+            status: credential_rotations::ROTATION_STATUS_COMPLETED,
+            environment_ids,
+            error: None,
+        }
+    }
+
+    /// Move the service and the environments already redeployed back to the old
+    /// credential
+    async fn roll_back(
+        &self,
+        service_id: i32,
+        endpoint: &Endpoint,
+        old: &Credential,
+        new: &Credential,
+        moved: &[(i32, i32)],
+        reason: String,
+    ) -> RotationOutcome {
+        warn!(
+            "Rolling back credential rotation of service {}: {}",
+            service_id, reason
+        );
+        let environment_ids: Vec<i32> = moved.iter().map(|(_, id)| *id).collect();
+
+        if let Err(e) = self.store_credential(service_id, old).await {
+            // Both credentials stay valid, so nothing that runs breaks
+            return RotationOutcome {
+                status: credential_rotations::ROTATION_STATUS_FAILED,
+                environment_ids,
+                error: Some(format!(
+                    "{}; restoring the old credential also failed ({}), both remain valid",
+                    reason, e
+                )),
+            };
+        }
+
+        let redeployer = self.redeployer.read().await.clone();
+        let mut still_on_new = Vec::new();
+        if let Some(redeployer) = redeployer {
+            for (project_id, environment_id) in moved {
+                if let Err(e) = redeployer
+                    .redeploy_environment(*project_id, *environment_id)
+                    .await
+                {
+                    error!(
+                        "Environment {} failed to redeploy with the old credential: {}",
+                        environment_id, e
+                    );
+                    still_on_new.push(*environment_id);
+                }
+            }
+        }
+        if !still_on_new.is_empty() {
+            return RotationOutcome {
+                status: credential_rotations::ROTATION_STATUS_FAILED,
+                environment_ids,
+                error: Some(format!(
+                    "{}; environments {:?} could not be moved back, so both credentials remain valid",
+                    reason, still_on_new
+                )),
+            };
+        }
+
+        if let Err(e) = revoke_login(endpoint, old, &new.username).await {
+            warn!(
+                "Failed to revoke rolled back credential {}: {}",
+                new.username, e
+            );
+        }
+        RotationOutcome {
+            status: credential_rotations::ROTATION_STATUS_ROLLED_BACK,
+            environment_ids,
+            error: Some(reason),
+        }
+    }
+
+    async fn store_credential(
+        &self,
+        service_id: i32,
+        credential: &Credential,
+    ) -> Result<(), ExternalServiceError> {
+        self.external_service_manager
+            .store_service_parameters(
+                service_id,
+                HashMap::from([
+                    (
+                        "username".to_string(),
+                        serde_json::Value::String(credential.username.clone()),
+                    ),
+                    (
+                        "password".to_string(),
+                        serde_json::Value::String(credential.password.clone()),
+                    ),
+                ]),
+            )
+            .await
+    }
+
+    async fn finish_rotation(
+        &self,
+        rotation: credential_rotations::Model,
+        outcome: RotationOutcome,
+        context: AuditContext,
+    ) {
+        let service_id = rotation.service_id;
+        match outcome.status {
+            credential_rotations::ROTATION_STATUS_COMPLETED => info!(
+                "Rotated credentials of service {} to {}",
+                service_id, rotation.new_username
+            ),
+            status => warn!(
+                "Credential rotation of service {} {}: {}",
+                service_id,
+                status,
+                outcome.error.as_deref().unwrap_or_default()
+            ),
+        }
+
+        let mut update: credential_rotations::ActiveModel = rotation.clone().into();
+        update.status = Set(outcome.status.to_string());
+        update.environment_ids = Set(serde_json::json!(outcome.environment_ids));
+        update.error = Set(outcome.error.clone());
+        update.finished_at = Set(Some(Utc::now()));
+        if let Err(e) = update.update(self.db.as_ref()).await {
+            error!(
+                "Failed to record credential rotation {}: {}",
+                rotation.id, e
+            );
+        }
+
+        let service_name = match self
+            .external_service_manager
+            .get_service_config(service_id)
+            .await
+        {
+            Ok(service) => service.name,
+            Err(_) => service_id.to_string(),
+        };
+        let audit = ExternalServiceCredentialsRotatedAudit {
+            context,
+            service_id,
+            service_name,
+            rotation_id: rotation.id,
+            trigger: rotation.trigger,
+            status: outcome.status.to_string(),
+            old_username: rotation.old_username,
+            new_username: rotation.new_username,
+            environment_ids: outcome.environment_ids,
+            error: outcome.error,
+        };
+        if let Err(e) = self.audit_service.create_audit_log(&audit).await {
+            error!("Failed to create audit log: {}", e);
+        }
+
+        self.rotating.lock().unwrap().remove(&service_id);
+    }
+
+    /// Past rotations of a service, latest first
+    pub async fn list_rotations(
+        &self,
+        service_id: i32,
+    ) -> Result<Vec<CredentialRotationInfo>, CredentialRotationError> {
+        Ok(credential_rotations::Entity::find()
+            .filter(credential_rotations::Column::ServiceId.eq(service_id))
+            .order_by_desc(credential_rotations::Column::StartedAt)
+            .limit(HISTORY_LIMIT)
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(CredentialRotationInfo::from)
+            .collect())
+    }
+
+    pub async fn get_policy(
+        &self,
+        service_id: i32,
+    ) -> Result<Option<CredentialRotationPolicyInfo>, CredentialRotationError> {
+        Ok(credential_rotation_policies::Entity::find()
+            .filter(credential_rotation_policies::Column::ServiceId.eq(service_id))
+            .one(self.db.as_ref())
+            .await?
+            .map(CredentialRotationPolicyInfo::from))
+    }
+
+    /// Rotate a service's credentials every `interval_days`, starting that many days
+    /// from now
+    pub async fn set_policy(
+        &self,
+        service_id: i32,
+        interval_days: i32,
+        user_id: i32,
+    ) -> Result<CredentialRotationPolicyInfo, CredentialRotationError> {
+        if !(1..=MAX_INTERVAL_DAYS).contains(&interval_days) {
+            return Err(CredentialRotationError::Validation(format!(
+                "interval_days must be between 1 and {}",
+                MAX_INTERVAL_DAYS
+            )));
+        }
+        // Only schedule rotations that can run
+        self.postgres_config(service_id).await?;
+
+        let next_rotation_at = Utc::now() + Duration::days(interval_days as i64);
+        let existing = credential_rotation_policies::Entity::find()
+            .filter(credential_rotation_policies::Column::ServiceId.eq(service_id))
+            .one(self.db.as_ref())
+            .await?;
+        let policy = match existing {
+            Some(policy) => {
+                let mut update: credential_rotation_policies::ActiveModel = policy.into();
+                update.interval_days = Set(interval_days);
+                update.next_rotation_at = Set(next_rotation_at);
+                update.updated_by = Set(user_id);
+                update.update(self.db.as_ref()).await?
+            }
+            None => {
+                credential_rotation_policies::ActiveModel {
+                    service_id: Set(service_id),
+                    interval_days: Set(interval_days),
+                    next_rotation_at: Set(next_rotation_at),
+                    updated_by: Set(user_id),
+                    ..Default::default()
+                }
+                .insert(self.db.as_ref())
+                .await?
+            }
+        };
+        Ok(policy.into())
+    }
+
+    /// Stop rotating a service's credentials on a schedule
+    /// Returns whether there was a schedule
+    pub async fn delete_policy(&self, service_id: i32) -> Result<bool, CredentialRotationError> {
+        let result = credential_rotation_policies::Entity::delete_many()
+            .filter(credential_rotation_policies::Column::ServiceId.eq(service_id))
+            .exec(self.db.as_ref())
+            .await?;
+        Ok(result.rows_affected > 0)
+    }
+
+    /// Mark rotations interrupted by a server restart as failed
+    pub async fn fail_interrupted_rotations(&self) -> Result<u64, CredentialRotationError> {
+        let result = credential_rotations::Entity::update_many()
+            .col_expr(
+                credential_rotations::Column::Status,
+                Expr::value(credential_rotations::ROTATION_STATUS_FAILED),
+            )
+            .col_expr(
+                credential_rotations::Column::Error,
+                Expr::value(
+                    "Interrupted by a server restart; check which credential the service's apps use",
+                ),
+            )
+            .col_expr(
+                credential_rotations::Column::FinishedAt,
+                Expr::value(Utc::now()),
+            )
+            .filter(
+                credential_rotations::Column::Status
+                    .eq(credential_rotations::ROTATION_STATUS_RUNNING),
+            )
+            .exec(self.db.as_ref())
+            .await?;
+        Ok(result.rows_affected)
+    }
+
+    /// Start the rotations whose schedule is due
+    async fn run_due_rotations(self: &Arc<Self>) -> Result<(), CredentialRotationError> {
+        let now = Utc::now();
+        let due = credential_rotation_policies::Entity::find()
+            .filter(credential_rotation_policies::Column::NextRotationAt.lte(now))
+            .all(self.db.as_ref())
+            .await?;
+
+        for policy in due {
+            let service_id = policy.service_id;
+            let interval_days = policy.interval_days;
+            let user_id = policy.updated_by;
+
+            // Move the schedule on first, so a failing rotation isn't retried every check
+            let mut update: credential_rotation_policies::ActiveModel = policy.into();
+            update.next_rotation_at = Set(now + Duration::days(interval_days as i64));
+            update.update(self.db.as_ref()).await?;
+
+            let context = AuditContext {
+                user_id,
+                ip_address: None,
+                user_agent: SCHEDULER_USER_AGENT.to_string(),
+            };
+            if let Err(e) = self
+                .rotate(
+                    service_id,
+                    credential_rotations::ROTATION_TRIGGER_SCHEDULED,
+                    context,
+                )
+                .await
+            {
+                warn!(
+                    "Scheduled credential rotation of service {} did not start: {}",
+                    service_id, e
+                );
+            }
+        }
+        Ok(())
+    }
+
+    pub async fn start_scheduler(self: Arc<Self>) {
+        let mut interval =
+            tokio::time::interval(std::time::Duration::from_secs(SCHEDULE_CHECK_INTERVAL_SECS));
+        loop {
+            interval.tick().await;
+            if let Err(e) = self.run_due_rotations().await {
+                error!("Failed to run scheduled credential rotations: {}", e);
+            }
+        }
+    }
+}
+
+async fn connect(
+    endpoint: &Endpoint,
+    credential: &Credential,
+) -> Result<PgConnection, sqlx::Error> {
+    let options = PgConnectOptions::new()
+        .host(&endpoint.host)
+        .port(endpoint.port)
+        .username(&credential.username)
+        .password(&credential.password)
+        .database(&endpoint.database)
+        .application_name("temps-credential-rotation");
+    PgConnection::connect_with(&options).await
+}
+
+/// Give `new` login with its password, connected as `admin`
+async fn grant_login(
+    endpoint: &Endpoint,
+    admin: &Credential,
+    new: &Credential,
+) -> Result<(), sqlx::Error> {
+    let mut conn = connect(endpoint, admin).await?;
+    let exists: Option<(i32,)> = sqlx::query_as("SELECT 1 FROM pg_roles WHERE rolname = $1")
+        .bind(&new.username)
+        .fetch_optional(&mut conn)
+        .await?;
+    sqlx::query(&grant_login_sql(new, exists.is_some()))
+        .execute(&mut conn)
+        .await?;
+    let _ = conn.close().await;
+    Ok(())
+}
+
+/// Take away a role's login and drop its sessions, connected as `admin`
+async fn revoke_login(
+    endpoint: &Endpoint,
+    admin: &Credential,
+    username: &str,
+) -> Result<(), sqlx::Error> {
+    let mut conn = connect(endpoint, admin).await?;
+    sqlx::query(&revoke_login_sql(username))
+        .execute(&mut conn)
+        .await?;
+    sqlx::query(
+        "SELECT pg_terminate_backend(pid) FROM pg_stat_activity \
+         WHERE usename = $1 AND pid <> pg_backend_pid()",
+    )
+    .bind(username)
+    .execute(&mut conn)
+    .await?;
+    let _ = conn.close().await;
+    Ok(())
+}
+
+/// Check that a credential can connect and run a query
+async fn verify(endpoint: &Endpoint, credential: &Credential) -> Result<(), sqlx::Error> {
+    let mut conn = connect(endpoint, credential).await?;
+    sqlx::query("SELECT 1").execute(&mut conn).await?;
+    let _ = conn.close().await;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_alternate_username_switches_between_two_roles() {
+        assert_eq!(alternate_username("postgres"), "postgres_alt");
+        assert_eq!(alternate_username("postgres_alt"), "postgres");
+        assert_eq!(
+            alternate_username(&alternate_username("app")),
+            "app".to_string()
+        );
+        // A role named just the suffix has no base to return to
+        assert_eq!(alternate_username("_alt"), "_alt_alt");
+    }
+
+    #[test]
+    fn test_role_sql_quotes_names_and_passwords() {
+        let credential = Credential {
+            username: "we\"ird".to_string(),
+            password: "it's".to_string(),
+        };
+        assert_eq!(
+            grant_login_sql(&credential, false),
+            "CREATE ROLE \"we\"\"ird\" WITH LOGIN SUPERUSER PASSWORD 'it''s'"
+        );
+        assert_eq!(
+            grant_login_sql(&credential, true),
+            "ALTER ROLE \"we\"\"ird\" WITH LOGIN SUPERUSER PASSWORD 'it''s'"
+        );
+        assert_eq!(
+            revoke_login_sql("postgres"),
+            "ALTER ROLE \"postgres\" WITH NOLOGIN PASSWORD NULL"
+        );
+    }
+}
@@ -0,0 +1,1223 @@
+//! Import of existing PostgreSQL databases into managed services
+//!
+//! Brings a self-hosted database under Temps management: a managed PostgreSQL
+//! service is created and the data loaded into it, either from the running source
+//! database or from a dump in an S3 source. Once loaded, every table's row count is
+//! compared with the source and the result is kept on the import record.
+//!
+//! For large databases the import can keep syncing until cutover, with logical
+//! replication: the schema is copied, the source publishes all of its tables and the
+//! managed service subscribes to them. Apps keep writing to the source while the
+//! initial copy runs and changes stream in; at cutover, once the service has caught
+//! up, sequences are copied, replication is torn down and the data verified.
+//!
+//! The managed service never runs an older major version than the source, since
+//! `pg_dump` can't dump a newer server and a dump from a newer server may not load
+//! into an older one. Without an explicit image, the service runs the source's major
+//! version.
+
+use chrono::Utc;
+use sea_orm::sqlx::{
+    self,
+    postgres::{PgConnectOptions, PgSslMode},
+    Connection, Executor, PgConnection,
+};
+use sea_orm::{ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, Set};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
+use std::str::FromStr;
+use std::sync::Arc;
+use std::time::Duration;
+use temps_core::EncryptionService;
+use temps_entities::database_imports;
+use thiserror::Error;
+use tracing::{debug, error, info, warn};
+use utoipa::ToSchema;
+
+use crate::credential_rotation::{quote_identifier, quote_literal};
+use crate::externalsvc::postgres::{PostgresInputConfig, PostgresService};
+use crate::externalsvc::ServiceType;
+use crate::services::{CreateExternalServiceRequest, ExternalServiceError, ExternalServiceManager};
+
+/// Oldest source version logical replication works from
+const MIN_REPLICATION_SOURCE_MAJOR: u32 = 10;
+/// Oldest PostgreSQL version managed services run
+const MIN_TARGET_MAJOR: u32 = 13;
+/// Image used for imports from a dump, which don't tell their version up front
+const DEFAULT_DOCKER_IMAGE: &str = "postgres:18-alpine";
+/// How long cutover waits for the managed service to catch up with the source
+const CUTOVER_CATCH_UP_TIMEOUT_SECS: u64 = 120;
+/// Prefix of the publication and subscription of a continuous sync
+const REPLICATION_NAME_PREFIX: &str = "temps_import_";
+/// Schemas left out of imports and verification
+const SYSTEM_SCHEMAS: &str = "('pg_catalog', 'information_schema')";
+
+#[derive(Error, Debug)]
+pub enum DatabaseImportError {
+    #[error("{0}")]
+    Validation(String),
+
+    #[error("{0}")]
+    VersionMismatch(String),
+
+    #[error("Source database: {0}")]
+    Source(String),
+
+    #[error("No import for service {0}")]
+    NotFound(i32),
+
+    #[error("{0}")]
+    InvalidState(String),
+
+    #[error(transparent)]
+    Service(#[from] ExternalServiceError),
+}
+
+impl From<sea_orm::DbErr> for DatabaseImportError {
+    fn from(err: sea_orm::DbErr) -> Self {
+        DatabaseImportError::Service(err.into())
+    }
+}
+
+/// Connection details of the database being imported
+///
+/// The host must be reachable both from the Temps server, which checks the source
+/// and verifies the import, and from the managed service's container, which reads
+/// the data.
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct SourceConnection {
+    #[schema(example = "db.internal.example.com")]
+    pub host: String,
+    #[serde(default = "default_port")]
+    #[schema(example = 5432)]
+    pub port: u16,
+    #[schema(example = "app")]
+    pub database: String,
+    #[schema(example = "postgres")]
+    pub username: String,
+    #[serde(default)]
+    pub password: Option<String>,
+    /// libpq SSL mode, such as `disable`, `prefer` or `require`
+    #[serde(default)]
+    #[schema(example = "prefer")]
+    pub ssl_mode: Option<String>,
+}
+
+fn default_port() -> u16 {
+    5432
+}
+
+impl SourceConnection {
+    fn describe(&self) -> String {
+        format!(
+            "postgres://{}@{}:{}/{}",
+            self.username, self.host, self.port, self.database
+        )
+    }
+
+    /// libpq connection string, as used by `pg_dump` and subscriptions
+    fn conninfo(&self) -> String {
+        let mut parts = vec![
+            format!("host={}", conninfo_value(&self.host)),
+            format!("port={}", self.port),
+            format!("dbname={}", conninfo_value(&self.database)),
+            format!("user={}", conninfo_value(&self.username)),
+        ];
+        if let Some(password) = &self.password {
+            parts.push(format!("password={}", conninfo_value(password)));
+        }
+        if let Some(ssl_mode) = &self.ssl_mode {
+            parts.push(format!("sslmode={}", conninfo_value(ssl_mode)));
+        }
+        parts.join(" ")
+    }
+
+    async fn connect(&self) -> Result<PgConnection, DatabaseImportError> {
+        let mut options = PgConnectOptions::new()
+            .host(&self.host)
+            .port(self.port)
+            .username(&self.username)
+            .database(&self.database)
+            .application_name("temps-database-import");
+        if let Some(password) = &self.password {
+            options = options.password(password);
+        }
+        if let Some(ssl_mode) = &self.ssl_mode {
+            let ssl_mode = PgSslMode::from_str(ssl_mode).map_err(|_| {
+                DatabaseImportError::Validation(format!("Invalid SSL mode '{}'", ssl_mode))
+            })?;
+            options = options.ssl_mode(ssl_mode);
+        }
+        PgConnection::connect_with(&options)
+            .await
+            .map_err(|e| DatabaseImportError::Source(format!("Failed to connect: {}", e)))
+    }
+}
+
+/// Quote a value of a libpq connection string
+fn conninfo_value(value: &str) -> String {
+    format!("'{}'", value.replace('\\', "\\\\").replace('\'', "\\'"))
+}
+
+/// Where the data comes from
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+#[serde(tag = "type", rename_all = "snake_case")]
+pub enum ImportSource {
+    /// A running PostgreSQL database
+    Connection(SourceConnection),
+    /// A `pg_dump` file (plain or gzipped) in an S3 source
+    Dump { s3_source_id: i32, key: String },
+}
+
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct ImportDatabaseRequest {
+    /// Name of the managed service to create
+    #[schema(example = "main-db")]
+    pub name: String,
+    pub source: ImportSource,
+    /// Keep the service in sync with the source through logical replication until
+    /// cutover, instead of loading a one-off copy. Needs a connection source with
+    /// `wal_level = logical` and a user with the REPLICATION attribute.
+    #[serde(default)]
+    pub continuous_sync: bool,
+    /// PostgreSQL image of the managed service; defaults to the source's major version
+    #[serde(default)]
+    #[schema(example = "postgres:16-alpine")]
+    pub docker_image: Option<String>,
+    /// Other service parameters, as when creating a PostgreSQL service
+    #[serde(default)]
+    #[schema(value_type = Object)]
+    pub parameters: HashMap<String, serde_json::Value>,
+}
+
+/// Row counts of one table, in the source and in the managed service
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct TableVerification {
+    #[schema(example = "public.users")]
+    pub table: String,
+    /// Not known for imports from a dump
+    pub source_rows: Option<i64>,
+    /// `None` if the table is missing from the managed service
+    pub target_rows: Option<i64>,
+}
+
+/// Comparison of the imported data with the source
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct ImportVerification {
+    pub tables: Vec<TableVerification>,
+    pub missing_tables: Vec<String>,
+    pub row_count_mismatches: Vec<String>,
+    pub passed: bool,
+}
+
+/// Progress of a continuous sync
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ReplicationProgress {
+    /// Tables whose initial copy isn't finished
+    pub tables_copying: i64,
+    /// How far the managed service is behind the source, in bytes of WAL
+    pub lag_bytes: Option<i64>,
+}
+
+/// An import of an external database into a managed service
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct DatabaseImportInfo {
+    pub id: i32,
+    pub service_id: i32,
+    /// `dump` or `replication`
+    pub mode: String,
+    /// `running`, `syncing`, `cutting_over`, `completed` or `failed`
+    pub status: String,
+    pub source: String,
+    pub source_version: Option<String>,
+    pub target_version: Option<String>,
+    pub verification: Option<ImportVerification>,
+    /// Only while syncing
+    pub replication: Option<ReplicationProgress>,
+    pub error: Option<String>,
+    pub created_by: i32,
+    pub started_at: chrono::DateTime<Utc>,
+    pub finished_at: Option<chrono::DateTime<Utc>>,
+}
+
+impl From<database_imports::Model> for DatabaseImportInfo {
+    fn from(import: database_imports::Model) -> Self {
+        Self {
+            id: import.id,
+            service_id: import.service_id,
+            mode: import.mode,
+            status: import.status,
+            source: import.source_description,
+            source_version: import.source_version,
+            target_version: import.target_version,
+            verification: import
+                .verification
+                .and_then(|verification| serde_json::from_value(verification).ok()),
+            replication: None,
+            error: import.error,
+            created_by: import.created_by,
+            started_at: import.started_at,
+            finished_at: import.finished_at,
+        }
+    }
+}
+
+/// Check that a source can be imported into a managed service of `target_major`
+fn check_versions(
+    source_major: u32,
+    target_major: u32,
+    continuous_sync: bool,
+) -> Result<(), DatabaseImportError> {
+    if target_major < source_major {
+        return Err(DatabaseImportError::VersionMismatch(format!(
+            "The source runs PostgreSQL {} but the managed service would run PostgreSQL {}; \
+             data can't be moved to an older major version. Use a PostgreSQL {} or newer image.",
+            source_major,
+            target_major,
+            source_major.max(MIN_TARGET_MAJOR)
+        )));
+    }
+    if target_major < MIN_TARGET_MAJOR {
+        return Err(DatabaseImportError::VersionMismatch(format!(
+            "Managed services run PostgreSQL {} or newer, not {}",
+            MIN_TARGET_MAJOR, target_major
+        )));
+    }
+    if continuous_sync && source_major < MIN_REPLICATION_SOURCE_MAJOR {
+        return Err(DatabaseImportError::VersionMismatch(format!(
+            "Continuous sync needs logical replication, which PostgreSQL {} doesn't have; \
+             upgrade the source to PostgreSQL {} or newer, or import a one-off copy",
+            source_major, MIN_REPLICATION_SOURCE_MAJOR
+        )));
+    }
+    Ok(())
+}
+
+/// Image a source of `source_major` is imported into by default
+fn default_docker_image(source_major: u32) -> String {
+    format!("postgres:{}-alpine", source_major.max(MIN_TARGET_MAJOR))
+}
+
+/// Compare row counts of the managed service with those of the source, if known
+fn compare_tables(
+    source: Option<&[(String, i64)]>,
+    target: &[(String, i64)],
+) -> ImportVerification {
+    let mut tables: BTreeMap<&str, TableVerification> = BTreeMap::new();
+    for (table, rows) in target {
+        tables.insert(
+            table.as_str(),
+            TableVerification {
+                table: table.clone(),
+                source_rows: None,
+                target_rows: Some(*rows),
+            },
+        );
+    }
+    for (table, rows) in source.unwrap_or_default() {
+        tables
+            .entry(table.as_str())
+            .or_insert_with(|| TableVerification {
+                table: table.clone(),
+                source_rows: None,
+                target_rows: None,
+            })
+            .source_rows = Some(*rows);
+    }
+
+    let tables: Vec<TableVerification> = tables.into_values().collect();
+    let missing_tables: Vec<String> = tables
+        .iter()
+        .filter(|table| table.source_rows.is_some() && table.target_rows.is_none())
+        .map(|table| table.table.clone())
+        .collect();
+    let row_count_mismatches: Vec<String> = tables
+        .iter()
+        .filter(|table| {
+            matches!((table.source_rows, table.target_rows), (Some(source), Some(target)) if source != target)
+        })
+        .map(|table| table.table.clone())
+        .collect();
+    ImportVerification {
+        passed: missing_tables.is_empty() && row_count_mismatches.is_empty(),
+        tables,
+        missing_tables,
+        row_count_mismatches,
+    }
+}
+
+fn replication_name(import_id: i32) -> String {
+    format!("{}{}", REPLICATION_NAME_PREFIX, import_id)
+}
+
+/// Major and full version of the server behind `conn`
+async fn server_version(conn: &mut PgConnection) -> Result<(u32, String), sqlx::Error> {
+    let (version_num, version): (String, String) = sqlx::query_as(
+        "SELECT current_setting('server_version_num'), current_setting('server_version')",
+    )
+    .fetch_one(&mut *conn)
+    .await?;
+    let major = version_num.parse::<u32>().unwrap_or_default() / 10000;
+    Ok((major, version))
+}
+
+/// Exact row count of every user table
+async fn table_row_counts(conn: &mut PgConnection) -> Result<Vec<(String, i64)>, sqlx::Error> {
+    let tables: Vec<(String, String)> = sqlx::query_as(&format!(
+        "SELECT schemaname::text, tablename::text FROM pg_tables \
+         WHERE schemaname NOT IN {} ORDER BY 1, 2",
+        SYSTEM_SCHEMAS
+    ))
+    .fetch_all(&mut *conn)
+    .await?;
+
+    let mut counts = Vec::with_capacity(tables.len());
+    for (schema, table) in tables {
+        let (rows,): (i64,) = sqlx::query_as(&format!(
+            "SELECT count(*) FROM {}.{}",
+            quote_identifier(&schema),
+            quote_identifier(&table)
+        ))
+        .fetch_one(&mut *conn)
+        .await?;
+        counts.push((format!("{}.{}", schema, table), rows));
+    }
+    Ok(counts)
+}
+
+pub struct DatabaseImportService {
+    db: Arc<DatabaseConnection>,
+    external_service_manager: Arc<ExternalServiceManager>,
+    encryption_service: Arc<EncryptionService>,
+    docker: Arc<bollard::Docker>,
+}
+
+impl DatabaseImportService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        external_service_manager: Arc<ExternalServiceManager>,
+        encryption_service: Arc<EncryptionService>,
+        docker: Arc<bollard::Docker>,
+    ) -> Self {
+        Self {
+            db,
+            external_service_manager,
+            encryption_service,
+            docker,
+        }
+    }
+
+    async fn find_import(
+        &self,
+        service_id: i32,
+    ) -> Result<database_imports::Model, DatabaseImportError> {
+        database_imports::Entity::find()
+            .filter(database_imports::Column::ServiceId.eq(service_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or(DatabaseImportError::NotFound(service_id))
+    }
+
+    /// The import of a service, with replication progress while it's syncing
+    pub async fn get_import(
+        &self,
+        service_id: i32,
+    ) -> Result<DatabaseImportInfo, DatabaseImportError> {
+        let import = self.find_import(service_id).await?;
+        let syncing = import.status == database_imports::IMPORT_STATUS_SYNCING;
+        let mut info = DatabaseImportInfo::from(import.clone());
+        if syncing {
+            match self.replication_progress(&import).await {
+                Ok(progress) => info.replication = Some(progress),
+                Err(e) => debug!(
+                    "Failed to get replication progress of import {}: {}",
+                    import.id, e
+                ),
+            }
+        }
+        Ok(info)
+    }
+
+    /// Start importing a database into a new managed service
+    ///
+    /// The service is created right away; the data is loaded in the background and
+    /// the import record updated as it goes.
+    pub async fn start_import(
+        self: &Arc<Self>,
+        request: ImportDatabaseRequest,
+        user_id: i32,
+    ) -> Result<DatabaseImportInfo, DatabaseImportError> {
+        if request.name.trim().is_empty() {
+            return Err(DatabaseImportError::Validation(
+                "Service name is required".to_string(),
+            ));
+        }
+
+        let (source_description, source_major, source_version, docker_image) = match &request.source
+        {
+            ImportSource::Connection(source) => {
+                if source.host.trim().is_empty()
+                    || source.database.trim().is_empty()
+                    || source.username.trim().is_empty()
+                {
+                    return Err(DatabaseImportError::Validation(
+                        "Source host, database and username are required".to_string(),
+                    ));
+                }
+                let (major, version) = self.inspect_source(source, request.continuous_sync).await?;
+                let docker_image = request
+                    .docker_image
+                    .clone()
+                    .unwrap_or_else(|| default_docker_image(major));
+                (source.describe(), Some(major), Some(version), docker_image)
+            }
+            ImportSource::Dump { s3_source_id, key } => {
+                if request.continuous_sync {
+                    return Err(DatabaseImportError::Validation(
+                        "Continuous sync needs a connection to the source database, not a dump"
+                            .to_string(),
+                    ));
+                }
+                if key.trim().is_empty() {
+                    return Err(DatabaseImportError::Validation(
+                        "Dump key is required".to_string(),
+                    ));
+                }
+                let docker_image = request
+                    .docker_image
+                    .clone()
+                    .unwrap_or_else(|| DEFAULT_DOCKER_IMAGE.to_string());
+                (
+                    format!("S3 source {}: {}", s3_source_id, key),
+                    None,
+                    None,
+                    docker_image,
+                )
+            }
+        };
+
+        let target_major = PostgresService::extract_postgres_version(&docker_image)
+            .map_err(|e| DatabaseImportError::Validation(e.to_string()))?;
+        if let Some(source_major) = source_major {
+            check_versions(source_major, target_major, request.continuous_sync)?;
+        }
+
+        let mut parameters = request.parameters.clone();
+        parameters.insert(
+            "docker_image".to_string(),
+            serde_json::Value::String(docker_image),
+        );
+        let service = self
+            .external_service_manager
+            .create_service(CreateExternalServiceRequest {
+                name: request.name.clone(),
+                service_type: ServiceType::Postgres,
+                version: None,
+                parameters,
+            })
+            .await?;
+
+        let source_config = match &request.source {
+            ImportSource::Connection(source) => {
+                let json = serde_json::to_string(source).map_err(|e| {
+                    ExternalServiceError::InternalError {
+                        reason: format!("Failed to serialize source: {}", e),
+                    }
+                })?;
+                Some(self.encryption_service.encrypt_string(&json).map_err(|e| {
+                    ExternalServiceError::InternalError {
+                        reason: format!("Failed to encrypt source: {}", e),
+                    }
+                })?)
+            }
+            ImportSource::Dump { .. } => None,
+        };
+        let mode = if request.continuous_sync {
+            database_imports::IMPORT_MODE_REPLICATION
+        } else {
+            database_imports::IMPORT_MODE_DUMP
+        };
+        let import = database_imports::ActiveModel {
+            service_id: Set(service.id),
+            mode: Set(mode.to_string()),
+            status: Set(database_imports::IMPORT_STATUS_RUNNING.to_string()),
+            source_description: Set(source_description),
+            source_config: Set(source_config),
+            source_version: Set(source_version),
+            target_version: Set(Some(target_major.to_string())),
+            created_by: Set(user_id),
+            started_at: Set(Utc::now()),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        info!(
+            "Importing {} into service {} ({})",
+            import.source_description, service.id, mode
+        );
+        let this = self.clone();
+        let record = import.clone();
+        let source = request.source.clone();
+        tokio::spawn(async move {
+            this.run_import(record, source).await;
+        });
+
+        Ok(import.into())
+    }
+
+    /// Check the source can be reached and imported, returning its version
+    async fn inspect_source(
+        &self,
+        source: &SourceConnection,
+        continuous_sync: bool,
+    ) -> Result<(u32, String), DatabaseImportError> {
+        let mut conn = source.connect().await?;
+        let (major, version) = server_version(&mut conn)
+            .await
+            .map_err(|e| DatabaseImportError::Source(format!("Failed to read version: {}", e)))?;
+        if continuous_sync {
+            let (wal_level,): (String,) = sqlx::query_as("SELECT current_setting('wal_level')")
+                .fetch_one(&mut conn)
+                .await
+                .map_err(|e| DatabaseImportError::Source(e.to_string()))?;
+            if wal_level != "logical" && major >= MIN_REPLICATION_SOURCE_MAJOR {
+                return Err(DatabaseImportError::Validation(format!(
+                    "Continuous sync needs wal_level = logical on the source (it is '{}'); \
+                     change it and restart the source, or import a one-off copy",
+                    wal_level
+                )));
+            }
+        }
+        let _ = conn.close().await;
+        Ok((major, version))
+    }
+
+    async fn run_import(&self, import: database_imports::Model, source: ImportSource) {
+        let result = match &source {
+            ImportSource::Connection(source)
+                if import.mode == database_imports::IMPORT_MODE_REPLICATION =>
+            {
+                self.start_replication(&import, source).await
+            }
+            ImportSource::Connection(source) => self.copy_database(&import, source).await,
+            ImportSource::Dump { s3_source_id, key } => {
+                self.load_dump(&import, *s3_source_id, key).await
+            }
+        };
+        if let Err(e) = result {
+            error!(
+                "Import {} into service {} failed: {}",
+                import.id, import.service_id, e
+            );
+            if let Err(e) = self.fail_import(import.id, &e.to_string()).await {
+                error!("Failed to record failure of import {}: {}", import.id, e);
+            }
+        }
+    }
+
+    /// One-off copy from a running database, verified against it
+    async fn copy_database(
+        &self,
+        import: &database_imports::Model,
+        source: &SourceConnection,
+    ) -> anyhow::Result<()> {
+        self.pipe_dump(import.service_id, source, false).await?;
+        let mut source_conn = source.connect().await?;
+        let source_counts = table_row_counts(&mut source_conn).await?;
+        let _ = source_conn.close().await;
+        self.finish_import(import, Some(&source_counts)).await
+    }
+
+    /// One-off load of a dump file; there's no source to compare with, so only the
+    /// loaded tables are recorded
+    async fn load_dump(
+        &self,
+        import: &database_imports::Model,
+        s3_source_id: i32,
+        key: &str,
+    ) -> anyhow::Result<()> {
+        self.external_service_manager
+            .restore_dump(import.service_id, s3_source_id, key)
+            .await?;
+        self.finish_import(import, None).await
+    }
+
+    /// Copy the schema and subscribe the managed service to the source's tables
+    async fn start_replication(
+        &self,
+        import: &database_imports::Model,
+        source: &SourceConnection,
+    ) -> anyhow::Result<()> {
+        self.pipe_dump(import.service_id, source, true).await?;
+
+        let name = replication_name(import.id);
+        let mut source_conn = source.connect().await?;
+        source_conn
+            .execute(
+                format!(
+                    "CREATE PUBLICATION {} FOR ALL TABLES",
+                    quote_identifier(&name)
+                )
+                .as_str(),
+            )
+            .await
+            .map_err(|e| anyhow::anyhow!("Failed to create publication on the source: {}", e))?;
+        let _ = source_conn.close().await;
+
+        // The subscription connects from the managed service's container
+        let mut target = self.target_connection(import.service_id).await?;
+        let subscribed = target
+            .execute(
+                format!(
+                    "CREATE SUBSCRIPTION {} CONNECTION {} PUBLICATION {}",
+                    quote_identifier(&name),
+                    quote_literal(&source.conninfo()),
+                    quote_identifier(&name)
+                )
+                .as_str(),
+            )
+            .await;
+        if let Err(e) = subscribed {
+            self.drop_publication(source, &name).await;
+            return Err(anyhow::anyhow!("Failed to subscribe to the source: {}", e));
+        }
+
+        self.set_status(import.id, database_imports::IMPORT_STATUS_SYNCING, None)
+            .await?;
+        info!(
+            "Import {} is syncing from {}",
+            import.id, import.source_description
+        );
+        Ok(())
+    }
+
+    /// Dump the source from inside the managed service's container and load it
+    async fn pipe_dump(
+        &self,
+        service_id: i32,
+        source: &SourceConnection,
+        schema_only: bool,
+    ) -> anyhow::Result<()> {
+        let service = self
+            .external_service_manager
+            .get_service_config(service_id)
+            .await?;
+        let config: PostgresInputConfig = serde_json::from_value(service.parameters.clone())?;
+        let container_name = self
+            .external_service_manager
+            .get_service_instance(service.name, ServiceType::Postgres)
+            .container_name();
+        let script = format!(
+            "set -eo pipefail\n\
+             pg_dump --no-owner --no-privileges --no-publications --no-subscriptions{} --dbname \"$SOURCE_DSN\" \
+             | psql -q -v ON_ERROR_STOP=1 -U \"$TARGET_USER\" -d \"$TARGET_DB\"\n",
+            if schema_only { " --schema-only" } else { "" }
+        );
+        let output = crate::utils::run_script_in_container(
+            &self.docker,
+            &container_name,
+            "import.sh",
+            &script,
+            vec!["sh".to_string(), "/tmp/import.sh".to_string()],
+            vec![
+                format!("SOURCE_DSN={}", source.conninfo()),
+                format!("TARGET_USER={}", config.username),
+                format!("TARGET_DB={}", config.database),
+                format!("PGPASSWORD={}", config.password.unwrap_or_default()),
+            ],
+        )
+        .await?;
+        debug!("Import into service {}: {}", service_id, output);
+        Ok(())
+    }
+
+    /// Connect to the managed service
+    async fn target_connection(&self, service_id: i32) -> anyhow::Result<PgConnection> {
+        let service = self
+            .external_service_manager
+            .get_service_config(service_id)
+            .await?;
+        let config: PostgresInputConfig = serde_json::from_value(service.parameters)?;
+        let port = config.port.as_deref().unwrap_or("5432").parse::<u16>()?;
+        let mut options = PgConnectOptions::new()
+            .host(&config.host)
+            .port(port)
+            .username(&config.username)
+            .database(&config.database)
+            .application_name("temps-database-import");
+        if let Some(password) = &config.password {
+            options = options.password(password);
+        }
+        Ok(PgConnection::connect_with(&options).await?)
+    }
+
+    fn source_connection(
+        &self,
+        import: &database_imports::Model,
+    ) -> Result<SourceConnection, DatabaseImportError> {
+        let encrypted = import.source_config.as_deref().ok_or_else(|| {
+            DatabaseImportError::InvalidState(
+                "The source connection of this import is no longer stored".to_string(),
+            )
+        })?;
+        let json = self
+            .encryption_service
+            .decrypt_string(encrypted)
+            .map_err(|e| ExternalServiceError::InternalError {
+                reason: format!("Failed to decrypt source: {}", e),
+            })?;
+        serde_json::from_str(&json).map_err(|e| {
+            ExternalServiceError::InternalError {
+                reason: format!("Invalid source: {}", e),
+            }
+            .into()
+        })
+    }
+
+    async fn replication_progress(
+        &self,
+        import: &database_imports::Model,
+    ) -> anyhow::Result<ReplicationProgress> {
+        let name = replication_name(import.id);
+        let mut target = self.target_connection(import.service_id).await?;
+        let (tables_copying,): (i64,) = sqlx::query_as(
+            "SELECT count(*) FROM pg_subscription_rel r \
+             JOIN pg_subscription s ON s.oid = r.srsubid \
+             WHERE s.subname = $1 AND r.srsubstate <> 'r'",
+        )
+        .bind(&name)
+        .fetch_one(&mut target)
+        .await?;
+        let received: Option<(Option<String>,)> = sqlx::query_as(
+            "SELECT latest_end_lsn::text FROM pg_stat_subscription \
+             WHERE subname = $1 AND relid IS NULL",
+        )
+        .bind(&name)
+        .fetch_optional(&mut target)
+        .await?;
+        let _ = target.close().await;
+
+        let lag_bytes = match received.and_then(|(lsn,)| lsn) {
+            Some(lsn) => {
+                let source = self.source_connection(import)?;
+                let mut source_conn = source.connect().await?;
+                let (lag,): (Option<i64>,) = sqlx::query_as(
+                    "SELECT pg_wal_lsn_diff(pg_current_wal_lsn(), $1::pg_lsn)::bigint",
+                )
+                .bind(lsn)
+                .fetch_one(&mut source_conn)
+                .await?;
+                let _ = source_conn.close().await;
+                lag.map(|lag| lag.max(0))
+            }
+            None => None,
+        };
+        Ok(ReplicationProgress {
+            tables_copying,
+            lag_bytes,
+        })
+    }
+
+    /// Switch a syncing import over to the managed service
+    ///
+    /// Writes to the source should be stopped first: cutover waits for the service
+    /// to catch up, copies sequence values, ends replication and verifies the data.
+    /// It runs in the background; the import is returned right away.
+    pub async fn cutover(
+        self: &Arc<Self>,
+        service_id: i32,
+    ) -> Result<DatabaseImportInfo, DatabaseImportError> {
+        let import = self.find_import(service_id).await?;
+        if import.status != database_imports::IMPORT_STATUS_SYNCING {
+            return Err(DatabaseImportError::InvalidState(format!(
+                "Only a syncing import can be cut over; this one is {}",
+                import.status
+            )));
+        }
+        let progress = self
+            .replication_progress(&import)
+            .await
+            .map_err(|e| DatabaseImportError::Source(e.to_string()))?;
+        if progress.tables_copying > 0 {
+            return Err(DatabaseImportError::InvalidState(format!(
+                "{} tables are still being copied; cut over once the initial copy is done",
+                progress.tables_copying
+            )));
+        }
+
+        let import = self
+            .set_status(
+                import.id,
+                database_imports::IMPORT_STATUS_CUTTING_OVER,
+                None,
+            )
+            .await?;
+        let this = self.clone();
+        let record = import.clone();
+        tokio::spawn(async move {
+            if let Err(e) = this.run_cutover(&record).await {
+                error!("Cutover of import {} failed: {}", record.id, e);
+                // Replication is still in place, so the import can be cut over again
+                let message = format!("Cutover failed: {}", e);
+                if let Err(e) = this
+                    .set_status(
+                        record.id,
+                        database_imports::IMPORT_STATUS_SYNCING,
+                        Some(message),
+                    )
+                    .await
+                {
+                    error!("Failed to record failure of import {}: {}", record.id, e);
+                }
+            }
+        });
+        Ok(import.into())
+    }
+
+    async fn run_cutover(&self, import: &database_imports::Model) -> anyhow::Result<()> {
+        let deadline =
+            tokio::time::Instant::now() + Duration::from_secs(CUTOVER_CATCH_UP_TIMEOUT_SECS);
+        loop {
+            let progress = self.replication_progress(import).await?;
+            if progress.lag_bytes == Some(0) {
+                break;
+            }
+            if tokio::time::Instant::now() >= deadline {
+                anyhow::bail!(
+                    "the managed service didn't catch up with the source within {}s \
+                     ({} bytes behind); stop writes to the source and try again",
+                    CUTOVER_CATCH_UP_TIMEOUT_SECS,
+                    progress
+                        .lag_bytes
+                        .map_or("unknown".to_string(), |lag| lag.to_string())
+                );
+            }
+            tokio::time::sleep(Duration::from_secs(2)).await;
+        }
+
+        // Sequences aren't replicated; without this, inserts would reuse ids
+        let source = self.source_connection(import)?;
+        let mut source_conn = source.connect().await?;
+        let sequences: Vec<(String, String, i64)> = sqlx::query_as(&format!(
+            "SELECT schemaname::text, sequencename::text, last_value FROM pg_sequences \
+             WHERE last_value IS NOT NULL AND schemaname NOT IN {}",
+            SYSTEM_SCHEMAS
+        ))
+        .fetch_all(&mut source_conn)
+        .await?;
+        let mut target = self.target_connection(import.service_id).await?;
+        for (schema, sequence, last_value) in &sequences {
+            sqlx::query("SELECT setval($1::regclass, $2)")
+                .bind(format!(
+                    "{}.{}",
+                    quote_identifier(schema),
+                    quote_identifier(sequence)
+                ))
+                .bind(last_value)
+                .execute(&mut target)
+                .await?;
+        }
+
+        let name = replication_name(import.id);
+        target
+            .execute(format!("DROP SUBSCRIPTION {}", quote_identifier(&name)).as_str())
+            .await?;
+        let _ = target.close().await;
+        self.drop_publication(&source, &name).await;
+        info!(
+            "Import {} cut over after copying {} sequences",
+            import.id,
+            sequences.len()
+        );
+
+        // Replication has ended, so from here on a failure fails the import
+        let verified = async {
+            let source_counts = table_row_counts(&mut source_conn).await?;
+            let _ = source_conn.close().await;
+            self.finish_import(import, Some(&source_counts)).await
+        }
+        .await;
+        if let Err(e) = verified {
+            self.fail_import(
+                import.id,
+                &format!("Replication ended but verification failed: {}", e),
+            )
+            .await?;
+        }
+        Ok(())
+    }
+
+    /// Stop a syncing import, ending replication and leaving the data as it is
+    pub async fn abort(&self, service_id: i32) -> Result<DatabaseImportInfo, DatabaseImportError> {
+        let import = self.find_import(service_id).await?;
+        if import.status != database_imports::IMPORT_STATUS_SYNCING {
+            return Err(DatabaseImportError::InvalidState(format!(
+                "Only a syncing import can be stopped; this one is {}",
+                import.status
+            )));
+        }
+
+        let name = replication_name(import.id);
+        let source = self.source_connection(&import)?;
+        let mut message = "Stopped before cutover".to_string();
+        match self.target_connection(service_id).await {
+            Ok(mut target) => {
+                let dropped = target
+                    .execute(
+                        format!("DROP SUBSCRIPTION IF EXISTS {}", quote_identifier(&name)).as_str(),
+                    )
+                    .await;
+                if let Err(e) = dropped {
+                    // The source can't be reached to drop its replication slot; detach
+                    // the subscription from it so it can still be dropped here
+                    warn!("Failed to drop subscription of import {}: {}", import.id, e);
+                    let subscription = quote_identifier(&name);
+                    let mut detached = Ok(());
+                    for statement in [
+                        format!("ALTER SUBSCRIPTION {} DISABLE", subscription),
+                        format!("ALTER SUBSCRIPTION {} SET (slot_name = NONE)", subscription),
+                        format!("DROP SUBSCRIPTION {}", subscription),
+                    ] {
+                        detached = target.execute(statement.as_str()).await.map(|_| ());
+                        if detached.is_err() {
+                            break;
+                        }
+                    }
+                    if let Err(e) = detached {
+                        error!(
+                            "Failed to detach subscription of import {}: {}",
+                            import.id, e
+                        );
+                    }
+                    message = format!(
+                        "{}; replication slot {} may be left on the source and should be dropped there",
+                        message, name
+                    );
+                }
+                let _ = target.close().await;
+            }
+            Err(e) => {
+                error!("Failed to connect to service {}: {}", service_id, e);
+                message = format!(
+                    "{}; subscription {} may be left on the managed service",
+                    message, name
+                );
+            }
+        }
+        self.drop_publication(&source, &name).await;
+
+        let import = self
+            .update_import(
+                &import,
+                database_imports::IMPORT_STATUS_FAILED,
+                None,
+                Some(message),
+            )
+            .await?;
+        Ok(import.into())
+    }
+
+    async fn drop_publication(&self, source: &SourceConnection, name: &str) {
+        let result = async {
+            let mut conn = source.connect().await?;
+            conn.execute(format!("DROP PUBLICATION IF EXISTS {}", quote_identifier(name)).as_str())
+                .await
+                .map_err(|e| DatabaseImportError::Source(e.to_string()))?;
+            let _ = conn.close().await;
+            Ok::<_, DatabaseImportError>(())
+        }
+        .await;
+        if let Err(e) = result {
+            warn!("Failed to drop publication {} on the source: {}", name, e);
+        }
+    }
+
+    /// Verify the managed service's data and finish the import with the result
+    async fn finish_import(
+        &self,
+        import: &database_imports::Model,
+        source_counts: Option<&[(String, i64)]>,
+    ) -> anyhow::Result<()> {
+        let mut target = self.target_connection(import.service_id).await?;
+        let target_counts = table_row_counts(&mut target).await?;
+        let _ = target.close().await;
+
+        let verification = compare_tables(source_counts, &target_counts);
+        let (status, error) = if verification.passed {
+            (database_imports::IMPORT_STATUS_COMPLETED, None)
+        } else {
+            (
+                database_imports::IMPORT_STATUS_FAILED,
+                Some(format!(
+                    "Verification failed: {} tables missing, {} with a different row count",
+                    verification.missing_tables.len(),
+                    verification.row_count_mismatches.len()
+                )),
+            )
+        };
+        info!(
+            "Import {} into service {} finished: {} ({} tables)",
+            import.id,
+            import.service_id,
+            status,
+            verification.tables.len()
+        );
+        self.update_import(import, status, Some(verification), error)
+            .await?;
+        Ok(())
+    }
+
+    async fn set_status(
+        &self,
+        import_id: i32,
+        status: &str,
+        error: Option<String>,
+    ) -> Result<database_imports::Model, DatabaseImportError> {
+        let import = database_imports::Entity::find_by_id(import_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or(DatabaseImportError::NotFound(import_id))?;
+        let mut update: database_imports::ActiveModel = import.into();
+        update.status = Set(status.to_string());
+        update.error = Set(error);
+        Ok(update.update(self.db.as_ref()).await?)
+    }
+
+    async fn fail_import(&self, import_id: i32, error: &str) -> Result<(), DatabaseImportError> {
+        let import = database_imports::Entity::find_by_id(import_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or(DatabaseImportError::NotFound(import_id))?;
+        self.update_import(
+            &import,
+            database_imports::IMPORT_STATUS_FAILED,
+            None,
+            Some(error.to_string()),
+        )
+        .await?;
+        Ok(())
+    }
+
+    /// Finish an import; the source credentials are no longer needed and are dropped
+    async fn update_import(
+        &self,
+        import: &database_imports::Model,
+        status: &str,
+        verification: Option<ImportVerification>,
+        error: Option<String>,
+    ) -> Result<database_imports::Model, DatabaseImportError> {
+        let mut update: database_imports::ActiveModel = import.clone().into();
+        update.status = Set(status.to_string());
+        update.source_config = Set(None);
+        if let Some(verification) = verification {
+            update.verification = Set(serde_json::to_value(verification).ok());
+        }
+        update.error = Set(error);
+        update.finished_at = Set(Some(Utc::now()));
+        Ok(update.update(self.db.as_ref()).await?)
+    }
+
+    /// Fail the imports interrupted by a restart
+    ///
+    /// A sync survives restarts, since replication runs in the databases; an
+    /// interrupted cutover is back to syncing and can be retried.
+    pub async fn recover_interrupted_imports(&self) -> Result<(), DatabaseImportError> {
+        let interrupted = database_imports::Entity::find()
+            .filter(database_imports::Column::Status.is_in([
+                database_imports::IMPORT_STATUS_RUNNING,
+                database_imports::IMPORT_STATUS_CUTTING_OVER,
+            ]))
+            .all(self.db.as_ref())
+            .await?;
+        for import in interrupted {
+            warn!("Import {} was interrupted by a restart", import.id);
+            if import.status == database_imports::IMPORT_STATUS_CUTTING_OVER {
+                self.set_status(
+                    import.id,
+                    database_imports::IMPORT_STATUS_SYNCING,
+                    Some("Cutover was interrupted by a restart".to_string()),
+                )
+                .await?;
+            } else {
+                self.update_import(
+                    &import,
+                    database_imports::IMPORT_STATUS_FAILED,
+                    None,
+                    Some("Interrupted by a restart".to_string()),
+                )
+                .await?;
+            }
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_check_versions() {
+        assert!(check_versions(16, 16, true).is_ok());
+        assert!(check_versions(14, 17, false).is_ok());
+        assert!(matches!(
+            check_versions(17, 16, false),
+            Err(DatabaseImportError::VersionMismatch(_))
+        ));
+        // Old sources can be copied but not synced
+        assert!(check_versions(9, 13, false).is_ok());
+        assert!(check_versions(9, 13, true).is_err());
+        assert!(check_versions(11, 12, false).is_err());
+        assert_eq!(default_docker_image(16), "postgres:16-alpine");
+        assert_eq!(default_docker_image(9), "postgres:13-alpine");
+    }
+
+    #[test]
+    fn test_compare_tables() {
+        let source = vec![
+            ("public.orders".to_string(), 120),
+            ("public.users".to_string(), 10),
+            ("billing.invoices".to_string(), 4),
+        ];
+        let target = vec![
+            ("public.orders".to_string(), 118),
+            ("public.users".to_string(), 10),
+        ];
+        let verification = compare_tables(Some(&source), &target);
+        assert!(!verification.passed);
+        assert_eq!(verification.missing_tables, vec!["billing.invoices"]);
+        assert_eq!(verification.row_count_mismatches, vec!["public.orders"]);
+        assert_eq!(verification.tables.len(), 3);
+
+        let verification = compare_tables(Some(&target), &target);
+        assert!(verification.passed);
+
+        // Without a source, the loaded tables are only listed
+        let verification = compare_tables(None, &target);
+        assert!(verification.passed);
+        assert_eq!(verification.tables[0].source_rows, None);
+        assert_eq!(verification.tables[0].target_rows, Some(118));
+    }
+
+    #[test]
+    fn test_conninfo_quotes_values() {
+        let source = SourceConnection {
+            host: "db.example.com".to_string(),
+            port: 5433,
+            database: "app".to_string(),
+            username: "import".to_string(),
+            password: Some("it's a \\secret".to_string()),
+            ssl_mode: Some("require".to_string()),
+        };
+        assert_eq!(
+            source.conninfo(),
+            "host='db.example.com' port=5433 dbname='app' user='import' \
+             password='it\\'s a \\\\secret' sslmode='require'"
+        );
+        assert_eq!(
+            source.describe(),
+            "postgres://import@db.example.com:5433/app"
+        );
+    }
+}
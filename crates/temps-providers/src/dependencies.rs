@@ -0,0 +1,434 @@
+//! Dependency ordering between managed services
+//!
+//! Services can declare that they depend on other services. When a set of services is
+//! started, dependencies come up first and dependents only start once every service
+//! they depend on has started and accepts connections, as checked by its readiness
+//! probe. Services that don't depend on each other start in parallel. Stopping happens
+//! in the reverse order.
+
+use std::collections::{BTreeSet, HashMap, HashSet};
+use std::sync::Arc;
+use std::time::Duration;
+
+use futures::future::join_all;
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, Set,
+    TransactionTrait,
+};
+use serde::Serialize;
+use temps_core::ServiceReadinessSettings;
+use temps_entities::{external_services, service_dependencies};
+use thiserror::Error;
+use tracing::{debug, info, warn};
+use utoipa::ToSchema;
+
+use crate::readiness::ServiceReadiness;
+use crate::services::{ExternalServiceError, ExternalServiceManager};
+
+#[derive(Error, Debug)]
+pub enum DependencyError {
+    #[error("Dependency cycle involving services {0:?}")]
+    Cycle(Vec<i32>),
+
+    #[error("{0}")]
+    Validation(String),
+
+    #[error("Service {id} did not accept connections within {timeout_secs}s")]
+    Unhealthy { id: i32, timeout_secs: u64 },
+
+    #[error(transparent)]
+    Service(#[from] ExternalServiceError),
+}
+
+impl From<sea_orm::DbErr> for DependencyError {
+    fn from(err: sea_orm::DbErr) -> Self {
+        DependencyError::Service(err.into())
+    }
+}
+
+/// A service another service depends on
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ServiceDependencyInfo {
+    pub service_id: i32,
+    pub name: String,
+    pub service_type: String,
+}
+
+/// Group services into start layers
+///
+/// Every service is placed in a later layer than all of its dependencies, so services
+/// within a layer can start in parallel. `dependencies` are `(service, depends_on)` pairs;
+/// pairs referring to services outside `services` are ignored.
+pub fn start_layers(
+    services: &[i32],
+    dependencies: &[(i32, i32)],
+) -> Result<Vec<Vec<i32>>, DependencyError> {
+    let nodes: BTreeSet<i32> = services.iter().copied().collect();
+
+    let mut pending: HashMap<i32, HashSet<i32>> =
+        nodes.iter().map(|id| (*id, HashSet::new())).collect();
+    for (service, depends_on) in dependencies {
+        if service != depends_on && nodes.contains(service) && nodes.contains(depends_on) {
+            if let Some(deps) = pending.get_mut(service) {
+                deps.insert(*depends_on);
+            }
+        }
+    }
+
+    let mut layers = Vec::new();
+    let mut remaining = nodes;
+    while !remaining.is_empty() {
+        let layer: Vec<i32> = remaining
+            .iter()
+            .copied()
+            .filter(|id| pending[id].is_empty())
+            .collect();
+
+        if layer.is_empty() {
+            return Err(DependencyError::Cycle(remaining.into_iter().collect()));
+        }
+
+        for id in &layer {
+            remaining.remove(id);
+        }
+        for id in &remaining {
+            if let Some(deps) = pending.get_mut(id) {
+                for started in &layer {
+                    deps.remove(started);
+                }
+            }
+        }
+        layers.push(layer);
+    }
+
+    Ok(layers)
+}
+
+/// Expand a set of services with everything they transitively depend on
+pub fn with_transitive_dependencies(services: &[i32], dependencies: &[(i32, i32)]) -> Vec<i32> {
+    let mut result: BTreeSet<i32> = services.iter().copied().collect();
+    let mut queue: Vec<i32> = services.to_vec();
+
+    while let Some(id) = queue.pop() {
+        for (service, depends_on) in dependencies {
+            if *service == id && result.insert(*depends_on) {
+                queue.push(*depends_on);
+            }
+        }
+    }
+
+    result.into_iter().collect()
+}
+
+/// Manages declared dependencies and starts/stops services in dependency order
+pub struct ServiceDependencyManager {
+    db: Arc<DatabaseConnection>,
+    external_service_manager: Arc<ExternalServiceManager>,
+    config_service: Arc<temps_config::ConfigService>,
+}
+
+impl ServiceDependencyManager {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        external_service_manager: Arc<ExternalServiceManager>,
+        config_service: Arc<temps_config::ConfigService>,
+    ) -> Self {
+        Self {
+            db,
+            external_service_manager,
+            config_service,
+        }
+    }
+
+    async fn readiness_settings(&self) -> ServiceReadinessSettings {
+        match self.config_service.get_settings().await {
+            Ok(settings) => settings.service_readiness,
+            Err(e) => {
+                warn!("Failed to load service readiness settings: {}", e);
+                ServiceReadinessSettings::default()
+            }
+        }
+    }
+
+    async fn all_dependencies(&self) -> Result<Vec<(i32, i32)>, DependencyError> {
+        Ok(service_dependencies::Entity::find()
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|dep| (dep.service_id, dep.depends_on_service_id))
+            .collect())
+    }
+
+    /// List the services a service depends on
+    pub async fn list_dependencies(
+        &self,
+        service_id: i32,
+    ) -> Result<Vec<ServiceDependencyInfo>, DependencyError> {
+        external_services::Entity::find_by_id(service_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or(ExternalServiceError::ServiceNotFound { id: service_id })?;
+
+        let dependencies = service_dependencies::Entity::find()
+            .filter(service_dependencies::Column::ServiceId.eq(service_id))
+            .find_also_related(external_services::Entity)
+            .all(self.db.as_ref())
+            .await?;
+
+        Ok(dependencies
+            .into_iter()
+            .filter_map(|(_, service)| service)
+            .map(|service| ServiceDependencyInfo {
+                service_id: service.id,
+                name: service.name,
+                service_type: service.service_type,
+            })
+            .collect())
+    }
+
+    /// Replace the services a service depends on
+    ///
+    /// Rejects unknown services, self-dependencies and changes that would introduce a cycle.
+    pub async fn set_dependencies(
+        &self,
+        service_id: i32,
+        depends_on: Vec<i32>,
+    ) -> Result<Vec<ServiceDependencyInfo>, DependencyError> {
+        let depends_on: Vec<i32> = depends_on
+            .into_iter()
+            .collect::<BTreeSet<_>>()
+            .into_iter()
+            .collect();
+
+        if depends_on.contains(&service_id) {
+            return Err(DependencyError::Validation(
+                "A service cannot depend on itself".to_string(),
+            ));
+        }
+
+        let mut all_ids = depends_on.clone();
+        all_ids.push(service_id);
+        let existing: HashSet<i32> = external_services::Entity::find()
+            .filter(external_services::Column::Id.is_in(all_ids.clone()))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|service| service.id)
+            .collect();
+        if let Some(missing) = all_ids.iter().find(|id| !existing.contains(id)) {
+            return Err(ExternalServiceError::ServiceNotFound { id: *missing }.into());
+        }
+
+        // Check the graph with the new edges for cycles before saving
+        let mut dependencies: Vec<(i32, i32)> = self
+            .all_dependencies()
+            .await?
+            .into_iter()
+            .filter(|(service, _)| *service != service_id)
+            .collect();
+        dependencies.extend(depends_on.iter().map(|dep| (service_id, *dep)));
+        let mut nodes: BTreeSet<i32> = BTreeSet::new();
+        for (service, dep) in &dependencies {
+            nodes.insert(*service);
+            nodes.insert(*dep);
+        }
+        start_layers(&nodes.into_iter().collect::<Vec<_>>(), &dependencies)?;
+
+        let txn = self.db.begin().await?;
+        service_dependencies::Entity::delete_many()
+            .filter(service_dependencies::Column::ServiceId.eq(service_id))
+            .exec(&txn)
+            .await?;
+        for dep in &depends_on {
+            service_dependencies::ActiveModel {
+                service_id: Set(service_id),
+                depends_on_service_id: Set(*dep),
+                ..Default::default()
+            }
+            .insert(&txn)
+            .await?;
+        }
+        txn.commit().await?;
+
+        info!(
+            "Service {} now depends on services {:?}",
+            service_id, depends_on
+        );
+        self.list_dependencies(service_id).await
+    }
+
+    /// Layers to start services and everything they depend on in, and the
+    /// `(service, depends_on)` pairs between them
+    pub async fn start_order(
+        &self,
+        service_ids: &[i32],
+    ) -> Result<(Vec<Vec<i32>>, Vec<(i32, i32)>), DependencyError> {
+        let dependencies = self.all_dependencies().await?;
+        let services = with_transitive_dependencies(service_ids, &dependencies);
+        let layers = start_layers(&services, &dependencies)?;
+        let dependencies = dependencies
+            .into_iter()
+            .filter(|(service, depends_on)| {
+                services.contains(service) && services.contains(depends_on)
+            })
+            .collect();
+        Ok((layers, dependencies))
+    }
+
+    /// Layers to stop services in, dependents first
+    pub async fn stop_order(&self, service_ids: &[i32]) -> Result<Vec<Vec<i32>>, DependencyError> {
+        let dependencies = self.all_dependencies().await?;
+        let mut layers = start_layers(service_ids, &dependencies)?;
+        layers.reverse();
+        Ok(layers)
+    }
+
+    /// Whether a service accepts connections
+    pub async fn readiness(&self, service_id: i32) -> Result<ServiceReadiness, DependencyError> {
+        Ok(self
+            .external_service_manager
+            .check_readiness(service_id)
+            .await?)
+    }
+
+    /// Probe a started service until it accepts connections
+    async fn wait_until_ready(
+        &self,
+        service_id: i32,
+        interval: Duration,
+    ) -> Result<(), DependencyError> {
+        loop {
+            let readiness = self.readiness(service_id).await?;
+            if readiness.ready {
+                info!("Service {} is ready ({})", service_id, readiness.probe);
+                return Ok(());
+            }
+            debug!(
+                "Service {} isn't ready yet: {}",
+                service_id,
+                readiness.detail.unwrap_or_default()
+            );
+            tokio::time::sleep(interval).await;
+        }
+    }
+
+    /// Start one service, waiting until it accepts connections
+    pub async fn start_service(&self, service_id: i32) -> Result<(), DependencyError> {
+        let settings = self.readiness_settings().await;
+        let start = async {
+            // Services hosted elsewhere can't be started, only waited for
+            if self.external_service_manager.is_managed(service_id).await? {
+                self.external_service_manager
+                    .start_service(service_id)
+                    .await?;
+            }
+            self.wait_until_ready(service_id, settings.interval()).await
+        };
+        match tokio::time::timeout(settings.timeout(), start).await {
+            Ok(result) => result,
+            Err(_) => Err(DependencyError::Unhealthy {
+                id: service_id,
+                timeout_secs: settings.timeout().as_secs(),
+            }),
+        }
+    }
+
+    /// Stop one service; services hosted outside of Temps are left alone
+    pub async fn stop_service(&self, service_id: i32) -> Result<(), DependencyError> {
+        if !self.external_service_manager.is_managed(service_id).await? {
+            return Ok(());
+        }
+        self.external_service_manager
+            .stop_service(service_id)
+            .await
+            .map(|_| ())
+            .map_err(DependencyError::from)
+    }
+
+    /// Start services and everything they depend on, in dependency order
+    ///
+    /// Each layer starts in parallel. A service only counts as started once it accepts
+    /// connections, so a layer is complete when all of its services are ready. Returns
+    /// the layers that were started.
+    pub async fn start_services(
+        &self,
+        service_ids: &[i32],
+    ) -> Result<Vec<Vec<i32>>, DependencyError> {
+        let (layers, _) = self.start_order(service_ids).await?;
+
+        for layer in &layers {
+            info!("Starting services {:?}", layer);
+            let results = join_all(layer.iter().map(|id| self.start_service(*id))).await;
+
+            // Dependents must not start if any of their dependencies failed
+            if let Some(err) = results.into_iter().find_map(Result::err) {
+                return Err(err);
+            }
+        }
+
+        Ok(layers)
+    }
+
+    /// Stop services in reverse dependency order, dependents first
+    ///
+    /// Only the given services are stopped; their dependencies are left running.
+    /// Returns the layers in the order they were stopped.
+    pub async fn stop_services(
+        &self,
+        service_ids: &[i32],
+    ) -> Result<Vec<Vec<i32>>, DependencyError> {
+        let layers = self.stop_order(service_ids).await?;
+
+        for layer in &layers {
+            info!("Stopping services {:?}", layer);
+            let results = join_all(layer.iter().map(|id| self.stop_service(*id))).await;
+
+            for (id, result) in layer.iter().zip(results) {
+                if let Err(e) = result {
+                    warn!("Failed to stop service {}: {}", id, e);
+                }
+            }
+        }
+
+        Ok(layers)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_independent_services_share_a_layer() {
+        let layers = start_layers(&[3, 1, 2], &[]).unwrap();
+        assert_eq!(layers, vec![vec![1, 2, 3]]);
+    }
+
+    #[test]
+    fn test_dependencies_start_first() {
+        // 3 depends on 1 and 2, 4 depends on 3
+        let layers = start_layers(&[1, 2, 3, 4], &[(3, 1), (3, 2), (4, 3)]).unwrap();
+        assert_eq!(layers, vec![vec![1, 2], vec![3], vec![4]]);
+    }
+
+    #[test]
+    fn test_dependencies_outside_the_set_are_ignored() {
+        let layers = start_layers(&[1, 2], &[(2, 1), (1, 99)]).unwrap();
+        assert_eq!(layers, vec![vec![1], vec![2]]);
+    }
+
+    #[test]
+    fn test_cycle_is_detected() {
+        let err = start_layers(&[1, 2, 3, 4], &[(1, 2), (2, 3), (3, 1), (4, 1)]).unwrap_err();
+        match err {
+            DependencyError::Cycle(ids) => assert_eq!(ids, vec![1, 2, 3, 4]),
+            other => panic!("expected cycle, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_transitive_dependencies_are_included() {
+        let services = with_transitive_dependencies(&[4], &[(4, 3), (3, 1), (2, 1)]);
+        assert_eq!(services, vec![1, 3, 4]);
+    }
+}
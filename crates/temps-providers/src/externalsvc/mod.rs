@@ -6,6 +6,7 @@ use utoipa::ToSchema;
 
 pub mod mongodb;
 pub mod postgres;
+pub mod progress;
 pub mod redis;
 pub mod rustfs;
 pub mod s3;
@@ -17,6 +18,7 @@ pub mod test_utils;
 // Re-export services for easier access
 pub use mongodb::MongodbService;
 pub use postgres::PostgresService;
+pub use progress::{TransferPhase, TransferProgress, TransferSnapshot, TransferState};
 pub use redis::RedisService;
 pub use rustfs::RustfsService;
 pub use s3::S3Service;
@@ -123,6 +125,26 @@ pub struct RuntimeEnvVar {
     pub sensitive: bool,
 }
 
+/// User of its own a project linked to a service connects with
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct AppCredentials {
+    pub username: String,
+    pub password: String,
+    /// Most connections the user can open at once; None for the service's limit
+    pub connection_limit: Option<i32>,
+}
+
+/// CPU and memory limits of a service's container
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct ResourceLimits {
+    /// CPU limit in millicores (e.g., 2000 = 2 CPUs); None for no limit
+    #[schema(example = 2000)]
+    pub cpu_limit: Option<i32>,
+    /// Memory limit in megabytes; None for no limit
+    #[schema(example = 4096)]
+    pub memory_limit: Option<i32>,
+}
+
 /// Information about an available Docker container that can be imported
 #[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
 pub struct AvailableContainer {
@@ -159,6 +181,9 @@ pub trait ExternalService: Send + Sync {
     /// Get service name
     fn get_name(&self) -> String;
 
+    /// Name of the service's Docker container
+    fn container_name(&self) -> String;
+
     /// Get connection string or endpoint
     fn get_connection_info(&self) -> Result<String>;
 
@@ -221,6 +246,58 @@ pub trait ExternalService: Send + Sync {
     ) -> Result<HashMap<String, String>> {
         Ok(HashMap::new())
     }
+
+    /// Create the user a project linked to the service connects with, so that
+    /// projects sharing the service can't reach each other's data and each one's
+    /// access can be revoked alone
+    ///
+    /// Returns None for services whose projects share the service's credentials.
+    async fn create_app_credentials(
+        &self,
+        _service_config: ServiceConfig,
+        _project_id: &str,
+        _connection_limit: Option<i32>,
+    ) -> Result<Option<AppCredentials>> {
+        Ok(None)
+    }
+
+    /// Drop the user of a project unlinked from the service, closing its connections
+    async fn drop_app_credentials(
+        &self,
+        _service_config: ServiceConfig,
+        _credentials: &AppCredentials,
+    ) -> Result<()> {
+        Ok(())
+    }
+
+    /// Create the user a tunnel hands out, with the access of the service's own user,
+    /// that stops working at `valid_until` even if it isn't dropped
+    ///
+    /// Tunnels drop the user with `drop_app_credentials` when they close. Returns None
+    /// for services that can't have users of their own; tunnels to them are refused.
+    async fn create_tunnel_credentials(
+        &self,
+        _service_config: ServiceConfig,
+        _tunnel_id: &str,
+        _valid_until: chrono::DateTime<chrono::Utc>,
+    ) -> Result<Option<AppCredentials>> {
+        Ok(None)
+    }
+
+    /// Runtime environment variables of a project connecting with its own user
+    ///
+    /// Also grants the user access to the resources it provisions.
+    async fn get_app_runtime_env_vars(
+        &self,
+        config: ServiceConfig,
+        project_id: &str,
+        environment: &str,
+        _credentials: &AppCredentials,
+    ) -> Result<HashMap<String, String>> {
+        self.get_runtime_env_vars(config, project_id, environment)
+            .await
+    }
+
     fn get_local_address(&self, service_config: ServiceConfig) -> Result<String>;
 
     /// Get the effective host and port for connecting to this service
@@ -231,6 +308,7 @@ pub trait ExternalService: Send + Sync {
     /// Backup the service data to an S3 location
     /// s3_source: The S3 source configuration to use for backup
     /// subpath: The subpath within the S3 bucket where the backup should be stored
+    /// progress: Where the phases of the backup and the bytes they move are reported
     async fn backup_to_s3(
         &self,
         _s3_client: &aws_sdk_s3::Client,
@@ -241,6 +319,7 @@ pub trait ExternalService: Send + Sync {
         _pool: &temps_database::DbConnection,
         _external_service: &temps_entities::external_services::Model,
         _service_config: ServiceConfig,
+        _progress: &TransferProgress,
     ) -> Result<String> {
         Err(anyhow::anyhow!("Backup not implemented for this service"))
     }
@@ -252,10 +331,34 @@ pub trait ExternalService: Send + Sync {
         _backup_location: &str,
         _s3_source: &temps_entities::s3_sources::Model,
         _service_config: ServiceConfig,
+        _progress: &TransferProgress,
     ) -> Result<()> {
         Err(anyhow::anyhow!("Restore not implemented for this service"))
     }
 
+    /// Run a seed script against the service: SQL for PostgreSQL, a mongosh script for
+    /// MongoDB, one command per line for Redis
+    ///
+    /// Stops at the first failing statement and returns the script's output.
+    async fn run_script(&self, _service_config: ServiceConfig, _script: &str) -> Result<String> {
+        Err(anyhow::anyhow!(
+            "Seed scripts are not supported for this service"
+        ))
+    }
+
+    /// Adapt the running service to new limits Docker already applied to its container
+    ///
+    /// Returns false when the service only picks them up on a restart, e.g. because it
+    /// sizes its caches once at startup. Services that don't size themselves after
+    /// their memory have nothing to do.
+    async fn apply_resource_limits(
+        &self,
+        _service_config: ServiceConfig,
+        _limits: ResourceLimits,
+    ) -> Result<bool> {
+        Ok(true)
+    }
+
     /// Upgrade the service to a new version/image with data migration
     /// This method handles version-specific upgrade logic (e.g., pg_upgrade for PostgreSQL)
     ///
@@ -16,12 +16,13 @@ use std::sync::Arc;
 use std::time::Duration;
 use tokio::sync::RwLock;
 use tokio::time::sleep;
-use tracing::{error, info};
+use tracing::{error, info, warn};
 use urlencoding;
 
 use crate::utils::ensure_network_exists;
 
-use super::{ExternalService, RuntimeEnvVar, ServiceConfig, ServiceType};
+use super::progress::{self, TransferPhase, TransferProgress};
+use super::{ExternalService, ResourceLimits, RuntimeEnvVar, ServiceConfig, ServiceType};
 
 /// Input configuration for creating a MongoDB service
 /// This is what users provide when creating the service
@@ -519,6 +520,10 @@ impl ExternalService for MongodbService {
         self.name.clone()
     }
 
+    fn container_name(&self) -> String {
+        self.get_container_name()
+    }
+
     fn get_connection_info(&self) -> Result<String> {
         let config_guard = self
             .config
@@ -883,6 +888,7 @@ impl ExternalService for MongodbService {
         _pool: &temps_database::DbConnection,
         _external_service: &temps_entities::external_services::Model,
         service_config: ServiceConfig,
+        progress: &TransferProgress,
     ) -> Result<String> {
         // Parse config from the provided service_config parameter (don't rely on in-memory config)
         let config = self.get_mongodb_config(service_config)?;
@@ -923,6 +929,8 @@ impl ExternalService for MongodbService {
             .create_exec(&container_name, exec_config)
             .await?;
 
+        // mongodump compresses as it dumps, so there is no compress phase
+        progress.start_phase(TransferPhase::Dump, None);
         let output = self.docker.start_exec(&exec.id, None).await?;
 
         let mut backup_data = Vec::new();
@@ -932,6 +940,7 @@ impl ExternalService for MongodbService {
                     Ok(log_output) => match log_output {
                         bollard::container::LogOutput::StdOut { message } => {
                             backup_data.extend_from_slice(&message);
+                            progress.advance(message.len() as u64);
                         }
                         bollard::container::LogOutput::StdErr { message } => {
                             let stderr_str = String::from_utf8_lossy(&message);
@@ -957,6 +966,7 @@ impl ExternalService for MongodbService {
         info!("MongoDB backup size: {} bytes", backup_data.len());
 
         // Upload to S3
+        progress.start_phase(TransferPhase::Upload, Some(backup_data.len() as u64));
         let body = aws_sdk_s3::primitives::ByteStream::from_path(temp_path).await?;
         s3_client
             .put_object()
@@ -966,6 +976,7 @@ impl ExternalService for MongodbService {
             .send()
             .await
             .map_err(|e| anyhow::anyhow!("Failed to upload MongoDB backup to S3: {}", e))?;
+        progress.advance(backup_data.len() as u64);
 
         info!("MongoDB backup uploaded to S3: {}", backup_path);
 
@@ -981,6 +992,7 @@ impl ExternalService for MongodbService {
         backup_location: &str,
         s3_source: &temps_entities::s3_sources::Model,
         service_config: ServiceConfig,
+        progress: &TransferProgress,
     ) -> Result<()> {
         // Parse config from the provided service_config parameter (don't rely on in-memory config)
         let config = self.get_mongodb_config(service_config)?;
@@ -990,20 +1002,9 @@ impl ExternalService for MongodbService {
         info!("Starting MongoDB restore from: {}", backup_location);
 
         // Download backup from S3
-        let response = s3_client
-            .get_object()
-            .bucket(&s3_source.bucket_name)
-            .key(backup_location)
-            .send()
-            .await
-            .map_err(|e| anyhow::anyhow!("Failed to download MongoDB backup from S3: {}", e))?;
-
-        let backup_data = response
-            .body
-            .collect()
-            .await
-            .map_err(|e| anyhow::anyhow!("Failed to read backup data: {}", e))?
-            .into_bytes();
+        let backup_data =
+            progress::download_object(s3_client, &s3_source.bucket_name, backup_location, progress)
+                .await?;
 
         info!("Downloaded backup, size: {} bytes", backup_data.len());
 
@@ -1050,53 +1051,85 @@ impl ExternalService for MongodbService {
             ..Default::default()
         };
 
-        let exec = self
-            .docker
-            .create_exec(&container_name, exec_config)
-            .await?;
-
-        let output = self.docker.start_exec(&exec.id, None).await?;
+        // mongorestore decompresses as it replays and doesn't tell how far it got
+        progress.start_phase(TransferPhase::Replay, None);
+        let replayed = async {
+            let exec = self
+                .docker
+                .create_exec(&container_name, exec_config)
+                .await?;
 
-        if let bollard::exec::StartExecResults::Attached { mut output, .. } = output {
-            while let Some(result) = output.next().await {
-                match result {
-                    Ok(log_output) => match log_output {
-                        bollard::container::LogOutput::StdOut { message } => {
-                            let stdout_str = String::from_utf8_lossy(&message);
-                            info!("mongorestore stdout: {}", stdout_str);
-                        }
-                        bollard::container::LogOutput::StdErr { message } => {
-                            let stderr_str = String::from_utf8_lossy(&message);
-                            info!("mongorestore stderr: {}", stderr_str);
+            let output = self.docker.start_exec(&exec.id, None).await?;
+
+            if let bollard::exec::StartExecResults::Attached { mut output, .. } = output {
+                while let Some(result) = output.next().await {
+                    match result {
+                        Ok(log_output) => match log_output {
+                            bollard::container::LogOutput::StdOut { message } => {
+                                let stdout_str = String::from_utf8_lossy(&message);
+                                info!("mongorestore stdout: {}", stdout_str);
+                            }
+                            bollard::container::LogOutput::StdErr { message } => {
+                                let stderr_str = String::from_utf8_lossy(&message);
+                                info!("mongorestore stderr: {}", stderr_str);
+                            }
+                            _ => {}
+                        },
+                        Err(e) => {
+                            error!("Error reading exec output: {}", e);
+                            return Err(anyhow::anyhow!(
+                                "Failed to read mongorestore output: {}",
+                                e
+                            ));
                         }
-                        _ => {}
-                    },
-                    Err(e) => {
-                        error!("Error reading exec output: {}", e);
-                        return Err(anyhow::anyhow!("Failed to read mongorestore output: {}", e));
                     }
                 }
             }
+            Ok(())
         }
+        .await;
 
-        // Clean up temporary file in container
-        let cleanup_exec = self
-            .docker
-            .create_exec(
-                &container_name,
-                CreateExecOptions {
-                    cmd: Some(vec!["rm", "/tmp/backup.gz"]),
-                    ..Default::default()
-                },
-            )
-            .await?;
-
-        self.docker.start_exec(&cleanup_exec.id, None).await?;
+        // Clean up temporary file in container, also when the restore failed
+        if let Err(e) =
+            crate::utils::remove_file_in_container(&self.docker, &container_name, "/tmp/backup.gz")
+                .await
+        {
+            warn!(
+                "Failed to remove the restored archive from {}: {}",
+                container_name, e
+            );
+        }
+        replayed?;
 
         info!("MongoDB restore completed successfully");
         Ok(())
     }
 
+    async fn run_script(&self, service_config: ServiceConfig, script: &str) -> Result<String> {
+        let config = self.get_mongodb_config(service_config)?;
+        crate::utils::run_script_in_container(
+            &self.docker,
+            &self.get_container_name(),
+            "seed.js",
+            script,
+            vec![
+                "mongosh".to_string(),
+                "--norc".to_string(),
+                "--quiet".to_string(),
+                "-u".to_string(),
+                config.username.clone(),
+                "-p".to_string(),
+                config.password.clone(),
+                "--authenticationDatabase".to_string(),
+                "admin".to_string(),
+                config.database.clone(),
+                "/tmp/seed.js".to_string(),
+            ],
+            Vec::new(),
+        )
+        .await
+    }
+
     fn get_default_docker_image(&self) -> (String, String) {
         // Return (image_name, version)
         ("mongo".to_string(), "8.0".to_string())
@@ -1136,6 +1169,15 @@ impl ExternalService for MongodbService {
         Ok(version)
     }
 
+    async fn apply_resource_limits(
+        &self,
+        _service_config: ServiceConfig,
+        _limits: ResourceLimits,
+    ) -> Result<bool> {
+        // mongod sizes the WiredTiger cache after the container's memory at startup
+        Ok(false)
+    }
+
     async fn upgrade(&self, old_config: ServiceConfig, new_config: ServiceConfig) -> Result<()> {
         info!("Starting MongoDB upgrade");
 
@@ -1758,6 +1800,7 @@ mod tests {
                 &db_conn,
                 &external_service,
                 service_config.clone(),
+                &TransferProgress::default(),
             )
             .await
             .expect("Failed to backup MongoDB to S3");
@@ -1802,6 +1845,7 @@ mod tests {
                 &backup_path,
                 &minio.s3_source,
                 service_config,
+                &TransferProgress::default(),
             )
             .await
             .expect("Failed to restore MongoDB from S3");
@@ -14,12 +14,15 @@ use std::time::Duration;
 use temps_entities::external_service_backups;
 use tokio::sync::RwLock;
 use tokio::time::sleep;
-use tracing::{error, info};
+use tracing::{error, info, warn};
 use urlencoding;
 
 use crate::utils::ensure_network_exists;
 
-use super::{ExternalService, RuntimeEnvVar, ServiceConfig, ServiceType};
+use super::progress::{self, TransferPhase, TransferProgress};
+use super::{
+    AppCredentials, ExternalService, ResourceLimits, RuntimeEnvVar, ServiceConfig, ServiceType,
+};
 
 /// Input configuration for creating a PostgreSQL service
 /// This is what users provide when creating the service
@@ -73,6 +76,11 @@ pub struct PostgresInputConfig {
     #[serde(default = "default_docker_image")]
     #[schemars(example = "example_docker_image", default = "default_docker_image")]
     pub docker_image: Option<String>,
+
+    /// Load the pg_stat_statements extension to record query statistics (restarts the container)
+    #[serde(default, deserialize_with = "deserialize_flag")]
+    #[schemars(default = "default_pg_stat_statements")]
+    pub pg_stat_statements: bool,
 }
 
 /// Internal runtime configuration for PostgreSQL service
@@ -88,6 +96,8 @@ pub struct PostgresConfig {
     pub max_connections: u32,
     pub ssl_mode: Option<String>,
     pub docker_image: String,
+    #[serde(default, deserialize_with = "deserialize_flag")]
+    pub pg_stat_statements: bool,
 }
 
 impl From<PostgresInputConfig> for PostgresConfig {
@@ -107,6 +117,7 @@ impl From<PostgresInputConfig> for PostgresConfig {
             docker_image: input
                 .docker_image
                 .unwrap_or_else(|| "postgres:18-alpine".to_string()),
+            pg_stat_statements: input.pg_stat_statements,
         }
     }
 }
@@ -142,6 +153,26 @@ where
     }
 }
 
+/// Deserialize a flag from either a boolean or a "true"/"false" string
+fn deserialize_flag<'de, D>(deserializer: D) -> Result<bool, D::Error>
+where
+    D: serde::Deserializer<'de>,
+{
+    use serde::de::{self, Deserialize};
+
+    #[derive(Deserialize)]
+    #[serde(untagged)]
+    enum StringOrBool {
+        String(String),
+        Bool(bool),
+    }
+
+    match StringOrBool::deserialize(deserializer)? {
+        StringOrBool::String(s) => s.parse::<bool>().map_err(de::Error::custom),
+        StringOrBool::Bool(b) => Ok(b),
+    }
+}
+
 fn default_host() -> String {
     "localhost".to_string()
 }
@@ -154,7 +185,7 @@ fn default_username() -> String {
     "postgres".to_string()
 }
 
-fn generate_password() -> String {
+pub(crate) fn generate_password() -> String {
     use rand::{distributions::Alphanumeric, Rng};
     rand::thread_rng()
         .sample_iter(&Alphanumeric)
@@ -175,6 +206,10 @@ fn default_ssl_mode_string() -> String {
     "disable".to_string()
 }
 
+fn default_pg_stat_statements() -> bool {
+    false
+}
+
 fn default_docker_image() -> Option<String> {
     Some("postgres:18-alpine".to_string())
 }
@@ -212,6 +247,12 @@ fn example_docker_image() -> &'static str {
     "postgres:18-alpine"
 }
 
+/// effective_cache_size for a container memory limit: the three quarters of it
+/// PostgreSQL's documentation suggests for a dedicated server
+fn effective_cache_size_mb(memory_limit_mb: i32) -> i32 {
+    memory_limit_mb / 4 * 3
+}
+
 fn is_port_available(port: u16) -> bool {
     TcpListener::bind(("0.0.0.0", port)).is_ok()
 }
@@ -248,6 +289,50 @@ impl PostgresService {
         format!("postgres-{}", self.name)
     }
 
+    /// Server command of the container
+    fn postgres_command(config: &PostgresConfig) -> Vec<String> {
+        let mut cmd = vec![
+            "postgres".to_string(),
+            "-c".to_string(),
+            format!("max_connections={}", config.max_connections),
+        ];
+        if config.pg_stat_statements {
+            // The flag replaces the image's own preload list, so keep TimescaleDB loaded
+            let libraries = if config.docker_image.contains("timescale") {
+                "timescaledb,pg_stat_statements"
+            } else {
+                "pg_stat_statements"
+            };
+            cmd.push("-c".to_string());
+            cmd.push(format!("shared_preload_libraries={}", libraries));
+        }
+        cmd
+    }
+
+    /// Create the pg_stat_statements extension in the service database once the
+    /// library is loaded, so its statistics view can be queried
+    async fn ensure_pg_stat_statements(config: &PostgresConfig) -> Result<()> {
+        let connection_string = format!(
+            "postgres://{}:{}@{}:{}/{}",
+            urlencoding::encode(&config.username),
+            urlencoding::encode(&config.password),
+            config.host,
+            config.port,
+            config.database
+        );
+        let pool = sqlx::postgres::PgPoolOptions::new()
+            .max_connections(1)
+            .connect(&connection_string)
+            .await
+            .map_err(|e| anyhow::anyhow!("Failed to connect to postgres: {}", e))?;
+        sqlx::query("CREATE EXTENSION IF NOT EXISTS pg_stat_statements")
+            .execute(&pool)
+            .await
+            .map_err(|e| anyhow::anyhow!("Failed to create pg_stat_statements extension: {}", e))?;
+        pool.close().await;
+        Ok(())
+    }
+
     async fn create_container(&self, docker: &Docker, config: &PostgresConfig) -> Result<()> {
         // Pull image first
         info!("Pulling PostgreSQL image {}", config.docker_image);
@@ -299,15 +384,30 @@ impl PostgresService {
             }))
             .await?;
 
+        let service_label_key = format!("{}service_type", temps_core::DOCKER_LABEL_PREFIX);
+        let name_label_key = format!("{}service_name", temps_core::DOCKER_LABEL_PREFIX);
+        let statements_label_key = format!("{}pg_stat_statements", temps_core::DOCKER_LABEL_PREFIX);
+
         if !containers.is_empty() {
-            // Container exists - check if the image has changed
+            // Container exists - check if the image or the preloaded libraries have changed
             let existing_container = &containers[0];
             let existing_image = existing_container.image.as_deref().unwrap_or("");
+            let existing_statements = existing_container
+                .labels
+                .as_ref()
+                .and_then(|labels| labels.get(&statements_label_key))
+                .is_some_and(|value| value == "true");
 
-            if existing_image != config.docker_image {
+            if existing_image != config.docker_image
+                || existing_statements != config.pg_stat_statements
+            {
                 info!(
-                    "Container {} exists with different image ({}), removing to upgrade to {}",
-                    container_name, existing_image, config.docker_image
+                    "Container {} exists with a different configuration (image {}, pg_stat_statements {}), recreating with image {} (pg_stat_statements {})",
+                    container_name,
+                    existing_image,
+                    existing_statements,
+                    config.docker_image,
+                    config.pg_stat_statements
                 );
 
                 // Stop the container if running
@@ -327,22 +427,20 @@ impl PostgresService {
                     .await
                     .context("Failed to remove old container for upgrade")?;
 
-                info!("Old container removed, proceeding with new image");
+                info!("Old container removed, proceeding with new configuration");
             } else {
                 info!(
-                    "Container {} already exists with same image",
+                    "Container {} already exists with same configuration",
                     container_name
                 );
                 return Ok(());
             }
         }
 
-        let service_label_key = format!("{}service_type", temps_core::DOCKER_LABEL_PREFIX);
-        let name_label_key = format!("{}service_name", temps_core::DOCKER_LABEL_PREFIX);
-
         let container_labels = HashMap::from([
             (service_label_key, "postgres".to_string()),
             (name_label_key, self.name.to_string()),
+            (statements_label_key, config.pg_stat_statements.to_string()),
         ]);
 
         // Determine PGDATA path based on docker image
@@ -391,11 +489,7 @@ impl PostgresService {
             exposed_ports: Some(HashMap::from([("5432/tcp".to_string(), HashMap::new())])),
             env: Some(env_vars.iter().map(|s| s.to_string()).collect()),
             labels: Some(container_labels),
-            cmd: Some(vec![
-                "postgres".to_string(),
-                "-c".to_string(),
-                format!("max_connections={}", config.max_connections),
-            ]),
+            cmd: Some(Self::postgres_command(config)),
             host_config: Some(bollard::models::HostConfig {
                 restart_policy: Some(bollard::models::RestartPolicy {
                     name: Some(bollard::models::RestartPolicyNameEnum::ALWAYS),
@@ -555,9 +649,161 @@ impl PostgresService {
         }
     }
 
+    /// Role a project linked to the service connects with
+    fn app_role_name(project_id: &str) -> String {
+        Self::normalize_database_name(&format!("app_{}", project_id))
+    }
+
+    fn tunnel_role_name(tunnel_id: &str) -> String {
+        let short_id: String = tunnel_id
+            .chars()
+            .filter(char::is_ascii_alphanumeric)
+            .take(12)
+            .collect();
+        Self::normalize_database_name(&format!("tunnel_{}", short_id))
+    }
+
+    fn quote_identifier(name: &str) -> String {
+        format!("\"{}\"", name.replace('"', "\"\""))
+    }
+
+    /// Connect as the service's user to one of its databases
+    async fn connect_as_owner(config: &PostgresConfig, database: &str) -> Result<sqlx::PgPool> {
+        let connection_string = format!(
+            "postgres://{}:{}@{}:{}/{}",
+            urlencoding::encode(&config.username),
+            urlencoding::encode(&config.password),
+            config.host,
+            config.port,
+            urlencoding::encode(database)
+        );
+        sqlx::postgres::PgPoolOptions::new()
+            .max_connections(1)
+            .connect(&connection_string)
+            .await
+            .map_err(|e| anyhow::anyhow!("Failed to connect to postgres: {}", e))
+    }
+
+    /// Run statements one after the other on a pool
+    async fn execute_all(pool: &sqlx::PgPool, statements: &[String]) -> Result<()> {
+        for statement in statements {
+            sqlx::query(statement)
+                .execute(pool)
+                .await
+                .map_err(|e| anyhow::anyhow!("Failed to run `{}`: {}", statement, e))?;
+        }
+        Ok(())
+    }
+
+    /// Let a project's role use one of its databases, and only that role besides the
+    /// service's user
+    async fn grant_database(config: &PostgresConfig, database: &str, role: &str) -> Result<()> {
+        let database_ident = Self::quote_identifier(database);
+        let role_ident = Self::quote_identifier(role);
+
+        let pool = Self::connect_as_owner(config, "postgres").await?;
+        Self::execute_all(
+            &pool,
+            &[
+                format!(
+                    "REVOKE CONNECT, TEMPORARY ON DATABASE {} FROM PUBLIC",
+                    database_ident
+                ),
+                format!(
+                    "GRANT CONNECT, TEMPORARY, CREATE ON DATABASE {} TO {}",
+                    database_ident, role_ident
+                ),
+            ],
+        )
+        .await?;
+        pool.close().await;
+
+        // Tables created before the project had a role of its own, or later by the
+        // service's user through seeds and restores, are handed to the role so that
+        // its migrations can alter them. Sequences owned by a table follow it.
+        let pool = Self::connect_as_owner(config, database).await?;
+        Self::execute_all(
+            &pool,
+            &[
+                format!("GRANT USAGE, CREATE ON SCHEMA public TO {}", role_ident),
+                format!(
+                    r#"DO $$
+DECLARE relation record;
+BEGIN
+    FOR relation IN
+        SELECT c.oid::regclass AS name FROM pg_class c
+        JOIN pg_namespace n ON n.oid = c.relnamespace
+        WHERE n.nspname = 'public'
+          AND c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S')
+          AND c.relowner <> {role_literal}::regrole
+          AND NOT EXISTS (
+              SELECT 1 FROM pg_depend d
+              WHERE d.objid = c.oid AND d.classid = 'pg_class'::regclass AND d.deptype IN ('a', 'i')
+          )
+    LOOP
+        EXECUTE format('ALTER TABLE %s OWNER TO %s', relation.name, {role_literal});
+    END LOOP;
+END $$"#,
+                    role_literal = format!("'{}'", role_ident.replace('\'', "''"))
+                ),
+            ],
+        )
+        .await?;
+        pool.close().await;
+        Ok(())
+    }
+
+    async fn role_exists(pool: &sqlx::PgPool, role: &str) -> Result<bool> {
+        let row = sqlx::query("SELECT 1 FROM pg_roles WHERE rolname = $1")
+            .bind(role)
+            .fetch_optional(pool)
+            .await
+            .map_err(|e| anyhow::anyhow!("Failed to check role existence: {}", e))?;
+        Ok(row.is_some())
+    }
+
+    /// Variables apps connect to a database of the service with
+    fn runtime_env_vars(
+        &self,
+        database: &str,
+        username: &str,
+        password: &str,
+    ) -> HashMap<String, String> {
+        let mut env_vars = HashMap::new();
+
+        // Always use container name and internal port for container-to-container communication
+        let effective_host = self.get_container_name();
+        let effective_port = POSTGRES_INTERNAL_PORT.to_string();
+
+        // Database-specific variable
+        env_vars.insert("POSTGRES_DATABASE".to_string(), database.to_string());
+
+        // Connection URL
+        env_vars.insert(
+            "POSTGRES_URL".to_string(),
+            format!(
+                "postgresql://{}:{}@{}:{}/{}",
+                urlencoding::encode(username),
+                urlencoding::encode(password),
+                effective_host,
+                effective_port,
+                database
+            ),
+        );
+
+        // Individual connection parameters
+        env_vars.insert("POSTGRES_HOST".to_string(), effective_host);
+        env_vars.insert("POSTGRES_PORT".to_string(), effective_port);
+        env_vars.insert("POSTGRES_NAME".to_string(), database.to_string());
+        env_vars.insert("POSTGRES_USER".to_string(), username.to_string());
+        env_vars.insert("POSTGRES_PASSWORD".to_string(), password.to_string());
+
+        env_vars
+    }
+
     /// Extract PostgreSQL major version from Docker image name
     /// Examples: "postgres:16-alpine" -> 16, "timescale/timescaledb-ha:pg17" -> 17
-    fn extract_postgres_version(docker_image: &str) -> Result<u32> {
+    pub(crate) fn extract_postgres_version(docker_image: &str) -> Result<u32> {
         // Try to extract version from image name
         if let Some(tag) = docker_image.split(':').nth(1) {
             // Handle formats like "16-alpine", "17.2-alpine", "pg17"
@@ -795,11 +1041,7 @@ impl PostgresService {
                 format!("POSTGRES_PASSWORD={}", new_config.password),
                 format!("PGDATA={}", pgdata_path),
             ]),
-            cmd: Some(vec![
-                "postgres".to_string(),
-                "-c".to_string(),
-                format!("max_connections={}", new_config.max_connections),
-            ]),
+            cmd: Some(Self::postgres_command(new_config)),
             host_config: Some(bollard::models::HostConfig {
                 mounts: Some(vec![bollard::models::Mount {
                     // Always mount at /var/lib/postgresql - PGDATA env var controls subdirectory
@@ -923,22 +1165,35 @@ impl PostgresService {
             .await
             .map_err(|e| anyhow::anyhow!(format!("Failed to create exec: {}", e)))?;
 
-        let output = docker.start_exec(&exec.id, None).await?;
-        if let bollard::exec::StartExecResults::Attached { mut output, .. } = output {
-            while let Some(Ok(output)) = output.next().await {
-                match output {
-                    bollard::container::LogOutput::StdOut { message } => {
-                        info!("stdout: {}", String::from_utf8_lossy(&message));
-                    }
-                    bollard::container::LogOutput::StdErr { message } => {
-                        error!("stderr: {}", String::from_utf8_lossy(&message));
+        let replayed = async {
+            let output = docker.start_exec(&exec.id, None).await?;
+            if let bollard::exec::StartExecResults::Attached { mut output, .. } = output {
+                while let Some(Ok(output)) = output.next().await {
+                    match output {
+                        bollard::container::LogOutput::StdOut { message } => {
+                            info!("stdout: {}", String::from_utf8_lossy(&message));
+                        }
+                        bollard::container::LogOutput::StdErr { message } => {
+                            error!("stderr: {}", String::from_utf8_lossy(&message));
+                        }
+                        _ => {}
                     }
-                    _ => {}
                 }
             }
+            Ok::<(), anyhow::Error>(())
         }
+        .await;
 
-        Ok(())
+        // The dump holds all of the data in plain text; don't leave it in the container
+        if let Err(e) =
+            crate::utils::remove_file_in_container(docker, container_name, "/backup.sql").await
+        {
+            warn!(
+                "Failed to remove the restored dump from {}: {}",
+                container_name, e
+            );
+        }
+        replayed
     }
 
     /// Verify that a Docker image can be pulled without actually downloading the full image
@@ -1020,6 +1275,7 @@ impl ExternalService for PostgresService {
         pool: &temps_database::DbConnection,
         external_service: &temps_entities::external_services::Model,
         service_config: ServiceConfig,
+        progress: &TransferProgress,
     ) -> anyhow::Result<String> {
         use chrono::Utc;
         use sea_orm::*;
@@ -1081,6 +1337,7 @@ impl ExternalService for PostgresService {
             )
             .await?;
 
+        progress.start_phase(TransferPhase::Dump, None);
         let output: bollard::exec::StartExecResults =
             self.docker.start_exec(&exec.id, None).await?;
         if let bollard::exec::StartExecResults::Attached { output, .. } = output {
@@ -1091,6 +1348,7 @@ impl ExternalService for PostgresService {
                         bollard::container::LogOutput::StdOut { message }
                         | bollard::container::LogOutput::StdErr { message } => {
                             temp_file.write_all(&message)?;
+                            progress.advance(message.len() as u64);
                         }
                         _ => (),
                     },
@@ -1119,10 +1377,7 @@ impl ExternalService for PostgresService {
 
         // Compress the backup
         let mut compressed_file = NamedTempFile::new()?;
-        let mut encoder =
-            flate2::write::GzEncoder::new(&mut compressed_file, flate2::Compression::default());
-        std::io::copy(&mut std::fs::File::open(temp_file.path())?, &mut encoder)?;
-        encoder.finish()?;
+        progress::gzip_file(temp_file.path(), &mut compressed_file, progress)?;
 
         // Get file size after compression
         let size_bytes = compressed_file.as_file().metadata()?.len() as i32;
@@ -1141,6 +1396,9 @@ impl ExternalService for PostgresService {
             ));
         }
 
+        let checksum = crate::utils::file_sha256(compressed_file.path())?;
+
+        progress.start_phase(TransferPhase::Upload, Some(size_bytes as u64));
         s3_client
             .put_object()
             .bucket(&s3_source.bucket_name)
@@ -1157,6 +1415,7 @@ impl ExternalService for PostgresService {
                 );
                 anyhow::anyhow!("Failed to upload backup to S3: {}", e.to_string())
             })?;
+        progress.advance(size_bytes as u64);
 
         info!("Successfully uploaded backup to S3");
 
@@ -1166,6 +1425,7 @@ impl ExternalService for PostgresService {
         backup_update.finished_at = Set(Some(Utc::now()));
         backup_update.size_bytes = Set(Some(size_bytes));
         backup_update.s3_location = Set(backup_key.clone());
+        backup_update.checksum = Set(Some(checksum));
         backup_update.update(pool).await?;
 
         info!("PostgreSQL backup completed successfully");
@@ -1199,6 +1459,8 @@ impl ExternalService for PostgresService {
                 inferred_params.insert(key.clone(), str_value.to_string());
             } else if let Some(num_value) = value.as_u64() {
                 inferred_params.insert(key.clone(), num_value.to_string());
+            } else if let Some(bool_value) = value.as_bool() {
+                inferred_params.insert(key.clone(), bool_value.to_string());
             }
         }
 
@@ -1219,6 +1481,10 @@ impl ExternalService for PostgresService {
         self.name.clone()
     }
 
+    fn container_name(&self) -> String {
+        self.get_container_name()
+    }
+
     fn get_connection_info(&self) -> Result<String> {
         let config = self
             .config
@@ -1263,36 +1529,157 @@ impl ExternalService for PostgresService {
         self.create_database(service_config.clone(), &resource_name)
             .await?;
         let config: PostgresConfig = self.get_postgres_config(service_config)?;
-        let mut env_vars = HashMap::new();
 
-        // Always use container name and internal port for container-to-container communication
-        let effective_host = self.get_container_name();
-        let effective_port = POSTGRES_INTERNAL_PORT.to_string();
+        Ok(self.runtime_env_vars(&resource_name, &config.username, &config.password))
+    }
 
-        // Database-specific variable
-        env_vars.insert("POSTGRES_DATABASE".to_string(), resource_name.clone());
+    async fn create_app_credentials(
+        &self,
+        service_config: ServiceConfig,
+        project_id: &str,
+        connection_limit: Option<i32>,
+    ) -> Result<Option<AppCredentials>> {
+        let config = self.get_postgres_config(service_config)?;
+        let username = Self::app_role_name(project_id);
+        if username == config.username {
+            return Err(anyhow::anyhow!(
+                "Role {} is the service's own user",
+                username
+            ));
+        }
+        let password = generate_password();
 
-        // Connection URL
-        env_vars.insert(
-            "POSTGRES_URL".to_string(),
-            format!(
-                "postgresql://{}:{}@{}:{}/{}",
-                urlencoding::encode(&config.username),
-                urlencoding::encode(&config.password),
-                effective_host,
-                effective_port,
-                resource_name
-            ),
-        );
+        let pool = Self::connect_as_owner(&config, "postgres").await?;
+        // A role left behind by a link whose revocation failed is taken over with a
+        // new password
+        let verb = if Self::role_exists(&pool, &username).await? {
+            "ALTER"
+        } else {
+            "CREATE"
+        };
+        info!("{} role {} for project {}", verb, username, project_id);
+        Self::execute_all(
+            &pool,
+            &[format!(
+                "{} ROLE {} WITH LOGIN NOSUPERUSER NOCREATEDB NOCREATEROLE PASSWORD '{}' CONNECTION LIMIT {}",
+                verb,
+                Self::quote_identifier(&username),
+                password,
+                connection_limit.unwrap_or(-1)
+            )],
+        )
+        .await?;
+        pool.close().await;
 
-        // Individual connection parameters
-        env_vars.insert("POSTGRES_HOST".to_string(), effective_host);
-        env_vars.insert("POSTGRES_PORT".to_string(), effective_port);
-        env_vars.insert("POSTGRES_NAME".to_string(), resource_name.clone());
-        env_vars.insert("POSTGRES_USER".to_string(), config.username.clone());
-        env_vars.insert("POSTGRES_PASSWORD".to_string(), config.password.clone());
+        Ok(Some(AppCredentials {
+            username,
+            password,
+            connection_limit,
+        }))
+    }
 
-        Ok(env_vars)
+    async fn create_tunnel_credentials(
+        &self,
+        service_config: ServiceConfig,
+        tunnel_id: &str,
+        valid_until: chrono::DateTime<chrono::Utc>,
+    ) -> Result<Option<AppCredentials>> {
+        let config = self.get_postgres_config(service_config)?;
+        let username = Self::tunnel_role_name(tunnel_id);
+        let password = generate_password();
+
+        let pool = Self::connect_as_owner(&config, "postgres").await?;
+        info!("Creating role {} for tunnel {}", username, tunnel_id);
+        // Membership in the service's user gives the tunnel its access
+        Self::execute_all(
+            &pool,
+            &[format!(
+                "CREATE ROLE {} WITH LOGIN NOSUPERUSER NOCREATEDB NOCREATEROLE PASSWORD '{}' VALID UNTIL '{}' IN ROLE {}",
+                Self::quote_identifier(&username),
+                password,
+                valid_until.to_rfc3339(),
+                Self::quote_identifier(&config.username)
+            )],
+        )
+        .await?;
+        pool.close().await;
+
+        Ok(Some(AppCredentials {
+            username,
+            password,
+            connection_limit: None,
+        }))
+    }
+
+    async fn drop_app_credentials(
+        &self,
+        service_config: ServiceConfig,
+        credentials: &AppCredentials,
+    ) -> Result<()> {
+        let config = self.get_postgres_config(service_config)?;
+        let role_ident = Self::quote_identifier(&credentials.username);
+
+        let pool = Self::connect_as_owner(&config, "postgres").await?;
+        if !Self::role_exists(&pool, &credentials.username).await? {
+            info!("Role {} already dropped", credentials.username);
+            return Ok(());
+        }
+        // Stop new connections before closing the open ones
+        Self::execute_all(&pool, &[format!("ALTER ROLE {} NOLOGIN", role_ident)]).await?;
+        sqlx::query("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE usename = $1")
+            .bind(&credentials.username)
+            .execute(&pool)
+            .await
+            .map_err(|e| anyhow::anyhow!("Failed to close connections: {}", e))?;
+        let databases: Vec<String> = sqlx::query_scalar(
+            "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate",
+        )
+        .fetch_all(&pool)
+        .await
+        .map_err(|e| anyhow::anyhow!("Failed to list databases: {}", e))?;
+
+        // Ownership and privileges are per database. The project's tables stay, owned
+        // by the service's user.
+        for database in databases {
+            let database_pool = Self::connect_as_owner(&config, &database).await?;
+            Self::execute_all(
+                &database_pool,
+                &[
+                    format!(
+                        "REASSIGN OWNED BY {} TO {}",
+                        role_ident,
+                        Self::quote_identifier(&config.username)
+                    ),
+                    format!("DROP OWNED BY {}", role_ident),
+                ],
+            )
+            .await?;
+            database_pool.close().await;
+        }
+
+        Self::execute_all(&pool, &[format!("DROP ROLE IF EXISTS {}", role_ident)]).await?;
+        pool.close().await;
+
+        info!("Dropped role {}", credentials.username);
+        Ok(())
+    }
+
+    async fn get_app_runtime_env_vars(
+        &self,
+        service_config: ServiceConfig,
+        project_id: &str,
+        environment: &str,
+        credentials: &AppCredentials,
+    ) -> Result<HashMap<String, String>> {
+        let resource_name = format!("{}_{}", project_id, environment);
+        let resource_name = Self::normalize_database_name(&resource_name);
+
+        self.create_database(service_config.clone(), &resource_name)
+            .await?;
+        let config: PostgresConfig = self.get_postgres_config(service_config)?;
+        Self::grant_database(&config, &resource_name, &credentials.username).await?;
+
+        Ok(self.runtime_env_vars(&resource_name, &credentials.username, &credentials.password))
     }
     fn get_docker_environment_variables(
         &self,
@@ -1349,14 +1736,15 @@ impl ExternalService for PostgresService {
             for key in properties.keys().cloned().collect::<Vec<_>>() {
                 // Define which fields should be editable
                 let editable = match key.as_str() {
-                    "host" => false,           // Don't change host after creation
-                    "port" => true,            // Port can be changed
-                    "database" => false,       // Don't change database name after creation
-                    "username" => false,       // Don't change username after creation
-                    "password" => true,        // Password can be changed by user
-                    "max_connections" => true, // Max connections can be adjusted
-                    "ssl_mode" => true,        // SSL mode can be changed
-                    "docker_image" => true,    // Docker image can be upgraded
+                    "host" => false,              // Don't change host after creation
+                    "port" => true,               // Port can be changed
+                    "database" => false,          // Don't change database name after creation
+                    "username" => false,          // Don't change username after creation
+                    "password" => true,           // Password can be changed by user
+                    "max_connections" => true,    // Max connections can be adjusted
+                    "ssl_mode" => true,           // SSL mode can be changed
+                    "docker_image" => true,       // Docker image can be upgraded
+                    "pg_stat_statements" => true, // Query statistics can be toggled
                     _ => false,
                 };
 
@@ -1435,6 +1823,16 @@ impl ExternalService for PostgresService {
         self.wait_for_container_health(&self.docker, &container_name)
             .await?;
 
+        let config = self.config.read().await.clone();
+        if let Some(config) = config.filter(|c| c.pg_stat_statements) {
+            if let Err(e) = Self::ensure_pg_stat_statements(&config).await {
+                error!(
+                    "Failed to enable pg_stat_statements for {}: {}",
+                    container_name, e
+                );
+            }
+        }
+
         Ok(())
     }
 
@@ -1577,6 +1975,7 @@ impl ExternalService for PostgresService {
         backup_location: &str,
         s3_source: &temps_entities::s3_sources::Model,
         service_config: ServiceConfig,
+        progress: &TransferProgress,
     ) -> Result<()> {
         info!("Starting PostgreSQL restore from S3: {}", backup_location);
 
@@ -1587,25 +1986,20 @@ impl ExternalService for PostgresService {
         let postgres_config = self.get_postgres_config(service_config)?;
 
         // Get the backup object from S3
-        let get_obj = s3_client
-            .get_object()
-            .bucket(&s3_source.bucket_name)
-            .key(backup_location)
-            .send()
-            .await?;
-
-        // Read the backup data
-        let backup_data = get_obj.body.collect().await?.to_vec();
+        let backup_data =
+            progress::download_object(s3_client, &s3_source.bucket_name, backup_location, progress)
+                .await?;
 
         // Decompress if needed (assuming gzip compression)
-        let mut decoder = flate2::read::GzDecoder::new(&backup_data[..]);
-        let mut decompressed_data = Vec::new();
-        std::io::Read::read_to_end(&mut decoder, &mut decompressed_data)?;
+        let decompressed_data = progress::gunzip(&backup_data, progress)?;
+        drop(backup_data);
 
         // Get container name
         let container_name = self.get_container_name();
 
-        // Restore the backup using Docker with actual credentials
+        // Restore the backup using Docker with actual credentials; psql doesn't tell
+        // how far it got
+        progress.start_phase(TransferPhase::Replay, None);
         self.restore_backup_file(
             &self.docker,
             &container_name,
@@ -1619,6 +2013,51 @@ impl ExternalService for PostgresService {
         Ok(())
     }
 
+    async fn run_script(&self, service_config: ServiceConfig, script: &str) -> Result<String> {
+        let postgres_config = self.get_postgres_config(service_config)?;
+        crate::utils::run_script_in_container(
+            &self.docker,
+            &self.get_container_name(),
+            "seed.sql",
+            script,
+            vec![
+                "psql".to_string(),
+                "-v".to_string(),
+                "ON_ERROR_STOP=1".to_string(),
+                "-U".to_string(),
+                postgres_config.username.clone(),
+                "-d".to_string(),
+                postgres_config.database.clone(),
+                "-f".to_string(),
+                "/tmp/seed.sql".to_string(),
+            ],
+            vec![format!("PGPASSWORD={}", postgres_config.password)],
+        )
+        .await
+    }
+
+    async fn apply_resource_limits(
+        &self,
+        service_config: ServiceConfig,
+        limits: ResourceLimits,
+    ) -> Result<bool> {
+        // Memory the planner counts on for caching; a reload applies it, unlike
+        // shared_buffers, which is left at its default
+        let statement = match limits.memory_limit {
+            Some(memory_limit) => format!(
+                "ALTER SYSTEM SET effective_cache_size = '{}MB'",
+                effective_cache_size_mb(memory_limit)
+            ),
+            None => "ALTER SYSTEM RESET effective_cache_size".to_string(),
+        };
+        self.run_script(
+            service_config,
+            &format!("{};\nSELECT pg_reload_conf();\n", statement),
+        )
+        .await?;
+        Ok(true)
+    }
+
     async fn upgrade(&self, old_config: ServiceConfig, new_config: ServiceConfig) -> Result<()> {
         info!("Starting PostgreSQL upgrade with pg_upgrade");
 
@@ -1824,6 +2263,7 @@ mod tests {
             max_connections: default_max_connections(),
             ssl_mode: default_ssl_mode(),
             docker_image: None,
+            pg_stat_statements: false,
         };
 
         let runtime_config: PostgresConfig = config.into();
@@ -1847,6 +2287,7 @@ mod tests {
             max_connections: 50,
             ssl_mode: Some("disable".to_string()),
             docker_image: Some("timescale/timescaledb-ha:pg17".to_string()),
+            pg_stat_statements: false,
         };
 
         let runtime_config: PostgresConfig = config.into();
@@ -1854,6 +2295,59 @@ mod tests {
         assert_eq!(runtime_config.docker_image, "timescale/timescaledb-ha:pg17");
     }
 
+    #[test]
+    fn test_postgres_command_preloads_pg_stat_statements() {
+        let mut config: PostgresConfig = PostgresInputConfig {
+            host: default_host(),
+            port: Some("5432".to_string()),
+            database: default_database(),
+            username: default_username(),
+            password: Some("secret".to_string()),
+            max_connections: 50,
+            ssl_mode: default_ssl_mode(),
+            docker_image: None,
+            pg_stat_statements: false,
+        }
+        .into();
+        assert_eq!(
+            PostgresService::postgres_command(&config),
+            vec!["postgres", "-c", "max_connections=50"]
+        );
+
+        config.pg_stat_statements = true;
+        assert_eq!(
+            PostgresService::postgres_command(&config),
+            vec![
+                "postgres",
+                "-c",
+                "max_connections=50",
+                "-c",
+                "shared_preload_libraries=pg_stat_statements"
+            ]
+        );
+
+        config.docker_image = "timescale/timescaledb-ha:pg17".to_string();
+        assert!(PostgresService::postgres_command(&config)
+            .contains(&"shared_preload_libraries=timescaledb,pg_stat_statements".to_string()));
+    }
+
+    #[test]
+    fn test_pg_stat_statements_flag_from_stored_parameters() {
+        // Parameters stored as strings by inference and as booleans by the API both parse
+        for value in [serde_json::json!("true"), serde_json::json!(true)] {
+            let config: PostgresInputConfig = serde_json::from_value(serde_json::json!({
+                "database": "app",
+                "username": "app",
+                "pg_stat_statements": value,
+            }))
+            .unwrap();
+            assert!(config.pg_stat_statements);
+        }
+        let config: PostgresInputConfig =
+            serde_json::from_value(serde_json::json!({ "database": "app" })).unwrap();
+        assert!(!config.pg_stat_statements);
+    }
+
     #[test]
     fn test_parameter_schema_editable_fields() {
         let docker = Arc::new(Docker::connect_with_local_defaults().unwrap());
@@ -1880,6 +2374,7 @@ mod tests {
             ("max_connections", true),
             ("ssl_mode", true),
             ("docker_image", true),
+            ("pg_stat_statements", true),
         ];
 
         for (field_name, should_be_editable) in editable_status {
@@ -1959,6 +2454,31 @@ mod tests {
         let _ = service.cleanup().await;
     }
 
+    #[test]
+    fn test_app_role_name() {
+        assert_eq!(PostgresService::app_role_name("my-app"), "app_my_app");
+        assert_eq!(PostgresService::app_role_name("123"), "app_123");
+        let long_slug = "a".repeat(80);
+        assert_eq!(PostgresService::app_role_name(&long_slug).len(), 63);
+    }
+
+    #[test]
+    fn test_tunnel_role_name() {
+        assert_eq!(
+            PostgresService::tunnel_role_name("3f2b8c1e-9a7d-4e21-b6c5-0d8f1a2b3c4d"),
+            "tunnel_3f2b8c1e9a7d"
+        );
+    }
+
+    #[test]
+    fn test_quote_identifier() {
+        assert_eq!(PostgresService::quote_identifier("app_x"), "\"app_x\"");
+        assert_eq!(
+            PostgresService::quote_identifier("we\"ird"),
+            "\"we\"\"ird\""
+        );
+    }
+
     #[test]
     fn test_default_docker_image() {
         let docker = Arc::new(Docker::connect_with_local_defaults().unwrap());
@@ -2123,6 +2643,7 @@ mod tests {
             max_connections: 100,
             ssl_mode: Some("disable".to_string()),
             docker_image: Some("postgres:16-alpine".to_string()),
+            pg_stat_statements: false,
         };
 
         let downgrade_config = PostgresInputConfig {
@@ -2134,6 +2655,7 @@ mod tests {
             max_connections: 100,
             ssl_mode: Some("disable".to_string()),
             docker_image: Some("postgres:15-alpine".to_string()),
+            pg_stat_statements: false,
         };
 
         let old_version =
@@ -2165,6 +2687,7 @@ mod tests {
             max_connections: 100,
             ssl_mode: Some("disable".to_string()),
             docker_image: Some("postgres:16-alpine".to_string()),
+            pg_stat_statements: false,
         };
 
         let v17_config = PostgresInputConfig {
@@ -2176,6 +2699,7 @@ mod tests {
             max_connections: 100,
             ssl_mode: Some("disable".to_string()),
             docker_image: Some("postgres:17-alpine".to_string()),
+            pg_stat_statements: false,
         };
 
         // Convert to runtime configs
@@ -2757,6 +3281,7 @@ mod tests {
                 &mock_db,
                 &external_service,
                 pg_config.clone(),
+                &TransferProgress::default(),
             )
             .await
         {
@@ -2829,6 +3354,7 @@ mod tests {
                 &backup_location,
                 &minio.s3_source,
                 pg_config.clone(),
+                &TransferProgress::default(),
             )
             .await
         {
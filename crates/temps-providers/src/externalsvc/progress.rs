@@ -0,0 +1,302 @@
+//! Backup and Restore Progress
+//!
+//! A backup of a managed service dumps its data, compresses the dump and uploads it;
+//! a restore downloads a backup, decompresses it and replays it into the service.
+//! Services report the phase they are in and the bytes it moved through a
+//! `TransferProgress`, and whoever started the operation follows it by subscribing.
+//! Phases whose size is known up front carry a total, from which the ETA of the phase
+//! is estimated at its rate so far. Dumps and replays don't know their size, so they
+//! only count bytes, or not even that when the tool doing the work doesn't tell.
+
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::io::{self, Read, Write};
+use std::path::Path;
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+use tokio::sync::watch;
+use utoipa::ToSchema;
+
+/// Step of a backup or restore
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum TransferPhase {
+    Dump,
+    Compress,
+    Upload,
+    Download,
+    Decompress,
+    Replay,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum TransferState {
+    Running,
+    Completed,
+    Failed,
+}
+
+/// Where a backup or restore stands
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct TransferSnapshot {
+    pub state: TransferState,
+    /// Phase running now, or the last one once the operation ended; None before the
+    /// first starts
+    pub phase: Option<TransferPhase>,
+    /// Bytes the phase moved so far
+    pub bytes_transferred: u64,
+    /// Bytes the phase moves in all, when known
+    pub total_bytes: Option<u64>,
+    /// Seconds until the phase ends at its rate so far, when its size is known
+    pub eta_seconds: Option<u64>,
+    /// Why the operation failed
+    pub error: Option<String>,
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub finished_at: Option<DateTime<Utc>>,
+}
+
+impl Default for TransferSnapshot {
+    fn default() -> Self {
+        Self {
+            state: TransferState::Running,
+            phase: None,
+            bytes_transferred: 0,
+            total_bytes: None,
+            eta_seconds: None,
+            error: None,
+            finished_at: None,
+        }
+    }
+}
+
+impl TransferSnapshot {
+    pub fn is_finished(&self) -> bool {
+        self.state != TransferState::Running
+    }
+}
+
+/// Time left of a phase that moved `transferred` of `total` bytes in `elapsed`,
+/// assuming it keeps its rate
+pub fn eta(transferred: u64, total: Option<u64>, elapsed: Duration) -> Option<Duration> {
+    let total = total?;
+    if transferred == 0 {
+        return None;
+    }
+    let remaining = total.saturating_sub(transferred);
+    Some(elapsed.mul_f64(remaining as f64 / transferred as f64))
+}
+
+/// Progress of a backup or restore, shared between the service doing it and the ones
+/// following it
+///
+/// Once completed or failed, further updates are ignored.
+#[derive(Debug, Clone)]
+pub struct TransferProgress {
+    sender: Arc<watch::Sender<TransferSnapshot>>,
+    phase_started: Arc<Mutex<Instant>>,
+}
+
+impl Default for TransferProgress {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl TransferProgress {
+    pub fn new() -> Self {
+        let (sender, _) = watch::channel(TransferSnapshot::default());
+        Self {
+            sender: Arc::new(sender),
+            phase_started: Arc::new(Mutex::new(Instant::now())),
+        }
+    }
+
+    /// Follow the progress; the receiver sees every change from now on
+    pub fn subscribe(&self) -> watch::Receiver<TransferSnapshot> {
+        self.sender.subscribe()
+    }
+
+    pub fn snapshot(&self) -> TransferSnapshot {
+        self.sender.borrow().clone()
+    }
+
+    /// Start a phase, moving `total_bytes` when known
+    pub fn start_phase(&self, phase: TransferPhase, total_bytes: Option<u64>) {
+        *self.phase_started.lock().unwrap() = Instant::now();
+        self.sender.send_if_modified(|snapshot| {
+            if snapshot.is_finished() {
+                return false;
+            }
+            snapshot.phase = Some(phase);
+            snapshot.bytes_transferred = 0;
+            snapshot.total_bytes = total_bytes;
+            snapshot.eta_seconds = None;
+            true
+        });
+    }
+
+    /// Count bytes the phase moved
+    pub fn advance(&self, bytes: u64) {
+        let elapsed = self.phase_started.lock().unwrap().elapsed();
+        self.sender.send_if_modified(|snapshot| {
+            if snapshot.is_finished() || bytes == 0 {
+                return false;
+            }
+            snapshot.bytes_transferred = snapshot.bytes_transferred.saturating_add(bytes);
+            snapshot.eta_seconds = eta(snapshot.bytes_transferred, snapshot.total_bytes, elapsed)
+                .map(|eta| eta.as_secs());
+            true
+        });
+    }
+
+    pub fn complete(&self) {
+        self.finish(TransferState::Completed, None);
+    }
+
+    pub fn fail(&self, error: impl Into<String>) {
+        self.finish(TransferState::Failed, Some(error.into()));
+    }
+
+    fn finish(&self, state: TransferState, error: Option<String>) {
+        self.sender.send_if_modified(|snapshot| {
+            if snapshot.is_finished() {
+                return false;
+            }
+            snapshot.state = state;
+            snapshot.eta_seconds = None;
+            snapshot.error = error;
+            snapshot.finished_at = Some(Utc::now());
+            true
+        });
+    }
+}
+
+/// Reader counting the bytes read through it as progress of the current phase
+pub struct ProgressReader<'a, R> {
+    inner: R,
+    progress: &'a TransferProgress,
+}
+
+impl<'a, R: Read> ProgressReader<'a, R> {
+    pub fn new(inner: R, progress: &'a TransferProgress) -> Self {
+        Self { inner, progress }
+    }
+}
+
+impl<R: Read> Read for ProgressReader<'_, R> {
+    fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+        let read = self.inner.read(buf)?;
+        self.progress.advance(read as u64);
+        Ok(read)
+    }
+}
+
+/// Download an object of an S3 source as the download phase
+pub async fn download_object(
+    s3_client: &aws_sdk_s3::Client,
+    bucket: &str,
+    key: &str,
+    progress: &TransferProgress,
+) -> anyhow::Result<Vec<u8>> {
+    let response = s3_client
+        .get_object()
+        .bucket(bucket)
+        .key(key)
+        .send()
+        .await
+        .map_err(|e| anyhow::anyhow!("Failed to download backup {} from S3: {}", key, e))?;
+    let total = response
+        .content_length
+        .and_then(|length| u64::try_from(length).ok());
+    progress.start_phase(TransferPhase::Download, total);
+
+    let mut data = Vec::with_capacity(total.unwrap_or_default() as usize);
+    let mut body = response.body;
+    while let Some(chunk) = body.next().await {
+        let chunk = chunk.map_err(|e| anyhow::anyhow!("Failed to read backup {}: {}", key, e))?;
+        data.extend_from_slice(&chunk);
+        progress.advance(chunk.len() as u64);
+    }
+    Ok(data)
+}
+
+/// Decompress a gzip backup as the decompress phase, counting the compressed bytes
+pub fn gunzip(data: &[u8], progress: &TransferProgress) -> io::Result<Vec<u8>> {
+    progress.start_phase(TransferPhase::Decompress, Some(data.len() as u64));
+    let mut decompressed = Vec::new();
+    flate2::read::GzDecoder::new(ProgressReader::new(data, progress))
+        .read_to_end(&mut decompressed)?;
+    Ok(decompressed)
+}
+
+/// Compress a dump into a gzip file as the compress phase
+pub fn gzip_file<W: Write>(from: &Path, to: W, progress: &TransferProgress) -> io::Result<()> {
+    let file = std::fs::File::open(from)?;
+    progress.start_phase(TransferPhase::Compress, Some(file.metadata()?.len()));
+    let mut encoder = flate2::write::GzEncoder::new(to, flate2::Compression::default());
+    io::copy(&mut ProgressReader::new(file, progress), &mut encoder)?;
+    encoder.finish()?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_eta() {
+        let elapsed = Duration::from_secs(10);
+        assert_eq!(eta(0, Some(100), elapsed), None);
+        assert_eq!(eta(50, None, elapsed), None);
+        assert_eq!(eta(25, Some(100), elapsed), Some(Duration::from_secs(30)));
+        assert_eq!(eta(100, Some(100), elapsed), Some(Duration::ZERO));
+    }
+
+    #[test]
+    fn test_progress_phases_and_end() {
+        let progress = TransferProgress::new();
+        let receiver = progress.subscribe();
+
+        progress.start_phase(TransferPhase::Download, Some(100));
+        progress.advance(40);
+        let snapshot = receiver.borrow().clone();
+        assert_eq!(snapshot.phase, Some(TransferPhase::Download));
+        assert_eq!(snapshot.bytes_transferred, 40);
+        assert!(snapshot.eta_seconds.is_some());
+
+        // A new phase counts from zero
+        progress.start_phase(TransferPhase::Replay, None);
+        assert_eq!(progress.snapshot().bytes_transferred, 0);
+        assert_eq!(progress.snapshot().total_bytes, None);
+
+        progress.fail("replay failed");
+        progress.complete();
+        progress.advance(10);
+        let snapshot = progress.snapshot();
+        assert_eq!(snapshot.state, TransferState::Failed);
+        assert_eq!(snapshot.error.as_deref(), Some("replay failed"));
+        assert_eq!(snapshot.bytes_transferred, 0);
+        assert!(snapshot.finished_at.is_some());
+    }
+
+    #[test]
+    fn test_gzip_round_trip_counts_bytes() {
+        let dir = tempfile::tempdir().unwrap();
+        let dump = dir.path().join("dump.sql");
+        std::fs::write(&dump, "SELECT 1;\n".repeat(100)).unwrap();
+
+        let progress = TransferProgress::new();
+        let mut compressed = Vec::new();
+        gzip_file(&dump, &mut compressed, &progress).unwrap();
+        assert_eq!(progress.snapshot().phase, Some(TransferPhase::Compress));
+        assert_eq!(progress.snapshot().bytes_transferred, 1000);
+
+        let decompressed = gunzip(&compressed, &progress).unwrap();
+        assert_eq!(decompressed.len(), 1000);
+        assert_eq!(
+            progress.snapshot().bytes_transferred,
+            compressed.len() as u64
+        );
+    }
+}
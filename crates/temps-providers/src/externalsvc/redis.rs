@@ -1,5 +1,6 @@
 use crate::utils::ensure_network_exists;
 
+use super::progress::{self, TransferPhase, TransferProgress};
 use super::{ExternalService, ServiceConfig, ServiceType};
 use anyhow::{Context, Result};
 use async_trait::async_trait;
@@ -624,6 +625,10 @@ impl ExternalService for RedisService {
         self.name.clone()
     }
 
+    fn container_name(&self) -> String {
+        self.get_container_name()
+    }
+
     fn get_connection_info(&self) -> Result<String> {
         let config = self
             .config
@@ -962,6 +967,7 @@ impl ExternalService for RedisService {
         pool: &temps_database::DbConnection,
         external_service: &temps_entities::external_services::Model,
         _service_config: ServiceConfig,
+        progress: &TransferProgress,
     ) -> Result<String> {
         use chrono::Utc;
         use sea_orm::*;
@@ -1014,6 +1020,7 @@ impl ExternalService for RedisService {
         tokio::time::sleep(tokio::time::Duration::from_secs(2)).await;
 
         // Copy both dump.rdb and appendonly.aof from container
+        progress.start_phase(TransferPhase::Dump, None);
         for file in &["dump.rdb", "appendonly.aof"] {
             let cat_exec = self
                 .docker
@@ -1040,6 +1047,7 @@ impl ExternalService for RedisService {
                             bollard::container::LogOutput::StdOut { message }
                             | bollard::container::LogOutput::StdErr { message } => {
                                 temp_file.write_all(&message)?;
+                                progress.advance(message.len() as u64);
                             }
                             _ => (),
                         },
@@ -1097,7 +1105,10 @@ impl ExternalService for RedisService {
             ));
         }
 
-        // Upload to S3
+        let checksum = crate::utils::file_sha256(&tar_path)?;
+
+        // Upload to S3; the archive isn't compressed
+        progress.start_phase(TransferPhase::Upload, Some(size_bytes as u64));
         s3_client
             .put_object()
             .bucket(&s3_source.bucket_name)
@@ -1106,6 +1117,7 @@ impl ExternalService for RedisService {
             .content_type("application/x-tar")
             .send()
             .await?;
+        progress.advance(size_bytes as u64);
 
         // Update backup record with success
         let mut backup_update: temps_entities::external_service_backups::ActiveModel =
@@ -1114,6 +1126,7 @@ impl ExternalService for RedisService {
         backup_update.finished_at = Set(Some(Utc::now()));
         backup_update.size_bytes = Set(Some(size_bytes));
         backup_update.s3_location = Set(backup_key.clone());
+        backup_update.checksum = Set(Some(checksum));
         backup_update.update(pool).await?;
 
         info!("Redis backup completed successfully");
@@ -1126,19 +1139,14 @@ impl ExternalService for RedisService {
         backup_location: &str,
         s3_source: &temps_entities::s3_sources::Model,
         _service_config: ServiceConfig,
+        progress: &TransferProgress,
     ) -> Result<()> {
         info!("Starting Redis restore from S3: {}", backup_location);
 
         // Get the backup object from S3
-        let get_obj = s3_client
-            .get_object()
-            .bucket(&s3_source.bucket_name)
-            .key(backup_location)
-            .send()
-            .await?;
-
-        // Read the backup data
-        let backup_data = get_obj.body.collect().await?.to_vec();
+        let backup_data =
+            progress::download_object(s3_client, &s3_source.bucket_name, backup_location, progress)
+                .await?;
 
         // Get container name
         let container_name = self.get_container_name();
@@ -1169,8 +1177,11 @@ impl ExternalService for RedisService {
             }
         }
         let tar_data = tar.into_inner()?;
+        let tar_data_len = tar_data.len() as u64;
 
-        // Copy both files into the container's data directory
+        // Copy both files into the container's data directory; Redis loads them as it
+        // starts
+        progress.start_phase(TransferPhase::Replay, Some(tar_data_len));
         self.docker
             .upload_to_container(
                 &container_name,
@@ -1182,6 +1193,7 @@ impl ExternalService for RedisService {
             )
             .await
             .context("Failed to upload backup files to container")?;
+        progress.advance(tar_data_len);
 
         // Start Redis server again
         self.docker
@@ -1200,6 +1212,30 @@ impl ExternalService for RedisService {
         Ok(())
     }
 
+    async fn run_script(&self, service_config: ServiceConfig, script: &str) -> Result<String> {
+        let config = self.get_redis_config(service_config)?;
+        let output = crate::utils::run_script_in_container(
+            &self.docker,
+            &self.get_container_name(),
+            "seed.redis",
+            script,
+            vec![
+                "sh".to_string(),
+                "-c".to_string(),
+                "redis-cli < /tmp/seed.redis".to_string(),
+            ],
+            vec![format!("REDISCLI_AUTH={}", config.password)],
+        )
+        .await?;
+
+        // redis-cli exits successfully when a command fails, so errors are read from
+        // the replies
+        if let Some(error) = output.lines().find(|line| is_error_reply(line)) {
+            return Err(anyhow::anyhow!("Redis command failed: {}", error.trim()));
+        }
+        Ok(output)
+    }
+
     fn get_default_docker_image(&self) -> (String, String) {
         // Return (image_name, version)
         ("redis".to_string(), "7-alpine".to_string())
@@ -1359,10 +1395,45 @@ impl ExternalService for RedisService {
     }
 }
 
+/// Error codes Redis starts its error replies with
+const ERROR_REPLY_CODES: &[&str] = &[
+    "ERR",
+    "WRONGTYPE",
+    "NOAUTH",
+    "NOPERM",
+    "OOM",
+    "EXECABORT",
+    "READONLY",
+    "NOSCRIPT",
+    "BUSY",
+    "LOADING",
+    "MISCONF",
+];
+
+/// Whether a line of redis-cli output is an error reply (`ERR ...`, `WRONGTYPE ...`)
+fn is_error_reply(line: &str) -> bool {
+    line.trim_start()
+        .trim_start_matches("(error) ")
+        .split_whitespace()
+        .next()
+        .is_some_and(|code| ERROR_REPLY_CODES.contains(&code))
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
+    #[test]
+    fn test_is_error_reply() {
+        assert!(is_error_reply("ERR unknown command 'SETT'"));
+        assert!(is_error_reply(
+            "(error) WRONGTYPE Operation against a key holding the wrong kind of value"
+        ));
+        assert!(!is_error_reply("OK"));
+        assert!(!is_error_reply("(integer) 1"));
+        assert!(!is_error_reply("hello world"));
+    }
+
     #[test]
     fn test_parameter_schema_editable_fields() {
         let docker = Arc::new(Docker::connect_with_local_defaults().unwrap());
@@ -1825,6 +1896,7 @@ mod tests {
                 &mock_db,
                 &external_service,
                 redis_config.clone(),
+                &TransferProgress::default(),
             )
             .await
         {
@@ -1888,6 +1960,7 @@ mod tests {
                 &backup_location,
                 &minio.s3_source,
                 redis_config.clone(),
+                &TransferProgress::default(),
             )
             .await
         {
@@ -717,6 +717,10 @@ impl ExternalService for RustfsService {
         self.name.clone()
     }
 
+    fn container_name(&self) -> String {
+        self.get_container_name()
+    }
+
     fn get_connection_info(&self) -> Result<String> {
         let config = self
             .config
@@ -21,6 +21,7 @@ use tracing::{debug, error, info};
 
 use crate::utils::ensure_network_exists;
 
+use super::progress::{TransferPhase, TransferProgress};
 use super::{ExternalService, ServiceConfig, ServiceType};
 
 /// Input configuration for creating an S3/MinIO service
@@ -635,6 +636,10 @@ impl ExternalService for S3Service {
         self.name.clone()
     }
 
+    fn container_name(&self) -> String {
+        self.get_container_name()
+    }
+
     fn get_connection_info(&self) -> Result<String> {
         let config = self
             .config
@@ -956,6 +961,7 @@ impl ExternalService for S3Service {
         pool: &temps_database::DbConnection,
         external_service: &temps_entities::external_services::Model,
         service_config: ServiceConfig,
+        progress: &TransferProgress,
     ) -> Result<String> {
         use chrono::Utc;
         use sea_orm::*;
@@ -1071,6 +1077,10 @@ impl ExternalService for S3Service {
         let source_name = "original/".to_string();
         let dest_name = format!("backup-dest/{}/{}", s3_source.bucket_name, subpath_root);
 
+        // mc mirrors the objects straight into the backup bucket, without telling how
+        // many bytes it copied
+        progress.start_phase(TransferPhase::Upload, None);
+
         // Execute commands in sequence
         let commands = vec![
             // Add source alias
@@ -1189,6 +1199,7 @@ impl ExternalService for S3Service {
         backup_location: &str,
         s3_source: &temps_entities::s3_sources::Model,
         service_config: ServiceConfig,
+        progress: &TransferProgress,
     ) -> Result<()> {
         info!(
             "Starting S3 restore from backup location: {}",
@@ -1319,6 +1330,7 @@ impl ExternalService for S3Service {
             }
         }
 
+        progress.start_phase(TransferPhase::Download, None);
         let source_backup_location = format!(
             "backup-source/{}/{}",
             s3_source.bucket_name, backup_location
@@ -2121,6 +2133,7 @@ mod tests {
                 &mock_db,
                 &external_service,
                 s3_config.clone(),
+                &TransferProgress::default(),
             )
             .await
         {
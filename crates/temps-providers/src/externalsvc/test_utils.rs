@@ -219,6 +219,10 @@ pub fn create_mock_external_service(
         updated_at: chrono::Utc::now().into(),
         slug: None,
         config: Some(serde_json::json!({}).to_string()),
+        tags: Default::default(),
+        managed: true,
+        cpu_limit: None,
+        memory_limit: None,
     }
 }
 
@@ -22,6 +22,14 @@ pub struct ExternalServiceUpdatedAudit {
     pub updated_parameters: HashMap<String, String>,
 }
 
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceTagsUpdatedAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub name: String,
+    pub tags: temps_entities::tags::Tags,
+}
+
 #[derive(Debug, Clone, Serialize)]
 pub struct ExternalServiceDeletedAudit {
     pub context: AuditContext,
@@ -55,6 +63,94 @@ pub struct ExternalServiceProjectUnlinkedAudit {
     pub project_id: i32,
 }
 
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceTunnelOpenedAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    pub tunnel_id: String,
+    pub port: u16,
+    pub allowed_sources: Vec<String>,
+    pub expires_at: String,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceTunnelClosedAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    pub tunnel_id: String,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceDependenciesUpdatedAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    pub depends_on: Vec<i32>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceCredentialsRotatedAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    pub rotation_id: i32,
+    pub trigger: String,
+    pub status: String,
+    pub old_username: String,
+    pub new_username: String,
+    pub environment_ids: Vec<i32>,
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceRotationPolicyUpdatedAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    /// None when the schedule was removed
+    pub interval_days: Option<i32>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceRecoveryPolicyUpdatedAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    /// restart or redeploy; None when the policy was removed
+    pub action: Option<String>,
+    pub cooldown_seconds: Option<i32>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceScaledAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    pub scaling_id: i32,
+    /// completed or failed
+    pub status: String,
+    /// online, restart or deferred
+    pub method: Option<String>,
+    pub old_cpu_limit: Option<i32>,
+    pub old_memory_limit: Option<i32>,
+    pub cpu_limit: Option<i32>,
+    pub memory_limit: Option<i32>,
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ExternalServiceDatabaseImportAudit {
+    pub context: AuditContext,
+    pub service_id: i32,
+    pub service_name: String,
+    /// started, cutover or stopped
+    pub event: String,
+    pub mode: String,
+    pub source: String,
+}
+
 impl AuditOperation for ExternalServiceCreatedAudit {
     fn operation_type(&self) -> String {
         "EXTERNAL_SERVICE_CREATED".to_string()
@@ -192,3 +288,210 @@ impl AuditOperation for ExternalServiceProjectUnlinkedAudit {
             .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
     }
 }
+
+impl AuditOperation for ExternalServiceTunnelOpenedAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_TUNNEL_OPENED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+impl AuditOperation for ExternalServiceTunnelClosedAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_TUNNEL_CLOSED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+impl AuditOperation for ExternalServiceTagsUpdatedAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_TAGS_UPDATED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+impl AuditOperation for ExternalServiceDependenciesUpdatedAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_DEPENDENCIES_UPDATED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+impl AuditOperation for ExternalServiceCredentialsRotatedAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_CREDENTIALS_ROTATED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+impl AuditOperation for ExternalServiceRotationPolicyUpdatedAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_ROTATION_POLICY_UPDATED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+impl AuditOperation for ExternalServiceRecoveryPolicyUpdatedAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_RECOVERY_POLICY_UPDATED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+impl AuditOperation for ExternalServiceScaledAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_SCALED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
+
+impl AuditOperation for ExternalServiceDatabaseImportAudit {
+    fn operation_type(&self) -> String {
+        "EXTERNAL_SERVICE_DATABASE_IMPORT".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation {}", e))
+    }
+}
@@ -0,0 +1,280 @@
+//! Handlers for rotating managed service credentials
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    Json,
+};
+use serde::Deserialize;
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::{
+    error_builder::{bad_request, conflict, internal_server_error, not_found},
+    problemdetails::Problem,
+    AuditContext, RequestMetadata,
+};
+use temps_entities::credential_rotations::ROTATION_TRIGGER_MANUAL;
+use tracing::error;
+use utoipa::ToSchema;
+
+use super::audit::ExternalServiceRotationPolicyUpdatedAudit;
+use super::types::AppState;
+use crate::credential_rotation::{
+    CredentialRotationError, CredentialRotationInfo, CredentialRotationPolicyInfo,
+};
+use crate::services::ExternalServiceError;
+
+impl From<CredentialRotationError> for Problem {
+    fn from(error: CredentialRotationError) -> Self {
+        match error {
+            CredentialRotationError::Unsupported(_) | CredentialRotationError::Validation(_) => {
+                bad_request().detail(error.to_string()).build()
+            }
+            CredentialRotationError::AlreadyRunning(_) => {
+                conflict().detail(error.to_string()).build()
+            }
+            CredentialRotationError::Service(ExternalServiceError::ServiceNotFound { .. }) => {
+                not_found().detail(error.to_string()).build()
+            }
+            CredentialRotationError::Service(_) | CredentialRotationError::Credential(_) => {
+                error!("Credential rotation error: {}", error);
+                internal_server_error().detail(error.to_string()).build()
+            }
+        }
+    }
+}
+
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct SetRotationPolicyRequest {
+    /// Days between scheduled rotations (1-365)
+    #[schema(example = 30)]
+    pub interval_days: i32,
+}
+
+pub fn configure_credential_rotation_routes() -> axum::Router<Arc<AppState>> {
+    axum::Router::new()
+        .route(
+            "/external-services/{id}/credentials/rotations",
+            axum::routing::get(list_credential_rotations).post(rotate_service_credentials),
+        )
+        .route(
+            "/external-services/{id}/credentials/rotation-policy",
+            axum::routing::get(get_rotation_policy)
+                .put(set_rotation_policy)
+                .delete(delete_rotation_policy),
+        )
+}
+
+/// Rotate a managed service's credentials
+///
+/// Creates and verifies a new credential, redeploys the linked apps with it and then
+/// revokes the old one, rolling back if the apps can't be moved over. The rotation
+/// runs in the background; poll the rotation history for its outcome.
+#[utoipa::path(
+    post,
+    path = "/external-services/{id}/credentials/rotations",
+    tag = "External Services",
+    responses(
+        (status = 202, description = "Rotation started", body = CredentialRotationInfo),
+        (status = 400, description = "Service type doesn't support rotation"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 409, description = "A rotation is already running"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn rotate_service_credentials(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    // The rotation is audited once it finishes, with its outcome
+    let context = AuditContext {
+        user_id: auth.user_id(),
+        ip_address: Some(metadata.ip_address.clone()),
+        user_agent: metadata.user_agent.clone(),
+    };
+    let rotation = app_state
+        .credential_rotation
+        .rotate(id, ROTATION_TRIGGER_MANUAL, context)
+        .await?;
+
+    Ok((StatusCode::ACCEPTED, Json(rotation)))
+}
+
+/// List recent credential rotations of a managed service, latest first
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/credentials/rotations",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Rotation history", body = Vec<CredentialRotationInfo>),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn list_credential_rotations(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let rotations = app_state.credential_rotation.list_rotations(id).await?;
+    Ok(Json(rotations))
+}
+
+/// Get a managed service's credential rotation schedule
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/credentials/rotation-policy",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Rotation schedule", body = CredentialRotationPolicyInfo),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No rotation schedule"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn get_rotation_policy(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let policy = app_state
+        .credential_rotation
+        .get_policy(id)
+        .await?
+        .ok_or_else(|| {
+            not_found()
+                .detail("Service credentials are not rotated on a schedule")
+                .build()
+        })?;
+    Ok(Json(policy))
+}
+
+/// Rotate a managed service's credentials on a schedule
+#[utoipa::path(
+    put,
+    path = "/external-services/{id}/credentials/rotation-policy",
+    tag = "External Services",
+    request_body = SetRotationPolicyRequest,
+    responses(
+        (status = 200, description = "Rotation schedule set", body = CredentialRotationPolicyInfo),
+        (status = 400, description = "Invalid interval or unsupported service type"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn set_rotation_policy(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<SetRotationPolicyRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    let policy = app_state
+        .credential_rotation
+        .set_policy(id, request.interval_days, auth.user_id())
+        .await?;
+
+    audit_policy_change(
+        &app_state,
+        auth.user_id(),
+        &metadata,
+        id,
+        Some(policy.interval_days),
+    )
+    .await;
+    Ok(Json(policy))
+}
+
+/// Stop rotating a managed service's credentials on a schedule
+#[utoipa::path(
+    delete,
+    path = "/external-services/{id}/credentials/rotation-policy",
+    tag = "External Services",
+    responses(
+        (status = 204, description = "Rotation schedule removed"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No rotation schedule"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn delete_rotation_policy(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    if !app_state.credential_rotation.delete_policy(id).await? {
+        return Err(not_found()
+            .detail("Service credentials are not rotated on a schedule")
+            .build());
+    }
+
+    audit_policy_change(&app_state, auth.user_id(), &metadata, id, None).await;
+    Ok(StatusCode::NO_CONTENT)
+}
+
+async fn audit_policy_change(
+    app_state: &AppState,
+    user_id: i32,
+    metadata: &RequestMetadata,
+    service_id: i32,
+    interval_days: Option<i32>,
+) {
+    let service_name = match app_state
+        .external_service_manager
+        .get_service_config(service_id)
+        .await
+    {
+        Ok(service) => service.name,
+        Err(_) => service_id.to_string(),
+    };
+    let audit = ExternalServiceRotationPolicyUpdatedAudit {
+        context: AuditContext {
+            user_id,
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id,
+        service_name,
+        interval_days,
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+}
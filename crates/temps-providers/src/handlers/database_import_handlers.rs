@@ -0,0 +1,256 @@
+//! Handlers for importing external databases into managed services
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    Json,
+};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::{
+    error_builder::{bad_request, conflict, internal_server_error, not_found},
+    problemdetails::Problem,
+    AuditContext, RequestMetadata,
+};
+use tracing::error;
+
+use super::audit::ExternalServiceDatabaseImportAudit;
+use super::types::AppState;
+use crate::database_import::{DatabaseImportError, DatabaseImportInfo, ImportDatabaseRequest};
+use crate::services::ExternalServiceError;
+
+impl From<DatabaseImportError> for Problem {
+    fn from(error: DatabaseImportError) -> Self {
+        match error {
+            DatabaseImportError::Validation(_)
+            | DatabaseImportError::VersionMismatch(_)
+            | DatabaseImportError::Source(_)
+            | DatabaseImportError::Service(ExternalServiceError::ParameterValidationFailed {
+                ..
+            }) => bad_request().detail(error.to_string()).build(),
+            DatabaseImportError::InvalidState(_) => conflict().detail(error.to_string()).build(),
+            DatabaseImportError::NotFound(_)
+            | DatabaseImportError::Service(ExternalServiceError::ServiceNotFound { .. }) => {
+                not_found().detail(error.to_string()).build()
+            }
+            DatabaseImportError::Service(_) => {
+                error!("Database import error: {}", error);
+                internal_server_error().detail(error.to_string()).build()
+            }
+        }
+    }
+}
+
+pub fn configure_database_import_routes() -> axum::Router<Arc<AppState>> {
+    axum::Router::new()
+        .route(
+            "/external-services/import-database",
+            axum::routing::post(import_database),
+        )
+        .route(
+            "/external-services/{id}/import",
+            axum::routing::get(get_database_import).delete(stop_database_import),
+        )
+        .route(
+            "/external-services/{id}/import/cutover",
+            axum::routing::post(cutover_database_import),
+        )
+}
+
+/// Import an existing PostgreSQL database into a new managed service
+///
+/// Creates the service and loads the data in the background, from the running
+/// database or from a dump in an S3 source, then compares every table's row count
+/// with the source. With `continuous_sync`, the service instead keeps in sync with
+/// the source through logical replication until it is cut over. The service never
+/// runs an older PostgreSQL major version than the source.
+#[utoipa::path(
+    post,
+    path = "/external-services/import-database",
+    tag = "External Services",
+    request_body = ImportDatabaseRequest,
+    responses(
+        (status = 202, description = "Import started", body = DatabaseImportInfo),
+        (status = 400, description = "Invalid request, unreachable source or version mismatch"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn import_database(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<ImportDatabaseRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesCreate);
+
+    let service_name = request.name.clone();
+    let import = app_state
+        .database_import
+        .start_import(request, auth.user_id())
+        .await?;
+
+    audit_import(
+        &app_state,
+        auth.user_id(),
+        &metadata,
+        service_name,
+        "started",
+        &import,
+    )
+    .await;
+    Ok((StatusCode::ACCEPTED, Json(import)))
+}
+
+/// Get the import of a managed service, with replication progress while it syncs
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/import",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Database import", body = DatabaseImportInfo),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "The service wasn't imported"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn get_database_import(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let import = app_state.database_import.get_import(id).await?;
+    Ok(Json(import))
+}
+
+/// Cut a syncing import over to the managed service
+///
+/// Stop writes to the source first. Once the service has caught up, sequence
+/// values are copied, replication ends and the data is verified; the import is
+/// completed or failed accordingly. Point apps at the service once it completes.
+#[utoipa::path(
+    post,
+    path = "/external-services/{id}/import/cutover",
+    tag = "External Services",
+    responses(
+        (status = 202, description = "Cutover started", body = DatabaseImportInfo),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "The service wasn't imported"),
+        (status = 409, description = "The import isn't syncing or the initial copy isn't done"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn cutover_database_import(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    let import = app_state.database_import.cutover(id).await?;
+
+    let service_name = service_name(&app_state, id).await;
+    audit_import(
+        &app_state,
+        auth.user_id(),
+        &metadata,
+        service_name,
+        "cutover",
+        &import,
+    )
+    .await;
+    Ok((StatusCode::ACCEPTED, Json(import)))
+}
+
+/// Stop syncing an import without cutting over
+///
+/// Replication is removed from both databases; the service keeps the data synced
+/// so far.
+#[utoipa::path(
+    delete,
+    path = "/external-services/{id}/import",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Sync stopped", body = DatabaseImportInfo),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "The service wasn't imported"),
+        (status = 409, description = "The import isn't syncing"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn stop_database_import(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    let import = app_state.database_import.abort(id).await?;
+
+    let service_name = service_name(&app_state, id).await;
+    audit_import(
+        &app_state,
+        auth.user_id(),
+        &metadata,
+        service_name,
+        "stopped",
+        &import,
+    )
+    .await;
+    Ok(Json(import))
+}
+
+async fn service_name(app_state: &AppState, service_id: i32) -> String {
+    match app_state
+        .external_service_manager
+        .get_service_config(service_id)
+        .await
+    {
+        Ok(service) => service.name,
+        Err(_) => service_id.to_string(),
+    }
+}
+
+async fn audit_import(
+    app_state: &AppState,
+    user_id: i32,
+    metadata: &RequestMetadata,
+    service_name: String,
+    event: &str,
+    import: &DatabaseImportInfo,
+) {
+    let audit = ExternalServiceDatabaseImportAudit {
+        context: AuditContext {
+            user_id,
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id: import.service_id,
+        service_name,
+        event: event.to_string(),
+        mode: import.mode.clone(),
+        source: import.source.clone(),
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+}
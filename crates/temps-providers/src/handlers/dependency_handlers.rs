@@ -0,0 +1,176 @@
+//! Handlers for declaring dependencies between managed services and checking whether
+//! a service is ready for its dependents
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, State},
+    response::IntoResponse,
+    Json,
+};
+use serde::Deserialize;
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::{
+    error_builder::{bad_request, internal_server_error, not_found},
+    problemdetails::Problem,
+    AuditContext, RequestMetadata,
+};
+use tracing::error;
+use utoipa::ToSchema;
+
+use super::audit::ExternalServiceDependenciesUpdatedAudit;
+use super::types::AppState;
+use crate::dependencies::{DependencyError, ServiceDependencyInfo};
+use crate::readiness::ServiceReadiness;
+use crate::services::ExternalServiceError;
+
+impl From<DependencyError> for Problem {
+    fn from(error: DependencyError) -> Self {
+        match error {
+            DependencyError::Cycle(_) | DependencyError::Validation(_) => {
+                bad_request().detail(error.to_string()).build()
+            }
+            DependencyError::Service(ExternalServiceError::ServiceNotFound { .. }) => {
+                not_found().detail(error.to_string()).build()
+            }
+            DependencyError::Unhealthy { .. } | DependencyError::Service(_) => {
+                error!("Service dependency error: {}", error);
+                internal_server_error().detail(error.to_string()).build()
+            }
+        }
+    }
+}
+
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct SetServiceDependenciesRequest {
+    /// IDs of the services that must be running before this one starts
+    #[schema(example = json!([1, 2]))]
+    pub depends_on: Vec<i32>,
+}
+
+pub fn configure_dependency_routes() -> axum::Router<Arc<AppState>> {
+    axum::Router::new()
+        .route(
+            "/external-services/{id}/dependencies",
+            axum::routing::get(list_service_dependencies).put(set_service_dependencies),
+        )
+        .route(
+            "/external-services/{id}/readiness",
+            axum::routing::get(get_service_readiness),
+        )
+}
+
+/// List the services a managed service depends on
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/dependencies",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Service dependencies", body = Vec<ServiceDependencyInfo>),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn list_service_dependencies(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let dependencies = app_state.dependency_manager.list_dependencies(id).await?;
+    Ok(Json(dependencies))
+}
+
+/// Check whether a managed service accepts connections
+///
+/// PostgreSQL must accept TCP connections and Redis must answer PING; other services
+/// are ready once their container runs and passes its health check.
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/readiness",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Service readiness", body = ServiceReadiness),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn get_service_readiness(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let readiness = app_state.dependency_manager.readiness(id).await?;
+    Ok(Json(readiness))
+}
+
+/// Replace the services a managed service depends on
+///
+/// Dependencies start first (and must accept connections) when a project is started,
+/// and are stopped last.
+#[utoipa::path(
+    put,
+    path = "/external-services/{id}/dependencies",
+    tag = "External Services",
+    request_body = SetServiceDependenciesRequest,
+    responses(
+        (status = 200, description = "Dependencies updated", body = Vec<ServiceDependencyInfo>),
+        (status = 400, description = "Self-dependency or dependency cycle"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn set_service_dependencies(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<SetServiceDependenciesRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    let dependencies = app_state
+        .dependency_manager
+        .set_dependencies(id, request.depends_on)
+        .await?;
+
+    let service = app_state
+        .external_service_manager
+        .get_service_details(id)
+        .await
+        .map_err(DependencyError::from)?;
+
+    let audit = ExternalServiceDependenciesUpdatedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id: id,
+        service_name: service.service.name,
+        depends_on: dependencies.iter().map(|dep| dep.service_id).collect(),
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+
+    Ok(Json(dependencies))
+}
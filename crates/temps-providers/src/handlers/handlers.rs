@@ -3,7 +3,7 @@ use std::sync::Arc;
 
 use super::types::AppState;
 use axum::{
-    extract::{Extension, Path, State},
+    extract::{Extension, Path, Query, State},
     http::StatusCode,
     response::IntoResponse,
     routing::{delete, get, post, put},
@@ -13,6 +13,7 @@ use temps_auth::permission_guard;
 use temps_auth::RequireAuth;
 use temps_core::{
     error_builder::{bad_request, forbidden, internal_server_error, not_found},
+    pagination::{CursorPage, ListParams},
     problemdetails::Problem,
 };
 use tracing::{error, info};
@@ -20,17 +21,19 @@ use utoipa::OpenApi;
 
 use super::audit::{
     ExternalServiceCreatedAudit, ExternalServiceDeletedAudit, ExternalServiceStatusChangedAudit,
-    ExternalServiceUpdatedAudit,
+    ExternalServiceTagsUpdatedAudit, ExternalServiceUpdatedAudit,
 };
 use crate::handlers::types::{
     AvailableContainerInfo, CreateExternalServiceRequest, EnvironmentVariableInfo,
     ExternalServiceDetails, ExternalServiceInfo, ImportExternalServiceRequest, LinkServiceRequest,
-    ProjectServiceInfo, ProviderMetadata, ServiceParameter, ServiceTypeInfo, ServiceTypeRoute,
-    UpdateExternalServiceRequest, UpgradeExternalServiceRequest,
+    ListServicesParams, ProjectServiceInfo, ProviderMetadata, RegisterExternalServiceRequest,
+    ServiceParameter, ServiceTypeInfo, ServiceTypeRoute, UpdateExternalServiceRequest,
+    UpgradeExternalServiceRequest,
 };
 use crate::services::EnvironmentVariableOptions;
 use temps_core::AuditContext;
 use temps_core::RequestMetadata;
+use temps_entities::tags::Tags;
 
 /// Get available service types
 #[utoipa::path(
@@ -213,6 +216,96 @@ async fn import_external_service(
     Ok((StatusCode::CREATED, Json(service)))
 }
 
+/// Register a database or cache hosted outside of Temps
+///
+/// Stores the connection details of an existing PostgreSQL, Redis or MongoDB
+/// server, like an RDS instance, once it accepted a connection with them. Linked
+/// apps get the same variables as with a managed service; Temps never runs,
+/// provisions or backs it up.
+#[utoipa::path(
+    post,
+    path = "/external-services/register",
+    tag = "External Services",
+    request_body = RegisterExternalServiceRequest,
+    responses(
+        (status = 201, description = "Service registered", body = ExternalServiceInfo),
+        (status = 400, description = "Invalid connection details, or the service didn't accept a connection"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn register_external_service(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<AppState>>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<RegisterExternalServiceRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesCreate);
+
+    request
+        .tags
+        .validate()
+        .map_err(|e| bad_request().detail(e).build())?;
+
+    let service_request = crate::services::RegisterExternalServiceRequest {
+        name: request.name,
+        service_type: request.service_type.into(),
+        version: request.version,
+        parameters: request.parameters,
+    };
+    let mut service = state
+        .external_service_manager
+        .register_service(service_request)
+        .await
+        .map_err(|e| match e {
+            crate::ExternalServiceError::ParameterValidationFailed { .. }
+            | crate::ExternalServiceError::ConnectionFailed { .. } => {
+                bad_request().detail(e.to_string()).build()
+            }
+            e => {
+                error!("Failed to register service: {}", e);
+                internal_server_error()
+                    .detail(format!("Failed to register service: {}", e))
+                    .build()
+            }
+        })?;
+
+    if !request.tags.is_empty() {
+        service = state
+            .external_service_manager
+            .set_service_tags(service.id, request.tags)
+            .await
+            .map_err(|e| {
+                internal_server_error()
+                    .detail(format!(
+                        "Service registered but its tags could not be saved: {}",
+                        e
+                    ))
+                    .build()
+            })?;
+    }
+
+    let audit = ExternalServiceCreatedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id: service.id,
+        name: service.name.clone(),
+        service_type: service.service_type.to_string(),
+        version: service.version.clone(),
+    };
+
+    if let Err(e) = state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+
+    Ok((StatusCode::CREATED, Json(service)))
+}
+
 pub fn configure_routes() -> Router<Arc<AppState>> {
     Router::new()
         .route("/external-services", get(list_services))
@@ -222,6 +315,10 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
             get(list_available_containers),
         )
         .route("/external-services/import", post(import_external_service))
+        .route(
+            "/external-services/register",
+            post(register_external_service),
+        )
         .route("/external-services/types", get(get_service_types))
         .route(
             "/external-services/providers/metadata",
@@ -238,6 +335,7 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
         .route("/external-services/{id}", get(get_service))
         .route("/external-services/{id}", put(update_service))
         .route("/external-services/{id}", delete(delete_service))
+        .route("/external-services/{id}/tags", put(set_service_tags))
         .route("/external-services/{id}/health", get(check_health))
         .route("/external-services/{id}/start", post(start_service))
         .route("/external-services/{id}/stop", post(stop_service))
@@ -283,6 +381,13 @@ pub fn configure_routes() -> Router<Arc<AppState>> {
             get(get_service_by_slug),
         )
         .merge(super::query_handlers::configure_query_routes())
+        .merge(super::tunnel_handlers::configure_tunnel_routes())
+        .merge(super::dependency_handlers::configure_dependency_routes())
+        .merge(super::postgres_insights_handlers::configure_postgres_insights_routes())
+        .merge(super::credential_rotation_handlers::configure_credential_rotation_routes())
+        .merge(super::service_recovery_handlers::configure_service_recovery_routes())
+        .merge(super::service_scaling_handlers::configure_service_scaling_routes())
+        .merge(super::database_import_handlers::configure_database_import_routes())
 }
 
 /// Get parameter schema for a specific service type
@@ -322,23 +427,61 @@ async fn get_service_type_parameters(
 }
 
 /// Get all external services
+///
+/// Passing `cursor` (empty for the first page) pages by cursor and answers with a
+/// `CursorPage<ExternalServiceInfo>`. Services sort by `created_at`, `name` or `id`
+/// and filter by `name`, `service_type` and `status`, alongside `tags`.
 #[utoipa::path(
     get,
     path = "/external-services",
     tag = "External Services",
+    params(ListServicesParams, ListParams),
     responses(
         (status = 200, description = "List of external services", body = Vec<ExternalServiceInfo>),
+        (status = 400, description = "Invalid tag filter, cursor, sort or filter"),
         (status = 500, description = "Internal server error")
     )
 )]
 async fn list_services(
     RequireAuth(auth): RequireAuth,
     State(app_state): State<Arc<AppState>>,
+    Query(params): Query<ListServicesParams>,
+    Query(list): Query<ListParams>,
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, ExternalServicesRead);
 
-    match app_state.external_service_manager.list_services().await {
-        Ok(services) => Ok((StatusCode::OK, Json(services))),
+    let tags = match params.tags.as_deref() {
+        Some(selector) => Tags::parse_selector(selector)
+            .map_err(|e| bad_request().title("Invalid tag filter").detail(e).build())?,
+        None => Tags::default(),
+    };
+
+    if list.uses_cursor() {
+        let request = list.resolve(&crate::services::SERVICE_LIST)?;
+        return match app_state
+            .external_service_manager
+            .list_services_page(&tags, &request)
+            .await
+        {
+            Ok(page) => Ok((StatusCode::OK, Json(page)).into_response()),
+            Err(e @ crate::ExternalServiceError::InvalidListQuery { .. }) => {
+                Err(bad_request().detail(e.to_string()).build())
+            }
+            Err(e) => {
+                error!("Failed to list services: {}", e);
+                Err(internal_server_error()
+                    .detail(format!("Failed to list services: {}", e))
+                    .build())
+            }
+        };
+    }
+
+    match app_state
+        .external_service_manager
+        .list_services_with_tags(&tags)
+        .await
+    {
+        Ok(services) => Ok((StatusCode::OK, Json(services)).into_response()),
         Err(e) => {
             error!("Failed to list services: {}", e);
             Err(internal_server_error()
@@ -404,6 +547,12 @@ async fn create_service(
 ) -> Result<impl IntoResponse, Problem> {
     permission_guard!(auth, ExternalServicesCreate);
 
+    // Checked before provisioning so invalid tags don't leave a new service behind
+    request
+        .tags
+        .validate()
+        .map_err(|e| bad_request().detail(e).build())?;
+
     let service_config = crate::services::CreateExternalServiceRequest {
         name: request.name.clone(),
         service_type: request.service_type.into(),
@@ -413,10 +562,25 @@ async fn create_service(
 
     match app_state
         .external_service_manager
-        .create_service(service_config)
+        .create_service_with_seed(service_config, request.seed)
         .await
     {
-        Ok(service) => {
+        Ok(mut service) => {
+            if !request.tags.is_empty() {
+                service = app_state
+                    .external_service_manager
+                    .set_service_tags(service.id, request.tags)
+                    .await
+                    .map_err(|e| {
+                        internal_server_error()
+                            .detail(format!(
+                                "Service created but its tags could not be saved: {}",
+                                e
+                            ))
+                            .build()
+                    })?;
+            }
+
             // Create audit log with metadata
             let audit = ExternalServiceCreatedAudit {
                 context: AuditContext {
@@ -441,6 +605,14 @@ async fn create_service(
             info!("Failed to create service: {}", error_msg);
             if error_msg.contains("validation failed") {
                 Err(bad_request().detail(&error_msg).build())
+            } else if matches!(e, crate::ExternalServiceError::SeedingFailed { .. }) {
+                Err(bad_request()
+                    .title("Seeding Failed")
+                    .detail(format!(
+                        "The service was removed because its initial data could not be loaded: {}",
+                        e
+                    ))
+                    .build())
             } else {
                 Err(internal_server_error()
                     .detail(format!("Failed to create service: {}", e))
@@ -524,7 +696,13 @@ async fn update_service(
         }
         Err(e) => match e.to_string().as_str() {
             "Service not found" => Err(not_found().detail("Service not found").build()),
-            _ if e.to_string().contains("validation failed") => {
+            _ if e.to_string().contains("validation failed")
+                || matches!(
+                    e,
+                    crate::ExternalServiceError::NotManaged { .. }
+                        | crate::ExternalServiceError::ConnectionFailed { .. }
+                ) =>
+            {
                 Err(bad_request().detail(e.to_string()).build())
             }
             _ => Err(internal_server_error()
@@ -534,6 +712,68 @@ async fn update_service(
     }
 }
 
+/// Replace the tags of an external service
+///
+/// Tags organize services and select them in list filters and bulk operations.
+#[utoipa::path(
+    put,
+    path = "/external-services/{id}/tags",
+    tag = "External Services",
+    request_body = Tags,
+    responses(
+        (status = 200, description = "Tags updated", body = ExternalServiceInfo),
+        (status = 400, description = "Invalid tags"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    )
+)]
+async fn set_service_tags(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(tags): Json<Tags>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    match app_state
+        .external_service_manager
+        .set_service_tags(id, tags)
+        .await
+    {
+        Ok(service) => {
+            let audit = ExternalServiceTagsUpdatedAudit {
+                context: AuditContext {
+                    user_id: auth.user_id(),
+                    ip_address: Some(metadata.ip_address.clone()),
+                    user_agent: metadata.user_agent.clone(),
+                },
+                service_id: service.id,
+                name: service.name.clone(),
+                tags: service.tags.clone(),
+            };
+
+            if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+                error!("Failed to create audit log: {}", e);
+            }
+
+            Ok((StatusCode::OK, Json(service)))
+        }
+        Err(crate::ExternalServiceError::ServiceNotFound { .. }) => {
+            Err(not_found().detail("Service not found").build())
+        }
+        Err(crate::ExternalServiceError::ParameterValidationFailed { reason, .. }) => {
+            Err(bad_request().detail(reason).build())
+        }
+        Err(e) => Err(internal_server_error()
+            .detail(format!("Failed to update tags: {}", e))
+            .build()),
+    }
+}
+
 /// Upgrade external service to new Docker image with data migration
 /// This endpoint uses service-specific upgrade procedures (e.g., pg_upgrade for PostgreSQL)
 #[utoipa::path(
@@ -590,7 +830,9 @@ async fn upgrade_service(
         }
         Err(e) => match e.to_string().as_str() {
             "Service not found" => Err(not_found().detail("Service not found").build()),
-            msg if msg.contains("Upgrade not implemented") => {
+            msg if msg.contains("Upgrade not implemented")
+                || matches!(e, crate::ExternalServiceError::NotManaged { .. }) =>
+            {
                 Err(bad_request().detail(msg).build())
             }
             _ => Err(internal_server_error()
@@ -630,6 +872,8 @@ async fn delete_service(
         Ok(service_details) => {
             match app_state.external_service_manager.delete_service(id).await {
                 Ok(_) => {
+                    app_state.tunnel_manager.close_service_tunnels(id).await;
+
                     // Create audit log with metadata
                     let audit = ExternalServiceDeletedAudit {
                         context: AuditContext {
@@ -762,6 +1006,9 @@ async fn start_service(
                     error!("Failed to start service: {}", e);
                     match e.to_string().as_str() {
                         "Service not found" => Err(not_found().detail("Service not found").build()),
+                        msg if matches!(e, crate::ExternalServiceError::NotManaged { .. }) => {
+                            Err(bad_request().detail(msg).build())
+                        }
                         _ => Err(internal_server_error()
                             .detail(format!("Failed to start service: {}", e))
                             .build()),
@@ -831,6 +1078,9 @@ async fn stop_service(
                     error!("Failed to stop service: {}", e);
                     match e.to_string().as_str() {
                         "Service not found" => Err(not_found().detail("Service not found").build()),
+                        msg if matches!(e, crate::ExternalServiceError::NotManaged { .. }) => {
+                            Err(bad_request().detail(msg).build())
+                        }
                         _ => Err(internal_server_error()
                             .detail(format!("Failed to stop service: {}", e))
                             .build()),
@@ -852,6 +1102,7 @@ async fn stop_service(
     request_body = LinkServiceRequest,
     responses(
         (status = 201, description = "Service linked to project successfully", body = ProjectServiceInfo),
+        (status = 400, description = "Invalid connection limit"),
         (status = 404, description = "Service or project not found"),
         (status = 500, description = "Internal server error")
     ),
@@ -869,10 +1120,13 @@ async fn link_service_to_project(
 
     match app_state
         .external_service_manager
-        .link_service_to_project(id, request.project_id)
+        .link_service_to_project(id, request.project_id, request.connection_limit)
         .await
     {
         Ok(info) => Ok((StatusCode::CREATED, Json(info))),
+        Err(e @ crate::ExternalServiceError::ParameterValidationFailed { .. }) => {
+            Err(bad_request().detail(e.to_string()).build())
+        }
         Err(e) => match e.to_string().as_str() {
             "Service not found" | "Project not found" => {
                 Err(not_found().detail(e.to_string()).build())
@@ -1274,7 +1528,9 @@ async fn get_service_preview_environment_variables_masked(
         create_service,
         list_available_containers,
         import_external_service,
+        register_external_service,
         update_service,
+        set_service_tags,
         upgrade_service,
         delete_service,
         start_service,
@@ -1297,6 +1553,29 @@ async fn get_service_preview_environment_variables_masked(
         super::query_handlers::get_entity_info,
         super::query_handlers::query_data,
         super::query_handlers::download_object,
+        super::tunnel_handlers::open_service_tunnel,
+        super::tunnel_handlers::list_service_tunnels,
+        super::tunnel_handlers::close_service_tunnel,
+        super::dependency_handlers::list_service_dependencies,
+        super::dependency_handlers::set_service_dependencies,
+        super::dependency_handlers::get_service_readiness,
+        super::postgres_insights_handlers::get_postgres_metrics,
+        super::postgres_insights_handlers::get_postgres_slow_queries,
+        super::postgres_insights_handlers::set_pg_stat_statements,
+        super::credential_rotation_handlers::rotate_service_credentials,
+        super::credential_rotation_handlers::list_credential_rotations,
+        super::credential_rotation_handlers::get_rotation_policy,
+        super::credential_rotation_handlers::set_rotation_policy,
+        super::credential_rotation_handlers::delete_rotation_policy,
+        super::service_recovery_handlers::get_recovery_policy,
+        super::service_recovery_handlers::set_recovery_policy,
+        super::service_recovery_handlers::delete_recovery_policy,
+        super::service_scaling_handlers::scale_service,
+        super::service_scaling_handlers::list_service_scalings,
+        super::database_import_handlers::import_database,
+        super::database_import_handlers::get_database_import,
+        super::database_import_handlers::cutover_database_import,
+        super::database_import_handlers::stop_database_import,
     ),
     components(schemas(
         ServiceTypeInfo,
@@ -1305,10 +1584,14 @@ async fn get_service_preview_environment_variables_masked(
         ProviderMetadata,
         ExternalServiceDetails,
         ExternalServiceInfo,
+        CursorPage<ExternalServiceInfo>,
         CreateExternalServiceRequest,
+        crate::ServiceSeed,
+        Tags,
         UpdateExternalServiceRequest,
         UpgradeExternalServiceRequest,
         ImportExternalServiceRequest,
+        RegisterExternalServiceRequest,
         AvailableContainerInfo,
         LinkServiceRequest,
         ProjectServiceInfo,
@@ -1322,6 +1605,36 @@ async fn get_service_preview_environment_variables_masked(
         super::query_handlers::FieldResponse,
         super::query_handlers::QueryDataRequest,
         super::query_handlers::QueryDataResponse,
+        super::tunnel_handlers::OpenServiceTunnelRequest,
+        super::tunnel_handlers::OpenServiceTunnelResponse,
+        crate::tunnel::ServiceTunnelInfo,
+        super::dependency_handlers::SetServiceDependenciesRequest,
+        crate::readiness::ServiceReadiness,
+        crate::dependencies::ServiceDependencyInfo,
+        super::postgres_insights_handlers::SetPgStatStatementsRequest,
+        crate::postgres_insights::PostgresMetrics,
+        crate::postgres_insights::ConnectionMetrics,
+        crate::postgres_insights::TransactionMetrics,
+        crate::postgres_insights::ReplicationMetrics,
+        crate::postgres_insights::ReplicaLag,
+        crate::postgres_insights::SlowQueries,
+        crate::postgres_insights::SlowQuery,
+        crate::postgres_insights::SlowQuerySort,
+        super::credential_rotation_handlers::SetRotationPolicyRequest,
+        crate::credential_rotation::CredentialRotationInfo,
+        crate::credential_rotation::CredentialRotationPolicyInfo,
+        super::service_recovery_handlers::SetRecoveryPolicyRequest,
+        crate::service_recovery::RecoveryAction,
+        crate::service_recovery::ServiceRecoveryPolicyInfo,
+        crate::externalsvc::ResourceLimits,
+        crate::service_scaling::ServiceScalingInfo,
+        crate::database_import::ImportDatabaseRequest,
+        crate::database_import::ImportSource,
+        crate::database_import::SourceConnection,
+        crate::database_import::DatabaseImportInfo,
+        crate::database_import::ImportVerification,
+        crate::database_import::TableVerification,
+        crate::database_import::ReplicationProgress,
     )),
     info(
         title = "External Services API",
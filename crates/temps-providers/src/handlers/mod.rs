@@ -1,8 +1,22 @@
 pub mod audit;
+pub mod credential_rotation_handlers;
+pub mod database_import_handlers;
+pub mod dependency_handlers;
 #[allow(clippy::module_inception)]
 pub mod handlers;
+pub mod postgres_insights_handlers;
 pub mod query_handlers;
+pub mod service_recovery_handlers;
+pub mod service_scaling_handlers;
+pub mod tunnel_handlers;
 pub mod types;
 pub use audit::*;
+pub use credential_rotation_handlers::*;
+pub use database_import_handlers::*;
+pub use dependency_handlers::*;
 pub use handlers::*;
+pub use postgres_insights_handlers::*;
 pub use query_handlers::*;
+pub use service_recovery_handlers::*;
+pub use service_scaling_handlers::*;
+pub use tunnel_handlers::*;
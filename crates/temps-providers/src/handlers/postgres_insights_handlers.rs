@@ -0,0 +1,196 @@
+//! Handlers for PostgreSQL metrics and slow-query insights
+
+use std::collections::HashMap;
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, Query, State},
+    response::IntoResponse,
+    Json,
+};
+use serde::Deserialize;
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::{
+    error_builder::{bad_request, internal_server_error, not_found},
+    problemdetails::Problem,
+    AuditContext, RequestMetadata,
+};
+use tracing::error;
+use utoipa::{IntoParams, ToSchema};
+
+use super::audit::ExternalServiceUpdatedAudit;
+use super::types::AppState;
+use crate::postgres_insights::{PostgresInsightsError, SlowQuerySort};
+use crate::services::ExternalServiceError;
+
+impl From<PostgresInsightsError> for Problem {
+    fn from(error: PostgresInsightsError) -> Self {
+        match error {
+            PostgresInsightsError::NotPostgres(_) => {
+                bad_request().detail(error.to_string()).build()
+            }
+            PostgresInsightsError::Service(ExternalServiceError::ServiceNotFound { .. }) => {
+                not_found().detail(error.to_string()).build()
+            }
+            PostgresInsightsError::Service(_)
+            | PostgresInsightsError::Connection(_)
+            | PostgresInsightsError::Query(_) => {
+                error!("PostgreSQL insights error: {}", error);
+                internal_server_error().detail(error.to_string()).build()
+            }
+        }
+    }
+}
+
+fn default_slow_query_limit() -> u32 {
+    20
+}
+
+#[derive(Debug, Deserialize, IntoParams)]
+pub struct SlowQueriesParams {
+    /// Number of queries to return (1-100, defaults to 20)
+    #[serde(default = "default_slow_query_limit")]
+    pub limit: u32,
+    /// Ranking: total_time (default), mean_time or calls
+    #[serde(default)]
+    #[param(value_type = Option<String>)]
+    pub sort: SlowQuerySort,
+}
+
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct SetPgStatStatementsRequest {
+    /// Whether to record query statistics with pg_stat_statements
+    pub enabled: bool,
+}
+
+pub fn configure_postgres_insights_routes() -> axum::Router<Arc<AppState>> {
+    axum::Router::new()
+        .route(
+            "/external-services/{id}/postgres/metrics",
+            axum::routing::get(get_postgres_metrics),
+        )
+        .route(
+            "/external-services/{id}/postgres/slow-queries",
+            axum::routing::get(get_postgres_slow_queries),
+        )
+        .route(
+            "/external-services/{id}/postgres/pg-stat-statements",
+            axum::routing::put(set_pg_stat_statements),
+        )
+}
+
+/// Get connection, cache, transaction, deadlock and replication metrics of a PostgreSQL service
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/postgres/metrics",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Current metrics", body = crate::postgres_insights::PostgresMetrics),
+        (status = 400, description = "Service is not PostgreSQL"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn get_postgres_metrics(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let metrics = app_state.postgres_insights.get_metrics(id).await?;
+    Ok(Json(metrics))
+}
+
+/// Get the slowest queries of a PostgreSQL service, as recorded by pg_stat_statements
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/postgres/slow-queries",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Slowest queries", body = crate::postgres_insights::SlowQueries),
+        (status = 400, description = "Service is not PostgreSQL"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID"),
+        SlowQueriesParams
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn get_postgres_slow_queries(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Query(params): Query<SlowQueriesParams>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let queries = app_state
+        .postgres_insights
+        .get_slow_queries(id, params.sort, params.limit)
+        .await?;
+    Ok(Json(queries))
+}
+
+/// Enable or disable pg_stat_statements query statistics
+///
+/// Changing the setting restarts the service container.
+#[utoipa::path(
+    put,
+    path = "/external-services/{id}/postgres/pg-stat-statements",
+    tag = "External Services",
+    request_body = SetPgStatStatementsRequest,
+    responses(
+        (status = 200, description = "Setting applied", body = super::types::ExternalServiceInfo),
+        (status = 400, description = "Service is not PostgreSQL"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn set_pg_stat_statements(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<SetPgStatStatementsRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    let service = app_state
+        .postgres_insights
+        .set_pg_stat_statements(id, request.enabled)
+        .await?;
+
+    let audit = ExternalServiceUpdatedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id: service.id,
+        name: service.name.clone(),
+        service_type: service.service_type.to_string(),
+        updated_parameters: HashMap::from([(
+            "pg_stat_statements".to_string(),
+            request.enabled.to_string(),
+        )]),
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+
+    Ok(Json(service))
+}
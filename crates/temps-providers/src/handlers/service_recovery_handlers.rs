@@ -0,0 +1,197 @@
+//! Handlers for restarting linked apps when a managed service recovers
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    Json,
+};
+use serde::Deserialize;
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::{
+    error_builder::{bad_request, internal_server_error, not_found},
+    problemdetails::Problem,
+    AuditContext, RequestMetadata,
+};
+use tracing::error;
+use utoipa::ToSchema;
+
+use super::audit::ExternalServiceRecoveryPolicyUpdatedAudit;
+use super::types::AppState;
+use crate::service_recovery::{RecoveryAction, ServiceRecoveryError, ServiceRecoveryPolicyInfo};
+use crate::services::ExternalServiceError;
+
+impl From<ServiceRecoveryError> for Problem {
+    fn from(error: ServiceRecoveryError) -> Self {
+        match error {
+            ServiceRecoveryError::Validation(_) => bad_request().detail(error.to_string()).build(),
+            ServiceRecoveryError::Service(ExternalServiceError::ServiceNotFound { .. }) => {
+                not_found().detail(error.to_string()).build()
+            }
+            ServiceRecoveryError::Service(_) => {
+                error!("Service recovery error: {}", error);
+                internal_server_error().detail(error.to_string()).build()
+            }
+        }
+    }
+}
+
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct SetRecoveryPolicyRequest {
+    /// What to do with the linked apps once the service is healthy again
+    pub action: RecoveryAction,
+    /// Least time between two automatic actions, in seconds (60-86400, default 600)
+    #[serde(default)]
+    #[schema(example = 600)]
+    pub cooldown_seconds: Option<i32>,
+}
+
+pub fn configure_service_recovery_routes() -> axum::Router<Arc<AppState>> {
+    axum::Router::new().route(
+        "/external-services/{id}/recovery-policy",
+        axum::routing::get(get_recovery_policy)
+            .put(set_recovery_policy)
+            .delete(delete_recovery_policy),
+    )
+}
+
+/// Get what happens to a managed service's linked apps when it recovers
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/recovery-policy",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Recovery policy", body = ServiceRecoveryPolicyInfo),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No recovery policy"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn get_recovery_policy(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let policy = app_state
+        .service_recovery
+        .get_policy(id)
+        .await?
+        .ok_or_else(|| {
+            not_found()
+                .detail("Linked apps are not restarted when this service recovers")
+                .build()
+        })?;
+    Ok(Json(policy))
+}
+
+/// Restart or redeploy a managed service's linked apps when it recovers from an outage
+///
+/// For apps that don't reconnect on their own. At most one automatic restart or
+/// redeploy runs per cooldown, however often the service goes down.
+#[utoipa::path(
+    put,
+    path = "/external-services/{id}/recovery-policy",
+    tag = "External Services",
+    request_body = SetRecoveryPolicyRequest,
+    responses(
+        (status = 200, description = "Recovery policy set", body = ServiceRecoveryPolicyInfo),
+        (status = 400, description = "Invalid cooldown"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn set_recovery_policy(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<SetRecoveryPolicyRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    let policy = app_state
+        .service_recovery
+        .set_policy(id, request.action, request.cooldown_seconds, auth.user_id())
+        .await?;
+
+    audit_policy_change(&app_state, auth.user_id(), &metadata, id, Some(&policy)).await;
+    Ok(Json(policy))
+}
+
+/// Stop restarting a managed service's linked apps when it recovers
+#[utoipa::path(
+    delete,
+    path = "/external-services/{id}/recovery-policy",
+    tag = "External Services",
+    responses(
+        (status = 204, description = "Recovery policy removed"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "No recovery policy"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn delete_recovery_policy(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    if !app_state.service_recovery.delete_policy(id).await? {
+        return Err(not_found()
+            .detail("Linked apps are not restarted when this service recovers")
+            .build());
+    }
+
+    audit_policy_change(&app_state, auth.user_id(), &metadata, id, None).await;
+    Ok(StatusCode::NO_CONTENT)
+}
+
+async fn audit_policy_change(
+    app_state: &AppState,
+    user_id: i32,
+    metadata: &RequestMetadata,
+    service_id: i32,
+    policy: Option<&ServiceRecoveryPolicyInfo>,
+) {
+    let service_name = match app_state
+        .external_service_manager
+        .get_service_config(service_id)
+        .await
+    {
+        Ok(service) => service.name,
+        Err(_) => service_id.to_string(),
+    };
+    let audit = ExternalServiceRecoveryPolicyUpdatedAudit {
+        context: AuditContext {
+            user_id,
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id,
+        service_name,
+        action: policy.map(|policy| policy.action.as_str().to_string()),
+        cooldown_seconds: policy.map(|policy| policy.cooldown_seconds),
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+}
@@ -0,0 +1,122 @@
+//! Handlers for changing the CPU and memory limits of managed services
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    Json,
+};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::{
+    error_builder::{bad_request, conflict, internal_server_error, not_found},
+    problemdetails::Problem,
+    AuditContext, RequestMetadata,
+};
+use tracing::error;
+
+use super::types::AppState;
+use crate::externalsvc::ResourceLimits;
+use crate::service_scaling::{ServiceScalingError, ServiceScalingInfo};
+use crate::services::ExternalServiceError;
+
+impl From<ServiceScalingError> for Problem {
+    fn from(error: ServiceScalingError) -> Self {
+        match error {
+            ServiceScalingError::Validation(_)
+            | ServiceScalingError::InsufficientCapacity(_)
+            | ServiceScalingError::Service(ExternalServiceError::NotManaged { .. }) => {
+                bad_request().detail(error.to_string()).build()
+            }
+            ServiceScalingError::AlreadyRunning(_) => conflict().detail(error.to_string()).build(),
+            ServiceScalingError::Service(ExternalServiceError::ServiceNotFound { .. }) => {
+                not_found().detail(error.to_string()).build()
+            }
+            ServiceScalingError::Service(_) | ServiceScalingError::Docker(_) => {
+                error!("Service scaling error: {}", error);
+                internal_server_error().detail(error.to_string()).build()
+            }
+        }
+    }
+}
+
+pub fn configure_service_scaling_routes() -> axum::Router<Arc<AppState>> {
+    axum::Router::new().route(
+        "/external-services/{id}/scalings",
+        axum::routing::get(list_service_scalings).post(scale_service),
+    )
+}
+
+/// Change the CPU and memory limits of a managed service
+///
+/// Limits left out keep their current value; a limit can be changed but not
+/// removed. Services that can take the new size online keep running; the others
+/// are restarted in place, after their linked apps are notified. The scaling runs
+/// in the background; poll the scaling history for its outcome.
+#[utoipa::path(
+    post,
+    path = "/external-services/{id}/scalings",
+    tag = "External Services",
+    request_body = ResourceLimits,
+    responses(
+        (status = 202, description = "Scaling started", body = ServiceScalingInfo),
+        (status = 400, description = "Invalid size, or more than the node has"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Service not found"),
+        (status = 409, description = "A scaling is already running"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn scale_service(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<ResourceLimits>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    // The scaling is audited once it finishes, with its outcome
+    let context = AuditContext {
+        user_id: auth.user_id(),
+        ip_address: Some(metadata.ip_address.clone()),
+        user_agent: metadata.user_agent.clone(),
+    };
+    let scaling = app_state
+        .service_scaling
+        .scale(id, request, context)
+        .await?;
+
+    Ok((StatusCode::ACCEPTED, Json(scaling)))
+}
+
+/// List recent changes to a managed service's CPU and memory limits, latest first
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/scalings",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Scaling history", body = Vec<ServiceScalingInfo>),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn list_service_scalings(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let scalings = app_state.service_scaling.list_scalings(id).await?;
+    Ok(Json(scalings))
+}
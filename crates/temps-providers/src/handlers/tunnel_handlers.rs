@@ -0,0 +1,231 @@
+//! Handlers for time-boxed tunnels to managed services
+
+use std::collections::HashMap;
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, State},
+    http::StatusCode,
+    response::IntoResponse,
+    Json,
+};
+use serde::{Deserialize, Serialize};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::{
+    error_builder::{bad_request, forbidden, internal_server_error, not_found},
+    problemdetails::Problem,
+    AuditContext, RequestMetadata,
+};
+use tracing::error;
+use utoipa::ToSchema;
+
+use super::audit::{ExternalServiceTunnelClosedAudit, ExternalServiceTunnelOpenedAudit};
+use super::types::AppState;
+use crate::services::ExternalServiceError;
+use crate::tunnel::{OpenTunnel, ServiceTunnelInfo, TunnelCloseReason, TunnelError};
+
+impl From<TunnelError> for Problem {
+    fn from(error: TunnelError) -> Self {
+        match error {
+            TunnelError::Disabled => forbidden()
+                .detail("Service tunnels are disabled. Enable them in Settings.")
+                .build(),
+            TunnelError::NotFound(_) => not_found().detail(error.to_string()).build(),
+            TunnelError::Validation(_) => bad_request().detail(error.to_string()).build(),
+            TunnelError::Service(ExternalServiceError::ServiceNotFound { .. }) => {
+                not_found().detail(error.to_string()).build()
+            }
+            TunnelError::Service(_) | TunnelError::Io(_) => {
+                error!("Service tunnel error: {}", error);
+                internal_server_error().detail(error.to_string()).build()
+            }
+        }
+    }
+}
+
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct OpenServiceTunnelRequest {
+    /// Tunnel lifetime in minutes (defaults to the configured default)
+    #[schema(example = 60)]
+    pub ttl_minutes: Option<u32>,
+    /// Source IPs or CIDRs allowed to connect (defaults to the caller's IP)
+    #[schema(example = json!(["203.0.113.7"]))]
+    #[serde(default)]
+    pub allowed_sources: Vec<String>,
+}
+
+#[derive(Debug, Serialize, ToSchema)]
+pub struct OpenServiceTunnelResponse {
+    #[serde(flatten)]
+    pub tunnel: ServiceTunnelInfo,
+    /// Credentials for connecting to the service through the tunnel: a user issued
+    /// for the tunnel and revoked when it closes or expires, and the database
+    pub credentials: HashMap<String, String>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct TunnelPath {
+    pub id: i32,
+    pub tunnel_id: String,
+}
+
+pub fn configure_tunnel_routes() -> axum::Router<Arc<AppState>> {
+    axum::Router::new()
+        .route(
+            "/external-services/{id}/tunnels",
+            axum::routing::get(list_service_tunnels).post(open_service_tunnel),
+        )
+        .route(
+            "/external-services/{id}/tunnels/{tunnel_id}",
+            axum::routing::delete(close_service_tunnel),
+        )
+}
+
+/// Open a time-boxed tunnel exposing a managed service on a port of the Temps host
+#[utoipa::path(
+    post,
+    path = "/external-services/{id}/tunnels",
+    tag = "External Services",
+    request_body = OpenServiceTunnelRequest,
+    responses(
+        (status = 201, description = "Tunnel opened", body = OpenServiceTunnelResponse),
+        (status = 400, description = "Invalid lifetime or source network, or tunnels not supported for the service"),
+        (status = 403, description = "Insufficient permissions or tunnels disabled"),
+        (status = 404, description = "Service not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn open_service_tunnel(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<OpenServiceTunnelRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    let allowed_sources = if request.allowed_sources.is_empty() {
+        vec![metadata.ip_address.clone()]
+    } else {
+        request.allowed_sources
+    };
+
+    let opened = app_state
+        .tunnel_manager
+        .open_tunnel(
+            id,
+            auth.user_id(),
+            OpenTunnel {
+                ttl_minutes: request.ttl_minutes,
+                allowed_sources,
+            },
+        )
+        .await?;
+
+    let audit = ExternalServiceTunnelOpenedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id: id,
+        service_name: opened.tunnel.service_name.clone(),
+        tunnel_id: opened.tunnel.id.clone(),
+        port: opened.tunnel.port,
+        allowed_sources: opened.tunnel.allowed_sources.clone(),
+        expires_at: opened.tunnel.expires_at.to_rfc3339(),
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+
+    Ok((
+        StatusCode::CREATED,
+        Json(OpenServiceTunnelResponse {
+            tunnel: opened.tunnel,
+            credentials: opened.credentials,
+        }),
+    ))
+}
+
+/// List open tunnels for a managed service
+#[utoipa::path(
+    get,
+    path = "/external-services/{id}/tunnels",
+    tag = "External Services",
+    responses(
+        (status = 200, description = "Open tunnels", body = Vec<ServiceTunnelInfo>),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn list_service_tunnels(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesRead);
+
+    let tunnels = app_state.tunnel_manager.list_tunnels(Some(id)).await;
+    Ok(Json(tunnels))
+}
+
+/// Close an open tunnel, dropping its active connections
+#[utoipa::path(
+    delete,
+    path = "/external-services/{id}/tunnels/{tunnel_id}",
+    tag = "External Services",
+    responses(
+        (status = 204, description = "Tunnel closed"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Tunnel not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    params(
+        ("id" = i32, Path, description = "External service ID"),
+        ("tunnel_id" = String, Path, description = "Tunnel ID")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn close_service_tunnel(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<AppState>>,
+    Path(path): Path<TunnelPath>,
+    Extension(metadata): Extension<RequestMetadata>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ExternalServicesWrite);
+
+    let tunnel = app_state.tunnel_manager.get_tunnel(&path.tunnel_id).await?;
+    if tunnel.service_id != path.id {
+        return Err(TunnelError::NotFound(path.tunnel_id).into());
+    }
+
+    let tunnel = app_state
+        .tunnel_manager
+        .close_tunnel(&path.tunnel_id, TunnelCloseReason::Manual)
+        .await?;
+
+    let audit = ExternalServiceTunnelClosedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        service_id: tunnel.service_id,
+        service_name: tunnel.service_name,
+        tunnel_id: tunnel.id,
+    };
+    if let Err(e) = app_state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+
+    Ok(StatusCode::NO_CONTENT)
+}
@@ -1,9 +1,14 @@
-use crate::{ExternalServiceManager, QueryService};
+use crate::{
+    CredentialRotationService, DatabaseImportService, ExternalServiceManager,
+    PostgresInsightsService, QueryService, ServiceDependencyManager, ServiceRecoveryService,
+    ServiceScalingService, ServiceTunnelManager,
+};
 
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 use std::sync::Arc;
-use utoipa::ToSchema;
+use temps_entities::tags::Tags;
+use utoipa::{IntoParams, ToSchema};
 
 use temps_core::AuditLogger;
 
@@ -11,6 +16,13 @@ pub struct AppState {
     pub external_service_manager: Arc<ExternalServiceManager>,
     pub audit_service: Arc<dyn AuditLogger>,
     pub query_service: Arc<QueryService>,
+    pub tunnel_manager: Arc<ServiceTunnelManager>,
+    pub dependency_manager: Arc<ServiceDependencyManager>,
+    pub postgres_insights: Arc<PostgresInsightsService>,
+    pub credential_rotation: Arc<CredentialRotationService>,
+    pub service_recovery: Arc<ServiceRecoveryService>,
+    pub service_scaling: Arc<ServiceScalingService>,
+    pub database_import: Arc<DatabaseImportService>,
 }
 
 #[derive(Debug, Serialize, Deserialize, ToSchema)]
@@ -156,6 +168,11 @@ pub struct ExternalServiceInfo {
     pub connection_info: Option<String>,
     pub created_at: String,
     pub updated_at: String,
+    /// Key/value tags of the service
+    pub tags: Tags,
+    /// Whether Temps runs the service; `false` for databases hosted elsewhere,
+    /// which can't be started, stopped, upgraded or backed up
+    pub managed: bool,
 }
 
 #[derive(Serialize, Deserialize, ToSchema)]
@@ -236,6 +253,19 @@ pub struct CreateExternalServiceRequest {
     pub service_type: ServiceTypeRoute,
     pub version: Option<String>,
     pub parameters: HashMap<String, serde_json::Value>,
+    /// Initial data loaded before the service is marked running; provisioning fails
+    /// if it can't be loaded
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub seed: Option<crate::ServiceSeed>,
+    /// Key/value tags of the new service
+    #[serde(default, skip_serializing_if = "Tags::is_empty")]
+    pub tags: Tags,
+}
+
+#[derive(Debug, Deserialize, IntoParams)]
+pub struct ListServicesParams {
+    /// Only services having all of these tags, e.g. `team=payments,tier=critical`
+    pub tags: Option<String>,
 }
 
 #[derive(Debug, Serialize, Deserialize, ToSchema)]
@@ -258,6 +288,11 @@ pub struct UpgradeExternalServiceRequest {
 #[derive(Debug, Serialize, Deserialize, ToSchema)]
 pub struct LinkServiceRequest {
     pub project_id: i32,
+    /// Most connections the project can open at once, for services giving each linked
+    /// project a user of its own
+    #[serde(default)]
+    #[schema(example = 20)]
+    pub connection_limit: Option<i32>,
 }
 
 /// Available Docker container that can be imported as a service
@@ -302,6 +337,28 @@ pub struct ImportExternalServiceRequest {
     pub container_id: String,
 }
 
+/// Request to register a database or cache hosted outside of Temps
+#[derive(Debug, Deserialize, ToSchema)]
+pub struct RegisterExternalServiceRequest {
+    /// Name to register the service as in Temps
+    #[schema(example = "production-rds")]
+    pub name: String,
+    /// Service type: `postgres`, `redis` or `mongodb`
+    pub service_type: ServiceTypeRoute,
+    /// Version of the hosted service, for display
+    #[schema(example = "16")]
+    pub version: Option<String>,
+    /// Connection details: `host` and optionally `port`, `database`, `username`,
+    /// `password` and `tls`. PostgreSQL and MongoDB need a database and username.
+    #[schema(
+        example = json!({"host": "prod.abc123.eu-west-1.rds.amazonaws.com", "port": "5432", "database": "app", "username": "app", "password": "secret", "tls": "true"})
+    )]
+    pub parameters: HashMap<String, serde_json::Value>,
+    /// Key/value tags of the new service
+    #[serde(default)]
+    pub tags: Tags,
+}
+
 #[derive(Debug, Serialize, Deserialize, ToSchema)]
 pub struct ProjectInfo {
     pub id: i32,
@@ -315,6 +372,10 @@ pub struct ProjectServiceInfo {
     pub id: i32,
     pub project: ProjectInfo,
     pub service: ExternalServiceInfo,
+    /// User of its own the project connects with; None when it shares the service's
+    #[schema(example = "app_my_project")]
+    pub app_username: Option<String>,
+    pub connection_limit: Option<i32>,
 }
 
 #[derive(Debug, Serialize, Deserialize, ToSchema)]
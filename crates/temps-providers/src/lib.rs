@@ -1,12 +1,31 @@
 //! providers services and utilities
 
+pub mod credential_rotation;
+pub use credential_rotation::{CredentialRotationError, CredentialRotationService};
+pub mod database_import;
+pub use database_import::{DatabaseImportError, DatabaseImportService};
+pub mod dependencies;
+pub use dependencies::{DependencyError, ServiceDependencyManager};
 pub mod externalsvc;
 pub mod parameter_strategies;
+pub mod postgres_insights;
+pub use postgres_insights::{PostgresInsightsError, PostgresInsightsService};
 pub mod query_service;
+pub mod readiness;
+pub use readiness::ServiceReadiness;
+pub mod seeding;
+pub use seeding::ServiceSeed;
+pub mod service_recovery;
+pub use service_recovery::{ServiceRecoveryError, ServiceRecoveryService};
+pub mod service_scaling;
+pub use service_scaling::{ServiceScalingError, ServiceScalingService};
 pub mod services;
 pub use services::*;
+pub mod tunnel;
+pub use tunnel::{ServiceTunnelManager, TunnelError};
 pub mod plugin;
 mod types;
+pub mod unmanaged;
 mod utils;
 pub use externalsvc::ServiceType;
 pub use query_service::QueryService;
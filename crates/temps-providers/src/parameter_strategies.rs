@@ -89,7 +89,13 @@ impl ParameterStrategy for PostgresParameterStrategy {
     }
 
     fn updateable_keys(&self) -> Vec<&'static str> {
-        vec!["port", "docker_image", "max_connections", "ssl_mode"]
+        vec![
+            "port",
+            "docker_image",
+            "max_connections",
+            "ssl_mode",
+            "pg_stat_statements",
+        ]
     }
 
     fn readonly_keys(&self) -> Vec<&'static str> {
@@ -149,6 +155,11 @@ impl ParameterStrategy for PostgresParameterStrategy {
                     "type": "string",
                     "description": "Docker image (updateable, e.g., postgres:17-alpine)",
                     "default": "postgres:17-alpine"
+                },
+                "pg_stat_statements": {
+                    "type": "boolean",
+                    "description": "Record query statistics with pg_stat_statements (updateable, restarts the service)",
+                    "default": false
                 }
             },
             "readonly": ["database", "username", "password", "host"]
@@ -731,6 +742,7 @@ mod tests {
         assert!(strategy.updateable_keys().contains(&"port"));
         assert!(strategy.updateable_keys().contains(&"max_connections"));
         assert!(strategy.updateable_keys().contains(&"ssl_mode"));
+        assert!(strategy.updateable_keys().contains(&"pg_stat_statements"));
     }
 
     #[test]
@@ -8,8 +8,15 @@ use temps_core::plugin::{
 use utoipa::openapi::OpenApi;
 use utoipa::OpenApi as OpenApiTrait;
 
+use crate::credential_rotation::CredentialRotationService;
+use crate::database_import::DatabaseImportService;
+use crate::dependencies::ServiceDependencyManager;
 use crate::handlers::{handlers, types::AppState};
+use crate::postgres_insights::PostgresInsightsService;
+use crate::service_recovery::ServiceRecoveryService;
+use crate::service_scaling::ServiceScalingService;
 use crate::services::ExternalServiceManager;
+use crate::tunnel::ServiceTunnelManager;
 
 /// Providers Plugin for managing external service integrations
 pub struct ProvidersPlugin;
@@ -41,24 +48,160 @@ impl TempsPlugin for ProvidersPlugin {
             let encryption_service = context.require_service::<temps_core::EncryptionService>();
             // AuditService should already be registered by the audit plugin
             let docker = context.require_service::<bollard::Docker>();
+            let config_service = context.require_service::<temps_config::ConfigService>();
 
             // Create ExternalServiceManager
             let external_service_manager = Arc::new(ExternalServiceManager::new(
                 db.clone(),
                 encryption_service.clone(),
+                docker.clone(),
+            ));
+            context.register_service(external_service_manager.clone());
+
+            // Declared dependencies deciding service start/stop order
+            let dependency_manager = Arc::new(ServiceDependencyManager::new(
+                db.clone(),
+                external_service_manager.clone(),
+                config_service.clone(),
+            ));
+            context.register_service(dependency_manager);
+
+            // Time-boxed tunnels exposing managed services for local development
+            let tunnel_manager = Arc::new(ServiceTunnelManager::new(
+                external_service_manager.clone(),
+                config_service,
+            ));
+            context.register_service(tunnel_manager);
+
+            // Metrics and slow-query insights of managed PostgreSQL services
+            let postgres_insights = Arc::new(PostgresInsightsService::new(
+                external_service_manager.clone(),
+            ));
+            context.register_service(postgres_insights);
+
+            // Coordinated credential rotation, on demand and on a schedule
+            let credential_rotation = Arc::new(CredentialRotationService::new(
+                db.clone(),
+                external_service_manager.clone(),
+                context.require_service::<dyn temps_core::AuditLogger>(),
+            ));
+            context.register_service(credential_rotation);
+
+            // Opt-in restart of linked apps when a service recovers from an outage
+            let service_recovery = Arc::new(ServiceRecoveryService::new(
+                db.clone(),
+                external_service_manager.clone(),
+                docker.clone(),
+            ));
+            context.register_service(service_recovery);
+
+            // CPU and memory limits of managed services, changed with as little downtime
+            // as each service allows
+            let mut service_scaling = ServiceScalingService::new(
+                db.clone(),
+                external_service_manager.clone(),
+                docker.clone(),
+                context.require_service::<dyn temps_core::AuditLogger>(),
+            );
+            if let Some(notification_service) =
+                context.get_service::<dyn temps_core::notifications::NotificationService>()
+            {
+                service_scaling = service_scaling.with_notification_service(notification_service);
+            }
+            context.register_service(Arc::new(service_scaling));
+
+            // Imports of external databases into managed services
+            let database_import = Arc::new(DatabaseImportService::new(
+                db.clone(),
+                external_service_manager,
+                encryption_service,
                 docker,
             ));
-            context.register_service(external_service_manager);
+            context.register_service(database_import);
 
             tracing::debug!("Providers plugin services registered successfully");
             Ok(())
         })
     }
 
+    fn initialize_plugin_services<'a>(
+        &'a self,
+        context: &'a PluginContext,
+    ) -> Pin<Box<dyn Future<Output = Result<(), PluginError>> + Send + 'a>> {
+        Box::pin(async move {
+            let tunnel_manager = context.require_service::<ServiceTunnelManager>();
+
+            // Close tunnels once they expire or go idle
+            tokio::spawn(async move {
+                tracing::debug!("Starting service tunnel reaper");
+                tunnel_manager.start_reaper().await;
+            });
+
+            // Linked apps are redeployed by the deployments plugin, registered after this one
+            let credential_rotation = context.require_service::<CredentialRotationService>();
+            let service_recovery = context.require_service::<ServiceRecoveryService>();
+            if let Some(redeployer) = context.get_service::<dyn temps_core::EnvironmentRedeployer>()
+            {
+                credential_rotation.set_redeployer(redeployer.clone()).await;
+                service_recovery.set_redeployer(redeployer).await;
+            } else {
+                tracing::warn!(
+                    "No environment redeployer registered; services with linked apps can't rotate credentials or restart them on recovery"
+                );
+            }
+            match credential_rotation.fail_interrupted_rotations().await {
+                Ok(0) => {}
+                Ok(count) => tracing::warn!(
+                    "Marked {} credential rotation(s) interrupted by a restart as failed",
+                    count
+                ),
+                Err(e) => {
+                    tracing::error!("Failed to clean up interrupted credential rotations: {}", e)
+                }
+            }
+
+            let service_scaling = context.require_service::<ServiceScalingService>();
+            match service_scaling.fail_interrupted_scalings().await {
+                Ok(0) => {}
+                Ok(count) => tracing::warn!(
+                    "Marked {} service scaling(s) interrupted by a restart as failed",
+                    count
+                ),
+                Err(e) => tracing::error!("Failed to clean up interrupted service scalings: {}", e),
+            }
+
+            let database_import = context.require_service::<DatabaseImportService>();
+            if let Err(e) = database_import.recover_interrupted_imports().await {
+                tracing::error!("Failed to clean up interrupted database imports: {}", e);
+            }
+
+            // Run scheduled credential rotations
+            tokio::spawn(async move {
+                tracing::debug!("Starting credential rotation scheduler");
+                credential_rotation.start_scheduler().await;
+            });
+
+            // Restart linked apps of services recovering from an outage, per their policy
+            tokio::spawn(async move {
+                tracing::debug!("Starting managed service recovery monitor");
+                service_recovery.start_monitor().await;
+            });
+
+            Ok(())
+        })
+    }
+
     fn configure_routes(&self, context: &PluginContext) -> Option<PluginRoutes> {
         // Get the services from the plugin context
         let external_service_manager = context.require_service::<ExternalServiceManager>();
         let audit_service = context.require_service::<dyn temps_core::AuditLogger>();
+        let tunnel_manager = context.require_service::<ServiceTunnelManager>();
+        let dependency_manager = context.require_service::<ServiceDependencyManager>();
+        let postgres_insights = context.require_service::<PostgresInsightsService>();
+        let credential_rotation = context.require_service::<CredentialRotationService>();
+        let service_recovery = context.require_service::<ServiceRecoveryService>();
+        let service_scaling = context.require_service::<ServiceScalingService>();
+        let database_import = context.require_service::<DatabaseImportService>();
 
         // Create QueryService
         let query_service = Arc::new(crate::QueryService::new(external_service_manager.clone()));
@@ -68,6 +211,13 @@ impl TempsPlugin for ProvidersPlugin {
             external_service_manager,
             audit_service,
             query_service,
+            tunnel_manager,
+            dependency_manager,
+            postgres_insights,
+            credential_rotation,
+            service_recovery,
+            service_scaling,
+            database_import,
         });
 
         // Configure routes with the app state
@@ -0,0 +1,492 @@
+//! PostgreSQL insights
+//!
+//! Database-level metrics of managed PostgreSQL services (connections, cache hit
+//! ratio, transaction rate, deadlocks, replication lag) and their slowest queries as
+//! recorded by `pg_stat_statements`. Statistics are read from the server's own
+//! `pg_stat_*` views on each request; nothing is stored by Temps.
+
+use chrono::{DateTime, Utc};
+use sea_orm::sqlx::{self, postgres::PgConnectOptions, Connection, PgConnection};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::sync::Arc;
+use thiserror::Error;
+use tokio::sync::RwLock;
+use tracing::debug;
+use utoipa::ToSchema;
+
+use crate::externalsvc::postgres::PostgresInputConfig;
+use crate::externalsvc::ServiceType;
+use crate::services::{ExternalServiceError, ExternalServiceInfo, ExternalServiceManager};
+
+/// Largest number of slow queries returned at once
+pub const MAX_SLOW_QUERIES: u32 = 100;
+
+#[derive(Error, Debug)]
+pub enum PostgresInsightsError {
+    #[error("Service {0} is not a PostgreSQL service")]
+    NotPostgres(i32),
+
+    #[error(transparent)]
+    Service(#[from] ExternalServiceError),
+
+    #[error("Failed to connect to PostgreSQL: {0}")]
+    Connection(String),
+
+    #[error("Failed to read PostgreSQL statistics: {0}")]
+    Query(String),
+}
+
+/// Database-level metrics of a PostgreSQL service
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct PostgresMetrics {
+    pub connections: ConnectionMetrics,
+    /// Share of block reads served from shared buffers (0-1); None before any reads
+    #[schema(example = 0.993)]
+    pub cache_hit_ratio: Option<f64>,
+    pub transactions: TransactionMetrics,
+    /// Deadlocks detected since statistics were last reset
+    pub deadlocks: i64,
+    pub replication: ReplicationMetrics,
+    pub collected_at: DateTime<Utc>,
+}
+
+/// Client connections by state
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ConnectionMetrics {
+    pub active: i64,
+    pub idle: i64,
+    pub idle_in_transaction: i64,
+    pub total: i64,
+    pub max_connections: i64,
+}
+
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct TransactionMetrics {
+    /// Committed transactions since statistics were last reset
+    pub commits: i64,
+    /// Rolled back transactions since statistics were last reset
+    pub rollbacks: i64,
+    /// Transactions per second since the previous sample, or on average since
+    /// statistics were last reset for the first sample
+    #[schema(example = 42.5)]
+    pub per_second: f64,
+}
+
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ReplicationMetrics {
+    /// Whether the server is a replica replaying another server's WAL
+    pub is_replica: bool,
+    /// On replicas, seconds since the last replayed transaction was committed
+    pub replay_lag_seconds: Option<f64>,
+    /// On primaries, the replicas streaming from the server
+    pub replicas: Vec<ReplicaLag>,
+}
+
+/// A replica streaming from the server
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ReplicaLag {
+    pub application_name: Option<String>,
+    pub client_addr: Option<String>,
+    /// Replication state (startup, catchup, streaming, ...)
+    pub state: Option<String>,
+    /// WAL bytes written on the primary that the replica hasn't replayed yet
+    pub lag_bytes: Option<i64>,
+    pub replay_lag_seconds: Option<f64>,
+}
+
+/// How slow queries are ranked
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum SlowQuerySort {
+    /// Total time spent executing the query (default)
+    #[default]
+    TotalTime,
+    /// Mean time per execution
+    MeanTime,
+    /// Number of executions
+    Calls,
+}
+
+/// A normalized query recorded by pg_stat_statements
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct SlowQuery {
+    /// Query text, with constants replaced by placeholders
+    #[schema(example = "SELECT * FROM orders WHERE customer_id = $1")]
+    pub query: String,
+    pub database: Option<String>,
+    pub calls: i64,
+    pub total_time_ms: f64,
+    pub mean_time_ms: f64,
+    pub max_time_ms: f64,
+    /// Rows returned or affected
+    pub rows: i64,
+    /// Share of the query's block reads served from shared buffers (0-1)
+    pub cache_hit_ratio: Option<f64>,
+}
+
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct SlowQueries {
+    /// Whether pg_stat_statements is loaded; no queries are recorded otherwise
+    pub enabled: bool,
+    pub queries: Vec<SlowQuery>,
+}
+
+/// Transaction counter sample, for the transaction rate between two requests
+#[derive(Debug, Clone, Copy, PartialEq)]
+struct TransactionSample {
+    /// Server clock, in seconds since the epoch
+    at: f64,
+    transactions: i64,
+}
+
+/// Share of block reads that were cache hits
+fn cache_hit_ratio(hits: i64, reads: i64) -> Option<f64> {
+    let total = hits + reads;
+    (total > 0).then(|| hits as f64 / total as f64)
+}
+
+/// Transactions per second since the previous sample
+///
+/// Falls back to the average since statistics were last reset when there is no
+/// usable previous sample, e.g. on the first request or after a statistics reset.
+fn transaction_rate(
+    previous: Option<TransactionSample>,
+    current: TransactionSample,
+    seconds_since_reset: f64,
+) -> f64 {
+    match previous {
+        Some(previous)
+            if current.at > previous.at && current.transactions >= previous.transactions =>
+        {
+            (current.transactions - previous.transactions) as f64 / (current.at - previous.at)
+        }
+        _ if seconds_since_reset > 0.0 => current.transactions as f64 / seconds_since_reset,
+        _ => 0.0,
+    }
+}
+
+/// Slow query SQL for a server version; the timing columns were renamed in PostgreSQL 13
+fn slow_queries_sql(server_version_num: i32, sort: SlowQuerySort) -> String {
+    let (total, mean, max) = if server_version_num >= 130000 {
+        ("total_exec_time", "mean_exec_time", "max_exec_time")
+    } else {
+        ("total_time", "mean_time", "max_time")
+    };
+    let order = match sort {
+        SlowQuerySort::TotalTime => total,
+        SlowQuerySort::MeanTime => mean,
+        SlowQuerySort::Calls => "calls",
+    };
+    format!(
+        "SELECT s.query, d.datname::text, s.calls::bigint, s.{total}::float8, s.{mean}::float8, \
+         s.{max}::float8, s.rows::bigint, s.shared_blks_hit::bigint, s.shared_blks_read::bigint \
+         FROM pg_stat_statements s LEFT JOIN pg_database d ON d.oid = s.dbid \
+         ORDER BY s.{order} DESC LIMIT $1"
+    )
+}
+
+const CONNECTIONS_SQL: &str = "SELECT \
+    count(*) FILTER (WHERE state = 'active')::bigint, \
+    count(*) FILTER (WHERE state = 'idle')::bigint, \
+    count(*) FILTER (WHERE state IN ('idle in transaction', 'idle in transaction (aborted)'))::bigint, \
+    count(*)::bigint, \
+    current_setting('max_connections')::bigint \
+    FROM pg_stat_activity WHERE backend_type = 'client backend'";
+
+const DATABASE_STATS_SQL: &str = "SELECT \
+    coalesce(sum(xact_commit), 0)::bigint, \
+    coalesce(sum(xact_rollback), 0)::bigint, \
+    coalesce(sum(deadlocks), 0)::bigint, \
+    coalesce(sum(blks_hit), 0)::bigint, \
+    coalesce(sum(blks_read), 0)::bigint, \
+    extract(epoch FROM now())::float8, \
+    extract(epoch FROM now() - coalesce(min(stats_reset), pg_postmaster_start_time()))::float8 \
+    FROM pg_stat_database WHERE datname IS NOT NULL";
+
+const RECOVERY_SQL: &str = "SELECT pg_is_in_recovery(), \
+    extract(epoch FROM now() - pg_last_xact_replay_timestamp())::float8";
+
+const REPLICAS_SQL: &str = "SELECT application_name::text, client_addr::text, state::text, \
+    pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn)::bigint, \
+    extract(epoch FROM replay_lag)::float8 \
+    FROM pg_stat_replication";
+
+const STATEMENTS_ENABLED_SQL: &str = "SELECT \
+    current_setting('shared_preload_libraries') LIKE '%pg_stat_statements%' \
+    AND EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements'), \
+    current_setting('server_version_num')::int";
+
+type DatabaseStatsRow = (i64, i64, i64, i64, i64, f64, f64);
+type SlowQueryRow = (String, Option<String>, i64, f64, f64, f64, i64, i64, i64);
+
+/// Reads metrics and query statistics of managed PostgreSQL services
+pub struct PostgresInsightsService {
+    external_service_manager: Arc<ExternalServiceManager>,
+    /// Last transaction counter sample per service
+    samples: RwLock<HashMap<i32, TransactionSample>>,
+}
+
+impl PostgresInsightsService {
+    pub fn new(external_service_manager: Arc<ExternalServiceManager>) -> Self {
+        Self {
+            external_service_manager,
+            samples: RwLock::new(HashMap::new()),
+        }
+    }
+
+    async fn postgres_config(
+        &self,
+        service_id: i32,
+    ) -> Result<PostgresInputConfig, PostgresInsightsError> {
+        let service = self
+            .external_service_manager
+            .get_service_config(service_id)
+            .await?;
+        if service.service_type != ServiceType::Postgres {
+            return Err(PostgresInsightsError::NotPostgres(service_id));
+        }
+        serde_json::from_value(service.parameters).map_err(|e| {
+            PostgresInsightsError::Connection(format!("Invalid service configuration: {}", e))
+        })
+    }
+
+    async fn connect(&self, service_id: i32) -> Result<PgConnection, PostgresInsightsError> {
+        let config = self.postgres_config(service_id).await?;
+        let port = config
+            .port
+            .as_deref()
+            .unwrap_or("5432")
+            .parse::<u16>()
+            .map_err(|e| PostgresInsightsError::Connection(format!("Invalid port: {}", e)))?;
+        let options = PgConnectOptions::new()
+            .host(&config.host)
+            .port(port)
+            .username(&config.username)
+            .password(config.password.as_deref().unwrap_or_default())
+            .database(&config.database)
+            .application_name("temps-insights");
+        PgConnection::connect_with(&options)
+            .await
+            .map_err(|e| PostgresInsightsError::Connection(e.to_string()))
+    }
+
+    /// Current metrics of a PostgreSQL service
+    pub async fn get_metrics(
+        &self,
+        service_id: i32,
+    ) -> Result<PostgresMetrics, PostgresInsightsError> {
+        let mut conn = self.connect(service_id).await?;
+        let query_error = |e: sqlx::Error| PostgresInsightsError::Query(e.to_string());
+
+        let (active, idle, idle_in_transaction, total, max_connections): (i64, i64, i64, i64, i64) =
+            sqlx::query_as(CONNECTIONS_SQL)
+                .fetch_one(&mut conn)
+                .await
+                .map_err(query_error)?;
+        let (commits, rollbacks, deadlocks, blks_hit, blks_read, now, seconds_since_reset): DatabaseStatsRow =
+            sqlx::query_as(DATABASE_STATS_SQL)
+                .fetch_one(&mut conn)
+                .await
+                .map_err(query_error)?;
+        let (is_replica, replica_lag): (bool, Option<f64>) = sqlx::query_as(RECOVERY_SQL)
+            .fetch_one(&mut conn)
+            .await
+            .map_err(query_error)?;
+
+        // WAL positions of replicas are only known on the primary
+        let replicas = if is_replica {
+            Vec::new()
+        } else {
+            let rows: Vec<(
+                Option<String>,
+                Option<String>,
+                Option<String>,
+                Option<i64>,
+                Option<f64>,
+            )> = sqlx::query_as(REPLICAS_SQL)
+                .fetch_all(&mut conn)
+                .await
+                .map_err(query_error)?;
+            rows.into_iter()
+                .map(
+                    |(application_name, client_addr, state, lag_bytes, replay_lag_seconds)| {
+                        ReplicaLag {
+                            application_name,
+                            client_addr,
+                            state,
+                            lag_bytes,
+                            replay_lag_seconds,
+                        }
+                    },
+                )
+                .collect()
+        };
+        let _ = conn.close().await;
+
+        let sample = TransactionSample {
+            at: now,
+            transactions: commits + rollbacks,
+        };
+        let previous = self.samples.write().await.insert(service_id, sample);
+
+        Ok(PostgresMetrics {
+            connections: ConnectionMetrics {
+                active,
+                idle,
+                idle_in_transaction,
+                total,
+                max_connections,
+            },
+            cache_hit_ratio: cache_hit_ratio(blks_hit, blks_read),
+            transactions: TransactionMetrics {
+                commits,
+                rollbacks,
+                per_second: transaction_rate(previous, sample, seconds_since_reset),
+            },
+            deadlocks,
+            replication: ReplicationMetrics {
+                is_replica,
+                replay_lag_seconds: if is_replica { replica_lag } else { None },
+                replicas,
+            },
+            collected_at: Utc::now(),
+        })
+    }
+
+    /// The slowest queries of a PostgreSQL service, across all of its databases
+    pub async fn get_slow_queries(
+        &self,
+        service_id: i32,
+        sort: SlowQuerySort,
+        limit: u32,
+    ) -> Result<SlowQueries, PostgresInsightsError> {
+        let mut conn = self.connect(service_id).await?;
+        let query_error = |e: sqlx::Error| PostgresInsightsError::Query(e.to_string());
+
+        let (enabled, server_version_num): (bool, i32) = sqlx::query_as(STATEMENTS_ENABLED_SQL)
+            .fetch_one(&mut conn)
+            .await
+            .map_err(query_error)?;
+        if !enabled {
+            debug!(
+                "pg_stat_statements is not enabled for service {}",
+                service_id
+            );
+            let _ = conn.close().await;
+            return Ok(SlowQueries {
+                enabled: false,
+                queries: Vec::new(),
+            });
+        }
+
+        let rows: Vec<SlowQueryRow> = sqlx::query_as(&slow_queries_sql(server_version_num, sort))
+            .bind(limit.clamp(1, MAX_SLOW_QUERIES) as i64)
+            .fetch_all(&mut conn)
+            .await
+            .map_err(query_error)?;
+        let _ = conn.close().await;
+
+        let queries = rows
+            .into_iter()
+            .map(
+                |(query, database, calls, total, mean, max, rows, hits, reads)| SlowQuery {
+                    query,
+                    database,
+                    calls,
+                    total_time_ms: total,
+                    mean_time_ms: mean,
+                    max_time_ms: max,
+                    rows,
+                    cache_hit_ratio: cache_hit_ratio(hits, reads),
+                },
+            )
+            .collect();
+        Ok(SlowQueries {
+            enabled: true,
+            queries,
+        })
+    }
+
+    /// Turn query statistics on or off
+    ///
+    /// Changing the setting recreates the service container, since the library must
+    /// be preloaded at server start; the data volume is kept.
+    pub async fn set_pg_stat_statements(
+        &self,
+        service_id: i32,
+        enabled: bool,
+    ) -> Result<ExternalServiceInfo, PostgresInsightsError> {
+        let config = self.postgres_config(service_id).await?;
+        if config.pg_stat_statements == enabled {
+            return Ok(self
+                .external_service_manager
+                .get_service_details(service_id)
+                .await?
+                .service);
+        }
+
+        let service = self
+            .external_service_manager
+            .update_service(
+                service_id,
+                crate::services::UpdateExternalServiceRequest {
+                    name: None,
+                    parameters: HashMap::from([(
+                        "pg_stat_statements".to_string(),
+                        serde_json::Value::Bool(enabled),
+                    )]),
+                    docker_image: None,
+                },
+            )
+            .await?;
+        // The server restarted, so the previous transaction sample no longer applies
+        self.samples.write().await.remove(&service_id);
+        Ok(service)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_cache_hit_ratio() {
+        assert_eq!(cache_hit_ratio(0, 0), None);
+        assert_eq!(cache_hit_ratio(99, 1), Some(0.99));
+        assert_eq!(cache_hit_ratio(0, 10), Some(0.0));
+    }
+
+    #[test]
+    fn test_transaction_rate() {
+        let sample = |at, transactions| TransactionSample { at, transactions };
+
+        // Between two samples
+        assert_eq!(
+            transaction_rate(Some(sample(100.0, 1000)), sample(110.0, 1500), 3600.0),
+            50.0
+        );
+        // First sample: average since the statistics reset
+        assert_eq!(transaction_rate(None, sample(110.0, 7200), 3600.0), 2.0);
+        // Counters went backwards: statistics were reset since the previous sample
+        assert_eq!(
+            transaction_rate(Some(sample(100.0, 1000)), sample(110.0, 100), 50.0),
+            2.0
+        );
+        assert_eq!(transaction_rate(None, sample(0.0, 0), 0.0), 0.0);
+    }
+
+    #[test]
+    fn test_slow_queries_sql_by_version() {
+        let sql = slow_queries_sql(160002, SlowQuerySort::TotalTime);
+        assert!(sql.contains("s.total_exec_time::float8"));
+        assert!(sql.contains("ORDER BY s.total_exec_time DESC"));
+
+        let sql = slow_queries_sql(120015, SlowQuerySort::MeanTime);
+        assert!(sql.contains("s.total_time::float8"));
+        assert!(sql.contains("ORDER BY s.mean_time DESC"));
+
+        let sql = slow_queries_sql(170000, SlowQuerySort::Calls);
+        assert!(sql.contains("ORDER BY s.calls DESC"));
+    }
+}
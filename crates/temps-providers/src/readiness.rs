@@ -0,0 +1,190 @@
+//! Readiness of managed services
+//!
+//! A started container isn't always a usable service: PostgreSQL's entrypoint runs a
+//! temporary server reachable only over its unix socket while it initializes a new
+//! data directory, and Redis answers `LOADING` while it reads its dataset. Readiness
+//! probes check a service the way apps connect to it, from inside its container:
+//! PostgreSQL has to accept TCP connections and Redis has to answer `PING`. Other
+//! services are ready once their container runs and passes its Docker health check.
+
+use bollard::Docker;
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+use utoipa::ToSchema;
+
+use crate::externalsvc::ServiceType;
+use crate::service_recovery::container_healthy;
+use crate::utils::{exec_in_container, output_tail};
+
+/// Probe name of services checked by their container alone
+const CONTAINER_PROBE: &str = "container";
+
+/// Whether a managed service accepts connections
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ServiceReadiness {
+    pub service_id: i32,
+    /// Whether apps can connect to the service
+    pub ready: bool,
+    /// How readiness was checked: `pg_isready`, `redis-cli ping`, `container` for
+    /// services without a probe, or `connect` for services hosted outside of Temps
+    #[schema(example = "pg_isready")]
+    pub probe: String,
+    /// Why the service isn't ready
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub detail: Option<String>,
+    pub checked_at: DateTime<Utc>,
+}
+
+/// A command checking a service from inside its container
+struct ReadinessProbe {
+    name: &'static str,
+    script: &'static str,
+}
+
+fn readiness_probe(service_type: ServiceType) -> Option<ReadinessProbe> {
+    match service_type {
+        // Over TCP, which the temporary server of a first start doesn't listen on
+        ServiceType::Postgres => Some(ReadinessProbe {
+            name: "pg_isready",
+            script: "pg_isready -h 127.0.0.1 -p 5432",
+        }),
+        ServiceType::Redis | ServiceType::Kv => Some(ReadinessProbe {
+            name: "redis-cli ping",
+            script: concat!(
+                "if [ -n \"$REDIS_PASSWORD\" ]; then export REDISCLI_AUTH=\"$REDIS_PASSWORD\"; fi; ",
+                "redis-cli -h 127.0.0.1 ping"
+            ),
+        }),
+        _ => None,
+    }
+}
+
+/// Whether a probe's result means the service accepts connections
+fn probe_passed(service_type: ServiceType, exit_code: i64, output: &str) -> bool {
+    match service_type {
+        // 0 accepting, 1 rejecting connections (starting up), 2 no response
+        ServiceType::Postgres => exit_code == 0,
+        // redis-cli exits with 0 on error replies like LOADING too. An imported
+        // container without the password in its environment gets NOAUTH, which a
+        // server only sends once it's processing commands.
+        ServiceType::Redis | ServiceType::Kv => {
+            let reply = output.trim();
+            exit_code == 0 && (reply == "PONG" || reply.starts_with("NOAUTH"))
+        }
+        _ => exit_code == 0,
+    }
+}
+
+/// Check whether a service's container runs and the service in it accepts connections
+pub(crate) async fn probe_service(
+    docker: &Docker,
+    service_id: i32,
+    container_name: &str,
+    service_type: ServiceType,
+) -> ServiceReadiness {
+    let probe = readiness_probe(service_type);
+    let not_ready = |detail: String| ServiceReadiness {
+        service_id,
+        ready: false,
+        probe: probe
+            .as_ref()
+            .map_or(CONTAINER_PROBE, |probe| probe.name)
+            .to_string(),
+        detail: Some(detail),
+        checked_at: Utc::now(),
+    };
+
+    let container = match docker
+        .inspect_container(
+            container_name,
+            None::<bollard::query_parameters::InspectContainerOptions>,
+        )
+        .await
+    {
+        Ok(container) => container,
+        Err(e) => return not_ready(format!("Failed to inspect container: {}", e)),
+    };
+    if !container.state.as_ref().is_some_and(container_healthy) {
+        return not_ready("Container isn't running or is failing its health check".to_string());
+    }
+
+    let Some(probe) = &probe else {
+        return ServiceReadiness {
+            service_id,
+            ready: true,
+            probe: CONTAINER_PROBE.to_string(),
+            detail: None,
+            checked_at: Utc::now(),
+        };
+    };
+    let cmd = vec!["sh".to_string(), "-c".to_string(), probe.script.to_string()];
+    match exec_in_container(docker, container_name, cmd, Vec::new()).await {
+        Ok((exit_code, output)) if probe_passed(service_type, exit_code, &output) => {
+            ServiceReadiness {
+                service_id,
+                ready: true,
+                probe: probe.name.to_string(),
+                detail: None,
+                checked_at: Utc::now(),
+            }
+        }
+        Ok((exit_code, output)) => not_ready(format!(
+            "{} exited with status {}: {}",
+            probe.name,
+            exit_code,
+            output_tail(&output)
+        )),
+        Err(e) => not_ready(format!("Failed to run {}: {}", probe.name, e)),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_postgres_probe_follows_exit_code() {
+        assert!(probe_passed(
+            ServiceType::Postgres,
+            0,
+            "127.0.0.1:5432 - accepting connections"
+        ));
+        assert!(!probe_passed(
+            ServiceType::Postgres,
+            1,
+            "127.0.0.1:5432 - rejecting connections"
+        ));
+        assert!(!probe_passed(
+            ServiceType::Postgres,
+            2,
+            "127.0.0.1:5432 - no response"
+        ));
+    }
+
+    #[test]
+    fn test_redis_probe_needs_a_pong() {
+        assert!(probe_passed(ServiceType::Redis, 0, "PONG\n"));
+        assert!(probe_passed(
+            ServiceType::Kv,
+            0,
+            "NOAUTH Authentication required."
+        ));
+        assert!(!probe_passed(
+            ServiceType::Redis,
+            0,
+            "LOADING Redis is loading the dataset in memory"
+        ));
+        assert!(!probe_passed(
+            ServiceType::Redis,
+            1,
+            "Could not connect to Redis at 127.0.0.1:6379: Connection refused"
+        ));
+    }
+
+    #[test]
+    fn test_probed_service_types() {
+        assert!(readiness_probe(ServiceType::Postgres).is_some());
+        assert!(readiness_probe(ServiceType::Kv).is_some());
+        assert!(readiness_probe(ServiceType::S3).is_none());
+    }
+}
@@ -0,0 +1,120 @@
+//! Managed service seeding
+//!
+//! A new managed service can be given initial data when it is provisioned: a script
+//! run against it (schema and reference data), or a dump restored from an S3 source,
+//! such as a backup of another service for a cloned environment. Seeding runs after
+//! the container is up and before the service is marked running; if it fails, the
+//! new service is removed so it can be provisioned again.
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+use crate::externalsvc::ServiceType;
+
+/// Largest seed script accepted, in bytes; larger data is restored from a dump
+pub const MAX_SEED_SCRIPT_BYTES: usize = 10 * 1024 * 1024;
+
+/// Initial data of a new managed service
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(tag = "source", rename_all = "snake_case")]
+pub enum ServiceSeed {
+    /// Script run against the service: SQL for PostgreSQL, a mongosh script for
+    /// MongoDB, one command per line for Redis
+    Script { script: String },
+    /// Dump restored into the service from an S3 source, in the format the service's
+    /// backups use (`pg_dumpall` output, gzipped, for PostgreSQL)
+    S3 { s3_source_id: i32, key: String },
+}
+
+impl ServiceSeed {
+    /// Short description for logs and errors
+    pub fn describe(&self) -> String {
+        match self {
+            ServiceSeed::Script { script } => format!("script ({} bytes)", script.len()),
+            ServiceSeed::S3 { s3_source_id, key } => {
+                format!("dump {} of S3 source {}", key, s3_source_id)
+            }
+        }
+    }
+
+    pub fn validate(&self, service_type: ServiceType) -> Result<(), String> {
+        let seedable = matches!(
+            service_type,
+            ServiceType::Postgres | ServiceType::Mongodb | ServiceType::Redis
+        );
+        if !seedable {
+            return Err(format!("{} services can't be seeded", service_type));
+        }
+
+        match self {
+            ServiceSeed::Script { script } => {
+                if script.trim().is_empty() {
+                    return Err("Seed script is empty".to_string());
+                }
+                if script.len() > MAX_SEED_SCRIPT_BYTES {
+                    return Err(format!(
+                        "Seed script is larger than {} MB; restore it from a dump in S3 instead",
+                        MAX_SEED_SCRIPT_BYTES / 1024 / 1024
+                    ));
+                }
+            }
+            ServiceSeed::S3 { key, .. } => {
+                if key.trim_matches('/').is_empty() {
+                    return Err("Seed dump needs the object key in the S3 source".to_string());
+                }
+            }
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_seed_is_tagged_by_source() {
+        let seed: ServiceSeed = serde_json::from_value(serde_json::json!({
+            "source": "script",
+            "script": "CREATE TABLE plans (id serial primary key);"
+        }))
+        .unwrap();
+        assert!(matches!(seed, ServiceSeed::Script { .. }));
+
+        let seed: ServiceSeed = serde_json::from_value(serde_json::json!({
+            "source": "s3",
+            "s3_source_id": 3,
+            "key": "backups/postgres_backup_20260101_000000.sql.gz"
+        }))
+        .unwrap();
+        assert_eq!(
+            seed.describe(),
+            "dump backups/postgres_backup_20260101_000000.sql.gz of S3 source 3"
+        );
+    }
+
+    #[test]
+    fn test_seed_validation() {
+        let script = ServiceSeed::Script {
+            script: "SET greeting hello".to_string(),
+        };
+        assert!(script.validate(ServiceType::Redis).is_ok());
+        assert!(script.validate(ServiceType::S3).is_err());
+
+        let empty = ServiceSeed::Script {
+            script: " \n".to_string(),
+        };
+        assert!(empty.validate(ServiceType::Postgres).is_err());
+
+        let too_large = ServiceSeed::Script {
+            script: "x".repeat(MAX_SEED_SCRIPT_BYTES + 1),
+        };
+        assert!(too_large.validate(ServiceType::Postgres).is_err());
+
+        let no_key = ServiceSeed::S3 {
+            s3_source_id: 1,
+            key: "/".to_string(),
+        };
+        assert!(no_key.validate(ServiceType::Mongodb).is_err());
+    }
+}
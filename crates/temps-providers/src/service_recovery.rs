@@ -0,0 +1,492 @@
+//! Restart of linked apps after a managed service outage
+//!
+//! Apps that hold connections to a managed service don't always recover when it goes
+//! down and comes back: pools keep dead connections, clients give up reconnecting.
+//! A service can opt in to a recovery policy, under which the apps linked to it are
+//! restarted (or redeployed) once the service is healthy again after an outage.
+//!
+//! The monitor checks the containers of running services every
+//! `HEALTH_CHECK_INTERVAL_SECS`; a service is healthy when its container runs and
+//! its Docker health check, if it has one, passes. At most one automatic action runs
+//! per policy cooldown, so a flapping service doesn't cause a restart storm. Apps
+//! that reconnect on their own don't need a policy, which is why there is none by
+//! default.
+
+use chrono::{DateTime, Duration, Utc};
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, QueryOrder, Set,
+};
+use serde::{Deserialize, Serialize};
+use std::collections::{HashMap, HashSet};
+use std::sync::{Arc, Mutex};
+use temps_core::EnvironmentRedeployer;
+use temps_entities::{
+    environments, external_services, project_services, service_recovery_policies,
+};
+use thiserror::Error;
+use tokio::sync::RwLock;
+use tracing::{debug, error, info, warn};
+use utoipa::ToSchema;
+
+use crate::externalsvc::ServiceType;
+use crate::services::{ExternalServiceError, ExternalServiceManager};
+
+/// How often the containers of running services are checked
+const HEALTH_CHECK_INTERVAL_SECS: u64 = 30;
+/// Least time between two automatic actions when the policy doesn't set one
+pub const DEFAULT_RECOVERY_COOLDOWN_SECONDS: i32 = 600;
+/// Shortest cooldown a policy can set
+const MIN_COOLDOWN_SECONDS: i32 = 60;
+/// Longest cooldown a policy can set
+const MAX_COOLDOWN_SECONDS: i32 = 24 * 60 * 60;
+
+#[derive(Error, Debug)]
+pub enum ServiceRecoveryError {
+    #[error("{0}")]
+    Validation(String),
+
+    #[error(transparent)]
+    Service(#[from] ExternalServiceError),
+}
+
+impl From<sea_orm::DbErr> for ServiceRecoveryError {
+    fn from(err: sea_orm::DbErr) -> Self {
+        ServiceRecoveryError::Service(err.into())
+    }
+}
+
+/// What happens to the linked apps when a service recovers
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum RecoveryAction {
+    /// Restart the containers of the current deployments
+    Restart,
+    /// Build and deploy the environments again
+    Redeploy,
+}
+
+impl RecoveryAction {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            RecoveryAction::Restart => service_recovery_policies::RECOVERY_ACTION_RESTART,
+            RecoveryAction::Redeploy => service_recovery_policies::RECOVERY_ACTION_REDEPLOY,
+        }
+    }
+
+    pub fn from_str(action: &str) -> Option<Self> {
+        match action {
+            service_recovery_policies::RECOVERY_ACTION_RESTART => Some(RecoveryAction::Restart),
+            service_recovery_policies::RECOVERY_ACTION_REDEPLOY => Some(RecoveryAction::Redeploy),
+            _ => None,
+        }
+    }
+}
+
+/// A service's recovery policy
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ServiceRecoveryPolicyInfo {
+    pub service_id: i32,
+    pub action: RecoveryAction,
+    /// Least time between two automatic actions
+    #[schema(example = 600)]
+    pub cooldown_seconds: i32,
+    /// When linked apps were last restarted or redeployed after a recovery
+    pub last_triggered_at: Option<DateTime<Utc>>,
+    pub updated_by: i32,
+}
+
+impl From<service_recovery_policies::Model> for ServiceRecoveryPolicyInfo {
+    fn from(policy: service_recovery_policies::Model) -> Self {
+        Self {
+            service_id: policy.service_id,
+            action: RecoveryAction::from_str(&policy.action).unwrap_or(RecoveryAction::Restart),
+            cooldown_seconds: policy.cooldown_seconds,
+            last_triggered_at: policy.last_triggered_at,
+            updated_by: policy.updated_by,
+        }
+    }
+}
+
+/// Whether a container counts as healthy: running, and passing its health check if
+/// it has one
+pub(crate) fn container_healthy(state: &bollard::models::ContainerState) -> bool {
+    let running = state.status == Some(bollard::models::ContainerStateStatusEnum::RUNNING);
+    let health_passing = match state.health.as_ref().and_then(|h| h.status.as_ref()) {
+        None
+        | Some(bollard::models::HealthStatusEnum::EMPTY)
+        | Some(bollard::models::HealthStatusEnum::NONE)
+        | Some(bollard::models::HealthStatusEnum::HEALTHY) => true,
+        // A container still starting hasn't recovered yet
+        Some(_) => false,
+    };
+    running && health_passing
+}
+
+/// Whether the policy's cooldown has passed since its last automatic action
+fn cooldown_elapsed(
+    last_triggered_at: Option<DateTime<Utc>>,
+    cooldown_seconds: i32,
+    now: DateTime<Utc>,
+) -> bool {
+    match last_triggered_at {
+        Some(last) => now - last >= Duration::seconds(cooldown_seconds as i64),
+        None => true,
+    }
+}
+
+/// Watches managed services and restarts their linked apps when they recover
+pub struct ServiceRecoveryService {
+    db: Arc<DatabaseConnection>,
+    external_service_manager: Arc<ExternalServiceManager>,
+    docker: Arc<bollard::Docker>,
+    /// Restarts and redeploys linked environments; provided by the deployments plugin
+    /// once every plugin has registered its services
+    redeployer: RwLock<Option<Arc<dyn EnvironmentRedeployer>>>,
+    /// Services currently down, with when the outage was first seen
+    outages: Mutex<HashMap<i32, DateTime<Utc>>>,
+}
+
+impl ServiceRecoveryService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        external_service_manager: Arc<ExternalServiceManager>,
+        docker: Arc<bollard::Docker>,
+    ) -> Self {
+        Self {
+            db,
+            external_service_manager,
+            docker,
+            redeployer: RwLock::new(None),
+            outages: Mutex::new(HashMap::new()),
+        }
+    }
+
+    pub async fn set_redeployer(&self, redeployer: Arc<dyn EnvironmentRedeployer>) {
+        *self.redeployer.write().await = Some(redeployer);
+    }
+
+    pub async fn get_policy(
+        &self,
+        service_id: i32,
+    ) -> Result<Option<ServiceRecoveryPolicyInfo>, ServiceRecoveryError> {
+        Ok(service_recovery_policies::Entity::find()
+            .filter(service_recovery_policies::Column::ServiceId.eq(service_id))
+            .one(self.db.as_ref())
+            .await?
+            .map(ServiceRecoveryPolicyInfo::from))
+    }
+
+    /// Restart or redeploy a service's linked apps when it recovers from an outage
+    pub async fn set_policy(
+        &self,
+        service_id: i32,
+        action: RecoveryAction,
+        cooldown_seconds: Option<i32>,
+        user_id: i32,
+    ) -> Result<ServiceRecoveryPolicyInfo, ServiceRecoveryError> {
+        let cooldown_seconds = cooldown_seconds.unwrap_or(DEFAULT_RECOVERY_COOLDOWN_SECONDS);
+        if !(MIN_COOLDOWN_SECONDS..=MAX_COOLDOWN_SECONDS).contains(&cooldown_seconds) {
+            return Err(ServiceRecoveryError::Validation(format!(
+                "cooldown_seconds must be between {} and {}",
+                MIN_COOLDOWN_SECONDS, MAX_COOLDOWN_SECONDS
+            )));
+        }
+        // Fails with ServiceNotFound for an unknown service
+        self.external_service_manager
+            .get_service_config(service_id)
+            .await?;
+
+        let existing = service_recovery_policies::Entity::find()
+            .filter(service_recovery_policies::Column::ServiceId.eq(service_id))
+            .one(self.db.as_ref())
+            .await?;
+        let policy = match existing {
+            Some(policy) => {
+                let mut update: service_recovery_policies::ActiveModel = policy.into();
+                update.action = Set(action.as_str().to_string());
+                update.cooldown_seconds = Set(cooldown_seconds);
+                update.updated_by = Set(user_id);
+                update.update(self.db.as_ref()).await?
+            }
+            None => {
+                service_recovery_policies::ActiveModel {
+                    service_id: Set(service_id),
+                    action: Set(action.as_str().to_string()),
+                    cooldown_seconds: Set(cooldown_seconds),
+                    last_triggered_at: Set(None),
+                    updated_by: Set(user_id),
+                    ..Default::default()
+                }
+                .insert(self.db.as_ref())
+                .await?
+            }
+        };
+        Ok(policy.into())
+    }
+
+    /// Stop restarting a service's linked apps when it recovers
+    /// Returns whether there was a policy
+    pub async fn delete_policy(&self, service_id: i32) -> Result<bool, ServiceRecoveryError> {
+        let result = service_recovery_policies::Entity::delete_many()
+            .filter(service_recovery_policies::Column::ServiceId.eq(service_id))
+            .exec(self.db.as_ref())
+            .await?;
+        Ok(result.rows_affected > 0)
+    }
+
+    /// Deployed, non-deleted environments of the projects linked to a service, as
+    /// `(project_id, environment_id)` pairs
+    async fn linked_environments(
+        &self,
+        service_id: i32,
+    ) -> Result<Vec<(i32, i32)>, ServiceRecoveryError> {
+        let project_ids: Vec<i32> = project_services::Entity::find()
+            .filter(project_services::Column::ServiceId.eq(service_id))
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|link| link.project_id)
+            .collect();
+        if project_ids.is_empty() {
+            return Ok(Vec::new());
+        }
+
+        Ok(environments::Entity::find()
+            .filter(environments::Column::ProjectId.is_in(project_ids))
+            .filter(environments::Column::DeletedAt.is_null())
+            .filter(environments::Column::CurrentDeploymentId.is_not_null())
+            .order_by_asc(environments::Column::Id)
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|env| (env.project_id, env.id))
+            .collect())
+    }
+
+    /// Whether a service's container is up and healthy
+    async fn is_healthy(&self, service: &external_services::Model) -> bool {
+        let service_type = match ServiceType::from_str(&service.service_type) {
+            Ok(service_type) => service_type,
+            Err(_) => return true,
+        };
+        let container_name = self
+            .external_service_manager
+            .get_service_instance(service.name.clone(), service_type)
+            .container_name();
+        match self
+            .docker
+            .inspect_container(
+                &container_name,
+                None::<bollard::query_parameters::InspectContainerOptions>,
+            )
+            .await
+        {
+            Ok(container) => container.state.as_ref().is_some_and(container_healthy),
+            Err(e) => {
+                debug!("Failed to inspect container {}: {}", container_name, e);
+                false
+            }
+        }
+    }
+
+    /// Check every running service, handling the ones that recovered since the last
+    /// check
+    async fn check_services(self: &Arc<Self>) -> Result<(), ServiceRecoveryError> {
+        // Services hosted elsewhere have no container to recover
+        let services = external_services::Entity::find()
+            .filter(external_services::Column::Status.eq("running"))
+            .filter(external_services::Column::Managed.eq(true))
+            .all(self.db.as_ref())
+            .await?;
+
+        let mut recovered = Vec::new();
+        for service in &services {
+            let healthy = self.is_healthy(service).await;
+            let mut outages = self.outages.lock().unwrap();
+            if healthy {
+                if let Some(down_since) = outages.remove(&service.id) {
+                    recovered.push((service.clone(), down_since));
+                }
+            } else if !outages.contains_key(&service.id) {
+                warn!(
+                    "Managed service '{}' ({}) is down",
+                    service.name, service.service_type
+                );
+                outages.insert(service.id, Utc::now());
+            }
+        }
+
+        // A service that was stopped on purpose isn't in an outage anymore
+        let running: HashSet<i32> = services.iter().map(|service| service.id).collect();
+        self.outages
+            .lock()
+            .unwrap()
+            .retain(|service_id, _| running.contains(service_id));
+
+        for (service, down_since) in recovered {
+            if let Err(e) = self.handle_recovery(&service, down_since).await {
+                error!(
+                    "Failed to handle recovery of managed service '{}': {}",
+                    service.name, e
+                );
+            }
+        }
+        Ok(())
+    }
+
+    /// Apply a recovered service's policy, if it has one and it is out of its cooldown
+    async fn handle_recovery(
+        &self,
+        service: &external_services::Model,
+        down_since: DateTime<Utc>,
+    ) -> Result<(), ServiceRecoveryError> {
+        let now = Utc::now();
+        let outage_seconds = (now - down_since).num_seconds();
+        info!(
+            "Managed service '{}' recovered after being down for about {}s",
+            service.name, outage_seconds
+        );
+
+        let Some(policy) = service_recovery_policies::Entity::find()
+            .filter(service_recovery_policies::Column::ServiceId.eq(service.id))
+            .one(self.db.as_ref())
+            .await?
+        else {
+            return Ok(());
+        };
+        if !cooldown_elapsed(policy.last_triggered_at, policy.cooldown_seconds, now) {
+            info!(
+                "Not restarting apps linked to managed service '{}': last automatic restart was at {:?}, within the {}s cooldown",
+                service.name, policy.last_triggered_at, policy.cooldown_seconds
+            );
+            return Ok(());
+        }
+        let Some(redeployer) = self.redeployer.read().await.clone() else {
+            warn!(
+                "No environment redeployer registered; can't restart apps linked to managed service '{}'",
+                service.name
+            );
+            return Ok(());
+        };
+        let action = RecoveryAction::from_str(&policy.action).unwrap_or(RecoveryAction::Restart);
+
+        // Start the cooldown before acting, so a failing restart isn't retried on
+        // every recovery
+        let mut update: service_recovery_policies::ActiveModel = policy.into();
+        update.last_triggered_at = Set(Some(now));
+        update.update(self.db.as_ref()).await?;
+
+        let environments = self.linked_environments(service.id).await?;
+        let cause = format!(
+            "managed service '{}' recovered after an outage of about {}s",
+            service.name, outage_seconds
+        );
+        tokio::spawn(async move {
+            for (project_id, environment_id) in environments {
+                info!(
+                    "Automatic {} of environment {} (project {}): {}",
+                    action.as_str(),
+                    environment_id,
+                    project_id,
+                    cause
+                );
+                let result = match action {
+                    RecoveryAction::Restart => redeployer
+                        .restart_environment(project_id, environment_id)
+                        .await
+                        .map(|_| ()),
+                    RecoveryAction::Redeploy => redeployer
+                        .redeploy_environment(project_id, environment_id)
+                        .await
+                        .map(|_| ()),
+                };
+                if let Err(e) = result {
+                    warn!(
+                        "Automatic {} of environment {} (project {}) failed: {}",
+                        action.as_str(),
+                        environment_id,
+                        project_id,
+                        e
+                    );
+                }
+            }
+        });
+        Ok(())
+    }
+
+    pub async fn start_monitor(self: Arc<Self>) {
+        let mut interval =
+            tokio::time::interval(std::time::Duration::from_secs(HEALTH_CHECK_INTERVAL_SECS));
+        loop {
+            interval.tick().await;
+            if let Err(e) = self.check_services().await {
+                error!("Failed to check managed service health: {}", e);
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use bollard::models::{ContainerState, ContainerStateStatusEnum, Health, HealthStatusEnum};
+
+    fn state(status: ContainerStateStatusEnum, health: Option<HealthStatusEnum>) -> ContainerState {
+        ContainerState {
+            status: Some(status),
+            health: health.map(|status| Health {
+                status: Some(status),
+                ..Default::default()
+            }),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_container_healthy() {
+        assert!(container_healthy(&state(
+            ContainerStateStatusEnum::RUNNING,
+            None
+        )));
+        assert!(container_healthy(&state(
+            ContainerStateStatusEnum::RUNNING,
+            Some(HealthStatusEnum::HEALTHY)
+        )));
+        assert!(!container_healthy(&state(
+            ContainerStateStatusEnum::RUNNING,
+            Some(HealthStatusEnum::STARTING)
+        )));
+        assert!(!container_healthy(&state(
+            ContainerStateStatusEnum::RUNNING,
+            Some(HealthStatusEnum::UNHEALTHY)
+        )));
+        assert!(!container_healthy(&state(
+            ContainerStateStatusEnum::RESTARTING,
+            None
+        )));
+    }
+
+    #[test]
+    fn test_cooldown_elapsed() {
+        let now = Utc::now();
+        assert!(cooldown_elapsed(None, 600, now));
+        assert!(!cooldown_elapsed(
+            Some(now - Duration::seconds(599)),
+            600,
+            now
+        ));
+        assert!(cooldown_elapsed(
+            Some(now - Duration::seconds(600)),
+            600,
+            now
+        ));
+    }
+
+    #[test]
+    fn test_recovery_action_round_trips() {
+        for action in [RecoveryAction::Restart, RecoveryAction::Redeploy] {
+            assert_eq!(RecoveryAction::from_str(action.as_str()), Some(action));
+        }
+        assert_eq!(RecoveryAction::from_str("reboot"), None);
+    }
+}
@@ -0,0 +1,693 @@
+//! Vertical scaling of managed services
+//!
+//! Changes the CPU and memory limits of a managed service's container. Docker applies
+//! new limits to a running container in place, so most services keep running
+//! through a scaling: the service is then told about its new size where it tunes
+//! itself after its memory, like PostgreSQL's planner, with an online config reload.
+//! Services that only size themselves at startup, like MongoDB's cache, are
+//! restarted instead. A restart keeps the container with its data and address, so
+//! linked apps only have to reconnect; they're notified before it happens.
+//!
+//! New sizes are checked against the CPUs and memory of the Docker host, counting
+//! the memory other running services are limited to as taken. Limits are stored
+//! with the service and applied again whenever its container is recreated, and each
+//! change is recorded and audited.
+
+use bollard::Docker;
+use chrono::{DateTime, Utc};
+use sea_orm::{
+    sea_query::Expr, ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter,
+    QueryOrder, QuerySelect, Set,
+};
+use serde::Serialize;
+use std::collections::{HashMap, HashSet};
+use std::sync::{Arc, Mutex};
+use temps_core::notifications::{
+    NotificationData, NotificationPriority, NotificationService, NotificationType,
+};
+use temps_core::{AuditContext, AuditLogger};
+use temps_entities::{external_services, project_services, projects, service_scalings};
+use thiserror::Error;
+use tracing::{error, info, warn};
+use utoipa::ToSchema;
+
+use crate::externalsvc::{ResourceLimits, ServiceType};
+use crate::handlers::audit::ExternalServiceScaledAudit;
+use crate::service_recovery::container_healthy;
+use crate::services::{ExternalServiceError, ExternalServiceManager};
+
+/// Smallest CPU limit, in millicores
+const MIN_CPU_LIMIT: i32 = 100;
+/// Smallest memory limit, in megabytes; databases don't start with much less
+const MIN_MEMORY_LIMIT: i32 = 64;
+/// Seconds a service gets to shut down cleanly before a restart kills it
+const RESTART_STOP_TIMEOUT_SECS: i32 = 30;
+/// Longest wait for a restarted service to become healthy
+const HEALTH_WAIT_SECS: u64 = 120;
+/// Number of past scalings listed per service
+const HISTORY_LIMIT: u64 = 50;
+
+#[derive(Error, Debug)]
+pub enum ServiceScalingError {
+    #[error("A scaling is already running for service {0}")]
+    AlreadyRunning(i32),
+
+    #[error("{0}")]
+    Validation(String),
+
+    #[error("The node can't fit this size: {0}")]
+    InsufficientCapacity(String),
+
+    #[error(transparent)]
+    Service(#[from] ExternalServiceError),
+
+    #[error("Docker error: {0}")]
+    Docker(String),
+}
+
+impl From<sea_orm::DbErr> for ServiceScalingError {
+    fn from(err: sea_orm::DbErr) -> Self {
+        ServiceScalingError::Service(err.into())
+    }
+}
+
+/// A change to a service's resource limits
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ServiceScalingInfo {
+    pub id: i32,
+    pub service_id: i32,
+    /// running, completed or failed
+    #[schema(example = "completed")]
+    pub status: String,
+    /// online, restart or deferred (the service was stopped); None while running
+    #[schema(example = "online")]
+    pub method: Option<String>,
+    pub old_limits: ResourceLimits,
+    pub limits: ResourceLimits,
+    pub error: Option<String>,
+    pub requested_by: i32,
+    pub started_at: DateTime<Utc>,
+    pub finished_at: Option<DateTime<Utc>>,
+}
+
+impl From<service_scalings::Model> for ServiceScalingInfo {
+    fn from(scaling: service_scalings::Model) -> Self {
+        Self {
+            id: scaling.id,
+            service_id: scaling.service_id,
+            status: scaling.status,
+            method: scaling.method,
+            old_limits: ResourceLimits {
+                cpu_limit: scaling.old_cpu_limit,
+                memory_limit: scaling.old_memory_limit,
+            },
+            limits: ResourceLimits {
+                cpu_limit: scaling.cpu_limit,
+                memory_limit: scaling.memory_limit,
+            },
+            error: scaling.error,
+            requested_by: scaling.requested_by,
+            started_at: scaling.started_at,
+            finished_at: scaling.finished_at,
+        }
+    }
+}
+
+/// CPUs and memory of the Docker host; None where Docker doesn't report them
+#[derive(Debug, Clone, Copy)]
+struct NodeCapacity {
+    cpu_millicores: Option<i64>,
+    memory_mb: Option<i64>,
+}
+
+/// Check new limits against their minimums and the node, of which
+/// `committed_memory_mb` is taken by the limits of other services
+fn validate_limits(
+    limits: ResourceLimits,
+    node: NodeCapacity,
+    committed_memory_mb: i64,
+) -> Result<(), ServiceScalingError> {
+    if let Some(cpu_limit) = limits.cpu_limit {
+        if cpu_limit < MIN_CPU_LIMIT {
+            return Err(ServiceScalingError::Validation(format!(
+                "cpu_limit must be at least {} millicores",
+                MIN_CPU_LIMIT
+            )));
+        }
+        if let Some(node_cpu) = node.cpu_millicores {
+            if i64::from(cpu_limit) > node_cpu {
+                return Err(ServiceScalingError::InsufficientCapacity(format!(
+                    "{} millicores of CPU requested, the node has {}",
+                    cpu_limit, node_cpu
+                )));
+            }
+        }
+    }
+
+    if let Some(memory_limit) = limits.memory_limit {
+        if memory_limit < MIN_MEMORY_LIMIT {
+            return Err(ServiceScalingError::Validation(format!(
+                "memory_limit must be at least {} MB",
+                MIN_MEMORY_LIMIT
+            )));
+        }
+        if let Some(node_memory) = node.memory_mb {
+            let available = node_memory - committed_memory_mb;
+            if i64::from(memory_limit) > available {
+                return Err(ServiceScalingError::InsufficientCapacity(format!(
+                    "{} MB of memory requested, the node has {} MB of which {} MB are taken by the limits of other services",
+                    memory_limit, node_memory, committed_memory_mb
+                )));
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Set the CPU and memory limits of a container, running or not
+pub(crate) async fn update_container_resources(
+    docker: &Docker,
+    container_name: &str,
+    limits: ResourceLimits,
+) -> Result<(), bollard::errors::Error> {
+    let memory = limits.memory_limit.map(|mb| i64::from(mb) * 1024 * 1024);
+    docker
+        .update_container(
+            container_name,
+            bollard::models::ContainerUpdateBody {
+                nano_cpus: limits
+                    .cpu_limit
+                    .map(|millicores| i64::from(millicores) * 1_000_000),
+                memory,
+                // Swap counts against the limit, so a resized service doesn't swap
+                // past the memory it was given
+                memory_swap: memory,
+                ..Default::default()
+            },
+        )
+        .await
+}
+
+/// How a scaling ended
+struct ScalingOutcome {
+    method: Option<&'static str>,
+    /// Whether the container has the new limits
+    applied: bool,
+    error: Option<String>,
+}
+
+/// Changes the CPU and memory limits of managed services
+pub struct ServiceScalingService {
+    db: Arc<DatabaseConnection>,
+    external_service_manager: Arc<ExternalServiceManager>,
+    docker: Arc<Docker>,
+    audit_service: Arc<dyn AuditLogger>,
+    notification_service: Option<Arc<dyn NotificationService>>,
+    /// Services with a scaling in progress
+    scaling: Mutex<HashSet<i32>>,
+}
+
+impl ServiceScalingService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        external_service_manager: Arc<ExternalServiceManager>,
+        docker: Arc<Docker>,
+        audit_service: Arc<dyn AuditLogger>,
+    ) -> Self {
+        Self {
+            db,
+            external_service_manager,
+            docker,
+            audit_service,
+            notification_service: None,
+            scaling: Mutex::new(HashSet::new()),
+        }
+    }
+
+    /// Notify linked apps before a restart cuts their connections
+    pub fn with_notification_service(
+        mut self,
+        notification_service: Arc<dyn NotificationService>,
+    ) -> Self {
+        self.notification_service = Some(notification_service);
+        self
+    }
+
+    /// Start changing a service's CPU and memory limits
+    ///
+    /// Limits left out of `requested` keep their current value. The scaling runs in
+    /// the background; its record is returned right away and updated as it finishes.
+    pub async fn scale(
+        self: &Arc<Self>,
+        service_id: i32,
+        requested: ResourceLimits,
+        context: AuditContext,
+    ) -> Result<ServiceScalingInfo, ServiceScalingError> {
+        let service = external_services::Entity::find_by_id(service_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or(ExternalServiceError::ServiceNotFound { id: service_id })?;
+        if !service.managed {
+            return Err(ExternalServiceError::NotManaged {
+                id: service_id,
+                operation: "scale",
+            }
+            .into());
+        }
+
+        let old_limits = ResourceLimits {
+            cpu_limit: service.cpu_limit,
+            memory_limit: service.memory_limit,
+        };
+        let limits = ResourceLimits {
+            cpu_limit: requested.cpu_limit.or(old_limits.cpu_limit),
+            memory_limit: requested.memory_limit.or(old_limits.memory_limit),
+        };
+        if limits == old_limits {
+            return Err(ServiceScalingError::Validation(
+                "The service already has these resources".to_string(),
+            ));
+        }
+        let node = self.node_capacity().await?;
+        let committed_memory = self.committed_memory(service_id).await?;
+        validate_limits(limits, node, committed_memory)?;
+
+        if !self.scaling.lock().unwrap().insert(service_id) {
+            return Err(ServiceScalingError::AlreadyRunning(service_id));
+        }
+        let inserted = service_scalings::ActiveModel {
+            service_id: Set(service_id),
+            status: Set(service_scalings::SCALING_STATUS_RUNNING.to_string()),
+            method: Set(None),
+            old_cpu_limit: Set(old_limits.cpu_limit),
+            old_memory_limit: Set(old_limits.memory_limit),
+            cpu_limit: Set(limits.cpu_limit),
+            memory_limit: Set(limits.memory_limit),
+            error: Set(None),
+            requested_by: Set(context.user_id),
+            started_at: Set(Utc::now()),
+            finished_at: Set(None),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await;
+        let scaling = match inserted {
+            Ok(scaling) => scaling,
+            Err(e) => {
+                self.scaling.lock().unwrap().remove(&service_id);
+                return Err(e.into());
+            }
+        };
+
+        info!(
+            "Scaling service {} from {:?} to {:?}",
+            service_id, old_limits, limits
+        );
+        let scaler = self.clone();
+        let record = scaling.clone();
+        tokio::spawn(async move {
+            let outcome = scaler.run_scaling(&service, limits).await;
+            scaler
+                .finish_scaling(record, service, limits, outcome, context)
+                .await;
+        });
+
+        Ok(scaling.into())
+    }
+
+    async fn node_capacity(&self) -> Result<NodeCapacity, ServiceScalingError> {
+        let info = self.docker.info().await.map_err(|e| {
+            ServiceScalingError::Docker(format!("Failed to read the node's capacity: {}", e))
+        })?;
+        Ok(NodeCapacity {
+            cpu_millicores: info.ncpu.filter(|ncpu| *ncpu > 0).map(|ncpu| ncpu * 1000),
+            memory_mb: info
+                .mem_total
+                .filter(|mem_total| *mem_total > 0)
+                .map(|mem_total| mem_total / (1024 * 1024)),
+        })
+    }
+
+    /// Memory the other running managed services are limited to, in megabytes
+    async fn committed_memory(&self, service_id: i32) -> Result<i64, ServiceScalingError> {
+        Ok(external_services::Entity::find()
+            .filter(external_services::Column::Id.ne(service_id))
+            .filter(external_services::Column::Managed.eq(true))
+            .filter(external_services::Column::Status.eq("running"))
+            .filter(external_services::Column::MemoryLimit.is_not_null())
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .filter_map(|service| service.memory_limit)
+            .map(i64::from)
+            .sum())
+    }
+
+    /// Apply new limits to a service's container, restarting it if the service can't
+    /// take them online
+    async fn run_scaling(
+        &self,
+        service: &external_services::Model,
+        limits: ResourceLimits,
+    ) -> ScalingOutcome {
+        // A stopped service gets its limits when it starts
+        if service.status != "running" {
+            return ScalingOutcome {
+                method: Some(service_scalings::SCALING_METHOD_DEFERRED),
+                applied: true,
+                error: None,
+            };
+        }
+        let service_type = match ServiceType::from_str(&service.service_type) {
+            Ok(service_type) => service_type,
+            Err(e) => {
+                return ScalingOutcome {
+                    method: None,
+                    applied: false,
+                    error: Some(e.to_string()),
+                }
+            }
+        };
+        let instance = self
+            .external_service_manager
+            .get_service_instance(service.name.clone(), service_type);
+        let container_name = instance.container_name();
+
+        if let Err(e) = update_container_resources(&self.docker, &container_name, limits).await {
+            return ScalingOutcome {
+                method: None,
+                applied: false,
+                error: Some(format!(
+                    "Failed to update the limits of container {}: {}",
+                    container_name, e
+                )),
+            };
+        }
+
+        let online = match self
+            .external_service_manager
+            .get_service_config(service.id)
+            .await
+        {
+            Ok(config) => match instance.apply_resource_limits(config, limits).await {
+                Ok(online) => online,
+                Err(e) => {
+                    warn!(
+                        "Service {} couldn't take its new limits online, restarting it: {}",
+                        service.id, e
+                    );
+                    false
+                }
+            },
+            Err(e) => {
+                warn!(
+                    "Failed to read the configuration of service {}, restarting it: {}",
+                    service.id, e
+                );
+                false
+            }
+        };
+        if online {
+            return ScalingOutcome {
+                method: Some(service_scalings::SCALING_METHOD_ONLINE),
+                applied: true,
+                error: None,
+            };
+        }
+
+        self.notify_linked_apps(service).await;
+        let error = self.restart_container(&container_name).await.err();
+        ScalingOutcome {
+            method: Some(service_scalings::SCALING_METHOD_RESTART),
+            applied: true,
+            error,
+        }
+    }
+
+    /// Restart a container in place and wait for it to become healthy
+    async fn restart_container(&self, container_name: &str) -> Result<(), String> {
+        info!("Restarting container {} to resize it", container_name);
+        self.docker
+            .restart_container(
+                container_name,
+                Some(bollard::query_parameters::RestartContainerOptions {
+                    t: Some(RESTART_STOP_TIMEOUT_SECS),
+                    ..Default::default()
+                }),
+            )
+            .await
+            .map_err(|e| format!("Failed to restart container {}: {}", container_name, e))?;
+
+        let deadline =
+            tokio::time::Instant::now() + std::time::Duration::from_secs(HEALTH_WAIT_SECS);
+        while tokio::time::Instant::now() < deadline {
+            let healthy = self
+                .docker
+                .inspect_container(
+                    container_name,
+                    None::<bollard::query_parameters::InspectContainerOptions>,
+                )
+                .await
+                .ok()
+                .and_then(|container| container.state)
+                .is_some_and(|state| container_healthy(&state));
+            if healthy {
+                return Ok(());
+            }
+            tokio::time::sleep(std::time::Duration::from_secs(1)).await;
+        }
+        Err(format!(
+            "Container {} didn't become healthy within {}s of restarting",
+            container_name, HEALTH_WAIT_SECS
+        ))
+    }
+
+    /// Warn that a service's linked apps are about to lose their connections to it
+    async fn notify_linked_apps(&self, service: &external_services::Model) {
+        let Some(notification_service) = self.notification_service.as_ref() else {
+            return;
+        };
+        let project_ids: Vec<i32> = match project_services::Entity::find()
+            .filter(project_services::Column::ServiceId.eq(service.id))
+            .all(self.db.as_ref())
+            .await
+        {
+            Ok(links) => links.into_iter().map(|link| link.project_id).collect(),
+            Err(e) => {
+                error!(
+                    "Failed to list the projects linked to service {}: {}",
+                    service.id, e
+                );
+                return;
+            }
+        };
+        if project_ids.is_empty() {
+            return;
+        }
+        let project_names: Vec<String> = projects::Entity::find()
+            .filter(projects::Column::Id.is_in(project_ids.clone()))
+            .order_by_asc(projects::Column::Name)
+            .all(self.db.as_ref())
+            .await
+            .map(|projects| projects.into_iter().map(|project| project.name).collect())
+            .unwrap_or_default();
+
+        let mut metadata = HashMap::new();
+        metadata.insert("service_id".to_string(), service.id.to_string());
+        metadata.insert(
+            "project_ids".to_string(),
+            project_ids
+                .iter()
+                .map(i32::to_string)
+                .collect::<Vec<_>>()
+                .join(","),
+        );
+        let notification = NotificationData {
+            title: format!("Managed service '{}' is restarting", service.name),
+            message: format!(
+                "'{}' restarts to apply its new CPU and memory limits. Linked projects ({}) lose their connections to it for a few seconds and have to reconnect.",
+                service.name,
+                project_names.join(", ")
+            ),
+            notification_type: NotificationType::Warning,
+            priority: NotificationPriority::Normal,
+            metadata,
+            ..Default::default()
+        };
+        if let Err(e) = notification_service.send_notification(notification).await {
+            error!(
+                "Failed to notify linked apps of the restart of service {}: {}",
+                service.id, e
+            );
+        }
+    }
+
+    async fn finish_scaling(
+        &self,
+        scaling: service_scalings::Model,
+        service: external_services::Model,
+        limits: ResourceLimits,
+        outcome: ScalingOutcome,
+        context: AuditContext,
+    ) {
+        let service_id = scaling.service_id;
+        let status = match outcome.error {
+            None => service_scalings::SCALING_STATUS_COMPLETED,
+            Some(_) => service_scalings::SCALING_STATUS_FAILED,
+        };
+        match outcome.error.as_deref() {
+            None => info!(
+                "Scaled service {} to {:?} ({})",
+                service_id,
+                limits,
+                outcome.method.unwrap_or_default()
+            ),
+            Some(e) => warn!("Scaling of service {} failed: {}", service_id, e),
+        }
+
+        // The container has the new limits even if the service didn't come back
+        // healthy, so they're what has to be applied again on recreation
+        if outcome.applied {
+            let service_name = service.name.clone();
+            let mut update: external_services::ActiveModel = service.into();
+            update.cpu_limit = Set(limits.cpu_limit);
+            update.memory_limit = Set(limits.memory_limit);
+            if let Err(e) = update.update(self.db.as_ref()).await {
+                error!(
+                    "Failed to store the limits of service '{}': {}",
+                    service_name, e
+                );
+            }
+        }
+
+        let mut update: service_scalings::ActiveModel = scaling.clone().into();
+        update.status = Set(status.to_string());
+        update.method = Set(outcome.method.map(str::to_string));
+        update.error = Set(outcome.error.clone());
+        update.finished_at = Set(Some(Utc::now()));
+        if let Err(e) = update.update(self.db.as_ref()).await {
+            error!("Failed to record service scaling {}: {}", scaling.id, e);
+        }
+
+        let service_name = match self
+            .external_service_manager
+            .get_service_config(service_id)
+            .await
+        {
+            Ok(service) => service.name,
+            Err(_) => service_id.to_string(),
+        };
+        let audit = ExternalServiceScaledAudit {
+            context,
+            service_id,
+            service_name,
+            scaling_id: scaling.id,
+            status: status.to_string(),
+            method: outcome.method.map(str::to_string),
+            old_cpu_limit: scaling.old_cpu_limit,
+            old_memory_limit: scaling.old_memory_limit,
+            cpu_limit: limits.cpu_limit,
+            memory_limit: limits.memory_limit,
+            error: outcome.error,
+        };
+        if let Err(e) = self.audit_service.create_audit_log(&audit).await {
+            error!("Failed to create audit log: {}", e);
+        }
+
+        self.scaling.lock().unwrap().remove(&service_id);
+    }
+
+    /// Past scalings of a service, latest first
+    pub async fn list_scalings(
+        &self,
+        service_id: i32,
+    ) -> Result<Vec<ServiceScalingInfo>, ServiceScalingError> {
+        Ok(service_scalings::Entity::find()
+            .filter(service_scalings::Column::ServiceId.eq(service_id))
+            .order_by_desc(service_scalings::Column::StartedAt)
+            .limit(HISTORY_LIMIT)
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(ServiceScalingInfo::from)
+            .collect())
+    }
+
+    /// Mark scalings interrupted by a server restart as failed
+    pub async fn fail_interrupted_scalings(&self) -> Result<u64, ServiceScalingError> {
+        let result = service_scalings::Entity::update_many()
+            .col_expr(
+                service_scalings::Column::Status,
+                Expr::value(service_scalings::SCALING_STATUS_FAILED),
+            )
+            .col_expr(
+                service_scalings::Column::Error,
+                Expr::value(
+                    "Interrupted by a server restart; the service's limits may not have changed",
+                ),
+            )
+            .col_expr(
+                service_scalings::Column::FinishedAt,
+                Expr::value(Utc::now()),
+            )
+            .filter(service_scalings::Column::Status.eq(service_scalings::SCALING_STATUS_RUNNING))
+            .exec(self.db.as_ref())
+            .await?;
+        Ok(result.rows_affected)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn limits(cpu_limit: Option<i32>, memory_limit: Option<i32>) -> ResourceLimits {
+        ResourceLimits {
+            cpu_limit,
+            memory_limit,
+        }
+    }
+
+    const NODE: NodeCapacity = NodeCapacity {
+        cpu_millicores: Some(4000),
+        memory_mb: Some(8192),
+    };
+
+    #[test]
+    fn test_limits_within_the_node_are_valid() {
+        assert!(validate_limits(limits(Some(2000), Some(4096)), NODE, 2048).is_ok());
+        assert!(validate_limits(limits(Some(4000), Some(6144)), NODE, 2048).is_ok());
+        assert!(validate_limits(limits(None, Some(512)), NODE, 0).is_ok());
+    }
+
+    #[test]
+    fn test_limits_past_the_node_are_rejected() {
+        assert!(matches!(
+            validate_limits(limits(Some(8000), None), NODE, 0),
+            Err(ServiceScalingError::InsufficientCapacity(_))
+        ));
+        // Memory other services are limited to is taken
+        assert!(matches!(
+            validate_limits(limits(None, Some(6145)), NODE, 2048),
+            Err(ServiceScalingError::InsufficientCapacity(_))
+        ));
+        // Nothing to check against when Docker doesn't report the node's size
+        let unknown = NodeCapacity {
+            cpu_millicores: None,
+            memory_mb: None,
+        };
+        assert!(validate_limits(limits(Some(64000), Some(1 << 20)), unknown, 0).is_ok());
+    }
+
+    #[test]
+    fn test_limits_below_the_minimums_are_rejected() {
+        assert!(matches!(
+            validate_limits(limits(Some(50), None), NODE, 0),
+            Err(ServiceScalingError::Validation(_))
+        ));
+        assert!(matches!(
+            validate_limits(limits(None, Some(32)), NODE, 0),
+            Err(ServiceScalingError::Validation(_))
+        ));
+    }
+}
@@ -1,9 +1,12 @@
 use crate::externalsvc::{
     mongodb::MongodbService, postgres::PostgresService, redis::RedisService, rustfs::RustfsService,
-    s3::S3Service, AvailableContainer, ExternalService, ServiceConfig, ServiceType,
+    s3::S3Service, AppCredentials, AvailableContainer, ExternalService, ResourceLimits,
+    ServiceConfig, ServiceType, TransferProgress,
 };
 use crate::parameter_strategies;
+use crate::seeding::ServiceSeed;
 use crate::types::EnvironmentVariableInfo;
+use crate::unmanaged::{self, ExternalConnection};
 use anyhow::Result;
 use bollard::Docker;
 use chrono::Utc;
@@ -14,9 +17,14 @@ use sea_orm::{
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 use std::sync::Arc;
-use temps_entities::{external_service_backups, external_services, project_services, projects};
+use temps_core::pagination::{CursorPage, ListRequest, ListSpec, Sort};
+use temps_database::queries::{list_select, page_from_models, ListField, ListFieldKind};
+use temps_entities::tags::Tags;
+use temps_entities::{
+    external_service_backups, external_services, project_services, projects, s3_sources,
+};
 use thiserror::Error;
-use tracing::{error, info};
+use tracing::{debug, error, info, warn};
 // use crate::routes::types::external_services::EnvironmentVariableInfo;
 use temps_core::EncryptionService;
 // Add these constants at the top of the file proper key management
@@ -37,6 +45,9 @@ pub enum ExternalServiceError {
     #[error("Failed to initialize service {id}: {reason}")]
     InitializationFailed { id: i32, reason: String },
 
+    #[error("Failed to seed service {id}: {reason}")]
+    SeedingFailed { id: i32, reason: String },
+
     #[error("Failed to encrypt parameter '{param_name}' for service {service_id}: {reason}")]
     EncryptionFailed {
         service_id: i32,
@@ -96,10 +107,50 @@ pub enum ExternalServiceError {
         service_type: String,
     },
 
+    #[error("Cannot {operation} service {id}: it is hosted outside of Temps")]
+    NotManaged { id: i32, operation: &'static str },
+
+    #[error("Could not connect to service: {reason}")]
+    ConnectionFailed { reason: String },
+
     #[error("Internal error: {reason}")]
     InternalError { reason: String },
+
+    #[error("Invalid list query: {reason}")]
+    InvalidListQuery { reason: String },
+}
+
+fn newest_first() -> Sort {
+    Sort::desc("created_at")
 }
 
+/// Sorting and filtering of the service list
+pub const SERVICE_LIST: ListSpec = ListSpec {
+    sort_fields: &["created_at", "name", "id"],
+    filter_fields: &["name", "service_type", "status"],
+    default_sort: newest_first,
+};
+
+const SERVICE_FIELDS: [ListField<external_services::Column>; 5] = [
+    ListField::new(
+        "created_at",
+        external_services::Column::CreatedAt,
+        ListFieldKind::Timestamp,
+    ),
+    ListField::new("name", external_services::Column::Name, ListFieldKind::Text),
+    ListField::new("id", external_services::Column::Id, ListFieldKind::Integer),
+    ListField::new(
+        "service_type",
+        external_services::Column::ServiceType,
+        ListFieldKind::Text,
+    ),
+    ListField::new(
+        "status",
+        external_services::Column::Status,
+        ListFieldKind::Text,
+    ),
+];
+
 impl From<sea_orm::DbErr> for ExternalServiceError {
     fn from(err: sea_orm::DbErr) -> Self {
         ExternalServiceError::DatabaseError {
@@ -135,6 +186,16 @@ pub struct CreateExternalServiceRequest {
     pub parameters: HashMap<String, serde_json::Value>,
 }
 
+/// A database or cache hosted outside of Temps, like an existing RDS instance
+#[derive(Debug, Deserialize)]
+pub struct RegisterExternalServiceRequest {
+    pub name: String,
+    pub service_type: ServiceType,
+    pub version: Option<String>,
+    /// Connection details: `host`, `port`, `database`, `username`, `password`, `tls`
+    pub parameters: HashMap<String, serde_json::Value>,
+}
+
 #[derive(Debug, Deserialize)]
 pub struct ImportExternalServiceRequest {
     pub name: String,
@@ -190,6 +251,9 @@ pub struct ExternalServiceInfo {
     pub connection_info: Option<String>,
     pub created_at: String,
     pub updated_at: String,
+    pub tags: Tags,
+    /// Whether Temps runs the service, or it's hosted elsewhere
+    pub managed: bool,
 }
 
 #[derive(Debug, Serialize, Clone)]
@@ -204,6 +268,9 @@ pub struct ProjectServiceInfo {
     pub id: i32,
     pub project: ProjectInfo,
     pub service: ExternalServiceInfo,
+    /// User of its own the project connects with; None when it shares the service's
+    pub app_username: Option<String>,
+    pub connection_limit: Option<i32>,
 }
 
 pub struct ExternalServiceManager {
@@ -328,8 +395,28 @@ impl ExternalServiceManager {
     pub async fn create_service(
         &self,
         request: CreateExternalServiceRequest,
+    ) -> Result<ExternalServiceInfo, ExternalServiceError> {
+        self.create_service_with_seed(request, None).await
+    }
+
+    /// Create a service and load its initial data before it is marked running
+    ///
+    /// The service is `seeding` while the seed runs. If seeding fails, the service,
+    /// its container and its data are removed again.
+    pub async fn create_service_with_seed(
+        &self,
+        request: CreateExternalServiceRequest,
+        seed: Option<ServiceSeed>,
     ) -> Result<ExternalServiceInfo, ExternalServiceError> {
         info!("Creating new external service");
+        if let Some(seed) = &seed {
+            seed.validate(request.service_type).map_err(|reason| {
+                ExternalServiceError::ParameterValidationFailed {
+                    service_id: 0,
+                    reason,
+                }
+            })?;
+        }
         let service_slug = Self::generate_slug(&request.name);
 
         // Get the parameter strategy for this service type
@@ -394,7 +481,7 @@ impl ExternalServiceManager {
             .map_err(ExternalServiceError::from)?;
 
         // Initialize the service - if this fails, delete the service record to maintain consistency
-        let init_result = self.initialize_service(service.id).await;
+        let init_result = self.initialize_service(service.id, seed.as_ref()).await;
         if let Err(e) = init_result {
             // Initialization failed - clean up the database record
             error!(
@@ -413,15 +500,160 @@ impl ExternalServiceManager {
                 );
             }
 
-            return Err(ExternalServiceError::InitializationFailed {
-                id: service.id,
-                reason: e.to_string(),
+            return Err(match e {
+                ExternalServiceError::SeedingFailed { .. } => e,
+                e => ExternalServiceError::InitializationFailed {
+                    id: service.id,
+                    reason: e.to_string(),
+                },
             });
         }
 
         self.get_service_info(service.id).await
     }
 
+    /// Register a database or cache hosted outside of Temps
+    ///
+    /// Only its connection details are stored, once it accepted a connection with
+    /// them; no container is created and nothing is provisioned in it.
+    pub async fn register_service(
+        &self,
+        request: RegisterExternalServiceRequest,
+    ) -> Result<ExternalServiceInfo, ExternalServiceError> {
+        let connection =
+            ExternalConnection::from_parameters(request.service_type, &request.parameters)
+                .map_err(|reason| ExternalServiceError::ParameterValidationFailed {
+                    service_id: 0,
+                    reason,
+                })?;
+        Self::check_connection(0, request.service_type, &connection).await?;
+
+        info!(
+            "Registering {} service {} hosted at {}:{}",
+            request.service_type, request.name, connection.host, connection.port
+        );
+        let encrypted_config = self.encrypt_parameters(&connection.to_parameters())?;
+        let service = external_services::ActiveModel {
+            name: Set(request.name.clone()),
+            slug: Set(Some(Self::generate_slug(&request.name))),
+            service_type: Set(request.service_type.to_string()),
+            version: Set(request.version),
+            status: Set("running".to_string()),
+            config: Set(Some(encrypted_config)),
+            managed: Set(false),
+            created_at: Set(Utc::now()),
+            updated_at: Set(Utc::now()),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        self.get_service_info(service.id).await
+    }
+
+    /// Replace connection details of a service hosted outside of Temps, once they
+    /// were checked to work
+    async fn update_unmanaged_service(
+        &self,
+        service: external_services::Model,
+        request: UpdateExternalServiceRequest,
+    ) -> Result<ExternalServiceInfo, ExternalServiceError> {
+        let service_id = service.id;
+        if request.docker_image.is_some() {
+            return Err(ExternalServiceError::NotManaged {
+                id: service_id,
+                operation: "change the image of",
+            });
+        }
+        let service_type = ServiceType::from_str(&service.service_type).map_err(|_| {
+            ExternalServiceError::InvalidServiceType {
+                id: service_id,
+                service_type: service.service_type.clone(),
+            }
+        })?;
+
+        let mut parameters = self.get_service_parameters(service_id).await?;
+        parameters.extend(request.parameters);
+        let connection =
+            ExternalConnection::from_parameters(service_type, &parameters).map_err(|reason| {
+                ExternalServiceError::ParameterValidationFailed { service_id, reason }
+            })?;
+        Self::check_connection(service_id, service_type, &connection).await?;
+
+        let encrypted_config = self.encrypt_parameters(&connection.to_parameters())?;
+        let mut service_update: external_services::ActiveModel = service.into();
+        if let Some(name) = request.name {
+            service_update.name = Set(name);
+        }
+        service_update.config = Set(Some(encrypted_config));
+        service_update.status = Set("running".to_string());
+        service_update.updated_at = Set(Utc::now());
+        service_update.update(self.db.as_ref()).await?;
+
+        self.get_service_info(service_id).await
+    }
+
+    async fn check_connection(
+        service_id: i32,
+        service_type: ServiceType,
+        connection: &ExternalConnection,
+    ) -> Result<(), ExternalServiceError> {
+        let readiness = unmanaged::probe_service(service_id, service_type, connection).await;
+        if readiness.ready {
+            return Ok(());
+        }
+        Err(ExternalServiceError::ConnectionFailed {
+            reason: readiness.detail.unwrap_or_default(),
+        })
+    }
+
+    fn encrypt_parameters(
+        &self,
+        parameters: &HashMap<String, serde_json::Value>,
+    ) -> Result<String, ExternalServiceError> {
+        let config_json =
+            serde_json::to_string(parameters).map_err(|e| ExternalServiceError::InternalError {
+                reason: format!("Failed to serialize config to JSON: {}", e),
+            })?;
+        self.encryption_service
+            .encrypt_string(&config_json)
+            .map_err(|e| ExternalServiceError::InternalError {
+                reason: format!("Failed to encrypt config: {}", e),
+            })
+    }
+
+    /// Variables of apps linked to a service hosted outside of Temps
+    fn unmanaged_env_vars(
+        service_id: i32,
+        service_type: ServiceType,
+        parameters: &HashMap<String, serde_json::Value>,
+    ) -> Result<HashMap<String, String>, ExternalServiceError> {
+        ExternalConnection::from_parameters(service_type, parameters)
+            .map(|connection| connection.env_vars(service_type))
+            .map_err(|reason| ExternalServiceError::ParameterValidationFailed {
+                service_id,
+                reason,
+            })
+    }
+
+    /// Whether Temps runs a service, rather than it being hosted elsewhere
+    pub async fn is_managed(&self, service_id: i32) -> Result<bool, ExternalServiceError> {
+        Ok(self.get_service(service_id).await?.managed)
+    }
+
+    fn ensure_managed(
+        service: &external_services::Model,
+        operation: &'static str,
+    ) -> Result<(), ExternalServiceError> {
+        if service.managed {
+            return Ok(());
+        }
+        Err(ExternalServiceError::NotManaged {
+            id: service.id,
+            operation,
+        })
+    }
+
     pub async fn get_service_config(
         &self,
         service_id: i32,
@@ -453,7 +685,19 @@ impl ExternalServiceManager {
     }
 
     pub async fn list_services(&self) -> Result<Vec<ExternalServiceInfo>, ExternalServiceError> {
-        let services = external_services::Entity::find()
+        self.list_services_with_tags(&Tags::default()).await
+    }
+
+    /// Services having every one of the given tags
+    pub async fn list_services_with_tags(
+        &self,
+        tags: &Tags,
+    ) -> Result<Vec<ExternalServiceInfo>, ExternalServiceError> {
+        let mut query = external_services::Entity::find();
+        if !tags.is_empty() {
+            query = query.filter(tags.contained_in(external_services::Column::Tags));
+        }
+        let services = query
             .order_by_desc(external_services::Column::CreatedAt)
             .all(self.db.as_ref())
             .await?;
@@ -466,6 +710,45 @@ impl ExternalServiceManager {
         Ok(result)
     }
 
+    /// A page of the services having every one of the given tags
+    pub async fn list_services_page(
+        &self,
+        tags: &Tags,
+        request: &ListRequest,
+    ) -> Result<CursorPage<ExternalServiceInfo>, ExternalServiceError> {
+        let mut query = external_services::Entity::find();
+        if !tags.is_empty() {
+            query = query.filter(tags.contained_in(external_services::Column::Tags));
+        }
+        let rows = list_select(
+            query,
+            request,
+            &SERVICE_FIELDS,
+            external_services::Column::Id,
+        )
+        .map_err(|e| ExternalServiceError::InvalidListQuery {
+            reason: e.to_string(),
+        })?
+        .all(self.db.as_ref())
+        .await?;
+        let page = page_from_models(
+            rows,
+            request,
+            &SERVICE_FIELDS,
+            external_services::Column::Id,
+        );
+
+        let mut items = Vec::with_capacity(page.items.len());
+        for service in &page.items {
+            items.push(self.get_service_info(service.id).await?);
+        }
+        Ok(CursorPage {
+            items,
+            next_cursor: page.next_cursor,
+            total: page.total,
+        })
+    }
+
     pub async fn get_service_details(
         &self,
         service_id: i32,
@@ -501,6 +784,7 @@ impl ExternalServiceManager {
         );
 
         let service = self.get_service(service_id).await?;
+        Self::ensure_managed(&service, "upgrade")?;
         let old_parameters = self.get_service_parameters(service_id).await?;
 
         // Get old configuration
@@ -561,6 +845,8 @@ impl ExternalServiceManager {
                 id: service_id,
                 reason: format!("Upgrade failed: {}", e),
             })?;
+        self.restore_resource_limits(&service, service_instance.as_ref())
+            .await;
 
         // Update the service configuration in the database with the new Docker image
         let config_json = serde_json::to_string(&new_parameters).map_err(|e| {
@@ -592,6 +878,9 @@ impl ExternalServiceManager {
         request: UpdateExternalServiceRequest,
     ) -> Result<ExternalServiceInfo, ExternalServiceError> {
         let service = self.get_service(service_id).await?;
+        if !service.managed {
+            return self.update_unmanaged_service(service, request).await;
+        }
 
         // Get the parameter strategy for this service type
         let strategy = parameter_strategies::get_strategy(&service.service_type).ok_or(
@@ -652,11 +941,46 @@ impl ExternalServiceManager {
         service_update.update(self.db.as_ref()).await?;
 
         // Reinitialize the service (this will stop, remove, and recreate the container with new image)
-        self.initialize_service(service_id).await?;
+        self.initialize_service(service_id, None).await?;
 
         self.get_service_info(service_id).await
     }
 
+    /// Overwrite some of a service's stored parameters without reinitializing it
+    ///
+    /// For values that change inside the running service rather than its container,
+    /// such as rotated credentials. Unlike `update_service`, keys aren't checked
+    /// against the updateable parameters and the container isn't recreated.
+    pub async fn store_service_parameters(
+        &self,
+        service_id: i32,
+        parameters: HashMap<String, serde_json::Value>,
+    ) -> Result<(), ExternalServiceError> {
+        let service = self.get_service(service_id).await?;
+        let mut current_params = self.get_service_parameters(service_id).await?;
+        current_params.extend(parameters);
+
+        let config_json = serde_json::to_string(&current_params).map_err(|e| {
+            ExternalServiceError::InternalError {
+                reason: format!("Failed to serialize config to JSON: {}", e),
+            }
+        })?;
+
+        let encrypted_config = self
+            .encryption_service
+            .encrypt_string(&config_json)
+            .map_err(|e| ExternalServiceError::InternalError {
+                reason: format!("Failed to encrypt config: {}", e),
+            })?;
+
+        let mut service_update: external_services::ActiveModel = service.into();
+        service_update.config = Set(Some(encrypted_config));
+        service_update.updated_at = Set(Utc::now());
+        service_update.update(self.db.as_ref()).await?;
+
+        Ok(())
+    }
+
     pub async fn delete_service(&self, service_id: i32) -> Result<(), ExternalServiceError> {
         // Get service to check if it exists
         let service = self.get_service(service_id).await?;
@@ -680,6 +1004,7 @@ impl ExternalServiceManager {
             });
         }
 
+        let managed = service.managed;
         let service_instance =
             self.create_service_instance(service.name.clone(), service_type_enum);
 
@@ -710,6 +1035,12 @@ impl ExternalServiceManager {
             .await
             .map_err(ExternalServiceError::from)?;
 
+        // Services hosted elsewhere are only forgotten
+        if !managed {
+            info!("Removed registration of unmanaged service {}", service_id);
+            return Ok(());
+        }
+
         // Stop the service
         info!("Stopping service {} before deletion", service_id);
         service_instance
@@ -723,8 +1054,49 @@ impl ExternalServiceManager {
         Ok(())
     }
 
+    /// Whether a service's container runs and the service in it accepts connections
+    ///
+    /// A service hosted outside of Temps has to accept a connection with its
+    /// credentials.
+    pub async fn check_readiness(
+        &self,
+        service_id: i32,
+    ) -> Result<crate::readiness::ServiceReadiness, ExternalServiceError> {
+        let service = self.get_service(service_id).await?;
+        let service_type = ServiceType::from_str(&service.service_type).map_err(|_| {
+            ExternalServiceError::InvalidServiceType {
+                id: service_id,
+                service_type: service.service_type.clone(),
+            }
+        })?;
+        if !service.managed {
+            let parameters = self.get_service_parameters(service_id).await?;
+            let connection =
+                ExternalConnection::from_parameters(service_type, &parameters).map_err(
+                    |reason| ExternalServiceError::ParameterValidationFailed { service_id, reason },
+                )?;
+            return Ok(unmanaged::probe_service(service_id, service_type, &connection).await);
+        }
+        let container_name = self
+            .create_service_instance(service.name, service_type)
+            .container_name();
+
+        Ok(
+            crate::readiness::probe_service(
+                &self.docker,
+                service_id,
+                &container_name,
+                service_type,
+            )
+            .await,
+        )
+    }
+
     pub async fn check_service_health(&self, service_id: i32) -> Result<bool> {
-        let _service = self.get_service(service_id).await?;
+        let service = self.get_service(service_id).await?;
+        if !service.managed {
+            return Ok(self.check_readiness(service_id).await?.ready);
+        }
 
         Ok(false)
     }
@@ -760,9 +1132,32 @@ impl ExternalServiceManager {
             connection_info: None,
             created_at: service.created_at.to_rfc3339(),
             updated_at: service.updated_at.to_rfc3339(),
+            tags: service.tags,
+            managed: service.managed,
         })
     }
 
+    /// Replace the tags of a service
+    pub async fn set_service_tags(
+        &self,
+        service_id: i32,
+        tags: Tags,
+    ) -> Result<ExternalServiceInfo, ExternalServiceError> {
+        tags.validate()
+            .map_err(|reason| ExternalServiceError::ParameterValidationFailed {
+                service_id,
+                reason,
+            })?;
+
+        let service = self.get_service(service_id).await?;
+        let mut active_service: external_services::ActiveModel = service.into();
+        active_service.tags = Set(tags);
+        active_service.updated_at = Set(Utc::now());
+        active_service.update(self.db.as_ref()).await?;
+
+        self.get_service_info(service_id).await
+    }
+
     async fn get_service_parameters(
         &self,
         service_id_val: i32,
@@ -800,7 +1195,11 @@ impl ExternalServiceManager {
         Ok(parameters)
     }
 
-    async fn initialize_service(&self, service_id: i32) -> Result<(), ExternalServiceError> {
+    async fn initialize_service(
+        &self,
+        service_id: i32,
+        seed: Option<&ServiceSeed>,
+    ) -> Result<(), ExternalServiceError> {
         info!("Initializing service: {}", service_id);
         let service = self.get_service(service_id).await?;
         let parameters = self.get_service_parameters(service_id).await?;
@@ -857,6 +1256,13 @@ impl ExternalServiceManager {
                 id: service_id,
                 reason: format!("Failed to start service: {}", e),
             })?;
+        self.restore_resource_limits(&service, service_instance.as_ref())
+            .await;
+
+        if let Some(seed) = seed {
+            self.seed_service(&service, service_instance.as_ref(), seed)
+                .await?;
+        }
 
         // Update status to running
         let mut service_update: external_services::ActiveModel = service.clone().into();
@@ -867,6 +1273,151 @@ impl ExternalServiceManager {
         Ok(())
     }
 
+    /// Apply a service's CPU and memory limits to its container, which doesn't keep
+    /// them when it's recreated
+    async fn restore_resource_limits(
+        &self,
+        service: &external_services::Model,
+        service_instance: &dyn ExternalService,
+    ) {
+        let limits = ResourceLimits {
+            cpu_limit: service.cpu_limit,
+            memory_limit: service.memory_limit,
+        };
+        if limits == ResourceLimits::default() {
+            return;
+        }
+        let container_name = service_instance.container_name();
+        if let Err(e) = crate::service_scaling::update_container_resources(
+            &self.docker,
+            &container_name,
+            limits,
+        )
+        .await
+        {
+            error!(
+                "Failed to apply the resource limits of service {} to container {}: {}",
+                service.id, container_name, e
+            );
+        }
+    }
+
+    /// Load a new service's initial data; on failure the service's container and
+    /// data are removed
+    async fn seed_service(
+        &self,
+        service: &external_services::Model,
+        service_instance: &dyn ExternalService,
+        seed: &ServiceSeed,
+    ) -> Result<(), ExternalServiceError> {
+        info!("Seeding service {} from {}", service.id, seed.describe());
+        let mut service_update: external_services::ActiveModel = service.clone().into();
+        service_update.status = Set("seeding".to_string());
+        service_update.updated_at = Set(Utc::now());
+        service_update.update(self.db.as_ref()).await?;
+
+        // Inferred parameters, such as a generated password, are stored by now
+        let service_config = self.get_service_config(service.id).await?;
+        let result = match seed {
+            ServiceSeed::Script { script } => service_instance
+                .run_script(service_config, script)
+                .await
+                .map(|output| {
+                    debug!("Seed script output of service {}: {}", service.id, output);
+                }),
+            ServiceSeed::S3 { s3_source_id, key } => {
+                self.restore_seed_dump(service_instance, service_config, *s3_source_id, key)
+                    .await
+            }
+        };
+
+        if let Err(e) = result {
+            error!("Seeding service {} failed: {}", service.id, e);
+            if let Err(remove_err) = service_instance.remove().await {
+                error!(
+                    "Failed to remove service {} after seeding failed: {}",
+                    service.id, remove_err
+                );
+            }
+            return Err(ExternalServiceError::SeedingFailed {
+                id: service.id,
+                reason: e.to_string(),
+            });
+        }
+
+        info!("Seeded service {}", service.id);
+        Ok(())
+    }
+
+    /// Restore a dump from an S3 source into an existing service, leaving the
+    /// service in place if it fails
+    pub(crate) async fn restore_dump(
+        &self,
+        service_id: i32,
+        s3_source_id: i32,
+        key: &str,
+    ) -> anyhow::Result<()> {
+        let service_config = self.get_service_config(service_id).await?;
+        let service_instance =
+            self.get_service_instance(service_config.name.clone(), service_config.service_type);
+        self.restore_seed_dump(service_instance.as_ref(), service_config, s3_source_id, key)
+            .await
+    }
+
+    /// Restore a dump from an S3 source into a service
+    async fn restore_seed_dump(
+        &self,
+        service_instance: &dyn ExternalService,
+        service_config: ServiceConfig,
+        s3_source_id: i32,
+        key: &str,
+    ) -> anyhow::Result<()> {
+        let s3_source = s3_sources::Entity::find_by_id(s3_source_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| anyhow::anyhow!("S3 source {} not found", s3_source_id))?;
+
+        let access_key = self
+            .encryption_service
+            .decrypt_string(&s3_source.access_key_id)
+            .map_err(|e| anyhow::anyhow!("Failed to decrypt access key: {}", e))?;
+        let secret_key = self
+            .encryption_service
+            .decrypt_string(&s3_source.secret_key)
+            .map_err(|e| anyhow::anyhow!("Failed to decrypt secret key: {}", e))?;
+
+        let mut config_builder = aws_sdk_s3::Config::builder()
+            .behavior_version(aws_sdk_s3::config::BehaviorVersion::latest())
+            .region(aws_sdk_s3::config::Region::new(s3_source.region.clone()))
+            .force_path_style(s3_source.force_path_style.unwrap_or(true))
+            .credentials_provider(aws_sdk_s3::config::Credentials::new(
+                access_key,
+                secret_key,
+                None,
+                None,
+                "service-seeding",
+            ));
+        if let Some(endpoint) = &s3_source.endpoint {
+            let endpoint_url = if endpoint.starts_with("http") {
+                endpoint.clone()
+            } else {
+                format!("http://{}", endpoint)
+            };
+            config_builder = config_builder.endpoint_url(endpoint_url);
+        }
+        let s3_client = aws_sdk_s3::Client::from_conf(config_builder.build());
+
+        service_instance
+            .restore_from_s3(
+                &s3_client,
+                key.trim_start_matches('/'),
+                &s3_source,
+                service_config,
+                &TransferProgress::default(),
+            )
+            .await
+    }
+
     async fn store_inferred_parameters(
         &self,
         service_id: i32,
@@ -968,6 +1519,7 @@ impl ExternalServiceManager {
             }
         })?;
 
+        Self::ensure_managed(&service, "start")?;
         let service_instance =
             self.create_service_instance(service.name.clone(), service_type_enum);
 
@@ -979,6 +1531,8 @@ impl ExternalServiceManager {
                 id: service_id,
                 reason: e.to_string(),
             })?;
+        self.restore_resource_limits(&service, service_instance.as_ref())
+            .await;
 
         // Update status to running
         let mut service_update: external_services::ActiveModel = service.into();
@@ -1001,6 +1555,7 @@ impl ExternalServiceManager {
             }
         })?;
 
+        Self::ensure_managed(&service, "stop")?;
         let service_instance =
             self.create_service_instance(service.name.clone(), service_type_enum);
 
@@ -1022,17 +1577,30 @@ impl ExternalServiceManager {
         self.get_service_info(service_id).await
     }
 
+    /// Link a service to a project
+    ///
+    /// Projects linked to a managed service that supports it get a user of their own,
+    /// limited to `connection_limit` connections when given.
     pub async fn link_service_to_project(
         &self,
         service_id_val: i32,
         project_id_val: i32,
+        connection_limit: Option<i32>,
     ) -> Result<ProjectServiceInfo, ExternalServiceError> {
         // Verify service exists and get its type
         let service = self.get_service(service_id_val).await?;
         let service_type = service.service_type.clone();
+        if let Some(limit) = connection_limit {
+            if limit < 1 {
+                return Err(ExternalServiceError::ParameterValidationFailed {
+                    service_id: service_id_val,
+                    reason: "Connection limit must be at least 1".to_string(),
+                });
+            }
+        }
 
         // Verify project exists
-        let _project = projects::Entity::find_by_id(project_id_val)
+        let project = projects::Entity::find_by_id(project_id_val)
             .one(self.db.as_ref())
             .await?
             .ok_or(ExternalServiceError::ProjectNotFound { id: project_id_val })?;
@@ -1055,35 +1623,200 @@ impl ExternalServiceManager {
             }
         }
 
+        let credentials = if service.managed {
+            match self
+                .create_app_credentials(&service, &project.slug, connection_limit)
+                .await
+            {
+                Ok(credentials) => credentials,
+                // A limit can't be enforced on the service's own user
+                Err(e) if connection_limit.is_some() => return Err(e),
+                Err(e) => {
+                    warn!(
+                        "Project {} connects to service {} with the service's credentials: {}",
+                        project_id_val, service_id_val, e
+                    );
+                    None
+                }
+            }
+        } else {
+            None
+        };
+        if credentials.is_none() && connection_limit.is_some() {
+            return Err(ExternalServiceError::ParameterValidationFailed {
+                service_id: service_id_val,
+                reason: format!(
+                    "Services of type '{}' don't support per-project connection limits",
+                    service_type
+                ),
+            });
+        }
+        let app_password = credentials
+            .as_ref()
+            .map(|credentials| {
+                self.encryption_service
+                    .encrypt_string(&credentials.password)
+                    .map_err(|e| ExternalServiceError::EncryptionFailed {
+                        service_id: service_id_val,
+                        param_name: "app_password".to_string(),
+                        reason: e.to_string(),
+                    })
+            })
+            .transpose()?;
+
         // Create link
         let new_link = project_services::ActiveModel {
             project_id: Set(project_id_val),
             service_id: Set(service_id_val),
+            app_username: Set(credentials.as_ref().map(|c| c.username.clone())),
+            app_password: Set(app_password),
+            connection_limit: Set(credentials.as_ref().and_then(|c| c.connection_limit)),
             created_at: Set(Utc::now()),
             updated_at: Set(Utc::now()),
             ..Default::default()
         };
 
-        let link = new_link.insert(self.db.as_ref()).await?;
+        let link = match new_link.insert(self.db.as_ref()).await {
+            Ok(link) => link,
+            Err(e) => {
+                if let Some(credentials) = &credentials {
+                    if let Err(drop_error) = self.drop_app_credentials(&service, credentials).await
+                    {
+                        warn!(
+                            "Failed to drop user {} of unlinked project {}: {}",
+                            credentials.username, project_id_val, drop_error
+                        );
+                    }
+                }
+                return Err(e.into());
+            }
+        };
         let service_info = self.get_service_info(service_id_val).await?;
 
-        // Fetch project metadata
-        let project = projects::Entity::find_by_id(link.project_id)
-            .one(self.db.as_ref())
-            .await?
-            .ok_or(ExternalServiceError::ProjectNotFound {
-                id: link.project_id,
-            })?;
+        Ok(Self::project_service_info(&link, &project, service_info))
+    }
 
-        Ok(ProjectServiceInfo {
+    fn project_service_info(
+        link: &project_services::Model,
+        project: &projects::Model,
+        service: ExternalServiceInfo,
+    ) -> ProjectServiceInfo {
+        ProjectServiceInfo {
             id: link.id,
             project: ProjectInfo {
                 id: project.id,
-                slug: project.slug,
+                slug: project.slug.clone(),
                 created_at: project.created_at.to_rfc3339(),
             },
-            service: service_info,
-        })
+            service,
+            app_username: link.app_username.clone(),
+            connection_limit: link.connection_limit,
+        }
+    }
+
+    /// Create the user a project connects to a managed service with, for services
+    /// that have one per project
+    async fn create_app_credentials(
+        &self,
+        service: &external_services::Model,
+        project_slug: &str,
+        connection_limit: Option<i32>,
+    ) -> Result<Option<AppCredentials>, ExternalServiceError> {
+        let service_type = ServiceType::from_str(&service.service_type).map_err(|_| {
+            ExternalServiceError::InvalidServiceType {
+                id: service.id,
+                service_type: service.service_type.clone(),
+            }
+        })?;
+        let service_config = self.get_service_config(service.id).await?;
+        self.create_service_instance(service.name.clone(), service_type)
+            .create_app_credentials(service_config, project_slug, connection_limit)
+            .await
+            .map_err(|e| ExternalServiceError::InternalError {
+                reason: format!("Failed to create credentials of project: {}", e),
+            })
+    }
+
+    async fn drop_app_credentials(
+        &self,
+        service: &external_services::Model,
+        credentials: &AppCredentials,
+    ) -> Result<(), ExternalServiceError> {
+        let service_type = ServiceType::from_str(&service.service_type).map_err(|_| {
+            ExternalServiceError::InvalidServiceType {
+                id: service.id,
+                service_type: service.service_type.clone(),
+            }
+        })?;
+        let service_config = self.get_service_config(service.id).await?;
+        self.create_service_instance(service.name.clone(), service_type)
+            .drop_app_credentials(service_config, credentials)
+            .await
+            .map_err(|e| ExternalServiceError::InternalError {
+                reason: format!("Failed to revoke credentials of project: {}", e),
+            })
+    }
+
+    /// User of its own a link's project connects with
+    fn link_app_credentials(
+        &self,
+        link: &project_services::Model,
+    ) -> Result<Option<AppCredentials>, ExternalServiceError> {
+        let (Some(username), Some(encrypted_password)) = (&link.app_username, &link.app_password)
+        else {
+            return Ok(None);
+        };
+        let password = self
+            .encryption_service
+            .decrypt_string(encrypted_password)
+            .map_err(|e| ExternalServiceError::DecryptionFailed {
+                service_id: link.service_id,
+                param_name: "app_password".to_string(),
+                reason: e.to_string(),
+            })?;
+        Ok(Some(AppCredentials {
+            username: username.clone(),
+            password,
+            connection_limit: link.connection_limit,
+        }))
+    }
+
+    /// Drop the users of their own a project connects to its services with, before the
+    /// project and its links are deleted
+    ///
+    /// Tries every link and returns the first failure.
+    pub async fn revoke_project_credentials(
+        &self,
+        project_id_val: i32,
+    ) -> Result<(), ExternalServiceError> {
+        let links = project_services::Entity::find()
+            .filter(project_services::Column::ProjectId.eq(project_id_val))
+            .all(self.db.as_ref())
+            .await?;
+
+        let mut first_error = None;
+        for link in links {
+            let result = match self.link_app_credentials(&link) {
+                Ok(Some(credentials)) => match self.get_service(link.service_id).await {
+                    Ok(service) => self.drop_app_credentials(&service, &credentials).await,
+                    Err(e) => Err(e),
+                },
+                Ok(None) => Ok(()),
+                Err(e) => Err(e),
+            };
+            if let Err(e) = result {
+                warn!(
+                    "Failed to revoke credentials of project {} on service {}: {}",
+                    project_id_val, link.service_id, e
+                );
+                first_error.get_or_insert(e);
+            }
+        }
+
+        match first_error {
+            Some(e) => Err(e),
+            None => Ok(()),
+        }
     }
 
     pub async fn get_service_environment_variables(
@@ -1105,6 +1838,10 @@ impl ExternalServiceManager {
         // Convert parameters to strings for the service
         let params_str = Self::params_to_strings(&parameters);
 
+        if !service.managed {
+            return Self::unmanaged_env_vars(service_id_val, service_type, &parameters);
+        }
+
         // Get connection info from the service instance
         service_instance
             .get_environment_variables(&params_str)
@@ -1129,26 +1866,31 @@ impl ExternalServiceManager {
         })?;
 
         // Verify service is linked to project
-        let link_exists = project_services::Entity::find()
+        let Some(link) = project_services::Entity::find()
             .filter(
                 project_services::Column::ServiceId
                     .eq(service_id_val)
                     .and(project_services::Column::ProjectId.eq(project_id)),
             )
             .one(self.db.as_ref())
-            .await?;
-
-        if link_exists.is_none() {
+            .await?
+        else {
             return Err(ExternalServiceError::ServiceNotLinkedToProject {
                 service_id: service_id_val,
                 project_id,
             });
+        };
+
+        let parameters = self.get_service_parameters(service_id_val).await?;
+        // Nothing is provisioned in services hosted elsewhere: linked environments
+        // share the registered database
+        if !service.managed {
+            return Self::unmanaged_env_vars(service_id_val, service_type, &parameters);
         }
 
         // Create service instance
         let service_instance =
             self.create_service_instance(service.name.clone(), service_type.clone());
-        let parameters = self.get_service_parameters(service_id_val).await?;
         let service_config = ServiceConfig {
             name: service.name.clone(),
             service_type,
@@ -1185,12 +1927,26 @@ impl ExternalServiceManager {
         let environment_slug = environment.slug;
 
         // Get runtime environment variables (this provisions resources like databases/buckets)
-        service_instance
-            .get_runtime_env_vars(service_config, &project_slug, &environment_slug)
-            .await
-            .map_err(|e| ExternalServiceError::InternalError {
-                reason: format!("Failed to get runtime environment variables: {}", e),
-            })
+        let runtime_vars = match self.link_app_credentials(&link)? {
+            Some(credentials) => {
+                service_instance
+                    .get_app_runtime_env_vars(
+                        service_config,
+                        &project_slug,
+                        &environment_slug,
+                        &credentials,
+                    )
+                    .await
+            }
+            None => {
+                service_instance
+                    .get_runtime_env_vars(service_config, &project_slug, &environment_slug)
+                    .await
+            }
+        };
+        runtime_vars.map_err(|e| ExternalServiceError::InternalError {
+            reason: format!("Failed to get runtime environment variables: {}", e),
+        })
     }
 
     pub async fn get_service_docker_environment_variables(
@@ -1225,6 +1981,9 @@ impl ExternalServiceManager {
         }
 
         let parameters = self.get_service_parameters(service_id_val).await?;
+        if !service.managed {
+            return Self::unmanaged_env_vars(service_id_val, service_type, &parameters);
+        }
         let service_instance = self.create_service_instance(service.name.clone(), service_type);
 
         // Convert parameters to strings for the service
@@ -1243,7 +2002,26 @@ impl ExternalServiceManager {
         project_id_val: i32,
     ) -> Result<(), ExternalServiceError> {
         // Verify service exists
-        self.get_service(service_id_val).await?;
+        let service = self.get_service(service_id_val).await?;
+
+        let link = project_services::Entity::find()
+            .filter(
+                project_services::Column::ServiceId
+                    .eq(service_id_val)
+                    .and(project_services::Column::ProjectId.eq(project_id_val)),
+            )
+            .one(self.db.as_ref())
+            .await?;
+        // The project's access is revoked first, so that a failure keeps the link and
+        // its credentials to retry with
+        if let Some(credentials) = link
+            .as_ref()
+            .map(|link| self.link_app_credentials(link))
+            .transpose()?
+            .flatten()
+        {
+            self.drop_app_credentials(&service, &credentials).await?;
+        }
 
         // Delete the link
         let deleted = project_services::Entity::delete_many()
@@ -1289,15 +2067,11 @@ impl ExternalServiceManager {
                     id: link.project_id,
                 })?;
 
-            project_services_list.push(ProjectServiceInfo {
-                id: link.id,
-                project: ProjectInfo {
-                    id: project.id,
-                    slug: project.slug,
-                    created_at: project.created_at.to_rfc3339(),
-                },
-                service: service_info.clone(),
-            });
+            project_services_list.push(Self::project_service_info(
+                &link,
+                &project,
+                service_info.clone(),
+            ));
         }
 
         Ok(project_services_list)
@@ -1323,15 +2097,7 @@ impl ExternalServiceManager {
         let mut project_services_list = Vec::new();
         for link in links {
             let service_info = self.get_service_info(link.service_id).await?;
-            project_services_list.push(ProjectServiceInfo {
-                id: link.id,
-                project: ProjectInfo {
-                    id: project.id,
-                    slug: project.slug.clone(),
-                    created_at: project.created_at.to_rfc3339(),
-                },
-                service: service_info,
-            });
+            project_services_list.push(Self::project_service_info(&link, &project, service_info));
         }
 
         Ok(project_services_list)
@@ -1373,11 +2139,15 @@ impl ExternalServiceManager {
         // Convert parameters to strings for the service
         let params_str = Self::params_to_strings(&parameters);
 
-        let env_vars = service_instance
-            .get_environment_variables(&params_str)
-            .map_err(|e| ExternalServiceError::InternalError {
-                reason: format!("Failed to get environment variables: {}", e),
-            })?;
+        let env_vars = if service.managed {
+            service_instance
+                .get_environment_variables(&params_str)
+                .map_err(|e| ExternalServiceError::InternalError {
+                    reason: format!("Failed to get environment variables: {}", e),
+                })?
+        } else {
+            Self::unmanaged_env_vars(service_id_val, service_type, &parameters)?
+        };
 
         // Check if the variable exists
         match env_vars.get(var_name) {
@@ -1502,8 +2272,33 @@ impl ExternalServiceManager {
 
         let mut all_vars = HashMap::new();
 
+        // Services hosted elsewhere have the same variables however apps reach them
+        if !service.managed {
+            if let Some(proj_id) = project_id {
+                let link_exists = project_services::Entity::find()
+                    .filter(
+                        project_services::Column::ServiceId
+                            .eq(service_id)
+                            .and(project_services::Column::ProjectId.eq(proj_id)),
+                    )
+                    .one(self.db.as_ref())
+                    .await?;
+                if link_exists.is_none() {
+                    return Err(ExternalServiceError::ServiceNotLinkedToProject {
+                        service_id,
+                        project_id: proj_id,
+                    });
+                }
+            }
+            all_vars.extend(Self::unmanaged_env_vars(
+                service_id,
+                service_type,
+                &parameters,
+            )?);
+        }
+
         // Get basic environment variables
-        if !options.include_runtime {
+        if !options.include_runtime && service.managed {
             // Basic localhost env vars
             let basic_vars = service_instance
                 .get_environment_variables(&params_str)
@@ -1514,7 +2309,7 @@ impl ExternalServiceManager {
         }
 
         // Get Docker-specific variables if requested
-        if options.include_docker {
+        if options.include_docker && service.managed {
             if let (Some(proj_id), Some(_env_id)) = (project_id, environment_id) {
                 // Verify service is linked to project
                 let link_exists = project_services::Entity::find()
@@ -1543,7 +2338,7 @@ impl ExternalServiceManager {
         }
 
         // Get runtime variables if requested
-        if options.include_runtime {
+        if options.include_runtime && service.managed {
             if let (Some(proj_id), Some(env_id)) = (project_id, environment_id) {
                 // Verify service is linked to project
                 let link_exists = project_services::Entity::find()
@@ -1555,12 +2350,12 @@ impl ExternalServiceManager {
                     .one(self.db.as_ref())
                     .await?;
 
-                if link_exists.is_none() {
+                let Some(link) = link_exists else {
                     return Err(ExternalServiceError::ServiceNotLinkedToProject {
                         service_id,
                         project_id: proj_id,
                     });
-                }
+                };
 
                 let service_config = ServiceConfig {
                     name: service.name.clone(),
@@ -1594,12 +2389,26 @@ impl ExternalServiceManager {
                         reason: format!("Environment {} not found", env_id),
                     })?;
 
-                let runtime_vars = service_instance
-                    .get_runtime_env_vars(service_config, &project.slug, &environment.slug)
-                    .await
-                    .map_err(|e| ExternalServiceError::InternalError {
-                        reason: format!("Failed to get runtime environment variables: {}", e),
-                    })?;
+                let runtime_vars = match self.link_app_credentials(&link)? {
+                    Some(credentials) => {
+                        service_instance
+                            .get_app_runtime_env_vars(
+                                service_config,
+                                &project.slug,
+                                &environment.slug,
+                                &credentials,
+                            )
+                            .await
+                    }
+                    None => {
+                        service_instance
+                            .get_runtime_env_vars(service_config, &project.slug, &environment.slug)
+                            .await
+                    }
+                }
+                .map_err(|e| ExternalServiceError::InternalError {
+                    reason: format!("Failed to get runtime environment variables: {}", e),
+                })?;
 
                 all_vars.extend(runtime_vars);
             }
@@ -1659,11 +2468,15 @@ impl ExternalServiceManager {
         // Convert parameters to strings for the service
         let params_str = Self::params_to_strings(&parameters);
 
-        let env_vars = service_instance
-            .get_environment_variables(&params_str)
-            .map_err(|e| ExternalServiceError::InternalError {
-                reason: format!("Failed to get environment variables: {}", e),
-            })?;
+        let env_vars = if service.managed {
+            service_instance
+                .get_environment_variables(&params_str)
+                .map_err(|e| ExternalServiceError::InternalError {
+                    reason: format!("Failed to get environment variables: {}", e),
+                })?
+        } else {
+            Self::unmanaged_env_vars(service_id_val, service_type, &parameters)?
+        };
 
         Ok(env_vars.keys().cloned().collect())
     }
@@ -1687,11 +2500,15 @@ impl ExternalServiceManager {
         // Convert parameters to strings for the service
         let params_str = Self::params_to_strings(&parameters);
 
-        let env_vars = service_instance
-            .get_environment_variables(&params_str)
-            .map_err(|e| ExternalServiceError::InternalError {
-                reason: format!("Failed to get environment variables: {}", e),
-            })?;
+        let env_vars = if service.managed {
+            service_instance
+                .get_environment_variables(&params_str)
+                .map_err(|e| ExternalServiceError::InternalError {
+                    reason: format!("Failed to get environment variables: {}", e),
+                })?
+        } else {
+            Self::unmanaged_env_vars(service_id_val, service_type, &parameters)?
+        };
 
         // Mask sensitive values based on variable names
         let masked_vars = env_vars
@@ -2014,6 +2831,8 @@ impl ExternalServiceManager {
             connection_info: None,
             created_at: external_service.created_at.to_rfc3339(),
             updated_at: external_service.updated_at.to_rfc3339(),
+            tags: external_service.tags,
+            managed: external_service.managed,
         })
     }
 }
@@ -3185,7 +4004,7 @@ mod tests {
 
         // Link first PostgreSQL service to project
         let result_link1 = manager
-            .link_service_to_project(service_pg1.id, project_id)
+            .link_service_to_project(service_pg1.id, project_id, None)
             .await;
         assert!(
             result_link1.is_ok(),
@@ -3195,7 +4014,7 @@ mod tests {
 
         // Try to link second PostgreSQL service (should fail due to duplicate type)
         let result_link2 = manager
-            .link_service_to_project(service_pg2.id, project_id)
+            .link_service_to_project(service_pg2.id, project_id, None)
             .await;
 
         assert!(
@@ -3419,6 +4238,8 @@ mod tests {
             connection_info: Some("postgresql://localhost:5432/postgres".to_string()),
             created_at: "2025-01-12T10:30:00Z".to_string(),
             updated_at: "2025-01-12T10:30:00Z".to_string(),
+            tags: Tags::default(),
+            managed: true,
         };
 
         assert_eq!(service_info.id, 1);
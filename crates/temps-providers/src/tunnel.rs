@@ -0,0 +1,696 @@
+//! Managed service tunnels
+//!
+//! Exposes a managed service (database, cache, ...) on a port of the Temps host for a
+//! time-boxed session so developers can connect from their local machine without the
+//! service port being published permanently.
+//!
+//! Each tunnel is an authenticated TCP proxy: only connections from the allowed source
+//! networks (by default the IP of the user who opened it, or WireGuard peer addresses when
+//! `bind_address` is the VPN interface) are forwarded to the service. Tunnels close
+//! automatically once they expire or stay idle for longer than the configured timeout.
+//!
+//! The service's own credentials are never handed out. Each tunnel gets a user of its
+//! own, valid until the tunnel expires and dropped when it closes, so tunnels can only be
+//! opened to services that can have users of their own.
+
+use chrono::{DateTime, Duration, Utc};
+use ipnet::IpNet;
+use serde::Serialize;
+use std::collections::HashMap;
+use std::net::{IpAddr, SocketAddr};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex};
+use temps_core::ServiceTunnelSettings;
+use thiserror::Error;
+use tokio::net::{TcpListener, TcpStream};
+use tokio::sync::{watch, RwLock};
+use tracing::{debug, info, warn};
+use utoipa::ToSchema;
+
+use crate::externalsvc::AppCredentials;
+use crate::services::{ExternalServiceError, ExternalServiceManager};
+
+/// How often expired and idle tunnels are reaped
+const REAP_INTERVAL_SECS: u64 = 30;
+
+#[derive(Error, Debug)]
+pub enum TunnelError {
+    #[error("Service tunnels are disabled")]
+    Disabled,
+
+    #[error("Tunnel {0} not found")]
+    NotFound(String),
+
+    #[error("{0}")]
+    Validation(String),
+
+    #[error(transparent)]
+    Service(#[from] ExternalServiceError),
+
+    #[error("Tunnel error: {0}")]
+    Io(String),
+}
+
+/// Request to open a tunnel
+#[derive(Debug, Clone, Default)]
+pub struct OpenTunnel {
+    /// Lifetime in minutes; uses the configured default when not set
+    pub ttl_minutes: Option<u32>,
+    /// Source networks allowed to connect (CIDR or bare IP)
+    pub allowed_sources: Vec<String>,
+}
+
+/// Public information about an open tunnel
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ServiceTunnelInfo {
+    pub id: String,
+    pub service_id: i32,
+    pub service_name: String,
+    /// User who opened the tunnel
+    pub opened_by: i32,
+    /// Host to connect to from the local machine
+    pub host: String,
+    /// Port to connect to from the local machine
+    pub port: u16,
+    /// Source networks allowed to connect
+    pub allowed_sources: Vec<String>,
+    pub active_connections: usize,
+    pub opened_at: DateTime<Utc>,
+    pub expires_at: DateTime<Utc>,
+    pub last_activity_at: DateTime<Utc>,
+}
+
+/// A newly opened tunnel together with the credentials issued for it
+#[derive(Debug, Clone)]
+pub struct OpenedTunnel {
+    pub tunnel: ServiceTunnelInfo,
+    pub credentials: HashMap<String, String>,
+}
+
+/// Why a tunnel was closed
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum TunnelCloseReason {
+    Manual,
+    Expired,
+    Idle,
+    ServiceRemoved,
+}
+
+impl std::fmt::Display for TunnelCloseReason {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            TunnelCloseReason::Manual => write!(f, "manual"),
+            TunnelCloseReason::Expired => write!(f, "expired"),
+            TunnelCloseReason::Idle => write!(f, "idle"),
+            TunnelCloseReason::ServiceRemoved => write!(f, "service_removed"),
+        }
+    }
+}
+
+/// Connection activity shared between a tunnel and its proxy tasks
+struct TunnelActivity {
+    active_connections: AtomicUsize,
+    last_activity_at: Mutex<DateTime<Utc>>,
+}
+
+impl TunnelActivity {
+    fn new(now: DateTime<Utc>) -> Self {
+        Self {
+            active_connections: AtomicUsize::new(0),
+            last_activity_at: Mutex::new(now),
+        }
+    }
+
+    fn touch(&self) {
+        *self.last_activity_at.lock().unwrap() = Utc::now();
+    }
+
+    fn last_activity_at(&self) -> DateTime<Utc> {
+        *self.last_activity_at.lock().unwrap()
+    }
+}
+
+struct TunnelEntry {
+    info: ServiceTunnelInfo,
+    activity: Arc<TunnelActivity>,
+    idle_timeout: Duration,
+    /// Signals the listener and every proxied connection to stop
+    shutdown: watch::Sender<bool>,
+    /// User of the service issued for the tunnel, dropped when it closes
+    credentials: AppCredentials,
+}
+
+impl TunnelEntry {
+    fn snapshot(&self) -> ServiceTunnelInfo {
+        let mut info = self.info.clone();
+        info.active_connections = self.activity.active_connections.load(Ordering::Relaxed);
+        info.last_activity_at = self.activity.last_activity_at();
+        info
+    }
+
+    fn close_reason(&self, now: DateTime<Utc>) -> Option<TunnelCloseReason> {
+        if now >= self.info.expires_at {
+            return Some(TunnelCloseReason::Expired);
+        }
+        let active = self.activity.active_connections.load(Ordering::Relaxed);
+        if active == 0 && now - self.activity.last_activity_at() >= self.idle_timeout {
+            return Some(TunnelCloseReason::Idle);
+        }
+        None
+    }
+}
+
+/// Parse allowed source networks, accepting bare IP addresses as single-host networks
+pub fn parse_allowed_sources(sources: &[String]) -> Result<Vec<IpNet>, TunnelError> {
+    sources
+        .iter()
+        .map(|source| {
+            let source = source.trim();
+            source
+                .parse::<IpNet>()
+                .or_else(|_| source.parse::<IpAddr>().map(IpNet::from))
+                .map(|net| net.trunc())
+                .map_err(|_| {
+                    TunnelError::Validation(format!("Invalid source network '{}'", source))
+                })
+        })
+        .collect()
+}
+
+/// Returns true if `addr` belongs to one of the allowed networks
+pub fn is_source_allowed(allowed: &[IpNet], addr: IpAddr) -> bool {
+    // Compare IPv4-mapped IPv6 peers (dual-stack listeners) as IPv4
+    let addr = match addr {
+        IpAddr::V6(v6) => v6
+            .to_ipv4_mapped()
+            .map(IpAddr::V4)
+            .unwrap_or(IpAddr::V6(v6)),
+        v4 => v4,
+    };
+    allowed.iter().any(|net| net.contains(&addr))
+}
+
+/// Resolve a requested lifetime against the configured default and maximum
+pub fn resolve_ttl(
+    requested: Option<u32>,
+    settings: &ServiceTunnelSettings,
+) -> Result<Duration, TunnelError> {
+    let minutes = requested.unwrap_or(settings.default_ttl_minutes);
+    if minutes == 0 {
+        return Err(TunnelError::Validation(
+            "Tunnel lifetime must be at least one minute".to_string(),
+        ));
+    }
+    if minutes > settings.max_ttl_minutes {
+        return Err(TunnelError::Validation(format!(
+            "Tunnel lifetime cannot exceed {} minutes",
+            settings.max_ttl_minutes
+        )));
+    }
+    Ok(Duration::minutes(minutes as i64))
+}
+
+/// Host clients should connect to: the configured public host, the external URL host,
+/// or the bind address as a last resort
+fn public_host(settings: &ServiceTunnelSettings, external_url: Option<&str>) -> String {
+    if let Some(host) = settings.public_host.as_ref().filter(|h| !h.is_empty()) {
+        return host.clone();
+    }
+    if let Some(host) = external_url
+        .and_then(|u| url::Url::parse(u).ok())
+        .and_then(|u| u.host_str().map(str::to_string))
+    {
+        return host;
+    }
+    settings.bind_address.clone()
+}
+
+/// Credentials returned to the client: the user issued for the tunnel and the
+/// service's database, if any
+fn tunnel_credentials(
+    parameters: &serde_json::Value,
+    user: &AppCredentials,
+) -> HashMap<String, String> {
+    let mut credentials = HashMap::new();
+    credentials.insert("username".to_string(), user.username.clone());
+    credentials.insert("password".to_string(), user.password.clone());
+    if let Some(database) = parameters
+        .get("database")
+        .and_then(|v| v.as_str())
+        .filter(|v| !v.is_empty())
+    {
+        credentials.insert("database".to_string(), database.to_string());
+    }
+    credentials
+}
+
+async fn proxy_connection(
+    mut inbound: TcpStream,
+    upstream: String,
+    activity: Arc<TunnelActivity>,
+    mut shutdown: watch::Receiver<bool>,
+) {
+    activity.active_connections.fetch_add(1, Ordering::Relaxed);
+    activity.touch();
+
+    match TcpStream::connect(&upstream).await {
+        Ok(mut outbound) => {
+            tokio::select! {
+                result = tokio::io::copy_bidirectional(&mut inbound, &mut outbound) => {
+                    if let Err(e) = result {
+                        debug!("Tunnel connection to {} ended: {}", upstream, e);
+                    }
+                }
+                _ = shutdown.changed() => {
+                    debug!("Tunnel closed, dropping connection to {}", upstream);
+                }
+            }
+        }
+        Err(e) => warn!("Tunnel failed to connect to upstream {}: {}", upstream, e),
+    }
+
+    activity.touch();
+    activity.active_connections.fetch_sub(1, Ordering::Relaxed);
+}
+
+async fn accept_loop(
+    listener: TcpListener,
+    tunnel_id: String,
+    upstream: String,
+    allowed_sources: Vec<IpNet>,
+    activity: Arc<TunnelActivity>,
+    mut shutdown: watch::Receiver<bool>,
+) {
+    loop {
+        let accepted = tokio::select! {
+            accepted = listener.accept() => accepted,
+            _ = shutdown.changed() => return,
+        };
+        let (stream, peer) = match accepted {
+            Ok(conn) => conn,
+            Err(e) => {
+                warn!("Tunnel {} failed to accept connection: {}", tunnel_id, e);
+                continue;
+            }
+        };
+
+        if !is_source_allowed(&allowed_sources, peer.ip()) {
+            warn!(
+                "Tunnel {} rejected connection from unauthorized source {}",
+                tunnel_id, peer
+            );
+            continue;
+        }
+
+        debug!("Tunnel {} accepted connection from {}", tunnel_id, peer);
+        tokio::spawn(proxy_connection(
+            stream,
+            upstream.clone(),
+            activity.clone(),
+            shutdown.clone(),
+        ));
+    }
+}
+
+/// Opens, tracks and closes tunnels to managed services
+pub struct ServiceTunnelManager {
+    external_service_manager: Arc<ExternalServiceManager>,
+    config_service: Arc<temps_config::ConfigService>,
+    tunnels: RwLock<HashMap<String, TunnelEntry>>,
+}
+
+impl ServiceTunnelManager {
+    pub fn new(
+        external_service_manager: Arc<ExternalServiceManager>,
+        config_service: Arc<temps_config::ConfigService>,
+    ) -> Self {
+        Self {
+            external_service_manager,
+            config_service,
+            tunnels: RwLock::new(HashMap::new()),
+        }
+    }
+
+    async fn get_settings(&self) -> Result<(ServiceTunnelSettings, Option<String>), TunnelError> {
+        let settings = self
+            .config_service
+            .get_settings()
+            .await
+            .map_err(|e| TunnelError::Io(format!("Failed to load tunnel settings: {}", e)))?;
+        Ok((settings.service_tunnels, settings.external_url))
+    }
+
+    /// Open a tunnel to a managed service on behalf of `user_id`
+    pub async fn open_tunnel(
+        &self,
+        service_id: i32,
+        user_id: i32,
+        request: OpenTunnel,
+    ) -> Result<OpenedTunnel, TunnelError> {
+        let (settings, external_url) = self.get_settings().await?;
+        if !settings.enabled {
+            return Err(TunnelError::Disabled);
+        }
+
+        let ttl = resolve_ttl(request.ttl_minutes, &settings)?;
+        if request.allowed_sources.is_empty() {
+            return Err(TunnelError::Validation(
+                "At least one allowed source network is required".to_string(),
+            ));
+        }
+        let allowed_sources = parse_allowed_sources(&request.allowed_sources)?;
+
+        let service_config = self
+            .external_service_manager
+            .get_service_config(service_id)
+            .await?;
+        let service_name = service_config.name.clone();
+        let service_type = service_config.service_type;
+        let parameters = service_config.parameters.clone();
+
+        let instance = self
+            .external_service_manager
+            .get_service_instance(service_name.clone(), service_config.service_type);
+        let (upstream_host, upstream_port) = instance
+            .get_effective_address(service_config.clone())
+            .map_err(|e| TunnelError::Io(format!("Failed to resolve service address: {}", e)))?;
+        let upstream = format!("{}:{}", upstream_host, upstream_port);
+
+        let listener = TcpListener::bind((settings.bind_address.as_str(), 0))
+            .await
+            .map_err(|e| TunnelError::Io(format!("Failed to bind tunnel listener: {}", e)))?;
+        let local_addr: SocketAddr = listener
+            .local_addr()
+            .map_err(|e| TunnelError::Io(e.to_string()))?;
+
+        let now = Utc::now();
+        let id = uuid::Uuid::new_v4().to_string();
+        let user = instance
+            .create_tunnel_credentials(service_config, &id, now + ttl)
+            .await
+            .map_err(|e| TunnelError::Io(format!("Failed to create tunnel credentials: {}", e)))?
+            .ok_or_else(|| {
+                TunnelError::Validation(format!(
+                    "Tunnels to {} services are not supported: they can't get a user of their own",
+                    service_type
+                ))
+            })?;
+        let credentials = tunnel_credentials(&parameters, &user);
+        let activity = Arc::new(TunnelActivity::new(now));
+        let info = ServiceTunnelInfo {
+            id: id.clone(),
+            service_id,
+            service_name,
+            opened_by: user_id,
+            host: public_host(&settings, external_url.as_deref()),
+            port: local_addr.port(),
+            allowed_sources: allowed_sources.iter().map(|n| n.to_string()).collect(),
+            active_connections: 0,
+            opened_at: now,
+            expires_at: now + ttl,
+            last_activity_at: now,
+        };
+
+        let (shutdown, shutdown_rx) = watch::channel(false);
+        tokio::spawn(accept_loop(
+            listener,
+            id.clone(),
+            upstream.clone(),
+            allowed_sources,
+            activity.clone(),
+            shutdown_rx,
+        ));
+
+        info!(
+            "Opened tunnel {} to service {} ({}) on port {} for user {}, expires at {}",
+            id, service_id, upstream, info.port, user_id, info.expires_at
+        );
+
+        self.tunnels.write().await.insert(
+            id,
+            TunnelEntry {
+                info: info.clone(),
+                activity,
+                idle_timeout: Duration::minutes(settings.idle_timeout_minutes as i64),
+                shutdown,
+                credentials: user,
+            },
+        );
+
+        Ok(OpenedTunnel {
+            tunnel: info,
+            credentials,
+        })
+    }
+
+    /// List open tunnels, optionally only those for one service
+    pub async fn list_tunnels(&self, service_id: Option<i32>) -> Vec<ServiceTunnelInfo> {
+        let tunnels = self.tunnels.read().await;
+        let mut list: Vec<ServiceTunnelInfo> = tunnels
+            .values()
+            .filter(|entry| match service_id {
+                Some(id) => entry.info.service_id == id,
+                None => true,
+            })
+            .map(TunnelEntry::snapshot)
+            .collect();
+        list.sort_by_key(|t| t.opened_at);
+        list
+    }
+
+    /// Get an open tunnel by ID
+    pub async fn get_tunnel(&self, tunnel_id: &str) -> Result<ServiceTunnelInfo, TunnelError> {
+        self.tunnels
+            .read()
+            .await
+            .get(tunnel_id)
+            .map(TunnelEntry::snapshot)
+            .ok_or_else(|| TunnelError::NotFound(tunnel_id.to_string()))
+    }
+
+    /// Drop the user issued for a tunnel, closing the connections made with it
+    async fn revoke_credentials(
+        &self,
+        service_id: i32,
+        credentials: &AppCredentials,
+    ) -> Result<(), TunnelError> {
+        let service_config = self
+            .external_service_manager
+            .get_service_config(service_id)
+            .await?;
+        self.external_service_manager
+            .get_service_instance(service_config.name.clone(), service_config.service_type)
+            .drop_app_credentials(service_config, credentials)
+            .await
+            .map_err(|e| TunnelError::Io(format!("Failed to revoke tunnel credentials: {}", e)))
+    }
+
+    /// Close a tunnel, stopping its listener, dropping proxied connections and
+    /// revoking the credentials issued for it
+    pub async fn close_tunnel(
+        &self,
+        tunnel_id: &str,
+        reason: TunnelCloseReason,
+    ) -> Result<ServiceTunnelInfo, TunnelError> {
+        let entry = self
+            .tunnels
+            .write()
+            .await
+            .remove(tunnel_id)
+            .ok_or_else(|| TunnelError::NotFound(tunnel_id.to_string()))?;
+
+        let _ = entry.shutdown.send(true);
+        // The user stops working when the tunnel expires anyway
+        if let Err(e) = self
+            .revoke_credentials(entry.info.service_id, &entry.credentials)
+            .await
+        {
+            warn!(
+                "Tunnel {} closed, but its user {} is left until it expires: {}",
+                tunnel_id, entry.credentials.username, e
+            );
+        }
+        info!(
+            "Closed tunnel {} to service {} ({})",
+            tunnel_id, entry.info.service_id, reason
+        );
+        Ok(entry.snapshot())
+    }
+
+    /// Close every tunnel that points at a service (e.g. when it is deleted)
+    pub async fn close_service_tunnels(&self, service_id: i32) {
+        let ids: Vec<String> = self
+            .list_tunnels(Some(service_id))
+            .await
+            .into_iter()
+            .map(|t| t.id)
+            .collect();
+        for id in ids {
+            let _ = self
+                .close_tunnel(&id, TunnelCloseReason::ServiceRemoved)
+                .await;
+        }
+    }
+
+    /// Close tunnels that expired or went idle, returning the IDs that were closed
+    pub async fn reap(&self) -> Vec<String> {
+        let now = Utc::now();
+        let due: Vec<(String, TunnelCloseReason)> = self
+            .tunnels
+            .read()
+            .await
+            .iter()
+            .filter_map(|(id, entry)| entry.close_reason(now).map(|r| (id.clone(), r)))
+            .collect();
+
+        let mut closed = Vec::new();
+        for (id, reason) in due {
+            if self.close_tunnel(&id, reason).await.is_ok() {
+                closed.push(id);
+            }
+        }
+        closed
+    }
+
+    /// Periodically close expired and idle tunnels; runs forever
+    pub async fn start_reaper(self: Arc<Self>) {
+        let mut interval =
+            tokio::time::interval(std::time::Duration::from_secs(REAP_INTERVAL_SECS));
+        loop {
+            interval.tick().await;
+            let closed = self.reap().await;
+            if !closed.is_empty() {
+                debug!("Reaped {} service tunnel(s)", closed.len());
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_allowed_sources_accepts_ips_and_cidrs() {
+        let sources = parse_allowed_sources(&[
+            "203.0.113.7".to_string(),
+            "10.66.0.5/24".to_string(),
+            "2001:db8::1".to_string(),
+        ])
+        .unwrap();
+
+        assert_eq!(
+            sources.iter().map(|n| n.to_string()).collect::<Vec<_>>(),
+            vec!["203.0.113.7/32", "10.66.0.0/24", "2001:db8::1/128"]
+        );
+        assert!(parse_allowed_sources(&["not-an-ip".to_string()]).is_err());
+    }
+
+    #[test]
+    fn test_is_source_allowed() {
+        let allowed = parse_allowed_sources(&["10.66.0.0/24".to_string()]).unwrap();
+
+        assert!(is_source_allowed(&allowed, "10.66.0.9".parse().unwrap()));
+        assert!(is_source_allowed(
+            &allowed,
+            "::ffff:10.66.0.9".parse().unwrap()
+        ));
+        assert!(!is_source_allowed(&allowed, "10.67.0.9".parse().unwrap()));
+    }
+
+    #[test]
+    fn test_resolve_ttl() {
+        let settings = ServiceTunnelSettings::default();
+
+        assert_eq!(resolve_ttl(None, &settings).unwrap(), Duration::minutes(60));
+        assert_eq!(
+            resolve_ttl(Some(5), &settings).unwrap(),
+            Duration::minutes(5)
+        );
+        assert!(resolve_ttl(Some(0), &settings).is_err());
+        assert!(resolve_ttl(Some(settings.max_ttl_minutes + 1), &settings).is_err());
+    }
+
+    #[test]
+    fn test_public_host_fallbacks() {
+        let mut settings = ServiceTunnelSettings::default();
+        assert_eq!(
+            public_host(&settings, Some("https://temps.example.com")),
+            "temps.example.com"
+        );
+        assert_eq!(public_host(&settings, None), "0.0.0.0");
+
+        settings.public_host = Some("db.example.com".to_string());
+        assert_eq!(
+            public_host(&settings, Some("https://temps.example.com")),
+            "db.example.com"
+        );
+    }
+
+    #[test]
+    fn test_tunnel_credentials_never_include_the_service_user() {
+        let parameters = serde_json::json!({
+            "host": "localhost",
+            "port": "5432",
+            "username": "postgres",
+            "password": "secret",
+            "database": "app",
+        });
+
+        let user = AppCredentials {
+            username: "tunnel_3f2b8c1e9a7d".to_string(),
+            password: "issued".to_string(),
+            connection_limit: None,
+        };
+        let credentials = tunnel_credentials(&parameters, &user);
+        assert_eq!(credentials.len(), 3);
+        assert_eq!(credentials.get("database").unwrap(), "app");
+        assert_eq!(credentials.get("username").unwrap(), "tunnel_3f2b8c1e9a7d");
+        assert_eq!(credentials.get("password").unwrap(), "issued");
+    }
+
+    #[test]
+    fn test_tunnel_entry_close_reason() {
+        let now = Utc::now();
+        let entry = TunnelEntry {
+            info: ServiceTunnelInfo {
+                id: "t1".to_string(),
+                service_id: 1,
+                service_name: "db".to_string(),
+                opened_by: 1,
+                host: "localhost".to_string(),
+                port: 40000,
+                allowed_sources: vec![],
+                active_connections: 0,
+                opened_at: now,
+                expires_at: now + Duration::minutes(60),
+                last_activity_at: now,
+            },
+            activity: Arc::new(TunnelActivity::new(now)),
+            idle_timeout: Duration::minutes(15),
+            shutdown: watch::channel(false).0,
+            credentials: AppCredentials {
+                username: "tunnel_t1".to_string(),
+                password: "issued".to_string(),
+                connection_limit: None,
+            },
+        };
+
+        assert_eq!(entry.close_reason(now + Duration::minutes(5)), None);
+        assert_eq!(
+            entry.close_reason(now + Duration::minutes(16)),
+            Some(TunnelCloseReason::Idle)
+        );
+
+        // An active connection keeps the tunnel open until it expires
+        entry
+            .activity
+            .active_connections
+            .store(1, Ordering::Relaxed);
+        assert_eq!(entry.close_reason(now + Duration::minutes(16)), None);
+        assert_eq!(
+            entry.close_reason(now + Duration::minutes(61)),
+            Some(TunnelCloseReason::Expired)
+        );
+    }
+}
@@ -17,6 +17,11 @@ pub(crate) async fn ensure_network_exists(
         let options = NetworkCreateRequest {
             name: network_name.to_string(),
             driver: Some("bridge".to_string()),
+            // Same `sh.temps.managed` label the deployer sets on the network
+            labels: Some(std::collections::HashMap::from([(
+                "sh.temps.managed".to_string(),
+                "true".to_string(),
+            )])),
             ..Default::default()
         };
 
@@ -31,3 +36,141 @@ pub(crate) async fn ensure_network_exists(
 
     Ok(())
 }
+
+/// Copy a script into a container at `/tmp/<file_name>` and run a command on it
+///
+/// Returns the command's output, stdout and stderr interleaved. Fails with the tail
+/// of the output when the command exits with a non-zero status.
+pub(crate) async fn run_script_in_container(
+    docker: &Docker,
+    container_name: &str,
+    file_name: &str,
+    script: &str,
+    cmd: Vec<String>,
+    env: Vec<String>,
+) -> anyhow::Result<String> {
+    let mut header = tar::Header::new_gnu();
+    header.set_size(script.len() as u64);
+    header.set_mode(0o644);
+    header.set_cksum();
+    let mut tar = tar::Builder::new(Vec::new());
+    tar.append_data(&mut header, file_name, script.as_bytes())?;
+    let tar_data = tar.into_inner()?;
+
+    docker
+        .upload_to_container(
+            container_name,
+            Some(bollard::query_parameters::UploadToContainerOptions {
+                path: "/tmp".to_string(),
+                ..Default::default()
+            }),
+            bollard::body_full(bytes::Bytes::from(tar_data)),
+        )
+        .await
+        .map_err(|e| anyhow::anyhow!("Failed to copy script into container: {}", e))?;
+
+    let (exit_code, output) = exec_in_container(docker, container_name, cmd, env).await?;
+
+    // The script may hold credentials or data, so it doesn't stay in the container
+    let cleanup = docker
+        .create_exec(
+            container_name,
+            bollard::exec::CreateExecOptions {
+                cmd: Some(vec![
+                    "rm".to_string(),
+                    "-f".to_string(),
+                    format!("/tmp/{}", file_name),
+                ]),
+                ..Default::default()
+            },
+        )
+        .await?;
+    docker.start_exec(&cleanup.id, None).await?;
+
+    if exit_code != 0 {
+        return Err(anyhow::anyhow!(
+            "Script exited with status {}: {}",
+            exit_code,
+            output_tail(&output)
+        ));
+    }
+    Ok(output)
+}
+
+/// Run a command in a container, returning its exit code and its output with stdout
+/// and stderr interleaved
+pub(crate) async fn exec_in_container(
+    docker: &Docker,
+    container_name: &str,
+    cmd: Vec<String>,
+    env: Vec<String>,
+) -> anyhow::Result<(i64, String)> {
+    use futures::StreamExt;
+
+    let exec = docker
+        .create_exec(
+            container_name,
+            bollard::exec::CreateExecOptions {
+                cmd: Some(cmd),
+                env: Some(env),
+                attach_stdout: Some(true),
+                attach_stderr: Some(true),
+                ..Default::default()
+            },
+        )
+        .await
+        .map_err(|e| anyhow::anyhow!("Failed to create exec: {}", e))?;
+
+    let mut output = String::new();
+    if let bollard::exec::StartExecResults::Attached {
+        output: mut stream, ..
+    } = docker.start_exec(&exec.id, None).await?
+    {
+        while let Some(chunk) = stream.next().await {
+            match chunk? {
+                bollard::container::LogOutput::StdOut { message }
+                | bollard::container::LogOutput::StdErr { message } => {
+                    output.push_str(&String::from_utf8_lossy(&message));
+                }
+                _ => {}
+            }
+        }
+    }
+
+    let exit_code = docker.inspect_exec(&exec.id).await?.exit_code.unwrap_or(0);
+    Ok((exit_code, output))
+}
+
+/// Remove a file from a container, such as a dump copied in for a restore
+pub(crate) async fn remove_file_in_container(
+    docker: &Docker,
+    container_name: &str,
+    path: &str,
+) -> anyhow::Result<()> {
+    exec_in_container(
+        docker,
+        container_name,
+        vec!["rm".to_string(), "-f".to_string(), path.to_string()],
+        Vec::new(),
+    )
+    .await
+    .map(|_| ())
+}
+
+/// Last lines of a command's output, which hold the error of a failed script
+pub(crate) fn output_tail(output: &str) -> String {
+    let lines: Vec<&str> = output
+        .lines()
+        .filter(|line| !line.trim().is_empty())
+        .collect();
+    lines[lines.len().saturating_sub(10)..].join("\n")
+}
+
+/// Hex SHA-256 of a file, recorded with a backup so downloads can be verified
+pub(crate) fn file_sha256(path: &std::path::Path) -> std::io::Result<String> {
+    use sha2::{Digest, Sha256};
+
+    let mut hasher = Sha256::new();
+    std::io::copy(&mut std::fs::File::open(path)?, &mut hasher)?;
+    Ok(hex::encode(hasher.finalize()))
+}
@@ -0,0 +1,302 @@
+//! Per-service cap on in-flight proxied requests
+//!
+//! Services with a `concurrency_limit` get `max_in_flight` slots, and every regular
+//! request proxied to them holds one until it ends. A request that finds every slot
+//! taken waits in the service's queue for one to free up; when the queue is full, or
+//! no slot frees up within the queue timeout, it's turned away with 503 and
+//! `Retry-After` instead of piling onto an upstream that is already busy. A changed
+//! limit applies to the requests that arrive after the change.
+//!
+//! Admitted, queued and rejected requests are counted per environment in
+//! [`ConcurrencyLimitStats`].
+
+use once_cell::sync::Lazy;
+use parking_lot::Mutex;
+use serde::Serialize;
+use std::collections::HashMap;
+use std::fmt;
+use std::sync::atomic::{AtomicU64, AtomicUsize, Ordering};
+use std::sync::Arc;
+use temps_entities::deployment_config::ConcurrencyLimitConfig;
+use tokio::sync::{OwnedSemaphorePermit, Semaphore};
+use utoipa::ToSchema;
+
+/// Seconds clients are told to wait before retrying a rejected request
+pub const CONCURRENCY_RETRY_AFTER_SECONDS: u64 = 1;
+
+/// Limits of every service, shared by the proxy and the API
+static CONCURRENCY_LIMITER: Lazy<ConcurrencyLimiter> = Lazy::new(ConcurrencyLimiter::new);
+
+/// Slots and queue of one service, for one version of its limit
+struct EnvironmentLimit {
+    config: ConcurrencyLimitConfig,
+    slots: Arc<Semaphore>,
+    /// Requests waiting for a slot
+    waiting: AtomicUsize,
+}
+
+impl EnvironmentLimit {
+    fn new(config: &ConcurrencyLimitConfig) -> Self {
+        Self {
+            config: config.clone(),
+            slots: Arc::new(Semaphore::new(config.max_in_flight as usize)),
+            waiting: AtomicUsize::new(0),
+        }
+    }
+
+    fn in_flight(&self) -> usize {
+        (self.config.max_in_flight as usize).saturating_sub(self.slots.available_permits())
+    }
+}
+
+#[derive(Default)]
+struct EnvironmentCounters {
+    admitted: AtomicU64,
+    queued: AtomicU64,
+    rejected_queue_full: AtomicU64,
+    rejected_queue_timeout: AtomicU64,
+}
+
+struct EnvironmentEntry {
+    limit: Arc<EnvironmentLimit>,
+    counters: Arc<EnvironmentCounters>,
+}
+
+/// Counts a request as waiting until dropped, also when the client goes away
+struct WaitingGuard<'a>(&'a AtomicUsize);
+
+impl Drop for WaitingGuard<'_> {
+    fn drop(&mut self) {
+        self.0.fetch_sub(1, Ordering::AcqRel);
+    }
+}
+
+/// Why a request didn't get a slot
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ConcurrencyRejection {
+    /// Every slot was taken and the queue was full, or the service has no queue
+    QueueFull,
+    /// No slot freed up while the request waited in the queue
+    QueueTimeout,
+}
+
+impl fmt::Display for ConcurrencyRejection {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            ConcurrencyRejection::QueueFull => write!(f, "queue full"),
+            ConcurrencyRejection::QueueTimeout => write!(f, "queue timeout"),
+        }
+    }
+}
+
+/// A slot held by a request; it's freed when this is dropped
+#[derive(Debug)]
+pub struct ConcurrencyPermit {
+    _permit: OwnedSemaphorePermit,
+}
+
+/// Slots of the services that have a concurrency limit, by environment ID
+#[derive(Default)]
+pub struct ConcurrencyLimiter {
+    environments: Mutex<HashMap<i32, EnvironmentEntry>>,
+}
+
+impl ConcurrencyLimiter {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// The limiter the proxy uses
+    pub fn global() -> &'static ConcurrencyLimiter {
+        &CONCURRENCY_LIMITER
+    }
+
+    /// Current limit of an environment, replaced when its config changed
+    fn entry(
+        &self,
+        environment_id: i32,
+        config: &ConcurrencyLimitConfig,
+    ) -> (Arc<EnvironmentLimit>, Arc<EnvironmentCounters>) {
+        let mut environments = self.environments.lock();
+        let entry = environments
+            .entry(environment_id)
+            .or_insert_with(|| EnvironmentEntry {
+                limit: Arc::new(EnvironmentLimit::new(config)),
+                counters: Arc::default(),
+            });
+        if entry.limit.config != *config {
+            // Requests holding slots of the old limit free them as they end
+            entry.limit = Arc::new(EnvironmentLimit::new(config));
+        }
+        (entry.limit.clone(), entry.counters.clone())
+    }
+
+    /// Take a slot of the environment, waiting in its queue when they are all taken
+    pub async fn acquire(
+        &self,
+        environment_id: i32,
+        config: &ConcurrencyLimitConfig,
+    ) -> Result<ConcurrencyPermit, ConcurrencyRejection> {
+        let (limit, counters) = self.entry(environment_id, config);
+        if let Ok(permit) = limit.slots.clone().try_acquire_owned() {
+            counters.admitted.fetch_add(1, Ordering::Relaxed);
+            return Ok(ConcurrencyPermit { _permit: permit });
+        }
+
+        if limit.waiting.fetch_add(1, Ordering::AcqRel) >= config.queue_size() as usize {
+            limit.waiting.fetch_sub(1, Ordering::AcqRel);
+            counters.rejected_queue_full.fetch_add(1, Ordering::Relaxed);
+            return Err(ConcurrencyRejection::QueueFull);
+        }
+        let _waiting = WaitingGuard(&limit.waiting);
+        counters.queued.fetch_add(1, Ordering::Relaxed);
+
+        match tokio::time::timeout(config.queue_timeout(), limit.slots.clone().acquire_owned())
+            .await
+        {
+            Ok(Ok(permit)) => {
+                counters.admitted.fetch_add(1, Ordering::Relaxed);
+                Ok(ConcurrencyPermit { _permit: permit })
+            }
+            // The semaphore is never closed, so only the timeout ends the wait early
+            Ok(Err(_)) | Err(_) => {
+                counters
+                    .rejected_queue_timeout
+                    .fetch_add(1, Ordering::Relaxed);
+                Err(ConcurrencyRejection::QueueTimeout)
+            }
+        }
+    }
+
+    pub fn stats(&self) -> ConcurrencyLimitStats {
+        let environments = self.environments.lock();
+        let mut stats: Vec<EnvironmentConcurrencyStats> = environments
+            .iter()
+            .map(|(environment_id, entry)| EnvironmentConcurrencyStats {
+                environment_id: *environment_id,
+                max_in_flight: entry.limit.config.max_in_flight,
+                queue_size: entry.limit.config.queue_size(),
+                in_flight: entry.limit.in_flight() as u64,
+                waiting: entry.limit.waiting.load(Ordering::Relaxed) as u64,
+                admitted: entry.counters.admitted.load(Ordering::Relaxed),
+                queued: entry.counters.queued.load(Ordering::Relaxed),
+                rejected_queue_full: entry.counters.rejected_queue_full.load(Ordering::Relaxed),
+                rejected_queue_timeout: entry
+                    .counters
+                    .rejected_queue_timeout
+                    .load(Ordering::Relaxed),
+            })
+            .collect();
+        stats.sort_by_key(|stats| stats.environment_id);
+        ConcurrencyLimitStats {
+            environments: stats,
+        }
+    }
+}
+
+/// Concurrency limit of one environment and its requests since the server started
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct EnvironmentConcurrencyStats {
+    pub environment_id: i32,
+    pub max_in_flight: u32,
+    pub queue_size: u32,
+    /// Requests holding a slot
+    pub in_flight: u64,
+    /// Requests waiting for a slot
+    pub waiting: u64,
+    /// Requests that got a slot, right away or after waiting
+    pub admitted: u64,
+    /// Requests that had to wait for a slot
+    pub queued: u64,
+    /// Requests turned away because the queue was full
+    pub rejected_queue_full: u64,
+    /// Requests turned away because no slot freed up within the queue timeout
+    pub rejected_queue_timeout: u64,
+}
+
+/// State of the services' concurrency limits
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ConcurrencyLimitStats {
+    /// Environments with a concurrency limit, by ID
+    pub environments: Vec<EnvironmentConcurrencyStats>,
+}
+
+impl ConcurrencyLimitStats {
+    pub fn current() -> Self {
+        ConcurrencyLimiter::global().stats()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    fn limit(max_in_flight: u32, queue_size: u32, queue_timeout_ms: u32) -> ConcurrencyLimitConfig {
+        ConcurrencyLimitConfig {
+            max_in_flight,
+            queue_size: Some(queue_size),
+            queue_timeout_ms: Some(queue_timeout_ms),
+        }
+    }
+
+    #[tokio::test]
+    async fn test_requests_over_the_limit_queue_then_get_rejected() {
+        let limiter = Arc::new(ConcurrencyLimiter::new());
+        let config = limit(1, 1, 5_000);
+        let first = limiter.acquire(1, &config).await.unwrap();
+
+        // The second request waits for the first one's slot
+        let queued = {
+            let limiter = limiter.clone();
+            let config = config.clone();
+            tokio::spawn(async move { limiter.acquire(1, &config).await })
+        };
+        tokio::time::sleep(Duration::from_millis(20)).await;
+        assert_eq!(limiter.stats().environments[0].waiting, 1);
+
+        // The third finds the queue full
+        assert_eq!(
+            limiter.acquire(1, &config).await.unwrap_err(),
+            ConcurrencyRejection::QueueFull
+        );
+        // Other services have their own slots
+        let other = limiter.acquire(2, &config).await.unwrap();
+
+        drop(first);
+        let second = tokio::time::timeout(Duration::from_secs(1), queued)
+            .await
+            .expect("the freed slot should go to the queued request")
+            .unwrap()
+            .unwrap();
+
+        let stats = limiter.stats();
+        let environment = &stats.environments[0];
+        assert_eq!(environment.environment_id, 1);
+        assert_eq!(environment.in_flight, 1);
+        assert_eq!(environment.waiting, 0);
+        assert_eq!(environment.admitted, 2);
+        assert_eq!(environment.queued, 1);
+        assert_eq!(environment.rejected_queue_full, 1);
+        drop((second, other));
+    }
+
+    #[tokio::test]
+    async fn test_queued_requests_give_up_at_the_queue_timeout() {
+        let limiter = ConcurrencyLimiter::new();
+        let config = limit(1, 5, 10);
+        let _held = limiter.acquire(1, &config).await.unwrap();
+
+        assert_eq!(
+            limiter.acquire(1, &config).await.unwrap_err(),
+            ConcurrencyRejection::QueueTimeout
+        );
+        let stats = limiter.stats();
+        assert_eq!(stats.environments[0].rejected_queue_timeout, 1);
+        assert_eq!(stats.environments[0].waiting, 0);
+
+        // A raised limit applies right away
+        let raised = limit(2, 5, 10);
+        assert!(limiter.acquire(1, &raised).await.is_ok());
+    }
+}
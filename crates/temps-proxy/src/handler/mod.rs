@@ -3,4 +3,5 @@ pub mod captcha;
 pub mod handler;
 pub mod ip_access_control;
 pub mod proxy_logs;
+pub mod saved_log_searches;
 pub mod types;
@@ -1,18 +1,57 @@
 use axum::{
-    extract::{Path, Query, State},
+    extract::{Extension, Path, Query, State},
     http::StatusCode,
     response::IntoResponse,
     Json,
 };
 use serde::{Deserialize, Serialize};
 use std::sync::Arc;
-use temps_core::{DateTime, UtcDateTime};
+use temps_auth::{require_log_scope, AuthContext, RequireAuth};
+use temps_core::pagination::{CursorPage, ListParams};
+use temps_core::{AuditLogger, DateTime, RequestMetadata, UtcDateTime};
 use utoipa::{IntoParams, ToSchema};
 
+use crate::concurrency_limit::{ConcurrencyLimitStats, EnvironmentConcurrencyStats};
+use crate::service::proxy_log_ingestion::ProxyLogIngestionStats;
 use crate::service::proxy_log_service::{
-    ProxyLogResponse, ProxyLogService, StatsFilters, TimeBucketStats, TodayStatsResponse,
+    ProxyLogResponse, ProxyLogService, ProxyLogServiceError, StatsFilters, TimeBucketStats,
+    TodayStatsResponse, PROXY_LOG_LIST,
 };
 
+pub struct ProxyLogsAppState {
+    pub proxy_log_service: Arc<ProxyLogService>,
+    pub audit_service: Arc<dyn AuditLogger>,
+}
+
+/// Reject reading proxy logs outside of the caller's log scope
+///
+/// Callers with a log scope have to filter by a project, and by an environment when
+/// only some of the project's are in their scope.
+async fn check_log_scope(
+    state: &ProxyLogsAppState,
+    auth: &AuthContext,
+    metadata: Option<&RequestMetadata>,
+    project_id: Option<i32>,
+    environment_id: Option<i32>,
+) -> Result<(), (StatusCode, String)> {
+    require_log_scope(
+        auth,
+        metadata,
+        state.audit_service.as_ref(),
+        "proxy_logs",
+        project_id,
+        environment_id,
+    )
+    .await
+    .map_err(|_| {
+        let detail = match project_id {
+            Some(_) => "These logs are outside of your log scope",
+            None => "Your logs access is scoped to projects; filter the logs by project_id",
+        };
+        (StatusCode::FORBIDDEN, detail.to_string())
+    })
+}
+
 /// Query parameters for listing proxy logs
 #[derive(Debug, Deserialize, IntoParams)]
 pub struct ProxyLogsQuery {
@@ -123,20 +162,58 @@ pub struct ProxyLogsPaginatedResponse {
 }
 
 /// Get proxy logs with optional filters and pagination
+///
+/// Passing `cursor` (empty for the first page) pages by cursor and answers with a
+/// `CursorPage<ProxyLogResponse>`, without counting a total; `page`, `page_size`,
+/// `sort_by` and `sort_order` are ignored then. Logs sort by `timestamp`,
+/// `response_time_ms`, `status_code` or `id`, and the filter parameters above still
+/// apply alongside `filter`.
 #[utoipa::path(
     get,
     path = "/proxy-logs",
-    params(ProxyLogsQuery),
+    params(ProxyLogsQuery, ListParams),
     responses(
         (status = 200, description = "List of proxy logs", body = ProxyLogsPaginatedResponse),
+        (status = 400, description = "Invalid cursor, sort or filter"),
+        (status = 403, description = "Logs outside of the caller's log scope"),
         (status = 500, description = "Internal server error")
     ),
     tag = "Proxy Logs"
 )]
 pub async fn get_proxy_logs(
-    State(service): State<Arc<ProxyLogService>>,
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<ProxyLogsAppState>>,
     Query(query): Query<ProxyLogsQuery>,
+    Query(list): Query<ListParams>,
+    metadata: Option<Extension<RequestMetadata>>,
 ) -> Result<impl IntoResponse, (StatusCode, String)> {
+    check_log_scope(
+        &state,
+        &auth,
+        metadata.as_deref(),
+        query.project_id,
+        query.environment_id,
+    )
+    .await?;
+    let service = &state.proxy_log_service;
+
+    if list.uses_cursor() {
+        let request = list
+            .resolve(&PROXY_LOG_LIST)
+            .map_err(|e| (StatusCode::BAD_REQUEST, e.to_string()))?;
+        let start_date = query.start_date.map(|d| d.into());
+        let end_date = query.end_date.map(|d| d.into());
+        let page: CursorPage<ProxyLogResponse> = service
+            .list_page(start_date, end_date, query, &request)
+            .await
+            .map_err(|e| match e {
+                ProxyLogServiceError::InvalidFilter(_) => (StatusCode::BAD_REQUEST, e.to_string()),
+                _ => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()),
+            })?
+            .map(ProxyLogResponse::from);
+        return Ok(Json(page).into_response());
+    }
+
     let page = query.page.unwrap_or(1);
     let page_size = std::cmp::min(query.page_size.unwrap_or(20), 100);
     let start_date = query.start_date.map(|d| d.into());
@@ -156,7 +233,7 @@ pub async fn get_proxy_logs(
         total_pages,
     };
 
-    Ok(Json(response))
+    Ok(Json(response).into_response())
 }
 
 /// Get a single proxy log by ID
@@ -168,22 +245,36 @@ pub async fn get_proxy_logs(
     ),
     responses(
         (status = 200, description = "Proxy log found", body = ProxyLogResponse),
+        (status = 403, description = "Proxy log outside of the caller's log scope"),
         (status = 404, description = "Proxy log not found"),
         (status = 500, description = "Internal server error")
     ),
     tag = "Proxy Logs"
 )]
 pub async fn get_proxy_log_by_id(
-    State(service): State<Arc<ProxyLogService>>,
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<ProxyLogsAppState>>,
     Path(id): Path<i32>,
+    metadata: Option<Extension<RequestMetadata>>,
 ) -> Result<impl IntoResponse, (StatusCode, String)> {
-    let log = service
+    let log = state
+        .proxy_log_service
         .get_by_id(id)
         .await
         .map_err(|e| (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()))?;
 
     match log {
-        Some(log) => Ok(Json(ProxyLogResponse::from(log))),
+        Some(log) => {
+            check_log_scope(
+                &state,
+                &auth,
+                metadata.as_deref(),
+                log.project_id,
+                log.environment_id,
+            )
+            .await?;
+            Ok(Json(ProxyLogResponse::from(log)))
+        }
         None => Err((StatusCode::NOT_FOUND, "Proxy log not found".to_string())),
     }
 }
@@ -197,22 +288,36 @@ pub async fn get_proxy_log_by_id(
     ),
     responses(
         (status = 200, description = "Proxy log found", body = ProxyLogResponse),
+        (status = 403, description = "Proxy log outside of the caller's log scope"),
         (status = 404, description = "Proxy log not found"),
         (status = 500, description = "Internal server error")
     ),
     tag = "Proxy Logs"
 )]
 pub async fn get_proxy_log_by_request_id(
-    State(service): State<Arc<ProxyLogService>>,
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<ProxyLogsAppState>>,
     Path(request_id): Path<String>,
+    metadata: Option<Extension<RequestMetadata>>,
 ) -> Result<impl IntoResponse, (StatusCode, String)> {
-    let log = service
+    let log = state
+        .proxy_log_service
         .get_by_request_id(&request_id)
         .await
         .map_err(|e| (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()))?;
 
     match log {
-        Some(log) => Ok(Json(ProxyLogResponse::from(log))),
+        Some(log) => {
+            check_log_scope(
+                &state,
+                &auth,
+                metadata.as_deref(),
+                log.project_id,
+                log.environment_id,
+            )
+            .await?;
+            Ok(Json(ProxyLogResponse::from(log)))
+        }
         None => Err((StatusCode::NOT_FOUND, "Proxy log not found".to_string())),
     }
 }
@@ -319,14 +424,26 @@ pub struct TimeBucketStatsResponse {
     params(StatsQuery),
     responses(
         (status = 200, description = "Today's request count", body = TodayStatsResponse),
+        (status = 403, description = "Logs outside of the caller's log scope"),
         (status = 500, description = "Internal server error")
     ),
     tag = "Proxy Logs"
 )]
 async fn get_today_stats(
-    State(service): State<Arc<ProxyLogService>>,
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<ProxyLogsAppState>>,
     Query(query): Query<StatsQuery>,
+    metadata: Option<Extension<RequestMetadata>>,
 ) -> Result<impl IntoResponse, (StatusCode, String)> {
+    check_log_scope(
+        &state,
+        &auth,
+        metadata.as_deref(),
+        query.project_id,
+        query.environment_id,
+    )
+    .await?;
+
     let filters = if query.method.is_some()
         || query.client_ip.is_some()
         || query.project_id.is_some()
@@ -344,7 +461,8 @@ async fn get_today_stats(
         None
     };
 
-    let count = service
+    let count = state
+        .proxy_log_service
         .get_today_count(filters)
         .await
         .map_err(|e| (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()))?;
@@ -365,14 +483,26 @@ async fn get_today_stats(
     responses(
         (status = 200, description = "Time-bucketed statistics", body = TimeBucketStatsResponse),
         (status = 400, description = "Invalid parameters"),
+        (status = 403, description = "Logs outside of the caller's log scope"),
         (status = 500, description = "Internal server error")
     ),
     tag = "Proxy Logs"
 )]
 async fn get_time_bucket_stats(
-    State(service): State<Arc<ProxyLogService>>,
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<ProxyLogsAppState>>,
     Query(query): Query<TimeBucketStatsQuery>,
+    metadata: Option<Extension<RequestMetadata>>,
 ) -> Result<impl IntoResponse, (StatusCode, String)> {
+    check_log_scope(
+        &state,
+        &auth,
+        metadata.as_deref(),
+        query.project_id,
+        query.environment_id,
+    )
+    .await?;
+
     let filters = if query.method.is_some()
         || query.client_ip.is_some()
         || query.project_id.is_some()
@@ -402,7 +532,8 @@ async fn get_time_bucket_stats(
         None
     };
 
-    let stats = service
+    let stats = state
+        .proxy_log_service
         .get_time_bucket_stats(
             query.start_time,
             query.end_time,
@@ -420,8 +551,40 @@ async fn get_time_bucket_stats(
     }))
 }
 
+/// Get the state of proxy log ingestion
+///
+/// `lag_ms` is how long the last written batch of logs waited in the queue; a
+/// growing queue or dropped logs mean the database isn't keeping up with traffic.
+#[utoipa::path(
+    get,
+    path = "/proxy-logs/stats/ingestion",
+    responses(
+        (status = 200, description = "Ingestion statistics", body = ProxyLogIngestionStats)
+    ),
+    tag = "Proxy Logs"
+)]
+async fn get_ingestion_stats() -> impl IntoResponse {
+    Json(ProxyLogIngestionStats::current())
+}
+
+/// Get the state of the services' concurrency limits
+///
+/// Requests queued and turned away with 503 per environment with a
+/// `concurrencyLimit`, since the server started.
+#[utoipa::path(
+    get,
+    path = "/proxy-logs/stats/concurrency",
+    responses(
+        (status = 200, description = "Concurrency limit statistics", body = ConcurrencyLimitStats)
+    ),
+    tag = "Proxy Logs"
+)]
+async fn get_concurrency_stats() -> impl IntoResponse {
+    Json(ConcurrencyLimitStats::current())
+}
+
 /// Create router for proxy log handlers
-pub fn create_routes() -> axum::Router<Arc<ProxyLogService>> {
+pub fn create_routes() -> axum::Router<Arc<ProxyLogsAppState>> {
     use axum::routing::get;
 
     axum::Router::new()
@@ -433,6 +596,8 @@ pub fn create_routes() -> axum::Router<Arc<ProxyLogService>> {
         )
         .route("/proxy-logs/stats/today", get(get_today_stats))
         .route("/proxy-logs/stats/time-buckets", get(get_time_bucket_stats))
+        .route("/proxy-logs/stats/ingestion", get(get_ingestion_stats))
+        .route("/proxy-logs/stats/concurrency", get(get_concurrency_stats))
 }
 
 /// Get OpenAPI documentation for proxy logs handlers
@@ -447,14 +612,20 @@ pub fn openapi() -> utoipa::openapi::OpenApi {
             get_proxy_log_by_request_id,
             get_today_stats,
             get_time_bucket_stats,
+            get_ingestion_stats,
+            get_concurrency_stats,
         ),
         components(schemas(
             ProxyLogResponse,
             ProxyLogsPaginatedResponse,
+            CursorPage<ProxyLogResponse>,
             TodayStatsResponse,
             TimeBucketStatsResponse,
             TimeBucketStats,
             StatsFilters,
+            ProxyLogIngestionStats,
+            ConcurrencyLimitStats,
+            EnvironmentConcurrencyStats,
         ))
     )]
     struct ApiDoc;
@@ -0,0 +1,472 @@
+//! Saved log search handlers
+
+use axum::{
+    extract::{Extension, Path, Query, State},
+    http::StatusCode,
+    response::IntoResponse,
+    routing::{get, post, put},
+    Json, Router,
+};
+use serde::Deserialize;
+use std::sync::Arc;
+use temps_auth::{permission_guard, require_log_scope, RequireAuth};
+use temps_core::{
+    error_builder::{bad_request, internal_server_error, not_found},
+    problemdetails::Problem,
+    AuditLogger, RequestMetadata,
+};
+use tracing::error;
+use utoipa::{IntoParams, OpenApi};
+
+use crate::service::saved_log_search_service::{
+    LogSearchAlertRequest, LogSearchAlertResponse, LogSearchResultsResponse,
+    RecentLogSearchResponse, RunLogSearchRequest, SavedLogSearchError, SavedLogSearchRequest,
+    SavedLogSearchResponse, SavedLogSearchService,
+};
+
+pub struct SavedLogSearchAppState {
+    pub saved_log_search_service: Arc<SavedLogSearchService>,
+    pub audit_service: Arc<dyn AuditLogger>,
+}
+
+/// Environment a search's filters limit it to
+fn filtered_environment(filters: &serde_json::Value) -> Option<i32> {
+    filters
+        .get("environment_id")
+        .and_then(|id| id.as_i64())
+        .and_then(|id| i32::try_from(id).ok())
+}
+
+impl From<SavedLogSearchError> for Problem {
+    fn from(error: SavedLogSearchError) -> Self {
+        match error {
+            SavedLogSearchError::NotFound(msg) => not_found().detail(msg).build(),
+            SavedLogSearchError::InvalidInput(msg) => bad_request().detail(msg).build(),
+            SavedLogSearchError::DatabaseError(e) => {
+                error!("Saved log search database error: {}", e);
+                internal_server_error().detail(e.to_string()).build()
+            }
+            SavedLogSearchError::SearchError(e) => {
+                error!("Log search failed: {}", e);
+                internal_server_error().detail(e.to_string()).build()
+            }
+        }
+    }
+}
+
+/// Paging of a saved search run
+#[derive(Debug, Deserialize, IntoParams)]
+pub struct RunSavedLogSearchQuery {
+    /// Page number (default: 1)
+    pub page: Option<u64>,
+    /// Page size (default: 20, max: 100)
+    pub page_size: Option<u64>,
+}
+
+/// List the project's saved log searches
+#[utoipa::path(
+    tag = "Log Searches",
+    get,
+    path = "/projects/{project_id}/log-searches",
+    params(("project_id" = i32, Path, description = "Project ID")),
+    responses(
+        (status = 200, description = "Saved searches", body = Vec<SavedLogSearchResponse>),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn list_saved_log_searches(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, LogsRead);
+
+    Ok(Json(state.saved_log_search_service.list(project_id).await?))
+}
+
+/// Save a log search for the project's team
+#[utoipa::path(
+    tag = "Log Searches",
+    post,
+    path = "/projects/{project_id}/log-searches",
+    params(("project_id" = i32, Path, description = "Project ID")),
+    request_body = SavedLogSearchRequest,
+    responses(
+        (status = 201, description = "Search saved", body = SavedLogSearchResponse),
+        (status = 400, description = "Invalid filters or time range"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Project not found")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn create_saved_log_search(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path(project_id): Path<i32>,
+    Json(request): Json<SavedLogSearchRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    let search = state
+        .saved_log_search_service
+        .create(project_id, request, auth.user_id())
+        .await?;
+    Ok((StatusCode::CREATED, Json(search)))
+}
+
+/// Get a saved log search
+#[utoipa::path(
+    tag = "Log Searches",
+    get,
+    path = "/projects/{project_id}/log-searches/{search_id}",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("search_id" = i32, Path, description = "Saved search ID")
+    ),
+    responses(
+        (status = 200, description = "Saved search", body = SavedLogSearchResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Saved search not found")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn get_saved_log_search(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path((project_id, search_id)): Path<(i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, LogsRead);
+
+    Ok(Json(
+        state
+            .saved_log_search_service
+            .get(project_id, search_id)
+            .await?,
+    ))
+}
+
+/// Replace a saved log search's name, filters and time range
+#[utoipa::path(
+    tag = "Log Searches",
+    put,
+    path = "/projects/{project_id}/log-searches/{search_id}",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("search_id" = i32, Path, description = "Saved search ID")
+    ),
+    request_body = SavedLogSearchRequest,
+    responses(
+        (status = 200, description = "Search updated", body = SavedLogSearchResponse),
+        (status = 400, description = "Invalid filters or time range"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Saved search not found")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn update_saved_log_search(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path((project_id, search_id)): Path<(i32, i32)>,
+    Json(request): Json<SavedLogSearchRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    Ok(Json(
+        state
+            .saved_log_search_service
+            .update(project_id, search_id, request)
+            .await?,
+    ))
+}
+
+/// Delete a saved log search and its alert
+#[utoipa::path(
+    tag = "Log Searches",
+    delete,
+    path = "/projects/{project_id}/log-searches/{search_id}",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("search_id" = i32, Path, description = "Saved search ID")
+    ),
+    responses(
+        (status = 204, description = "Search deleted"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Saved search not found")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn delete_saved_log_search(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path((project_id, search_id)): Path<(i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    state
+        .saved_log_search_service
+        .delete(project_id, search_id)
+        .await?;
+    Ok(StatusCode::NO_CONTENT)
+}
+
+/// Run a saved log search over its time range back from now
+#[utoipa::path(
+    tag = "Log Searches",
+    post,
+    path = "/projects/{project_id}/log-searches/{search_id}/run",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("search_id" = i32, Path, description = "Saved search ID"),
+        RunSavedLogSearchQuery
+    ),
+    responses(
+        (status = 200, description = "Matching logs", body = LogSearchResultsResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Saved search not found")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn run_saved_log_search(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path((project_id, search_id)): Path<(i32, i32)>,
+    Query(query): Query<RunSavedLogSearchQuery>,
+    metadata: Option<Extension<RequestMetadata>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, LogsRead);
+    if auth.log_scope.is_some() {
+        let search = state
+            .saved_log_search_service
+            .get(project_id, search_id)
+            .await?;
+        require_log_scope(
+            &auth,
+            metadata.as_deref(),
+            state.audit_service.as_ref(),
+            "proxy_logs",
+            Some(project_id),
+            filtered_environment(&search.filters),
+        )
+        .await?;
+    }
+
+    let results = state
+        .saved_log_search_service
+        .run_saved(
+            project_id,
+            search_id,
+            auth.user_id(),
+            query.page,
+            query.page_size,
+        )
+        .await?;
+    Ok(Json(results))
+}
+
+/// Alert when a saved log search matches too many requests
+#[utoipa::path(
+    tag = "Log Searches",
+    put,
+    path = "/projects/{project_id}/log-searches/{search_id}/alert",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("search_id" = i32, Path, description = "Saved search ID")
+    ),
+    request_body = LogSearchAlertRequest,
+    responses(
+        (status = 200, description = "Alert saved", body = SavedLogSearchResponse),
+        (status = 400, description = "Invalid threshold or severity"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Saved search not found")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn set_log_search_alert(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path((project_id, search_id)): Path<(i32, i32)>,
+    Json(request): Json<LogSearchAlertRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    Ok(Json(
+        state
+            .saved_log_search_service
+            .set_alert(project_id, search_id, request)
+            .await?,
+    ))
+}
+
+/// Stop alerting on a saved log search
+#[utoipa::path(
+    tag = "Log Searches",
+    delete,
+    path = "/projects/{project_id}/log-searches/{search_id}/alert",
+    params(
+        ("project_id" = i32, Path, description = "Project ID"),
+        ("search_id" = i32, Path, description = "Saved search ID")
+    ),
+    responses(
+        (status = 200, description = "Alert removed", body = SavedLogSearchResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Saved search not found")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn remove_log_search_alert(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path((project_id, search_id)): Path<(i32, i32)>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, ProjectsWrite);
+
+    Ok(Json(
+        state
+            .saved_log_search_service
+            .remove_alert(project_id, search_id)
+            .await?,
+    ))
+}
+
+/// Run a log search without saving it
+#[utoipa::path(
+    tag = "Log Searches",
+    post,
+    path = "/projects/{project_id}/log-searches/run",
+    params(("project_id" = i32, Path, description = "Project ID")),
+    request_body = RunLogSearchRequest,
+    responses(
+        (status = 200, description = "Matching logs", body = LogSearchResultsResponse),
+        (status = 400, description = "Invalid filters or time range"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn run_log_search(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path(project_id): Path<i32>,
+    metadata: Option<Extension<RequestMetadata>>,
+    Json(request): Json<RunLogSearchRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, LogsRead);
+    require_log_scope(
+        &auth,
+        metadata.as_deref(),
+        state.audit_service.as_ref(),
+        "proxy_logs",
+        Some(project_id),
+        filtered_environment(&request.filters),
+    )
+    .await?;
+
+    Ok(Json(
+        state
+            .saved_log_search_service
+            .run(project_id, auth.user_id(), request)
+            .await?,
+    ))
+}
+
+/// The current user's recent log searches on the project
+#[utoipa::path(
+    tag = "Log Searches",
+    get,
+    path = "/projects/{project_id}/log-searches/recent",
+    params(("project_id" = i32, Path, description = "Project ID")),
+    responses(
+        (status = 200, description = "Recent searches, most recent first", body = Vec<RecentLogSearchResponse>),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions")
+    ),
+    security(("bearer_auth" = []))
+)]
+pub async fn list_recent_log_searches(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<SavedLogSearchAppState>>,
+    Path(project_id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, LogsRead);
+
+    Ok(Json(
+        state
+            .saved_log_search_service
+            .recent(project_id, auth.user_id())
+            .await?,
+    ))
+}
+
+/// Create routes for saved log search handlers
+pub fn create_routes() -> Router<Arc<SavedLogSearchAppState>> {
+    Router::new()
+        .route(
+            "/projects/{project_id}/log-searches",
+            get(list_saved_log_searches).post(create_saved_log_search),
+        )
+        .route(
+            "/projects/{project_id}/log-searches/run",
+            post(run_log_search),
+        )
+        .route(
+            "/projects/{project_id}/log-searches/recent",
+            get(list_recent_log_searches),
+        )
+        .route(
+            "/projects/{project_id}/log-searches/{search_id}",
+            get(get_saved_log_search)
+                .put(update_saved_log_search)
+                .delete(delete_saved_log_search),
+        )
+        .route(
+            "/projects/{project_id}/log-searches/{search_id}/run",
+            post(run_saved_log_search),
+        )
+        .route(
+            "/projects/{project_id}/log-searches/{search_id}/alert",
+            put(set_log_search_alert).delete(remove_log_search_alert),
+        )
+}
+
+/// Get OpenAPI documentation for saved log search handlers
+pub fn openapi() -> utoipa::openapi::OpenApi {
+    #[derive(OpenApi)]
+    #[openapi(
+        paths(
+            list_saved_log_searches,
+            create_saved_log_search,
+            get_saved_log_search,
+            update_saved_log_search,
+            delete_saved_log_search,
+            run_saved_log_search,
+            set_log_search_alert,
+            remove_log_search_alert,
+            run_log_search,
+            list_recent_log_searches,
+        ),
+        components(schemas(
+            SavedLogSearchRequest,
+            SavedLogSearchResponse,
+            LogSearchAlertRequest,
+            LogSearchAlertResponse,
+            RunLogSearchRequest,
+            LogSearchResultsResponse,
+            RecentLogSearchResponse,
+        )),
+        tags(
+            (name = "Log Searches", description = "Saved proxy log searches, recent searches and search alerts")
+        )
+    )]
+    struct SavedLogSearchApiDoc;
+
+    SavedLogSearchApiDoc::openapi()
+}
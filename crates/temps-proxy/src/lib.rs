@@ -8,6 +8,7 @@
 //! - Static file serving
 //! - Request/response filtering
 
+pub mod concurrency_limit;
 pub mod config;
 pub mod crawler_detector;
 pub mod handler;
@@ -19,6 +20,7 @@ pub mod services;
 pub mod tls_cert_loader;
 pub mod tls_fingerprint;
 pub mod traits;
+pub mod trusted_proxy;
 pub use crawler_detector::CrawlerDetector;
 pub use handler::*;
 pub use temps_routes::{CachedPeerTable, RouteInfo, RouteTableListener};
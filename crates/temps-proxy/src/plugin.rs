@@ -13,6 +13,7 @@ use crate::{
     service::{
         challenge_service::ChallengeService, ip_access_control_service::IpAccessControlService,
         lb_service::LbService, proxy_log_service::ProxyLogService,
+        saved_log_search_service::SavedLogSearchService,
     },
 };
 
@@ -43,7 +44,11 @@ impl TempsPlugin for ProxyPlugin {
                 })?;
 
             // Create LB service
-            let lb_service = Arc::new(LbService::new(db.clone()));
+            let mut lb_service = LbService::new(db.clone());
+            if let Some(hostname_locks) = context.get_service::<temps_core::HostnameLocks>() {
+                lb_service = lb_service.with_hostname_locks(hostname_locks);
+            }
+            let lb_service = Arc::new(lb_service);
 
             // Create Proxy Log service with IP service for enrichment
             let proxy_log_service = Arc::new(ProxyLogService::new(db.clone(), ip_service));
@@ -54,11 +59,27 @@ impl TempsPlugin for ProxyPlugin {
             // Create Challenge service for CAPTCHA
             let challenge_service = Arc::new(ChallengeService::new(db.clone()));
 
+            // Create Saved Log Search service, alerting through notifications when available
+            let mut saved_log_search_service =
+                SavedLogSearchService::new(db.clone(), proxy_log_service.clone());
+            if let Some(notification_service) =
+                context.get_service::<dyn temps_core::notifications::NotificationService>()
+            {
+                saved_log_search_service =
+                    saved_log_search_service.with_notification_service(notification_service);
+            }
+            let saved_log_search_service = Arc::new(saved_log_search_service);
+            let scheduler_service = saved_log_search_service.clone();
+            tokio::spawn(async move {
+                scheduler_service.start_scheduler().await;
+            });
+
             // Register the services for other plugins to use
             context.register_service(lb_service);
             context.register_service(proxy_log_service);
             context.register_service(ip_access_control_service);
             context.register_service(challenge_service);
+            context.register_service(saved_log_search_service);
 
             tracing::debug!("Proxy plugin services registered successfully");
             Ok(())
@@ -71,7 +92,9 @@ impl TempsPlugin for ProxyPlugin {
         let proxy_log_service = context.get_service::<ProxyLogService>()?;
         let ip_access_control_service = context.get_service::<IpAccessControlService>()?;
         let challenge_service = context.get_service::<ChallengeService>()?;
+        let saved_log_search_service = context.get_service::<SavedLogSearchService>()?;
         let db = context.get_service::<DbConnection>()?;
+        let audit_service = context.get_service::<dyn temps_core::AuditLogger>()?;
 
         // Create the app state directly
         let app_state = Arc::new(crate::handler::types::AppState { lb_service });
@@ -85,25 +108,45 @@ impl TempsPlugin for ProxyPlugin {
         // Configure routes with the app state
         let router = crate::handler::handler::configure_routes()
             .with_state(app_state)
-            .merge(crate::handler::proxy_logs::create_routes().with_state(proxy_log_service))
+            .merge(
+                crate::handler::proxy_logs::create_routes().with_state(Arc::new(
+                    crate::handler::proxy_logs::ProxyLogsAppState {
+                        proxy_log_service,
+                        audit_service: audit_service.clone(),
+                    },
+                )),
+            )
             .merge(
                 crate::handler::ip_access_control::create_routes()
                     .with_state(ip_access_control_service),
             )
+            .merge(
+                crate::handler::saved_log_searches::create_routes().with_state(Arc::new(
+                    crate::handler::saved_log_searches::SavedLogSearchAppState {
+                        saved_log_search_service,
+                        audit_service,
+                    },
+                )),
+            )
             .merge(crate::handler::captcha::create_routes().with_state(captcha_state));
 
         Some(PluginRoutes::new(router))
     }
 
     fn openapi_schema(&self) -> Option<OpenApi> {
-        // Merge the OpenAPI specs from LB, Proxy Logs, and IP Access Control APIs
+        // Merge the OpenAPI specs from LB, Proxy Logs, Saved Log Searches and IP Access Control APIs
         let lb_spec = LbApiDoc::openapi();
         let proxy_logs_spec = crate::handler::proxy_logs::openapi();
         let ip_access_control_spec = crate::handler::ip_access_control::openapi();
+        let saved_log_searches_spec = crate::handler::saved_log_searches::openapi();
 
         let merged = temps_core::openapi::merge_openapi_schemas(
             lb_spec,
-            vec![proxy_logs_spec, ip_access_control_spec],
+            vec![
+                proxy_logs_spec,
+                ip_access_control_spec,
+                saved_log_searches_spec,
+            ],
         );
 
         Some(merged)
@@ -203,12 +246,33 @@ mod tests {
         // Create Challenge service
         let challenge_service = Arc::new(ChallengeService::new(db_connection.clone()));
 
+        // Create Saved Log Search service
+        let saved_log_search_service = Arc::new(SavedLogSearchService::new(
+            db_connection.clone(),
+            proxy_log_service.clone(),
+        ));
+
+        struct MockAuditLogger;
+
+        #[async_trait::async_trait]
+        impl temps_core::AuditLogger for MockAuditLogger {
+            async fn create_audit_log(
+                &self,
+                _operation: &dyn temps_core::AuditOperation,
+            ) -> anyhow::Result<()> {
+                Ok(())
+            }
+        }
+        let audit_service: Arc<dyn temps_core::AuditLogger> = Arc::new(MockAuditLogger);
+
         // Register all services in the service registry
+        service_registry.register(audit_service);
         service_registry.register(db_connection);
         service_registry.register(lb_service);
         service_registry.register(proxy_log_service);
         service_registry.register(ip_access_control_service);
         service_registry.register(challenge_service);
+        service_registry.register(saved_log_search_service);
 
         let plugin_context = PluginContext::new(service_registry, state_registry);
         let plugin = ProxyPlugin::new();
@@ -1,8 +1,12 @@
+use crate::concurrency_limit::{
+    ConcurrencyLimiter, ConcurrencyPermit, CONCURRENCY_RETRY_AFTER_SECONDS,
+};
 use crate::service::challenge_service::ChallengeService;
 use crate::service::ip_access_control_service::IpAccessControlService;
 use crate::service::proxy_log_service::{CreateProxyLogRequest, ProxyLogService};
 use crate::tls_fingerprint;
 use crate::traits::*;
+use crate::trusted_proxy::TrustedProxyCache;
 use async_trait::async_trait;
 use axum::http::header;
 use bytes::Bytes;
@@ -12,17 +16,20 @@ use flate2::Compression;
 use pingora::http::StatusCode;
 use pingora::Error;
 use pingora_core::{
-    upstreams::peer::{HttpPeer, Peer},
+    upstreams::peer::{HttpPeer, Peer, Scheme},
     Result,
 };
-use pingora_http::ResponseHeader;
+use pingora_http::{RequestHeader, ResponseHeader};
 use pingora_proxy::{FailToProxy, ProxyHttp, Session as PingoraSession};
 use sea_orm::{ColumnTrait, EntityTrait, QueryFilter};
 use std::collections::HashMap;
 use std::io::Write;
 use std::sync::Arc;
-use std::time::Instant;
+use std::time::{Duration, Instant};
 use temps_database::DbConnection;
+use temps_entities::deployment_config::{
+    DeploymentConfig, HttpsMode, StaticSiteConfig, UpstreamProtocol,
+};
 use temps_entities::{deployments, domains, environments, projects};
 use tracing::{debug, error, info, warn};
 use uuid::Uuid;
@@ -40,6 +47,170 @@ fn get_visitor_cookie_name(_project_id: Option<i32>) -> String {
 fn get_session_cookie_name(_project_id: Option<i32>) -> String {
     SESSION_ID_COOKIE.to_string()
 }
+
+/// Build a redirect target on another scheme/host, keeping the path and query string
+fn build_redirect_url(scheme: &str, host: &str, path: &str, query: Option<&str>) -> String {
+    match query {
+        Some(query) if !query.is_empty() => format!("{}://{}{}?{}", scheme, host, path, query),
+        _ => format!("{}://{}{}", scheme, host, path),
+    }
+}
+
+/// Scheme a request is redirected to before it is served
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum SchemeRedirect {
+    Https,
+    Http,
+}
+
+impl SchemeRedirect {
+    fn scheme(&self) -> &'static str {
+        match self {
+            SchemeRedirect::Https => "https",
+            SchemeRedirect::Http => "http",
+        }
+    }
+}
+
+/// Decide whether a request moves to the other scheme under its service's HTTPS mode
+///
+/// - `tls_here`: this proxy terminated TLS for the request
+/// - `forwarded_https`: an upstream proxy says the client used HTTPS
+/// - `domain_forces_https`: false when the custom domain opted out of HTTPS redirects,
+///   which wins over the service's mode
+/// - `has_certificate`: an active certificate covers the host
+///
+/// A request an external TLS-terminating proxy forwarded is never sent to the other
+/// scheme in a way that proxy could undo: HTTPS redirects are skipped once it reports
+/// HTTPS, and HTTP-only services only leave HTTPS that this proxy terminated.
+fn scheme_redirect(
+    mode: HttpsMode,
+    tls_here: bool,
+    forwarded_https: bool,
+    domain_forces_https: bool,
+    has_certificate: bool,
+) -> Option<SchemeRedirect> {
+    let client_https = tls_here || forwarded_https;
+    match mode {
+        HttpsMode::ForceHttps if !client_https && domain_forces_https => {
+            Some(SchemeRedirect::Https)
+        }
+        HttpsMode::Auto if !client_https && domain_forces_https && has_certificate => {
+            Some(SchemeRedirect::Https)
+        }
+        HttpsMode::HttpOnly if tls_here && !forwarded_https => Some(SchemeRedirect::Http),
+        _ => None,
+    }
+}
+
+/// How often idle HTTP/2 connections to streaming upstreams are pinged to keep them open
+const H2_STREAM_PING_INTERVAL: Duration = Duration::from_secs(30);
+
+/// How many times a request is retried on another connect attempt when the upstream
+/// refuses the connection, e.g. while a rolling deploy swaps containers
+const MAX_CONNECT_RETRIES: usize = 2;
+
+/// What kind of exchange a request is, which decides its upstream protocol and timeout
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum UpstreamRequestKind {
+    Regular,
+    /// gRPC and SSE streams
+    Stream,
+    WebSocket,
+}
+
+/// Apply a service's upstream protocol and proxy timeouts to the peer serving a request
+fn configure_upstream_peer(
+    peer: &mut HttpPeer,
+    config: &DeploymentConfig,
+    host: &str,
+    kind: UpstreamRequestKind,
+) {
+    let protocol = config.upstream_protocol();
+    // The WebSocket handshake is an HTTP/1.1 upgrade, so it never goes over HTTP/2
+    let http2 = protocol.is_http2() && kind != UpstreamRequestKind::WebSocket;
+    if protocol == UpstreamProtocol::H2 {
+        // Containers present their own (usually self-signed) certificates
+        peer.scheme = Scheme::HTTPS;
+        peer.sni = host.to_string();
+        peer.options.verify_cert = false;
+        peer.options.verify_hostname = false;
+    }
+    if http2 {
+        peer.options.set_http_version(2, 2);
+    }
+
+    let seconds = |seconds: u32| Duration::from_secs(seconds as u64);
+    let read_timeout = match kind {
+        UpstreamRequestKind::Regular => Some(config.request_timeout()),
+        UpstreamRequestKind::Stream => config.stream_timeout_seconds,
+        UpstreamRequestKind::WebSocket => config.websocket_idle_timeout(),
+    };
+    peer.options.read_timeout = read_timeout.map(seconds);
+    peer.options.write_timeout = config.write_timeout_seconds.map(seconds);
+    peer.options.connection_timeout = Some(seconds(config.connect_timeout()));
+    peer.options.idle_timeout = Some(seconds(config.idle_timeout()));
+    if http2 && kind == UpstreamRequestKind::Stream {
+        peer.options.h2_ping_interval = Some(H2_STREAM_PING_INTERVAL);
+    }
+}
+
+/// Whether the upstream asked for its response to be streamed through unbuffered, with
+/// nginx's `X-Accel-Buffering: no`
+fn upstream_disables_buffering(upstream_response: &ResponseHeader) -> bool {
+    upstream_response
+        .headers
+        .get("x-accel-buffering")
+        .and_then(|v| v.to_str().ok())
+        .is_some_and(|value| value.trim().eq_ignore_ascii_case("no"))
+}
+
+/// Status for a request that breaks its service's header or body size limits, decided
+/// from the headers alone so it is rejected before anything is proxied
+fn request_limit_status(req: &RequestHeader, config: &DeploymentConfig) -> Option<u16> {
+    let header_bytes = req.uri.to_string().len()
+        + req
+            .headers
+            .iter()
+            .map(|(name, value)| name.as_str().len() + value.len() + 4)
+            .sum::<usize>();
+    if header_bytes > config.max_request_header_bytes() as usize {
+        return Some(431);
+    }
+
+    let content_length = req
+        .headers
+        .get(header::CONTENT_LENGTH)
+        .and_then(|v| v.to_str().ok())
+        .and_then(|v| v.trim().parse::<u64>().ok());
+    if content_length.is_some_and(|length| length > config.max_request_body_bytes()) {
+        return Some(413);
+    }
+    None
+}
+
+/// Status and body sent when proxying fails
+///
+/// - 413/431 and other explicit statuses raised by the filters are passed through
+/// - 408 when the client is too slow sending its request
+/// - 504 when the upstream is too slow to accept a connection, read or respond
+/// - 503 for anything else, e.g. the upstream refusing connections
+fn failure_status(e: &Error) -> (u16, &'static str) {
+    use pingora::{ErrorSource, ErrorType};
+
+    match (e.etype(), e.esource()) {
+        (ErrorType::HTTPStatus(413), _) => (413, "Payload Too Large"),
+        (ErrorType::HTTPStatus(431), _) => (431, "Request Header Fields Too Large"),
+        (ErrorType::ReadTimedout | ErrorType::WriteTimedout, ErrorSource::Downstream) => {
+            (408, "Request Timeout")
+        }
+        (ErrorType::ConnectTimedout | ErrorType::ReadTimedout | ErrorType::WriteTimedout, _) => {
+            (504, "Gateway Timeout")
+        }
+        _ => (503, "Service Unavailable"),
+    }
+}
+
 pub const SERVER_NAME: &[u8; 5] = b"Temps";
 pub const LB_SEED: u64 = 42;
 pub const MAX_WEBHOOK_BODY_SIZE: usize = 16 * 1024;
@@ -64,6 +235,8 @@ pub struct ProxyContext {
     pub user_agent: String,
     pub referrer: Option<String>,
     pub ip_address: Option<String>,
+    /// Scheme a trusted upstream proxy reported via X-Forwarded-Proto (true for HTTPS)
+    pub forwarded_https: Option<bool>,
     pub visitor_id: Option<String>,
     pub visitor_id_i32: Option<i32>,
     pub session_id: Option<String>,
@@ -75,6 +248,7 @@ pub struct ProxyContext {
     pub request_session_cookie: Option<String>,
     pub is_sse: bool,
     pub is_websocket: bool,
+    pub is_grpc: bool,
     pub skip_tracking: bool,
     pub routing_status: String,
     pub error_message: Option<String>,
@@ -85,6 +259,22 @@ pub struct ProxyContext {
     pub tls_cipher: Option<String>,
     /// SNI hostname from TLS handshake (for SNI-based routing)
     pub sni_hostname: Option<String>,
+    /// Environment a path or routing rule sent this request to, instead of the host's own
+    pub path_target_environment_id: Option<i32>,
+    /// When a WebSocket reaches its service's maximum duration and is closed
+    pub websocket_deadline: Option<Instant>,
+    /// Connect attempts retried so far
+    pub connect_retries: usize,
+    /// Largest request body the service accepts; None skips the check (WebSockets)
+    pub max_request_body_bytes: Option<u64>,
+    /// Request body bytes received so far
+    pub request_body_bytes: u64,
+    /// Response bytes go to the client as they arrive, uncompressed
+    pub unbuffered: bool,
+    /// Counts this request against its deployment's open connections until it ends
+    pub connection_guard: Option<temps_routes::ConnectionGuard>,
+    /// Slot of the service's concurrency limit this request holds until it ends
+    pub concurrency_permit: Option<ConcurrencyPermit>,
 }
 
 impl ProxyContext {
@@ -102,6 +292,46 @@ impl ProxyContext {
             None
         }
     }
+
+    /// Effective deployment config of the environment serving the request
+    fn upstream_deployment_config(&self) -> Option<DeploymentConfig> {
+        let environment = self.environment.as_ref()?;
+        let project_config = self
+            .project
+            .as_ref()
+            .and_then(|project| project.deployment_config.clone())
+            .unwrap_or_default();
+        Some(environment.get_effective_deployment_config(&project_config))
+    }
+
+    fn upstream_request_kind(&self) -> UpstreamRequestKind {
+        if self.is_websocket {
+            UpstreamRequestKind::WebSocket
+        } else if self.is_grpc || self.is_sse {
+            UpstreamRequestKind::Stream
+        } else {
+            UpstreamRequestKind::Regular
+        }
+    }
+
+    /// Fail once a WebSocket has been open longer than its service allows, which
+    /// closes both sides and makes the client reconnect
+    fn check_websocket_deadline(&self) -> Result<()> {
+        match self.websocket_deadline {
+            Some(deadline) if Instant::now() >= deadline => {
+                debug!(
+                    request_id = %self.request_id,
+                    host = %self.host,
+                    "Closing WebSocket at its maximum duration"
+                );
+                Err(Error::explain(
+                    pingora::ErrorType::Custom("WebSocketMaxDuration"),
+                    "WebSocket reached its maximum duration",
+                ))
+            }
+            _ => Ok(()),
+        }
+    }
 }
 
 /// Main load balancer proxy implementation using traits
@@ -117,6 +347,7 @@ pub struct LoadBalancer {
     config_service: Arc<temps_config::ConfigService>,
     ip_access_control_service: Arc<IpAccessControlService>,
     challenge_service: Arc<ChallengeService>,
+    trusted_proxies: TrustedProxyCache,
 }
 
 impl LoadBalancer {
@@ -143,6 +374,7 @@ impl LoadBalancer {
             session_manager,
             crypto,
             db,
+            trusted_proxies: TrustedProxyCache::new(config_service.clone()),
             config_service,
             ip_access_control_service,
             challenge_service,
@@ -673,14 +905,49 @@ impl LoadBalancer {
         Ok(())
     }
 
-    fn is_https_request(&self, session: &PingoraSession) -> bool {
-        session
-            .req_header()
-            .headers
+    /// Resolve the client address and scheme, honoring forwarded headers only from
+    /// trusted upstream proxies
+    async fn resolve_client(&self, session: &PingoraSession, ctx: &mut ProxyContext) {
+        let Some(peer) = session
+            .client_addr()
+            .and_then(|addr| addr.as_inet())
+            .map(|addr| addr.ip())
+        else {
+            ctx.ip_address = Some("unknown".to_string());
+            return;
+        };
+
+        let trusted_proxies = self.trusted_proxies.get().await;
+        let headers = &session.req_header().headers;
+        let forwarded_for: Vec<&str> = headers
+            .get_all("x-forwarded-for")
+            .iter()
+            .filter_map(|v| v.to_str().ok())
+            .collect();
+        let forwarded_proto = headers
             .get("x-forwarded-proto")
-            .and_then(|v| v.to_str().ok())
-            .map(|proto| proto == "https")
-            .unwrap_or_else(|| session.req_header().uri.scheme_str() == Some("https"))
+            .and_then(|v| v.to_str().ok());
+
+        let client_ip = trusted_proxies.client_ip(peer, &forwarded_for);
+        if client_ip != peer.to_canonical() {
+            debug!(
+                request_id = %ctx.request_id,
+                peer = %peer,
+                client_ip = %client_ip,
+                "Client address taken from trusted X-Forwarded-For"
+            );
+        }
+        ctx.ip_address = Some(client_ip.to_string());
+        ctx.forwarded_https = trusted_proxies.forwarded_https(peer, forwarded_proto);
+    }
+
+    /// Whether the client reached us over HTTPS, either directly or through a trusted
+    /// upstream proxy that terminated TLS
+    fn is_https_request(&self, session: &PingoraSession, ctx: &ProxyContext) -> bool {
+        ctx.forwarded_https.unwrap_or_else(|| {
+            self.is_tls_connection(session)
+                || session.req_header().uri.scheme_str() == Some("https")
+        })
     }
 
     /// Check if the connection is a TLS connection by checking for SSL digest
@@ -692,6 +959,39 @@ impl LoadBalancer {
             .is_some()
     }
 
+    /// Point the request at a path rule's rewritten path, keeping the query string
+    fn rewrite_request_path(
+        &self,
+        session: &mut PingoraSession,
+        ctx: &mut ProxyContext,
+        path: String,
+    ) -> Result<()> {
+        if path == ctx.path {
+            return Ok(());
+        }
+        let uri = match &ctx.query_string {
+            Some(query) if !query.is_empty() => format!("{}?{}", path, query),
+            _ => path.clone(),
+        };
+        let uri = uri.parse::<axum::http::Uri>().map_err(|e| {
+            Error::because(
+                pingora::ErrorType::InternalError,
+                format!("Invalid rewritten path '{}'", path),
+                e,
+            )
+        })?;
+        debug!(
+            request_id = %ctx.request_id,
+            host = %ctx.host,
+            from = %ctx.path,
+            to = %path,
+            "Rewriting path"
+        );
+        session.req_header_mut().set_uri(uri);
+        ctx.path = path;
+        Ok(())
+    }
+
     async fn handle_acme_http_challenge(&self, host: &str, path: &str) -> Result<Option<String>> {
         const ACME_CHALLENGE_PREFIX: &str = "/.well-known/acme-challenge/";
 
@@ -929,12 +1229,8 @@ impl LoadBalancer {
                 .unwrap_or(false);
 
             if should_log {
-                // Spawn async task to avoid blocking the response
-                tokio::spawn(async move {
-                    if let Err(e) = proxy_log_service.create(proxy_log_request).await {
-                        warn!("Failed to create proxy log: {:?}", e);
-                    }
-                });
+                // Written in the background, batched with other requests
+                proxy_log_service.enqueue(proxy_log_request);
             }
         }
 
@@ -1086,7 +1382,7 @@ impl LoadBalancer {
                     crawler_name: None,
                 };
 
-                let is_https = self.is_https_request(session);
+                let is_https = self.is_https_request(session, ctx);
                 let visitor_cookie = match self
                     .visitor_manager
                     .generate_visitor_cookie(&visitor, is_https, ctx.get_project_context().as_ref())
@@ -1113,7 +1409,7 @@ impl LoadBalancer {
                 is_new_session: ctx.is_new_session,
             };
 
-            let is_https = self.is_https_request(session);
+            let is_https = self.is_https_request(session, ctx);
             let session_cookie = match self
                 .session_manager
                 .generate_session_cookie(&session_obj, is_https, ctx.get_project_context().as_ref())
@@ -1131,6 +1427,77 @@ impl LoadBalancer {
         Ok(())
     }
 
+    /// Directory a static deployment's files are served from
+    fn absolute_static_dir(&self, static_dir: &str) -> std::path::PathBuf {
+        // Security: ALWAYS join with base static directory
+        // Never trust absolute paths from database - always enforce that static files
+        // must be within the configured static directory to prevent path traversal
+        let static_dir_path = std::path::PathBuf::from(static_dir);
+
+        // Strip leading slash if present (treat all paths as relative)
+        let relative_static_dir = static_dir_path
+            .strip_prefix("/")
+            .unwrap_or(&static_dir_path);
+
+        // Always join with base static directory from config
+        self.config_service.static_dir().join(relative_static_dir)
+    }
+
+    /// Cache-Control for a static file: the site's matching rule, else the defaults
+    fn static_cache_control<'a>(site: &'a StaticSiteConfig, path: &str) -> &'a str {
+        if let Some(value) = site.cache_control_for(path) {
+            return value;
+        }
+        if Self::is_cacheable_static_asset(path) {
+            "public, max-age=31536000, immutable"
+        } else {
+            "public, max-age=0, must-revalidate"
+        }
+    }
+
+    /// Serve a static site's own not found page with status 404
+    /// Returns the body size if the site has the page, None otherwise
+    async fn serve_not_found_page(
+        &self,
+        session: &mut PingoraSession,
+        ctx: &mut ProxyContext,
+        static_dir: &str,
+        site: &StaticSiteConfig,
+    ) -> Result<Option<usize>> {
+        use tokio::fs;
+
+        let absolute_static_dir = self.absolute_static_dir(static_dir);
+        let (Ok(canonical_static_dir), Ok(page_path)) = (
+            fs::canonicalize(&absolute_static_dir).await,
+            fs::canonicalize(absolute_static_dir.join(site.not_found_page())).await,
+        ) else {
+            return Ok(None);
+        };
+        if !page_path.starts_with(&canonical_static_dir) || !page_path.is_file() {
+            return Ok(None);
+        }
+        let Ok(content) = fs::read(&page_path).await else {
+            return Ok(None);
+        };
+
+        let mut resp = ResponseHeader::build(StatusCode::NOT_FOUND, None)?;
+        resp.insert_header(
+            header::CONTENT_TYPE,
+            Self::infer_content_type(page_path.to_str().unwrap_or("404.html")),
+        )?;
+        resp.insert_header(header::CONTENT_LENGTH, content.len().to_string())?;
+        resp.insert_header(header::CACHE_CONTROL, "public, max-age=0, must-revalidate")?;
+        resp.insert_header("X-Request-ID", &ctx.request_id)?;
+        self.set_tracking_cookies(session, &mut resp, ctx).await?;
+
+        let size = content.len();
+        session.write_response_header(Box::new(resp), false).await?;
+        session
+            .write_response_body(Some(Bytes::from(content)), true)
+            .await?;
+        Ok(Some(size))
+    }
+
     /// Serve a static file from the filesystem
     /// Returns Ok(true) if file was served, Ok(false) if file not found, Err on error
     async fn serve_static_file(
@@ -1138,8 +1505,8 @@ impl LoadBalancer {
         session: &mut PingoraSession,
         ctx: &mut ProxyContext,
         static_dir: &str,
+        site: &StaticSiteConfig,
     ) -> Result<bool> {
-        use std::path::PathBuf;
         use tokio::fs;
 
         let mut requested_path = ctx.path.trim_start_matches('/');
@@ -1148,19 +1515,9 @@ impl LoadBalancer {
         if requested_path.is_empty() {
             requested_path = "index.html";
         }
+        let cache_control = Self::static_cache_control(site, &ctx.path).to_string();
 
-        // Security: ALWAYS join with base static directory
-        // Never trust absolute paths from database - always enforce that static files
-        // must be within the configured static directory to prevent path traversal
-        let static_dir_path = PathBuf::from(static_dir);
-
-        // Strip leading slash if present (treat all paths as relative)
-        let relative_static_dir = static_dir_path
-            .strip_prefix("/")
-            .unwrap_or(&static_dir_path);
-
-        // Always join with base static directory from config
-        let absolute_static_dir = self.config_service.static_dir().join(relative_static_dir);
+        let absolute_static_dir = self.absolute_static_dir(static_dir);
 
         let file_path = absolute_static_dir.join(requested_path);
 
@@ -1178,7 +1535,7 @@ impl LoadBalancer {
             Ok(path) => path,
             Err(_) => {
                 // File doesn't exist - try with index.html for SPA routing
-                if !requested_path.contains('.') {
+                if site.spa_fallback() && !requested_path.contains('.') {
                     // Likely a SPA route, serve index.html
                     let index_path = absolute_static_dir.join("index.html");
                     match fs::canonicalize(&index_path).await {
@@ -1234,17 +1591,7 @@ impl LoadBalancer {
                 resp.insert_header("X-Request-ID", &ctx.request_id)?;
 
                 // Add cache headers
-                if Self::is_cacheable_static_asset(requested_path) {
-                    resp.insert_header(
-                        header::CACHE_CONTROL,
-                        "public, max-age=31536000, immutable",
-                    )?;
-                } else {
-                    resp.insert_header(
-                        header::CACHE_CONTROL,
-                        "public, max-age=0, must-revalidate",
-                    )?;
-                }
+                resp.insert_header(header::CACHE_CONTROL, &cache_control)?;
 
                 // CRITICAL: Set tracking cookies even for 304 responses to keep sessions alive
                 // Without this, visitors won't get cookies on cached root URLs (/) and events will fail
@@ -1311,11 +1658,7 @@ impl LoadBalancer {
         }
 
         // Add cache headers for static assets
-        if Self::is_cacheable_static_asset(requested_path) {
-            resp.insert_header(header::CACHE_CONTROL, "public, max-age=31536000, immutable")?;
-        } else {
-            resp.insert_header(header::CACHE_CONTROL, "public, max-age=0, must-revalidate")?;
-        }
+        resp.insert_header(header::CACHE_CONTROL, &cache_control)?;
 
         // Set visitor and session tracking cookies for static file responses
         self.set_tracking_cookies(session, &mut resp, ctx).await?;
@@ -1501,6 +1844,7 @@ impl ProxyHttp for LoadBalancer {
             user_agent: String::new(),
             referrer: None,
             ip_address: None,
+            forwarded_https: None,
             visitor_id: None,
             visitor_id_i32: None,
             session_id: None,
@@ -1512,6 +1856,7 @@ impl ProxyHttp for LoadBalancer {
             request_session_cookie: None,
             is_sse: false,
             is_websocket: false,
+            is_grpc: false,
             skip_tracking: false,
             routing_status: "pending".to_string(),
             error_message: None,
@@ -1521,6 +1866,14 @@ impl ProxyHttp for LoadBalancer {
             tls_version: None,
             tls_cipher: None,
             sni_hostname: None,
+            path_target_environment_id: None,
+            websocket_deadline: None,
+            connect_retries: 0,
+            max_request_body_bytes: None,
+            request_body_bytes: 0,
+            unbuffered: false,
+            connection_guard: None,
+            concurrency_permit: None,
         }
     }
 
@@ -1529,15 +1882,12 @@ impl ProxyHttp for LoadBalancer {
         session: &mut PingoraSession,
         ctx: &mut Self::CTX,
     ) -> Result<()> {
-        // Extract client IP address FIRST (needed for TLS fingerprinting)
-        let client_ip = session
-            .client_addr()
-            .map(|addr| {
-                let addr_str = addr.to_string();
-                addr_str.split(':').next().unwrap_or("unknown").to_string()
-            })
+        // Resolve client IP address FIRST (needed for TLS fingerprinting)
+        self.resolve_client(session, ctx).await;
+        let client_ip = ctx
+            .ip_address
+            .clone()
             .unwrap_or_else(|| "unknown".to_string());
-        ctx.ip_address = Some(client_ip.clone());
 
         // Extract user-agent FIRST (needed for TLS fingerprinting)
         ctx.user_agent = session
@@ -1617,6 +1967,14 @@ impl ProxyHttp for LoadBalancer {
             .and_then(|v| v.to_str().ok())
             .map(|upgrade| upgrade.to_lowercase().contains("websocket"))
             .unwrap_or(false);
+        // gRPC calls are HTTP/2 streams that end with trailers
+        let is_grpc = session
+            .req_header()
+            .headers
+            .get("content-type")
+            .and_then(|v| v.to_str().ok())
+            .map(|content_type| content_type.starts_with("application/grpc"))
+            .unwrap_or(false);
 
         // Check if the request path suggests it might return streaming data
         let req_path = session.req_header().uri.path().to_string();
@@ -1626,7 +1984,7 @@ impl ProxyHttp for LoadBalancer {
             || req_path.contains("/logs")
             || req_path.contains("/webhook");
 
-        if accepts_sse || is_websocket_upgrade || is_chunked || is_streaming_path {
+        if accepts_sse || is_websocket_upgrade || is_grpc || is_chunked || is_streaming_path {
             // Disable compression for SSE/WebSocket/streaming paths
             // compression requires buffering which breaks streaming responses
             session.upstream_compression.adjust_level(0);
@@ -1645,6 +2003,12 @@ impl ProxyHttp for LoadBalancer {
                 debug!("WebSocket upgrade detected, disabling compression for streaming");
             }
 
+            if is_grpc {
+                ctx.is_grpc = true;
+                ctx.skip_tracking = true;
+                debug!("gRPC request detected, disabling compression for streaming");
+            }
+
             if is_streaming_path {
                 debug!(
                     "Streaming path detected: {}, disabling compression",
@@ -1686,13 +2050,6 @@ impl ProxyHttp for LoadBalancer {
             .map(|h| h.to_str().unwrap_or_default().to_string())
             .unwrap_or_default();
 
-        // Extract client IP address early (needed for attack mode checks)
-        if let Some(addr) = session.client_addr() {
-            let addr_str = addr.to_string();
-            let client_ip = addr_str.split(':').next().unwrap_or_default();
-            ctx.ip_address = Some(client_ip.to_string());
-        }
-
         // Detect demo subdomain (demo.<preview_domain>) and add demo mode header
         // This allows the auth middleware to auto-authenticate as demo user
         if ctx.host.starts_with("demo.") {
@@ -1893,40 +2250,105 @@ impl ProxyHttp for LoadBalancer {
             return Ok(true);
         }
 
-        // HTTP to HTTPS redirect for non-TLS connections
-        // This MUST come after ACME challenge handling to allow Let's Encrypt HTTP-01 validation
-        if !self.is_tls_connection(session) {
-            // Build the HTTPS redirect URL preserving path and query string
-            let redirect_url = if let Some(query) = &ctx.query_string {
-                format!(
-                    "https://{}{}{}",
-                    ctx.host,
-                    ctx.path,
-                    if query.is_empty() {
-                        String::new()
-                    } else {
-                        format!("?{}", query)
-                    }
-                )
-            } else {
-                format!("https://{}{}", ctx.host, ctx.path)
+        // The service's HTTPS mode decides whether the request moves to the other scheme.
+        // Behind a trusted upstream that terminates TLS, its X-Forwarded-Proto decides
+        // whether the request is HTTPS. For redirects any X-Forwarded-Proto counts: an
+        // untrusted one can only spare its sender a redirect, while ignoring it would
+        // loop behind a TLS-terminating proxy that isn't configured as trusted.
+        let is_https = self.is_https_request(session, ctx);
+        let forwarded_https = session
+            .req_header()
+            .headers
+            .get("x-forwarded-proto")
+            .and_then(|v| v.to_str().ok())
+            .and_then(|v| v.split(',').next())
+            .is_some_and(|proto| proto.trim().eq_ignore_ascii_case("https"));
+        let config = ctx.upstream_deployment_config().unwrap_or_default();
+        let domain_forces_https = self
+            .project_context_resolver
+            .should_force_https(&ctx.host)
+            .await;
+        let has_certificate = self
+            .project_context_resolver
+            .has_certificate(&ctx.host)
+            .await;
+        let redirect = scheme_redirect(
+            config.https_mode(),
+            self.is_tls_connection(session)
+                || session.req_header().uri.scheme_str() == Some("https"),
+            forwarded_https || ctx.forwarded_https == Some(true),
+            domain_forces_https,
+            has_certificate,
+        );
+
+        // Canonical apex/www redirect, straight to the scheme the service wants so
+        // visitors only take one hop. Comes after ACME handling so both hosts can still
+        // complete HTTP-01 validation.
+        if let Some(canonical_host) = self
+            .project_context_resolver
+            .get_canonical_host(&ctx.host)
+            .await
+        {
+            let scheme = match redirect {
+                Some(redirect) => redirect.scheme(),
+                None if is_https => "https",
+                None => "http",
             };
+            let redirect_url = build_redirect_url(
+                scheme,
+                &canonical_host,
+                &ctx.path,
+                ctx.query_string.as_deref(),
+            );
 
             debug!(
                 request_id = %ctx.request_id,
                 host = %ctx.host,
-                path = %ctx.path,
                 redirect_url = %redirect_url,
-                "Redirecting HTTP to HTTPS"
+                "Redirecting to canonical host"
             );
 
-            // Use 301 Permanent Redirect for HTTP→HTTPS
             let mut resp = ResponseHeader::build(301, None)?;
             resp.insert_header("Location", &redirect_url)?;
             resp.insert_header("Content-Length", "0")?;
             resp.insert_header("X-Request-ID", &ctx.request_id)?;
 
-            ctx.routing_status = "http_to_https_redirect".to_string();
+            ctx.routing_status = "canonical_redirect".to_string();
+
+            session.write_response_header(Box::new(resp), true).await?;
+            return Ok(true);
+        }
+
+        // HTTP to HTTPS redirect (or back to HTTP for HTTP-only services)
+        // This MUST come after ACME challenge handling to allow Let's Encrypt HTTP-01 validation
+        if let Some(redirect) = redirect {
+            // Build the redirect URL preserving path and query string
+            let redirect_url = build_redirect_url(
+                redirect.scheme(),
+                &ctx.host,
+                &ctx.path,
+                ctx.query_string.as_deref(),
+            );
+
+            debug!(
+                request_id = %ctx.request_id,
+                host = %ctx.host,
+                path = %ctx.path,
+                redirect_url = %redirect_url,
+                "Redirecting to {}",
+                redirect.scheme()
+            );
+
+            let mut resp = ResponseHeader::build(config.https_redirect_status(), None)?;
+            resp.insert_header("Location", &redirect_url)?;
+            resp.insert_header("Content-Length", "0")?;
+            resp.insert_header("X-Request-ID", &ctx.request_id)?;
+
+            ctx.routing_status = match redirect {
+                SchemeRedirect::Https => "http_to_https_redirect",
+                SchemeRedirect::Http => "https_to_http_redirect",
+            }
+            .to_string();
 
             session.write_response_header(Box::new(resp), true).await?;
             return Ok(true);
@@ -1961,6 +2383,141 @@ impl ProxyHttp for LoadBalancer {
             return Ok(true); // Skip proxying
         }
 
+        // Apply the domain's path rules; the internal /api/_temps paths always pass through
+        if !ctx.path.starts_with(ROUTE_PREFIX_TEMPS) {
+            match self
+                .project_context_resolver
+                .resolve_path(&ctx.host, &ctx.path)
+                .await
+            {
+                None => {
+                    debug!(
+                        request_id = %ctx.request_id,
+                        host = %ctx.host,
+                        path = %ctx.path,
+                        "Path not served by this domain"
+                    );
+
+                    let mut resp = ResponseHeader::build(404, None)?;
+                    resp.insert_header("Content-Length", "0")?;
+                    resp.insert_header("X-Request-ID", &ctx.request_id)?;
+
+                    ctx.routing_status = "path_not_routed".to_string();
+
+                    session.write_response_header(Box::new(resp), true).await?;
+                    return Ok(true);
+                }
+                Some(resolution) => {
+                    if let Some(environment_id) = resolution.environment_id {
+                        let Some(target) = self
+                            .project_context_resolver
+                            .resolve_environment_context(environment_id)
+                            .await
+                        else {
+                            warn!(
+                                request_id = %ctx.request_id,
+                                host = %ctx.host,
+                                environment_id,
+                                "Path rule targets an environment without an active deployment"
+                            );
+
+                            let mut resp = ResponseHeader::build(502, None)?;
+                            resp.insert_header("Content-Length", "0")?;
+                            resp.insert_header("X-Request-ID", &ctx.request_id)?;
+
+                            ctx.routing_status = "path_target_unavailable".to_string();
+
+                            session.write_response_header(Box::new(resp), true).await?;
+                            return Ok(true);
+                        };
+                        ctx.project = Some(target.project);
+                        ctx.environment = Some(target.environment);
+                        ctx.deployment = Some(target.deployment);
+                        ctx.path_target_environment_id = Some(environment_id);
+                    }
+                    self.rewrite_request_path(session, ctx, resolution.path)?;
+                }
+            }
+        }
+
+        // Header and host routing rules pick another environment for the request; a
+        // path rule's target takes precedence, and an unavailable target falls through
+        // to the host's own environment
+        if ctx.path_target_environment_id.is_none() && !ctx.path.starts_with(ROUTE_PREFIX_TEMPS) {
+            if let Some(environment_id) = self
+                .project_context_resolver
+                .resolve_routing_rule(&ctx.host, session.req_header())
+                .await
+            {
+                match self
+                    .project_context_resolver
+                    .resolve_environment_context(environment_id)
+                    .await
+                {
+                    Some(target) => {
+                        debug!(
+                            request_id = %ctx.request_id,
+                            host = %ctx.host,
+                            environment_id,
+                            "Routing rule sent request to another environment"
+                        );
+                        ctx.project = Some(target.project);
+                        ctx.environment = Some(target.environment);
+                        ctx.deployment = Some(target.deployment);
+                        ctx.path_target_environment_id = Some(environment_id);
+                    }
+                    None => warn!(
+                        request_id = %ctx.request_id,
+                        host = %ctx.host,
+                        environment_id,
+                        "Routing rule targets an environment without an active deployment"
+                    ),
+                }
+            }
+        }
+
+        // Enforce the service's header and body size limits and how long the client may
+        // take to send its request; streams and WebSockets are long-lived by design
+        if let Some(config) = ctx.upstream_deployment_config() {
+            if let Some(status) = request_limit_status(session.req_header(), &config) {
+                debug!(
+                    request_id = %ctx.request_id,
+                    host = %ctx.host,
+                    status,
+                    "Request exceeds the service's size limits"
+                );
+
+                let mut resp = ResponseHeader::build(status, None)?;
+                resp.insert_header("Content-Length", "0")?;
+                resp.insert_header("Connection", "close")?;
+                resp.insert_header("X-Request-ID", &ctx.request_id)?;
+
+                ctx.routing_status = "request_too_large".to_string();
+
+                session.write_response_header(Box::new(resp), true).await?;
+                return Ok(true);
+            }
+
+            // Compression holds bytes back until it has enough of them to compress
+            if !config.response_buffering() {
+                ctx.unbuffered = true;
+                session.upstream_compression.adjust_level(0);
+            }
+
+            match ctx.upstream_request_kind() {
+                UpstreamRequestKind::Regular => {
+                    ctx.max_request_body_bytes = Some(config.max_request_body_bytes());
+                    session.set_read_timeout(Some(Duration::from_secs(
+                        config.client_timeout() as u64
+                    )));
+                }
+                UpstreamRequestKind::Stream => {
+                    ctx.max_request_body_bytes = Some(config.max_request_body_bytes());
+                }
+                UpstreamRequestKind::WebSocket => {}
+            }
+        }
+
         // Capture request headers
         let request_headers: HashMap<String, String> = session
             .req_header()
@@ -2006,7 +2563,8 @@ impl ProxyHttp for LoadBalancer {
             .map(|cookie| cookie.value().to_string());
 
         // Get IP from the connection
-        // Add X-Forwarded-For header with client IP (already extracted in request_filter)
+        // Add X-Forwarded-For header with client IP (resolved in early_request_filter).
+        // Replaces any incoming value so untrusted clients can't spoof their address.
         if let Some(ref ip) = ctx.ip_address {
             session
                 .req_header_mut()
@@ -2014,7 +2572,7 @@ impl ProxyHttp for LoadBalancer {
         }
 
         // Add X-Forwarded-Proto header to indicate the original protocol (HTTP/HTTPS)
-        let proto = if self.is_https_request(session) {
+        let proto = if self.is_https_request(session, ctx) {
             "https"
         } else {
             "http"
@@ -2081,11 +2639,19 @@ impl ProxyHttp for LoadBalancer {
         }
 
         // Check if this is a static deployment using route table
-        if let Some(static_dir) = self
-            .project_context_resolver
-            .get_static_path(&ctx.host)
-            .await
-        {
+        let static_path = match ctx.path_target_environment_id {
+            Some(environment_id) => {
+                self.project_context_resolver
+                    .get_environment_static_path(environment_id)
+                    .await
+            }
+            None => {
+                self.project_context_resolver
+                    .get_static_path(&ctx.host)
+                    .await
+            }
+        };
+        if let Some(static_dir) = static_path {
             debug!(
                 "Static deployment detected for {}: {}",
                 ctx.host, static_dir
@@ -2101,8 +2667,16 @@ impl ProxyHttp for LoadBalancer {
                     // Continue serving the file even if visitor/session creation fails
                 }
 
+                let site = ctx
+                    .upstream_deployment_config()
+                    .and_then(|config| config.static_site)
+                    .unwrap_or_default();
+
                 // Serve static file
-                match self.serve_static_file(session, ctx, &static_dir).await {
+                match self
+                    .serve_static_file(session, ctx, &static_dir, &site)
+                    .await
+                {
                     Ok(served) => {
                         if served {
                             debug!("Served static file: {}", ctx.path);
@@ -2125,6 +2699,24 @@ impl ProxyHttp for LoadBalancer {
                                 "Static file not found: {} (static dir: {})",
                                 ctx.path, static_dir
                             );
+                            ctx.routing_status = "static_file_not_found".to_string();
+
+                            // Prefer the site's own 404 page
+                            if let Some(size) = self
+                                .serve_not_found_page(session, ctx, &static_dir, &site)
+                                .await?
+                            {
+                                self.log_static_request(
+                                    ctx,
+                                    404,
+                                    "static_file_not_found",
+                                    &static_dir,
+                                    Some("Static file not found".to_string()),
+                                    Some(size as i64),
+                                );
+                                return Ok(true);
+                            }
+
                             let mut resp = ResponseHeader::build(StatusCode::NOT_FOUND, None)?;
                             resp.insert_header(header::CONTENT_TYPE, "text/html")?;
 
@@ -2204,12 +2796,53 @@ impl ProxyHttp for LoadBalancer {
             // fall through to normal proxying logic (will be proxied to console)
         }
 
+        // Hold one of the service's slots while the request is proxied, waiting in its
+        // queue when they are all taken; streams and WebSockets don't count
+        if let (Some(environment_id), Some(config)) = (
+            ctx.environment.as_ref().map(|environment| environment.id),
+            ctx.upstream_deployment_config(),
+        ) {
+            if let Some(limit) = config.concurrency_limit.as_ref().filter(|_| {
+                ctx.upstream_request_kind() == UpstreamRequestKind::Regular
+                    && !ctx.path.starts_with(ROUTE_PREFIX_TEMPS)
+            }) {
+                match ConcurrencyLimiter::global()
+                    .acquire(environment_id, limit)
+                    .await
+                {
+                    Ok(permit) => ctx.concurrency_permit = Some(permit),
+                    Err(rejection) => {
+                        debug!(
+                            request_id = %ctx.request_id,
+                            host = %ctx.host,
+                            environment_id,
+                            reason = %rejection,
+                            "Request over the service's concurrency limit"
+                        );
+
+                        let mut resp = ResponseHeader::build(503, None)?;
+                        resp.insert_header("Content-Length", "0")?;
+                        resp.insert_header(
+                            header::RETRY_AFTER,
+                            CONCURRENCY_RETRY_AFTER_SECONDS.to_string(),
+                        )?;
+                        resp.insert_header("X-Request-ID", &ctx.request_id)?;
+
+                        ctx.routing_status = "concurrency_limited".to_string();
+
+                        session.write_response_header(Box::new(resp), true).await?;
+                        return Ok(true);
+                    }
+                }
+            }
+        }
+
         Ok(false)
     }
 
     fn upstream_response_filter(
         &self,
-        _session: &mut PingoraSession,
+        session: &mut PingoraSession,
         upstream_response: &mut ResponseHeader,
         ctx: &mut Self::CTX,
     ) -> Result<()> {
@@ -2237,9 +2870,41 @@ impl ProxyHttp for LoadBalancer {
             debug!("SSE response detected from upstream");
         }
 
+        // Responses only known to stream once they arrive aren't compressed either
+        if is_sse || upstream_disables_buffering(upstream_response) {
+            ctx.unbuffered = true;
+            session.upstream_compression.adjust_level(0);
+        }
+
         Ok(())
     }
 
+    async fn request_body_filter(
+        &self,
+        _session: &mut PingoraSession,
+        body: &mut Option<Bytes>,
+        _end_of_stream: bool,
+        ctx: &mut Self::CTX,
+    ) -> Result<()>
+    where
+        Self::CTX: Send + Sync,
+    {
+        // Chunked bodies have no Content-Length, so their size is checked as they arrive
+        if let (Some(limit), Some(chunk)) = (ctx.max_request_body_bytes, body.as_ref()) {
+            ctx.request_body_bytes += chunk.len() as u64;
+            if ctx.request_body_bytes > limit {
+                ctx.routing_status = "request_too_large".to_string();
+                return Err(Error::explain(
+                    pingora::ErrorType::HTTPStatus(413),
+                    format!("Request body exceeds {} bytes", limit),
+                ));
+            }
+        }
+
+        // Frames sent by the client of an upgraded WebSocket pass through here
+        ctx.check_websocket_deadline()
+    }
+
     fn response_body_filter(
         &self,
         _session: &mut PingoraSession,
@@ -2250,15 +2915,25 @@ impl ProxyHttp for LoadBalancer {
     where
         Self::CTX: Send + Sync,
     {
-        // For SSE or WebSocket responses, pass through immediately without buffering
-        if ctx.is_sse || ctx.is_websocket {
+        ctx.check_websocket_deadline()?;
+
+        // For SSE, WebSocket and unbuffered responses, pass through immediately
+        if ctx.is_sse || ctx.is_websocket || ctx.unbuffered {
             if let Some(chunk) = body {
-                let stream_type = if ctx.is_sse { "SSE" } else { "WebSocket" };
+                let stream_type = if ctx.is_sse {
+                    "SSE"
+                } else if ctx.is_websocket {
+                    "WebSocket"
+                } else {
+                    "unbuffered"
+                };
                 debug!("Streaming {} chunk: {} bytes", stream_type, chunk.len());
             }
         }
 
-        // Pass all responses through without buffering
+        // Pass all responses through without buffering or delay; the upstream isn't
+        // read further until the client took the chunk, so a slow client slows the
+        // upstream down instead of piling the response up in the proxy
         Ok(None)
     }
 
@@ -2330,6 +3005,11 @@ impl ProxyHttp for LoadBalancer {
             ctx.skip_tracking = true;
         }
 
+        // Proxies and CDNs in front of this one should stream the response through too
+        if ctx.unbuffered && !upstream_response.headers.contains_key("x-accel-buffering") {
+            upstream_response.insert_header("X-Accel-Buffering", "no")?;
+        }
+
         // Handle WebSocket upgrade responses
         if ctx.is_websocket {
             // WebSocket requires specific upgrade headers - don't modify them
@@ -2423,10 +3103,29 @@ impl ProxyHttp for LoadBalancer {
 
         // Use the upstream resolver trait
         // Pass SNI hostname for TLS-based routing
-        let peer = self
-            .upstream_resolver
-            .resolve_peer(&domain, &path, ctx.sni_hostname.as_deref())
-            .await?;
+        let mut peer = match ctx.path_target_environment_id {
+            Some(environment_id) => {
+                self.upstream_resolver
+                    .resolve_environment_peer(environment_id)
+                    .await?
+            }
+            None => {
+                self.upstream_resolver
+                    .resolve_peer(&domain, &path, ctx.sni_hostname.as_deref())
+                    .await?
+            }
+        };
+
+        // Services opt into HTTP/2 (h2c or h2) and their own timeouts; HTTP/1.1 otherwise
+        if let Some(config) = ctx.upstream_deployment_config() {
+            let kind = ctx.upstream_request_kind();
+            configure_upstream_peer(&mut peer, &config, &domain, kind);
+            if kind == UpstreamRequestKind::WebSocket {
+                ctx.websocket_deadline = config
+                    .websocket_max_duration_seconds
+                    .map(|seconds| ctx.start_time + Duration::from_secs(seconds as u64));
+            }
+        }
 
         // Populate context with upstream information
         // Use the Peer trait's address() method
@@ -2439,6 +3138,13 @@ impl ProxyHttp for LoadBalancer {
             // For now, we'll use the deployment ID as a proxy for container tracking
             // In the future, the upstream resolver could provide actual container IDs
             ctx.container_id = Some(format!("deployment-{}", deployment.id));
+
+            // Retries resolve the peer again but stay the same connection
+            if ctx.connection_guard.is_none() {
+                ctx.connection_guard = self
+                    .project_context_resolver
+                    .track_connection(deployment.id);
+            }
         }
 
         Ok(peer)
@@ -2448,10 +3154,16 @@ impl ProxyHttp for LoadBalancer {
         &self,
         _session: &mut PingoraSession,
         _peer: &HttpPeer,
-        _ctx: &mut Self::CTX,
-        e: Box<Error>,
+        ctx: &mut Self::CTX,
+        mut e: Box<Error>,
     ) -> Box<Error> {
         error!("Failed to connect to upstream: {:?}", e);
+        // Nothing reached the upstream yet, so any request can be retried; the retry
+        // resolves the peer again and picks up containers swapped in by a deploy
+        if ctx.connect_retries < MAX_CONNECT_RETRIES {
+            ctx.connect_retries += 1;
+            e.set_retry(true);
+        }
         e
     }
 
@@ -2481,7 +3193,8 @@ impl ProxyHttp for LoadBalancer {
         ctx.error_message = Some(e.to_string());
         ctx.routing_status = "error".to_string();
 
-        let mut header = match ResponseHeader::build(503, None) {
+        let (status, message) = failure_status(e);
+        let mut header = match ResponseHeader::build(status, None) {
             Ok(header) => header,
             Err(e) => {
                 error!("Failed to build response header: {:?}", e);
@@ -2511,13 +3224,13 @@ impl ProxyHttp for LoadBalancer {
         }
 
         if let Err(e) = session
-            .write_response_body(Some(Bytes::from("Service Unavailable")), true)
+            .write_response_body(Some(Bytes::from(message)), true)
             .await
         {
             error!("Failed to write response body: {:?}", e);
         }
 
-        error_code = 503;
+        error_code = status;
 
         // Asynchronously log failed proxy request (skip static assets)
         if Self::should_log_request(&ctx.path) {
@@ -2529,7 +3242,7 @@ impl ProxyHttp for LoadBalancer {
                 .and_then(|v| v.parse::<i64>().ok());
 
             // For failed requests, response size is the error message size
-            let response_size = Some("Service Unavailable".len() as i64);
+            let response_size = Some(message.len() as i64);
 
             let proxy_log_service = self.proxy_log_service.clone();
             let proxy_log_request = CreateProxyLogRequest {
@@ -2588,3 +3301,251 @@ impl ProxyHttp for LoadBalancer {
         }
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use temps_entities::deployment_config::{
+        DEFAULT_CONNECT_TIMEOUT_SECONDS, DEFAULT_REQUEST_TIMEOUT_SECONDS,
+    };
+
+    fn peer() -> HttpPeer {
+        HttpPeer::new("127.0.0.1:8080", false, "".to_string())
+    }
+
+    #[test]
+    fn test_http1_peer_gets_default_timeouts() {
+        let mut peer = peer();
+        configure_upstream_peer(
+            &mut peer,
+            &DeploymentConfig::default(),
+            "app.com",
+            UpstreamRequestKind::Regular,
+        );
+        assert_eq!(peer.options.alpn.get_max_http_version(), 1);
+        assert_eq!(
+            peer.options.read_timeout,
+            Some(Duration::from_secs(DEFAULT_REQUEST_TIMEOUT_SECONDS as u64))
+        );
+        assert_eq!(
+            peer.options.connection_timeout,
+            Some(Duration::from_secs(DEFAULT_CONNECT_TIMEOUT_SECONDS as u64))
+        );
+        assert!(peer.options.write_timeout.is_none());
+    }
+
+    #[test]
+    fn test_upstream_can_disable_buffering() {
+        assert!(DeploymentConfig::default().response_buffering());
+
+        let mut response = ResponseHeader::build(200, None).unwrap();
+        assert!(!upstream_disables_buffering(&response));
+        response.insert_header("X-Accel-Buffering", "no").unwrap();
+        assert!(upstream_disables_buffering(&response));
+        response.insert_header("X-Accel-Buffering", "yes").unwrap();
+        assert!(!upstream_disables_buffering(&response));
+    }
+
+    #[test]
+    fn test_request_limits() {
+        let config = DeploymentConfig {
+            max_request_body_bytes: Some(1024),
+            max_request_header_bytes: Some(2048),
+            ..Default::default()
+        };
+
+        let mut req = RequestHeader::build("POST", b"/upload", None).unwrap();
+        req.insert_header("Content-Length", "512").unwrap();
+        assert_eq!(request_limit_status(&req, &config), None);
+
+        req.insert_header("Content-Length", "4096").unwrap();
+        assert_eq!(request_limit_status(&req, &config), Some(413));
+
+        let mut req = RequestHeader::build("GET", b"/", None).unwrap();
+        req.insert_header("Cookie", "a".repeat(4096)).unwrap();
+        assert_eq!(request_limit_status(&req, &config), Some(431));
+    }
+
+    #[test]
+    fn test_failure_status() {
+        use pingora::{ErrorSource, ErrorType};
+
+        let mut e = Error::new(ErrorType::ReadTimedout);
+        e.as_up();
+        assert_eq!(failure_status(&e).0, 504);
+
+        let mut e = Error::new(ErrorType::ReadTimedout);
+        e.as_down();
+        assert_eq!(e.esource(), &ErrorSource::Downstream);
+        assert_eq!(failure_status(&e).0, 408);
+
+        assert_eq!(
+            failure_status(&Error::new(ErrorType::HTTPStatus(413))).0,
+            413
+        );
+        assert_eq!(
+            failure_status(&Error::new(ErrorType::ConnectTimedout)).0,
+            504
+        );
+        assert_eq!(
+            failure_status(&Error::new(ErrorType::ConnectRefused)).0,
+            503
+        );
+    }
+
+    #[test]
+    fn test_h2c_peer_speaks_http2_over_plain_tcp() {
+        let config = DeploymentConfig {
+            upstream_protocol: Some(UpstreamProtocol::H2c),
+            ..Default::default()
+        };
+        let mut peer = peer();
+        configure_upstream_peer(
+            &mut peer,
+            &config,
+            "grpc.app.com",
+            UpstreamRequestKind::Stream,
+        );
+        assert_eq!(peer.options.alpn.get_min_http_version(), 2);
+        assert!(!peer.is_tls());
+        assert_eq!(peer.options.h2_ping_interval, Some(H2_STREAM_PING_INTERVAL));
+    }
+
+    #[test]
+    fn test_h2_peer_uses_tls() {
+        let config = DeploymentConfig {
+            upstream_protocol: Some(UpstreamProtocol::H2),
+            ..Default::default()
+        };
+        let mut peer = peer();
+        configure_upstream_peer(
+            &mut peer,
+            &config,
+            "grpc.app.com",
+            UpstreamRequestKind::Regular,
+        );
+        assert!(peer.is_tls());
+        assert_eq!(peer.sni(), "grpc.app.com");
+        assert!(!peer.options.verify_cert);
+    }
+
+    #[test]
+    fn test_streams_use_stream_timeout() {
+        let config = DeploymentConfig {
+            request_timeout_seconds: Some(30),
+            stream_timeout_seconds: Some(3600),
+            ..Default::default()
+        };
+
+        let mut regular = peer();
+        configure_upstream_peer(
+            &mut regular,
+            &config,
+            "app.com",
+            UpstreamRequestKind::Regular,
+        );
+        assert_eq!(regular.options.read_timeout, Some(Duration::from_secs(30)));
+
+        let mut stream = peer();
+        configure_upstream_peer(&mut stream, &config, "app.com", UpstreamRequestKind::Stream);
+        assert_eq!(stream.options.read_timeout, Some(Duration::from_secs(3600)));
+    }
+
+    #[test]
+    fn test_websockets_stay_on_http1_with_their_own_idle_timeout() {
+        let config = DeploymentConfig {
+            upstream_protocol: Some(UpstreamProtocol::H2c),
+            stream_timeout_seconds: Some(3600),
+            websocket_idle_timeout_seconds: Some(300),
+            ..Default::default()
+        };
+        let mut peer = peer();
+        configure_upstream_peer(
+            &mut peer,
+            &config,
+            "app.com",
+            UpstreamRequestKind::WebSocket,
+        );
+        assert_eq!(peer.options.alpn.get_max_http_version(), 1);
+        assert_eq!(peer.options.read_timeout, Some(Duration::from_secs(300)));
+        assert!(peer.options.h2_ping_interval.is_none());
+    }
+
+    #[test]
+    fn test_static_cache_control_prefers_site_rules() {
+        let site = StaticSiteConfig {
+            cache_control: vec![temps_entities::deployment_config::CacheControlRule {
+                pattern: "/index.html".to_string(),
+                value: "no-store".to_string(),
+            }],
+            ..Default::default()
+        };
+        assert_eq!(
+            LoadBalancer::static_cache_control(&site, "/index.html"),
+            "no-store"
+        );
+        assert_eq!(
+            LoadBalancer::static_cache_control(&site, "/assets/app.js"),
+            "public, max-age=31536000, immutable"
+        );
+        assert_eq!(
+            LoadBalancer::static_cache_control(&StaticSiteConfig::default(), "/about"),
+            "public, max-age=0, must-revalidate"
+        );
+    }
+
+    #[test]
+    fn test_auto_https_redirects_once_certificate_is_active() {
+        assert_eq!(
+            scheme_redirect(HttpsMode::Auto, false, false, true, false),
+            None
+        );
+        assert_eq!(
+            scheme_redirect(HttpsMode::Auto, false, false, true, true),
+            Some(SchemeRedirect::Https)
+        );
+        assert_eq!(
+            scheme_redirect(HttpsMode::Auto, true, false, true, true),
+            None
+        );
+    }
+
+    #[test]
+    fn test_https_modes() {
+        assert_eq!(
+            scheme_redirect(HttpsMode::ForceHttps, false, false, true, false),
+            Some(SchemeRedirect::Https)
+        );
+        // A custom domain that opted out of redirects keeps plain HTTP
+        assert_eq!(
+            scheme_redirect(HttpsMode::ForceHttps, false, false, false, true),
+            None
+        );
+        assert_eq!(
+            scheme_redirect(HttpsMode::Both, false, false, true, true),
+            None
+        );
+        assert_eq!(
+            scheme_redirect(HttpsMode::HttpOnly, true, false, true, true),
+            Some(SchemeRedirect::Http)
+        );
+        assert_eq!(
+            scheme_redirect(HttpsMode::HttpOnly, false, false, true, true),
+            None
+        );
+    }
+
+    #[test]
+    fn test_no_redirect_loops_behind_tls_terminating_proxy() {
+        // The external proxy already speaks HTTPS to the client
+        assert_eq!(
+            scheme_redirect(HttpsMode::ForceHttps, false, true, true, true),
+            None
+        );
+        // Sending its HTTPS traffic to HTTP could bounce straight back
+        assert_eq!(
+            scheme_redirect(HttpsMode::HttpOnly, true, true, true, true),
+            None
+        );
+    }
+}
@@ -1,11 +1,13 @@
 use crate::config::*;
 use crate::proxy::LoadBalancer;
 use crate::service::lb_service::LbService;
+use crate::service::proxy_log_ingestion::ProxyLogIngestionConfig;
 use crate::services::*;
 use crate::tls_cert_loader::CertificateLoader;
 use crate::traits::*;
 use anyhow::Result;
 use pingora::server::RunArgs;
+use pingora_core::apps::HttpServerOptions;
 use pingora_core::listeners::tls::TlsSettings;
 use pingora_core::listeners::TlsAccept;
 use pingora_core::protocols::tls::TlsRef;
@@ -194,10 +196,13 @@ pub fn setup_proxy_server(
         ip_service.clone(),
     )) as Arc<dyn RequestLogger>;
 
-    let proxy_log_service = Arc::new(crate::service::proxy_log_service::ProxyLogService::new(
-        db.clone(),
-        ip_service.clone(),
-    ));
+    let proxy_log_service = Arc::new(
+        crate::service::proxy_log_service::ProxyLogService::with_ingestion_config(
+            db.clone(),
+            ip_service.clone(),
+            ProxyLogIngestionConfig::from_server_config(&config),
+        ),
+    );
 
     let ip_access_control_service = Arc::new(
         crate::service::ip_access_control_service::IpAccessControlService::new(db.clone()),
@@ -251,6 +256,13 @@ pub fn setup_proxy_server(
 
     // Create HTTP proxy service
     let mut proxy_service = http_proxy_service(&server.configuration, lb);
+    // Accept HTTP/2 with prior knowledge (h2c) on the plain listener so gRPC clients can
+    // connect without TLS; HTTP/1.1 clients are unaffected
+    if let Some(proxy) = proxy_service.app_logic_mut() {
+        let mut server_options = HttpServerOptions::default();
+        server_options.h2c = true;
+        proxy.server_options = Some(server_options);
+    }
     proxy_service.add_tcp(&proxy_config.address);
     // Add TLS if configured
     if let Some(ref tls_address) = proxy_config.tls_address {
@@ -325,10 +337,13 @@ pub fn create_proxy_service(
         ip_service.clone(),
     )) as Arc<dyn RequestLogger>;
 
-    let proxy_log_service = Arc::new(crate::service::proxy_log_service::ProxyLogService::new(
-        db.clone(),
-        ip_service.clone(),
-    ));
+    let proxy_log_service = Arc::new(
+        crate::service::proxy_log_service::ProxyLogService::with_ingestion_config(
+            db.clone(),
+            ip_service.clone(),
+            ProxyLogIngestionConfig::from_server_config(&config),
+        ),
+    );
 
     let ip_access_control_service = Arc::new(
         crate::service::ip_access_control_service::IpAccessControlService::new(db.clone()),
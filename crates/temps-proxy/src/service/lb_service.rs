@@ -5,6 +5,7 @@ use anyhow::{Context, Result};
 use chrono::Utc;
 use sea_orm::*;
 use std::net::IpAddr;
+use temps_core::HostnameLocks;
 use temps_entities::custom_routes::RouteType;
 use tracing::{error, info};
 
@@ -52,11 +53,42 @@ pub enum LbServiceError {
 
 pub struct LbService {
     db: Arc<DatabaseConnection>,
+    hostname_locks: Arc<HostnameLocks>,
 }
 
 impl LbService {
     pub fn new(db: Arc<DatabaseConnection>) -> Self {
-        Self { db }
+        Self {
+            db,
+            hostname_locks: Arc::new(HostnameLocks::new()),
+        }
+    }
+
+    /// Share hostname locks with the other services that change routing
+    pub fn with_hostname_locks(mut self, hostname_locks: Arc<HostnameLocks>) -> Self {
+        self.hostname_locks = hostname_locks;
+        self
+    }
+
+    /// Whether an environment already serves the domain, as a custom domain or one of
+    /// its own domains
+    async fn domain_used_by_environment(&self, domain: &str) -> Result<bool, LbServiceError> {
+        use temps_entities::{environment_domains, project_custom_domains};
+
+        let custom_domain = project_custom_domains::Entity::find()
+            .filter(project_custom_domains::Column::Domain.eq(domain))
+            .one(self.db.as_ref())
+            .await
+            .map_err(LbServiceError::DatabaseError)?;
+        if custom_domain.is_some() {
+            return Ok(true);
+        }
+        let environment_domain = environment_domains::Entity::find()
+            .filter(environment_domains::Column::Domain.eq(domain))
+            .one(self.db.as_ref())
+            .await
+            .map_err(LbServiceError::DatabaseError)?;
+        Ok(environment_domain.is_some())
     }
 
     /// Check if a domain matches a wildcard pattern
@@ -94,6 +126,7 @@ impl LbService {
             "Creating new route for domain: {} (type: {:?})",
             domain, route_type
         );
+        let _hostname = self.hostname_locks.lock([&domain]).await;
         // Check if route already exists
         match self.get_route(&domain).await {
             Ok(_) => {
@@ -108,6 +141,12 @@ impl LbService {
                 return Err(e);
             }
         }
+        // Routing a domain an environment serves would leave the host with two backends
+        if self.domain_used_by_environment(&domain).await? {
+            return Err(LbServiceError::RouteAlreadyExists {
+                domain: domain.clone(),
+            });
+        }
 
         use temps_entities::custom_routes;
 
@@ -186,6 +225,7 @@ impl LbService {
     ) -> Result<temps_entities::custom_routes::Model> {
         use temps_entities::custom_routes;
 
+        let _hostname = self.hostname_locks.lock([domain_val]).await;
         let mut update_model = custom_routes::ActiveModel {
             updated_at: Set(Utc::now()),
             enabled: Set(enabled_val),
@@ -218,6 +258,7 @@ impl LbService {
     pub async fn delete_route(&self, domain_val: &str) -> Result<()> {
         use temps_entities::custom_routes;
 
+        let _hostname = self.hostname_locks.lock([domain_val]).await;
         custom_routes::Entity::delete_many()
             .filter(custom_routes::Column::Domain.eq(domain_val))
             .exec(self.db.as_ref())
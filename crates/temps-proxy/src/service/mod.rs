@@ -1,4 +1,6 @@
 pub mod challenge_service;
 pub mod ip_access_control_service;
 pub mod lb_service;
+pub mod proxy_log_ingestion;
 pub mod proxy_log_service;
+pub mod saved_log_search_service;
@@ -0,0 +1,338 @@
+//! Batched ingestion of proxy logs
+//!
+//! Requests are logged from the proxy's hot path, so writing each one to the
+//! `proxy_logs` hypertable as it is served doesn't hold up at high traffic: one task
+//! and one INSERT per request, with nothing limiting how many pile up when the
+//! database is slow. Instead, logs go into a bounded queue that a few workers drain
+//! in batches, each written with a single multi-row INSERT. A batch is flushed when
+//! it is full or when the flush interval has passed since its first log.
+//!
+//! When the database can't keep up and the queue is full, new logs are dropped
+//! rather than buffered without bound; drops, write failures and ingestion lag are
+//! counted in [`ProxyLogIngestionStats`].
+
+use chrono::Utc;
+use once_cell::sync::Lazy;
+use serde::Serialize;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+use temps_core::UtcDateTime;
+use tokio::sync::{mpsc, Mutex};
+use tracing::{debug, warn};
+use utoipa::ToSchema;
+
+use super::proxy_log_service::{CreateProxyLogRequest, ProxyLogService};
+
+/// Least time between two warnings about dropped logs
+const DROP_WARNING_INTERVAL: Duration = Duration::from_secs(60);
+
+/// Counters of the ingestion pipeline, shared by the proxy and the API
+static INGESTION_COUNTERS: Lazy<IngestionCounters> = Lazy::new(IngestionCounters::default);
+
+/// Tuning of proxy log ingestion
+#[derive(Debug, Clone, PartialEq)]
+pub struct ProxyLogIngestionConfig {
+    /// Most logs written in one INSERT
+    pub batch_size: usize,
+    /// Longest time a log waits for its batch to fill up
+    pub flush_interval: Duration,
+    /// Batches written concurrently
+    pub workers: usize,
+    /// Logs waiting to be written before new ones are dropped
+    pub queue_capacity: usize,
+}
+
+impl Default for ProxyLogIngestionConfig {
+    fn default() -> Self {
+        Self {
+            batch_size: 500,
+            flush_interval: Duration::from_millis(1000),
+            workers: 2,
+            queue_capacity: 20_000,
+        }
+    }
+}
+
+impl ProxyLogIngestionConfig {
+    pub fn from_server_config(config: &temps_config::ServerConfig) -> Self {
+        Self {
+            batch_size: config.get_proxy_log_batch_size(),
+            flush_interval: Duration::from_millis(config.get_proxy_log_flush_interval_ms()),
+            workers: config.get_proxy_log_workers(),
+            queue_capacity: config.get_proxy_log_queue_capacity(),
+        }
+        .normalized()
+    }
+
+    /// The config with every setting at least 1
+    fn normalized(self) -> Self {
+        Self {
+            batch_size: self.batch_size.max(1),
+            flush_interval: self.flush_interval.max(Duration::from_millis(1)),
+            workers: self.workers.max(1),
+            queue_capacity: self.queue_capacity.max(self.batch_size.max(1)),
+        }
+    }
+}
+
+#[derive(Default)]
+struct IngestionCounters {
+    queued: AtomicU64,
+    written: AtomicU64,
+    dropped: AtomicU64,
+    failed: AtomicU64,
+    batches: AtomicU64,
+    /// Time from a request being logged to its batch being written, for the last batch
+    last_lag_ms: AtomicU64,
+    queue_capacity: AtomicU64,
+    last_drop_warning: std::sync::Mutex<Option<Instant>>,
+}
+
+/// State of proxy log ingestion since the server started
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct ProxyLogIngestionStats {
+    /// Logs waiting to be written
+    pub queued: u64,
+    pub queue_capacity: u64,
+    pub written: u64,
+    /// Logs dropped because the queue was full
+    pub dropped: u64,
+    /// Logs lost to failed writes
+    pub failed: u64,
+    pub batches: u64,
+    /// How long the logs of the last batch waited to be written, in milliseconds
+    pub lag_ms: u64,
+}
+
+impl ProxyLogIngestionStats {
+    pub fn current() -> Self {
+        let counters = &*INGESTION_COUNTERS;
+        Self {
+            queued: counters.queued.load(Ordering::Relaxed),
+            queue_capacity: counters.queue_capacity.load(Ordering::Relaxed),
+            written: counters.written.load(Ordering::Relaxed),
+            dropped: counters.dropped.load(Ordering::Relaxed),
+            failed: counters.failed.load(Ordering::Relaxed),
+            batches: counters.batches.load(Ordering::Relaxed),
+            lag_ms: counters.last_lag_ms.load(Ordering::Relaxed),
+        }
+    }
+}
+
+struct QueuedLog {
+    request: CreateProxyLogRequest,
+    /// Time of the request, as stored
+    logged_at: UtcDateTime,
+    queued_at: Instant,
+}
+
+/// Queue in front of the proxy log writers
+pub struct ProxyLogIngester {
+    sender: mpsc::Sender<QueuedLog>,
+}
+
+impl ProxyLogIngester {
+    /// Start the writers; must be called from within a Tokio runtime
+    pub fn start(service: Arc<ProxyLogService>, config: ProxyLogIngestionConfig) -> Self {
+        let config = config.normalized();
+        let (sender, receiver) = mpsc::channel(config.queue_capacity);
+        let receiver = Arc::new(Mutex::new(receiver));
+        INGESTION_COUNTERS
+            .queue_capacity
+            .store(config.queue_capacity as u64, Ordering::Relaxed);
+
+        for worker in 0..config.workers {
+            let service = service.clone();
+            let receiver = receiver.clone();
+            let config = config.clone();
+            tokio::spawn(async move {
+                debug!("Starting proxy log writer {}", worker);
+                while let Some(batch) =
+                    next_batch(&receiver, config.batch_size, config.flush_interval).await
+                {
+                    write_batch(&service, batch).await;
+                }
+            });
+        }
+        Self { sender }
+    }
+
+    /// Queue a log to be written, dropping it if the queue is full
+    pub fn enqueue(&self, request: CreateProxyLogRequest) {
+        let counters = &*INGESTION_COUNTERS;
+        let log = QueuedLog {
+            request,
+            logged_at: Utc::now(),
+            queued_at: Instant::now(),
+        };
+        match self.sender.try_send(log) {
+            Ok(()) => {
+                counters.queued.fetch_add(1, Ordering::Relaxed);
+            }
+            Err(_) => {
+                let dropped = counters.dropped.fetch_add(1, Ordering::Relaxed) + 1;
+                let mut last_warning = counters
+                    .last_drop_warning
+                    .lock()
+                    .unwrap_or_else(|e| e.into_inner());
+                if last_warning.map_or(true, |at| at.elapsed() >= DROP_WARNING_INTERVAL) {
+                    *last_warning = Some(Instant::now());
+                    warn!(
+                        "Proxy log queue is full, dropping logs ({} dropped so far); \
+                         the database isn't keeping up, consider more writers or larger batches",
+                        dropped
+                    );
+                }
+            }
+        }
+    }
+}
+
+/// Wait for the next batch: up to `batch_size` logs, collected for at most
+/// `flush_interval` after the first one arrives. `None` once the queue is closed.
+async fn next_batch(
+    receiver: &Mutex<mpsc::Receiver<QueuedLog>>,
+    batch_size: usize,
+    flush_interval: Duration,
+) -> Option<Vec<QueuedLog>> {
+    // One writer collects at a time; the others write their batches meanwhile
+    let mut receiver = receiver.lock().await;
+    let first = receiver.recv().await?;
+    let deadline = tokio::time::Instant::now() + flush_interval;
+    let mut batch = Vec::with_capacity(batch_size);
+    batch.push(first);
+    while batch.len() < batch_size {
+        match tokio::time::timeout_at(deadline, receiver.recv()).await {
+            Ok(Some(log)) => batch.push(log),
+            Ok(None) | Err(_) => break,
+        }
+    }
+    Some(batch)
+}
+
+async fn write_batch(service: &ProxyLogService, batch: Vec<QueuedLog>) {
+    let counters = &*INGESTION_COUNTERS;
+    let count = batch.len() as u64;
+    counters.queued.fetch_sub(count, Ordering::Relaxed);
+    let oldest = batch.iter().map(|log| log.queued_at).min();
+
+    let requests = batch
+        .into_iter()
+        .map(|log| (log.request, log.logged_at))
+        .collect();
+    match service.create_many(requests).await {
+        Ok(()) => {
+            counters.written.fetch_add(count, Ordering::Relaxed);
+        }
+        Err(e) => {
+            counters.failed.fetch_add(count, Ordering::Relaxed);
+            warn!("Failed to write {} proxy logs: {:?}", count, e);
+        }
+    }
+    counters.batches.fetch_add(1, Ordering::Relaxed);
+    if let Some(oldest) = oldest {
+        counters
+            .last_lag_ms
+            .store(oldest.elapsed().as_millis() as u64, Ordering::Relaxed);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn queued() -> QueuedLog {
+        QueuedLog {
+            request: CreateProxyLogRequest {
+                method: "GET".to_string(),
+                path: "/".to_string(),
+                query_string: None,
+                host: "example.com".to_string(),
+                status_code: 200,
+                response_time_ms: Some(3),
+                request_source: "proxy".to_string(),
+                is_system_request: false,
+                routing_status: "routed".to_string(),
+                project_id: None,
+                environment_id: None,
+                deployment_id: None,
+                session_id: None,
+                visitor_id: None,
+                container_id: None,
+                upstream_host: None,
+                error_message: None,
+                client_ip: None,
+                user_agent: None,
+                referrer: None,
+                request_id: "req".to_string(),
+                ip_geolocation_id: None,
+                browser: None,
+                browser_version: None,
+                operating_system: None,
+                device_type: None,
+                is_bot: None,
+                bot_name: None,
+                request_size_bytes: None,
+                response_size_bytes: None,
+                cache_status: None,
+                request_headers: None,
+                response_headers: None,
+            },
+            logged_at: Utc::now(),
+            queued_at: Instant::now(),
+        }
+    }
+
+    #[tokio::test]
+    async fn test_next_batch_flushes_when_full() {
+        let (sender, receiver) = mpsc::channel(10);
+        let receiver = Mutex::new(receiver);
+        for _ in 0..5 {
+            sender.try_send(queued()).unwrap();
+        }
+
+        let batch = next_batch(&receiver, 3, Duration::from_secs(60))
+            .await
+            .unwrap();
+        assert_eq!(batch.len(), 3);
+        let batch = next_batch(&receiver, 3, Duration::from_millis(10))
+            .await
+            .unwrap();
+        assert_eq!(batch.len(), 2);
+    }
+
+    #[tokio::test]
+    async fn test_next_batch_ends_when_queue_closes() {
+        let (sender, receiver) = mpsc::channel(10);
+        let receiver = Mutex::new(receiver);
+        sender.try_send(queued()).unwrap();
+        drop(sender);
+
+        assert_eq!(
+            next_batch(&receiver, 3, Duration::from_secs(60))
+                .await
+                .unwrap()
+                .len(),
+            1
+        );
+        assert!(next_batch(&receiver, 3, Duration::from_secs(60))
+            .await
+            .is_none());
+    }
+
+    #[test]
+    fn test_config_is_normalized() {
+        let config = ProxyLogIngestionConfig {
+            batch_size: 0,
+            flush_interval: Duration::ZERO,
+            workers: 0,
+            queue_capacity: 0,
+        }
+        .normalized();
+        assert_eq!(config.batch_size, 1);
+        assert_eq!(config.workers, 1);
+        assert_eq!(config.queue_capacity, 1);
+        assert!(config.flush_interval > Duration::ZERO);
+    }
+}
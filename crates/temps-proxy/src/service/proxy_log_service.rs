@@ -1,12 +1,16 @@
 use chrono::Utc;
 use sea_orm::*;
 use serde::{Deserialize, Serialize};
-use std::sync::Arc;
+use std::sync::{Arc, OnceLock};
+use temps_core::pagination::{CursorPage, ListRequest, ListSpec, Sort};
 use temps_core::UtcDateTime;
+use temps_database::queries::{list_select, page_from_models, ListField, ListFieldKind};
 use temps_entities::proxy_logs;
 use thiserror::Error;
 use utoipa::ToSchema;
 
+use super::proxy_log_ingestion::{ProxyLogIngester, ProxyLogIngestionConfig};
+
 #[derive(Error, Debug)]
 pub enum ProxyLogServiceError {
     #[error("Database error")]
@@ -16,6 +20,78 @@ pub enum ProxyLogServiceError {
     InvalidFilter(String),
 }
 
+fn latest_first() -> Sort {
+    Sort::desc("timestamp")
+}
+
+/// Sorting and filtering of the proxy log list
+pub const PROXY_LOG_LIST: ListSpec = ListSpec {
+    sort_fields: &["timestamp", "response_time_ms", "status_code", "id"],
+    filter_fields: &[
+        "timestamp",
+        "status_code",
+        "response_time_ms",
+        "method",
+        "host",
+        "path",
+        "routing_status",
+        "request_source",
+        "project_id",
+        "environment_id",
+        "deployment_id",
+        "is_bot",
+    ],
+    default_sort: latest_first,
+};
+
+const PROXY_LOG_FIELDS: [ListField<proxy_logs::Column>; 13] = [
+    ListField::new(
+        "timestamp",
+        proxy_logs::Column::Timestamp,
+        ListFieldKind::Timestamp,
+    ),
+    ListField::new(
+        "response_time_ms",
+        proxy_logs::Column::ResponseTimeMs,
+        ListFieldKind::Integer,
+    ),
+    ListField::new(
+        "status_code",
+        proxy_logs::Column::StatusCode,
+        ListFieldKind::Integer,
+    ),
+    ListField::new("id", proxy_logs::Column::Id, ListFieldKind::Integer),
+    ListField::new("method", proxy_logs::Column::Method, ListFieldKind::Text),
+    ListField::new("host", proxy_logs::Column::Host, ListFieldKind::Text),
+    ListField::new("path", proxy_logs::Column::Path, ListFieldKind::Text),
+    ListField::new(
+        "routing_status",
+        proxy_logs::Column::RoutingStatus,
+        ListFieldKind::Text,
+    ),
+    ListField::new(
+        "request_source",
+        proxy_logs::Column::RequestSource,
+        ListFieldKind::Text,
+    ),
+    ListField::new(
+        "project_id",
+        proxy_logs::Column::ProjectId,
+        ListFieldKind::Integer,
+    ),
+    ListField::new(
+        "environment_id",
+        proxy_logs::Column::EnvironmentId,
+        ListFieldKind::Integer,
+    ),
+    ListField::new(
+        "deployment_id",
+        proxy_logs::Column::DeploymentId,
+        ListFieldKind::Integer,
+    ),
+    ListField::new("is_bot", proxy_logs::Column::IsBot, ListFieldKind::Bool),
+];
+
 /// Response model for proxy logs
 #[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
 pub struct ProxyLogResponse {
@@ -135,19 +211,72 @@ pub struct CreateProxyLogRequest {
 pub struct ProxyLogService {
     db: Arc<DatabaseConnection>,
     ip_service: Arc<temps_geo::IpAddressService>,
+    ingestion_config: ProxyLogIngestionConfig,
+    ingester: OnceLock<ProxyLogIngester>,
 }
 
 impl ProxyLogService {
     pub fn new(db: Arc<DatabaseConnection>, ip_service: Arc<temps_geo::IpAddressService>) -> Self {
-        Self { db, ip_service }
+        Self::with_ingestion_config(db, ip_service, ProxyLogIngestionConfig::default())
+    }
+
+    pub fn with_ingestion_config(
+        db: Arc<DatabaseConnection>,
+        ip_service: Arc<temps_geo::IpAddressService>,
+        ingestion_config: ProxyLogIngestionConfig,
+    ) -> Self {
+        Self {
+            db,
+            ip_service,
+            ingestion_config,
+            ingester: OnceLock::new(),
+        }
+    }
+
+    /// Queue a proxy log to be written in the background, in a batch
+    ///
+    /// The writers are started on first use, from the proxy's runtime. Never waits:
+    /// if the writers are behind and the queue is full, the log is dropped.
+    pub fn enqueue(self: &Arc<Self>, request: CreateProxyLogRequest) {
+        self.ingester
+            .get_or_init(|| ProxyLogIngester::start(self.clone(), self.ingestion_config.clone()))
+            .enqueue(request);
     }
 
     /// Create a new proxy log entry asynchronously
     pub async fn create(
         &self,
-        mut request: CreateProxyLogRequest,
+        request: CreateProxyLogRequest,
     ) -> Result<proxy_logs::Model, ProxyLogServiceError> {
-        let now = Utc::now();
+        let new_log = self.prepare(request, Utc::now()).await;
+        let result = new_log.insert(self.db.as_ref()).await?;
+        Ok(result)
+    }
+
+    /// Write proxy logs, each with the time it was logged at, in a single INSERT
+    pub async fn create_many(
+        &self,
+        requests: Vec<(CreateProxyLogRequest, UtcDateTime)>,
+    ) -> Result<(), ProxyLogServiceError> {
+        if requests.is_empty() {
+            return Ok(());
+        }
+        let mut logs = Vec::with_capacity(requests.len());
+        for (request, timestamp) in requests {
+            logs.push(self.prepare(request, timestamp).await);
+        }
+        proxy_logs::Entity::insert_many(logs)
+            .exec_without_returning(self.db.as_ref())
+            .await?;
+        Ok(())
+    }
+
+    /// Enrich a proxy log with geolocation, user agent and bot details
+    async fn prepare(
+        &self,
+        mut request: CreateProxyLogRequest,
+        now: UtcDateTime,
+    ) -> proxy_logs::ActiveModel {
         let created_date = now.date_naive();
 
         // Enrich with IP geolocation if not provided
@@ -187,7 +316,7 @@ impl ProxyLogService {
             }
         }
 
-        let new_log = proxy_logs::ActiveModel {
+        proxy_logs::ActiveModel {
             timestamp: Set(now),
             method: Set(request.method),
             path: Set(request.path),
@@ -224,10 +353,7 @@ impl ProxyLogService {
             response_headers: Set(request.response_headers),
             created_date: Set(created_date),
             ..Default::default()
-        };
-
-        let result = new_log.insert(self.db.as_ref()).await?;
-        Ok(result)
+        }
     }
 
     /// Get proxy logs with filters and pagination
@@ -239,6 +365,80 @@ impl ProxyLogService {
         page: u64,
         page_size: u64,
     ) -> Result<(Vec<proxy_logs::Model>, u64), ProxyLogServiceError> {
+        let sort_by = filters.sort_by.clone();
+        let sort_order = filters.sort_order.clone();
+        let mut query = Self::filtered_query(start_date, end_date, filters);
+
+        // Sorting - support both snake_case and alternative naming
+        let sort_col = match sort_by.as_deref() {
+            Some("timestamp") | None => proxy_logs::Column::Timestamp,
+            Some("response_time") | Some("response_time_ms") => proxy_logs::Column::ResponseTimeMs,
+            Some("status_code") => proxy_logs::Column::StatusCode,
+            Some("method") => proxy_logs::Column::Method,
+            Some("host") => proxy_logs::Column::Host,
+            Some("path") => proxy_logs::Column::Path,
+            Some("request_size") | Some("request_size_bytes") => {
+                proxy_logs::Column::RequestSizeBytes
+            }
+            Some("response_size") | Some("response_size_bytes") => {
+                proxy_logs::Column::ResponseSizeBytes
+            }
+            Some("client_ip") => proxy_logs::Column::ClientIp,
+            Some("routing_status") => proxy_logs::Column::RoutingStatus,
+            Some("project_id") => proxy_logs::Column::ProjectId,
+            Some("environment_id") => proxy_logs::Column::EnvironmentId,
+            Some("deployment_id") => proxy_logs::Column::DeploymentId,
+            Some("request_source") => proxy_logs::Column::RequestSource,
+            Some("browser") => proxy_logs::Column::Browser,
+            Some("operating_system") => proxy_logs::Column::OperatingSystem,
+            Some("device_type") => proxy_logs::Column::DeviceType,
+            Some("is_bot") => proxy_logs::Column::IsBot,
+            Some("is_system_request") => proxy_logs::Column::IsSystemRequest,
+            _ => proxy_logs::Column::Timestamp,
+        };
+
+        query = match sort_order.as_deref() {
+            Some("asc") => query.order_by_asc(sort_col),
+            _ => query.order_by_desc(sort_col),
+        };
+
+        let paginator = query.paginate(self.db.as_ref(), page_size);
+        let total = paginator.num_items().await?;
+        let items = paginator.fetch_page(page - 1).await?;
+
+        Ok((items, total))
+    }
+
+    /// A page of proxy logs matching both the query filters and the list request
+    pub async fn list_page(
+        &self,
+        start_date: Option<UtcDateTime>,
+        end_date: Option<UtcDateTime>,
+        filters: crate::handler::proxy_logs::ProxyLogsQuery,
+        request: &ListRequest,
+    ) -> Result<CursorPage<proxy_logs::Model>, ProxyLogServiceError> {
+        let query = list_select(
+            Self::filtered_query(start_date, end_date, filters),
+            request,
+            &PROXY_LOG_FIELDS,
+            proxy_logs::Column::Id,
+        )
+        .map_err(|e| ProxyLogServiceError::InvalidFilter(e.to_string()))?;
+        let rows = query.all(self.db.as_ref()).await?;
+        Ok(page_from_models(
+            rows,
+            request,
+            &PROXY_LOG_FIELDS,
+            proxy_logs::Column::Id,
+        ))
+    }
+
+    /// Proxy logs matching the filters of a list query
+    fn filtered_query(
+        start_date: Option<UtcDateTime>,
+        end_date: Option<UtcDateTime>,
+        filters: crate::handler::proxy_logs::ProxyLogsQuery,
+    ) -> Select<proxy_logs::Entity> {
         let mut query = proxy_logs::Entity::find();
 
         // Project/Environment/Deployment filters
@@ -360,44 +560,7 @@ impl ProxyLogService {
             }
         }
 
-        // Sorting - support both snake_case and alternative naming
-        let sort_col = match filters.sort_by.as_deref() {
-            Some("timestamp") | None => proxy_logs::Column::Timestamp,
-            Some("response_time") | Some("response_time_ms") => proxy_logs::Column::ResponseTimeMs,
-            Some("status_code") => proxy_logs::Column::StatusCode,
-            Some("method") => proxy_logs::Column::Method,
-            Some("host") => proxy_logs::Column::Host,
-            Some("path") => proxy_logs::Column::Path,
-            Some("request_size") | Some("request_size_bytes") => {
-                proxy_logs::Column::RequestSizeBytes
-            }
-            Some("response_size") | Some("response_size_bytes") => {
-                proxy_logs::Column::ResponseSizeBytes
-            }
-            Some("client_ip") => proxy_logs::Column::ClientIp,
-            Some("routing_status") => proxy_logs::Column::RoutingStatus,
-            Some("project_id") => proxy_logs::Column::ProjectId,
-            Some("environment_id") => proxy_logs::Column::EnvironmentId,
-            Some("deployment_id") => proxy_logs::Column::DeploymentId,
-            Some("request_source") => proxy_logs::Column::RequestSource,
-            Some("browser") => proxy_logs::Column::Browser,
-            Some("operating_system") => proxy_logs::Column::OperatingSystem,
-            Some("device_type") => proxy_logs::Column::DeviceType,
-            Some("is_bot") => proxy_logs::Column::IsBot,
-            Some("is_system_request") => proxy_logs::Column::IsSystemRequest,
-            _ => proxy_logs::Column::Timestamp,
-        };
-
-        query = match filters.sort_order.as_deref() {
-            Some("asc") => query.order_by_asc(sort_col),
-            _ => query.order_by_desc(sort_col),
-        };
-
-        let paginator = query.paginate(self.db.as_ref(), page_size);
-        let total = paginator.num_items().await?;
-        let items = paginator.fetch_page(page - 1).await?;
-
-        Ok((items, total))
+        query
     }
 
     /// Legacy method - kept for backward compatibility
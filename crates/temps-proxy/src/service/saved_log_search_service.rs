@@ -0,0 +1,811 @@
+//! Saved Log Searches
+//!
+//! Named proxy log searches shared by a project's team, and the searches each user ran
+//! recently. A search is the filters of the `/proxy-logs` endpoint plus a time range
+//! relative to when it runs (`15m`, `1h`, `7d`), so the same search can be run again
+//! during every incident. A saved search can be promoted to an alert: it is run every
+//! minute, fires a notification once it matches at least its threshold of requests
+//! and resolves once it matches fewer.
+
+use std::sync::Arc;
+
+use chrono::{Duration, Utc};
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, QueryOrder,
+    QuerySelect, Set,
+};
+use serde::{Deserialize, Serialize};
+use temps_core::notifications::{
+    NotificationData, NotificationPriority, NotificationService, NotificationType,
+};
+use temps_core::UtcDateTime;
+use temps_entities::{log_search_history, projects, saved_log_searches};
+use thiserror::Error;
+use tracing::{debug, error, info};
+use utoipa::ToSchema;
+
+use super::proxy_log_service::{ProxyLogResponse, ProxyLogService, ProxyLogServiceError};
+use crate::handler::proxy_logs::ProxyLogsQuery;
+
+/// Searches kept in each user's history of a project
+pub const RECENT_SEARCHES_KEPT: u64 = 20;
+
+/// Longest time range a search can cover
+pub const MAX_TIME_RANGE_DAYS: i64 = 30;
+
+/// How often alerting searches are run
+const ALERT_CHECK_INTERVAL: std::time::Duration = std::time::Duration::from_secs(60);
+
+/// Filters a search can't set: the project is the search's own, the dates come from
+/// its time range and paging from the request running it
+const RESERVED_FILTERS: [&str; 5] = ["project_id", "start_date", "end_date", "page", "page_size"];
+
+/// Alert severities
+const SEVERITIES: [&str; 3] = ["minor", "major", "critical"];
+
+#[derive(Error, Debug)]
+pub enum SavedLogSearchError {
+    #[error("{0}")]
+    NotFound(String),
+
+    #[error("{0}")]
+    InvalidInput(String),
+
+    #[error("Database error: {0}")]
+    DatabaseError(#[from] sea_orm::DbErr),
+
+    #[error("Search failed: {0}")]
+    SearchError(#[from] ProxyLogServiceError),
+}
+
+/// Request to save a search, or replace a saved one
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct SavedLogSearchRequest {
+    #[schema(example = "API errors")]
+    pub name: String,
+    #[serde(default)]
+    pub description: Option<String>,
+    /// Filters in the `/proxy-logs` query format, e.g. `{"path": "/api", "has_error": true}`;
+    /// project, dates and paging can't be set
+    #[schema(value_type = Object)]
+    pub filters: serde_json::Value,
+    /// Time range searched, back from when the search runs: minutes, hours or days
+    /// such as `15m`, `1h` or `7d` (at most 30 days)
+    #[schema(example = "1h")]
+    pub time_range: String,
+}
+
+/// Request to run a search without saving it
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct RunLogSearchRequest {
+    #[schema(value_type = Object)]
+    #[serde(default = "empty_filters")]
+    pub filters: serde_json::Value,
+    #[schema(example = "1h")]
+    pub time_range: String,
+    /// Page number (default: 1)
+    #[serde(default)]
+    pub page: Option<u64>,
+    /// Page size (default: 20, max: 100)
+    #[serde(default)]
+    pub page_size: Option<u64>,
+}
+
+fn empty_filters() -> serde_json::Value {
+    serde_json::Value::Object(Default::default())
+}
+
+/// Request to turn a saved search into an alert, or change its alert
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct LogSearchAlertRequest {
+    /// Fire when the search matches at least this many requests in its time range
+    #[schema(example = 50)]
+    pub threshold: i64,
+    /// Severity of the alert: minor, major or critical (default: major)
+    #[serde(default)]
+    pub severity: Option<String>,
+    #[serde(default = "default_enabled")]
+    pub enabled: bool,
+}
+
+fn default_enabled() -> bool {
+    true
+}
+
+/// Alert of a saved search and its current state
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct LogSearchAlertResponse {
+    pub threshold: i64,
+    pub severity: String,
+    pub enabled: bool,
+    pub firing: bool,
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub last_alerted_at: Option<UtcDateTime>,
+}
+
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct SavedLogSearchResponse {
+    pub id: i32,
+    pub project_id: i32,
+    pub name: String,
+    pub description: Option<String>,
+    #[schema(value_type = Object)]
+    pub filters: serde_json::Value,
+    pub time_range: String,
+    /// Set when the search is an alert
+    pub alert: Option<LogSearchAlertResponse>,
+    pub created_by: i32,
+    #[schema(value_type = String, format = "date-time")]
+    pub created_at: UtcDateTime,
+    #[schema(value_type = String, format = "date-time")]
+    pub updated_at: UtcDateTime,
+}
+
+impl From<saved_log_searches::Model> for SavedLogSearchResponse {
+    fn from(search: saved_log_searches::Model) -> Self {
+        let alert = search
+            .alert_threshold
+            .map(|threshold| LogSearchAlertResponse {
+                threshold,
+                severity: search
+                    .alert_severity
+                    .clone()
+                    .unwrap_or_else(|| "major".to_string()),
+                enabled: search.alert_enabled,
+                firing: search.alert_firing,
+                last_alerted_at: search.last_alerted_at,
+            });
+        Self {
+            id: search.id,
+            project_id: search.project_id,
+            name: search.name,
+            description: search.description,
+            filters: search.filters,
+            time_range: search.time_range,
+            alert,
+            created_by: search.created_by,
+            created_at: search.created_at,
+            updated_at: search.updated_at,
+        }
+    }
+}
+
+/// A search a user ran recently
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct RecentLogSearchResponse {
+    pub id: i32,
+    #[schema(value_type = Object)]
+    pub filters: serde_json::Value,
+    pub time_range: String,
+    /// Saved search that was run, if any
+    pub saved_search_id: Option<i32>,
+    #[schema(value_type = String, format = "date-time")]
+    pub searched_at: UtcDateTime,
+}
+
+impl From<log_search_history::Model> for RecentLogSearchResponse {
+    fn from(entry: log_search_history::Model) -> Self {
+        Self {
+            id: entry.id,
+            filters: entry.filters,
+            time_range: entry.time_range,
+            saved_search_id: entry.saved_search_id,
+            searched_at: entry.searched_at,
+        }
+    }
+}
+
+/// Logs a search matched, newest first unless its filters sort otherwise
+#[derive(Debug, Serialize, ToSchema)]
+pub struct LogSearchResultsResponse {
+    pub logs: Vec<ProxyLogResponse>,
+    pub total: u64,
+    pub page: u64,
+    pub page_size: u64,
+    pub total_pages: u64,
+    /// Time range the search covered
+    #[schema(value_type = String, format = "date-time")]
+    pub start_date: UtcDateTime,
+    #[schema(value_type = String, format = "date-time")]
+    pub end_date: UtcDateTime,
+}
+
+/// Change of an alert's state worth telling people about
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum LogAlertTransition {
+    Fired,
+    Resolved,
+}
+
+/// Parse a relative time range: a positive number of minutes, hours or days
+pub fn parse_time_range(time_range: &str) -> Result<Duration, String> {
+    let time_range = time_range.trim();
+    let invalid = || {
+        format!(
+            "Invalid time range '{}'; use minutes, hours or days such as 15m, 1h or 7d",
+            time_range
+        )
+    };
+    let split = time_range
+        .find(|c: char| !c.is_ascii_digit())
+        .ok_or_else(invalid)?;
+    let (amount, unit) = time_range.split_at(split);
+    let amount: i64 = amount.parse().map_err(|_| invalid())?;
+    let duration = match unit {
+        "m" => Duration::try_minutes(amount),
+        "h" => Duration::try_hours(amount),
+        "d" => Duration::try_days(amount),
+        _ => None,
+    }
+    .filter(|duration| *duration > Duration::zero())
+    .ok_or_else(invalid)?;
+    if duration > Duration::days(MAX_TIME_RANGE_DAYS) {
+        return Err(format!(
+            "A search can cover at most {} days",
+            MAX_TIME_RANGE_DAYS
+        ));
+    }
+    Ok(duration)
+}
+
+/// Check a search's filters and turn them into a `/proxy-logs` query on the project
+pub fn search_query(
+    project_id: i32,
+    filters: &serde_json::Value,
+) -> Result<ProxyLogsQuery, String> {
+    let Some(object) = filters.as_object() else {
+        return Err("Filters must be an object".to_string());
+    };
+    if let Some(key) = RESERVED_FILTERS
+        .iter()
+        .find(|key| object.contains_key(**key))
+    {
+        return Err(format!("Filter '{}' can't be set on a search", key));
+    }
+    let mut object = object.clone();
+    object.insert("project_id".to_string(), project_id.into());
+    serde_json::from_value(serde_json::Value::Object(object))
+        .map_err(|e| format!("Invalid filters: {}", e))
+}
+
+/// Whether an alert matching `matches` requests changes state
+pub fn alert_transition(firing: bool, matches: u64, threshold: i64) -> Option<LogAlertTransition> {
+    let breached = i64::try_from(matches).unwrap_or(i64::MAX) >= threshold;
+    match (firing, breached) {
+        (false, true) => Some(LogAlertTransition::Fired),
+        (true, false) => Some(LogAlertTransition::Resolved),
+        _ => None,
+    }
+}
+
+/// Saves, runs and alerts on proxy log searches
+pub struct SavedLogSearchService {
+    db: Arc<DatabaseConnection>,
+    proxy_log_service: Arc<ProxyLogService>,
+    notification_service: Option<Arc<dyn NotificationService>>,
+}
+
+impl SavedLogSearchService {
+    pub fn new(db: Arc<DatabaseConnection>, proxy_log_service: Arc<ProxyLogService>) -> Self {
+        Self {
+            db,
+            proxy_log_service,
+            notification_service: None,
+        }
+    }
+
+    /// Also send alerts through the notification providers
+    pub fn with_notification_service(
+        mut self,
+        notification_service: Arc<dyn NotificationService>,
+    ) -> Self {
+        self.notification_service = Some(notification_service);
+        self
+    }
+
+    async fn find_search(
+        &self,
+        project_id: i32,
+        search_id: i32,
+    ) -> Result<saved_log_searches::Model, SavedLogSearchError> {
+        saved_log_searches::Entity::find_by_id(search_id)
+            .filter(saved_log_searches::Column::ProjectId.eq(project_id))
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| SavedLogSearchError::NotFound("Saved search not found".to_string()))
+    }
+
+    pub async fn list(
+        &self,
+        project_id: i32,
+    ) -> Result<Vec<SavedLogSearchResponse>, SavedLogSearchError> {
+        let searches = saved_log_searches::Entity::find()
+            .filter(saved_log_searches::Column::ProjectId.eq(project_id))
+            .order_by_asc(saved_log_searches::Column::Name)
+            .all(self.db.as_ref())
+            .await?;
+        Ok(searches.into_iter().map(Into::into).collect())
+    }
+
+    pub async fn get(
+        &self,
+        project_id: i32,
+        search_id: i32,
+    ) -> Result<SavedLogSearchResponse, SavedLogSearchError> {
+        Ok(self.find_search(project_id, search_id).await?.into())
+    }
+
+    pub async fn create(
+        &self,
+        project_id: i32,
+        request: SavedLogSearchRequest,
+        user_id: i32,
+    ) -> Result<SavedLogSearchResponse, SavedLogSearchError> {
+        projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or_else(|| SavedLogSearchError::NotFound("Project not found".to_string()))?;
+        validate_request(project_id, &request)?;
+
+        let search = saved_log_searches::ActiveModel {
+            project_id: Set(project_id),
+            name: Set(request.name.trim().to_string()),
+            description: Set(request.description),
+            filters: Set(request.filters),
+            time_range: Set(request.time_range.trim().to_string()),
+            alert_enabled: Set(false),
+            alert_firing: Set(false),
+            created_by: Set(user_id),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        info!("Saved log search {} for project {}", search.id, project_id);
+        Ok(search.into())
+    }
+
+    /// Replace a saved search's name, filters and time range; its alert is kept
+    pub async fn update(
+        &self,
+        project_id: i32,
+        search_id: i32,
+        request: SavedLogSearchRequest,
+    ) -> Result<SavedLogSearchResponse, SavedLogSearchError> {
+        let search = self.find_search(project_id, search_id).await?;
+        validate_request(project_id, &request)?;
+
+        let mut active: saved_log_searches::ActiveModel = search.into();
+        active.name = Set(request.name.trim().to_string());
+        active.description = Set(request.description);
+        active.filters = Set(request.filters);
+        active.time_range = Set(request.time_range.trim().to_string());
+        let search = active.update(self.db.as_ref()).await?;
+        Ok(search.into())
+    }
+
+    pub async fn delete(&self, project_id: i32, search_id: i32) -> Result<(), SavedLogSearchError> {
+        let search = self.find_search(project_id, search_id).await?;
+        saved_log_searches::Entity::delete_by_id(search.id)
+            .exec(self.db.as_ref())
+            .await?;
+        Ok(())
+    }
+
+    /// Promote a saved search to an alert, or change its alert; disabling a firing
+    /// alert resolves it
+    pub async fn set_alert(
+        &self,
+        project_id: i32,
+        search_id: i32,
+        request: LogSearchAlertRequest,
+    ) -> Result<SavedLogSearchResponse, SavedLogSearchError> {
+        let search = self.find_search(project_id, search_id).await?;
+        if request.threshold < 1 {
+            return Err(SavedLogSearchError::InvalidInput(
+                "Alert threshold must be at least 1".to_string(),
+            ));
+        }
+        let severity = request.severity.unwrap_or_else(|| "major".to_string());
+        if !SEVERITIES.contains(&severity.as_str()) {
+            return Err(SavedLogSearchError::InvalidInput(
+                "Invalid severity. Must be one of: minor, major, critical".to_string(),
+            ));
+        }
+
+        let mut active: saved_log_searches::ActiveModel = search.into();
+        active.alert_threshold = Set(Some(request.threshold));
+        active.alert_severity = Set(Some(severity));
+        active.alert_enabled = Set(request.enabled);
+        if !request.enabled {
+            active.alert_firing = Set(false);
+        }
+        let search = active.update(self.db.as_ref()).await?;
+        Ok(search.into())
+    }
+
+    /// Turn an alert back into a plain saved search
+    pub async fn remove_alert(
+        &self,
+        project_id: i32,
+        search_id: i32,
+    ) -> Result<SavedLogSearchResponse, SavedLogSearchError> {
+        let search = self.find_search(project_id, search_id).await?;
+
+        let mut active: saved_log_searches::ActiveModel = search.into();
+        active.alert_threshold = Set(None);
+        active.alert_severity = Set(None);
+        active.alert_enabled = Set(false);
+        active.alert_firing = Set(false);
+        let search = active.update(self.db.as_ref()).await?;
+        Ok(search.into())
+    }
+
+    /// Run a saved search and add it to the user's recent searches
+    pub async fn run_saved(
+        &self,
+        project_id: i32,
+        search_id: i32,
+        user_id: i32,
+        page: Option<u64>,
+        page_size: Option<u64>,
+    ) -> Result<LogSearchResultsResponse, SavedLogSearchError> {
+        let search = self.find_search(project_id, search_id).await?;
+        let results = self
+            .search(
+                project_id,
+                &search.filters,
+                &search.time_range,
+                page,
+                page_size,
+            )
+            .await?;
+        self.record_search(
+            project_id,
+            user_id,
+            search.filters,
+            search.time_range,
+            Some(search.id),
+        )
+        .await;
+        Ok(results)
+    }
+
+    /// Run a search without saving it and add it to the user's recent searches
+    pub async fn run(
+        &self,
+        project_id: i32,
+        user_id: i32,
+        request: RunLogSearchRequest,
+    ) -> Result<LogSearchResultsResponse, SavedLogSearchError> {
+        let time_range = request.time_range.trim().to_string();
+        let results = self
+            .search(
+                project_id,
+                &request.filters,
+                &time_range,
+                request.page,
+                request.page_size,
+            )
+            .await?;
+        self.record_search(project_id, user_id, request.filters, time_range, None)
+            .await;
+        Ok(results)
+    }
+
+    /// The user's latest searches on the project, most recent first
+    pub async fn recent(
+        &self,
+        project_id: i32,
+        user_id: i32,
+    ) -> Result<Vec<RecentLogSearchResponse>, SavedLogSearchError> {
+        let entries = log_search_history::Entity::find()
+            .filter(log_search_history::Column::ProjectId.eq(project_id))
+            .filter(log_search_history::Column::UserId.eq(user_id))
+            .order_by_desc(log_search_history::Column::SearchedAt)
+            .limit(RECENT_SEARCHES_KEPT)
+            .all(self.db.as_ref())
+            .await?;
+        Ok(entries.into_iter().map(Into::into).collect())
+    }
+
+    async fn search(
+        &self,
+        project_id: i32,
+        filters: &serde_json::Value,
+        time_range: &str,
+        page: Option<u64>,
+        page_size: Option<u64>,
+    ) -> Result<LogSearchResultsResponse, SavedLogSearchError> {
+        let range = parse_time_range(time_range).map_err(SavedLogSearchError::InvalidInput)?;
+        let query = search_query(project_id, filters).map_err(SavedLogSearchError::InvalidInput)?;
+        let page = page.unwrap_or(1).max(1);
+        let page_size = page_size.unwrap_or(20).clamp(1, 100);
+        let end_date = Utc::now();
+        let start_date = end_date - range;
+
+        let (logs, total) = self
+            .proxy_log_service
+            .list_with_filters(Some(start_date), Some(end_date), query, page, page_size)
+            .await?;
+
+        Ok(LogSearchResultsResponse {
+            logs: logs.into_iter().map(ProxyLogResponse::from).collect(),
+            total,
+            page,
+            page_size,
+            total_pages: total.div_ceil(page_size),
+            start_date,
+            end_date,
+        })
+    }
+
+    /// Add a search to the user's history, keeping only the latest few; running the
+    /// latest search again only moves it up
+    async fn record_search(
+        &self,
+        project_id: i32,
+        user_id: i32,
+        filters: serde_json::Value,
+        time_range: String,
+        saved_search_id: Option<i32>,
+    ) {
+        if let Err(e) = self
+            .try_record_search(project_id, user_id, filters, time_range, saved_search_id)
+            .await
+        {
+            error!("Failed to record log search: {}", e);
+        }
+    }
+
+    async fn try_record_search(
+        &self,
+        project_id: i32,
+        user_id: i32,
+        filters: serde_json::Value,
+        time_range: String,
+        saved_search_id: Option<i32>,
+    ) -> Result<(), sea_orm::DbErr> {
+        let history = log_search_history::Entity::find()
+            .filter(log_search_history::Column::ProjectId.eq(project_id))
+            .filter(log_search_history::Column::UserId.eq(user_id))
+            .order_by_desc(log_search_history::Column::SearchedAt)
+            .all(self.db.as_ref())
+            .await?;
+
+        match history.first() {
+            Some(latest)
+                if latest.filters == filters
+                    && latest.time_range == time_range
+                    && latest.saved_search_id == saved_search_id =>
+            {
+                let mut active: log_search_history::ActiveModel = latest.clone().into();
+                active.searched_at = Set(Utc::now());
+                active.update(self.db.as_ref()).await?;
+                return Ok(());
+            }
+            _ => {}
+        }
+
+        log_search_history::ActiveModel {
+            project_id: Set(project_id),
+            user_id: Set(user_id),
+            filters: Set(filters),
+            time_range: Set(time_range),
+            saved_search_id: Set(saved_search_id),
+            searched_at: Set(Utc::now()),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        let expired: Vec<i32> = history
+            .iter()
+            .skip(RECENT_SEARCHES_KEPT as usize - 1)
+            .map(|entry| entry.id)
+            .collect();
+        if !expired.is_empty() {
+            log_search_history::Entity::delete_many()
+                .filter(log_search_history::Column::Id.is_in(expired))
+                .exec(self.db.as_ref())
+                .await?;
+        }
+        Ok(())
+    }
+
+    /// Run the alerting searches every minute
+    pub async fn start_scheduler(&self) {
+        info!("Log search alert scheduler started");
+        loop {
+            tokio::time::sleep(ALERT_CHECK_INTERVAL).await;
+            self.evaluate_alerts().await;
+        }
+    }
+
+    /// Run every enabled alert and fire or resolve the ones whose matches crossed
+    /// their threshold
+    pub async fn evaluate_alerts(&self) {
+        let searches = match saved_log_searches::Entity::find()
+            .filter(saved_log_searches::Column::AlertEnabled.eq(true))
+            .filter(saved_log_searches::Column::AlertThreshold.is_not_null())
+            .all(self.db.as_ref())
+            .await
+        {
+            Ok(searches) => searches,
+            Err(e) => {
+                error!("Failed to load log search alerts: {}", e);
+                return;
+            }
+        };
+
+        for search in searches {
+            if let Err(e) = self.evaluate_alert(search).await {
+                error!("Failed to evaluate log search alert: {}", e);
+            }
+        }
+    }
+
+    async fn evaluate_alert(
+        &self,
+        search: saved_log_searches::Model,
+    ) -> Result<(), SavedLogSearchError> {
+        let Some(threshold) = search.alert_threshold else {
+            return Ok(());
+        };
+        let matches = self
+            .search(
+                search.project_id,
+                &search.filters,
+                &search.time_range,
+                Some(1),
+                Some(1),
+            )
+            .await?
+            .total;
+        let Some(transition) = alert_transition(search.alert_firing, matches, threshold) else {
+            return Ok(());
+        };
+        debug!(
+            "Log search alert {} {:?} with {} matches",
+            search.id, transition, matches
+        );
+
+        self.notify(&search, transition, matches, threshold).await;
+
+        let mut active: saved_log_searches::ActiveModel = search.into();
+        active.alert_firing = Set(transition == LogAlertTransition::Fired);
+        if transition == LogAlertTransition::Fired {
+            active.last_alerted_at = Set(Some(Utc::now()));
+        }
+        active.update(self.db.as_ref()).await?;
+        Ok(())
+    }
+
+    async fn notify(
+        &self,
+        search: &saved_log_searches::Model,
+        transition: LogAlertTransition,
+        matches: u64,
+        threshold: i64,
+    ) {
+        let Some(notification_service) = &self.notification_service else {
+            return;
+        };
+
+        let severity = search
+            .alert_severity
+            .clone()
+            .unwrap_or_else(|| "major".to_string());
+        let (title, message, notification_type, priority) = match transition {
+            LogAlertTransition::Fired => (
+                format!("{}: {} matching requests", search.name, matches),
+                format!(
+                    "The saved log search \"{}\" matched {} requests in the last {}, at or \
+                     above its alert threshold of {}.",
+                    search.name, matches, search.time_range, threshold
+                ),
+                NotificationType::Alert,
+                match severity.as_str() {
+                    "critical" => NotificationPriority::Critical,
+                    "minor" => NotificationPriority::Normal,
+                    _ => NotificationPriority::High,
+                },
+            ),
+            LogAlertTransition::Resolved => (
+                format!("Resolved: {}", search.name),
+                format!(
+                    "The saved log search \"{}\" matched {} requests in the last {}, below \
+                     its alert threshold of {}.",
+                    search.name, matches, search.time_range, threshold
+                ),
+                NotificationType::Info,
+                NotificationPriority::Normal,
+            ),
+        };
+
+        let mut metadata = std::collections::HashMap::new();
+        metadata.insert("project_id".to_string(), search.project_id.to_string());
+        metadata.insert("saved_search_id".to_string(), search.id.to_string());
+        metadata.insert("matches".to_string(), matches.to_string());
+
+        let notification = NotificationData {
+            id: temps_core::uuid::Uuid::new_v4().to_string(),
+            title,
+            message,
+            notification_type,
+            priority,
+            severity: Some(severity),
+            timestamp: Utc::now(),
+            metadata,
+            bypass_throttling: false,
+        };
+        if let Err(e) = notification_service.send_notification(notification).await {
+            error!(
+                "Failed to send log search alert for search {}: {}",
+                search.id, e
+            );
+        }
+    }
+}
+
+fn validate_request(
+    project_id: i32,
+    request: &SavedLogSearchRequest,
+) -> Result<(), SavedLogSearchError> {
+    if request.name.trim().is_empty() {
+        return Err(SavedLogSearchError::InvalidInput(
+            "Search name is required".to_string(),
+        ));
+    }
+    parse_time_range(&request.time_range).map_err(SavedLogSearchError::InvalidInput)?;
+    search_query(project_id, &request.filters).map_err(SavedLogSearchError::InvalidInput)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_parse_time_range() {
+        assert_eq!(parse_time_range("15m").unwrap(), Duration::minutes(15));
+        assert_eq!(parse_time_range(" 1h ").unwrap(), Duration::hours(1));
+        assert_eq!(parse_time_range("30d").unwrap(), Duration::days(30));
+        for invalid in ["", "h", "0m", "1w", "1.5h", "-1h", "31d", "1h30m"] {
+            assert!(parse_time_range(invalid).is_err(), "{}", invalid);
+        }
+    }
+
+    #[test]
+    fn test_search_query_uses_the_proxy_log_filters() {
+        let query = search_query(
+            7,
+            &json!({"path": "/api", "status_code": 502, "has_error": true}),
+        )
+        .unwrap();
+        assert_eq!(query.project_id, Some(7));
+        assert_eq!(query.path.as_deref(), Some("/api"));
+        assert_eq!(query.status_code, Some(502));
+        assert_eq!(query.has_error, Some(true));
+
+        assert!(search_query(7, &json!({"project_id": 8})).is_err());
+        assert!(search_query(7, &json!({"page": 2})).is_err());
+        assert!(search_query(7, &json!({"status_code": "five hundred"})).is_err());
+        assert!(search_query(7, &json!(["path"])).is_err());
+    }
+
+    #[test]
+    fn test_alert_transition() {
+        assert_eq!(
+            alert_transition(false, 50, 50),
+            Some(LogAlertTransition::Fired)
+        );
+        assert_eq!(alert_transition(false, 49, 50), None);
+        assert_eq!(alert_transition(true, 60, 50), None);
+        assert_eq!(
+            alert_transition(true, 10, 50),
+            Some(LogAlertTransition::Resolved)
+        );
+    }
+}
@@ -5,6 +5,7 @@ use crate::traits::*;
 use async_trait::async_trait;
 use cookie::Cookie;
 use pingora_core::{upstreams::peer::HttpPeer, Result as PingoraResult};
+use pingora_http::RequestHeader;
 use sea_orm::*;
 use std::sync::Arc;
 use temps_database::DbConnection;
@@ -141,6 +142,21 @@ impl UpstreamResolver for UpstreamResolverImpl {
     async fn get_lb_strategy(&self, _host: &str) -> Option<String> {
         Some("round_robin".to_string())
     }
+
+    async fn resolve_environment_peer(&self, environment_id: i32) -> PingoraResult<Box<HttpPeer>> {
+        let Some(route_info) = self.route_table.get_environment_route(environment_id) else {
+            return Err(pingora_core::Error::explain(
+                pingora_core::ErrorType::ConnectNoRoute,
+                format!("No active deployment for environment {}", environment_id),
+            ));
+        };
+        let backend_addr = route_info.get_backend_addr();
+        debug!(
+            "Path rule route to environment {} -> {}",
+            environment_id, backend_addr
+        );
+        Ok(Box::new(HttpPeer::new(backend_addr, false, "".to_string())))
+    }
 }
 
 /// Implementation of RequestLogger trait
@@ -407,6 +423,74 @@ impl ProjectContextResolver for ProjectContextResolverImpl {
         let route_info = self.route_table.get_route(host)?;
         route_info.static_dir().map(|s| s.to_string())
     }
+
+    async fn get_canonical_host(&self, host: &str) -> Option<String> {
+        self.route_table.get_route(host)?.canonical_host
+    }
+
+    async fn should_force_https(&self, host: &str) -> bool {
+        // Hosts without a route keep the default HTTPS redirect
+        self.route_table
+            .get_route(host)
+            .map(|route_info| route_info.force_https)
+            .unwrap_or(true)
+    }
+
+    async fn has_certificate(&self, host: &str) -> bool {
+        self.route_table.has_certificate(host)
+    }
+
+    async fn resolve_path(&self, host: &str, path: &str) -> Option<PathResolution> {
+        let unchanged = PathResolution {
+            path: path.to_string(),
+            environment_id: None,
+        };
+        let Some(route_info) = self.route_table.get_route(host) else {
+            return Some(unchanged);
+        };
+        if route_info.path_rules.is_empty() {
+            return Some(unchanged);
+        }
+
+        let rule = route_info.path_rules.match_rule(path)?;
+        let own_environment = route_info.environment.as_ref().map(|env| env.id);
+        Some(PathResolution {
+            path: rule.apply(path),
+            environment_id: rule
+                .environment_id
+                .filter(|id| Some(*id) != own_environment),
+        })
+    }
+
+    async fn resolve_routing_rule(&self, host: &str, request: &RequestHeader) -> Option<i32> {
+        let route_info = self.route_table.get_route(host)?;
+        let environment = route_info.environment.as_ref()?;
+        let rule = environment.routing_rules.match_rule(host, |name| {
+            request
+                .headers
+                .get(name)
+                .and_then(|value| value.to_str().ok())
+        })?;
+        Some(rule.environment_id).filter(|id| *id != environment.id)
+    }
+
+    async fn resolve_environment_context(&self, environment_id: i32) -> Option<ProjectContext> {
+        let route_info = self.route_table.get_environment_route(environment_id)?;
+        Some(ProjectContext {
+            project: route_info.project?,
+            environment: route_info.environment?,
+            deployment: route_info.deployment?,
+        })
+    }
+
+    async fn get_environment_static_path(&self, environment_id: i32) -> Option<String> {
+        let route_info = self.route_table.get_environment_route(environment_id)?;
+        route_info.static_dir().map(|s| s.to_string())
+    }
+
+    fn track_connection(&self, deployment_id: i32) -> Option<temps_routes::ConnectionGuard> {
+        Some(self.route_table.connections().track(deployment_id))
+    }
 }
 
 /// Implementation of VisitorManager trait
@@ -1,5 +1,6 @@
 use async_trait::async_trait;
 use pingora_core::{upstreams::peer::HttpPeer, Result as PingoraResult};
+use pingora_http::RequestHeader;
 use serde::{Deserialize, Serialize};
 use std::sync::Arc;
 use temps_core::UtcDateTime;
@@ -13,6 +14,15 @@ pub struct ProjectContext {
     pub deployment: Arc<deployments::Model>,
 }
 
+/// Where a host's path rules send a request
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PathResolution {
+    /// Path to send upstream
+    pub path: String,
+    /// Environment serving the request when it isn't the host's own
+    pub environment_id: Option<i32>,
+}
+
 /// Visitor information
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct Visitor {
@@ -98,6 +108,15 @@ pub trait UpstreamResolver: Send + Sync {
 
     /// Get load balancing strategy for a host (for future use)
     async fn get_lb_strategy(&self, host: &str) -> Option<String>;
+
+    /// Resolve the upstream peer of an environment's current deployment, for requests a
+    /// custom domain's path rules send to another environment
+    async fn resolve_environment_peer(&self, environment_id: i32) -> PingoraResult<Box<HttpPeer>> {
+        Err(pingora_core::Error::explain(
+            pingora_core::ErrorType::ConnectNoRoute,
+            format!("No route to environment {}", environment_id),
+        ))
+    }
 }
 
 /// Trait for logging request/response data
@@ -137,6 +156,54 @@ pub trait ProjectContextResolver: Send + Sync {
 
     /// Get static file path for a host (if it serves static files)
     async fn get_static_path(&self, host: &str) -> Option<String>;
+
+    /// Get the host a request should be redirected to (apex ↔ www), if any
+    async fn get_canonical_host(&self, _host: &str) -> Option<String> {
+        None
+    }
+
+    /// Whether plain HTTP requests for this host may be redirected to HTTPS; custom
+    /// domains can opt out
+    async fn should_force_https(&self, _host: &str) -> bool {
+        true
+    }
+
+    /// Whether an active certificate covers the host, so services in the `auto` HTTPS
+    /// mode are redirected to HTTPS
+    async fn has_certificate(&self, _host: &str) -> bool {
+        true
+    }
+
+    /// Apply the host's path rules to a request path, or None when the host
+    /// doesn't serve this path
+    async fn resolve_path(&self, _host: &str, path: &str) -> Option<PathResolution> {
+        Some(PathResolution {
+            path: path.to_string(),
+            environment_id: None,
+        })
+    }
+
+    /// Environment the host's routing rules send a request to, or None when the
+    /// host's own environment serves it
+    async fn resolve_routing_rule(&self, _host: &str, _request: &RequestHeader) -> Option<i32> {
+        None
+    }
+
+    /// Resolve the project context of an environment's current deployment
+    async fn resolve_environment_context(&self, _environment_id: i32) -> Option<ProjectContext> {
+        None
+    }
+
+    /// Get the static directory of an environment's current deployment, if it is static
+    async fn get_environment_static_path(&self, _environment_id: i32) -> Option<String> {
+        None
+    }
+
+    /// Count a connection forwarded to a deployment until the guard is dropped, so a
+    /// draining deployment can report what it still serves
+    fn track_connection(&self, _deployment_id: i32) -> Option<temps_routes::ConnectionGuard> {
+        None
+    }
 }
 
 /// Trait for managing visitors
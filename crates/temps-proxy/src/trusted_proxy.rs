@@ -0,0 +1,242 @@
+//! Client address and scheme resolution behind trusted upstream proxies
+//!
+//! When Temps runs behind a CDN or load balancer, the TCP peer is the upstream proxy
+//! and the real client is only known from `X-Forwarded-For`. Those headers are honored
+//! only when the peer is a configured trusted proxy; otherwise anyone could claim any
+//! address or scheme.
+
+use std::net::{IpAddr, SocketAddr};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+use ipnet::IpNet;
+use parking_lot::RwLock;
+use temps_core::TrustedProxySettings;
+use tracing::warn;
+
+/// How long trusted proxy settings are cached before being re-read
+const SETTINGS_TTL: Duration = Duration::from_secs(30);
+
+/// Trusted upstream proxy networks, parsed from settings
+#[derive(Debug, Clone, Default)]
+pub struct TrustedProxies {
+    networks: Vec<IpNet>,
+}
+
+impl TrustedProxies {
+    /// Parse the trusted networks, skipping entries that aren't an IP or CIDR range
+    pub fn from_settings(settings: &TrustedProxySettings) -> Self {
+        let networks = settings
+            .trusted_cidrs
+            .iter()
+            .filter_map(|entry| {
+                let network = parse_network(entry.trim());
+                if network.is_none() {
+                    warn!("Ignoring invalid trusted proxy address '{}'", entry);
+                }
+                network
+            })
+            .collect();
+        Self { networks }
+    }
+
+    pub fn is_trusted(&self, ip: IpAddr) -> bool {
+        let ip = ip.to_canonical();
+        self.networks.iter().any(|network| network.contains(&ip))
+    }
+
+    /// Resolve the client address of a request received from `peer`
+    ///
+    /// `X-Forwarded-For` is walked from the right, skipping trusted hops; the first
+    /// untrusted address is the client. Entries left of it were supplied by the client
+    /// and can't be trusted. From an untrusted peer the header is ignored entirely.
+    pub fn client_ip(&self, peer: IpAddr, forwarded_for: &[&str]) -> IpAddr {
+        let mut client = peer.to_canonical();
+        if !self.is_trusted(client) {
+            return client;
+        }
+
+        let hops = forwarded_for
+            .iter()
+            .flat_map(|value| value.split(','))
+            .map(str::trim)
+            .filter(|hop| !hop.is_empty());
+        for hop in hops.rev() {
+            let Some(ip) = parse_forwarded_ip(hop) else {
+                // A malformed hop ends the chain we can vouch for
+                break;
+            };
+            client = ip;
+            if !self.is_trusted(ip) {
+                break;
+            }
+        }
+        client
+    }
+
+    /// Scheme the client used, as reported by a trusted peer in `X-Forwarded-Proto`
+    ///
+    /// Returns `None` when the peer isn't trusted or the header is missing, in which
+    /// case the scheme of the connection itself applies.
+    pub fn forwarded_https(&self, peer: IpAddr, forwarded_proto: Option<&str>) -> Option<bool> {
+        if !self.is_trusted(peer) {
+            return None;
+        }
+        // With several proxies each appends its own; the first is the client's scheme
+        let proto = forwarded_proto?.split(',').next()?.trim();
+        match proto.to_ascii_lowercase().as_str() {
+            "https" => Some(true),
+            "http" => Some(false),
+            _ => None,
+        }
+    }
+}
+
+/// Trusted proxy settings cached for the proxy's request path
+pub struct TrustedProxyCache {
+    config_service: Arc<temps_config::ConfigService>,
+    cached: RwLock<Option<(Instant, Arc<TrustedProxies>)>>,
+}
+
+impl TrustedProxyCache {
+    pub fn new(config_service: Arc<temps_config::ConfigService>) -> Self {
+        Self {
+            config_service,
+            cached: RwLock::new(None),
+        }
+    }
+
+    /// Current trusted proxies, re-read from settings at most every 30 seconds
+    pub async fn get(&self) -> Arc<TrustedProxies> {
+        if let Some((loaded_at, proxies)) = self.cached.read().as_ref() {
+            if loaded_at.elapsed() < SETTINGS_TTL {
+                return proxies.clone();
+            }
+        }
+
+        let proxies = match self.config_service.get_settings().await {
+            Ok(settings) => Arc::new(TrustedProxies::from_settings(&settings.trusted_proxies)),
+            Err(e) => {
+                warn!("Failed to load trusted proxy settings: {}", e);
+                // Keep using the last known settings rather than trusting nobody
+                if let Some((_, proxies)) = self.cached.read().as_ref() {
+                    return proxies.clone();
+                }
+                return Arc::new(TrustedProxies::default());
+            }
+        };
+        *self.cached.write() = Some((Instant::now(), proxies.clone()));
+        proxies
+    }
+}
+
+fn parse_network(value: &str) -> Option<IpNet> {
+    if value.contains('/') {
+        value.parse::<IpNet>().ok().map(|network| network.trunc())
+    } else {
+        value.parse::<IpAddr>().ok().map(IpNet::from)
+    }
+}
+
+/// Parse an `X-Forwarded-For` hop, which some proxies send with a port
+fn parse_forwarded_ip(hop: &str) -> Option<IpAddr> {
+    hop.parse::<IpAddr>()
+        .or_else(|_| hop.parse::<SocketAddr>().map(|addr| addr.ip()))
+        .ok()
+        .map(|ip| ip.to_canonical())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn proxies(cidrs: &[&str]) -> TrustedProxies {
+        TrustedProxies::from_settings(&TrustedProxySettings {
+            trusted_cidrs: cidrs.iter().map(|c| c.to_string()).collect(),
+            tls_terminated_upstream: false,
+        })
+    }
+
+    fn ip(value: &str) -> IpAddr {
+        value.parse().unwrap()
+    }
+
+    #[test]
+    fn test_untrusted_peer_ignores_forwarded_headers() {
+        let proxies = proxies(&["10.0.0.0/8"]);
+        let peer = ip("203.0.113.7");
+
+        assert_eq!(proxies.client_ip(peer, &["1.2.3.4"]), peer);
+        assert_eq!(proxies.forwarded_https(peer, Some("https")), None);
+    }
+
+    #[test]
+    fn test_trusted_peer_resolves_client_from_right() {
+        let proxies = proxies(&["10.0.0.0/8", "173.245.48.0/20"]);
+        let peer = ip("10.0.0.2");
+
+        // Client-supplied "6.6.6.6" is left of the real client and ignored
+        assert_eq!(
+            proxies.client_ip(peer, &["6.6.6.6, 198.51.100.9", "173.245.48.1"]),
+            ip("198.51.100.9")
+        );
+        assert_eq!(proxies.client_ip(peer, &[]), peer);
+    }
+
+    #[test]
+    fn test_all_trusted_hops_use_leftmost() {
+        let proxies = proxies(&["10.0.0.0/8"]);
+        assert_eq!(
+            proxies.client_ip(ip("10.0.0.2"), &["10.1.1.1, 10.2.2.2"]),
+            ip("10.1.1.1")
+        );
+    }
+
+    #[test]
+    fn test_malformed_hop_stops_chain() {
+        let proxies = proxies(&["10.0.0.0/8"]);
+        assert_eq!(
+            proxies.client_ip(ip("10.0.0.2"), &["198.51.100.9, garbage, 10.3.3.3"]),
+            ip("10.3.3.3")
+        );
+    }
+
+    #[test]
+    fn test_ipv6_and_ports() {
+        let proxies = proxies(&["2001:db8::/32", "10.0.0.1"]);
+
+        assert!(proxies.is_trusted(ip("2001:db8::5")));
+        // IPv4-mapped peers from a dual-stack listener match IPv4 ranges
+        assert!(proxies.is_trusted(ip("::ffff:10.0.0.1")));
+        assert!(!proxies.is_trusted(ip("10.0.0.2")));
+        assert_eq!(
+            proxies.client_ip(ip("2001:db8::5"), &["[2001:db9::1]:4711"]),
+            ip("2001:db9::1")
+        );
+        assert_eq!(
+            proxies.client_ip(ip("10.0.0.1"), &["198.51.100.9:443"]),
+            ip("198.51.100.9")
+        );
+    }
+
+    #[test]
+    fn test_forwarded_proto_from_trusted_peer() {
+        let proxies = proxies(&["10.0.0.0/8"]);
+        let peer = ip("10.0.0.2");
+
+        assert_eq!(proxies.forwarded_https(peer, Some("HTTPS")), Some(true));
+        assert_eq!(
+            proxies.forwarded_https(peer, Some("http, https")),
+            Some(false)
+        );
+        assert_eq!(proxies.forwarded_https(peer, Some("ws")), None);
+        assert_eq!(proxies.forwarded_https(peer, None), None);
+    }
+
+    #[test]
+    fn test_invalid_entries_are_skipped() {
+        let proxies = proxies(&["not-an-ip", "10.0.0.0/33", "192.168.1.7/24"]);
+        assert_eq!(proxies.networks.len(), 1);
+        assert!(proxies.is_trusted(ip("192.168.1.200")));
+    }
+}
@@ -584,6 +584,11 @@ mod tests {
             tag: None,
             commit: "abc123def456".to_string(),
             project_id: 123,
+            environment_id: None,
+            manual: false,
+            release_note: None,
+            triggered_by: None,
+            dry_run: false,
         };
 
         // Publish job
@@ -738,6 +743,11 @@ mod tests {
             tag: None,
             commit: "abc123".to_string(),
             project_id: 123,
+            environment_id: None,
+            manual: false,
+            release_note: None,
+            triggered_by: None,
+            dry_run: false,
         });
 
         let cert_job = Job::ProvisionCertificate(ProvisionCertificateJob {
@@ -24,6 +24,11 @@ mod tests {
             tag: None,
             commit: "abc123".to_string(),
             project_id: 123,
+            environment_id: None,
+            manual: false,
+            release_note: None,
+            triggered_by: None,
+            dry_run: false,
         };
         queue.send(Job::GitPushEvent(git_push_job)).await.unwrap();
 
@@ -77,6 +82,11 @@ mod tests {
             tag: None,
             commit: "def456".to_string(),
             project_id: 999,
+            environment_id: None,
+            manual: false,
+            release_note: None,
+            triggered_by: None,
+            dry_run: false,
         };
         queue.send(Job::GitPushEvent(git_push_job)).await.unwrap();
 
@@ -116,6 +126,11 @@ mod tests {
             tag: None,
             commit: "xyz789".to_string(),
             project_id: 42,
+            environment_id: None,
+            manual: false,
+            release_note: None,
+            triggered_by: None,
+            dry_run: false,
         };
         queue.send(Job::GitPushEvent(git_push_job)).await.unwrap();
 
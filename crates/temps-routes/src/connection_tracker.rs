@@ -0,0 +1,94 @@
+//! In-flight connection counts per deployment
+//!
+//! The proxy holds a [`ConnectionGuard`] for every request it forwards to a
+//! deployment's containers, for as long as the request (or WebSocket, or stream) is
+//! open. The deploy flow reads the counts to show how many connections a previous
+//! deployment still serves while it drains.
+
+use parking_lot::Mutex;
+use std::collections::HashMap;
+use std::sync::Arc;
+
+/// Open proxied connections per deployment ID
+#[derive(Debug, Default)]
+pub struct ConnectionTracker {
+    counts: Mutex<HashMap<i32, usize>>,
+}
+
+impl ConnectionTracker {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Count a connection to a deployment until the returned guard is dropped
+    pub fn track(self: &Arc<Self>, deployment_id: i32) -> ConnectionGuard {
+        *self.counts.lock().entry(deployment_id).or_insert(0) += 1;
+        ConnectionGuard {
+            tracker: self.clone(),
+            deployment_id,
+        }
+    }
+
+    /// Connections currently open to a deployment
+    pub fn in_flight(&self, deployment_id: i32) -> usize {
+        self.counts.lock().get(&deployment_id).copied().unwrap_or(0)
+    }
+
+    /// Connections currently open to any of the deployments
+    pub fn in_flight_total(&self, deployment_ids: &[i32]) -> usize {
+        let counts = self.counts.lock();
+        deployment_ids.iter().filter_map(|id| counts.get(id)).sum()
+    }
+
+    fn release(&self, deployment_id: i32) {
+        let mut counts = self.counts.lock();
+        if let Some(count) = counts.get_mut(&deployment_id) {
+            *count = count.saturating_sub(1);
+            if *count == 0 {
+                counts.remove(&deployment_id);
+            }
+        }
+    }
+}
+
+/// One open connection to a deployment, released when dropped
+#[derive(Debug)]
+pub struct ConnectionGuard {
+    tracker: Arc<ConnectionTracker>,
+    deployment_id: i32,
+}
+
+impl ConnectionGuard {
+    pub fn deployment_id(&self) -> i32 {
+        self.deployment_id
+    }
+}
+
+impl Drop for ConnectionGuard {
+    fn drop(&mut self) {
+        self.tracker.release(self.deployment_id);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn counts_connections_until_guards_drop() {
+        let tracker = Arc::new(ConnectionTracker::new());
+        let first = tracker.track(1);
+        let second = tracker.track(1);
+        let other = tracker.track(2);
+
+        assert_eq!(tracker.in_flight(1), 2);
+        assert_eq!(tracker.in_flight_total(&[1, 2, 3]), 3);
+
+        drop(first);
+        assert_eq!(tracker.in_flight(1), 1);
+        drop(second);
+        drop(other);
+        assert_eq!(tracker.in_flight_total(&[1, 2]), 0);
+        assert!(tracker.counts.lock().is_empty());
+    }
+}
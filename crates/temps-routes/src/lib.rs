@@ -1,4 +1,6 @@
+pub mod connection_tracker;
 pub mod project_change_listener;
+pub mod route_conflicts;
 pub mod route_table;
 pub mod wildcard_matcher;
 
@@ -8,6 +10,8 @@ mod test_utils;
 #[cfg(test)]
 mod route_table_test;
 
+pub use connection_tracker::*;
 pub use project_change_listener::*;
+pub use route_conflicts::*;
 pub use route_table::*;
 pub use wildcard_matcher::*;
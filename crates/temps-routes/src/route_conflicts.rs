@@ -0,0 +1,125 @@
+//! Conflicting hostnames in the route table
+//!
+//! A hostname can only route to one place. When two environments, or an environment
+//! and a custom route, both claim the same hostname, which one serves it depends on
+//! the order the route table happens to be built in. Reloads that would add such a
+//! conflict are rejected, so the proxy keeps serving the routes it had instead.
+
+use std::collections::{BTreeMap, BTreeSet};
+use std::fmt;
+
+/// What a hostname routes to
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, PartialOrd, Ord)]
+pub enum RouteOwner {
+    Environment(i32),
+    CustomRoute(i32),
+}
+
+impl fmt::Display for RouteOwner {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            RouteOwner::Environment(id) => write!(f, "environment {}", id),
+            RouteOwner::CustomRoute(id) => write!(f, "custom route {}", id),
+        }
+    }
+}
+
+/// A hostname claimed by more than one owner
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RouteConflict {
+    pub host: String,
+    pub owners: Vec<RouteOwner>,
+}
+
+impl fmt::Display for RouteConflict {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let owners: Vec<String> = self.owners.iter().map(|owner| owner.to_string()).collect();
+        write!(f, "{} is claimed by {}", self.host, owners.join(", "))
+    }
+}
+
+/// Hostnames claimed while building the route table
+#[derive(Debug, Default)]
+pub struct RouteClaims {
+    claims: BTreeMap<String, BTreeSet<RouteOwner>>,
+}
+
+impl RouteClaims {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Record that the host routes to the owner; hosts compare case-insensitively
+    pub fn claim(&mut self, host: &str, owner: RouteOwner) {
+        self.claims
+            .entry(host.trim_end_matches('.').to_lowercase())
+            .or_default()
+            .insert(owner);
+    }
+
+    /// Hosts claimed by more than one owner, sorted by host
+    pub fn conflicts(&self) -> Vec<RouteConflict> {
+        self.claims
+            .iter()
+            .filter(|(_, owners)| owners.len() > 1)
+            .map(|(host, owners)| RouteConflict {
+                host: host.clone(),
+                owners: owners.iter().copied().collect(),
+            })
+            .collect()
+    }
+}
+
+/// Conflicts of the candidate table the current table doesn't already have
+///
+/// Conflicts that were there before are left alone, so one that already made it
+/// into the table doesn't block every reload after it.
+pub fn new_conflicts(current: &[RouteConflict], candidate: &[RouteConflict]) -> Vec<RouteConflict> {
+    candidate
+        .iter()
+        .filter(|conflict| !current.contains(conflict))
+        .cloned()
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_hosts_claimed_twice_conflict() {
+        let mut claims = RouteClaims::new();
+        claims.claim("app.example.com", RouteOwner::Environment(1));
+        // The same owner claiming a host again, e.g. through a custom domain, is fine
+        claims.claim("App.Example.com", RouteOwner::Environment(1));
+        claims.claim("api.example.com", RouteOwner::Environment(1));
+        claims.claim("api.example.com.", RouteOwner::CustomRoute(7));
+        claims.claim("www.example.com", RouteOwner::Environment(2));
+
+        assert_eq!(
+            claims.conflicts(),
+            vec![RouteConflict {
+                host: "api.example.com".to_string(),
+                owners: vec![RouteOwner::Environment(1), RouteOwner::CustomRoute(7)],
+            }]
+        );
+    }
+
+    #[test]
+    fn test_only_new_conflicts_are_reported() {
+        let existing = RouteConflict {
+            host: "api.example.com".to_string(),
+            owners: vec![RouteOwner::Environment(1), RouteOwner::CustomRoute(7)],
+        };
+        let added = RouteConflict {
+            host: "www.example.com".to_string(),
+            owners: vec![RouteOwner::Environment(1), RouteOwner::Environment(2)],
+        };
+
+        assert!(new_conflicts(&[existing.clone()], &[existing.clone()]).is_empty());
+        assert_eq!(
+            new_conflicts(&[existing.clone()], &[existing, added.clone()]),
+            vec![added]
+        );
+    }
+}
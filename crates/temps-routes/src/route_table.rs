@@ -18,15 +18,20 @@
 //! - `*.example.com` does NOT match `sub.api.example.com` ✗
 //! - `*.example.com` does NOT match `example.com` ✗
 
+use crate::connection_tracker::ConnectionTracker;
+use crate::route_conflicts::{new_conflicts, RouteClaims, RouteConflict, RouteOwner};
 use crate::wildcard_matcher::WildcardMatcher;
 use parking_lot::RwLock;
 use sea_orm::DatabaseConnection;
 use sqlx::postgres::{PgListener, PgPool};
-use std::collections::HashMap;
-use std::sync::atomic::{AtomicUsize, Ordering};
+use std::collections::{HashMap, HashSet};
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
 use std::sync::Arc;
 use temps_core::DeploymentMode;
 use temps_entities::custom_routes::RouteType;
+use temps_entities::deployment_config::WEB_PROCESS_TYPE;
+use temps_entities::path_rules::PathRuleList;
+use temps_entities::project_custom_domains::CanonicalRedirect;
 use temps_entities::{deployments, environments, projects};
 use tracing::{debug, error, info, warn};
 
@@ -112,6 +117,13 @@ pub struct RouteInfo {
     pub redirect_to: Option<String>,
     /// Optional status code for redirects
     pub status_code: Option<i32>,
+    /// Host requests should be redirected to (keeping path and query) when this host
+    /// is the non-canonical half of an apex/www pair
+    pub canonical_host: Option<String>,
+    /// Whether plain HTTP requests are redirected to HTTPS
+    pub force_https: bool,
+    /// Which paths a custom domain serves and where they map on its environment
+    pub path_rules: PathRuleList,
     /// Cached project model (None for custom_routes without project)
     pub project: Option<Arc<projects::Model>>,
     /// Cached environment model (None for custom_routes)
@@ -166,6 +178,31 @@ pub struct CachedPeerTable {
     /// Contains all environment domains, project custom domains, etc.
     routes: Arc<RwLock<HashMap<String, RouteInfo>>>,
 
+    /// Environment ID -> RouteInfo for its current deployment
+    /// Used for custom domain path rules that send a prefix to another environment
+    environment_routes: Arc<RwLock<HashMap<i32, RouteInfo>>>,
+
+    /// Domains with an active certificate, including wildcard patterns like
+    /// `*.example.com`
+    /// Used to redirect a host to HTTPS only once it can be served over HTTPS
+    certificate_domains: Arc<RwLock<HashSet<String>>>,
+
+    /// Connections the proxy has open to each deployment
+    connections: Arc<ConnectionTracker>,
+
+    /// Hostnames claimed by more than one owner in the loaded table
+    conflicts: Arc<RwLock<Vec<RouteConflict>>>,
+
+    /// Conflicts that made the latest reload be rejected; empty once a reload applies
+    rejected_conflicts: Arc<RwLock<Vec<RouteConflict>>>,
+
+    /// Whether a table has been loaded; the first load always applies
+    loaded: Arc<AtomicBool>,
+
+    /// Held while a reload builds and swaps in the tables, so reloads triggered
+    /// together apply one after the other
+    reload_lock: Arc<tokio::sync::Mutex<()>>,
+
     /// Database connection for loading routes
     db: Arc<DatabaseConnection>,
 }
@@ -178,10 +215,42 @@ impl CachedPeerTable {
             http_wildcards: Arc::new(RwLock::new(WildcardMatcher::new())),
             tls_wildcards: Arc::new(RwLock::new(WildcardMatcher::new())),
             routes: Arc::new(RwLock::new(HashMap::new())),
+            environment_routes: Arc::new(RwLock::new(HashMap::new())),
+            certificate_domains: Arc::new(RwLock::new(HashSet::new())),
+            connections: Arc::new(ConnectionTracker::new()),
+            conflicts: Arc::new(RwLock::new(Vec::new())),
+            rejected_conflicts: Arc::new(RwLock::new(Vec::new())),
+            loaded: Arc::new(AtomicBool::new(false)),
+            reload_lock: Arc::new(tokio::sync::Mutex::new(())),
             db,
         }
     }
 
+    /// Connections the proxy sharing this table has open to each deployment
+    pub fn connections(&self) -> Arc<ConnectionTracker> {
+        self.connections.clone()
+    }
+
+    /// Whether an active certificate covers the host, exactly or through a wildcard
+    pub fn has_certificate(&self, host: &str) -> bool {
+        certificate_covers(&self.certificate_domains.read(), host)
+    }
+
+    /// Hostnames claimed by more than one owner in the loaded table
+    pub fn conflicts(&self) -> Vec<RouteConflict> {
+        self.conflicts.read().clone()
+    }
+
+    /// Conflicts that made the latest reload be rejected
+    pub fn rejected_conflicts(&self) -> Vec<RouteConflict> {
+        self.rejected_conflicts.read().clone()
+    }
+
+    /// Get the route for an environment's current deployment
+    pub fn get_environment_route(&self, environment_id: i32) -> Option<RouteInfo> {
+        self.environment_routes.read().get(&environment_id).cloned()
+    }
+
     /// Get route by HTTP Host header
     ///
     /// Used for route_type = 'http' routes.
@@ -221,14 +290,21 @@ impl CachedPeerTable {
 
     /// Load all routes from the database into the cache with full models
     /// This queries environment_domains, custom_routes, and project_custom_domains
+    ///
+    /// A reload that would route a hostname to two owners the loaded table doesn't
+    /// already have conflicting is rejected, and the loaded table keeps serving.
     pub async fn load_routes(&self) -> Result<(), sea_orm::DbErr> {
         use sea_orm::{ColumnTrait, EntityTrait, QueryFilter};
         use temps_entities::{
-            custom_routes, deployments, environment_domains, environments, project_custom_domains,
-            settings,
+            custom_routes, deployments, domains, environment_domains, environments,
+            project_custom_domains, settings,
         };
 
+        let _reload = self.reload_lock.lock().await;
+
         let mut routes = HashMap::new();
+        let mut environment_routes: HashMap<i32, RouteInfo> = HashMap::new();
+        let mut claims = RouteClaims::new();
 
         // Build entity caches as we go - only cache what we actually need for routing
         let mut projects_cache: HashMap<i32, Arc<projects::Model>> = HashMap::new();
@@ -290,6 +366,7 @@ impl CachedPeerTable {
                         let containers = deployment_containers::Entity::find()
                             .filter(deployment_containers::Column::DeploymentId.eq(deployment_id))
                             .filter(deployment_containers::Column::DeletedAt.is_null())
+                            .filter(deployment_containers::Column::ProcessType.eq(WEB_PROCESS_TYPE))
                             .all(self.db.as_ref())
                             .await
                             .unwrap_or_default();
@@ -334,12 +411,16 @@ impl CachedPeerTable {
                             continue;
                         };
 
+                        claims.claim(&env_domain.domain, RouteOwner::Environment(environment.id));
                         routes.insert(
                             env_domain.domain.clone(),
                             RouteInfo {
                                 backend: backend.clone(),
                                 redirect_to: None,
                                 status_code: None,
+                                canonical_host: None,
+                                force_https: true,
+                                path_rules: PathRuleList::default(),
                                 project: project.cloned(),
                                 environment: Some(Arc::clone(environment)),
                                 deployment: Some(Arc::clone(deployment)),
@@ -392,11 +473,18 @@ impl CachedPeerTable {
                 },
                 redirect_to: None,
                 status_code: None,
+                canonical_host: None,
+                force_https: true,
+                path_rules: PathRuleList::default(),
                 project: None, // Custom routes don't have project context
                 environment: None,
                 deployment: None,
             };
 
+            claims.claim(
+                &custom_route.domain,
+                RouteOwner::CustomRoute(custom_route.id),
+            );
             let is_wildcard = custom_route.domain.starts_with("*.");
             let route_type_str = match custom_route.route_type {
                 RouteType::Http => "http",
@@ -451,6 +539,16 @@ impl CachedPeerTable {
             custom_domains.len()
         );
 
+        // Apex domains that redirect to or from their www subdomain
+        let canonical_redirects: HashMap<String, CanonicalRedirect> = custom_domains
+            .iter()
+            .filter_map(|domain| {
+                domain
+                    .canonical_redirect()
+                    .map(|mode| (domain.domain.to_lowercase(), mode))
+            })
+            .collect();
+
         for custom_domain in custom_domains {
             // Fetch environment if not cached
             if !environments_cache.contains_key(&custom_domain.environment_id) {
@@ -481,6 +579,7 @@ impl CachedPeerTable {
                         let containers = deployment_containers::Entity::find()
                             .filter(deployment_containers::Column::DeploymentId.eq(deployment_id))
                             .filter(deployment_containers::Column::DeletedAt.is_null())
+                            .filter(deployment_containers::Column::ProcessType.eq(WEB_PROCESS_TYPE))
                             .all(self.db.as_ref())
                             .await
                             .unwrap_or_default();
@@ -525,12 +624,22 @@ impl CachedPeerTable {
                             continue;
                         };
 
+                        claims.claim(
+                            &custom_domain.domain,
+                            RouteOwner::Environment(environment.id),
+                        );
                         routes.insert(
                             custom_domain.domain.clone(),
                             RouteInfo {
                                 backend: backend.clone(),
                                 redirect_to: custom_domain.redirect_to.clone(),
                                 status_code: custom_domain.status_code,
+                                canonical_host: canonical_host_for(
+                                    &custom_domain.domain,
+                                    &canonical_redirects,
+                                ),
+                                force_https: custom_domain.force_https,
+                                path_rules: custom_domain.path_rules.clone(),
                                 project: project.cloned(),
                                 environment: Some(Arc::clone(environment)),
                                 deployment: Some(Arc::clone(deployment)),
@@ -603,6 +712,7 @@ impl CachedPeerTable {
                     let containers = deployment_containers::Entity::find()
                         .filter(deployment_containers::Column::DeploymentId.eq(deployment_id))
                         .filter(deployment_containers::Column::DeletedAt.is_null())
+                        .filter(deployment_containers::Column::ProcessType.eq(WEB_PROCESS_TYPE))
                         .all(self.db.as_ref())
                         .await
                         .unwrap_or_default();
@@ -655,6 +765,9 @@ impl CachedPeerTable {
                                 backend: backend.clone(),
                                 redirect_to: None,
                                 status_code: None,
+                                canonical_host: None,
+                                force_https: true,
+                                path_rules: PathRuleList::default(),
                                 project: project.cloned(),
                                 environment: environment.cloned(),
                                 deployment: Some(Arc::clone(deployment)),
@@ -685,6 +798,9 @@ impl CachedPeerTable {
                                 backend: backend.clone(),
                                 redirect_to: None,
                                 status_code: None,
+                                canonical_host: None,
+                                force_https: true,
+                                path_rules: PathRuleList::default(),
                                 project: project.cloned(),
                                 environment: environment.cloned(),
                                 deployment: Some(Arc::clone(deployment)),
@@ -766,6 +882,7 @@ impl CachedPeerTable {
                     let containers = deployment_containers::Entity::find()
                         .filter(deployment_containers::Column::DeploymentId.eq(deployment_id))
                         .filter(deployment_containers::Column::DeletedAt.is_null())
+                        .filter(deployment_containers::Column::ProcessType.eq(WEB_PROCESS_TYPE))
                         .all(self.db.as_ref())
                         .await
                         .unwrap_or_default();
@@ -797,6 +914,21 @@ impl CachedPeerTable {
                         continue;
                     };
 
+                    environment_routes.insert(
+                        env.id,
+                        RouteInfo {
+                            backend: backend.clone(),
+                            redirect_to: None,
+                            status_code: None,
+                            canonical_host: None,
+                            force_https: true,
+                            path_rules: PathRuleList::default(),
+                            project: Some(Arc::clone(project)),
+                            environment: Some(Arc::clone(environment)),
+                            deployment: Some(Arc::clone(deployment)),
+                        },
+                    );
+
                     // Generate a fallback route using deployment slug if no other routes exist
                     // This ensures every active deployment is accessible
                     let fallback_domain = format!("{}.{}", deployment.slug, preview_domain);
@@ -808,6 +940,9 @@ impl CachedPeerTable {
                                 backend: backend.clone(),
                                 redirect_to: None,
                                 status_code: None,
+                                canonical_host: None,
+                                force_https: true,
+                                path_rules: PathRuleList::default(),
                                 project: Some(Arc::clone(project)),
                                 environment: Some(Arc::clone(environment)),
                                 deployment: Some(Arc::clone(deployment)),
@@ -832,9 +967,41 @@ impl CachedPeerTable {
             }
         }
 
+        // Domains that can be served over HTTPS
+        let certificate_domains: HashSet<String> = domains::Entity::find()
+            .filter(domains::Column::Status.eq("active"))
+            .filter(domains::Column::Certificate.is_not_null())
+            .all(self.db.as_ref())
+            .await?
+            .into_iter()
+            .map(|domain| domain.domain.to_lowercase())
+            .collect();
+
         debug!("Loaded all active deployments. Final cache: {} projects, {} environments, {} deployments",
             projects_cache.len(), environments_cache.len(), deployments_cache.len());
 
+        // Refuse a table that routes a host two ways, keeping the loaded one
+        let conflicts = claims.conflicts();
+        if self.loaded.load(Ordering::Acquire) {
+            let added = new_conflicts(&self.conflicts.read(), &conflicts);
+            if !added.is_empty() {
+                let details: Vec<String> =
+                    added.iter().map(|conflict| conflict.to_string()).collect();
+                error!(
+                    "Rejected route table reload, keeping the loaded routes: {}",
+                    details.join("; ")
+                );
+                *self.rejected_conflicts.write() = added;
+                return Err(sea_orm::DbErr::Custom(format!(
+                    "Conflicting routes: {}",
+                    details.join("; ")
+                )));
+            }
+        }
+        for conflict in &conflicts {
+            warn!("Conflicting route: {}", conflict);
+        }
+
         // Atomically replace all route tables
         let route_count = routes.len();
         let http_routes_count = http_routes_map.len();
@@ -844,12 +1011,17 @@ impl CachedPeerTable {
 
         // Replace legacy routes
         *self.routes.write() = routes;
+        *self.environment_routes.write() = environment_routes;
+        *self.certificate_domains.write() = certificate_domains;
 
         // Replace HTTP and TLS route caches
         *self.http_routes.write() = http_routes_map;
         *self.tls_routes.write() = tls_routes_map;
         *self.http_wildcards.write() = http_wildcards_matcher;
         *self.tls_wildcards.write() = tls_wildcards_matcher;
+        *self.conflicts.write() = conflicts;
+        self.rejected_conflicts.write().clear();
+        self.loaded.store(true, Ordering::Release);
 
         debug!(
             "Route table loaded with {} total entries ({} HTTP exact, {} TLS exact, {} HTTP wildcards, {} TLS wildcards)",
@@ -958,6 +1130,40 @@ impl RouteTableListener {
     }
 }
 
+/// The host a custom domain should redirect to, if it is the non-canonical half of an
+/// apex/www pair
+///
+/// `canonical_redirects` maps apex domains to their configured redirect direction.
+pub fn canonical_host_for(
+    domain: &str,
+    canonical_redirects: &HashMap<String, CanonicalRedirect>,
+) -> Option<String> {
+    let domain = domain.to_lowercase();
+
+    if let Some(CanonicalRedirect::ApexToWww) = canonical_redirects.get(&domain) {
+        return Some(format!("www.{}", domain));
+    }
+
+    let apex = domain.strip_prefix("www.")?;
+    match canonical_redirects.get(apex) {
+        Some(CanonicalRedirect::WwwToApex) => Some(apex.to_string()),
+        _ => None,
+    }
+}
+
+/// Whether one of the certificate domains covers the host
+///
+/// A wildcard covers a single label, like the wildcard routes:
+/// `*.example.com` covers `api.example.com` but not `example.com`.
+fn certificate_covers(certificate_domains: &HashSet<String>, host: &str) -> bool {
+    let host = host.to_lowercase();
+    if certificate_domains.contains(&host) {
+        return true;
+    }
+    host.split_once('.')
+        .is_some_and(|(_, parent)| certificate_domains.contains(&format!("*.{}", parent)))
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -971,6 +1177,9 @@ mod tests {
             },
             redirect_to: None,
             status_code: None,
+            canonical_host: None,
+            force_https: true,
+            path_rules: PathRuleList::default(),
             project: None,
             environment: None,
             deployment: None,
@@ -993,6 +1202,9 @@ mod tests {
             },
             redirect_to: Some("https://example.com".to_string()),
             status_code: Some(301),
+            canonical_host: None,
+            force_https: true,
+            path_rules: PathRuleList::default(),
             project: None,
             environment: None,
             deployment: None,
@@ -1011,6 +1223,9 @@ mod tests {
             },
             redirect_to: None,
             status_code: None,
+            canonical_host: None,
+            force_https: true,
+            path_rules: PathRuleList::default(),
             project: None,
             environment: None,
             deployment: None,
@@ -1036,6 +1251,9 @@ mod tests {
             },
             redirect_to: None,
             status_code: None,
+            canonical_host: None,
+            force_https: true,
+            path_rules: PathRuleList::default(),
             project: None,
             environment: None,
             deployment: None,
@@ -1056,6 +1274,9 @@ mod tests {
             },
             redirect_to: None,
             status_code: None,
+            canonical_host: None,
+            force_https: true,
+            path_rules: PathRuleList::default(),
             project: None,
             environment: None,
             deployment: None,
@@ -1145,6 +1366,9 @@ mod tests {
             },
             redirect_to: None,
             status_code: None,
+            canonical_host: None,
+            force_https: true,
+            path_rules: PathRuleList::default(),
             project: None,
             environment: None,
             deployment: None,
@@ -1165,6 +1389,9 @@ mod tests {
             },
             redirect_to: None,
             status_code: None,
+            canonical_host: None,
+            force_https: true,
+            path_rules: PathRuleList::default(),
             project: None,
             environment: None,
             deployment: None,
@@ -1175,4 +1402,51 @@ mod tests {
         assert_eq!(route.static_dir(), None);
         assert_eq!(route.get_backend_addr(), "10.0.0.1:9000");
     }
+
+    #[test]
+    fn test_canonical_host_for_apex_and_www() {
+        let mut redirects = HashMap::new();
+        redirects.insert("example.com".to_string(), CanonicalRedirect::WwwToApex);
+        redirects.insert("other.io".to_string(), CanonicalRedirect::ApexToWww);
+
+        // www -> apex
+        assert_eq!(
+            canonical_host_for("www.example.com", &redirects),
+            Some("example.com".to_string())
+        );
+        assert_eq!(canonical_host_for("example.com", &redirects), None);
+
+        // apex -> www
+        assert_eq!(
+            canonical_host_for("other.io", &redirects),
+            Some("www.other.io".to_string())
+        );
+        assert_eq!(canonical_host_for("www.other.io", &redirects), None);
+
+        // Unrelated hosts and other subdomains are untouched
+        assert_eq!(canonical_host_for("api.example.com", &redirects), None);
+        assert_eq!(canonical_host_for("www.unknown.dev", &redirects), None);
+        assert_eq!(
+            canonical_host_for("WWW.Example.com", &redirects),
+            Some("example.com".to_string())
+        );
+    }
+
+    #[test]
+    fn test_certificate_covers_exact_and_wildcard_hosts() {
+        let certificate_domains: HashSet<String> = ["app.example.com", "*.preview.dev"]
+            .into_iter()
+            .map(String::from)
+            .collect();
+
+        assert!(certificate_covers(&certificate_domains, "app.example.com"));
+        assert!(certificate_covers(&certificate_domains, "App.Example.com"));
+        assert!(certificate_covers(&certificate_domains, "pr-1.preview.dev"));
+        assert!(!certificate_covers(&certificate_domains, "preview.dev"));
+        assert!(!certificate_covers(
+            &certificate_domains,
+            "a.pr-1.preview.dev"
+        ));
+        assert!(!certificate_covers(&certificate_domains, "www.example.com"));
+    }
 }
@@ -142,6 +142,7 @@ mod tests {
     use crate::route_table::BackendType;
     use std::sync::atomic::AtomicUsize;
     use std::sync::Arc;
+    use temps_entities::path_rules::PathRuleList;
 
     fn create_test_route(addr: &str) -> RouteInfo {
         RouteInfo {
@@ -151,6 +152,9 @@ mod tests {
             },
             redirect_to: None,
             status_code: None,
+            canonical_host: None,
+            force_https: true,
+            path_rules: PathRuleList::default(),
             project: None,
             environment: None,
             deployment: None,
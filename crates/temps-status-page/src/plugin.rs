@@ -10,7 +10,9 @@ use utoipa::openapi::OpenApi;
 use utoipa::OpenApi as OpenApiTrait;
 
 use crate::routes::status_page::{create_router, StatusPageApiDoc, StatusPageAppState};
-use crate::services::{HealthCheckService, MonitorService, StatusPageService};
+use crate::services::{
+    HealthCheckService, MonitorService, ServiceHealthTracker, StatusPageService,
+};
 
 /// Status Page Plugin for monitoring and incident management
 pub struct StatusPagePlugin;
@@ -151,9 +153,26 @@ impl TempsPlugin for StatusPagePlugin {
                 queue_service.clone(),
             ));
 
+            // Track service health from the checks; restarts go through the deployments
+            // plugin, registered before this one
+            let mut service_health = ServiceHealthTracker::new(db.clone());
+            match context.get_service::<dyn temps_core::EnvironmentRedeployer>() {
+                Some(redeployer) => service_health = service_health.with_redeployer(redeployer),
+                None => tracing::warn!(
+                    "No environment redeployer available; failing services won't be restarted automatically"
+                ),
+            }
+            if let Some(notification_service) =
+                context.get_service::<dyn temps_core::notifications::NotificationService>()
+            {
+                service_health = service_health.with_notification_service(notification_service);
+            }
+
             // Create health check service with mandatory ConfigService
-            let health_check_service =
-                Arc::new(HealthCheckService::new(db.clone(), config_service));
+            let health_check_service = Arc::new(
+                HealthCheckService::new(db.clone(), config_service)
+                    .with_service_health(service_health),
+            );
             context.register_service(health_check_service.clone());
 
             // Start the health check scheduler with job receiver for realtime monitor creation
@@ -182,19 +201,26 @@ impl TempsPlugin for StatusPagePlugin {
 
     fn configure_routes(&self, context: &PluginContext) -> Option<PluginRoutes> {
         let status_page_service = context.require_service::<StatusPageService>();
+        let health_check_service = context.require_service::<HealthCheckService>();
 
         struct AppState {
             status_page_service: Arc<StatusPageService>,
+            health_check_service: Arc<HealthCheckService>,
         }
 
         impl StatusPageAppState for AppState {
             fn status_page_service(&self) -> &StatusPageService {
                 &self.status_page_service
             }
+
+            fn health_check_service(&self) -> &HealthCheckService {
+                &self.health_check_service
+            }
         }
 
         let app_state = Arc::new(AppState {
             status_page_service,
+            health_check_service,
         });
 
         let routes = create_router().with_state(app_state);
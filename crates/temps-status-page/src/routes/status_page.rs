@@ -15,15 +15,16 @@ use temps_core::DateTime;
 use utoipa::OpenApi;
 
 use crate::services::{
-    CreateIncidentRequest, CreateMonitorRequest, CurrentStatusResponse, IncidentBucketedResponse,
-    IncidentResponse, IncidentUpdateResponse, MonitorResponse, StatusBucketedResponse,
-    StatusPageError, StatusPageOverview, StatusPageService, UpdateIncidentStatusRequest,
-    UptimeHistoryResponse,
+    CreateIncidentRequest, CreateMonitorRequest, CurrentStatusResponse, HealthCheckService,
+    HealthMonitorLag, IncidentBucketedResponse, IncidentResponse, IncidentUpdateResponse,
+    MonitorResponse, RecentChange, ServiceHealthMetrics, StatusBucketedResponse, StatusPageError,
+    StatusPageOverview, StatusPageService, UpdateIncidentStatusRequest, UptimeHistoryResponse,
 };
 
 /// Application state trait for status page routes
 pub trait StatusPageAppState: Send + Sync + 'static {
     fn status_page_service(&self) -> &StatusPageService;
+    fn health_check_service(&self) -> &HealthCheckService;
 }
 
 /// OpenAPI documentation for status page endpoints
@@ -44,10 +45,13 @@ pub trait StatusPageAppState: Send + Sync + 'static {
         update_incident_status,
         get_incident_updates,
         get_bucketed_incidents,
+        get_health_monitor_lag,
+        get_service_health_metrics,
     ),
     components(
         schemas(
             StatusPageOverview,
+            RecentChange,
             MonitorResponse,
             CreateMonitorRequest,
             CurrentStatusResponse,
@@ -58,6 +62,8 @@ pub trait StatusPageAppState: Send + Sync + 'static {
             UpdateIncidentStatusRequest,
             IncidentUpdateResponse,
             IncidentBucketedResponse,
+            HealthMonitorLag,
+            ServiceHealthMetrics,
         )
     ),
     tags(
@@ -248,6 +254,60 @@ where
         .map_err(map_error)
 }
 
+/// How far the health checks of all monitors are behind their intervals
+#[utoipa::path(
+    get,
+    path = "/monitors/lag",
+    responses(
+        (status = 200, description = "Lag of the health check scheduler", body = HealthMonitorLag),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+    ),
+    tag = "Status Page",
+    security(("bearer_auth" = []))
+)]
+pub async fn get_health_monitor_lag<T>(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<T>>,
+) -> Result<impl IntoResponse, Problem>
+where
+    T: StatusPageAppState,
+{
+    permission_guard!(auth, SystemRead);
+    Ok(Json(app_state.health_check_service().lag()))
+}
+
+/// How many monitored services are healthy, failing or unhealthy, and how many were
+/// restarted for failed checks
+#[utoipa::path(
+    get,
+    path = "/monitors/health",
+    responses(
+        (status = 200, description = "Health of the monitored services", body = ServiceHealthMetrics),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error"),
+    ),
+    tag = "Status Page",
+    security(("bearer_auth" = []))
+)]
+pub async fn get_service_health_metrics<T>(
+    RequireAuth(auth): RequireAuth,
+    State(app_state): State<Arc<T>>,
+) -> Result<impl IntoResponse, Problem>
+where
+    T: StatusPageAppState,
+{
+    permission_guard!(auth, SystemRead);
+    app_state
+        .health_check_service()
+        .service_health()
+        .metrics()
+        .await
+        .map(Json)
+        .map_err(map_error)
+}
+
 /// Delete a monitor
 #[utoipa::path(
     delete,
@@ -665,6 +725,8 @@ where
         .route("/projects/{project_id}/status", get(get_status_overview))
         .route("/projects/{project_id}/monitors", post(create_monitor))
         .route("/projects/{project_id}/monitors", get(list_monitors))
+        .route("/monitors/lag", get(get_health_monitor_lag))
+        .route("/monitors/health", get(get_service_health_metrics))
         .route("/monitors/{monitor_id}", get(get_monitor))
         .route("/monitors/{monitor_id}", delete(delete_monitor))
         .route(
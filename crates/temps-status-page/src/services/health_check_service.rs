@@ -1,22 +1,41 @@
 use chrono::Utc;
 use sea_orm::{ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, Set};
-use std::sync::Arc;
-use std::time::Duration;
+use std::collections::hash_map::DefaultHasher;
+use std::collections::{HashMap, HashSet, VecDeque};
+use std::hash::{Hash, Hasher};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
 use temps_config::ConfigService;
 use temps_core::{Job, JobReceiver};
 use temps_entities::{
     deployment_containers, deployments, environments, projects, status_checks, status_monitors,
 };
-use tokio::time::{sleep, timeout};
+use tokio::sync::mpsc;
+use tokio::time::{sleep, timeout, MissedTickBehavior};
 use tracing::{debug, error, info, warn};
 
-use super::types::StatusPageError;
+use super::service_health::ServiceHealthTracker;
+use super::types::{HealthMonitorLag, StatusPageError};
+
+/// How often the scheduler starts the checks that are due
+const SCHEDULER_TICK: Duration = Duration::from_secs(1);
+/// How often the active monitors and the scheduling settings are reloaded
+const MONITOR_REFRESH_INTERVAL: Duration = Duration::from_secs(30);
+/// Shortest interval a monitor is checked on
+const MIN_CHECK_INTERVAL: Duration = Duration::from_secs(10);
+/// Window the peak lag is taken over
+const PEAK_LAG_WINDOW: Duration = Duration::from_secs(60);
+/// Peak lag past which the scheduler warns that checks fall behind
+const LAG_WARNING_THRESHOLD: Duration = Duration::from_secs(10);
 
 /// Service for performing health checks on monitored environments
 pub struct HealthCheckService {
     db: Arc<DatabaseConnection>,
     http_client: reqwest::Client,
     config_service: Arc<ConfigService>,
+    /// Lag of the scheduler, updated on every tick
+    lag: Mutex<HealthMonitorLag>,
+    service_health: Arc<ServiceHealthTracker>,
 }
 
 impl HealthCheckService {
@@ -29,12 +48,30 @@ impl HealthCheckService {
             .expect("Failed to create HTTP client");
 
         Self {
+            service_health: Arc::new(ServiceHealthTracker::new(db.clone())),
             db,
             http_client,
             config_service,
+            lag: Mutex::new(HealthMonitorLag::default()),
         }
     }
 
+    /// How far the scheduled checks are behind
+    pub fn lag(&self) -> HealthMonitorLag {
+        self.lag.lock().unwrap().clone()
+    }
+
+    /// Track the health of the monitored services with this tracker
+    pub fn with_service_health(mut self, service_health: ServiceHealthTracker) -> Self {
+        self.service_health = Arc::new(service_health);
+        self
+    }
+
+    /// Health of the monitored services, tracked from their checks
+    pub fn service_health(&self) -> &ServiceHealthTracker {
+        &self.service_health
+    }
+
     /// Run health checks for all active monitors
     pub async fn run_all_checks(&self) -> Result<(), StatusPageError> {
         debug!("Starting health check cycle");
@@ -48,18 +85,28 @@ impl HealthCheckService {
         debug!("Found {} active monitors to check", monitors.len());
 
         // Run checks concurrently with a limit
-        let semaphore = Arc::new(tokio::sync::Semaphore::new(10)); // Limit concurrent checks
+        let concurrency = self
+            .config_service
+            .get_settings()
+            .await
+            .unwrap_or_default()
+            .health_monitor
+            .concurrency();
+        let semaphore = Arc::new(tokio::sync::Semaphore::new(concurrency));
         let mut tasks = Vec::new();
 
         for monitor in monitors {
             let db = self.db.clone();
             let http_client = self.http_client.clone();
             let config_service = self.config_service.clone();
+            let service_health = self.service_health.clone();
             let permit = semaphore.clone().acquire_owned().await.unwrap();
 
             let task = tokio::spawn(async move {
                 let _permit = permit; // Hold permit until task completes
-                if let Err(e) = Self::check_monitor(db, http_client, config_service, monitor).await
+                if let Err(e) =
+                    Self::check_monitor(db, http_client, config_service, service_health, monitor)
+                        .await
                 {
                     error!("Health check failed: {:?}", e);
                 }
@@ -79,13 +126,29 @@ impl HealthCheckService {
         Ok(())
     }
 
-    /// Check a single monitor
+    /// Check a single monitor, and track the health of its service from the result
     async fn check_monitor(
         db: Arc<DatabaseConnection>,
         http_client: reqwest::Client,
         config_service: Arc<ConfigService>,
+        service_health: Arc<ServiceHealthTracker>,
         monitor: status_monitors::Model,
     ) -> Result<(), StatusPageError> {
+        let checked = monitor.clone();
+        if let Some(status) = Self::probe_monitor(db, http_client, config_service, monitor).await? {
+            service_health.record(&checked, &status).await?;
+        }
+        Ok(())
+    }
+
+    /// Check a single monitor and record the result; None when there was nothing to
+    /// check
+    async fn probe_monitor(
+        db: Arc<DatabaseConnection>,
+        http_client: reqwest::Client,
+        config_service: Arc<ConfigService>,
+        monitor: status_monitors::Model,
+    ) -> Result<Option<String>, StatusPageError> {
         // Check if environment_id is set
         let env_id = monitor.environment_id.ok_or_else(|| {
             warn!("Monitor {} has no environment_id", monitor.id);
@@ -101,7 +164,7 @@ impl HealthCheckService {
             .ok_or_else(|| StatusPageError::NotFound)?;
         if environment.current_deployment_id.is_none() {
             warn!("Environment {} has no current deployment", env_id);
-            return Ok(());
+            return Ok(None);
         }
 
         // IMPORTANT: Always use the public URL for health checks
@@ -126,16 +189,15 @@ impl HealthCheckService {
                 );
 
                 // Record check as failed due to configuration error
-                Self::record_check(
+                return Self::record_check(
                     &db,
                     monitor.id,
                     "degraded".to_string(),
                     None,
                     Some(format!("Failed to determine public URL: {:?}", e)),
                 )
-                .await?;
-
-                return Ok(());
+                .await
+                .map(Some);
             }
         };
 
@@ -213,7 +275,8 @@ impl HealthCheckService {
                             None
                         },
                     )
-                    .await;
+                    .await
+                    .map(Some);
                 }
                 Ok(Err(e)) => {
                     // Network errors - retry for connection and timeout errors
@@ -261,7 +324,8 @@ impl HealthCheckService {
                             attempt + 1
                         )),
                     )
-                    .await;
+                    .await
+                    .map(Some);
                 }
                 Err(_) => {
                     // Timeout - retry
@@ -287,7 +351,8 @@ impl HealthCheckService {
                             attempt + 1
                         )),
                     )
-                    .await;
+                    .await
+                    .map(Some);
                 }
             }
         }
@@ -302,16 +367,19 @@ impl HealthCheckService {
             Some(last_error.unwrap_or_else(|| "Unknown error after retries".to_string())),
         )
         .await
+        .map(Some)
     }
 
-    /// Record a check result in the database with retry logic
+    /// Record a check result in the database with retry logic; returns the status
+    /// recorded
     async fn record_check(
         db: &Arc<DatabaseConnection>,
         monitor_id: i32,
         status: String,
         response_time_ms: Option<i32>,
         error_message: Option<String>,
-    ) -> Result<(), StatusPageError> {
+    ) -> Result<String, StatusPageError> {
+        let recorded = status.clone();
         let check = status_checks::ActiveModel {
             monitor_id: Set(monitor_id),
             status: Set(status),
@@ -342,7 +410,7 @@ impl HealthCheckService {
                     if attempt > 0 {
                         debug!("Database insert succeeded after {} attempts", attempt + 1);
                     }
-                    return Ok(());
+                    return Ok(recorded);
                 }
                 Err(e) => {
                     // Check if it's a transient error that we should retry
@@ -423,16 +491,17 @@ impl HealthCheckService {
         Ok(())
     }
 
-    /// Start the periodic health check scheduler with realtime monitor creation handling
+    /// Start the health check scheduler with realtime monitor creation handling
     ///
     /// This scheduler:
     /// 1. Initializes monitors for all existing environments at startup
-    /// 2. Runs health checks every 60 seconds for all active monitors
+    /// 2. Checks every active monitor on its own interval, with up to the configured
+    ///    number of checks running at once
     /// 3. Listens for MonitorCreated events and immediately checks new monitors
     ///
     /// The job_receiver parameter allows the scheduler to react to monitor creation
     /// events in realtime, ensuring new monitors are checked immediately without
-    /// waiting for the next scheduled cycle.
+    /// waiting for their first interval.
     pub async fn start_scheduler(self: Arc<Self>, mut job_receiver: Box<dyn JobReceiver>) {
         debug!("Starting health check scheduler with realtime monitor creation handling");
 
@@ -441,20 +510,8 @@ impl HealthCheckService {
             error!("Failed to initialize monitors: {:?}", e);
         }
 
-        // Start the periodic check cycle
-        let service_for_interval = self.clone();
-        tokio::spawn(async move {
-            let mut interval = tokio::time::interval(Duration::from_secs(60));
-            loop {
-                interval.tick().await;
-                let service = service_for_interval.clone();
-                tokio::spawn(async move {
-                    if let Err(e) = service.run_all_checks().await {
-                        error!("Health check cycle failed: {:?}", e);
-                    }
-                });
-            }
-        });
+        let (created_tx, created_rx) = mpsc::unbounded_channel();
+        tokio::spawn(self.clone().run_schedule(created_rx));
 
         // Listen for MonitorCreated events and check new monitors immediately
         loop {
@@ -464,45 +521,12 @@ impl HealthCheckService {
                         "Received MonitorCreated event for monitor {} (environment {}), checking immediately",
                         job.monitor_id, job.environment_id
                     );
-
-                    let service = self.clone();
-                    tokio::spawn(async move {
-                        // Fetch the monitor and check it immediately
-                        match status_monitors::Entity::find_by_id(job.monitor_id)
-                            .one(service.db.as_ref())
-                            .await
-                        {
-                            Ok(Some(monitor)) => {
-                                if let Err(e) = Self::check_monitor(
-                                    service.db.clone(),
-                                    service.http_client.clone(),
-                                    service.config_service.clone(),
-                                    monitor,
-                                )
-                                .await
-                                {
-                                    error!(
-                                        "Failed to check newly created monitor {}: {:?}",
-                                        job.monitor_id, e
-                                    );
-                                } else {
-                                    info!(
-                                        "Successfully checked newly created monitor {}",
-                                        job.monitor_id
-                                    );
-                                }
-                            }
-                            Ok(None) => {
-                                warn!(
-                                    "Monitor {} not found after MonitorCreated event",
-                                    job.monitor_id
-                                );
-                            }
-                            Err(e) => {
-                                error!("Failed to fetch monitor {}: {:?}", job.monitor_id, e);
-                            }
-                        }
-                    });
+                    if created_tx.send(job.monitor_id).is_err() {
+                        error!(
+                            "Health check scheduler stopped; monitor {} won't be checked",
+                            job.monitor_id
+                        );
+                    }
                 }
                 Ok(_) => {
                     // Ignore other job types
@@ -515,6 +539,94 @@ impl HealthCheckService {
         }
     }
 
+    /// Start the checks that are due every tick, keeping at most `concurrency` running
+    ///
+    /// A check is due one interval after the previous one was due, not after it
+    /// finished, so slow checks don't stretch the interval. A monitor falling a whole
+    /// interval behind skips the checks it missed.
+    async fn run_schedule(self: Arc<Self>, mut created: mpsc::UnboundedReceiver<i32>) {
+        let mut schedule = MonitorSchedule::default();
+        let mut concurrency = temps_core::HealthMonitorSettings::default().concurrency();
+        let (done_tx, mut done_rx) = mpsc::unbounded_channel::<i32>();
+
+        let mut refresh = tokio::time::interval(MONITOR_REFRESH_INTERVAL);
+        let mut tick = tokio::time::interval(SCHEDULER_TICK);
+        tick.set_missed_tick_behavior(MissedTickBehavior::Delay);
+
+        loop {
+            tokio::select! {
+                _ = refresh.tick() => {
+                    let settings = self
+                        .config_service
+                        .get_settings()
+                        .await
+                        .unwrap_or_default()
+                        .health_monitor;
+                    concurrency = settings.concurrency();
+                    schedule.jitter_percent = settings.jitter_percent();
+
+                    match status_monitors::Entity::find()
+                        .filter(status_monitors::Column::IsActive.eq(true))
+                        .all(self.db.as_ref())
+                        .await
+                    {
+                        Ok(monitors) => schedule.sync(monitors, Instant::now()),
+                        Err(e) => error!("Failed to load monitors to schedule: {:?}", e),
+                    }
+
+                    let lag = schedule.lag(Instant::now(), concurrency);
+                    if lag.peak_lag_ms >= LAG_WARNING_THRESHOLD.as_millis() as u64 {
+                        warn!(
+                            "Health checks are falling behind: checks started up to {}ms late, {} of {} monitors overdue with {} checks at once",
+                            lag.peak_lag_ms, lag.overdue, lag.targets, concurrency
+                        );
+                    }
+                }
+                Some(monitor_id) = created.recv() => {
+                    match status_monitors::Entity::find_by_id(monitor_id)
+                        .one(self.db.as_ref())
+                        .await
+                    {
+                        Ok(Some(monitor)) => schedule.check_now(monitor, Instant::now()),
+                        Ok(None) => {
+                            warn!("Monitor {} not found after MonitorCreated event", monitor_id);
+                        }
+                        Err(e) => error!("Failed to fetch monitor {}: {:?}", monitor_id, e),
+                    }
+                }
+                Some(monitor_id) = done_rx.recv() => schedule.finish(monitor_id),
+                _ = tick.tick() => {
+                    let now = Instant::now();
+                    let slots = concurrency.saturating_sub(schedule.in_flight());
+                    for monitor in schedule.take_due(now, slots) {
+                        let service = self.clone();
+                        let done = done_tx.clone();
+                        tokio::spawn(async move {
+                            let monitor_id = monitor.id;
+                            // Checked in a task of its own so the slot is freed even
+                            // if the check panics
+                            let check = tokio::spawn(Self::check_monitor(
+                                service.db.clone(),
+                                service.http_client.clone(),
+                                service.config_service.clone(),
+                                service.service_health.clone(),
+                                monitor,
+                            ))
+                            .await;
+                            match check {
+                                Ok(Ok(())) => {}
+                                Ok(Err(e)) => error!("Health check failed: {:?}", e),
+                                Err(e) => error!("Health check of monitor {} panicked: {:?}", monitor_id, e),
+                            }
+                            let _ = done.send(monitor_id);
+                        });
+                    }
+                    *self.lag.lock().unwrap() = schedule.lag(now, concurrency);
+                }
+            }
+        }
+    }
+
     /// Check a specific environment using its deployment URL
     pub async fn check_environment(
         &self,
@@ -542,6 +654,10 @@ impl HealthCheckService {
         // Get the deployment container
         let container = deployment_containers::Entity::find()
             .filter(deployment_containers::Column::DeploymentId.eq(deployment.id))
+            .filter(
+                deployment_containers::Column::ProcessType
+                    .eq(temps_entities::deployment_config::WEB_PROCESS_TYPE),
+            )
             .one(self.db.as_ref())
             .await?;
 
@@ -583,3 +699,296 @@ impl HealthCheckService {
         }
     }
 }
+
+/// A monitor in the schedule
+struct ScheduledMonitor {
+    monitor: status_monitors::Model,
+    next_due: Instant,
+    in_flight: bool,
+    /// Checks started, which varies the jitter from one check to the next
+    round: u64,
+}
+
+/// When each active monitor is checked next
+#[derive(Default)]
+struct MonitorSchedule {
+    monitors: HashMap<i32, ScheduledMonitor>,
+    jitter_percent: u32,
+    /// Start time and lateness of the checks started within the peak lag window
+    recent_lags: VecDeque<(Instant, Duration)>,
+}
+
+impl MonitorSchedule {
+    /// Replace the monitors with the active ones
+    ///
+    /// Monitors new to the schedule have their first check spread over their first
+    /// interval, so those loaded together aren't all checked at once.
+    fn sync(&mut self, monitors: Vec<status_monitors::Model>, now: Instant) {
+        let active: HashSet<i32> = monitors.iter().map(|monitor| monitor.id).collect();
+        self.monitors.retain(|id, _| active.contains(id));
+        for monitor in monitors {
+            match self.monitors.get_mut(&monitor.id) {
+                Some(scheduled) => scheduled.monitor = monitor,
+                None => {
+                    let interval = check_interval(&monitor);
+                    let offset = Duration::from_millis(
+                        spread(monitor.id, 0) % interval.as_millis().max(1) as u64,
+                    );
+                    self.monitors.insert(
+                        monitor.id,
+                        ScheduledMonitor {
+                            monitor,
+                            next_due: now + offset,
+                            in_flight: false,
+                            round: 0,
+                        },
+                    );
+                }
+            }
+        }
+    }
+
+    /// Check the monitor on the next tick, adding it to the schedule if needed
+    fn check_now(&mut self, monitor: status_monitors::Model, now: Instant) {
+        match self.monitors.get_mut(&monitor.id) {
+            Some(scheduled) => {
+                scheduled.monitor = monitor;
+                scheduled.next_due = scheduled.next_due.min(now);
+            }
+            None => {
+                self.monitors.insert(
+                    monitor.id,
+                    ScheduledMonitor {
+                        monitor,
+                        next_due: now,
+                        in_flight: false,
+                        round: 0,
+                    },
+                );
+            }
+        }
+    }
+
+    /// Up to `slots` of the due monitors, longest-waiting first, marked in flight and
+    /// rescheduled
+    fn take_due(&mut self, now: Instant, slots: usize) -> Vec<status_monitors::Model> {
+        let mut due: Vec<(Instant, i32)> = self
+            .monitors
+            .values()
+            .filter(|scheduled| !scheduled.in_flight && scheduled.next_due <= now)
+            .map(|scheduled| (scheduled.next_due, scheduled.monitor.id))
+            .collect();
+        due.sort();
+        due.truncate(slots);
+
+        let mut started = Vec::with_capacity(due.len());
+        for (_, id) in due {
+            let Some(scheduled) = self.monitors.get_mut(&id) else {
+                continue;
+            };
+            self.recent_lags
+                .push_back((now, now.saturating_duration_since(scheduled.next_due)));
+            scheduled.in_flight = true;
+            scheduled.round += 1;
+
+            let interval = check_interval(&scheduled.monitor);
+            let next_due = scheduled.next_due
+                + jittered(
+                    interval,
+                    scheduled.monitor.id,
+                    scheduled.round,
+                    self.jitter_percent,
+                );
+            scheduled.next_due = if next_due <= now {
+                now + interval
+            } else {
+                next_due
+            };
+            started.push(scheduled.monitor.clone());
+        }
+        started
+    }
+
+    /// Free the monitor's slot once its check is done
+    fn finish(&mut self, monitor_id: i32) {
+        if let Some(scheduled) = self.monitors.get_mut(&monitor_id) {
+            scheduled.in_flight = false;
+        }
+    }
+
+    fn in_flight(&self) -> usize {
+        self.monitors
+            .values()
+            .filter(|scheduled| scheduled.in_flight)
+            .count()
+    }
+
+    fn lag(&mut self, now: Instant, concurrency: usize) -> HealthMonitorLag {
+        while let Some((started_at, _)) = self.recent_lags.front() {
+            if now.saturating_duration_since(*started_at) <= PEAK_LAG_WINDOW {
+                break;
+            }
+            self.recent_lags.pop_front();
+        }
+
+        let waiting: Vec<Duration> = self
+            .monitors
+            .values()
+            .filter(|scheduled| !scheduled.in_flight && scheduled.next_due <= now)
+            .map(|scheduled| now.saturating_duration_since(scheduled.next_due))
+            .collect();
+
+        HealthMonitorLag {
+            targets: self.monitors.len(),
+            in_flight: self.in_flight(),
+            overdue: waiting.len(),
+            lag_ms: waiting
+                .iter()
+                .max()
+                .copied()
+                .unwrap_or_default()
+                .as_millis() as u64,
+            peak_lag_ms: self
+                .recent_lags
+                .iter()
+                .map(|(_, lag)| *lag)
+                .max()
+                .unwrap_or_default()
+                .as_millis() as u64,
+            concurrency,
+            measured_at: Some(Utc::now()),
+        }
+    }
+}
+
+/// Interval the monitor is checked on
+fn check_interval(monitor: &status_monitors::Model) -> Duration {
+    Duration::from_secs(monitor.check_interval_seconds.max(0) as u64).max(MIN_CHECK_INTERVAL)
+}
+
+/// Stable pseudo-random number of a monitor's check
+fn spread(monitor_id: i32, round: u64) -> u64 {
+    let mut hasher = DefaultHasher::new();
+    (monitor_id, round).hash(&mut hasher);
+    hasher.finish()
+}
+
+/// The interval lengthened or shortened by up to `jitter_percent` of it
+fn jittered(interval: Duration, monitor_id: i32, round: u64, jitter_percent: u32) -> Duration {
+    let max_jitter_ms = interval.as_millis() as u64 * u64::from(jitter_percent) / 100;
+    if max_jitter_ms == 0 {
+        return interval;
+    }
+    let jitter_ms = spread(monitor_id, round) % (2 * max_jitter_ms + 1);
+    interval + Duration::from_millis(jitter_ms) - Duration::from_millis(max_jitter_ms)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn monitor(id: i32, check_interval_seconds: i32) -> status_monitors::Model {
+        status_monitors::Model {
+            id,
+            project_id: 1,
+            environment_id: Some(1),
+            name: format!("monitor-{}", id),
+            monitor_type: "web".to_string(),
+            check_interval_seconds,
+            is_active: true,
+            consecutive_failures: 0,
+            health_state: "healthy".to_string(),
+            auto_restarts: 0,
+            last_auto_restart_at: None,
+            created_at: Utc::now(),
+            updated_at: Utc::now(),
+        }
+    }
+
+    #[test]
+    fn test_schedule_spreads_first_checks_over_the_interval() {
+        let now = Instant::now();
+        let mut schedule = MonitorSchedule::default();
+        schedule.sync((1..=100).map(|id| monitor(id, 60)).collect(), now);
+
+        assert!(schedule.lag(now, 10).overdue < 10);
+        assert_eq!(
+            schedule.take_due(now + Duration::from_secs(60), 100).len(),
+            100
+        );
+    }
+
+    #[test]
+    fn test_schedule_bounds_checks_in_flight() {
+        let now = Instant::now();
+        let mut schedule = MonitorSchedule::default();
+        for id in 1..=5 {
+            schedule.check_now(monitor(id, 60), now);
+        }
+
+        let started = schedule.take_due(now, 2);
+        assert_eq!(started.len(), 2);
+        assert_eq!(schedule.in_flight(), 2);
+
+        let lag = schedule.lag(now + Duration::from_secs(3), 2);
+        assert_eq!(lag.overdue, 3);
+        assert_eq!(lag.lag_ms, 3000);
+
+        // Checks in flight aren't started twice
+        schedule.finish(started[0].id);
+        let started_next = schedule.take_due(now + Duration::from_secs(3), 3);
+        assert_eq!(started_next.len(), 3);
+        assert!(started_next.iter().all(|m| m.id != started[1].id));
+        assert_eq!(
+            schedule.lag(now + Duration::from_secs(3), 2).peak_lag_ms,
+            3000
+        );
+    }
+
+    #[test]
+    fn test_schedule_honors_intervals() {
+        let now = Instant::now();
+        let mut schedule = MonitorSchedule::default();
+        schedule.check_now(monitor(1, 30), now);
+
+        assert_eq!(schedule.take_due(now, 10).len(), 1);
+        schedule.finish(1);
+        assert!(schedule
+            .take_due(now + Duration::from_secs(29), 10)
+            .is_empty());
+        assert_eq!(
+            schedule.take_due(now + Duration::from_secs(30), 10).len(),
+            1
+        );
+
+        // A monitor a whole interval behind skips the checks it missed
+        schedule.finish(1);
+        assert_eq!(
+            schedule.take_due(now + Duration::from_secs(200), 10).len(),
+            1
+        );
+        schedule.finish(1);
+        assert!(schedule
+            .take_due(now + Duration::from_secs(220), 10)
+            .is_empty());
+    }
+
+    #[test]
+    fn test_jittered_interval_stays_within_bounds() {
+        let interval = Duration::from_secs(60);
+        for round in 0..50 {
+            let jittered = jittered(interval, 7, round, 10);
+            assert!(jittered >= Duration::from_secs(54) && jittered <= Duration::from_secs(66));
+        }
+        assert_eq!(jittered(interval, 7, 1, 0), interval);
+    }
+
+    #[test]
+    fn test_removed_monitors_leave_the_schedule() {
+        let now = Instant::now();
+        let mut schedule = MonitorSchedule::default();
+        schedule.sync(vec![monitor(1, 60), monitor(2, 60)], now);
+        schedule.sync(vec![monitor(2, 60)], now);
+        assert_eq!(schedule.lag(now, 10).targets, 1);
+    }
+}
@@ -1,11 +1,13 @@
 pub mod health_check_service;
 pub mod incident_service;
 pub mod monitor_service;
+pub mod service_health;
 pub mod status_page_service;
 pub mod types;
 
 pub use health_check_service::HealthCheckService;
 pub use incident_service::IncidentService;
 pub use monitor_service::MonitorService;
+pub use service_health::ServiceHealthTracker;
 pub use status_page_service::StatusPageService;
 pub use types::*;
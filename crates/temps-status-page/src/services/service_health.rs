@@ -0,0 +1,365 @@
+//! Service Health
+//!
+//! Follows the health checks of each monitored service through two thresholds.
+//! After `unhealthyAfter` failed checks in a row the service is marked unhealthy,
+//! which is what alerts and its status show; a single failure only makes it failing.
+//! Every `restartAfter` failed checks in a row its containers are restarted, once
+//! more for each further streak of that many failures, so that a blip doesn't bounce
+//! a service that would recover by itself. A passed check resets the streak. Both
+//! thresholds are part of the project's or environment's deployment config.
+
+use chrono::Utc;
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, PaginatorTrait, QueryFilter,
+    Set,
+};
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Arc;
+use temps_core::notifications::{
+    NotificationData, NotificationPriority, NotificationService, NotificationType,
+};
+use temps_core::EnvironmentRedeployer;
+use temps_entities::deployment_config::{HealthThresholdsConfig, ServiceHealthState};
+use temps_entities::{environments, projects, status_monitors};
+use tracing::{error, info, warn};
+
+use super::types::{ServiceHealthMetrics, StatusPageError};
+
+/// Whether a check with a status failed; None for statuses that say nothing about
+/// the service
+///
+/// A degraded service still answered, so its checks pass.
+pub fn check_failed(status: &str) -> Option<bool> {
+    match status {
+        "operational" | "degraded" => Some(false),
+        "partial_outage" | "major_outage" => Some(true),
+        _ => None,
+    }
+}
+
+/// Where a service stands after a check
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct HealthUpdate {
+    pub consecutive_failures: u32,
+    pub state: ServiceHealthState,
+    /// Restart the service's containers now
+    pub restart: bool,
+}
+
+/// Update of a service's health for a check, given the checks it failed in a row
+/// before it
+pub fn next_health(
+    consecutive_failures: u32,
+    failed: bool,
+    thresholds: &HealthThresholdsConfig,
+) -> HealthUpdate {
+    let consecutive_failures = if failed {
+        consecutive_failures.saturating_add(1)
+    } else {
+        0
+    };
+    HealthUpdate {
+        consecutive_failures,
+        state: thresholds.state_after(consecutive_failures),
+        restart: thresholds.restart_due(consecutive_failures),
+    }
+}
+
+fn parse_state(state: &str) -> ServiceHealthState {
+    match state {
+        "failing" => ServiceHealthState::Failing,
+        "unhealthy" => ServiceHealthState::Unhealthy,
+        _ => ServiceHealthState::Healthy,
+    }
+}
+
+/// Tracks the health of monitored services and restarts the ones failing for long
+pub struct ServiceHealthTracker {
+    db: Arc<DatabaseConnection>,
+    redeployer: Option<Arc<dyn EnvironmentRedeployer>>,
+    notification_service: Option<Arc<dyn NotificationService>>,
+    auto_restarts: Arc<AtomicU64>,
+    auto_restart_failures: Arc<AtomicU64>,
+}
+
+impl ServiceHealthTracker {
+    pub fn new(db: Arc<DatabaseConnection>) -> Self {
+        Self {
+            db,
+            redeployer: None,
+            notification_service: None,
+            auto_restarts: Arc::new(AtomicU64::new(0)),
+            auto_restart_failures: Arc::new(AtomicU64::new(0)),
+        }
+    }
+
+    /// Restart services through the redeployer once they reach their restart
+    /// threshold; without one they are only marked unhealthy
+    pub fn with_redeployer(mut self, redeployer: Arc<dyn EnvironmentRedeployer>) -> Self {
+        self.redeployer = Some(redeployer);
+        self
+    }
+
+    /// Notify when a service turns unhealthy and when it recovers
+    pub fn with_notification_service(
+        mut self,
+        notification_service: Arc<dyn NotificationService>,
+    ) -> Self {
+        self.notification_service = Some(notification_service);
+        self
+    }
+
+    /// Thresholds of the environment's effective deployment config
+    async fn thresholds(
+        &self,
+        project_id: i32,
+        environment_id: i32,
+    ) -> Result<HealthThresholdsConfig, StatusPageError> {
+        let project = projects::Entity::find_by_id(project_id)
+            .one(self.db.as_ref())
+            .await?;
+        let environment = environments::Entity::find_by_id(environment_id)
+            .one(self.db.as_ref())
+            .await?;
+        let (Some(project), Some(environment)) = (project, environment) else {
+            return Ok(HealthThresholdsConfig::default());
+        };
+        let config = environment
+            .get_effective_deployment_config(&project.deployment_config.unwrap_or_default());
+        Ok(config.health_thresholds.unwrap_or_default())
+    }
+
+    /// Record a check of a monitor's service and act on its new health
+    pub async fn record(
+        &self,
+        monitor: &status_monitors::Model,
+        status: &str,
+    ) -> Result<(), StatusPageError> {
+        let Some(failed) = check_failed(status) else {
+            return Ok(());
+        };
+        let Some(environment_id) = monitor.environment_id else {
+            return Ok(());
+        };
+        // The scheduler's copy of the monitor may be older than the latest check
+        let Some(current) = status_monitors::Entity::find_by_id(monitor.id)
+            .one(self.db.as_ref())
+            .await?
+        else {
+            return Ok(());
+        };
+
+        let thresholds = self.thresholds(current.project_id, environment_id).await?;
+        let previous = parse_state(&current.health_state);
+        let update = next_health(
+            current.consecutive_failures.max(0) as u32,
+            failed,
+            &thresholds,
+        );
+        if update.consecutive_failures == 0 && current.consecutive_failures == 0 {
+            return Ok(());
+        }
+
+        let mut active: status_monitors::ActiveModel = current.clone().into();
+        active.consecutive_failures = Set(update.consecutive_failures as i32);
+        active.health_state = Set(update.state.as_str().to_string());
+        if update.consecutive_failures == 0 {
+            active.auto_restarts = Set(0);
+        }
+        if update.restart {
+            active.auto_restarts = Set(current.auto_restarts + 1);
+            active.last_auto_restart_at = Set(Some(Utc::now()));
+        }
+        active.update(self.db.as_ref()).await?;
+
+        if previous != update.state {
+            info!(
+                "Service of monitor {} (environment {}) is now {} after {} failed checks in a row",
+                current.id,
+                environment_id,
+                update.state.as_str(),
+                update.consecutive_failures
+            );
+        }
+        if previous != ServiceHealthState::Unhealthy
+            && update.state == ServiceHealthState::Unhealthy
+        {
+            self.notify(&current, environment_id, &update, false).await;
+        } else if previous == ServiceHealthState::Unhealthy
+            && update.state == ServiceHealthState::Healthy
+        {
+            self.notify(&current, environment_id, &update, true).await;
+        }
+
+        if update.restart {
+            self.restart(
+                current.project_id,
+                environment_id,
+                update.consecutive_failures,
+            );
+        }
+        Ok(())
+    }
+
+    /// Restart the environment's containers in the background, so that the check
+    /// doesn't hold its slot while they stop and start
+    fn restart(&self, project_id: i32, environment_id: i32, consecutive_failures: u32) {
+        let Some(redeployer) = self.redeployer.clone() else {
+            warn!(
+                "Environment {} failed {} checks in a row but no redeployer is available to restart it",
+                environment_id, consecutive_failures
+            );
+            return;
+        };
+        let auto_restarts = self.auto_restarts.clone();
+        let auto_restart_failures = self.auto_restart_failures.clone();
+        warn!(
+            "Restarting environment {} after {} failed checks in a row",
+            environment_id, consecutive_failures
+        );
+        tokio::spawn(async move {
+            auto_restarts.fetch_add(1, Ordering::Relaxed);
+            if let Err(e) = redeployer
+                .restart_environment(project_id, environment_id)
+                .await
+            {
+                auto_restart_failures.fetch_add(1, Ordering::Relaxed);
+                error!(
+                    "Failed to restart environment {} after failed checks: {}",
+                    environment_id, e
+                );
+            }
+        });
+    }
+
+    async fn notify(
+        &self,
+        monitor: &status_monitors::Model,
+        environment_id: i32,
+        update: &HealthUpdate,
+        recovered: bool,
+    ) {
+        let Some(notification_service) = &self.notification_service else {
+            return;
+        };
+
+        let (title, message, notification_type, priority) = if recovered {
+            (
+                format!("Service recovered: {}", monitor.name),
+                format!(
+                    "{} passed its health check again after being marked unhealthy",
+                    monitor.name
+                ),
+                NotificationType::Info,
+                NotificationPriority::Normal,
+            )
+        } else {
+            (
+                format!("Service unhealthy: {}", monitor.name),
+                format!(
+                    "{} failed {} health checks in a row and is marked unhealthy",
+                    monitor.name, update.consecutive_failures
+                ),
+                NotificationType::Alert,
+                NotificationPriority::High,
+            )
+        };
+        let mut metadata = HashMap::new();
+        metadata.insert("monitor_id".to_string(), monitor.id.to_string());
+        metadata.insert("project_id".to_string(), monitor.project_id.to_string());
+        metadata.insert("environment_id".to_string(), environment_id.to_string());
+        metadata.insert(
+            "consecutive_failures".to_string(),
+            update.consecutive_failures.to_string(),
+        );
+
+        let notification = NotificationData {
+            id: temps_core::uuid::Uuid::new_v4().to_string(),
+            title,
+            message,
+            notification_type,
+            priority,
+            severity: Some(if recovered { "info" } else { "critical" }.to_string()),
+            timestamp: Utc::now(),
+            metadata,
+            bypass_throttling: false,
+        };
+        if let Err(e) = notification_service.send_notification(notification).await {
+            error!(
+                "Failed to send health notification for monitor {}: {}",
+                monitor.id, e
+            );
+        }
+    }
+
+    /// Active monitors by the health of their service, and the restarts made since the
+    /// server started
+    pub async fn metrics(&self) -> Result<ServiceHealthMetrics, StatusPageError> {
+        let count = |state: ServiceHealthState| {
+            status_monitors::Entity::find()
+                .filter(status_monitors::Column::IsActive.eq(true))
+                .filter(status_monitors::Column::HealthState.eq(state.as_str()))
+                .count(self.db.as_ref())
+        };
+        Ok(ServiceHealthMetrics {
+            healthy: count(ServiceHealthState::Healthy).await?,
+            failing: count(ServiceHealthState::Failing).await?,
+            unhealthy: count(ServiceHealthState::Unhealthy).await?,
+            auto_restarts: self.auto_restarts.load(Ordering::Relaxed),
+            auto_restart_failures: self.auto_restart_failures.load(Ordering::Relaxed),
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_check_failed() {
+        assert_eq!(check_failed("operational"), Some(false));
+        assert_eq!(check_failed("degraded"), Some(false));
+        assert_eq!(check_failed("major_outage"), Some(true));
+        assert_eq!(check_failed("unknown"), None);
+    }
+
+    #[test]
+    fn test_next_health_restarts_every_streak() {
+        let thresholds = HealthThresholdsConfig {
+            unhealthy_after: Some(2),
+            restart_after: Some(3),
+        };
+        let mut failures = 0;
+        let mut updates = Vec::new();
+        for _ in 0..6 {
+            let update = next_health(failures, true, &thresholds);
+            failures = update.consecutive_failures;
+            updates.push((update.state, update.restart));
+        }
+        assert_eq!(
+            updates,
+            vec![
+                (ServiceHealthState::Failing, false),
+                (ServiceHealthState::Unhealthy, false),
+                (ServiceHealthState::Unhealthy, true),
+                (ServiceHealthState::Unhealthy, false),
+                (ServiceHealthState::Unhealthy, false),
+                (ServiceHealthState::Unhealthy, true),
+            ]
+        );
+
+        let passed = next_health(failures, false, &thresholds);
+        assert_eq!(passed.consecutive_failures, 0);
+        assert_eq!(passed.state, ServiceHealthState::Healthy);
+        assert!(!passed.restart);
+    }
+
+    #[test]
+    fn test_next_health_without_restart_threshold() {
+        let thresholds = HealthThresholdsConfig::default();
+        let update = next_health(99, true, &thresholds);
+        assert_eq!(update.state, ServiceHealthState::Unhealthy);
+        assert!(!update.restart);
+    }
+}
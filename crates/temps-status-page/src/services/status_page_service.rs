@@ -1,14 +1,20 @@
-use sea_orm::DatabaseConnection;
+use chrono::Utc;
+use sea_orm::{ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, QueryOrder, QuerySelect};
 use std::sync::Arc;
 use temps_config::ConfigService;
+use temps_entities::deployments;
 use tracing::info;
 
 use super::incident_service::IncidentService;
 use super::monitor_service::MonitorService;
-use super::types::{MonitorStatus, StatusPageError, StatusPageOverview};
+use super::types::{MonitorStatus, RecentChange, StatusPageError, StatusPageOverview};
+
+/// States of deployments that went live
+const LIVE_DEPLOYMENT_STATES: &[&str] = &["completed", "deployed", "paused", "stopped"];
 
 /// Service for managing the overall status page
 pub struct StatusPageService {
+    db: Arc<DatabaseConnection>,
     monitor_service: Arc<MonitorService>,
     incident_service: Arc<IncidentService>,
 }
@@ -16,9 +22,10 @@ pub struct StatusPageService {
 impl StatusPageService {
     pub fn new(db: Arc<DatabaseConnection>, config_service: Arc<ConfigService>) -> Self {
         let monitor_service = Arc::new(MonitorService::new(db.clone(), config_service));
-        let incident_service = Arc::new(IncidentService::new(db));
+        let incident_service = Arc::new(IncidentService::new(db.clone()));
 
         Self {
+            db,
             monitor_service,
             incident_service,
         }
@@ -86,6 +93,10 @@ impl StatusPageService {
             .get_recent_incidents(project_id, environment_id, Some(5))
             .await?;
 
+        let recent_changes = self
+            .get_recent_changes(project_id, environment_id, 5)
+            .await?;
+
         // Determine overall status based on monitors and active incidents
         let overall_status = self.calculate_overall_status(&monitor_statuses, &recent_incidents);
 
@@ -93,9 +104,45 @@ impl StatusPageService {
             status: overall_status,
             monitors: monitor_statuses,
             recent_incidents,
+            recent_changes,
         })
     }
 
+    /// Deploys that went live in the last 30 days, newest first
+    pub async fn get_recent_changes(
+        &self,
+        project_id: i32,
+        environment_id: Option<i32>,
+        limit: u64,
+    ) -> Result<Vec<RecentChange>, StatusPageError> {
+        let start_date = Utc::now() - chrono::Duration::days(30);
+
+        let mut query = deployments::Entity::find()
+            .filter(deployments::Column::ProjectId.eq(project_id))
+            .filter(deployments::Column::State.is_in(LIVE_DEPLOYMENT_STATES.iter().copied()))
+            .filter(deployments::Column::CreatedAt.gte(start_date));
+
+        if let Some(env_id) = environment_id {
+            query = query.filter(deployments::Column::EnvironmentId.eq(env_id));
+        }
+
+        let deployments = query
+            .order_by_desc(deployments::Column::CreatedAt)
+            .limit(limit)
+            .all(self.db.as_ref())
+            .await?;
+
+        Ok(deployments
+            .into_iter()
+            .map(|deployment| RecentChange {
+                note: deployment.changelog_note(),
+                deployment_id: deployment.id,
+                environment_id: deployment.environment_id,
+                deployed_at: deployment.finished_at.unwrap_or(deployment.created_at),
+            })
+            .collect())
+    }
+
     /// Calculate overall system status
     fn calculate_overall_status(
         &self,
@@ -37,12 +37,54 @@ pub struct MonitorResponse {
     pub monitor_url: String, // The URL being monitored (constructed from environment)
     pub check_interval_seconds: i32,
     pub is_active: bool,
+    /// Health of the service from its latest checks: healthy, failing or unhealthy
+    pub health_state: String,
+    /// Checks failed in a row
+    pub consecutive_failures: i32,
+    /// Automatic restarts since the service last passed a check
+    pub auto_restarts: i32,
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub last_auto_restart_at: Option<UtcDateTime>,
     #[schema(value_type = String, format = "date-time")]
     pub created_at: UtcDateTime,
     #[schema(value_type = String, format = "date-time")]
     pub updated_at: UtcDateTime,
 }
 
+/// How far the health checks are behind their monitors' intervals
+#[derive(Debug, Clone, Default, Serialize, Deserialize, ToSchema)]
+pub struct HealthMonitorLag {
+    /// Active monitors being checked
+    pub targets: usize,
+    /// Checks running now
+    pub in_flight: usize,
+    /// Checks that are due but wait for a free slot
+    pub overdue: usize,
+    /// How long the longest-waiting due check has waited, in milliseconds
+    pub lag_ms: u64,
+    /// Longest a check started late over the last minute, in milliseconds
+    pub peak_lag_ms: u64,
+    /// Checks allowed to run at once
+    pub concurrency: usize,
+    #[schema(value_type = Option<String>, format = "date-time")]
+    pub measured_at: Option<UtcDateTime>,
+}
+
+/// Health of the monitored services, and the restarts made for failing ones
+#[derive(Debug, Clone, Default, Serialize, Deserialize, ToSchema)]
+pub struct ServiceHealthMetrics {
+    /// Active monitors whose service passed its latest check
+    pub healthy: u64,
+    /// Active monitors failing checks, below their unhealthy threshold
+    pub failing: u64,
+    /// Active monitors failing checks at or past their unhealthy threshold
+    pub unhealthy: u64,
+    /// Services restarted for failed checks since the server started
+    pub auto_restarts: u64,
+    /// Automatic restarts that failed since the server started
+    pub auto_restart_failures: u64,
+}
+
 #[derive(Debug, Serialize, Deserialize, ToSchema)]
 pub struct StatusCheckResponse {
     pub id: i32,
@@ -106,6 +148,19 @@ pub struct StatusPageOverview {
     pub status: String,
     pub monitors: Vec<MonitorStatus>,
     pub recent_incidents: Vec<IncidentResponse>,
+    /// Deploys that went live in the last 30 days, newest first
+    pub recent_changes: Vec<RecentChange>,
+}
+
+/// A deploy listed in the status page's recent changes
+#[derive(Debug, Serialize, Deserialize, ToSchema)]
+pub struct RecentChange {
+    pub deployment_id: i32,
+    pub environment_id: i32,
+    /// Release note of the deploy, or the first line of its commit message
+    pub note: Option<String>,
+    #[schema(value_type = String, format = "date-time")]
+    pub deployed_at: UtcDateTime,
 }
 
 #[derive(Debug, Serialize, Deserialize, ToSchema)]
@@ -200,6 +255,10 @@ impl From<temps_entities::status_monitors::Model> for MonitorResponse {
             monitor_url: String::new(), // Will be populated by service layer
             check_interval_seconds: model.check_interval_seconds,
             is_active: model.is_active,
+            health_state: model.health_state,
+            consecutive_failures: model.consecutive_failures,
+            auto_restarts: model.auto_restarts,
+            last_auto_restart_at: model.last_auto_restart_at,
             created_at: model.created_at,
             updated_at: model.updated_at,
         }
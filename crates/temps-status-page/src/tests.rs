@@ -1076,6 +1076,10 @@ mod integration_tests {
             postgres_acquire_timeout_secs: None,
             postgres_idle_timeout_secs: None,
             postgres_max_lifetime_secs: None,
+            proxy_log_batch_size: None,
+            proxy_log_flush_interval_ms: None,
+            proxy_log_workers: None,
+            proxy_log_queue_capacity: None,
         });
         let config_service = Arc::new(temps_config::ConfigService::new(server_config, db.clone()));
         let health_check_service = HealthCheckService::new(db.clone(), config_service);
@@ -2,6 +2,7 @@
 
 use chrono::{DateTime, Utc};
 use serde::{Deserialize, Serialize};
+use temps_core::DeploymentSuccessKind;
 use utoipa::ToSchema;
 
 /// All supported webhook event types
@@ -14,6 +15,8 @@ pub enum WebhookEventType {
     DeploymentFailed,
     DeploymentCancelled,
     DeploymentReady,
+    DeploymentQueueDelayed,
+    DeploymentRunningLong,
 
     // Project events
     ProjectCreated,
@@ -33,6 +36,8 @@ impl WebhookEventType {
             Self::DeploymentFailed,
             Self::DeploymentCancelled,
             Self::DeploymentReady,
+            Self::DeploymentQueueDelayed,
+            Self::DeploymentRunningLong,
             Self::ProjectCreated,
             Self::ProjectDeleted,
             Self::DomainCreated,
@@ -48,6 +53,8 @@ impl WebhookEventType {
             Self::DeploymentFailed => "deployment.failed",
             Self::DeploymentCancelled => "deployment.cancelled",
             Self::DeploymentReady => "deployment.ready",
+            Self::DeploymentQueueDelayed => "deployment.queue_delayed",
+            Self::DeploymentRunningLong => "deployment.running_long",
             Self::ProjectCreated => "project.created",
             Self::ProjectDeleted => "project.deleted",
             Self::DomainCreated => "domain.created",
@@ -63,6 +70,12 @@ impl WebhookEventType {
             "deployment.failed" | "deployment_failed" => Some(Self::DeploymentFailed),
             "deployment.cancelled" | "deployment_cancelled" => Some(Self::DeploymentCancelled),
             "deployment.ready" | "deployment_ready" => Some(Self::DeploymentReady),
+            "deployment.queue_delayed" | "deployment_queue_delayed" => {
+                Some(Self::DeploymentQueueDelayed)
+            }
+            "deployment.running_long" | "deployment_running_long" => {
+                Some(Self::DeploymentRunningLong)
+            }
             "project.created" | "project_created" => Some(Self::ProjectCreated),
             "project.deleted" | "project_deleted" => Some(Self::ProjectDeleted),
             "domain.created" | "domain_created" => Some(Self::DomainCreated),
@@ -108,6 +121,14 @@ impl WebhookEvent {
             payload,
         }
     }
+
+    /// Kind of the successful deployment the event is about (succeeded events only)
+    pub fn deployment_kind(&self) -> Option<DeploymentSuccessKind> {
+        match &self.payload {
+            WebhookPayload::Deployment(payload) => payload.deployment_kind,
+            _ => None,
+        }
+    }
 }
 
 /// Webhook payload variants for different event types
@@ -134,6 +155,19 @@ pub struct DeploymentPayload {
     pub error_message: Option<String>,
     pub started_at: Option<DateTime<Utc>>,
     pub finished_at: Option<DateTime<Utc>>,
+    /// Position among deployments waiting for a build slot (queue alerts only)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub queue_position: Option<u32>,
+    /// Seconds spent waiting or running so far (queue and slow build alerts only)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub elapsed_seconds: Option<u64>,
+    /// Usual duration of the environment's deployments (slow build alerts only)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub typical_seconds: Option<u64>,
+    /// Whether this is the environment's first successful deployment, the first after
+    /// a failure, or a routine one (succeeded events only)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub deployment_kind: Option<DeploymentSuccessKind>,
 }
 
 /// Project event payload
@@ -177,6 +211,10 @@ mod tests {
             WebhookEventType::from_str("deployment_created"),
             Some(WebhookEventType::DeploymentCreated)
         );
+        assert_eq!(
+            WebhookEventType::from_str(WebhookEventType::DeploymentQueueDelayed.as_str()),
+            Some(WebhookEventType::DeploymentQueueDelayed)
+        );
         assert_eq!(WebhookEventType::from_str("invalid"), None);
     }
 
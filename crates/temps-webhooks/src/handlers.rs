@@ -14,6 +14,7 @@ use std::sync::Arc;
 use temps_auth::{permission_guard, RequireAuth};
 use temps_core::error_builder::ErrorBuilder;
 use temps_core::problemdetails::Problem;
+use temps_core::DeploymentSuccessKind;
 use tracing::{error, info};
 use utoipa::{OpenApi, ToSchema};
 
@@ -48,6 +49,7 @@ impl WebhookState {
             UpdateWebhookRequestBody,
             WebhookDeliveryResponse,
             EventTypeResponse,
+            DeploymentSuccessKind,
         )
     ),
     info(
@@ -72,6 +74,8 @@ pub struct WebhookResponse {
     pub project_id: i32,
     pub url: String,
     pub events: Vec<String>,
+    /// Kinds of successful deployments `deployment.succeeded` is sent for; all when empty
+    pub deployment_kinds: Vec<DeploymentSuccessKind>,
     pub enabled: bool,
     pub has_secret: bool,
     #[schema(example = "2025-10-12T12:15:47.609192Z")]
@@ -83,11 +87,17 @@ pub struct WebhookResponse {
 impl From<temps_entities::webhooks::Model> for WebhookResponse {
     fn from(webhook: temps_entities::webhooks::Model) -> Self {
         let events: Vec<String> = serde_json::from_str(&webhook.events).unwrap_or_default();
+        let deployment_kinds = webhook
+            .deployment_kinds
+            .as_deref()
+            .and_then(|kinds| serde_json::from_str(kinds).ok())
+            .unwrap_or_default();
         Self {
             id: webhook.id,
             project_id: webhook.project_id,
             url: webhook.url,
             events,
+            deployment_kinds,
             enabled: webhook.enabled,
             has_secret: webhook.secret.is_some(),
             created_at: webhook.created_at,
@@ -106,6 +116,11 @@ pub struct CreateWebhookRequestBody {
     /// Event types to subscribe to
     #[schema(example = json!(["deployment.created", "deployment.succeeded"]))]
     pub events: Vec<String>,
+    /// Only send `deployment.succeeded` for these kinds of successful deployments,
+    /// such as the environment's first one; all of them when empty
+    #[serde(default)]
+    #[schema(example = json!(["first", "recovery"]))]
+    pub deployment_kinds: Vec<DeploymentSuccessKind>,
     /// Whether the webhook is enabled
     #[schema(default = true)]
     pub enabled: Option<bool>,
@@ -119,6 +134,9 @@ pub struct UpdateWebhookRequestBody {
     pub secret: Option<String>,
     /// Event types to subscribe to
     pub events: Option<Vec<String>>,
+    /// Kinds of successful deployments to send `deployment.succeeded` for; an empty
+    /// list sends all of them
+    pub deployment_kinds: Option<Vec<DeploymentSuccessKind>>,
     /// Whether the webhook is enabled
     pub enabled: Option<bool>,
 }
@@ -309,6 +327,7 @@ async fn create_webhook(
         url: body.url,
         secret: body.secret,
         events,
+        deployment_kinds: body.deployment_kinds,
         enabled: body.enabled.unwrap_or(true),
     };
 
@@ -376,6 +395,7 @@ async fn update_webhook(
         url: body.url,
         secret: body.secret,
         events,
+        deployment_kinds: body.deployment_kinds,
         enabled: body.enabled,
     };
 
@@ -658,6 +678,18 @@ async fn list_event_types() -> impl IntoResponse {
             description: "Triggered when a deployment is ready to receive traffic".to_string(),
             category: "Deployment".to_string(),
         },
+        EventTypeResponse {
+            event_type: "deployment.queue_delayed".to_string(),
+            description: "Triggered when a deployment has waited long for a build slot".to_string(),
+            category: "Deployment".to_string(),
+        },
+        EventTypeResponse {
+            event_type: "deployment.running_long".to_string(),
+            description:
+                "Triggered when a deployment runs longer than its environment usually takes"
+                    .to_string(),
+            category: "Deployment".to_string(),
+        },
         EventTypeResponse {
             event_type: "project.created".to_string(),
             description: "Triggered when a new project is created".to_string(),
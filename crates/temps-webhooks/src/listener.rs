@@ -127,19 +127,30 @@ impl WebhookEventListener {
                     "Processing DeploymentSucceeded event for deployment {}",
                     event.deployment_id
                 );
-                Self::trigger_webhook(
+                let payload = WebhookPayload::Deployment(DeploymentPayload {
+                    deployment_id: event.deployment_id,
+                    project_id: event.project_id,
+                    project_name: String::new(), // TODO: Fetch from database
+                    environment: event.environment_name.clone(),
+                    branch: None, // Branch not in succeeded event
+                    commit_sha: event.commit_sha.clone(),
+                    commit_message: None,
+                    url: event.url.clone(),
+                    status: "succeeded".to_string(),
+                    error_message: None,
+                    started_at: None, // TODO: Get started_at from database
+                    finished_at: Some(chrono::Utc::now()),
+                    queue_position: None,
+                    elapsed_seconds: None,
+                    typical_seconds: None,
+                    deployment_kind: Some(event.kind),
+                });
+                Self::send_webhook(
                     webhook_service,
                     WebhookEventType::DeploymentSucceeded,
                     event.project_id,
                     event.deployment_id,
-                    event.environment_name.clone(),
-                    None, // Branch not in succeeded event
-                    event.commit_sha.clone(),
-                    event.url.clone(),
-                    "succeeded".to_string(),
-                    None, // No error
-                    None, // TODO: Get started_at from database
-                    Some(chrono::Utc::now()),
+                    payload,
                 )
                 .await?;
             }
@@ -206,6 +217,70 @@ impl WebhookEventListener {
                 )
                 .await?;
             }
+            Job::DeploymentQueueDelayed(event) => {
+                debug!(
+                    "Processing DeploymentQueueDelayed event for deployment {}",
+                    event.deployment_id
+                );
+                let payload = WebhookPayload::Deployment(DeploymentPayload {
+                    deployment_id: event.deployment_id,
+                    project_id: event.project_id,
+                    project_name: String::new(), // TODO: Fetch from database
+                    environment: event.environment_name.clone(),
+                    branch: None,
+                    commit_sha: None,
+                    commit_message: None,
+                    url: None,
+                    status: "queued".to_string(),
+                    error_message: None,
+                    started_at: None, // Not started yet
+                    finished_at: None,
+                    queue_position: Some(event.queue_position),
+                    elapsed_seconds: Some(event.waiting_seconds),
+                    typical_seconds: None,
+                    deployment_kind: None,
+                });
+                Self::send_webhook(
+                    webhook_service,
+                    WebhookEventType::DeploymentQueueDelayed,
+                    event.project_id,
+                    event.deployment_id,
+                    payload,
+                )
+                .await?;
+            }
+            Job::DeploymentRunningLong(event) => {
+                debug!(
+                    "Processing DeploymentRunningLong event for deployment {}",
+                    event.deployment_id
+                );
+                let payload = WebhookPayload::Deployment(DeploymentPayload {
+                    deployment_id: event.deployment_id,
+                    project_id: event.project_id,
+                    project_name: String::new(), // TODO: Fetch from database
+                    environment: event.environment_name.clone(),
+                    branch: None,
+                    commit_sha: None,
+                    commit_message: None,
+                    url: None,
+                    status: "running".to_string(),
+                    error_message: None,
+                    started_at: None,
+                    finished_at: None, // Still running
+                    queue_position: None,
+                    elapsed_seconds: Some(event.running_seconds),
+                    typical_seconds: Some(event.typical_seconds),
+                    deployment_kind: None,
+                });
+                Self::send_webhook(
+                    webhook_service,
+                    WebhookEventType::DeploymentRunningLong,
+                    event.project_id,
+                    event.deployment_id,
+                    payload,
+                )
+                .await?;
+            }
             _ => {
                 // Ignore other job types
                 return Ok(());
@@ -249,8 +324,30 @@ impl WebhookEventListener {
             error_message: error_message.clone(),
             started_at,
             finished_at,
+            queue_position: None,
+            elapsed_seconds: None,
+            typical_seconds: None,
+            deployment_kind: None,
         });
 
+        Self::send_webhook(
+            webhook_service,
+            event_type,
+            project_id,
+            deployment_id,
+            payload,
+        )
+        .await
+    }
+
+    /// Trigger the webhooks of a project for a deployment event payload
+    async fn send_webhook(
+        webhook_service: &WebhookService,
+        event_type: WebhookEventType,
+        project_id: i32,
+        deployment_id: i32,
+        payload: WebhookPayload,
+    ) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
         let webhook_event = WebhookEvent::new(event_type, Some(project_id), payload);
 
         debug!(
@@ -9,6 +9,7 @@ use sea_orm::{
 };
 use sha2::Sha256;
 use std::sync::Arc;
+use temps_core::DeploymentSuccessKind;
 use thiserror::Error;
 use tracing::{error, info, warn};
 use url; // For URL validation
@@ -54,6 +55,8 @@ pub struct CreateWebhookRequest {
     pub url: String,
     pub secret: Option<String>,
     pub events: Vec<WebhookEventType>,
+    /// Kinds of successful deployments to send `deployment.succeeded` for; all when empty
+    pub deployment_kinds: Vec<DeploymentSuccessKind>,
     pub enabled: bool,
 }
 
@@ -63,6 +66,8 @@ pub struct UpdateWebhookRequest {
     pub url: Option<String>,
     pub secret: Option<String>,
     pub events: Option<Vec<WebhookEventType>>,
+    /// Replaces the deployment kinds; an empty list sends all of them again
+    pub deployment_kinds: Option<Vec<DeploymentSuccessKind>>,
     pub enabled: Option<bool>,
 }
 
@@ -114,12 +119,14 @@ impl WebhookService {
 
         // Serialize events to JSON
         let events_json = serde_json::to_string(&request.events)?;
+        let deployment_kinds_json = deployment_kinds_json(&request.deployment_kinds)?;
 
         let webhook = temps_entities::webhooks::ActiveModel {
             project_id: Set(request.project_id),
             url: Set(request.url),
             secret: Set(encrypted_secret),
             events: Set(events_json),
+            deployment_kinds: Set(deployment_kinds_json),
             enabled: Set(request.enabled),
             ..Default::default()
         };
@@ -191,6 +198,10 @@ impl WebhookService {
             active_model.events = Set(events_json);
         }
 
+        if let Some(deployment_kinds) = request.deployment_kinds {
+            active_model.deployment_kinds = Set(deployment_kinds_json(&deployment_kinds)?);
+        }
+
         if let Some(enabled) = request.enabled {
             active_model.enabled = Set(enabled);
         }
@@ -237,6 +248,12 @@ impl WebhookService {
             if !events.contains(&event.event_type) {
                 continue;
             }
+            if !accepts_deployment_kind(
+                webhook.deployment_kinds.as_deref(),
+                event.deployment_kind(),
+            ) {
+                continue;
+            }
 
             // Deliver the webhook
             let result = self.deliver_webhook(&webhook, &event).await;
@@ -533,10 +550,58 @@ impl WebhookService {
     }
 }
 
+/// Deployment kinds of a webhook as stored, NULL standing for all of them
+fn deployment_kinds_json(kinds: &[DeploymentSuccessKind]) -> Result<Option<String>, WebhookError> {
+    if kinds.is_empty() {
+        return Ok(None);
+    }
+    Ok(Some(serde_json::to_string(kinds)?))
+}
+
+/// Whether a webhook with the stored deployment kinds wants an event of the given
+/// deployment kind
+///
+/// Only successful deployment events have a kind; the others always go through.
+fn accepts_deployment_kind(kinds_json: Option<&str>, kind: Option<DeploymentSuccessKind>) -> bool {
+    let (Some(kinds_json), Some(kind)) = (kinds_json, kind) else {
+        return true;
+    };
+    match serde_json::from_str::<Vec<DeploymentSuccessKind>>(kinds_json) {
+        Ok(kinds) => kinds.is_empty() || kinds.contains(&kind),
+        Err(e) => {
+            warn!("Ignoring unreadable deployment kinds of webhook: {}", e);
+            true
+        }
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
+    #[test]
+    fn test_accepts_deployment_kind() {
+        let first_only = deployment_kinds_json(&[DeploymentSuccessKind::First])
+            .unwrap()
+            .unwrap();
+        assert!(accepts_deployment_kind(
+            Some(&first_only),
+            Some(DeploymentSuccessKind::First)
+        ));
+        assert!(!accepts_deployment_kind(
+            Some(&first_only),
+            Some(DeploymentSuccessKind::Routine)
+        ));
+        // Events without a kind aren't filtered
+        assert!(accepts_deployment_kind(Some(&first_only), None));
+        // No filter sends every kind
+        assert_eq!(deployment_kinds_json(&[]).unwrap(), None);
+        assert!(accepts_deployment_kind(
+            None,
+            Some(DeploymentSuccessKind::Recovery)
+        ));
+    }
+
     #[test]
     fn test_signature_generation() {
         let _encryption_service = Arc::new(
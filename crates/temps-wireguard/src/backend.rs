@@ -0,0 +1,434 @@
+//! WireGuard interface management
+//!
+//! The [`WireGuardBackend`] trait abstracts the host operations needed to run the VPN
+//! (mockable for testing). [`CommandWireGuardBackend`] drives the kernel WireGuard
+//! module through the `ip`, `wg` and `iptables` tools.
+//!
+//! Subnet scoping is enforced on the server with a dedicated `TEMPS-WG` iptables chain:
+//! traffic entering from the WireGuard interface, whether forwarded or addressed to the
+//! host itself, is only accepted for the subnets a peer was granted, everything else is
+//! dropped.
+
+use chrono::{DateTime, TimeZone, Utc};
+use ipnet::Ipv4Net;
+use std::collections::HashMap;
+use std::net::Ipv4Addr;
+use std::process::Stdio;
+use tokio::io::AsyncWriteExt;
+use tokio::process::Command;
+use tracing::debug;
+
+use crate::WireGuardError;
+
+/// iptables chain holding per-peer forwarding rules
+pub const FIREWALL_CHAIN: &str = "TEMPS-WG";
+
+/// Server side interface configuration
+#[derive(Debug, Clone)]
+pub struct InterfaceConfig {
+    pub name: String,
+    pub private_key: String,
+    pub listen_port: u16,
+    pub address: Ipv4Addr,
+    pub network: Ipv4Net,
+}
+
+/// A peer as installed on the interface
+#[derive(Debug, Clone)]
+pub struct PeerConfig {
+    pub id: i32,
+    pub public_key: String,
+    pub preshared_key: String,
+    pub address: Ipv4Addr,
+    pub allowed_subnets: Vec<String>,
+}
+
+/// Host operations required to run the VPN (mockable for testing)
+#[async_trait::async_trait]
+pub trait WireGuardBackend: Send + Sync {
+    /// Create (if needed) and configure the interface and firewall chain
+    async fn ensure_interface(&self, config: &InterfaceConfig) -> Result<(), WireGuardError>;
+    /// Remove the interface and its firewall rules, if present
+    async fn remove_interface(
+        &self,
+        interface: &str,
+        network: Ipv4Net,
+    ) -> Result<(), WireGuardError>;
+    /// Add or update a peer and its forwarding rules
+    async fn add_peer(&self, interface: &str, peer: &PeerConfig) -> Result<(), WireGuardError>;
+    /// Remove a peer and its forwarding rules
+    async fn remove_peer(&self, interface: &str, peer: &PeerConfig) -> Result<(), WireGuardError>;
+    /// Latest handshake time per peer public key
+    async fn latest_handshakes(
+        &self,
+        interface: &str,
+    ) -> Result<HashMap<String, DateTime<Utc>>, WireGuardError>;
+}
+
+/// Rules sending traffic from the interface through [`FIREWALL_CHAIN`]: both forwarded
+/// traffic and traffic to the host itself, which would otherwise reach every service
+/// listening on the host
+pub fn jump_rules(interface: &str) -> Vec<Vec<String>> {
+    ["FORWARD", "INPUT"]
+        .into_iter()
+        .map(|chain| {
+            [chain, "-i", interface, "-j", FIREWALL_CHAIN]
+                .into_iter()
+                .map(String::from)
+                .collect()
+        })
+        .collect()
+}
+
+/// NAT rule masquerading traffic leaving the VPN network through other interfaces
+fn masquerade_rule(interface: &str, network: &Ipv4Net) -> Vec<String> {
+    [
+        "POSTROUTING",
+        "-s",
+        &network.to_string(),
+        "!",
+        "-o",
+        interface,
+        "-j",
+        "MASQUERADE",
+    ]
+    .into_iter()
+    .map(String::from)
+    .collect()
+}
+
+fn peer_rule_comment(peer_id: i32) -> String {
+    format!("temps-wg-peer-{}", peer_id)
+}
+
+/// Build the `iptables -D` argument lists removing a peer's rules from `iptables -S` output
+pub fn peer_rule_deletions(listing: &str, peer_id: i32) -> Vec<Vec<String>> {
+    let comment = peer_rule_comment(peer_id);
+    listing
+        .lines()
+        .filter_map(|line| {
+            let args: Vec<&str> = line.split_whitespace().collect();
+            let matches = args
+                .windows(2)
+                .any(|pair| pair[0] == "--comment" && pair[1].trim_matches('"') == comment);
+            if !matches || args.first() != Some(&"-A") {
+                return None;
+            }
+            let mut delete: Vec<String> = args.iter().map(|arg| arg.to_string()).collect();
+            delete[0] = "-D".to_string();
+            Some(delete)
+        })
+        .collect()
+}
+
+/// Parse `wg show <interface> latest-handshakes` output
+pub fn parse_latest_handshakes(output: &str) -> HashMap<String, DateTime<Utc>> {
+    output
+        .lines()
+        .filter_map(|line| {
+            let mut parts = line.split_whitespace();
+            let public_key = parts.next()?;
+            let timestamp: i64 = parts.next()?.parse().ok()?;
+            // 0 means the peer never completed a handshake
+            if timestamp <= 0 {
+                return None;
+            }
+            let time = Utc.timestamp_opt(timestamp, 0).single()?;
+            Some((public_key.to_string(), time))
+        })
+        .collect()
+}
+
+/// [`WireGuardBackend`] that shells out to `ip`, `wg` and `iptables`
+#[derive(Debug, Default)]
+pub struct CommandWireGuardBackend;
+
+impl CommandWireGuardBackend {
+    pub fn new() -> Self {
+        Self
+    }
+
+    async fn run(
+        &self,
+        program: &str,
+        args: &[&str],
+        stdin: Option<&str>,
+    ) -> Result<String, WireGuardError> {
+        debug!("Running {} {}", program, args.join(" "));
+
+        let mut child = Command::new(program)
+            .args(args)
+            .stdin(if stdin.is_some() {
+                Stdio::piped()
+            } else {
+                Stdio::null()
+            })
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .spawn()
+            .map_err(|e| WireGuardError::Command(format!("Failed to run {}: {}", program, e)))?;
+
+        if let (Some(input), Some(mut pipe)) = (stdin, child.stdin.take()) {
+            pipe.write_all(input.as_bytes())
+                .await
+                .map_err(|e| WireGuardError::Command(format!("Failed to write stdin: {}", e)))?;
+        }
+
+        let output = child
+            .wait_with_output()
+            .await
+            .map_err(|e| WireGuardError::Command(format!("Failed to run {}: {}", program, e)))?;
+
+        if !output.status.success() {
+            return Err(WireGuardError::Command(format!(
+                "{} {} failed: {}",
+                program,
+                args.join(" "),
+                String::from_utf8_lossy(&output.stderr).trim()
+            )));
+        }
+
+        Ok(String::from_utf8_lossy(&output.stdout).to_string())
+    }
+
+    /// Run `iptables -C ...` and append/insert the rule if it is missing
+    async fn ensure_rule(
+        &self,
+        table: &str,
+        action: &str,
+        rule: &[&str],
+    ) -> Result<(), WireGuardError> {
+        let mut check = vec!["-t", table, "-C"];
+        check.extend_from_slice(rule);
+        if self.run("iptables", &check, None).await.is_ok() {
+            return Ok(());
+        }
+
+        let mut add = vec!["-t", table, action];
+        add.extend_from_slice(rule);
+        self.run("iptables", &add, None).await.map(|_| ())
+    }
+
+    /// Delete every copy of a rule, if there is any
+    async fn delete_rule(&self, table: &str, rule: &[&str]) -> Result<(), WireGuardError> {
+        let mut check = vec!["-t", table, "-C"];
+        check.extend_from_slice(rule);
+        let mut delete = vec!["-t", table, "-D"];
+        delete.extend_from_slice(rule);
+        while self.run("iptables", &check, None).await.is_ok() {
+            self.run("iptables", &delete, None).await?;
+        }
+        Ok(())
+    }
+
+    async fn remove_peer_rules(&self, peer_id: i32) -> Result<(), WireGuardError> {
+        let listing = self.run("iptables", &["-S", FIREWALL_CHAIN], None).await?;
+        for delete in peer_rule_deletions(&listing, peer_id) {
+            let args: Vec<&str> = delete.iter().map(String::as_str).collect();
+            self.run("iptables", &args, None).await?;
+        }
+        Ok(())
+    }
+}
+
+#[async_trait::async_trait]
+impl WireGuardBackend for CommandWireGuardBackend {
+    async fn ensure_interface(&self, config: &InterfaceConfig) -> Result<(), WireGuardError> {
+        let name = config.name.as_str();
+
+        if self
+            .run("ip", &["link", "show", "dev", name], None)
+            .await
+            .is_err()
+        {
+            self.run(
+                "ip",
+                &["link", "add", "dev", name, "type", "wireguard"],
+                None,
+            )
+            .await?;
+        }
+
+        let address = format!("{}/{}", config.address, config.network.prefix_len());
+        self.run("ip", &["address", "replace", &address, "dev", name], None)
+            .await?;
+
+        let port = config.listen_port.to_string();
+        self.run(
+            "wg",
+            &[
+                "set",
+                name,
+                "listen-port",
+                &port,
+                "private-key",
+                "/dev/stdin",
+            ],
+            Some(&config.private_key),
+        )
+        .await?;
+        self.run("ip", &["link", "set", "up", "dev", name], None)
+            .await?;
+
+        // Peer chain: per-peer ACCEPT rules are inserted above the final DROP
+        if self
+            .run("iptables", &["-S", FIREWALL_CHAIN], None)
+            .await
+            .is_err()
+        {
+            self.run("iptables", &["-N", FIREWALL_CHAIN], None).await?;
+        }
+        self.ensure_rule("filter", "-A", &[FIREWALL_CHAIN, "-j", "DROP"])
+            .await?;
+        for rule in jump_rules(name) {
+            let rule: Vec<&str> = rule.iter().map(String::as_str).collect();
+            self.ensure_rule("filter", "-I", &rule).await?;
+        }
+
+        let masquerade = masquerade_rule(name, &config.network);
+        let masquerade: Vec<&str> = masquerade.iter().map(String::as_str).collect();
+        self.ensure_rule("nat", "-A", &masquerade).await?;
+
+        Ok(())
+    }
+
+    async fn remove_interface(
+        &self,
+        interface: &str,
+        network: Ipv4Net,
+    ) -> Result<(), WireGuardError> {
+        for rule in jump_rules(interface) {
+            let rule: Vec<&str> = rule.iter().map(String::as_str).collect();
+            self.delete_rule("filter", &rule).await?;
+        }
+        let masquerade = masquerade_rule(interface, &network);
+        let masquerade: Vec<&str> = masquerade.iter().map(String::as_str).collect();
+        self.delete_rule("nat", &masquerade).await?;
+
+        if self
+            .run("iptables", &["-S", FIREWALL_CHAIN], None)
+            .await
+            .is_ok()
+        {
+            self.run("iptables", &["-F", FIREWALL_CHAIN], None).await?;
+            self.run("iptables", &["-X", FIREWALL_CHAIN], None).await?;
+        }
+
+        if self
+            .run("ip", &["link", "show", "dev", interface], None)
+            .await
+            .is_ok()
+        {
+            self.run("ip", &["link", "delete", "dev", interface], None)
+                .await?;
+        }
+
+        Ok(())
+    }
+
+    async fn add_peer(&self, interface: &str, peer: &PeerConfig) -> Result<(), WireGuardError> {
+        let allowed_ips = format!("{}/32", peer.address);
+        self.run(
+            "wg",
+            &[
+                "set",
+                interface,
+                "peer",
+                &peer.public_key,
+                "preshared-key",
+                "/dev/stdin",
+                "allowed-ips",
+                &allowed_ips,
+            ],
+            Some(&peer.preshared_key),
+        )
+        .await?;
+
+        // Replace any stale rules so re-syncing a peer is idempotent
+        self.remove_peer_rules(peer.id).await?;
+        let comment = peer_rule_comment(peer.id);
+        for subnet in &peer.allowed_subnets {
+            self.run(
+                "iptables",
+                &[
+                    "-I",
+                    FIREWALL_CHAIN,
+                    "-s",
+                    &allowed_ips,
+                    "-d",
+                    subnet,
+                    "-m",
+                    "comment",
+                    "--comment",
+                    &comment,
+                    "-j",
+                    "ACCEPT",
+                ],
+                None,
+            )
+            .await?;
+        }
+
+        Ok(())
+    }
+
+    async fn remove_peer(&self, interface: &str, peer: &PeerConfig) -> Result<(), WireGuardError> {
+        self.run(
+            "wg",
+            &["set", interface, "peer", &peer.public_key, "remove"],
+            None,
+        )
+        .await?;
+        self.remove_peer_rules(peer.id).await
+    }
+
+    async fn latest_handshakes(
+        &self,
+        interface: &str,
+    ) -> Result<HashMap<String, DateTime<Utc>>, WireGuardError> {
+        let output = self
+            .run("wg", &["show", interface, "latest-handshakes"], None)
+            .await?;
+        Ok(parse_latest_handshakes(&output))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_peer_rule_deletions() {
+        let listing = "-N TEMPS-WG\n\
+            -A TEMPS-WG -s 10.66.0.2/32 -d 172.16.0.0/12 -m comment --comment temps-wg-peer-3 -j ACCEPT\n\
+            -A TEMPS-WG -s 10.66.0.4/32 -d 172.16.0.0/12 -m comment --comment temps-wg-peer-31 -j ACCEPT\n\
+            -A TEMPS-WG -j DROP\n";
+
+        let deletions = peer_rule_deletions(listing, 3);
+        assert_eq!(deletions.len(), 1);
+        assert_eq!(deletions[0][0], "-D");
+        assert!(deletions[0].contains(&"10.66.0.2/32".to_string()));
+    }
+
+    #[test]
+    fn test_interface_traffic_goes_through_peer_chain() {
+        let rules = jump_rules("wg-temps");
+
+        assert_eq!(
+            rules,
+            vec![
+                vec!["FORWARD", "-i", "wg-temps", "-j", "TEMPS-WG"],
+                vec!["INPUT", "-i", "wg-temps", "-j", "TEMPS-WG"],
+            ]
+        );
+    }
+
+    #[test]
+    fn test_parse_latest_handshakes() {
+        let output = "abc=\t1700000000\ndef=\t0\n";
+        let handshakes = parse_latest_handshakes(output);
+
+        assert_eq!(handshakes.len(), 1);
+        assert_eq!(handshakes["abc="].timestamp(), 1700000000);
+    }
+}
@@ -0,0 +1,78 @@
+//! WireGuard client configuration rendering
+
+use std::net::Ipv4Addr;
+
+/// Values needed to render a client (`wg-quick`) configuration file
+#[derive(Debug, Clone)]
+pub struct ClientConfig {
+    pub private_key: String,
+    pub address: Ipv4Addr,
+    pub server_public_key: String,
+    pub preshared_key: String,
+    /// `host:port` the client connects to
+    pub endpoint: String,
+    /// Subnets routed through the tunnel
+    pub allowed_ips: Vec<String>,
+}
+
+impl ClientConfig {
+    /// Render the configuration in `wg-quick` format
+    pub fn render(&self) -> String {
+        format!(
+            "[Interface]\n\
+            PrivateKey = {}\n\
+            Address = {}/32\n\
+            \n\
+            [Peer]\n\
+            PublicKey = {}\n\
+            PresharedKey = {}\n\
+            Endpoint = {}\n\
+            AllowedIPs = {}\n\
+            PersistentKeepalive = 25\n",
+            self.private_key,
+            self.address,
+            self.server_public_key,
+            self.preshared_key,
+            self.endpoint,
+            self.allowed_ips.join(", ")
+        )
+    }
+}
+
+/// Format an endpoint, bracketing IPv6 literals
+pub fn format_endpoint(host: &str, port: u16) -> String {
+    if host.contains(':') && !host.starts_with('[') {
+        format!("[{}]:{}", host, port)
+    } else {
+        format!("{}:{}", host, port)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_render_client_config() {
+        let config = ClientConfig {
+            private_key: "client-private".to_string(),
+            address: Ipv4Addr::new(10, 66, 0, 2),
+            server_public_key: "server-public".to_string(),
+            preshared_key: "psk".to_string(),
+            endpoint: format_endpoint("vpn.example.com", 51820),
+            allowed_ips: vec!["10.66.0.1/32".to_string(), "172.16.0.0/12".to_string()],
+        };
+
+        let rendered = config.render();
+        assert!(rendered.contains("PrivateKey = client-private\n"));
+        assert!(rendered.contains("Address = 10.66.0.2/32\n"));
+        assert!(rendered.contains("Endpoint = vpn.example.com:51820\n"));
+        assert!(rendered.contains("AllowedIPs = 10.66.0.1/32, 172.16.0.0/12\n"));
+    }
+
+    #[test]
+    fn test_format_ipv6_endpoint() {
+        assert_eq!(format_endpoint("2001:db8::1", 51820), "[2001:db8::1]:51820");
+        assert_eq!(format_endpoint("203.0.113.5", 51820), "203.0.113.5:51820");
+    }
+}
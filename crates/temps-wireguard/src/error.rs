@@ -0,0 +1,68 @@
+//! Error types for WireGuard peer management
+
+use axum::http::StatusCode;
+use temps_core::problemdetails::{self, Problem};
+use thiserror::Error;
+
+#[derive(Error, Debug)]
+pub enum WireGuardError {
+    #[error("WireGuard is not enabled")]
+    NotEnabled,
+
+    #[error("Peer not found: {0}")]
+    PeerNotFound(i32),
+
+    #[error("Invalid input: {0}")]
+    Validation(String),
+
+    #[error("No free addresses left in {0}")]
+    AddressPoolExhausted(String),
+
+    #[error("Database error: {0}")]
+    Database(#[from] sea_orm::DbErr),
+
+    #[error("Command failed: {0}")]
+    Command(String),
+
+    #[error("Internal error: {0}")]
+    Internal(String),
+}
+
+impl From<WireGuardError> for Problem {
+    fn from(error: WireGuardError) -> Self {
+        match error {
+            WireGuardError::NotEnabled => problemdetails::new(StatusCode::CONFLICT)
+                .with_title("WireGuard Not Enabled")
+                .with_detail("Enable WireGuard in Settings before managing peers"),
+
+            WireGuardError::PeerNotFound(id) => problemdetails::new(StatusCode::NOT_FOUND)
+                .with_title("Peer Not Found")
+                .with_detail(format!("WireGuard peer {} does not exist", id)),
+
+            WireGuardError::Validation(msg) => problemdetails::new(StatusCode::BAD_REQUEST)
+                .with_title("Invalid Input")
+                .with_detail(msg),
+
+            WireGuardError::AddressPoolExhausted(range) => {
+                problemdetails::new(StatusCode::CONFLICT)
+                    .with_title("Address Pool Exhausted")
+                    .with_detail(format!(
+                        "No free addresses left in {}; revoke unused peers or widen the range",
+                        range
+                    ))
+            }
+
+            WireGuardError::Database(e) => problemdetails::new(StatusCode::INTERNAL_SERVER_ERROR)
+                .with_title("Database Error")
+                .with_detail(e.to_string()),
+
+            WireGuardError::Command(msg) => problemdetails::new(StatusCode::INTERNAL_SERVER_ERROR)
+                .with_title("WireGuard Error")
+                .with_detail(msg),
+
+            WireGuardError::Internal(msg) => problemdetails::new(StatusCode::INTERNAL_SERVER_ERROR)
+                .with_title("Internal Error")
+                .with_detail(msg),
+        }
+    }
+}
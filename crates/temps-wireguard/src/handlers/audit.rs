@@ -0,0 +1,71 @@
+//! Audit types for WireGuard peer management operations
+
+use anyhow::Result;
+use serde::Serialize;
+pub use temps_core::AuditContext;
+use temps_core::AuditOperation;
+
+/// Audit event for creating a WireGuard peer
+#[derive(Debug, Clone, Serialize)]
+pub struct WireGuardPeerCreatedAudit {
+    pub context: AuditContext,
+    pub peer_id: i32,
+    pub name: String,
+    pub address: String,
+    pub allowed_subnets: Vec<String>,
+}
+
+/// Audit event for revoking a WireGuard peer
+#[derive(Debug, Clone, Serialize)]
+pub struct WireGuardPeerRevokedAudit {
+    pub context: AuditContext,
+    pub peer_id: i32,
+    pub name: String,
+    pub address: String,
+}
+
+impl AuditOperation for WireGuardPeerCreatedAudit {
+    fn operation_type(&self) -> String {
+        "WIREGUARD_PEER_CREATED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation: {}", e))
+    }
+}
+
+impl AuditOperation for WireGuardPeerRevokedAudit {
+    fn operation_type(&self) -> String {
+        "WIREGUARD_PEER_REVOKED".to_string()
+    }
+
+    fn user_id(&self) -> i32 {
+        self.context.user_id
+    }
+
+    fn ip_address(&self) -> Option<String> {
+        self.context.ip_address.clone()
+    }
+
+    fn user_agent(&self) -> &str {
+        &self.context.user_agent
+    }
+
+    fn serialize(&self) -> Result<String> {
+        serde_json::to_string(self)
+            .map_err(|e| anyhow::anyhow!("Failed to serialize audit operation: {}", e))
+    }
+}
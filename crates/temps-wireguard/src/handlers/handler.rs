@@ -0,0 +1,250 @@
+//! HTTP handlers for WireGuard peer management
+
+use std::sync::Arc;
+
+use axum::{
+    extract::{Extension, Path, Query, State},
+    http::StatusCode,
+    response::IntoResponse,
+    routing::get,
+    Json, Router,
+};
+use temps_auth::{permission_guard, RequireAuth};
+use temps_core::problemdetails::Problem;
+use temps_core::RequestMetadata;
+use tracing::error;
+use utoipa::OpenApi;
+
+use super::audit::{AuditContext, WireGuardPeerCreatedAudit, WireGuardPeerRevokedAudit};
+use super::types::*;
+use crate::services::{CreatePeer, WireGuardStatus};
+
+/// OpenAPI documentation for WireGuard endpoints
+#[derive(OpenApi)]
+#[openapi(
+    paths(get_status, list_peers, create_peer, get_peer, revoke_peer),
+    components(schemas(
+        WireGuardStatus,
+        WireGuardPeerResponse,
+        CreateWireGuardPeerRequest,
+        CreateWireGuardPeerResponse,
+        ListPeersQuery
+    )),
+    info(
+        title = "WireGuard VPN API",
+        description = "API endpoints for managing WireGuard peers that give operators \
+        private access to internal services and managed databases.",
+        version = "1.0.0"
+    ),
+    tags(
+        (name = "WireGuard", description = "WireGuard VPN peer management")
+    )
+)]
+pub struct WireGuardApiDoc;
+
+/// Configure WireGuard routes
+pub fn configure_routes() -> Router<Arc<WireGuardAppState>> {
+    Router::new()
+        .route("/wireguard/status", get(get_status))
+        .route("/wireguard/peers", get(list_peers).post(create_peer))
+        .route("/wireguard/peers/{id}", get(get_peer).delete(revoke_peer))
+}
+
+/// Get the WireGuard VPN status
+#[utoipa::path(
+    tag = "WireGuard",
+    get,
+    path = "/wireguard/status",
+    responses(
+        (status = 200, description = "WireGuard status", body = WireGuardStatus),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_status(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<WireGuardAppState>>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    let status = state.wireguard_service.status().await?;
+    Ok(Json(status))
+}
+
+/// List WireGuard peers
+#[utoipa::path(
+    tag = "WireGuard",
+    get,
+    path = "/wireguard/peers",
+    params(
+        ("include_revoked" = Option<bool>, Query, description = "Include revoked peers (default: false)")
+    ),
+    responses(
+        (status = 200, description = "List of peers", body = Vec<WireGuardPeerResponse>),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn list_peers(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<WireGuardAppState>>,
+    Query(query): Query<ListPeersQuery>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    let peers = state
+        .wireguard_service
+        .list_peers(query.include_revoked)
+        .await?;
+    Ok(Json(
+        peers
+            .into_iter()
+            .map(WireGuardPeerResponse::from)
+            .collect::<Vec<_>>(),
+    ))
+}
+
+/// Create a WireGuard peer and return its client configuration
+///
+/// The returned configuration contains the peer's private key, which is not stored and
+/// cannot be retrieved again.
+#[utoipa::path(
+    tag = "WireGuard",
+    post,
+    path = "/wireguard/peers",
+    request_body = CreateWireGuardPeerRequest,
+    responses(
+        (status = 201, description = "Peer created", body = CreateWireGuardPeerResponse),
+        (status = 400, description = "Invalid request"),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 409, description = "WireGuard not enabled or address pool exhausted"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn create_peer(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<WireGuardAppState>>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Json(request): Json<CreateWireGuardPeerRequest>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    let created = state
+        .wireguard_service
+        .create_peer(
+            CreatePeer {
+                name: request.name,
+                allowed_subnets: request.allowed_subnets,
+            },
+            Some(auth.user_id()),
+        )
+        .await?;
+
+    let peer = WireGuardPeerResponse::from(created.peer);
+
+    let audit = WireGuardPeerCreatedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        peer_id: peer.id,
+        name: peer.name.clone(),
+        address: peer.address.clone(),
+        allowed_subnets: peer.allowed_subnets.clone(),
+    };
+    if let Err(e) = state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+
+    Ok((
+        StatusCode::CREATED,
+        Json(CreateWireGuardPeerResponse {
+            peer,
+            client_config: created.client_config,
+        }),
+    ))
+}
+
+/// Get a WireGuard peer
+#[utoipa::path(
+    tag = "WireGuard",
+    get,
+    path = "/wireguard/peers/{id}",
+    params(
+        ("id" = i32, Path, description = "Peer ID")
+    ),
+    responses(
+        (status = 200, description = "Peer details", body = WireGuardPeerResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Peer not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn get_peer(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<WireGuardAppState>>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    let peer = state.wireguard_service.get_peer(id).await?;
+    Ok(Json(WireGuardPeerResponse::from(peer)))
+}
+
+/// Revoke a WireGuard peer
+///
+/// The peer is removed from the interface immediately and kept for auditing.
+#[utoipa::path(
+    tag = "WireGuard",
+    delete,
+    path = "/wireguard/peers/{id}",
+    params(
+        ("id" = i32, Path, description = "Peer ID")
+    ),
+    responses(
+        (status = 200, description = "Peer revoked", body = WireGuardPeerResponse),
+        (status = 401, description = "Unauthorized"),
+        (status = 403, description = "Insufficient permissions"),
+        (status = 404, description = "Peer not found"),
+        (status = 500, description = "Internal server error")
+    ),
+    security(("bearer_auth" = []))
+)]
+async fn revoke_peer(
+    RequireAuth(auth): RequireAuth,
+    State(state): State<Arc<WireGuardAppState>>,
+    Extension(metadata): Extension<RequestMetadata>,
+    Path(id): Path<i32>,
+) -> Result<impl IntoResponse, Problem> {
+    permission_guard!(auth, SystemAdmin);
+
+    let peer = state
+        .wireguard_service
+        .revoke_peer(id, Some(auth.user_id()))
+        .await?;
+
+    let audit = WireGuardPeerRevokedAudit {
+        context: AuditContext {
+            user_id: auth.user_id(),
+            ip_address: Some(metadata.ip_address.clone()),
+            user_agent: metadata.user_agent.clone(),
+        },
+        peer_id: peer.id,
+        name: peer.name.clone(),
+        address: peer.address.clone(),
+    };
+    if let Err(e) = state.audit_service.create_audit_log(&audit).await {
+        error!("Failed to create audit log: {}", e);
+    }
+
+    Ok(Json(WireGuardPeerResponse::from(peer)))
+}
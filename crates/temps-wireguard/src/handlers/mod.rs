@@ -0,0 +1,9 @@
+//! HTTP handlers for WireGuard peer management
+
+mod audit;
+mod handler;
+mod types;
+
+pub use audit::*;
+pub use handler::*;
+pub use types::*;
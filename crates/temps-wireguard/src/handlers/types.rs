@@ -0,0 +1,80 @@
+//! Request and response types for WireGuard endpoints
+
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::sync::Arc;
+use temps_core::AuditLogger;
+use temps_entities::wireguard_peers;
+use utoipa::ToSchema;
+
+use crate::WireGuardService;
+
+/// App state for WireGuard handlers
+pub struct WireGuardAppState {
+    pub wireguard_service: Arc<WireGuardService>,
+    pub audit_service: Arc<dyn AuditLogger>,
+}
+
+/// A WireGuard peer
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct WireGuardPeerResponse {
+    pub id: i32,
+    pub name: String,
+    pub public_key: String,
+    /// Tunnel address assigned to the peer
+    #[schema(example = "10.66.0.2")]
+    pub address: String,
+    /// Subnets the peer is allowed to reach
+    pub allowed_subnets: Vec<String>,
+    pub created_by: Option<i32>,
+    pub created_at: DateTime<Utc>,
+    pub last_handshake_at: Option<DateTime<Utc>>,
+    pub revoked_at: Option<DateTime<Utc>>,
+    pub revoked_by: Option<i32>,
+    pub active: bool,
+}
+
+impl From<wireguard_peers::Model> for WireGuardPeerResponse {
+    fn from(peer: wireguard_peers::Model) -> Self {
+        Self {
+            allowed_subnets: serde_json::from_value(peer.allowed_subnets).unwrap_or_default(),
+            active: peer.revoked_at.is_none(),
+            id: peer.id,
+            name: peer.name,
+            public_key: peer.public_key,
+            address: peer.address,
+            created_by: peer.created_by,
+            created_at: peer.created_at,
+            last_handshake_at: peer.last_handshake_at,
+            revoked_at: peer.revoked_at,
+            revoked_by: peer.revoked_by,
+        }
+    }
+}
+
+/// Request to create a WireGuard peer
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct CreateWireGuardPeerRequest {
+    /// Human readable name, e.g. the device or person using it
+    #[schema(example = "alice-laptop")]
+    pub name: String,
+    /// CIDR subnets the peer may reach; defaults to the configured default subnets
+    #[schema(example = json!(["172.18.0.0/16"]))]
+    pub allowed_subnets: Option<Vec<String>>,
+}
+
+/// Newly created peer with its client configuration
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct CreateWireGuardPeerResponse {
+    pub peer: WireGuardPeerResponse,
+    /// `wg-quick` configuration for the client. Contains the private key and is only
+    /// returned once.
+    pub client_config: String,
+}
+
+#[derive(Debug, Clone, Deserialize, ToSchema)]
+pub struct ListPeersQuery {
+    /// Include revoked peers (default: false)
+    #[serde(default)]
+    pub include_revoked: bool,
+}
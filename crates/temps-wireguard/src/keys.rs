@@ -0,0 +1,109 @@
+//! WireGuard key generation
+//!
+//! WireGuard keys are Curve25519 keys encoded as standard base64, the same format
+//! produced by `wg genkey` / `wg pubkey` / `wg genpsk`.
+
+use base64::{engine::general_purpose::STANDARD, Engine as _};
+use curve25519_dalek::montgomery::MontgomeryPoint;
+use rand::RngCore;
+
+use crate::WireGuardError;
+
+/// A WireGuard key pair (both keys base64-encoded)
+#[derive(Debug, Clone)]
+pub struct KeyPair {
+    pub private_key: String,
+    pub public_key: String,
+}
+
+fn random_bytes() -> [u8; 32] {
+    let mut bytes = [0u8; 32];
+    rand::thread_rng().fill_bytes(&mut bytes);
+    bytes
+}
+
+/// Clamp a scalar as required for X25519 private keys
+fn clamp(mut key: [u8; 32]) -> [u8; 32] {
+    key[0] &= 248;
+    key[31] &= 127;
+    key[31] |= 64;
+    key
+}
+
+fn decode_key(key: &str) -> Result<[u8; 32], WireGuardError> {
+    let bytes = STANDARD
+        .decode(key.trim())
+        .map_err(|_| WireGuardError::Validation("Key is not valid base64".to_string()))?;
+    bytes
+        .try_into()
+        .map_err(|_| WireGuardError::Validation("Key must be 32 bytes".to_string()))
+}
+
+/// Generate a new key pair
+pub fn generate_keypair() -> KeyPair {
+    let private_key = clamp(random_bytes());
+    KeyPair {
+        private_key: STANDARD.encode(private_key),
+        public_key: STANDARD.encode(MontgomeryPoint::mul_base_clamped(private_key).to_bytes()),
+    }
+}
+
+/// Derive the public key from a base64-encoded private key
+pub fn public_key_from_private(private_key: &str) -> Result<String, WireGuardError> {
+    let private_key = decode_key(private_key)?;
+    Ok(STANDARD.encode(MontgomeryPoint::mul_base_clamped(private_key).to_bytes()))
+}
+
+/// Generate a random preshared key for an additional layer of symmetric encryption
+pub fn generate_preshared_key() -> String {
+    STANDARD.encode(random_bytes())
+}
+
+/// Returns true if `key` is a well-formed WireGuard key
+pub fn is_valid_key(key: &str) -> bool {
+    decode_key(key).is_ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn hex_to_base64(hex: &str) -> String {
+        let bytes: Vec<u8> = (0..hex.len())
+            .step_by(2)
+            .map(|i| u8::from_str_radix(&hex[i..i + 2], 16).unwrap())
+            .collect();
+        STANDARD.encode(bytes)
+    }
+
+    #[test]
+    fn test_public_key_matches_rfc7748_vector() {
+        // RFC 7748 section 6.1 (Alice)
+        let private_key =
+            hex_to_base64("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a");
+        let expected =
+            hex_to_base64("8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a");
+
+        assert_eq!(public_key_from_private(&private_key).unwrap(), expected);
+    }
+
+    #[test]
+    fn test_generated_keypair_is_consistent() {
+        let keypair = generate_keypair();
+
+        assert!(is_valid_key(&keypair.private_key));
+        assert!(is_valid_key(&keypair.public_key));
+        assert_eq!(
+            public_key_from_private(&keypair.private_key).unwrap(),
+            keypair.public_key
+        );
+        assert_ne!(generate_keypair().private_key, keypair.private_key);
+    }
+
+    #[test]
+    fn test_invalid_keys_are_rejected() {
+        assert!(!is_valid_key("not base64!"));
+        assert!(!is_valid_key(&STANDARD.encode([0u8; 16])));
+        assert!(is_valid_key(&generate_preshared_key()));
+    }
+}
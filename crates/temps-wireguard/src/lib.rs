@@ -0,0 +1,20 @@
+//! temps-wireguard: WireGuard VPN access for operators
+//!
+//! Lets authorized users generate WireGuard client configs that tunnel into the
+//! private network, so internal services and managed databases can be reached
+//! directly without exposing their ports. Peers can be scoped to specific subnets,
+//! revoked at any time, and every change is recorded in the audit log.
+
+pub mod backend;
+pub mod config;
+pub mod error;
+pub mod handlers;
+pub mod keys;
+pub mod network;
+pub mod plugin;
+pub mod services;
+
+pub use backend::{CommandWireGuardBackend, InterfaceConfig, PeerConfig, WireGuardBackend};
+pub use error::WireGuardError;
+pub use plugin::WireGuardPlugin;
+pub use services::WireGuardService;
@@ -0,0 +1,108 @@
+//! Tunnel address allocation and subnet validation
+
+use ipnet::{IpNet, Ipv4Net};
+use std::collections::HashSet;
+use std::net::Ipv4Addr;
+
+use crate::WireGuardError;
+
+/// Parse the configured tunnel address range
+pub fn parse_address_range(range: &str) -> Result<Ipv4Net, WireGuardError> {
+    let network: Ipv4Net = range.trim().parse().map_err(|_| {
+        WireGuardError::Validation(format!("Invalid WireGuard address range: {}", range))
+    })?;
+
+    if network.prefix_len() > 30 {
+        return Err(WireGuardError::Validation(format!(
+            "WireGuard address range {} is too small",
+            range
+        )));
+    }
+
+    Ok(network.trunc())
+}
+
+/// The server's own tunnel address (the first usable host of the range)
+pub fn server_address(network: &Ipv4Net) -> Ipv4Addr {
+    network.hosts().next().unwrap_or_else(|| network.network())
+}
+
+/// Pick the lowest free peer address in `network`, skipping the server address
+pub fn allocate_address(network: &Ipv4Net, used: &HashSet<Ipv4Addr>) -> Option<Ipv4Addr> {
+    let server = server_address(network);
+    network
+        .hosts()
+        .find(|address| *address != server && !used.contains(address))
+}
+
+/// Validate and normalize the subnets a peer may reach
+pub fn normalize_subnets(subnets: &[String]) -> Result<Vec<String>, WireGuardError> {
+    if subnets.is_empty() {
+        return Err(WireGuardError::Validation(
+            "At least one allowed subnet is required".to_string(),
+        ));
+    }
+
+    let mut normalized = Vec::new();
+    for subnet in subnets {
+        let parsed: IpNet = subnet.trim().parse().map_err(|_| {
+            WireGuardError::Validation(format!("Invalid subnet '{}', expected CIDR", subnet))
+        })?;
+        let value = parsed.trunc().to_string();
+        if !normalized.contains(&value) {
+            normalized.push(value);
+        }
+    }
+
+    Ok(normalized)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_server_takes_first_host() {
+        let network = parse_address_range("10.66.0.0/24").unwrap();
+        assert_eq!(server_address(&network), Ipv4Addr::new(10, 66, 0, 1));
+    }
+
+    #[test]
+    fn test_allocate_skips_server_and_used() {
+        let network = parse_address_range("10.66.0.0/24").unwrap();
+        let used: HashSet<Ipv4Addr> = [Ipv4Addr::new(10, 66, 0, 2)].into_iter().collect();
+
+        assert_eq!(
+            allocate_address(&network, &used),
+            Some(Ipv4Addr::new(10, 66, 0, 3))
+        );
+    }
+
+    #[test]
+    fn test_allocate_exhausted_pool() {
+        let network = parse_address_range("10.66.0.0/30").unwrap();
+        let used: HashSet<Ipv4Addr> = [Ipv4Addr::new(10, 66, 0, 2)].into_iter().collect();
+
+        assert_eq!(allocate_address(&network, &used), None);
+    }
+
+    #[test]
+    fn test_invalid_range() {
+        assert!(parse_address_range("not-a-range").is_err());
+        assert!(parse_address_range("10.66.0.1/32").is_err());
+    }
+
+    #[test]
+    fn test_normalize_subnets() {
+        let subnets = normalize_subnets(&[
+            "172.18.0.5/16".to_string(),
+            " 172.18.0.0/16 ".to_string(),
+            "10.0.0.0/8".to_string(),
+        ])
+        .unwrap();
+
+        assert_eq!(subnets, vec!["172.18.0.0/16", "10.0.0.0/8"]);
+        assert!(normalize_subnets(&[]).is_err());
+        assert!(normalize_subnets(&["172.18.0.0".to_string()]).is_err());
+    }
+}
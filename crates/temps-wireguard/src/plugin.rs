@@ -0,0 +1,117 @@
+//! WireGuard Plugin implementation for the Temps plugin system
+
+use std::future::Future;
+use std::pin::Pin;
+use std::sync::Arc;
+
+use temps_core::plugin::{
+    PluginContext, PluginError, PluginRoutes, ServiceRegistrationContext, TempsPlugin,
+};
+use temps_core::AuditLogger;
+use tracing::{debug, warn};
+use utoipa::openapi::OpenApi;
+use utoipa::OpenApi as OpenApiTrait;
+
+use crate::backend::CommandWireGuardBackend;
+use crate::handlers::{configure_routes, WireGuardApiDoc, WireGuardAppState};
+use crate::services::WireGuardService;
+
+/// WireGuard Plugin providing VPN access to internal services
+pub struct WireGuardPlugin;
+
+impl WireGuardPlugin {
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Default for WireGuardPlugin {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl TempsPlugin for WireGuardPlugin {
+    fn name(&self) -> &'static str {
+        "wireguard"
+    }
+
+    fn register_services<'a>(
+        &'a self,
+        context: &'a ServiceRegistrationContext,
+    ) -> Pin<Box<dyn Future<Output = Result<(), PluginError>> + Send + 'a>> {
+        Box::pin(async move {
+            let db = context.require_service::<sea_orm::DatabaseConnection>();
+            let config_service = context.require_service::<temps_config::ConfigService>();
+            let encryption_service = context.require_service::<temps_core::EncryptionService>();
+
+            let wireguard_service = Arc::new(WireGuardService::new(
+                db,
+                config_service,
+                encryption_service,
+                Arc::new(CommandWireGuardBackend::new()),
+            ));
+            context.register_service(wireguard_service);
+
+            debug!("WireGuard plugin services registered successfully");
+            Ok(())
+        })
+    }
+
+    fn initialize_plugin_services<'a>(
+        &'a self,
+        context: &'a PluginContext,
+    ) -> Pin<Box<dyn Future<Output = Result<(), PluginError>> + Send + 'a>> {
+        Box::pin(async move {
+            let wireguard_service = context.require_service::<WireGuardService>();
+
+            // Re-create the interface and peers (they don't survive host reboots).
+            // A failure here must not prevent Temps from starting.
+            if let Err(e) = wireguard_service.sync().await {
+                warn!("Failed to sync WireGuard interface: {}", e);
+            }
+
+            tokio::spawn(async move {
+                debug!("Starting WireGuard handshake monitor");
+                wireguard_service.start_handshake_monitor().await;
+            });
+
+            Ok(())
+        })
+    }
+
+    fn configure_routes(&self, context: &PluginContext) -> Option<PluginRoutes> {
+        let wireguard_service = context.require_service::<WireGuardService>();
+        let audit_service = context.require_service::<dyn AuditLogger>();
+
+        let app_state = Arc::new(WireGuardAppState {
+            wireguard_service,
+            audit_service,
+        });
+
+        Some(PluginRoutes {
+            router: configure_routes().with_state(app_state),
+        })
+    }
+
+    fn openapi_schema(&self) -> Option<OpenApi> {
+        Some(<WireGuardApiDoc as OpenApiTrait>::openapi())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_wireguard_plugin_name() {
+        let plugin = WireGuardPlugin::new();
+        assert_eq!(plugin.name(), "wireguard");
+    }
+
+    #[test]
+    fn test_wireguard_plugin_openapi() {
+        let openapi = WireGuardPlugin::default().openapi_schema().unwrap();
+        assert!(openapi.paths.paths.contains_key("/wireguard/peers"));
+    }
+}
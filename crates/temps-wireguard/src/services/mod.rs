@@ -0,0 +1,3 @@
+mod wireguard_service;
+
+pub use wireguard_service::*;
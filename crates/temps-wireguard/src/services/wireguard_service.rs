@@ -0,0 +1,456 @@
+//! WireGuard peer management service
+
+use chrono::Utc;
+use sea_orm::{
+    ActiveModelTrait, ColumnTrait, DatabaseConnection, EntityTrait, QueryFilter, QueryOrder, Set,
+};
+use serde::Serialize;
+use std::collections::HashSet;
+use std::net::Ipv4Addr;
+use std::path::PathBuf;
+use std::sync::Arc;
+use temps_config::ConfigService;
+use temps_core::{EncryptionService, WireGuardSettings};
+use temps_entities::wireguard_peers;
+use tokio::time::{sleep, Duration};
+use tracing::{debug, error, info, warn};
+use utoipa::ToSchema;
+
+use crate::backend::{InterfaceConfig, PeerConfig, WireGuardBackend};
+use crate::config::{format_endpoint, ClientConfig};
+use crate::network::{allocate_address, normalize_subnets, parse_address_range, server_address};
+use crate::{keys, WireGuardError};
+
+/// File (under the data directory) holding the encrypted server private key
+const SERVER_KEY_FILE: &str = "wireguard/server.key";
+
+/// How often peer handshakes are read from the interface
+const HANDSHAKE_POLL_INTERVAL_SECS: u64 = 60;
+
+/// Current state of the WireGuard VPN
+#[derive(Debug, Clone, Serialize, ToSchema)]
+pub struct WireGuardStatus {
+    pub enabled: bool,
+    pub interface_name: String,
+    /// `host:port` peers connect to, if an endpoint host is known
+    pub endpoint: Option<String>,
+    pub address_range: String,
+    pub server_address: String,
+    pub server_public_key: String,
+    pub active_peers: usize,
+}
+
+/// Request to create a new peer
+#[derive(Debug, Clone)]
+pub struct CreatePeer {
+    pub name: String,
+    /// Subnets the peer may reach; defaults to `default_allowed_subnets`
+    pub allowed_subnets: Option<Vec<String>>,
+}
+
+/// A newly created peer with its one-time client configuration
+#[derive(Debug, Clone)]
+pub struct CreatedPeer {
+    pub peer: wireguard_peers::Model,
+    /// Complete `wg-quick` config including the private key (not stored)
+    pub client_config: String,
+}
+
+/// Manages the WireGuard interface and its peers
+pub struct WireGuardService {
+    db: Arc<DatabaseConnection>,
+    config_service: Arc<ConfigService>,
+    encryption_service: Arc<EncryptionService>,
+    backend: Arc<dyn WireGuardBackend>,
+}
+
+impl WireGuardService {
+    pub fn new(
+        db: Arc<DatabaseConnection>,
+        config_service: Arc<ConfigService>,
+        encryption_service: Arc<EncryptionService>,
+        backend: Arc<dyn WireGuardBackend>,
+    ) -> Self {
+        Self {
+            db,
+            config_service,
+            encryption_service,
+            backend,
+        }
+    }
+
+    async fn settings(&self) -> Result<(WireGuardSettings, Option<String>), WireGuardError> {
+        let settings = self
+            .config_service
+            .get_settings()
+            .await
+            .map_err(|e| WireGuardError::Internal(format!("Failed to load settings: {}", e)))?;
+        Ok((settings.wireguard, settings.external_url))
+    }
+
+    fn server_key_path(&self) -> PathBuf {
+        self.config_service.data_dir().join(SERVER_KEY_FILE)
+    }
+
+    /// Load the server private key, generating and persisting one on first use
+    async fn server_private_key(&self) -> Result<String, WireGuardError> {
+        let path = self.server_key_path();
+
+        if let Ok(encrypted) = tokio::fs::read_to_string(&path).await {
+            return self
+                .encryption_service
+                .decrypt_string(encrypted.trim())
+                .map_err(|e| {
+                    WireGuardError::Internal(format!("Failed to decrypt server key: {}", e))
+                });
+        }
+
+        let keypair = keys::generate_keypair();
+        let encrypted = self
+            .encryption_service
+            .encrypt_string(&keypair.private_key)
+            .map_err(|e| {
+                WireGuardError::Internal(format!("Failed to encrypt server key: {}", e))
+            })?;
+
+        if let Some(parent) = path.parent() {
+            tokio::fs::create_dir_all(parent).await.map_err(|e| {
+                WireGuardError::Internal(format!("Failed to create {}: {}", parent.display(), e))
+            })?;
+        }
+        tokio::fs::write(&path, encrypted).await.map_err(|e| {
+            WireGuardError::Internal(format!("Failed to write {}: {}", path.display(), e))
+        })?;
+
+        info!("Generated WireGuard server key at {}", path.display());
+        Ok(keypair.private_key)
+    }
+
+    fn endpoint(settings: &WireGuardSettings, external_url: Option<&str>) -> Option<String> {
+        let host = settings.endpoint_host.clone().or_else(|| {
+            external_url
+                .and_then(|url| url::Url::parse(url).ok())
+                .and_then(|url| url.host_str().map(str::to_string))
+        })?;
+        Some(format_endpoint(&host, settings.listen_port))
+    }
+
+    fn decode_subnets(peer: &wireguard_peers::Model) -> Vec<String> {
+        serde_json::from_value(peer.allowed_subnets.clone()).unwrap_or_default()
+    }
+
+    fn peer_config(&self, peer: &wireguard_peers::Model) -> Result<PeerConfig, WireGuardError> {
+        let preshared_key = self
+            .encryption_service
+            .decrypt_string(&peer.encrypted_preshared_key)
+            .map_err(|e| {
+                WireGuardError::Internal(format!("Failed to decrypt preshared key: {}", e))
+            })?;
+        let address = peer.address.parse().map_err(|_| {
+            WireGuardError::Internal(format!("Invalid stored peer address {}", peer.address))
+        })?;
+
+        Ok(PeerConfig {
+            id: peer.id,
+            public_key: peer.public_key.clone(),
+            preshared_key,
+            address,
+            allowed_subnets: Self::decode_subnets(peer),
+        })
+    }
+
+    async fn active_peers(&self) -> Result<Vec<wireguard_peers::Model>, WireGuardError> {
+        Ok(wireguard_peers::Entity::find()
+            .filter(wireguard_peers::Column::RevokedAt.is_null())
+            .order_by_asc(wireguard_peers::Column::Id)
+            .all(self.db.as_ref())
+            .await?)
+    }
+
+    /// Current VPN status
+    pub async fn status(&self) -> Result<WireGuardStatus, WireGuardError> {
+        let (settings, external_url) = self.settings().await?;
+        let network = parse_address_range(&settings.address_range)?;
+        let server_public_key = keys::public_key_from_private(&self.server_private_key().await?)?;
+
+        Ok(WireGuardStatus {
+            enabled: settings.enabled,
+            endpoint: Self::endpoint(&settings, external_url.as_deref()),
+            interface_name: settings.interface_name,
+            address_range: network.to_string(),
+            server_address: server_address(&network).to_string(),
+            server_public_key,
+            active_peers: self.active_peers().await?.len(),
+        })
+    }
+
+    /// Bring the interface up and install every active peer, or remove the interface
+    /// and its firewall rules when the VPN is disabled
+    ///
+    /// Safe to call repeatedly; used on startup so the interface survives host reboots.
+    pub async fn sync(&self) -> Result<(), WireGuardError> {
+        let (settings, _) = self.settings().await?;
+        let network = parse_address_range(&settings.address_range)?;
+        if !settings.enabled {
+            debug!("WireGuard is disabled, removing the interface if present");
+            return self
+                .backend
+                .remove_interface(&settings.interface_name, network)
+                .await;
+        }
+
+        self.backend
+            .ensure_interface(&InterfaceConfig {
+                name: settings.interface_name.clone(),
+                private_key: self.server_private_key().await?,
+                listen_port: settings.listen_port,
+                address: server_address(&network),
+                network,
+            })
+            .await?;
+
+        let peers = self.active_peers().await?;
+        for peer in &peers {
+            let config = self.peer_config(peer)?;
+            if let Err(e) = self
+                .backend
+                .add_peer(&settings.interface_name, &config)
+                .await
+            {
+                error!("Failed to install WireGuard peer {}: {}", peer.name, e);
+            }
+        }
+
+        info!(
+            "WireGuard interface {} synced with {} peer(s)",
+            settings.interface_name,
+            peers.len()
+        );
+        Ok(())
+    }
+
+    /// List peers, newest first
+    pub async fn list_peers(
+        &self,
+        include_revoked: bool,
+    ) -> Result<Vec<wireguard_peers::Model>, WireGuardError> {
+        let mut query = wireguard_peers::Entity::find();
+        if !include_revoked {
+            query = query.filter(wireguard_peers::Column::RevokedAt.is_null());
+        }
+        Ok(query
+            .order_by_desc(wireguard_peers::Column::CreatedAt)
+            .all(self.db.as_ref())
+            .await?)
+    }
+
+    /// Get a single peer
+    pub async fn get_peer(&self, id: i32) -> Result<wireguard_peers::Model, WireGuardError> {
+        wireguard_peers::Entity::find_by_id(id)
+            .one(self.db.as_ref())
+            .await?
+            .ok_or(WireGuardError::PeerNotFound(id))
+    }
+
+    /// Create a peer, install it on the interface and return its client configuration
+    pub async fn create_peer(
+        &self,
+        request: CreatePeer,
+        created_by: Option<i32>,
+    ) -> Result<CreatedPeer, WireGuardError> {
+        let (settings, external_url) = self.settings().await?;
+        if !settings.enabled {
+            return Err(WireGuardError::NotEnabled);
+        }
+
+        let name = request.name.trim().to_string();
+        if name.is_empty() {
+            return Err(WireGuardError::Validation(
+                "Peer name cannot be empty".to_string(),
+            ));
+        }
+
+        let endpoint = Self::endpoint(&settings, external_url.as_deref()).ok_or_else(|| {
+            WireGuardError::Validation(
+                "No WireGuard endpoint host configured; set an endpoint host or external URL"
+                    .to_string(),
+            )
+        })?;
+
+        let allowed_subnets = normalize_subnets(
+            request
+                .allowed_subnets
+                .as_deref()
+                .unwrap_or(&settings.default_allowed_subnets),
+        )?;
+
+        let network = parse_address_range(&settings.address_range)?;
+        let used: HashSet<Ipv4Addr> = self
+            .active_peers()
+            .await?
+            .iter()
+            .filter_map(|peer| peer.address.parse().ok())
+            .collect();
+        let address = allocate_address(&network, &used)
+            .ok_or_else(|| WireGuardError::AddressPoolExhausted(network.to_string()))?;
+
+        let keypair = keys::generate_keypair();
+        let preshared_key = keys::generate_preshared_key();
+        let encrypted_preshared_key = self
+            .encryption_service
+            .encrypt_string(&preshared_key)
+            .map_err(|e| {
+                WireGuardError::Internal(format!("Failed to encrypt preshared key: {}", e))
+            })?;
+
+        let peer = wireguard_peers::ActiveModel {
+            name: Set(name),
+            public_key: Set(keypair.public_key.clone()),
+            encrypted_preshared_key: Set(encrypted_preshared_key),
+            address: Set(address.to_string()),
+            allowed_subnets: Set(serde_json::json!(allowed_subnets)),
+            created_by: Set(created_by),
+            ..Default::default()
+        }
+        .insert(self.db.as_ref())
+        .await?;
+
+        let peer_config = PeerConfig {
+            id: peer.id,
+            public_key: peer.public_key.clone(),
+            preshared_key: preshared_key.clone(),
+            address,
+            allowed_subnets: allowed_subnets.clone(),
+        };
+        if let Err(e) = self
+            .backend
+            .add_peer(&settings.interface_name, &peer_config)
+            .await
+        {
+            // Don't leave a peer in the database that was never installed
+            wireguard_peers::Entity::delete_by_id(peer.id)
+                .exec(self.db.as_ref())
+                .await?;
+            return Err(e);
+        }
+
+        let server_public_key = keys::public_key_from_private(&self.server_private_key().await?)?;
+        let mut client_allowed_ips = vec![format!("{}/32", server_address(&network))];
+        client_allowed_ips.extend(allowed_subnets);
+
+        let client_config = ClientConfig {
+            private_key: keypair.private_key,
+            address,
+            server_public_key,
+            preshared_key,
+            endpoint,
+            allowed_ips: client_allowed_ips,
+        }
+        .render();
+
+        info!("Created WireGuard peer {} ({})", peer.name, peer.address);
+        Ok(CreatedPeer {
+            peer,
+            client_config,
+        })
+    }
+
+    /// Revoke a peer: remove it from the interface and mark it revoked
+    pub async fn revoke_peer(
+        &self,
+        id: i32,
+        revoked_by: Option<i32>,
+    ) -> Result<wireguard_peers::Model, WireGuardError> {
+        let peer = self.get_peer(id).await?;
+        if peer.revoked_at.is_some() {
+            return Ok(peer);
+        }
+
+        let (settings, _) = self.settings().await?;
+        if settings.enabled {
+            let config = self.peer_config(&peer)?;
+            self.backend
+                .remove_peer(&settings.interface_name, &config)
+                .await?;
+        }
+
+        let mut active: wireguard_peers::ActiveModel = peer.into();
+        active.revoked_at = Set(Some(Utc::now()));
+        active.revoked_by = Set(revoked_by);
+        let peer = active.update(self.db.as_ref()).await?;
+
+        info!("Revoked WireGuard peer {} ({})", peer.name, peer.address);
+        Ok(peer)
+    }
+
+    /// Record the latest handshake of every active peer
+    pub async fn refresh_handshakes(&self) -> Result<(), WireGuardError> {
+        let (settings, _) = self.settings().await?;
+        if !settings.enabled {
+            return Ok(());
+        }
+
+        let handshakes = self
+            .backend
+            .latest_handshakes(&settings.interface_name)
+            .await?;
+
+        for peer in self.active_peers().await? {
+            let Some(latest) = handshakes.get(&peer.public_key).copied() else {
+                continue;
+            };
+            if peer.last_handshake_at == Some(latest) {
+                continue;
+            }
+
+            if peer.last_handshake_at.is_none() {
+                info!(
+                    "WireGuard peer {} ({}) connected for the first time",
+                    peer.name, peer.address
+                );
+            }
+            let mut active: wireguard_peers::ActiveModel = peer.into();
+            active.last_handshake_at = Set(Some(latest));
+            active.update(self.db.as_ref()).await?;
+        }
+
+        Ok(())
+    }
+
+    /// Periodically record peer handshakes
+    pub async fn start_handshake_monitor(&self) {
+        loop {
+            if let Err(e) = self.refresh_handshakes().await {
+                warn!("Failed to refresh WireGuard handshakes: {}", e);
+            }
+            sleep(Duration::from_secs(HANDSHAKE_POLL_INTERVAL_SECS)).await;
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_endpoint_prefers_explicit_host() {
+        let settings = WireGuardSettings {
+            endpoint_host: Some("vpn.example.com".to_string()),
+            ..Default::default()
+        };
+        assert_eq!(
+            WireGuardService::endpoint(&settings, Some("https://app.example.com")),
+            Some("vpn.example.com:51820".to_string())
+        );
+    }
+
+    #[test]
+    fn test_endpoint_falls_back_to_external_url() {
+        let settings = WireGuardSettings::default();
+        assert_eq!(
+            WireGuardService::endpoint(&settings, Some("https://app.example.com:8443")),
+            Some("app.example.com:51820".to_string())
+        );
+        assert_eq!(WireGuardService::endpoint(&settings, None), None);
+    }
+}